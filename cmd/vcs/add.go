@@ -9,7 +9,6 @@ import (
 	"github.com/fenilsonani/vcs/internal/core/index"
 	"github.com/fenilsonani/vcs/internal/core/objects"
 	"github.com/fenilsonani/vcs/internal/core/workdir"
-	"github.com/fenilsonani/vcs/pkg/vcs"
 	"github.com/spf13/cobra"
 )
 
@@ -18,7 +17,13 @@ func newAddCommand() *cobra.Command {
 		Use:   "add [flags] [pathspec...]",
 		Short: "Add file contents to the index",
 		Long: `Updates the index using the current content found in the working tree, 
-to prepare the content staged for the next commit.`,
+to prepare the content staged for the next commit.
+
+With -p the changes to tracked files are shown a hunk at a time and only
+the hunks chosen are staged. Each hunk may be staged (y), skipped (n),
+split into smaller hunks (s) or edited by hand (e); q stops, and a and d
+stage or skip the rest of the file. reset, checkout, commit and stash take
+-p too, to unstage, discard, commit or stash hunks.`,
 		RunE: runAdd,
 	}
 
@@ -27,6 +32,7 @@ to prepare the content staged for the next commit.`,
 	cmd.Flags().BoolP("force", "f", false, "Allow adding otherwise ignored files")
 	cmd.Flags().BoolP("dry-run", "n", false, "Don't actually add the file(s), just show if they exist and/or will be ignored")
 	cmd.Flags().BoolP("verbose", "v", false, "Be verbose")
+	cmd.Flags().BoolP("patch", "p", false, "Interactively choose hunks of changes to stage")
 
 	return cmd
 }
@@ -39,11 +45,15 @@ func runAdd(cmd *cobra.Command, args []string) error {
 	}
 
 	// Open repository
-	repo, err := vcs.Open(repoPath)
+	repo, err := openRepository(repoPath)
 	if err != nil {
 		return fmt.Errorf("failed to open repository: %w", err)
 	}
 
+	if patch, _ := cmd.Flags().GetBool("patch"); patch {
+		return stageHunks(cmd, repo, args)
+	}
+
 	// Get flags
 	addAll, _ := cmd.Flags().GetBool("all")
 	updateOnly, _ := cmd.Flags().GetBool("update")
@@ -52,32 +62,30 @@ func runAdd(cmd *cobra.Command, args []string) error {
 	verbose, _ := cmd.Flags().GetBool("verbose")
 
 	// Get index
-	idx := index.New()
-	indexPath := filepath.Join(repo.GitDir(), "index")
-	if _, err := os.Stat(indexPath); err == nil {
-		if err := idx.ReadFromFile(indexPath); err != nil {
-			// If we can't read the index file, start with empty index
-			idx = index.New()
-		}
+	idx, err := readIndex(repo)
+	if err != nil {
+		return err
 	}
+	indexPath := filepath.Join(repo.GitDir(), "index")
 
 	// Create scanner for working directory
-	scanner := workdir.NewScanner(repoPath, repo.GitDir())
-	
-	// Load .gitignore file if it exists
-	gitignorePath := filepath.Join(repoPath, ".gitignore")
-	scanner.LoadIgnoreFile(gitignorePath)
+	scanner := newScanner(repo)
+
+	conv := newConverter(repo, cmd.ErrOrStderr(), nil)
 
 	var pathsToAdd []string
 
 	if addAll {
 		// Add all files
-		files, err := scanner.ScanFiles()
+		files, err := scanner.ScanWorkingDirectory()
 		if err != nil {
 			return fmt.Errorf("failed to scan working directory: %w", err)
 		}
 
 		for _, file := range files {
+			if file.IsDir && !file.IsRepo {
+				continue
+			}
 			if !force && scanner.IsIgnored(file.Path) {
 				continue
 			}
@@ -96,8 +104,11 @@ func runAdd(cmd *cobra.Command, args []string) error {
 		}
 	}
 
-	// Process each path
-	modified := false
+	// Paths are checked and their index updates queued in order, then the
+	// files to stage are hashed in parallel and the updates applied in the
+	// same order
+	var updates []addUpdate
+	var jobs []hashJob
 	for _, path := range pathsToAdd {
 		// Convert to relative path from repo root
 		absPath := filepath.Join(repoPath, path)
@@ -115,21 +126,35 @@ func runAdd(cmd *cobra.Command, args []string) error {
 			if os.IsNotExist(err) {
 				// File doesn't exist, check if it's in the index to remove it
 				if _, exists := idx.Get(relPath); exists {
-					if !dryRun {
-						idx.Remove(relPath)
-						modified = true
-					}
-					if verbose {
-						fmt.Printf("remove '%s'\n", relPath)
+					if dryRun {
+						if verbose {
+							fmt.Printf("remove '%s'\n", relPath)
+						}
+						continue
 					}
+					updates = append(updates, addUpdate{path: relPath, job: -1})
 				}
 				continue
 			}
 			return fmt.Errorf("failed to stat file %s: %w", path, err)
 		}
 
-		// Skip directories
+		// Skip directories, except that a submodule or other nested
+		// repository is staged as a gitlink to its checked-out commit
 		if info.IsDir() {
+			if relPath == "." || !workdir.IsRepository(absPath) {
+				continue
+			}
+			head, err := submoduleHead(absPath)
+			if err != nil {
+				return fmt.Errorf("'%s' does not have a commit checked out", relPath)
+			}
+			if dryRun {
+				fmt.Printf("add '%s'\n", relPath)
+				continue
+			}
+			entry := &index.Entry{CTime: info.ModTime(), MTime: info.ModTime(), Mode: objects.ModeCommit, ID: head, Path: relPath}
+			updates = append(updates, addUpdate{path: relPath, entry: entry, job: -1})
 			continue
 		}
 
@@ -153,25 +178,13 @@ func runAdd(cmd *cobra.Command, args []string) error {
 			continue
 		}
 
-		// Read file content
-		content, err := os.ReadFile(absPath)
-		if err != nil {
-			return fmt.Errorf("failed to read file %s: %w", path, err)
-		}
-
 		// Get file mode
 		fileMode, err := scanner.GetFileMode(relPath)
 		if err != nil {
 			return fmt.Errorf("failed to get file mode for %s: %w", relPath, err)
 		}
 
-		// Create blob and write to object store
-		blob := objects.NewBlob(content)
-		if err := repo.WriteObject(blob); err != nil {
-			return fmt.Errorf("failed to write blob for %s: %w", relPath, err)
-		}
-
-		// Create index entry
+		// Create index entry, its ID filled in once the file is hashed
 		entry := &index.Entry{
 			CTime: info.ModTime(),
 			MTime: info.ModTime(),
@@ -181,20 +194,43 @@ func runAdd(cmd *cobra.Command, args []string) error {
 			UID:   0, // Not used in our implementation
 			GID:   0, // Not used in our implementation
 			Size:  uint32(info.Size()),
-			ID:    blob.ID(),
 			Flags: 0,
 			Path:  relPath,
 		}
+		updates = append(updates, addUpdate{path: relPath, entry: entry, job: len(jobs)})
+		jobs = append(jobs, hashJob{file: absPath, path: relPath})
+	}
 
-		// Add to index
-		if err := idx.Add(entry); err != nil {
-			return fmt.Errorf("failed to add entry to index: %w", err)
-		}
+	// Store content as the paths' attributes ask, such as with LF line
+	// endings, and write the blobs to the object store
+	ids := make([]objects.ObjectID, len(jobs))
+	err = hashFiles(repo, conv, jobs, hashThreads(repo.GitDir()), true, func(i int, id objects.ObjectID) error {
+		ids[i] = id
+		return nil
+	})
+	if err != nil {
+		return err
+	}
 
+	modified := false
+	for _, update := range updates {
 		modified = true
+		if update.entry == nil {
+			idx.Remove(update.path)
+			if verbose {
+				fmt.Printf("remove '%s'\n", update.path)
+			}
+			continue
+		}
 
+		if update.job >= 0 {
+			update.entry.ID = ids[update.job]
+		}
+		if err := idx.Add(update.entry); err != nil {
+			return fmt.Errorf("failed to add entry to index: %w", err)
+		}
 		if verbose {
-			fmt.Printf("add '%s'\n", relPath)
+			fmt.Printf("add '%s'\n", update.path)
 		}
 	}
 
@@ -208,6 +244,15 @@ func runAdd(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// addUpdate is a change add makes to the index
+type addUpdate struct {
+	path string
+	// entry is staged for path, or nil to remove path from the index
+	entry *index.Entry
+	// job is the hash job giving entry's ID, or -1 when it is known
+	job int
+}
+
 // expandPath expands a path pattern to matching files
 func expandPath(repoPath, pattern string) ([]string, error) {
 	var paths []string
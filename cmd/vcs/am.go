@@ -0,0 +1,475 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/config"
+	"github.com/fenilsonani/vcs/internal/core/index"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/patch"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// errAmStopped is returned when am stops on a patch it cannot apply
+var errAmStopped = errors.New(`am stopped on a patch that does not apply; resolve it and run "vcs am --continue"`)
+
+type amOptions struct {
+	threeWay   bool
+	whitespace string
+	strip      int
+	continueOp bool
+	skip       bool
+	abort      bool
+}
+
+func newAmCommand() *cobra.Command {
+	var opts amOptions
+
+	cmd := &cobra.Command{
+		Use:   "am [flags] [<mbox>...]",
+		Short: "Apply a series of patches from a mailbox",
+		Long: `Reads mails holding patches, as "vcs format-patch" or git format-patch
+writes them, from the given mbox files or from standard input and
+records a commit for each on top of HEAD. The author and date of each
+commit come from the mail, or from "From:" and "Date:" lines at the top
+of its body, and the message is the subject, without its "[PATCH]"
+prefix, and the body up to the "---" line before the diff.
+
+If a patch does not apply, am stops. Apply it by hand, mark the result
+with "vcs add" and run "vcs am --continue", skip the patch with --skip,
+or use --abort to return to the state before am. Progress is kept in
+.git/rebase-apply.
+
+With -3 a patch that does not apply falls back to a three-way merge
+with the versions it was made against, as for "vcs apply -3", and am
+stops on conflicts. --whitespace and -p are passed on as for "vcs
+apply".`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			return runAm(cmd, args, opts)
+		},
+	}
+
+	cmd.Flags().BoolVarP(&opts.threeWay, "3way", "3", false, "Fall back to a three-way merge when a patch does not apply")
+	cmd.Flags().StringVar(&opts.whitespace, "whitespace", "", "What to do about whitespace errors (nowarn, warn, fix, error, error-all)")
+	cmd.Flags().IntVarP(&opts.strip, "p", "p", 1, "Remove this many leading path components from the paths of the patches")
+	cmd.Flags().BoolVar(&opts.continueOp, "continue", false, "Commit the resolved patch and continue")
+	cmd.Flags().BoolVar(&opts.skip, "skip", false, "Skip the current patch and continue")
+	cmd.Flags().BoolVar(&opts.abort, "abort", false, "Stop and restore the original branch")
+
+	return cmd
+}
+
+func runAm(cmd *cobra.Command, args []string, opts amOptions) error {
+	actions := 0
+	for _, set := range []bool{opts.continueOp, opts.skip, opts.abort} {
+		if set {
+			actions++
+		}
+	}
+	if actions > 1 {
+		return fmt.Errorf("--continue, --skip and --abort cannot be used together")
+	}
+	if actions == 1 && len(args) > 0 {
+		return fmt.Errorf("--continue, --skip and --abort take no mailboxes")
+	}
+	if err := validateWhitespaceAction(opts.whitespace); err != nil {
+		return err
+	}
+
+	repoPath, err := findRepository()
+	if err != nil {
+		return err
+	}
+	repo, err := openRepository(repoPath)
+	if err != nil {
+		return err
+	}
+	refManager := refs.NewRefManager(repo.GitDir())
+	out := cmd.OutOrStdout()
+
+	if actions == 0 {
+		return startAm(cmd, repo, refManager, args, opts)
+	}
+	state, err := loadAmState(repo)
+	if err != nil {
+		return err
+	}
+	switch {
+	case opts.abort:
+		return abortAm(repo, refManager, state)
+	case opts.skip:
+		headID, _, err := refManager.HEAD()
+		if err != nil {
+			return fmt.Errorf("failed to read HEAD: %w", err)
+		}
+		if err := restoreWorkingTree(repo, headID); err != nil {
+			return err
+		}
+	default:
+		if err := continueAm(out, repo, refManager, state); err != nil {
+			return err
+		}
+	}
+	state.next++
+	if err := state.save(); err != nil {
+		return err
+	}
+	return applyMails(cmd, repo, refManager, state)
+}
+
+// amState is the progress of am, kept in .git/rebase-apply. The mails are
+// numbered from 1 and next is the one to apply, or the one am stopped on.
+type amState struct {
+	dir        string
+	origHead   objects.ObjectID
+	next, last int
+	threeWay   bool
+	whitespace string
+	strip      int
+}
+
+func amDir(repo *vcs.Repository) string {
+	return filepath.Join(repo.GitDir(), "rebase-apply")
+}
+
+// mailPath returns the file of mail n
+func (s *amState) mailPath(n int) string {
+	return filepath.Join(s.dir, fmt.Sprintf("%04d", n))
+}
+
+// loadAmState reads the state of the am in progress
+func loadAmState(repo *vcs.Repository) (*amState, error) {
+	dir := amDir(repo)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil, fmt.Errorf("no am in progress")
+	}
+
+	read := func(name string) (string, error) {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return "", fmt.Errorf("failed to read am state: %w", err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+
+	state := &amState{dir: dir}
+	head, err := read("orig-head")
+	if err != nil {
+		return nil, err
+	}
+	if state.origHead, err = objects.NewObjectID(head); err != nil {
+		return nil, fmt.Errorf("invalid am state orig-head: %w", err)
+	}
+	for name, n := range map[string]*int{"next": &state.next, "last": &state.last} {
+		value, err := read(name)
+		if err != nil {
+			return nil, err
+		}
+		if *n, err = strconv.Atoi(value); err != nil {
+			return nil, fmt.Errorf("invalid am state %s: %s", name, value)
+		}
+	}
+
+	opts, err := config.ReadFile(filepath.Join(dir, "opts"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read am state: %w", err)
+	}
+	threeWay, _ := opts.Get("options.threeway")
+	state.threeWay = threeWay == "true"
+	state.whitespace, _ = opts.Get("options.whitespace")
+	strip, _ := opts.Get("options.strip")
+	if state.strip, err = strconv.Atoi(strip); err != nil {
+		return nil, fmt.Errorf("invalid am state strip: %s", strip)
+	}
+	return state, nil
+}
+
+// save writes the state, but not the mails, to .git/rebase-apply
+func (s *amState) save() error {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create am state: %w", err)
+	}
+	files := map[string]string{
+		"orig-head": s.origHead.String() + "\n",
+		"next":      strconv.Itoa(s.next) + "\n",
+		"last":      strconv.Itoa(s.last) + "\n",
+		"opts":      fmt.Sprintf("[options]\n\tthreeway = %t\n\twhitespace = %s\n\tstrip = %d\n", s.threeWay, s.whitespace, s.strip),
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(s.dir, name), []byte(content), 0644); err != nil {
+			return fmt.Errorf("failed to write am state: %w", err)
+		}
+	}
+	return nil
+}
+
+// readMail reads and parses mail n
+func (s *amState) readMail(n int) (*patch.Mail, error) {
+	data, err := os.ReadFile(s.mailPath(n))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read am state: %w", err)
+	}
+	mail, err := patch.ParseMail(data)
+	if err != nil {
+		return nil, fmt.Errorf("patch %04d: %w", n, err)
+	}
+	return mail, nil
+}
+
+func startAm(cmd *cobra.Command, repo *vcs.Repository, refManager *refs.RefManager, args []string, opts amOptions) error {
+	if fileExists(amDir(repo)) {
+		return fmt.Errorf(`an am is already in progress; use "vcs am --continue", "--skip" or "--abort"`)
+	}
+	if _, ok, err := readMergeHead(repo); err != nil {
+		return err
+	} else if ok {
+		return fmt.Errorf("you have not concluded your merge (MERGE_HEAD exists)")
+	}
+
+	headID, _, err := refManager.HEAD()
+	if err != nil {
+		return fmt.Errorf("failed to read HEAD: %w", err)
+	}
+	head, err := repo.GetCommit(headID)
+	if err != nil {
+		return fmt.Errorf("failed to read HEAD commit: %w", err)
+	}
+	idx, err := readSeriesIndex(repo)
+	if err != nil {
+		return err
+	}
+	if treeID, err := writeIndexTree(repo, idx); err != nil {
+		return err
+	} else if treeID != head.Tree() {
+		return fmt.Errorf("dirty index: cannot apply patches")
+	}
+
+	inputs, err := readPatchInputs(cmd.InOrStdin(), args)
+	if err != nil {
+		return err
+	}
+	var mails [][]byte
+	for _, input := range inputs {
+		for _, mail := range patch.SplitMbox(input.data) {
+			if _, err := patch.ParseMail(mail); err != nil {
+				return fmt.Errorf("%s: %w", input.name, err)
+			}
+			mails = append(mails, mail)
+		}
+	}
+
+	if opts.whitespace == "" {
+		opts.whitespace, _ = loadConfig(repo.GitDir()).Get("apply.whitespace")
+	}
+	state := &amState{
+		dir:        amDir(repo),
+		origHead:   headID,
+		next:       1,
+		last:       len(mails),
+		threeWay:   opts.threeWay,
+		whitespace: opts.whitespace,
+		strip:      opts.strip,
+	}
+	if err := state.save(); err != nil {
+		return err
+	}
+	for i, mail := range mails {
+		if err := os.WriteFile(state.mailPath(i+1), mail, 0644); err != nil {
+			return fmt.Errorf("failed to write am state: %w", err)
+		}
+	}
+	return applyMails(cmd, repo, refManager, state)
+}
+
+// applyMails applies the mails from state.next on, committing each
+func applyMails(cmd *cobra.Command, repo *vcs.Repository, refManager *refs.RefManager, state *amState) error {
+	out := cmd.OutOrStdout()
+	for ; state.next <= state.last; state.next++ {
+		if err := state.save(); err != nil {
+			return err
+		}
+		mail, err := state.readMail(state.next)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Applying: %s\n", mail.Subject)
+
+		applied, err := applyMail(cmd, repo, state, mail)
+		if err != nil {
+			fmt.Fprintf(out, "error: %v\n", err)
+		}
+		if err != nil || !applied {
+			fmt.Fprintf(out, "Patch failed at %04d %s\n", state.next, mail.Subject)
+			fmt.Fprintf(out, "When you have resolved this problem, run \"vcs am --continue\".\n")
+			fmt.Fprintf(out, "If you prefer to skip this patch, run \"vcs am --skip\" instead.\n")
+			fmt.Fprintf(out, "To restore the original branch and stop patching, run \"vcs am --abort\".\n")
+			return errAmStopped
+		}
+
+		idx, err := readSeriesIndex(repo)
+		if err != nil {
+			return err
+		}
+		committed, err := commitMail(repo, refManager, idx, mail)
+		if err != nil {
+			return err
+		}
+		if !committed {
+			fmt.Fprintln(out, "No changes -- Patch already applied.")
+		}
+	}
+
+	if err := os.RemoveAll(state.dir); err != nil {
+		return fmt.Errorf("failed to remove am state: %w", err)
+	}
+	return nil
+}
+
+// applyMail applies the patch of mail to the working tree and the index,
+// which must match HEAD for the files it changes. It reports false when
+// it left conflicts.
+func applyMail(cmd *cobra.Command, repo *vcs.Repository, state *amState, mail *patch.Mail) (bool, error) {
+	files, err := patch.Parse(mail.Patch, state.strip)
+	if err != nil {
+		return false, err
+	}
+	if len(files) == 0 {
+		return false, fmt.Errorf("patch is empty")
+	}
+	name, _ := filepath.Rel(repo.WorkDir(), state.mailPath(state.next))
+	if err := checkPatchWhitespace(cmd.ErrOrStderr(), filepath.ToSlash(name), files, state.whitespace); err != nil {
+		return false, err
+	}
+
+	idx, _, err := patchIndex(repo)
+	if err != nil {
+		return false, err
+	}
+	conv := newConverter(repo, cmd.ErrOrStderr(), nil)
+	read := func(p string) (*appliedFile, error) {
+		return readIndexedFile(repo, conv, idx, p)
+	}
+	applier := newPatchApplier(repo, cmd.ErrOrStderr(), read, state.threeWay)
+	for _, f := range files {
+		if err := applier.apply(f); err != nil {
+			return false, err
+		}
+	}
+
+	if err := applier.writeWorkingTree(conv); err != nil {
+		return false, err
+	}
+	if err := applier.stage(idx); err != nil {
+		return false, err
+	}
+	if err := idx.WriteToFile(filepath.Join(repo.GitDir(), "index")); err != nil {
+		return false, fmt.Errorf("failed to write index: %w", err)
+	}
+
+	conflicted := applier.conflicted()
+	for _, p := range conflicted {
+		fmt.Fprintf(cmd.OutOrStdout(), "U %s\n", p)
+	}
+	return len(conflicted) == 0, nil
+}
+
+// commitMail commits the staged changes of idx with the author and
+// message of mail, reporting false when there are none
+func commitMail(repo *vcs.Repository, refManager *refs.RefManager, idx *index.Index, mail *patch.Mail) (bool, error) {
+	headID, branchRef, err := refManager.HEAD()
+	if err != nil {
+		return false, fmt.Errorf("failed to read HEAD: %w", err)
+	}
+	head, err := repo.GetCommit(headID)
+	if err != nil {
+		return false, fmt.Errorf("failed to read HEAD commit: %w", err)
+	}
+	treeID, err := writeIndexTree(repo, idx)
+	if err != nil {
+		return false, err
+	}
+	if treeID == head.Tree() {
+		return false, resetIndexToHead(repo)
+	}
+
+	author := mail.Author
+	author.When = mail.Date()
+	committer, err := getSignature("")
+	if err != nil {
+		return false, err
+	}
+	commit, err := repo.CreateCommit(treeID, []objects.ObjectID{headID}, author, committer, mail.Message)
+	if err != nil {
+		return false, fmt.Errorf("failed to create commit: %w", err)
+	}
+
+	if branchRef == "" {
+		err = refManager.SetHEADToCommit(commit.ID())
+		branchRef = "HEAD"
+	} else {
+		err = refManager.WriteRef(branchRef, commit.ID(), nil)
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to update HEAD: %w", err)
+	}
+	logRefUpdate(refManager, branchRef, headID, commit.ID(), "am: "+mail.Subject)
+	return true, resetIndexToHead(repo)
+}
+
+// continueAm commits the patch am stopped on, as resolved in the index
+func continueAm(out io.Writer, repo *vcs.Repository, refManager *refs.RefManager, state *amState) error {
+	idx, err := readSeriesIndex(repo)
+	if err != nil {
+		return err
+	}
+	mail, err := state.readMail(state.next)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Applying: %s\n", mail.Subject)
+	committed, err := commitMail(repo, refManager, idx, mail)
+	if err != nil {
+		return err
+	}
+	if !committed {
+		return fmt.Errorf(`no changes - did you forget to use "vcs add"? If there is nothing left to stage, something else already introduced the same changes; skip this patch with "vcs am --skip"`)
+	}
+	return nil
+}
+
+// abortAm restores the branch and the working tree from before am
+func abortAm(repo *vcs.Repository, refManager *refs.RefManager, state *amState) error {
+	if err := restoreWorkingTree(repo, state.origHead); err != nil {
+		return err
+	}
+
+	headID, branchRef, err := refManager.HEAD()
+	if err != nil {
+		return fmt.Errorf("failed to read HEAD: %w", err)
+	}
+	if branchRef == "" {
+		err = refManager.SetHEADToCommit(state.origHead)
+		branchRef = "HEAD"
+	} else {
+		err = refManager.WriteRef(branchRef, state.origHead, nil)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update HEAD: %w", err)
+	}
+	logRefUpdate(refManager, branchRef, headID, state.origHead, "am --abort")
+
+	if err := os.RemoveAll(state.dir); err != nil {
+		return fmt.Errorf("failed to remove am state: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+const amMbox = `From 0123456789abcdef0123456789abcdef01234567 Mon Sep 17 00:00:00 2001
+From: Alice <alice@example.com>
+Date: Tue, 14 Nov 2023 22:13:20 +0000
+Subject: [PATCH 1/2] Change two
+
+Because two is better.
+---
+ a.txt | 2 +-
+ 1 file changed, 1 insertion(+), 1 deletion(-)
+
+diff --git a/a.txt b/a.txt
+--- a/a.txt
++++ b/a.txt
+@@ -1,3 +1,3 @@
+ 1
+-2
++two
+ 3
+--
+2.40.0
+
+From 89abcdef0123456789abcdef0123456789abcdef Mon Sep 17 00:00:00 2001
+From: Bob <bob@example.com>
+Date: Wed, 15 Nov 2023 10:00:00 +0000
+Subject: [PATCH 2/2] Add b
+
+---
+diff --git a/b.txt b/b.txt
+new file mode 100644
+--- /dev/null
++++ b/b.txt
+@@ -0,0 +1 @@
++b
+--
+2.40.0
+`
+
+// writeMbox writes an mbox outside the working tree and returns its path
+func writeMbox(t *testing.T, content string) string {
+	path := filepath.Join(t.TempDir(), "series.mbox")
+	require.NoError(t, os.WriteFile(path, []byte(content), 0644))
+	return path
+}
+
+func headMessage(t *testing.T, repo *vcs.Repository, refManager *refs.RefManager) string {
+	id, err := refManager.ResolveRef("refs/heads/main")
+	require.NoError(t, err)
+	commit, err := repo.GetCommit(id)
+	require.NoError(t, err)
+	return commit.Message()
+}
+
+func TestAm(t *testing.T) {
+	repo, refManager := setupSeriesRepo(t, map[string]string{"a.txt": "1\n2\n3\n"})
+	base, err := refManager.ResolveRef("refs/heads/main")
+	require.NoError(t, err)
+
+	out, err := runCommandArgs(newAmCommand(), writeMbox(t, amMbox))
+	require.NoError(t, err)
+	assert.Contains(t, out, "Applying: Change two")
+	assert.Contains(t, out, "Applying: Add b")
+	assert.Equal(t, "1\ntwo\n3\n", readWorkFile(t, "a.txt"))
+	assert.Equal(t, "b\n", readWorkFile(t, "b.txt"))
+	assert.NoDirExists(t, filepath.Join(".git", "rebase-apply"))
+
+	id, err := refManager.ResolveRef("refs/heads/main")
+	require.NoError(t, err)
+	second, err := repo.GetCommit(id)
+	require.NoError(t, err)
+	assert.Equal(t, "Add b\n", second.Message())
+	assert.Equal(t, "Bob", second.Author().Name)
+	first, err := repo.GetCommit(second.Parents()[0])
+	require.NoError(t, err)
+	assert.Equal(t, "Change two\n\nBecause two is better.\n", first.Message())
+	assert.Equal(t, "alice@example.com", first.Author().Email)
+	assert.True(t, first.Author().When.Equal(time.Date(2023, 11, 14, 22, 13, 20, 0, time.UTC)))
+	assert.Equal(t, base, first.Parents()[0])
+}
+
+func TestAmStopAndAbort(t *testing.T) {
+	repo, refManager := setupSeriesRepo(t, map[string]string{"a.txt": "1\nzwei\n3\n"})
+	base, err := refManager.ResolveRef("refs/heads/main")
+	require.NoError(t, err)
+
+	out, err := runCommandArgs(newAmCommand(), writeMbox(t, amMbox))
+	assert.ErrorIs(t, err, errAmStopped)
+	assert.Contains(t, out, "Patch failed at 0001 Change two")
+	assert.DirExists(t, filepath.Join(".git", "rebase-apply"))
+
+	_, err = runCommandArgs(newAmCommand(), writeMbox(t, amMbox))
+	assert.ErrorContains(t, err, "already in progress")
+	_, err = runCommandArgs(newAmCommand(), "--continue")
+	assert.ErrorContains(t, err, "no changes")
+
+	_, err = runCommandArgs(newAmCommand(), "--abort")
+	require.NoError(t, err)
+	head, err := refManager.ResolveRef("refs/heads/main")
+	require.NoError(t, err)
+	assert.Equal(t, base, head)
+	assert.NoDirExists(t, filepath.Join(".git", "rebase-apply"))
+	assert.Equal(t, "base\n", headMessage(t, repo, refManager))
+
+	_, err = runCommandArgs(newAmCommand(), "--abort")
+	assert.Error(t, err)
+}
+
+func TestAmSkip(t *testing.T) {
+	repo, refManager := setupSeriesRepo(t, map[string]string{"a.txt": "1\nzwei\n3\n"})
+
+	_, err := runCommandArgs(newAmCommand(), writeMbox(t, amMbox))
+	assert.ErrorIs(t, err, errAmStopped)
+
+	_, err = runCommandArgs(newAmCommand(), "--skip")
+	require.NoError(t, err)
+	assert.Equal(t, "Add b\n", headMessage(t, repo, refManager))
+	assert.Equal(t, "1\nzwei\n3\n", readWorkFile(t, "a.txt"))
+	assert.Equal(t, "b\n", readWorkFile(t, "b.txt"))
+}
+
+func TestAmThreeWayContinue(t *testing.T) {
+	base := "1\n2\n3\n"
+	repo, refManager := setupSeriesRepo(t, map[string]string{"a.txt": base})
+	moveMain(t, repo, refManager, map[string]string{"a.txt": "1\nzwei\n3\n"})
+	// The index line names the blob the patch was made against
+	mbox := writeMbox(t, strings.Replace(amMbox, "diff --git a/a.txt b/a.txt\n",
+		"diff --git a/a.txt b/a.txt\nindex "+blobAbbrev(base)+"..1234567 100644\n", 1))
+
+	out, err := runCommandArgs(newAmCommand(), "-3", mbox)
+	assert.ErrorIs(t, err, errAmStopped)
+	assert.Contains(t, out, "U a.txt")
+	assert.Contains(t, readWorkFile(t, "a.txt"), "<<<<<<< ours\nzwei\n=======\ntwo\n>>>>>>> theirs\n")
+
+	stageFile(t, "a.txt", "1\nzwei two\n3\n")
+	_, err = runCommandArgs(newAmCommand(), "--continue")
+	require.NoError(t, err)
+
+	id, err := refManager.ResolveRef("refs/heads/main")
+	require.NoError(t, err)
+	second, err := repo.GetCommit(id)
+	require.NoError(t, err)
+	assert.Equal(t, "Add b\n", second.Message())
+	first, err := repo.GetCommit(second.Parents()[0])
+	require.NoError(t, err)
+	assert.Equal(t, "Alice", first.Author().Name)
+	assert.Equal(t, "1\nzwei two\n3\n", readWorkFile(t, "a.txt"))
+	assert.NoDirExists(t, filepath.Join(".git", "rebase-apply"))
+}
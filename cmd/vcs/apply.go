@@ -0,0 +1,517 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/convert"
+	"github.com/fenilsonani/vcs/internal/core/index"
+	"github.com/fenilsonani/vcs/internal/core/merge"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/patch"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+type applyOptions struct {
+	check      bool
+	cached     bool
+	index      bool
+	threeWay   bool
+	reverse    bool
+	whitespace string
+	strip      int
+}
+
+func newApplyCommand() *cobra.Command {
+	var opts applyOptions
+
+	cmd := &cobra.Command{
+		Use:   "apply [flags] [<patch>...]",
+		Short: "Apply a patch to files and/or to the index",
+		Long: `Reads unified diffs, as written by "vcs diff" or diff -u, from the given
+files or from standard input and applies them to the working tree. With
+--cached they are applied to the index only, and with --index to both, in
+which case the working tree files must match the index. Either all of
+the changes apply or none of them do; --check only reports whether they
+would.
+
+A hunk whose lines moved since the patch was made is applied where they
+are now. With -3 a hunk that does not apply at all falls back to a
+three-way merge with the version the patch was made against, found by
+the blob ID of its index line, leaving conflicts to resolve as a merge
+does. -3 implies --index unless --cached is given.
+
+A GIT binary patch, as written by "vcs diff --binary" and format-patch,
+is applied whole to the version of the file it was made against, which
+its full index line names; a binary file that is only said to differ
+cannot be applied.
+
+With -R the patch is applied in reverse. --whitespace says what to do
+about lines the patch adds with trailing whitespace, spaces before tabs
+in the indent or blank lines at the end of the file: nowarn, warn (the
+default, or apply.whitespace), fix to correct them, or error to refuse
+the patch.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			return runApply(cmd, args, opts)
+		},
+	}
+
+	cmd.Flags().BoolVar(&opts.check, "check", false, "Check that the patch applies without applying it")
+	cmd.Flags().BoolVar(&opts.cached, "cached", false, "Apply the patch to the index only")
+	cmd.Flags().BoolVar(&opts.index, "index", false, "Apply the patch to both the working tree and the index")
+	cmd.Flags().BoolVarP(&opts.threeWay, "3way", "3", false, "Fall back to a three-way merge when a hunk does not apply")
+	cmd.Flags().BoolVarP(&opts.reverse, "reverse", "R", false, "Apply the patch in reverse")
+	cmd.Flags().StringVar(&opts.whitespace, "whitespace", "", "What to do about whitespace errors (nowarn, warn, fix, error, error-all)")
+	cmd.Flags().IntVarP(&opts.strip, "p", "p", 1, "Remove this many leading path components from the paths of the patch")
+
+	return cmd
+}
+
+func runApply(cmd *cobra.Command, args []string, opts applyOptions) error {
+	repoPath, err := findRepository()
+	if err != nil {
+		return err
+	}
+	repo, err := openRepository(repoPath)
+	if err != nil {
+		return err
+	}
+	if opts.whitespace == "" {
+		opts.whitespace, _ = loadConfig(repo.GitDir()).Get("apply.whitespace")
+	}
+	if err := validateWhitespaceAction(opts.whitespace); err != nil {
+		return err
+	}
+	if opts.threeWay && !opts.cached {
+		opts.index = true
+	}
+
+	inputs, err := readPatchInputs(cmd.InOrStdin(), args)
+	if err != nil {
+		return err
+	}
+	var files []*patch.File
+	for _, input := range inputs {
+		parsed, err := patch.Parse(input.data, opts.strip)
+		if err != nil {
+			return fmt.Errorf("%s: %w", input.name, err)
+		}
+		if opts.reverse {
+			for _, f := range parsed {
+				f.Reverse()
+			}
+		}
+		if err := checkPatchWhitespace(cmd.ErrOrStderr(), input.name, parsed, opts.whitespace); err != nil {
+			return err
+		}
+		files = append(files, parsed...)
+	}
+	if len(files) == 0 {
+		return fmt.Errorf("no valid patches in input")
+	}
+
+	idx, _, err := patchIndex(repo)
+	if err != nil {
+		return err
+	}
+	conv := newConverter(repo, cmd.ErrOrStderr(), nil)
+	read := func(p string) (*appliedFile, error) {
+		return readWorkingFile(repo, conv, p)
+	}
+	if opts.cached {
+		read = func(p string) (*appliedFile, error) {
+			return readIndexFile(repo, idx, p), nil
+		}
+	} else if opts.index {
+		read = func(p string) (*appliedFile, error) {
+			return readIndexedFile(repo, conv, idx, p)
+		}
+	}
+
+	applier := newPatchApplier(repo, cmd.ErrOrStderr(), read, opts.threeWay)
+	for _, f := range files {
+		if err := applier.apply(f); err != nil {
+			return err
+		}
+	}
+	if opts.check {
+		return nil
+	}
+
+	if !opts.cached {
+		if err := applier.writeWorkingTree(conv); err != nil {
+			return err
+		}
+	}
+	if opts.cached || opts.index {
+		if err := applier.stage(idx); err != nil {
+			return err
+		}
+		if err := idx.WriteToFile(filepath.Join(repo.GitDir(), "index")); err != nil {
+			return fmt.Errorf("failed to write index: %w", err)
+		}
+	}
+	for _, p := range applier.conflicted() {
+		fmt.Fprintf(cmd.OutOrStdout(), "U %s\n", p)
+	}
+	return nil
+}
+
+// patchInput is a patch and the name it is reported by
+type patchInput struct {
+	name string
+	data []byte
+}
+
+// readPatchInputs reads the patch files args names, "-" being standard
+// input, or standard input when there are none
+func readPatchInputs(stdin io.Reader, args []string) ([]patchInput, error) {
+	if len(args) == 0 {
+		args = []string{"-"}
+	}
+	var inputs []patchInput
+	for _, arg := range args {
+		var data []byte
+		var err error
+		name := arg
+		if arg == "-" {
+			name = "<stdin>"
+			data, err = io.ReadAll(stdin)
+		} else {
+			data, err = os.ReadFile(arg)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", name, err)
+		}
+		inputs = append(inputs, patchInput{name: name, data: data})
+	}
+	return inputs, nil
+}
+
+// validateWhitespaceAction checks the value of --whitespace
+func validateWhitespaceAction(action string) error {
+	switch action {
+	case "", "nowarn", "warn", "fix", "strip", "error", "error-all":
+		return nil
+	}
+	return fmt.Errorf("unrecognized whitespace option '%s'", action)
+}
+
+// checkPatchWhitespace reports the whitespace errors of the lines files
+// add, read from the patch name, fixes them or refuses the patch as action
+// says
+func checkPatchWhitespace(errOut io.Writer, name string, files []*patch.File, action string) error {
+	if action == "nowarn" {
+		return nil
+	}
+	count := 0
+	for _, f := range files {
+		errs := f.WhitespaceErrors()
+		count += len(errs)
+		for _, e := range errs {
+			fmt.Fprintf(errOut, "%s:%d: %s.\n+%s\n", name, e.Line.Num, e.Problem, strings.TrimRight(e.Line.Text, "\r\n"))
+		}
+		if action == "fix" || action == "strip" {
+			f.FixWhitespace()
+		}
+	}
+	if count == 0 {
+		return nil
+	}
+
+	lines := "line adds"
+	if count > 1 {
+		lines = "lines add"
+	}
+	switch action {
+	case "fix", "strip":
+		fmt.Fprintf(errOut, "warning: %d %s whitespace errors, fixed.\n", count, lines)
+	case "error", "error-all":
+		return fmt.Errorf("%d %s whitespace errors", count, lines)
+	default:
+		fmt.Fprintf(errOut, "warning: %d %s whitespace errors.\n", count, lines)
+	}
+	return nil
+}
+
+// appliedFile is a file as the patches applied so far leave it
+type appliedFile struct {
+	mode    objects.FileMode
+	content []byte
+	exists  bool
+	// conflict is set when a three-way merge left conflict markers in
+	// content, and holds the versions merged
+	conflict *appliedConflict
+}
+
+// appliedConflict is the base, our and their version of a file whose
+// three-way merge conflicted
+type appliedConflict struct {
+	base, ours, theirs []byte
+}
+
+// readWorkingFile reads path from the working tree
+func readWorkingFile(repo *vcs.Repository, conv *convert.Converter, path string) (*appliedFile, error) {
+	full := filepath.Join(repo.WorkDir(), filepath.FromSlash(path))
+	info, err := os.Lstat(full)
+	if err != nil || !info.Mode().IsRegular() {
+		return &appliedFile{}, nil
+	}
+	data, err := os.ReadFile(full)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if data, err = conv.Clean(path, data); err != nil {
+		return nil, err
+	}
+	mode := objects.ModeBlob
+	if info.Mode()&0111 != 0 {
+		mode = objects.ModeExec
+	}
+	return &appliedFile{mode: mode, content: data, exists: true}, nil
+}
+
+// readIndexFile reads path as the index has it
+func readIndexFile(repo *vcs.Repository, idx *index.Index, path string) *appliedFile {
+	mode, id, ok := trackedFile(idx, path)
+	if !ok {
+		return &appliedFile{}
+	}
+	return &appliedFile{mode: mode, content: getObjectContent(repo, id), exists: true}
+}
+
+// readIndexedFile reads path from the working tree, which must match the
+// index
+func readIndexedFile(repo *vcs.Repository, conv *convert.Converter, idx *index.Index, path string) (*appliedFile, error) {
+	file, err := readWorkingFile(repo, conv, path)
+	if err != nil {
+		return nil, err
+	}
+	staged := readIndexFile(repo, idx, path)
+	if file.exists != staged.exists || objects.NewBlob(file.content).ID() != objects.NewBlob(staged.content).ID() {
+		return nil, fmt.Errorf("%s: does not match index", path)
+	}
+	return file, nil
+}
+
+// patchApplier applies patches to files read through read. The results
+// are kept in memory, so that later patches see what earlier ones did and
+// nothing is written unless all of them apply.
+type patchApplier struct {
+	repo     *vcs.Repository
+	errOut   io.Writer
+	read     func(path string) (*appliedFile, error)
+	threeWay bool
+	files    map[string]*appliedFile
+	// changed lists the paths the patches changed, in order
+	changed   []string
+	isChanged map[string]bool
+}
+
+func newPatchApplier(repo *vcs.Repository, errOut io.Writer, read func(path string) (*appliedFile, error), threeWay bool) *patchApplier {
+	return &patchApplier{
+		repo:      repo,
+		errOut:    errOut,
+		read:      read,
+		threeWay:  threeWay,
+		files:     make(map[string]*appliedFile),
+		isChanged: make(map[string]bool),
+	}
+}
+
+// file returns path as the patches applied so far leave it
+func (a *patchApplier) file(path string) (*appliedFile, error) {
+	if file, ok := a.files[path]; ok {
+		return file, nil
+	}
+	file, err := a.read(path)
+	if err != nil {
+		return nil, err
+	}
+	a.files[path] = file
+	return file, nil
+}
+
+// set records the new state of path
+func (a *patchApplier) set(path string, file *appliedFile) {
+	if !a.isChanged[path] {
+		a.isChanged[path] = true
+		a.changed = append(a.changed, path)
+	}
+	a.files[path] = file
+}
+
+// apply applies the change of f
+func (a *patchApplier) apply(f *patch.File) error {
+	name := f.Name()
+
+	old := &appliedFile{}
+	if f.OldPath != "" {
+		var err error
+		if old, err = a.file(f.OldPath); err != nil {
+			return err
+		}
+		if !old.exists {
+			return fmt.Errorf("%s: does not exist", f.OldPath)
+		}
+	}
+	if f.NewPath != "" && f.NewPath != f.OldPath {
+		existing, err := a.file(f.NewPath)
+		if err != nil {
+			return err
+		}
+		if existing.exists {
+			return fmt.Errorf("%s: already exists", f.NewPath)
+		}
+	}
+
+	content, err := f.Apply(old.content)
+	var conflict *appliedConflict
+	if err != nil {
+		var hunkErr *patch.HunkError
+		if !errors.As(err, &hunkErr) {
+			return err
+		}
+		fmt.Fprintf(a.errOut, "error: %v\n", err)
+		if !a.threeWay || f.OldPath == "" || f.NewPath == "" {
+			return fmt.Errorf("%s: patch does not apply", name)
+		}
+		fmt.Fprintln(a.errOut, "Falling back to three-way merge...")
+		if content, conflict, err = a.merge(f, old.content); err != nil {
+			return err
+		}
+		if conflict != nil {
+			fmt.Fprintf(a.errOut, "Applied patch to '%s' with conflicts.\n", name)
+		} else {
+			fmt.Fprintf(a.errOut, "Applied patch to '%s' cleanly.\n", name)
+		}
+	}
+
+	if f.NewPath == "" {
+		if len(content) > 0 {
+			return fmt.Errorf("%s: removal patch leaves file contents", name)
+		}
+		a.set(f.OldPath, &appliedFile{})
+		return nil
+	}
+	mode := old.mode
+	if f.NewMode != 0 {
+		mode = f.NewMode
+	} else if mode == 0 {
+		mode = objects.ModeBlob
+	}
+	if f.IsRename {
+		a.set(f.OldPath, &appliedFile{})
+	}
+	a.set(f.NewPath, &appliedFile{mode: mode, content: content, exists: true, conflict: conflict})
+	return nil
+}
+
+// merge applies f to the version it was made against, named by the blob
+// ID of its index line, and merges the result with ours
+func (a *patchApplier) merge(f *patch.File, ours []byte) ([]byte, *appliedConflict, error) {
+	missing := fmt.Errorf("%s: repository lacks the necessary blob to perform 3-way merge", f.Name())
+	if strings.Trim(f.OldID, "0") == "" {
+		return nil, nil, missing
+	}
+	ids, err := a.repo.Storage().FindObjects(f.OldID)
+	if err != nil || len(ids) != 1 {
+		return nil, nil, missing
+	}
+	blob, err := a.repo.GetBlob(ids[0])
+	if err != nil {
+		return nil, nil, missing
+	}
+
+	base := blob.Data()
+	theirs, err := f.Apply(base)
+	if err != nil {
+		return nil, nil, fmt.Errorf("%s: patch does not apply to its own preimage", f.Name())
+	}
+	merged, clean := merge.MergeContent(base, ours, theirs, merge.Options{})
+	if clean {
+		return merged, nil, nil
+	}
+	return merged, &appliedConflict{base: base, ours: ours, theirs: theirs}, nil
+}
+
+// conflicted returns the paths a three-way merge left conflicts in
+func (a *patchApplier) conflicted() []string {
+	var paths []string
+	for _, p := range a.changed {
+		if a.files[p].conflict != nil {
+			paths = append(paths, p)
+		}
+	}
+	return paths
+}
+
+// writeWorkingTree writes the changed files to the working tree
+func (a *patchApplier) writeWorkingTree(conv *convert.Converter) error {
+	for _, p := range a.changed {
+		file := a.files[p]
+		if !file.exists {
+			full := filepath.Join(a.repo.WorkDir(), filepath.FromSlash(p))
+			if err := os.Remove(full); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove %s: %w", p, err)
+			}
+			removeEmptyParents(a.repo.WorkDir(), filepath.Dir(full))
+			continue
+		}
+		if err := writeWorkingFile(a.repo, conv, p, file.mode, file.content); err != nil {
+			return err
+		}
+		// writeWorkingFile keeps the permissions of an existing file
+		perm := os.FileMode(0644)
+		if file.mode == objects.ModeExec {
+			perm = 0755
+		}
+		if err := os.Chmod(filepath.Join(a.repo.WorkDir(), filepath.FromSlash(p)), perm); err != nil {
+			return fmt.Errorf("failed to set mode of %s: %w", p, err)
+		}
+	}
+	return nil
+}
+
+// stage records the changed files in idx, conflicted ones at stages 1
+// (base), 2 (ours) and 3 (theirs)
+func (a *patchApplier) stage(idx *index.Index) error {
+	for _, p := range a.changed {
+		file := a.files[p]
+		if !file.exists {
+			idx.Remove(p)
+			continue
+		}
+
+		if file.conflict == nil {
+			blob, err := a.repo.CreateBlob(file.content)
+			if err != nil {
+				return fmt.Errorf("failed to write blob for %s: %w", p, err)
+			}
+			if err := idx.Add(&index.Entry{Mode: file.mode, ID: blob.ID(), Path: p, Size: uint32(len(file.content))}); err != nil {
+				return fmt.Errorf("failed to add %s to index: %w", p, err)
+			}
+			continue
+		}
+
+		for stage, content := range [][]byte{file.conflict.base, file.conflict.ours, file.conflict.theirs} {
+			blob, err := a.repo.CreateBlob(content)
+			if err != nil {
+				return fmt.Errorf("failed to write blob for %s: %w", p, err)
+			}
+			entry := &index.Entry{Mode: file.mode, ID: blob.ID(), Path: p}
+			entry.SetStage(stage + 1)
+			if err := idx.Add(entry); err != nil {
+				return fmt.Errorf("failed to add %s to index: %w", p, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+)
+
+func runApplyArgs(stdin string, args ...string) (string, error) {
+	cmd := newApplyCommand()
+	var stdout bytes.Buffer
+	cmd.SetIn(strings.NewReader(stdin))
+	cmd.SetOut(&stdout)
+	cmd.SetErr(&stdout)
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+	return stdout.String(), err
+}
+
+// blobAbbrev returns the abbreviated ID of a blob of content, as in the
+// index line of a patch
+func blobAbbrev(content string) string {
+	return objects.NewBlob([]byte(content)).ID().String()[:7]
+}
+
+const applyPatch = `diff --git a/a.txt b/a.txt
+--- a/a.txt
++++ b/a.txt
+@@ -2,3 +2,3 @@
+ 2
+-3
++three
+ 4
+diff --git a/b.txt b/b.txt
+deleted file mode 100644
+--- a/b.txt
++++ /dev/null
+@@ -1 +0,0 @@
+-b
+diff --git a/c.txt b/c.txt
+new file mode 100755
+--- /dev/null
++++ b/c.txt
+@@ -0,0 +1 @@
++c
+`
+
+func TestApply(t *testing.T) {
+	repo, _ := setupSeriesRepo(t, map[string]string{"a.txt": "1\n2\n3\n4\n5\n", "b.txt": "b\n"})
+	// Lines added above the hunk move it down
+	require.NoError(t, os.WriteFile("a.txt", []byte("0\n1\n2\n3\n4\n5\n"), 0644))
+
+	_, err := runApplyArgs(applyPatch, "--check")
+	require.NoError(t, err)
+	assert.NoFileExists(t, "c.txt")
+
+	_, err = runApplyArgs(applyPatch)
+	require.NoError(t, err)
+	assert.Equal(t, "0\n1\n2\nthree\n4\n5\n", readWorkFile(t, "a.txt"))
+	assert.NoFileExists(t, "b.txt")
+	assert.Equal(t, "c\n", readWorkFile(t, "c.txt"))
+	info, err := os.Stat("c.txt")
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0755), info.Mode().Perm())
+
+	// Nothing is staged
+	staged, err := hasUncommittedChanges(repo, refs.NewRefManager(repo.GitDir()))
+	require.NoError(t, err)
+	assert.False(t, staged)
+
+	out, err := runApplyArgs(applyPatch)
+	assert.ErrorContains(t, err, "patch does not apply")
+	assert.Contains(t, out, "error: patch failed: a.txt:2")
+
+	_, err = runApplyArgs(applyPatch, "-R")
+	require.NoError(t, err)
+	assert.Equal(t, "0\n1\n2\n3\n4\n5\n", readWorkFile(t, "a.txt"))
+	assert.Equal(t, "b\n", readWorkFile(t, "b.txt"))
+	assert.NoFileExists(t, "c.txt")
+}
+
+func TestApplyIsAtomic(t *testing.T) {
+	setupSeriesRepo(t, map[string]string{"a.txt": "1\n2\n3\n4\n5\n", "b.txt": "changed\n"})
+
+	_, err := runApplyArgs(applyPatch)
+	assert.ErrorContains(t, err, "b.txt: patch does not apply")
+	assert.Equal(t, "1\n2\n3\n4\n5\n", readWorkFile(t, "a.txt"))
+	assert.NoFileExists(t, "c.txt")
+
+	_, err = runApplyArgs("not a patch\n")
+	assert.ErrorContains(t, err, "no valid patches in input")
+	_, err = runApplyArgs(applyPatch, "--whitespace=sometimes")
+	assert.ErrorContains(t, err, "unrecognized whitespace option")
+}
+
+func TestApplyCachedAndIndex(t *testing.T) {
+	repo, _ := setupSeriesRepo(t, map[string]string{"a.txt": "1\n2\n3\n4\n5\n", "b.txt": "b\n"})
+
+	_, err := runApplyArgs(applyPatch, "--cached")
+	require.NoError(t, err)
+	assert.Equal(t, "1\n2\n3\n4\n5\n", readWorkFile(t, "a.txt"))
+	assert.FileExists(t, "b.txt")
+	assert.NoFileExists(t, "c.txt")
+
+	idx, _, err := patchIndex(repo)
+	require.NoError(t, err)
+	_, id, ok := trackedFile(idx, "a.txt")
+	require.True(t, ok)
+	assert.Equal(t, "1\n2\nthree\n4\n5\n", string(getObjectContent(repo, id)))
+	mode, _, ok := trackedFile(idx, "c.txt")
+	require.True(t, ok)
+	assert.Equal(t, objects.ModeExec, mode)
+	_, _, tracked := trackedFile(idx, "b.txt")
+	assert.False(t, tracked)
+
+	// The working tree no longer matches the index
+	_, err = runApplyArgs(applyPatch, "--index", "-R")
+	assert.ErrorContains(t, err, "does not match index")
+
+	require.NoError(t, os.WriteFile("a.txt", []byte("1\n2\nthree\n4\n5\n"), 0644))
+	require.NoError(t, os.Remove("b.txt"))
+	require.NoError(t, os.WriteFile("c.txt", []byte("c\n"), 0755))
+	_, err = runApplyArgs(applyPatch, "--index", "-R")
+	require.NoError(t, err)
+	assert.Equal(t, "1\n2\n3\n4\n5\n", readWorkFile(t, "a.txt"))
+	assert.Equal(t, "b\n", readWorkFile(t, "b.txt"))
+	assert.NoFileExists(t, "c.txt")
+}
+
+func TestApplyThreeWay(t *testing.T) {
+	base := "1\n2\n3\n4\n5\n6\n7\n8\n9\n"
+	repo, _ := setupSeriesRepo(t, map[string]string{"a.txt": base})
+	patch := "diff --git a/a.txt b/a.txt\nindex " + blobAbbrev(base) + "..1234567 100644\n" + `--- a/a.txt
++++ b/a.txt
+@@ -1,4 +1,4 @@
+ 1
+-2
++two
+ 3
+ 4
+@@ -6,4 +6,4 @@
+ 6
+ 7
+-8
++eight
+ 9
+`
+	// Our change overlaps the first hunk but not the second
+	require.NoError(t, os.WriteFile("a.txt", []byte("1\nTWO\n3\n4\n5\n6\n7\n8\n9\n"), 0644))
+	_, err := runApplyArgs(patch)
+	assert.ErrorContains(t, err, "patch does not apply")
+
+	// -3 needs the index to match
+	_, err = runApplyArgs(patch, "-3")
+	assert.ErrorContains(t, err, "does not match index")
+	require.NoError(t, os.WriteFile("a.txt", []byte(base), 0644))
+	_, err = runApplyArgs("--- a/a.txt\n+++ b/a.txt\n@@ -1,3 +1,3 @@\n 1\n-2\n+TWO\n 3\n", "--index")
+	require.NoError(t, err)
+
+	out, err := runApplyArgs(patch, "-3")
+	require.NoError(t, err)
+	assert.Contains(t, out, "Falling back to three-way merge...")
+	assert.Contains(t, out, "Applied patch to 'a.txt' with conflicts.")
+	assert.Contains(t, out, "U a.txt")
+	assert.Equal(t, "1\n<<<<<<< ours\nTWO\n=======\ntwo\n>>>>>>> theirs\n3\n4\n5\n6\n7\neight\n9\n", readWorkFile(t, "a.txt"))
+
+	idx, _, err := patchIndex(repo)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a.txt"}, idx.Unmerged())
+
+	// Without the blob the patch was made against there is nothing to merge
+	missing := strings.Replace(patch, blobAbbrev(base), "fedcba9", 1)
+	require.NoError(t, os.WriteFile("a.txt", []byte("1\nTWO\n3\n4\n5\n6\n7\n8\n9\n"), 0644))
+	_, err = runApplyArgs(missing, "-3", "--cached")
+	assert.ErrorContains(t, err, "lacks the necessary blob")
+}
+
+func TestApplyWhitespace(t *testing.T) {
+	setupSeriesRepo(t, map[string]string{"a.txt": "a\n"})
+	patch := "--- a/a.txt\n+++ b/a.txt\n@@ -1 +1,3 @@\n a\n+b \n+\n"
+
+	out, err := runApplyArgs(patch, "--check")
+	require.NoError(t, err)
+	assert.Contains(t, out, "<stdin>:5: trailing whitespace.\n+b \n")
+	assert.Contains(t, out, "<stdin>:6: new blank line at EOF.")
+	assert.Contains(t, out, "warning: 2 lines add whitespace errors.")
+
+	_, err = runApplyArgs(patch, "--whitespace=error")
+	assert.ErrorContains(t, err, "2 lines add whitespace errors")
+	assert.Equal(t, "a\n", readWorkFile(t, "a.txt"))
+
+	_, err = runApplyArgs(patch, "--whitespace=fix")
+	require.NoError(t, err)
+	assert.Equal(t, "a\nb\n", readWorkFile(t, "a.txt"))
+}
+
+func TestApplyBinaryDiff(t *testing.T) {
+	old := strings.Repeat("\x00binary\x01", 300)
+	new := "\x00prefix" + old[:2000]
+	setupTreeRepo(t, map[string]string{"data.bin": old})
+	require.NoError(t, os.WriteFile("data.bin", []byte(new), 0644))
+
+	runDiffArgs := func(args ...string) (string, error) {
+		return captureStdout(t, func() error {
+			cmd := newDiffCommand()
+			cmd.SetArgs(args)
+			return cmd.Execute()
+		})
+	}
+	out, err := runDiffArgs("--binary")
+	require.NoError(t, err)
+	assert.Contains(t, out, "index "+objects.NewBlob([]byte(old)).ID().String()+"..")
+	assert.Contains(t, out, "GIT binary patch\n")
+	plain, err := runDiffArgs()
+	require.NoError(t, err)
+	assert.Contains(t, plain, "index "+blobAbbrev(old)+"..")
+	assert.Contains(t, plain, "Binary files a/data.bin and b/data.bin differ\n")
+
+	// The patch applies in reverse, and forward again
+	_, err = runApplyArgs(out, "-R")
+	require.NoError(t, err)
+	assert.Equal(t, old, readWorkFile(t, "data.bin"))
+	_, err = runApplyArgs(out)
+	require.NoError(t, err)
+	assert.Equal(t, new, readWorkFile(t, "data.bin"))
+	_, err = runApplyArgs(out)
+	assert.ErrorContains(t, err, "does not match the current contents")
+
+	_, err = runApplyArgs(plain, "-R")
+	assert.ErrorContains(t, err, "without full index line")
+}
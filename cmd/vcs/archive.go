@@ -0,0 +1,332 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/convert"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/revparse"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// archiveOptions holds the flags of archive
+type archiveOptions struct {
+	format string
+	prefix string
+	output string
+	mtime  string
+}
+
+// archiveWriter writes the entries of one archive format
+type archiveWriter interface {
+	dir(name string) error
+	file(name string, mode objects.FileMode, data []byte) error
+	Close() error
+}
+
+func newArchiveCommand() *cobra.Command {
+	var opts archiveOptions
+
+	cmd := &cobra.Command{
+		Use:   "archive [flags] <tree-ish>",
+		Short: "Create an archive of files from a named tree",
+		Long: `Writes the files of a commit or tree to a tar or zip archive on stdout,
+or to the file given with --output, without needing a working tree. The
+format is taken from --format, or else from the extension of the output
+file: .zip, .tar.gz or .tgz, and tar otherwise.
+
+--prefix is prepended to every path, so --prefix=project-1.0/ unpacks into
+a directory. Every entry gets the time of the commit, or the current time
+for a tree, so archiving the same commit twice gives the same archive;
+--mtime sets another. The commit ID is recorded as the archive comment.
+
+Files are converted as on checkout, and those with the export-ignore
+attribute in the tree's .gitattributes files or info/attributes are left
+out, as are directories with it.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runArchive(cmd, args[0], opts)
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.format, "format", "", "Format of the archive: tar, tgz, tar.gz or zip")
+	cmd.Flags().StringVar(&opts.prefix, "prefix", "", "Prepend <prefix> to every path in the archive")
+	cmd.Flags().StringVarP(&opts.output, "output", "o", "", "Write the archive to <file> instead of stdout")
+	cmd.Flags().StringVar(&opts.mtime, "mtime", "", "Set the modification time of every entry")
+
+	return cmd
+}
+
+func runArchive(cmd *cobra.Command, treeish string, opts archiveOptions) error {
+	format := opts.format
+	if format == "" {
+		format = archiveFormatOf(opts.output)
+	}
+	switch format {
+	case "tar", "tgz", "tar.gz", "zip":
+	default:
+		return fmt.Errorf("unknown archive format '%s'", format)
+	}
+
+	repoPath, err := findRepository()
+	if err != nil {
+		return fmt.Errorf("not a git repository: %w", err)
+	}
+	repo, err := openRepository(repoPath)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	treeID, commitID, mtime, err := resolveArchiveTree(repo, treeish)
+	if err != nil {
+		return err
+	}
+	if opts.mtime != "" {
+		if mtime, err = revparse.ParseDate(opts.mtime, time.Now()); err != nil {
+			return fmt.Errorf("invalid --mtime: %w", err)
+		}
+	}
+	tree, err := repo.GetTree(treeID)
+	if err != nil {
+		return fmt.Errorf("failed to read tree %s: %w", treeID.Short(), err)
+	}
+
+	out := cmd.OutOrStdout()
+	if opts.output != "" {
+		f, err := os.Create(opts.output)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", opts.output, err)
+		}
+		defer f.Close()
+		out = f
+	}
+
+	var w archiveWriter
+	switch format {
+	case "zip":
+		w, err = newZipArchive(out, commitID, mtime)
+	case "tar":
+		w, err = newTarArchive(out, nil, commitID, mtime)
+	default:
+		w, err = newTarArchive(out, gzip.NewWriter(out), commitID, mtime)
+	}
+	if err == nil {
+		conv := newConverter(repo, cmd.ErrOrStderr(), treeAttributes(repo, tree))
+		err = writeArchiveTree(w, repo, conv, tree, "", opts.prefix)
+		if closeErr := w.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	if err != nil {
+		if opts.output != "" {
+			os.Remove(opts.output)
+		}
+		return fmt.Errorf("failed to write archive: %w", err)
+	}
+	return nil
+}
+
+// archiveFormatOf returns the format the extension of output names
+func archiveFormatOf(output string) string {
+	switch {
+	case strings.HasSuffix(output, ".zip"):
+		return "zip"
+	case strings.HasSuffix(output, ".tar.gz"), strings.HasSuffix(output, ".tgz"):
+		return "tgz"
+	}
+	return "tar"
+}
+
+// resolveArchiveTree resolves treeish to its tree, the commit it names if
+// any, and the time to give the entries: the committer time of the commit,
+// or now for a bare tree
+func resolveArchiveTree(repo *vcs.Repository, treeish string) (objects.ObjectID, objects.ObjectID, time.Time, error) {
+	id, err := newResolver(repo).Resolve(treeish)
+	if err != nil {
+		return objects.ObjectID{}, objects.ObjectID{}, time.Time{}, err
+	}
+	for {
+		obj, err := repo.ReadObject(id)
+		if err != nil {
+			return objects.ObjectID{}, objects.ObjectID{}, time.Time{}, fmt.Errorf("failed to read object %s: %w", id.Short(), err)
+		}
+		switch obj := obj.(type) {
+		case *objects.Tag:
+			id = obj.Object()
+		case *objects.Commit:
+			return obj.Tree(), id, obj.Committer().When, nil
+		case *objects.Tree:
+			return id, objects.ObjectID{}, time.Now(), nil
+		default:
+			return objects.ObjectID{}, objects.ObjectID{}, time.Time{}, fmt.Errorf("not a tree object: %s", treeish)
+		}
+	}
+}
+
+// writeArchiveTree writes the entries of tree, found at dir, under prefix
+func writeArchiveTree(w archiveWriter, repo *vcs.Repository, conv *convert.Converter, tree *objects.Tree, dir, prefix string) error {
+	for _, entry := range tree.Entries() {
+		name := path.Join(dir, entry.Name)
+		if conv.Attributes(name).IsSet("export-ignore") {
+			continue
+		}
+
+		switch entry.Mode {
+		case objects.ModeTree:
+			subtree, err := repo.GetTree(entry.ID)
+			if err != nil {
+				return fmt.Errorf("failed to read tree %s: %w", name, err)
+			}
+			if err := w.dir(prefix + name + "/"); err != nil {
+				return err
+			}
+			if err := writeArchiveTree(w, repo, conv, subtree, name, prefix); err != nil {
+				return err
+			}
+		case objects.ModeCommit:
+			// Submodules are archived as empty directories, as in Git
+			if err := w.dir(prefix + name + "/"); err != nil {
+				return err
+			}
+		default:
+			blob, err := repo.GetBlob(entry.ID)
+			if err != nil {
+				return fmt.Errorf("failed to read %s: %w", name, err)
+			}
+			data := blob.Data()
+			if entry.Mode != objects.ModeSymlink {
+				if data, err = conv.Smudge(name, data); err != nil {
+					return err
+				}
+			}
+			if err := w.file(prefix+name, entry.Mode, data); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// tarArchive writes a tar archive with the modes Git gives entries
+type tarArchive struct {
+	tw    *tar.Writer
+	gz    *gzip.Writer
+	mtime time.Time
+}
+
+// newTarArchive starts a tar archive on out, compressed through gz when it
+// is not nil, recording commitID when it is not zero
+func newTarArchive(out io.Writer, gz *gzip.Writer, commitID objects.ObjectID, mtime time.Time) (*tarArchive, error) {
+	a := &tarArchive{gz: gz, mtime: mtime}
+	if gz != nil {
+		out = gz
+	}
+	a.tw = tar.NewWriter(out)
+	if commitID.IsZero() {
+		return a, nil
+	}
+	err := a.tw.WriteHeader(&tar.Header{
+		Typeflag:   tar.TypeXGlobalHeader,
+		PAXRecords: map[string]string{"comment": commitID.String()},
+	})
+	return a, err
+}
+
+func (a *tarArchive) header(name string, mode int64) *tar.Header {
+	return &tar.Header{Name: name, Mode: mode, ModTime: a.mtime, Uname: "root", Gname: "root"}
+}
+
+func (a *tarArchive) dir(name string) error {
+	hdr := a.header(name, 0775)
+	hdr.Typeflag = tar.TypeDir
+	return a.tw.WriteHeader(hdr)
+}
+
+func (a *tarArchive) file(name string, mode objects.FileMode, data []byte) error {
+	if mode == objects.ModeSymlink {
+		hdr := a.header(name, 0777)
+		hdr.Typeflag = tar.TypeSymlink
+		hdr.Linkname = string(data)
+		return a.tw.WriteHeader(hdr)
+	}
+
+	hdr := a.header(name, 0664)
+	if mode == objects.ModeExec {
+		hdr.Mode = 0775
+	}
+	hdr.Typeflag = tar.TypeReg
+	hdr.Size = int64(len(data))
+	if err := a.tw.WriteHeader(hdr); err != nil {
+		return err
+	}
+	_, err := a.tw.Write(data)
+	return err
+}
+
+func (a *tarArchive) Close() error {
+	if err := a.tw.Close(); err != nil {
+		return err
+	}
+	if a.gz != nil {
+		return a.gz.Close()
+	}
+	return nil
+}
+
+// zipArchive writes a zip archive with Unix modes
+type zipArchive struct {
+	zw    *zip.Writer
+	mtime time.Time
+}
+
+// newZipArchive starts a zip archive on out, recording commitID as its
+// comment when it is not zero
+func newZipArchive(out io.Writer, commitID objects.ObjectID, mtime time.Time) (*zipArchive, error) {
+	a := &zipArchive{zw: zip.NewWriter(out), mtime: mtime}
+	if !commitID.IsZero() {
+		if err := a.zw.SetComment(commitID.String()); err != nil {
+			return nil, err
+		}
+	}
+	return a, nil
+}
+
+func (a *zipArchive) dir(name string) error {
+	hdr := &zip.FileHeader{Name: name, Modified: a.mtime}
+	hdr.SetMode(os.ModeDir | 0755)
+	_, err := a.zw.CreateHeader(hdr)
+	return err
+}
+
+func (a *zipArchive) file(name string, mode objects.FileMode, data []byte) error {
+	hdr := &zip.FileHeader{Name: name, Modified: a.mtime, Method: zip.Deflate}
+	switch mode {
+	case objects.ModeSymlink:
+		hdr.SetMode(os.ModeSymlink | 0777)
+		hdr.Method = zip.Store
+	case objects.ModeExec:
+		hdr.SetMode(0755)
+	default:
+		hdr.SetMode(0644)
+	}
+	fw, err := a.zw.CreateHeader(hdr)
+	if err != nil {
+		return err
+	}
+	_, err = fw.Write(data)
+	return err
+}
+
+func (a *zipArchive) Close() error {
+	return a.zw.Close()
+}
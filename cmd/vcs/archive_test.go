@@ -0,0 +1,136 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func runArchiveArgs(t *testing.T, args ...string) []byte {
+	cmd := newArchiveCommand()
+	var stdout bytes.Buffer
+	cmd.SetOut(&stdout)
+	cmd.SetArgs(args)
+	require.NoError(t, cmd.Execute())
+	return stdout.Bytes()
+}
+
+// readTar returns the entries of a tar archive by name, with the contents
+// of files, and the global header
+func readTar(t *testing.T, r io.Reader) (map[string]string, map[string]*tar.Header, *tar.Header) {
+	tr := tar.NewReader(r)
+	contents := make(map[string]string)
+	headers := make(map[string]*tar.Header)
+	var global *tar.Header
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		if hdr.Typeflag == tar.TypeXGlobalHeader {
+			global = hdr
+			continue
+		}
+		data, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		contents[hdr.Name] = string(data)
+		headers[hdr.Name] = hdr
+	}
+	return contents, headers, global
+}
+
+func TestArchiveTar(t *testing.T) {
+	setupTreeRepo(t, map[string]string{
+		".gitattributes":  "docs export-ignore\n*.log export-ignore\n",
+		"a.txt":           "a\n",
+		"debug.log":       "log\n",
+		"docs/guide.md":   "guide\n",
+		"src/main.go":     "package main\n",
+		"src/sub/util.go": "package sub\n",
+	})
+	repo, err := openRepository(".")
+	require.NoError(t, err)
+	head, err := resolveCommitish(repo, "HEAD")
+	require.NoError(t, err)
+	commit, err := repo.GetCommit(head)
+	require.NoError(t, err)
+
+	contents, headers, global := readTar(t, bytes.NewReader(runArchiveArgs(t, "--prefix=project/", "HEAD")))
+	assert.Equal(t, map[string]string{
+		"project/.gitattributes":  "docs export-ignore\n*.log export-ignore\n",
+		"project/a.txt":           "a\n",
+		"project/src/":            "",
+		"project/src/main.go":     "package main\n",
+		"project/src/sub/":        "",
+		"project/src/sub/util.go": "package sub\n",
+	}, contents)
+	require.NotNil(t, global)
+	assert.Equal(t, head.String(), global.PAXRecords["comment"])
+	for name, hdr := range headers {
+		assert.True(t, hdr.ModTime.Equal(commit.Committer().When.Truncate(time.Second)), name)
+	}
+	assert.Equal(t, int64(0664), headers["project/a.txt"].Mode)
+
+	// The same commit always gives the same archive
+	assert.Equal(t, runArchiveArgs(t, "HEAD"), runArchiveArgs(t, "HEAD"))
+
+	gz, err := gzip.NewReader(bytes.NewReader(runArchiveArgs(t, "--format=tgz", "--mtime=2024-01-02", "HEAD:src")))
+	require.NoError(t, err)
+	contents, headers, global = readTar(t, gz)
+	assert.Equal(t, map[string]string{
+		"main.go":     "package main\n",
+		"sub/":        "",
+		"sub/util.go": "package sub\n",
+	}, contents)
+	assert.Nil(t, global)
+	assert.Equal(t, 2024, headers["main.go"].ModTime.Year())
+}
+
+func TestArchiveZip(t *testing.T) {
+	setupTreeRepo(t, map[string]string{
+		".gitattributes": "secret.txt export-ignore\n",
+		"a.txt":          "a\n",
+		"secret.txt":     "s\n",
+		"dir/b.txt":      "b\n",
+	})
+
+	runArchiveArgs(t, "-o", "out.zip", "HEAD")
+	zr, err := zip.OpenReader("out.zip")
+	require.NoError(t, err)
+	defer zr.Close()
+
+	repo, err := openRepository(".")
+	require.NoError(t, err)
+	head, err := resolveCommitish(repo, "HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, head.String(), zr.Comment)
+
+	contents := make(map[string]string)
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		require.NoError(t, err)
+		data, err := io.ReadAll(rc)
+		rc.Close()
+		require.NoError(t, err)
+		contents[f.Name] = string(data)
+	}
+	assert.Equal(t, map[string]string{
+		".gitattributes": "secret.txt export-ignore\n",
+		"a.txt":          "a\n",
+		"dir/":           "",
+		"dir/b.txt":      "b\n",
+	}, contents)
+
+	cmd := newArchiveCommand()
+	cmd.SetOut(io.Discard)
+	cmd.SetArgs([]string{"--format=rar", "HEAD"})
+	assert.ErrorContains(t, cmd.Execute(), "unknown archive format")
+}
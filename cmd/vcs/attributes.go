@@ -0,0 +1,148 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fenilsonani/vcs/internal/core/config"
+	"github.com/fenilsonani/vcs/internal/core/convert"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/workdir"
+	"github.com/fenilsonani/vcs/internal/lfs"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// newAttributeResolver resolves attributes from the .gitattributes files
+// read reads, core.attributesFile and info/attributes. A nil read reads
+// them from the working tree.
+func newAttributeResolver(repo *vcs.Repository, cfg *config.Config, read workdir.AttributeReader) *workdir.AttributeResolver {
+	if read == nil {
+		read = workdir.WorkTreeAttributes(repo.WorkDir())
+	}
+	return workdir.NewAttributeResolver(read, globalAttributesFile(cfg), filepath.Join(repo.CommonDir(), "info", "attributes"))
+}
+
+// globalAttributesFile returns core.attributesFile, or else the attributes
+// file in the XDG config directory
+func globalAttributesFile(cfg *config.Config) string {
+	if path, ok := cfg.Get("core.attributesFile"); ok && path != "" {
+		if strings.HasPrefix(path, "~/") {
+			if home, err := os.UserHomeDir(); err == nil {
+				path = filepath.Join(home, path[2:])
+			}
+		}
+		return path
+	}
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		return filepath.Join(xdg, "git", "attributes")
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".config", "git", "attributes")
+	}
+	return ""
+}
+
+// newConverter returns a converter for the repository's attributes, with
+// core.autocrlf, core.eol, the filter drivers and lfs.threshold
+// configured, reporting filters that fail on errOut. read is as for
+// newAttributeResolver.
+func newConverter(repo *vcs.Repository, errOut io.Writer, read workdir.AttributeReader) *convert.Converter {
+	cfg := loadConfig(repo.GitDir())
+	opts := convert.Options{
+		AutoCRLF: "false",
+		Drivers:  make(map[string]convert.Driver),
+		WorkTree: repo.WorkDir(),
+		Stderr:   errOut,
+	}
+	if value, ok := cfg.Get("core.autocrlf"); ok {
+		if strings.EqualFold(strings.TrimSpace(value), "input") {
+			opts.AutoCRLF = "input"
+		} else if on, err := config.ParseBool(value); err == nil && on {
+			opts.AutoCRLF = "true"
+		}
+	}
+	opts.EOL, _ = cfg.Get("core.eol")
+	opts.EOL = strings.ToLower(opts.EOL)
+
+	for _, name := range cfg.Subsections("filter") {
+		driver := convert.Driver{}
+		driver.Clean, _ = cfg.Get("filter." + name + ".clean")
+		driver.Smudge, _ = cfg.Get("filter." + name + ".smudge")
+		if value, ok := cfg.Get("filter." + name + ".required"); ok {
+			driver.Required, _ = config.ParseBool(value)
+		}
+		opts.Drivers[name] = driver
+	}
+	opts.DiffDrivers = make(map[string]convert.DiffDriver)
+	for _, name := range cfg.Subsections("diff") {
+		driver := convert.DiffDriver{}
+		driver.Textconv, _ = cfg.Get("diff." + name + ".textconv")
+		driver.Command, _ = cfg.Get("diff." + name + ".command")
+		if value, ok := cfg.Get("diff." + name + ".cachetextconv"); ok {
+			driver.CacheTextconv, _ = config.ParseBool(value)
+		}
+		if value, ok := cfg.Get("diff." + name + ".binary"); ok {
+			driver.Binary, _ = config.ParseBool(value)
+		}
+		opts.DiffDrivers[name] = driver
+	}
+
+	// Large files are kept in the LFS store by the built-in lfs driver,
+	// unless one is configured, such as Git LFS's own
+	if lfsDriver := opts.Drivers["lfs"]; lfsDriver.Clean == "" && lfsDriver.Smudge == "" {
+		store := lfs.NewStore(repo.CommonDir())
+		lfsDriver.CleanFunc = func(_ string, data []byte) ([]byte, error) { return store.Clean(data) }
+		lfsDriver.SmudgeFunc = func(_ string, data []byte) ([]byte, error) { return store.Smudge(data) }
+		opts.Drivers["lfs"] = lfsDriver
+	}
+	if value, ok := cfg.Get("lfs.threshold"); ok {
+		if threshold, err := parseConfigSize(value); err == nil && threshold > 0 {
+			opts.LargeFileFilter = "lfs"
+			opts.LargeFileThreshold = threshold
+		}
+	}
+
+	return convert.New(newAttributeResolver(repo, cfg, read), opts)
+}
+
+// treeAttributes reads the .gitattributes files recorded in tree, for
+// checking out a tree before its files are in the working tree
+func treeAttributes(repo *vcs.Repository, tree *objects.Tree) workdir.AttributeReader {
+	return func(dir string) ([]byte, bool) {
+		current := tree
+		if dir != "" {
+			for _, name := range strings.Split(dir, "/") {
+				entry, ok := treeEntry(current, name)
+				if !ok || entry.Mode != objects.ModeTree {
+					return nil, false
+				}
+				subtree, err := repo.GetTree(entry.ID)
+				if err != nil {
+					return nil, false
+				}
+				current = subtree
+			}
+		}
+		entry, ok := treeEntry(current, ".gitattributes")
+		if !ok || entry.Mode == objects.ModeTree || entry.Mode == objects.ModeCommit {
+			return nil, false
+		}
+		blob, err := repo.GetBlob(entry.ID)
+		if err != nil {
+			return nil, false
+		}
+		return blob.Data(), true
+	}
+}
+
+// treeEntry returns the entry called name in tree
+func treeEntry(tree *objects.Tree, name string) (objects.TreeEntry, bool) {
+	for _, entry := range tree.Entries() {
+		if entry.Name == name {
+			return entry, true
+		}
+	}
+	return objects.TreeEntry{}, false
+}
@@ -0,0 +1,154 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/index"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// stagedContent returns the content of path as staged in the index
+func stagedContent(t *testing.T, repo *vcs.Repository, path string) string {
+	idx := index.New()
+	require.NoError(t, idx.ReadFromFile(filepath.Join(repo.GitDir(), "index")))
+	entry, ok := idx.Get(path)
+	require.True(t, ok, "%s is not staged", path)
+	blob, err := repo.GetBlob(entry.ID)
+	require.NoError(t, err)
+	return string(blob.Data())
+}
+
+func TestAddNormalizesLineEndings(t *testing.T) {
+	repo, _ := setupConfigRepo(t)
+	require.NoError(t, os.WriteFile(".gitattributes", []byte("*.txt text\n*.bin binary\n"), 0644))
+	require.NoError(t, os.WriteFile("notes.txt", []byte("one\r\ntwo\r\n"), 0644))
+	require.NoError(t, os.WriteFile("data.bin", []byte("one\r\ntwo\r\n"), 0644))
+
+	_, err := runCommandArgs(newAddCommand(), ".gitattributes", "notes.txt", "data.bin")
+	require.NoError(t, err)
+
+	assert.Equal(t, "one\ntwo\n", stagedContent(t, repo, "notes.txt"))
+	assert.Equal(t, "one\r\ntwo\r\n", stagedContent(t, repo, "data.bin"))
+
+	// The CRLF working copy is clean once normalized
+	out, err := runCommandArgs(newStatusCommand(), "--porcelain")
+	require.NoError(t, err)
+	assert.NotContains(t, out, "notes.txt")
+}
+
+func TestCheckoutConvertsLineEndings(t *testing.T) {
+	repo, _ := setupConfigRepo(t)
+	require.NoError(t, os.WriteFile(".gitattributes", []byte("*.txt text\nrun.txt eol=crlf\n"), 0644))
+	require.NoError(t, os.WriteFile("run.txt", []byte("one\ntwo\n"), 0644))
+	require.NoError(t, os.WriteFile("unix.txt", []byte("one\ntwo\n"), 0644))
+	_, err := runCommandArgs(newAddCommand(), "-A")
+	require.NoError(t, err)
+	_, err = stageAndCommit(t, repo, "base.txt", "-m", "base")
+	require.NoError(t, err)
+
+	require.NoError(t, os.Remove("run.txt"))
+	require.NoError(t, os.Remove("unix.txt"))
+	_, err = captureStdout(t, func() error {
+		cmd := newResetCommand()
+		cmd.SetArgs([]string{"--hard", "HEAD"})
+		return cmd.Execute()
+	})
+	require.NoError(t, err)
+
+	data, err := os.ReadFile("run.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "one\r\ntwo\r\n", string(data))
+	data, err = os.ReadFile("unix.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "one\ntwo\n", string(data))
+
+	out, err := runCommandArgs(newCatFileCommand(), "--filters", "HEAD:run.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "one\r\ntwo\r\n", out)
+}
+
+func TestFilterDriverRoundTrip(t *testing.T) {
+	repo, _ := setupConfigRepo(t)
+	_, err := runConfigArgs("filter.rot.clean", "tr a-z n-za-m")
+	require.NoError(t, err)
+	_, err = runConfigArgs("filter.rot.smudge", "tr n-za-m a-z")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(".gitattributes", []byte("*.secret filter=rot\n"), 0644))
+	require.NoError(t, os.WriteFile("key.secret", []byte("hello\n"), 0644))
+
+	_, err = runCommandArgs(newAddCommand(), ".gitattributes", "key.secret")
+	require.NoError(t, err)
+	assert.Equal(t, "uryyb\n", stagedContent(t, repo, "key.secret"))
+
+	out, err := runCommandArgs(newHashObjectCommand(), "key.secret")
+	require.NoError(t, err)
+	idx := index.New()
+	require.NoError(t, idx.ReadFromFile(filepath.Join(repo.GitDir(), "index")))
+	entry, _ := idx.Get("key.secret")
+	assert.Equal(t, entry.ID.String()+"\n", out)
+
+	out, err = runCommandArgs(newHashObjectCommand(), "--no-filters", "key.secret")
+	require.NoError(t, err)
+	assert.NotEqual(t, entry.ID.String()+"\n", out)
+
+	out, err = runCommandArgs(newCatFileCommand(), "--filters", ":key.secret")
+	require.NoError(t, err)
+	assert.Equal(t, "hello\n", out)
+
+	out, err = runCommandArgs(newCatFileCommand(), "--path", "other.secret", entry.ID.String())
+	require.NoError(t, err)
+	assert.Equal(t, "hello\n", out)
+
+	out, err = runCommandArgs(newCatFileCommand(), "-p", entry.ID.String())
+	require.NoError(t, err)
+	assert.Equal(t, "uryyb\n", out)
+}
+
+func TestHashObjectPath(t *testing.T) {
+	setupConfigRepo(t)
+	require.NoError(t, os.WriteFile(".gitattributes", []byte("*.txt text\n"), 0644))
+	require.NoError(t, os.WriteFile("crlf.dat", []byte("one\r\n"), 0644))
+	require.NoError(t, os.WriteFile("lf.dat", []byte("one\n"), 0644))
+
+	want, err := runCommandArgs(newHashObjectCommand(), "lf.dat")
+	require.NoError(t, err)
+	raw, err := runCommandArgs(newHashObjectCommand(), "crlf.dat")
+	require.NoError(t, err)
+	assert.NotEqual(t, want, raw)
+
+	got, err := runCommandArgs(newHashObjectCommand(), "--path", "notes.txt", "crlf.dat")
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+}
+
+func TestDiffBinaryFiles(t *testing.T) {
+	repo, _ := setupConfigRepo(t)
+	require.NoError(t, os.WriteFile(".gitattributes", []byte("*.lock -diff\n"), 0644))
+	require.NoError(t, os.WriteFile("deps.lock", []byte("a\n"), 0644))
+	require.NoError(t, os.WriteFile("image.raw", []byte("x\x00y\n"), 0644))
+	require.NoError(t, os.WriteFile("notes.txt", []byte("a\n"), 0644))
+	_, err := runCommandArgs(newAddCommand(), "-A")
+	require.NoError(t, err)
+	_, err = stageAndCommit(t, repo, "base.txt", "-m", "base")
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile("deps.lock", []byte("b\n"), 0644))
+	require.NoError(t, os.WriteFile("image.raw", []byte("x\x00z\n"), 0644))
+	require.NoError(t, os.WriteFile("notes.txt", []byte("b\n"), 0644))
+
+	out, err := captureStdout(t, func() error {
+		cmd := newDiffCommand()
+		cmd.SetArgs([]string{"HEAD"})
+		return cmd.Execute()
+	})
+	require.NoError(t, err)
+	assert.Contains(t, out, "Binary files a/deps.lock and b/deps.lock differ")
+	assert.Contains(t, out, "Binary files a/image.raw and b/image.raw differ")
+	assert.NotContains(t, out, "+z")
+	assert.Contains(t, out, "+b")
+}
@@ -0,0 +1,260 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fenilsonani/vcs/internal/core/config"
+	"github.com/spf13/cobra"
+)
+
+// autocorrector maps unknown command names to the closest known command or
+// alias before cobra dispatches, following Git's help.autocorrect setting:
+//
+//	unset, 0, false, show  list similar commands and fail
+//	never                  fail without suggestions
+//	immediate, negative    run the closest command right away
+//	prompt                 ask before running the closest command
+//	N > 0                  run the closest command after N deciseconds
+type autocorrector struct {
+	root   *cobra.Command
+	config map[string]string
+	in     io.Reader
+	out    io.Writer
+	sleep  func(time.Duration)
+}
+
+// newAutocorrector creates an autocorrector using the repository and global
+// configuration
+func newAutocorrector(root *cobra.Command) *autocorrector {
+	return &autocorrector{
+		root:   root,
+		config: loadCommandConfig(),
+		in:     os.Stdin,
+		out:    os.Stderr,
+		sleep:  time.Sleep,
+	}
+}
+
+// resolve returns args with aliases expanded and, when allowed, a mistyped
+// command name replaced by its closest match
+func (a *autocorrector) resolve(args []string) ([]string, error) {
+	if len(args) == 0 || strings.HasPrefix(args[0], "-") {
+		return args, nil
+	}
+
+	name := args[0]
+	if a.isCommand(name) {
+		return args, nil
+	}
+	if expanded, ok := a.expandAlias(args); ok {
+		return expanded, nil
+	}
+
+	setting := strings.ToLower(a.config["help.autocorrect"])
+	if setting == "never" {
+		return args, nil
+	}
+
+	candidates := a.similarCommands(name)
+	if len(candidates) == 0 {
+		return args, nil
+	}
+
+	if len(candidates) == 1 {
+		corrected := append([]string{candidates[0]}, args[1:]...)
+
+		switch {
+		case setting == "immediate":
+			a.warnCorrection(name, candidates[0])
+			return a.expandCorrected(corrected), nil
+		case setting == "prompt":
+			fmt.Fprintf(a.out, "WARNING: You called a vcs command named '%s', which does not exist.\n", name)
+			fmt.Fprintf(a.out, "Run '%s' instead [y/N]? ", candidates[0])
+			answer, _ := bufio.NewReader(a.in).ReadString('\n')
+			answer = strings.ToLower(strings.TrimSpace(answer))
+			if answer == "y" || answer == "yes" {
+				return a.expandCorrected(corrected), nil
+			}
+			return nil, fmt.Errorf("vcs: '%s' is not a vcs command. See 'vcs --help'", name)
+		default:
+			if delay, err := strconv.Atoi(setting); err == nil && delay != 0 {
+				a.warnCorrection(name, candidates[0])
+				if delay > 0 {
+					fmt.Fprintf(a.out, "in %.1f seconds automatically...\n", float64(delay)/10)
+					a.sleep(time.Duration(delay) * 100 * time.Millisecond)
+				}
+				return a.expandCorrected(corrected), nil
+			}
+		}
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "vcs: '%s' is not a vcs command. See 'vcs --help'.\n\n", name)
+	if len(candidates) == 1 {
+		b.WriteString("The most similar command is\n")
+	} else {
+		b.WriteString("The most similar commands are\n")
+	}
+	for _, candidate := range candidates {
+		fmt.Fprintf(&b, "\t%s\n", candidate)
+	}
+	return nil, fmt.Errorf("%s", strings.TrimSuffix(b.String(), "\n"))
+}
+
+// warnCorrection tells the user which command is being run instead
+func (a *autocorrector) warnCorrection(name, candidate string) {
+	fmt.Fprintf(a.out, "WARNING: You called a vcs command named '%s', which does not exist.\n", name)
+	fmt.Fprintf(a.out, "Continuing under the assumption that you meant '%s'.\n", candidate)
+}
+
+// expandCorrected expands the corrected command when it names an alias
+func (a *autocorrector) expandCorrected(args []string) []string {
+	if expanded, ok := a.expandAlias(args); ok {
+		return expanded
+	}
+	return args
+}
+
+// isCommand reports whether name is a subcommand or one of its aliases
+func (a *autocorrector) isCommand(name string) bool {
+	for _, c := range a.root.Commands() {
+		if c.Name() == name || c.HasAlias(name) {
+			return true
+		}
+	}
+	return name == "help" || name == "completion"
+}
+
+// expandAlias replaces args[0] with the value of alias.<name>. Shell aliases
+// (starting with "!") are not supported.
+func (a *autocorrector) expandAlias(args []string) ([]string, bool) {
+	value, ok := a.config["alias."+args[0]]
+	if !ok || value == "" || strings.HasPrefix(value, "!") {
+		return nil, false
+	}
+	return append(strings.Fields(value), args[1:]...), true
+}
+
+// similarCommands returns the commands and aliases closest to name
+func (a *autocorrector) similarCommands(name string) []string {
+	var candidates []string
+	for _, c := range a.root.Commands() {
+		if c.Hidden || !c.IsAvailableCommand() {
+			continue
+		}
+		candidates = append(candidates, c.Name())
+		candidates = append(candidates, c.Aliases...)
+	}
+	for key := range a.config {
+		if strings.HasPrefix(key, "alias.") {
+			candidates = append(candidates, strings.TrimPrefix(key, "alias."))
+		}
+	}
+
+	// Allow roughly one edit per three characters, and at least one
+	maxDistance := len(name)/3 + 1
+	best := maxDistance + 1
+	var matches []string
+	seen := make(map[string]bool)
+
+	for _, candidate := range candidates {
+		if seen[candidate] {
+			continue
+		}
+		seen[candidate] = true
+
+		distance := levenshtein(name, candidate)
+		switch {
+		case distance < best:
+			best = distance
+			matches = []string{candidate}
+		case distance == best:
+			matches = append(matches, candidate)
+		}
+	}
+
+	if best > maxDistance {
+		return nil
+	}
+	sort.Strings(matches)
+	return matches
+}
+
+// levenshtein returns the edit distance between a and b
+func levenshtein(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	curr := make([]int, len(rb)+1)
+
+	for j := range prev {
+		prev[j] = j
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		curr[0] = i
+		for j := 1; j <= len(rb); j++ {
+			cost := 1
+			if ra[i-1] == rb[j-1] {
+				cost = 0
+			}
+			curr[j] = minInt(prev[j]+1, minInt(curr[j-1]+1, prev[j-1]+cost))
+		}
+		prev, curr = curr, prev
+	}
+
+	return prev[len(rb)]
+}
+
+func minInt(a, b int) int {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+// loadCommandConfig reads the settings that affect command dispatch from the
+// global and repository config files. Repository values take precedence.
+func loadCommandConfig() map[string]string {
+	return loadConfigSections(currentGitDir(), "help", "alias")
+}
+
+// loadConfigSections reads the given sections from the system and global
+// config and, when gitDir is set, the repository config, which takes
+// precedence
+func loadConfigSections(gitDir string, sections ...string) map[string]string {
+	values := make(map[string]string)
+	addConfigSections(loadConfig(gitDir).Entries(), values, sections...)
+	return values
+}
+
+// parseConfigValues adds the help.* and alias.* entries in content to values
+func parseConfigValues(content string, values map[string]string) {
+	file, err := config.Parse("", []byte(content))
+	if err != nil {
+		return
+	}
+	addConfigSections(file.Entries(), values, "help", "alias")
+}
+
+// addConfigSections adds the entries of the given sections to values,
+// keyed as section.name
+func addConfigSections(entries []config.Entry, values map[string]string, sections ...string) {
+	wanted := make(map[string]bool, len(sections))
+	for _, section := range sections {
+		wanted[section] = true
+	}
+
+	for _, entry := range entries {
+		section, _, _ := strings.Cut(entry.Key, ".")
+		if wanted[section] {
+			values[entry.Key] = entry.Value
+		}
+	}
+}
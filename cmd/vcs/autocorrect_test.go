@@ -0,0 +1,142 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestAutocorrector(config map[string]string, input string) (*autocorrector, *bytes.Buffer, *[]time.Duration) {
+	root := &cobra.Command{Use: "vcs"}
+	for _, name := range []string{"commit", "status", "stash", "checkout", "log"} {
+		root.AddCommand(&cobra.Command{Use: name, Run: func(*cobra.Command, []string) {}})
+	}
+
+	var out bytes.Buffer
+	var slept []time.Duration
+	return &autocorrector{
+		root:   root,
+		config: config,
+		in:     strings.NewReader(input),
+		out:    &out,
+		sleep:  func(d time.Duration) { slept = append(slept, d) },
+	}, &out, &slept
+}
+
+func TestLevenshtein(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"", "", 0},
+		{"comit", "commit", 1},
+		{"sttaus", "status", 2},
+		{"log", "", 3},
+		{"kitten", "sitting", 3},
+	}
+
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, levenshtein(tt.a, tt.b), "%s -> %s", tt.a, tt.b)
+	}
+}
+
+func TestAutocorrectSuggestsByDefault(t *testing.T) {
+	a, _, _ := newTestAutocorrector(map[string]string{}, "")
+
+	_, err := a.resolve([]string{"comit", "-m", "msg"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "'comit' is not a vcs command")
+	assert.Contains(t, err.Error(), "The most similar command is\n\tcommit")
+
+	// Known commands, flags and hopeless typos pass through untouched
+	for _, args := range [][]string{{"status"}, {"--version"}, {"zzzzzzzz"}, {}} {
+		got, err := a.resolve(args)
+		require.NoError(t, err)
+		assert.Equal(t, args, got)
+	}
+}
+
+func TestAutocorrectListsAmbiguousMatches(t *testing.T) {
+	a, _, _ := newTestAutocorrector(map[string]string{"help.autocorrect": "immediate"}, "")
+
+	_, err := a.resolve([]string{"stat"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "The most similar commands are\n\tstash\n\tstatus")
+}
+
+func TestAutocorrectRunsClosestCommand(t *testing.T) {
+	a, out, slept := newTestAutocorrector(map[string]string{"help.autocorrect": "immediate"}, "")
+
+	got, err := a.resolve([]string{"comit", "-m", "msg"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"commit", "-m", "msg"}, got)
+	assert.Contains(t, out.String(), "you meant 'commit'")
+	assert.Empty(t, *slept)
+
+	a, _, slept = newTestAutocorrector(map[string]string{"help.autocorrect": "15"}, "")
+	got, err = a.resolve([]string{"chekout", "main"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"checkout", "main"}, got)
+	assert.Equal(t, []time.Duration{1500 * time.Millisecond}, *slept)
+}
+
+func TestAutocorrectPrompt(t *testing.T) {
+	a, _, _ := newTestAutocorrector(map[string]string{"help.autocorrect": "prompt"}, "y\n")
+	got, err := a.resolve([]string{"stauts"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"status"}, got)
+
+	a, _, _ = newTestAutocorrector(map[string]string{"help.autocorrect": "prompt"}, "n\n")
+	_, err = a.resolve([]string{"stauts"})
+	assert.Error(t, err)
+}
+
+func TestAutocorrectNever(t *testing.T) {
+	a, _, _ := newTestAutocorrector(map[string]string{"help.autocorrect": "never"}, "")
+
+	got, err := a.resolve([]string{"comit"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"comit"}, got)
+}
+
+func TestAutocorrectAliases(t *testing.T) {
+	config := map[string]string{
+		"help.autocorrect": "-1",
+		"alias.lg":         "log --oneline",
+		"alias.publish":    "!./deploy.sh",
+	}
+	a, _, _ := newTestAutocorrector(config, "")
+
+	got, err := a.resolve([]string{"lg", "-n", "3"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"log", "--oneline", "-n", "3"}, got)
+
+	// Aliases are correction candidates too
+	got, err = a.resolve([]string{"publsh"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"publish"}, got, "shell aliases are not expanded")
+}
+
+func TestParseConfigValues(t *testing.T) {
+	config := make(map[string]string)
+	parseConfigValues(`[core]
+	bare = false
+[help]
+	autocorrect = prompt
+# comment
+[alias]
+	co = checkout
+	LG = "log --oneline"
+`, config)
+
+	assert.Equal(t, map[string]string{
+		"help.autocorrect": "prompt",
+		"alias.co":         "checkout",
+		"alias.lg":         "log --oneline",
+	}, config)
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/config"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// mergeAutostashFile holds the autostash of a merge that stopped before
+// committing, for the commit concluding it to apply
+const mergeAutostashFile = "MERGE_AUTOSTASH"
+
+// addAutostashFlags adds --autostash and --no-autostash
+func addAutostashFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool("autostash", false, "Stash local changes before starting and apply them again afterwards")
+	cmd.Flags().Bool("no-autostash", false, "Do not stash local changes, overriding the configuration")
+}
+
+// autostashRequested reports whether to stash local changes around the
+// operation: as --autostash or --no-autostash say, or else as configKey
+// does
+func autostashRequested(cmd *cobra.Command, cfg *config.Config, configKey string) (bool, error) {
+	if off, _ := cmd.Flags().GetBool("no-autostash"); off {
+		return false, nil
+	}
+	if on, _ := cmd.Flags().GetBool("autostash"); on {
+		return true, nil
+	}
+	if value, ok := cfg.Get(configKey); ok {
+		autostash, err := config.ParseBool(value)
+		if err != nil {
+			return false, fmt.Errorf("invalid %s: %w", configKey, err)
+		}
+		return autostash, nil
+	}
+	return false, nil
+}
+
+// createAutostash stashes the changes of the index and of the tracked files
+// without adding them to the stash list, and resets both to HEAD. It
+// returns the stash commit, or a zero ID when there was nothing to stash.
+func createAutostash(out io.Writer, repo *vcs.Repository, refManager *refs.RefManager) (objects.ObjectID, error) {
+	id, _, err := saveStash(repo, refManager, nil, stashPushOptions{message: "autostash"}, false)
+	if err != nil {
+		return objects.ObjectID{}, fmt.Errorf("cannot autostash: %w", err)
+	}
+	if !id.IsZero() {
+		fmt.Fprintf(out, "Created autostash: %s\n", id.Short())
+	}
+	return id, nil
+}
+
+// applyAutostash applies the autostash id to the working tree. When it
+// cannot be applied cleanly it is added to the stash list instead, so the
+// changes are never lost; that is reported but not an error.
+func applyAutostash(out io.Writer, repo *vcs.Repository, refManager *refs.RefManager, id objects.ObjectID) error {
+	entry := &stashEntry{name: id.String(), position: -1}
+	err := loadStashEntry(repo, entry, id)
+	if err == nil {
+		if err = applyStash(out, repo, entry, false); err == nil {
+			fmt.Fprintln(out, "Applied autostash.")
+			return nil
+		}
+	}
+	if !errors.Is(err, errStashConflict) {
+		fmt.Fprintf(out, "error: %v\n", err)
+	}
+	if err := storeStash(refManager, id, "autostash"); err != nil {
+		return fmt.Errorf("cannot store the autostash %s: %w", id, err)
+	}
+	fmt.Fprint(out, "Applying autostash resulted in conflicts.\n"+
+		"Your changes are safe in the stash.\n"+
+		"You can run \"vcs stash pop\" or \"vcs stash drop\" at any time.\n")
+	return nil
+}
+
+// finishMergeAutostash applies the autostash id once a merge is done. A
+// merge left for a commit to conclude keeps it in MERGE_AUTOSTASH until
+// then.
+func finishMergeAutostash(out io.Writer, repo *vcs.Repository, refManager *refs.RefManager, id objects.ObjectID) error {
+	if _, ok, err := readMergeHead(repo); err != nil {
+		return err
+	} else if ok {
+		path := filepath.Join(repo.GitDir(), mergeAutostashFile)
+		if err := os.WriteFile(path, []byte(id.String()+"\n"), 0644); err != nil {
+			// Rather than losing the changes, list them
+			if err := storeStash(refManager, id, "autostash"); err != nil {
+				return fmt.Errorf("cannot store the autostash %s: %w", id, err)
+			}
+			return fmt.Errorf("failed to write %s: %w", mergeAutostashFile, err)
+		}
+		return nil
+	}
+	return applyAutostash(out, repo, refManager, id)
+}
+
+// applyMergeAutostash applies the autostash a concluded merge left in
+// MERGE_AUTOSTASH, if any
+func applyMergeAutostash(out io.Writer, repo *vcs.Repository, refManager *refs.RefManager) error {
+	path := filepath.Join(repo.GitDir(), mergeAutostashFile)
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	} else if err != nil {
+		return fmt.Errorf("failed to read %s: %w", mergeAutostashFile, err)
+	}
+	id, err := objects.NewObjectID(strings.TrimSpace(string(data)))
+	if err != nil {
+		return fmt.Errorf("invalid %s: %w", mergeAutostashFile, err)
+	}
+	if err := os.Remove(path); err != nil {
+		return fmt.Errorf("failed to remove %s: %w", mergeAutostashFile, err)
+	}
+	return applyAutostash(out, repo, refManager, id)
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRebaseAutostash(t *testing.T) {
+	f := setupRebaseRepo(t,
+		map[string]string{"a.txt": "base\n"},
+		map[string]string{"a.txt": "base\n", "main.txt": "main\n"},
+		map[string]string{"a.txt": "base\n", "t.txt": "t\n"},
+	)
+	require.NoError(t, resetIndexToHead(f.repo))
+	stageFile(t, "a.txt", "staged\n")
+
+	_, _, err := runRebaseArgs("main")
+	assert.ErrorContains(t, err, "cannot rebase: your index contains uncommitted changes")
+
+	out, _, err := runRebaseArgs("--autostash", "main")
+	require.NoError(t, err)
+	assert.Regexp(t, `^Created autostash: [0-9a-f]{7}\n`, out)
+	assert.Contains(t, out, "Applied autostash.\nSuccessfully rebased and updated refs/heads/topic.\n")
+	assert.Equal(t, "staged\n", f.readFile(t, "a.txt"))
+	assert.Equal(t, "main\n", f.readFile(t, "main.txt"))
+	assert.False(t, f.refs.RefExists("refs/stash"))
+
+	// rebase.autoStash stashes without the flag, and --no-autostash
+	// overrides it. A rebase with nothing to do applies the stash at once.
+	stageFile(t, "a.txt", "staged again\n")
+	_, err = runConfigArgs("rebase.autoStash", "true")
+	require.NoError(t, err)
+	_, _, err = runRebaseArgs("--no-autostash", "main")
+	assert.ErrorContains(t, err, "cannot rebase: your index contains uncommitted changes")
+	out, _, err = runRebaseArgs("main")
+	require.NoError(t, err)
+	assert.Contains(t, out, "Current branch topic is up to date.\nApplied autostash.\n")
+	assert.Equal(t, "staged again\n", f.readFile(t, "a.txt"))
+}
+
+func TestRebaseAutostashConflict(t *testing.T) {
+	f := setupRebaseRepo(t,
+		map[string]string{"a.txt": "base\n"},
+		map[string]string{"a.txt": "main\n"},
+		map[string]string{"a.txt": "base\n", "t.txt": "t\n"},
+	)
+	require.NoError(t, resetIndexToHead(f.repo))
+	require.NoError(t, os.WriteFile("a.txt", []byte("local\n"), 0644))
+
+	out, _, err := runRebaseArgs("--autostash", "main")
+	require.NoError(t, err)
+	assert.Contains(t, out, "CONFLICT (content): Merge conflict in a.txt\n")
+	assert.Contains(t, out, "Applying autostash resulted in conflicts.\nYour changes are safe in the stash.\n")
+	assert.Contains(t, f.readFile(t, "a.txt"), "<<<<<<< Updated upstream\nmain\n=======\nlocal\n>>>>>>> Stashed changes\n")
+
+	out, err = runStash("list")
+	require.NoError(t, err)
+	assert.Equal(t, "stash@{0}: autostash\n", out)
+}
+
+func TestRebaseAutostashAbort(t *testing.T) {
+	f := setupRebaseRepo(t,
+		map[string]string{"a.txt": "base\n", "b.txt": "b\n"},
+		map[string]string{"a.txt": "main\n", "b.txt": "b\n"},
+		map[string]string{"a.txt": "topic\n", "b.txt": "b\n"},
+	)
+	require.NoError(t, resetIndexToHead(f.repo))
+	require.NoError(t, os.WriteFile("b.txt", []byte("local\n"), 0644))
+
+	_, _, err := runRebaseArgs("--autostash", "main")
+	require.ErrorIs(t, err, errRebaseConflict)
+	assert.Equal(t, "b\n", f.readFile(t, "b.txt"))
+
+	out, _, err := runRebaseArgs("--abort")
+	require.NoError(t, err)
+	assert.Contains(t, out, "Applied autostash.\n")
+	assert.Equal(t, "topic\n", f.readFile(t, "a.txt"))
+	assert.Equal(t, "local\n", f.readFile(t, "b.txt"))
+}
+
+func TestMergeAutostash(t *testing.T) {
+	repo := setupMergeRepo(t,
+		map[string]string{"a.txt": "base\n", "b.txt": "b\n"},
+		map[string]string{"a.txt": "ours\n", "b.txt": "b\n"},
+		map[string]string{"a.txt": "theirs\n", "b.txt": "b\n"},
+	)
+	require.NoError(t, resetIndexToHead(repo))
+	require.NoError(t, os.WriteFile("b.txt", []byte("local\n"), 0644))
+
+	// A merge stopping on conflicts keeps the changes until it is committed
+	_, err := runConfigArgs("merge.autoStash", "true")
+	require.NoError(t, err)
+	out, _, err := runMergeArgs("topic")
+	require.ErrorIs(t, err, errMergeConflict)
+	assert.Regexp(t, `^Created autostash: [0-9a-f]{7}\n`, out)
+	assert.Equal(t, "b\n", readWorkFile(t, "b.txt"))
+	assert.FileExists(t, filepath.Join(repo.GitDir(), "MERGE_AUTOSTASH"))
+
+	stageFile(t, "a.txt", "resolved\n")
+	commitCmd := newCommitCommand()
+	var commitOut bytes.Buffer
+	commitCmd.SetOut(&commitOut)
+	commitCmd.SetArgs([]string{"-m", "merge topic"})
+	require.NoError(t, commitCmd.Execute())
+	assert.Contains(t, commitOut.String(), "Applied autostash.\n")
+	assert.Equal(t, "local\n", readWorkFile(t, "b.txt"))
+	assert.NoFileExists(t, filepath.Join(repo.GitDir(), "MERGE_AUTOSTASH"))
+}
@@ -0,0 +1,588 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/bits"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// errBisectSkipped is returned when only skipped commits are left to test
+var errBisectSkipped = errors.New("we cannot bisect more; only skipped commits are left")
+
+// bisectFiles are the files in the git directory that hold a bisection,
+// removed by bisect reset
+var bisectFiles = []string{"BISECT_START", "BISECT_TERMS", "BISECT_LOG", "BISECT_EXPECTED_REV"}
+
+func newBisectCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "bisect",
+		Short: "Use binary search to find the commit that introduced a bug",
+		Long: `Finds the commit that introduced a change by binary search. Start with
+"vcs bisect start", mark a commit that has the change with "vcs bisect bad"
+and one that does not with "vcs bisect good". Each time both are known a
+commit halfway between them is checked out on a detached HEAD to be tested
+and marked in turn, until the first bad commit is found. "vcs bisect skip"
+marks a commit that cannot be tested, and "vcs bisect reset" ends the
+bisection and returns to the branch it started on.
+
+"vcs bisect run <cmd>" tests each commit with a command instead: exit code
+0 marks it good, 125 skips it and any other code below 128 marks it bad.
+
+The state is kept as Git keeps it, in .git/BISECT_* and refs/bisect.`,
+	}
+
+	start := &cobra.Command{
+		Use:   "start [<bad> [<good>...]]",
+		Short: "Start bisecting",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runBisect(cmd, func(out io.Writer, repo *vcs.Repository, refManager *refs.RefManager) error {
+				return startBisect(out, repo, refManager, args)
+			})
+		},
+	}
+
+	mark := func(term string) *cobra.Command {
+		use := term + " [<rev>...]"
+		if term == "bad" {
+			use = "bad [<rev>]"
+		}
+		return &cobra.Command{
+			Use:   use,
+			Short: fmt.Sprintf("Mark commits as %s, HEAD by default", term),
+			Args: func(cmd *cobra.Command, args []string) error {
+				if term == "bad" && len(args) > 1 {
+					return fmt.Errorf("only one bad commit can be given")
+				}
+				return nil
+			},
+			RunE: func(cmd *cobra.Command, args []string) error {
+				return runBisect(cmd, func(out io.Writer, repo *vcs.Repository, refManager *refs.RefManager) error {
+					state, err := loadBisectState(repo, refManager)
+					if err != nil {
+						return err
+					}
+					if len(args) == 0 {
+						args = []string{"HEAD"}
+					}
+					for _, arg := range args {
+						id, err := resolveCommitish(repo, arg)
+						if err != nil {
+							return err
+						}
+						if err := state.mark(term, id); err != nil {
+							return err
+						}
+					}
+					_, err = state.next(out)
+					return err
+				})
+			},
+		}
+	}
+
+	reset := &cobra.Command{
+		Use:   "reset [<commit>]",
+		Short: "End bisecting and check out the original branch or <commit>",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runBisect(cmd, func(out io.Writer, repo *vcs.Repository, refManager *refs.RefManager) error {
+				return resetBisect(out, repo, refManager, args)
+			})
+		},
+	}
+
+	run := &cobra.Command{
+		Use:   "run <cmd> [<arg>...]",
+		Short: "Bisect automatically by running a command on each commit",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runBisect(cmd, func(out io.Writer, repo *vcs.Repository, refManager *refs.RefManager) error {
+				return runBisectCommand(out, cmd.ErrOrStderr(), repo, refManager, args)
+			})
+		},
+	}
+	// Flags after the command belong to it
+	run.Flags().SetInterspersed(false)
+
+	cmd.AddCommand(start, mark("bad"), mark("good"), mark("skip"), reset, run)
+	return cmd
+}
+
+// runBisect opens the repository and runs fn
+func runBisect(cmd *cobra.Command, fn func(out io.Writer, repo *vcs.Repository, refManager *refs.RefManager) error) error {
+	repoPath, err := findRepository()
+	if err != nil {
+		return fmt.Errorf("not a git repository: %w", err)
+	}
+	repo, err := openRepository(repoPath)
+	if err != nil {
+		return err
+	}
+	cmd.SilenceUsage = true
+	return fn(cmd.OutOrStdout(), repo, refs.NewRefManager(repo.GitDir()))
+}
+
+// bisectState is a bisection in progress: the commits marked so far, kept
+// in refs/bisect, and where it started, kept in BISECT_START
+type bisectState struct {
+	repo *vcs.Repository
+	refs *refs.RefManager
+	// start is the branch HEAD was on when bisecting started, or the
+	// commit it pointed to when detached
+	start string
+	bad   objects.ObjectID
+	good  []objects.ObjectID
+	skip  []objects.ObjectID
+}
+
+func bisectPath(repo *vcs.Repository, name string) string {
+	return filepath.Join(repo.GitDir(), name)
+}
+
+// loadBisectState reads the bisection in progress
+func loadBisectState(repo *vcs.Repository, refManager *refs.RefManager) (*bisectState, error) {
+	data, err := os.ReadFile(bisectPath(repo, "BISECT_START"))
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf(`not bisecting; start with "vcs bisect start"`)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bisect state: %w", err)
+	}
+	state := &bisectState{repo: repo, refs: refManager, start: strings.TrimSpace(string(data))}
+
+	entries, err := os.ReadDir(filepath.Join(repo.GitDir(), "refs", "bisect"))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read bisect refs: %w", err)
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		id, err := refManager.ResolveRef("refs/bisect/" + name)
+		if err != nil {
+			return nil, fmt.Errorf("invalid bisect ref %s: %w", name, err)
+		}
+		switch {
+		case name == "bad":
+			state.bad = id
+		case strings.HasPrefix(name, "good-"):
+			state.good = append(state.good, id)
+		case strings.HasPrefix(name, "skip-"):
+			state.skip = append(state.skip, id)
+		}
+	}
+	return state, nil
+}
+
+// log appends lines to BISECT_LOG
+func (s *bisectState) log(lines ...string) error {
+	f, err := os.OpenFile(bisectPath(s.repo, "BISECT_LOG"), os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to write bisect log: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(strings.Join(lines, "\n") + "\n"); err != nil {
+		return fmt.Errorf("failed to write bisect log: %w", err)
+	}
+	return nil
+}
+
+// mark records commit id as bad, good or skipped
+func (s *bisectState) mark(term string, id objects.ObjectID) error {
+	commit, err := s.repo.GetCommit(id)
+	if err != nil {
+		return fmt.Errorf("failed to read commit %s: %w", id.Short(), err)
+	}
+
+	refName := "refs/bisect/" + term
+	switch term {
+	case "bad":
+		s.bad = id
+	case "good":
+		refName += "-" + id.String()
+		s.good = append(s.good, id)
+	default:
+		refName += "-" + id.String()
+		s.skip = append(s.skip, id)
+	}
+	if err := s.refs.UpdateRef(refName, id); err != nil {
+		return fmt.Errorf("failed to update %s: %w", refName, err)
+	}
+	return s.log(
+		fmt.Sprintf("# %s: [%s] %s", term, id, commitSubject(commit)),
+		fmt.Sprintf("git bisect %s %s", term, id),
+	)
+}
+
+// next checks out the commit to test next, or reports the first bad commit
+// and returns true once it is known
+func (s *bisectState) next(out io.Writer) (bool, error) {
+	switch {
+	case s.bad.IsZero() && len(s.good) == 0:
+		fmt.Fprintln(out, "status: waiting for both good and bad commits")
+		return false, nil
+	case s.bad.IsZero():
+		fmt.Fprintf(out, "status: waiting for bad commit, %d good commit%s known\n", len(s.good), plural(len(s.good)))
+		return false, nil
+	case len(s.good) == 0:
+		fmt.Fprintln(out, "status: waiting for good commit(s), bad commit known")
+		return false, nil
+	}
+
+	candidates, err := bisectCandidates(s.repo, s.bad, s.good)
+	if err != nil {
+		return false, err
+	}
+	if len(candidates) == 0 {
+		return false, fmt.Errorf("the bad commit %s is an ancestor of a good commit", s.bad.Short())
+	}
+
+	skipped := make(map[objects.ObjectID]bool)
+	for _, id := range s.skip {
+		skipped[id] = true
+	}
+	weights := bisectWeights(candidates)
+	all := len(candidates)
+	best, bestDistance := -1, 0
+	for i, commit := range candidates {
+		if commit.ID() == s.bad || skipped[commit.ID()] {
+			continue
+		}
+		distance := weights[i]
+		if all-weights[i] < distance {
+			distance = all - weights[i]
+		}
+		if distance > bestDistance {
+			best, bestDistance = i, distance
+		}
+	}
+
+	if all == 1 {
+		commit := candidates[0]
+		fmt.Fprintf(out, "%s is the first bad commit\n", commit.ID())
+		fmt.Fprintf(out, "commit %s\n", commit.ID())
+		fmt.Fprintf(out, "Author: %s <%s>\n", commit.Author().Name, commit.Author().Email)
+		fmt.Fprintf(out, "Date:   %s\n\n", formatDate(commit.Author().When))
+		for _, line := range strings.Split(strings.TrimSpace(commit.Message()), "\n") {
+			fmt.Fprintf(out, "    %s\n", line)
+		}
+		return true, s.log(fmt.Sprintf("# first bad commit: [%s] %s", commit.ID(), commitSubject(commit)))
+	}
+	if best < 0 {
+		fmt.Fprintln(out, "There are only 'skip'ped commits left to test.")
+		fmt.Fprintln(out, "The first bad commit could be any of:")
+		for _, commit := range candidates {
+			fmt.Fprintln(out, commit.ID())
+		}
+		return false, errBisectSkipped
+	}
+
+	commit := candidates[best]
+	left := all - weights[best] - 1
+	steps := bisectSteps(all)
+	fmt.Fprintf(out, "Bisecting: %d revision%s left to test after this (roughly %d step%s)\n", left, plural(left), steps, plural(steps))
+	fmt.Fprintf(out, "[%s] %s\n", commit.ID(), commitSubject(commit))
+	return false, s.checkout(commit.ID())
+}
+
+// checkout detaches HEAD at the commit to test
+func (s *bisectState) checkout(id objects.ObjectID) error {
+	headID, _, err := s.refs.HEAD()
+	if err != nil {
+		return fmt.Errorf("failed to read HEAD: %w", err)
+	}
+	if err := checkoutTree(s.repo, headID, id); err != nil {
+		return err
+	}
+	if err := s.refs.SetHEADToCommit(id); err != nil {
+		return fmt.Errorf("failed to update HEAD: %w", err)
+	}
+	if err := os.WriteFile(bisectPath(s.repo, "BISECT_EXPECTED_REV"), []byte(id.String()+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write bisect state: %w", err)
+	}
+	return nil
+}
+
+// bisectCandidates returns the commits reachable from bad but not from any
+// good commit, newest first. The first bad commit is among them.
+func bisectCandidates(repo *vcs.Repository, bad objects.ObjectID, good []objects.ObjectID) ([]*objects.Commit, error) {
+	excluded, err := newResolver(repo).Reachable(good)
+	if err != nil {
+		return nil, err
+	}
+
+	var candidates []*objects.Commit
+	seen := make(map[objects.ObjectID]bool)
+	queue := []objects.ObjectID{bad}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		if excluded[id] || seen[id] {
+			continue
+		}
+		seen[id] = true
+		commit, err := repo.GetCommit(id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read commit %s: %w", id.Short(), err)
+		}
+		candidates = append(candidates, commit)
+		queue = append(queue, commit.Parents()...)
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return candidates[i].Committer().When.After(candidates[j].Committer().When)
+	})
+	return candidates, nil
+}
+
+// bisectWeights returns, for each candidate, how many candidates it can
+// reach, itself included. Testing the one whose weight is nearest half
+// the candidates halves what is left whatever the result.
+func bisectWeights(candidates []*objects.Commit) []int {
+	position := make(map[objects.ObjectID]int, len(candidates))
+	for i, commit := range candidates {
+		position[commit.ID()] = i
+	}
+
+	weights := make([]int, len(candidates))
+	for i := range candidates {
+		seen := make([]bool, len(candidates))
+		stack := []int{i}
+		for len(stack) > 0 {
+			j := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if seen[j] {
+				continue
+			}
+			seen[j] = true
+			weights[i]++
+			for _, parent := range candidates[j].Parents() {
+				if k, ok := position[parent]; ok {
+					stack = append(stack, k)
+				}
+			}
+		}
+	}
+	return weights
+}
+
+// bisectSteps estimates the number of tests left among all candidates, as
+// Git does
+func bisectSteps(all int) int {
+	if all < 3 {
+		return 0
+	}
+	n := bits.Len(uint(all)) - 1
+	e := 1 << n
+	if e < 3*(all-e) {
+		return n
+	}
+	return n - 1
+}
+
+func plural(n int) string {
+	if n == 1 {
+		return ""
+	}
+	return "s"
+}
+
+// startBisect starts a new bisection, or restarts the one in progress,
+// marking bad and good commits when given
+func startBisect(out io.Writer, repo *vcs.Repository, refManager *refs.RefManager, args []string) error {
+	if dirty, err := hasUncommittedChanges(repo, refManager); err != nil {
+		return err
+	} else if dirty {
+		return fmt.Errorf("cannot bisect: your index contains uncommitted changes")
+	}
+
+	var marks []objects.ObjectID
+	for _, arg := range args {
+		id, err := resolveCommitish(repo, arg)
+		if err != nil {
+			return err
+		}
+		marks = append(marks, id)
+	}
+
+	start := ""
+	if previous, err := loadBisectState(repo, refManager); err == nil {
+		// Restarting keeps the branch to return to
+		start = previous.start
+		if err := clearBisectState(repo, refManager); err != nil {
+			return err
+		}
+	}
+	if start == "" {
+		headID, headName, err := refManager.HEAD()
+		if err != nil {
+			return fmt.Errorf("failed to read HEAD: %w", err)
+		}
+		start = strings.TrimPrefix(headName, "refs/heads/")
+		if headName == "" {
+			start = headID.String()
+		}
+	}
+
+	files := map[string]string{
+		"BISECT_START": start + "\n",
+		"BISECT_TERMS": "bad\ngood\n",
+		"BISECT_LOG":   "git bisect start\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(bisectPath(repo, name), []byte(content), 0644); err != nil {
+			return fmt.Errorf("failed to write bisect state: %w", err)
+		}
+	}
+
+	state := &bisectState{repo: repo, refs: refManager, start: start}
+	for i, id := range marks {
+		term := "good"
+		if i == 0 {
+			term = "bad"
+		}
+		if err := state.mark(term, id); err != nil {
+			return err
+		}
+	}
+	_, err := state.next(out)
+	return err
+}
+
+// resetBisect ends the bisection, checking out target or else where it
+// started
+func resetBisect(out io.Writer, repo *vcs.Repository, refManager *refs.RefManager, args []string) error {
+	state, err := loadBisectState(repo, refManager)
+	if err != nil {
+		fmt.Fprintln(out, "We are not bisecting.")
+		return nil
+	}
+
+	headID, _, err := refManager.HEAD()
+	if err != nil {
+		return fmt.Errorf("failed to read HEAD: %w", err)
+	}
+	branch := ""
+	var target objects.ObjectID
+	if len(args) > 0 {
+		if target, err = resolveCommitish(repo, args[0]); err != nil {
+			return err
+		}
+	} else if id, err := refManager.ResolveRef("refs/heads/" + state.start); err == nil {
+		branch, target = "refs/heads/"+state.start, id
+	} else if target, err = objects.NewObjectID(state.start); err != nil {
+		return fmt.Errorf("cannot return to '%s': it is neither a branch nor a commit", state.start)
+	}
+
+	if err := checkoutTree(repo, headID, target); err != nil {
+		return err
+	}
+	if branch != "" {
+		err = refManager.SetHEAD(branch)
+	} else {
+		err = refManager.SetHEADToCommit(target)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update HEAD: %w", err)
+	}
+	if err := clearBisectState(repo, refManager); err != nil {
+		return err
+	}
+
+	if branch != "" {
+		fmt.Fprintf(out, "Switched to branch '%s'\n", state.start)
+	} else {
+		fmt.Fprintf(out, "HEAD is now at %s\n", target.Short())
+	}
+	return nil
+}
+
+// clearBisectState removes the bisect files and refs
+func clearBisectState(repo *vcs.Repository, refManager *refs.RefManager) error {
+	entries, err := os.ReadDir(filepath.Join(repo.GitDir(), "refs", "bisect"))
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read bisect refs: %w", err)
+	}
+	for _, entry := range entries {
+		if err := refManager.DeleteRef("refs/bisect/" + entry.Name()); err != nil {
+			return err
+		}
+	}
+	os.Remove(filepath.Join(repo.GitDir(), "refs", "bisect"))
+
+	for _, name := range bisectFiles {
+		if err := os.Remove(bisectPath(repo, name)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", name, err)
+		}
+	}
+	return nil
+}
+
+// runBisectCommand tests each commit the bisection checks out with args,
+// marking it by the exit code, until the first bad commit is found
+func runBisectCommand(out, errOut io.Writer, repo *vcs.Repository, refManager *refs.RefManager, args []string) error {
+	state, err := loadBisectState(repo, refManager)
+	if err != nil {
+		return err
+	}
+	if state.bad.IsZero() || len(state.good) == 0 {
+		return fmt.Errorf("bisect run needs a good and a bad commit first")
+	}
+
+	command := strings.Join(args, " ")
+	for {
+		fmt.Fprintf(out, "running %s\n", command)
+		test := exec.Command(args[0], args[1:]...)
+		test.Dir = repo.WorkDir()
+		test.Stdin = os.Stdin
+		test.Stdout = out
+		test.Stderr = errOut
+
+		code := 0
+		if err := test.Run(); err != nil {
+			var exitErr *exec.ExitError
+			if !errors.As(err, &exitErr) {
+				return fmt.Errorf("failed to run '%s': %w", command, err)
+			}
+			code = exitErr.ExitCode()
+		}
+
+		var term string
+		switch {
+		case code < 0 || code >= 128:
+			return fmt.Errorf("bisect run failed: exit code %d from '%s' is < 0 or >= 128", code, command)
+		case code == 0:
+			term = "good"
+		case code == 125:
+			term = "skip"
+		default:
+			term = "bad"
+		}
+
+		headID, _, err := refManager.HEAD()
+		if err != nil {
+			return fmt.Errorf("failed to read HEAD: %w", err)
+		}
+		if err := state.mark(term, headID); err != nil {
+			return err
+		}
+		done, err := state.next(out)
+		if err != nil {
+			return err
+		}
+		if done {
+			fmt.Fprintln(out, "bisect found first bad commit")
+			return nil
+		}
+	}
+}
@@ -0,0 +1,140 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// setupBisectRepo creates a line of n commits on main, commit i holding
+// n.txt with i, and checks out its tip
+func setupBisectRepo(t *testing.T, n int) (*vcs.Repository, *refs.RefManager, []objects.ObjectID) {
+	repoPath := t.TempDir()
+	repo, err := vcs.Init(repoPath)
+	require.NoError(t, err)
+
+	var ids []objects.ObjectID
+	var parents []objects.ObjectID
+	for i := 0; i < n; i++ {
+		id := commitFiles(t, repo, map[string]string{"n.txt": fmt.Sprintln(i)}, parents, fmt.Sprintf("commit %d\n", i))
+		ids = append(ids, id)
+		parents = []objects.ObjectID{id}
+	}
+	refManager := refs.NewRefManager(repo.GitDir())
+	require.NoError(t, refManager.UpdateRef("refs/heads/main", ids[n-1]))
+	require.NoError(t, refManager.SetHEAD("refs/heads/main"))
+	require.NoError(t, os.WriteFile(filepath.Join(repoPath, "n.txt"), []byte(fmt.Sprintln(n-1)), 0644))
+
+	oldWd, _ := os.Getwd()
+	t.Cleanup(func() { os.Chdir(oldWd) })
+	require.NoError(t, os.Chdir(repoPath))
+	return repo, refManager, ids
+}
+
+func runBisectArgs(args ...string) (string, error) {
+	cmd := newBisectCommand()
+	var stdout bytes.Buffer
+	cmd.SetOut(&stdout)
+	cmd.SetErr(&stdout)
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+	return stdout.String(), err
+}
+
+func TestBisectManual(t *testing.T) {
+	repo, refManager, ids := setupBisectRepo(t, 8)
+
+	out, err := runBisectArgs("start")
+	require.NoError(t, err)
+	assert.Contains(t, out, "waiting for both good and bad commits")
+	assert.FileExists(t, filepath.Join(repo.GitDir(), "BISECT_START"))
+
+	_, err = runBisectArgs("bad")
+	require.NoError(t, err)
+	out, err = runBisectArgs("good", ids[0].String())
+	require.NoError(t, err)
+	assert.Contains(t, out, "Bisecting: 2 revisions left to test after this (roughly 2 steps)")
+
+	// The first bad commit is commit 5
+	for {
+		head, name, err := refManager.HEAD()
+		require.NoError(t, err)
+		assert.Empty(t, name)
+		data, err := os.ReadFile("n.txt")
+		require.NoError(t, err)
+
+		var n int
+		fmt.Sscan(string(data), &n)
+		assert.Equal(t, ids[n], head)
+		term := "good"
+		if n >= 5 {
+			term = "bad"
+		}
+		out, err = runBisectArgs(term)
+		require.NoError(t, err)
+		if bytes.Contains([]byte(out), []byte("is the first bad commit")) {
+			break
+		}
+	}
+	assert.Contains(t, out, ids[5].String()+" is the first bad commit")
+	assert.Contains(t, out, "    commit 5")
+
+	out, err = runBisectArgs("reset")
+	require.NoError(t, err)
+	assert.Contains(t, out, "Switched to branch 'main'")
+	head, err := refManager.SymbolicHEAD()
+	require.NoError(t, err)
+	assert.Equal(t, "refs/heads/main", head)
+	data, err := os.ReadFile("n.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "7\n", string(data))
+	assert.NoFileExists(t, filepath.Join(repo.GitDir(), "BISECT_START"))
+	assert.NoDirExists(t, filepath.Join(repo.GitDir(), "refs", "bisect"))
+
+	out, err = runBisectArgs("reset")
+	require.NoError(t, err)
+	assert.Contains(t, out, "We are not bisecting.")
+}
+
+func TestBisectRun(t *testing.T) {
+	_, refManager, ids := setupBisectRepo(t, 20)
+
+	_, err := runBisectArgs("start", "HEAD", ids[0].String())
+	require.NoError(t, err)
+	out, err := runBisectArgs("run", "sh", "-c", `test "$(cat n.txt)" -lt 13`)
+	require.NoError(t, err)
+	assert.Contains(t, out, ids[13].String()+" is the first bad commit")
+	assert.Contains(t, out, "bisect found first bad commit")
+
+	log, err := os.ReadFile(filepath.Join(refManager.GitDir(), "BISECT_LOG"))
+	require.NoError(t, err)
+	assert.Contains(t, string(log), "# first bad commit: ["+ids[13].String()+"] commit 13")
+
+	_, err = runBisectArgs("reset")
+	require.NoError(t, err)
+
+	// 125 skips the commit; with every commit skipped no answer is possible
+	_, err = runBisectArgs("start", "HEAD", ids[16].String())
+	require.NoError(t, err)
+	out, err = runBisectArgs("run", "sh", "-c", "exit 125")
+	assert.ErrorIs(t, err, errBisectSkipped)
+	assert.Contains(t, out, "There are only 'skip'ped commits left to test.")
+
+	_, err = runBisectArgs("run", "sh", "-c", "exit 200")
+	assert.ErrorContains(t, err, "exit code 200")
+}
+
+func TestBisectSteps(t *testing.T) {
+	assert.Equal(t, 0, bisectSteps(2))
+	assert.Equal(t, 2, bisectSteps(10))
+	assert.Equal(t, 3, bisectSteps(20))
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/fenilsonani/vcs/internal/core/history"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/spf13/cobra"
+)
+
+func newBlameCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "blame <file>",
+		Short: "Show what revision and author last modified each line of a file",
+		Long: `Annotates each line of the file as of HEAD with the commit that last changed
+it, following the file across renames. Lines marked with ^ reach the start of
+the available history, such as a root or shallow commit.`,
+		Args: cobra.ExactArgs(1),
+		RunE: runBlame,
+	}
+
+	return cmd
+}
+
+func runBlame(cmd *cobra.Command, args []string) error {
+	repoPath, err := findRepository()
+	if err != nil {
+		return fmt.Errorf("not a git repository: %w", err)
+	}
+	repo, err := openRepository(repoPath)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	path, err := repoRelativePath(repoPath, args[0])
+	if err != nil {
+		return err
+	}
+
+	head, _, err := refs.NewRefManager(repo.GitDir()).HEAD()
+	if err != nil {
+		return fmt.Errorf("failed to get HEAD: %w", err)
+	}
+	if head.IsZero() {
+		return fmt.Errorf("no commits yet")
+	}
+
+	shallowCommits, err := repo.ShallowCommits()
+	if err != nil {
+		return err
+	}
+	shallow := make(map[objects.ObjectID]bool)
+	for _, id := range shallowCommits {
+		shallow[id] = true
+	}
+
+	lines, err := history.Blame(repo, head, path, history.BlameOptions{
+		RenameThreshold: history.DefaultRenameThreshold,
+		Boundary:        func(id objects.ObjectID) bool { return shallow[id] },
+	})
+	if err != nil {
+		return err
+	}
+
+	commits := make(map[objects.ObjectID]*objects.Commit)
+	nameWidth, pathWidth := 0, 0
+	for _, line := range lines {
+		if _, ok := commits[line.Commit]; !ok {
+			commit, err := history.ReadCommit(repo, line.Commit)
+			if err != nil {
+				return err
+			}
+			commits[line.Commit] = commit
+		}
+		if n := len(commits[line.Commit].Author().Name); n > nameWidth {
+			nameWidth = n
+		}
+		// Git only shows paths when some lines come from another name
+		if line.Path != path && len(line.Path) > pathWidth {
+			pathWidth = len(line.Path)
+		}
+	}
+	if pathWidth > 0 && len(path) > pathWidth {
+		pathWidth = len(path)
+	}
+	numberWidth := len(fmt.Sprint(len(lines)))
+
+	out := cmd.OutOrStdout()
+	for i, line := range lines {
+		commit := commits[line.Commit]
+		id := line.Commit.String()[:8]
+		if line.Boundary {
+			id = "^" + id[:7]
+		}
+		if pathWidth > 0 {
+			id += fmt.Sprintf(" %-*s", pathWidth, line.Path)
+		}
+		fmt.Fprintf(out, "%s (%-*s %s %*d) %s\n",
+			id, nameWidth, commit.Author().Name,
+			commit.Author().When.Format("2006-01-02 15:04:05 -0700"),
+			numberWidth, i+1, strings.TrimSuffix(line.Text, "\n"))
+	}
+
+	return nil
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// setupRenameRepo creates a repository where old.txt is edited, renamed to
+// new.txt and edited again, with an unrelated commit on top, and changes
+// into it. It returns the commits oldest first.
+func setupRenameRepo(t *testing.T) (*vcs.Repository, []objects.ObjectID) {
+	repo, _ := setupConfigRepo(t)
+
+	body := "one\ntwo\nthree\nfour\nfive\n"
+	first := commitFiles(t, repo, map[string]string{"old.txt": body, "other.txt": "other\n"}, nil, "add old\n")
+	second := commitFiles(t, repo, map[string]string{"old.txt": strings.Replace(body, "two", "TWO", 1), "other.txt": "other\n"},
+		[]objects.ObjectID{first}, "edit old\n")
+	third := commitFiles(t, repo, map[string]string{"new.txt": strings.Replace(body, "two", "TWO", 1) + "six\n", "other.txt": "other\n"},
+		[]objects.ObjectID{second}, "rename old to new\n")
+	fourth := commitFiles(t, repo, map[string]string{"new.txt": strings.Replace(body, "two", "TWO", 1) + "six\n", "other.txt": "changed\n"},
+		[]objects.ObjectID{third}, "edit other\n")
+
+	refManager := refs.NewRefManager(repo.GitDir())
+	require.NoError(t, refManager.UpdateRef("refs/heads/main", fourth))
+	require.NoError(t, refManager.SetHEAD("refs/heads/main"))
+	return repo, []objects.ObjectID{first, second, third, fourth}
+}
+
+func runBlameArgs(args ...string) (string, error) {
+	cmd := newBlameCommand()
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+	return out.String(), err
+}
+
+func TestBlameFollowsRenames(t *testing.T) {
+	_, commits := setupRenameRepo(t)
+
+	out, err := runBlameArgs("new.txt")
+	require.NoError(t, err)
+
+	lines := strings.Split(strings.TrimSuffix(out, "\n"), "\n")
+	require.Len(t, lines, 6)
+	assert.True(t, strings.HasPrefix(lines[0], "^"+commits[0].String()[:7]+" old.txt (Test "), lines[0])
+	assert.True(t, strings.HasSuffix(lines[0], " 1) one"), lines[0])
+	assert.True(t, strings.HasPrefix(lines[1], commits[1].String()[:8]+" old.txt (Test "), lines[1])
+	assert.True(t, strings.HasSuffix(lines[1], " 2) TWO"), lines[1])
+	assert.True(t, strings.HasPrefix(lines[5], commits[2].String()[:8]+" new.txt (Test "), lines[5])
+	assert.True(t, strings.HasSuffix(lines[5], " 6) six"), lines[5])
+}
+
+func TestBlameShallowBoundary(t *testing.T) {
+	repo, commits := setupRenameRepo(t)
+	require.NoError(t, repo.UpdateShallow([]objects.ObjectID{commits[2]}, nil))
+
+	out, err := runBlameArgs("new.txt")
+	require.NoError(t, err)
+	for _, line := range strings.Split(strings.TrimSuffix(out, "\n"), "\n") {
+		assert.True(t, strings.HasPrefix(line, "^"+commits[2].String()[:7]+" ("), line)
+	}
+}
+
+func TestBlameMissingFile(t *testing.T) {
+	setupRenameRepo(t)
+
+	_, err := runBlameArgs("missing.txt")
+	assert.ErrorContains(t, err, "no such path missing.txt")
+}
@@ -2,10 +2,13 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"strings"
 
+	"github.com/fenilsonani/vcs/internal/core/config"
 	"github.com/fenilsonani/vcs/internal/core/objects"
 	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/internal/revparse"
 	"github.com/fenilsonani/vcs/pkg/vcs"
 	"github.com/spf13/cobra"
 )
@@ -16,7 +19,26 @@ func newBranchCommand() *cobra.Command {
 		Short: "List, create, or delete branches",
 		Long: `With no arguments, list existing branches. The current branch will be highlighted with an asterisk.
 With one argument, create a new branch with that name.
-With two arguments, create a new branch with the first name starting at the second commit.`,
+With two arguments, create a new branch with the first name starting at the second commit.
+
+A branch started from a remote-tracking branch tracks it, recording it in
+branch.<name>.remote and branch.<name>.merge, unless --no-track is given
+or branch.autoSetupMerge is false; with --track, or branch.autoSetupMerge
+set to always, a branch started from a local branch tracks that one.
+--set-upstream-to sets the upstream of an existing branch and
+--unset-upstream removes it.
+
+With -v each branch is listed with its commit and how many commits it is
+ahead of and behind its upstream; -vv names the upstream too. The list is
+paged on a terminal, with the current branch in color.branch.current and
+upstreams in color.branch.upstream.
+
+--format lists each branch as the format says, with the fields "vcs
+for-each-ref" takes, such as "%(refname:short) %(upstream:track)"; with
+-a remote-tracking branches are listed too. --json, or --format=json,
+lists the branches as JSON, each with its
+commit and subject and how many commits it is ahead of and behind its
+upstream.`,
 		RunE: runBranch,
 	}
 
@@ -24,7 +46,14 @@ With two arguments, create a new branch with the first name starting at the seco
 	cmd.Flags().BoolP("force", "f", false, "Force creation or deletion")
 	cmd.Flags().BoolP("list", "l", false, "List branches (default)")
 	cmd.Flags().BoolP("all", "a", false, "List both remote-tracking and local branches")
-	cmd.Flags().BoolP("verbose", "v", false, "Show sha1 and commit subject line for each head")
+	cmd.Flags().CountP("verbose", "v", "Show sha1 and commit subject line for each head, and the upstream when given twice")
+	cmd.Flags().BoolP("track", "t", false, "Set up the new branch to track its start point")
+	cmd.Flags().Bool("no-track", false, "Do not set up tracking for the new branch")
+	cmd.Flags().StringP("set-upstream-to", "u", "", "Set the upstream of a branch")
+	cmd.Flags().Bool("unset-upstream", false, "Remove the upstream of a branch")
+	addColorFlags(cmd)
+	addJSONFlags(cmd)
+	cmd.Flags().Lookup("format").Usage = "Format of each branch, as for-each-ref takes it, or json"
 
 	return cmd
 }
@@ -37,7 +66,7 @@ func runBranch(cmd *cobra.Command, args []string) error {
 	}
 
 	// Open repository
-	repo, err := vcs.Open(repoPath)
+	repo, err := openRepository(repoPath)
 	if err != nil {
 		return fmt.Errorf("failed to open repository: %w", err)
 	}
@@ -47,7 +76,11 @@ func runBranch(cmd *cobra.Command, args []string) error {
 	force, _ := cmd.Flags().GetBool("force")
 	listBranches, _ := cmd.Flags().GetBool("list")
 	showAll, _ := cmd.Flags().GetBool("all")
-	verbose, _ := cmd.Flags().GetBool("verbose")
+	verbose, _ := cmd.Flags().GetCount("verbose")
+	track, _ := cmd.Flags().GetBool("track")
+	noTrack, _ := cmd.Flags().GetBool("no-track")
+	upstream, _ := cmd.Flags().GetString("set-upstream-to")
+	unset, _ := cmd.Flags().GetBool("unset-upstream")
 
 	// Get reference manager
 	refManager := refs.NewRefManager(repo.GitDir())
@@ -56,18 +89,70 @@ func runBranch(cmd *cobra.Command, args []string) error {
 	switch {
 	case deleteBranch:
 		return deleteBranchOperation(refManager, args, force)
+	case upstream != "" || unset:
+		return upstreamOperation(repo, refManager, args, upstream)
 	case len(args) == 0 || listBranches:
-		return listBranchesOperation(repo, refManager, showAll, verbose)
-	case len(args) == 1:
-		return createBranchOperation(repo, refManager, args[0], "")
-	case len(args) == 2:
-		return createBranchOperation(repo, refManager, args[0], args[1])
+		if format, _ := cmd.Flags().GetString("format"); format != "" && format != "json" {
+			return formatBranches(cmd.OutOrStdout(), repo, refManager, format, showAll)
+		}
+		asJSON, err := jsonRequested(cmd)
+		if err != nil {
+			return err
+		}
+		if asJSON {
+			return listBranchesJSON(cmd.OutOrStdout(), repo, refManager)
+		}
+		startPager(repo.GitDir(), "branch", true)
+		colors, err := newPalette(cmd, repo.GitDir(), "branch")
+		if err != nil {
+			return err
+		}
+		return listBranchesOperation(repo, refManager, showAll, verbose, colors)
+	case len(args) <= 2:
+		startPoint := ""
+		if len(args) == 2 {
+			startPoint = args[1]
+		}
+		if err := createBranchOperation(repo, refManager, args[0], startPoint); err != nil {
+			return err
+		}
+		if noTrack {
+			return nil
+		}
+		return setupTracking(repo, refManager, args[0], startPoint, track)
 	default:
 		return fmt.Errorf("too many arguments")
 	}
 }
 
-func listBranchesOperation(repo *vcs.Repository, refManager *refs.RefManager, showAll bool, verbose bool) error {
+// formatBranches writes the branches, and with all the remote-tracking
+// branches, as the for-each-ref format says
+func formatBranches(w io.Writer, repo *vcs.Repository, refManager *refs.RefManager, format string, all bool) error {
+	parsed, err := parseRefFormat(format)
+	if err != nil {
+		return err
+	}
+	items, err := newRefItems(refManager, "refs/heads/")
+	if err != nil {
+		return err
+	}
+	if all {
+		remotes, err := newRefItems(refManager, "refs/remotes/")
+		if err != nil {
+			return err
+		}
+		items = append(items, remotes...)
+	}
+	formatter := newRefFormatter(repo, refManager)
+	if err := formatter.sort(items, nil); err != nil {
+		return err
+	}
+	return formatter.write(w, parsed, items)
+}
+
+func listBranchesOperation(repo *vcs.Repository, refManager *refs.RefManager, showAll bool, verbose int, colors *palette) error {
+	resolver := newResolver(repo)
+
 	// Get current branch
 	currentBranch, err := refManager.CurrentBranch()
 	isDetached := err != nil
@@ -87,16 +172,17 @@ func listBranchesOperation(repo *vcs.Repository, refManager *refs.RefManager, sh
 		branchName := strings.TrimPrefix(branchRef, "refs/heads/")
 		
 		// Mark current branch with asterisk
-		prefix := "  "
+		prefix, slot := "  ", "local"
 		if !isDetached && branchName == currentBranch {
-			prefix = "* "
+			prefix, slot = "* ", "current"
 		}
+		shownName := colors.paint(slot, branchName)
 
-		if verbose {
+		if verbose > 0 {
 			// Show commit info
 			commitID, err := refManager.ResolveRef(branchRef)
 			if err != nil {
-				fmt.Printf("%s%s\n", prefix, branchName)
+				fmt.Printf("%s%s\n", prefix, shownName)
 				continue
 			}
 
@@ -107,13 +193,13 @@ func listBranchesOperation(repo *vcs.Repository, refManager *refs.RefManager, sh
 					if len(message) > 50 {
 						message = message[:47] + "..."
 					}
-					commitInfo = fmt.Sprintf(" %s %s", commitID.String()[:7], message)
+					commitInfo = fmt.Sprintf(" %s %s%s", commitID.String()[:7], trackingInfo(resolver, branchName, verbose, colors), message)
 				}
 			}
 
-			fmt.Printf("%s%s%s\n", prefix, branchName, commitInfo)
+			fmt.Printf("%s%s%s\n", prefix, shownName, commitInfo)
 		} else {
-			fmt.Printf("%s%s\n", prefix, branchName)
+			fmt.Printf("%s%s\n", prefix, shownName)
 		}
 	}
 
@@ -122,7 +208,7 @@ func listBranchesOperation(repo *vcs.Repository, refManager *refs.RefManager, sh
 		headCommitID, _, err := refManager.HEAD()
 		if err == nil && !headCommitID.IsZero() {
 			prefix := "* "
-			if verbose {
+			if verbose > 0 {
 				commitInfo := ""
 				if obj, err := repo.ReadObject(headCommitID); err == nil {
 					if commit, ok := obj.(*objects.Commit); ok {
@@ -133,9 +219,9 @@ func listBranchesOperation(repo *vcs.Repository, refManager *refs.RefManager, sh
 						commitInfo = fmt.Sprintf(" %s", message)
 					}
 				}
-				fmt.Printf("%s(HEAD detached at %s)%s\n", prefix, headCommitID.String()[:7], commitInfo)
+				fmt.Printf("%s%s%s\n", prefix, colors.paint("current", "(HEAD detached at "+headCommitID.String()[:7]+")"), commitInfo)
 			} else {
-				fmt.Printf("%s(HEAD detached at %s)\n", prefix, headCommitID.String()[:7])
+				fmt.Printf("%s%s\n", prefix, colors.paint("current", "(HEAD detached at "+headCommitID.String()[:7]+")"))
 			}
 		}
 	}
@@ -148,6 +234,38 @@ func listBranchesOperation(repo *vcs.Repository, refManager *refs.RefManager, sh
 	return nil
 }
 
+// listBranchesJSON writes the local branches as JSON, with the commit each
+// points to and how it stands against its upstream
+func listBranchesJSON(w io.Writer, repo *vcs.Repository, refManager *refs.RefManager) error {
+	resolver := newResolver(repo)
+	currentBranch, err := refManager.CurrentBranch()
+	isDetached := err != nil
+
+	branches, err := refManager.ListBranches()
+	if err != nil {
+		return fmt.Errorf("failed to list branches: %w", err)
+	}
+
+	listed := []jsonBranch{}
+	for _, branchRef := range branches {
+		name := strings.TrimPrefix(branchRef, "refs/heads/")
+		branch := jsonBranch{Name: name, Current: !isDetached && name == currentBranch}
+		if id, err := refManager.ResolveRef(branchRef); err == nil {
+			branch.Commit = id.String()
+			if commit, err := repo.GetCommit(id); err == nil {
+				branch.Subject = commitSubject(commit)
+			}
+		}
+		tracking, err := branchTracking(resolver, name, true)
+		if err != nil {
+			return err
+		}
+		branch.Upstream = newJSONUpstream(tracking)
+		listed = append(listed, branch)
+	}
+	return writeJSON(w, listed)
+}
+
 func createBranchOperation(repo *vcs.Repository, refManager *refs.RefManager, branchName string, startPoint string) error {
 	// Validate branch name
 	if !refManager.IsValidRef("refs/heads/"+branchName) {
@@ -170,23 +288,10 @@ func createBranchOperation(repo *vcs.Repository, refManager *refs.RefManager, br
 			return fmt.Errorf("no commits found to start branch from")
 		}
 	} else {
-		// Resolve start point
-		startCommitID, err = refManager.ResolveRef(startPoint)
-		if err != nil {
-			// Try to parse as object ID
-			startCommitID, err = objects.NewObjectID(startPoint)
-			if err != nil {
-				return fmt.Errorf("invalid start point: %s", startPoint)
-			}
-		}
-
-		// Verify it's a commit
-		obj, err := repo.ReadObject(startCommitID)
+		// Resolve start point, which may be any revision naming a commit
+		startCommitID, err = resolveCommitish(repo, startPoint)
 		if err != nil {
-			return fmt.Errorf("start point does not exist: %s", startPoint)
-		}
-		if _, ok := obj.(*objects.Commit); !ok {
-			return fmt.Errorf("start point is not a commit: %s", startPoint)
+			return fmt.Errorf("invalid start point: %s: %w", startPoint, err)
 		}
 	}
 
@@ -199,6 +304,105 @@ func createBranchOperation(repo *vcs.Repository, refManager *refs.RefManager, br
 	return nil
 }
 
+// trackingInfo returns the "[origin/main: ahead 1] " part of a branch -v
+// line: how the branch stands against its upstream, which -vv also names.
+// It is empty for a branch without one, and under -v for a branch equal
+// to it.
+func trackingInfo(resolver *revparse.Resolver, branch string, verbose int, colors *palette) string {
+	t, err := branchTracking(resolver, branch, true)
+	if err != nil || t == nil {
+		return ""
+	}
+	counts := t.counts()
+	switch {
+	case verbose < 2 && counts == "":
+		return ""
+	case verbose < 2:
+		return "[" + counts + "] "
+	case counts == "":
+		return "[" + colors.paint("upstream", t.upstream) + "] "
+	}
+	return "[" + colors.paint("upstream", t.upstream) + ": " + counts + "] "
+}
+
+// setupTracking makes the new branch track startPoint when it is a
+// remote-tracking branch, or a local branch with track set or
+// branch.autoSetupMerge set to always
+func setupTracking(repo *vcs.Repository, refManager *refs.RefManager, branch, startPoint string, track bool) error {
+	mode := "true"
+	if cfg, err := config.Load(repo.GitDir()); err == nil {
+		if value, ok := cfg.Get("branch.autoSetupMerge"); ok {
+			mode = strings.ToLower(value)
+		}
+	}
+	if mode == "false" && !track {
+		return nil
+	}
+
+	if startPoint == "" {
+		startPoint, _ = refManager.SymbolicHEAD()
+	} else if full, err := refManager.ExpandRef(startPoint); err == nil {
+		startPoint = full
+	}
+	switch {
+	case strings.HasPrefix(startPoint, "refs/remotes/"):
+	case strings.HasPrefix(startPoint, "refs/heads/"):
+		if !track && mode != "always" {
+			return nil
+		}
+	default:
+		if track {
+			return fmt.Errorf("cannot set up tracking information; starting point '%s' is not a branch", startPoint)
+		}
+		return nil
+	}
+
+	return trackUpstream(repo, refManager, branch, startPoint)
+}
+
+// upstreamOperation sets the upstream of the named branch, or of the
+// current one, to upstream, or removes it when upstream is empty
+func upstreamOperation(repo *vcs.Repository, refManager *refs.RefManager, args []string, upstream string) error {
+	if len(args) > 1 {
+		return fmt.Errorf("too many arguments")
+	}
+	var branch string
+	if len(args) == 1 {
+		branch = strings.TrimPrefix(args[0], "refs/heads/")
+		if !refManager.RefExists("refs/heads/" + branch) {
+			return fmt.Errorf("branch '%s' not found", branch)
+		}
+	} else {
+		head, err := refManager.SymbolicHEAD()
+		if err != nil || !strings.HasPrefix(head, "refs/heads/") {
+			return fmt.Errorf("HEAD does not point to a branch")
+		}
+		branch = strings.TrimPrefix(head, "refs/heads/")
+	}
+
+	if upstream == "" {
+		return unsetUpstream(repo, branch)
+	}
+	return trackUpstream(repo, refManager, branch, upstream)
+}
+
+// trackUpstream records upstream, a remote-tracking or local branch, as
+// the upstream of branch
+func trackUpstream(repo *vcs.Repository, refManager *refs.RefManager, branch, upstream string) error {
+	remote, upstreamBranch, err := splitUpstream(repo, refManager, upstream)
+	if err != nil {
+		return err
+	}
+	if err := setUpstreamBranch(repo, branch, remote, upstreamBranch); err != nil {
+		return fmt.Errorf("failed to set upstream: %w", err)
+	}
+	if remote != "." {
+		upstreamBranch = strings.TrimPrefix(upstream, "refs/remotes/")
+	}
+	fmt.Printf("branch '%s' set up to track '%s'.\n", branch, upstreamBranch)
+	return nil
+}
+
 func deleteBranchOperation(refManager *refs.RefManager, args []string, force bool) error {
 	if len(args) == 0 {
 		return fmt.Errorf("branch name required for deletion")
@@ -210,6 +414,9 @@ func deleteBranchOperation(refManager *refs.RefManager, args []string, force boo
 		if err == nil && currentBranch == branchName {
 			return fmt.Errorf("cannot delete the currently active branch '%s'", branchName)
 		}
+		if wt := branchWorktree(refManager.CommonDir(), refManager.GitDir(), "refs/heads/"+branchName); wt != nil {
+			return fmt.Errorf("cannot delete branch '%s' checked out at '%s'", branchName, wt.path)
+		}
 
 		// Check if branch exists
 		if !refManager.RefExists(branchName) {
@@ -73,19 +73,19 @@ func TestListBranchesOperation(t *testing.T) {
 	tests := []struct {
 		name         string
 		showAll      bool
-		verbose      bool
+		verbose      int
 		wantContains []string
 	}{
 		{
 			name:         "simple list",
 			showAll:      false,
-			verbose:      false,
+			verbose:      0,
 			wantContains: []string{"* main", "  feature", "  develop"},
 		},
 		{
 			name:         "verbose list",
 			showAll:      false,
-			verbose:      true,
+			verbose:      1,
 			wantContains: []string{"* main", "Test commit", commit.ID().String()[:7]},
 		},
 	}
@@ -97,7 +97,7 @@ func TestListBranchesOperation(t *testing.T) {
 			r, w, _ := os.Pipe()
 			os.Stdout = w
 
-			err := listBranchesOperation(repo, refManager, tt.showAll, tt.verbose)
+			err := listBranchesOperation(repo, refManager, tt.showAll, tt.verbose, nil)
 			if err != nil {
 				t.Errorf("listBranchesOperation() error = %v", err)
 			}
@@ -0,0 +1,360 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/bundle"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/packfile"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/internal/revparse"
+	"github.com/fenilsonani/vcs/internal/serve"
+	"github.com/fenilsonani/vcs/internal/transport"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+func newBundleCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "bundle",
+		Short: "Move objects and refs by archive",
+		Long: `Bundles carry refs and the objects they need in a single file, so
+history can be moved between machines without a network connection, as
+on a USB stick or through mail.
+
+"vcs bundle create <file> --all" bundles every ref; revision arguments
+such as main or v1.0..main bundle just the refs named, and history that
+is excluded is left out, to be required of the receiving repository as
+prerequisites. A bundle file can be cloned and fetched from as if it was
+a repository, or its objects stored with "vcs bundle unbundle".`,
+	}
+
+	var all bool
+	create := &cobra.Command{
+		Use:   "create <file> [--all | <rev-list-args>...]",
+		Short: "Create a bundle of the refs given",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runBundle(cmd, func(repo *vcs.Repository) error {
+				return createBundle(repo, args[0], args[1:], all)
+			})
+		},
+	}
+	create.Flags().BoolVar(&all, "all", false, "Bundle every ref and HEAD")
+
+	verify := &cobra.Command{
+		Use:   "verify <file>",
+		Short: "Check that a bundle is valid and applies to this repository",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runBundle(cmd, func(repo *vcs.Repository) error {
+				return verifyBundle(cmd.OutOrStdout(), repo, args[0])
+			})
+		},
+	}
+
+	listHeads := &cobra.Command{
+		Use:   "list-heads <file> [<refname>...]",
+		Short: "List the refs in a bundle",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			b, err := bundle.Open(args[0])
+			if err != nil {
+				return err
+			}
+			listBundleRefs(cmd.OutOrStdout(), b, args[1:])
+			return nil
+		},
+	}
+
+	unbundle := &cobra.Command{
+		Use:   "unbundle <file> [<refname>...]",
+		Short: "Store the objects of a bundle and list its refs",
+		Long: `Stores the objects of a bundle in the repository and lists the refs it
+carries, or those named. No ref is updated; fetch from the bundle to
+update refs.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runBundle(cmd, func(repo *vcs.Repository) error {
+				return unbundleBundle(cmd.OutOrStdout(), repo, args[0], args[1:])
+			})
+		},
+	}
+
+	cmd.AddCommand(create, verify, listHeads, unbundle)
+	return cmd
+}
+
+// runBundle opens the repository and runs fn
+func runBundle(cmd *cobra.Command, fn func(repo *vcs.Repository) error) error {
+	repoPath, err := findRepository()
+	if err != nil {
+		return fmt.Errorf("not a git repository: %w", err)
+	}
+	repo, err := openStoredRepository(repoPath)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+	cmd.SilenceUsage = true
+	return fn(repo)
+}
+
+// createBundle writes a bundle of the refs args and all name to path. The
+// objects reachable from the commits args include and not from those they
+// exclude go in the pack; the excluded parents of included commits become
+// the prerequisites.
+func createBundle(repo *vcs.Repository, path string, args []string, all bool) error {
+	refManager := refs.NewRefManager(repo.GitDir())
+	resolver := newResolver(repo)
+
+	header := &bundle.Header{}
+	addRef := func(name string, id objects.ObjectID) {
+		if _, ok := header.Ref(name); !ok {
+			header.Refs = append(header.Refs, bundle.Ref{Name: name, ID: id})
+		}
+	}
+
+	var tips, excluded []objects.ObjectID
+	if all {
+		allRefs, err := refManager.AllRefs()
+		if err != nil {
+			return fmt.Errorf("failed to list refs: %w", err)
+		}
+		if id, _, err := refManager.HEAD(); err == nil {
+			addRef("HEAD", id)
+		}
+		names := make([]string, 0, len(allRefs))
+		for name := range allRefs {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			addRef(name, allRefs[name])
+		}
+	}
+
+	for _, arg := range args {
+		r, err := resolver.ResolveRange(arg)
+		if err != nil {
+			return err
+		}
+		tips = append(tips, r.Include...)
+		excluded = append(excluded, r.Exclude...)
+
+		// The positive end of a range is recorded when it names a ref
+		name := arg
+		if i := strings.Index(arg, ".."); i >= 0 {
+			if name = strings.TrimPrefix(arg[i+2:], "."); name == "" {
+				name = "HEAD"
+			}
+		} else if revparse.IsRange(arg) {
+			continue
+		}
+		full, err := refManager.ExpandRef(name)
+		if err != nil {
+			continue
+		}
+		id, err := resolver.Resolve(full)
+		if err != nil {
+			return err
+		}
+		addRef(full, id)
+	}
+	if len(header.Refs) == 0 {
+		return fmt.Errorf("refusing to create empty bundle")
+	}
+	for _, ref := range header.Refs {
+		tips = append(tips, ref.ID)
+	}
+
+	ids, err := objectsToSend(repo, tips, excluded)
+	if err != nil {
+		return fmt.Errorf("failed to list objects: %w", err)
+	}
+	objs, err := packfile.ReadObjects(repo.Storage(), ids)
+	if err != nil {
+		return err
+	}
+	if header.Prerequisites, err = bundlePrerequisites(repo, objs); err != nil {
+		return err
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", path, err)
+	}
+	err = bundle.WriteHeader(f, header)
+	if err == nil {
+		_, err = packfile.WritePack(f, objs, packWriterOptions(repo.GitDir()))
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path)
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// bundlePrerequisites returns the parents of the commits of objs that are
+// not themselves among objs
+func bundlePrerequisites(repo *vcs.Repository, objs []*packfile.Object) ([]bundle.Prerequisite, error) {
+	included := make(map[objects.ObjectID]bool, len(objs))
+	for _, obj := range objs {
+		included[obj.ID] = true
+	}
+
+	var prereqs []bundle.Prerequisite
+	seen := make(map[objects.ObjectID]bool)
+	for _, obj := range objs {
+		if obj.Type != objects.TypeCommit {
+			continue
+		}
+		commit, err := objects.ParseCommit(obj.ID, obj.Data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse commit %s: %w", obj.ID.Short(), err)
+		}
+		for _, parent := range commit.Parents() {
+			if included[parent] || seen[parent] {
+				continue
+			}
+			seen[parent] = true
+			prereq := bundle.Prerequisite{ID: parent}
+			if c, err := repo.GetCommit(parent); err == nil {
+				prereq.Comment = commitSubject(c)
+			}
+			prereqs = append(prereqs, prereq)
+		}
+	}
+	return prereqs, nil
+}
+
+// missingPrerequisites returns the prerequisites of b that repo lacks
+func missingPrerequisites(repo *vcs.Repository, b *bundle.Bundle) []bundle.Prerequisite {
+	var missing []bundle.Prerequisite
+	for _, prereq := range b.Prerequisites {
+		if !repo.HasObject(prereq.ID) {
+			missing = append(missing, prereq)
+		}
+	}
+	return missing
+}
+
+// checkPrerequisites fails when repo lacks prerequisites of b
+func checkPrerequisites(repo *vcs.Repository, b *bundle.Bundle) error {
+	missing := missingPrerequisites(repo, b)
+	if len(missing) == 0 {
+		return nil
+	}
+	lines := make([]string, len(missing))
+	for i, prereq := range missing {
+		lines[i] = strings.TrimSpace(prereq.ID.String() + " " + prereq.Comment)
+	}
+	return fmt.Errorf("repository lacks these prerequisite commits:\n%s", strings.Join(lines, "\n"))
+}
+
+// verifyBundle checks that the bundle at path can be read and that repo
+// has its prerequisites, and describes it
+func verifyBundle(out io.Writer, repo *vcs.Repository, path string) error {
+	b, err := bundle.Open(path)
+	if err != nil {
+		return err
+	}
+	if err := checkPrerequisites(repo, b); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	fmt.Fprintf(out, "The bundle contains %s:\n", bundleRefCount(len(b.Refs)))
+	listBundleRefs(out, b, nil)
+	if len(b.Prerequisites) == 0 {
+		fmt.Fprintln(out, "The bundle records a complete history.")
+	} else {
+		fmt.Fprintf(out, "The bundle requires %s:\n", bundleRefCount(len(b.Prerequisites)))
+		for _, prereq := range b.Prerequisites {
+			fmt.Fprintln(out, strings.TrimSpace(prereq.ID.String()+" "+prereq.Comment))
+		}
+	}
+	fmt.Fprintf(out, "%s is okay\n", path)
+	return nil
+}
+
+// bundleRefCount phrases n refs as Git's bundle verify does
+func bundleRefCount(n int) string {
+	if n == 1 {
+		return "this ref"
+	}
+	return fmt.Sprintf("these %d refs", n)
+}
+
+// listBundleRefs prints the refs of b, or those of them named
+func listBundleRefs(out io.Writer, b *bundle.Bundle, names []string) {
+	for _, ref := range b.Refs {
+		if len(names) > 0 && !bundleRefMatches(ref.Name, names) {
+			continue
+		}
+		fmt.Fprintf(out, "%s %s\n", ref.ID, ref.Name)
+	}
+}
+
+// bundleRefMatches reports whether name is one of names, in full or
+// without its refs/ prefix
+func bundleRefMatches(name string, names []string) bool {
+	for _, candidate := range names {
+		if name == candidate || strings.HasSuffix(name, "/"+candidate) {
+			return true
+		}
+	}
+	return false
+}
+
+// unbundleBundle stores the objects of the bundle at path in repo and
+// lists its refs
+func unbundleBundle(out io.Writer, repo *vcs.Repository, path string, names []string) error {
+	b, err := bundle.Open(path)
+	if err != nil {
+		return err
+	}
+	if err := checkPrerequisites(repo, b); err != nil {
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	pack, err := b.Pack()
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	defer pack.Close()
+	if _, err := packfile.Unpack(pack, repo); err != nil {
+		return fmt.Errorf("failed to unpack %s: %w", path, err)
+	}
+	listBundleRefs(out, b, names)
+	return nil
+}
+
+// isBundleURL reports whether url is a local URL naming a bundle file
+func isBundleURL(url string) bool {
+	return isLocalURL(url) && bundle.IsBundle(localURLPath(url))
+}
+
+// newBundleTransport reaches the bundle a local URL names by serving it in
+// this process, once repo is found to have its prerequisites
+func newBundleTransport(repo *vcs.Repository, url string) (*transport.HTTPTransport, error) {
+	path, err := filepath.Abs(localURLPath(url))
+	if err != nil {
+		return nil, err
+	}
+	b, err := bundle.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkPrerequisites(repo, b); err != nil {
+		return nil, fmt.Errorf("%s: %w", url, err)
+	}
+	return transport.NewHandlerTransport("file://"+filepath.ToSlash(path), serve.NewBundleServer(b)), nil
+}
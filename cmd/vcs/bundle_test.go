@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+func runBundleArgs(args ...string) (string, error) {
+	cmd := newBundleCommand()
+	var stdout bytes.Buffer
+	cmd.SetOut(&stdout)
+	cmd.SetErr(&stdout)
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+	return stdout.String(), err
+}
+
+func TestBundleCreateVerifyUnbundle(t *testing.T) {
+	_, refManager, ids := setupBisectRepo(t, 6)
+	require.NoError(t, refManager.UpdateRef("refs/tags/v1", ids[2]))
+	dir := t.TempDir()
+	full := filepath.Join(dir, "full.bundle")
+	incremental := filepath.Join(dir, "incremental.bundle")
+
+	_, err := runBundleArgs("create", full, "--all")
+	require.NoError(t, err)
+	out, err := runBundleArgs("verify", full)
+	require.NoError(t, err)
+	assert.Contains(t, out, "The bundle contains these 3 refs:\n"+ids[5].String()+" HEAD\n")
+	assert.Contains(t, out, ids[2].String()+" refs/tags/v1\n")
+	assert.Contains(t, out, "The bundle records a complete history.")
+	assert.Contains(t, out, full+" is okay")
+
+	_, err = runBundleArgs("create", incremental, "v1..main")
+	require.NoError(t, err)
+	out, err = runBundleArgs("list-heads", incremental)
+	require.NoError(t, err)
+	assert.Equal(t, ids[5].String()+" refs/heads/main\n", out)
+	out, err = runBundleArgs("verify", incremental)
+	require.NoError(t, err)
+	assert.Contains(t, out, "The bundle requires this ref:\n"+ids[2].String()+" commit 2\n")
+
+	_, err = runBundleArgs("create", filepath.Join(dir, "empty.bundle"), ids[3].String())
+	assert.ErrorContains(t, err, "refusing to create empty bundle")
+	assert.NoFileExists(t, filepath.Join(dir, "empty.bundle"))
+
+	// A new repository lacks the prerequisites of the incremental bundle
+	other, err := vcs.Init(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(other.Path()))
+	_, err = runBundleArgs("verify", incremental)
+	assert.ErrorContains(t, err, "lacks these prerequisite commits")
+
+	out, err = runBundleArgs("unbundle", full, "main")
+	require.NoError(t, err)
+	assert.Equal(t, ids[5].String()+" refs/heads/main\n", out)
+	for _, id := range ids {
+		assert.True(t, other.HasObject(id))
+	}
+	_, err = runBundleArgs("verify", incremental)
+	assert.NoError(t, err)
+}
+
+func TestCloneAndFetchFromBundle(t *testing.T) {
+	_, refManager, ids := setupBisectRepo(t, 6)
+	dir := t.TempDir()
+	require.NoError(t, refManager.UpdateRef("refs/heads/main", ids[3]))
+	_, err := runBundleArgs("create", filepath.Join(dir, "old.bundle"), "HEAD", "main")
+	require.NoError(t, err)
+	require.NoError(t, refManager.UpdateRef("refs/heads/main", ids[5]))
+	_, err = runBundleArgs("create", filepath.Join(dir, "new.bundle"), "main~2..main")
+	require.NoError(t, err)
+
+	clone := filepath.Join(dir, "clone")
+	require.NoError(t, runCloneArgs(filepath.Join(dir, "old.bundle"), clone))
+	data, err := os.ReadFile(filepath.Join(clone, "n.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "3\n", string(data))
+
+	require.NoError(t, os.Chdir(clone))
+	_, err = runConfigArgs("remote.origin.url", filepath.Join(dir, "new.bundle"))
+	require.NoError(t, err)
+	_, err = runFetchArgs("origin")
+	require.NoError(t, err)
+	id, err := refs.NewRefManager(filepath.Join(clone, ".git")).ResolveRef("refs/remotes/origin/main")
+	require.NoError(t, err)
+	assert.Equal(t, ids[5], id)
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"fmt"
+	"io"
+	"strings"
 
 	"github.com/fenilsonani/vcs/internal/core/objects"
 	"github.com/fenilsonani/vcs/pkg/vcs"
@@ -14,6 +16,8 @@ func newCatFileCommand() *cobra.Command {
 		showSize    bool
 		showContent bool
 		pretty      bool
+		filters     bool
+		path        string
 	)
 	
 	cmd := &cobra.Command{
@@ -23,11 +27,19 @@ func newCatFileCommand() *cobra.Command {
 		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			// Open repository
-			repo, err := vcs.Open(".")
+			repoPath, err := findRepository()
+			if err != nil {
+				return fmt.Errorf("not in a vcs repository: %w", err)
+			}
+			repo, err := openRepository(repoPath)
 			if err != nil {
 				return fmt.Errorf("not in a vcs repository: %w", err)
 			}
 			
+			if filters || path != "" {
+				return catFileFilters(cmd.OutOrStdout(), cmd.ErrOrStderr(), repo, args[0], path)
+			}
+
 			// Parse object ID
 			id, err := objects.NewObjectID(args[0])
 			if err != nil {
@@ -64,6 +76,35 @@ func newCatFileCommand() *cobra.Command {
 	cmd.Flags().BoolVarP(&showSize, "size", "s", false, "Show object size")
 	cmd.Flags().BoolVarP(&showContent, "exist", "e", false, "Exit with zero status if object exists")
 	cmd.Flags().BoolVarP(&pretty, "pretty-print", "p", false, "Pretty-print object content")
+	cmd.Flags().BoolVar(&filters, "filters", false, "Show blob content as checkout would write it to the working tree")
+	cmd.Flags().StringVar(&path, "path", "", "Path whose attributes convert the blob, for --filters")
 	
 	return cmd
-}
\ No newline at end of file
+}
+
+// catFileFilters writes the blob rev names as it would be checked out at
+// path, or at the path in rev when it is <rev>:<path>
+func catFileFilters(out, errOut io.Writer, repo *vcs.Repository, rev, path string) error {
+	if path == "" {
+		var ok bool
+		if _, path, ok = strings.Cut(rev, ":"); !ok || path == "" {
+			return fmt.Errorf("--filters needs <rev>:<path> or --path")
+		}
+	}
+
+	id, err := newResolver(repo).Resolve(rev)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %s: %w", rev, err)
+	}
+	blob, err := repo.GetBlob(id)
+	if err != nil {
+		return fmt.Errorf("%s is not a blob: %w", rev, err)
+	}
+
+	data, err := newConverter(repo, errOut, nil).Smudge(path, blob.Data())
+	if err != nil {
+		return err
+	}
+	_, err = out.Write(data)
+	return err
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/config"
+	"github.com/fenilsonani/vcs/internal/core/ignore"
+	"github.com/fenilsonani/vcs/internal/core/workdir"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// checkIgnoreOptions holds the flags of check-ignore
+type checkIgnoreOptions struct {
+	verbose     bool
+	nonMatching bool
+	stdin       bool
+	nul         bool
+	quiet       bool
+	noIndex     bool
+}
+
+// newScanner returns a scanner of the working tree of repo, ignoring
+// paths as its .gitignore files, info/exclude and core.excludesFile say
+func newScanner(repo *vcs.Repository) *workdir.Scanner {
+	scanner := workdir.NewScanner(repo.WorkDir(), repo.GitDir())
+	if file := globalExcludesFile(loadConfig(repo.GitDir())); file != "" {
+		scanner.LoadExcludesFile(file)
+	}
+	return scanner
+}
+
+// globalExcludesFile returns core.excludesFile, or else the ignore file in
+// the XDG config directory
+func globalExcludesFile(cfg *config.Config) string {
+	if path, ok := cfg.Get("core.excludesFile"); ok && path != "" {
+		return expandUserPath(path)
+	}
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		return filepath.Join(xdg, "git", "ignore")
+	}
+	if home, err := os.UserHomeDir(); err == nil {
+		return filepath.Join(home, ".config", "git", "ignore")
+	}
+	return ""
+}
+
+func newCheckIgnoreCommand() *cobra.Command {
+	var opts checkIgnoreOptions
+
+	cmd := &cobra.Command{
+		Use:   "check-ignore [flags] <pathname>...",
+		Short: "Debug which ignore rules exclude paths",
+		Long: `Prints each pathname that is ignored, as decided by the .gitignore files
+of its directory and those above it, .git/info/exclude and
+core.excludesFile (by default ~/.config/git/ignore).
+
+With -v each is printed after the pattern that decides it, as
+source:line:pattern, tab, pathname. A negated pattern that re-includes a
+path is shown too, as it explains why the path is not ignored; -n also
+lists the paths no pattern matches, with empty fields.
+
+Files in the index are never ignored, so they are not printed unless
+--no-index is given. check-ignore exits with status 1 when no pathname
+is ignored.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if opts.stdin && len(args) > 0 {
+				return fmt.Errorf("cannot specify pathnames with --stdin")
+			}
+			if !opts.stdin && len(args) == 0 {
+				return fmt.Errorf("no path specified")
+			}
+			if opts.nonMatching && !opts.verbose {
+				return fmt.Errorf("--non-matching is only valid with --verbose")
+			}
+			if opts.quiet && opts.verbose {
+				return fmt.Errorf("cannot have both --quiet and --verbose")
+			}
+			paths := args
+			if opts.stdin {
+				var err error
+				if paths, err = readCheckIgnorePaths(cmd.InOrStdin(), opts.nul); err != nil {
+					return err
+				}
+			}
+			ignored, err := runCheckIgnore(cmd.OutOrStdout(), paths, opts)
+			if err == nil && !ignored {
+				cmd.SilenceErrors = true
+				cmd.SilenceUsage = true
+				return fmt.Errorf("no path is ignored")
+			}
+			return err
+		},
+	}
+
+	cmd.Flags().BoolVarP(&opts.verbose, "verbose", "v", false, "Print the pattern deciding each path")
+	cmd.Flags().BoolVarP(&opts.nonMatching, "non-matching", "n", false, "Also print paths no pattern matches, with -v")
+	cmd.Flags().BoolVar(&opts.stdin, "stdin", false, "Read the pathnames from stdin, one per line")
+	cmd.Flags().BoolVarP(&opts.nul, "null", "z", false, "Separate input and output with NUL instead of newlines")
+	cmd.Flags().BoolVarP(&opts.quiet, "quiet", "q", false, "Print nothing, only set the exit status")
+	cmd.Flags().BoolVar(&opts.noIndex, "no-index", false, "Check paths in the index too")
+
+	return cmd
+}
+
+// runCheckIgnore prints the paths that are ignored and reports whether
+// any was
+func runCheckIgnore(out io.Writer, paths []string, opts checkIgnoreOptions) (bool, error) {
+	repoPath, err := findRepository()
+	if err != nil {
+		return false, fmt.Errorf("not a git repository: %w", err)
+	}
+	repo, err := openRepository(repoPath)
+	if err != nil {
+		return false, fmt.Errorf("failed to open repository: %w", err)
+	}
+	matcher := newScanner(repo).Ignores()
+
+	tracked := make(map[string]bool)
+	if !opts.noIndex {
+		idx, err := readIndex(repo)
+		if err != nil {
+			return false, err
+		}
+		for _, entry := range idx.Entries() {
+			tracked[entry.Path] = true
+		}
+	}
+
+	w := bufio.NewWriter(out)
+	defer w.Flush()
+	anyIgnored := false
+	for _, arg := range paths {
+		relPath, err := repoRelativePath(repoPath, arg)
+		if err != nil {
+			return anyIgnored, err
+		}
+		var pattern *ignore.Pattern
+		ignored := false
+		if !tracked[relPath] {
+			info, err := os.Lstat(filepath.Join(repoPath, filepath.FromSlash(relPath)))
+			isDir := strings.HasSuffix(arg, "/") || (err == nil && info.IsDir())
+			pattern, ignored = matcher.Match(relPath, isDir)
+		}
+		anyIgnored = anyIgnored || ignored
+		switch {
+		case opts.quiet:
+		case opts.verbose && (pattern != nil || opts.nonMatching):
+			writeCheckIgnoreMatch(w, repoPath, pattern, arg, opts.nul)
+		case ignored:
+			w.WriteString(arg)
+			w.WriteByte(checkIgnoreTerminator(opts.nul))
+		}
+	}
+	return anyIgnored, nil
+}
+
+// writeCheckIgnoreMatch prints the pattern deciding for arg, or empty
+// fields for none, as check-ignore -v does
+func writeCheckIgnoreMatch(w *bufio.Writer, repoPath string, p *ignore.Pattern, arg string, nul bool) {
+	var source, line, pattern string
+	if p != nil {
+		source, line, pattern = p.File, strconv.Itoa(p.Line), p.Pattern
+		// Files in the repository are shown relative to its top
+		if rel, err := filepath.Rel(repoPath, source); err == nil && filepath.IsAbs(source) && !strings.HasPrefix(rel, "..") {
+			source = filepath.ToSlash(rel)
+		}
+		if p.File == "" {
+			line = ""
+		}
+	}
+	if nul {
+		for _, field := range []string{source, line, pattern, arg} {
+			w.WriteString(field)
+			w.WriteByte(0)
+		}
+		return
+	}
+	fmt.Fprintf(w, "%s:%s:%s\t%s\n", source, line, pattern, arg)
+}
+
+// checkIgnoreTerminator ends each path printed
+func checkIgnoreTerminator(nul bool) byte {
+	if nul {
+		return 0
+	}
+	return '\n'
+}
+
+// readCheckIgnorePaths returns the paths listed in r, one per line or,
+// with nul, separated by NULs
+func readCheckIgnorePaths(r io.Reader, nul bool) ([]string, error) {
+	if !nul {
+		return readStdinPaths(r)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read paths: %w", err)
+	}
+	var paths []string
+	for _, path := range bytes.Split(data, []byte{0}) {
+		if len(path) > 0 {
+			paths = append(paths, string(path))
+		}
+	}
+	return paths, nil
+}
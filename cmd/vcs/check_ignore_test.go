@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func runCheckIgnoreArgs(t *testing.T, stdin string, args ...string) (string, error) {
+	cmd := newCheckIgnoreCommand()
+	var stdout bytes.Buffer
+	cmd.SetOut(&stdout)
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetIn(strings.NewReader(stdin))
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+	return stdout.String(), err
+}
+
+func TestCheckIgnore(t *testing.T) {
+	setupTreeRepo(t, map[string]string{
+		".gitignore":     "*.log\nbuild/\n",
+		"src/.gitignore": "!keep.log\n",
+		"tracked.log":    "committed before the rule\n",
+	})
+	repo, _ := openRepository(".")
+	excludes := filepath.Join(t.TempDir(), "ignore")
+	require.NoError(t, os.WriteFile(excludes, []byte("*.swp\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(repo.GitDir(), "info", "exclude"), []byte("secret.txt\n"), 0644))
+	_, err := runConfigArgs("core.excludesFile", excludes)
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll("build", 0755))
+
+	out, err := runCheckIgnoreArgs(t, "", "debug.log", "src/keep.log", "build/out.o", "main.go", "secret.txt", "x.swp", "tracked.log")
+	require.NoError(t, err)
+	assert.Equal(t, "debug.log\nbuild/out.o\nsecret.txt\nx.swp\n", out)
+
+	out, err = runCheckIgnoreArgs(t, "", "-v", "-n", "debug.log", "src/keep.log", "build/out.o", "main.go", "secret.txt", "x.swp")
+	require.NoError(t, err)
+	assert.Equal(t, ".gitignore:1:*.log\tdebug.log\n"+
+		"src/.gitignore:1:!keep.log\tsrc/keep.log\n"+
+		".gitignore:2:build/\tbuild/out.o\n"+
+		"::\tmain.go\n"+
+		".git/info/exclude:1:secret.txt\tsecret.txt\n"+
+		excludes+":1:*.swp\tx.swp\n", out)
+
+	out, err = runCheckIgnoreArgs(t, "", "--no-index", "tracked.log")
+	require.NoError(t, err)
+	assert.Equal(t, "tracked.log\n", out)
+
+	out, err = runCheckIgnoreArgs(t, "a.log\x00main.go\x00", "--stdin", "-z")
+	require.NoError(t, err)
+	assert.Equal(t, "a.log\x00", out)
+
+	// Nothing ignored exits with an error and prints nothing
+	out, err = runCheckIgnoreArgs(t, "", "main.go", "src/keep.log")
+	assert.Error(t, err)
+	assert.Empty(t, out)
+}
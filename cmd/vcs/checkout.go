@@ -4,8 +4,10 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
 
-	"github.com/fenilsonani/vcs/internal/core/index"
+	"github.com/fenilsonani/vcs/internal/core/merge"
 	"github.com/fenilsonani/vcs/internal/core/objects"
 	"github.com/fenilsonani/vcs/internal/core/refs"
 	"github.com/fenilsonani/vcs/pkg/vcs"
@@ -17,18 +19,38 @@ func newCheckoutCommand() *cobra.Command {
 		Use:   "checkout [flags] <branch|commit>",
 		Short: "Switch branches or restore working tree files",
 		Long: `Updates files in the working tree to match the version in the index or the specified tree.
-If no pathspec is given, also updates HEAD to set the specified branch as the current branch.`,
+If no pathspec is given, also updates HEAD to set the specified branch as the current branch.
+
+Progress updating the working tree is shown when standard error is a
+terminal, and the checkout ends with the paths it updated and deleted,
+grouped by directory. --porcelain prints them to stdout as "<status> <path>"
+lines instead, with M or D as the status, and sends other messages to stderr.`,
 		RunE: runCheckout,
 	}
 
 	cmd.Flags().BoolP("force", "f", false, "Force checkout (lose local changes)")
 	cmd.Flags().BoolP("create", "b", false, "Create a new branch and switch to it")
+	cmd.Flags().String("orphan", "", "Create a new unborn branch, keeping the current files staged")
+	cmd.Flags().BoolP("patch", "p", false, "Interactively choose hunks of working tree changes to discard")
+	addWorktreeOutputFlags(cmd)
 
 	return cmd
 }
 
 func runCheckout(cmd *cobra.Command, args []string) error {
-	if len(args) != 1 {
+	orphan, _ := cmd.Flags().GetString("orphan")
+	if patch, _ := cmd.Flags().GetBool("patch"); patch {
+		repoPath, err := findRepository()
+		if err != nil {
+			return fmt.Errorf("not a git repository: %w", err)
+		}
+		repo, err := openRepository(repoPath)
+		if err != nil {
+			return fmt.Errorf("failed to open repository: %w", err)
+		}
+		return discardHunks(cmd, repo, args)
+	}
+	if len(args) != 1 && orphan == "" {
 		return fmt.Errorf("checkout requires exactly one argument")
 	}
 
@@ -39,7 +61,7 @@ func runCheckout(cmd *cobra.Command, args []string) error {
 	}
 
 	// Open repository
-	repo, err := vcs.Open(repoPath)
+	repo, err := openRepository(repoPath)
 	if err != nil {
 		return fmt.Errorf("failed to open repository: %w", err)
 	}
@@ -48,39 +70,64 @@ func runCheckout(cmd *cobra.Command, args []string) error {
 	force, _ := cmd.Flags().GetBool("force")
 	createBranch, _ := cmd.Flags().GetBool("create")
 
-	target := args[0]
-
 	// Get reference manager
 	refManager := refs.NewRefManager(repo.GitDir())
 
+	// Orphan branches keep the index and working tree, like git checkout --orphan
+	if orphan != "" {
+		if len(args) > 0 {
+			return fmt.Errorf("--orphan does not take a start point")
+		}
+		return createOrphanBranch(cmd, repo, refManager, orphan, false, force)
+	}
+
+	target := args[0]
+
 	// Handle branch creation
 	if createBranch {
 		return createAndCheckoutBranch(cmd, repo, refManager, target, force)
 	}
 
-	// Check if target is a branch or commit
-	var targetCommitID objects.ObjectID
-	var isBranch bool
+	// "-" and @{-N} name the branch checked out before, which stays attached
+	if target == "-" {
+		target = "@{-1}"
+	}
+	if strings.HasPrefix(target, "@{-") && strings.HasSuffix(target, "}") {
+		n, err := strconv.Atoi(target[3 : len(target)-1])
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid revision %s", target)
+		}
+		if target, err = newResolver(repo).PreviousCheckout(n); err != nil {
+			return err
+		}
+	}
 
-	// Try to resolve as branch first
-	if refManager.RefExists(target) {
-		targetCommitID, err = refManager.ResolveRef(target)
+	// A local branch is checked out; any other revision detaches HEAD
+	var targetCommitID objects.ObjectID
+	isBranch := refManager.RefExists("refs/heads/" + target)
+	if isBranch {
+		targetCommitID, err = refManager.ResolveRef("refs/heads/" + target)
 		if err != nil {
 			return fmt.Errorf("failed to resolve branch %s: %w", target, err)
 		}
-		isBranch = true
-	} else {
-		// Try to parse as commit ID
-		targetCommitID, err = objects.NewObjectID(target)
-		if err != nil {
-			return fmt.Errorf("invalid branch or commit: %s", target)
-		}
-		isBranch = false
+	} else if targetCommitID, err = resolveCommitish(repo, target); err != nil {
+		return fmt.Errorf("invalid branch or commit %s: %w", target, err)
+	}
 
-		// Verify the commit exists
-		_, err = repo.ReadObject(targetCommitID)
-		if err != nil {
-			return fmt.Errorf("commit does not exist: %s", target)
+	branch := ""
+	if isBranch {
+		branch = target
+	}
+	return checkoutCommit(cmd, repo, refManager, repoPath, targetCommitID, branch, force)
+}
+
+// checkoutCommit updates the working directory to commitID and moves HEAD.
+// HEAD is attached to branch when one is given and detached otherwise.
+func checkoutCommit(cmd *cobra.Command, repo *vcs.Repository, refManager *refs.RefManager, repoPath string, targetCommitID objects.ObjectID, branch string, force bool) error {
+	// A branch is checked out in one worktree at a time
+	if branch != "" && !force {
+		if wt := branchWorktree(repo.CommonDir(), repo.GitDir(), "refs/heads/"+branch); wt != nil {
+			return fmt.Errorf("'%s' is already checked out at '%s'", branch, wt.path)
 		}
 	}
 
@@ -95,31 +142,46 @@ func runCheckout(cmd *cobra.Command, args []string) error {
 		}
 	}
 
+	// With --porcelain only the changed paths go to stdout
+	update := newWorktreeUpdate(cmd)
+	out := cmd.OutOrStdout()
+	if update.porcelain {
+		out = cmd.ErrOrStderr()
+	}
+
 	// Update working directory
-	if err := updateWorkingDirectory(repo, targetCommitID, repoPath); err != nil {
+	oldHeadID, _, _ := refManager.HEAD()
+	if err := updateWorkingDirectory(repo, oldHeadID, targetCommitID, update); err != nil {
 		return fmt.Errorf("failed to update working directory: %w", err)
 	}
 
+	from := getCurrentBranchName(refManager)
+	if from == "HEAD" {
+		from = oldHeadID.String()
+	}
+
 	// Update HEAD
-	if isBranch {
-		if err := refManager.SetHEAD("refs/heads/" + target); err != nil {
+	if branch != "" {
+		if err := refManager.SetHEAD("refs/heads/" + branch); err != nil {
 			return fmt.Errorf("failed to update HEAD: %w", err)
 		}
-		fmt.Fprintf(cmd.OutOrStdout(), "Switched to branch '%s'\n", target)
+		logRefUpdate(refManager, "HEAD", oldHeadID, targetCommitID, "checkout: moving from "+from+" to "+branch)
+		fmt.Fprintf(out, "Switched to branch '%s'\n", branch)
 	} else {
 		if err := refManager.SetHEADToCommit(targetCommitID); err != nil {
 			return fmt.Errorf("failed to update HEAD: %w", err)
 		}
-		fmt.Fprintf(cmd.OutOrStdout(), "HEAD is now at %s\n", targetCommitID.String()[:7])
+		logRefUpdate(refManager, "HEAD", oldHeadID, targetCommitID, "checkout: moving from "+from+" to "+targetCommitID.String())
+		fmt.Fprintf(out, "HEAD is now at %s\n", targetCommitID.String()[:7])
 	}
 
-	// Clear index (for simplicity)
-	idx := index.New()
-	indexPath := filepath.Join(repo.GitDir(), "index")
-	if err := idx.WriteToFile(indexPath); err != nil {
-		return fmt.Errorf("failed to clear index: %w", err)
+	// The index now holds the files of the checked out commit
+	if err := resetIndexToHead(repo); err != nil {
+		return err
 	}
+	update.printSummary(cmd.OutOrStdout())
 
+	runPostCheckoutHook(cmd.ErrOrStderr(), repoPath, repo.GitDir(), oldHeadID, targetCommitID, true)
 	return nil
 }
 
@@ -151,63 +213,54 @@ func createAndCheckoutBranch(cmd *cobra.Command, repo *vcs.Repository, refManage
 	}
 
 	fmt.Fprintf(cmd.OutOrStdout(), "Switched to a new branch '%s'\n", branchName)
+	runPostCheckoutHook(cmd.ErrOrStderr(), repo.WorkDir(), repo.GitDir(), currentCommitID, currentCommitID, true)
 	return nil
 }
 
 func hasUncommittedChanges(repo *vcs.Repository, refManager *refs.RefManager) (bool, error) {
-	// For simplicity, we'll just check if the index has entries
-	// A full implementation would compare working directory with HEAD
-	idx := index.New()
-	indexPath := filepath.Join(repo.GitDir(), "index")
-	if _, err := os.Stat(indexPath); err == nil {
-		if err := idx.ReadFromFile(indexPath); err == nil {
-			return len(idx.Entries()) > 0, nil
+	// For simplicity, only staged changes count: those of the index against
+	// HEAD. A full implementation would compare the working directory too
+	idx, err := readIndex(repo)
+	if err != nil {
+		return false, err
+	}
+	head, _, err := headFiles(repo, readStatusCache(repo))
+	if err != nil {
+		return false, fmt.Errorf("failed to read HEAD: %w", err)
+	}
+	entries := idx.Entries()
+	if len(entries) != len(head) {
+		return true, nil
+	}
+	for _, entry := range entries {
+		if file, ok := head[entry.Path]; !ok || file.Mode != entry.Mode || file.ID != entry.ID {
+			return true, nil
 		}
 	}
 	return false, nil
 }
 
-func updateWorkingDirectory(repo *vcs.Repository, commitID objects.ObjectID, repoPath string) error {
-	// Read the commit
-	obj, err := repo.ReadObject(commitID)
-	if err != nil {
-		return err
-	}
-
-	commit, ok := obj.(*objects.Commit)
-	if !ok {
-		return fmt.Errorf("object is not a commit")
+// updateWorkingDirectory updates the working directory from the files of
+// fromID, which is zero on an unborn branch, to those of commitID
+func updateWorkingDirectory(repo *vcs.Repository, fromID, commitID objects.ObjectID, update *worktreeUpdate) error {
+	var fromTree objects.ObjectID
+	if !fromID.IsZero() {
+		from, err := repo.GetCommit(fromID)
+		if err != nil {
+			return err
+		}
+		fromTree = from.Tree()
 	}
 
-	// Read the tree
-	treeObj, err := repo.ReadObject(commit.Tree())
+	commit, err := repo.GetCommit(commitID)
 	if err != nil {
 		return err
 	}
-
-	tree, ok := treeObj.(*objects.Tree)
-	if !ok {
-		return fmt.Errorf("commit tree is not a tree object")
-	}
-
-	// Clear working directory (except .git and untracked files)
-	// For simplicity, we'll just remove files that exist in the tree
-	for _, entry := range tree.Entries() {
-		filePath := filepath.Join(repoPath, entry.Name)
-		os.Remove(filePath) // Ignore errors
-	}
-
-	// Extract files from tree
-	for _, entry := range tree.Entries() {
-		if entry.Mode == objects.ModeBlob || entry.Mode == objects.ModeExec {
-			if err := extractFile(repo, entry, repoPath); err != nil {
-				return fmt.Errorf("failed to extract file %s: %w", entry.Name, err)
-			}
-		}
-		// TODO: Handle subdirectories (trees within trees)
+	files, err := merge.ReadTree(repo, commit.Tree())
+	if err != nil {
+		return err
 	}
-
-	return nil
+	return updateWorktree(repo, fromTree, &merge.Result{Entries: files}, update)
 }
 
 func extractFile(repo *vcs.Repository, entry objects.TreeEntry, repoPath string) error {
@@ -222,6 +275,11 @@ func extractFile(repo *vcs.Repository, entry objects.TreeEntry, repoPath string)
 		return fmt.Errorf("tree entry is not a blob")
 	}
 
+	data, err := newConverter(repo, os.Stderr, nil).Smudge(entry.Name, blob.Data())
+	if err != nil {
+		return err
+	}
+
 	// Write file
 	filePath := filepath.Join(repoPath, entry.Name)
 	fileMode := os.FileMode(0644)
@@ -229,5 +287,5 @@ func extractFile(repo *vcs.Repository, entry objects.TreeEntry, repoPath string)
 		fileMode = os.FileMode(0755)
 	}
 
-	return os.WriteFile(filePath, blob.Data(), fileMode)
+	return os.WriteFile(filePath, data, fileMode)
 }
\ No newline at end of file
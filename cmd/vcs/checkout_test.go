@@ -149,6 +149,7 @@ func TestRunCheckout(t *testing.T) {
 			os.RemoveAll(filepath.Join(repo.GitDir(), "refs", "heads"))
 			os.MkdirAll(filepath.Join(repo.GitDir(), "refs", "heads"), 0755)
 			os.Remove(filepath.Join(repo.GitDir(), "HEAD"))
+			os.Remove(filepath.Join(repo.GitDir(), "index"))
 			
 			// Setup
 			var commit1ID objects.ObjectID
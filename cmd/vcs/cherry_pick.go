@@ -0,0 +1,638 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/config"
+	"github.com/fenilsonani/vcs/internal/core/index"
+	"github.com/fenilsonani/vcs/internal/core/merge"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// errCherryPickConflict is returned when a cherry-pick stops on a conflict
+var errCherryPickConflict = errors.New(`cherry-pick stopped on a conflict; resolve it and run "vcs cherry-pick --continue"`)
+
+type cherryPickOptions struct {
+	noCommit     bool
+	recordOrigin bool
+	continueOp   bool
+	abort        bool
+	reportFormat string
+	// from is a remote or URL to fetch the commits from first
+	from string
+}
+
+func newCherryPickCommand() *cobra.Command {
+	var opts cherryPickOptions
+
+	cmd := &cobra.Command{
+		Use:   "cherry-pick [flags] <commit>...",
+		Short: "Apply the changes introduced by some existing commits",
+		Long: `Applies the change each given commit introduces on top of HEAD and
+records a new commit for it. A range <from>..<to> picks the commits
+reachable from <to> but not from <from>, oldest first.
+
+If a commit cannot be applied cleanly the cherry-pick stops with the
+conflicts in the working tree. Resolve them, mark them with "vcs add" and
+run "vcs cherry-pick --continue", or use --abort to return to the state
+before the cherry-pick. Progress is kept in .git/sequencer.
+
+With -n the changes are applied to the working tree and index without
+committing. With -x a "(cherry picked from commit ...)" line is added to
+each commit message.
+
+With --from, the commits, given by their full IDs, are first fetched from
+another repository, a remote or a URL, along with the objects they need
+that are missing here. No refs are updated, so commits can be ported
+from a fork without adding it as a remote. They are asked for over
+protocol v2; a server only speaking v0 must allow commits that are not
+the tip of a branch to be fetched by ID.
+
+With --report=json a summary (new commits, conflicted paths with their
+conflict type, and whether the result is resolved) is written to stdout
+and other messages go to stderr.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := validateReportFormat(opts.reportFormat); err != nil {
+				return err
+			}
+			cmd.SilenceUsage = true
+
+			report, err := runCherryPick(cmd, args, opts)
+			if reportErr := writeReport(cmd.OutOrStdout(), opts.reportFormat, report); reportErr != nil && err == nil {
+				err = reportErr
+			}
+			return err
+		},
+	}
+
+	cmd.Flags().BoolVarP(&opts.noCommit, "no-commit", "n", false, "Apply the changes without committing")
+	cmd.Flags().BoolVarP(&opts.recordOrigin, "x", "x", false, "Append the original commit ID to the commit message")
+	cmd.Flags().BoolVar(&opts.continueOp, "continue", false, "Continue the cherry-pick after resolving conflicts")
+	cmd.Flags().BoolVar(&opts.abort, "abort", false, "Abort the cherry-pick and restore the original state")
+	cmd.Flags().StringVar(&opts.from, "from", "", "Fetch the commits by ID from this remote or URL first")
+	addReportFlag(cmd, &opts.reportFormat)
+
+	return cmd
+}
+
+func runCherryPick(cmd *cobra.Command, args []string, opts cherryPickOptions) (*operationReport, error) {
+	if opts.continueOp && opts.abort {
+		return nil, fmt.Errorf("--continue and --abort cannot be used together")
+	}
+	if (opts.continueOp || opts.abort) && (len(args) > 0 || opts.noCommit || opts.recordOrigin || opts.from != "") {
+		return nil, fmt.Errorf("--continue and --abort take no other arguments")
+	}
+
+	repoPath, err := findRepository()
+	if err != nil {
+		return nil, err
+	}
+	repo, err := openRepository(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	refManager := refs.NewRefManager(repo.GitDir())
+	out := reportOutput(cmd, opts.reportFormat)
+
+	switch {
+	case opts.abort:
+		state, err := loadSequencerState(repo)
+		if err != nil {
+			return nil, err
+		}
+		return abortCherryPick(repo, refManager, state)
+	case opts.continueOp:
+		state, err := loadSequencerState(repo)
+		if err != nil {
+			return nil, err
+		}
+		return continueCherryPick(out, repo, refManager, state)
+	default:
+		if len(args) == 0 {
+			return nil, fmt.Errorf("no commit given; specify the commits to cherry-pick")
+		}
+		if opts.from != "" {
+			if err := fetchCherryPicks(cmd, repo, opts.from, args); err != nil {
+				return nil, err
+			}
+		}
+		return startCherryPick(out, repo, refManager, args, opts)
+	}
+}
+
+// fetchCherryPicks fetches the commits args names, which must be full
+// commit IDs, from the remote or URL from
+func fetchCherryPicks(cmd *cobra.Command, repo *vcs.Repository, from string, args []string) error {
+	ids := make([]objects.ObjectID, 0, len(args))
+	for _, arg := range args {
+		id, err := objects.NewObjectID(arg)
+		if err != nil {
+			return fmt.Errorf("--from needs full commit IDs, not '%s'", arg)
+		}
+		ids = append(ids, id)
+	}
+	if err := fetchCommitsByID(cmd, repo, from, ids); err != nil {
+		return fmt.Errorf("failed to fetch from %s: %w", from, err)
+	}
+	return nil
+}
+
+// sequencerState is the progress of a cherry-pick, kept in .git/sequencer
+// so it can be continued after stopping on a conflict. While stopped the
+// first todo step is the one that conflicted.
+type sequencerState struct {
+	dir          string
+	head         objects.ObjectID
+	todo         []rebaseStep
+	done         []rebaseStep
+	noCommit     bool
+	recordOrigin bool
+}
+
+func sequencerDir(repo *vcs.Repository) string {
+	return filepath.Join(repo.GitDir(), "sequencer")
+}
+
+// loadSequencerState reads the state of the cherry-pick in progress
+func loadSequencerState(repo *vcs.Repository) (*sequencerState, error) {
+	dir := sequencerDir(repo)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil, fmt.Errorf("no cherry-pick in progress")
+	}
+
+	read := func(name string) (string, error) {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return "", fmt.Errorf("failed to read cherry-pick state: %w", err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+
+	state := &sequencerState{dir: dir}
+	head, err := read("head")
+	if err != nil {
+		return nil, err
+	}
+	if state.head, err = objects.NewObjectID(head); err != nil {
+		return nil, fmt.Errorf("invalid cherry-pick state head: %w", err)
+	}
+
+	todo, err := read("todo")
+	if err != nil {
+		return nil, err
+	}
+	if state.todo, err = parseRebaseTodo(todo, nil); err != nil {
+		return nil, err
+	}
+	done, err := read("done")
+	if err != nil {
+		return nil, err
+	}
+	if state.done, err = parseRebaseTodo(done, nil); err != nil {
+		return nil, err
+	}
+
+	opts, err := config.ReadFile(filepath.Join(dir, "opts"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read cherry-pick state: %w", err)
+	}
+	noCommit, _ := opts.Get("options.no-commit")
+	recordOrigin, _ := opts.Get("options.record-origin")
+	state.noCommit = noCommit == "true"
+	state.recordOrigin = recordOrigin == "true"
+
+	return state, nil
+}
+
+// save writes the state to .git/sequencer
+func (s *sequencerState) save() error {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create cherry-pick state: %w", err)
+	}
+
+	formatSteps := func(steps []rebaseStep) string {
+		var b strings.Builder
+		for _, step := range steps {
+			b.WriteString(step.String() + "\n")
+		}
+		return b.String()
+	}
+
+	files := map[string]string{
+		"head": s.head.String() + "\n",
+		"todo": formatSteps(s.todo),
+		"done": formatSteps(s.done),
+		"opts": fmt.Sprintf("[options]\n\tno-commit = %t\n\trecord-origin = %t\n", s.noCommit, s.recordOrigin),
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(s.dir, name), []byte(content), 0644); err != nil {
+			return fmt.Errorf("failed to write cherry-pick state: %w", err)
+		}
+	}
+	return nil
+}
+
+// resolveCherryPicks expands the arguments of cherry-pick into the commits
+// to apply, in order
+func resolveCherryPicks(repo *vcs.Repository, refManager *refs.RefManager, args []string) ([]*objects.Commit, error) {
+	var commits []*objects.Commit
+	for _, arg := range args {
+		if from, to, ok := strings.Cut(arg, ".."); ok {
+			fromID, err := resolveCommitish(repo, from)
+			if err != nil {
+				return nil, err
+			}
+			toID, err := resolveCommitish(repo, to)
+			if err != nil {
+				return nil, err
+			}
+			between, err := commitsBetween(repo, fromID, toID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to list commits in %s: %w", arg, err)
+			}
+			commits = append(commits, between...)
+			continue
+		}
+
+		id, err := resolveCommitish(repo, arg)
+		if err != nil {
+			return nil, err
+		}
+		commit, err := repo.GetCommit(id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read commit %s: %w", arg, err)
+		}
+		commits = append(commits, commit)
+	}
+
+	if len(commits) == 0 {
+		return nil, fmt.Errorf("empty commit set passed")
+	}
+	for _, commit := range commits {
+		if len(commit.Parents()) > 1 {
+			return nil, fmt.Errorf("commit %s is a merge and cannot be cherry-picked", commit.ID().Short())
+		}
+	}
+	return commits, nil
+}
+
+func startCherryPick(out io.Writer, repo *vcs.Repository, refManager *refs.RefManager, args []string, opts cherryPickOptions) (*operationReport, error) {
+	if fileExists(sequencerDir(repo)) {
+		return nil, fmt.Errorf(`a cherry-pick is already in progress; use "vcs cherry-pick --continue" or "--abort"`)
+	}
+	if _, ok, err := readMergeHead(repo); err != nil {
+		return nil, err
+	} else if ok {
+		return nil, fmt.Errorf("you have not concluded your merge (MERGE_HEAD exists)")
+	}
+	if dirty, err := hasUncommittedChanges(repo, refManager); err != nil {
+		return nil, err
+	} else if dirty {
+		return nil, fmt.Errorf("cannot cherry-pick: your index contains uncommitted changes")
+	}
+
+	headID, _, err := refManager.HEAD()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read HEAD: %w", err)
+	}
+	head, err := repo.GetCommit(headID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read HEAD commit: %w", err)
+	}
+
+	commits, err := resolveCherryPicks(repo, refManager, args)
+	if err != nil {
+		return nil, err
+	}
+
+	state := &sequencerState{
+		dir:          sequencerDir(repo),
+		head:         headID,
+		noCommit:     opts.noCommit,
+		recordOrigin: opts.recordOrigin,
+	}
+	for _, commit := range commits {
+		state.todo = append(state.todo, rebaseStep{action: "pick", id: commit.ID(), subject: commitSubject(commit)})
+	}
+	if err := state.save(); err != nil {
+		return nil, err
+	}
+
+	return runSequencer(out, repo, refManager, state, head.Tree())
+}
+
+// runSequencer applies the todo steps on top of oursTree, the tree of HEAD
+// or, without committing, of the changes picked so far
+func runSequencer(out io.Writer, repo *vcs.Repository, refManager *refs.RefManager, state *sequencerState, oursTree objects.ObjectID) (*operationReport, error) {
+	report := &operationReport{Operation: "cherry-pick"}
+
+	for len(state.todo) > 0 {
+		step := state.todo[0]
+		commit, err := repo.GetCommit(step.id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read commit %s: %w", step.id.Short(), err)
+		}
+
+		var baseTree objects.ObjectID
+		if parents := commit.Parents(); len(parents) > 0 {
+			parent, err := repo.GetCommit(parents[0])
+			if err != nil {
+				return nil, fmt.Errorf("failed to read parent of %s: %w", step.id.Short(), err)
+			}
+			baseTree = parent.Tree()
+		}
+
+		label := step.id.Short() + " (" + step.subject + ")"
+		result, err := merge.Trees(repo, baseTree, oursTree, commit.Tree(), merge.Options{
+			OursLabel:   "HEAD",
+			TheirsLabel: label,
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to apply %s: %w", step.id.Short(), err)
+		}
+		if err := checkoutMergeResult(repo, oursTree, result); err != nil {
+			return nil, fmt.Errorf("failed to update working directory: %w", err)
+		}
+
+		if !result.Clean() {
+			if err := writeMergeIndex(repo, result); err != nil {
+				return nil, err
+			}
+			if !state.noCommit {
+				if err := writeCherryPickHead(repo, step.id); err != nil {
+					return nil, err
+				}
+			}
+
+			printMergeConflicts(out, result.Conflicts, label)
+			if err := rerereConflicts(out, repo, result.Conflicts); err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(out, "error: could not apply %s... %s\n", step.id.Short(), step.subject)
+			fmt.Fprintf(out, "hint: After resolving the conflicts, mark them with\n")
+			fmt.Fprintf(out, "hint: \"vcs add <paths>\", then run \"vcs cherry-pick --continue\".\n")
+			fmt.Fprintf(out, "hint: To abort and get back to the state before \"vcs cherry-pick\",\n")
+			fmt.Fprintf(out, "hint: run \"vcs cherry-pick --abort\".\n")
+
+			if err := fillCherryPickReport(repo, refManager, state, report); err != nil {
+				return nil, err
+			}
+			report.Status = reportConflicted
+			report.Conflicts = newReportConflicts(result.Conflicts)
+			return report, errCherryPickConflict
+		}
+
+		treeID, err := merge.WriteTree(repo, result.Entries)
+		if err != nil {
+			return nil, fmt.Errorf("failed to write tree: %w", err)
+		}
+		if state.noCommit {
+			oursTree = treeID
+		} else {
+			if err := commitCherryPick(out, repo, refManager, state, commit, treeID); err != nil {
+				return nil, err
+			}
+			if oursTree, err = headTree(repo, refManager); err != nil {
+				return nil, err
+			}
+		}
+
+		state.todo = state.todo[1:]
+		state.done = append(state.done, step)
+		if err := state.save(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := fillCherryPickReport(repo, refManager, state, report); err != nil {
+		return nil, err
+	}
+
+	report.Status = reportCompleted
+	if state.noCommit {
+		// Leave the picked changes in the index for the next commit
+		files, err := merge.ReadTree(repo, oursTree)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read tree: %w", err)
+		}
+		if err := writeMergeIndex(repo, &merge.Result{Entries: files}); err != nil {
+			return nil, err
+		}
+		report.Status = reportStopped
+	}
+
+	if err := os.RemoveAll(state.dir); err != nil {
+		return nil, fmt.Errorf("failed to remove cherry-pick state: %w", err)
+	}
+	return report, nil
+}
+
+// commitCherryPick records the change of commit, applied as tree treeID,
+// on top of HEAD
+func commitCherryPick(out io.Writer, repo *vcs.Repository, refManager *refs.RefManager, state *sequencerState, commit *objects.Commit, treeID objects.ObjectID) error {
+	headID, branchRef, err := refManager.HEAD()
+	if err != nil {
+		return fmt.Errorf("failed to read HEAD: %w", err)
+	}
+	head, err := repo.GetCommit(headID)
+	if err != nil {
+		return fmt.Errorf("failed to read HEAD commit: %w", err)
+	}
+
+	if treeID == head.Tree() {
+		fmt.Fprintf(out, "skipping %s %s -- patch contents already applied\n", commit.ID().Short(), commitSubject(commit))
+		return removeCherryPickHead(repo)
+	}
+
+	message := commit.Message()
+	if state.recordOrigin {
+		message = strings.TrimRight(message, "\n") + "\n\n(cherry picked from commit " + commit.ID().String() + ")\n"
+	}
+
+	committer, err := getSignature("")
+	if err != nil {
+		return err
+	}
+	newCommit, err := repo.CreateCommit(treeID, []objects.ObjectID{headID}, commit.Author(), committer, message)
+	if err != nil {
+		return fmt.Errorf("failed to create commit: %w", err)
+	}
+
+	if branchRef == "" {
+		err = refManager.SetHEADToCommit(newCommit.ID())
+	} else {
+		err = refManager.WriteRef(branchRef, newCommit.ID(), nil)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update HEAD: %w", err)
+	}
+
+	branch := strings.TrimPrefix(branchRef, "refs/heads/")
+	if branchRef == "" {
+		branch = detachedHeadName
+	}
+	fmt.Fprintf(out, "[%s %s] %s\n", branch, newCommit.ID().Short(), commitSubject(commit))
+
+	if err := removeCherryPickHead(repo); err != nil {
+		return err
+	}
+	return resetIndexToHead(repo)
+}
+
+// continueCherryPick commits the resolved conflicts of the stopped step and
+// applies the rest of the todo list
+func continueCherryPick(out io.Writer, repo *vcs.Repository, refManager *refs.RefManager, state *sequencerState) (*operationReport, error) {
+	if len(state.todo) == 0 {
+		return nil, fmt.Errorf("no cherry-pick to continue")
+	}
+
+	idx := index.New()
+	if err := idx.ReadFromFile(filepath.Join(repo.GitDir(), "index")); err != nil {
+		return nil, fmt.Errorf("failed to read index: %w", err)
+	}
+	if unmerged := idx.Unmerged(); len(unmerged) > 0 {
+		return nil, fmt.Errorf("you must edit all merge conflicts and then mark them as resolved using vcs add: %s", strings.Join(unmerged, ", "))
+	}
+	if err := recordRerereResolutions(out, repo); err != nil {
+		return nil, err
+	}
+
+	var entries []merge.Entry
+	for _, e := range idx.Entries() {
+		entries = append(entries, merge.Entry{Path: e.Path, Mode: e.Mode, ID: e.ID})
+	}
+	treeID, err := merge.WriteTree(repo, entries)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write tree: %w", err)
+	}
+
+	step := state.todo[0]
+	oursTree := treeID
+	if !state.noCommit {
+		// Without CHERRY_PICK_HEAD the resolution was already committed
+		if fileExists(cherryPickHeadPath(repo)) {
+			commit, err := repo.GetCommit(step.id)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read commit %s: %w", step.id.Short(), err)
+			}
+			if err := commitCherryPick(out, repo, refManager, state, commit, treeID); err != nil {
+				return nil, err
+			}
+		}
+		if oursTree, err = headTree(repo, refManager); err != nil {
+			return nil, err
+		}
+	}
+
+	state.todo = state.todo[1:]
+	state.done = append(state.done, step)
+	if err := state.save(); err != nil {
+		return nil, err
+	}
+	return runSequencer(out, repo, refManager, state, oursTree)
+}
+
+// abortCherryPick restores HEAD and the working tree from before the
+// cherry-pick
+func abortCherryPick(repo *vcs.Repository, refManager *refs.RefManager, state *sequencerState) (*operationReport, error) {
+	if err := restoreWorkingTree(repo, state.head); err != nil {
+		return nil, err
+	}
+	if err := clearRerere(repo); err != nil {
+		return nil, err
+	}
+
+	_, branchRef, err := refManager.HEAD()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read HEAD: %w", err)
+	}
+	if branchRef == "" {
+		err = refManager.SetHEADToCommit(state.head)
+	} else {
+		err = refManager.WriteRef(branchRef, state.head, nil)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to update HEAD: %w", err)
+	}
+
+	if err := removeCherryPickHead(repo); err != nil {
+		return nil, err
+	}
+	if err := os.RemoveAll(state.dir); err != nil {
+		return nil, fmt.Errorf("failed to remove cherry-pick state: %w", err)
+	}
+
+	return &operationReport{
+		Operation: "cherry-pick",
+		Status:    reportAborted,
+		Head:      state.head.String(),
+	}, nil
+}
+
+// fillCherryPickReport records HEAD and the commits applied so far in
+// report. Without committing those are the picked commits themselves.
+func fillCherryPickReport(repo *vcs.Repository, refManager *refs.RefManager, state *sequencerState, report *operationReport) error {
+	headID, _, err := refManager.HEAD()
+	if err != nil {
+		return fmt.Errorf("failed to read HEAD: %w", err)
+	}
+	report.Head = headID.String()
+	report.Applied = nil
+
+	if state.noCommit {
+		for _, step := range state.done {
+			report.Applied = append(report.Applied, reportCommit{ID: step.id.String(), Subject: step.subject})
+		}
+		return nil
+	}
+
+	applied, err := commitsBetween(repo, state.head, headID)
+	if err != nil {
+		return fmt.Errorf("failed to list picked commits: %w", err)
+	}
+	for _, commit := range applied {
+		report.Applied = append(report.Applied, newReportCommit(commit))
+	}
+	return nil
+}
+
+// headTree returns the tree of the commit HEAD points to
+func headTree(repo *vcs.Repository, refManager *refs.RefManager) (objects.ObjectID, error) {
+	headID, _, err := refManager.HEAD()
+	if err != nil {
+		return objects.ObjectID{}, fmt.Errorf("failed to read HEAD: %w", err)
+	}
+	head, err := repo.GetCommit(headID)
+	if err != nil {
+		return objects.ObjectID{}, fmt.Errorf("failed to read HEAD commit: %w", err)
+	}
+	return head.Tree(), nil
+}
+
+func cherryPickHeadPath(repo *vcs.Repository) string {
+	return filepath.Join(repo.GitDir(), "CHERRY_PICK_HEAD")
+}
+
+// writeCherryPickHead records the commit whose conflicts are being resolved
+func writeCherryPickHead(repo *vcs.Repository, id objects.ObjectID) error {
+	if err := os.WriteFile(cherryPickHeadPath(repo), []byte(id.String()+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write CHERRY_PICK_HEAD: %w", err)
+	}
+	return nil
+}
+
+func removeCherryPickHead(repo *vcs.Repository) error {
+	if err := os.Remove(cherryPickHeadPath(repo)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove CHERRY_PICK_HEAD: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/index"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// setupCherryPickRepo creates the repository of setupRebaseRepo with main
+// checked out instead of topic
+func setupCherryPickRepo(t *testing.T, base, main map[string]string, topic ...map[string]string) *rebaseFixture {
+	f := setupRebaseRepo(t, base, main, topic...)
+	require.NoError(t, checkoutTree(f.repo, f.topic[len(f.topic)-1], f.main))
+	require.NoError(t, f.refs.SetHEAD("refs/heads/main"))
+	return f
+}
+
+func runCherryPickArgs(args ...string) (string, string, error) {
+	cmd := newCherryPickCommand()
+	var stdout, stderr bytes.Buffer
+	cmd.SetOut(&stdout)
+	cmd.SetErr(&stderr)
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+	return stdout.String(), stderr.String(), err
+}
+
+func TestCherryPickRange(t *testing.T) {
+	f := setupCherryPickRepo(t,
+		map[string]string{"a.txt": "base\n"},
+		map[string]string{"a.txt": "base\n", "main.txt": "main\n"},
+		map[string]string{"a.txt": "topic\n"},
+		map[string]string{"a.txt": "topic\n", "t.txt": "t\n"},
+	)
+	first, err := f.repo.GetCommit(f.topic[0])
+	require.NoError(t, err)
+	base := first.Parents()[0]
+
+	out, _, err := runCherryPickArgs("-x", base.String()+"..topic")
+	require.NoError(t, err)
+	assert.Contains(t, out, "topic 1")
+	assert.Contains(t, out, "topic 2")
+
+	commits := f.branchHistory(t, "refs/heads/main", f.main)
+	require.Len(t, commits, 2)
+	assert.Equal(t, "topic 2\n\n(cherry picked from commit "+f.topic[1].String()+")\n", commits[0].Message())
+	assert.Equal(t, "topic 1\n\n(cherry picked from commit "+f.topic[0].String()+")\n", commits[1].Message())
+	assert.Equal(t, "Test", commits[1].Author().Name)
+
+	assert.Equal(t, "topic\n", f.readFile(t, "a.txt"))
+	assert.Equal(t, "main\n", f.readFile(t, "main.txt"))
+	assert.Equal(t, "t\n", f.readFile(t, "t.txt"))
+	assert.NoDirExists(t, filepath.Join(f.repo.GitDir(), "sequencer"))
+
+	head, err := f.refs.SymbolicHEAD()
+	require.NoError(t, err)
+	assert.Equal(t, "refs/heads/main", head)
+}
+
+func TestCherryPickConflictContinue(t *testing.T) {
+	f := setupCherryPickRepo(t,
+		map[string]string{"a.txt": "base\n"},
+		map[string]string{"a.txt": "main\n"},
+		map[string]string{"a.txt": "topic\n"},
+		map[string]string{"a.txt": "topic\n", "t.txt": "t\n"},
+	)
+
+	stdout, stderr, err := runCherryPickArgs("--report=json", f.topic[0].String(), f.topic[1].String())
+	require.ErrorIs(t, err, errCherryPickConflict)
+	assert.Contains(t, stderr, "CONFLICT (content): Merge conflict in a.txt")
+	assert.Contains(t, stderr, "could not apply "+f.topic[0].Short()+"... topic 1")
+
+	var report operationReport
+	require.NoError(t, json.Unmarshal([]byte(stdout), &report))
+	assert.Equal(t, "cherry-pick", report.Operation)
+	assert.Equal(t, reportConflicted, report.Status)
+	assert.Equal(t, f.main.String(), report.Head)
+	assert.False(t, report.Resolved)
+	require.Len(t, report.Conflicts, 1)
+	assert.Equal(t, "a.txt", report.Conflicts[0].Path)
+
+	assert.FileExists(t, filepath.Join(f.repo.GitDir(), "CHERRY_PICK_HEAD"))
+	assert.FileExists(t, filepath.Join(f.repo.GitDir(), "sequencer", "todo"))
+	assert.Contains(t, f.readFile(t, "a.txt"), "<<<<<<< HEAD\nmain\n=======\ntopic\n>>>>>>> "+f.topic[0].Short())
+
+	_, _, err = runCherryPickArgs(f.topic[1].String())
+	assert.ErrorContains(t, err, "already in progress")
+	_, _, err = runCherryPickArgs("--continue")
+	assert.ErrorContains(t, err, "mark them as resolved")
+
+	require.NoError(t, os.WriteFile(filepath.Join(f.repo.WorkDir(), "a.txt"), []byte("resolved\n"), 0644))
+	addCmd := newAddCommand()
+	addCmd.SetOut(&bytes.Buffer{})
+	addCmd.SetArgs([]string{"a.txt"})
+	require.NoError(t, addCmd.Execute())
+
+	stdout, _, err = runCherryPickArgs("--continue", "--report=json")
+	require.NoError(t, err)
+	report = operationReport{}
+	require.NoError(t, json.Unmarshal([]byte(stdout), &report))
+	assert.Equal(t, reportCompleted, report.Status)
+	assert.True(t, report.Resolved)
+	require.Len(t, report.Applied, 2)
+	assert.Equal(t, "topic 1", report.Applied[0].Subject)
+
+	commits := f.branchHistory(t, "refs/heads/main", f.main)
+	require.Len(t, commits, 2)
+	assert.Equal(t, "topic 1\n", commits[1].Message())
+	assert.Equal(t, "resolved\n", f.readFile(t, "a.txt"))
+	assert.Equal(t, "t\n", f.readFile(t, "t.txt"))
+	assert.NoFileExists(t, filepath.Join(f.repo.GitDir(), "CHERRY_PICK_HEAD"))
+	assert.NoDirExists(t, filepath.Join(f.repo.GitDir(), "sequencer"))
+}
+
+func TestCherryPickAbort(t *testing.T) {
+	f := setupCherryPickRepo(t,
+		map[string]string{"a.txt": "base\n"},
+		map[string]string{"a.txt": "main\n"},
+		map[string]string{"a.txt": "base\n", "new.txt": "new\n"},
+		map[string]string{"a.txt": "topic\n", "new.txt": "new\n"},
+	)
+
+	_, _, err := runCherryPickArgs(f.topic[0].String(), f.topic[1].String())
+	require.ErrorIs(t, err, errCherryPickConflict)
+	assert.Len(t, f.branchHistory(t, "refs/heads/main", f.main), 1)
+
+	_, _, err = runCherryPickArgs("--abort")
+	require.NoError(t, err)
+
+	id, err := f.refs.ResolveRef("refs/heads/main")
+	require.NoError(t, err)
+	assert.Equal(t, f.main, id)
+	assert.Equal(t, "main\n", f.readFile(t, "a.txt"))
+	assert.NoFileExists(t, filepath.Join(f.repo.WorkDir(), "new.txt"))
+	assert.NoFileExists(t, filepath.Join(f.repo.GitDir(), "CHERRY_PICK_HEAD"))
+	assert.NoDirExists(t, filepath.Join(f.repo.GitDir(), "sequencer"))
+
+	_, _, err = runCherryPickArgs("--abort")
+	assert.ErrorContains(t, err, "no cherry-pick in progress")
+}
+
+func TestCherryPickNoCommit(t *testing.T) {
+	f := setupCherryPickRepo(t,
+		map[string]string{"a.txt": "base\n"},
+		map[string]string{"a.txt": "base\n", "main.txt": "main\n"},
+		map[string]string{"a.txt": "topic\n"},
+		map[string]string{"a.txt": "topic\n", "t.txt": "t\n"},
+	)
+
+	stdout, _, err := runCherryPickArgs("-n", "--report=json", f.topic[0].String(), f.topic[1].String())
+	require.NoError(t, err)
+
+	var report operationReport
+	require.NoError(t, json.Unmarshal([]byte(stdout), &report))
+	assert.Equal(t, reportStopped, report.Status)
+	require.Len(t, report.Applied, 2)
+	assert.Equal(t, f.topic[1].String(), report.Applied[1].ID)
+
+	id, err := f.refs.ResolveRef("refs/heads/main")
+	require.NoError(t, err)
+	assert.Equal(t, f.main, id)
+	assert.Equal(t, "topic\n", f.readFile(t, "a.txt"))
+	assert.Equal(t, "t\n", f.readFile(t, "t.txt"))
+
+	idx := index.New()
+	require.NoError(t, idx.ReadFromFile(filepath.Join(f.repo.GitDir(), "index")))
+	_, staged := idx.Get("t.txt")
+	assert.True(t, staged)
+	assert.NoDirExists(t, filepath.Join(f.repo.GitDir(), "sequencer"))
+}
+
+func TestCherryPickRejectsMerge(t *testing.T) {
+	f := setupCherryPickRepo(t,
+		map[string]string{"a.txt": "base\n"},
+		map[string]string{"a.txt": "base\n", "main.txt": "main\n"},
+		map[string]string{"a.txt": "topic\n"},
+	)
+	mergeID := commitFiles(t, f.repo, map[string]string{"a.txt": "topic\n", "main.txt": "main\n"},
+		[]objects.ObjectID{f.main, f.topic[0]}, "merge\n")
+
+	_, _, err := runCherryPickArgs(mergeID.String())
+	assert.ErrorContains(t, err, "is a merge")
+	_, _, err = runCherryPickArgs("nope")
+	assert.ErrorContains(t, err, `invalid revision "nope"`)
+	assert.NoDirExists(t, filepath.Join(f.repo.GitDir(), "sequencer"))
+}
+
+func TestCherryPickFromFork(t *testing.T) {
+	isolateConfig(t)
+	f := setupCherryPickRepo(t,
+		map[string]string{"a.txt": "base\n"},
+		map[string]string{"a.txt": "base\n", "main.txt": "main\n"},
+		map[string]string{"a.txt": "base\n", "t.txt": "t\n"},
+	)
+
+	// The fix is buried under a later commit on a branch of the fork
+	fork, err := vcs.Init(filepath.Join(t.TempDir(), "fork"))
+	require.NoError(t, err)
+	base := commitFiles(t, fork, map[string]string{"a.txt": "base\n"}, nil, "base\n")
+	fix := commitFiles(t, fork, map[string]string{"a.txt": "fixed\n"}, []objects.ObjectID{base}, "fix a\n")
+	later := commitFiles(t, fork, map[string]string{"a.txt": "fixed\n", "b.txt": "later\n"}, []objects.ObjectID{fix}, "later\n")
+	forkRefs := refs.NewRefManager(fork.GitDir())
+	require.NoError(t, forkRefs.UpdateRef("refs/heads/main", later))
+	require.NoError(t, forkRefs.SetHEAD("refs/heads/main"))
+	_, err = runConfigArgs("remote.fork.url", fork.WorkDir())
+	require.NoError(t, err)
+
+	_, _, err = runCherryPickArgs("--from", fork.WorkDir(), fix.String()[:7])
+	assert.ErrorContains(t, err, "--from needs full commit IDs")
+
+	out, _, err := runCherryPickArgs("-x", "--from", "fork", fix.String())
+	require.NoError(t, err)
+	assert.Contains(t, out, "fix a")
+
+	commits := f.branchHistory(t, "refs/heads/main", f.main)
+	require.Len(t, commits, 1)
+	assert.Equal(t, "fix a\n\n(cherry picked from commit "+fix.String()+")\n", commits[0].Message())
+	assert.Equal(t, "fixed\n", f.readFile(t, "a.txt"))
+	assert.Equal(t, "main\n", f.readFile(t, "main.txt"))
+
+	assert.True(t, f.repo.HasObject(fix))
+	assert.False(t, f.repo.HasObject(later), "only the picked commit is fetched")
+	_, err = f.refs.ResolveRef("refs/remotes/fork/main")
+	assert.Error(t, err, "no refs are updated")
+}
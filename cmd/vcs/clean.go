@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/config"
+	"github.com/fenilsonani/vcs/internal/core/workdir"
+)
+
+type cleanOptions struct {
+	dryRun      bool
+	force       bool
+	dirs        bool
+	ignored     bool
+	onlyIgnored bool
+	interactive bool
+	quiet       bool
+}
+
+func newCleanCommand() *cobra.Command {
+	var opts cleanOptions
+
+	cmd := &cobra.Command{
+		Use:   "clean [flags] [<path>...]",
+		Short: "Remove untracked files from the working tree",
+		Long: `Removes the files that are neither staged nor committed in HEAD, starting
+from the top of the working tree or only under the given paths.
+
+Untracked directories are left alone unless -d is given, in which case
+they are removed as a whole. Files matched by .gitignore are kept; -x
+removes them too and -X removes only them, such as build output.
+Nested repositories are never removed.
+
+As this cannot be undone, clean refuses to run unless -f, -n or -i is
+given, or clean.requireForce is set to false. Use -n to see what would
+be removed, and -i to be asked about each path.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if opts.ignored && opts.onlyIgnored {
+				return fmt.Errorf("-x and -X cannot be used together")
+			}
+			return runClean(cmd, args, opts)
+		},
+	}
+
+	cmd.Flags().BoolVarP(&opts.dryRun, "dry-run", "n", false, "Only show what would be removed")
+	cmd.Flags().BoolVarP(&opts.force, "force", "f", false, "Remove the files, as clean.requireForce asks")
+	cmd.Flags().BoolVarP(&opts.dirs, "dirs", "d", false, "Remove untracked directories too")
+	cmd.Flags().BoolVarP(&opts.ignored, "ignored", "x", false, "Remove ignored files as well as untracked ones")
+	cmd.Flags().BoolVarP(&opts.onlyIgnored, "only-ignored", "X", false, "Remove only ignored files")
+	cmd.Flags().BoolVarP(&opts.interactive, "interactive", "i", false, "Ask before removing each path")
+	cmd.Flags().BoolVarP(&opts.quiet, "quiet", "q", false, "Do not list the removed paths")
+
+	return cmd
+}
+
+func runClean(cmd *cobra.Command, args []string, opts cleanOptions) error {
+	repoPath, err := findRepository()
+	if err != nil {
+		return fmt.Errorf("not a git repository: %w", err)
+	}
+	repo, err := openRepository(repoPath)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	if !opts.force && !opts.dryRun && !opts.interactive {
+		requireForce := true
+		if value, ok := loadConfig(repo.GitDir()).Get("clean.requireForce"); ok {
+			if requireForce, err = config.ParseBool(value); err != nil {
+				return fmt.Errorf("invalid clean.requireForce: %w", err)
+			}
+		}
+		if requireForce {
+			return fmt.Errorf("clean.requireForce defaults to true and neither -i, -n, nor -f given; refusing to clean")
+		}
+	}
+
+	scanner := newScanner(repo)
+
+	// Files in the index are tracked
+	idx, err := readIndex(repo)
+	if err != nil {
+		return err
+	}
+	tracked := make(map[string]bool, len(idx.Entries()))
+	for _, entry := range idx.Entries() {
+		tracked[entry.Path] = true
+	}
+
+	files, err := scanner.ScanWorkingDirectory()
+	if err != nil {
+		return fmt.Errorf("failed to scan working directory: %w", err)
+	}
+
+	var pathspecs []string
+	for _, arg := range args {
+		pathspecs = append(pathspecs, filepath.ToSlash(filepath.Clean(arg)))
+	}
+
+	paths, skipped := cleanCandidates(files, tracked, scanner.IsIgnored, pathspecs, opts)
+	out := cmd.OutOrStdout()
+	for _, p := range skipped {
+		if !opts.quiet {
+			fmt.Fprintf(out, "Skipping repository %s\n", p)
+		}
+	}
+
+	var input *bufio.Reader
+	if opts.interactive {
+		input = bufio.NewReader(cmd.InOrStdin())
+	}
+	for _, p := range paths {
+		if opts.dryRun {
+			fmt.Fprintf(out, "Would remove %s\n", p)
+			continue
+		}
+		if input != nil && !confirm(out, input, fmt.Sprintf("Remove %s [y/N]? ", p)) {
+			continue
+		}
+
+		full := filepath.Join(repoPath, filepath.FromSlash(strings.TrimSuffix(p, "/")))
+		if strings.HasSuffix(p, "/") {
+			err = os.RemoveAll(full)
+		} else {
+			err = os.Remove(full)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to remove %s: %w", p, err)
+		}
+		if !opts.quiet {
+			fmt.Fprintf(out, "Removing %s\n", p)
+		}
+	}
+	return nil
+}
+
+// cleanCandidates returns the paths clean removes out of files, as scanned
+// from the working tree, directories ending in "/", and the nested
+// repositories it skips. A directory is removed as a whole, with -d, only
+// when nothing under it is to be kept.
+func cleanCandidates(files []workdir.FileInfo, tracked map[string]bool, ignored func(string) bool, pathspecs []string, opts cleanOptions) (paths, skipped []string) {
+	selected := func(p string) bool {
+		switch {
+		case opts.ignored:
+			return true
+		case opts.onlyIgnored:
+			return ignored(p)
+		}
+		return !ignored(p)
+	}
+
+	// Directories holding anything that stays cannot be removed whole, and
+	// ones holding tracked files are not untracked directories at all
+	keep := make(map[string]bool)
+	hasTracked := make(map[string]bool)
+	parents := func(p string, set map[string]bool) {
+		for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+			set[dir] = true
+		}
+	}
+	for p := range tracked {
+		parents(p, hasTracked)
+		parents(p, keep)
+	}
+	for _, file := range files {
+		if file.IsRepo || (!file.IsDir && !tracked[file.Path] && !selected(file.Path)) {
+			parents(file.Path, keep)
+		}
+	}
+
+	// Files come in walk order, each directory before its contents
+	var done []string
+	for _, file := range files {
+		// Directories on the way to a pathspec are looked into, but only
+		// what the pathspecs cover is removed
+		if insideAny(file.Path, done) || !matchPathspecs(file.Path, pathspecs) {
+			continue
+		}
+		switch {
+		case file.IsRepo:
+			skipped = append(skipped, file.Path+"/")
+			done = append(done, file.Path)
+		case file.IsDir:
+			switch {
+			case hasTracked[file.Path]:
+			case !opts.dirs:
+				// Untracked directories are left alone
+				done = append(done, file.Path)
+			case keep[file.Path]:
+			case selected(file.Path):
+				paths = append(paths, file.Path+"/")
+				done = append(done, file.Path)
+			}
+		case !tracked[file.Path] && selected(file.Path):
+			paths = append(paths, file.Path)
+		}
+	}
+	return paths, skipped
+}
+
+// insideAny reports whether p is inside one of dirs
+func insideAny(p string, dirs []string) bool {
+	for _, dir := range dirs {
+		if strings.HasPrefix(p, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// matchPathspecs reports whether p is one of pathspecs or inside one, as
+// every path is when there are none
+func matchPathspecs(p string, pathspecs []string) bool {
+	if len(pathspecs) == 0 {
+		return true
+	}
+	for _, spec := range pathspecs {
+		if spec == "." || p == spec || strings.HasPrefix(p, spec+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// confirm asks question and reports whether the answer read from input is
+// yes
+func confirm(out io.Writer, input *bufio.Reader, question string) bool {
+	fmt.Fprint(out, question)
+	answer, _ := input.ReadString('\n')
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes"
+}
@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// setupCleanRepo creates a repository with a committed and a staged file,
+// and untracked and ignored files and directories around them
+func setupCleanRepo(t *testing.T) {
+	repo, _ := setupConfigRepo(t)
+	_, err := stageAndCommit(t, repo, "tracked.txt", "-m", "initial")
+	require.NoError(t, err)
+
+	files := map[string]string{
+		".gitignore":       "build/\n*.log\n",
+		"new.txt":          "new\n",
+		"debug.log":        "log\n",
+		"build/out.o":      "object\n",
+		"tmp/a.txt":        "a\n",
+		"tmp/b.log":        "b\n",
+		"src/staged.txt":   "staged\n",
+		"src/extra.txt":    "extra\n",
+		"nested/.git/HEAD": "ref: refs/heads/main\n",
+	}
+	for name, content := range files {
+		require.NoError(t, os.MkdirAll(filepath.Dir(name), 0755))
+		require.NoError(t, os.WriteFile(name, []byte(content), 0644))
+	}
+	_, err = runCommandArgs(newAddCommand(), "src/staged.txt")
+	require.NoError(t, err)
+}
+
+// cleanLines runs clean with args and returns its output lines
+func cleanLines(t *testing.T, args ...string) []string {
+	out, err := runCommandArgs(newCleanCommand(), args...)
+	require.NoError(t, err)
+	return strings.Split(strings.TrimSpace(out), "\n")
+}
+
+func TestCleanDryRun(t *testing.T) {
+	setupCleanRepo(t)
+
+	assert.Equal(t, []string{
+		"Skipping repository nested/",
+		"Would remove .gitignore",
+		"Would remove new.txt",
+		"Would remove src/extra.txt",
+	}, cleanLines(t, "-n"))
+
+	// Untracked directories are only looked into with -d, ignored files
+	// inside them kept
+	assert.Equal(t, []string{
+		"Skipping repository nested/",
+		"Would remove .gitignore",
+		"Would remove new.txt",
+		"Would remove src/extra.txt",
+		"Would remove tmp/a.txt",
+	}, cleanLines(t, "-n", "-d"))
+
+	assert.Equal(t, []string{
+		"Skipping repository nested/",
+		"Would remove .gitignore",
+		"Would remove build/",
+		"Would remove debug.log",
+		"Would remove new.txt",
+		"Would remove src/extra.txt",
+		"Would remove tmp/",
+	}, cleanLines(t, "-n", "-d", "-x"))
+
+	assert.Equal(t, []string{
+		"Skipping repository nested/",
+		"Would remove build/",
+		"Would remove debug.log",
+		"Would remove tmp/b.log",
+	}, cleanLines(t, "-n", "-d", "-X"))
+
+	assert.Equal(t, []string{"Would remove tmp/a.txt"}, cleanLines(t, "-n", "-d", "-q", "tmp"))
+
+	// Nothing was removed
+	assert.FileExists(t, "new.txt")
+	assert.FileExists(t, "build/out.o")
+}
+
+func TestCleanForce(t *testing.T) {
+	setupCleanRepo(t)
+
+	_, err := runCommandArgs(newCleanCommand())
+	assert.ErrorContains(t, err, "refusing to clean")
+	assert.FileExists(t, "new.txt")
+
+	out := cleanLines(t, "-f", "-d", "-x")
+	assert.Contains(t, out, "Removing build/")
+	for _, name := range []string{".gitignore", "new.txt", "debug.log", "build", "tmp", "src/extra.txt"} {
+		assert.NoFileExists(t, name)
+		assert.NoDirExists(t, name)
+	}
+	for _, name := range []string{"tracked.txt", "src/staged.txt", "nested/.git/HEAD"} {
+		assert.FileExists(t, name)
+	}
+
+	// Without clean.requireForce, -f is not needed
+	require.NoError(t, os.WriteFile("other.txt", nil, 0644))
+	_, err = runConfigArgs("clean.requireForce", "false")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Skipping repository nested/", "Removing other.txt"}, cleanLines(t))
+}
+
+func TestCleanInteractive(t *testing.T) {
+	setupCleanRepo(t)
+
+	cmd := newCleanCommand()
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+	cmd.SetIn(strings.NewReader("y\nn\nyes\n"))
+	cmd.SetArgs([]string{"-i"})
+	require.NoError(t, cmd.Execute())
+
+	assert.Contains(t, out.String(), "Remove new.txt [y/N]? ")
+	assert.NoFileExists(t, ".gitignore")
+	assert.FileExists(t, "new.txt")
+	assert.NoFileExists(t, "src/extra.txt")
+
+	_, err := runCommandArgs(newCleanCommand(), "-x", "-X", "-n")
+	assert.ErrorContains(t, err, "-x and -X cannot be used together")
+}
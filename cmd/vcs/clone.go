@@ -2,19 +2,28 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/spf13/cobra"
 
+	"github.com/fenilsonani/vcs/internal/core/bundle"
+	"github.com/fenilsonani/vcs/internal/core/merge"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/internal/progress"
+	"github.com/fenilsonani/vcs/internal/transport"
 	"github.com/fenilsonani/vcs/pkg/vcs"
 )
 
 func newCloneCommand() *cobra.Command {
 	var (
-		bare   bool
-		depth  int
-		branch string
+		bare              bool
+		depth             int
+		branch            string
+		recurseSubmodules bool
 	)
 
 	cmd := &cobra.Command{
@@ -35,18 +44,39 @@ and sets up the remote configuration.`,
 				directory = getDirectoryNameFromURL(repository)
 			}
 
-			return runClone(repository, directory, bare, depth, branch)
+			return runClone(cmd, repository, directory, bare, depth, branch, recurseSubmodules)
 		},
 	}
 
 	cmd.Flags().BoolVar(&bare, "bare", false, "Create a bare repository")
 	cmd.Flags().IntVar(&depth, "depth", 0, "Create a shallow clone with truncated history")
-	cmd.Flags().StringVarP(&branch, "branch", "b", "", "Checkout specific branch instead of default")
+	cmd.Flags().StringVarP(&branch, "branch", "b", "", "Checkout specific branch instead of the remote's HEAD")
+	cmd.Flags().BoolVar(&recurseSubmodules, "recurse-submodules", false, "Initialize and check out the submodules of the clone, recursively")
+	cmd.Flags().String("limit-rate", "", "Cap transfer bandwidth in bytes per second, with an optional k, m or g suffix (overrides transfer.rateLimit)")
+	cmd.Flags().Bool("progress", false, "Show progress even when standard error is not a terminal")
 
 	return cmd
 }
 
-func runClone(repository, directory string, bare bool, depth int, branch string) error {
+func runClone(cmd *cobra.Command, repository, directory string, bare bool, depth int, branch string, recurseSubmodules bool) (err error) {
+	if depth < 0 {
+		return fmt.Errorf("depth %d is not a positive number", depth)
+	}
+
+	// Remember local repositories by absolute path, so the clone can still
+	// reach them from its own directory
+	local := isLocalURL(repository)
+	if local {
+		path, err := filepath.Abs(localURLPath(repository))
+		if err != nil {
+			return fmt.Errorf("failed to resolve %s: %w", repository, err)
+		}
+		if _, err := localGitDir(path); err != nil && !bundle.IsBundle(path) {
+			return fmt.Errorf("repository '%s' does not exist", repository)
+		}
+		repository = path
+	}
+
 	// Check if directory already exists
 	if _, err := os.Stat(directory); err == nil {
 		return fmt.Errorf("destination path '%s' already exists", directory)
@@ -59,10 +89,17 @@ func runClone(repository, directory string, bare bool, depth int, branch string)
 		return fmt.Errorf("failed to create directory: %w", err)
 	}
 
+	// A clone that fails before its branch is checked out leaves nothing
+	// behind, as Git's does
+	checkedOut := false
+	defer func() {
+		if err != nil && !checkedOut {
+			os.RemoveAll(directory)
+		}
+	}()
+
 	// Initialize repository
 	var repo *vcs.Repository
-	var err error
-
 	if bare {
 		// For bare repositories, the directory itself is the git directory
 		repo, err = initBareRepository(directory)
@@ -71,7 +108,6 @@ func runClone(repository, directory string, bare bool, depth int, branch string)
 	}
 
 	if err != nil {
-		os.RemoveAll(directory) // Clean up on failure
 		return fmt.Errorf("failed to initialize repository: %w", err)
 	}
 
@@ -80,6 +116,33 @@ func runClone(repository, directory string, bare bool, depth int, branch string)
 		return fmt.Errorf("failed to add remote: %w", err)
 	}
 
+	// Fetch objects over HTTP when the remote supports it
+	if local || isHTTPURL(repository) {
+		discovery, err := fetchRefsWithHTTPTransport(cmd, repo, "origin", repository, shallowOptions{depth: depth}, false)
+		if err != nil {
+			return fmt.Errorf("failed to fetch: %w", err)
+		}
+
+		checkedOut, err = checkoutClonedBranch(repo, discovery, branch, bare, transferProgress(cmd))
+		if err != nil {
+			return err
+		}
+		if checkedOut {
+			if !bare {
+				headID, _, _ := refs.NewRefManager(repo.GitDir()).HEAD()
+				runPostCheckoutHook(cmd.ErrOrStderr(), repo.WorkDir(), repo.GitDir(), objects.ObjectID{}, headID, true)
+			}
+			if recurseSubmodules && !bare {
+				return updateClonedSubmodules(cmd, repo)
+			}
+			return nil
+		}
+		if local {
+			fmt.Fprintln(cmd.ErrOrStderr(), "warning: You appear to have cloned an empty repository.")
+			return nil
+		}
+	}
+
 	if !bare {
 		// In a real implementation, this would:
 		// 1. Fetch objects from remote
@@ -104,6 +167,99 @@ func runClone(repository, directory string, bare bool, depth int, branch string)
 	return nil
 }
 
+// checkoutClonedBranch creates the local branch for a fetched remote branch
+// and checks it out: the branch given with -b, or else the one the remote
+// HEAD points to. It also points origin/HEAD at the remote's HEAD branch. It
+// reports false when nothing was fetched. The files written are counted on
+// out, when it is not nil.
+func checkoutClonedBranch(repo *vcs.Repository, discovery *transport.RefDiscovery, branch string, bare bool, out io.Writer) (bool, error) {
+	refManager := refs.NewRefManager(repo.GitDir())
+
+	var remoteHead string
+	if discovery != nil {
+		remoteHead = strings.TrimPrefix(discovery.HeadRef(), "refs/heads/")
+	}
+	if remoteHead != "" && refManager.RefExists("refs/remotes/origin/"+remoteHead) {
+		if err := refManager.SetSymbolicRef("refs/remotes/origin/HEAD", "refs/remotes/origin/"+remoteHead); err != nil {
+			return false, fmt.Errorf("failed to update origin/HEAD: %w", err)
+		}
+	}
+
+	// Without HEAD information from the remote, fall back to the usual names
+	candidates := []string{"main", "master"}
+	switch {
+	case branch != "":
+		candidates = []string{branch}
+	case remoteHead != "":
+		candidates = []string{remoteHead}
+	}
+
+	var commitID objects.ObjectID
+	var branchName string
+	for _, name := range candidates {
+		id, err := refManager.ResolveRef("refs/remotes/origin/" + name)
+		if err == nil && repo.HasObject(id) {
+			commitID, branchName = id, name
+			break
+		}
+	}
+
+	if branchName == "" {
+		if branch != "" {
+			return false, fmt.Errorf("remote branch %s not found in upstream origin", branch)
+		}
+		if remoteHead != "" {
+			// An empty remote still names its unborn default branch
+			if err := refManager.SetHEAD("refs/heads/" + remoteHead); err != nil {
+				return false, fmt.Errorf("failed to update HEAD: %w", err)
+			}
+		}
+		return false, nil
+	}
+
+	if err := refManager.UpdateRef("refs/heads/"+branchName, commitID); err != nil {
+		return false, fmt.Errorf("failed to create branch %s: %w", branchName, err)
+	}
+	if err := refManager.SetHEAD("refs/heads/" + branchName); err != nil {
+		return false, fmt.Errorf("failed to update HEAD: %w", err)
+	}
+	logRefUpdate(refManager, "refs/heads/"+branchName, objects.ObjectID{}, commitID, "clone: from origin")
+
+	if bare {
+		return true, nil
+	}
+	if err := setUpstreamBranch(repo, branchName, "origin", branchName); err != nil {
+		return false, fmt.Errorf("failed to set upstream of %s: %w", branchName, err)
+	}
+
+	commit, err := repo.GetCommit(commitID)
+	if err != nil {
+		return false, fmt.Errorf("failed to read commit %s: %w", commitID.Short(), err)
+	}
+	tree, err := repo.GetTree(commit.Tree())
+	if err != nil {
+		return false, fmt.Errorf("failed to read tree: %w", err)
+	}
+	files, err := merge.ReadTree(repo, commit.Tree())
+	if err != nil {
+		return false, err
+	}
+	var meter *progress.Meter
+	if len(files) > 0 {
+		meter = progress.New(out, "Updating files", len(files))
+	}
+	conv := newConverter(repo, os.Stderr, treeAttributes(repo, tree))
+	if err := extractTree(repo, conv, tree, repo.WorkDir(), "", meter); err != nil {
+		return false, err
+	}
+	meter.Done()
+	if err := resetIndex(repo, commit); err != nil {
+		return false, err
+	}
+
+	return true, nil
+}
+
 func initBareRepository(path string) (*vcs.Repository, error) {
 	// Create git directories
 	dirs := []string{"objects/info", "objects/pack", "refs/heads", "refs/tags", "hooks", "info"}
@@ -139,9 +295,8 @@ func initBareRepository(path string) (*vcs.Repository, error) {
 		return nil, fmt.Errorf("failed to create description file: %w", err)
 	}
 
-	// Return a basic repository reference
-	// In a full implementation, this would properly initialize the repository
-	return vcs.Open(path)
+	// The directory is both the work tree and the repository directory
+	return vcs.OpenWithGitDir(path, path)
 }
 
 func getDirectoryNameFromURL(url string) string {
@@ -149,9 +304,9 @@ func getDirectoryNameFromURL(url string) string {
 	// e.g., "https://github.com/user/repo.git" -> "repo"
 	//       "git@github.com:user/repo.git" -> "repo"
 	
-	// Remove .git suffix if present
-	if filepath.Ext(url) == ".git" {
-		url = url[:len(url)-4]
+	// Remove .git suffix if present, or .bundle from a bundle file
+	if ext := filepath.Ext(url); ext == ".git" || ext == ".bundle" {
+		url = strings.TrimSuffix(url, ext)
 	}
 	
 	// Get the last component
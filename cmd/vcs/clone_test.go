@@ -2,13 +2,19 @@ package main
 
 import (
 	"bytes"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/internal/transport"
 	"github.com/fenilsonani/vcs/pkg/vcs"
 )
 
@@ -428,4 +434,110 @@ func TestCloneValidation(t *testing.T) {
 	// Both flags should work together
 	output := buf.String()
 	assert.Contains(t, output, "Cloning into 'repo'")
-}
\ No newline at end of file
+}
+// newCloneTestServer serves a repository with a main and a trunk branch over
+// smart HTTP, advertising HEAD as a symref to trunk
+func newCloneTestServer(t *testing.T) (*httptest.Server, map[string]objects.ObjectID) {
+	src, err := vcs.Init(filepath.Join(t.TempDir(), "src"))
+	require.NoError(t, err)
+
+	sig := objects.Signature{Name: "Test", Email: "test@example.com", When: time.Unix(1700000000, 0)}
+	tips := make(map[string]objects.ObjectID)
+	var packed []objects.ObjectID
+	for _, name := range []string{"main", "trunk"} {
+		blob, err := src.CreateBlob([]byte(name + "\n"))
+		require.NoError(t, err)
+		tree, err := src.CreateTree([]objects.TreeEntry{{Mode: objects.ModeBlob, Name: name + ".txt", ID: blob.ID()}})
+		require.NoError(t, err)
+		commit, err := src.CreateCommit(tree.ID(), nil, sig, sig, name+"\n")
+		require.NoError(t, err)
+		tips[name] = commit.ID()
+		packed = append(packed, commit.ID(), tree.ID(), blob.ID())
+	}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/info/refs":
+			w.Header().Set("Content-Type", "application/x-git-upload-pack-advertisement")
+			pw := transport.NewPktLineWriter(w)
+			pw.WriteString("# service=git-upload-pack\n")
+			pw.Flush()
+			pw.Writef("%s HEAD\x00ofs-delta symref=HEAD:refs/heads/trunk\n", tips["trunk"])
+			pw.Writef("%s refs/heads/main\n", tips["main"])
+			pw.Writef("%s refs/heads/trunk\n", tips["trunk"])
+			pw.Flush()
+		case "/git-upload-pack":
+			w.Header().Set("Content-Type", "application/x-git-upload-pack-result")
+			pw := transport.NewPktLineWriter(w)
+			pw.WriteString("NAK\n")
+			w.Write(buildTestPack(t, src, packed...))
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	t.Cleanup(server.Close)
+
+	return server, tips
+}
+
+func runCloneArgs(args ...string) error {
+	cmd := newCloneCommand()
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs(args)
+	return cmd.Execute()
+}
+
+func TestCloneChecksOutRemoteHEAD(t *testing.T) {
+	server, tips := newCloneTestServer(t)
+	isolateConfig(t)
+
+	dir := filepath.Join(t.TempDir(), "clone")
+	require.NoError(t, runCloneArgs(server.URL, dir))
+
+	refManager := refs.NewRefManager(filepath.Join(dir, ".git"))
+	head, err := refManager.SymbolicHEAD()
+	require.NoError(t, err)
+	assert.Equal(t, "refs/heads/trunk", head)
+	id, err := refManager.ResolveRef("refs/heads/trunk")
+	require.NoError(t, err)
+	assert.Equal(t, tips["trunk"], id)
+
+	assert.FileExists(t, filepath.Join(dir, "trunk.txt"))
+	assert.NoFileExists(t, filepath.Join(dir, "main.txt"))
+
+	originHead, err := os.ReadFile(filepath.Join(dir, ".git", "refs", "remotes", "origin", "HEAD"))
+	require.NoError(t, err)
+	assert.Equal(t, "ref: refs/remotes/origin/trunk\n", string(originHead))
+
+	cfg := loadConfig(filepath.Join(dir, ".git"))
+	remote, _ := cfg.Get("branch.trunk.remote")
+	merge, _ := cfg.Get("branch.trunk.merge")
+	assert.Equal(t, "origin", remote)
+	assert.Equal(t, "refs/heads/trunk", merge)
+}
+
+func TestCloneBranchOverridesRemoteHEAD(t *testing.T) {
+	server, tips := newCloneTestServer(t)
+	isolateConfig(t)
+
+	dir := filepath.Join(t.TempDir(), "clone")
+	require.NoError(t, runCloneArgs("-b", "main", server.URL, dir))
+
+	refManager := refs.NewRefManager(filepath.Join(dir, ".git"))
+	head, err := refManager.SymbolicHEAD()
+	require.NoError(t, err)
+	assert.Equal(t, "refs/heads/main", head)
+	id, err := refManager.ResolveRef("HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, tips["main"], id)
+	assert.FileExists(t, filepath.Join(dir, "main.txt"))
+
+	// origin/HEAD still follows the remote, not -b
+	originHead, err := os.ReadFile(filepath.Join(dir, ".git", "refs", "remotes", "origin", "HEAD"))
+	require.NoError(t, err)
+	assert.Equal(t, "ref: refs/remotes/origin/trunk\n", string(originHead))
+
+	err = runCloneArgs("-b", "nope", server.URL, filepath.Join(t.TempDir(), "other"))
+	assert.ErrorContains(t, err, "remote branch nope not found in upstream origin")
+}
@@ -7,9 +7,13 @@ import (
 	"strings"
 	"time"
 
+	"github.com/fenilsonani/vcs/internal/core/config"
+	"github.com/fenilsonani/vcs/internal/core/history"
 	"github.com/fenilsonani/vcs/internal/core/index"
+	"github.com/fenilsonani/vcs/internal/core/merge"
 	"github.com/fenilsonani/vcs/internal/core/objects"
 	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/internal/core/trailer"
 	"github.com/fenilsonani/vcs/pkg/vcs"
 	"github.com/spf13/cobra"
 )
@@ -19,7 +23,24 @@ func newCommitCommand() *cobra.Command {
 		Use:   "commit",
 		Short: "Record changes to the repository",
 		Long: `Stores the current contents of the index in a new commit along with a log message 
-from the user describing the changes.`,
+from the user describing the changes.
+
+The pre-commit hook runs first and the commit-msg hook is given the path of
+a file holding the message, which it may edit; either exiting non-zero
+aborts the commit. --no-verify skips both. To audit skipped hooks, set
+commit.recordNoVerify to add a "No-Verify: <hooks>" trailer to such commits
+and commit.noVerifyLog to a file, relative to the repository directory, to
+append a JSON event for each of them to.
+
+-S signs the commit, as does setting commit.gpgSign, with gpg or, when
+gpg.format is ssh, with ssh-keygen. The key is user.signingKey: a key ID
+for gpg, which defaults to the committer's, or the path of an SSH key.
+
+--signoff adds a "Signed-off-by" trailer for the committer unless the
+message already ends with it, and --trailer adds trailers as
+"vcs interpret-trailers --trailer" does, following the trailer.*
+configuration. Both join the trailer block ending the message, or start
+one after its body, before the hooks run.`,
 		RunE: runCommit,
 	}
 
@@ -28,6 +49,13 @@ from the user describing the changes.`,
 	cmd.Flags().Bool("allow-empty", false, "Usually recording a commit that has the exact same tree as its sole parent commit is a mistake, and the command prevents you from making such a commit. This option bypasses the safety")
 	cmd.Flags().StringP("author", "", "", "Override the commit author (format: Name <email>)")
 	cmd.Flags().Bool("amend", false, "Replace the tip of the current branch by creating a new commit")
+	cmd.Flags().BoolP("no-verify", "n", false, "Bypass the pre-commit and commit-msg hooks")
+	cmd.Flags().StringP("gpg-sign", "S", "", "Sign the commit, with the given key or else user.signingKey")
+	cmd.Flags().Lookup("gpg-sign").NoOptDefVal = signingKeyFlag
+	cmd.Flags().Bool("no-gpg-sign", false, "Do not sign the commit, overriding commit.gpgSign")
+	cmd.Flags().BoolP("patch", "p", false, "Interactively choose hunks of changes to stage before committing")
+	cmd.Flags().BoolP("signoff", "s", false, "Add a Signed-off-by trailer for the committer")
+	cmd.Flags().StringArray("trailer", nil, "Add a trailer to the message, as token=value")
 
 	return cmd
 }
@@ -40,7 +68,7 @@ func runCommit(cmd *cobra.Command, args []string) error {
 	}
 
 	// Open repository
-	repo, err := vcs.Open(repoPath)
+	repo, err := openRepository(repoPath)
 	if err != nil {
 		return fmt.Errorf("failed to open repository: %w", err)
 	}
@@ -51,10 +79,16 @@ func runCommit(cmd *cobra.Command, args []string) error {
 	allowEmpty, _ := cmd.Flags().GetBool("allow-empty")
 	authorStr, _ := cmd.Flags().GetString("author")
 	amend, _ := cmd.Flags().GetBool("amend")
+	noVerify, _ := cmd.Flags().GetBool("no-verify")
 
-	// Get commit message
+	// Get commit message, which concluding a merge defaults to MERGE_MSG
 	if message == "" && messageFile == "" {
-		return fmt.Errorf("no commit message provided (use -m or -F)")
+		if message, err = readMergeMsg(repo); err != nil {
+			return err
+		}
+		if message == "" {
+			return fmt.Errorf("no commit message provided (use -m or -F)")
+		}
 	}
 
 	if messageFile != "" {
@@ -70,18 +104,47 @@ func runCommit(cmd *cobra.Command, args []string) error {
 		message += "\n"
 	}
 
-	// Get index
-	idx := index.New()
-	indexPath := filepath.Join(repo.GitDir(), "index")
-	if _, err := os.Stat(indexPath); err == nil {
-		if err := idx.ReadFromFile(indexPath); err != nil {
-			return fmt.Errorf("failed to read index: %w", err)
+	if patch, _ := cmd.Flags().GetBool("patch"); patch {
+		if err := stageHunks(cmd, repo, args); err != nil {
+			return err
 		}
 	}
 
-	// Check if there are changes to commit
-	if len(idx.Entries()) == 0 && !allowEmpty {
-		return fmt.Errorf("nothing to commit")
+	cfg := loadConfig(repo.GitDir())
+	if message, err = addCommitTrailers(cmd, cfg, message); err != nil {
+		return err
+	}
+
+	var skippedHooks []string
+	if noVerify {
+		skippedHooks = installedHooks(repoPath, repo.GitDir(), commitHooks)
+	}
+	if message, err = runCommitHooks(cmd, repoPath, repo.GitDir(), message, noVerify); err != nil {
+		return err
+	}
+	if len(skippedHooks) > 0 {
+		if value, _ := cfg.Get("commit.recordNoVerify"); value != "" {
+			record, err := config.ParseBool(value)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "warning: ignoring commit.recordNoVerify: %v\n", err)
+			} else if record {
+				message = trailer.Append(message, noVerifyTrailer, strings.Join(skippedHooks, ", "))
+			}
+		}
+	}
+
+	// Get index
+	idx, err := readIndex(repo)
+	if err != nil {
+		return err
+	}
+
+	// Conflicts left by a merge must be resolved with add first
+	if unmerged := idx.Unmerged(); len(unmerged) > 0 {
+		return fmt.Errorf("cannot commit because of unresolved conflicts in: %s", strings.Join(unmerged, ", "))
+	}
+	if err := recordRerereResolutions(cmd.ErrOrStderr(), repo); err != nil {
+		return err
 	}
 
 	// Create tree from index
@@ -100,6 +163,13 @@ func runCommit(cmd *cobra.Command, args []string) error {
 		if err == nil && !currentCommitID.IsZero() {
 			parents = append(parents, currentCommitID)
 		}
+
+		// Conclude a merge that stopped before committing
+		mergeHeads, _, err := readMergeHead(repo)
+		if err != nil {
+			return err
+		}
+		parents = append(parents, mergeHeads...)
 	} else {
 		// For amend, get the parents of the current commit
 		currentCommitID, _, err := refManager.HEAD()
@@ -113,74 +183,230 @@ func runCommit(cmd *cobra.Command, args []string) error {
 		}
 	}
 
+	// Check if there are changes to commit: a merge commit always records
+	// its parents, and amending may only change the message
+	var parentTree objects.ObjectID
+	if len(parents) > 0 {
+		parent, err := repo.GetCommit(parents[0])
+		if err != nil {
+			return fmt.Errorf("failed to read parent commit: %w", err)
+		}
+		parentTree = parent.Tree()
+	}
+	changes, err := history.DiffTrees(repo, parentTree, tree.ID(), true)
+	if err != nil {
+		return fmt.Errorf("failed to compare with parent: %w", err)
+	}
+	if len(changes) == 0 && len(parents) < 2 && !amend && !allowEmpty {
+		return fmt.Errorf("nothing to commit")
+	}
+
 	// Create author and committer signatures
 	author, err := getSignature(authorStr)
 	if err != nil {
 		return fmt.Errorf("invalid author format: %w", err)
 	}
 
-	committer := author // For now, author and committer are the same
+	// --author changes only the author; the committer is the user
+	committer, err := getSignature("")
+	if err != nil {
+		return err
+	}
+	committer.When = author.When
 
 	// Create commit
-	commit, err := repo.CreateCommit(tree.ID(), parents, author, committer, message)
+	sign, signKey, err := signingRequested(cmd, cfg, "gpg-sign", "no-gpg-sign", "commit.gpgSign")
 	if err != nil {
-		return fmt.Errorf("failed to create commit: %w", err)
+		return err
+	}
+	var commitID objects.ObjectID
+	if sign {
+		signer, err := newSigner(repo, signKey)
+		if err != nil {
+			return err
+		}
+		if commitID, err = writeSignedCommit(repo, signer, tree.ID(), parents, author, committer, message); err != nil {
+			return fmt.Errorf("failed to sign commit: %w", err)
+		}
+	} else {
+		commit, err := repo.CreateCommit(tree.ID(), parents, author, committer, message)
+		if err != nil {
+			return fmt.Errorf("failed to create commit: %w", err)
+		}
+		commitID = commit.ID()
 	}
 
-	// Update HEAD to point to new commit
-	currentBranch, err := refManager.CurrentBranch()
+	// Update HEAD to point to new commit. The branch HEAD names may be
+	// unborn (a new repository or orphan branch) and is created here.
+	branchRef, err := refManager.SymbolicHEAD()
 	if err != nil {
+		return fmt.Errorf("failed to read HEAD: %w", err)
+	}
+	oldHeadID, _, _ := refManager.HEAD()
+	if branchRef == "" {
 		// Detached HEAD, update HEAD directly
-		if err := refManager.SetHEADToCommit(commit.ID()); err != nil {
+		if err := refManager.SetHEADToCommit(commitID); err != nil {
 			return fmt.Errorf("failed to update HEAD: %w", err)
 		}
+		branchRef = "HEAD"
 	} else {
 		// Update current branch
-		branchRef := "refs/heads/" + currentBranch
-		if err := refManager.UpdateRef(branchRef, commit.ID()); err != nil {
-			return fmt.Errorf("failed to update branch %s: %w", currentBranch, err)
+		if err := refManager.UpdateRef(branchRef, commitID); err != nil {
+			return fmt.Errorf("failed to update branch %s: %w", strings.TrimPrefix(branchRef, "refs/heads/"), err)
 		}
 	}
+	reflogAction := "commit"
+	if amend {
+		reflogAction = "commit (amend)"
+	} else if len(parents) == 0 {
+		reflogAction = "commit (initial)"
+	}
+	subject := strings.SplitN(strings.TrimSpace(message), "\n", 2)[0]
+	logRefUpdate(refManager, branchRef, oldHeadID, commitID, reflogAction+": "+subject)
+
+	if logPath, _ := cfg.Get("commit.noVerifyLog"); logPath != "" && len(skippedHooks) > 0 {
+		event := noVerifyEvent{
+			Commit: commitID.String(),
+			Ref:    branchRef,
+			Hooks:  skippedHooks,
+			Author: fmt.Sprintf("%s <%s>", author.Name, author.Email),
+		}
+		if err := logNoVerify(repo.GitDir(), logPath, event); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: failed to log --no-verify: %v\n", err)
+		}
+	}
+
+	if err := removeMergeState(repo); err != nil {
+		return err
+	}
+	if err := removeCherryPickHead(repo); err != nil {
+		return err
+	}
 
-	// Clear the index after successful commit
-	fileCount := len(idx.Entries())
-	idx.Clear()
-	if err := idx.WriteToFile(indexPath); err != nil {
-		return fmt.Errorf("failed to clear index: %w", err)
+	// An index read from HEAD is kept for the next command
+	if err := idx.WriteToFile(filepath.Join(repo.GitDir(), "index")); err != nil {
+		return fmt.Errorf("failed to write index: %w", err)
 	}
 
+	runPostHook(cmd.ErrOrStderr(), repoPath, repo.GitDir(), "post-commit")
+
 	// Print commit summary
 	if amend {
-		fmt.Printf("[%s %s] %s", getCurrentBranchName(refManager), commit.ID().String()[:7], strings.TrimSpace(message))
+		fmt.Printf("[%s %s] %s", getCurrentBranchName(refManager), commitID.String()[:7], strings.TrimSpace(message))
 	} else {
 		commitCount := len(parents)
 		if commitCount == 0 {
-			fmt.Printf("[%s (root-commit) %s] %s", getCurrentBranchName(refManager), commit.ID().String()[:7], strings.TrimSpace(message))
+			fmt.Printf("[%s (root-commit) %s] %s", getCurrentBranchName(refManager), commitID.String()[:7], strings.TrimSpace(message))
 		} else {
-			fmt.Printf("[%s %s] %s", getCurrentBranchName(refManager), commit.ID().String()[:7], strings.TrimSpace(message))
+			fmt.Printf("[%s %s] %s", getCurrentBranchName(refManager), commitID.String()[:7], strings.TrimSpace(message))
 		}
 	}
-	fmt.Printf("\n %d file(s) changed\n", fileCount)
+	fmt.Printf("\n %d file(s) changed\n", len(changes))
 
+	// The merge this commit concludes may have stashed local changes
+	if err := applyMergeAutostash(cmd.OutOrStdout(), repo, refManager); err != nil {
+		return err
+	}
+
+	autoGC(cmd.ErrOrStderr(), repo)
 	return nil
 }
 
-func createTreeFromIndex(repo *vcs.Repository, idx *index.Index) (*objects.Tree, error) {
-	var entries []objects.TreeEntry
+// addCommitTrailers adds the sign-off of --signoff and then the trailers of
+// --trailer to message
+func addCommitTrailers(cmd *cobra.Command, cfg *config.Config, message string) (string, error) {
+	signoff, _ := cmd.Flags().GetBool("signoff")
+	trailers, _ := cmd.Flags().GetStringArray("trailer")
+	if !signoff && len(trailers) == 0 {
+		return message, nil
+	}
 
-	for _, entry := range idx.Entries() {
-		treeEntry := objects.TreeEntry{
-			Mode: entry.Mode,
-			Name: filepath.Base(entry.Path),
-			ID:   entry.ID,
+	conf, err := readTrailerConfig(cfg)
+	if err != nil {
+		return "", err
+	}
+	m := conf.parse(message, false)
+	if signoff {
+		committer, err := getSignature("")
+		if err != nil {
+			return "", err
 		}
+		m.Add(trailer.Trailer{
+			Token: trailer.SignedOffBy,
+			Value: fmt.Sprintf("%s <%s>", committer.Name, committer.Email),
+		}, trailer.Placement{})
+	}
+	if err := conf.add(m, trailers); err != nil {
+		return "", err
+	}
+	return m.String(), nil
+}
 
-		// For now, we only handle files in the root directory
-		// A full implementation would need to handle subdirectories
-		entries = append(entries, treeEntry)
+// runCommitHooks runs the pre-commit hook, then the prepare-commit-msg and
+// commit-msg hooks on a copy of message in COMMIT_EDITMSG, and returns the
+// message as the hooks left it. noVerify skips pre-commit and commit-msg.
+func runCommitHooks(cmd *cobra.Command, workTree, gitDir, message string, noVerify bool) (string, error) {
+	if !noVerify {
+		if err := runHook(cmd.ErrOrStderr(), workTree, gitDir, "pre-commit"); err != nil {
+			return "", err
+		}
+	}
+	prepare := findHook(workTree, gitDir, "prepare-commit-msg") != ""
+	verify := !noVerify && findHook(workTree, gitDir, "commit-msg") != ""
+	if !prepare && !verify {
+		return message, nil
 	}
 
-	return repo.CreateTree(entries)
+	path := filepath.Join(gitDir, "COMMIT_EDITMSG")
+	if err := os.WriteFile(path, []byte(message), 0644); err != nil {
+		return "", fmt.Errorf("failed to write COMMIT_EDITMSG: %w", err)
+	}
+	// The message always comes from -m or -F
+	if prepare {
+		if err := runHook(cmd.ErrOrStderr(), workTree, gitDir, "prepare-commit-msg", path, "message"); err != nil {
+			return "", err
+		}
+	}
+	if verify {
+		if err := runHook(cmd.ErrOrStderr(), workTree, gitDir, "commit-msg", path); err != nil {
+			return "", err
+		}
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read COMMIT_EDITMSG: %w", err)
+	}
+	if message = stripComments(string(data)); message == "" {
+		return "", fmt.Errorf("aborting commit due to empty commit message")
+	}
+	return message, nil
+}
+
+// createTreeFromIndex writes the trees of the files in idx, returning the
+// root one
+func createTreeFromIndex(repo *vcs.Repository, idx *index.Index) (*objects.Tree, error) {
+	treeID, err := writeIndexTree(repo, idx)
+	if err != nil {
+		return nil, err
+	}
+	return repo.GetTree(treeID)
+}
+
+// writeIndexTree writes the tree of the files in idx
+func writeIndexTree(repo *vcs.Repository, idx *index.Index) (objects.ObjectID, error) {
+	if unmerged := idx.Unmerged(); len(unmerged) > 0 {
+		return objects.ObjectID{}, fmt.Errorf("cannot write a tree with unmerged paths: %s", strings.Join(unmerged, ", "))
+	}
+
+	var entries []merge.Entry
+	for _, entry := range idx.Entries() {
+		entries = append(entries, merge.Entry{Path: entry.Path, Mode: entry.Mode, ID: entry.ID})
+	}
+	treeID, err := merge.WriteTree(repo, entries)
+	if err != nil {
+		return objects.ObjectID{}, fmt.Errorf("failed to write tree: %w", err)
+	}
+	return treeID, nil
 }
 
 func getSignature(authorStr string) (objects.Signature, error) {
@@ -209,16 +435,19 @@ func getSignature(authorStr string) (objects.Signature, error) {
 	}, nil
 }
 
+// getConfigValue returns the value of key in the config of the current
+// repository, or defaultValue when it is unset
 func getConfigValue(key, defaultValue string) string {
-	// For now, just return default values
-	// A full implementation would read from .git/config
+	if value, ok := loadConfig(currentGitDir()).Get(key); ok && value != "" {
+		return value
+	}
 	return defaultValue
 }
 
 func getCurrentBranchName(refManager *refs.RefManager) string {
-	branch, err := refManager.CurrentBranch()
-	if err != nil {
+	refName, err := refManager.SymbolicHEAD()
+	if err != nil || refName == "" {
 		return "HEAD"
 	}
-	return branch
+	return strings.TrimPrefix(refName, "refs/heads/")
 }
\ No newline at end of file
@@ -548,7 +548,7 @@ func TestCommitCommand_Hooks(t *testing.T) {
 	err = ensureDir(hooksDir)
 	require.NoError(t, err)
 
-	// Create mock hook files (they won't actually execute in tests)
+	// Create hook files that succeed without changing anything
 	hooks := []string{
 		"pre-commit",
 		"prepare-commit-msg",
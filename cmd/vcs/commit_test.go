@@ -8,6 +8,7 @@ import (
 	"testing"
 
 	"github.com/fenilsonani/vcs/internal/core/index"
+	"github.com/fenilsonani/vcs/internal/core/merge"
 	"github.com/fenilsonani/vcs/internal/core/objects"
 	"github.com/fenilsonani/vcs/internal/core/refs"
 	"github.com/fenilsonani/vcs/pkg/vcs"
@@ -215,6 +216,14 @@ func TestRunCommit(t *testing.T) {
 }
 
 func TestGetSignature(t *testing.T) {
+	// The defaults apply outside of any repository and user config
+	isolateConfig(t)
+	oldWd, _ := os.Getwd()
+	t.Cleanup(func() { os.Chdir(oldWd) })
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+
 	tests := []struct {
 		name      string
 		authorStr string
@@ -313,6 +322,7 @@ func TestCreateTreeFromIndex(t *testing.T) {
 	
 	idx.Add(entry1)
 	idx.Add(entry2)
+	idx.Add(&index.Entry{Mode: objects.ModeBlob, ID: blob1.ID(), Path: "dir/sub/file3.txt"})
 
 	// Create tree from index
 	tree, err := createTreeFromIndex(repo, idx)
@@ -326,8 +336,8 @@ func TestCreateTreeFromIndex(t *testing.T) {
 
 	// Verify tree entries
 	entries := tree.Entries()
-	if len(entries) != 2 {
-		t.Errorf("Expected 2 tree entries, got %d", len(entries))
+	if len(entries) != 3 {
+		t.Errorf("Expected 3 tree entries, got %d", len(entries))
 	}
 
 	// Check entries are present
@@ -347,6 +357,18 @@ func TestCreateTreeFromIndex(t *testing.T) {
 	} else if entry.Mode != objects.ModeExec {
 		t.Errorf("script.sh mode = %v, want %v", entry.Mode, objects.ModeExec)
 	}
+
+	// Files in subdirectories go in subtrees
+	if entry, exists := entryMap["dir"]; !exists || entry.Mode != objects.ModeTree {
+		t.Fatalf("dir = %+v, want a subtree", entry)
+	}
+	files, err := merge.ReadTree(repo, tree.ID())
+	if err != nil {
+		t.Fatalf("ReadTree() error = %v", err)
+	}
+	if len(files) != 3 || files[0].Path != "dir/sub/file3.txt" {
+		t.Errorf("files of tree = %+v", files)
+	}
 }
 
 func TestGetCurrentBranchName(t *testing.T) {
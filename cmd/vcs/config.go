@@ -0,0 +1,277 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/fenilsonani/vcs/internal/core/config"
+	"github.com/spf13/cobra"
+)
+
+// configOptions holds the flags of the config command
+type configOptions struct {
+	global        bool
+	system        bool
+	local         bool
+	get           bool
+	getAll        bool
+	set           bool
+	add           bool
+	unset         bool
+	unsetAll      bool
+	list          bool
+	removeSection bool
+	showOrigin    bool
+}
+
+// configArgs is the number of arguments each config action takes
+var configArgs = map[string]int{
+	"list":           0,
+	"get":            1,
+	"get-all":        1,
+	"set":            2,
+	"add":            2,
+	"unset":          1,
+	"unset-all":      1,
+	"remove-section": 1,
+}
+
+func newConfigCommand() *cobra.Command {
+	var opts configOptions
+
+	cmd := &cobra.Command{
+		Use:   "config [flags] [<name> [<value>]]",
+		Short: "Get and set repository or global options",
+		Long: `Reads and writes settings in the system (/etc/gitconfig), global
+(~/.gitconfig) and repository (.git/config) config files. Reads see all
+three merged, with repository settings taking precedence; writes go to the
+repository config unless --global or --system is given.
+
+With one argument the value of that key is printed, with two it is set:
+
+  vcs config user.name
+  vcs config --global user.email jane@example.com
+  vcs config --list --show-origin`,
+		Args: cobra.MaximumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runConfig(cmd, opts, args)
+		},
+	}
+
+	cmd.Flags().BoolVar(&opts.global, "global", false, "Use the global config file")
+	cmd.Flags().BoolVar(&opts.system, "system", false, "Use the system config file")
+	cmd.Flags().BoolVar(&opts.local, "local", false, "Use the repository config file")
+	cmd.Flags().BoolVar(&opts.get, "get", false, "Print the value of a key")
+	cmd.Flags().BoolVar(&opts.getAll, "get-all", false, "Print every value of a multi-valued key")
+	cmd.Flags().BoolVar(&opts.set, "set", false, "Set a key, replacing its value")
+	cmd.Flags().BoolVar(&opts.add, "add", false, "Add a value to a key without replacing existing values")
+	cmd.Flags().BoolVar(&opts.unset, "unset", false, "Remove a key")
+	cmd.Flags().BoolVar(&opts.unsetAll, "unset-all", false, "Remove every value of a multi-valued key")
+	cmd.Flags().BoolVarP(&opts.list, "list", "l", false, "List all settings")
+	cmd.Flags().BoolVar(&opts.removeSection, "remove-section", false, "Remove a section, such as remote.origin")
+	cmd.Flags().BoolVar(&opts.showOrigin, "show-origin", false, "Show the file each setting comes from")
+
+	return cmd
+}
+
+func runConfig(cmd *cobra.Command, opts configOptions, args []string) error {
+	action, err := configAction(opts, args)
+	if err != nil {
+		return err
+	}
+
+	scope, scoped, err := configScope(opts)
+	if err != nil {
+		return err
+	}
+
+	gitDir := currentGitDir()
+	if gitDir == "" && scoped && scope == config.ScopeLocal {
+		return fmt.Errorf("--local can only be used inside a repository")
+	}
+
+	switch action {
+	case "list", "get", "get-all":
+		cfg, err := config.Load(gitDir)
+		if err != nil {
+			return fmt.Errorf("failed to read config: %w", err)
+		}
+
+		var entries []config.Entry
+		for _, entry := range cfg.Entries() {
+			if !scoped || entry.Scope == scope {
+				entries = append(entries, entry)
+			}
+		}
+		return printConfig(cmd, opts, action, entries, args)
+	}
+
+	if !scoped {
+		scope = config.ScopeLocal
+	}
+	path := config.Path(scope, gitDir)
+	if path == "" {
+		if scope == config.ScopeLocal {
+			return fmt.Errorf("not in a repository; use --global to change the global config")
+		}
+		return fmt.Errorf("no %s config file is available", scope)
+	}
+
+	file, err := config.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	switch action {
+	case "set":
+		err = file.Set(args[0], args[1])
+	case "add":
+		err = file.Add(args[0], args[1])
+	case "unset":
+		var found bool
+		if found, err = file.Unset(args[0]); err == nil && !found {
+			err = fmt.Errorf("key %s is not set", args[0])
+		}
+	case "unset-all":
+		var n int
+		if n, err = file.UnsetAll(args[0]); err == nil && n == 0 {
+			err = fmt.Errorf("key %s is not set", args[0])
+		}
+	case "remove-section":
+		section, subsection, _ := strings.Cut(args[0], ".")
+		if !file.RemoveSection(section, subsection) {
+			err = fmt.Errorf("no such section: %s", args[0])
+		}
+	}
+	if err != nil {
+		return err
+	}
+
+	return file.Save()
+}
+
+// configAction works out which action the flags and arguments ask for and
+// checks it was given the right number of arguments
+func configAction(opts configOptions, args []string) (string, error) {
+	flags := []struct {
+		name string
+		set  bool
+	}{
+		{"list", opts.list}, {"get", opts.get}, {"get-all", opts.getAll},
+		{"set", opts.set}, {"add", opts.add}, {"unset", opts.unset},
+		{"unset-all", opts.unsetAll}, {"remove-section", opts.removeSection},
+	}
+
+	var action string
+	for _, flag := range flags {
+		if !flag.set {
+			continue
+		}
+		if action != "" {
+			return "", fmt.Errorf("only one action at a time: --%s and --%s", action, flag.name)
+		}
+		action = flag.name
+	}
+
+	if action == "" {
+		switch len(args) {
+		case 1:
+			action = "get"
+		case 2:
+			action = "set"
+		default:
+			return "", fmt.Errorf("no action given; use --get, --set, --unset or --list")
+		}
+	}
+
+	if want := configArgs[action]; len(args) != want {
+		return "", fmt.Errorf("wrong number of arguments for --%s, should be %d", action, want)
+	}
+	return action, nil
+}
+
+// configScope returns the scope chosen with --system, --global or --local,
+// and whether one was chosen
+func configScope(opts configOptions) (config.Scope, bool, error) {
+	var scopes []config.Scope
+	if opts.system {
+		scopes = append(scopes, config.ScopeSystem)
+	}
+	if opts.global {
+		scopes = append(scopes, config.ScopeGlobal)
+	}
+	if opts.local {
+		scopes = append(scopes, config.ScopeLocal)
+	}
+
+	switch len(scopes) {
+	case 0:
+		return config.ScopeLocal, false, nil
+	case 1:
+		return scopes[0], true, nil
+	default:
+		return 0, false, fmt.Errorf("only one of --system, --global and --local may be given")
+	}
+}
+
+// printConfig prints the entries for --list, --get and --get-all
+func printConfig(cmd *cobra.Command, opts configOptions, action string, entries []config.Entry, args []string) error {
+	out := cmd.OutOrStdout()
+	origin := func(entry config.Entry) string {
+		if !opts.showOrigin {
+			return ""
+		}
+		return "file:" + entry.File + "\t"
+	}
+
+	if action == "list" {
+		for _, entry := range entries {
+			fmt.Fprintf(out, "%s%s=%s\n", origin(entry), entry.Key, entry.Value)
+		}
+		return nil
+	}
+
+	key, err := config.NormalizeKey(args[0])
+	if err != nil {
+		return err
+	}
+
+	var matches []config.Entry
+	for _, entry := range entries {
+		if entry.Key == key {
+			matches = append(matches, entry)
+		}
+	}
+	if len(matches) == 0 {
+		return fmt.Errorf("key %s is not set", args[0])
+	}
+	if action == "get" {
+		matches = matches[len(matches)-1:]
+	}
+
+	for _, entry := range matches {
+		fmt.Fprintf(out, "%s%s\n", origin(entry), entry.Value)
+	}
+	return nil
+}
+
+// currentGitDir returns the git directory of the repository the command
+// runs in, or "" outside of one
+func currentGitDir() string {
+	if _, gitDir, err := discoverRepository(); err == nil {
+		return gitDir
+	}
+	return ""
+}
+
+// loadConfig reads the config that applies to the repository at gitDir. A
+// file that fails to parse is reported, and the settings read before it are
+// still used.
+func loadConfig(gitDir string) *config.Config {
+	cfg, err := config.Load(gitDir)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+	}
+	return cfg
+}
@@ -0,0 +1,179 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// isolateConfig keeps the system and user config out of a test, returning
+// the path used as the global config file
+func isolateConfig(t *testing.T) string {
+	global := filepath.Join(t.TempDir(), "gitconfig")
+	t.Setenv("GIT_CONFIG_GLOBAL", global)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	return global
+}
+
+// setupConfigRepo creates an empty repository with isolated config and
+// changes into it
+func setupConfigRepo(t *testing.T) (*vcs.Repository, string) {
+	global := isolateConfig(t)
+	repoPath := t.TempDir()
+	repo, err := vcs.Init(repoPath)
+	require.NoError(t, err)
+
+	oldWd, _ := os.Getwd()
+	t.Cleanup(func() { os.Chdir(oldWd) })
+	require.NoError(t, os.Chdir(repoPath))
+	return repo, global
+}
+
+func runConfigArgs(args ...string) (string, error) {
+	cmd := newConfigCommand()
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+	return out.String(), err
+}
+
+func TestConfigSetGet(t *testing.T) {
+	repo, _ := setupConfigRepo(t)
+
+	_, err := runConfigArgs("user.name", "Jane Doe")
+	require.NoError(t, err)
+	_, err = runConfigArgs("--set", "User.Email", "jane@example.com")
+	require.NoError(t, err)
+
+	out, err := runConfigArgs("user.name")
+	require.NoError(t, err)
+	assert.Equal(t, "Jane Doe\n", out)
+	out, err = runConfigArgs("--get", "user.email")
+	require.NoError(t, err)
+	assert.Equal(t, "jane@example.com\n", out)
+
+	content, err := os.ReadFile(filepath.Join(repo.GitDir(), "config"))
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "[user]\n\tname = Jane Doe\n\tEmail = jane@example.com\n")
+	assert.Contains(t, string(content), "[core]", "existing settings are kept")
+
+	out, err = runConfigArgs("--list", "--show-origin")
+	require.NoError(t, err)
+	assert.Contains(t, out, "file:"+filepath.Join(repo.GitDir(), "config")+"\tuser.name=Jane Doe\n")
+	assert.Contains(t, out, "\tuser.email=jane@example.com\n")
+
+	// Commits take their identity from the config
+	sig, err := getSignature("")
+	require.NoError(t, err)
+	assert.Equal(t, "Jane Doe", sig.Name)
+	assert.Equal(t, "jane@example.com", sig.Email)
+
+	_, err = runConfigArgs("--unset", "user.name")
+	require.NoError(t, err)
+	_, err = runConfigArgs("user.name")
+	assert.ErrorContains(t, err, "key user.name is not set")
+}
+
+func TestConfigGlobal(t *testing.T) {
+	_, global := setupConfigRepo(t)
+
+	_, err := runConfigArgs("--global", "user.name", "Global Name")
+	require.NoError(t, err)
+	_, err = runConfigArgs("--global", "core.editor", "nano")
+	require.NoError(t, err)
+	content, err := os.ReadFile(global)
+	require.NoError(t, err)
+	assert.Equal(t, "[user]\n\tname = Global Name\n[core]\n\teditor = nano\n", string(content))
+
+	_, err = runConfigArgs("user.name", "Local Name")
+	require.NoError(t, err)
+
+	out, err := runConfigArgs("user.name")
+	require.NoError(t, err)
+	assert.Equal(t, "Local Name\n", out, "repository settings take precedence")
+	out, err = runConfigArgs("--global", "--get", "user.name")
+	require.NoError(t, err)
+	assert.Equal(t, "Global Name\n", out)
+
+	out, err = runConfigArgs("--local", "--list")
+	require.NoError(t, err)
+	assert.NotContains(t, out, "core.editor")
+	assert.Contains(t, out, "user.name=Local Name")
+	assert.Equal(t, "nano", loadConfigSections(currentGitDir(), "core")["core.editor"])
+}
+
+func TestConfigMultiValued(t *testing.T) {
+	setupConfigRepo(t)
+
+	_, err := runConfigArgs("--add", "remote.origin.fetch", "+refs/heads/*:refs/remotes/origin/*")
+	require.NoError(t, err)
+	_, err = runConfigArgs("--add", "remote.origin.fetch", "+refs/tags/*:refs/tags/*")
+	require.NoError(t, err)
+
+	out, err := runConfigArgs("--get-all", "remote.origin.fetch")
+	require.NoError(t, err)
+	assert.Equal(t, "+refs/heads/*:refs/remotes/origin/*\n+refs/tags/*:refs/tags/*\n", out)
+	out, err = runConfigArgs("--get", "remote.origin.fetch")
+	require.NoError(t, err)
+	assert.Equal(t, "+refs/tags/*:refs/tags/*\n", out)
+
+	_, err = runConfigArgs("remote.origin.fetch", "x")
+	assert.ErrorContains(t, err, "multiple values")
+	_, err = runConfigArgs("--unset", "remote.origin.fetch")
+	assert.ErrorContains(t, err, "multiple values")
+	_, err = runConfigArgs("--unset-all", "remote.origin.fetch")
+	require.NoError(t, err)
+	_, err = runConfigArgs("--get-all", "remote.origin.fetch")
+	assert.Error(t, err)
+}
+
+func TestConfigRemoveSection(t *testing.T) {
+	repo, _ := setupConfigRepo(t)
+	require.NoError(t, writeRemoteConfig(repo, "origin", "https://example.com/repo.git"))
+	require.NoError(t, setUpstreamBranch(repo, "main", "origin", "main"))
+
+	_, err := runConfigArgs("--remove-section", "remote.origin")
+	require.NoError(t, err)
+	remotes, err := getRemotes(repo)
+	require.NoError(t, err)
+	assert.Empty(t, remotes)
+
+	out, err := runConfigArgs("branch.main.merge")
+	require.NoError(t, err)
+	assert.Equal(t, "refs/heads/main\n", out)
+
+	_, err = runConfigArgs("--remove-section", "remote.origin")
+	assert.ErrorContains(t, err, "no such section")
+}
+
+func TestConfigErrors(t *testing.T) {
+	setupConfigRepo(t)
+
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{"no action", nil, "no action given"},
+		{"two actions", []string{"--get", "--unset", "user.name"}, "only one action"},
+		{"two scopes", []string{"--global", "--local", "--list"}, "only one of"},
+		{"wrong argument count", []string{"--get", "user.name", "x"}, "wrong number of arguments"},
+		{"no section", []string{"name", "x"}, "key does not contain a section"},
+		{"unset missing", []string{"--unset", "user.name"}, "is not set"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := runConfigArgs(tt.args...)
+			assert.ErrorContains(t, err, tt.want)
+		})
+	}
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/config"
+	"github.com/fenilsonani/vcs/internal/credential"
+)
+
+func newCredentialCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "credential (fill|approve|reject)",
+		Short: "Retrieve and store user credentials",
+		Long: `Speaks the git-credential protocol: reads a credential description,
+attribute=value lines such as protocol, host, path and username, from
+standard input. fill writes it back completed with a username and
+password, from the helpers configured with credential.helper or else by
+prompting. approve gives it to every helper to store, and reject asks
+every helper to erase it.
+
+Helpers are the programs Git runs: a name such as osxkeychain, wincred,
+libsecret or cache runs git-credential-<name>, a value starting with !
+is a shell command, and an absolute path runs that program. The store
+helper, which keeps credentials in ~/.git-credentials or the file given
+with --file, is built in. Credentials are asked for with GIT_ASKPASS,
+core.askPass or SSH_ASKPASS when set, or on the terminal unless
+GIT_TERMINAL_PROMPT is 0.`,
+		Args:      cobra.ExactArgs(1),
+		ValidArgs: []string{"fill", "approve", "reject"},
+		RunE: func(cmd *cobra.Command, args []string) error {
+			gitDir := ""
+			if repo, err := openSuperproject(); err == nil {
+				gitDir = repo.GitDir()
+			}
+			manager := newCredentialManager(gitDir)
+
+			c, _, err := credential.Read(cmd.InOrStdin())
+			if err != nil {
+				return err
+			}
+			switch args[0] {
+			case "fill":
+				if err := manager.Fill(c); err != nil {
+					return err
+				}
+				return c.Write(cmd.OutOrStdout())
+			case "approve":
+				manager.Approve(c)
+			case "reject":
+				manager.Reject(c)
+			default:
+				return fmt.Errorf("unknown credential action '%s'", args[0])
+			}
+			return nil
+		},
+	}
+}
+
+// newCredentialManager returns the credential manager configured for the
+// repository directory gitDir, or by the global config when it is empty
+func newCredentialManager(gitDir string) *credential.Manager {
+	cfg := loadConfig(gitDir)
+	manager := credential.NewManager(cfg.GetAll("credential.helper"))
+	if value, ok := cfg.Get("credential.usehttppath"); ok {
+		if use, err := config.ParseBool(value); err == nil {
+			manager.SetUseHTTPPath(use)
+		}
+	}
+	if username, ok := cfg.Get("credential.username"); ok {
+		manager.SetUsername(username)
+	}
+
+	askpass := os.Getenv("GIT_ASKPASS")
+	if askpass == "" {
+		askpass, _ = cfg.Get("core.askpass")
+	}
+	if askpass == "" {
+		askpass = os.Getenv("SSH_ASKPASS")
+	}
+	if askpass != "" {
+		manager.SetPrompt(credential.AskpassPrompt(askpass))
+	} else {
+		manager.SetPrompt(credential.TerminalPrompt())
+	}
+	return manager
+}
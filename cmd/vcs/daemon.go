@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/serve"
+)
+
+// daemonOptions holds the flags of vcs daemon
+type daemonOptions struct {
+	root           string
+	port           int
+	exportAll      bool
+	allowPush      bool
+	maxConnections int
+	timeout        time.Duration
+	verbose        bool
+}
+
+func newDaemonCommand() *cobra.Command {
+	opts := daemonOptions{maxConnections: serve.DefaultDaemonOptions().MaxConnections}
+
+	cmd := &cobra.Command{
+		Use:   "daemon",
+		Short: "Serve repositories over the git:// protocol",
+		Long: `Serves the repositories under the root directory over the plain git://
+protocol, as git daemon does, so that vcs and git can fetch from them:
+
+  vcs daemon --root /repos
+  git clone git://localhost/team/project.git
+
+Only repositories holding a git-daemon-export-ok file in their repository
+directory are served, unless --export-all is given. A bare repository's
+.git suffix may be left out of its URL.
+
+Repositories are read-only unless --allow-push is given. A repository's
+daemon.receivePack setting overrides the flag for that repository,
+enabling or disabling pushes to it. Pushes are checked against the
+repository's receive.* settings, described in vcs help share.
+
+The git:// protocol neither authenticates nor encrypts: serve only on
+networks whose clients are trusted. Serving stops on Ctrl-C.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runDaemon(cmd, opts)
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.root, "root", ".", "Directory holding the repositories to serve")
+	cmd.Flags().IntVar(&opts.port, "port", serve.DefaultDaemonPort, "Port to serve on")
+	cmd.Flags().BoolVar(&opts.exportAll, "export-all", false, "Serve repositories without a git-daemon-export-ok file")
+	cmd.Flags().BoolVar(&opts.allowPush, "allow-push", false, "Accept pushes, subject to each repository's receive.* settings")
+	cmd.Flags().IntVar(&opts.maxConnections, "max-connections", opts.maxConnections, "Most clients served at once, 0 for no limit")
+	cmd.Flags().DurationVar(&opts.timeout, "timeout", 0, "Close connections idle for this long (default: never)")
+	cmd.Flags().BoolVarP(&opts.verbose, "verbose", "v", false, "Log each request and failure to stderr")
+
+	return cmd
+}
+
+func runDaemon(cmd *cobra.Command, opts daemonOptions) error {
+	root, err := filepath.Abs(opts.root)
+	if err != nil {
+		return fmt.Errorf("failed to resolve root: %w", err)
+	}
+	if info, err := os.Stat(root); err != nil {
+		return fmt.Errorf("failed to read root: %w", err)
+	} else if !info.IsDir() {
+		return fmt.Errorf("'%s' is not a directory", root)
+	}
+	if opts.maxConnections < 0 {
+		return fmt.Errorf("--max-connections cannot be negative")
+	}
+	cmd.SilenceUsage = true
+
+	daemonOpts := serve.DaemonOptions{
+		ExportAll:      opts.exportAll,
+		MaxConnections: opts.maxConnections,
+		Timeout:        opts.timeout,
+	}
+	if opts.verbose {
+		daemonOpts.Log = cmd.ErrOrStderr()
+	}
+	daemon := serve.NewDaemon(root, func(dir string) (*serve.Server, error) {
+		return openServedRepository(dir, opts.allowPush, "daemon.receivePack")
+	}, daemonOpts)
+
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", opts.port))
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	mode := "read-only"
+	if opts.allowPush {
+		mode = "with pushes allowed"
+	}
+	out := cmd.OutOrStdout()
+	fmt.Fprintf(out, "Serving repositories under %s %s on port %d\n", root, mode, port)
+	fmt.Fprintln(out, "Press Ctrl-C to stop serving.")
+
+	return daemon.Serve(ctx, listener)
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDaemonInvalidFlags(t *testing.T) {
+	run := func(args ...string) error {
+		cmd := newDaemonCommand()
+		cmd.SetOut(&bytes.Buffer{})
+		cmd.SetErr(&bytes.Buffer{})
+		cmd.SetArgs(append(args, "--port", "0"))
+		return cmd.Execute()
+	}
+	assert.ErrorContains(t, run("--root", t.TempDir(), "--max-connections", "-1"), "cannot be negative")
+	assert.ErrorContains(t, run("--root", "/nonexistent/repos"), "failed to read root")
+}
@@ -0,0 +1,270 @@
+package main
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// describeCandidates is how many of the most recent tagged ancestors are
+// compared to find the nearest, as Git's --candidates default
+const describeCandidates = 10
+
+// describeOptions holds the flags of describe
+type describeOptions struct {
+	tags   bool
+	long   bool
+	dirty  string
+	match  []string
+	abbrev int
+}
+
+// describeTag is a tag that can name a commit
+type describeTag struct {
+	name      string
+	annotated bool
+	date      time.Time
+}
+
+func newDescribeCommand() *cobra.Command {
+	var opts describeOptions
+
+	cmd := &cobra.Command{
+		Use:   "describe [flags] [<commit-ish>...]",
+		Short: "Give an object a human readable name based on an available tag",
+		Long: `Names each commit, HEAD by default, after the nearest tag it descends
+from: the tag alone when it points at the commit, otherwise the tag, the
+number of commits on top of it and the abbreviated commit name, as in
+v1.2.3-14-gabc1234. Only annotated tags are used unless --tags is given.
+
+--long always uses the long form, --abbrev sets the length of the commit
+name and --abbrev=0 leaves it and the count out. --match keeps only tags
+matching a glob pattern and may be repeated. --dirty appends -dirty, or
+the mark given, when the working tree has changes.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if cmd.Flags().Changed("dirty") && len(args) > 0 {
+				return fmt.Errorf("--dirty is incompatible with commit-ishes")
+			}
+			if opts.long && opts.abbrev == 0 {
+				return fmt.Errorf("--long is incompatible with --abbrev=0")
+			}
+			return runDescribe(cmd, args, opts)
+		},
+	}
+
+	cmd.Flags().BoolVar(&opts.tags, "tags", false, "Use any tag, including lightweight ones")
+	cmd.Flags().BoolVar(&opts.long, "long", false, "Always use the long format")
+	cmd.Flags().StringVar(&opts.dirty, "dirty", "", "Append a mark (default -dirty) when the working tree has changes")
+	cmd.Flags().Lookup("dirty").NoOptDefVal = "-dirty"
+	cmd.Flags().StringArrayVar(&opts.match, "match", nil, "Only consider tags matching the glob pattern")
+	cmd.Flags().IntVar(&opts.abbrev, "abbrev", 7, "Use at least n hex digits of the commit name")
+
+	return cmd
+}
+
+func runDescribe(cmd *cobra.Command, args []string, opts describeOptions) error {
+	repoPath, err := findRepository()
+	if err != nil {
+		return fmt.Errorf("not a git repository: %w", err)
+	}
+	repo, err := openRepository(repoPath)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+	refManager := refs.NewRefManager(repo.GitDir())
+
+	tags, unannotated, err := describeTags(repo, refManager, opts)
+	if err != nil {
+		return err
+	}
+	if len(tags) == 0 {
+		if unannotated {
+			return fmt.Errorf("no annotated tags can describe anything; there are unannotated tags: try --tags")
+		}
+		return fmt.Errorf("no names found, cannot describe anything")
+	}
+
+	dirty := ""
+	if len(args) == 0 {
+		args = []string{"HEAD"}
+		if opts.dirty != "" {
+			changed, err := describeDirty(repo, refManager)
+			if err != nil {
+				return err
+			}
+			if changed {
+				dirty = opts.dirty
+			}
+		}
+	}
+
+	for _, arg := range args {
+		id, err := resolveCommitish(repo, arg)
+		if err != nil {
+			return err
+		}
+		name, err := describeName(repo, tags, id, opts)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(cmd.OutOrStdout(), name+dirty)
+	}
+	return nil
+}
+
+// describeTags returns the tags describe may use by the commit they point
+// to, and whether lightweight tags were left out for want of --tags
+func describeTags(repo *vcs.Repository, refManager *refs.RefManager, opts describeOptions) (map[objects.ObjectID]describeTag, bool, error) {
+	allRefs, err := refManager.AllRefs()
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to list refs: %w", err)
+	}
+	var names []string
+	for name := range allRefs {
+		if strings.HasPrefix(name, "refs/tags/") {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	tags := make(map[objects.ObjectID]describeTag)
+	unannotated := false
+	for _, refName := range names {
+		name := strings.TrimPrefix(refName, "refs/tags/")
+		if !describeMatches(name, opts.match) {
+			continue
+		}
+
+		tag := describeTag{name: name}
+		id := allRefs[refName]
+		obj, err := repo.ReadObject(id)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to read tag %s: %w", name, err)
+		}
+		if annotated, ok := obj.(*objects.Tag); ok {
+			tag.annotated = true
+			tag.date = annotated.Tagger().When
+			for ok {
+				id = annotated.Object()
+				if obj, err = repo.ReadObject(id); err != nil {
+					return nil, false, fmt.Errorf("failed to read tag %s: %w", name, err)
+				}
+				annotated, ok = obj.(*objects.Tag)
+			}
+		} else if !opts.tags {
+			unannotated = true
+			continue
+		}
+		// Tags of trees and blobs name no commit
+		if obj.Type() != objects.TypeCommit {
+			continue
+		}
+
+		// Of several tags of one commit, annotated ones win, then the newest
+		if prev, ok := tags[id]; ok && (prev.annotated && !tag.annotated || prev.annotated == tag.annotated && !tag.date.After(prev.date)) {
+			continue
+		}
+		tags[id] = tag
+	}
+	return tags, unannotated, nil
+}
+
+// describeMatches reports whether name matches one of patterns, or there
+// are none
+func describeMatches(name string, patterns []string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// describeName names id after the tag of tags nearest to it: the one
+// with the fewest commits reachable from id but not from the tag
+func describeName(repo *vcs.Repository, tags map[objects.ObjectID]describeTag, id objects.ObjectID, opts describeOptions) (string, error) {
+	if tag, ok := tags[id]; ok && !opts.long {
+		return tag.name, nil
+	}
+
+	resolver := newResolver(repo)
+	reachable, err := resolver.Reachable([]objects.ObjectID{id})
+	if err != nil {
+		return "", err
+	}
+
+	// Compare only the most recently committed tagged ancestors
+	var candidates []*objects.Commit
+	for tagged := range tags {
+		if !reachable[tagged] {
+			continue
+		}
+		commit, err := repo.GetCommit(tagged)
+		if err != nil {
+			return "", fmt.Errorf("failed to read commit %s: %w", tagged.Short(), err)
+		}
+		candidates = append(candidates, commit)
+	}
+	if len(candidates) == 0 {
+		return "", fmt.Errorf("no tags can describe '%s'", id)
+	}
+	sort.Slice(candidates, func(i, j int) bool {
+		ti, tj := candidates[i].Committer().When, candidates[j].Committer().When
+		if !ti.Equal(tj) {
+			return ti.After(tj)
+		}
+		return tags[candidates[i].ID()].name < tags[candidates[j].ID()].name
+	})
+	if len(candidates) > describeCandidates {
+		candidates = candidates[:describeCandidates]
+	}
+
+	best, depth := objects.ObjectID{}, -1
+	for _, commit := range candidates {
+		ancestors, err := resolver.Reachable([]objects.ObjectID{commit.ID()})
+		if err != nil {
+			return "", err
+		}
+		if d := len(reachable) - len(ancestors); depth < 0 || d < depth {
+			best, depth = commit.ID(), d
+		}
+	}
+
+	name := tags[best].name
+	if opts.abbrev == 0 {
+		return name, nil
+	}
+	abbrev, err := resolver.Abbrev(id, opts.abbrev)
+	if err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%s-%d-g%s", name, depth, abbrev), nil
+}
+
+// describeDirty reports whether changes are staged or tracked files differ
+// from HEAD; untracked files do not count
+func describeDirty(repo *vcs.Repository, refManager *refs.RefManager) (bool, error) {
+	if staged, err := hasUncommittedChanges(repo, refManager); err != nil || staged {
+		return staged, err
+	}
+	headID, _, err := refManager.HEAD()
+	if err != nil {
+		return false, fmt.Errorf("failed to read HEAD: %w", err)
+	}
+	changed, err := filesDifferFromCommit(repo, headID, false)
+	if err != nil {
+		return false, fmt.Errorf("failed to check the working tree: %w", err)
+	}
+	return changed, nil
+}
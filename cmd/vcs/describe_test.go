@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+func runDescribeArgs(args ...string) (string, error) {
+	cmd := newDescribeCommand()
+	var stdout bytes.Buffer
+	cmd.SetOut(&stdout)
+	cmd.SetErr(&stdout)
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+	return strings.TrimSpace(stdout.String()), err
+}
+
+func TestDescribe(t *testing.T) {
+	repo, refManager, ids := setupBisectRepo(t, 6)
+
+	_, err := runDescribeArgs()
+	assert.ErrorContains(t, err, "no names found")
+
+	require.NoError(t, refManager.UpdateRef("refs/tags/v1.1", ids[3]))
+	_, err = runDescribeArgs()
+	assert.ErrorContains(t, err, "try --tags")
+
+	sig := objects.Signature{Name: "Test", Email: "test@example.com", When: time.Now()}
+	tag, err := repo.CreateTag(ids[1], objects.TypeCommit, "v1.0", sig, "release\n")
+	require.NoError(t, err)
+	require.NoError(t, refManager.UpdateRef("refs/tags/v1.0", tag.ID()))
+
+	head := ids[5].String()[:7]
+	out, err := runDescribeArgs()
+	require.NoError(t, err)
+	assert.Equal(t, "v1.0-4-g"+head, out)
+
+	out, err = runDescribeArgs("--tags")
+	require.NoError(t, err)
+	assert.Equal(t, "v1.1-2-g"+head, out)
+
+	out, err = runDescribeArgs("--tags", "--match", "v1.0*", "--abbrev=10")
+	require.NoError(t, err)
+	assert.Equal(t, "v1.0-4-g"+ids[5].String()[:10], out)
+
+	_, err = runDescribeArgs("--match", "v2*")
+	assert.Error(t, err)
+
+	out, err = runDescribeArgs(ids[1].String(), ids[2].String())
+	require.NoError(t, err)
+	assert.Equal(t, "v1.0\nv1.0-1-g"+ids[2].String()[:7], out)
+
+	out, err = runDescribeArgs("--long", ids[1].String())
+	require.NoError(t, err)
+	assert.Equal(t, "v1.0-0-g"+ids[1].String()[:7], out)
+
+	out, err = runDescribeArgs("--abbrev=0")
+	require.NoError(t, err)
+	assert.Equal(t, "v1.0", out)
+
+	_, err = runDescribeArgs(ids[0].String())
+	assert.ErrorContains(t, err, "no tags can describe")
+
+	// Untracked files do not make the tree dirty
+	require.NoError(t, os.WriteFile("new.txt", []byte("new\n"), 0644))
+	out, err = runDescribeArgs("--dirty")
+	require.NoError(t, err)
+	assert.Equal(t, "v1.0-4-g"+head, out)
+
+	require.NoError(t, os.WriteFile("n.txt", []byte("changed\n"), 0644))
+	out, err = runDescribeArgs("--dirty")
+	require.NoError(t, err)
+	assert.Equal(t, "v1.0-4-g"+head+"-dirty", out)
+	out, err = runDescribeArgs("--dirty=.mod")
+	require.NoError(t, err)
+	assert.Equal(t, "v1.0-4-g"+head+".mod", out)
+
+	_, err = runDescribeArgs("--dirty", "HEAD")
+	assert.ErrorContains(t, err, "incompatible")
+}
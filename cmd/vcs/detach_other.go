@@ -0,0 +1,8 @@
+//go:build !unix
+
+package main
+
+import "os/exec"
+
+// detachProcess leaves cmd as it is: it already outlives its parent
+func detachProcess(cmd *exec.Cmd) {}
@@ -1,17 +1,27 @@
 package main
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/spf13/cobra"
 
+	"github.com/fenilsonani/vcs/internal/core/config"
+	"github.com/fenilsonani/vcs/internal/core/convert"
+	"github.com/fenilsonani/vcs/internal/core/history"
 	"github.com/fenilsonani/vcs/internal/core/index"
+	"github.com/fenilsonani/vcs/internal/core/merge"
 	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/patch"
 	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/internal/interactive"
+	"github.com/fenilsonani/vcs/internal/revparse"
 	"github.com/fenilsonani/vcs/pkg/vcs"
 )
 
@@ -21,27 +31,103 @@ func newDiffCommand() *cobra.Command {
 		nameOnly   bool
 		nameStatus bool
 		unified    int
+		fullIndex  bool
+		binary     bool
+		noTextconv bool
+		noExtDiff  bool
+		stat       bool
 	)
 
 	cmd := &cobra.Command{
 		Use:   "diff [flags] [commit] [commit] [-- path...]",
 		Short: "Show changes between commits, commit and working tree, etc",
 		Long: `Show changes between the working tree and the index or a tree, changes between
-the index and a tree, changes between two trees, or changes between two files.`,
+the index and a tree, changes between two trees, or changes between two files.
+Commits may be given in any revision syntax; A..B compares A with B and
+A...B compares B with the merge base of A and B.
+
+-M pairs deleted and added files at least 50% similar, or as similar as
+given, as renames, shown as R with the similarity in --name-status. -C
+also finds added files copied from a modified or deleted file, shown as
+C. diff.renames set to true or copies turns them on by default.
+
+--word-diff shows changed lines word by word: plain marks removed words
+[-like this-] and added ones {+like this+}, color shows them in red and
+green, and porcelain puts each on its own line starting with -, + or a
+space, with ~ ending each line of the file. Words are runs of non-space
+unless --word-diff-regex or diff.wordRegex gives a regular expression
+matching them. --color-words[=<regex>] is --word-diff=color with that
+regex.
+
+--diff-algorithm picks how changed lines are found: myers, the default,
+minimal, which always finds the smallest diff, patience, which anchors on
+lines that appear once on each side, or histogram, which anchors on the
+rarest lines. --minimal, --patience and --histogram are short for them,
+and diff.algorithm sets the default.
+
+Files that are binary, by the binary or -diff attribute or by having a
+NUL byte in their first 8000 bytes, are only said to differ. --binary
+writes a GIT binary patch of them instead, the new content and the old
+one each compressed and either whole or as a delta against the other,
+which "vcs apply" can apply either way. The index lines of those files
+then give the full blob IDs, as --full-index does for every file.
+
+The diff attribute can name a driver configured as diff.<driver>.*.
+textconv is a command given a temporary file holding the content, whose
+output is diffed instead, so that PDFs, image metadata or encrypted files
+can be compared; with cachetextconv set the output is kept for each blob
+as notes in refs/notes/textconv/<driver>. command is a program run in
+place of the built-in diff with the path, then the temporary file, blob
+ID and mode of each side, as is $GIT_EXTERNAL_DIFF or diff.external for
+every file. binary makes files of the driver binary. --no-textconv and
+--no-ext-diff turn the commands off.
+
+Output to a terminal is paged and colored, headers in color.diff.meta,
+hunk headers in color.diff.frag and removed and added lines in
+color.diff.old and color.diff.new.
+
+--stat shows a diffstat in place of the diff: each file changed with a
+graph of the lines added and removed, then the totals. --json, or
+--format=json, writes that diffstat as JSON, with the status, old path
+and counts of each file.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			repo, err := findRepository()
 			if err != nil {
 				return err
 			}
 
-			vcsRepo, err := vcs.Open(repo)
+			vcsRepo, err := openRepository(repo)
 			if err != nil {
 				return err
 			}
 
 			refManager := refs.NewRefManager(vcsRepo.GitDir())
 
-			return runDiff(vcsRepo, refManager, args, cached, nameOnly, nameStatus, unified)
+			d, err := readDetection(cmd, vcsRepo.GitDir(), false, "diff.renames")
+			if err != nil {
+				return err
+			}
+			format := diffFormat{unified: unified, fullIndex: fullIndex, binary: binary, textconv: !noTextconv, extDiff: !noExtDiff, stat: stat}
+			asJSON, err := jsonRequested(cmd)
+			if err != nil {
+				return err
+			}
+			if asJSON {
+				format.json = cmd.OutOrStdout()
+				return runDiff(vcsRepo, refManager, args, cached, nameOnly, nameStatus, format, d)
+			}
+			if format.algorithm, err = readDiffAlgorithm(cmd, vcsRepo.GitDir()); err != nil {
+				return err
+			}
+			if format.words, err = readWordDiff(cmd, vcsRepo.GitDir()); err != nil {
+				return err
+			}
+			startPager(vcsRepo.GitDir(), "diff", true)
+			if format.colors, err = newPalette(cmd, vcsRepo.GitDir(), "diff"); err != nil {
+				return err
+			}
+
+			return runDiff(vcsRepo, refManager, args, cached, nameOnly, nameStatus, format, d)
 		},
 	}
 
@@ -49,40 +135,250 @@ the index and a tree, changes between two trees, or changes between two files.`,
 	cmd.Flags().BoolVar(&nameOnly, "name-only", false, "Show only names of changed files")
 	cmd.Flags().BoolVar(&nameStatus, "name-status", false, "Show names and status of changed files")
 	cmd.Flags().IntVarP(&unified, "unified", "u", 3, "Number of context lines")
+	cmd.Flags().BoolVar(&fullIndex, "full-index", false, "Show full blob IDs on index lines")
+	cmd.Flags().BoolVar(&binary, "binary", false, "Write binary patches of binary files")
+	cmd.Flags().BoolVar(&noTextconv, "no-textconv", false, "Diff the content of files, not their textconv output")
+	cmd.Flags().BoolVar(&noExtDiff, "no-ext-diff", false, "Do not run external diff programs")
+	cmd.Flags().BoolVar(&stat, "stat", false, "Show a diffstat instead of the diff")
+	addDetectionFlags(cmd)
+	addAlgorithmFlags(cmd)
+	addWordDiffFlags(cmd)
+	addColorFlags(cmd)
+	addJSONFlags(cmd)
 
 	return cmd
 }
 
-func runDiff(repo *vcs.Repository, refManager *refs.RefManager, args []string, cached, nameOnly, nameStatus bool, unified int) error {
+// diffFormat is how diff shows the changes of a file
+type diffFormat struct {
+	// unified is how many unchanged lines surround each change
+	unified int
+	// algorithm finds the lines the two sides have in common
+	algorithm merge.DiffAlgorithm
+	// words shows the changes word by word when set
+	words *wordDiff
+	// fullIndex writes whole blob IDs on index lines, and binary writes
+	// the changes of binary files as patches that apply can apply
+	fullIndex, binary bool
+	// textconv diffs the output of the textconv of a file's diff driver,
+	// and extDiff runs its external diff program instead of diffing
+	textconv, extDiff bool
+	// converted is set for a file whose content textconv converted
+	converted bool
+	// colors paints the lines of the diff, nil for none
+	colors *palette
+	// stat shows a diffstat instead, written as JSON to json when set
+	stat bool
+	json io.Writer
+}
+
+// indexID returns id as an index line shows it, abbreviated unless format
+// asks for the full IDs
+func (f diffFormat) indexID(id objects.ObjectID) string {
+	if f.fullIndex {
+		return id.String()
+	}
+	return id.String()[:7]
+}
+
+// meta prints a header line of a file's diff in the meta color
+func (f diffFormat) meta(format string, args ...any) {
+	fmt.Println(f.colors.paint("meta", fmt.Sprintf(format, args...)))
+}
+
+// addAlgorithmFlags adds the flags readDiffAlgorithm reads
+func addAlgorithmFlags(cmd *cobra.Command) {
+	cmd.Flags().String("diff-algorithm", "", "Diff algorithm: myers, minimal, patience or histogram")
+	cmd.Flags().Bool("minimal", false, "Spend extra time to find the smallest diff")
+	cmd.Flags().Bool("patience", false, "Use the patience diff algorithm")
+	cmd.Flags().Bool("histogram", false, "Use the histogram diff algorithm")
+}
+
+// readDiffAlgorithm returns the diff algorithm --diff-algorithm, --minimal,
+// --patience or --histogram selects, of which only one may be given, or
+// else diff.algorithm
+func readDiffAlgorithm(cmd *cobra.Command, gitDir string) (merge.DiffAlgorithm, error) {
+	var names []string
+	if cmd.Flags().Changed("diff-algorithm") {
+		name, _ := cmd.Flags().GetString("diff-algorithm")
+		names = append(names, name)
+	}
+	for _, flag := range []string{"minimal", "patience", "histogram"} {
+		if set, _ := cmd.Flags().GetBool(flag); set {
+			names = append(names, flag)
+		}
+	}
+	switch len(names) {
+	case 0:
+		name, ok := loadConfig(gitDir).Get("diff.algorithm")
+		if !ok {
+			return merge.DiffMyers, nil
+		}
+		algorithm, err := merge.ParseDiffAlgorithm(name)
+		if err != nil {
+			return "", fmt.Errorf("bad diff.algorithm: %w", err)
+		}
+		return algorithm, nil
+	case 1:
+		return merge.ParseDiffAlgorithm(names[0])
+	}
+	return "", fmt.Errorf("only one of --diff-algorithm, --minimal, --patience and --histogram may be given")
+}
+
+// addDetectionFlags adds the flags readDetection reads: -M, -C and
+// --no-renames
+func addDetectionFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP("find-renames", "M", "", "Detect renames, optionally with a similarity threshold such as 90%")
+	cmd.Flags().Lookup("find-renames").NoOptDefVal = fmt.Sprintf("%d%%", history.DefaultRenameThreshold)
+	cmd.Flags().StringP("find-copies", "C", "", "Detect copies as well as renames, optionally with a similarity threshold")
+	cmd.Flags().Lookup("find-copies").NoOptDefVal = fmt.Sprintf("%d%%", history.DefaultRenameThreshold)
+	cmd.Flags().Bool("no-renames", false, "Turn off rename detection")
+}
+
+// readDetection returns the rename and copy detection the flags of cmd ask
+// for. Without any, the first of configKeys set, such as diff.renames,
+// turns renames on with true and copies too with copies, and on is the
+// default. Plumbing commands read no config.
+func readDetection(cmd *cobra.Command, gitDir string, on bool, configKeys ...string) (history.Detection, error) {
+	var d history.Detection
+	if noRenames, _ := cmd.Flags().GetBool("no-renames"); noRenames {
+		return d, nil
+	}
+
+	if cmd.Flags().Changed("find-renames") || cmd.Flags().Changed("find-copies") {
+		var err error
+		d.Renames = history.DefaultRenameThreshold
+		if cmd.Flags().Changed("find-renames") {
+			value, _ := cmd.Flags().GetString("find-renames")
+			if d.Renames, err = parseRenameThreshold(value); err != nil {
+				return d, err
+			}
+		}
+		if cmd.Flags().Changed("find-copies") {
+			value, _ := cmd.Flags().GetString("find-copies")
+			if d.Copies, err = parseRenameThreshold(value); err != nil {
+				return d, err
+			}
+		}
+		return d, nil
+	}
+
+	copies := false
+	if len(configKeys) > 0 {
+		cfg := loadConfig(gitDir)
+		for _, key := range configKeys {
+			value, ok := cfg.Get(key)
+			if !ok {
+				continue
+			}
+			switch strings.ToLower(value) {
+			case "copies", "copy":
+				on, copies = true, true
+			default:
+				enabled, err := config.ParseBool(value)
+				if err != nil {
+					return d, fmt.Errorf("bad %s: %w", key, err)
+				}
+				on = enabled
+			}
+			break
+		}
+	}
+	if on {
+		d.Renames = history.DefaultRenameThreshold
+	}
+	if copies {
+		d.Copies = history.DefaultRenameThreshold
+	}
+	return d, nil
+}
+
+// parseRenameThreshold parses a -M similarity: a percentage such as "90%",
+// or digits read as a fraction the way Git does, so "9" also means 90%
+func parseRenameThreshold(value string) (int, error) {
+	if strings.HasSuffix(value, "%") {
+		percent, err := strconv.Atoi(strings.TrimSuffix(value, "%"))
+		if err != nil || percent < 0 || percent > 100 {
+			return 0, fmt.Errorf("invalid rename threshold %q", value)
+		}
+		return percent, nil
+	}
+	for _, c := range value {
+		if c < '0' || c > '9' {
+			return 0, fmt.Errorf("invalid rename threshold %q", value)
+		}
+	}
+	fraction, err := strconv.ParseFloat("0."+value, 64)
+	if err != nil {
+		return 0, fmt.Errorf("invalid rename threshold %q", value)
+	}
+	return int(fraction*100 + 0.5), nil
+}
+
+func runDiff(repo *vcs.Repository, refManager *refs.RefManager, args []string, cached, nameOnly, nameStatus bool, format diffFormat, d history.Detection) error {
 	if cached {
-		return diffIndexToHEAD(repo, refManager, nameOnly, nameStatus, unified)
+		return diffIndexToHEAD(repo, refManager, nameOnly, nameStatus, format, d)
 	}
 
 	switch len(args) {
 	case 0:
-		return diffWorkingTreeToIndex(repo, nameOnly, nameStatus, unified)
+		return diffWorkingTreeToIndex(repo, nameOnly, nameStatus, format, d)
 	case 1:
-		return diffCommitToWorkingTree(repo, refManager, args[0], nameOnly, nameStatus, unified)
+		from, to, ok, err := diffRange(repo, args[0])
+		if err != nil {
+			return err
+		}
+		if ok {
+			return diffCommitToCommit(repo, refManager, from, to, nameOnly, nameStatus, format, d)
+		}
+		return diffCommitToWorkingTree(repo, refManager, args[0], nameOnly, nameStatus, format, d)
 	case 2:
-		return diffCommitToCommit(repo, refManager, args[0], args[1], nameOnly, nameStatus, unified)
+		return diffCommitToCommit(repo, refManager, args[0], args[1], nameOnly, nameStatus, format, d)
 	default:
 		return fmt.Errorf("too many arguments")
 	}
 }
 
-func diffWorkingTreeToIndex(repo *vcs.Repository, nameOnly, nameStatus bool, unified int) error {
-	idx := index.New()
-	indexPath := filepath.Join(repo.GitDir(), "index")
-	
-	if _, err := os.Stat(indexPath); err == nil {
-		if err := idx.ReadFromFile(indexPath); err != nil {
-			return fmt.Errorf("failed to read index: %w", err)
-		}
+// diffRange returns the commits to compare for A..B, which are A and B, and
+// for A...B, which are the merge base of A and B and B. An empty side
+// stands for HEAD.
+func diffRange(repo *vcs.Repository, arg string) (from, to string, ok bool, err error) {
+	if !revparse.IsRange(arg) || !strings.Contains(arg, "..") {
+		return "", "", false, nil
+	}
+	from, to, _ = strings.Cut(arg, "..")
+	symmetric := strings.HasPrefix(to, ".")
+	to = strings.TrimPrefix(to, ".")
+	if from == "" {
+		from = "HEAD"
+	}
+	if to == "" {
+		to = "HEAD"
+	}
+	if !symmetric {
+		return from, to, true, nil
 	}
 
-	// Get working tree files
+	r, err := newResolver(repo).ResolveRange(arg)
+	if err != nil {
+		return "", "", false, err
+	}
+	if len(r.Exclude) == 0 {
+		return "", "", false, fmt.Errorf("%s and %s have no merge base", from, to)
+	}
+	return r.Exclude[0].String(), to, true, nil
+}
+
+func diffWorkingTreeToIndex(repo *vcs.Repository, nameOnly, nameStatus bool, format diffFormat, d history.Detection) error {
+	idx, err := readIndex(repo)
+	if err != nil {
+		return err
+	}
+
+	// Get working tree files, as they would be stored
+	conv := newConverter(repo, os.Stderr, nil)
 	workingFiles := make(map[string]*WorkingFile)
-	err := filepath.Walk(repo.WorkDir(), func(path string, info os.FileInfo, err error) error {
+	err = filepath.Walk(repo.WorkDir(), func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return err
 		}
@@ -100,8 +396,11 @@ func diffWorkingTreeToIndex(repo *vcs.Repository, nameOnly, nameStatus bool, uni
 		if err != nil {
 			return err
 		}
+		if content, err = conv.Clean(filepath.ToSlash(relPath), content); err != nil {
+			return err
+		}
 
-		blob := repo.CreateBlobDirect(content)
+		blob := objects.NewBlob(content)
 		workingFiles[relPath] = &WorkingFile{
 			Path:    relPath,
 			Content: content,
@@ -153,10 +452,10 @@ func diffWorkingTreeToIndex(repo *vcs.Repository, nameOnly, nameStatus bool, uni
 		}
 	}
 
-	return printDiff(changes, nameOnly, nameStatus, unified)
+	return printDiff(repo, changes, nameOnly, nameStatus, format, d)
 }
 
-func diffIndexToHEAD(repo *vcs.Repository, refManager *refs.RefManager, nameOnly, nameStatus bool, unified int) error {
+func diffIndexToHEAD(repo *vcs.Repository, refManager *refs.RefManager, nameOnly, nameStatus bool, format diffFormat, d history.Detection) error {
 	// Get HEAD commit
 	headID, err := refManager.ResolveRef("HEAD")
 	if err != nil {
@@ -168,26 +467,17 @@ func diffIndexToHEAD(repo *vcs.Repository, refManager *refs.RefManager, nameOnly
 		return fmt.Errorf("failed to get HEAD commit: %w", err)
 	}
 
-	headTree, err := repo.GetTree(headCommit.Tree())
-	if err != nil {
-		return fmt.Errorf("failed to get HEAD tree: %w", err)
-	}
-
 	// Get index
-	idx := index.New()
-	indexPath := filepath.Join(repo.GitDir(), "index")
-	
-	if _, err := os.Stat(indexPath); err == nil {
-		if err := idx.ReadFromFile(indexPath); err != nil {
-			return fmt.Errorf("failed to read index: %w", err)
-		}
+	idx, err := readIndex(repo)
+	if err != nil {
+		return err
 	}
 
-	return diffTreeToIndex(repo, headTree, idx, nameOnly, nameStatus, unified)
+	return diffTreeToIndex(repo, headCommit.Tree(), idx, nameOnly, nameStatus, format, d)
 }
 
-func diffCommitToWorkingTree(repo *vcs.Repository, refManager *refs.RefManager, commitRef string, nameOnly, nameStatus bool, unified int) error {
-	commitID, err := refManager.ResolveRef(commitRef)
+func diffCommitToWorkingTree(repo *vcs.Repository, refManager *refs.RefManager, commitRef string, nameOnly, nameStatus bool, format diffFormat, d history.Detection) error {
+	commitID, err := resolveCommitish(repo, commitRef)
 	if err != nil {
 		return fmt.Errorf("failed to resolve ref %q: %w", commitRef, err)
 	}
@@ -197,21 +487,16 @@ func diffCommitToWorkingTree(repo *vcs.Repository, refManager *refs.RefManager,
 		return fmt.Errorf("failed to get commit: %w", err)
 	}
 
-	tree, err := repo.GetTree(commit.Tree())
-	if err != nil {
-		return fmt.Errorf("failed to get tree: %w", err)
-	}
-
-	return diffTreeToWorkingTree(repo, tree, nameOnly, nameStatus, unified)
+	return diffTreeToWorkingTree(repo, commit.Tree(), nameOnly, nameStatus, format, d)
 }
 
-func diffCommitToCommit(repo *vcs.Repository, refManager *refs.RefManager, commit1Ref, commit2Ref string, nameOnly, nameStatus bool, unified int) error {
-	commit1ID, err := refManager.ResolveRef(commit1Ref)
+func diffCommitToCommit(repo *vcs.Repository, refManager *refs.RefManager, commit1Ref, commit2Ref string, nameOnly, nameStatus bool, format diffFormat, d history.Detection) error {
+	commit1ID, err := resolveCommitish(repo, commit1Ref)
 	if err != nil {
 		return fmt.Errorf("failed to resolve ref %q: %w", commit1Ref, err)
 	}
 
-	commit2ID, err := refManager.ResolveRef(commit2Ref)
+	commit2ID, err := resolveCommitish(repo, commit2Ref)
 	if err != nil {
 		return fmt.Errorf("failed to resolve ref %q: %w", commit2Ref, err)
 	}
@@ -226,27 +511,17 @@ func diffCommitToCommit(repo *vcs.Repository, refManager *refs.RefManager, commi
 		return fmt.Errorf("failed to get commit2: %w", err)
 	}
 
-	tree1, err := repo.GetTree(commit1.Tree())
-	if err != nil {
-		return fmt.Errorf("failed to get tree1: %w", err)
-	}
+	return diffTreeToTree(repo, commit1.Tree(), commit2.Tree(), nameOnly, nameStatus, format, d)
+}
 
-	tree2, err := repo.GetTree(commit2.Tree())
+// diffTreeToIndex compares the files of a tree, at every depth, with those
+// the index stages
+func diffTreeToIndex(repo *vcs.Repository, treeID objects.ObjectID, idx *index.Index, nameOnly, nameStatus bool, format diffFormat, d history.Detection) error {
+	treeEntries, err := readTreeFiles(repo, treeID)
 	if err != nil {
-		return fmt.Errorf("failed to get tree2: %w", err)
+		return err
 	}
-
-	return diffTreeToTree(repo, tree1, tree2, nameOnly, nameStatus, unified)
-}
-
-func diffTreeToIndex(repo *vcs.Repository, tree *objects.Tree, idx *index.Index, nameOnly, nameStatus bool, unified int) error {
 	changes := make(map[string]*DiffChange)
-	
-	// Get tree entries
-	treeEntries := make(map[string]objects.TreeEntry)
-	for _, entry := range tree.Entries() {
-		treeEntries[entry.Name] = entry
-	}
 
 	// Compare tree to index
 	for _, entry := range idx.Entries() {
@@ -283,49 +558,46 @@ func diffTreeToIndex(repo *vcs.Repository, tree *objects.Tree, idx *index.Index,
 		}
 	}
 
-	return printDiff(changes, nameOnly, nameStatus, unified)
+	return printDiff(repo, changes, nameOnly, nameStatus, format, d)
 }
 
-func diffTreeToWorkingTree(repo *vcs.Repository, tree *objects.Tree, nameOnly, nameStatus bool, unified int) error {
-	// Get working tree files
-	workingFiles := make(map[string]*WorkingFile)
-	err := filepath.Walk(repo.WorkDir(), func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return err
-		}
-
-		relPath, err := filepath.Rel(repo.WorkDir(), path)
-		if err != nil {
-			return err
-		}
+// diffTreeToWorkingTree compares the files of a tree, at every depth, with
+// those of the working tree. Only the paths the index tracks count as
+// working files, so untracked files are left out as in Git.
+func diffTreeToWorkingTree(repo *vcs.Repository, treeID objects.ObjectID, nameOnly, nameStatus bool, format diffFormat, d history.Detection) error {
+	entries, err := merge.ReadTree(repo, treeID)
+	if err != nil {
+		return fmt.Errorf("failed to read tree: %w", err)
+	}
+	idx, err := readIndex(repo)
+	if err != nil {
+		return err
+	}
 
-		if strings.HasPrefix(relPath, ".git") || info.IsDir() {
-			return nil
+	// Get the tracked working files, as they would be stored
+	conv := newConverter(repo, os.Stderr, nil)
+	workingFiles := make(map[string]*WorkingFile)
+	for _, entry := range idx.Entries() {
+		if entry.Mode == objects.ModeCommit || workingFiles[entry.Path] != nil {
+			continue
 		}
-
-		content, err := os.ReadFile(path)
+		file, err := readWorkingFile(repo, conv, entry.Path)
 		if err != nil {
 			return err
 		}
-
-		blob := repo.CreateBlobDirect(content)
-		workingFiles[relPath] = &WorkingFile{
-			Path:    relPath,
-			Content: content,
-			ID:      blob.ID(),
+		if file.exists {
+			workingFiles[entry.Path] = &WorkingFile{Path: entry.Path, Content: file.content, ID: objects.NewBlob(file.content).ID()}
 		}
-		return nil
-	})
-	if err != nil {
-		return err
 	}
 
 	changes := make(map[string]*DiffChange)
-	
+
 	// Get tree entries
-	treeEntries := make(map[string]objects.TreeEntry)
-	for _, entry := range tree.Entries() {
-		treeEntries[entry.Name] = entry
+	treeEntries := make(map[string]merge.Entry)
+	for _, entry := range entries {
+		if entry.Mode != objects.ModeCommit {
+			treeEntries[entry.Path] = entry
+		}
 	}
 
 	// Compare tree to working tree
@@ -363,22 +635,20 @@ func diffTreeToWorkingTree(repo *vcs.Repository, tree *objects.Tree, nameOnly, n
 		}
 	}
 
-	return printDiff(changes, nameOnly, nameStatus, unified)
+	return printDiff(repo, changes, nameOnly, nameStatus, format, d)
 }
 
-func diffTreeToTree(repo *vcs.Repository, tree1, tree2 *objects.Tree, nameOnly, nameStatus bool, unified int) error {
-	changes := make(map[string]*DiffChange)
-	
-	// Get tree entries
-	tree1Entries := make(map[string]objects.TreeEntry)
-	for _, entry := range tree1.Entries() {
-		tree1Entries[entry.Name] = entry
+// diffTreeToTree compares the files of two trees at every depth
+func diffTreeToTree(repo *vcs.Repository, tree1, tree2 objects.ObjectID, nameOnly, nameStatus bool, format diffFormat, d history.Detection) error {
+	tree1Entries, err := readTreeFiles(repo, tree1)
+	if err != nil {
+		return err
 	}
-
-	tree2Entries := make(map[string]objects.TreeEntry)
-	for _, entry := range tree2.Entries() {
-		tree2Entries[entry.Name] = entry
+	tree2Entries, err := readTreeFiles(repo, tree2)
+	if err != nil {
+		return err
 	}
+	changes := make(map[string]*DiffChange)
 
 	// All unique paths
 	allPaths := make(map[string]bool)
@@ -421,7 +691,20 @@ func diffTreeToTree(repo *vcs.Repository, tree1, tree2 *objects.Tree, nameOnly,
 		}
 	}
 
-	return printDiff(changes, nameOnly, nameStatus, unified)
+	return printDiff(repo, changes, nameOnly, nameStatus, format, d)
+}
+
+// readTreeFiles returns the files of a tree at every depth by path
+func readTreeFiles(repo *vcs.Repository, treeID objects.ObjectID) (map[string]merge.Entry, error) {
+	entries, err := merge.ReadTree(repo, treeID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tree: %w", err)
+	}
+	files := make(map[string]merge.Entry, len(entries))
+	for _, entry := range entries {
+		files[entry.Path] = entry
+	}
+	return files, nil
 }
 
 type DiffType int
@@ -430,6 +713,8 @@ const (
 	DiffAdded DiffType = iota
 	DiffModified
 	DiffDeleted
+	DiffRenamed
+	DiffCopied
 )
 
 type DiffChange struct {
@@ -439,6 +724,10 @@ type DiffChange struct {
 	NewID      objects.ObjectID
 	OldContent []byte
 	NewContent []byte
+	// OldPath and Score describe where a renamed or copied file came from
+	// and how similar it still is, in percent
+	OldPath string
+	Score   int
 }
 
 type WorkingFile struct {
@@ -465,11 +754,77 @@ func getObjectContent(repo *vcs.Repository, id objects.ObjectID) []byte {
 	return blob.Data()
 }
 
-func printDiff(changes map[string]*DiffChange, nameOnly, nameStatus bool, unified int) error {
-	if len(changes) == 0 {
+// detectRenames replaces pairs of deleted and added files similar enough to
+// meet d.Renames with a single rename keyed by the new path, and with
+// d.Copies set, added files similar enough to a modified or deleted file
+// with a copy of it
+func detectRenames(changes map[string]*DiffChange, d history.Detection) {
+	var deleted, added, sources []history.Candidate
+	for path, change := range changes {
+		switch change.Type {
+		case DiffDeleted:
+			deleted = append(deleted, history.Candidate{Path: path, ID: change.OldID, Content: change.OldContent})
+		case DiffAdded:
+			added = append(added, history.Candidate{Path: path, ID: change.NewID, Content: change.NewContent})
+		case DiffModified:
+			sources = append(sources, history.Candidate{Path: path, ID: change.OldID, Content: change.OldContent})
+		}
+	}
+	if len(added) == 0 {
+		return
+	}
+	// Map order must not decide between equally good matches
+	for _, candidates := range [][]history.Candidate{deleted, added, sources} {
+		sort.Slice(candidates, func(i, j int) bool { return candidates[i].Path < candidates[j].Path })
+	}
+
+	var found []history.Rename
+	if d.Renames > 0 && len(deleted) > 0 {
+		found = history.MatchRenames(deleted, added, d.Renames)
+	}
+	if d.Copies > 0 {
+		renamed := make(map[string]bool)
+		for _, rename := range found {
+			renamed[rename.To.Path] = true
+		}
+		var rest []history.Candidate
+		for _, c := range added {
+			if !renamed[c.Path] {
+				rest = append(rest, c)
+			}
+		}
+		found = append(found, history.MatchCopies(append(sources, deleted...), rest, d.Copies)...)
+	}
+
+	for _, rename := range found {
+		change := &DiffChange{
+			Path:       rename.To.Path,
+			Type:       DiffRenamed,
+			OldID:      rename.From.ID,
+			NewID:      rename.To.ID,
+			OldContent: rename.From.Content,
+			NewContent: rename.To.Content,
+			OldPath:    rename.From.Path,
+			Score:      rename.Score,
+		}
+		if rename.Copy {
+			change.Type = DiffCopied
+		} else {
+			delete(changes, rename.From.Path)
+		}
+		changes[rename.To.Path] = change
+	}
+}
+
+func printDiff(repo *vcs.Repository, changes map[string]*DiffChange, nameOnly, nameStatus bool, format diffFormat, d history.Detection) error {
+	if len(changes) == 0 && format.json == nil {
 		return nil
 	}
 
+	if d.Renames > 0 || d.Copies > 0 {
+		detectRenames(changes, d)
+	}
+
 	// Sort paths for consistent output
 	paths := make([]string, 0, len(changes))
 	for path := range changes {
@@ -477,6 +832,10 @@ func printDiff(changes map[string]*DiffChange, nameOnly, nameStatus bool, unifie
 	}
 	sort.Strings(paths)
 
+	if format.stat || format.json != nil {
+		return printDiffStat(repo, paths, changes, format)
+	}
+
 	if nameOnly {
 		for _, path := range paths {
 			fmt.Println(path)
@@ -495,6 +854,12 @@ func printDiff(changes map[string]*DiffChange, nameOnly, nameStatus bool, unifie
 				status = "M"
 			case DiffDeleted:
 				status = "D"
+			case DiffRenamed:
+				fmt.Printf("R%03d\t%s\t%s\n", change.Score, change.OldPath, path)
+				continue
+			case DiffCopied:
+				fmt.Printf("C%03d\t%s\t%s\n", change.Score, change.OldPath, path)
+				continue
 			}
 			fmt.Printf("%s\t%s\n", status, path)
 		}
@@ -502,133 +867,188 @@ func printDiff(changes map[string]*DiffChange, nameOnly, nameStatus bool, unifie
 	}
 
 	// Full diff output
+	conv := newConverter(repo, io.Discard, nil)
+	drivers := newDiffDrivers(repo, conv)
 	for _, path := range paths {
 		change := changes[path]
 		
+		if format.extDiff {
+			if program := drivers.command(path); program != "" {
+				if err := drivers.runExternal(program, path, change); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		format := format
+		if format.textconv {
+			converted, err := drivers.textconvChange(path, change)
+			if err != nil {
+				return err
+			}
+			if converted != nil {
+				change = converted
+				format.converted = true
+			}
+		}
+
+		// A binary patch needs the full blob IDs to apply
+		if format.binary && !format.converted && (conv.DiffBinary(path, change.OldContent) || conv.DiffBinary(path, change.NewContent)) {
+			format.fullIndex = true
+		}
+
 		switch change.Type {
 		case DiffAdded:
-			fmt.Printf("diff --git a/%s b/%s\n", path, path)
-			fmt.Println("new file mode 100644")
-			fmt.Printf("index 0000000..%s\n", change.NewID.String()[:7])
-			fmt.Println("--- /dev/null")
-			fmt.Printf("+++ b/%s\n", path)
-			printUnifiedDiff(nil, change.NewContent, unified)
+			format.meta("diff --git a/%s b/%s", path, path)
+			format.meta("new file mode 100644")
+			format.meta("index %s..%s", format.indexID(objects.ObjectID{}), format.indexID(change.NewID))
+			printContentDiff(conv, path, "/dev/null", "b/"+path, nil, change.NewContent, format)
 		case DiffDeleted:
-			fmt.Printf("diff --git a/%s b/%s\n", path, path)
-			fmt.Println("deleted file mode 100644")
-			fmt.Printf("index %s..0000000\n", change.OldID.String()[:7])
-			fmt.Printf("--- a/%s\n", path)
-			fmt.Println("+++ /dev/null")
-			printUnifiedDiff(change.OldContent, nil, unified)
+			format.meta("diff --git a/%s b/%s", path, path)
+			format.meta("deleted file mode 100644")
+			format.meta("index %s..%s", format.indexID(change.OldID), format.indexID(objects.ObjectID{}))
+			printContentDiff(conv, path, "a/"+path, "/dev/null", change.OldContent, nil, format)
 		case DiffModified:
-			fmt.Printf("diff --git a/%s b/%s\n", path, path)
-			fmt.Printf("index %s..%s 100644\n", change.OldID.String()[:7], change.NewID.String()[:7])
-			fmt.Printf("--- a/%s\n", path)
-			fmt.Printf("+++ b/%s\n", path)
-			printUnifiedDiff(change.OldContent, change.NewContent, unified)
+			format.meta("diff --git a/%s b/%s", path, path)
+			format.meta("index %s..%s 100644", format.indexID(change.OldID), format.indexID(change.NewID))
+			printContentDiff(conv, path, "a/"+path, "b/"+path, change.OldContent, change.NewContent, format)
+		case DiffRenamed, DiffCopied:
+			kind := "rename"
+			if change.Type == DiffCopied {
+				kind = "copy"
+			}
+			format.meta("diff --git a/%s b/%s", change.OldPath, path)
+			format.meta("similarity index %d%%", change.Score)
+			format.meta("%s from %s", kind, change.OldPath)
+			format.meta("%s to %s", kind, path)
+			if change.OldID != change.NewID {
+				format.meta("index %s..%s 100644", format.indexID(change.OldID), format.indexID(change.NewID))
+				printContentDiff(conv, path, "a/"+change.OldPath, "b/"+path, change.OldContent, change.NewContent, format)
+			}
 		}
 		fmt.Println()
 	}
 
-	return nil
+	return drivers.flush()
 }
 
-func printUnifiedDiff(oldContent, newContent []byte, contextLines int) {
-	oldLines := strings.Split(string(oldContent), "\n")
-	newLines := strings.Split(string(newContent), "\n")
-	
-	if len(oldLines) == 1 && oldLines[0] == "" {
-		oldLines = nil
+// printDiffStat prints the diffstat of changes, as JSON when format asks
+func printDiffStat(repo *vcs.Repository, paths []string, changes map[string]*DiffChange, format diffFormat) error {
+	conv := newConverter(repo, io.Discard, nil)
+	drivers := newDiffDrivers(repo, conv)
+	diffs := make([]*fileDiff, 0, len(paths))
+	for _, path := range paths {
+		change := changes[path]
+		converted := false
+		if format.textconv {
+			textconv, err := drivers.textconvChange(path, change)
+			if err != nil {
+				return err
+			}
+			if textconv != nil {
+				change, converted = textconv, true
+			}
+		}
+		diffs = append(diffs, statFileDiff(conv, path, change, converted, format))
 	}
-	if len(newLines) == 1 && newLines[0] == "" {
-		newLines = nil
+	if err := drivers.flush(); err != nil {
+		return err
 	}
 
-	// Simple line-by-line diff
-	maxLen := len(oldLines)
-	if len(newLines) > maxLen {
-		maxLen = len(newLines)
+	if format.json != nil {
+		return writeJSON(format.json, newJSONStat(diffs))
+	}
+	if len(diffs) > 0 {
+		writeDiffStat(os.Stdout, diffs)
 	}
+	return nil
+}
 
-	if maxLen == 0 {
-		return
+// statFileDiff returns the diff of change for a diffstat, which only
+// counts the lines of files that are not binary or were converted
+func statFileDiff(conv *convert.Converter, path string, change *DiffChange, converted bool, format diffFormat) *fileDiff {
+	d := &fileDiff{change: history.Change{
+		Path:    path,
+		OldPath: change.OldPath,
+		OldID:   change.OldID,
+		NewID:   change.NewID,
+		Score:   change.Score,
+	}}
+	switch change.Type {
+	case DiffAdded:
+		d.change.Type = history.Added
+	case DiffDeleted:
+		d.change.Type = history.Deleted
+	case DiffRenamed:
+		d.change.Type = history.Renamed
+	case DiffCopied:
+		d.change.Type = history.Copied
+	default:
+		d.change.Type = history.Modified
+	}
+	if !converted && (conv.DiffBinary(path, change.OldContent) || conv.DiffBinary(path, change.NewContent)) {
+		d.binary = true
+		d.oldData, d.newData = change.OldContent, change.NewContent
+		return d
 	}
+	d.hunks = interactive.DiffWith(change.OldContent, change.NewContent, format.unified, format.algorithm)
+	return d
+}
 
-	// Find first and last different lines
-	firstDiff := -1
-	lastDiff := -1
-	
-	for i := 0; i < maxLen; i++ {
-		oldLine := ""
-		newLine := ""
-		
-		if i < len(oldLines) {
-			oldLine = oldLines[i]
-		}
-		if i < len(newLines) {
-			newLine = newLines[i]
+// printContentDiff prints the changes between oldContent and newContent as
+// format asks. When either is binary by the attributes of path it prints a
+// binary patch with --binary and otherwise only that they differ. Content
+// converted by textconv is shown as text, and nothing is printed when the
+// conversions are the same.
+func printContentDiff(conv *convert.Converter, path, oldName, newName string, oldContent, newContent []byte, format diffFormat) {
+	if format.converted {
+		if bytes.Equal(oldContent, newContent) {
+			return
 		}
-		
-		if oldLine != newLine {
-			if firstDiff == -1 {
-				firstDiff = i
-			}
-			lastDiff = i
+	} else if conv.DiffBinary(path, oldContent) || conv.DiffBinary(path, newContent) {
+		if format.binary {
+			patch.WriteBinary(os.Stdout, oldContent, newContent)
+			return
 		}
+		fmt.Printf("Binary files %s and %s differ\n", oldName, newName)
+		return
 	}
+	format.meta("--- %s", oldName)
+	format.meta("+++ %s", newName)
+	printUnifiedDiff(oldContent, newContent, format)
+}
 
-	if firstDiff == -1 {
-		return // No differences
-	}
-
-	// Calculate hunk boundaries
-	hunkStart := firstDiff - contextLines
-	if hunkStart < 0 {
-		hunkStart = 0
+// printUnifiedDiff prints the hunks changing oldContent into newContent,
+// word by word when format asks for it
+func printUnifiedDiff(oldContent, newContent []byte, format diffFormat) {
+	hunks := interactive.DiffWith(oldContent, newContent, format.unified, format.algorithm)
+	if format.words != nil {
+		format.words.write(os.Stdout, hunks)
+		return
 	}
-	
-	hunkEnd := lastDiff + contextLines
-	if hunkEnd >= maxLen {
-		hunkEnd = maxLen - 1
+	for _, hunk := range hunks {
+		printHunk(hunk, format.colors)
 	}
+}
 
-	// Count old and new lines in hunk
-	oldCount := 0
-	newCount := 0
-	
-	for i := hunkStart; i <= hunkEnd; i++ {
-		if i < len(oldLines) {
-			oldCount++
-		}
-		if i < len(newLines) {
-			newCount++
-		}
+// printHunk prints hunk with its header and lines in the colors of colors
+func printHunk(hunk *interactive.Hunk, colors *palette) {
+	if colors == nil {
+		fmt.Print(hunk.String())
+		return
 	}
-
-	// Print hunk header
-	fmt.Printf("@@ -%d,%d +%d,%d @@\n", hunkStart+1, oldCount, hunkStart+1, newCount)
-
-	// Print hunk content
-	for i := hunkStart; i <= hunkEnd; i++ {
-		oldLine := ""
-		newLine := ""
-		
-		if i < len(oldLines) {
-			oldLine = oldLines[i]
+	fmt.Println(colors.paint("frag", hunk.Header()))
+	for _, line := range hunk.Lines {
+		slot := "context"
+		switch line.Op {
+		case '-':
+			slot = "old"
+		case '+':
+			slot = "new"
 		}
-		if i < len(newLines) {
-			newLine = newLines[i]
-		}
-
-		if oldLine == newLine {
-			fmt.Printf(" %s\n", oldLine)
-		} else {
-			if i < len(oldLines) {
-				fmt.Printf("-%s\n", oldLine)
-			}
-			if i < len(newLines) {
-				fmt.Printf("+%s\n", newLine)
-			}
+		fmt.Println(colors.paint(slot, string(line.Op)+strings.TrimSuffix(line.Text, "\n")))
+		if !strings.HasSuffix(line.Text, "\n") {
+			fmt.Println("\\ No newline at end of file")
 		}
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/fenilsonani/vcs/internal/core/convert"
+	"github.com/fenilsonani/vcs/internal/core/notes"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// textconvRefPrefix is where the notes caching the textconv output of each
+// diff driver are kept, as refs/notes/textconv/<driver>
+const textconvRefPrefix = "refs/notes/textconv/"
+
+// diffDrivers runs the commands of the diff drivers the diff attribute of
+// each path names: textconv, whose output is diffed in place of the
+// content, and external diff programs, which replace the built-in diff
+type diffDrivers struct {
+	repo *vcs.Repository
+	conv *convert.Converter
+	// external is $GIT_EXTERNAL_DIFF or diff.external, the program for
+	// files whose driver has none
+	external string
+	caches   map[string]*textconvCache
+}
+
+// textconvCache is the textconv output of a driver kept as notes on the
+// blobs it was made from. The notes commit message records the textconv
+// command, and a cache made by another command is not used.
+type textconvCache struct {
+	command string
+	notes   *notes.Notes
+	changed bool
+}
+
+func newDiffDrivers(repo *vcs.Repository, conv *convert.Converter) *diffDrivers {
+	external := os.Getenv("GIT_EXTERNAL_DIFF")
+	if external == "" {
+		external, _ = loadConfig(repo.GitDir()).Get("diff.external")
+	}
+	return &diffDrivers{repo: repo, conv: conv, external: external, caches: make(map[string]*textconvCache)}
+}
+
+// command returns the external diff program for path, empty for none
+func (d *diffDrivers) command(path string) string {
+	if _, driver, ok := d.conv.DiffDriver(path); ok && driver.Command != "" {
+		return driver.Command
+	}
+	return d.external
+}
+
+// runExternal runs program on change as Git does, with the path, then the
+// file, blob ID and mode of each side, where a missing side is /dev/null
+// with "." for its ID and mode. A rename or copy adds the new path and the
+// header lines describing it.
+func (d *diffDrivers) runExternal(program, path string, change *DiffChange) error {
+	args := []string{path}
+	for _, side := range []struct {
+		id      objects.ObjectID
+		content []byte
+		exists  bool
+	}{
+		{change.OldID, change.OldContent, change.Type != DiffAdded},
+		{change.NewID, change.NewContent, change.Type != DiffDeleted},
+	} {
+		if !side.exists {
+			args = append(args, os.DevNull, ".", ".")
+			continue
+		}
+		tmp, err := os.CreateTemp("", "*_"+filepath.Base(path))
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+		_, err = tmp.Write(side.content)
+		if closeErr := tmp.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+		args = append(args, tmp.Name(), side.id.String(), "100644")
+	}
+	if change.Type == DiffRenamed || change.Type == DiffCopied {
+		kind := "rename"
+		if change.Type == DiffCopied {
+			kind = "copy"
+		}
+		args[0] = change.OldPath
+		args = append(args, path, fmt.Sprintf("similarity index %d%%\n%s from %s\n%s to %s\n",
+			change.Score, kind, change.OldPath, kind, path))
+	}
+
+	cmd := exec.Command("sh", append([]string{"-c", program + ` "$@"`, program}, args...)...)
+	cmd.Dir = d.repo.WorkDir()
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("external diff died, stopping at %s", path)
+	}
+	return nil
+}
+
+// textconv returns data, the content of blob id at path, converted by the
+// textconv of path's diff driver, or reports false when it has none. The
+// output is taken from and kept in the driver's cache when it has one.
+func (d *diffDrivers) textconv(path string, id objects.ObjectID, data []byte) ([]byte, bool, error) {
+	name, driver, ok := d.conv.DiffDriver(path)
+	if !ok || driver.Textconv == "" {
+		return nil, false, nil
+	}
+	var cache *textconvCache
+	if driver.CacheTextconv && !id.IsZero() {
+		var err error
+		if cache, err = d.cache(name, driver.Textconv); err != nil {
+			return nil, false, err
+		}
+		if text, ok, err := cache.notes.Text(id); err == nil && ok {
+			return []byte(text), true, nil
+		}
+	}
+
+	out, ok, err := d.conv.Textconv(path, data)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	if cache != nil {
+		if err := cache.notes.Set(id, string(out)); err != nil {
+			return nil, false, err
+		}
+		cache.changed = true
+	}
+	return out, true, nil
+}
+
+// cache returns the textconv cache of driver name, reading it the first
+// time it is asked for
+func (d *diffDrivers) cache(name, command string) (*textconvCache, error) {
+	if cache, ok := d.caches[name]; ok {
+		return cache, nil
+	}
+	ref := textconvRefPrefix + name
+	tip := objects.ObjectID{}
+	if id, err := refs.NewRefManager(d.repo.GitDir()).ResolveRef(ref); err == nil {
+		if commit, err := d.repo.GetCommit(id); err == nil && commit.Message() == command+"\n" {
+			tip = id
+		}
+	}
+	n, err := notes.Read(d.repo, tip)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", ref, err)
+	}
+	cache := &textconvCache{command: command, notes: n}
+	d.caches[name] = cache
+	return cache, nil
+}
+
+// flush commits the textconv caches that changed and moves their refs
+func (d *diffDrivers) flush() error {
+	refManager := refs.NewRefManager(d.repo.GitDir())
+	for name, cache := range d.caches {
+		if !cache.changed {
+			continue
+		}
+		sig, err := getSignature("")
+		if err != nil {
+			return err
+		}
+		tip, err := cache.notes.Commit(sig, sig, cache.command+"\n")
+		if err != nil {
+			return err
+		}
+		if err := refManager.UpdateRef(textconvRefPrefix+name, tip); err != nil {
+			return fmt.Errorf("failed to update %s: %w", textconvRefPrefix+name, err)
+		}
+		cache.changed = false
+	}
+	return nil
+}
+
+// textconvChange returns a copy of change with the content of each side
+// converted by the textconv of its path's diff driver, or nil when neither
+// has one
+func (d *diffDrivers) textconvChange(path string, change *DiffChange) (*DiffChange, error) {
+	oldPath := path
+	if change.OldPath != "" {
+		oldPath = change.OldPath
+	}
+	converted := *change
+	found := false
+	if change.Type != DiffAdded {
+		out, ok, err := d.textconv(oldPath, change.OldID, change.OldContent)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			converted.OldContent, found = out, true
+		}
+	}
+	if change.Type != DiffDeleted {
+		out, ok, err := d.textconv(path, change.NewID, change.NewContent)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			converted.NewContent, found = out, true
+		}
+	}
+	if !found {
+		return nil, nil
+	}
+	return &converted, nil
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/notes"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+func runDiffCommandArgs(t *testing.T, args ...string) string {
+	out, err := captureStdout(t, func() error {
+		cmd := newDiffCommand()
+		cmd.SetArgs(args)
+		return cmd.Execute()
+	})
+	require.NoError(t, err)
+	return out
+}
+
+func TestDiffTextconv(t *testing.T) {
+	setupTreeRepo(t, map[string]string{".gitattributes": "*.pdf diff=upper\n", "doc.pdf": "hello\x00\n"})
+	require.NoError(t, os.WriteFile("doc.pdf", []byte("world\x00\n"), 0644))
+
+	// Without a configured driver the content is binary
+	assert.Contains(t, runDiffCommandArgs(t), "Binary files a/doc.pdf and b/doc.pdf differ\n")
+
+	for key, value := range map[string]string{
+		"diff.upper.textconv":      "tr a-z A-Z <",
+		"diff.upper.cachetextconv": "true",
+	} {
+		_, err := runConfigArgs(key, value)
+		require.NoError(t, err)
+	}
+	out := runDiffCommandArgs(t)
+	assert.Contains(t, out, "--- a/doc.pdf\n+++ b/doc.pdf\n@@ -1 +1 @@\n-HELLO\x00\n+WORLD\x00\n")
+	assert.Contains(t, runDiffCommandArgs(t, "--no-textconv"), "Binary files a/doc.pdf and b/doc.pdf differ\n")
+
+	// The output is cached as notes on each blob, with the command
+	repoPath, err := findRepository()
+	require.NoError(t, err)
+	repo, err := vcs.Open(repoPath)
+	require.NoError(t, err)
+	tip, err := refs.NewRefManager(repo.GitDir()).ResolveRef("refs/notes/textconv/upper")
+	require.NoError(t, err)
+	cached, err := notes.Read(repo, tip)
+	require.NoError(t, err)
+	text, ok, err := cached.Text(objects.NewBlob([]byte("world\x00\n")).ID())
+	require.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "WORLD\x00\n", text)
+	commit, err := repo.GetCommit(tip)
+	require.NoError(t, err)
+	assert.Equal(t, "tr a-z A-Z <\n", commit.Message())
+
+	// A cache made by another command is not used
+	_, err = runConfigArgs("diff.upper.textconv", "tr a-z b-za <")
+	require.NoError(t, err)
+	assert.Contains(t, runDiffCommandArgs(t), "-ifmmp\x00\n+xpsme\x00\n")
+}
+
+func TestDiffExternal(t *testing.T) {
+	setupTreeRepo(t, map[string]string{".gitattributes": "*.dat diff=show\n", "a.dat": "old\n", "b.txt": "b\n"})
+	require.NoError(t, os.WriteFile("a.dat", []byte("new\n"), 0644))
+	require.NoError(t, os.WriteFile("b.txt", []byte("B\n"), 0644))
+	_, err := runConfigArgs("diff.show.command", `f() { echo "$1 $3 $4 $6 $7"; cat "$2" "$5"; }; f`)
+	require.NoError(t, err)
+
+	out := runDiffCommandArgs(t)
+	old, new := objects.NewBlob([]byte("old\n")).ID(), objects.NewBlob([]byte("new\n")).ID()
+	assert.Contains(t, out, "a.dat "+old.String()+" 100644 "+new.String()+" 100644\nold\nnew\n")
+	assert.NotContains(t, out, "diff --git a/a.dat")
+	assert.Contains(t, out, "diff --git a/b.txt b/b.txt\n")
+
+	out = runDiffCommandArgs(t, "--no-ext-diff")
+	assert.Contains(t, out, "diff --git a/a.dat b/a.dat\n")
+
+	// diff.external applies to files whose driver has no command
+	_, err = runConfigArgs("diff.external", "false")
+	require.NoError(t, err)
+	_, err = captureStdout(t, func() error {
+		return newDiffCommand().Execute()
+	})
+	assert.ErrorContains(t, err, "external diff died, stopping at")
+}
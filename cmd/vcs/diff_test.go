@@ -8,6 +8,10 @@ import (
 	"testing"
 	"time"
 
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/history"
 	"github.com/fenilsonani/vcs/internal/core/index"
 	"github.com/fenilsonani/vcs/internal/core/objects"
 	"github.com/fenilsonani/vcs/internal/core/refs"
@@ -327,7 +331,7 @@ func TestDiffWorkingTreeToIndex(t *testing.T) {
 			r, w, _ := os.Pipe()
 			os.Stdout = w
 
-			err := diffWorkingTreeToIndex(repo, tt.nameOnly, tt.nameStatus, 3)
+			err := diffWorkingTreeToIndex(repo, tt.nameOnly, tt.nameStatus, diffFormat{unified: 3}, history.Detection{})
 
 			w.Close()
 			os.Stdout = oldStdout
@@ -397,7 +401,7 @@ func TestPrintUnifiedDiff(t *testing.T) {
 			r, w, _ := os.Pipe()
 			os.Stdout = w
 
-			printUnifiedDiff(tt.oldContent, tt.newContent, tt.contextLines)
+			printUnifiedDiff(tt.oldContent, tt.newContent, diffFormat{unified: tt.contextLines})
 
 			w.Close()
 			os.Stdout = oldStdout
@@ -446,3 +450,250 @@ func TestGetObjectContent(t *testing.T) {
 	}
 }
 
+
+func TestParseRenameThreshold(t *testing.T) {
+	tests := []struct {
+		value   string
+		want    int
+		wantErr bool
+	}{
+		{"50%", 50, false},
+		{"100%", 100, false},
+		{"9", 90, false},
+		{"95", 95, false},
+		{"05", 5, false},
+		{"101%", 0, true},
+		{"abc", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseRenameThreshold(tt.value)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseRenameThreshold(%q) error = %v, wantErr %v", tt.value, err, tt.wantErr)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseRenameThreshold(%q) = %d, want %d", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestDiffFindRenames(t *testing.T) {
+	repo, commits := setupRenameRepo(t)
+	refManager := refs.NewRefManager(repo.GitDir())
+	if err := refManager.CreateBranch("before", commits[1]); err != nil {
+		t.Fatal(err)
+	}
+	if err := refManager.CreateBranch("after", commits[2]); err != nil {
+		t.Fatal(err)
+	}
+	from, to := "before", "after"
+
+	runDiffArgs := func(args ...string) string {
+		out, err := captureStdout(t, func() error {
+			cmd := newDiffCommand()
+			cmd.SetArgs(args)
+			return cmd.Execute()
+		})
+		if err != nil {
+			t.Fatalf("diff %v failed: %v", args, err)
+		}
+		return out
+	}
+
+	if out := runDiffArgs("--name-status", from, to); out != "A\tnew.txt\nD\told.txt\n" {
+		t.Errorf("diff --name-status = %q", out)
+	}
+	if out := runDiffArgs("--name-status", "-M", from, to); out != "R085\told.txt\tnew.txt\n" {
+		t.Errorf("diff --name-status -M = %q", out)
+	}
+	if out := runDiffArgs("--name-status", "--find-renames=90%", from, to); out != "A\tnew.txt\nD\told.txt\n" {
+		t.Errorf("diff --name-status --find-renames=90%% = %q", out)
+	}
+
+	out := runDiffArgs("-M", from, to)
+	for _, want := range []string{
+		"diff --git a/old.txt b/new.txt\n",
+		"similarity index 85%\n",
+		"rename from old.txt\nrename to new.txt\n",
+		"--- a/old.txt\n+++ b/new.txt\n",
+		"+six",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("diff -M missing %q\nGot: %s", want, out)
+		}
+	}
+}
+
+func TestDiffFindCopies(t *testing.T) {
+	repo, commits := setupRenameRepo(t)
+	body := "one\nTWO\nthree\nfour\nfive\n"
+	copied := commitFiles(t, repo, map[string]string{
+		"old.txt":   body + "seven\n",
+		"other.txt": "other\n",
+		"copy.txt":  body,
+	}, []objects.ObjectID{commits[1]}, "copy old\n")
+	from, to := commits[1].String(), copied.String()
+
+	runDiffArgs := func(args ...string) string {
+		out, err := captureStdout(t, func() error {
+			cmd := newDiffCommand()
+			cmd.SetArgs(args)
+			return cmd.Execute()
+		})
+		if err != nil {
+			t.Fatalf("diff %v failed: %v", args, err)
+		}
+		return out
+	}
+
+	if out := runDiffArgs("--name-status", "-M", from, to); out != "A\tcopy.txt\nM\told.txt\n" {
+		t.Errorf("diff --name-status -M = %q", out)
+	}
+	if out := runDiffArgs("--name-status", "-C", from, to); out != "C100\told.txt\tcopy.txt\nM\told.txt\n" {
+		t.Errorf("diff --name-status -C = %q", out)
+	}
+
+	out := runDiffArgs("-C", from, to)
+	for _, want := range []string{
+		"diff --git a/old.txt b/copy.txt\n",
+		"similarity index 100%\n",
+		"copy from old.txt\ncopy to copy.txt\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Errorf("diff -C missing %q\nGot: %s", want, out)
+		}
+	}
+
+	// diff.renames=copies turns copy detection on by default
+	if _, err := runConfigArgs("diff.renames", "copies"); err != nil {
+		t.Fatal(err)
+	}
+	if out := runDiffArgs("--name-status", from, to); out != "C100\told.txt\tcopy.txt\nM\told.txt\n" {
+		t.Errorf("diff --name-status with diff.renames=copies = %q", out)
+	}
+	if out := runDiffArgs("--name-status", "--no-renames", from, to); out != "A\tcopy.txt\nM\told.txt\n" {
+		t.Errorf("diff --name-status --no-renames = %q", out)
+	}
+}
+
+func TestDiffAlgorithmFlags(t *testing.T) {
+	setupTreeRepo(t, map[string]string{"f.txt": "B\n}\nx\nA\n"})
+	if err := os.WriteFile("f.txt", []byte("x\nx\nA\n}\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	runDiffArgs := func(args ...string) (string, error) {
+		out, err := captureStdout(t, func() error {
+			cmd := newDiffCommand()
+			cmd.SetArgs(args)
+			return cmd.Execute()
+		})
+		_, hunks, _ := strings.Cut(out, "+++ b/f.txt\n")
+		return hunks, err
+	}
+
+	// Anchored on the lines unique to both sides, the x before A is kept
+	anchored := "@@ -1,4 +1,4 @@\n-B\n-}\n+x\n x\n A\n+}\n\n"
+	for _, args := range [][]string{{"--patience"}, {"--histogram"}, {"--diff-algorithm=patience"}} {
+		out, err := runDiffArgs(args...)
+		if err != nil {
+			t.Fatalf("diff %v failed: %v", args, err)
+		}
+		if out != anchored {
+			t.Errorf("diff %v = %q, want %q", args, out, anchored)
+		}
+	}
+	if out, err := runDiffArgs("--minimal"); err != nil || out == anchored {
+		t.Errorf("diff --minimal = %q, %v", out, err)
+	}
+
+	if _, err := runConfigArgs("diff.algorithm", "histogram"); err != nil {
+		t.Fatal(err)
+	}
+	if out, err := runDiffArgs(); err != nil || out != anchored {
+		t.Errorf("diff with diff.algorithm=histogram = %q, %v", out, err)
+	}
+	if _, err := runDiffArgs("--patience", "--minimal"); err == nil || !strings.Contains(err.Error(), "only one of") {
+		t.Errorf("diff --patience --minimal error = %v", err)
+	}
+	if _, err := runDiffArgs("--diff-algorithm=fast"); err == nil || !strings.Contains(err.Error(), "unknown diff algorithm") {
+		t.Errorf("diff --diff-algorithm=fast error = %v", err)
+	}
+}
+
+func TestDiffCommitToWorkingTreeNested(t *testing.T) {
+	files := map[string]string{"dir/sub/a.txt": "a\n", "dir/b.txt": "b\n", "top.txt": "t\n"}
+	setupTreeRepo(t, files)
+	repo, err := openRepository(".")
+	require.NoError(t, err)
+	refManager := refs.NewRefManager(repo.GitDir())
+	base, err := refManager.ResolveRef("refs/heads/main")
+	require.NoError(t, err)
+	files["dir/sub/a.txt"] = "a2\n"
+	require.NoError(t, refManager.UpdateRef("refs/heads/main", commitFiles(t, repo, files, []objects.ObjectID{base}, "second\n")))
+	require.NoError(t, os.WriteFile("dir/sub/a.txt", []byte("a2\n"), 0644))
+	require.NoError(t, resetIndexToHead(repo))
+
+	// Untracked files are left out, and nested files compare by path
+	require.NoError(t, os.WriteFile("dir/b.txt", []byte("local\n"), 0644))
+	require.NoError(t, os.WriteFile("dir/untracked.txt", []byte("u\n"), 0644))
+	require.NoError(t, os.Remove("top.txt"))
+	stageFile(t, "dir/sub/new.txt", "new\n")
+
+	out, err := captureStdout(t, func() error {
+		_, err := runCommandArgs(newDiffCommand(), "--name-status", "HEAD~1")
+		return err
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "M\tdir/b.txt\nM\tdir/sub/a.txt\nA\tdir/sub/new.txt\nD\ttop.txt\n", out)
+
+	out, err = captureStdout(t, func() error {
+		_, err := runCommandArgs(newDiffCommand(), "HEAD")
+		return err
+	})
+	require.NoError(t, err)
+	assert.Contains(t, out, "--- a/dir/b.txt\n+++ b/dir/b.txt\n")
+	assert.NotContains(t, out, "untracked.txt")
+	assert.NotContains(t, out, "a/dir\n")
+}
+
+func TestDiffCachedAndCommitsNested(t *testing.T) {
+	files := map[string]string{"a/g.txt": "g\n", "a/b/h.txt": "h\n", "top.txt": "t\n"}
+	setupTreeRepo(t, files)
+	repo, err := openRepository(".")
+	require.NoError(t, err)
+	refManager := refs.NewRefManager(repo.GitDir())
+	base, err := refManager.ResolveRef("refs/heads/main")
+	require.NoError(t, err)
+	files["a/b/h.txt"] = "h2\n"
+	files["a/b/new.txt"] = "new\n"
+	delete(files, "top.txt")
+	require.NoError(t, refManager.UpdateRef("refs/heads/main", commitFiles(t, repo, files, []objects.ObjectID{base}, "second\n")))
+	require.NoError(t, resetIndexToHead(repo))
+
+	// A staged change inside a directory is its file, not the directory
+	stageFile(t, "a/g.txt", "g2\n")
+	out, err := captureStdout(t, func() error {
+		_, err := runCommandArgs(newDiffCommand(), "--cached", "--name-status")
+		return err
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "M\ta/g.txt\n", out)
+
+	out, err = captureStdout(t, func() error {
+		_, err := runCommandArgs(newDiffCommand(), "--name-status", "HEAD~1", "HEAD")
+		return err
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "M\ta/b/h.txt\nA\ta/b/new.txt\nD\ttop.txt\n", out)
+
+	out, err = captureStdout(t, func() error {
+		_, err := runCommandArgs(newDiffCommand(), "--stat", "HEAD~1", "HEAD")
+		return err
+	})
+	require.NoError(t, err)
+	assert.Contains(t, out, " a/b/h.txt ")
+	assert.Contains(t, out, " a/b/new.txt ")
+	assert.Contains(t, out, "3 files changed")
+	assert.NotContains(t, out, " a |")
+}
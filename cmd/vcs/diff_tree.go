@@ -0,0 +1,197 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/history"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+// diffTreeOptions holds the flags of diff-tree
+type diffTreeOptions struct {
+	recursive  bool
+	root       bool
+	nulTerm    bool
+	noCommitID bool
+	nameOnly   bool
+	nameStatus bool
+}
+
+func newDiffTreeCommand() *cobra.Command {
+	var opts diffTreeOptions
+
+	cmd := &cobra.Command{
+		Use:   "diff-tree [flags] <tree-ish> [<tree-ish>] [[--] <path>...]",
+		Short: "Compare the content and mode of blobs found via two tree objects",
+		Long: `Compares two trees, or a commit with its parent, and prints one line per
+changed entry in raw format:
+
+  :<old mode> <new mode> <old object> <new object> <status>	<path>
+
+where the status is A, D, M or T, or with -M and -C R or C followed by
+the similarity, such as R086, with the source path before the path. Given a single commit, its name is printed
+first; root commits are only compared with the empty tree with --root and
+merge commits are not compared. Without -r, changed subtrees are printed as
+single entries. With -z, the path is separated by NUL instead of a tab and
+each record ends with NUL instead of a newline.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runDiffTree(cmd, args, opts)
+		},
+	}
+
+	cmd.Flags().BoolVarP(&opts.recursive, "recursive", "r", false, "Recurse into subtrees")
+	cmd.Flags().BoolVar(&opts.root, "root", false, "Show a root commit as adding all of its files")
+	cmd.Flags().BoolVarP(&opts.nulTerm, "null", "z", false, "Terminate fields with NUL instead of tabs and newlines")
+	cmd.Flags().BoolVar(&opts.noCommitID, "no-commit-id", false, "Do not print the commit name")
+	cmd.Flags().BoolVar(&opts.nameOnly, "name-only", false, "Show only the names of changed entries")
+	cmd.Flags().BoolVar(&opts.nameStatus, "name-status", false, "Show only the names and status of changed entries")
+	cmd.Flags().Bool("raw", true, "Show changes in raw format (the default)")
+	addDetectionFlags(cmd)
+
+	return cmd
+}
+
+func runDiffTree(cmd *cobra.Command, args []string, opts diffTreeOptions) error {
+	repoPath, err := findRepository()
+	if err != nil {
+		return fmt.Errorf("not a git repository: %w", err)
+	}
+	repo, err := openRepository(repoPath)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+	resolver := newResolver(repo)
+
+	// Up to two tree-ishes come first, then paths
+	revisions := args
+	var paths []string
+	if dash := cmd.ArgsLenAtDash(); dash >= 0 {
+		revisions, paths = args[:dash], args[dash:]
+	} else if len(args) > 1 {
+		revisions, paths = args[:1], args[1:]
+		if _, err := resolver.ResolveTree(args[1]); err == nil {
+			revisions, paths = args[:2], args[2:]
+		}
+	}
+
+	var oldTree, newTree objects.ObjectID
+	header := ""
+	switch len(revisions) {
+	case 1:
+		commitID, err := resolver.ResolveCommit(revisions[0])
+		if err != nil {
+			return fmt.Errorf("invalid commit %s: %w", revisions[0], err)
+		}
+		commit, err := history.ReadCommit(repo, commitID)
+		if err != nil {
+			return err
+		}
+		switch len(commit.Parents()) {
+		case 0:
+			if !opts.root {
+				return nil
+			}
+		case 1:
+			parent, err := history.ReadCommit(repo, commit.Parents()[0])
+			if err != nil {
+				return err
+			}
+			oldTree = parent.Tree()
+		default:
+			return nil
+		}
+		newTree = commit.Tree()
+		if !opts.noCommitID {
+			header = commitID.String()
+		}
+	case 2:
+		if oldTree, err = resolver.ResolveTree(revisions[0]); err != nil {
+			return fmt.Errorf("invalid tree %s: %w", revisions[0], err)
+		}
+		if newTree, err = resolver.ResolveTree(revisions[1]); err != nil {
+			return fmt.Errorf("invalid tree %s: %w", revisions[1], err)
+		}
+	default:
+		return fmt.Errorf("diff-tree takes one or two tree-ishes")
+	}
+
+	d, err := readDetection(cmd, repo.GitDir(), false)
+	if err != nil {
+		return err
+	}
+	changes, err := history.DiffTrees(repo, oldTree, newTree, opts.recursive)
+	if err != nil {
+		return fmt.Errorf("failed to compare trees: %w", err)
+	}
+	if changes, err = history.DetectRenames(repo, changes, d); err != nil {
+		return err
+	}
+	if len(paths) > 0 {
+		kept := changes[:0]
+		for _, change := range changes {
+			if matchesDiffPath(change.Path, paths) {
+				kept = append(kept, change)
+			}
+		}
+		changes = kept
+	}
+	if len(changes) == 0 {
+		return nil
+	}
+
+	out := cmd.OutOrStdout()
+	if header != "" {
+		if opts.nulTerm {
+			fmt.Fprint(out, header+"\x00")
+		} else {
+			fmt.Fprintln(out, header)
+		}
+	}
+	for _, change := range changes {
+		printRawChange(out, change, opts)
+	}
+	return nil
+}
+
+// matchesDiffPath reports whether p is one of paths, lies below one of them
+// or is a directory containing one of them
+func matchesDiffPath(p string, paths []string) bool {
+	for _, want := range paths {
+		want = strings.TrimSuffix(want, "/")
+		if want == "" || want == "." || p == want || strings.HasPrefix(p, want+"/") || strings.HasPrefix(want, p+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// printRawChange prints a change in raw format, or as a name with
+// --name-only and --name-status
+func printRawChange(out io.Writer, change history.Change, opts diffTreeOptions) {
+	separator, end := "\t", "\n"
+	if opts.nulTerm {
+		separator, end = "\x00", "\x00"
+	}
+
+	// A rename or copy shows its score and both paths
+	status, paths := change.Type.String(), change.Path
+	if change.Type == history.Renamed || change.Type == history.Copied {
+		status = fmt.Sprintf("%s%03d", status, change.Score)
+		paths = change.OldPath + separator + change.Path
+	}
+
+	switch {
+	case opts.nameOnly:
+		fmt.Fprint(out, change.Path+end)
+	case opts.nameStatus:
+		fmt.Fprint(out, status+separator+paths+end)
+	default:
+		fmt.Fprintf(out, ":%06o %06o %s %s %s%s%s%s", uint32(change.OldMode), uint32(change.NewMode),
+			change.OldID, change.NewID, status, separator, paths, end)
+	}
+}
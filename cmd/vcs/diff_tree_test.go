@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+func runDiffTreeArgs(args ...string) (string, error) {
+	cmd := newDiffTreeCommand()
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+	return out.String(), err
+}
+
+func TestDiffTree(t *testing.T) {
+	repo, commits := setupRenameRepo(t)
+	blob := func(content string) string { return objects.NewBlob([]byte(content)).ID().String() }
+	zero := strings.Repeat("0", 40)
+	body := "one\nTWO\nthree\nfour\nfive\n"
+
+	out, err := runDiffTreeArgs("HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, commits[3].String()+"\n"+
+		":100644 100644 "+blob("other\n")+" "+blob("changed\n")+" M\tother.txt\n", out)
+
+	out, err = runDiffTreeArgs("--no-commit-id", "HEAD~1")
+	require.NoError(t, err)
+	assert.Equal(t, ":000000 100644 "+zero+" "+blob(body+"six\n")+" A\tnew.txt\n"+
+		":100644 000000 "+blob(body)+" "+zero+" D\told.txt\n", out)
+
+	out, err = runDiffTreeArgs("--no-commit-id", "-M", "HEAD~1")
+	require.NoError(t, err)
+	assert.Equal(t, ":100644 100644 "+blob(body)+" "+blob(body+"six\n")+" R085\told.txt\tnew.txt\n", out)
+	out, err = runDiffTreeArgs("--name-status", "--find-renames=90%", "HEAD~2", "HEAD~1")
+	require.NoError(t, err)
+	assert.Equal(t, "A\tnew.txt\nD\told.txt\n", out)
+
+	out, err = runDiffTreeArgs("-z", "--name-status", "HEAD~3", "HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, "A\x00new.txt\x00D\x00old.txt\x00M\x00other.txt\x00", out)
+
+	out, err = runDiffTreeArgs("--name-only", "HEAD~3", "HEAD", "other.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "other.txt\n", out)
+
+	// Root commits are only shown with --root
+	out, err = runDiffTreeArgs(commits[0].String())
+	require.NoError(t, err)
+	assert.Empty(t, out)
+	out, err = runDiffTreeArgs("--root", "--name-only", "-z", commits[0].String())
+	require.NoError(t, err)
+	assert.Equal(t, commits[0].String()+"\x00old.txt\x00other.txt\x00", out)
+
+	// Merge commits are not compared
+	merge := commitFiles(t, repo, map[string]string{"m.txt": "m\n"}, []objects.ObjectID{commits[2], commits[3]}, "merge\n")
+	out, err = runDiffTreeArgs(merge.String())
+	require.NoError(t, err)
+	assert.Empty(t, out)
+
+	_, err = runDiffTreeArgs("nope")
+	assert.ErrorContains(t, err, "invalid commit nope")
+}
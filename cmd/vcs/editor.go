@@ -0,0 +1,101 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// editorCommand returns the editor to run, checked in git's order:
+// GIT_EDITOR, core.editor, VISUAL, EDITOR, then vi
+func editorCommand(gitDir string) string {
+	if editor := os.Getenv("GIT_EDITOR"); editor != "" {
+		return editor
+	}
+	if editor := loadConfigSections(gitDir, "core")["core.editor"]; editor != "" {
+		return editor
+	}
+	for _, name := range []string{"VISUAL", "EDITOR"} {
+		if editor := os.Getenv(name); editor != "" {
+			return editor
+		}
+	}
+	return "vi"
+}
+
+// sequenceEditorCommand returns the editor for the todo list of an
+// interactive rebase: GIT_SEQUENCE_EDITOR, sequence.editor, then the usual
+// editor. Tools set either to rewrite the todo list without a user.
+func sequenceEditorCommand(gitDir string) string {
+	if editor := os.Getenv("GIT_SEQUENCE_EDITOR"); editor != "" {
+		return editor
+	}
+	if editor, ok := loadConfig(gitDir).Get("sequence.editor"); ok && editor != "" {
+		return editor
+	}
+	return editorCommand(gitDir)
+}
+
+// launchEditor opens path in the user's editor and waits for it to exit
+func launchEditor(gitDir, path string) error {
+	return runEditor(editorCommand(gitDir), path)
+}
+
+// runEditor opens path in editor and waits for it to exit. The editor
+// setting is run through the shell so it may carry arguments.
+func runEditor(editor, path string) error {
+	if editor == ":" {
+		return nil
+	}
+
+	cmd := exec.Command("sh", "-c", editor+` "$@"`, editor, path)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("there was a problem with the editor '%s': %w", editor, err)
+	}
+	return nil
+}
+
+// editText lets the user edit text in a file under gitDir and returns the
+// result with comment lines removed
+func editText(gitDir, name, text string) (string, error) {
+	return editTextWith(editorCommand(gitDir), gitDir, name, text)
+}
+
+// editTextWith is editText with the given editor, writing the file in dir
+func editTextWith(editor, dir, name, text string) (string, error) {
+	path := filepath.Join(dir, name)
+	if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+		return "", fmt.Errorf("failed to write %s: %w", name, err)
+	}
+	if err := runEditor(editor, path); err != nil {
+		return "", err
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	return stripComments(string(data)), nil
+}
+
+// stripComments removes # comment lines and surrounding blank lines
+func stripComments(text string) string {
+	var kept []string
+	for _, line := range strings.Split(text, "\n") {
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		kept = append(kept, strings.TrimRight(line, " \t\r"))
+	}
+
+	result := strings.Trim(strings.Join(kept, "\n"), "\n")
+	if result == "" {
+		return ""
+	}
+	return result + "\n"
+}
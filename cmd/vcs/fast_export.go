@@ -0,0 +1,538 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/faststream"
+	"github.com/fenilsonani/vcs/internal/core/history"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/internal/core/signing"
+	"github.com/fenilsonani/vcs/internal/revparse"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// fastExportOptions holds the flags of fast-export
+type fastExportOptions struct {
+	all             bool
+	exportMarks     string
+	importMarks     string
+	showOriginalIDs bool
+}
+
+// fastExporter writes the history of a repository as a fast-import stream
+type fastExporter struct {
+	repo   *vcs.Repository
+	w      *faststream.Writer
+	errOut io.Writer
+	opts   fastExportOptions
+	marks  faststream.Marks
+	// marked is the mark of each object exported so far
+	marked   map[objects.ObjectID]int
+	nextMark int
+}
+
+// fastExportRef is a ref to export and what it points to
+type fastExportRef struct {
+	name string
+	id   objects.ObjectID
+}
+
+// exportCommit is a commit as fast-export reads it from its raw data
+type exportCommit struct {
+	tree      objects.ObjectID
+	parents   []objects.ObjectID
+	author    string
+	committer string
+	encoding  string
+	message   []byte
+}
+
+func newFastExportCommand() *cobra.Command {
+	var opts fastExportOptions
+
+	cmd := &cobra.Command{
+		Use:   "fast-export [flags] [<rev-list-args>...]",
+		Short: "Export history as a fast-import stream",
+		Long: `Writes the history of the given refs, or of every ref with --all, to
+stdout as a stream that fast-import here or in Git, and importers for other
+systems, can replay. Ranges such as main..topic leave out history the
+receiving side already has; commits built on it refer to their parents by
+object ID.
+
+Commits are written parents first, each preceded by the blobs it adds, and
+with the identities and message of the original so that importing the
+stream recreates the same commit IDs. Signatures cannot survive and are
+dropped. Annotated tags are written as tags and other refs as resets.
+
+--export-marks saves the marks given to objects, and --import-marks loads
+those of an earlier run, whose objects are then not written again, so
+history can be exported incrementally.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if !opts.all && len(args) == 0 {
+				return fmt.Errorf("no revisions given; use --all to export every ref")
+			}
+			return runFastExport(cmd, args, opts)
+		},
+	}
+
+	cmd.Flags().BoolVar(&opts.all, "all", false, "Export every ref")
+	cmd.Flags().StringVar(&opts.exportMarks, "export-marks", "", "Write the marks of exported objects to <file>")
+	cmd.Flags().StringVar(&opts.importMarks, "import-marks", "", "Read marks of objects already exported from <file>")
+	cmd.Flags().BoolVar(&opts.showOriginalIDs, "show-original-ids", false, "Record the original ID of each blob and commit")
+
+	return cmd
+}
+
+func runFastExport(cmd *cobra.Command, args []string, opts fastExportOptions) error {
+	repoPath, err := findRepository()
+	if err != nil {
+		return fmt.Errorf("not a git repository: %w", err)
+	}
+	repo, err := openRepository(repoPath)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	e := &fastExporter{
+		repo:   repo,
+		w:      faststream.NewWriter(cmd.OutOrStdout()),
+		errOut: cmd.ErrOrStderr(),
+		opts:   opts,
+		marks:  make(faststream.Marks),
+		marked: make(map[objects.ObjectID]int),
+	}
+	if opts.importMarks != "" {
+		if err := e.loadMarks(opts.importMarks); err != nil {
+			return err
+		}
+	}
+	e.nextMark = e.marks.Max() + 1
+
+	exportRefs, tips, excluded, err := fastExportRefs(repo, args, opts.all)
+	if err != nil {
+		return err
+	}
+	if err := e.export(exportRefs, tips, excluded); err != nil {
+		return err
+	}
+	if err := e.w.Flush(); err != nil {
+		return fmt.Errorf("failed to write stream: %w", err)
+	}
+
+	if opts.exportMarks != "" {
+		f, err := os.Create(opts.exportMarks)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", opts.exportMarks, err)
+		}
+		err = faststream.WriteMarks(f, e.marks)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write %s: %w", opts.exportMarks, err)
+		}
+	}
+	return nil
+}
+
+// loadMarks reads the marks of an earlier export, whose objects are not
+// exported again
+func (e *fastExporter) loadMarks(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open marks file: %w", err)
+	}
+	defer f.Close()
+	marks, err := faststream.ReadMarks(f)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	for mark, id := range marks {
+		e.marks[mark] = id
+		e.marked[id] = mark
+	}
+	return nil
+}
+
+// fastExportRefs resolves the arguments of fast-export to the refs to
+// export with what they point to, sorted by name, the commits to start
+// from and those whose history is left out
+func fastExportRefs(repo *vcs.Repository, args []string, all bool) ([]fastExportRef, []objects.ObjectID, []objects.ObjectID, error) {
+	refManager := refs.NewRefManager(repo.GitDir())
+	resolver := newResolver(repo)
+
+	named := make(map[string]objects.ObjectID)
+	if all {
+		allRefs, err := refManager.AllRefs()
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("failed to list refs: %w", err)
+		}
+		for name, id := range allRefs {
+			named[name] = id
+		}
+	}
+
+	var tips, excluded []objects.ObjectID
+	for _, arg := range args {
+		r, err := resolver.ResolveRange(arg)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+		tips = append(tips, r.Include...)
+		excluded = append(excluded, r.Exclude...)
+
+		// As in bundles, the positive end of a range names the ref to export
+		name := arg
+		if i := strings.Index(arg, ".."); i >= 0 {
+			if name = strings.TrimPrefix(arg[i+2:], "."); name == "" {
+				name = "HEAD"
+			}
+		} else if revparse.IsRange(arg) {
+			continue
+		}
+		full, err := refManager.ExpandRef(name)
+		if err != nil {
+			return nil, nil, nil, fmt.Errorf("'%s' does not name a ref", name)
+		}
+		if full == "HEAD" {
+			if full, err = refManager.SymbolicHEAD(); err != nil || full == "" {
+				return nil, nil, nil, fmt.Errorf("cannot export a detached HEAD")
+			}
+		}
+		if named[full], err = resolver.Resolve(full); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	list := make([]fastExportRef, 0, len(named))
+	for name, id := range named {
+		list = append(list, fastExportRef{name: name, id: id})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].name < list[j].name })
+	return list, tips, excluded, nil
+}
+
+// export writes the commits reachable from the refs and tips but not from
+// excluded, then the refs themselves
+func (e *fastExporter) export(exportRefs []fastExportRef, tips, excluded []objects.ObjectID) error {
+	resolver := newResolver(e.repo)
+	skip, err := resolver.Reachable(excluded)
+	if err != nil {
+		return err
+	}
+
+	// Each commit is exported on the first ref it is reached from
+	type start struct {
+		ref string
+		id  objects.ObjectID
+	}
+	var starts []start
+	for _, ref := range exportRefs {
+		id, err := e.peelCommit(ref.id)
+		if err != nil {
+			return err
+		}
+		if !id.IsZero() {
+			starts = append(starts, start{ref.name, id})
+		}
+	}
+	for _, id := range tips {
+		starts = append(starts, start{"", id})
+	}
+
+	refOf := make(map[objects.ObjectID]string)
+	var order []objects.ObjectID
+	visited := make(map[objects.ObjectID]bool)
+	for _, s := range starts {
+		commits, err := e.topoOrder(s.id, skip, visited)
+		if err != nil {
+			return err
+		}
+		for _, id := range commits {
+			refOf[id] = s.ref
+		}
+		order = append(order, commits...)
+	}
+
+	for _, id := range order {
+		ref := refOf[id]
+		if ref == "" {
+			return fmt.Errorf("commit %s is not on any ref being exported", id.Short())
+		}
+		if err := e.exportCommit(id, ref); err != nil {
+			return err
+		}
+	}
+
+	for _, ref := range exportRefs {
+		if err := e.exportRef(ref, refOf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// peelCommit returns the commit id points to through any tags, or zero
+// when it points to another kind of object
+func (e *fastExporter) peelCommit(id objects.ObjectID) (objects.ObjectID, error) {
+	for {
+		obj, err := e.repo.ReadObject(id)
+		if err != nil {
+			return objects.ObjectID{}, fmt.Errorf("failed to read object %s: %w", id.Short(), err)
+		}
+		switch obj := obj.(type) {
+		case *objects.Commit:
+			return id, nil
+		case *objects.Tag:
+			id = obj.Object()
+		default:
+			return objects.ObjectID{}, nil
+		}
+	}
+}
+
+// topoOrder returns the commits reachable from tip that are not in skip,
+// visited or already exported, parents before children
+func (e *fastExporter) topoOrder(tip objects.ObjectID, skip, visited map[objects.ObjectID]bool) ([]objects.ObjectID, error) {
+	var order []objects.ObjectID
+	parentsOf := make(map[objects.ObjectID][]objects.ObjectID)
+
+	// An explicit stack keeps long histories from exhausting the Go stack
+	type frame struct {
+		id   objects.ObjectID
+		next int
+	}
+	var stack []frame
+	push := func(id objects.ObjectID) error {
+		if visited[id] || skip[id] {
+			return nil
+		}
+		if _, ok := e.marked[id]; ok {
+			return nil
+		}
+		visited[id] = true
+		commit, err := e.repo.GetCommit(id)
+		if err != nil {
+			return fmt.Errorf("failed to read commit %s: %w", id.Short(), err)
+		}
+		parentsOf[id] = commit.Parents()
+		stack = append(stack, frame{id: id})
+		return nil
+	}
+
+	if err := push(tip); err != nil {
+		return nil, err
+	}
+	for len(stack) > 0 {
+		top := &stack[len(stack)-1]
+		parents := parentsOf[top.id]
+		if top.next < len(parents) {
+			parent := parents[top.next]
+			top.next++
+			if err := push(parent); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		order = append(order, top.id)
+		stack = stack[:len(stack)-1]
+	}
+	return order, nil
+}
+
+// objectRef returns how the stream refers to id: by its mark once it has
+// been exported, and by ID otherwise
+func (e *fastExporter) objectRef(id objects.ObjectID) string {
+	if mark, ok := e.marked[id]; ok {
+		return faststream.MarkRef(mark)
+	}
+	return id.String()
+}
+
+// mark gives id the next mark
+func (e *fastExporter) mark(id objects.ObjectID) int {
+	mark := e.nextMark
+	e.nextMark++
+	e.marks[mark] = id
+	e.marked[id] = mark
+	return mark
+}
+
+// originalID returns id as --show-original-ids records it
+func (e *fastExporter) originalID(id objects.ObjectID) string {
+	if e.opts.showOriginalIDs {
+		return id.String()
+	}
+	return ""
+}
+
+// exportCommit writes the commit id on ref, preceded by the blobs it
+// adds. Files are compared with the first parent only, as in Git.
+func (e *fastExporter) exportCommit(id objects.ObjectID, ref string) error {
+	commit, err := e.readCommit(id)
+	if err != nil {
+		return err
+	}
+
+	var parentTree objects.ObjectID
+	if len(commit.parents) > 0 {
+		parent, err := e.repo.GetCommit(commit.parents[0])
+		if err != nil {
+			return fmt.Errorf("failed to read commit %s: %w", commit.parents[0].Short(), err)
+		}
+		parentTree = parent.Tree()
+	}
+	changes, err := history.DiffTrees(e.repo, parentTree, commit.tree, true)
+	if err != nil {
+		return fmt.Errorf("failed to diff commit %s: %w", id.Short(), err)
+	}
+
+	// Deletions go first so a file can be replaced by a directory
+	var deletes, modifies []faststream.FileChange
+	for _, change := range changes {
+		if change.Type == history.Deleted {
+			deletes = append(deletes, faststream.FileChange{Op: faststream.FileDelete, Path: change.Path})
+			continue
+		}
+
+		dataRef := change.NewID.String()
+		if change.NewMode != objects.ModeCommit {
+			if err := e.exportBlob(change.NewID); err != nil {
+				return err
+			}
+			dataRef = e.objectRef(change.NewID)
+		}
+		modifies = append(modifies, faststream.FileChange{
+			Op:      faststream.FileModify,
+			Mode:    change.NewMode,
+			DataRef: dataRef,
+			Path:    change.Path,
+		})
+	}
+
+	if len(commit.parents) == 0 {
+		// Otherwise the commit would be put on top of the ref's last one
+		if err := e.w.Write(&faststream.Reset{Ref: ref}); err != nil {
+			return err
+		}
+	}
+	out := &faststream.Commit{
+		Ref:         ref,
+		Mark:        e.mark(id),
+		OriginalOID: e.originalID(id),
+		Author:      commit.author,
+		Committer:   commit.committer,
+		Encoding:    commit.encoding,
+		Message:     commit.message,
+		Files:       append(deletes, modifies...),
+	}
+	for i, parent := range commit.parents {
+		if i == 0 {
+			out.From = e.objectRef(parent)
+		} else {
+			out.Merge = append(out.Merge, e.objectRef(parent))
+		}
+	}
+	return e.w.Write(out)
+}
+
+// exportBlob writes the blob id unless it has been already
+func (e *fastExporter) exportBlob(id objects.ObjectID) error {
+	if _, ok := e.marked[id]; ok {
+		return nil
+	}
+	blob, err := e.repo.GetBlob(id)
+	if err != nil {
+		return fmt.Errorf("failed to read blob %s: %w", id.Short(), err)
+	}
+	return e.w.Write(&faststream.Blob{Mark: e.mark(id), OriginalOID: e.originalID(id), Data: blob.Data()})
+}
+
+// readCommit reads the commit id from its raw data, keeping identities and
+// message byte for byte so the commit can be recreated exactly
+func (e *fastExporter) readCommit(id objects.ObjectID) (*exportCommit, error) {
+	_, data, err := e.repo.Storage().ReadRawObject(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read commit %s: %w", id.Short(), err)
+	}
+	payload, signature := signing.SplitCommit(data)
+	if signature != nil {
+		fmt.Fprintf(e.errOut, "warning: dropping the signature of commit %s\n", id.Short())
+	}
+
+	commit := &exportCommit{}
+	header, message, _ := bytes.Cut(payload, []byte("\n\n"))
+	commit.message = message
+	for _, line := range strings.Split(string(header), "\n") {
+		name, value, _ := strings.Cut(line, " ")
+		switch name {
+		case "tree":
+			commit.tree, err = objects.NewObjectID(value)
+		case "parent":
+			var parent objects.ObjectID
+			parent, err = objects.NewObjectID(value)
+			commit.parents = append(commit.parents, parent)
+		case "author":
+			commit.author = value
+		case "committer":
+			commit.committer = value
+		case "encoding":
+			commit.encoding = value
+		}
+		if err != nil {
+			return nil, fmt.Errorf("invalid commit %s: %w", id.Short(), err)
+		}
+	}
+	return commit, nil
+}
+
+// exportRef writes ref: annotated tags as tags, and a reset for any other
+// ref unless its commit was just exported on it
+func (e *fastExporter) exportRef(ref fastExportRef, refOf map[objects.ObjectID]string) error {
+	obj, err := e.repo.ReadObject(ref.id)
+	if err != nil {
+		return fmt.Errorf("failed to read object %s: %w", ref.id.Short(), err)
+	}
+
+	if tag, ok := obj.(*objects.Tag); ok && strings.HasPrefix(ref.name, "refs/tags/") {
+		_, data, err := e.repo.Storage().ReadRawObject(ref.id)
+		if err != nil {
+			return fmt.Errorf("failed to read tag %s: %w", ref.id.Short(), err)
+		}
+		var tagger string
+		header, message, _ := bytes.Cut(data, []byte("\n\n"))
+		for _, line := range strings.Split(string(header), "\n") {
+			if value, ok := strings.CutPrefix(line, "tagger "); ok {
+				tagger = value
+			}
+		}
+		payload, signature := signing.SplitTag(message)
+		if signature != nil {
+			fmt.Fprintf(e.errOut, "warning: dropping the signature of tag %s\n", strings.TrimPrefix(ref.name, "refs/tags/"))
+		}
+		return e.w.Write(&faststream.Tag{
+			Name:        strings.TrimPrefix(ref.name, "refs/tags/"),
+			From:        e.objectRef(tag.Object()),
+			OriginalOID: e.originalID(ref.id),
+			Tagger:      tagger,
+			Message:     payload,
+		})
+	}
+
+	if obj.Type() != objects.TypeCommit {
+		fmt.Fprintf(e.errOut, "warning: skipping %s, which does not point to a commit\n", ref.name)
+		return nil
+	}
+	if refOf[ref.id] == ref.name {
+		return nil
+	}
+	return e.w.Write(&faststream.Reset{Ref: ref.name, From: e.objectRef(ref.id)})
+}
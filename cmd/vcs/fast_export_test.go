@@ -0,0 +1,223 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+func runFastExportArgs(t *testing.T, args ...string) string {
+	cmd := newFastExportCommand()
+	var stdout bytes.Buffer
+	cmd.SetOut(&stdout)
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs(args)
+	require.NoError(t, cmd.Execute())
+	return stdout.String()
+}
+
+func runFastImportArgs(stream string, args ...string) (string, error) {
+	cmd := newFastImportCommand()
+	var stdout bytes.Buffer
+	cmd.SetIn(strings.NewReader(stream))
+	cmd.SetOut(&stdout)
+	cmd.SetErr(&stdout)
+	cmd.SetArgs(append([]string{"--quiet"}, args...))
+	err := cmd.Execute()
+	return stdout.String(), err
+}
+
+// chdirNewRepo creates an empty repository and makes it the current
+// directory
+func chdirNewRepo(t *testing.T) *refs.RefManager {
+	path := t.TempDir()
+	repo, err := vcs.Init(path)
+	require.NoError(t, err)
+	oldWd, _ := os.Getwd()
+	t.Cleanup(func() { os.Chdir(oldWd) })
+	require.NoError(t, os.Chdir(path))
+	return refs.NewRefManager(repo.GitDir())
+}
+
+func TestFastExportImportRoundTrip(t *testing.T) {
+	repo, refManager, ids := setupBisectRepo(t, 3)
+	side := commitFiles(t, repo, map[string]string{"n.txt": "side\n", "s.txt": "s\n"}, ids[:1], "side\n")
+	merged := commitFiles(t, repo, map[string]string{"n.txt": "2\n", "s.txt": "s\n"}, []objects.ObjectID{ids[2], side}, "merge\n")
+	require.NoError(t, refManager.UpdateRef("refs/heads/main", merged))
+	require.NoError(t, refManager.UpdateRef("refs/heads/side", side))
+	sig := objects.Signature{Name: "Test", Email: "test@example.com", When: time.Now()}
+	tag, err := repo.CreateTag(ids[1], objects.TypeCommit, "v1.0", sig, "release\n")
+	require.NoError(t, err)
+	require.NoError(t, refManager.UpdateRef("refs/tags/v1.0", tag.ID()))
+	require.NoError(t, refManager.UpdateRef("refs/tags/light", ids[0]))
+
+	marks := filepath.Join(t.TempDir(), "marks")
+	stream := runFastExportArgs(t, "--all", "--export-marks", marks)
+	assert.Contains(t, stream, "commit refs/heads/main\n")
+	assert.Contains(t, stream, "tag v1.0\n")
+	assert.Contains(t, stream, "reset refs/tags/light\nfrom :")
+	// Parents come before their children
+	assert.Less(t, strings.Index(stream, "data 9\ncommit 0\n"), strings.Index(stream, "data 9\ncommit 1\n"))
+
+	data, err := os.ReadFile(marks)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), merged.String())
+
+	imported := chdirNewRepo(t)
+	_, err = runFastImportArgs(stream)
+	require.NoError(t, err)
+	for name, want := range map[string]objects.ObjectID{
+		"refs/heads/main": merged,
+		"refs/heads/side": side,
+		"refs/tags/v1.0":  tag.ID(),
+		"refs/tags/light": ids[0],
+	} {
+		id, err := imported.ResolveRef(name)
+		require.NoError(t, err, name)
+		assert.Equal(t, want, id, name)
+	}
+
+	// Importing the same stream again changes nothing
+	_, err = runFastImportArgs(stream)
+	require.NoError(t, err)
+}
+
+func TestFastExportIncremental(t *testing.T) {
+	repo, refManager, ids := setupBisectRepo(t, 2)
+	marks := filepath.Join(t.TempDir(), "marks")
+	first := runFastExportArgs(t, "--export-marks", marks, "main")
+	assert.Equal(t, 2, strings.Count(first, "commit refs/heads/main\n"))
+
+	next := commitFiles(t, repo, map[string]string{"n.txt": "2\n"}, ids[1:], "commit 2\n")
+	require.NoError(t, refManager.UpdateRef("refs/heads/main", next))
+	second := runFastExportArgs(t, "--import-marks", marks, "--export-marks", marks, "main")
+	assert.Equal(t, 1, strings.Count(second, "commit refs/heads/main\n"))
+	// New objects continue from the highest mark, and parents keep theirs
+	assert.Contains(t, second, "mark :6\n")
+	assert.Contains(t, second, "from :4\n")
+
+	// A range refers to the history it leaves out by ID
+	ranged := runFastExportArgs(t, ids[0].String()+"..main")
+	assert.Contains(t, ranged, "from "+ids[0].String()+"\n")
+	assert.NotContains(t, ranged, "reset refs/heads/main\n")
+
+	imported := chdirNewRepo(t)
+	_, err := runFastImportArgs(first + second)
+	require.NoError(t, err)
+	id, err := imported.ResolveRef("refs/heads/main")
+	require.NoError(t, err)
+	assert.Equal(t, next, id)
+}
+
+func TestFastImport(t *testing.T) {
+	refManager := chdirNewRepo(t)
+	marks := filepath.Join(t.TempDir(), "marks")
+
+	stream := `feature done
+blob
+mark :1
+data <<EOF
+hello
+EOF
+
+commit refs/heads/main
+mark :2
+committer C O Mitter <c@example.com> 1700000000 +0000
+data 6
+first
+M 100644 :1 "dir/a b.txt"
+M 755 inline run.sh
+data 10
+#!/bin/sh
+
+commit refs/heads/main
+mark :3
+author A U Thor <a@example.com> 1700000100 +0100
+committer C O Mitter <c@example.com> 1700000100 +0000
+data 7
+second
+R "dir/a\040b.txt" moved.txt
+C run.sh dir/run.sh
+
+progress imported main
+reset refs/heads/other
+from :2
+
+done
+`
+	out, err := runFastImportArgs(stream, "--export-marks", marks)
+	require.NoError(t, err)
+	assert.Contains(t, out, "progress imported main")
+
+	repo, err := vcs.Open(".")
+	require.NoError(t, err)
+	mainID, err := refManager.ResolveRef("refs/heads/main")
+	require.NoError(t, err)
+	commit, err := repo.GetCommit(mainID)
+	require.NoError(t, err)
+	assert.Equal(t, "A U Thor", commit.Author().Name)
+	assert.Equal(t, "second\n", commit.Message())
+	files := readCommitFiles(t, repo, mainID)
+	assert.Equal(t, map[string]string{
+		"moved.txt":  "hello\n",
+		"run.sh":     "#!/bin/sh\n",
+		"dir/run.sh": "#!/bin/sh\n",
+	}, files)
+
+	otherID, err := refManager.ResolveRef("refs/heads/other")
+	require.NoError(t, err)
+	assert.Equal(t, commit.Parents(), []objects.ObjectID{otherID})
+
+	data, err := os.ReadFile(marks)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), ":3 "+mainID.String()+"\n")
+
+	// A new root commit on main would lose history
+	root := "commit refs/heads/main\ncommitter C <c@example.com> 1700000200 +0000\ndata 5\nroot\n"
+	out, err = runFastImportArgs(root)
+	assert.ErrorIs(t, err, errBranchesNotUpdated)
+	assert.Contains(t, out, "not updating refs/heads/main")
+	_, err = runFastImportArgs(root, "--force")
+	require.NoError(t, err)
+	id, err := refManager.ResolveRef("refs/heads/main")
+	require.NoError(t, err)
+	assert.NotEqual(t, mainID, id)
+
+	_, err = runFastImportArgs("feature done\n")
+	assert.ErrorContains(t, err, "without the done command")
+	_, err = runFastImportArgs("commit refs/heads/x\ncommitter C <c@example.com> 1 +0000\ndata 0\nM 100644 :9 f\n")
+	assert.ErrorContains(t, err, "mark :9 not declared")
+}
+
+// readCommitFiles returns the contents of the files of commit id by path
+func readCommitFiles(t *testing.T, repo *vcs.Repository, id objects.ObjectID) map[string]string {
+	commit, err := repo.GetCommit(id)
+	require.NoError(t, err)
+	files := make(map[string]string)
+	var walk func(treeID objects.ObjectID, prefix string)
+	walk = func(treeID objects.ObjectID, prefix string) {
+		tree, err := repo.GetTree(treeID)
+		require.NoError(t, err)
+		for _, entry := range tree.Entries() {
+			if entry.Mode == objects.ModeTree {
+				walk(entry.ID, prefix+entry.Name+"/")
+				continue
+			}
+			blob, err := repo.GetBlob(entry.ID)
+			require.NoError(t, err)
+			files[prefix+entry.Name] = string(blob.Data())
+		}
+	}
+	walk(commit.Tree(), "")
+	return files
+}
@@ -0,0 +1,669 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/faststream"
+	"github.com/fenilsonani/vcs/internal/core/merge"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/internal/revparse"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// fastImportOptions holds the flags of fast-import
+type fastImportOptions struct {
+	exportMarks         string
+	importMarks         string
+	importMarksIfExists bool
+	force               bool
+	quiet               bool
+}
+
+// importBranch is what the stream has done to a branch so far
+type importBranch struct {
+	tip objects.ObjectID
+	// files is the tree of tip, read when first needed
+	files map[string]merge.Entry
+}
+
+// fastImporter replays a fast-import stream into a repository
+type fastImporter struct {
+	repo       *vcs.Repository
+	refManager *refs.RefManager
+	resolver   *revparse.Resolver
+	out        io.Writer
+	errOut     io.Writer
+	opts       fastImportOptions
+	marks      faststream.Marks
+	branches   map[string]*importBranch
+	tags       map[string]objects.ObjectID
+	done       bool
+	// counts is the number of objects of each type written
+	counts map[objects.ObjectType]int
+}
+
+func newFastImportCommand() *cobra.Command {
+	var opts fastImportOptions
+
+	cmd := &cobra.Command{
+		Use:   "fast-import [flags]",
+		Short: "Import history from a fast-import stream",
+		Long: `Reads a fast-import stream, such as fast-export here or in Git writes,
+from stdin and creates the blobs, trees, commits and tags it describes.
+The working tree and index are left alone.
+
+Branches are updated when the stream ends or asks for a checkpoint. A
+branch is only moved to a commit that contains its current tip unless
+--force is given; branches that cannot be updated are reported and make
+the import fail. Tags are always updated.
+
+--export-marks writes the object each mark of the stream stood for, and
+--import-marks loads marks of an earlier import, so a stream can build on
+it. The stream may also name marks files with the import-marks and
+export-marks features, which the flags override.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runFastImport(cmd, opts)
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.exportMarks, "export-marks", "", "Write the marks of the stream to <file> when done")
+	cmd.Flags().StringVar(&opts.importMarks, "import-marks", "", "Load marks from <file> before reading the stream")
+	cmd.Flags().BoolVar(&opts.importMarksIfExists, "import-marks-if-exists", false, "Ignore a missing --import-marks file")
+	cmd.Flags().BoolVar(&opts.force, "force", false, "Update branches even if they lose commits")
+	cmd.Flags().BoolVar(&opts.quiet, "quiet", false, "Do not print statistics")
+
+	return cmd
+}
+
+func runFastImport(cmd *cobra.Command, opts fastImportOptions) error {
+	repoPath, err := findRepository()
+	if err != nil {
+		return fmt.Errorf("not a git repository: %w", err)
+	}
+	repo, err := openRepository(repoPath)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	im := &fastImporter{
+		repo:       repo,
+		refManager: refs.NewRefManager(repo.GitDir()),
+		resolver:   newResolver(repo),
+		out:        cmd.OutOrStdout(),
+		errOut:     cmd.ErrOrStderr(),
+		opts:       opts,
+		marks:      make(faststream.Marks),
+		branches:   make(map[string]*importBranch),
+		tags:       make(map[string]objects.ObjectID),
+		counts:     make(map[objects.ObjectType]int),
+	}
+	if opts.importMarks != "" {
+		if err := im.loadMarks(opts.importMarks, opts.importMarksIfExists); err != nil {
+			return err
+		}
+	}
+
+	err = im.run(faststream.NewReader(cmd.InOrStdin()))
+	// Whatever was imported before an error is kept, as in Git
+	if refErr := im.checkpoint(); err == nil {
+		err = refErr
+	}
+	if err != nil {
+		return err
+	}
+	if !opts.quiet {
+		im.printStats()
+	}
+	autoGC(cmd.ErrOrStderr(), repo)
+	return nil
+}
+
+// loadMarks adds the marks in the file at path
+func (im *fastImporter) loadMarks(path string, ifExists bool) error {
+	f, err := os.Open(path)
+	if ifExists && os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to open marks file: %w", err)
+	}
+	defer f.Close()
+	marks, err := faststream.ReadMarks(f)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	for mark, id := range marks {
+		im.marks[mark] = id
+	}
+	return nil
+}
+
+// run replays the commands of the stream
+func (im *fastImporter) run(r *faststream.Reader) error {
+	requireDone := false
+	for {
+		cmd, err := r.Next()
+		if err == io.EOF {
+			if requireDone && !im.done {
+				return fmt.Errorf("stream ends early without the done command")
+			}
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read stream: %w", err)
+		}
+
+		switch cmd := cmd.(type) {
+		case *faststream.Blob:
+			err = im.importBlob(cmd)
+		case *faststream.Commit:
+			err = im.importCommit(cmd)
+		case *faststream.Tag:
+			err = im.importTag(cmd)
+		case *faststream.Reset:
+			err = im.importReset(cmd)
+		case *faststream.Progress:
+			fmt.Fprintf(im.out, "progress %s\n", cmd.Message)
+		case *faststream.Checkpoint:
+			err = im.checkpoint()
+		case *faststream.Done:
+			im.done = true
+			return nil
+		case *faststream.Feature:
+			if cmd.Name == "done" {
+				requireDone = true
+			}
+			err = im.feature(cmd)
+		case *faststream.Option:
+			err = im.option(cmd.Value)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// feature enables a feature the stream requires, or fails for those that
+// are not supported
+func (im *fastImporter) feature(f *faststream.Feature) error {
+	switch f.Name {
+	case "done":
+	case "date-format":
+		if f.Arg != "raw" {
+			return fmt.Errorf("unsupported date format: %s", f.Arg)
+		}
+	case "force":
+		im.opts.force = true
+	case "import-marks", "import-marks-if-exists":
+		// Marks given on the command line win over those in the stream
+		if im.opts.importMarks == "" {
+			return im.loadMarks(f.Arg, f.Name == "import-marks-if-exists")
+		}
+	case "export-marks":
+		if im.opts.exportMarks == "" {
+			im.opts.exportMarks = f.Arg
+		}
+	default:
+		return fmt.Errorf("feature %s is not supported", f.Name)
+	}
+	return nil
+}
+
+// option applies an option meant for Git's fast-import; those for other
+// importers are ignored
+func (im *fastImporter) option(value string) error {
+	opt, ok := strings.CutPrefix(value, "git ")
+	if !ok {
+		return nil
+	}
+	switch {
+	case opt == "quiet":
+		im.opts.quiet = true
+	case opt == "force":
+		im.opts.force = true
+	case strings.HasPrefix(opt, "export-marks="), strings.HasPrefix(opt, "import-marks="),
+		strings.HasPrefix(opt, "import-marks-if-exists="):
+		name, arg, _ := strings.Cut(opt, "=")
+		return im.feature(&faststream.Feature{Name: name, Arg: arg})
+	default:
+		return fmt.Errorf("option %s is not supported", opt)
+	}
+	return nil
+}
+
+// writeObject writes raw object data and records it under mark
+func (im *fastImporter) writeObject(objType objects.ObjectType, data []byte, mark int) (objects.ObjectID, error) {
+	id, err := im.repo.Storage().WriteRawObject(objType, data)
+	if err != nil {
+		return objects.ObjectID{}, fmt.Errorf("failed to write %s: %w", objType, err)
+	}
+	im.counts[objType]++
+	if mark > 0 {
+		im.marks[mark] = id
+	}
+	return id, nil
+}
+
+func (im *fastImporter) importBlob(blob *faststream.Blob) error {
+	_, err := im.writeObject(objects.TypeBlob, blob.Data, blob.Mark)
+	return err
+}
+
+// resolve returns the object a stream names by mark, object ID, branch of
+// the stream or ref of the repository
+func (im *fastImporter) resolve(name string) (objects.ObjectID, error) {
+	if mark, ok := faststream.ParseMarkRef(name); ok {
+		id, ok := im.marks[mark]
+		if !ok {
+			return objects.ObjectID{}, fmt.Errorf("mark %s not declared", name)
+		}
+		return id, nil
+	}
+	if id, err := objects.NewObjectID(name); err == nil {
+		if !im.repo.Storage().HasObject(id) {
+			return objects.ObjectID{}, fmt.Errorf("object %s not found", name)
+		}
+		return id, nil
+	}
+	if branch, ok := im.branches[name]; ok && !branch.tip.IsZero() {
+		return branch.tip, nil
+	}
+	id, err := im.resolver.Resolve(name)
+	if err != nil {
+		return objects.ObjectID{}, fmt.Errorf("cannot resolve '%s': %w", name, err)
+	}
+	return id, nil
+}
+
+// resolveCommit resolves name to a commit, through any tags
+func (im *fastImporter) resolveCommit(name string) (objects.ObjectID, error) {
+	id, err := im.resolve(name)
+	if err != nil {
+		return objects.ObjectID{}, err
+	}
+	for {
+		obj, err := im.repo.ReadObject(id)
+		if err != nil {
+			return objects.ObjectID{}, fmt.Errorf("failed to read object %s: %w", id.Short(), err)
+		}
+		switch obj := obj.(type) {
+		case *objects.Commit:
+			return id, nil
+		case *objects.Tag:
+			id = obj.Object()
+		default:
+			return objects.ObjectID{}, fmt.Errorf("'%s' is not a commit", name)
+		}
+	}
+}
+
+// branch returns the state of the branch ref, starting it if the stream
+// has not used it yet. A branch the stream starts has no commits, even if
+// the ref exists.
+func (im *fastImporter) branch(ref string) (*importBranch, error) {
+	if !strings.HasPrefix(ref, "refs/") {
+		return nil, fmt.Errorf("invalid ref name '%s'", ref)
+	}
+	branch, ok := im.branches[ref]
+	if !ok {
+		branch = &importBranch{}
+		im.branches[ref] = branch
+	}
+	return branch, nil
+}
+
+// setTip moves branch to commit id, forgetting its files
+func (branch *importBranch) setTip(id objects.ObjectID) {
+	if branch.tip != id {
+		branch.tip = id
+		branch.files = nil
+	}
+}
+
+// loadFiles returns the files of the branch's tip
+func (im *fastImporter) loadFiles(branch *importBranch) (map[string]merge.Entry, error) {
+	if branch.files != nil {
+		return branch.files, nil
+	}
+	files := make(map[string]merge.Entry)
+	if !branch.tip.IsZero() {
+		commit, err := im.repo.GetCommit(branch.tip)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read commit %s: %w", branch.tip.Short(), err)
+		}
+		entries, err := merge.ReadTree(im.repo, commit.Tree())
+		if err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			files[entry.Path] = entry
+		}
+	}
+	branch.files = files
+	return files, nil
+}
+
+func (im *fastImporter) importCommit(c *faststream.Commit) error {
+	branch, err := im.branch(c.Ref)
+	if err != nil {
+		return err
+	}
+
+	var parents []objects.ObjectID
+	if c.From != "" {
+		from, err := im.resolveCommit(c.From)
+		if err != nil {
+			return fmt.Errorf("commit %s: %w", c.Ref, err)
+		}
+		branch.setTip(from)
+	}
+	if !branch.tip.IsZero() {
+		parents = append(parents, branch.tip)
+	}
+	for _, name := range c.Merge {
+		id, err := im.resolveCommit(name)
+		if err != nil {
+			return fmt.Errorf("commit %s: %w", c.Ref, err)
+		}
+		parents = append(parents, id)
+	}
+
+	// The files of the parent are copied so the branch is unchanged if a
+	// file command fails
+	parentFiles, err := im.loadFiles(branch)
+	if err != nil {
+		return err
+	}
+	files := make(map[string]merge.Entry, len(parentFiles))
+	for p, entry := range parentFiles {
+		files[p] = entry
+	}
+	for _, change := range c.Files {
+		if err := im.applyFileChange(files, change); err != nil {
+			return fmt.Errorf("commit %s: %w", c.Ref, err)
+		}
+	}
+
+	entries := make([]merge.Entry, 0, len(files))
+	for _, entry := range files {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	treeID, err := merge.WriteTree(im.repo, entries)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "tree %s\n", treeID)
+	for _, parent := range parents {
+		fmt.Fprintf(&buf, "parent %s\n", parent)
+	}
+	author := c.Author
+	if author == "" {
+		author = c.Committer
+	}
+	fmt.Fprintf(&buf, "author %s\ncommitter %s\n", author, c.Committer)
+	if c.Encoding != "" {
+		fmt.Fprintf(&buf, "encoding %s\n", c.Encoding)
+	}
+	buf.WriteString("\n")
+	buf.Write(c.Message)
+
+	id, err := im.writeObject(objects.TypeCommit, buf.Bytes(), c.Mark)
+	if err != nil {
+		return err
+	}
+	branch.tip = id
+	branch.files = files
+	return nil
+}
+
+// applyFileChange applies a file command to the files of a commit
+func (im *fastImporter) applyFileChange(files map[string]merge.Entry, change faststream.FileChange) error {
+	switch change.Op {
+	case faststream.FileDeleteAll:
+		for p := range files {
+			delete(files, p)
+		}
+		return nil
+	case faststream.FileDelete:
+		removePath(files, cleanImportPath(change.Path))
+		return nil
+	case faststream.FileRename, faststream.FileCopy:
+		return copyPath(files, cleanImportPath(change.Source), cleanImportPath(change.Path), change.Op == faststream.FileRename)
+	}
+
+	p := cleanImportPath(change.Path)
+	if p == "" && change.Mode != objects.ModeTree {
+		return fmt.Errorf("empty path")
+	}
+	var id objects.ObjectID
+	switch change.DataRef {
+	case "inline":
+		if change.Mode == objects.ModeCommit || change.Mode == objects.ModeTree {
+			return fmt.Errorf("inline data cannot have mode %06o", uint32(change.Mode))
+		}
+		var err error
+		if id, err = im.writeObject(objects.TypeBlob, change.Data, 0); err != nil {
+			return err
+		}
+	default:
+		// Submodule commits live in another repository
+		var err error
+		if change.Mode == objects.ModeCommit {
+			id, err = objects.NewObjectID(change.DataRef)
+		} else {
+			id, err = im.resolve(change.DataRef)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", p, err)
+		}
+	}
+
+	removePath(files, p)
+	if change.Mode == objects.ModeTree {
+		entries, err := merge.ReadTree(im.repo, id)
+		if err != nil {
+			return err
+		}
+		for _, entry := range entries {
+			entry.Path = strings.TrimPrefix(p+"/"+entry.Path, "/")
+			files[entry.Path] = entry
+		}
+		return nil
+	}
+	// A file replaces any file at a directory above it
+	for dir := p; strings.Contains(dir, "/"); {
+		dir = dir[:strings.LastIndexByte(dir, '/')]
+		delete(files, dir)
+	}
+	files[p] = merge.Entry{Path: p, Mode: change.Mode, ID: id}
+	return nil
+}
+
+// cleanImportPath drops the slashes a stream may put around a path
+func cleanImportPath(p string) string {
+	return strings.Trim(p, "/")
+}
+
+// removePath removes the file at p or the files under it
+func removePath(files map[string]merge.Entry, p string) {
+	if p == "" {
+		for name := range files {
+			delete(files, name)
+		}
+		return
+	}
+	delete(files, p)
+	for name := range files {
+		if strings.HasPrefix(name, p+"/") {
+			delete(files, name)
+		}
+	}
+}
+
+// copyPath copies the file or directory at source to dest, removing
+// source when rename is set
+func copyPath(files map[string]merge.Entry, source, dest string, rename bool) error {
+	moved := make(map[string]merge.Entry)
+	for name, entry := range files {
+		if name == source {
+			moved[dest] = merge.Entry{Path: dest, Mode: entry.Mode, ID: entry.ID}
+		} else if rest, ok := strings.CutPrefix(name, source+"/"); ok {
+			moved[dest+"/"+rest] = merge.Entry{Path: dest + "/" + rest, Mode: entry.Mode, ID: entry.ID}
+		}
+	}
+	if len(moved) == 0 {
+		return fmt.Errorf("path %s not in branch", source)
+	}
+	if rename {
+		removePath(files, source)
+	}
+	removePath(files, dest)
+	for name, entry := range moved {
+		files[name] = entry
+	}
+	return nil
+}
+
+func (im *fastImporter) importTag(t *faststream.Tag) error {
+	target, err := im.resolve(t.From)
+	if err != nil {
+		return fmt.Errorf("tag %s: %w", t.Name, err)
+	}
+	objType, _, err := im.repo.Storage().ReadRawObject(target)
+	if err != nil {
+		return fmt.Errorf("failed to read object %s: %w", target.Short(), err)
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "object %s\ntype %s\ntag %s\n", target, objType, t.Name)
+	if t.Tagger != "" {
+		fmt.Fprintf(&buf, "tagger %s\n", t.Tagger)
+	}
+	buf.WriteString("\n")
+	buf.Write(t.Message)
+
+	id, err := im.writeObject(objects.TypeTag, buf.Bytes(), t.Mark)
+	if err != nil {
+		return err
+	}
+	im.tags["refs/tags/"+t.Name] = id
+	return nil
+}
+
+func (im *fastImporter) importReset(reset *faststream.Reset) error {
+	// Tags may be reset like branches
+	branch, err := im.branch(reset.Ref)
+	if err != nil {
+		return err
+	}
+	delete(im.tags, reset.Ref)
+	if reset.From == "" {
+		branch.setTip(objects.ObjectID{})
+		return nil
+	}
+	id, err := im.resolve(reset.From)
+	if err != nil {
+		return fmt.Errorf("reset %s: %w", reset.Ref, err)
+	}
+	branch.setTip(id)
+	return nil
+}
+
+// errBranchesNotUpdated reports that some branches would have lost commits
+var errBranchesNotUpdated = errors.New("some branches were not updated")
+
+// checkpoint updates the refs the stream has set and writes the marks
+func (im *fastImporter) checkpoint() error {
+	var failed bool
+	names := make([]string, 0, len(im.branches))
+	for name := range im.branches {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		tip := im.branches[name].tip
+		if tip.IsZero() {
+			continue
+		}
+		old, err := im.refManager.ResolveRef(name)
+		if err == nil && old != tip && !im.opts.force {
+			reachable, err := im.resolver.Reachable([]objects.ObjectID{tip})
+			if err != nil {
+				return err
+			}
+			if !reachable[old] {
+				fmt.Fprintf(im.errOut, "warning: not updating %s (new tip %s does not contain %s)\n", name, tip, old)
+				failed = true
+				continue
+			}
+		}
+		if err := im.updateRef(name, tip); err != nil {
+			return err
+		}
+	}
+	for name, id := range im.tags {
+		if err := im.updateRef(name, id); err != nil {
+			return err
+		}
+	}
+
+	if im.opts.exportMarks != "" {
+		f, err := os.Create(im.opts.exportMarks)
+		if err != nil {
+			return fmt.Errorf("failed to create %s: %w", im.opts.exportMarks, err)
+		}
+		err = faststream.WriteMarks(f, im.marks)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return fmt.Errorf("failed to write %s: %w", im.opts.exportMarks, err)
+		}
+	}
+	if failed {
+		return errBranchesNotUpdated
+	}
+	return nil
+}
+
+// updateRef points name at id, logging the update
+func (im *fastImporter) updateRef(name string, id objects.ObjectID) error {
+	old, _ := im.refManager.ResolveRef(name)
+	if old == id {
+		return nil
+	}
+	if err := im.refManager.UpdateRef(name, id); err != nil {
+		return fmt.Errorf("failed to update %s: %w", name, err)
+	}
+	logRefUpdate(im.refManager, name, old, id, "fast-import")
+	return nil
+}
+
+// printStats reports what the import wrote
+func (im *fastImporter) printStats() {
+	total := 0
+	for _, n := range im.counts {
+		total += n
+	}
+	fmt.Fprintf(im.errOut, "fast-import statistics:\n")
+	fmt.Fprintf(im.errOut, "Total objects: %10d\n", total)
+	for _, objType := range []objects.ObjectType{objects.TypeBlob, objects.TypeCommit, objects.TypeTag} {
+		fmt.Fprintf(im.errOut, "      %-7s: %10d\n", string(objType)+"s", im.counts[objType])
+	}
+	fmt.Fprintf(im.errOut, "Total branches: %9d\n", len(im.branches))
+	fmt.Fprintf(im.errOut, "      marks  : %10d\n", len(im.marks))
+}
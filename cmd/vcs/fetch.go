@@ -3,21 +3,73 @@ package main
 import (
 	"context"
 	"fmt"
+	"os"
 	"path/filepath"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
+	"github.com/fenilsonani/vcs/internal/core/config"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/packfile"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/internal/metrics"
+	"github.com/fenilsonani/vcs/internal/revparse"
 	"github.com/fenilsonani/vcs/pkg/vcs"
+	"github.com/fenilsonani/vcs/internal/serve"
+	"github.com/fenilsonani/vcs/internal/trace"
 	"github.com/fenilsonani/vcs/internal/transport"
 )
 
+// shallowOptions controls how much history a fetch retrieves
+type shallowOptions struct {
+	depth     int
+	deepen    int
+	unshallow bool
+	since     time.Time
+	exclude   []string
+}
+
+// isSet reports whether any history-limiting option was given
+func (o shallowOptions) isSet() bool {
+	return o.depth > 0 || o.deepen > 0 || o.unshallow || !o.since.IsZero() || len(o.exclude) > 0
+}
+
+// validate rejects option combinations Git also refuses
+func (o shallowOptions) validate(repo *vcs.Repository) error {
+	if o.depth < 0 {
+		return fmt.Errorf("depth %d is not a positive number", o.depth)
+	}
+	if o.deepen < 0 {
+		return fmt.Errorf("deepen %d is not a positive number", o.deepen)
+	}
+	if o.depth > 0 && o.deepen > 0 {
+		return fmt.Errorf("options '--depth' and '--deepen' cannot be used together")
+	}
+	if o.unshallow {
+		if o.depth > 0 || o.deepen > 0 {
+			return fmt.Errorf("options '--unshallow' and '--depth'/'--deepen' cannot be used together")
+		}
+		if !repo.IsShallow() {
+			return fmt.Errorf("--unshallow on a complete repository does not make sense")
+		}
+	}
+	return nil
+}
+
 func newFetchCommand() *cobra.Command {
 	var (
-		all     bool
-		prune   bool
-		tags    bool
-		depth   int
-		verbose bool
+		all          bool
+		prune        bool
+		tags         bool
+		depth        int
+		deepen       int
+		unshallow    bool
+		shallowSince string
+		exclude      []string
+		verbose      bool
 	)
 
 	cmd := &cobra.Command{
@@ -25,7 +77,22 @@ func newFetchCommand() *cobra.Command {
 		Short: "Download objects and refs from another repository",
 		Long: `Fetch branches and/or tags (collectively, "refs") from one or more
 other repositories, along with the objects necessary to complete their
-histories. Remote-tracking branches are updated.`,
+histories. Remote-tracking branches are updated.
+
+Over HTTP, the list of refs a server advertises is kept in the repository
+with the ETag the server sent. The next fetch asks whether it changed and
+reuses the kept copy if not, so polling a busy server for changes stays
+cheap.
+
+--prune deletes the remote-tracking branches whose branch the remote no
+longer has, and with --prune-tags also the local tags it does not have.
+remote.<name>.prune and remote.<name>.pruneTags, or else fetch.prune and
+fetch.pruneTags, turn them on by default.
+
+The fetched branches are listed in FETCH_HEAD, those named after the
+remote marked for merging and the others left out. Without names the
+upstream of the current branch is marked, and "vcs merge FETCH_HEAD"
+merges the marked branch.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			// Find repository
 			repoPath, err := findRepository()
@@ -34,7 +101,7 @@ histories. Remote-tracking branches are updated.`,
 			}
 
 			// Open repository
-			repo, err := vcs.Open(repoPath)
+			repo, err := openStoredRepository(repoPath)
 			if err != nil {
 				return fmt.Errorf("failed to open repository: %w", err)
 			}
@@ -56,6 +123,21 @@ histories. Remote-tracking branches are updated.`,
 				return fmt.Errorf("remote '%s' does not exist", remoteName)
 			}
 
+			shallow := shallowOptions{
+				depth:     depth,
+				deepen:    deepen,
+				unshallow: unshallow,
+				exclude:   exclude,
+			}
+			if shallowSince != "" {
+				if shallow.since, err = parseShallowSince(shallowSince); err != nil {
+					return err
+				}
+			}
+			if err := shallow.validate(repo); err != nil {
+				return err
+			}
+
 			fmt.Fprintf(cmd.OutOrStdout(), "Fetching from %s (%s)\n", remoteName, remoteURL)
 
 			// In a real implementation, this would:
@@ -66,63 +148,182 @@ histories. Remote-tracking branches are updated.`,
 			// 5. Update remote-tracking branches
 
 			// For now, create a basic implementation that shows the structure
-			if err := fetchFromRemote(cmd, repo, remoteName, remoteURL, all, prune, tags, depth, verbose); err != nil {
+			start := time.Now()
+			var forMerge []string
+			if len(args) > 1 {
+				forMerge = args[1:]
+			}
+			err = fetchFromRemote(cmd, repo, remoteName, remoteURL, forMerge, all, prune, tags, shallow, verbose)
+			recordRun(cmd.ErrOrStderr(), repo, metrics.KindFetch, remoteName, start, err)
+			if err != nil {
 				return fmt.Errorf("fetch failed: %w", err)
 			}
 
+			autoGC(cmd.ErrOrStderr(), repo)
 			return nil
 		},
 	}
 
 	cmd.Flags().BoolVar(&all, "all", false, "Fetch all remotes")
-	cmd.Flags().BoolVar(&prune, "prune", false, "Prune remote-tracking branches no longer on remote")
+	cmd.Flags().BoolVarP(&prune, "prune", "p", false, "Prune remote-tracking branches no longer on remote")
+	cmd.Flags().BoolP("prune-tags", "P", false, "When pruning, also prune local tags no longer on remote")
 	cmd.Flags().BoolVar(&tags, "tags", false, "Fetch all tags from the remote")
 	cmd.Flags().IntVar(&depth, "depth", 0, "Limit fetching to specified number of commits")
+	cmd.Flags().IntVar(&deepen, "deepen", 0, "Deepen history of a shallow repository by the given number of commits")
+	cmd.Flags().BoolVar(&unshallow, "unshallow", false, "Convert a shallow repository to a complete one")
+	cmd.Flags().StringVar(&shallowSince, "shallow-since", "", "Deepen history of a shallow repository based on time")
+	cmd.Flags().StringSliceVar(&exclude, "shallow-exclude", nil, "Deepen history of a shallow repository, excluding a ref")
 	cmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Be verbose")
+	cmd.Flags().String("limit-rate", "", "Cap transfer bandwidth in bytes per second, with an optional k, m or g suffix (overrides transfer.rateLimit)")
+	cmd.Flags().Bool("progress", false, "Show progress even when standard error is not a terminal")
 
 	return cmd
 }
 
-func fetchFromRemote(cmd *cobra.Command, repo *vcs.Repository, remoteName, remoteURL string, all, prune, tags bool, depth int, verbose bool) error {
+func fetchFromRemote(cmd *cobra.Command, repo *vcs.Repository, remoteName, remoteURL string, forMerge []string, all, prune, tags bool, shallow shallowOptions, verbose bool) error {
 	// Create refs/remotes directory structure
-	remoteRefsDir := filepath.Join(repo.GitDir(), "refs", "remotes", remoteName)
+	remoteRefsDir := filepath.Join(repo.CommonDir(), "refs", "remotes", remoteName)
 	if err := ensureDir(remoteRefsDir); err != nil {
 		return fmt.Errorf("failed to create remote refs directory: %w", err)
 	}
 
-	// Try to use HTTP transport for supported URLs
-	if isHTTPURL(remoteURL) {
-		return fetchWithHTTPTransport(cmd, repo, remoteName, remoteURL, verbose)
+	// Try to use HTTP transport for supported URLs, and serve local
+	// repositories through the same protocol
+	if isLocalRepository(remoteURL) || isBundleURL(remoteURL) || isHTTPURL(remoteURL) {
+		discovery, err := fetchRefsWithHTTPTransport(cmd, repo, remoteName, remoteURL, shallow, verbose)
+		if err != nil || discovery == nil {
+			return err
+		}
+		if err := writeFetchHead(repo, remoteName, remoteURL, discovery.Refs, forMerge); err != nil {
+			return err
+		}
+		return pruneFetched(cmd, repo, remoteName, discovery, prune)
 	}
 
 	// Fallback to basic implementation for other URLs
 	return fetchBasicImplementation(cmd, repo, remoteName, remoteURL, verbose)
 }
 
+// writeFetchHead records in FETCH_HEAD the branches advertised by the
+// remote at remoteURL. The branches named in forMerge are listed first and
+// marked for merging, and only they are listed; without any the upstream of
+// the current branch is marked, as pull would choose it.
+func writeFetchHead(repo *vcs.Repository, remoteName, remoteURL string, advertised map[string]string, forMerge []string) error {
+	heads := make(map[string]objects.ObjectID)
+	var names []string
+	for refName, hexID := range advertised {
+		branch, ok := strings.CutPrefix(refName, "refs/heads/")
+		if !ok {
+			continue
+		}
+		id, err := objects.NewObjectID(hexID)
+		if err != nil {
+			continue
+		}
+		heads[branch] = id
+		names = append(names, branch)
+	}
+	sort.Strings(names)
+
+	merging := make([]string, 0, len(forMerge))
+	for _, name := range forMerge {
+		name = strings.TrimPrefix(name, "refs/heads/")
+		if _, ok := heads[name]; !ok {
+			return fmt.Errorf("couldn't find remote ref %s", name)
+		}
+		merging = append(merging, name)
+	}
+	listed := merging
+	if len(forMerge) == 0 {
+		listed = names
+		if current, err := refs.NewRefManager(repo.GitDir()).CurrentBranch(); err == nil {
+			if remote, branch := pullSource(loadConfig(repo.GitDir()), current, nil); remote == remoteName {
+				if _, ok := heads[branch]; ok {
+					merging = []string{branch}
+				}
+			}
+		}
+	}
+
+	// Git names the remote without its trailing slash and .git
+	url := strings.TrimSuffix(strings.TrimRight(remoteURL, "/"), ".git")
+	var data strings.Builder
+	for _, name := range merging {
+		fmt.Fprintf(&data, "%s\t\tbranch '%s' of %s\n", heads[name], name, url)
+	}
+	for _, name := range listed {
+		if !contains(merging, name) {
+			fmt.Fprintf(&data, "%s\tnot-for-merge\tbranch '%s' of %s\n", heads[name], name, url)
+		}
+	}
+	if err := writeFile(filepath.Join(repo.GitDir(), "FETCH_HEAD"), []byte(data.String())); err != nil {
+		return fmt.Errorf("failed to update FETCH_HEAD: %w", err)
+	}
+	return nil
+}
+
+// pruneFetched deletes, after a fetch from remoteName, the remote-tracking
+// branches and, with --prune-tags, the tags that are not among the refs
+// the remote advertised. prune is --prune; without it the configuration
+// decides.
+func pruneFetched(cmd *cobra.Command, repo *vcs.Repository, remoteName string, discovery *transport.RefDiscovery, prune bool) error {
+	pruneTags, _ := cmd.Flags().GetBool("prune-tags")
+	cfg := loadConfig(repo.GitDir())
+	for _, setting := range []struct {
+		value *bool
+		key   string
+	}{{&prune, "prune"}, {&pruneTags, "pruneTags"}} {
+		if *setting.value {
+			continue
+		}
+		for _, key := range []string{"remote." + remoteName + "." + setting.key, "fetch." + setting.key} {
+			if value, ok := cfg.Get(key); ok {
+				on, err := config.ParseBool(value)
+				if err != nil {
+					return fmt.Errorf("invalid %s: %w", key, err)
+				}
+				*setting.value = on
+				break
+			}
+		}
+	}
+	if !prune {
+		return nil
+	}
+
+	refManager := refs.NewRefManager(repo.GitDir())
+	stale, err := staleRefs(refManager, remoteName, discovery.Refs, pruneTags)
+	if err != nil {
+		return err
+	}
+	for _, ref := range stale {
+		if err := refManager.DeleteRef(ref); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", ref, err)
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), " - %-18s %-10s -> %s\n", "[deleted]", "(none)", shortRemoteRef(ref))
+	}
+	return nil
+}
+
 func isHTTPURL(url string) bool {
 	return strings.HasPrefix(url, "http://") || strings.HasPrefix(url, "https://") || 
+		   strings.HasPrefix(url, serve.URLScheme) ||
 		   strings.Contains(url, "github.com") || strings.Contains(url, "@")
 }
 
-func fetchWithHTTPTransport(cmd *cobra.Command, repo *vcs.Repository, remoteName, remoteURL string, verbose bool) error {
+func fetchWithHTTPTransport(cmd *cobra.Command, repo *vcs.Repository, remoteName, remoteURL string, shallow shallowOptions, verbose bool) error {
+	_, err := fetchRefsWithHTTPTransport(cmd, repo, remoteName, remoteURL, shallow, verbose)
+	return err
+}
+
+// fetchRefsWithHTTPTransport fetches like fetchWithHTTPTransport and returns
+// the refs the remote advertised, or nil when it fell back to the basic
+// implementation
+func fetchRefsWithHTTPTransport(cmd *cobra.Command, repo *vcs.Repository, remoteName, remoteURL string, shallow shallowOptions, verbose bool) (*transport.RefDiscovery, error) {
 	ctx := context.Background()
-	
-	// Create appropriate transport
-	var httpTransport *transport.HTTPTransport
-	if strings.Contains(remoteURL, "github.com") {
-		// Use GitHub transport with potential token authentication
-		githubTransport, err := transport.NewGitHubTransport(remoteURL, "")
-		if err != nil {
-			return fmt.Errorf("failed to create GitHub transport: %w", err)
-		}
-		httpTransport = githubTransport.HTTPTransport
-	} else {
-		// Parse URL to get HTTP equivalent
-		httpURL, err := transport.ParseGitURL(remoteURL)
-		if err != nil {
-			return fmt.Errorf("failed to parse remote URL: %w", err)
-		}
-		httpTransport = transport.NewHTTPTransport(httpURL)
+	httpTransport, err := openHTTPTransport(cmd, repo, remoteName, remoteURL)
+	if err != nil {
+		return nil, err
 	}
 
 	if verbose {
@@ -132,55 +333,420 @@ func fetchWithHTTPTransport(cmd *cobra.Command, repo *vcs.Repository, remoteName
 	// Discover remote refs
 	discovery, err := httpTransport.DiscoverRefs(ctx, "git-upload-pack")
 	if err != nil {
+		// A local repository has nothing to fall back to
+		if isLocalURL(remoteURL) {
+			return nil, fmt.Errorf("failed to read refs of %s: %w", remoteURL, err)
+		}
 		if verbose {
 			fmt.Fprintf(cmd.OutOrStdout(), "HTTP transport failed: %v\n", err)
 			fmt.Fprintln(cmd.OutOrStdout(), "Falling back to basic implementation...")
 		}
-		return fetchBasicImplementation(cmd, repo, remoteName, remoteURL, verbose)
+		return nil, fetchBasicImplementation(cmd, repo, remoteName, remoteURL, verbose)
 	}
 
 	if verbose {
+		if discovery.Cached {
+			fmt.Fprintln(cmd.OutOrStdout(), "Refs unchanged since the last fetch, using the cached advertisement")
+		}
 		fmt.Fprintln(cmd.OutOrStdout(), "remote: Enumerating objects...")
 		fmt.Fprintf(cmd.OutOrStdout(), "remote: Found %d refs\n", len(discovery.Refs))
 	}
 
-	fmt.Fprintf(cmd.OutOrStdout(), "From %s\n", remoteURL)
-
-	// Update local remote refs with discovered refs
+	// Collect the branch heads to fetch
+	heads := make(map[string]objects.ObjectID)
 	for refName, objectID := range discovery.Refs {
-		if strings.HasPrefix(refName, "refs/heads/") {
-			branchName := strings.TrimPrefix(refName, "refs/heads/")
-			remoteRefPath := filepath.Join(repo.GitDir(), "refs", "remotes", remoteName, branchName)
-			
-			if err := ensureDir(filepath.Dir(remoteRefPath)); err != nil {
-				return fmt.Errorf("failed to create remote ref directory: %w", err)
-			}
-			
-			if err := writeFile(remoteRefPath, []byte(objectID+"\n")); err != nil {
-				return fmt.Errorf("failed to update remote ref: %w", err)
-			}
-			
+		if !strings.HasPrefix(refName, "refs/heads/") {
+			continue
+		}
+		id, err := objects.NewObjectID(objectID)
+		if err != nil {
 			if verbose {
-				fmt.Fprintf(cmd.OutOrStdout(), " * [new branch]      %s       -> %s/%s\n", 
-					branchName, remoteName, branchName)
+				fmt.Fprintf(cmd.OutOrStdout(), "warning: ignoring invalid ref %s\n", refName)
 			}
+			continue
 		}
+		heads[strings.TrimPrefix(refName, "refs/heads/")] = id
 	}
 
-	// Update FETCH_HEAD
-	fetchHeadPath := filepath.Join(repo.GitDir(), "FETCH_HEAD")
-	fetchHeadContent := fmt.Sprintf("# Fetched from %s via HTTP transport\n", remoteURL)
-	if err := writeFile(fetchHeadPath, []byte(fetchHeadContent)); err != nil {
-		return fmt.Errorf("failed to update FETCH_HEAD: %w", err)
+	if err := fetchPackForHeads(cmd, repo, httpTransport, discovery, heads, shallow, verbose); err != nil {
+		return nil, err
+	}
+
+	fmt.Fprintf(cmd.OutOrStdout(), "From %s\n", remoteURL)
+
+	// Update remote-tracking refs only once their objects are present. The
+	// remote's fetch refspecs say which refs they are.
+	cfg, err := config.Load(repo.GitDir())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	refManager := refs.NewRefManager(repo.GitDir())
+	for branchName, id := range heads {
+		remoteRef, ok := revparse.TrackingRef(cfg, remoteName, "refs/heads/"+branchName)
+		if !ok {
+			continue
+		}
+		remoteRefPath := filepath.Join(repo.CommonDir(), filepath.FromSlash(remoteRef))
+
+		if err := ensureDir(filepath.Dir(remoteRefPath)); err != nil {
+			return nil, fmt.Errorf("failed to create remote ref directory: %w", err)
+		}
+
+		oldID, _ := refManager.ResolveRef(remoteRef)
+		if err := writeFile(remoteRefPath, []byte(id.String()+"\n")); err != nil {
+			return nil, fmt.Errorf("failed to update remote ref: %w", err)
+		}
+		logRefUpdate(refManager, remoteRef, oldID, id, "fetch: "+remoteURL)
+
+		if verbose {
+			fmt.Fprintf(cmd.OutOrStdout(), " * [new branch]      %s       -> %s\n",
+				branchName, shortRefName(remoteRef))
+		}
 	}
 
 	if verbose {
 		fmt.Fprintln(cmd.OutOrStdout(), "HTTP transport fetch completed successfully")
 	}
 
+	return discovery, nil
+}
+
+// fetchPackForHeads negotiates with upload-pack, unpacks the received pack and
+// records any new shallow boundaries
+func fetchPackForHeads(cmd *cobra.Command, repo *vcs.Repository, httpTransport *transport.HTTPTransport, discovery *transport.RefDiscovery, heads map[string]objects.ObjectID, shallow shallowOptions, verbose bool) error {
+	shallowCommits, err := repo.ShallowCommits()
+	if err != nil {
+		return err
+	}
+
+	req := &transport.FetchRequest{
+		Depth:       shallow.depth,
+		DeepenSince: shallow.since,
+		DeepenNot:   shallow.exclude,
+	}
+	switch {
+	case shallow.unshallow:
+		req.Depth = transport.InfiniteDepth
+	case shallow.deepen > 0:
+		req.Depth = shallow.deepen
+		req.DeepenRelative = true
+	}
+	for _, id := range shallowCommits {
+		req.Shallows = append(req.Shallows, id.String())
+	}
+
+	// Without history options, only ask for tips we do not already have
+	seen := make(map[objects.ObjectID]bool)
+	for _, id := range heads {
+		if seen[id] || (!shallow.isSet() && repo.HasObject(id)) {
+			continue
+		}
+		seen[id] = true
+		req.Wants = append(req.Wants, id.String())
+	}
+
+	if len(req.Wants) == 0 {
+		if verbose {
+			fmt.Fprintln(cmd.OutOrStdout(), "Already up to date.")
+		}
+		return nil
+	}
+
+	caps, err := negotiateCapabilities(discovery, req, len(shallowCommits) > 0)
+	if err != nil {
+		return err
+	}
+	req.Capabilities = caps
+
+	haves, err := localRefTips(repo)
+	if err != nil {
+		return err
+	}
+	req.Haves = haves
+	req.Progress = remoteProgress(cmd)
+
+	start := time.Now()
+	resp, err := httpTransport.Fetch(context.Background(), req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch pack: %w", err)
+	}
+	defer resp.Close()
+	trace.Performance.Since(start, "fetch: negotiation of %d wants and %d haves", len(req.Wants), len(req.Haves))
+
+	start = time.Now()
+	result, err := packfile.UnpackProgress(resp.Pack, repo, transferProgress(cmd))
+	if err != nil {
+		return fmt.Errorf("failed to unpack objects: %w", err)
+	}
+	trace.Performance.Since(start, "fetch: receiving and unpacking %d objects", len(result.Objects))
+
+	if verbose {
+		fmt.Fprintf(cmd.OutOrStdout(), "Received %d objects (%d deltas)\n", len(result.Objects), result.Deltas)
+	}
+
+	add, err := parseObjectIDs(resp.Shallows)
+	if err != nil {
+		return fmt.Errorf("invalid shallow line from remote: %w", err)
+	}
+	remove, err := parseObjectIDs(resp.Unshallows)
+	if err != nil {
+		return fmt.Errorf("invalid unshallow line from remote: %w", err)
+	}
+	if err := repo.UpdateShallow(add, remove); err != nil {
+		return fmt.Errorf("failed to update shallow file: %w", err)
+	}
+
 	return nil
 }
 
+// openHTTPTransport returns the transport to fetch from remoteURL with,
+// configured for remoteName, which may be "" for a URL given directly
+func openHTTPTransport(cmd *cobra.Command, repo *vcs.Repository, remoteName, remoteURL string) (*transport.HTTPTransport, error) {
+	// Create appropriate transport
+	var httpTransport *transport.HTTPTransport
+	if isBundleURL(remoteURL) {
+		t, err := newBundleTransport(repo, remoteURL)
+		if err != nil {
+			return nil, err
+		}
+		httpTransport = t
+	} else if isLocalURL(remoteURL) {
+		t, err := newLocalTransport(remoteURL)
+		if err != nil {
+			return nil, err
+		}
+		httpTransport = t
+	} else if name, ok := serve.ParseURL(remoteURL); ok {
+		// Repositories shared with vcs share are found by name
+		resolveCtx, cancel := context.WithTimeout(context.Background(), localShareTimeout)
+		share, err := resolveLocalShare(resolveCtx, name)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		httpTransport = transport.NewHTTPTransport(share.URL())
+	} else if provider := remoteProvider(repo, remoteName, remoteURL); provider != "" {
+		// Hosting providers take their API token as the password
+		client, err := openProvider(repo, provider, remoteName, remoteURL, providerToken(provider))
+		if err != nil {
+			return nil, fmt.Errorf("failed to create %s transport: %w", provider, err)
+		}
+		httpTransport = client.Transport()
+	} else {
+		// Parse URL to get HTTP equivalent
+		httpURL, err := transport.ParseGitURL(remoteURL)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse remote URL: %w", err)
+		}
+		httpTransport = transport.NewHTTPTransport(httpURL)
+	}
+
+	if err := configureHTTP(httpTransport, repo.GitDir(), remoteName); err != nil {
+		return nil, err
+	}
+	rateLimit, err := transferRateLimit(cmd, repo.GitDir())
+	if err != nil {
+		return nil, err
+	}
+	httpTransport.SetRateLimit(rateLimit)
+	httpTransport.SetCredentialManager(newCredentialManager(repo.GitDir()))
+	httpTransport.SetRefCache(transport.NewRefCache(filepath.Join(repo.CommonDir(), "cache", "info-refs")))
+	return httpTransport, nil
+}
+
+// fetchCommitsByID fetches the commits ids, with the objects they need that
+// repo lacks, from remote, the name of a remote or a URL. No refs are
+// updated. The commits are asked for over protocol v2, whose wants may be
+// any commit the server has. Servers only speaking v0 must allow reachable
+// commits to be asked for by ID, or the commits must be tips of their refs.
+func fetchCommitsByID(cmd *cobra.Command, repo *vcs.Repository, remote string, ids []objects.ObjectID) error {
+	var wants []string
+	seen := make(map[objects.ObjectID]bool)
+	for _, id := range ids {
+		if !seen[id] && !repo.HasObject(id) {
+			seen[id] = true
+			wants = append(wants, id.String())
+		}
+	}
+	if len(wants) == 0 {
+		return nil
+	}
+
+	remotes, err := getRemotes(repo)
+	if err != nil {
+		return fmt.Errorf("failed to get remotes: %w", err)
+	}
+	remoteName, remoteURL := remote, remotes[remote]
+	if remoteURL == "" {
+		remoteName, remoteURL = "", remote
+	}
+	if !isLocalRepository(remoteURL) && !isHTTPURL(remoteURL) {
+		return fmt.Errorf("cannot fetch commits by ID from '%s'", remoteURL)
+	}
+
+	httpTransport, err := openHTTPTransport(cmd, repo, remoteName, remoteURL)
+	if err != nil {
+		return err
+	}
+	httpTransport.SetProtocolVersion(2)
+	ctx := context.Background()
+	discovery, err := httpTransport.DiscoverRefs(ctx, "git-upload-pack")
+	if err != nil {
+		return fmt.Errorf("failed to read refs of %s: %w", remoteURL, err)
+	}
+
+	req := &transport.FetchRequest{Wants: wants}
+	if req.Haves, err = localRefTips(repo); err != nil {
+		return err
+	}
+	req.Progress = remoteProgress(cmd)
+
+	var resp *transport.FetchResponse
+	if discovery.Version == 2 {
+		if !discovery.HasCapability("fetch") {
+			return fmt.Errorf("%s does not support fetch over protocol v2", remoteURL)
+		}
+		req.Capabilities = []string{"ofs-delta"}
+		resp, err = httpTransport.FetchV2(ctx, req)
+	} else {
+		if !discovery.HasCapability("allow-reachable-sha1-in-want") && !discovery.HasCapability("allow-any-sha1-in-want") {
+			tips := make(map[string]bool)
+			for _, id := range discovery.Refs {
+				tips[id] = true
+			}
+			for _, want := range wants {
+				if !tips[want] {
+					return fmt.Errorf("%s does not allow fetching commit %s by ID", remoteURL, want)
+				}
+			}
+		}
+		if req.Capabilities, err = negotiateCapabilities(discovery, req, false); err != nil {
+			return err
+		}
+		resp, err = httpTransport.Fetch(ctx, req)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to fetch pack: %w", err)
+	}
+	defer resp.Close()
+	if resp.Pack == nil {
+		return fmt.Errorf("%s sent no pack", remoteURL)
+	}
+	if _, err := packfile.UnpackProgress(resp.Pack, repo, transferProgress(cmd)); err != nil {
+		return fmt.Errorf("failed to unpack objects: %w", err)
+	}
+	return nil
+}
+
+// negotiateCapabilities picks the capabilities to request, failing when the
+// request needs one the server did not advertise
+func negotiateCapabilities(discovery *transport.RefDiscovery, req *transport.FetchRequest, isShallowRepo bool) ([]string, error) {
+	var caps []string
+	if discovery.HasCapability("multi_ack_detailed") {
+		caps = append(caps, "multi_ack_detailed")
+		if discovery.HasCapability("no-done") {
+			caps = append(caps, "no-done")
+		}
+	}
+	if discovery.HasCapability("side-band-64k") {
+		caps = append(caps, "side-band-64k")
+	} else if discovery.HasCapability("side-band") {
+		caps = append(caps, "side-band")
+	}
+	if discovery.HasCapability("ofs-delta") {
+		caps = append(caps, "ofs-delta")
+	}
+
+	required := []struct {
+		name   string
+		needed bool
+	}{
+		{"shallow", req.Depth > 0 || isShallowRepo},
+		{"deepen-since", !req.DeepenSince.IsZero()},
+		{"deepen-not", len(req.DeepenNot) > 0},
+		{"deepen-relative", req.DeepenRelative},
+	}
+	for _, c := range required {
+		if !c.needed {
+			continue
+		}
+		if !discovery.HasCapability(c.name) {
+			return nil, fmt.Errorf("server does not support %s", c.name)
+		}
+		if c.name != "deepen-relative" {
+			caps = append(caps, c.name)
+		}
+	}
+
+	return append(caps, "agent=vcs/1.0"), nil
+}
+
+// localRefTips returns the object IDs of local branches, remote-tracking
+// refs and the refs of maintenance's prefetch task, which are sent as haves
+// during negotiation
+func localRefTips(repo *vcs.Repository) ([]string, error) {
+	seen := make(map[string]bool)
+	var tips []string
+
+	for _, dir := range []string{"refs/heads", "refs/remotes", "refs/prefetch"} {
+		root := filepath.Join(repo.GitDir(), dir)
+		err := filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				if os.IsNotExist(err) {
+					return nil
+				}
+				return err
+			}
+			if info.IsDir() {
+				return nil
+			}
+			data, err := readFile(path)
+			if err != nil {
+				return err
+			}
+			id, err := objects.NewObjectID(strings.TrimSpace(string(data)))
+			if err != nil || seen[id.String()] || !repo.HasObject(id) {
+				return nil
+			}
+			seen[id.String()] = true
+			tips = append(tips, id.String())
+			return nil
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to read local refs: %w", err)
+		}
+	}
+
+	return tips, nil
+}
+
+// parseObjectIDs converts hex object IDs sent by the remote
+func parseObjectIDs(hexIDs []string) ([]objects.ObjectID, error) {
+	ids := make([]objects.ObjectID, 0, len(hexIDs))
+	for _, hexID := range hexIDs {
+		id, err := objects.NewObjectID(hexID)
+		if err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+// parseShallowSince parses the --shallow-since argument. It accepts a Unix
+// timestamp, an RFC 3339 time or a YYYY-MM-DD date.
+func parseShallowSince(value string) (time.Time, error) {
+	if secs, err := strconv.ParseInt(strings.TrimPrefix(value, "@"), 10, 64); err == nil {
+		return time.Unix(secs, 0), nil
+	}
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid --shallow-since date: %s", value)
+}
+
 func fetchBasicImplementation(cmd *cobra.Command, repo *vcs.Repository, remoteName, remoteURL string, verbose bool) error {
 	// Original basic implementation
 	if verbose {
@@ -211,4 +777,58 @@ func fetchBasicImplementation(cmd *cobra.Command, repo *vcs.Repository, remoteNa
 	fmt.Fprintln(cmd.OutOrStdout(), "  - Object deduplication and delta compression")
 
 	return nil
-}
\ No newline at end of file
+}
+// transferRateLimit returns the bandwidth cap for pack transfers in bytes
+// per second, from --limit-rate or else transfer.rateLimit, or 0 for none
+func transferRateLimit(cmd *cobra.Command, gitDir string) (int64, error) {
+	if flag := cmd.Flags().Lookup("limit-rate"); flag != nil && flag.Changed {
+		limit, err := parseConfigSize(flag.Value.String())
+		if err != nil {
+			return 0, fmt.Errorf("invalid --limit-rate: %w", err)
+		}
+		return limit, nil
+	}
+
+	value, ok := loadConfig(gitDir).Get("transfer.rateLimit")
+	if !ok {
+		return 0, nil
+	}
+	limit, err := parseConfigSize(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid transfer.rateLimit: %w", err)
+	}
+	return limit, nil
+}
+
+// configureHTTP applies to t the timeout of http.timeout, in seconds, the
+// number of retries of http.retries, and the proxy of remote.<name>.proxy
+// or else http.proxy, an empty remote.<name>.proxy meaning none. Without
+// them requests time out after 30 seconds, are retried three times and go
+// through the proxy HTTP_PROXY, HTTPS_PROXY and NO_PROXY choose.
+func configureHTTP(t *transport.HTTPTransport, gitDir, remoteName string) error {
+	cfg := loadConfig(gitDir)
+	if value, ok := cfg.Get("http.timeout"); ok {
+		seconds, err := strconv.Atoi(value)
+		if err != nil || seconds < 0 {
+			return fmt.Errorf("invalid http.timeout: %q", value)
+		}
+		t.SetTimeout(time.Duration(seconds) * time.Second)
+	}
+	if value, ok := cfg.Get("http.retries"); ok {
+		retries, err := strconv.Atoi(value)
+		if err != nil || retries < 0 {
+			return fmt.Errorf("invalid http.retries: %q", value)
+		}
+		t.SetRetries(retries, transport.DefaultRetryDelay)
+	}
+
+	proxy, ok := cfg.Get("remote." + remoteName + ".proxy")
+	if !ok {
+		proxy, ok = cfg.Get("http.proxy")
+		ok = ok && proxy != ""
+	}
+	if ok {
+		return t.SetProxy(proxy)
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/transport"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// buildTestPack encodes objects from src into an undeltified packfile
+func buildTestPack(t *testing.T, src *vcs.Repository, ids ...objects.ObjectID) []byte {
+	var buf bytes.Buffer
+	buf.WriteString("PACK")
+	binary.Write(&buf, binary.BigEndian, uint32(2))
+	binary.Write(&buf, binary.BigEndian, uint32(len(ids)))
+
+	packTypes := map[objects.ObjectType]byte{
+		objects.TypeCommit: 1, objects.TypeTree: 2, objects.TypeBlob: 3, objects.TypeTag: 4,
+	}
+
+	for _, id := range ids {
+		objType, data, err := src.ReadRawObject(id)
+		require.NoError(t, err)
+
+		size := len(data)
+		b := packTypes[objType]<<4 | byte(size&0x0f)
+		for size >>= 4; size > 0; size >>= 7 {
+			buf.WriteByte(b | 0x80)
+			b = byte(size & 0x7f)
+		}
+		buf.WriteByte(b)
+
+		zw := zlib.NewWriter(&buf)
+		zw.Write(data)
+		zw.Close()
+	}
+
+	sum := sha1.Sum(buf.Bytes())
+	buf.Write(sum[:])
+	return buf.Bytes()
+}
+
+// shallowTestServer serves a two-commit history over smart HTTP and answers
+// depth 1 and deepen requests the way git-upload-pack does
+type shallowTestServer struct {
+	t        *testing.T
+	src      *vcs.Repository
+	root     *objects.Commit
+	tip      *objects.Commit
+	requests []string
+}
+
+func newShallowTestServer(t *testing.T) (*shallowTestServer, *httptest.Server) {
+	src, err := vcs.Init(filepath.Join(t.TempDir(), "src"))
+	require.NoError(t, err)
+
+	sig := objects.Signature{Name: "Test", Email: "test@example.com", When: time.Unix(1700000000, 0)}
+
+	blobA, err := src.CreateBlob([]byte("a\n"))
+	require.NoError(t, err)
+	treeA, err := src.CreateTree([]objects.TreeEntry{{Mode: objects.ModeBlob, Name: "a.txt", ID: blobA.ID()}})
+	require.NoError(t, err)
+	root, err := src.CreateCommit(treeA.ID(), nil, sig, sig, "root\n")
+	require.NoError(t, err)
+
+	blobB, err := src.CreateBlob([]byte("b\n"))
+	require.NoError(t, err)
+	treeB, err := src.CreateTree([]objects.TreeEntry{
+		{Mode: objects.ModeBlob, Name: "a.txt", ID: blobA.ID()},
+		{Mode: objects.ModeBlob, Name: "b.txt", ID: blobB.ID()},
+	})
+	require.NoError(t, err)
+	tip, err := src.CreateCommit(treeB.ID(), []objects.ObjectID{root.ID()}, sig, sig, "tip\n")
+	require.NoError(t, err)
+
+	s := &shallowTestServer{t: t, src: src, root: root, tip: tip}
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/info/refs":
+			w.Header().Set("Content-Type", "application/x-git-upload-pack-advertisement")
+			pw := transport.NewPktLineWriter(w)
+			pw.WriteString("# service=git-upload-pack\n")
+			pw.Flush()
+			pw.Writef("%s HEAD\x00shallow deepen-since deepen-not deepen-relative ofs-delta\n", tip.ID())
+			pw.Writef("%s refs/heads/main\n", tip.ID())
+			pw.Flush()
+		case r.URL.Path == "/git-upload-pack":
+			body, _ := io.ReadAll(r.Body)
+			s.requests = append(s.requests, string(body))
+
+			w.Header().Set("Content-Type", "application/x-git-upload-pack-result")
+			pw := transport.NewPktLineWriter(w)
+			if strings.Contains(string(body), "deepen-relative") {
+				// Deepening past the tip reaches the root commit
+				pw.Writef("unshallow %s\n", tip.ID())
+				pw.Flush()
+				pw.WriteString("NAK\n")
+				w.Write(buildTestPack(t, src, root.ID(), treeA.ID()))
+			} else {
+				pw.Writef("shallow %s\n", tip.ID())
+				pw.Flush()
+				pw.WriteString("NAK\n")
+				w.Write(buildTestPack(t, src, tip.ID(), treeB.ID(), blobA.ID(), blobB.ID()))
+			}
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+
+	return s, server
+}
+
+func setupShallowFetchRepo(t *testing.T, url string) *vcs.Repository {
+	repoPath := filepath.Join(t.TempDir(), "repo")
+	repo, err := vcs.Init(repoPath)
+	require.NoError(t, err)
+
+	configContent := `[remote "origin"]
+	url = ` + url + `
+	fetch = +refs/heads/*:refs/remotes/origin/*
+`
+	require.NoError(t, os.WriteFile(filepath.Join(repo.GitDir(), "config"), []byte(configContent), 0644))
+
+	oldWd, _ := os.Getwd()
+	t.Cleanup(func() { os.Chdir(oldWd) })
+	require.NoError(t, os.Chdir(repoPath))
+
+	return repo
+}
+
+func runFetchArgs(args ...string) (string, error) {
+	cmd := newFetchCommand()
+	var buf bytes.Buffer
+	cmd.SetOut(&buf)
+	cmd.SetErr(&buf)
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+	return buf.String(), err
+}
+
+func TestFetchShallowAndDeepen(t *testing.T) {
+	s, server := newShallowTestServer(t)
+	defer server.Close()
+
+	repo := setupShallowFetchRepo(t, server.URL)
+
+	_, err := runFetchArgs("--depth", "1", "origin")
+	require.NoError(t, err)
+
+	require.Len(t, s.requests, 1)
+	assert.Contains(t, s.requests[0], "want "+s.tip.ID().String()+" ofs-delta shallow")
+	assert.Contains(t, s.requests[0], "deepen 1\n")
+
+	shallow, err := repo.ShallowCommits()
+	require.NoError(t, err)
+	assert.Equal(t, []objects.ObjectID{s.tip.ID()}, shallow)
+	assert.True(t, repo.HasObject(s.tip.ID()))
+	assert.False(t, repo.HasObject(s.root.ID()))
+
+	ref, err := os.ReadFile(filepath.Join(repo.GitDir(), "refs", "remotes", "origin", "main"))
+	require.NoError(t, err)
+	assert.Equal(t, s.tip.ID().String(), strings.TrimSpace(string(ref)))
+
+	_, err = runFetchArgs("--deepen", "1", "origin")
+	require.NoError(t, err)
+
+	require.Len(t, s.requests, 2)
+	assert.Contains(t, s.requests[1], "shallow "+s.tip.ID().String()+"\n")
+	assert.Contains(t, s.requests[1], "deepen 1\n")
+	assert.True(t, repo.HasObject(s.root.ID()))
+	assert.False(t, repo.IsShallow())
+	assert.NoFileExists(t, filepath.Join(repo.GitDir(), "shallow"))
+
+	_, err = runFetchArgs("--unshallow", "origin")
+	assert.ErrorContains(t, err, "complete repository")
+}
+
+func TestFetchShallowOptionValidation(t *testing.T) {
+	setupShallowFetchRepo(t, "https://example.com/repo.git")
+
+	_, err := runFetchArgs("--depth", "1", "--deepen", "1", "origin")
+	assert.ErrorContains(t, err, "cannot be used together")
+
+	_, err = runFetchArgs("--depth", "-1", "origin")
+	assert.ErrorContains(t, err, "not a positive number")
+
+	_, err = runFetchArgs("--shallow-since", "yesterday-ish", "origin")
+	assert.ErrorContains(t, err, "invalid --shallow-since")
+}
+
+func TestParseShallowSince(t *testing.T) {
+	got, err := parseShallowSince("1700000000")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1700000000), got.Unix())
+
+	got, err = parseShallowSince("2024-01-02T03:04:05Z")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1704164645), got.Unix())
+}
@@ -8,6 +8,8 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/refs"
 	"github.com/fenilsonani/vcs/pkg/vcs"
 )
 
@@ -250,7 +252,7 @@ func TestFetchFromRemote(t *testing.T) {
 	cmd.SetOut(&buf)
 	
 	// Test fetch
-	err = fetchFromRemote(cmd, repo, "origin", "https://github.com/example/repo.git", false, false, false, 0, true)
+	err = fetchFromRemote(cmd, repo, "origin", "https://github.com/example/repo.git", nil, false, false, false, shallowOptions{}, true)
 	assert.NoError(t, err)
 	
 	// Check output
@@ -375,4 +377,112 @@ func TestParseConfig(t *testing.T) {
 			assert.Equal(t, tc.expectedRemotes, remotes)
 		})
 	}
-}
\ No newline at end of file
+}
+func TestTransferRateLimit(t *testing.T) {
+	setupConfigRepo(t)
+	gitDir := currentGitDir()
+
+	cmd := newFetchCommand()
+	limit, err := transferRateLimit(cmd, gitDir)
+	require.NoError(t, err)
+	assert.Zero(t, limit, "transfers are unlimited by default")
+
+	_, err = runConfigArgs("transfer.rateLimit", "512k")
+	require.NoError(t, err)
+	limit, err = transferRateLimit(cmd, gitDir)
+	require.NoError(t, err)
+	assert.Equal(t, int64(512<<10), limit)
+
+	require.NoError(t, cmd.Flags().Set("limit-rate", "2m"))
+	limit, err = transferRateLimit(cmd, gitDir)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2<<20), limit, "--limit-rate overrides the config")
+
+	require.NoError(t, cmd.Flags().Set("limit-rate", "fast"))
+	_, err = transferRateLimit(cmd, gitDir)
+	assert.ErrorContains(t, err, "invalid --limit-rate")
+
+	_, err = runConfigArgs("transfer.rateLimit", "fast")
+	require.NoError(t, err)
+	_, err = transferRateLimit(newCloneCommand(), gitDir)
+	assert.ErrorContains(t, err, "invalid transfer.rateLimit")
+}
+
+func TestFetchPrune(t *testing.T) {
+	repo, bare := setupPushRepo(t)
+	_, err := runCommandArgs(newPushCommand(), "origin", "main", "main:topic", "main:other")
+	require.NoError(t, err)
+	localRefs := refs.NewRefManager(repo.GitDir())
+	id, err := localRefs.ResolveRef("refs/heads/main")
+	require.NoError(t, err)
+	require.NoError(t, localRefs.CreateTag("v1", id))
+	require.NoError(t, localRefs.CreateTag("v2", id))
+	_, err = runCommandArgs(newPushCommand(), "origin", "refs/tags/v2:refs/tags/v2")
+	require.NoError(t, err)
+	remoteRefs := refs.NewRefManager(bare.GitDir())
+	require.NoError(t, remoteRefs.DeleteBranch("topic"))
+
+	// Tags are only pruned along with branches
+	out, err := runFetchArgs("--prune-tags", "origin")
+	require.NoError(t, err)
+	assert.NotContains(t, out, "[deleted]")
+	assert.True(t, localRefs.RefExists("refs/remotes/origin/topic"))
+
+	out, err = runFetchArgs("-p", "origin")
+	require.NoError(t, err)
+	assert.Contains(t, out, " - [deleted]          (none)     -> origin/topic\n")
+	assert.False(t, localRefs.RefExists("refs/remotes/origin/topic"))
+	assert.True(t, localRefs.RefExists("refs/remotes/origin/main"))
+	assert.True(t, localRefs.RefExists("refs/tags/v1"))
+
+	out, err = runFetchArgs("-p", "-P", "origin")
+	require.NoError(t, err)
+	assert.Contains(t, out, " - [deleted]          (none)     -> v1\n")
+	assert.False(t, localRefs.RefExists("refs/tags/v1"))
+	assert.True(t, localRefs.RefExists("refs/tags/v2"))
+
+	// remote.<name>.prune prunes without the flag
+	require.NoError(t, remoteRefs.DeleteBranch("other"))
+	_, err = runConfigArgs("remote.origin.prune", "true")
+	require.NoError(t, err)
+	_, err = runFetchArgs("origin")
+	require.NoError(t, err)
+	assert.False(t, localRefs.RefExists("refs/remotes/origin/other"))
+}
+
+func TestFetchHead(t *testing.T) {
+	repo, bare := setupPushRepo(t)
+	_, err := runCommandArgs(newPushCommand(), "origin", "main", "main:topic")
+	require.NoError(t, err)
+	localRefs := refs.NewRefManager(repo.GitDir())
+	main, err := localRefs.ResolveRef("refs/heads/main")
+	require.NoError(t, err)
+	topic := commitFiles(t, bare, map[string]string{"a.txt": "a.txt\n", "t.txt": "t\n"}, []objects.ObjectID{main}, "topic\n")
+	require.NoError(t, refs.NewRefManager(bare.GitDir()).UpdateRef("refs/heads/topic", topic))
+	url := filepath.Join(filepath.Dir(bare.GitDir()), "origin")
+
+	// Without names the branch pull would merge is marked for merging
+	_, err = runFetchArgs("origin")
+	require.NoError(t, err)
+	data, err := os.ReadFile(filepath.Join(repo.GitDir(), "FETCH_HEAD"))
+	require.NoError(t, err)
+	assert.Equal(t, main.String()+"\t\tbranch 'main' of "+url+"\n"+
+		topic.String()+"\tnot-for-merge\tbranch 'topic' of "+url+"\n", string(data))
+
+	_, err = runFetchArgs("origin", "topic")
+	require.NoError(t, err)
+	data, err = os.ReadFile(filepath.Join(repo.GitDir(), "FETCH_HEAD"))
+	require.NoError(t, err)
+	assert.Equal(t, topic.String()+"\t\tbranch 'topic' of "+url+"\n", string(data))
+	_, err = runFetchArgs("origin", "missing")
+	assert.ErrorContains(t, err, "couldn't find remote ref missing")
+
+	_, _, err = runMergeArgs("--ff=no", "FETCH_HEAD")
+	require.NoError(t, err)
+	headID, _, err := localRefs.HEAD()
+	require.NoError(t, err)
+	head, err := repo.GetCommit(headID)
+	require.NoError(t, err)
+	assert.Equal(t, "Merge branch 'topic' of "+url+"\n", head.Message())
+	assert.Equal(t, []objects.ObjectID{main, topic}, head.Parents())
+}
@@ -10,6 +10,8 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/internal/serve"
 	"github.com/fenilsonani/vcs/pkg/vcs"
 )
 
@@ -239,7 +241,7 @@ func TestFetchWithHTTPTransport_ParseError(t *testing.T) {
 	cmd.SetErr(&buf)
 
 	// Call fetchWithHTTPTransport directly with invalid URL
-	err = fetchWithHTTPTransport(cmd, repo, "origin", "ht!tp://invalid-url", true)
+	err = fetchWithHTTPTransport(cmd, repo, "origin", "ht!tp://invalid-url", shallowOptions{}, true)
 	require.Error(t, err)
 	assert.Contains(t, err.Error(), "failed to parse remote URL")
 }
@@ -478,4 +480,39 @@ func TestFetchCommand_CustomRemote(t *testing.T) {
 	output := buf.String()
 	assert.Contains(t, output, "Fetching from upstream")
 	assert.Contains(t, output, "From /path/to/upstream/repo")
-}
\ No newline at end of file
+}
+func TestFetchThroughConfiguredProxy(t *testing.T) {
+	lib, head, _ := serveLibrary(t)
+	server := serve.NewServer(lib.GitDir(), lib.Storage())
+	var hosts []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hosts = append(hosts, r.Host)
+		server.ServeHTTP(w, r)
+	}))
+	defer proxy.Close()
+
+	// Nothing listens on the remote's port, so the fetch only works
+	// through the proxy
+	repo, _ := setupConfigRepo(t)
+	for _, kv := range [][2]string{
+		{"remote.origin.url", "http://localhost:1/lib.git"},
+		{"http.proxy", proxy.URL},
+		{"http.timeout", "10"},
+		{"http.retries", "1"},
+	} {
+		_, err := runConfigArgs(kv[0], kv[1])
+		require.NoError(t, err)
+	}
+
+	_, err := runCommandArgs(newFetchCommand(), "origin")
+	require.NoError(t, err)
+	assert.Contains(t, hosts, "localhost:1")
+	id, err := refs.NewRefManager(repo.GitDir()).ResolveRef("refs/remotes/origin/main")
+	require.NoError(t, err)
+	assert.Equal(t, head, id)
+
+	_, err = runConfigArgs("http.timeout", "soon")
+	require.NoError(t, err)
+	_, err = runCommandArgs(newFetchCommand(), "origin")
+	assert.ErrorContains(t, err, "invalid http.timeout")
+}
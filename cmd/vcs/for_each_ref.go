@@ -0,0 +1,154 @@
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+)
+
+// defaultRefFormat is how for-each-ref lists a ref without --format
+const defaultRefFormat = "%(objectname) %(objecttype)\t%(refname)"
+
+// forEachRefOptions holds the flags of for-each-ref
+type forEachRefOptions struct {
+	format   string
+	sort     []string
+	count    int
+	contains *revisionsFlag
+	pointsAt *revisionsFlag
+}
+
+func newForEachRefCommand() *cobra.Command {
+	var opts forEachRefOptions
+
+	cmd := &cobra.Command{
+		Use:   "for-each-ref [flags] [<pattern>...]",
+		Short: "Output information on each ref",
+		Long: `Lists the refs matching the patterns, or all of them, each as --format
+says. A pattern matches a ref as a shell pattern or as a prefix ending
+at a slash, so refs/heads lists the branches.
+
+The format is text with fields written %(field) or %(field:modifier):
+refname, with :short, :lstrip=<n> or :rstrip=<n>; objectname, with
+:short or :short=<n>; objecttype; objectsize; HEAD, "*" for the branch
+checked out; symref; upstream, with :short, :track or :trackshort;
+subject, body and contents; author, committer and tagger name, email and
+date; and creatordate, the date of the tagger or committer. Dates take
+:short, :iso, :iso-strict, :rfc, :unix or :raw. %(*field) reads the
+object a tag points to, %% is a percent sign and %xx the byte of hex xx.
+
+--sort orders the refs by a field, in reverse with a leading "-" and as
+versions with a version: prefix; given more than once the last key
+decides first. --contains lists only refs whose commit contains the
+commit, and --points-at only those pointing at the object; both default
+to HEAD, and take the argument after them when it names a commit or
+object.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runForEachRef(cmd.OutOrStdout(), args, opts)
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.format, "format", defaultRefFormat, "Format of each ref")
+	cmd.Flags().StringArrayVar(&opts.sort, "sort", nil, "Sort by the field, in reverse with a leading -")
+	cmd.Flags().IntVar(&opts.count, "count", 0, "Stop after that many refs")
+	opts.contains = newRevisionsFlag(cmd, "contains", "List only the refs containing the commit")
+	opts.pointsAt = newRevisionsFlag(cmd, "points-at", "List only the refs pointing at the object")
+
+	return cmd
+}
+
+func runForEachRef(out io.Writer, patterns []string, opts forEachRefOptions) error {
+	format, err := parseRefFormat(opts.format)
+	if err != nil {
+		return err
+	}
+	keys, err := parseRefSortKeys(opts.sort)
+	if err != nil {
+		return err
+	}
+
+	repoPath, err := findRepository()
+	if err != nil {
+		return fmt.Errorf("not a git repository: %w", err)
+	}
+	repo, err := openRepository(repoPath)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+	refManager := refs.NewRefManager(repo.GitDir())
+	formatter := newRefFormatter(repo, refManager)
+
+	// --contains and --points-at may take the next argument as their value
+	taken := make(map[int]bool)
+	containsRevs := opts.contains.claimArgs(patterns, taken, func(arg string) bool {
+		_, err := formatter.resolver.ResolveCommit(arg)
+		return err == nil
+	})
+	pointsAtRevs := opts.pointsAt.claimArgs(patterns, taken, func(arg string) bool {
+		_, err := formatter.resolver.Resolve(arg)
+		return err == nil
+	})
+	patterns = unclaimedArgs(patterns, taken)
+
+	var contains, pointsAt []objects.ObjectID
+	for _, rev := range containsRevs {
+		id, err := formatter.resolver.ResolveCommit(rev)
+		if err != nil {
+			return fmt.Errorf("malformed object name %s: %w", rev, err)
+		}
+		contains = append(contains, id)
+	}
+	for _, rev := range pointsAtRevs {
+		id, err := formatter.resolver.Resolve(rev)
+		if err != nil {
+			return fmt.Errorf("malformed object name %s: %w", rev, err)
+		}
+		pointsAt = append(pointsAt, id)
+	}
+
+	all, err := newRefItems(refManager, "refs/")
+	if err != nil {
+		return err
+	}
+	var items []*refItem
+	for _, item := range all {
+		if len(patterns) > 0 && !matchesAnyRefPattern(patterns, item.name) {
+			continue
+		}
+		if len(pointsAt) > 0 && !formatter.pointsAtAny(item, pointsAt) {
+			continue
+		}
+		if len(contains) > 0 {
+			ok, err := formatter.containsAny(item, contains)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				continue
+			}
+		}
+		items = append(items, item)
+	}
+
+	if err := formatter.sort(items, keys); err != nil {
+		return err
+	}
+	if opts.count > 0 && len(items) > opts.count {
+		items = items[:opts.count]
+	}
+	return formatter.write(out, format, items)
+}
+
+// matchesAnyRefPattern reports whether ref name matches any of patterns
+func matchesAnyRefPattern(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matchRefPattern(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForEachRef(t *testing.T) {
+	repo, refManager := setupSeriesRepo(t, map[string]string{"a.txt": "a\n"})
+	base, err := refManager.ResolveRef("refs/heads/main")
+	require.NoError(t, err)
+	_, err = runCommandArgs(newTagCommand(), "v1.9")
+	require.NoError(t, err)
+	head := moveMain(t, repo, refManager, map[string]string{"a.txt": "a\n", "b.txt": "b\n"})
+	_, err = runCommandArgs(newTagCommand(), "-m", "Release 1.10\n\nWith notes", "v1.10")
+	require.NoError(t, err)
+
+	// topic tracks origin/main, which is one commit ahead of it
+	require.NoError(t, refManager.UpdateRef("refs/heads/topic", base))
+	require.NoError(t, refManager.UpdateRef("refs/remotes/origin/main", head))
+	_, err = runConfigArgs("branch.topic.remote", "origin")
+	require.NoError(t, err)
+	_, err = runConfigArgs("branch.topic.merge", "refs/heads/main")
+	require.NoError(t, err)
+
+	forEachRef := func(args ...string) string {
+		out, err := runCommandArgs(newForEachRefCommand(), args...)
+		require.NoError(t, err)
+		return out
+	}
+
+	assert.Equal(t, head.String()+" commit\trefs/heads/main\n"+base.String()+" commit\trefs/heads/topic\n", forEachRef("refs/heads"))
+	assert.Equal(t, "main\ntopic\n", forEachRef("--format=%(refname:short)", "refs/heads/"))
+	assert.Equal(t, "*main \n topic [behind 1]\n", forEachRef("--format=%(HEAD)%(refname:lstrip=2) %(upstream:track)", "refs/heads"))
+	assert.Equal(t, "\n<\n", forEachRef("--format=%(upstream:trackshort)", "refs/heads"))
+	assert.Equal(t, "origin/main\n", forEachRef("--format=%(upstream:short)", "refs/heads/topic"))
+	assert.Equal(t, "refs/remotes/origin\n", forEachRef("--format=%(refname:rstrip=1)", "refs/remotes/*/*"))
+
+	// %(*field) reads the commit an annotated tag points to
+	assert.Equal(t, "tag Release 1.10 With notes\n\ncommit "+head.Short()+"\n",
+		forEachRef("--format=%(objecttype) %(subject) %(body)%0a%(*objecttype) %(*objectname:short)", "refs/tags/v1.10"))
+	assert.Equal(t, "100%\n", forEachRef("--format=100%%", "refs/tags/v1.10"))
+
+	// --sort takes the last key first; ties go by name
+	assert.Equal(t, "v1.9\nv1.10\n", forEachRef("--format=%(refname:short)", "--sort=version:refname", "refs/tags"))
+	assert.Equal(t, "refs/tags/v1.9\nrefs/tags/v1.10\n", forEachRef("--format=%(refname)", "--sort=-refname", "--sort=v:refname", "refs/tags"))
+	assert.Equal(t, "v1.10\n", forEachRef("--format=%(refname:short)", "--sort=-creatordate", "--count=1", "refs/tags"))
+
+	assert.Equal(t, "refs/heads/main\nrefs/remotes/origin/main\nrefs/tags/v1.10\n", forEachRef("--format=%(refname)", "--contains"))
+	assert.Equal(t, "refs/heads/topic\nrefs/tags/v1.9\n", forEachRef("--format=%(refname)", "--points-at="+base.String()))
+	assert.Equal(t, "refs/heads/main\nrefs/remotes/origin/main\nrefs/tags/v1.10\n", forEachRef("--format=%(refname)", "--contains", "HEAD"))
+	assert.Equal(t, "refs/tags/v1.10\n", forEachRef("--format=%(refname)", "--contains", "HEAD", "refs/tags"))
+	assert.Equal(t, "refs/tags/v1.10\n", forEachRef("--format=%(refname)", "--contains", "refs/tags"))
+	assert.Equal(t, "refs/heads/topic\nrefs/tags/v1.9\n", forEachRef("--format=%(refname)", "--points-at", base.String()))
+
+	_, err = runCommandArgs(newForEachRefCommand(), "--format=%(size)")
+	assert.ErrorContains(t, err, "unknown field name: size")
+	_, err = runCommandArgs(newForEachRefCommand(), "--sort=size")
+	assert.ErrorContains(t, err, "unsupported sort specification 'size'")
+
+	// tag and branch take the same formats
+	out, err := runCommandArgs(newTagCommand(), "--format=%(refname:short) %(objecttype)", "--sort=v:refname")
+	require.NoError(t, err)
+	assert.Equal(t, "v1.9 commit\nv1.10 tag\n", out)
+	out, err = runCommandArgs(newBranchCommand(), "--format=%(refname:short) %(upstream:track)")
+	require.NoError(t, err)
+	assert.Equal(t, "main \ntopic [behind 1]\n", out)
+	out, err = runCommandArgs(newBranchCommand(), "-a", "--format=%(refname)")
+	require.NoError(t, err)
+	assert.Equal(t, "refs/heads/main\nrefs/heads/topic\nrefs/remotes/origin/main\n", out)
+}
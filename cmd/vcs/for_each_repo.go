@@ -0,0 +1,93 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/config"
+)
+
+func newForEachRepoCommand() *cobra.Command {
+	var (
+		key       string
+		keepGoing bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "for-each-repo --config=<key> <command> [<args>...]",
+		Short: "Run a vcs command in a list of repositories",
+		Long: `Runs vcs -C <path> <command> <args> for every path that the config
+variable <key> holds, in the order the values are set. Paths that no
+longer exist are skipped. The first command to fail stops the run unless
+--keep-going is given, when the failures are reported at the end.
+
+The jobs vcs maintenance start schedules use it to run maintenance in
+every registered repository:
+
+  vcs for-each-repo --config=maintenance.repo maintenance run --schedule=daily`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if key == "" {
+				return fmt.Errorf("missing --config=<key>")
+			}
+
+			gitDir := ""
+			if _, dir, err := discoverRepository(); err == nil {
+				gitDir = dir
+			}
+			cfg, err := config.Load(gitDir)
+			if err != nil {
+				return fmt.Errorf("failed to read config: %w", err)
+			}
+			cmd.SilenceUsage = true
+
+			exe, err := vcsExecutable()
+			if err != nil {
+				return fmt.Errorf("failed to find the vcs executable: %w", err)
+			}
+			failed := 0
+			for _, path := range cfg.GetAll(key) {
+				path = expandUserPath(path)
+				if _, err := os.Stat(path); os.IsNotExist(err) {
+					continue
+				}
+				run := exec.Command(exe, append([]string{"-C", path}, args...)...)
+				run.Stdin = cmd.InOrStdin()
+				run.Stdout = cmd.OutOrStdout()
+				run.Stderr = cmd.ErrOrStderr()
+				if err := run.Run(); err != nil {
+					if !keepGoing {
+						return fmt.Errorf("failed to run in %s: %w", path, err)
+					}
+					fmt.Fprintf(cmd.ErrOrStderr(), "error: failed to run in %s: %v\n", path, err)
+					failed++
+				}
+			}
+			if failed > 0 {
+				return fmt.Errorf("the command failed in %d repositories", failed)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&key, "config", "", "Config variable holding the repository paths")
+	cmd.Flags().BoolVar(&keepGoing, "keep-going", false, "Run in every repository even when the command fails in one")
+	// The flags after the command are its own
+	cmd.Flags().SetInterspersed(false)
+
+	return cmd
+}
+
+// expandUserPath expands a leading ~/ to the home directory
+func expandUserPath(path string) string {
+	if strings.HasPrefix(path, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return home + path[1:]
+		}
+	}
+	return path
+}
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestForEachRepo(t *testing.T) {
+	isolateConfig(t)
+	oldExe := vcsExecutable
+	t.Cleanup(func() { vcsExecutable = oldExe })
+	// A stand-in for vcs that reports where it ran and fails in b
+	script := filepath.Join(t.TempDir(), "vcs")
+	require.NoError(t, os.WriteFile(script, []byte("#!/bin/sh\necho \"$@\"\ncase \"$2\" in */b) exit 1;; esac\n"), 0755))
+	vcsExecutable = func() (string, error) { return script, nil }
+
+	root := t.TempDir()
+	oldWd, _ := os.Getwd()
+	t.Cleanup(func() { os.Chdir(oldWd) })
+	require.NoError(t, os.Chdir(root))
+	for _, name := range []string{"a", "b", "c"} {
+		require.NoError(t, os.Mkdir(filepath.Join(root, name), 0755))
+		_, err := runConfigArgs("--global", "--add", "maintenance.repo", filepath.Join(root, name))
+		require.NoError(t, err)
+	}
+	_, err := runConfigArgs("--global", "--add", "maintenance.repo", filepath.Join(root, "missing"))
+	require.NoError(t, err)
+
+	run := func(args ...string) (string, error) {
+		cmd := newForEachRepoCommand()
+		var out bytes.Buffer
+		cmd.SetOut(&out)
+		cmd.SetErr(&bytes.Buffer{})
+		cmd.SetArgs(args)
+		err := cmd.Execute()
+		return out.String(), err
+	}
+
+	out, err := run("--config=maintenance.repo", "maintenance", "run", "--schedule=daily")
+	assert.ErrorContains(t, err, "failed to run in "+filepath.Join(root, "b"))
+	assert.Equal(t, "-C "+filepath.Join(root, "a")+" maintenance run --schedule=daily\n-C "+filepath.Join(root, "b")+" maintenance run --schedule=daily\n", out)
+
+	out, err = run("--config=maintenance.repo", "--keep-going", "status")
+	assert.ErrorContains(t, err, "failed in 1 repositories")
+	assert.Contains(t, out, "-C "+filepath.Join(root, "c")+" status\n")
+
+	_, err = run("status")
+	assert.ErrorContains(t, err, "missing --config")
+}
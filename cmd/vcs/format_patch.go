@@ -0,0 +1,900 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"mime"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/convert"
+	"github.com/fenilsonani/vcs/internal/core/history"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/patch"
+	"github.com/fenilsonani/vcs/internal/interactive"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// mboxFromLine ends the "From <commit>" line starting each mail; Git uses
+// this fixed date so that tools can recognise patches
+const mboxFromLine = " Mon Sep 17 00:00:00 2001"
+
+// patchStatWidth is the width of the diffstat of a patch, as Git wraps mails
+const patchStatWidth = 72
+
+// patchNameMax is how long the name of a patch file may be before ".patch"
+const patchNameMax = 64 - len(".patch") - 1
+
+// formatPatchOptions holds the flags of format-patch
+type formatPatchOptions struct {
+	outputDir     string
+	stdout        bool
+	root          bool
+	coverLetter   bool
+	numbered      bool
+	noNumbered    bool
+	subjectPrefix string
+	startNumber   int
+	rerollCount   int
+	thread        string
+	inReplyTo     string
+	signature     string
+	noSignature   bool
+	noBinary      bool
+}
+
+func newFormatPatchCommand() *cobra.Command {
+	var opts formatPatchOptions
+
+	cmd := &cobra.Command{
+		Use:   "format-patch [flags] (<since> | <revision-range>)",
+		Short: "Prepare patches for e-mail submission",
+		Long: `Writes each commit of a range as a mail in mbox format, ready to be sent
+or applied with "vcs am". Given a single commit, the range is the commits
+on HEAD since it; otherwise it is a range like A..B. Merge commits are
+left out. With --root, a single commit names the tip of a range starting
+at the root commit.
+
+Each patch is written to a file named after its number and subject, such
+as 0001-Fix-the-frobnicator.patch, in the current directory or the one
+given with -o, and the names of the files are printed. With --stdout the
+mails are written to standard output instead.
+
+The subject of each mail is the commit subject after a "[PATCH n/m]"
+prefix, numbered when there is more than one patch; --subject-prefix
+(or format.subjectPrefix) changes "PATCH" and -v adds a version to it
+and to the file names. The mail carries the author and date of the
+commit, and the commit message up to a "---" line, after which come a
+diffstat and the diff.
+
+--cover-letter adds a patch 0 with a summary of the series to fill in.
+--thread adds Message-Id, In-Reply-To and References headers so that mail
+clients show the series as a thread: shallow makes every mail a reply to
+the first, deep each to the one before it. format.thread sets the default
+style. --in-reply-to makes the first mail a reply to the given message,
+and implies --thread.
+
+The changes of binary files are written as GIT binary patches, so that
+"vcs am" can apply them; --no-binary only says that they differ.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			return runFormatPatch(cmd, args[0], opts)
+		},
+	}
+
+	cmd.Flags().StringVarP(&opts.outputDir, "output-directory", "o", "", "Write the patches to this directory")
+	cmd.Flags().BoolVar(&opts.stdout, "stdout", false, "Write the mails to standard output instead of files")
+	cmd.Flags().BoolVar(&opts.root, "root", false, "Treat the revision as the tip of a range from the root commit")
+	cmd.Flags().BoolVar(&opts.coverLetter, "cover-letter", false, "Add a cover letter describing the series")
+	cmd.Flags().BoolVarP(&opts.numbered, "numbered", "n", false, "Number the patches even when there is only one")
+	cmd.Flags().BoolVarP(&opts.noNumbered, "no-numbered", "N", false, "Do not number the patches")
+	cmd.Flags().StringVar(&opts.subjectPrefix, "subject-prefix", "PATCH", "The prefix of the subjects instead of PATCH")
+	cmd.Flags().IntVar(&opts.startNumber, "start-number", 1, "Number the patches from this number")
+	cmd.Flags().IntVarP(&opts.rerollCount, "reroll-count", "v", 0, "Mark the series as this version of it")
+	cmd.Flags().StringVar(&opts.thread, "thread", "", "Add threading headers, shallow or deep")
+	cmd.Flags().Lookup("thread").NoOptDefVal = "shallow"
+	cmd.Flags().StringVar(&opts.inReplyTo, "in-reply-to", "", "Make the first mail a reply to this Message-Id")
+	cmd.Flags().StringVar(&opts.signature, "signature", version, "The signature at the end of each mail")
+	cmd.Flags().BoolVar(&opts.noSignature, "no-signature", false, "Do not add a signature")
+	cmd.Flags().BoolVar(&opts.noBinary, "no-binary", false, "Only say that binary files differ instead of writing their patches")
+
+	return cmd
+}
+
+// patchMail is a mail of a series, the cover letter or a patch
+type patchMail struct {
+	// id goes on the "From " line of the mbox, and subject is the commit
+	// subject the file is named after
+	id      objects.ObjectID
+	subject string
+	cover   bool
+	from    objects.Signature
+	title   string
+	body    string
+	// mime is set when the message is not ASCII
+	mime bool
+
+	messageID  string
+	inReplyTo  string
+	references []string
+}
+
+func runFormatPatch(cmd *cobra.Command, revision string, opts formatPatchOptions) error {
+	repoPath, err := findRepository()
+	if err != nil {
+		return err
+	}
+	repo, err := openRepository(repoPath)
+	if err != nil {
+		return err
+	}
+
+	cfg := loadConfig(repo.GitDir())
+	if !cmd.Flags().Changed("subject-prefix") {
+		if prefix, ok := cfg.Get("format.subjectPrefix"); ok {
+			opts.subjectPrefix = prefix
+		}
+	}
+	if !cmd.Flags().Changed("thread") {
+		if style, ok := cfg.Get("format.thread"); ok {
+			switch strings.ToLower(style) {
+			case "true", "shallow":
+				opts.thread = "shallow"
+			case "deep":
+				opts.thread = "deep"
+			}
+		}
+	}
+	switch opts.thread {
+	case "", "shallow", "deep":
+	default:
+		return fmt.Errorf("invalid --thread style '%s'", opts.thread)
+	}
+	if opts.inReplyTo != "" && opts.thread == "" {
+		opts.thread = "shallow"
+	}
+	if opts.numbered && opts.noNumbered {
+		return fmt.Errorf("--numbered and --no-numbered cannot be used together")
+	}
+
+	commits, err := formatPatchCommits(repo, revision, opts.root)
+	if err != nil {
+		return err
+	}
+	if len(commits) == 0 {
+		return nil
+	}
+
+	conv := newConverter(repo, io.Discard, nil)
+	mails, err := formatPatchMails(repo, conv, commits, opts)
+	if err != nil {
+		return err
+	}
+	threadPatchMails(mails, opts)
+
+	out := cmd.OutOrStdout()
+	if opts.stdout {
+		for _, mail := range mails {
+			writePatchMail(out, mail)
+		}
+		return nil
+	}
+
+	if opts.outputDir != "" {
+		if err := os.MkdirAll(opts.outputDir, 0755); err != nil {
+			return fmt.Errorf("failed to create output directory: %w", err)
+		}
+	}
+	number := opts.startNumber
+	if opts.coverLetter {
+		number--
+	}
+	for _, mail := range mails {
+		name := patchFileName(number, mail.subject, opts.rerollCount)
+		if mail.cover {
+			name = patchFileName(0, "cover-letter", opts.rerollCount)
+		}
+		path := filepath.Join(opts.outputDir, name)
+		var content strings.Builder
+		writePatchMail(&content, mail)
+		if err := os.WriteFile(path, []byte(content.String()), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", path, err)
+		}
+		fmt.Fprintln(out, path)
+		number++
+	}
+	return nil
+}
+
+// formatPatchCommits returns the commits of a range to format, oldest
+// first. A single revision stands for the commits since it on HEAD, or for
+// all of its history with root.
+func formatPatchCommits(repo *vcs.Repository, revision string, root bool) ([]*objects.Commit, error) {
+	from, to, isRange := strings.Cut(revision, "..")
+	if !isRange {
+		from, to = revision, "HEAD"
+		if root {
+			from, to = "", revision
+		}
+	}
+	if to == "" {
+		to = "HEAD"
+	}
+
+	toID, err := resolveCommitish(repo, to)
+	if err != nil {
+		return nil, err
+	}
+	var fromID objects.ObjectID
+	if from != "" || isRange {
+		if from == "" {
+			from = "HEAD"
+		}
+		if fromID, err = resolveCommitish(repo, from); err != nil {
+			return nil, err
+		}
+	}
+
+	between, err := commitsBetween(repo, fromID, toID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list commits in %s: %w", revision, err)
+	}
+	var commits []*objects.Commit
+	for _, commit := range between {
+		if len(commit.Parents()) <= 1 {
+			commits = append(commits, commit)
+		}
+	}
+	return commits, nil
+}
+
+// formatPatchMails builds the mails of a series: the cover letter, when
+// asked for, and a patch per commit
+func formatPatchMails(repo *vcs.Repository, conv *convert.Converter, commits []*objects.Commit, opts formatPatchOptions) ([]*patchMail, error) {
+	numbered := (len(commits) > 1 || opts.numbered || opts.coverLetter) && !opts.noNumbered
+	total := len(commits) + opts.startNumber - 1
+	prefix := opts.subjectPrefix
+	if opts.rerollCount > 0 {
+		prefix = strings.TrimSpace(fmt.Sprintf("%s v%d", prefix, opts.rerollCount))
+	}
+	tag := func(n int) string {
+		if numbered {
+			return strings.TrimSpace(fmt.Sprintf("[%s %d/%d]", prefix, n, total))
+		}
+		if prefix == "" {
+			return ""
+		}
+		return "[" + prefix + "]"
+	}
+	signature := ""
+	if !opts.noSignature && opts.signature != "" {
+		signature = "-- \n" + opts.signature + "\n"
+	}
+
+	var mails []*patchMail
+	if opts.coverLetter {
+		mail, err := coverLetterMail(repo, conv, commits, signature)
+		if err != nil {
+			return nil, err
+		}
+		mail.title = tag(0) + " *** SUBJECT HERE ***"
+		mails = append(mails, mail)
+	}
+
+	for i, commit := range commits {
+		var parentTree objects.ObjectID
+		if len(commit.Parents()) == 1 {
+			parent, err := repo.GetCommit(commit.Parents()[0])
+			if err != nil {
+				return nil, fmt.Errorf("failed to read parent of %s: %w", commit.ID().Short(), err)
+			}
+			parentTree = parent.Tree()
+		}
+		diffs, err := treeFileDiffs(repo, conv, parentTree, commit.Tree(), history.Detection{Renames: history.DefaultRenameThreshold})
+		if err != nil {
+			return nil, err
+		}
+
+		subject, message := splitCommitMessage(commit.Message())
+		var body strings.Builder
+		if message != "" {
+			body.WriteString(message + "\n")
+		}
+		body.WriteString("---\n")
+		writeDiffStat(&body, diffs)
+		writeDiffSummary(&body, diffs)
+		body.WriteString("\n")
+		for _, diff := range diffs {
+			writeFileDiff(&body, diff, !opts.noBinary)
+		}
+		body.WriteString(signature)
+
+		title := subject
+		if t := tag(opts.startNumber + i); t != "" {
+			title = t + " " + subject
+		}
+		mails = append(mails, &patchMail{
+			id:      commit.ID(),
+			subject: subject,
+			from:    commit.Author(),
+			title:   title,
+			body:    body.String(),
+			mime:    !isASCII(commit.Message()),
+		})
+	}
+	return mails, nil
+}
+
+// coverLetterMail builds the cover letter of a series: a blurb to fill in,
+// the subjects of the patches by author and a diffstat of the whole series
+func coverLetterMail(repo *vcs.Repository, conv *convert.Converter, commits []*objects.Commit, signature string) (*patchMail, error) {
+	first, last := commits[0], commits[len(commits)-1]
+	var baseTree objects.ObjectID
+	if len(first.Parents()) == 1 {
+		parent, err := repo.GetCommit(first.Parents()[0])
+		if err != nil {
+			return nil, fmt.Errorf("failed to read parent of %s: %w", first.ID().Short(), err)
+		}
+		baseTree = parent.Tree()
+	}
+	diffs, err := treeFileDiffs(repo, conv, baseTree, last.Tree(), history.Detection{Renames: history.DefaultRenameThreshold})
+	if err != nil {
+		return nil, err
+	}
+
+	var body strings.Builder
+	body.WriteString("*** BLURB HERE ***\n\n")
+
+	// Subjects are grouped by author, as in git shortlog
+	bySubjects := make(map[string][]string)
+	var authors []string
+	for _, commit := range commits {
+		name := commit.Author().Name
+		if _, ok := bySubjects[name]; !ok {
+			authors = append(authors, name)
+		}
+		subject, _ := splitCommitMessage(commit.Message())
+		bySubjects[name] = append(bySubjects[name], subject)
+	}
+	sort.Strings(authors)
+	for _, name := range authors {
+		fmt.Fprintf(&body, "%s (%d):\n", name, len(bySubjects[name]))
+		for _, subject := range bySubjects[name] {
+			body.WriteString(wrapText(subject, "  ", "    ", patchStatWidth))
+		}
+		body.WriteString("\n")
+	}
+	writeDiffStat(&body, diffs)
+	writeDiffSummary(&body, diffs)
+	body.WriteString("\n" + signature)
+
+	from, err := getSignature("")
+	if err != nil {
+		from = last.Author()
+	}
+	return &patchMail{id: last.ID(), cover: true, from: from, body: body.String(), mime: !isASCII(body.String())}, nil
+}
+
+// splitCommitMessage splits a commit message into its subject, the first
+// paragraph on one line, and the rest
+func splitCommitMessage(message string) (subject, body string) {
+	message = strings.TrimLeft(message, "\n")
+	paragraph, rest, _ := strings.Cut(message, "\n\n")
+	subject = strings.Join(strings.Fields(paragraph), " ")
+	return subject, strings.TrimRight(strings.TrimLeft(rest, "\n"), "\n ")
+}
+
+// threadPatchMails gives the mails Message-Id, In-Reply-To and References
+// headers when threading: shallow makes each mail a reply to the first and
+// deep each a reply to the one before it
+func threadPatchMails(mails []*patchMail, opts formatPatchOptions) {
+	if opts.thread == "" {
+		return
+	}
+	now := time.Now().Unix()
+	var references []string
+	if opts.inReplyTo != "" {
+		references = append(references, "<"+strings.Trim(opts.inReplyTo, "<>")+">")
+	}
+	for i, mail := range mails {
+		base := mail.id.String()
+		if mail.cover {
+			base = "cover"
+		}
+		mail.messageID = fmt.Sprintf("<%s.%d.vcs.%s>", base, now, mail.from.Email)
+
+		if len(references) > 0 {
+			mail.inReplyTo = references[len(references)-1]
+			mail.references = append([]string(nil), references...)
+		}
+		if opts.thread == "deep" || i == 0 {
+			references = append(references, mail.messageID)
+		}
+	}
+}
+
+// writePatchMail writes a mail as an mbox entry, with MIME headers when its
+// text is not ASCII
+func writePatchMail(w io.Writer, mail *patchMail) {
+	fmt.Fprintf(w, "From %s%s\n", mail.id, mboxFromLine)
+	if mail.messageID != "" {
+		fmt.Fprintf(w, "Message-Id: %s\n", mail.messageID)
+	}
+	if mail.inReplyTo != "" {
+		fmt.Fprintf(w, "In-Reply-To: %s\n", mail.inReplyTo)
+		fmt.Fprintf(w, "References: %s\n", strings.Join(mail.references, "\n "))
+	}
+	fmt.Fprintf(w, "From: %s\n", mailAddress(mail.from))
+	fmt.Fprintf(w, "Date: %s\n", mail.from.When.Format("Mon, 2 Jan 2006 15:04:05 -0700"))
+	fmt.Fprintf(w, "Subject: %s\n", encodeMailHeader(mail.title))
+	if mail.mime {
+		fmt.Fprint(w, "MIME-Version: 1.0\nContent-Type: text/plain; charset=UTF-8\nContent-Transfer-Encoding: 8bit\n")
+	}
+	fmt.Fprintf(w, "\n%s\n", mail.body)
+}
+
+// mailAddress formats a signature as a From: header value, quoting the
+// name when it has special characters and encoding it when not ASCII
+func mailAddress(sig objects.Signature) string {
+	name := sig.Name
+	switch {
+	case !isASCII(name):
+		name = mime.QEncoding.Encode("UTF-8", name)
+	case strings.ContainsAny(name, `()<>[]:;@\,."`):
+		name = strconv.Quote(name)
+	}
+	if name == "" {
+		return "<" + sig.Email + ">"
+	}
+	return name + " <" + sig.Email + ">"
+}
+
+// encodeMailHeader encodes a header value that is not ASCII as RFC 2047
+// words, and folds one that is onto lines of up to 78 columns
+func encodeMailHeader(value string) string {
+	if !isASCII(value) {
+		return mime.QEncoding.Encode("UTF-8", value)
+	}
+	return strings.TrimSuffix(wrapText(value, "", " ", 78-len("Subject: ")), "\n")
+}
+
+// wrapText breaks text into lines of words up to width columns, the first
+// starting with indent and the others with hang
+func wrapText(text, indent, hang string, width int) string {
+	var b strings.Builder
+	line := indent
+	start := true
+	for _, word := range strings.Fields(text) {
+		if !start && len(line)+1+len(word) > width {
+			b.WriteString(line + "\n")
+			line, start = hang, true
+		}
+		if !start {
+			line += " "
+		}
+		line += word
+		start = false
+	}
+	b.WriteString(line + "\n")
+	return b.String()
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= 0x80 {
+			return false
+		}
+	}
+	return true
+}
+
+// patchFileName names the file of patch n after its subject, keeping only
+// letters, digits, dots and underscores, as Git does
+func patchFileName(n int, subject string, rerollCount int) string {
+	var name strings.Builder
+	if rerollCount > 0 {
+		fmt.Fprintf(&name, "v%d-", rerollCount)
+	}
+	fmt.Fprintf(&name, "%04d-", n)
+
+	var slug strings.Builder
+	gap := false
+	for i := 0; i < len(subject); i++ {
+		c := subject[i]
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '.' || c == '_' {
+			if gap && slug.Len() > 0 {
+				slug.WriteByte('-')
+			}
+			gap = false
+			slug.WriteByte(c)
+			for c == '.' && i+1 < len(subject) && subject[i+1] == '.' {
+				i++
+			}
+		} else {
+			gap = true
+		}
+	}
+	name.WriteString(strings.TrimRight(slug.String(), ".-"))
+
+	s := name.String()
+	if len(s) > patchNameMax {
+		s = s[:patchNameMax]
+	}
+	return s + ".patch"
+}
+
+// fileDiff is the change of one file in a patch
+type fileDiff struct {
+	change history.Change
+	hunks  []*interactive.Hunk
+	binary bool
+	// oldData and newData are the content of a binary file, for the
+	// diffstat and a binary patch
+	oldData, newData []byte
+}
+
+// counts returns how many lines the diff adds and removes
+func (d *fileDiff) counts() (added, removed int) {
+	for _, hunk := range d.hunks {
+		for _, line := range hunk.Lines {
+			switch line.Op {
+			case '+':
+				added++
+			case '-':
+				removed++
+			}
+		}
+	}
+	return added, removed
+}
+
+// treeFileDiffs compares two trees file by file, pairing similar files as
+// renames and copies as d asks
+func treeFileDiffs(repo *vcs.Repository, conv *convert.Converter, oldTree, newTree objects.ObjectID, d history.Detection) ([]*fileDiff, error) {
+	changes, err := history.DiffTrees(repo, oldTree, newTree, true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compare trees: %w", err)
+	}
+
+	// A file that becomes a symlink is shown as deleted and added, as by Git
+	var split []history.Change
+	for _, change := range changes {
+		if change.Type != history.TypeChanged {
+			split = append(split, change)
+			continue
+		}
+		split = append(split,
+			history.Change{Type: history.Deleted, Path: change.Path, OldPath: change.Path, OldMode: change.OldMode, OldID: change.OldID},
+			history.Change{Type: history.Added, Path: change.Path, NewMode: change.NewMode, NewID: change.NewID})
+	}
+
+	contents := make(map[objects.ObjectID][]byte)
+	content := func(mode objects.FileMode, id objects.ObjectID) ([]byte, error) {
+		if id.IsZero() {
+			return nil, nil
+		}
+		if mode == objects.ModeCommit {
+			return []byte("Subproject commit " + id.String() + "\n"), nil
+		}
+		if data, ok := contents[id]; ok {
+			return data, nil
+		}
+		data, err := history.ReadBlob(repo, id)
+		if err != nil {
+			return nil, err
+		}
+		contents[id] = data
+		return data, nil
+	}
+
+	split, err = history.DetectRenames(repo, split, d)
+	if err != nil {
+		return nil, err
+	}
+
+	var diffs []*fileDiff
+	for _, change := range split {
+		oldData, err := content(change.OldMode, change.OldID)
+		if err != nil {
+			return nil, err
+		}
+		newData, err := content(change.NewMode, change.NewID)
+		if err != nil {
+			return nil, err
+		}
+		diff := &fileDiff{change: change}
+		if change.OldID != change.NewID {
+			if conv.DiffBinary(change.Path, oldData) || conv.DiffBinary(change.Path, newData) {
+				diff.binary = true
+				diff.oldData, diff.newData = oldData, newData
+			} else {
+				diff.hunks = interactive.Diff(oldData, newData, interactive.DefaultContext)
+			}
+		}
+		diffs = append(diffs, diff)
+	}
+	sort.SliceStable(diffs, func(i, j int) bool { return diffs[i].change.Path < diffs[j].change.Path })
+	return diffs, nil
+}
+
+// writeFileDiff writes the diff of a file in Git's extended format. With
+// binary the change of a binary file is written as a binary patch, whose
+// index line has the full blob IDs.
+func writeFileDiff(w io.Writer, d *fileDiff, binary bool) {
+	change := d.change
+	abbrev := abbrevObject
+	if binary && d.binary {
+		abbrev = func(id objects.ObjectID) string { return id.String() }
+	}
+	oldPath := change.OldPath
+	if oldPath == "" {
+		oldPath = change.Path
+	}
+	oldName, newName := patch.QuoteName("a/"+oldPath), patch.QuoteName("b/"+change.Path)
+	fmt.Fprintf(w, "diff --git %s %s\n", oldName, newName)
+
+	switch change.Type {
+	case history.Added:
+		oldName = "/dev/null"
+		fmt.Fprintf(w, "new file mode %06o\n", uint32(change.NewMode))
+		fmt.Fprintf(w, "index %s..%s\n", abbrev(change.OldID), abbrev(change.NewID))
+	case history.Deleted:
+		newName = "/dev/null"
+		fmt.Fprintf(w, "deleted file mode %06o\n", uint32(change.OldMode))
+		fmt.Fprintf(w, "index %s..%s\n", abbrev(change.OldID), abbrev(change.NewID))
+	default:
+		switch change.Type {
+		case history.Renamed:
+			fmt.Fprintf(w, "similarity index %d%%\n", change.Score)
+			fmt.Fprintf(w, "rename from %s\n", patch.QuoteName(change.OldPath))
+			fmt.Fprintf(w, "rename to %s\n", patch.QuoteName(change.Path))
+		case history.Copied:
+			fmt.Fprintf(w, "similarity index %d%%\n", change.Score)
+			fmt.Fprintf(w, "copy from %s\n", patch.QuoteName(change.OldPath))
+			fmt.Fprintf(w, "copy to %s\n", patch.QuoteName(change.Path))
+		}
+		if change.OldMode != change.NewMode {
+			fmt.Fprintf(w, "old mode %06o\n", uint32(change.OldMode))
+			fmt.Fprintf(w, "new mode %06o\n", uint32(change.NewMode))
+		}
+		if change.OldID != change.NewID {
+			fmt.Fprintf(w, "index %s..%s", abbrev(change.OldID), abbrev(change.NewID))
+			if change.OldMode == change.NewMode {
+				fmt.Fprintf(w, " %06o", uint32(change.NewMode))
+			}
+			fmt.Fprintln(w)
+		}
+	}
+
+	if d.binary && binary {
+		patch.WriteBinary(w, d.oldData, d.newData)
+		return
+	}
+	if d.binary {
+		fmt.Fprintf(w, "Binary files %s and %s differ\n", oldName, newName)
+		return
+	}
+	if len(d.hunks) == 0 {
+		return
+	}
+	fmt.Fprintf(w, "--- %s\n+++ %s\n", oldName, newName)
+	for _, hunk := range d.hunks {
+		fmt.Fprint(w, hunk.String())
+	}
+}
+
+// abbrevObject abbreviates an object ID for an index line, where a zero
+// ID is all zeros
+func abbrevObject(id objects.ObjectID) string {
+	if id.IsZero() {
+		return "0000000"
+	}
+	return id.String()[:7]
+}
+
+// writeDiffStat writes a diffstat of the diffs as Git does in mails, a line
+// per file with a graph of its changes scaled to fit, then the totals
+func writeDiffStat(w io.Writer, diffs []*fileDiff) {
+	if len(diffs) == 0 {
+		fmt.Fprintln(w, " 0 files changed")
+		return
+	}
+
+	names := make([]string, len(diffs))
+	maxName, maxChange, binWidth := 0, 0, 0
+	for i, d := range diffs {
+		names[i] = patch.QuoteName(d.change.Path)
+		if d.change.Type == history.Renamed || d.change.Type == history.Copied {
+			names[i] = renameName(patch.QuoteName(d.change.OldPath), names[i])
+		}
+		if len(names[i]) > maxName {
+			maxName = len(names[i])
+		}
+		if d.binary {
+			if n := len(fmt.Sprintf("Bin %d -> %d bytes", len(d.oldData), len(d.newData))); n > binWidth {
+				binWidth = n
+			}
+			continue
+		}
+		added, removed := d.counts()
+		if added+removed > maxChange {
+			maxChange = added + removed
+		}
+	}
+
+	numberWidth := len(strconv.Itoa(maxChange))
+	if binWidth > 0 && numberWidth < 3 {
+		numberWidth = 3
+	}
+	graphWidth := maxChange
+	if binWidth > graphWidth+4 {
+		graphWidth = binWidth - 4
+	}
+	nameWidth := maxName
+	if nameWidth+numberWidth+6+graphWidth > patchStatWidth {
+		if limit := patchStatWidth*3/8 - numberWidth - 6; graphWidth > limit {
+			graphWidth = limit
+			if graphWidth < 6 {
+				graphWidth = 6
+			}
+		}
+		if limit := patchStatWidth - numberWidth - 6 - graphWidth; nameWidth > limit {
+			nameWidth = limit
+		} else {
+			graphWidth = patchStatWidth - numberWidth - 6 - nameWidth
+		}
+	}
+
+	totalAdded, totalRemoved := 0, 0
+	for i, d := range diffs {
+		name := names[i]
+		if len(name) > nameWidth {
+			tail := name[len(name)-(nameWidth-3):]
+			if slash := strings.IndexByte(tail, '/'); slash >= 0 {
+				tail = tail[slash:]
+			}
+			name = "..." + tail
+		}
+		if d.binary {
+			fmt.Fprintf(w, " %-*s | %*s", nameWidth, name, numberWidth, "Bin")
+			if len(d.oldData) != 0 || len(d.newData) != 0 {
+				fmt.Fprintf(w, " %d -> %d bytes", len(d.oldData), len(d.newData))
+			}
+			fmt.Fprintln(w)
+			continue
+		}
+
+		added, removed := d.counts()
+		totalAdded += added
+		totalRemoved += removed
+		fmt.Fprintf(w, " %-*s | %*d", nameWidth, name, numberWidth, added+removed)
+		if added+removed > 0 {
+			if maxChange > graphWidth {
+				added = scaleStat(added, graphWidth, maxChange)
+				removed = scaleStat(removed, graphWidth, maxChange)
+			}
+			fmt.Fprintf(w, " %s%s", strings.Repeat("+", added), strings.Repeat("-", removed))
+		}
+		fmt.Fprintln(w)
+	}
+
+	writeStatTotals(w, len(diffs), totalAdded, totalRemoved)
+}
+
+// writeStatTotals writes the last line of a diffstat, how many files
+// changed and how many lines were added and removed
+func writeStatTotals(w io.Writer, files, added, removed int) {
+	fmt.Fprintf(w, " %d file%s changed", files, plural(files))
+	if added > 0 || removed == 0 {
+		fmt.Fprintf(w, ", %d %s(+)", added, "insertion"+plural(added))
+	}
+	if removed > 0 || added == 0 {
+		fmt.Fprintf(w, ", %d %s(-)", removed, "deletion"+plural(removed))
+	}
+	fmt.Fprintln(w)
+}
+
+// writeShortStat writes only the totals of the diffstat of diffs, as
+// --shortstat does
+func writeShortStat(w io.Writer, diffs []*fileDiff) {
+	totalAdded, totalRemoved := 0, 0
+	for _, d := range diffs {
+		if d.binary {
+			continue
+		}
+		added, removed := d.counts()
+		totalAdded += added
+		totalRemoved += removed
+	}
+	writeStatTotals(w, len(diffs), totalAdded, totalRemoved)
+}
+
+// writeNumStat writes a line for each of the diffs with the lines it adds
+// and removes and its path, separated by tabs, as --numstat does. Binary
+// files show "-" for both counts.
+func writeNumStat(w io.Writer, diffs []*fileDiff) {
+	for _, d := range diffs {
+		name := patch.QuoteName(d.change.Path)
+		if d.change.Type == history.Renamed || d.change.Type == history.Copied {
+			name = renameName(patch.QuoteName(d.change.OldPath), name)
+		}
+		if d.binary {
+			fmt.Fprintf(w, "-\t-\t%s\n", name)
+			continue
+		}
+		added, removed := d.counts()
+		fmt.Fprintf(w, "%d\t%d\t%s\n", added, removed, name)
+	}
+}
+
+// writeDiffSummary writes the files created, deleted, renamed, copied or
+// changing mode, as Git does below the diffstat in mails
+func writeDiffSummary(w io.Writer, diffs []*fileDiff) {
+	for _, d := range diffs {
+		change := d.change
+		switch {
+		case change.Type == history.Added:
+			fmt.Fprintf(w, " create mode %06o %s\n", uint32(change.NewMode), patch.QuoteName(change.Path))
+		case change.Type == history.Deleted:
+			fmt.Fprintf(w, " delete mode %06o %s\n", uint32(change.OldMode), patch.QuoteName(change.Path))
+		case change.Type == history.Renamed:
+			fmt.Fprintf(w, " rename %s (%d%%)\n", renameName(patch.QuoteName(change.OldPath), patch.QuoteName(change.Path)), change.Score)
+		case change.Type == history.Copied:
+			fmt.Fprintf(w, " copy %s (%d%%)\n", renameName(patch.QuoteName(change.OldPath), patch.QuoteName(change.Path)), change.Score)
+		}
+		if change.Type != history.Added && change.Type != history.Deleted && change.OldMode != change.NewMode {
+			fmt.Fprintf(w, " mode change %06o => %06o %s\n", uint32(change.OldMode), uint32(change.NewMode), patch.QuoteName(change.Path))
+		}
+	}
+}
+
+// scaleStat scales a count of changed lines to a graph of width columns
+// for the largest count max, keeping every change visible
+func scaleStat(n, width, max int) int {
+	if n == 0 {
+		return 0
+	}
+	return 1 + n*(width-1)/max
+}
+
+// renameName shows a rename with the directories both paths share outside
+// braces, as in "dir/{old => new}.go"
+func renameName(from, to string) string {
+	prefix := 0
+	for i := 0; i < len(from) && i < len(to) && from[i] == to[i]; i++ {
+		if from[i] == '/' {
+			prefix = i + 1
+		}
+	}
+
+	// The suffix may reach back to the slash ending the prefix
+	suffix := 0
+	back := 0
+	if prefix > 0 {
+		back = 1
+	}
+	for i, j := len(from)-1, len(to)-1; i >= prefix-back && j >= prefix-back && from[i] == to[j]; i, j = i-1, j-1 {
+		if from[i] == '/' {
+			suffix = len(from) - i
+		}
+	}
+
+	if prefix+suffix == 0 {
+		return from + " => " + to
+	}
+	fromMid, toMid := "", ""
+	if end := len(from) - suffix; end > prefix {
+		fromMid = from[prefix:end]
+	}
+	if end := len(to) - suffix; end > prefix {
+		toMid = to[prefix:end]
+	}
+	return from[:prefix] + "{" + fromMid + " => " + toMid + "}" + from[len(from)-suffix:]
+}
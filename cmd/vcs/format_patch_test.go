@@ -0,0 +1,165 @@
+package main
+
+import (
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// setupFormatPatchRepo commits two changes on top of a base commit on main
+// and returns the base and the tip
+func setupFormatPatchRepo(t *testing.T) (repo *vcs.Repository, refManager *refs.RefManager, base, tip objects.ObjectID) {
+	repo, refManager = setupSeriesRepo(t, map[string]string{"a.txt": "1\n2\n3\n", "old.txt": "same\n"})
+	base, err := refManager.ResolveRef("refs/heads/main")
+	require.NoError(t, err)
+	first := commitFiles(t, repo, map[string]string{"a.txt": "1\ntwo\n3\n", "old.txt": "same\n"}, []objects.ObjectID{base}, "Change two\n\nBecause two is better.\n")
+	tip = commitFiles(t, repo, map[string]string{"a.txt": "1\ntwo\n3\n", "new.txt": "same\n", "b.txt": "b\n"}, []objects.ObjectID{first}, "Add b and rename old\n")
+	require.NoError(t, checkoutTree(repo, base, tip))
+	require.NoError(t, refManager.UpdateRef("refs/heads/main", tip))
+	return repo, refManager, base, tip
+}
+
+func TestFormatPatch(t *testing.T) {
+	repo, refManager, base, tip := setupFormatPatchRepo(t)
+
+	out, err := runCommandArgs(newFormatPatchCommand(), "-o", "outgoing", "HEAD~2")
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join("outgoing", "0001-Change-two.patch")+"\n"+
+		filepath.Join("outgoing", "0002-Add-b-and-rename-old.patch")+"\n", out)
+
+	first := readWorkFile(t, filepath.Join("outgoing", "0001-Change-two.patch"))
+	assert.Contains(t, first, "Subject: [PATCH 1/2] Change two\n\nBecause two is better.\n---\n")
+	assert.Contains(t, first, " a.txt | 2 +-\n 1 file changed, 1 insertion(+), 1 deletion(-)\n")
+	assert.Contains(t, first, "@@ -1,3 +1,3 @@\n 1\n-2\n+two\n 3\n-- \n")
+
+	second := readWorkFile(t, filepath.Join("outgoing", "0002-Add-b-and-rename-old.patch"))
+	assert.Contains(t, second, " b.txt              | 1 +\n old.txt => new.txt | 0\n")
+	assert.Contains(t, second, " create mode 100644 b.txt\n rename old.txt => new.txt (100%)\n")
+	assert.Contains(t, second, "diff --git a/old.txt b/new.txt\nsimilarity index 100%\nrename from old.txt\nrename to new.txt\n")
+
+	// A single patch is not numbered
+	out, err = runCommandArgs(newFormatPatchCommand(), "--stdout", "HEAD~1")
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(out, "From "+tip.String()+" Mon Sep 17 00:00:00 2001\n"))
+	assert.Contains(t, out, "Subject: [PATCH] Add b and rename old\n")
+
+	// The patches apply back onto the base
+	require.NoError(t, checkoutTree(repo, tip, base))
+	require.NoError(t, refManager.UpdateRef("refs/heads/main", base))
+	_, err = runCommandArgs(newAmCommand(), filepath.Join("outgoing", "0001-Change-two.patch"), filepath.Join("outgoing", "0002-Add-b-and-rename-old.patch"))
+	require.NoError(t, err)
+	headID, err := refManager.ResolveRef("refs/heads/main")
+	require.NoError(t, err)
+	head, err := repo.GetCommit(headID)
+	require.NoError(t, err)
+	want, err := repo.GetCommit(tip)
+	require.NoError(t, err)
+	assert.Equal(t, want.Tree(), head.Tree())
+	assert.Equal(t, "Add b and rename old\n", head.Message())
+}
+
+func TestFormatPatchCoverLetterAndThread(t *testing.T) {
+	setupFormatPatchRepo(t)
+
+	out, err := runCommandArgs(newFormatPatchCommand(), "--stdout", "--cover-letter", "--in-reply-to", "start@example.com", "-v", "2", "HEAD~2")
+	require.NoError(t, err)
+	mails := strings.Split(out, "\nFrom ")
+	require.Len(t, mails, 3)
+
+	cover := mails[0]
+	assert.Contains(t, cover, "Subject: [PATCH v2 0/2] *** SUBJECT HERE ***\n")
+	assert.Contains(t, cover, "*** BLURB HERE ***\n\nTest (2):\n  Change two\n  Add b and rename old\n\n")
+	assert.Contains(t, cover, " 3 files changed, 2 insertions(+), 1 deletion(-)\n")
+
+	messageID := regexp.MustCompile(`(?m)^Message-Id: (<[^>]+>)$`)
+	coverID := messageID.FindStringSubmatch(cover)
+	require.NotNil(t, coverID)
+	assert.Contains(t, cover, "In-Reply-To: <start@example.com>\nReferences: <start@example.com>\n")
+	for _, mail := range mails[1:] {
+		assert.Contains(t, mail, "In-Reply-To: "+coverID[1]+"\nReferences: <start@example.com>\n "+coverID[1]+"\n")
+	}
+	assert.Contains(t, mails[2], "Subject: [PATCH v2 2/2] Add b and rename old\n")
+
+	// Deep threading replies to the mail before
+	out, err = runCommandArgs(newFormatPatchCommand(), "--stdout", "--thread=deep", "HEAD~2")
+	require.NoError(t, err)
+	mails = strings.Split(out, "\nFrom ")
+	require.Len(t, mails, 2)
+	firstID := messageID.FindStringSubmatch(mails[0])
+	require.NotNil(t, firstID)
+	assert.NotContains(t, mails[0], "In-Reply-To")
+	assert.Contains(t, mails[1], "In-Reply-To: "+firstID[1]+"\n")
+
+	_, err = runCommandArgs(newFormatPatchCommand(), "--thread=sideways", "HEAD~2")
+	assert.ErrorContains(t, err, "invalid --thread style")
+}
+
+func TestPatchFileName(t *testing.T) {
+	tests := []struct {
+		subject string
+		want    string
+	}{
+		{"Fix the frobnicator", "0001-Fix-the-frobnicator.patch"},
+		{"docs: update README...", "0001-docs-update-README.patch"},
+		{"  Use [brackets] & symbols!  ", "0001-Use-brackets-symbols.patch"},
+		{strings.Repeat("long ", 20), "0001-long-long-long-long-long-long-long-long-long-long-lo.patch"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, patchFileName(1, tt.subject, 0), tt.subject)
+	}
+	assert.Equal(t, "v3-0000-cover-letter.patch", patchFileName(0, "cover-letter", 3))
+}
+
+func TestRenameName(t *testing.T) {
+	assert.Equal(t, "old.txt => new.txt", renameName("old.txt", "new.txt"))
+	assert.Equal(t, "src/{a.go => b.go}", renameName("src/a.go", "src/b.go"))
+	assert.Equal(t, "{d1 => d2}/f.txt", renameName("d1/f.txt", "d2/f.txt"))
+	assert.Equal(t, "a/{b => }/c", renameName("a/b/c", "a/c"))
+}
+
+func TestFormatPatchBinary(t *testing.T) {
+	image := strings.Repeat("\x89PNG\x00\x01\x02pixels", 100)
+	repo, refManager := setupSeriesRepo(t, map[string]string{"image.png": image})
+	base, err := refManager.ResolveRef("refs/heads/main")
+	require.NoError(t, err)
+	changed := image[:500] + "\xff\xfe" + image[500:]
+	tip := commitFiles(t, repo, map[string]string{"image.png": changed, "new.bin": "\x00new\n"}, []objects.ObjectID{base}, "Change image\n")
+	require.NoError(t, checkoutTree(repo, base, tip))
+	require.NoError(t, refManager.UpdateRef("refs/heads/main", tip))
+
+	out, err := runCommandArgs(newFormatPatchCommand(), "--stdout", "HEAD~1")
+	require.NoError(t, err)
+	assert.Contains(t, out, " image.png | Bin 1300 -> 1302 bytes\n")
+	assert.Contains(t, out, "index "+objects.NewBlob([]byte(image)).ID().String()+".."+objects.NewBlob([]byte(changed)).ID().String()+" 100644\nGIT binary patch\ndelta ")
+	assert.Contains(t, out, "new file mode 100644\nindex 0000000000000000000000000000000000000000..")
+	assert.NotContains(t, out, "Binary files")
+	mbox := writeMbox(t, out)
+
+	out, err = runCommandArgs(newFormatPatchCommand(), "--stdout", "--no-binary", "HEAD~1")
+	require.NoError(t, err)
+	assert.Contains(t, out, "Binary files a/image.png and b/image.png differ\n")
+	assert.NotContains(t, out, "GIT binary patch")
+
+	// The binary patches apply back onto the base
+	require.NoError(t, checkoutTree(repo, tip, base))
+	require.NoError(t, refManager.UpdateRef("refs/heads/main", base))
+	_, err = runCommandArgs(newAmCommand(), mbox)
+	require.NoError(t, err)
+	assert.Equal(t, changed, readWorkFile(t, "image.png"))
+	assert.Equal(t, "\x00new\n", readWorkFile(t, "new.bin"))
+	headID, err := refManager.ResolveRef("refs/heads/main")
+	require.NoError(t, err)
+	head, err := repo.GetCommit(headID)
+	require.NoError(t, err)
+	want, err := repo.GetCommit(tip)
+	require.NoError(t, err)
+	assert.Equal(t, want.Tree(), head.Tree())
+}
@@ -0,0 +1,411 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/fsck"
+	"github.com/fenilsonani/vcs/internal/core/index"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/packfile"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// fsckOptions holds the flags of fsck
+type fsckOptions struct {
+	strict      bool
+	unreachable bool
+	noDangling  bool
+}
+
+// fsckLink is an object another object points to
+type fsckLink struct {
+	objType objects.ObjectType
+	id      objects.ObjectID
+}
+
+// fsckRun is the state of one fsck: the objects found, what they point to
+// and the errors reported so far
+type fsckRun struct {
+	out    io.Writer
+	repo   *vcs.Repository
+	opts   fsckOptions
+	errors int
+
+	types      map[objects.ObjectID]objects.ObjectType
+	links      map[objects.ObjectID][]fsckLink
+	referenced map[objects.ObjectID]bool
+	missing    map[objects.ObjectID]bool
+}
+
+func newFsckCommand() *cobra.Command {
+	var opts fsckOptions
+
+	cmd := &cobra.Command{
+		Use:   "fsck [flags]",
+		Short: "Verify the connectivity and validity of the objects in the database",
+		Long: `Checks the integrity of the repository. Every loose and packed object
+must hash to its name and be well formed, packs must match their checksums
+and indexes, refs must point to objects that exist and branches to
+commits, the index must be readable, and every object reachable from the
+refs, HEAD, the index and the reflogs must be present.
+
+Each finding is printed on a line of its own, for scripts to parse:
+
+  error in <kind> <name>: <id>: <message>
+  warning in <kind> <name>: <id>: <message>
+  broken link from <type> <object> to <type> <object>
+  missing <type> <object>
+  dangling <type> <object>
+  unreachable <type> <object>
+
+<kind> is an object type, object when the type cannot be read, ref, pack
+or index, and <id> names the problem as Git's fsck does, as badTimezone or
+treeNotSorted. Dangling objects are unreachable ones no other object
+points to; --unreachable lists every unreachable object instead, and
+--no-dangling neither. --strict also reports group-writable file modes
+and makes every warning an error.
+
+fsck fails when it finds an error or a missing object.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runFsck(cmd, opts)
+		},
+	}
+
+	cmd.Flags().BoolVar(&opts.strict, "strict", false, "Report group-writable file modes and treat warnings as errors")
+	cmd.Flags().BoolVar(&opts.unreachable, "unreachable", false, "List every unreachable object, not just dangling ones")
+	cmd.Flags().BoolVar(&opts.noDangling, "no-dangling", false, "Do not list dangling objects")
+
+	return cmd
+}
+
+func runFsck(cmd *cobra.Command, opts fsckOptions) error {
+	repoPath, err := findRepository()
+	if err != nil {
+		return fmt.Errorf("not a git repository: %w", err)
+	}
+	// Not openRepository, whose object cache would answer for the files
+	repo, err := vcs.Open(repoPath)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+	refManager := refs.NewRefManager(repo.GitDir())
+	cmd.SilenceUsage = true
+
+	f := &fsckRun{
+		out:        cmd.OutOrStdout(),
+		repo:       repo,
+		opts:       opts,
+		types:      make(map[objects.ObjectID]objects.ObjectType),
+		links:      make(map[objects.ObjectID][]fsckLink),
+		referenced: make(map[objects.ObjectID]bool),
+		missing:    make(map[objects.ObjectID]bool),
+	}
+
+	ids, err := f.checkPacks()
+	if err != nil {
+		return err
+	}
+	loose, err := repo.Storage().LooseObjects()
+	if err != nil {
+		return fmt.Errorf("failed to list loose objects: %w", err)
+	}
+	f.checkObjects(append(ids, loose...))
+
+	if err := f.checkRefs(refManager); err != nil {
+		return err
+	}
+	for _, gitDir := range worktreeGitDirs(repo.CommonDir()) {
+		f.checkIndex(gitDir)
+	}
+
+	roots, err := gcRoots(repo, refManager)
+	if err != nil {
+		return err
+	}
+	reachable, err := f.checkConnectivity(roots)
+	if err != nil {
+		return err
+	}
+	f.listUnreachable(reachable)
+
+	if f.errors > 0 {
+		return fmt.Errorf("found %d error%s", f.errors, plural(f.errors))
+	}
+	return nil
+}
+
+// report prints a problem with the object, ref, pack or index called name
+func (f *fsckRun) report(severity fsck.Severity, kind, name, id, message string) {
+	fmt.Fprintf(f.out, "%s in %s %s: %s: %s\n", severity, kind, name, id, message)
+	if severity == fsck.Error {
+		f.errors++
+	}
+}
+
+// checkPacks verifies every pack against its checksum and index, and
+// returns the objects they hold
+func (f *fsckRun) checkPacks() ([]objects.ObjectID, error) {
+	packDir := packfile.NewPackDir(f.repo.Storage().PackDir())
+	defer packDir.Close()
+	packs, err := packDir.Packs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to open packs: %w", err)
+	}
+
+	var ids []objects.ObjectID
+	for _, pack := range packs {
+		if err := pack.Verify(); err != nil {
+			f.report(fsck.Error, "pack", filepath.Base(pack.Path()), "badPack", err.Error())
+		}
+		ids = append(ids, pack.IDs()...)
+	}
+	return ids, nil
+}
+
+// checkObjects reads every object of ids, checking that it hashes to its
+// name and is well formed, and records its type and what it points to
+func (f *fsckRun) checkObjects(ids []objects.ObjectID) {
+	sort.Slice(ids, func(i, j int) bool { return bytes.Compare(ids[i][:], ids[j][:]) < 0 })
+	for i, id := range ids {
+		if i > 0 && ids[i-1] == id {
+			continue
+		}
+
+		objType, data, err := f.repo.Storage().ReadRawObject(id)
+		if err != nil {
+			f.report(fsck.Error, "object", id.String(), "badObject", err.Error())
+			continue
+		}
+		if actual := objects.ComputeHash(objType, data); actual != id {
+			f.report(fsck.Error, string(objType), id.String(), "hashMismatch", fmt.Sprintf("content hashes to %s", actual))
+			continue
+		}
+		f.types[id] = objType
+
+		for _, problem := range fsck.Check(objType, data, fsck.Options{Strict: f.opts.strict}) {
+			f.report(problem.Severity, string(objType), id.String(), problem.ID, problem.Message)
+		}
+
+		obj, err := objects.ParseObject(id, objType, data)
+		if err != nil {
+			f.report(fsck.Error, string(objType), id.String(), "badObject", err.Error())
+			continue
+		}
+		links := objectLinks(obj)
+		for _, link := range links {
+			f.referenced[link.id] = true
+		}
+		f.links[id] = links
+	}
+}
+
+// objectLinks returns the objects obj points to. Submodule commits live in
+// another repository and are left out.
+func objectLinks(obj objects.Object) []fsckLink {
+	var links []fsckLink
+	switch obj := obj.(type) {
+	case *objects.Commit:
+		links = append(links, fsckLink{objects.TypeTree, obj.Tree()})
+		for _, parent := range obj.Parents() {
+			links = append(links, fsckLink{objects.TypeCommit, parent})
+		}
+	case *objects.Tag:
+		links = append(links, fsckLink{obj.Type(), obj.Object()})
+	case *objects.Tree:
+		for _, entry := range obj.Entries() {
+			switch entry.Mode {
+			case objects.ModeCommit:
+			case objects.ModeTree:
+				links = append(links, fsckLink{objects.TypeTree, entry.ID})
+			default:
+				links = append(links, fsckLink{objects.TypeBlob, entry.ID})
+			}
+		}
+	}
+	return links
+}
+
+// checkRefs checks that every ref can be read and points to an object
+// that exists, a commit for branches, and that HEAD can be resolved in
+// every worktree
+func (f *fsckRun) checkRefs(refManager *refs.RefManager) error {
+	commonDir := f.repo.CommonDir()
+	if err := f.checkLooseRefs(refManager); err != nil {
+		return err
+	}
+
+	allRefs, err := refManager.AllRefs()
+	if err != nil {
+		return fmt.Errorf("failed to list refs: %w", err)
+	}
+	names := make([]string, 0, len(allRefs))
+	for name := range allRefs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		f.checkRefTarget(name, allRefs[name])
+	}
+
+	for _, gitDir := range worktreeGitDirs(commonDir) {
+		name := "HEAD"
+		if gitDir != commonDir {
+			rel, _ := filepath.Rel(commonDir, gitDir)
+			name = filepath.ToSlash(rel) + "/HEAD"
+		}
+		worktreeRefs := refs.NewRefManager(gitDir)
+		id, _, err := worktreeRefs.HEAD()
+		if err != nil {
+			// A branch with no commits yet is not a problem
+			if branch, _ := worktreeRefs.SymbolicHEAD(); branch == "" {
+				f.report(fsck.Error, "ref", name, "badHead", err.Error())
+			}
+			continue
+		}
+		f.checkRefTarget(name, id)
+	}
+	return nil
+}
+
+// checkLooseRefs checks that every file under refs/ holds an object ID or
+// a symbolic ref. A reftable repository keeps its refs in tables, which
+// reading them checks, and has a refs/heads file that is not a ref.
+func (f *fsckRun) checkLooseRefs(refManager *refs.RefManager) error {
+	if refManager.Store().Format() != refs.FormatFiles {
+		return nil
+	}
+	commonDir := f.repo.CommonDir()
+	err := filepath.WalkDir(filepath.Join(commonDir, "refs"), func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || strings.HasSuffix(path, ".lock") {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		value := strings.TrimSpace(string(data))
+		if _, err := objects.NewObjectID(value); err != nil && !strings.HasPrefix(value, "ref: ") {
+			rel, _ := filepath.Rel(commonDir, path)
+			f.report(fsck.Error, "ref", filepath.ToSlash(rel), "badRefContent", fmt.Sprintf("invalid content %q", value))
+		}
+		return nil
+	})
+	if err != nil {
+		return fmt.Errorf("failed to read refs: %w", err)
+	}
+	return nil
+}
+
+// checkRefTarget checks that the ref called name can point to id
+func (f *fsckRun) checkRefTarget(name string, id objects.ObjectID) {
+	objType, ok := f.types[id]
+	switch {
+	case !ok:
+		f.report(fsck.Error, "ref", name, "badRefTarget", fmt.Sprintf("points to missing object %s", id))
+	case strings.HasPrefix(name, "refs/heads/") && objType != objects.TypeCommit:
+		f.report(fsck.Error, "ref", name, "badRefType", fmt.Sprintf("points to %s %s, not a commit", objType, id))
+	}
+}
+
+// checkIndex checks that the index of the worktree at gitDir can be read
+// and that the files it stages exist
+func (f *fsckRun) checkIndex(gitDir string) {
+	indexPath := filepath.Join(gitDir, "index")
+	if _, err := os.Stat(indexPath); err != nil {
+		return
+	}
+	name, _ := filepath.Rel(f.repo.CommonDir(), indexPath)
+	idx := index.New()
+	if err := idx.ReadFromFile(indexPath); err != nil {
+		f.report(fsck.Error, "index", filepath.ToSlash(name), "badIndex", err.Error())
+		return
+	}
+	for _, entry := range idx.Entries() {
+		if entry.Mode != objects.ModeCommit {
+			if _, ok := f.types[entry.ID]; !ok {
+				f.reportMissing(objects.TypeBlob, entry.ID)
+			}
+		}
+	}
+}
+
+// reportMissing prints that id is missing, once
+func (f *fsckRun) reportMissing(objType objects.ObjectType, id objects.ObjectID) {
+	if f.missing[id] {
+		return
+	}
+	f.missing[id] = true
+	fmt.Fprintf(f.out, "missing %s %s\n", objType, id)
+	f.errors++
+}
+
+// checkConnectivity walks from roots through the objects found, reporting
+// links to objects that are missing, and returns those reached. History
+// ending at the commits of a shallow repository is not missing.
+func (f *fsckRun) checkConnectivity(roots []objects.ObjectID) (map[objects.ObjectID]bool, error) {
+	shallowIDs, err := f.repo.ShallowCommits()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read shallow commits: %w", err)
+	}
+	shallow := make(map[objects.ObjectID]bool, len(shallowIDs))
+	for _, id := range shallowIDs {
+		shallow[id] = true
+	}
+
+	reachable := make(map[objects.ObjectID]bool)
+	stack := append([]objects.ObjectID(nil), roots...)
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		// Roots that are missing were reported with their ref or index
+		if reachable[id] || f.types[id] == "" {
+			continue
+		}
+		reachable[id] = true
+
+		for _, link := range f.links[id] {
+			if _, ok := f.types[link.id]; ok {
+				stack = append(stack, link.id)
+				continue
+			}
+			if shallow[id] && link.objType == objects.TypeCommit {
+				continue
+			}
+			fmt.Fprintf(f.out, "broken link from %s %s to %s %s\n", f.types[id], id, link.objType, link.id)
+			f.reportMissing(link.objType, link.id)
+		}
+	}
+	return reachable, nil
+}
+
+// listUnreachable prints the objects not reachable from the roots: every
+// one with --unreachable, otherwise those no object points to
+func (f *fsckRun) listUnreachable(reachable map[objects.ObjectID]bool) {
+	var ids []objects.ObjectID
+	for id := range f.types {
+		if !reachable[id] {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool { return bytes.Compare(ids[i][:], ids[j][:]) < 0 })
+
+	for _, id := range ids {
+		switch {
+		case f.opts.unreachable:
+			fmt.Fprintf(f.out, "unreachable %s %s\n", f.types[id], id)
+		case !f.opts.noDangling && !f.referenced[id]:
+			fmt.Fprintf(f.out, "dangling %s %s\n", f.types[id], id)
+		}
+	}
+}
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+func runFsckArgs(args ...string) (string, error) {
+	cmd := newFsckCommand()
+	var stdout bytes.Buffer
+	cmd.SetOut(&stdout)
+	cmd.SetErr(&stdout)
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+	return stdout.String(), err
+}
+
+func TestFsck(t *testing.T) {
+	repo, _, ids := setupBisectRepo(t, 3)
+
+	out, err := runFsckArgs()
+	require.NoError(t, err)
+	assert.Empty(t, out)
+
+	// Unreachable objects are listed, dangling ones by default
+	blob, err := repo.Storage().WriteRawObject(objects.TypeBlob, []byte("lost\n"))
+	require.NoError(t, err)
+	head, err := repo.GetCommit(ids[2])
+	require.NoError(t, err)
+	lost, err := repo.Storage().WriteRawObject(objects.TypeCommit, []byte("tree "+head.Tree().String()+
+		"\nparent "+ids[2].String()+"\nauthor A <a@example.com> 1700000000 0100\ncommitter A <a@example.com> 1700000000 +0100\n\nlost\n"))
+	require.NoError(t, err)
+
+	out, err = runFsckArgs()
+	assert.ErrorContains(t, err, "found 1 error")
+	assert.Contains(t, out, "error in commit "+lost.String()+": badTimezone: invalid author/committer line - bad time zone\n")
+	assert.Contains(t, out, "dangling blob "+blob.String()+"\n")
+	assert.Contains(t, out, "dangling commit "+lost.String()+"\n")
+
+	out, _ = runFsckArgs("--unreachable")
+	assert.Contains(t, out, "unreachable blob "+blob.String()+"\n")
+	out, _ = runFsckArgs("--no-dangling")
+	assert.NotContains(t, out, "dangling")
+	require.NoError(t, os.Remove(repo.Storage().LooseObjectPath(lost)))
+
+	// A missing blob breaks the tree that holds it
+	first, err := repo.GetCommit(ids[0])
+	require.NoError(t, err)
+	tree, err := repo.GetTree(first.Tree())
+	require.NoError(t, err)
+	missing := tree.Entries()[0].ID
+	require.NoError(t, os.Remove(repo.Storage().LooseObjectPath(missing)))
+	require.NoError(t, os.WriteFile(filepath.Join(repo.GitDir(), "refs", "heads", "broken"), []byte("nonsense\n"), 0644))
+
+	out, err = runFsckArgs()
+	assert.ErrorContains(t, err, "found 2 errors")
+	assert.Contains(t, out, "error in ref refs/heads/broken: badRefContent: invalid content \"nonsense\"\n")
+	assert.Contains(t, out, "broken link from tree "+first.Tree().String()+" to blob "+missing.String()+"\n")
+	assert.Contains(t, out, "missing blob "+missing.String()+"\n")
+}
+
+func TestFsckPacked(t *testing.T) {
+	repo, _, _ := setupBisectRepo(t, 3)
+	gc := newGCCommand()
+	gc.SetOut(&bytes.Buffer{})
+	gc.SetErr(&bytes.Buffer{})
+	gc.SetArgs([]string{"--quiet"})
+	require.NoError(t, gc.Execute())
+
+	out, err := runFsckArgs()
+	require.NoError(t, err)
+	assert.Empty(t, out)
+
+	packs, err := filepath.Glob(filepath.Join(repo.Storage().PackDir(), "*.pack"))
+	require.NoError(t, err)
+	require.Len(t, packs, 1)
+	data, err := os.ReadFile(packs[0])
+	require.NoError(t, err)
+	data[len(data)-1] ^= 0xff
+	require.NoError(t, os.Chmod(packs[0], 0644))
+	require.NoError(t, os.WriteFile(packs[0], data, 0644))
+
+	out, err = runFsckArgs()
+	assert.Error(t, err)
+	assert.Contains(t, out, "error in pack "+filepath.Base(packs[0])+": badPack: pack checksum mismatch\n")
+}
+
+func TestFsckStrict(t *testing.T) {
+	repo, refManager, ids := setupBisectRepo(t, 1)
+	// A tag without a tagger is only a warning
+	tag, err := repo.Storage().WriteRawObject(objects.TypeTag, []byte("object "+ids[0].String()+"\ntype commit\ntag v1\n\nrelease\n"))
+	require.NoError(t, err)
+	require.NoError(t, refManager.UpdateRef("refs/tags/v1", tag))
+
+	out, err := runFsckArgs()
+	require.NoError(t, err)
+	assert.Equal(t, "warning in tag "+tag.String()+": missingTaggerEntry: invalid format - expected 'tagger' line\n", out)
+
+	out, err = runFsckArgs("--strict")
+	assert.Error(t, err)
+	assert.Contains(t, out, "error in tag "+tag.String()+": missingTaggerEntry: invalid format - expected 'tagger' line\n", out)
+}
@@ -0,0 +1,682 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fenilsonani/vcs/internal/core/config"
+	"github.com/fenilsonani/vcs/internal/core/index"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/packfile"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/internal/metrics"
+	"github.com/fenilsonani/vcs/internal/revparse"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+	"github.com/spf13/cobra"
+)
+
+const (
+	defaultPruneExpire  = "2.weeks.ago"
+	defaultReflogExpire = "90.days.ago"
+
+	// defaultGCAuto is the approximate number of loose objects, and
+	// defaultGCAutoPackLimit the number of packs, above which gc --auto
+	// collects garbage, as in Git
+	defaultGCAuto          = 6700
+	defaultGCAutoPackLimit = 50
+
+	// gcLockStale is how old a gc.pid lock must be before it is taken to
+	// belong to a gc that died
+	gcLockStale = 12 * time.Hour
+)
+
+// errGCRunning is returned when another gc holds the lock
+var errGCRunning = errors.New("gc is already running")
+
+// gcOptions holds the settings for a gc run
+type gcOptions struct {
+	prune      string
+	noPrune    bool
+	aggressive bool
+	quiet      bool
+	auto       bool
+}
+
+// gcStats summarizes what a gc run did
+type gcStats struct {
+	reflogExpired int
+	refsPacked    int
+	packed        int
+	deltas        int
+	pruned        int
+}
+
+func newGCCommand() *cobra.Command {
+	var opts gcOptions
+
+	cmd := &cobra.Command{
+		Use:   "gc",
+		Short: "Cleanup unnecessary files and optimize the local repository",
+		Long: `Repacks objects into a single packfile, prunes unreachable objects older
+than the grace period (gc.pruneExpire, default 2.weeks.ago), expires reflog
+entries older than gc.reflogExpire (default 90.days.ago) and packs refs.
+It then writes the commit-graph, unless gc.writeCommitGraph is false, and
+rewrites the multi-pack-index if there was one. Unless repack.writeBitmaps
+is false, the pack gets a reachability bitmap, which lets push and serving
+fetches count the objects to send without walking the history.
+
+With --auto, gc only runs when there are more than gc.auto loose objects
+(default 6700) or more than gc.autoPackLimit packs (default 50); setting
+either to 0 disables that check. commit, fetch and merge run gc --auto when
+they finish. Only one gc runs at a time per repository.
+
+The delta search uses pack.threads goroutines (default one per CPU) and
+keeps at most pack.windowMemory bytes of bases per thread. Inside a
+container with a cgroup memory limit, fewer threads are used so the search
+fits in half of the limit.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runGC(cmd, opts)
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.prune, "prune", "", "Prune unreachable objects older than date (default gc.pruneExpire)")
+	cmd.Flags().BoolVar(&opts.noPrune, "no-prune", false, "Do not prune any unreachable objects")
+	cmd.Flags().BoolVar(&opts.aggressive, "aggressive", false, "Spend more time searching for deltas")
+	cmd.Flags().BoolVarP(&opts.quiet, "quiet", "q", false, "Suppress progress reporting")
+	cmd.Flags().BoolVar(&opts.auto, "auto", false, "Only collect garbage when the repository needs it")
+
+	return cmd
+}
+
+func runGC(cmd *cobra.Command, opts gcOptions) error {
+	repoPath, err := findRepository()
+	if err != nil {
+		return fmt.Errorf("not a git repository: %w", err)
+	}
+
+	repo, err := openStoredRepository(repoPath)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	return gcRepository(cmd.OutOrStdout(), cmd.ErrOrStderr(), repo, opts)
+}
+
+// autoGC runs gc --auto after a command that adds objects. The command
+// itself succeeded, so problems are only reported.
+func autoGC(errOut io.Writer, repo *vcs.Repository) {
+	if readOnlyRepository(repo.GitDir()) {
+		return
+	}
+	if err := gcRepository(io.Discard, errOut, repo, gcOptions{auto: true, quiet: true}); err != nil {
+		fmt.Fprintf(errOut, "warning: auto gc failed: %v\n", err)
+	}
+}
+
+// gcRepository collects garbage in repo while holding the gc lock. With
+// opts.auto it does nothing unless autoGCNeeded says so, or while another
+// gc is running.
+func gcRepository(out, errOut io.Writer, repo *vcs.Repository, opts gcOptions) error {
+	config := loadConfigSections(repo.GitDir(), "gc")
+	now := time.Now()
+
+	if opts.auto {
+		needed, err := autoGCNeeded(repo, config)
+		if err != nil || !needed {
+			return err
+		}
+	}
+
+	unlock, err := lockGC(repo.CommonDir(), now)
+	if err != nil {
+		if opts.auto && errors.Is(err, errGCRunning) {
+			return nil
+		}
+		return err
+	}
+	defer unlock()
+
+	if opts.auto {
+		fmt.Fprintln(errOut, "Auto packing the repository for optimum performance.")
+	}
+
+	pruneSetting := opts.prune
+	if pruneSetting == "" {
+		pruneSetting = configOrDefault(config, "gc.pruneexpire", defaultPruneExpire)
+	}
+	if opts.noPrune {
+		pruneSetting = "never"
+	}
+	pruneCutoff, err := parseExpiry(pruneSetting, now)
+	if err != nil {
+		return err
+	}
+
+	reflogCutoff, err := parseExpiry(configOrDefault(config, "gc.reflogexpire", defaultReflogExpire), now)
+	if err != nil {
+		return err
+	}
+
+	stats, err := collectGarbage(repo, pruneCutoff, reflogCutoff, opts.aggressive)
+	if err == nil && gcWritesCommitGraph(repo.GitDir()) {
+		_, err = writeCommitGraph(repo)
+	}
+	recordRun(errOut, repo, metrics.KindMaintenance, "gc", now, err)
+	if err != nil {
+		return err
+	}
+
+	if !opts.quiet {
+		fmt.Fprintf(out, "Expired %d reflog entries\n", stats.reflogExpired)
+		fmt.Fprintf(out, "Packed %d refs\n", stats.refsPacked)
+		fmt.Fprintf(out, "Total %d (delta %d)\n", stats.packed, stats.deltas)
+		fmt.Fprintf(out, "Pruned %d unreachable objects\n", stats.pruned)
+	}
+
+	return nil
+}
+
+// autoGCNeeded reports whether gc --auto should run: when the loose objects,
+// estimated from one of the 256 fan-out directories as Git does, exceed
+// gc.auto, or the packs exceed gc.autoPackLimit
+func autoGCNeeded(repo *vcs.Repository, config map[string]string) (bool, error) {
+	limit, err := strconv.Atoi(configOrDefault(config, "gc.auto", strconv.Itoa(defaultGCAuto)))
+	if err != nil {
+		return false, fmt.Errorf("invalid gc.auto: %w", err)
+	}
+	if limit <= 0 {
+		return false, nil
+	}
+
+	entries, err := os.ReadDir(filepath.Join(repo.CommonDir(), "objects", "17"))
+	if err != nil && !os.IsNotExist(err) {
+		return false, fmt.Errorf("failed to count loose objects: %w", err)
+	}
+	loose := 0
+	for _, entry := range entries {
+		if len(entry.Name()) == 38 && !entry.IsDir() {
+			loose++
+		}
+	}
+	if loose > (limit+255)/256 {
+		return true, nil
+	}
+
+	packLimit, err := strconv.Atoi(configOrDefault(config, "gc.autopacklimit", strconv.Itoa(defaultGCAutoPackLimit)))
+	if err != nil {
+		return false, fmt.Errorf("invalid gc.autoPackLimit: %w", err)
+	}
+	if packLimit <= 0 {
+		return false, nil
+	}
+	packs, err := filepath.Glob(filepath.Join(repo.Storage().PackDir(), "*.pack"))
+	if err != nil {
+		return false, err
+	}
+	return len(packs) > packLimit, nil
+}
+
+// lockGC takes gc.pid so only one gc runs in the repository at a time. A
+// lock older than gcLockStale is left from a gc that died and is replaced.
+// The returned function releases the lock.
+func lockGC(gitDir string, now time.Time) (func(), error) {
+	lockPath := filepath.Join(gitDir, "gc.pid")
+	for attempt := 0; attempt < 2; attempt++ {
+		file, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err == nil {
+			hostname, _ := os.Hostname()
+			fmt.Fprintf(file, "%d %s\n", os.Getpid(), hostname)
+			file.Close()
+			return func() { os.Remove(lockPath) }, nil
+		}
+		if !os.IsExist(err) {
+			return nil, fmt.Errorf("failed to lock gc: %w", err)
+		}
+
+		info, err := os.Stat(lockPath)
+		if err == nil && now.Sub(info.ModTime()) < gcLockStale {
+			owner, _ := os.ReadFile(lockPath)
+			return nil, fmt.Errorf("%w (pid and host %s)", errGCRunning, strings.TrimSpace(string(owner)))
+		}
+		os.Remove(lockPath)
+	}
+	return nil, fmt.Errorf("%w", errGCRunning)
+}
+
+// configOrDefault returns config[key] or def when it is unset
+func configOrDefault(config map[string]string, key, def string) string {
+	if value, ok := config[key]; ok && value != "" {
+		return value
+	}
+	return def
+}
+
+// collectGarbage expires reflogs, packs refs, repacks every reachable object
+// into one pack and prunes unreachable objects last modified before
+// pruneCutoff. Unreachable objects from old packs that are still within the
+// grace period are kept as loose objects.
+func collectGarbage(repo *vcs.Repository, pruneCutoff, reflogCutoff time.Time, aggressive bool) (*gcStats, error) {
+	stats := &gcStats{}
+	storage := repo.Storage()
+	refManager := refs.NewRefManager(repo.GitDir())
+
+	for _, gitDir := range worktreeGitDirs(repo.CommonDir()) {
+		expired, err := expireReflogs(gitDir, reflogCutoff)
+		if err != nil {
+			return nil, err
+		}
+		stats.reflogExpired += expired
+	}
+
+	var err error
+	if stats.refsPacked, err = refManager.PackRefs(); err != nil {
+		return nil, fmt.Errorf("failed to pack refs: %w", err)
+	}
+
+	roots, err := gcRoots(repo, refManager)
+	if err != nil {
+		return nil, err
+	}
+	reachable, err := reachableObjects(repo, roots)
+	if err != nil {
+		return nil, err
+	}
+
+	packDir := packfile.NewPackDir(storage.PackDir())
+	packDir.SetWindowLimits(packWindowLimits(repo.GitDir()))
+	defer packDir.Close()
+	oldPacks, err := packDir.Packs()
+	if err != nil {
+		return nil, err
+	}
+
+	var toPack []*packfile.Object
+	for _, id := range reachable.order {
+		objType, data, err := repo.ReadRawObject(id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read object %s: %w", id, err)
+		}
+		toPack = append(toPack, &packfile.Object{ID: id, Type: objType, Data: data, Path: reachable.paths[id]})
+	}
+
+	// Unreachable objects in packs newer than the grace period are written
+	// loose so they get the same grace as unreachable loose objects
+	for _, pack := range oldPacks {
+		info, err := os.Stat(pack.Path())
+		if err != nil || !info.ModTime().After(pruneCutoff) {
+			continue
+		}
+		for _, id := range pack.IDs() {
+			if reachable.seen[id] {
+				continue
+			}
+			objType, data, err := pack.ReadObject(id)
+			if err != nil {
+				return nil, err
+			}
+			if _, err := storage.WriteLooseObject(objType, data); err != nil {
+				return nil, err
+			}
+			// Keep the pack's age so the grace period is not restarted
+			os.Chtimes(storage.LooseObjectPath(id), info.ModTime(), info.ModTime())
+		}
+	}
+
+	var packPath string
+	if len(toPack) > 0 {
+		opts := packWriterOptions(repo.GitDir())
+		if aggressive {
+			opts.Window = 250
+		}
+		var result *packfile.WriteResult
+		packPath, result, err = packfile.SavePack(storage.PackDir(), toPack, opts)
+		if err != nil {
+			return nil, err
+		}
+		stats.packed = len(result.Entries)
+		stats.deltas = result.Deltas
+
+		if gcWritesBitmaps(repo.GitDir()) {
+			if err := writePackBitmap(repo, packPath); err != nil {
+				return nil, err
+			}
+		} else {
+			// The same pack may come out of an earlier gc with a bitmap
+			os.Remove(strings.TrimSuffix(packPath, ".pack") + ".bitmap")
+		}
+	}
+
+	// The multi-pack-index covers packs about to be removed; it is written
+	// again over the packs left
+	midxPath := filepath.Join(storage.PackDir(), packfile.MultiPackIndexFile)
+	hadMidx := fileExists(midxPath)
+	if err := os.Remove(midxPath); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to remove multi-pack-index: %w", err)
+	}
+
+	for _, pack := range oldPacks {
+		if pack.Path() == packPath {
+			continue
+		}
+		pack.Close()
+		base := strings.TrimSuffix(pack.Path(), ".pack")
+		// Remove the index first so readers stop using the pack
+		if err := os.Remove(base + ".idx"); err != nil {
+			return nil, fmt.Errorf("failed to remove old pack: %w", err)
+		}
+		os.Remove(base + ".pack")
+		os.Remove(base + ".bitmap")
+	}
+	// Objects only the removed packs held may be gone now
+	storage.Cache().Invalidate()
+	if hadMidx {
+		if _, err := packfile.WriteMultiPackIndex(storage.PackDir()); err != nil {
+			return nil, err
+		}
+	}
+
+	loose, err := storage.LooseObjects()
+	if err != nil {
+		return nil, err
+	}
+	for _, id := range loose {
+		if reachable.seen[id] {
+			// Now stored in the new pack
+			if err := storage.RemoveLooseObject(id); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		info, err := os.Stat(storage.LooseObjectPath(id))
+		if err != nil || info.ModTime().After(pruneCutoff) {
+			continue
+		}
+		if err := storage.RemoveLooseObject(id); err != nil {
+			return nil, err
+		}
+		stats.pruned++
+	}
+
+	return stats, nil
+}
+
+// gcWritesBitmaps reports whether gc writes a bitmap for its pack, as it
+// does unless repack.writeBitmaps is false
+func gcWritesBitmaps(gitDir string) bool {
+	value, ok := loadConfig(gitDir).Get("repack.writeBitmaps")
+	if !ok {
+		return true
+	}
+	enabled, err := config.ParseBool(value)
+	return err != nil || enabled
+}
+
+// writePackBitmap writes the reachability bitmap of the pack at packPath,
+// which holds everything the refs reach
+func writePackBitmap(repo *vcs.Repository, packPath string) error {
+	tips, err := commitGraphTips(repo)
+	if err != nil {
+		return err
+	}
+	pack, err := packfile.OpenPack(packPath)
+	if err != nil {
+		return err
+	}
+	defer pack.Close()
+	_, err = packfile.WriteBitmap(pack, repo, tips)
+	return err
+}
+
+// gcRoots returns the objects that keep history alive: refs, and the HEAD
+// and other pseudo-refs, index, reflog entries and operation log of every
+// worktree
+func gcRoots(repo *vcs.Repository, refManager *refs.RefManager) ([]objects.ObjectID, error) {
+	allRefs, err := refManager.AllRefs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list refs: %w", err)
+	}
+
+	var roots []objects.ObjectID
+	for _, id := range allRefs {
+		roots = append(roots, id)
+	}
+
+	// Every worktree has its own HEAD, pseudo-refs, index and HEAD reflog
+	for _, gitDir := range worktreeGitDirs(repo.CommonDir()) {
+		worktreeRoots, err := worktreeGCRoots(gitDir)
+		if err != nil {
+			return nil, err
+		}
+		roots = append(roots, worktreeRoots...)
+
+		oplogRoots, err := oplogGCRoots(repo, gitDir)
+		if err != nil {
+			return nil, err
+		}
+		roots = append(roots, oplogRoots...)
+	}
+
+	return roots, nil
+}
+
+// worktreeGCRoots returns the objects kept alive by the repository
+// directory of one worktree: HEAD and other pseudo-refs, the index and the
+// reflogs under it
+func worktreeGCRoots(gitDir string) ([]objects.ObjectID, error) {
+	var roots []objects.ObjectID
+	if id, _, err := refs.NewRefManager(gitDir).HEAD(); err == nil {
+		roots = append(roots, id)
+	}
+
+	for _, name := range []string{"ORIG_HEAD", "MERGE_HEAD", "CHERRY_PICK_HEAD", "REVERT_HEAD", "FETCH_HEAD"} {
+		data, err := os.ReadFile(filepath.Join(gitDir, name))
+		if err != nil {
+			continue
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if len(line) >= 40 {
+				if id, err := objects.NewObjectID(line[:40]); err == nil {
+					roots = append(roots, id)
+				}
+			}
+		}
+	}
+
+	idx := index.New()
+	indexPath := filepath.Join(gitDir, "index")
+	if _, err := os.Stat(indexPath); err == nil {
+		if err := idx.ReadFromFile(indexPath); err != nil {
+			return nil, fmt.Errorf("failed to read index: %w", err)
+		}
+		for _, entry := range idx.Entries() {
+			roots = append(roots, entry.ID)
+		}
+	}
+
+	err := walkReflogs(gitDir, func(path string, lines []string) error {
+		for _, line := range lines {
+			fields := strings.Fields(line)
+			for _, field := range fields[:minInt(2, len(fields))] {
+				if id, err := objects.NewObjectID(field); err == nil && !id.IsZero() {
+					roots = append(roots, id)
+				}
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return roots, nil
+}
+
+// reachableSet is the result of a reachability walk
+type reachableSet struct {
+	seen  map[objects.ObjectID]bool
+	order []objects.ObjectID
+	// paths records the tree entry name of blobs and trees, used to group
+	// similar objects when searching for deltas
+	paths map[objects.ObjectID]string
+}
+
+// reachableObjects returns every object reachable from roots. Missing objects
+// are skipped, since shallow history ends at commits whose parents are absent.
+func reachableObjects(repo *vcs.Repository, roots []objects.ObjectID) (*reachableSet, error) {
+	set := &reachableSet{
+		seen:  make(map[objects.ObjectID]bool),
+		paths: make(map[objects.ObjectID]string),
+	}
+
+	stack := append([]objects.ObjectID(nil), roots...)
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if set.seen[id] || !repo.HasObject(id) {
+			continue
+		}
+
+		obj, err := repo.ReadObject(id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read object %s: %w", id, err)
+		}
+		set.seen[id] = true
+		set.order = append(set.order, id)
+
+		switch o := obj.(type) {
+		case *objects.Commit:
+			stack = append(stack, o.Tree())
+			stack = append(stack, o.Parents()...)
+		case *objects.Tree:
+			for _, entry := range o.Entries() {
+				// Submodule commits live in another repository
+				if entry.Mode == objects.ModeCommit {
+					continue
+				}
+				if _, ok := set.paths[entry.ID]; !ok {
+					set.paths[entry.ID] = entry.Name
+				}
+				stack = append(stack, entry.ID)
+			}
+		case *objects.Tag:
+			stack = append(stack, o.Object())
+		}
+	}
+
+	return set, nil
+}
+
+// expireReflogs drops reflog entries older than cutoff and returns how many
+// were removed
+func expireReflogs(gitDir string, cutoff time.Time) (int, error) {
+	expired := 0
+	err := walkReflogs(gitDir, func(path string, lines []string) error {
+		var kept []string
+		for _, line := range lines {
+			if when, ok := reflogTime(line); ok && !when.After(cutoff) {
+				expired++
+				continue
+			}
+			kept = append(kept, line)
+		}
+		if len(kept) == len(lines) {
+			return nil
+		}
+
+		content := strings.Join(kept, "\n")
+		if len(kept) > 0 {
+			content += "\n"
+		}
+		tmpPath := path + ".lock"
+		if err := os.WriteFile(tmpPath, []byte(content), 0644); err != nil {
+			return fmt.Errorf("failed to write reflog: %w", err)
+		}
+		return os.Rename(tmpPath, path)
+	})
+	return expired, err
+}
+
+// walkReflogs calls fn with the lines of every reflog under logs/
+func walkReflogs(gitDir string, fn func(path string, lines []string) error) error {
+	logsDir := filepath.Join(gitDir, "logs")
+	err := filepath.Walk(logsDir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if info.IsDir() || strings.HasSuffix(path, ".lock") {
+			return nil
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		var lines []string
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			if line := scanner.Text(); line != "" {
+				lines = append(lines, line)
+			}
+		}
+		file.Close()
+		if err := scanner.Err(); err != nil {
+			return fmt.Errorf("failed to read reflog %s: %w", path, err)
+		}
+
+		return fn(path, lines)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to process reflogs: %w", err)
+	}
+	return nil
+}
+
+// reflogTime extracts the timestamp of a reflog line of the form
+// "<old> <new> <name> <<email>> <unix> <tz>\t<message>"
+func reflogTime(line string) (time.Time, bool) {
+	if tab := strings.IndexByte(line, '\t'); tab >= 0 {
+		line = line[:tab]
+	}
+	end := strings.LastIndexByte(line, '>')
+	if end < 0 {
+		return time.Time{}, false
+	}
+	fields := strings.Fields(line[end+1:])
+	if len(fields) == 0 {
+		return time.Time{}, false
+	}
+	secs, err := strconv.ParseInt(fields[0], 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(secs, 0), true
+}
+
+// parseExpiry converts an expiry setting into a cutoff time. Objects and
+// entries at or before the cutoff expire. "never" returns the zero time.
+func parseExpiry(value string, now time.Time) (time.Time, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	switch value {
+	case "never", "false":
+		return time.Time{}, nil
+	case "now", "all":
+		return now, nil
+	}
+
+	when, err := revparse.ParseDate(value, now)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid expiry date: %s", value)
+	}
+	return when, nil
+}
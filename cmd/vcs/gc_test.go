@@ -0,0 +1,319 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/index"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// setupGCRepo creates a repository with three commits on main and changes
+// into it
+func setupGCRepo(t *testing.T) (*vcs.Repository, objects.ObjectID) {
+	repoPath := t.TempDir()
+	repo, err := vcs.Init(repoPath)
+	require.NoError(t, err)
+
+	sig := objects.Signature{Name: "Test", Email: "test@example.com", When: time.Now()}
+	var parents []objects.ObjectID
+	var head objects.ObjectID
+	for i := 1; i <= 3; i++ {
+		var content strings.Builder
+		for line := 0; line < 100; line++ {
+			fmt.Fprintf(&content, "line %d\n", line)
+		}
+		fmt.Fprintf(&content, "revision %d\n", i)
+
+		blob, err := repo.CreateBlob([]byte(content.String()))
+		require.NoError(t, err)
+		tree, err := repo.CreateTree([]objects.TreeEntry{{Mode: objects.ModeBlob, Name: "file.txt", ID: blob.ID()}})
+		require.NoError(t, err)
+		commit, err := repo.CreateCommit(tree.ID(), parents, sig, sig, fmt.Sprintf("commit %d\n", i))
+		require.NoError(t, err)
+		parents = []objects.ObjectID{commit.ID()}
+		head = commit.ID()
+	}
+
+	refManager := refs.NewRefManager(repo.GitDir())
+	require.NoError(t, refManager.UpdateRef("refs/heads/main", head))
+	require.NoError(t, refManager.SetHEAD("refs/heads/main"))
+
+	oldWd, _ := os.Getwd()
+	t.Cleanup(func() { os.Chdir(oldWd) })
+	require.NoError(t, os.Chdir(repoPath))
+
+	return repo, head
+}
+
+func runGCArgs(args ...string) (string, error) {
+	cmd := newGCCommand()
+	var buf bytes.Buffer
+	cmd.SetOut(&buf)
+	cmd.SetErr(&buf)
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+	return buf.String(), err
+}
+
+// writeAgedBlob writes an unreachable blob whose file is age old
+func writeAgedBlob(t *testing.T, repo *vcs.Repository, content string, age time.Duration) objects.ObjectID {
+	id, err := repo.WriteRawObject(objects.TypeBlob, []byte(content))
+	require.NoError(t, err)
+	when := time.Now().Add(-age)
+	require.NoError(t, os.Chtimes(repo.Storage().LooseObjectPath(id), when, when))
+	return id
+}
+
+func TestGCRepacksAndPrunes(t *testing.T) {
+	repo, head := setupGCRepo(t)
+
+	oldGarbage := writeAgedBlob(t, repo, "old garbage", 30*24*time.Hour)
+	newGarbage := writeAgedBlob(t, repo, "new garbage", time.Hour)
+
+	out, err := runGCArgs()
+	require.NoError(t, err)
+	assert.Contains(t, out, "Total 9 (delta")
+	assert.Contains(t, out, "Pruned 1 unreachable objects")
+
+	// Only the recent unreachable object is left loose
+	loose, err := repo.Storage().LooseObjects()
+	require.NoError(t, err)
+	assert.Equal(t, []objects.ObjectID{newGarbage}, loose)
+
+	packs, err := filepath.Glob(filepath.Join(repo.GitDir(), "objects", "pack", "pack-*.idx"))
+	require.NoError(t, err)
+	assert.Len(t, packs, 1)
+
+	// Refs are packed and history is readable from the pack
+	_, err = os.Stat(filepath.Join(repo.GitDir(), "refs", "heads", "main"))
+	assert.True(t, os.IsNotExist(err))
+
+	reopened, err := vcs.Open(repo.WorkDir())
+	require.NoError(t, err)
+	id, err := refs.NewRefManager(reopened.GitDir()).ResolveRef("main")
+	require.NoError(t, err)
+	assert.Equal(t, head, id)
+
+	commit, err := reopened.GetCommit(head)
+	require.NoError(t, err)
+	assert.Len(t, commit.Parents(), 1)
+	tree, err := reopened.GetTree(commit.Tree())
+	require.NoError(t, err)
+	blob, err := reopened.GetBlob(tree.Entries()[0].ID)
+	require.NoError(t, err)
+	assert.Contains(t, string(blob.Data()), "revision 3")
+	assert.False(t, reopened.HasObject(oldGarbage))
+
+	// A second run consolidates into a new pack and prunes everything
+	out, err = runGCArgs("--prune=now")
+	require.NoError(t, err)
+	assert.Contains(t, out, "Pruned 1 unreachable objects")
+	assert.False(t, reopened.HasObject(newGarbage))
+	assert.True(t, reopened.HasObject(head))
+}
+
+func TestGCKeepsObjectsReferencedByIndexAndReflog(t *testing.T) {
+	repo, head := setupGCRepo(t)
+
+	staged := writeAgedBlob(t, repo, "staged only", 30*24*time.Hour)
+	idx := index.New()
+	require.NoError(t, idx.Add(&index.Entry{Mode: objects.ModeBlob, ID: staged, Path: "staged.txt"}))
+	require.NoError(t, idx.WriteToFile(filepath.Join(repo.GitDir(), "index")))
+
+	logged := writeAgedBlob(t, repo, "only in reflog", 30*24*time.Hour)
+	logDir := filepath.Join(repo.GitDir(), "logs", "refs", "heads")
+	require.NoError(t, os.MkdirAll(logDir, 0755))
+	recent := time.Now().Unix()
+	expired := time.Now().AddDate(-1, 0, 0).Unix()
+	reflog := fmt.Sprintf("%s %s Test <test@example.com> %d +0000\tcommit: old\n", objects.ObjectID{}, head, expired) +
+		fmt.Sprintf("%s %s Test <test@example.com> %d +0000\treset: moving\n", head, logged, recent)
+	require.NoError(t, os.WriteFile(filepath.Join(logDir, "main"), []byte(reflog), 0644))
+
+	out, err := runGCArgs("--prune=now")
+	require.NoError(t, err)
+	assert.Contains(t, out, "Expired 1 reflog entries")
+
+	assert.True(t, repo.HasObject(staged), "objects in the index are kept")
+	assert.True(t, repo.HasObject(logged), "objects in the reflog are kept")
+
+	data, err := os.ReadFile(filepath.Join(logDir, "main"))
+	require.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(string(data), "\n"))
+	assert.Contains(t, string(data), "reset: moving")
+}
+
+func TestGCNoPrune(t *testing.T) {
+	repo, _ := setupGCRepo(t)
+	garbage := writeAgedBlob(t, repo, "garbage", 365*24*time.Hour)
+
+	out, err := runGCArgs("--no-prune", "--quiet")
+	require.NoError(t, err)
+	assert.Empty(t, out)
+	assert.True(t, repo.HasObject(garbage))
+}
+
+// writeLooseIn17 writes n blobs that land in the objects/17 fan-out
+// directory gc --auto samples
+func writeLooseIn17(t *testing.T, repo *vcs.Repository, n int) {
+	for i := 0; n > 0; i++ {
+		content := []byte(fmt.Sprintf("sample %d\n", i))
+		if objects.ComputeHash(objects.TypeBlob, content).String()[:2] != "17" {
+			continue
+		}
+		_, err := repo.WriteRawObject(objects.TypeBlob, content)
+		require.NoError(t, err)
+		n--
+	}
+}
+
+func TestGCAuto(t *testing.T) {
+	isolateConfig(t)
+	repo, _ := setupGCRepo(t)
+	writeLooseIn17(t, repo, 2)
+
+	// Well below the default threshold nothing happens
+	out, err := runGCArgs("--auto")
+	require.NoError(t, err)
+	assert.Empty(t, out)
+	packs, err := filepath.Glob(filepath.Join(repo.GitDir(), "objects", "pack", "*.pack"))
+	require.NoError(t, err)
+	assert.Empty(t, packs)
+
+	_, err = runConfigArgs("gc.auto", "0")
+	require.NoError(t, err)
+	needed, err := autoGCNeeded(repo, loadConfigSections(repo.GitDir(), "gc"))
+	require.NoError(t, err)
+	assert.False(t, needed, "gc.auto=0 disables gc --auto")
+
+	// Two objects in the sampled directory estimate more than 256 in all
+	_, err = runConfigArgs("gc.auto", "256")
+	require.NoError(t, err)
+	out, err = runGCArgs("--auto")
+	require.NoError(t, err)
+	assert.Contains(t, out, "Auto packing the repository for optimum performance.")
+	packs, err = filepath.Glob(filepath.Join(repo.GitDir(), "objects", "pack", "*.pack"))
+	require.NoError(t, err)
+	assert.Len(t, packs, 1)
+	assert.NoFileExists(t, filepath.Join(repo.GitDir(), "gc.pid"))
+}
+
+func TestGCAutoPackLimit(t *testing.T) {
+	isolateConfig(t)
+	repo, _ := setupGCRepo(t)
+
+	_, err := runConfigArgs("gc.autoPackLimit", "1")
+	require.NoError(t, err)
+	config := loadConfigSections(repo.GitDir(), "gc")
+
+	needed, err := autoGCNeeded(repo, config)
+	require.NoError(t, err)
+	assert.False(t, needed)
+
+	for _, name := range []string{"pack-a.pack", "pack-b.pack"} {
+		require.NoError(t, os.WriteFile(filepath.Join(repo.Storage().PackDir(), name), nil, 0644))
+	}
+	needed, err = autoGCNeeded(repo, config)
+	require.NoError(t, err)
+	assert.True(t, needed)
+}
+
+func TestGCLock(t *testing.T) {
+	isolateConfig(t)
+	repo, _ := setupGCRepo(t)
+	writeLooseIn17(t, repo, 2)
+	_, err := runConfigArgs("gc.auto", "1")
+	require.NoError(t, err)
+
+	lockPath := filepath.Join(repo.GitDir(), "gc.pid")
+	require.NoError(t, os.WriteFile(lockPath, []byte("12345 otherhost\n"), 0644))
+
+	_, err = runGCArgs()
+	assert.ErrorContains(t, err, "gc is already running (pid and host 12345 otherhost)")
+
+	// gc --auto quietly leaves the repository to the running gc
+	out, err := runGCArgs("--auto")
+	require.NoError(t, err)
+	assert.Empty(t, out)
+	loose, err := repo.Storage().LooseObjects()
+	require.NoError(t, err)
+	assert.NotEmpty(t, loose)
+
+	// A lock left by a gc that died long ago is taken over
+	old := time.Now().Add(-13 * time.Hour)
+	require.NoError(t, os.Chtimes(lockPath, old, old))
+	var errOut bytes.Buffer
+	autoGC(&errOut, repo)
+	assert.Equal(t, "Auto packing the repository for optimum performance.\n", errOut.String())
+	assert.NoFileExists(t, lockPath)
+}
+
+func TestParseExpiry(t *testing.T) {
+	now := time.Date(2024, 3, 15, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"never", time.Time{}},
+		{"now", now},
+		{"2.weeks.ago", now.AddDate(0, 0, -14)},
+		{"1.day.ago", now.AddDate(0, 0, -1)},
+		{"3 months ago", now.AddDate(0, -3, 0)},
+		{"90.days.ago", now.AddDate(0, 0, -90)},
+		{"2024-01-02", time.Date(2024, 1, 2, 0, 0, 0, 0, time.Local)},
+	}
+
+	for _, tt := range tests {
+		got, err := parseExpiry(tt.value, now)
+		require.NoError(t, err, tt.value)
+		assert.True(t, tt.want.Equal(got), "%s: got %v, want %v", tt.value, got, tt.want)
+	}
+
+	_, err := parseExpiry("whenever", now)
+	assert.Error(t, err)
+}
+
+func TestGCWritesBitmap(t *testing.T) {
+	repo, head := setupGCRepo(t)
+
+	_, err := runGCArgs("-q")
+	require.NoError(t, err)
+	bitmaps, err := filepath.Glob(filepath.Join(repo.Storage().PackDir(), "pack-*.bitmap"))
+	require.NoError(t, err)
+	require.Len(t, bitmaps, 1)
+
+	// Three commits, each with a tree and a blob
+	repo, err = vcs.Open(repo.Path())
+	require.NoError(t, err)
+	ids, err := objectsToSend(repo, []objects.ObjectID{head}, nil)
+	require.NoError(t, err)
+	assert.Len(t, ids, 9)
+
+	commit, err := repo.GetCommit(head)
+	require.NoError(t, err)
+	ids, err = objectsToSend(repo, []objects.ObjectID{head}, commit.Parents())
+	require.NoError(t, err)
+	assert.Len(t, ids, 3)
+
+	cfg, err := os.OpenFile(filepath.Join(repo.GitDir(), "config"), os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = cfg.WriteString("[repack]\n\twriteBitmaps = false\n")
+	require.NoError(t, err)
+	require.NoError(t, cfg.Close())
+	_, err = runGCArgs("-q")
+	require.NoError(t, err)
+	bitmaps, err = filepath.Glob(filepath.Join(repo.Storage().PackDir(), "pack-*.bitmap"))
+	require.NoError(t, err)
+	assert.Empty(t, bitmaps)
+}
@@ -0,0 +1,304 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/gitdir"
+	"github.com/fenilsonani/vcs/internal/core/packfile"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// globalOptions holds the options given before the command name, which
+// apply to repository discovery for every subcommand
+var globalOptions struct {
+	gitDir   string
+	workTree string
+	perf     bool
+	readOnly bool
+	// noReplaceObjects reads every object as stored, ignoring refs/replace
+	noReplaceObjects bool
+	// paginate pages the output of every command, and noPager of none
+	paginate, noPager bool
+}
+
+// globalOptionsHelp describes the global options in the root command help
+const globalOptionsHelp = `
+Global options (given before the command):
+  -C <path>              Run as if vcs was started in <path>
+  --git-dir=<path>       Set the path to the repository's git directory
+  --work-tree=<path>     Set the path to the working tree
+  --perf                 Report object cache statistics on exit
+  --read-only            Never write to the repository, as when it is
+                         on a read-only mount: commands that would
+                         write are refused
+  --no-replace-objects   Ignore the replacement refs of vcs replace
+  -p, --paginate         Page the output of any command
+  -P, --no-pager         Do not page the output
+
+Output to a terminal goes through $GIT_PAGER, core.pager, $PAGER or less
+for log, diff, grep and branch listings, or for <command> as pager.<command>
+says. It is colored as --color=always|never|auto, color.<command> or
+color.ui say, auto by default, in the colors color.<command>.<slot> sets,
+such as "bold red" or "#ff8000 black" for color.diff.old.
+
+Tracing (environment variables set to 1 for standard error, or to an
+absolute path to append to that file):
+  VCS_TRACE              Commands, hooks, HTTP requests and object reads
+                         and writes
+  VCS_TRACE_PACKET       Every pkt-line sent and received
+  VCS_TRACE_PERFORMANCE  How long commands and their phases take`
+
+// parseGlobalOptions consumes the leading global options in args, changing
+// directory for each -C, and returns the remaining arguments
+func parseGlobalOptions(args []string) ([]string, error) {
+	for len(args) > 0 {
+		arg := args[0]
+
+		name, value, hasValue := arg, "", false
+		if strings.HasPrefix(arg, "--") {
+			if idx := strings.Index(arg, "="); idx >= 0 {
+				name, value, hasValue = arg[:idx], arg[idx+1:], true
+			}
+		}
+
+		switch name {
+		case "-C", "--git-dir", "--work-tree":
+		case "--perf":
+			globalOptions.perf = true
+			args = args[1:]
+			continue
+		case "--read-only":
+			globalOptions.readOnly = true
+			args = args[1:]
+			continue
+		case "--no-replace-objects":
+			globalOptions.noReplaceObjects = true
+			args = args[1:]
+			continue
+		case "-p", "--paginate":
+			globalOptions.paginate = true
+			args = args[1:]
+			continue
+		case "-P", "--no-pager":
+			globalOptions.noPager = true
+			args = args[1:]
+			continue
+		default:
+			return args, nil
+		}
+
+		args = args[1:]
+		if !hasValue {
+			if len(args) == 0 {
+				return nil, fmt.Errorf("no directory given for %s", name)
+			}
+			value, args = args[0], args[1:]
+		}
+
+		switch name {
+		case "-C":
+			// An empty path leaves the directory unchanged, as in Git
+			if value == "" {
+				continue
+			}
+			if err := os.Chdir(value); err != nil {
+				return nil, fmt.Errorf("cannot change to '%s': %w", value, err)
+			}
+		case "--git-dir":
+			globalOptions.gitDir = value
+		case "--work-tree":
+			globalOptions.workTree = value
+		}
+	}
+
+	return args, nil
+}
+
+// discoverRepository returns the working tree and git directory of the
+// repository to operate on. An explicit --git-dir uses the current directory
+// as the working tree; otherwise the directory tree is searched upwards for
+// .git. --work-tree overrides the working tree in both cases.
+func discoverRepository() (workTree, gitDir string, err error) {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", "", err
+	}
+
+	if globalOptions.gitDir != "" {
+		gitDir, err = filepath.Abs(globalOptions.gitDir)
+		if err != nil {
+			return "", "", err
+		}
+		if info, err := os.Stat(gitDir); err != nil || !info.IsDir() {
+			return "", "", fmt.Errorf("not a git repository: '%s'", globalOptions.gitDir)
+		}
+		workTree = cwd
+	} else {
+		// Walk up directory tree looking for .git, which in a linked
+		// worktree is a file pointing to the repository directory
+		dir := cwd
+		for {
+			candidate := filepath.Join(dir, ".git")
+			if info, err := os.Stat(candidate); err == nil && info.IsDir() {
+				workTree, gitDir = dir, candidate
+				break
+			} else if err == nil {
+				if gitDir, err = gitdir.ReadGitFile(candidate); err != nil {
+					return "", "", err
+				}
+				workTree = dir
+				break
+			}
+
+			parent := filepath.Dir(dir)
+			if parent == dir {
+				return "", "", fmt.Errorf("not a git repository")
+			}
+			dir = parent
+		}
+	}
+
+	if globalOptions.workTree != "" {
+		workTree, err = filepath.Abs(globalOptions.workTree)
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	return workTree, gitDir, nil
+}
+
+// openRepository opens the repository whose working tree was returned by
+// findRepository, honouring --git-dir and --work-tree
+func openRepository(workTree string) (*vcs.Repository, error) {
+	var repo *vcs.Repository
+	var err error
+	if globalOptions.gitDir == "" && globalOptions.workTree == "" {
+		repo, err = vcs.Open(workTree)
+	} else {
+		var gitDir string
+		if _, gitDir, err = discoverRepository(); err != nil {
+			return nil, err
+		}
+		repo, err = vcs.OpenWithGitDir(workTree, gitDir)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Every open of the same repository in this process shares one cache
+	repo.Storage().SetCache(objectCache(repo.GitDir()))
+
+	if windowSize, limit := packWindowLimits(repo.GitDir()); windowSize > 0 {
+		packDir := packfile.NewPackDir(repo.Storage().PackDir())
+		packDir.SetWindowLimits(windowSize, limit)
+		repo.Storage().SetPackedObjects(packDir)
+	}
+	cfg := loadConfig(repo.GitDir())
+	setupPromisorFetcher(repo, cfg)
+	useReplaceRefs(repo, cfg)
+	return repo, nil
+}
+
+// openStoredRepository is openRepository for commands that copy, check or
+// prune objects, which read them as stored: a replacement read under the ID
+// it replaces would be written into packs, and the history it hides would
+// look unreachable.
+func openStoredRepository(workTree string) (*vcs.Repository, error) {
+	repo, err := openRepository(workTree)
+	if err != nil {
+		return nil, err
+	}
+	repo.Storage().SetReplacements(nil)
+	return repo, nil
+}
+
+// readOnlyRepository reports whether nothing may be written to the
+// repository in gitDir: with --read-only, or when gitDir is not writable.
+// Only query commands run then, skipping the index refresh, auto gc and
+// operation log, which are only bookkeeping.
+func readOnlyRepository(gitDir string) bool {
+	return globalOptions.readOnly || !writableDir(gitDir)
+}
+
+// queryCommands are the commands that only read the repository, and so
+// run when it is read-only. Those that also change it report whether
+// their arguments only ask to read.
+var queryCommands = map[string]func(cmd *cobra.Command, args []string) bool{
+	"archive": always, "blame": always, "cat-file": always, "check-ignore": always,
+	"describe": always, "diff": always, "diff-tree": always, "fast-export": always,
+	"for-each-ref": always, "format-patch": always, "fsck": always, "grep": always,
+	"help": always, "interpret-trailers": always, "log": always, "ls-files": always,
+	"ls-tree": always, "merge-base": always, "rev-list": always, "rev-parse": always,
+	"shortlog": always, "status": always, "timeline": always, "verify-commit": always,
+	"verify-tag": always,
+
+	"lfs ls-files": always, "notes list": always, "notes show": always,
+	"remote list": always, "remote show": always, "rerere status": always,
+	"stash list": always, "stash show": always, "submodule status": always,
+	"worktree list": always,
+
+	"hash-object": func(cmd *cobra.Command, args []string) bool {
+		return !flagSet(cmd, "write")
+	},
+	"remote": func(cmd *cobra.Command, args []string) bool {
+		return len(args) == 0
+	},
+	"branch": func(cmd *cobra.Command, args []string) bool {
+		if flagSet(cmd, "delete") || cmd.Flags().Changed("set-upstream-to") || flagSet(cmd, "unset-upstream") {
+			return false
+		}
+		return len(args) == 0 || flagSet(cmd, "list")
+	},
+	"tag": func(cmd *cobra.Command, args []string) bool {
+		if flagSet(cmd, "delete") {
+			return false
+		}
+		return len(args) == 0 || flagSet(cmd, "list") || flagSet(cmd, "verify") ||
+			cmd.Flags().Changed("lines") || cmd.Flags().Changed("contains") || cmd.Flags().Changed("points-at")
+	},
+	"config": func(cmd *cobra.Command, args []string) bool {
+		for _, name := range []string{"set", "add", "unset", "unset-all", "remove-section"} {
+			if flagSet(cmd, name) {
+				return false
+			}
+		}
+		return len(args) <= 1
+	},
+}
+
+// always is a query command whatever its arguments
+func always(*cobra.Command, []string) bool { return true }
+
+// flagSet reports whether the boolean flag name of cmd is set
+func flagSet(cmd *cobra.Command, name string) bool {
+	on, _ := cmd.Flags().GetBool(name)
+	return on
+}
+
+// refuseWrites fails cmd, before it starts, when it would write to a
+// read-only repository rather than let it write some files and not others
+func refuseWrites(cmd *cobra.Command, args []string) error {
+	if !cmd.HasParent() {
+		return nil
+	}
+	name := strings.TrimPrefix(cmd.CommandPath(), cmd.Root().Name()+" ")
+	if query, ok := queryCommands[name]; ok && query(cmd, args) {
+		return nil
+	}
+	if strings.HasPrefix(name, "completion") {
+		return nil
+	}
+	if !globalOptions.readOnly {
+		if gitDir := currentGitDir(); gitDir == "" || !readOnlyRepository(gitDir) {
+			return nil
+		}
+	}
+	cmd.SilenceUsage = true
+	return fmt.Errorf("'%s' would write to the repository, which is read-only", name)
+}
@@ -0,0 +1,174 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// resetGlobalOptions restores the working directory and global options when
+// the test finishes
+func resetGlobalOptions(t *testing.T) {
+	oldWd, _ := os.Getwd()
+	t.Cleanup(func() {
+		os.Chdir(oldWd)
+		globalOptions.gitDir = ""
+		globalOptions.workTree = ""
+		globalOptions.perf = false
+		globalOptions.readOnly = false
+	})
+}
+
+func TestParseGlobalOptions(t *testing.T) {
+	resetGlobalOptions(t)
+
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "a", "b"), 0755))
+	require.NoError(t, os.Chdir(root))
+
+	// -C is cumulative and options stop at the command name
+	rest, err := parseGlobalOptions([]string{"-C", "a", "-C", "b", "--git-dir=/x/.git", "--work-tree", "/x", "status", "-C", "ignored"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"status", "-C", "ignored"}, rest)
+	assert.Equal(t, "/x/.git", globalOptions.gitDir)
+	assert.Equal(t, "/x", globalOptions.workTree)
+
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	assert.Equal(t, filepath.Join(root, "a", "b"), wd)
+
+	// An empty -C is a no-op
+	rest, err = parseGlobalOptions([]string{"-C", "", "log"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"log"}, rest)
+
+	_, err = parseGlobalOptions([]string{"-C"})
+	assert.EqualError(t, err, "no directory given for -C")
+
+	_, err = parseGlobalOptions([]string{"-C", filepath.Join(root, "missing"), "status"})
+	assert.Error(t, err)
+}
+
+func TestDiscoverRepositoryWithGlobalOptions(t *testing.T) {
+	resetGlobalOptions(t)
+
+	root, err := filepath.EvalSymlinks(t.TempDir())
+	require.NoError(t, err)
+	repo, err := vcs.Init(filepath.Join(root, "repo"))
+	require.NoError(t, err)
+	other := filepath.Join(root, "other")
+	require.NoError(t, os.MkdirAll(filepath.Join(repo.WorkDir(), "sub"), 0755))
+	require.NoError(t, os.MkdirAll(other, 0755))
+
+	// Discovery walks up from a subdirectory
+	require.NoError(t, os.Chdir(filepath.Join(repo.WorkDir(), "sub")))
+	workTree, gitDir, err := discoverRepository()
+	require.NoError(t, err)
+	assert.Equal(t, repo.WorkDir(), workTree)
+	assert.Equal(t, repo.GitDir(), gitDir)
+
+	// --git-dir alone uses the current directory as the working tree
+	require.NoError(t, os.Chdir(other))
+	globalOptions.gitDir = repo.GitDir()
+	workTree, gitDir, err = discoverRepository()
+	require.NoError(t, err)
+	assert.Equal(t, other, workTree)
+	assert.Equal(t, repo.GitDir(), gitDir)
+
+	// --work-tree overrides it
+	globalOptions.workTree = repo.WorkDir()
+	opened, err := openRepository(repo.WorkDir())
+	require.NoError(t, err)
+	assert.Equal(t, repo.GitDir(), opened.GitDir())
+	assert.Equal(t, repo.WorkDir(), opened.WorkDir())
+
+	globalOptions.gitDir = filepath.Join(root, "missing")
+	_, _, err = discoverRepository()
+	assert.Error(t, err)
+}
+
+func TestParseGlobalOptionsPerf(t *testing.T) {
+	resetGlobalOptions(t)
+
+	rest, err := parseGlobalOptions([]string{"--perf", "log", "--perf"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"log", "--perf"}, rest)
+	assert.True(t, globalOptions.perf)
+}
+
+func TestReadOnlyQueries(t *testing.T) {
+	resetGlobalOptions(t)
+	setupTreeRepo(t, map[string]string{"a.txt": "a\n"})
+	stageFile(t, "b.txt", "b\n")
+	require.NoError(t, os.WriteFile("a.txt", []byte("changed\n"), 0644))
+
+	rest, err := parseGlobalOptions([]string{"--read-only", "status"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"status"}, rest)
+	assert.True(t, readOnlyRepository(".git"))
+
+	before := snapshotGitDir(t)
+
+	// Status would otherwise keep the HEAD files in the index, and diff
+	// hash working files into objects
+	assert.Equal(t, []string{" M a.txt", "A  b.txt"}, shortStatus(t))
+	_, err = runCommandArgs(newDiffCommand())
+	require.NoError(t, err)
+	_, err = runCommandArgs(newLogCommand())
+	require.NoError(t, err)
+	assert.Equal(t, before, snapshotGitDir(t))
+}
+
+// snapshotGitDir returns the contents of every file under .git
+func snapshotGitDir(t *testing.T) map[string]string {
+	files := make(map[string]string)
+	require.NoError(t, filepath.Walk(".git", func(path string, info os.FileInfo, err error) error {
+		if err == nil && !info.IsDir() {
+			data, err := os.ReadFile(path)
+			files[path] = string(data)
+			return err
+		}
+		return err
+	}))
+	return files
+}
+
+func TestReadOnlyRefusesWrites(t *testing.T) {
+	resetGlobalOptions(t)
+	setupTreeRepo(t, map[string]string{"a.txt": "a\n"})
+	require.NoError(t, os.WriteFile("b.txt", []byte("b\n"), 0644))
+	globalOptions.readOnly = true
+	before := snapshotGitDir(t)
+
+	run := func(args ...string) (string, error) {
+		root := &cobra.Command{Use: "vcs", PersistentPreRunE: refuseWrites}
+		root.AddCommand(newAddCommand(), newCommitCommand(), newBranchCommand(), newTagCommand(), newStashCommand())
+		return runCommandArgs(root, args...)
+	}
+
+	// Commands that write fail before writing anything
+	_, err := run("add", "b.txt")
+	assert.EqualError(t, err, "'add' would write to the repository, which is read-only")
+	_, err = run("commit", "-m", "x")
+	assert.EqualError(t, err, "'commit' would write to the repository, which is read-only")
+	_, err = run("branch", "topic")
+	assert.EqualError(t, err, "'branch' would write to the repository, which is read-only")
+	_, err = run("stash", "push")
+	assert.EqualError(t, err, "'stash push' would write to the repository, which is read-only")
+
+	// Those that only list run
+	_, err = run("branch")
+	require.NoError(t, err)
+	_, err = run("tag", "-l")
+	require.NoError(t, err)
+	_, err = run("stash", "list")
+	require.NoError(t, err)
+	assert.Equal(t, before, snapshotGitDir(t))
+}
@@ -0,0 +1,476 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/convert"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// grepOptions holds the flags of grep
+type grepOptions struct {
+	patterns          []string
+	ignoreCase        bool
+	invert            bool
+	wordRegexp        bool
+	fixedStrings      bool
+	extended          bool
+	lineNumber        bool
+	filesWithMatches  bool
+	filesWithoutMatch bool
+	count             bool
+	quiet             bool
+	cached            bool
+	threads           int
+	// colors paints names, line numbers and matches, nil for none
+	colors *palette
+}
+
+// grepFile is a file to search: the name it is shown by, and how to read
+// its content
+type grepFile struct {
+	name string
+	read func() ([]byte, error)
+}
+
+// grepResult is the outcome of searching a grepFile: what to print for it
+// and whether anything matched
+type grepResult struct {
+	out     []byte
+	matched bool
+	err     error
+}
+
+// errGrepDone stops the search once grep -q has seen a match
+var errGrepDone = errors.New("match found")
+
+func newGrepCommand() *cobra.Command {
+	var opts grepOptions
+
+	cmd := &cobra.Command{
+		Use:   "grep [flags] [-e] <pattern> [<tree-ish>...] [--] [<pathspec>...]",
+		Short: "Print lines matching a pattern in tracked files",
+		Long: `Searches the tracked files of the working tree for lines matching a
+regular expression and prints them as path:line. --cached searches what
+is staged instead, and each tree-ish given, such as a branch or commit,
+is searched as it was there, its matches shown as tree-ish:path:line.
+
+Patterns are Go regular expressions, so -E changes nothing; -F takes
+them as fixed strings. Several patterns given with -e match a line when
+any of them does. Binary files that match are reported without their
+lines.
+
+Only files under the current directory are searched unless pathspecs
+say otherwise. Files are searched with grep.threads goroutines, or one
+per CPU, and printed in order whatever the number. grep exits with
+status 1 when nothing matched.
+
+Output to a terminal is paged, with file names, line numbers and
+matches colored as color.grep.filename, lineNumber and match say.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			revs, pathspecs := args, []string(nil)
+			if dash := cmd.ArgsLenAtDash(); dash >= 0 {
+				revs, pathspecs = args[:dash], args[dash:]
+			}
+			if len(opts.patterns) == 0 {
+				if len(revs) == 0 {
+					return fmt.Errorf("no pattern given")
+				}
+				opts.patterns, revs = revs[:1], revs[1:]
+			}
+			matched, err := runGrep(cmd, revs, pathspecs, opts, cmd.ArgsLenAtDash() >= 0)
+			if err == nil && !matched {
+				cmd.SilenceErrors = true
+				cmd.SilenceUsage = true
+				return fmt.Errorf("no match")
+			}
+			return err
+		},
+	}
+
+	cmd.Flags().StringArrayVarP(&opts.patterns, "regexp", "e", nil, "Match <pattern>, which may be given more than once")
+	cmd.Flags().BoolVarP(&opts.ignoreCase, "ignore-case", "i", false, "Ignore case differences")
+	cmd.Flags().BoolVarP(&opts.invert, "invert-match", "v", false, "Select the lines that do not match")
+	cmd.Flags().BoolVarP(&opts.wordRegexp, "word-regexp", "w", false, "Match the pattern only at word boundaries")
+	cmd.Flags().BoolVarP(&opts.fixedStrings, "fixed-strings", "F", false, "Take the patterns as fixed strings")
+	cmd.Flags().BoolVarP(&opts.extended, "extended-regexp", "E", false, "Take the patterns as extended regular expressions")
+	cmd.Flags().BoolVarP(&opts.lineNumber, "line-number", "n", false, "Prefix each line with its number")
+	cmd.Flags().BoolVarP(&opts.filesWithMatches, "files-with-matches", "l", false, "Print only the names of files that match")
+	cmd.Flags().BoolVarP(&opts.filesWithoutMatch, "files-without-match", "L", false, "Print only the names of files that do not match")
+	cmd.Flags().BoolVarP(&opts.count, "count", "c", false, "Print the number of matching lines in each file")
+	cmd.Flags().BoolVarP(&opts.quiet, "quiet", "q", false, "Print nothing, only exit with 0 on a match")
+	cmd.Flags().BoolVar(&opts.cached, "cached", false, "Search the staged files instead of the working tree")
+	cmd.Flags().IntVar(&opts.threads, "threads", 0, "Search with <n> goroutines, 0 for grep.threads or one per CPU")
+	addColorFlags(cmd)
+
+	return cmd
+}
+
+// runGrep searches the files revs and pathspecs name and reports whether
+// any matched. Without a --, revs that do not resolve start the pathspecs.
+func runGrep(cmd *cobra.Command, revs, pathspecs []string, opts grepOptions, dashed bool) (bool, error) {
+	if opts.cached && len(revs) > 0 {
+		return false, fmt.Errorf("--cached cannot be used with a tree-ish")
+	}
+	re, err := compileGrepPattern(opts)
+	if err != nil {
+		return false, err
+	}
+
+	repoPath, err := findRepository()
+	if err != nil {
+		return false, fmt.Errorf("not a git repository: %w", err)
+	}
+	repo, err := openRepository(repoPath)
+	if err != nil {
+		return false, fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	type treeish struct {
+		name string
+		tree objects.ObjectID
+	}
+	var trees []treeish
+	for i, rev := range revs {
+		tree, _, _, err := resolveArchiveTree(repo, rev)
+		if err != nil {
+			if dashed {
+				return false, err
+			}
+			pathspecs = append(revs[i:], pathspecs...)
+			break
+		}
+		trees = append(trees, treeish{name: rev, tree: tree})
+	}
+
+	specs, err := cwdPathspecs(repoPath, pathspecs)
+	if err != nil {
+		return false, err
+	}
+
+	var files []grepFile
+	switch {
+	case len(trees) > 0:
+		for _, t := range trees {
+			if files, err = grepTreeFiles(repo, repoPath, t.tree, "", t.name+":", specs, files); err != nil {
+				return false, err
+			}
+		}
+	default:
+		if files, err = grepIndexFiles(repo, repoPath, specs, opts.cached); err != nil {
+			return false, err
+		}
+	}
+
+	threads := opts.threads
+	if threads <= 0 {
+		threads = grepThreads(repo.GitDir())
+	}
+	if !opts.quiet {
+		startPager(repo.GitDir(), "grep", true)
+	}
+	if opts.colors, err = newPalette(cmd, repo.GitDir(), "grep"); err != nil {
+		return false, err
+	}
+	out := bufio.NewWriter(cmd.OutOrStdout())
+	defer out.Flush()
+	matched := false
+	err = grepFiles(files, threads, func(f grepFile, data []byte) grepResult {
+		return grepData(f.name, data, re, opts)
+	}, func(result grepResult) error {
+		if result.matched {
+			matched = true
+			if opts.quiet {
+				return errGrepDone
+			}
+		}
+		_, err := out.Write(result.out)
+		return err
+	})
+	if err == errGrepDone {
+		err = nil
+	}
+	return matched, err
+}
+
+// compileGrepPattern joins the patterns of opts into one expression
+func compileGrepPattern(opts grepOptions) (*regexp.Regexp, error) {
+	alternatives := make([]string, len(opts.patterns))
+	for i, pattern := range opts.patterns {
+		if opts.fixedStrings {
+			pattern = regexp.QuoteMeta(pattern)
+		}
+		alternatives[i] = "(?:" + pattern + ")"
+	}
+	expr := strings.Join(alternatives, "|")
+	if opts.wordRegexp {
+		expr = `\b(?:` + expr + `)\b`
+	}
+	if opts.ignoreCase {
+		expr = "(?i)" + expr
+	}
+	re, err := regexp.Compile(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pattern: %w", err)
+	}
+	return re, nil
+}
+
+// grepThreads returns how many files to search at once in the repository
+// at gitDir: grep.threads, or one per CPU when it is zero or not set
+func grepThreads(gitDir string) int {
+	cfg := loadConfigSections(gitDir, "grep")
+	if value, ok := cfg["grep.threads"]; ok {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			fmt.Fprintf(os.Stderr, "warning: ignoring grep.threads: invalid number %q\n", value)
+		} else if n > 0 {
+			return n
+		}
+	}
+	return runtime.NumCPU()
+}
+
+// grepIndexFiles returns the tracked files under specs, read from the
+// working tree or, with cached, from the index. Files the working tree
+// does not have are skipped, and those marked skip-worktree are read from
+// the index.
+func grepIndexFiles(repo *vcs.Repository, repoPath string, specs []string, cached bool) ([]grepFile, error) {
+	idx, err := readIndex(repo)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var files []grepFile
+	for _, entry := range idx.Entries() {
+		entry := entry
+		if entry.Mode == objects.ModeCommit || seen[entry.Path] || !matchPathspecs(entry.Path, specs) {
+			continue
+		}
+		seen[entry.Path] = true
+
+		full := filepath.Join(repoPath, filepath.FromSlash(entry.Path))
+		name := cwdRelativePath(full)
+		if cached || entry.SkipWorktree {
+			files = append(files, grepFile{name: name, read: func() ([]byte, error) {
+				return readGrepBlob(repo, entry.ID, entry.Path)
+			}})
+			continue
+		}
+		info, err := os.Lstat(full)
+		if err != nil {
+			continue
+		}
+		read := func() ([]byte, error) { return os.ReadFile(full) }
+		if info.Mode()&os.ModeSymlink != 0 {
+			read = func() ([]byte, error) {
+				target, err := os.Readlink(full)
+				return []byte(target), err
+			}
+		}
+		files = append(files, grepFile{name: name, read: read})
+	}
+	return files, nil
+}
+
+// grepTreeFiles appends the files of the tree id, found at dir, that are
+// under specs, shown with prefix
+func grepTreeFiles(repo *vcs.Repository, repoPath string, id objects.ObjectID, dir, prefix string, specs []string, files []grepFile) ([]grepFile, error) {
+	tree, err := repo.GetTree(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tree %s: %w", id.Short(), err)
+	}
+	for _, entry := range tree.Entries() {
+		entry := entry
+		name := path.Join(dir, entry.Name)
+		switch entry.Mode {
+		case objects.ModeTree:
+			if !matchPathspecs(name, specs) && !specWithin(specs, name) {
+				continue
+			}
+			if files, err = grepTreeFiles(repo, repoPath, entry.ID, name, prefix, specs, files); err != nil {
+				return nil, err
+			}
+		case objects.ModeCommit:
+		default:
+			if !matchPathspecs(name, specs) {
+				continue
+			}
+			full := filepath.Join(repoPath, filepath.FromSlash(name))
+			files = append(files, grepFile{name: prefix + cwdRelativePath(full), read: func() ([]byte, error) {
+				return readGrepBlob(repo, entry.ID, name)
+			}})
+		}
+	}
+	return files, nil
+}
+
+// specWithin reports whether one of specs is inside the directory dir, so
+// that the directory has to be looked into
+func specWithin(specs []string, dir string) bool {
+	for _, spec := range specs {
+		if strings.HasPrefix(spec, dir+"/") {
+			return true
+		}
+	}
+	return false
+}
+
+// readGrepBlob reads the blob id, the content of p
+func readGrepBlob(repo *vcs.Repository, id objects.ObjectID, p string) ([]byte, error) {
+	blob, err := repo.GetBlob(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", p, err)
+	}
+	return blob.Data(), nil
+}
+
+// grepFiles searches files with up to threads goroutines, calling search
+// on the content of each. emit is called with the results in the order of
+// files, so the output is the same however many threads there are. The
+// first error, from reading or from emit, stops the search.
+func grepFiles(files []grepFile, threads int, search func(grepFile, []byte) grepResult, emit func(grepResult) error) error {
+	if threads < 1 {
+		threads = 1
+	}
+	if threads > len(files) {
+		threads = len(files)
+	}
+
+	// Each file has its own channel, so results are taken in order while
+	// later files are still being searched
+	results := make([]chan grepResult, len(files))
+	for i := range results {
+		results[i] = make(chan grepResult, 1)
+	}
+
+	next := make(chan int)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for w := 0; w < threads; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				data, err := files[i].read()
+				if err != nil {
+					results[i] <- grepResult{err: err}
+					continue
+				}
+				results[i] <- search(files[i], data)
+			}
+		}()
+	}
+	go func() {
+		defer close(next)
+		for i := range files {
+			select {
+			case next <- i:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	defer wg.Wait()
+	defer close(stop)
+
+	for i := range files {
+		result := <-results[i]
+		if result.err != nil {
+			return result.err
+		}
+		if err := emit(result); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// grepData searches data, the content of the file shown as name, and
+// returns what opts print for it
+func grepData(name string, data []byte, re *regexp.Regexp, opts grepOptions) grepResult {
+	binary := convert.IsBinary(data)
+	colors := opts.colors
+	separator := colors.paint("separator", ":")
+	var buf bytes.Buffer
+	count := 0
+	for n, rest := 1, data; len(rest) > 0; n++ {
+		line := rest
+		if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+			line, rest = rest[:i], rest[i+1:]
+		} else {
+			rest = nil
+		}
+		if re.Match(line) == opts.invert {
+			continue
+		}
+		count++
+		if opts.quiet || opts.filesWithMatches || opts.filesWithoutMatch || opts.count {
+			if !opts.count {
+				break
+			}
+			continue
+		}
+		if binary {
+			fmt.Fprintf(&buf, "Binary file %s matches\n", name)
+			break
+		}
+		buf.WriteString(colors.paint("filename", name))
+		buf.WriteString(separator)
+		if opts.lineNumber {
+			buf.WriteString(colors.paint("lineNumber", strconv.Itoa(n)))
+			buf.WriteString(separator)
+		}
+		writeGrepLine(&buf, line, re, opts)
+		buf.WriteByte('\n')
+	}
+
+	// -L matches the files it prints, those without a matching line
+	result := grepResult{matched: count > 0}
+	switch {
+	case opts.filesWithoutMatch:
+		result.matched = count == 0
+		if result.matched {
+			fmt.Fprintln(&buf, colors.paint("filename", name))
+		}
+	case opts.filesWithMatches && count > 0:
+		fmt.Fprintln(&buf, colors.paint("filename", name))
+	case opts.count && count > 0:
+		fmt.Fprintf(&buf, "%s%s%d\n", colors.paint("filename", name), separator, count)
+	}
+	result.out = buf.Bytes()
+	return result
+}
+
+// writeGrepLine writes a matching line, with the matches in it colored
+// when opts colors the output. The lines -v selects have none to color.
+func writeGrepLine(buf *bytes.Buffer, line []byte, re *regexp.Regexp, opts grepOptions) {
+	if opts.colors == nil || opts.invert {
+		buf.Write(line)
+		return
+	}
+	shown := 0
+	for _, loc := range re.FindAllIndex(line, -1) {
+		if loc[0] == loc[1] {
+			continue
+		}
+		buf.Write(line[shown:loc[0]])
+		buf.WriteString(opts.colors.paint("match", string(line[loc[0]:loc[1]])))
+		shown = loc[1]
+	}
+	buf.Write(line[shown:])
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// runGrepArgs runs grep with args and returns its lines and error
+func runGrepArgs(t *testing.T, args ...string) ([]string, error) {
+	cmd := newGrepCommand()
+	var stdout bytes.Buffer
+	cmd.SetOut(&stdout)
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+	out := strings.TrimRight(stdout.String(), "\n")
+	if out == "" {
+		return nil, err
+	}
+	return strings.Split(out, "\n"), err
+}
+
+func TestGrepWorkingTree(t *testing.T) {
+	setupTreeRepo(t, map[string]string{
+		"a.txt":       "hello world\nnothing here\nHello again\n",
+		"b.txt":       "goodbye\n",
+		"src/main.go": "package main\n// hello from main\n",
+		"bin.dat":     "hello\x00binary\n",
+	})
+
+	lines, err := runGrepArgs(t, "hello")
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"a.txt:hello world",
+		"Binary file bin.dat matches",
+		"src/main.go:// hello from main",
+	}, lines)
+
+	lines, err = runGrepArgs(t, "-n", "-i", "hello", "--", "a.txt")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a.txt:1:hello world", "a.txt:3:Hello again"}, lines)
+
+	lines, err = runGrepArgs(t, "-l", "-e", "goodbye", "-e", "package")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b.txt", "src/main.go"}, lines)
+
+	lines, err = runGrepArgs(t, "-c", "-i", "hello", "a.txt")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a.txt:2"}, lines)
+
+	lines, err = runGrepArgs(t, "-L", "hello")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b.txt"}, lines)
+
+	lines, err = runGrepArgs(t, "-F", "-w", "main", "src")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"src/main.go:package main", "src/main.go:// hello from main"}, lines)
+
+	// Edits in the working tree are searched, not what is committed
+	require.NoError(t, os.WriteFile("b.txt", []byte("goodbye hello\n"), 0644))
+	lines, err = runGrepArgs(t, "hello", "b.txt")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b.txt:goodbye hello"}, lines)
+
+	lines, err = runGrepArgs(t, "-q", "absent")
+	assert.Error(t, err)
+	assert.Empty(t, lines)
+}
+
+func TestGrepTreeish(t *testing.T) {
+	setupTreeRepo(t, map[string]string{
+		"a.txt":     "hello\n",
+		"dir/b.txt": "hello there\n",
+	})
+	require.NoError(t, os.WriteFile("a.txt", []byte("changed\n"), 0644))
+
+	lines, err := runGrepArgs(t, "hello", "HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"HEAD:a.txt:hello", "HEAD:dir/b.txt:hello there"}, lines)
+
+	lines, err = runGrepArgs(t, "--cached", "-l", "hello")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a.txt", "dir/b.txt"}, lines)
+
+	// Paths are shown and limited relative to the current directory
+	require.NoError(t, os.Chdir("dir"))
+	lines, err = runGrepArgs(t, "hello", "HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"HEAD:b.txt:hello there"}, lines)
+
+	lines, err = runGrepArgs(t, "-l", "hello", "HEAD", "--", "../a.txt")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"HEAD:../a.txt"}, lines)
+}
+
+func TestGrepFilesOrder(t *testing.T) {
+	var files []grepFile
+	for _, name := range []string{"a", "b", "c", "d", "e", "f"} {
+		data := []byte(name + " match\n")
+		files = append(files, grepFile{name: name, read: func() ([]byte, error) { return data, nil }})
+	}
+	re, err := compileGrepPattern(grepOptions{patterns: []string{"match"}})
+	require.NoError(t, err)
+
+	for _, threads := range []int{1, 3, 16} {
+		var out bytes.Buffer
+		err := grepFiles(files, threads, func(f grepFile, data []byte) grepResult {
+			return grepData(f.name, data, re, grepOptions{})
+		}, func(result grepResult) error {
+			out.Write(result.out)
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, "a:a match\nb:b match\nc:c match\nd:d match\ne:e match\nf:f match\n", out.String(), "threads %d", threads)
+	}
+}
@@ -1,10 +1,15 @@
 package main
 
 import (
+	"bufio"
 	"fmt"
 	"io"
 	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
 
+	"github.com/fenilsonani/vcs/internal/core/convert"
 	"github.com/fenilsonani/vcs/internal/core/objects"
 	"github.com/fenilsonani/vcs/pkg/vcs"
 	"github.com/spf13/cobra"
@@ -12,54 +17,85 @@ import (
 
 func newHashObjectCommand() *cobra.Command {
 	var (
-		write  bool
-		stdin  bool
-		objType string
+		write      bool
+		stdin      bool
+		stdinPaths bool
+		objType    string
+		attrPath   string
+		noFilters  bool
 	)
 	
 	cmd := &cobra.Command{
 		Use:   "hash-object [file...]",
 		Short: "Compute object ID and optionally creates a blob from a file",
-		Long:  "Computes the object ID value for an object with specified type and optionally writes it to the object database",
+		Long: `Computes the object ID value for an object with specified type and optionally writes it to the object database.
+
+Several files, named as arguments or one per line on stdin with
+--stdin-paths, are hashed in parallel, add.threads at a time or one per
+CPU, and their IDs printed in the order given.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			// Validate object type
 			if objType != "blob" {
 				return fmt.Errorf("only blob type is currently supported")
 			}
+			if stdinPaths && (stdin || len(args) > 0) {
+				return fmt.Errorf("--stdin-paths cannot be used with --stdin or file arguments")
+			}
 			
-			// Open repository if writing
+			// Open repository if writing; otherwise one is only needed for
+			// its attributes
 			var repo *vcs.Repository
-			if write {
-				var err error
-				repo, err = vcs.Open(".")
-				if err != nil {
+			repoPath, err := findRepository()
+			if err == nil {
+				repo, err = openRepository(repoPath)
+			}
+			if err != nil {
+				if write {
 					return fmt.Errorf("not in a vcs repository: %w", err)
 				}
+				repo = nil
+			}
+
+			// Content is converted as add would store it, by the
+			// attributes of --path or else of the file hashed
+			var conv *convert.Converter
+			if repo != nil && !noFilters {
+				conv = newConverter(repo, cmd.ErrOrStderr(), nil)
 			}
 			
 			// Process stdin or files
-			if stdin || len(args) == 0 {
-				id, err := hashObject(repo, os.Stdin, objects.TypeBlob, write)
+			if stdin || (len(args) == 0 && !stdinPaths) {
+				id, err := hashObject(repo, os.Stdin, objects.TypeBlob, write, conv, attrPath)
 				if err != nil {
 					return err
 				}
 				fmt.Fprintln(cmd.OutOrStdout(), id)
 			} else {
-				// Process each file
-				for _, path := range args {
-					file, err := os.Open(path)
-					if err != nil {
-						return fmt.Errorf("failed to open %s: %w", path, err)
+				paths := args
+				if stdinPaths {
+					if paths, err = readStdinPaths(cmd.InOrStdin()); err != nil {
+						return err
 					}
-					
-					id, err := hashObject(repo, file, objects.TypeBlob, write)
-					file.Close()
-					
-					if err != nil {
-						return fmt.Errorf("failed to hash %s: %w", path, err)
+				}
+
+				// Files are hashed in parallel, their IDs printed in order
+				jobs := make([]hashJob, len(paths))
+				for i, path := range paths {
+					jobs[i] = hashJob{file: path, path: attrPath}
+					if attrPath == "" && conv != nil {
+						jobs[i].path = hashObjectPath(repo, path)
 					}
-					
-					fmt.Fprintln(cmd.OutOrStdout(), id)
+				}
+				threads := runtime.NumCPU()
+				if repo != nil {
+					threads = hashThreads(repo.GitDir())
+				}
+				err := hashFiles(repo, conv, jobs, threads, write, func(i int, id objects.ObjectID) error {
+					_, err := fmt.Fprintln(cmd.OutOrStdout(), id)
+					return err
+				})
+				if err != nil {
+					return err
 				}
 			}
 			
@@ -69,16 +105,26 @@ func newHashObjectCommand() *cobra.Command {
 	
 	cmd.Flags().BoolVarP(&write, "write", "w", false, "Actually write the object into the object database")
 	cmd.Flags().BoolVar(&stdin, "stdin", false, "Read from stdin instead of from a file")
+	cmd.Flags().BoolVar(&stdinPaths, "stdin-paths", false, "Read the paths of the files to hash from stdin, one per line")
 	cmd.Flags().StringVarP(&objType, "type", "t", "blob", "Specify the type of object to be created")
+	cmd.Flags().StringVar(&attrPath, "path", "", "Hash the content as if it were at this path, for its attributes")
+	cmd.Flags().BoolVar(&noFilters, "no-filters", false, "Hash the content as is, ignoring attributes")
 	
 	return cmd
 }
 
-func hashObject(repo *vcs.Repository, reader io.Reader, objType objects.ObjectType, write bool) (objects.ObjectID, error) {
+// hashObject hashes the content of reader, cleaned by conv for path when
+// both are given
+func hashObject(repo *vcs.Repository, reader io.Reader, objType objects.ObjectType, write bool, conv *convert.Converter, path string) (objects.ObjectID, error) {
 	data, err := io.ReadAll(reader)
 	if err != nil {
 		return objects.ObjectID{}, fmt.Errorf("failed to read data: %w", err)
 	}
+	if conv != nil && path != "" {
+		if data, err = conv.Clean(path, data); err != nil {
+			return objects.ObjectID{}, err
+		}
+	}
 	
 	if repo != nil && write {
 		return repo.HashObject(data, objType, true)
@@ -87,4 +133,34 @@ func hashObject(repo *vcs.Repository, reader io.Reader, objType objects.ObjectTy
 	// Just compute hash without writing
 	obj := objects.NewBlob(data)
 	return obj.ID(), nil
-}
\ No newline at end of file
+}
+
+// hashObjectPath returns file relative to the top of repo's working tree,
+// or "" when it is outside it
+func hashObjectPath(repo *vcs.Repository, file string) string {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return ""
+	}
+	rel, err := filepath.Rel(repo.WorkDir(), abs)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return ""
+	}
+	return filepath.ToSlash(rel)
+}
+
+// readStdinPaths returns the paths listed one per line in r, as given to
+// --stdin-paths
+func readStdinPaths(r io.Reader) ([]string, error) {
+	var paths []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		if line := strings.TrimSuffix(scanner.Text(), "\r"); line != "" {
+			paths = append(paths, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read paths: %w", err)
+	}
+	return paths, nil
+}
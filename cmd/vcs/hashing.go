@@ -0,0 +1,115 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strconv"
+	"sync"
+
+	"github.com/fenilsonani/vcs/internal/core/convert"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// hashJob is a file to hash as a blob: where to read it, and its path in
+// the working tree, whose attributes say how it is converted, or "" to
+// hash it as is
+type hashJob struct {
+	file string
+	path string
+}
+
+// hashResult is the outcome of a hashJob
+type hashResult struct {
+	id  objects.ObjectID
+	err error
+}
+
+// hashThreads returns how many files to hash at once in the repository at
+// gitDir: add.threads, or one per CPU when it is zero or not set
+func hashThreads(gitDir string) int {
+	cfg := loadConfigSections(gitDir, "add")
+	if value, ok := cfg["add.threads"]; ok {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			fmt.Fprintf(os.Stderr, "warning: ignoring add.threads: invalid number %q\n", value)
+		} else if n > 0 {
+			return n
+		}
+	}
+	return runtime.NumCPU()
+}
+
+// hashFiles hashes the files of jobs with up to threads goroutines,
+// converting them by conv and writing the blobs to repo when write is set.
+// emit is called with each ID in the order of jobs, so that what it does is
+// the same however many threads there are. The first error, from hashing
+// or from emit, stops the work.
+func hashFiles(repo *vcs.Repository, conv *convert.Converter, jobs []hashJob, threads int, write bool, emit func(i int, id objects.ObjectID) error) error {
+	if threads < 1 {
+		threads = 1
+	}
+	if threads > len(jobs) {
+		threads = len(jobs)
+	}
+
+	// Each job has its own channel, so results are taken in order while
+	// later files are still being hashed
+	results := make([]chan hashResult, len(jobs))
+	for i := range results {
+		results[i] = make(chan hashResult, 1)
+	}
+
+	next := make(chan int)
+	stop := make(chan struct{})
+	var wg sync.WaitGroup
+	for w := 0; w < threads; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				id, err := hashFile(repo, conv, jobs[i], write)
+				results[i] <- hashResult{id: id, err: err}
+			}
+		}()
+	}
+	go func() {
+		defer close(next)
+		for i := range jobs {
+			select {
+			case next <- i:
+			case <-stop:
+				return
+			}
+		}
+	}()
+	defer wg.Wait()
+	defer close(stop)
+
+	for i := range jobs {
+		result := <-results[i]
+		if result.err != nil {
+			return result.err
+		}
+		if err := emit(i, result.id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// hashFile hashes the file of job as hash-object would
+func hashFile(repo *vcs.Repository, conv *convert.Converter, job hashJob, write bool) (objects.ObjectID, error) {
+	file, err := os.Open(job.file)
+	if err != nil {
+		return objects.ObjectID{}, fmt.Errorf("failed to open %s: %w", job.file, err)
+	}
+	defer file.Close()
+
+	id, err := hashObject(repo, file, objects.TypeBlob, write, conv, job.path)
+	if err != nil {
+		return objects.ObjectID{}, fmt.Errorf("failed to hash %s: %w", job.file, err)
+	}
+	return id, nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/index"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+func TestHashFilesKeepsOrder(t *testing.T) {
+	dir := t.TempDir()
+	var jobs []hashJob
+	var want []objects.ObjectID
+	for i := 0; i < 50; i++ {
+		file := filepath.Join(dir, fmt.Sprintf("file%02d", i))
+		content := []byte(strings.Repeat(fmt.Sprintf("line %d\n", i), i))
+		require.NoError(t, os.WriteFile(file, content, 0644))
+		jobs = append(jobs, hashJob{file: file})
+		want = append(want, objects.NewBlob(content).ID())
+	}
+
+	for _, threads := range []int{0, 1, 4, 100} {
+		var got []objects.ObjectID
+		err := hashFiles(nil, nil, jobs, threads, false, func(i int, id objects.ObjectID) error {
+			assert.Equal(t, len(got), i)
+			got = append(got, id)
+			return nil
+		})
+		require.NoError(t, err)
+		assert.Equal(t, want, got, "threads = %d", threads)
+	}
+
+	// A file that cannot be read stops the work, after the IDs of the
+	// files before it
+	jobs[10].file = filepath.Join(dir, "missing")
+	emitted := 0
+	err := hashFiles(nil, nil, jobs, 4, false, func(i int, id objects.ObjectID) error {
+		emitted++
+		return nil
+	})
+	assert.ErrorContains(t, err, "failed to open")
+	assert.Equal(t, 10, emitted)
+}
+
+func TestHashThreadsConfig(t *testing.T) {
+	isolateConfig(t)
+	repo, err := vcs.Init(t.TempDir())
+	require.NoError(t, err)
+	assert.Equal(t, runtime.NumCPU(), hashThreads(repo.GitDir()))
+
+	f, err := os.OpenFile(filepath.Join(repo.GitDir(), "config"), os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteString("[add]\n\tthreads = 3\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+	assert.Equal(t, 3, hashThreads(repo.GitDir()))
+}
+
+func TestAddHashesInParallel(t *testing.T) {
+	repo, _ := setupConfigRepo(t)
+	_, err := runConfigArgs("add.threads", "4")
+	require.NoError(t, err)
+
+	contents := make(map[string]string)
+	for i := 0; i < 40; i++ {
+		name := fmt.Sprintf("dir%d/file%02d.txt", i%3, i)
+		contents[name] = fmt.Sprintf("content %d\n", i)
+		require.NoError(t, os.MkdirAll(filepath.Dir(name), 0755))
+		require.NoError(t, os.WriteFile(name, []byte(contents[name]), 0644))
+	}
+	_, err = runCommandArgs(newAddCommand(), "--all")
+	require.NoError(t, err)
+
+	idx := index.New()
+	require.NoError(t, idx.ReadFromFile(filepath.Join(repo.GitDir(), "index")))
+	entries := idx.Entries()
+	require.Len(t, entries, len(contents))
+	for _, entry := range entries {
+		assert.Equal(t, objects.NewBlob([]byte(contents[entry.Path])).ID(), entry.ID, entry.Path)
+		_, err := repo.GetBlob(entry.ID)
+		assert.NoError(t, err, entry.Path)
+	}
+}
+
+func TestHashObjectStdinPaths(t *testing.T) {
+	repo, _ := setupConfigRepo(t)
+	var paths []string
+	var want strings.Builder
+	for i := 0; i < 10; i++ {
+		name := fmt.Sprintf("file%d.txt", i)
+		content := []byte(fmt.Sprintf("content %d\n", i))
+		require.NoError(t, os.WriteFile(name, content, 0644))
+		paths = append(paths, name)
+		fmt.Fprintln(&want, objects.NewBlob(content).ID())
+	}
+
+	cmd := newHashObjectCommand()
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+	cmd.SetIn(strings.NewReader(strings.Join(paths, "\n") + "\n"))
+	cmd.SetArgs([]string{"-w", "--stdin-paths"})
+	require.NoError(t, cmd.Execute())
+	assert.Equal(t, want.String(), out.String())
+
+	id, err := objects.NewObjectID(strings.Fields(out.String())[3])
+	require.NoError(t, err)
+	_, err = repo.GetBlob(id)
+	assert.NoError(t, err)
+
+	_, err = runCommandArgs(newHashObjectCommand(), "--stdin-paths", "file1.txt")
+	assert.ErrorContains(t, err, "--stdin-paths cannot be used")
+}
@@ -4,6 +4,7 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 )
 
 // ensureDir creates a directory if it doesn't exist
@@ -56,4 +57,53 @@ func readFile(path string) ([]byte, error) {
 		return nil, fmt.Errorf("failed to read %s: %w", path, err)
 	}
 	return data, nil
-}
\ No newline at end of file
+}
+// repoRelativePath converts a path given on the command line into a path
+// relative to the repository root at repoPath, with forward slashes
+func repoRelativePath(repoPath, path string) (string, error) {
+	absPath, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	relPath, err := filepath.Rel(repoPath, absPath)
+	if err != nil || relPath == "." || strings.HasPrefix(relPath, "..") {
+		return "", fmt.Errorf("'%s' is outside repository at '%s'", path, repoPath)
+	}
+	return filepath.ToSlash(relPath), nil
+}
+
+// cwdPathspecs converts the pathspecs given on the command line into paths
+// relative to the repository root at repoPath, "." for the root itself.
+// Without any only the current directory is covered, as by grep and
+// ls-files.
+func cwdPathspecs(repoPath string, args []string) ([]string, error) {
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+	var specs []string
+	for _, arg := range args {
+		spec, err := pathspecPath(repoPath, arg)
+		if err != nil {
+			return nil, err
+		}
+		if spec == "" {
+			spec = "."
+		}
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// cwdRelativePath returns the path full relative to the current directory,
+// with forward slashes, as paths in the working tree are shown
+func cwdRelativePath(full string) string {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return filepath.ToSlash(full)
+	}
+	rel, err := filepath.Rel(cwd, full)
+	if err != nil {
+		return filepath.ToSlash(full)
+	}
+	return filepath.ToSlash(rel)
+}
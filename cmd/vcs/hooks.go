@@ -0,0 +1,212 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fenilsonani/vcs/internal/core/gitdir"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/serve"
+	"github.com/fenilsonani/vcs/internal/trace"
+)
+
+// commitHooks are the hooks run by commit that commit --no-verify skips.
+// prepare-commit-msg and post-commit run regardless, as in Git.
+var commitHooks = []string{"pre-commit", "commit-msg"}
+
+// noVerifyTrailer is the trailer commit.recordNoVerify adds to commits made
+// with --no-verify, naming the hooks that were skipped
+const noVerifyTrailer = "No-Verify"
+
+// hooksDir returns the directory hooks are run from: core.hooksPath,
+// relative to the working tree, or the hooks directory of the repository,
+// which linked worktrees share
+func hooksDir(workTree, gitDir string) string {
+	dir, ok := loadConfig(gitDir).Get("core.hooksPath")
+	if !ok || dir == "" {
+		return filepath.Join(gitdir.CommonDir(gitDir), "hooks")
+	}
+	if strings.HasPrefix(dir, "~/") {
+		if home, err := os.UserHomeDir(); err == nil {
+			dir = filepath.Join(home, dir[2:])
+		}
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(workTree, dir)
+	}
+	return dir
+}
+
+// findHook returns the path of the hook called name, or "" when it is not
+// installed. As in Git, a hook that is not executable is ignored.
+func findHook(workTree, gitDir, name string) string {
+	path := filepath.Join(hooksDir(workTree, gitDir), name)
+	info, err := os.Stat(path)
+	if err != nil || info.IsDir() || info.Mode()&0111 == 0 {
+		return ""
+	}
+	return path
+}
+
+// installedHooks returns those of names that are installed
+func installedHooks(workTree, gitDir string, names []string) []string {
+	var installed []string
+	for _, name := range names {
+		if findHook(workTree, gitDir, name) != "" {
+			installed = append(installed, name)
+		}
+	}
+	return installed
+}
+
+// runHook runs the hook called name, if installed, in the working tree with
+// its output sent to out. A hook exiting non-zero aborts the operation.
+func runHook(out io.Writer, workTree, gitDir, name string, args ...string) error {
+	return runHookWithInput(out, nil, workTree, gitDir, name, args...)
+}
+
+// runHookWithInput runs a hook like runHook with stdin as its standard
+// input. Hooks Git gives no input to read from the null device.
+func runHookWithInput(out io.Writer, stdin io.Reader, workTree, gitDir, name string, args ...string) error {
+	return runHookWithEnv(out, stdin, nil, workTree, gitDir, name, args...)
+}
+
+// runHookWithEnv runs a hook like runHookWithInput with env added to its
+// environment
+func runHookWithEnv(out io.Writer, stdin io.Reader, env []string, workTree, gitDir, name string, args ...string) error {
+	path := findHook(workTree, gitDir, name)
+	if path == "" {
+		return nil
+	}
+
+	absGitDir, err := filepath.Abs(gitDir)
+	if err != nil {
+		return fmt.Errorf("failed to run %s hook: %w", name, err)
+	}
+	cmd := exec.Command(path, args...)
+	cmd.Dir = workTree
+	cmd.Env = append(os.Environ(), "GIT_DIR="+absGitDir, "GIT_INDEX_FILE="+filepath.Join(absGitDir, "index"))
+	cmd.Env = append(cmd.Env, env...)
+	cmd.Stdin = stdin
+	cmd.Stdout = out
+	cmd.Stderr = out
+	trace.General.Printf("run_command: %s hook: %s %s", name, path, strings.Join(args, " "))
+	start := time.Now()
+	err = cmd.Run()
+	trace.Performance.Since(start, "%s hook", name)
+	if err != nil {
+		if _, ok := err.(*exec.ExitError); ok {
+			return fmt.Errorf("%s hook failed: %w", name, err)
+		}
+		return fmt.Errorf("failed to run %s hook: %w", name, err)
+	}
+	return nil
+}
+
+// runPostHook runs a hook that is told about an operation already done,
+// such as post-commit. It cannot undo the operation, so its failure is only
+// reported.
+func runPostHook(out io.Writer, workTree, gitDir, name string, args ...string) {
+	if err := runHook(out, workTree, gitDir, name, args...); err != nil {
+		fmt.Fprintf(out, "warning: %v\n", err)
+	}
+}
+
+// runPostCheckoutHook runs the post-checkout hook after HEAD moved from
+// oldID to newID, with branch set when a branch was checked out rather than
+// files. Unborn HEADs are given as the null ID.
+func runPostCheckoutHook(out io.Writer, workTree, gitDir string, oldID, newID objects.ObjectID, branch bool) {
+	flag := "0"
+	if branch {
+		flag = "1"
+	}
+	runPostHook(out, workTree, gitDir, "post-checkout", oldID.String(), newID.String(), flag)
+}
+
+// receiveHooks runs the pre-receive, update and post-receive hooks of a
+// repository as pushes to it are applied, with their output sent to out.
+// pre-receive and post-receive read "<old> <new> <ref>" lines; update is
+// given the ref, old and new IDs as arguments. As in Git, pre-receive and
+// post-receive find the push options in GIT_PUSH_OPTION_COUNT and
+// GIT_PUSH_OPTION_<n>.
+func receiveHooks(out io.Writer, workTree, gitDir string) serve.Hooks {
+	input := func(updates []serve.RefUpdate) io.Reader {
+		var b strings.Builder
+		for _, u := range updates {
+			fmt.Fprintf(&b, "%s %s %s\n", u.Old, u.New, u.Ref)
+		}
+		return strings.NewReader(b.String())
+	}
+	optionEnv := func(updates []serve.RefUpdate) []string {
+		if len(updates) == 0 || updates[0].PushOptions == nil {
+			return nil
+		}
+		options := updates[0].PushOptions
+		env := []string{fmt.Sprintf("GIT_PUSH_OPTION_COUNT=%d", len(options))}
+		for i, option := range options {
+			env = append(env, fmt.Sprintf("GIT_PUSH_OPTION_%d=%s", i, option))
+		}
+		return env
+	}
+
+	return serve.Hooks{
+		PreReceive: func(updates []serve.RefUpdate) error {
+			if err := runHookWithEnv(out, input(updates), optionEnv(updates), workTree, gitDir, "pre-receive"); err != nil {
+				return fmt.Errorf("pre-receive hook declined")
+			}
+			return nil
+		},
+		Update: func(u serve.RefUpdate) error {
+			if err := runHook(out, workTree, gitDir, "update", u.Ref, u.Old.String(), u.New.String()); err != nil {
+				return fmt.Errorf("hook declined")
+			}
+			return nil
+		},
+		PostReceive: func(updates []serve.RefUpdate) {
+			if err := runHookWithEnv(out, input(updates), optionEnv(updates), workTree, gitDir, "post-receive"); err != nil {
+				fmt.Fprintf(out, "warning: %v\n", err)
+			}
+		},
+	}
+}
+
+// noVerifyEvent is the JSON line appended to commit.noVerifyLog for each
+// commit made with --no-verify
+type noVerifyEvent struct {
+	Event  string   `json:"event"`
+	Commit string   `json:"commit"`
+	Ref    string   `json:"ref"`
+	Hooks  []string `json:"hooks"`
+	Author string   `json:"author"`
+	Time   string   `json:"time"`
+}
+
+// logNoVerify appends event, stamped with the current time, to the log
+// file at path, relative to gitDir
+func logNoVerify(gitDir, path string, event noVerifyEvent) error {
+	event.Event = "no-verify"
+	event.Time = time.Now().UTC().Format(time.RFC3339)
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(gitDir, path)
+	}
+	line, err := json.Marshal(event)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", path, err)
+	}
+	if _, err := f.Write(append(line, '\n')); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return f.Close()
+}
@@ -0,0 +1,204 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/internal/core/trailer"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// writeHook installs an executable hook running script
+func writeHook(t *testing.T, repo *vcs.Repository, name, script string) {
+	path := filepath.Join(repo.GitDir(), "hooks", name)
+	require.NoError(t, os.WriteFile(path, []byte("#!/bin/sh\n"+script), 0755))
+}
+
+// stageAndCommit adds a file and commits it with args, returning the
+// message of the new HEAD commit
+func stageAndCommit(t *testing.T, repo *vcs.Repository, file string, args ...string) (string, error) {
+	require.NoError(t, os.WriteFile(file, []byte(file+"\n"), 0644))
+	add := newAddCommand()
+	add.SetArgs([]string{file})
+	require.NoError(t, add.Execute())
+
+	_, err := captureStdout(t, func() error {
+		cmd := newCommitCommand()
+		cmd.SetErr(os.Stderr)
+		cmd.SetArgs(args)
+		return cmd.Execute()
+	})
+	if err != nil {
+		return "", err
+	}
+	head, _, err := refs.NewRefManager(repo.GitDir()).HEAD()
+	require.NoError(t, err)
+	obj, err := repo.ReadObject(head)
+	require.NoError(t, err)
+	return obj.(*objects.Commit).Message(), nil
+}
+
+func TestCommitHooks(t *testing.T) {
+	repo, _ := setupConfigRepo(t)
+
+	writeHook(t, repo, "pre-commit", "test ! -e block\n")
+	writeHook(t, repo, "commit-msg", `printf '\nReviewed-by: hook\n' >> "$1"`+"\n")
+	message, err := stageAndCommit(t, repo, "a.txt", "-m", "first")
+	require.NoError(t, err)
+	assert.Equal(t, "first\n\nReviewed-by: hook\n", message)
+
+	require.NoError(t, os.WriteFile("block", nil, 0644))
+	_, err = stageAndCommit(t, repo, "b.txt", "-m", "second")
+	assert.ErrorContains(t, err, "pre-commit hook failed")
+
+	// Skipped hooks are not recorded unless configured
+	message, err = stageAndCommit(t, repo, "b.txt", "--no-verify", "-m", "second")
+	require.NoError(t, err)
+	assert.Equal(t, "second\n", message)
+}
+
+func TestCommitNoVerifyAudit(t *testing.T) {
+	repo, _ := setupConfigRepo(t)
+	writeHook(t, repo, "pre-commit", "exit 1\n")
+	_, err := runConfigArgs("commit.recordNoVerify", "true")
+	require.NoError(t, err)
+	_, err = runConfigArgs("commit.noVerifyLog", "no-verify.log")
+	require.NoError(t, err)
+
+	message, err := stageAndCommit(t, repo, "a.txt", "-n", "-m", "skip checks\n\nSigned-off-by: Test <test@example.com>")
+	require.NoError(t, err)
+	assert.Equal(t, "skip checks\n\nSigned-off-by: Test <test@example.com>\nNo-Verify: pre-commit\n", message)
+
+	data, err := os.ReadFile(filepath.Join(repo.GitDir(), "no-verify.log"))
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	require.Len(t, lines, 1)
+	var event noVerifyEvent
+	require.NoError(t, json.Unmarshal([]byte(lines[0]), &event))
+	head, _, err := refs.NewRefManager(repo.GitDir()).HEAD()
+	require.NoError(t, err)
+	assert.Equal(t, "no-verify", event.Event)
+	assert.Equal(t, head.String(), event.Commit)
+	assert.Equal(t, "refs/heads/main", event.Ref)
+	assert.Equal(t, []string{"pre-commit"}, event.Hooks)
+
+	// Without installed hooks nothing is bypassed
+	require.NoError(t, os.Remove(filepath.Join(repo.GitDir(), "hooks", "pre-commit")))
+	message, err = stageAndCommit(t, repo, "b.txt", "--no-verify", "-m", "plain")
+	require.NoError(t, err)
+	assert.Equal(t, "plain\n", message)
+}
+
+func TestPrepareCommitMsgAndPostCommitHooks(t *testing.T) {
+	repo, _ := setupConfigRepo(t)
+
+	writeHook(t, repo, "prepare-commit-msg", `printf '[%s] ' "$2" | cat - "$1" > "$1.new" && mv "$1.new" "$1"`+"\n")
+	writeHook(t, repo, "post-commit", "echo ran >> post-commit.out\nexit 1\n")
+	message, err := stageAndCommit(t, repo, "a.txt", "-m", "first")
+	require.NoError(t, err, "a failing post-commit hook does not fail the commit")
+	assert.Equal(t, "[message] first\n", message)
+
+	// --no-verify skips only pre-commit and commit-msg
+	writeHook(t, repo, "commit-msg", "exit 1\n")
+	message, err = stageAndCommit(t, repo, "b.txt", "--no-verify", "-m", "second")
+	require.NoError(t, err)
+	assert.Equal(t, "[message] second\n", message)
+
+	data, err := os.ReadFile("post-commit.out")
+	require.NoError(t, err)
+	assert.Equal(t, "ran\nran\n", string(data))
+}
+
+func TestHooksPath(t *testing.T) {
+	repo, _ := setupConfigRepo(t)
+	require.NoError(t, os.Mkdir("githooks", 0755))
+	require.NoError(t, os.WriteFile(filepath.Join("githooks", "commit-msg"), []byte("#!/bin/sh\necho 'Hooked: yes' >> \"$1\"\n"), 0755))
+	writeHook(t, repo, "commit-msg", "exit 1\n")
+	_, err := runConfigArgs("core.hooksPath", "githooks")
+	require.NoError(t, err)
+
+	message, err := stageAndCommit(t, repo, "a.txt", "-m", "first")
+	require.NoError(t, err)
+	assert.Equal(t, "first\nHooked: yes\n", message)
+}
+
+func TestPrePushHook(t *testing.T) {
+	repo, _ := setupConfigRepo(t)
+	_, err := stageAndCommit(t, repo, "a.txt", "-m", "first")
+	require.NoError(t, err)
+	head, _, err := refs.NewRefManager(repo.GitDir()).HEAD()
+	require.NoError(t, err)
+	_, url := servePushOrigin(t)
+	_, err = runConfigArgs("remote.origin.url", url)
+	require.NoError(t, err)
+
+	writeHook(t, repo, "pre-push", "echo \"$@\" > pre-push.out\ncat >> pre-push.out\ntest ! -e block\n")
+	_, err = runCommandArgs(newPushCommand(), "origin", "main")
+	require.NoError(t, err)
+	data, err := os.ReadFile("pre-push.out")
+	require.NoError(t, err)
+	zero := objects.ObjectID{}
+	assert.Equal(t, "origin "+url+"\nrefs/heads/main "+head.String()+" refs/heads/main "+zero.String()+"\n", string(data))
+
+	require.NoError(t, os.WriteFile("block", nil, 0644))
+	out, err := runCommandArgs(newPushCommand(), "origin", "main")
+	assert.ErrorContains(t, err, "pre-push hook failed")
+	assert.NotContains(t, out, "main -> main")
+
+	_, err = runCommandArgs(newPushCommand(), "--no-verify", "origin", "main")
+	assert.NoError(t, err)
+}
+
+func TestPostCheckoutAndPostMergeHooks(t *testing.T) {
+	repo, _ := setupConfigRepo(t)
+	_, err := stageAndCommit(t, repo, "a.txt", "-m", "first")
+	require.NoError(t, err)
+	refManager := refs.NewRefManager(repo.GitDir())
+	first, _, err := refManager.HEAD()
+	require.NoError(t, err)
+
+	writeHook(t, repo, "post-checkout", "echo checkout \"$@\" >> hooks.out\n")
+	writeHook(t, repo, "post-merge", "echo merge \"$@\" >> hooks.out\n")
+
+	_, err = runCommandArgs(newCheckoutCommand(), "-b", "feature")
+	require.NoError(t, err)
+	_, err = stageAndCommit(t, repo, "b.txt", "-m", "second")
+	require.NoError(t, err)
+	second, _, err := refManager.HEAD()
+	require.NoError(t, err)
+	_, err = runCommandArgs(newSwitchCommand(), "main")
+	require.NoError(t, err)
+	_, err = runCommandArgs(newMergeCommand(), "feature")
+	require.NoError(t, err)
+
+	data, err := os.ReadFile("hooks.out")
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"checkout " + first.String() + " " + first.String() + " 1",
+		"checkout " + second.String() + " " + first.String() + " 1",
+		"merge 0",
+	}, strings.Split(strings.TrimSpace(string(data)), "\n"))
+}
+
+func TestNoVerifyTrailer(t *testing.T) {
+	tests := []struct {
+		message string
+		want    string
+	}{
+		{"subject\n", "subject\n\nNo-Verify: pre-commit\n"},
+		{"Subject: with colon\n", "Subject: with colon\n\nNo-Verify: pre-commit\n"},
+		{"subject\n\nbody text\n", "subject\n\nbody text\n\nNo-Verify: pre-commit\n"},
+		{"subject\n\nCo-authored-by: A <a@b>\n", "subject\n\nCo-authored-by: A <a@b>\nNo-Verify: pre-commit\n"},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.want, trailer.Append(tt.message, noVerifyTrailer, "pre-commit"))
+	}
+}
@@ -2,14 +2,17 @@ package main
 
 import (
 	"fmt"
+	"os"
 	"path/filepath"
 
+	"github.com/fenilsonani/vcs/internal/core/refs"
 	"github.com/fenilsonani/vcs/pkg/vcs"
 	"github.com/spf13/cobra"
 )
 
 func newInitCommand() *cobra.Command {
 	var bare bool
+	var refFormat string
 	
 	cmd := &cobra.Command{
 		Use:   "init [path]",
@@ -17,6 +20,10 @@ func newInitCommand() *cobra.Command {
 		Long:  "Create an empty VCS repository or reinitialize an existing one",
 		Args:  cobra.MaximumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if refFormat != refs.FormatFiles && refFormat != refs.FormatReftable {
+				return fmt.Errorf("unknown ref storage format '%s'", refFormat)
+			}
+
 			path := "."
 			if len(args) > 0 {
 				path = args[0]
@@ -29,10 +36,23 @@ func newInitCommand() *cobra.Command {
 			}
 			
 			// Initialize repository
-			repo, err := vcs.Init(absPath)
+			var repo *vcs.Repository
+			if bare {
+				if err := os.MkdirAll(absPath, 0755); err != nil {
+					return fmt.Errorf("failed to create directory: %w", err)
+				}
+				repo, err = initBareRepository(absPath)
+			} else {
+				repo, err = vcs.Init(absPath)
+			}
 			if err != nil {
 				return fmt.Errorf("failed to initialize repository: %w", err)
 			}
+			if refFormat == refs.FormatReftable {
+				if err := refs.InitReftable(repo.GitDir()); err != nil {
+					return fmt.Errorf("failed to initialize repository: %w", err)
+				}
+			}
 			
 			// Print success message
 			if bare {
@@ -46,6 +66,7 @@ func newInitCommand() *cobra.Command {
 	}
 	
 	cmd.Flags().BoolVar(&bare, "bare", false, "Create a bare repository")
+	cmd.Flags().StringVar(&refFormat, "ref-format", refs.FormatFiles, "Storage format of the refs: files or reftable")
 	
 	return cmd
 }
\ No newline at end of file
@@ -8,6 +8,9 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/pkg/vcs"
 )
 
 func TestNewInitCommand(t *testing.T) {
@@ -405,4 +408,44 @@ func TestInitMultipleTimes(t *testing.T) {
 	
 	// Repository should still be valid
 	assert.DirExists(t, filepath.Join(tmpDir, ".git"))
-}
\ No newline at end of file
+}
+func TestInitRefFormat(t *testing.T) {
+	tmpDir := t.TempDir()
+	oldWd, _ := os.Getwd()
+	t.Cleanup(func() { os.Chdir(oldWd) })
+	require.NoError(t, os.Chdir(tmpDir))
+
+	cmd := newInitCommand()
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetArgs([]string{"--ref-format=packed"})
+	assert.ErrorContains(t, cmd.Execute(), "unknown ref storage format 'packed'")
+
+	cmd = newInitCommand()
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetArgs([]string{"--ref-format=reftable"})
+	require.NoError(t, cmd.Execute())
+
+	gitDir := filepath.Join(tmpDir, ".git")
+	content, err := os.ReadFile(filepath.Join(gitDir, "config"))
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "repositoryformatversion = 1")
+	assert.Contains(t, string(content), "refStorage = reftable")
+	assert.FileExists(t, filepath.Join(gitDir, "reftable", "tables.list"))
+
+	// The refs are read and written through the tables
+	repo, err := vcs.Open(tmpDir)
+	require.NoError(t, err)
+	id := commitFiles(t, repo, map[string]string{"a.txt": "a\n"}, nil, "first\n")
+	refManager := refs.NewRefManager(gitDir)
+	assert.Equal(t, refs.FormatReftable, refManager.Store().Format())
+	require.NoError(t, refManager.UpdateRef("refs/heads/main", id))
+	require.NoError(t, refManager.CreateTag("v1.0", id))
+	head, branch, err := refManager.HEAD()
+	require.NoError(t, err)
+	assert.Equal(t, id, head)
+	assert.Equal(t, "refs/heads/main", branch)
+
+	out, err := runFsckArgs()
+	require.NoError(t, err)
+	assert.Empty(t, out)
+}
@@ -0,0 +1,282 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/config"
+	"github.com/fenilsonani/vcs/internal/core/trailer"
+)
+
+func newInterpretTrailersCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "interpret-trailers [flags] [<file>...]",
+		Short: "Add or parse structured information in commit messages",
+		Long: `Reads commit messages from the files given, or from standard input, and
+writes them with the trailers given by --trailer added to the trailer
+block ending each: the "Token: value" lines of its last paragraph. A
+trailer is given as "token=value" or "token: value".
+
+Where a trailer goes and whether it is added when the block has its
+token is set by trailer.where (end, start, after or before the trailer
+with the same token), trailer.ifExists (addIfDifferentNeighbor,
+addIfDifferent, add, replace or doNothing) and trailer.ifMissing (add or
+doNothing), or for one token by trailer.<name>.where, .ifExists and
+.ifMissing; --where, --if-exists and --if-missing override them.
+trailer.<name>.key is the token written for trailers given as <name>,
+and trailer.separators the characters that may end a token.
+
+--parse, short for --only-trailers --only-input --unfold, writes only
+the trailers of each message, one per line.`,
+		RunE: runInterpretTrailers,
+	}
+
+	cmd.Flags().StringArray("trailer", nil, "Trailer to add, as token=value")
+	cmd.Flags().Bool("in-place", false, "Edit the files in place")
+	cmd.Flags().Bool("trim-empty", false, "Remove trailers with an empty value")
+	cmd.Flags().String("where", "", "Where to place new trailers: end, start, after or before")
+	cmd.Flags().String("if-exists", "", "What to do when the token is already there: addIfDifferentNeighbor, addIfDifferent, add, replace or doNothing")
+	cmd.Flags().String("if-missing", "", "What to do when the token is missing: add or doNothing")
+	cmd.Flags().Bool("only-trailers", false, "Output only the trailers")
+	cmd.Flags().Bool("only-input", false, "Do not add trailers, only read those of the input")
+	cmd.Flags().Bool("unfold", false, "Join the continuation lines of each trailer")
+	cmd.Flags().Bool("parse", false, "Same as --only-trailers --only-input --unfold")
+	cmd.Flags().Bool("no-divider", false, "Do not treat \"---\" as the end of the commit message")
+
+	return cmd
+}
+
+func runInterpretTrailers(cmd *cobra.Command, args []string) error {
+	trailers, _ := cmd.Flags().GetStringArray("trailer")
+	inPlace, _ := cmd.Flags().GetBool("in-place")
+	trimEmpty, _ := cmd.Flags().GetBool("trim-empty")
+	onlyTrailers, _ := cmd.Flags().GetBool("only-trailers")
+	onlyInput, _ := cmd.Flags().GetBool("only-input")
+	unfold, _ := cmd.Flags().GetBool("unfold")
+	noDivider, _ := cmd.Flags().GetBool("no-divider")
+	if parse, _ := cmd.Flags().GetBool("parse"); parse {
+		onlyTrailers, onlyInput, unfold = true, true, true
+	}
+	if onlyInput && len(trailers) > 0 {
+		return fmt.Errorf("--trailer with --only-input does not make sense")
+	}
+	if inPlace && len(args) == 0 {
+		return fmt.Errorf("no input file given for in-place editing")
+	}
+
+	conf, err := readTrailerConfig(loadConfig(currentGitDir()))
+	if err != nil {
+		return err
+	}
+	if err := conf.override(cmd); err != nil {
+		return err
+	}
+
+	rewrite := func(message string) (string, error) {
+		m := conf.parse(message, noDivider)
+		if err := conf.add(m, trailers); err != nil {
+			return "", err
+		}
+		if trimEmpty {
+			m.TrimEmpty()
+		}
+		if unfold {
+			m.Unfold()
+		}
+		if onlyTrailers {
+			return m.TrailerText(), nil
+		}
+		return m.String(), nil
+	}
+
+	if len(args) == 0 {
+		data, err := io.ReadAll(cmd.InOrStdin())
+		if err != nil {
+			return fmt.Errorf("failed to read standard input: %w", err)
+		}
+		out, err := rewrite(string(data))
+		if err != nil {
+			return err
+		}
+		fmt.Fprint(cmd.OutOrStdout(), out)
+		return nil
+	}
+	for _, path := range args {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("could not read input file '%s': %w", path, err)
+		}
+		out, err := rewrite(string(data))
+		if err != nil {
+			return err
+		}
+		if !inPlace {
+			fmt.Fprint(cmd.OutOrStdout(), out)
+			continue
+		}
+		if err := os.WriteFile(path, []byte(out), 0644); err != nil {
+			return fmt.Errorf("could not write '%s': %w", path, err)
+		}
+	}
+	return nil
+}
+
+// trailerConfig is how trailers are added, as trailer.* sets it: the
+// placement of every trailer and the rules of trailer.<name>.*
+type trailerConfig struct {
+	separators string
+	placement  trailer.Placement
+	rules      []trailerRule
+	// set are the placement flags given, which override the configuration
+	set map[string]string
+}
+
+// trailerRule is the trailer.<name>.* configuration of a token
+type trailerRule struct {
+	name string
+	// key is the token trailers of the rule are written with, name when
+	// not configured. It may end with a separator, as in "Bug #", to write
+	// them with that separator and no space.
+	key       string
+	placement trailer.Placement
+}
+
+// readTrailerConfig reads trailer.separators, trailer.where,
+// trailer.ifExists, trailer.ifMissing and the trailer.<name>.* rules
+func readTrailerConfig(cfg *config.Config) (*trailerConfig, error) {
+	c := &trailerConfig{separators: ":"}
+	if value, ok := cfg.Get("trailer.separators"); ok && value != "" {
+		c.separators = value
+	}
+	if err := readPlacement(cfg, "trailer.", &c.placement); err != nil {
+		return nil, err
+	}
+	for _, name := range cfg.Subsections("trailer") {
+		rule := trailerRule{name: name, key: name, placement: c.placement}
+		if key, ok := cfg.Get("trailer." + name + ".key"); ok && key != "" {
+			rule.key = key
+		}
+		if err := readPlacement(cfg, "trailer."+name+".", &rule.placement); err != nil {
+			return nil, err
+		}
+		c.rules = append(c.rules, rule)
+	}
+	return c, nil
+}
+
+// readPlacement sets the fields of p that <prefix>where, <prefix>ifExists
+// and <prefix>ifMissing configure
+func readPlacement(cfg *config.Config, prefix string, p *trailer.Placement) error {
+	var err error
+	if value, ok := cfg.Get(prefix + "where"); ok {
+		if p.Where, err = trailer.ParseWhere(value); err != nil {
+			return fmt.Errorf("invalid %swhere: %w", prefix, err)
+		}
+	}
+	if value, ok := cfg.Get(prefix + "ifExists"); ok {
+		if p.IfExists, err = trailer.ParseIfExists(value); err != nil {
+			return fmt.Errorf("invalid %sifExists: %w", prefix, err)
+		}
+	}
+	if value, ok := cfg.Get(prefix + "ifMissing"); ok {
+		if p.IfMissing, err = trailer.ParseIfMissing(value); err != nil {
+			return fmt.Errorf("invalid %sifMissing: %w", prefix, err)
+		}
+	}
+	return nil
+}
+
+// override records the --where, --if-exists and --if-missing flags of
+// cmd, checking their values
+func (c *trailerConfig) override(cmd *cobra.Command) error {
+	c.set = make(map[string]string)
+	for _, flag := range []string{"where", "if-exists", "if-missing"} {
+		if value, _ := cmd.Flags().GetString(flag); value != "" {
+			c.set[flag] = value
+		}
+	}
+	var p trailer.Placement
+	return c.apply(&p)
+}
+
+// apply sets the fields of p given by flags
+func (c *trailerConfig) apply(p *trailer.Placement) error {
+	var err error
+	if value, ok := c.set["where"]; ok {
+		if p.Where, err = trailer.ParseWhere(value); err != nil {
+			return err
+		}
+	}
+	if value, ok := c.set["if-exists"]; ok {
+		if p.IfExists, err = trailer.ParseIfExists(value); err != nil {
+			return err
+		}
+	}
+	if value, ok := c.set["if-missing"]; ok {
+		if p.IfMissing, err = trailer.ParseIfMissing(value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// options returns how messages are parsed: with the configured separators
+// and with the keys of the rules known as trailers
+func (c *trailerConfig) options(noDivider bool) trailer.Options {
+	opts := trailer.Options{Separators: c.separators, NoDivider: noDivider}
+	for _, rule := range c.rules {
+		opts.Known = append(opts.Known, rule.key)
+	}
+	return opts
+}
+
+// parse splits message around its trailer block, writing the trailers
+// with a rule's token with its key
+func (c *trailerConfig) parse(message string, noDivider bool) *trailer.Message {
+	m := trailer.Parse(message, c.options(noDivider))
+	for i, t := range m.Trailers {
+		if rule := c.rule(t.Token); rule != nil {
+			m.Trailers[i].Token = rule.key
+		}
+	}
+	return m
+}
+
+// rule returns the rule for token, given as the rule's name or key, or nil
+func (c *trailerConfig) rule(token string) *trailerRule {
+	if token == "" {
+		return nil
+	}
+	for i, rule := range c.rules {
+		key := strings.TrimSpace(strings.TrimRight(strings.TrimSpace(rule.key), c.separators))
+		if strings.EqualFold(token, rule.name) || strings.EqualFold(token, key) {
+			return &c.rules[i]
+		}
+	}
+	return nil
+}
+
+// add adds the trailers given as --trailer arguments to m in order. A
+// token naming a rule, or its key, is written as the rule's key and placed
+// as the rule says.
+func (c *trailerConfig) add(m *trailer.Message, args []string) error {
+	for _, arg := range args {
+		t, err := trailer.ParseTrailer(arg, c.separators)
+		if err != nil {
+			return err
+		}
+		placement := c.placement
+		if rule := c.rule(t.Token); rule != nil {
+			t.Token, placement = rule.key, rule.placement
+		}
+		if err := c.apply(&placement); err != nil {
+			return err
+		}
+		m.Add(t, placement)
+	}
+	return nil
+}
@@ -0,0 +1,89 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// interpretTrailers runs interpret-trailers with args on input
+func interpretTrailers(t *testing.T, input string, args ...string) (string, error) {
+	return captureStdout(t, func() error {
+		cmd := newInterpretTrailersCommand()
+		cmd.SetIn(strings.NewReader(input))
+		cmd.SetArgs(args)
+		return cmd.Execute()
+	})
+}
+
+func TestInterpretTrailers(t *testing.T) {
+	setupConfigRepo(t)
+	message := "subject\n\nbody\n\nAcked-by: A\nFixes : #1\n"
+
+	out, err := interpretTrailers(t, message, "--trailer", "Reviewed-by=B", "--trailer", "acked-by: A")
+	require.NoError(t, err)
+	assert.Equal(t, "subject\n\nbody\n\nAcked-by: A\nFixes: #1\nReviewed-by: B\nacked-by: A\n", out)
+
+	out, err = interpretTrailers(t, message, "--where", "start", "--if-exists", "replace", "--trailer", "Fixes=#2")
+	require.NoError(t, err)
+	assert.Equal(t, "subject\n\nbody\n\nFixes: #2\nAcked-by: A\n", out)
+
+	out, err = interpretTrailers(t, "subject\n\nbody\n", "--trailer", "Reviewed-by=B")
+	require.NoError(t, err)
+	assert.Equal(t, "subject\n\nbody\n\nReviewed-by: B\n", out)
+
+	out, err = interpretTrailers(t, "subject\n\nbody\n\nNote: one\n  two\nEmpty:\n", "--parse", "--trim-empty")
+	require.NoError(t, err)
+	assert.Equal(t, "Note: one two\n", out)
+
+	_, err = interpretTrailers(t, message, "--if-exists", "sometimes")
+	assert.ErrorContains(t, err, `unknown trailer ifExists value "sometimes"`)
+	_, err = interpretTrailers(t, message, "--only-input", "--trailer", "A=1")
+	assert.Error(t, err)
+}
+
+func TestInterpretTrailersConfig(t *testing.T) {
+	setupConfigRepo(t)
+	for _, kv := range [][]string{
+		{"trailer.separators", ":#"},
+		{"trailer.ifExists", "addIfDifferent"},
+		{"trailer.sign.key", "Signed-off-by: "},
+		{"trailer.bug.key", "Bug #"},
+		{"trailer.bug.where", "start"},
+	} {
+		_, err := runConfigArgs(kv...)
+		require.NoError(t, err)
+	}
+
+	require.NoError(t, os.WriteFile("msg.txt", []byte("subject\n\nBug #1\nSigned-off-by: A\n"), 0644))
+	_, err := interpretTrailers(t, "", "--in-place",
+		"--trailer", "sign=A", "--trailer", "bug=2", "--trailer", "Other#x", "msg.txt")
+	require.NoError(t, err)
+	data, err := os.ReadFile("msg.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "subject\n\nBug #2\nBug #1\nSigned-off-by: A\nOther: x\n", string(data))
+}
+
+func TestCommitTrailers(t *testing.T) {
+	repo, _ := setupConfigRepo(t)
+	_, err := runConfigArgs("user.name", "Committer")
+	require.NoError(t, err)
+	_, err = runConfigArgs("user.email", "c@example.com")
+	require.NoError(t, err)
+
+	message, err := stageAndCommit(t, repo, "a.txt", "-m", "first\n\nwhy", "-s", "--trailer", "Reviewed-by=R")
+	require.NoError(t, err)
+	assert.Equal(t, "first\n\nwhy\n\nSigned-off-by: Committer <c@example.com>\nReviewed-by: R\n", message)
+
+	// A sign-off already ending the message is not repeated
+	message, err = stageAndCommit(t, repo, "b.txt", "-m", "second\n\nSigned-off-by: Committer <c@example.com>", "--signoff")
+	require.NoError(t, err)
+	assert.Equal(t, "second\n\nSigned-off-by: Committer <c@example.com>\n", message)
+
+	message, err = stageAndCommit(t, repo, "c.txt", "-m", "third\n\nSigned-off-by: Other <o@example.com>", "--signoff")
+	require.NoError(t, err)
+	assert.Equal(t, "third\n\nSigned-off-by: Other <o@example.com>\nSigned-off-by: Committer <c@example.com>\n", message)
+}
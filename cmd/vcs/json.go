@@ -0,0 +1,250 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/history"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+// The types below are the JSON that --json writes, documented in
+// docs/JSON.md. Fields are only ever added to them, so scripts reading
+// them keep working; IDs are full hex object IDs and dates RFC 3339.
+
+// jsonSignature is the author, committer or tagger of an object
+type jsonSignature struct {
+	Name  string `json:"name"`
+	Email string `json:"email"`
+	Date  string `json:"date"`
+}
+
+// jsonCommit is a commit listed by log
+type jsonCommit struct {
+	ID        string        `json:"id"`
+	Tree      string        `json:"tree"`
+	Parents   []string      `json:"parents"`
+	Author    jsonSignature `json:"author"`
+	Committer jsonSignature `json:"committer"`
+	Subject   string        `json:"subject"`
+	// Body is the message after the subject and the blank line following
+	// it
+	Body string `json:"body"`
+	// Notes holds the note of each notes ref shown, by the full ref name
+	Notes map[string]string `json:"notes,omitempty"`
+	Stat  *jsonStat         `json:"stat,omitempty"`
+}
+
+// jsonStat is a diffstat: the lines each file changed adds and removes,
+// and their totals
+type jsonStat struct {
+	Files        []jsonFileStat `json:"files"`
+	FilesChanged int            `json:"files_changed"`
+	Insertions   int            `json:"insertions"`
+	Deletions    int            `json:"deletions"`
+}
+
+// jsonFileStat is a file of a diffstat. Status is added, modified,
+// deleted, renamed or copied; OldPath is the path a renamed or copied file
+// came from. Lines of binary files are not counted.
+type jsonFileStat struct {
+	Path       string `json:"path"`
+	OldPath    string `json:"old_path,omitempty"`
+	Status     string `json:"status"`
+	Binary     bool   `json:"binary"`
+	Insertions int    `json:"insertions"`
+	Deletions  int    `json:"deletions"`
+}
+
+// jsonStatus is the status of the working tree
+type jsonStatus struct {
+	Branch jsonBranchStatus `json:"branch"`
+	Files  []jsonFileStatus `json:"files"`
+}
+
+// jsonBranchStatus is the current branch, with no name when HEAD is
+// detached and no head before its first commit
+type jsonBranchStatus struct {
+	Name     string        `json:"name,omitempty"`
+	Detached bool          `json:"detached"`
+	Head     string        `json:"head,omitempty"`
+	Upstream *jsonUpstream `json:"upstream,omitempty"`
+}
+
+// jsonUpstream is how a branch stands against its upstream. Ahead and
+// behind are left out when they were not counted.
+type jsonUpstream struct {
+	Name    string `json:"name"`
+	Gone    bool   `json:"gone"`
+	Differs bool   `json:"differs"`
+	Ahead   *int   `json:"ahead,omitempty"`
+	Behind  *int   `json:"behind,omitempty"`
+}
+
+// jsonFileStatus is a file that differs between HEAD, the index and the
+// working tree. Index is unmodified, added, modified, deleted or renamed,
+// and worktree unmodified, modified, deleted, untracked or ignored.
+type jsonFileStatus struct {
+	Path     string `json:"path"`
+	From     string `json:"from,omitempty"`
+	Index    string `json:"index"`
+	Worktree string `json:"worktree"`
+}
+
+// jsonBranch is a branch listed by branch
+type jsonBranch struct {
+	Name     string        `json:"name"`
+	Current  bool          `json:"current"`
+	Commit   string        `json:"commit"`
+	Subject  string        `json:"subject"`
+	Upstream *jsonUpstream `json:"upstream,omitempty"`
+}
+
+// jsonTag is a tag listed by tag. Object is what the tag ref points to and
+// target the commit it comes to once annotated tags are followed; only
+// annotated tags have a tagger and message.
+type jsonTag struct {
+	Name      string         `json:"name"`
+	Object    string         `json:"object"`
+	Target    string         `json:"target"`
+	Annotated bool           `json:"annotated"`
+	Tagger    *jsonSignature `json:"tagger,omitempty"`
+	Message   string         `json:"message,omitempty"`
+}
+
+// jsonRemote is a remote listed by remote list
+type jsonRemote struct {
+	Name     string `json:"name"`
+	FetchURL string `json:"fetch_url"`
+	PushURL  string `json:"push_url"`
+}
+
+// jsonStash is an entry listed by stash list
+type jsonStash struct {
+	Index   int    `json:"index"`
+	Ref     string `json:"ref"`
+	Branch  string `json:"branch"`
+	Message string `json:"message"`
+	Date    string `json:"date"`
+}
+
+// addJSONFlags adds --json and --format, whose one format is json, to a
+// command that can write its output as JSON
+func addJSONFlags(cmd *cobra.Command) {
+	cmd.Flags().Bool("json", false, "Write the output as JSON")
+	cmd.Flags().String("format", "", "Format of the output (json)")
+}
+
+// jsonRequested reports whether --json or --format=json was given, failing
+// for any other format before any work is done
+func jsonRequested(cmd *cobra.Command) (bool, error) {
+	on, _ := cmd.Flags().GetBool("json")
+	if format, _ := cmd.Flags().GetString("format"); format != "" {
+		if format != "json" {
+			return false, fmt.Errorf("unsupported format %q (supported: json)", format)
+		}
+		on = true
+	}
+	return on, nil
+}
+
+// writeJSON writes v as indented JSON followed by a newline
+func writeJSON(w io.Writer, v any) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(v); err != nil {
+		return fmt.Errorf("failed to write JSON: %w", err)
+	}
+	return nil
+}
+
+func newJSONSignature(sig objects.Signature) jsonSignature {
+	return jsonSignature{Name: sig.Name, Email: sig.Email, Date: sig.When.Format(time.RFC3339)}
+}
+
+// newJSONCommit describes commit, with the parents it is shown with and
+// its notes of sets
+func newJSONCommit(id objects.ObjectID, commit *objects.Commit, parents []objects.ObjectID, sets []logNotes) (jsonCommit, error) {
+	c := jsonCommit{
+		ID:        id.String(),
+		Tree:      commit.Tree().String(),
+		Parents:   []string{},
+		Author:    newJSONSignature(commit.Author()),
+		Committer: newJSONSignature(commit.Committer()),
+	}
+	for _, set := range sets {
+		text, ok, err := set.notes.Text(id)
+		if err != nil {
+			return c, err
+		}
+		if !ok {
+			continue
+		}
+		if c.Notes == nil {
+			c.Notes = make(map[string]string)
+		}
+		c.Notes[set.ref] = text
+	}
+	for _, parent := range parents {
+		c.Parents = append(c.Parents, parent.String())
+	}
+	message := strings.TrimSpace(commit.Message())
+	subject, body, _ := strings.Cut(message, "\n")
+	c.Subject = subject
+	c.Body = strings.TrimLeft(body, "\n")
+	if c.Body != "" {
+		c.Body += "\n"
+	}
+	return c, nil
+}
+
+// newJSONStat returns the diffstat of diffs
+func newJSONStat(diffs []*fileDiff) *jsonStat {
+	stat := &jsonStat{Files: []jsonFileStat{}, FilesChanged: len(diffs)}
+	for _, d := range diffs {
+		file := jsonFileStat{Path: d.change.Path, Status: changeStatus(d.change.Type), Binary: d.binary}
+		if d.change.Type == history.Renamed || d.change.Type == history.Copied {
+			file.OldPath = d.change.OldPath
+		}
+		if !d.binary {
+			file.Insertions, file.Deletions = d.counts()
+		}
+		stat.Insertions += file.Insertions
+		stat.Deletions += file.Deletions
+		stat.Files = append(stat.Files, file)
+	}
+	return stat
+}
+
+// changeStatus names the type of a change for a diffstat
+func changeStatus(t history.ChangeType) string {
+	switch t {
+	case history.Added:
+		return "added"
+	case history.Deleted:
+		return "deleted"
+	case history.Renamed:
+		return "renamed"
+	case history.Copied:
+		return "copied"
+	}
+	return "modified"
+}
+
+// newJSONUpstream describes t, or returns nil for no upstream
+func newJSONUpstream(t *tracking) *jsonUpstream {
+	if t == nil {
+		return nil
+	}
+	u := &jsonUpstream{Name: t.upstream, Gone: t.gone, Differs: t.different || t.ahead > 0 || t.behind > 0}
+	if !t.gone && !t.different {
+		ahead, behind := t.ahead, t.behind
+		u.Ahead, u.Behind = &ahead, &behind
+	}
+	return u
+}
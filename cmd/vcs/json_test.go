@@ -0,0 +1,168 @@
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// runJSON runs cmd with args and decodes the JSON it writes into v
+func runJSON(t *testing.T, cmd *cobra.Command, v any, args ...string) {
+	out, err := captureStdout(t, func() error {
+		cmd.SetArgs(args)
+		return cmd.Execute()
+	})
+	require.NoError(t, err)
+	require.NoError(t, json.Unmarshal([]byte(out), v), out)
+}
+
+func TestStatusJSON(t *testing.T) {
+	setupTreeRepo(t, map[string]string{"f.txt": "one\n"})
+	require.NoError(t, os.WriteFile("f.txt", []byte("two\n"), 0644))
+	require.NoError(t, os.WriteFile("new.txt", []byte("new\n"), 0644))
+
+	var status jsonStatus
+	runJSON(t, newStatusCommand(), &status, "--json")
+	assert.Equal(t, "main", status.Branch.Name)
+	assert.False(t, status.Branch.Detached)
+	assert.Len(t, status.Branch.Head, 40)
+	assert.Nil(t, status.Branch.Upstream)
+	assert.Equal(t, []jsonFileStatus{
+		{Path: "f.txt", Index: "unmodified", Worktree: "modified"},
+		{Path: "new.txt", Index: "unmodified", Worktree: "untracked"},
+	}, status.Files)
+
+	var again jsonStatus
+	runJSON(t, newStatusCommand(), &again, "--format=json")
+	assert.Equal(t, status, again)
+
+	_, err := captureStdout(t, func() error {
+		cmd := newStatusCommand()
+		cmd.SetArgs([]string{"--format=xml"})
+		return cmd.Execute()
+	})
+	assert.ErrorContains(t, err, `unsupported format "xml" (supported: json)`)
+}
+
+func TestBranchAndStatusJSONUpstream(t *testing.T) {
+	setupTrackingRepo(t)
+
+	var branches []jsonBranch
+	runJSON(t, newBranchCommand(), &branches, "--json")
+	require.Len(t, branches, 1)
+	assert.Equal(t, "main", branches[0].Name)
+	assert.True(t, branches[0].Current)
+	assert.Equal(t, "m2", branches[0].Subject)
+	require.NotNil(t, branches[0].Upstream)
+	assert.Equal(t, "origin/main", branches[0].Upstream.Name)
+	assert.True(t, branches[0].Upstream.Differs)
+	assert.Equal(t, 2, *branches[0].Upstream.Ahead)
+	assert.Equal(t, 1, *branches[0].Upstream.Behind)
+
+	// Without counting only whether they differ is known
+	var status jsonStatus
+	runJSON(t, newStatusCommand(), &status, "--json", "--no-ahead-behind")
+	require.NotNil(t, status.Branch.Upstream)
+	assert.True(t, status.Branch.Upstream.Differs)
+	assert.Nil(t, status.Branch.Upstream.Ahead)
+	assert.Nil(t, status.Branch.Upstream.Behind)
+}
+
+func TestLogJSON(t *testing.T) {
+	setupTreeRepo(t, map[string]string{"f.txt": "one\n"})
+	require.NoError(t, os.WriteFile("f.txt", []byte("one\ntwo\n"), 0644))
+	_, err := runCommandArgs(newAddCommand(), "f.txt")
+	require.NoError(t, err)
+	_, err = runCommandArgs(newCommitCommand(), "-m", "second\n\nWith a body.")
+	require.NoError(t, err)
+
+	var commits []jsonCommit
+	runJSON(t, newLogCommand(), &commits, "--json", "--stat")
+	require.Len(t, commits, 2)
+	assert.Equal(t, "second", commits[0].Subject)
+	assert.Equal(t, "With a body.\n", commits[0].Body)
+	assert.Equal(t, []string{commits[1].ID}, commits[0].Parents)
+	assert.Equal(t, "test@example.com", commits[1].Author.Email)
+	assert.Equal(t, &jsonStat{
+		Files:        []jsonFileStat{{Path: "f.txt", Status: "modified", Insertions: 1}},
+		FilesChanged: 1,
+		Insertions:   1,
+	}, commits[0].Stat)
+	assert.Equal(t, "base", commits[1].Subject)
+	assert.Equal(t, []string{}, commits[1].Parents)
+	assert.Equal(t, "", commits[1].Body)
+
+	var limited []jsonCommit
+	runJSON(t, newLogCommand(), &limited, "--format=json", "-n", "1")
+	require.Len(t, limited, 1)
+	assert.Nil(t, limited[0].Stat)
+
+	_, err = runLogArgs(t, "--json", "--oneline")
+	assert.ErrorContains(t, err, "--json cannot be used with --oneline, --graph or --pretty")
+}
+
+func TestDiffStatJSON(t *testing.T) {
+	setupTreeRepo(t, map[string]string{"f.txt": "keep\nold\n"})
+
+	var stat jsonStat
+	runJSON(t, newDiffCommand(), &stat, "--json")
+	assert.Equal(t, jsonStat{Files: []jsonFileStat{}}, stat)
+
+	require.NoError(t, os.WriteFile("f.txt", []byte("keep\nnew\nmore\n"), 0644))
+	runJSON(t, newDiffCommand(), &stat, "--json")
+	assert.Equal(t, jsonStat{
+		Files:        []jsonFileStat{{Path: "f.txt", Status: "modified", Insertions: 2, Deletions: 1}},
+		FilesChanged: 1,
+		Insertions:   2,
+		Deletions:    1,
+	}, stat)
+
+	out, err := captureStdout(t, func() error {
+		cmd := newDiffCommand()
+		cmd.SetArgs([]string{"--stat"})
+		return cmd.Execute()
+	})
+	require.NoError(t, err)
+	assert.Equal(t, " f.txt | 3 ++-\n 1 file changed, 2 insertions(+), 1 deletion(-)\n", out)
+}
+
+func TestListJSON(t *testing.T) {
+	setupTreeRepo(t, map[string]string{"f.txt": "one\n"})
+	_, err := runCommandArgs(newTagCommand(), "v1")
+	require.NoError(t, err)
+	_, err = runCommandArgs(newTagCommand(), "-a", "-m", "Release", "v2")
+	require.NoError(t, err)
+
+	var tags []jsonTag
+	runJSON(t, newTagCommand(), &tags, "--json")
+	require.Len(t, tags, 2)
+	assert.Equal(t, "v1", tags[0].Name)
+	assert.False(t, tags[0].Annotated)
+	assert.Equal(t, tags[0].Object, tags[0].Target)
+	assert.Equal(t, "v2", tags[1].Name)
+	assert.True(t, tags[1].Annotated)
+	assert.NotEqual(t, tags[1].Object, tags[1].Target)
+	assert.Equal(t, tags[0].Target, tags[1].Target)
+	assert.Equal(t, "Release", strings.TrimSpace(tags[1].Message))
+	require.NotNil(t, tags[1].Tagger)
+
+	_, err = runConfigArgs("remote.upstream.url", "https://example.com/b.git")
+	require.NoError(t, err)
+	_, err = runConfigArgs("remote.origin.url", "https://example.com/a.git")
+	require.NoError(t, err)
+	var remotes []jsonRemote
+	runJSON(t, newRemoteListCommand(), &remotes, "--json")
+	assert.Equal(t, []jsonRemote{
+		{Name: "origin", FetchURL: "https://example.com/a.git", PushURL: "https://example.com/a.git"},
+		{Name: "upstream", FetchURL: "https://example.com/b.git", PushURL: "https://example.com/b.git"},
+	}, remotes)
+
+	var stashes []jsonStash
+	runJSON(t, newStashListCommand(), &stashes, "--json")
+	assert.Equal(t, []jsonStash{}, stashes)
+}
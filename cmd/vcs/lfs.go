@@ -0,0 +1,620 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/history"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/internal/lfs"
+	"github.com/fenilsonani/vcs/internal/serve"
+	"github.com/fenilsonani/vcs/internal/transport"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// lfsAttributes are the attributes lfs track gives a pattern, as Git LFS
+// writes them
+const lfsAttributes = "filter=lfs diff=lfs merge=lfs -text"
+
+// lfsBatchSize is the most objects asked about in one batch request
+const lfsBatchSize = 100
+
+func newLFSCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "lfs",
+		Short: "Store large files outside the repository",
+		Long: `Keeps the content of large files out of the object database, compatibly
+with Git LFS. Files whose attributes have filter=lfs, as lfs track sets,
+are committed as small pointer blobs naming the SHA-256 of their content,
+and the content is kept in lfs/objects in the repository directory. With
+lfs.threshold set, such as to 10m, any file larger than it is stored the
+same way.
+
+Checkout writes the content of pointers whose objects are present, and
+the pointer itself otherwise. lfs fetch and lfs push move objects with a
+remote through the Git LFS batch API, at <url>.git/info/lfs unless
+lfs.url or remote.<name>.lfsurl says otherwise, or directly when the
+remote is a path on this machine.`,
+	}
+
+	cmd.AddCommand(
+		newLFSTrackCommand(),
+		newLFSLsFilesCommand(),
+		newLFSFetchCommand(),
+		newLFSPullCommand(),
+		newLFSPushCommand(),
+	)
+	return cmd
+}
+
+func newLFSTrackCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "track [<pattern>...]",
+		Short: "Store files matching patterns with LFS",
+		Long: `Adds each pattern to .gitattributes with the LFS filter, so matching
+files added from now on are stored with LFS. Without patterns, lists the
+patterns tracked.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repo, err := openSuperproject()
+			if err != nil {
+				return err
+			}
+			path := filepath.Join(repo.WorkDir(), ".gitattributes")
+			tracked, err := lfsTrackedPatterns(path)
+			if err != nil {
+				return err
+			}
+
+			out := cmd.OutOrStdout()
+			if len(args) == 0 {
+				fmt.Fprintln(out, "Listing tracked patterns")
+				for _, pattern := range tracked {
+					fmt.Fprintf(out, "    %s (.gitattributes)\n", pattern)
+				}
+				return nil
+			}
+
+			var lines []string
+			for _, pattern := range args {
+				if contains(tracked, pattern) {
+					fmt.Fprintf(out, "\"%s\" already supported\n", pattern)
+					continue
+				}
+				tracked = append(tracked, pattern)
+				lines = append(lines, pattern+" "+lfsAttributes)
+				fmt.Fprintf(out, "Tracking \"%s\"\n", pattern)
+			}
+			return appendLines(path, lines)
+		},
+	}
+}
+
+func newLFSLsFilesCommand() *cobra.Command {
+	var long bool
+
+	cmd := &cobra.Command{
+		Use:   "ls-files [<ref>]",
+		Short: "List the files stored with LFS",
+		Long: `Lists the files of <ref>, HEAD by default, that are stored with LFS: the
+object ID, * when the object is present or - when only the pointer is,
+and the path.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repo, err := openSuperproject()
+			if err != nil {
+				return err
+			}
+			rev := "HEAD"
+			if len(args) == 1 {
+				rev = args[0]
+			}
+			id, err := resolveCommitish(repo, rev)
+			if err != nil {
+				return err
+			}
+			files, err := lfsFiles(repo, id)
+			if err != nil {
+				return err
+			}
+
+			store := lfs.NewStore(repo.CommonDir())
+			for _, file := range files {
+				oid := file.pointer.Oid
+				if !long {
+					oid = oid[:10]
+				}
+				present := "-"
+				if store.Has(file.pointer) {
+					present = "*"
+				}
+				fmt.Fprintf(cmd.OutOrStdout(), "%s %s %s\n", oid, present, file.path)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVarP(&long, "long", "l", false, "Show the full object ID")
+	return cmd
+}
+
+func newLFSFetchCommand() *cobra.Command {
+	var all bool
+
+	cmd := &cobra.Command{
+		Use:   "fetch [<remote> [<ref>...]]",
+		Short: "Download LFS objects from a remote",
+		Long: `Downloads the LFS objects of the files in each <ref>, HEAD by default,
+that are not present yet, from <remote>, origin by default. With --all,
+downloads those of every commit reachable from any ref.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repo, err := openSuperproject()
+			if err != nil {
+				return err
+			}
+			remoteName, revs := lfsRemoteArgs(args, "HEAD")
+			_, err = lfsFetch(cmd.Context(), cmd.OutOrStdout(), repo, remoteName, revs, all)
+			return err
+		},
+	}
+
+	cmd.Flags().BoolVar(&all, "all", false, "Download the objects of all refs and their history")
+	return cmd
+}
+
+func newLFSPullCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "pull [<remote>]",
+		Short: "Download LFS objects and check them out",
+		Long: `Fetches the LFS objects of HEAD, then replaces the files of the working
+tree that are still pointers with their content.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repo, err := openSuperproject()
+			if err != nil {
+				return err
+			}
+			remoteName, _ := lfsRemoteArgs(args, "HEAD")
+			files, err := lfsFetch(cmd.Context(), cmd.OutOrStdout(), repo, remoteName, []string{"HEAD"}, false)
+			if err != nil {
+				return err
+			}
+			return lfsCheckout(cmd.OutOrStdout(), repo, files)
+		},
+	}
+}
+
+func newLFSPushCommand() *cobra.Command {
+	var all bool
+
+	cmd := &cobra.Command{
+		Use:   "push [<remote> [<ref>...]]",
+		Short: "Upload LFS objects to a remote",
+		Long: `Uploads the LFS objects of every commit reachable from each <ref>, the
+current branch by default, to <remote>, origin by default, skipping those
+it has already. With --all, uploads those reachable from any ref. Objects
+that are not present locally are skipped with a warning.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repo, err := openSuperproject()
+			if err != nil {
+				return err
+			}
+			remoteName, revs := lfsRemoteArgs(args, "HEAD")
+			roots, err := lfsRoots(repo, revs, all)
+			if err != nil {
+				return err
+			}
+			pointers, err := lfsReachablePointers(repo, roots)
+			if err != nil {
+				return err
+			}
+
+			store := lfs.NewStore(repo.CommonDir())
+			var present []lfs.Pointer
+			for _, p := range pointers {
+				if !store.Has(p) {
+					fmt.Fprintf(cmd.ErrOrStderr(), "warning: LFS object %s is not present locally; skipping it\n", p.Oid)
+					continue
+				}
+				present = append(present, p)
+			}
+
+			remote, err := openLFSRemote(repo, remoteName)
+			if err != nil {
+				return err
+			}
+			uploaded, err := remote.upload(contextOrBackground(cmd.Context()), store, present)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Uploaded %d of %d LFS objects\n", uploaded, len(present))
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&all, "all", false, "Upload the objects of all refs")
+	return cmd
+}
+
+// lfsTrackedPatterns returns the patterns the .gitattributes file at path
+// gives the LFS filter
+func lfsTrackedPatterns(path string) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read .gitattributes: %w", err)
+	}
+
+	var patterns []string
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if contains(fields[1:], "filter=lfs") {
+			patterns = append(patterns, fields[0])
+		}
+	}
+	return patterns, nil
+}
+
+// appendLines adds lines to the end of the file at path, creating it, and
+// starting on a new line
+func appendLines(path string, lines []string) error {
+	if len(lines) == 0 {
+		return nil
+	}
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read %s: %w", filepath.Base(path), err)
+	}
+	if len(data) > 0 && data[len(data)-1] != '\n' {
+		data = append(data, '\n')
+	}
+	data = append(data, strings.Join(lines, "\n")+"\n"...)
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", filepath.Base(path), err)
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// lfsRemoteArgs splits the arguments of fetch and push into the remote,
+// origin by default, and the refs, defaultRev by default
+func lfsRemoteArgs(args []string, defaultRev string) (string, []string) {
+	if len(args) == 0 {
+		return "origin", []string{defaultRev}
+	}
+	if len(args) == 1 {
+		return args[0], []string{defaultRev}
+	}
+	return args[0], args[1:]
+}
+
+// lfsFile is a file of a tree stored with LFS
+type lfsFile struct {
+	path    string
+	mode    objects.FileMode
+	pointer lfs.Pointer
+}
+
+// lfsFiles returns the files of commit that are LFS pointers
+func lfsFiles(repo *vcs.Repository, commitID objects.ObjectID) ([]lfsFile, error) {
+	commit, err := repo.GetCommit(commitID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read commit: %w", err)
+	}
+	changes, err := history.DiffTrees(repo, objects.ObjectID{}, commit.Tree(), true)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tree: %w", err)
+	}
+
+	var files []lfsFile
+	for _, change := range changes {
+		if change.NewMode == objects.ModeCommit || change.NewMode == objects.ModeTree {
+			continue
+		}
+		if p, ok := readPointer(repo, change.NewID); ok {
+			files = append(files, lfsFile{path: change.Path, mode: change.NewMode, pointer: p})
+		}
+	}
+	return files, nil
+}
+
+// readPointer reads the blob id as an LFS pointer
+func readPointer(repo *vcs.Repository, id objects.ObjectID) (lfs.Pointer, bool) {
+	blob, err := repo.GetBlob(id)
+	if err != nil {
+		return lfs.Pointer{}, false
+	}
+	return lfs.ParsePointer(blob.Data())
+}
+
+// lfsRoots resolves revs to commits, or returns the tips of all refs when
+// all is set
+func lfsRoots(repo *vcs.Repository, revs []string, all bool) ([]objects.ObjectID, error) {
+	var roots []objects.ObjectID
+	if all {
+		allRefs, err := refs.NewRefManager(repo.GitDir()).AllRefs()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list refs: %w", err)
+		}
+		for _, id := range allRefs {
+			roots = append(roots, id)
+		}
+		return roots, nil
+	}
+	for _, rev := range revs {
+		id, err := resolveCommitish(repo, rev)
+		if err != nil {
+			return nil, err
+		}
+		roots = append(roots, id)
+	}
+	return roots, nil
+}
+
+// lfsReachablePointers returns the LFS pointers among the blobs reachable
+// from roots, each object once
+func lfsReachablePointers(repo *vcs.Repository, roots []objects.ObjectID) ([]lfs.Pointer, error) {
+	set, err := reachableObjects(repo, roots)
+	if err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	var pointers []lfs.Pointer
+	for _, id := range set.order {
+		if p, ok := readPointer(repo, id); ok && !seen[p.Oid] {
+			seen[p.Oid] = true
+			pointers = append(pointers, p)
+		}
+	}
+	return pointers, nil
+}
+
+// lfsFetch downloads the missing LFS objects of revs, or of all history
+// when all is set, and returns the LFS files of revs
+func lfsFetch(ctx context.Context, out io.Writer, repo *vcs.Repository, remoteName string, revs []string, all bool) ([]lfsFile, error) {
+	roots, err := lfsRoots(repo, revs, all)
+	if err != nil {
+		return nil, err
+	}
+
+	var files []lfsFile
+	var pointers []lfs.Pointer
+	if all {
+		if pointers, err = lfsReachablePointers(repo, roots); err != nil {
+			return nil, err
+		}
+	} else {
+		seen := make(map[string]bool)
+		for _, root := range roots {
+			rootFiles, err := lfsFiles(repo, root)
+			if err != nil {
+				return nil, err
+			}
+			files = append(files, rootFiles...)
+			for _, file := range rootFiles {
+				if !seen[file.pointer.Oid] {
+					seen[file.pointer.Oid] = true
+					pointers = append(pointers, file.pointer)
+				}
+			}
+		}
+	}
+
+	store := lfs.NewStore(repo.CommonDir())
+	var missing []lfs.Pointer
+	for _, p := range pointers {
+		if !store.Has(p) {
+			missing = append(missing, p)
+		}
+	}
+	if len(missing) == 0 {
+		fmt.Fprintln(out, "LFS objects are up to date")
+		return files, nil
+	}
+
+	remote, err := openLFSRemote(repo, remoteName)
+	if err != nil {
+		return nil, err
+	}
+	if err := remote.download(contextOrBackground(ctx), store, missing); err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(out, "Downloaded %d LFS objects\n", len(missing))
+	return files, nil
+}
+
+// lfsCheckout writes the content of files whose working tree copy is still
+// their pointer
+func lfsCheckout(out io.Writer, repo *vcs.Repository, files []lfsFile) error {
+	store := lfs.NewStore(repo.CommonDir())
+	checkedOut := 0
+	for _, file := range files {
+		path := filepath.Join(repo.WorkDir(), filepath.FromSlash(file.path))
+		current, err := os.ReadFile(path)
+		if err != nil {
+			continue // deleted or replaced since
+		}
+		if p, ok := lfs.ParsePointer(current); !ok || p != file.pointer || !store.Has(p) {
+			continue
+		}
+		data, err := store.Read(file.pointer)
+		if err != nil {
+			return err
+		}
+		mode := os.FileMode(0644)
+		if file.mode == objects.ModeExec {
+			mode = 0755
+		}
+		if err := os.WriteFile(path, data, mode); err != nil {
+			return fmt.Errorf("failed to write %s: %w", file.path, err)
+		}
+		checkedOut++
+	}
+	fmt.Fprintf(out, "Checked out %d LFS files\n", checkedOut)
+	return nil
+}
+
+// lfsRemote moves LFS objects to and from a remote: over the batch API, or
+// between stores when the remote is on this machine
+type lfsRemote struct {
+	client *lfs.Client
+	store  *lfs.Store
+}
+
+// openLFSRemote returns the LFS remote of the remote called name
+func openLFSRemote(repo *vcs.Repository, name string) (*lfsRemote, error) {
+	cfg := loadConfig(repo.GitDir())
+	if url, ok := cfg.Get("lfs.url"); ok && url != "" {
+		return &lfsRemote{client: lfs.NewClient(url)}, nil
+	}
+	if url, ok := cfg.Get("remote." + name + ".lfsurl"); ok && url != "" {
+		return &lfsRemote{client: lfs.NewClient(url)}, nil
+	}
+
+	remotes, err := getRemotes(repo)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get remotes: %w", err)
+	}
+	url, ok := remotes[name]
+	if !ok {
+		return nil, fmt.Errorf("remote '%s' does not exist", name)
+	}
+
+	if isLocalURL(url) {
+		dir, err := localGitDir(localURLPath(url))
+		if err != nil {
+			return nil, err
+		}
+		return &lfsRemote{store: lfs.NewStore(dir)}, nil
+	}
+	if shareName, ok := serve.ParseURL(url); ok {
+		ctx, cancel := context.WithTimeout(context.Background(), localShareTimeout)
+		share, err := resolveLocalShare(ctx, shareName)
+		cancel()
+		if err != nil {
+			return nil, err
+		}
+		url = share.URL()
+	} else if url, err = transport.ParseGitURL(url); err != nil {
+		return nil, fmt.Errorf("failed to parse remote URL: %w", err)
+	}
+	return &lfsRemote{client: lfs.NewClient(lfs.Endpoint(url))}, nil
+}
+
+// download fetches pointers into store
+func (r *lfsRemote) download(ctx context.Context, store *lfs.Store, pointers []lfs.Pointer) error {
+	if r.store != nil {
+		for _, p := range pointers {
+			if !r.store.Has(p) {
+				return fmt.Errorf("remote is missing LFS object %s", p.Oid)
+			}
+			if err := copyLFSObject(r.store, store, p); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return batches(pointers, func(batch []lfs.Pointer) error {
+		resp, err := r.client.Batch(ctx, lfs.Download, batch)
+		if err != nil {
+			return err
+		}
+		for _, obj := range resp.Objects {
+			if err := r.client.Download(ctx, store, obj); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// upload sends the pointers in store that the remote lacks and returns how
+// many it sent
+func (r *lfsRemote) upload(ctx context.Context, store *lfs.Store, pointers []lfs.Pointer) (int, error) {
+	uploaded := 0
+	if r.store != nil {
+		for _, p := range pointers {
+			if r.store.Has(p) {
+				continue
+			}
+			if err := copyLFSObject(store, r.store, p); err != nil {
+				return uploaded, err
+			}
+			uploaded++
+		}
+		return uploaded, nil
+	}
+
+	err := batches(pointers, func(batch []lfs.Pointer) error {
+		resp, err := r.client.Batch(ctx, lfs.Upload, batch)
+		if err != nil {
+			return err
+		}
+		for _, obj := range resp.Objects {
+			if obj.Error == nil && obj.Actions[lfs.Upload] == nil {
+				continue
+			}
+			if err := r.client.Upload(ctx, store, obj); err != nil {
+				return err
+			}
+			uploaded++
+		}
+		return nil
+	})
+	return uploaded, err
+}
+
+// copyLFSObject copies the object p points to from one store to another
+func copyLFSObject(from, to *lfs.Store, p lfs.Pointer) error {
+	file, err := from.Open(p)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return to.Receive(p, bufio.NewReader(file))
+}
+
+// batches calls fn with pointers lfsBatchSize at a time
+func batches(pointers []lfs.Pointer, fn func([]lfs.Pointer) error) error {
+	for len(pointers) > 0 {
+		n := len(pointers)
+		if n > lfsBatchSize {
+			n = lfsBatchSize
+		}
+		if err := fn(pointers[:n]); err != nil {
+			return err
+		}
+		pointers = pointers[n:]
+	}
+	return nil
+}
+
+// contextOrBackground returns ctx, or a background context when a command
+// runs without one
+func contextOrBackground(ctx context.Context) context.Context {
+	if ctx == nil {
+		return context.Background()
+	}
+	return ctx
+}
@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/lfs"
+	"github.com/fenilsonani/vcs/internal/serve"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+func runLFSArgs(args ...string) (string, error) {
+	return runCommandArgs(newLFSCommand(), args...)
+}
+
+func resetHard(t *testing.T) {
+	_, err := captureStdout(t, func() error {
+		cmd := newResetCommand()
+		cmd.SetArgs([]string{"--hard", "HEAD"})
+		return cmd.Execute()
+	})
+	require.NoError(t, err)
+}
+
+// commitLFSFile tracks *.bin and commits name with content
+func commitLFSFile(t *testing.T, repo *vcs.Repository, name string, content []byte) {
+	_, err := runLFSArgs("track", "*.bin")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(name, content, 0644))
+	_, err = runCommandArgs(newAddCommand(), "-A")
+	require.NoError(t, err)
+	_, err = stageAndCommit(t, repo, "README", "-m", "add "+name)
+	require.NoError(t, err)
+}
+
+func TestLFSTrack(t *testing.T) {
+	setupConfigRepo(t)
+
+	out, err := runLFSArgs("track", "*.bin", "*.iso")
+	require.NoError(t, err)
+	assert.Equal(t, "Tracking \"*.bin\"\nTracking \"*.iso\"\n", out)
+
+	out, err = runLFSArgs("track", "*.bin")
+	require.NoError(t, err)
+	assert.Equal(t, "\"*.bin\" already supported\n", out)
+
+	data, err := os.ReadFile(".gitattributes")
+	require.NoError(t, err)
+	assert.Equal(t, "*.bin filter=lfs diff=lfs merge=lfs -text\n*.iso filter=lfs diff=lfs merge=lfs -text\n", string(data))
+
+	out, err = runLFSArgs("track")
+	require.NoError(t, err)
+	assert.Contains(t, out, "    *.bin (.gitattributes)\n")
+	assert.Contains(t, out, "    *.iso (.gitattributes)\n")
+}
+
+func TestLFSAddAndCheckout(t *testing.T) {
+	repo, _ := setupConfigRepo(t)
+	content := bytes.Repeat([]byte{0, 1, 2, 3}, 2048)
+	commitLFSFile(t, repo, "data.bin", content)
+
+	// The blob committed is a pointer, and the content is in the store
+	p := lfs.NewPointer(content)
+	blob, err := newResolver(repo).Resolve("HEAD:data.bin")
+	require.NoError(t, err)
+	obj, err := repo.GetBlob(blob)
+	require.NoError(t, err)
+	assert.Equal(t, string(p.Encode()), string(obj.Data()))
+	assert.True(t, lfs.NewStore(repo.GitDir()).Has(p))
+
+	out, err := runLFSArgs("ls-files")
+	require.NoError(t, err)
+	assert.Equal(t, p.Oid[:10]+" * data.bin\n", out)
+
+	require.NoError(t, os.Remove("data.bin"))
+	resetHard(t)
+	data, err := os.ReadFile("data.bin")
+	require.NoError(t, err)
+	assert.Equal(t, content, data)
+}
+
+func TestLFSThreshold(t *testing.T) {
+	repo, _ := setupConfigRepo(t)
+	_, err := runConfigArgs("lfs.threshold", "1k")
+	require.NoError(t, err)
+
+	require.NoError(t, os.WriteFile("large.dat", bytes.Repeat([]byte("x"), 2048), 0644))
+	require.NoError(t, os.WriteFile("small.dat", []byte("small\n"), 0644))
+	_, err = runCommandArgs(newAddCommand(), "large.dat", "small.dat")
+	require.NoError(t, err)
+
+	_, ok := lfs.ParsePointer([]byte(stagedContent(t, repo, "large.dat")))
+	assert.True(t, ok, "large.dat was not stored with LFS")
+	assert.Equal(t, "small\n", stagedContent(t, repo, "small.dat"))
+}
+
+func TestLFSPushAndPullLocal(t *testing.T) {
+	remote, err := vcs.Init(t.TempDir())
+	require.NoError(t, err)
+	repo, _ := setupConfigRepo(t)
+	_, err = runConfigArgs("remote.origin.url", remote.WorkDir())
+	require.NoError(t, err)
+
+	content := bytes.Repeat([]byte("payload "), 512)
+	commitLFSFile(t, repo, "data.bin", content)
+	p := lfs.NewPointer(content)
+
+	out, err := runLFSArgs("push", "origin")
+	require.NoError(t, err)
+	assert.Equal(t, "Uploaded 1 of 1 LFS objects\n", out)
+	assert.True(t, lfs.NewStore(remote.GitDir()).Has(p))
+
+	// Lose the local copy, so checkout leaves the pointer until pull
+	require.NoError(t, os.RemoveAll(filepath.Join(repo.GitDir(), "lfs")))
+	require.NoError(t, os.Remove("data.bin"))
+	resetHard(t)
+	data, err := os.ReadFile("data.bin")
+	require.NoError(t, err)
+	assert.Equal(t, p.Encode(), data)
+
+	out, err = runLFSArgs("pull")
+	require.NoError(t, err)
+	assert.Contains(t, out, "Downloaded 1 LFS objects")
+	data, err = os.ReadFile("data.bin")
+	require.NoError(t, err)
+	assert.Equal(t, content, data)
+}
+
+func TestLFSPushAndFetchHTTP(t *testing.T) {
+	remote, err := vcs.Init(t.TempDir())
+	require.NoError(t, err)
+	s := serve.NewServer(remote.GitDir(), remote.Storage())
+	s.AllowPush(serve.Policy{})
+	server := httptest.NewServer(s)
+	defer server.Close()
+
+	repo, _ := setupConfigRepo(t)
+	_, err = runConfigArgs("remote.origin.url", server.URL+"/repo.git")
+	require.NoError(t, err)
+
+	content := bytes.Repeat([]byte("served "), 512)
+	commitLFSFile(t, repo, "data.bin", content)
+	p := lfs.NewPointer(content)
+
+	_, err = runLFSArgs("push")
+	require.NoError(t, err)
+	assert.True(t, lfs.NewStore(remote.GitDir()).Has(p))
+
+	require.NoError(t, os.RemoveAll(filepath.Join(repo.GitDir(), "lfs")))
+	out, err := runLFSArgs("fetch", "origin")
+	require.NoError(t, err)
+	assert.Equal(t, "Downloaded 1 LFS objects\n", out)
+	assert.True(t, lfs.NewStore(repo.GitDir()).Has(p))
+}
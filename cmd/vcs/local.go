@@ -0,0 +1,87 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fenilsonani/vcs/internal/core/gitdir"
+	"github.com/fenilsonani/vcs/internal/serve"
+	"github.com/fenilsonani/vcs/internal/transport"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// isLocalURL reports whether url names a repository on this machine: a
+// file:// URL or a plain path, as opposed to a URL with another scheme or
+// the scp-like [user@]host:path form
+func isLocalURL(url string) bool {
+	if strings.HasPrefix(url, "file://") {
+		return true
+	}
+	if url == "" || strings.Contains(url, "://") {
+		return false
+	}
+	colon := strings.IndexByte(url, ':')
+	return colon < 0 || strings.Contains(url[:colon], "/")
+}
+
+// localURLPath returns the path a local URL names
+func localURLPath(url string) string {
+	return strings.TrimPrefix(url, "file://")
+}
+
+// localGitDir returns the repository directory of the repository at path,
+// which may be bare
+func localGitDir(path string) (string, error) {
+	if dir, err := gitdir.Resolve(filepath.Join(path, ".git")); err == nil {
+		return dir, nil
+	}
+	if _, err := os.Stat(filepath.Join(path, "objects")); err != nil {
+		return "", fmt.Errorf("'%s' does not appear to be a repository", path)
+	}
+	if _, err := os.Stat(filepath.Join(path, "HEAD")); err != nil {
+		return "", fmt.Errorf("'%s' does not appear to be a repository", path)
+	}
+	return path, nil
+}
+
+// isLocalRepository reports whether url names a repository on this machine
+func isLocalRepository(url string) bool {
+	if !isLocalURL(url) {
+		return false
+	}
+	_, err := localGitDir(localURLPath(url))
+	return err == nil
+}
+
+// openLocalRemote opens the repository a local URL names
+func openLocalRemote(url string) (*vcs.Repository, error) {
+	path := localURLPath(url)
+	dir, err := localGitDir(path)
+	if err != nil {
+		return nil, err
+	}
+	return vcs.OpenWithGitDir(path, dir)
+}
+
+// newLocalTransport reaches the repository a local URL names by serving it
+// in this process, so fetches and pushes negotiate exactly as they do with
+// vcs share. Pushes are checked against the repository's own receive.*
+// settings and run its receive hooks.
+func newLocalTransport(url string) (*transport.HTTPTransport, error) {
+	remote, err := openLocalRemote(url)
+	if err != nil {
+		return nil, err
+	}
+	policy, err := receivePolicy(remote.GitDir())
+	if err != nil {
+		return nil, err
+	}
+
+	server := serve.NewServer(remote.GitDir(), remote.Storage())
+	server.SetPackOptions(packWriterOptions(remote.GitDir()))
+	server.AllowPush(policy)
+	server.SetHooks(receiveHooks(os.Stderr, remote.Path(), remote.GitDir()))
+	return transport.NewHandlerTransport("file://"+filepath.ToSlash(remote.Path()), server), nil
+}
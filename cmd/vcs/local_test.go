@@ -0,0 +1,425 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+func TestIsLocalURL(t *testing.T) {
+	tests := []struct {
+		url   string
+		local bool
+	}{
+		{"/srv/repo.git", true},
+		{"../repo", true},
+		{"repo", true},
+		{"file:///srv/repo.git", true},
+		{"./dir:with/colon", true},
+		{"https://example.com/repo.git", false},
+		{"ssh://example.com/repo.git", false},
+		{"git@example.com:repo.git", false},
+		{"example.com:repo.git", false},
+		{"", false},
+	}
+	for _, tt := range tests {
+		assert.Equal(t, tt.local, isLocalURL(tt.url), tt.url)
+	}
+}
+
+// setupPushRepo creates a repository with one commit on main and a bare
+// repository set as its origin
+func setupPushRepo(t *testing.T) (*vcs.Repository, *vcs.Repository) {
+	repo, _ := setupConfigRepo(t)
+	_, err := stageAndCommit(t, repo, "a.txt", "-m", "one")
+	require.NoError(t, err)
+
+	bare, err := initBareRepository(filepath.Join(t.TempDir(), "origin.git"))
+	require.NoError(t, err)
+	_, err = runConfigArgs("remote.origin.url", bare.GitDir())
+	require.NoError(t, err)
+	return repo, bare
+}
+
+func TestCloneFromLocalRepository(t *testing.T) {
+	src, _ := setupConfigRepo(t)
+	_, err := stageAndCommit(t, src, "a.txt", "-m", "one")
+	require.NoError(t, err)
+	head := headCommit(t, src)
+
+	for _, url := range []string{src.Path(), "file://" + src.Path()} {
+		dir := filepath.Join(t.TempDir(), "clone")
+		require.NoError(t, runCloneArgs(url, dir))
+
+		data, err := os.ReadFile(filepath.Join(dir, "a.txt"))
+		require.NoError(t, err)
+		assert.Equal(t, "a.txt\n", string(data))
+		id, err := refs.NewRefManager(filepath.Join(dir, ".git")).ResolveRef("refs/remotes/origin/main")
+		require.NoError(t, err)
+		assert.Equal(t, head, id.String())
+		fetch, _ := loadConfig(filepath.Join(dir, ".git")).Get("remote.origin.fetch")
+		assert.Equal(t, "+refs/heads/*:refs/remotes/origin/*", fetch)
+
+		// Git finds the upstream of the checked out branch
+		if _, err := exec.LookPath("git"); err == nil {
+			out, err := exec.Command("git", "-C", dir, "rev-parse", "--symbolic-full-name", "@{u}").CombinedOutput()
+			require.NoError(t, err, string(out))
+			assert.Equal(t, "refs/remotes/origin/main\n", string(out))
+		}
+	}
+
+	err = runCloneArgs(filepath.Join(t.TempDir(), "missing"), filepath.Join(t.TempDir(), "clone"))
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "does not exist")
+}
+
+func TestShallowCloneFromLocalRepository(t *testing.T) {
+	src, _ := setupConfigRepo(t)
+	for _, file := range []string{"a.txt", "b.txt", "c.txt"} {
+		_, err := stageAndCommit(t, src, file, "-m", file)
+		require.NoError(t, err)
+	}
+	head := headCommit(t, src)
+
+	for _, url := range []string{src.Path(), "file://" + src.Path()} {
+		dir := filepath.Join(t.TempDir(), "clone")
+		require.NoError(t, runCloneArgs("--depth", "1", url, dir))
+
+		clone, err := vcs.Open(dir)
+		require.NoError(t, err)
+		shallow, err := clone.ShallowCommits()
+		require.NoError(t, err)
+		require.Len(t, shallow, 1)
+		assert.Equal(t, head, shallow[0].String())
+		assert.FileExists(t, filepath.Join(dir, "c.txt"))
+	}
+
+	// A shallow clone fetches new commits and deepens its history
+	dir := filepath.Join(t.TempDir(), "clone")
+	require.NoError(t, runCloneArgs("--depth", "1", src.Path(), dir))
+	_, err := stageAndCommit(t, src, "d.txt", "-m", "d.txt")
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	clone, err := vcs.Open(dir)
+	require.NoError(t, err)
+
+	_, err = runFetchArgs("origin")
+	require.NoError(t, err)
+	id, err := refs.NewRefManager(clone.GitDir()).ResolveRef("refs/remotes/origin/main")
+	require.NoError(t, err)
+	assert.Equal(t, headCommit(t, src), id.String())
+	shallow, err := clone.ShallowCommits()
+	require.NoError(t, err)
+	require.Len(t, shallow, 1)
+	assert.Equal(t, head, shallow[0].String(), "the boundary stays where it was")
+
+	_, err = runFetchArgs("--unshallow", "origin")
+	require.NoError(t, err)
+	assert.False(t, clone.IsShallow())
+}
+
+func TestFailedCloneRemovesDirectory(t *testing.T) {
+	src, _ := setupConfigRepo(t)
+	_, err := stageAndCommit(t, src, "a.txt", "-m", "one")
+	require.NoError(t, err)
+
+	dir := filepath.Join(t.TempDir(), "clone")
+	err = runCloneArgs("-b", "nope", src.Path(), dir)
+	assert.ErrorContains(t, err, "remote branch nope not found in upstream origin")
+	assert.NoDirExists(t, dir)
+}
+
+func TestCloneShowsRemoteProgress(t *testing.T) {
+	src, _ := setupConfigRepo(t)
+	_, err := stageAndCommit(t, src, "a.txt", "-m", "one")
+	require.NoError(t, err)
+
+	for _, progress := range []bool{true, false} {
+		var stderr bytes.Buffer
+		cmd := newCloneCommand()
+		cmd.SetOut(&bytes.Buffer{})
+		cmd.SetErr(&stderr)
+		args := []string{src.Path(), filepath.Join(t.TempDir(), "clone")}
+		if progress {
+			args = append(args, "--progress")
+		}
+		cmd.SetArgs(args)
+		require.NoError(t, cmd.Execute())
+
+		if progress {
+			assert.Contains(t, stderr.String(), "remote: Enumerating objects: 3, done.\n")
+			assert.Contains(t, stderr.String(), "Receiving objects: 100% (3/3)")
+			assert.Contains(t, stderr.String(), "Updating files: 100% (1/1), done.\n")
+		} else {
+			assert.NotContains(t, stderr.String(), "remote:")
+			assert.NotContains(t, stderr.String(), "Receiving objects")
+		}
+	}
+}
+
+func TestFetchFromLocalRepository(t *testing.T) {
+	repo, bare := setupPushRepo(t)
+	_, err := runCommandArgs(newPushCommand(), "origin", "main")
+	require.NoError(t, err)
+
+	// Rewind the remote-tracking branch, so the fetch has to restore it
+	refManager := refs.NewRefManager(repo.GitDir())
+	require.NoError(t, refManager.DeleteRef("refs/remotes/origin/main"))
+	_, err = runConfigArgs("remote.origin.url", "file://"+bare.GitDir())
+	require.NoError(t, err)
+
+	_, err = runFetchArgs("origin")
+	require.NoError(t, err)
+	id, err := refManager.ResolveRef("refs/remotes/origin/main")
+	require.NoError(t, err)
+	assert.Equal(t, headCommit(t, repo), id.String())
+}
+
+func TestPushToLocalBareRepository(t *testing.T) {
+	repo, bare := setupPushRepo(t)
+
+	out, err := runCommandArgs(newPushCommand(), "-u", "origin", "main")
+	require.NoError(t, err)
+	assert.Contains(t, out, " * [new branch]       main -> main")
+	head := headCommit(t, repo)
+	remoteRefs := refs.NewRefManager(bare.GitDir())
+	id, err := remoteRefs.ResolveRef("refs/heads/main")
+	require.NoError(t, err)
+	assert.Equal(t, head, id.String())
+	assert.True(t, bare.HasObject(id), "the pushed commit was sent")
+
+	remote, _ := loadConfig(repo.GitDir()).Get("branch.main.remote")
+	assert.Equal(t, "origin", remote)
+
+	out, err = runCommandArgs(newPushCommand(), "origin", "main")
+	require.NoError(t, err)
+	assert.Contains(t, out, "up to date")
+
+	// A fast-forward sends only the new commit's objects
+	_, err = stageAndCommit(t, repo, "b.txt", "-m", "two")
+	require.NoError(t, err)
+	out, err = runCommandArgs(newPushCommand(), "origin", "main")
+	require.NoError(t, err)
+	assert.Contains(t, out, head[:7]+".."+headCommit(t, repo)[:7]+"  main -> main")
+	tracking, err := refs.NewRefManager(repo.GitDir()).ResolveRef("refs/remotes/origin/main")
+	require.NoError(t, err)
+	assert.Equal(t, headCommit(t, repo), tracking.String())
+}
+
+func TestPushShowsProgress(t *testing.T) {
+	setupPushRepo(t)
+
+	var stderr bytes.Buffer
+	cmd := newPushCommand()
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetErr(&stderr)
+	cmd.SetArgs([]string{"--progress", "origin", "main"})
+	require.NoError(t, cmd.Execute())
+	assert.Contains(t, stderr.String(), "Writing objects: 100% (3/3)")
+	assert.Contains(t, stderr.String(), ", done.\n")
+}
+
+// unrelatedCommit creates a root commit holding only name and points
+// branch at it
+func unrelatedCommit(t *testing.T, repo *vcs.Repository, branch, name string) objects.ObjectID {
+	sig := objects.Signature{Name: "Test", Email: "test@example.com", When: time.Unix(1700000000, 0)}
+	blob, err := repo.CreateBlob([]byte(name + "\n"))
+	require.NoError(t, err)
+	tree, err := repo.CreateTree([]objects.TreeEntry{{Mode: objects.ModeBlob, Name: name, ID: blob.ID()}})
+	require.NoError(t, err)
+	commit, err := repo.CreateCommit(tree.ID(), nil, sig, sig, name+"\n")
+	require.NoError(t, err)
+	require.NoError(t, refs.NewRefManager(repo.GitDir()).UpdateRef("refs/heads/"+branch, commit.ID()))
+	return commit.ID()
+}
+
+func TestPushRejectsNonFastForward(t *testing.T) {
+	repo, bare := setupPushRepo(t)
+	_, err := runCommandArgs(newPushCommand(), "origin", "main")
+	require.NoError(t, err)
+	pushed := headCommit(t, repo)
+
+	// Replace main with an unrelated commit
+	commit := unrelatedCommit(t, repo, "main", "other.txt")
+
+	out, err := runCommandArgs(newPushCommand(), "origin", "main")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed to push some refs")
+	assert.Contains(t, out, "(non-fast-forward)")
+	remoteRefs := refs.NewRefManager(bare.GitDir())
+	id, err := remoteRefs.ResolveRef("refs/heads/main")
+	require.NoError(t, err)
+	assert.Equal(t, pushed, id.String())
+
+	out, err = runCommandArgs(newPushCommand(), "--force", "origin", "main")
+	require.NoError(t, err)
+	assert.Contains(t, out, "(forced update)")
+	id, err = remoteRefs.ResolveRef("refs/heads/main")
+	require.NoError(t, err)
+	assert.Equal(t, commit, id)
+}
+
+func TestPushForceWithLease(t *testing.T) {
+	repo, bare := setupPushRepo(t)
+	_, err := runCommandArgs(newPushCommand(), "origin", "main")
+	require.NoError(t, err)
+	remoteRefs := refs.NewRefManager(bare.GitDir())
+
+	// The remote is where it was last fetched, so the lease holds
+	rewritten := unrelatedCommit(t, repo, "main", "rewritten.txt")
+	out, err := runCommandArgs(newPushCommand(), "--force-with-lease", "origin", "main")
+	require.NoError(t, err)
+	assert.Contains(t, out, "(forced update)")
+	id, err := remoteRefs.ResolveRef("refs/heads/main")
+	require.NoError(t, err)
+	assert.Equal(t, rewritten, id)
+
+	// Someone else moves main on the remote
+	theirs := unrelatedCommit(t, repo, "theirs", "theirs.txt")
+	_, err = runCommandArgs(newPushCommand(), "origin", "theirs")
+	require.NoError(t, err)
+	require.NoError(t, remoteRefs.UpdateRef("refs/heads/main", theirs))
+
+	ours := unrelatedCommit(t, repo, "main", "ours.txt")
+	for _, lease := range []string{"--force-with-lease", "--force-with-lease=main", "--force-with-lease=main:"} {
+		out, err = runCommandArgs(newPushCommand(), lease, "origin", "main")
+		require.Error(t, err, lease)
+		assert.Contains(t, out, " ! [rejected]        main -> main (stale info)\n", lease)
+	}
+	id, err = remoteRefs.ResolveRef("refs/heads/main")
+	require.NoError(t, err)
+	assert.Equal(t, theirs, id)
+
+	// Expecting what the remote has lets the push through
+	_, err = runCommandArgs(newPushCommand(), "--force-with-lease=main:theirs", "origin", "main")
+	require.NoError(t, err)
+	id, err = remoteRefs.ResolveRef("refs/heads/main")
+	require.NoError(t, err)
+	assert.Equal(t, ours, id)
+}
+
+func TestPushDelete(t *testing.T) {
+	repo, bare := setupPushRepo(t)
+	_, err := runCommandArgs(newPushCommand(), "origin", "main", "main:topic")
+	require.NoError(t, err)
+	require.NoError(t, os.MkdirAll(filepath.Join(repo.GitDir(), "hooks"), 0755))
+	writeHook(t, repo, "pre-push", "cat > \"$GIT_DIR/pre-push.in\"\n")
+
+	out, err := runCommandArgs(newPushCommand(), "origin", ":topic")
+	require.NoError(t, err)
+	assert.Contains(t, out, " - [deleted]          topic\n")
+	assert.False(t, refs.NewRefManager(bare.GitDir()).RefExists("refs/heads/topic"))
+	assert.False(t, refs.NewRefManager(repo.GitDir()).RefExists("refs/remotes/origin/topic"))
+	input, err := os.ReadFile(filepath.Join(repo.GitDir(), "pre-push.in"))
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("(delete) %s refs/heads/topic %s\n", objects.ObjectID{}, headCommit(t, repo)), string(input))
+
+	var stderr bytes.Buffer
+	cmd := newPushCommand()
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetErr(&stderr)
+	cmd.SetArgs([]string{"origin", ":topic"})
+	require.Error(t, cmd.Execute())
+	assert.Contains(t, stderr.String(), "error: unable to delete 'topic': remote ref does not exist\n")
+}
+
+func TestPushAtomic(t *testing.T) {
+	repo, bare := setupPushRepo(t)
+	_, err := runCommandArgs(newPushCommand(), "origin", "main")
+	require.NoError(t, err)
+	remoteRefs := refs.NewRefManager(bare.GitDir())
+
+	// main can no longer be fast-forwarded, so topic is not pushed either
+	_, err = stageAndCommit(t, repo, "b.txt", "-m", "two")
+	require.NoError(t, err)
+	_, err = runCommandArgs(newBranchCommand(), "topic")
+	require.NoError(t, err)
+	unrelatedCommit(t, repo, "main", "other.txt")
+
+	out, err := runCommandArgs(newPushCommand(), "--atomic", "origin", "main", "topic")
+	require.Error(t, err)
+	assert.Contains(t, out, " ! [rejected]        main -> main (non-fast-forward)\n")
+	assert.Contains(t, out, " ! [rejected]        topic -> topic (atomic push failed)\n")
+	assert.False(t, remoteRefs.RefExists("refs/heads/topic"))
+
+	// A hook refusing one refuses both on the remote
+	require.NoError(t, os.MkdirAll(filepath.Join(bare.GitDir(), "hooks"), 0755))
+	writeHook(t, bare, "update", "test \"$1\" != refs/heads/main\n")
+	out, err = runCommandArgs(newPushCommand(), "--atomic", "--force", "origin", "main", "topic")
+	require.Error(t, err)
+	assert.Contains(t, out, " ! [remote rejected] main -> main (hook declined)\n")
+	assert.Contains(t, out, " ! [remote rejected] topic -> topic (atomic push failure)\n")
+	assert.False(t, remoteRefs.RefExists("refs/heads/topic"))
+
+	// Without --atomic the rest is pushed
+	_, err = runCommandArgs(newPushCommand(), "--force", "origin", "main", "topic")
+	require.Error(t, err)
+	assert.True(t, remoteRefs.RefExists("refs/heads/topic"))
+}
+
+func TestPushOptions(t *testing.T) {
+	_, bare := setupPushRepo(t)
+	require.NoError(t, os.MkdirAll(filepath.Join(bare.GitDir(), "hooks"), 0755))
+	writeHook(t, bare, "pre-receive", "echo \"$GIT_PUSH_OPTION_COUNT $GIT_PUSH_OPTION_0 $GIT_PUSH_OPTION_1\" > \"$GIT_DIR/options\"\n")
+
+	_, err := runCommandArgs(newPushCommand(), "-o", "ci.skip", "--push-option", "reason=test", "origin", "main")
+	require.NoError(t, err)
+	options, err := os.ReadFile(filepath.Join(bare.GitDir(), "options"))
+	require.NoError(t, err)
+	assert.Equal(t, "2 ci.skip reason=test\n", string(options))
+}
+
+func TestPushRefusesCheckedOutBranch(t *testing.T) {
+	isolateConfig(t)
+	other, err := vcs.Init(filepath.Join(t.TempDir(), "other"))
+	require.NoError(t, err)
+
+	setupPushRepo(t)
+	_, err = runConfigArgs("remote.origin.url", other.Path())
+	require.NoError(t, err)
+
+	out, err := runCommandArgs(newPushCommand(), "origin", "main")
+	require.Error(t, err)
+	assert.Contains(t, out, "[remote rejected] main -> main (branch is currently checked out)")
+
+	// Other branches can be pushed
+	_, err = runCommandArgs(newPushCommand(), "origin", "main:topic")
+	require.NoError(t, err)
+	assert.True(t, refs.NewRefManager(other.GitDir()).RefExists("refs/heads/topic"))
+}
+
+func TestPushRunsReceiveHooks(t *testing.T) {
+	repo, bare := setupPushRepo(t)
+	require.NoError(t, os.MkdirAll(filepath.Join(bare.GitDir(), "hooks"), 0755))
+	writeHook(t, bare, "pre-receive", "cat > \"$GIT_DIR/pre-receive.in\"\nexit 1\n")
+
+	out, err := runCommandArgs(newPushCommand(), "origin", "main")
+	require.Error(t, err)
+	assert.Contains(t, out, "[remote rejected] main -> main (pre-receive hook declined)")
+	assert.False(t, refs.NewRefManager(bare.GitDir()).RefExists("refs/heads/main"))
+	input, err := os.ReadFile(filepath.Join(bare.GitDir(), "pre-receive.in"))
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("%s %s refs/heads/main\n", objects.ObjectID{}, headCommit(t, repo)), string(input))
+
+	writeHook(t, bare, "pre-receive", "exit 0\n")
+	writeHook(t, bare, "update", "test \"$1\" != refs/heads/main\n")
+	writeHook(t, bare, "post-receive", "cat > \"$GIT_DIR/post-receive.in\"\n")
+	out, err = runCommandArgs(newPushCommand(), "origin", "main", "main:topic")
+	require.Error(t, err)
+	assert.Contains(t, out, "[remote rejected] main -> main (hook declined)")
+	assert.Contains(t, out, "[new branch]       main -> topic")
+	input, err = os.ReadFile(filepath.Join(bare.GitDir(), "post-receive.in"))
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("%s %s refs/heads/topic\n", objects.ObjectID{}, headCommit(t, repo)), string(input))
+}
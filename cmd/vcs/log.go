@@ -2,9 +2,13 @@ package main
 
 import (
 	"fmt"
+	"io"
+	"os"
 	"strings"
 	"time"
 
+	"github.com/fenilsonani/vcs/internal/core/history"
+	"github.com/fenilsonani/vcs/internal/core/notes"
 	"github.com/fenilsonani/vcs/internal/core/objects"
 	"github.com/fenilsonani/vcs/internal/core/refs"
 	"github.com/fenilsonani/vcs/pkg/vcs"
@@ -15,14 +19,46 @@ func newLogCommand() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "log",
 		Short: "Show commit logs",
-		Long:  `Shows the commit logs starting from the current HEAD.`,
-		RunE:  runLog,
+		Long: `Shows the commit logs starting from the current HEAD, or from the given
+revisions as in "vcs log [<revision-range>] [[--] <path>]". Ranges such as
+A..B, A...B and ^A leave out the commits they exclude. Given a path, only
+commits that changed it are shown; with --follow the file's history
+continues across renames.
+
+Notes of the default notes ref are shown under the message unless
+--oneline, --pretty or --no-notes is given; --notes=<ref> shows those of
+another ref instead, and may be repeated.
+
+--stat shows the files each commit changed with a graph of the lines
+added and removed, --numstat the number of lines added and removed in
+each file and --shortstat only the totals. Renames are found as in
+"vcs diff -M" unless --no-renames is given or diff.renames is false, and
+copies with -C.
+
+On a terminal the log is paged, with commit IDs in color.diff.commit.
+
+--json, or --format=json, writes the commits as a JSON array, each with
+its parents, author, committer, message and notes, and with --stat the
+files it changed. Any other --format is a --pretty format.`,
+		Args: cobra.ArbitraryArgs,
+		RunE: runLog,
 	}
 
 	cmd.Flags().IntP("max-count", "n", 0, "Limit the number of commits to output")
 	cmd.Flags().Bool("oneline", false, "Show each commit on a single line")
 	cmd.Flags().Bool("graph", false, "Show a text-based graphical representation of the commit history")
 	cmd.Flags().StringP("pretty", "", "", "Pretty-print the contents of the commit logs")
+	cmd.Flags().String("format", "", "Pretty-print the commit logs in the given format, or as JSON for json")
+	cmd.Flags().Bool("json", false, "Write the commits as JSON")
+	cmd.Flags().Bool("follow", false, "Continue listing the history of a file beyond renames")
+	cmd.Flags().StringArray("notes", nil, "Show the notes of the notes ref given, or of the default notes ref")
+	cmd.Flags().Lookup("notes").NoOptDefVal = notes.DefaultRef
+	cmd.Flags().Bool("no-notes", false, "Do not show notes")
+	cmd.Flags().Bool("stat", false, "Show a diffstat of the files each commit changed")
+	cmd.Flags().Bool("numstat", false, "Show the lines added and removed in each file each commit changed")
+	cmd.Flags().Bool("shortstat", false, "Show only the totals of the diffstat of each commit")
+	addDetectionFlags(cmd)
+	addColorFlags(cmd)
 
 	return cmd
 }
@@ -35,7 +71,7 @@ func runLog(cmd *cobra.Command, args []string) error {
 	}
 
 	// Open repository
-	repo, err := vcs.Open(repoPath)
+	repo, err := openRepository(repoPath)
 	if err != nil {
 		return fmt.Errorf("failed to open repository: %w", err)
 	}
@@ -45,70 +81,287 @@ func runLog(cmd *cobra.Command, args []string) error {
 	oneline, _ := cmd.Flags().GetBool("oneline")
 	showGraph, _ := cmd.Flags().GetBool("graph")
 	prettyFormat, _ := cmd.Flags().GetString("pretty")
+	follow, _ := cmd.Flags().GetBool("follow")
+	notesRefs, _ := cmd.Flags().GetStringArray("notes")
+	noNotes, _ := cmd.Flags().GetBool("no-notes")
+	showStat, _ := cmd.Flags().GetBool("stat")
+	numStat, _ := cmd.Flags().GetBool("numstat")
+	shortStat, _ := cmd.Flags().GetBool("shortstat")
+	// The most detailed of the stats asked for is shown
+	var writeStat func(io.Writer, []*fileDiff)
+	switch {
+	case showStat:
+		writeStat = writeDiffStat
+	case numStat:
+		writeStat = writeNumStat
+	case shortStat:
+		writeStat = writeShortStat
+	}
+	asJSON, _ := cmd.Flags().GetBool("json")
+	if format, _ := cmd.Flags().GetString("format"); format == "json" {
+		asJSON = true
+	} else if format != "" {
+		prettyFormat = format
+	}
+	if asJSON && (oneline || showGraph || prettyFormat != "") {
+		return fmt.Errorf("--json cannot be used with --oneline, --graph or --pretty")
+	}
+	detection, err := readDetection(cmd, repo.GitDir(), true, "diff.renames")
+	if err != nil {
+		return err
+	}
+	var colors *palette
+	if !asJSON {
+		startPager(repo.GitDir(), "log", true)
+		if colors, err = newPalette(cmd, repo.GitDir(), "diff"); err != nil {
+			return err
+		}
+	}
+
+	// Revisions come first, then at most one path, with an optional "--"
+	// between them. Without "--" an argument is a path unless it names a
+	// revision.
+	resolver := newResolver(repo)
+	revArgs, pathArgs := args, []string(nil)
+	if dash := cmd.ArgsLenAtDash(); dash >= 0 {
+		revArgs, pathArgs = args[:dash], args[dash:]
+	} else {
+		for i, arg := range args {
+			if _, err := resolver.ResolveRange(arg); err != nil {
+				revArgs, pathArgs = args[:i], args[i:]
+				break
+			}
+		}
+	}
+	if len(pathArgs) > 1 {
+		return fmt.Errorf("only one path may be given")
+	}
+
+	var path string
+	if len(pathArgs) > 0 {
+		if path, err = repoRelativePath(repoPath, pathArgs[0]); err != nil {
+			return err
+		}
+	} else if follow {
+		return fmt.Errorf("--follow requires exactly one pathspec")
+	}
+	renameThreshold := 0
+	if follow {
+		renameThreshold = history.DefaultRenameThreshold
+		if detection.Renames > 0 {
+			renameThreshold = detection.Renames
+		}
+	}
+
+	var starts, excluded []objects.ObjectID
+	for _, arg := range revArgs {
+		r, err := resolver.ResolveRange(arg)
+		if err != nil {
+			return err
+		}
+		starts = append(starts, r.Include...)
+		excluded = append(excluded, r.Exclude...)
+	}
 
-	// Get reference manager
-	refManager := refs.NewRefManager(repo.GitDir())
+	if len(revArgs) == 0 {
+		// Get current HEAD
+		currentCommitID, _, err := refs.NewRefManager(repo.GitDir()).HEAD()
+		if err != nil {
+			return fmt.Errorf("failed to get HEAD: %w", err)
+		}
+
+		if currentCommitID.IsZero() {
+			if asJSON {
+				return writeJSON(cmd.OutOrStdout(), []jsonCommit{})
+			}
+			fmt.Println("No commits found")
+			return nil
+		}
+		starts = []objects.ObjectID{currentCommitID}
+	}
+
+	// Notes are shown in the default format unless asked for
+	if len(notesRefs) == 0 && !oneline && prettyFormat == "" {
+		notesRefs = []string{notes.DefaultRef}
+	}
+	var noteSets []logNotes
+	if !noNotes {
+		if noteSets, err = readLogNotes(repo, notesRefs); err != nil {
+			return err
+		}
+	}
 
-	// Get current HEAD
-	currentCommitID, _, err := refManager.HEAD()
+	hidden, err := resolver.Reachable(excluded)
 	if err != nil {
-		return fmt.Errorf("failed to get HEAD: %w", err)
+		return err
 	}
 
-	if currentCommitID.IsZero() {
-		fmt.Println("No commits found")
-		return nil
+	shallowCommits, err := repo.ShallowCommits()
+	if err != nil {
+		return err
+	}
+	shallow := make(map[objects.ObjectID]bool)
+	for _, id := range shallowCommits {
+		shallow[id] = true
 	}
 
-	// Walk commit history
+	// Walk commit history, following the first parent of each starting
+	// commit and showing the newest pending commit first
 	commitCount := 0
-	commitID := currentCommitID
+	listed := []jsonCommit{}
+	pending := make(map[objects.ObjectID]*objects.Commit)
+	for _, id := range starts {
+		if hidden[id] {
+			continue
+		}
+		commit, err := history.ReadCommit(repo, id)
+		if err != nil {
+			return fmt.Errorf("failed to read commit %s: %w", id.String(), err)
+		}
+		pending[id] = commit
+	}
 
-	for !commitID.IsZero() {
+	for len(pending) > 0 {
 		if maxCount > 0 && commitCount >= maxCount {
 			break
 		}
 
-		// Read commit object
-		obj, err := repo.ReadObject(commitID)
-		if err != nil {
-			return fmt.Errorf("failed to read commit %s: %w", commitID.String(), err)
+		var commitID objects.ObjectID
+		var commit *objects.Commit
+		for id, c := range pending {
+			if commit == nil || c.Committer().When.After(commit.Committer().When) {
+				commitID, commit = id, c
+			}
 		}
+		delete(pending, commitID)
+		hidden[commitID] = true
 
-		commit, ok := obj.(*objects.Commit)
-		if !ok {
-			return fmt.Errorf("object %s is not a commit", commitID.String())
+		// History ends at shallow boundaries; their parents were not fetched
+		parents := commit.Parents()
+		if shallow[commitID] {
+			parents = nil
 		}
 
-		// Print commit
-		if oneline {
-			printCommitOneline(commitID, commit)
-		} else if prettyFormat != "" {
-			printCommitPretty(commitID, commit, prettyFormat)
-		} else {
-			printCommitFull(commitID, commit, showGraph, commitCount == 0)
+		show := true
+		if path != "" {
+			var parentTree objects.ObjectID
+			if len(parents) > 0 {
+				parent, err := history.ReadCommit(repo, parents[0])
+				if err != nil {
+					return err
+				}
+				parentTree = parent.Tree()
+			}
+
+			change, err := history.FileChange(repo, parentTree, commit.Tree(), path, renameThreshold)
+			if err != nil {
+				return err
+			}
+			show = change != nil
+			// Older commits know the file by its name before the rename
+			if change != nil && change.Type == history.Renamed {
+				path = change.OldPath
+			}
 		}
 
-		// Get parent commit
-		parents := commit.Parents()
-		if len(parents) == 0 {
-			break
+		if show && asJSON {
+			c, err := newJSONCommit(commitID, commit, parents, noteSets)
+			if err != nil {
+				return err
+			}
+			if writeStat != nil {
+				diffs, err := logStatDiffs(repo, commit, parents, detection)
+				if err != nil {
+					return err
+				}
+				// Merges have no diffstat, as in the text log
+				if len(parents) <= 1 {
+					c.Stat = newJSONStat(diffs)
+				}
+			}
+			listed = append(listed, c)
+			commitCount++
+		} else if show {
+			noteText, err := commitNotes(noteSets, commitID)
+			if err != nil {
+				return err
+			}
+			if oneline {
+				printCommitOneline(commitID, commit, colors)
+			} else if prettyFormat != "" {
+				printCommitPretty(commitID, commit, prettyFormat, noteText, colors)
+			} else {
+				printCommitFull(commitID, commit, showGraph, commitCount == 0, noteText, colors)
+			}
+			if writeStat != nil {
+				compact := oneline || prettyFormat == "oneline"
+				if err := printLogStat(repo, commit, parents, detection, writeStat, !compact); err != nil {
+					return err
+				}
+			}
+			commitCount++
 		}
 
 		// For now, just follow the first parent
-		commitID = parents[0]
-		commitCount++
+		if len(parents) > 0 && !hidden[parents[0]] {
+			parent, err := history.ReadCommit(repo, parents[0])
+			if err != nil {
+				return fmt.Errorf("failed to read commit %s: %w", parents[0].String(), err)
+			}
+			pending[parents[0]] = parent
+		}
+	}
+
+	if asJSON {
+		return writeJSON(cmd.OutOrStdout(), listed)
 	}
+	return nil
+}
 
+// printLogStat prints the diffstat of commit against its parent, or for a
+// root commit against the empty tree, with writeStat. Merges show none, as
+// in Git. With separate a blank line follows, as it does after a full
+// commit.
+func printLogStat(repo *vcs.Repository, commit *objects.Commit, parents []objects.ObjectID, d history.Detection, writeStat func(io.Writer, []*fileDiff), separate bool) error {
+	diffs, err := logStatDiffs(repo, commit, parents, d)
+	if err != nil {
+		return err
+	}
+	if len(diffs) == 0 {
+		return nil
+	}
+	writeStat(os.Stdout, diffs)
+	if separate {
+		fmt.Println()
+	}
 	return nil
 }
 
-func printCommitOneline(commitID objects.ObjectID, commit *objects.Commit) {
+// logStatDiffs returns the diffs of the files commit changed against its
+// parent, or against the empty tree for a root commit, and nil for a merge
+func logStatDiffs(repo *vcs.Repository, commit *objects.Commit, parents []objects.ObjectID, d history.Detection) ([]*fileDiff, error) {
+	if len(parents) > 1 {
+		return nil, nil
+	}
+	var parentTree objects.ObjectID
+	if len(parents) == 1 {
+		parent, err := history.ReadCommit(repo, parents[0])
+		if err != nil {
+			return nil, err
+		}
+		parentTree = parent.Tree()
+	}
+	return treeFileDiffs(repo, newConverter(repo, io.Discard, nil), parentTree, commit.Tree(), d)
+}
+
+func printCommitOneline(commitID objects.ObjectID, commit *objects.Commit, colors *palette) {
 	message := strings.Split(strings.TrimSpace(commit.Message()), "\n")[0]
-	fmt.Printf("%s %s\n", commitID.String()[:7], message)
+	fmt.Printf("%s %s\n", colors.paint("commit", commitID.String()[:7]), message)
 }
 
-func printCommitFull(commitID objects.ObjectID, commit *objects.Commit, showGraph bool, isFirst bool) {
+// printCommitFull prints a commit in the default format, followed by
+// commitNotes, its notes as commitNotes formats them
+func printCommitFull(commitID objects.ObjectID, commit *objects.Commit, showGraph bool, isFirst bool, commitNotes string, colors *palette) {
 	prefix := ""
 	if showGraph {
 		if isFirst {
@@ -118,7 +371,7 @@ func printCommitFull(commitID objects.ObjectID, commit *objects.Commit, showGrap
 		}
 	}
 
-	fmt.Printf("%scommit %s\n", prefix, commitID.String())
+	fmt.Printf("%s%s\n", prefix, colors.paint("commit", "commit "+commitID.String()))
 
 	parents := commit.Parents()
 	if len(parents) > 1 {
@@ -138,20 +391,21 @@ func printCommitFull(commitID objects.ObjectID, commit *objects.Commit, showGrap
 	for _, line := range messageLines {
 		fmt.Printf("    %s\n", line)
 	}
+	fmt.Print(commitNotes)
 	fmt.Println()
 }
 
-func printCommitPretty(commitID objects.ObjectID, commit *objects.Commit, format string) {
+func printCommitPretty(commitID objects.ObjectID, commit *objects.Commit, format string, commitNotes string, colors *palette) {
 	// Simple pretty format implementation
 	switch format {
 	case "oneline":
-		printCommitOneline(commitID, commit)
+		printCommitOneline(commitID, commit, colors)
 	case "short":
-		fmt.Printf("commit %s\n", commitID.String()[:7])
+		fmt.Println(colors.paint("commit", "commit "+commitID.String()[:7]))
 		fmt.Printf("Author: %s\n", commit.Author().Name)
 		fmt.Printf("\n    %s\n\n", strings.TrimSpace(commit.Message()))
 	default:
-		printCommitFull(commitID, commit, false, true)
+		printCommitFull(commitID, commit, false, true, commitNotes, colors)
 	}
 }
 
@@ -196,7 +196,7 @@ func TestPrintCommitOneline(t *testing.T) {
 	r, w, _ := os.Pipe()
 	os.Stdout = w
 
-	printCommitOneline(commitID, commit)
+	printCommitOneline(commitID, commit, nil)
 
 	w.Close()
 	os.Stdout = oldStdout
@@ -236,7 +236,7 @@ func TestPrintCommitFull(t *testing.T) {
 	r, w, _ := os.Pipe()
 	os.Stdout = w
 
-	printCommitFull(commitID, commit, false, true)
+	printCommitFull(commitID, commit, false, true, "", nil)
 
 	w.Close()
 	os.Stdout = oldStdout
@@ -272,4 +272,120 @@ func TestFormatDate(t *testing.T) {
 			t.Errorf("Formatted date missing expected part %q\nGot: %s", part, formatted)
 		}
 	}
-}
\ No newline at end of file
+}
+// captureStdout returns what fn prints to standard output
+func captureStdout(t *testing.T, fn func() error) (string, error) {
+	oldStdout := os.Stdout
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Stdout = w
+
+	done := make(chan string)
+	go func() {
+		var buf bytes.Buffer
+		buf.ReadFrom(r)
+		done <- buf.String()
+	}()
+
+	fnErr := fn()
+	w.Close()
+	os.Stdout = oldStdout
+	return <-done, fnErr
+}
+
+func runLogArgs(t *testing.T, args ...string) (string, error) {
+	return captureStdout(t, func() error {
+		cmd := newLogCommand()
+		cmd.SetArgs(args)
+		return cmd.Execute()
+	})
+}
+
+func TestLogPathFollow(t *testing.T) {
+	_, commits := setupRenameRepo(t)
+	short := func(i int) string { return commits[i].String()[:7] }
+
+	// Without --follow history stops where new.txt appeared
+	out, err := runLogArgs(t, "--oneline", "new.txt")
+	if err != nil {
+		t.Fatalf("log failed: %v", err)
+	}
+	if want := short(2) + " rename old to new\n"; out != want {
+		t.Errorf("log new.txt = %q, want %q", out, want)
+	}
+
+	out, err = runLogArgs(t, "--oneline", "--follow", "new.txt")
+	if err != nil {
+		t.Fatalf("log --follow failed: %v", err)
+	}
+	want := short(2) + " rename old to new\n" + short(1) + " edit old\n" + short(0) + " add old\n"
+	if out != want {
+		t.Errorf("log --follow new.txt = %q, want %q", out, want)
+	}
+
+	out, err = runLogArgs(t, "--oneline", "-n", "2", "--follow", "new.txt")
+	if err != nil {
+		t.Fatalf("log --follow failed: %v", err)
+	}
+	if strings.Count(out, "\n") != 2 {
+		t.Errorf("log -n 2 --follow printed %q, want 2 commits", out)
+	}
+
+	if _, err := runLogArgs(t, "--follow"); err == nil || !strings.Contains(err.Error(), "--follow requires exactly one pathspec") {
+		t.Errorf("log --follow without a path error = %v", err)
+	}
+}
+
+func TestLogStat(t *testing.T) {
+	_, commits := setupRenameRepo(t)
+	short := func(i int) string { return commits[i].String()[:7] }
+
+	out, err := runLogArgs(t, "--oneline", "--stat", "-n", "2")
+	if err != nil {
+		t.Fatalf("log --stat failed: %v", err)
+	}
+	want := short(3) + " edit other\n" +
+		" other.txt | 2 +-\n" +
+		" 1 file changed, 1 insertion(+), 1 deletion(-)\n" +
+		short(2) + " rename old to new\n" +
+		" old.txt => new.txt | 1 +\n" +
+		" 1 file changed, 1 insertion(+)\n"
+	if out != want {
+		t.Errorf("log --oneline --stat = %q, want %q", out, want)
+	}
+
+	out, err = runLogArgs(t, "--oneline", "--stat", "--no-renames", "-n", "1", commits[2].String())
+	if err != nil {
+		t.Fatalf("log --stat --no-renames failed: %v", err)
+	}
+	if !strings.Contains(out, " new.txt | 6 ++++++\n old.txt | 5 -----\n") {
+		t.Errorf("log --stat --no-renames = %q", out)
+	}
+}
+
+func TestLogNumstat(t *testing.T) {
+	_, commits := setupRenameRepo(t)
+	short := func(i int) string { return commits[i].String()[:7] }
+
+	out, err := runLogArgs(t, "--oneline", "--numstat", "-n", "2")
+	if err != nil {
+		t.Fatalf("log --numstat failed: %v", err)
+	}
+	want := short(3) + " edit other\n" +
+		"1\t1\tother.txt\n" +
+		short(2) + " rename old to new\n" +
+		"1\t0\told.txt => new.txt\n"
+	if out != want {
+		t.Errorf("log --oneline --numstat = %q, want %q", out, want)
+	}
+
+	out, err = runLogArgs(t, "--oneline", "--shortstat", "-n", "1")
+	if err != nil {
+		t.Fatalf("log --shortstat failed: %v", err)
+	}
+	if want := short(3) + " edit other\n 1 file changed, 1 insertion(+), 1 deletion(-)\n"; out != want {
+		t.Errorf("log --oneline --shortstat = %q, want %q", out, want)
+	}
+}
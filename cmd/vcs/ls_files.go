@@ -0,0 +1,217 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/index"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// lsFilesOptions holds the flags of ls-files
+type lsFilesOptions struct {
+	cached          bool
+	deleted         bool
+	modified        bool
+	others          bool
+	ignored         bool
+	stage           bool
+	excludeStandard bool
+	fullName        bool
+	nulTerm         bool
+}
+
+func newLsFilesCommand() *cobra.Command {
+	var opts lsFilesOptions
+
+	cmd := &cobra.Command{
+		Use:   "ls-files [flags] [--] [<pathspec>...]",
+		Short: "Show information about files in the index and the working tree",
+		Long: `Lists the files in the index, one per line, relative to the current
+directory and limited to it unless pathspecs say otherwise.
+
+-d lists the tracked files missing from the working tree, -m those
+modified or missing, and -o the untracked files; -c lists the index
+again when combined with them. --exclude-standard leaves out what the
+.gitignore files, info/exclude and core.excludesFile ignore, and -i
+lists only that instead, with -o or -c.
+
+With -s each file of the index is shown in the format
+
+  <mode> <object> <stage>	<file>
+
+giving every stage of a conflicted file. With -z each line ends with NUL
+instead of a newline.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if opts.ignored && !opts.others && !opts.cached {
+				return fmt.Errorf("ls-files -i must be used with either -o or -c")
+			}
+			if opts.ignored && !opts.excludeStandard {
+				return fmt.Errorf("ls-files -i needs --exclude-standard")
+			}
+			return runLsFiles(cmd.OutOrStdout(), args, opts)
+		},
+	}
+
+	cmd.Flags().BoolVarP(&opts.cached, "cached", "c", false, "Show the files in the index (the default)")
+	cmd.Flags().BoolVarP(&opts.deleted, "deleted", "d", false, "Show the tracked files deleted from the working tree")
+	cmd.Flags().BoolVarP(&opts.modified, "modified", "m", false, "Show the tracked files modified or deleted in the working tree")
+	cmd.Flags().BoolVarP(&opts.others, "others", "o", false, "Show the untracked files")
+	cmd.Flags().BoolVarP(&opts.ignored, "ignored", "i", false, "Show only the ignored files")
+	cmd.Flags().BoolVarP(&opts.stage, "stage", "s", false, "Show the mode, object and stage of each file")
+	cmd.Flags().BoolVar(&opts.excludeStandard, "exclude-standard", false, "Apply the standard ignore rules")
+	cmd.Flags().BoolVar(&opts.fullName, "full-name", false, "Show paths relative to the top of the working tree")
+	cmd.Flags().BoolVarP(&opts.nulTerm, "null", "z", false, "End each line with NUL instead of a newline")
+
+	return cmd
+}
+
+func runLsFiles(out io.Writer, args []string, opts lsFilesOptions) error {
+	repoPath, err := findRepository()
+	if err != nil {
+		return fmt.Errorf("not a git repository: %w", err)
+	}
+	repo, err := openRepository(repoPath)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+	specs, err := cwdPathspecs(repoPath, args)
+	if err != nil {
+		return err
+	}
+	idx, err := readIndex(repo)
+	if err != nil {
+		return err
+	}
+	scanner := newScanner(repo)
+
+	// The index is listed unless only other kinds are asked for
+	if !opts.deleted && !opts.modified && !opts.others {
+		opts.cached = true
+	}
+	if opts.stage {
+		opts.cached = true
+	}
+
+	w := bufio.NewWriter(out)
+	defer w.Flush()
+	end := byte('\n')
+	if opts.nulTerm {
+		end = 0
+	}
+	show := func(p string) string {
+		if opts.fullName {
+			return p
+		}
+		return cwdRelativePath(filepath.Join(repoPath, filepath.FromSlash(p)))
+	}
+
+	// The index is sorted by path, so the kinds of one file come together
+	var hashes *statCache
+	if opts.modified {
+		hashes = newStatCache(repo, newConverter(repo, io.Discard, nil), readStatusCache(repo), nil, time.Now())
+	}
+	for _, entry := range idx.Entries() {
+		if !matchPathspecs(entry.Path, specs) {
+			continue
+		}
+		if opts.ignored && !scanner.IsIgnored(entry.Path) {
+			continue
+		}
+		if opts.cached {
+			if opts.stage {
+				fmt.Fprintf(w, "%06o %s %d\t", uint32(entry.Mode), entry.ID, entry.Stage())
+			}
+			w.WriteString(show(entry.Path))
+			w.WriteByte(end)
+		}
+		if entry.SkipWorktree || entry.Stage() > 1 {
+			continue
+		}
+		if opts.deleted || opts.modified {
+			state := entryWorkTreeState(repo, hashes, entry)
+			if opts.deleted && state == workTreeDeleted {
+				w.WriteString(show(entry.Path))
+				w.WriteByte(end)
+			}
+			if opts.modified && state != workTreeUnchanged {
+				w.WriteString(show(entry.Path))
+				w.WriteByte(end)
+			}
+		}
+	}
+
+	if opts.others {
+		files, err := scanner.ScanFiles()
+		if err != nil {
+			return fmt.Errorf("failed to scan working directory: %w", err)
+		}
+		var others []string
+		for _, file := range files {
+			if _, tracked := idx.Get(file.Path); tracked || !matchPathspecs(file.Path, specs) {
+				continue
+			}
+			if opts.excludeStandard && scanner.IsIgnored(file.Path) != opts.ignored {
+				continue
+			}
+			others = append(others, file.Path)
+		}
+		sort.Strings(others)
+		for _, p := range others {
+			w.WriteString(show(p))
+			w.WriteByte(end)
+		}
+	}
+	return nil
+}
+
+// workTreeState is how the working tree file of an index entry differs
+// from it
+type workTreeState int
+
+const (
+	workTreeUnchanged workTreeState = iota
+	workTreeModified
+	workTreeDeleted
+)
+
+// entryWorkTreeState compares the working tree file of entry with it,
+// hashing its content with hashes when its type is unchanged, or taking it
+// as unchanged when hashes is nil
+func entryWorkTreeState(repo *vcs.Repository, hashes *statCache, entry *index.Entry) workTreeState {
+	info, err := os.Lstat(filepath.Join(repo.WorkDir(), filepath.FromSlash(entry.Path)))
+	if err != nil {
+		return workTreeDeleted
+	}
+	switch {
+	case entry.Mode == objects.ModeCommit:
+		if !info.IsDir() {
+			return workTreeModified
+		}
+		return workTreeUnchanged
+	case entry.Mode == objects.ModeSymlink:
+		target, err := os.Readlink(filepath.Join(repo.WorkDir(), filepath.FromSlash(entry.Path)))
+		if err != nil || repo.HashData([]byte(target)) != entry.ID {
+			return workTreeModified
+		}
+		return workTreeUnchanged
+	case !info.Mode().IsRegular():
+		return workTreeModified
+	}
+	if hashes == nil {
+		return workTreeUnchanged
+	}
+	id, err := hashes.hash(entry.Path)
+	if err != nil || id != entry.ID {
+		return workTreeModified
+	}
+	return workTreeUnchanged
+}
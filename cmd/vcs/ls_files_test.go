@@ -0,0 +1,63 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLsFiles(t *testing.T) {
+	setupTreeRepo(t, map[string]string{
+		".gitignore":  "*.log\n",
+		"a.txt":       "a\n",
+		"b.txt":       "b\n",
+		"src/main.go": "package main\n",
+	})
+	require.NoError(t, os.WriteFile("a.txt", []byte("changed\n"), 0644))
+	require.NoError(t, os.Remove("b.txt"))
+	require.NoError(t, os.WriteFile("new.txt", []byte("new\n"), 0644))
+	require.NoError(t, os.WriteFile("debug.log", []byte("log\n"), 0644))
+
+	out, err := runCommandArgs(newLsFilesCommand())
+	require.NoError(t, err)
+	assert.Equal(t, ".gitignore\na.txt\nb.txt\nsrc/main.go\n", out)
+
+	out, err = runCommandArgs(newLsFilesCommand(), "-m")
+	require.NoError(t, err)
+	assert.Equal(t, "a.txt\nb.txt\n", out)
+
+	out, err = runCommandArgs(newLsFilesCommand(), "-d", "-z")
+	require.NoError(t, err)
+	assert.Equal(t, "b.txt\x00", out)
+
+	out, err = runCommandArgs(newLsFilesCommand(), "-o")
+	require.NoError(t, err)
+	assert.Equal(t, "debug.log\nnew.txt\n", out)
+
+	out, err = runCommandArgs(newLsFilesCommand(), "-o", "--exclude-standard")
+	require.NoError(t, err)
+	assert.Equal(t, "new.txt\n", out)
+
+	out, err = runCommandArgs(newLsFilesCommand(), "-o", "-i", "--exclude-standard")
+	require.NoError(t, err)
+	assert.Equal(t, "debug.log\n", out)
+
+	out, err = runCommandArgs(newLsFilesCommand(), "-s", "src")
+	require.NoError(t, err)
+	assert.Regexp(t, "^100644 [0-9a-f]{40} 0\tsrc/main.go\n$", out)
+
+	// Paths are limited to and shown relative to the current directory
+	require.NoError(t, os.Chdir("src"))
+	out, err = runCommandArgs(newLsFilesCommand())
+	require.NoError(t, err)
+	assert.Equal(t, "main.go\n", out)
+
+	out, err = runCommandArgs(newLsFilesCommand(), "..", "--full-name")
+	require.NoError(t, err)
+	assert.Equal(t, ".gitignore\na.txt\nb.txt\nsrc/main.go\n", out)
+
+	_, err = runCommandArgs(newLsFilesCommand(), "-i")
+	assert.Error(t, err)
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// lsTreeOptions holds the flags of ls-tree
+type lsTreeOptions struct {
+	recursive bool
+	onlyTrees bool
+	showTrees bool
+	long      bool
+	nameOnly  bool
+	nulTerm   bool
+	fullName  bool
+	fullTree  bool
+}
+
+func newLsTreeCommand() *cobra.Command {
+	var opts lsTreeOptions
+
+	cmd := &cobra.Command{
+		Use:   "ls-tree [flags] <tree-ish> [<path>...]",
+		Short: "List the contents of a tree object",
+		Long: `Lists the entries of a tree, or of the tree of a commit, one per line:
+
+  <mode> <type> <object>	<path>
+
+Run in a subdirectory, only the entries below it are listed, relative to
+it, as if the directory were given as a path ending in a slash; --full-tree
+lists from the top instead. A path names the entry itself, or with a
+trailing slash the entries inside it.
+
+-r recurses into subtrees, showing them only with -t; -d shows only tree
+entries. -l adds the size of each blob, right-aligned, or "-" for trees
+and submodules. --name-only prints only the paths and -z ends each line
+with NUL instead of a newline.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runLsTree(cmd.OutOrStdout(), args[0], args[1:], opts)
+		},
+	}
+
+	cmd.Flags().BoolVarP(&opts.recursive, "recursive", "r", false, "Recurse into subtrees")
+	cmd.Flags().BoolVarP(&opts.onlyTrees, "dirs-only", "d", false, "Show only tree entries")
+	cmd.Flags().BoolVarP(&opts.showTrees, "trees", "t", false, "Show tree entries even when recursing into them")
+	cmd.Flags().BoolVarP(&opts.long, "long", "l", false, "Show the size of blobs")
+	cmd.Flags().BoolVar(&opts.nameOnly, "name-only", false, "Show only the paths")
+	cmd.Flags().BoolVar(&opts.nameOnly, "name-status", false, "Show only the paths")
+	cmd.Flags().BoolVarP(&opts.nulTerm, "null", "z", false, "End each line with NUL instead of a newline")
+	cmd.Flags().BoolVar(&opts.fullName, "full-name", false, "Show paths relative to the top of the tree")
+	cmd.Flags().BoolVar(&opts.fullTree, "full-tree", false, "List the whole tree, wherever it is run from")
+
+	return cmd
+}
+
+func runLsTree(out io.Writer, treeish string, args []string, opts lsTreeOptions) error {
+	repoPath, err := findRepository()
+	if err != nil {
+		return fmt.Errorf("not a git repository: %w", err)
+	}
+	repo, err := openRepository(repoPath)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+	treeID, err := newResolver(repo).ResolveTree(treeish)
+	if err != nil {
+		return fmt.Errorf("not a tree object: %s", treeish)
+	}
+
+	// The current directory, relative to the top, ends in a slash
+	prefix := ""
+	if !opts.fullTree {
+		if prefix, err = pathspecPath(repoPath, "."); err != nil {
+			return err
+		}
+		if prefix != "" {
+			prefix += "/"
+		}
+	} else {
+		opts.fullName = true
+	}
+
+	// A spec ending in a slash lists what is inside the tree it names
+	var specs []string
+	for _, arg := range args {
+		full := arg
+		if opts.fullTree {
+			full = filepath.Join(repoPath, filepath.FromSlash(arg))
+		}
+		spec, err := pathspecPath(repoPath, full)
+		if err != nil {
+			return err
+		}
+		if spec == "" || strings.HasSuffix(arg, "/") || strings.HasSuffix(arg, string(os.PathSeparator)) || filepath.Base(arg) == "." {
+			spec += "/"
+		}
+		specs = append(specs, spec)
+	}
+	if len(specs) == 0 {
+		specs = []string{"/"}
+		if prefix != "" {
+			specs = []string{prefix}
+		}
+	}
+
+	l := &treeLister{repo: repo, w: bufio.NewWriter(out), specs: specs, prefix: prefix, opts: opts}
+	defer l.w.Flush()
+	return l.list(treeID, "")
+}
+
+// treeLister prints the entries of a tree for ls-tree
+type treeLister struct {
+	repo   *vcs.Repository
+	w      *bufio.Writer
+	specs  []string
+	prefix string
+	opts   lsTreeOptions
+}
+
+// list prints the entries of the tree id, found at dir
+func (l *treeLister) list(id objects.ObjectID, dir string) error {
+	obj, err := l.repo.ReadObject(id)
+	if err != nil {
+		return fmt.Errorf("failed to read tree %s: %w", id.Short(), err)
+	}
+	tree, ok := obj.(*objects.Tree)
+	if !ok {
+		return fmt.Errorf("not a tree object: %s", id)
+	}
+	for _, entry := range tree.Entries() {
+		p := joinTreePath(dir, entry.Name)
+		isTree := entry.Mode == objects.ModeTree
+		show, descend := l.match(p, isTree)
+		if descend {
+			// Trees recursed into are shown only when asked for
+			show = l.opts.showTrees || l.opts.onlyTrees && l.opts.recursive
+		}
+		if show && (isTree || !l.opts.onlyTrees) {
+			if err := l.print(entry, p); err != nil {
+				return err
+			}
+		}
+		if descend {
+			if err := l.list(entry.ID, p); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// match reports whether the entry at p is shown and, for a tree, whether
+// its entries are listed
+func (l *treeLister) match(p string, isTree bool) (show, descend bool) {
+	for _, spec := range l.specs {
+		base := strings.TrimSuffix(spec, "/")
+		switch {
+		case base == "" || strings.HasPrefix(p, base+"/"):
+			// Inside the tree named, whose own entries are listed
+			rest := strings.TrimPrefix(p, base+"/")
+			show = show || l.opts.recursive || base != spec && !strings.Contains(rest, "/")
+			descend = descend || isTree && l.opts.recursive
+		case p == base:
+			if isTree && base != spec {
+				descend = true
+			} else {
+				show = true
+				descend = descend || isTree && l.opts.recursive
+			}
+		case isTree && strings.HasPrefix(base, p+"/"):
+			descend = true
+		}
+	}
+	return show, descend
+}
+
+// print writes the line of entry, found at p
+func (l *treeLister) print(entry objects.TreeEntry, p string) error {
+	name := p
+	if !l.opts.fullName {
+		name = treePathRelative(p, l.prefix)
+	}
+	end := byte('\n')
+	if l.opts.nulTerm {
+		end = 0
+	}
+	if l.opts.nameOnly {
+		l.w.WriteString(name)
+		l.w.WriteByte(end)
+		return nil
+	}
+
+	kind := "blob"
+	switch entry.Mode {
+	case objects.ModeTree:
+		kind = "tree"
+	case objects.ModeCommit:
+		kind = "commit"
+	}
+	fmt.Fprintf(l.w, "%06o %s %s", uint32(entry.Mode), kind, entry.ID)
+	if l.opts.long {
+		size := "-"
+		if kind == "blob" {
+			_, data, err := l.repo.ReadRawObject(entry.ID)
+			if err != nil {
+				return fmt.Errorf("failed to read blob %s: %w", entry.ID.Short(), err)
+			}
+			size = strconv.Itoa(len(data))
+		}
+		fmt.Fprintf(l.w, " %7s", size)
+	}
+	l.w.WriteByte('\t')
+	l.w.WriteString(name)
+	l.w.WriteByte(end)
+	return nil
+}
+
+// joinTreePath joins a slash separated directory, "" for the top, and a name
+func joinTreePath(dir, name string) string {
+	if dir == "" {
+		return name
+	}
+	return dir + "/" + name
+}
+
+// treePathRelative returns p, relative to the top, relative to the
+// directory prefix, which is "" or ends in a slash
+func treePathRelative(p, prefix string) string {
+	if strings.HasPrefix(p, prefix) {
+		return p[len(prefix):]
+	}
+	rel, err := filepath.Rel(filepath.FromSlash(prefix), filepath.FromSlash(p))
+	if err != nil {
+		return p
+	}
+	return filepath.ToSlash(rel)
+}
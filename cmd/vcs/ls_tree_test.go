@@ -0,0 +1,66 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLsTree(t *testing.T) {
+	setupTreeRepo(t, map[string]string{
+		"a.txt":        "hello\n",
+		"src/main.go":  "package main\n",
+		"src/lib/x.go": "package lib\n",
+	})
+	repo, _ := openRepository(".")
+	resolver := newResolver(repo)
+	src, err := resolver.Resolve("HEAD:src")
+	require.NoError(t, err)
+	blob, err := resolver.Resolve("HEAD:a.txt")
+	require.NoError(t, err)
+
+	out, err := runCommandArgs(newLsTreeCommand(), "HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, "100644 blob "+blob.String()+"\ta.txt\n040000 tree "+src.String()+"\tsrc\n", out)
+
+	out, err = runCommandArgs(newLsTreeCommand(), "-r", "--name-only", "HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, "a.txt\nsrc/lib/x.go\nsrc/main.go\n", out)
+
+	out, err = runCommandArgs(newLsTreeCommand(), "-r", "-t", "--name-only", "-z", "HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, "a.txt\x00src\x00src/lib\x00src/lib/x.go\x00src/main.go\x00", out)
+
+	out, err = runCommandArgs(newLsTreeCommand(), "-d", "-r", "--name-only", "HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, "src\nsrc/lib\n", out)
+
+	out, err = runCommandArgs(newLsTreeCommand(), "-l", "HEAD", "a.txt", "src")
+	require.NoError(t, err)
+	assert.Equal(t, "100644 blob "+blob.String()+"       6\ta.txt\n040000 tree "+src.String()+"       -\tsrc\n", out)
+
+	// A trailing slash lists what is inside the tree
+	out, err = runCommandArgs(newLsTreeCommand(), "--name-only", "HEAD", "src/")
+	require.NoError(t, err)
+	assert.Equal(t, "src/lib\nsrc/main.go\n", out)
+
+	// In a subdirectory only its entries are listed, relative to it
+	require.NoError(t, os.Chdir("src"))
+	out, err = runCommandArgs(newLsTreeCommand(), "--name-only", "HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, "lib\nmain.go\n", out)
+
+	out, err = runCommandArgs(newLsTreeCommand(), "--name-only", "HEAD", "../a.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "../a.txt\n", out)
+
+	out, err = runCommandArgs(newLsTreeCommand(), "--name-only", "--full-tree", "HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, "a.txt\nsrc\n", out)
+
+	_, err = runCommandArgs(newLsTreeCommand(), "HEAD:a.txt")
+	assert.True(t, err != nil && strings.Contains(err.Error(), "not a tree object"))
+}
@@ -3,8 +3,12 @@ package main
 import (
 	"fmt"
 	"os"
+	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/trace"
 )
 
 var (
@@ -18,8 +22,11 @@ func main() {
 		Use:   "vcs",
 		Short: "A high-performance custom git implementation",
 		Long: `VCS is a high-performance version control system compatible with Git.
-It provides optimized performance for large repositories and seamless GitHub integration.`,
+It provides optimized performance for large repositories and seamless GitHub integration.
+` + globalOptionsHelp,
 		Version: fmt.Sprintf("%s (commit: %s, built: %s)", version, commit, date),
+		// Refuse commands that would write before they start
+		PersistentPreRunE: refuseWrites,
 	}
 
 	// Add hardware check flag
@@ -39,27 +46,114 @@ It provides optimized performance for large repositories and seamless GitHub int
 	rootCmd.AddCommand(
 		newInitCommand(),
 		newCloneCommand(),
+		newBundleCommand(),
+		newFastExportCommand(),
+		newFastImportCommand(),
 		newHashObjectCommand(),
 		newCatFileCommand(),
+		newDiffTreeCommand(),
+		newLsTreeCommand(),
+		newLsFilesCommand(),
+		newUpdateIndexCommand(),
+		newReadTreeCommand(),
+		newWriteTreeCommand(),
+		newUpdateRefCommand(),
+		newSymbolicRefCommand(),
+		newForEachRefCommand(),
+		newRevParseCommand(),
+		newRevListCommand(),
+		newMergeBaseCommand(),
 		newStatusCommand(),
 		newAddCommand(),
+		newRmCommand(),
+		newMvCommand(),
 		newCommitCommand(),
 		newLogCommand(),
 		newBranchCommand(),
 		newCheckoutCommand(),
+		newSwitchCommand(),
 		newDiffCommand(),
+		newBlameCommand(),
+		newGrepCommand(),
+		newBisectCommand(),
 		newMergeCommand(),
+		newRebaseCommand(),
+		newCherryPickCommand(),
+		newFormatPatchCommand(),
+		newApplyCommand(),
+		newAmCommand(),
+		newMergetoolCommand(),
+		newRerereCommand(),
+		newSeriesCommand(),
 		newResetCommand(),
+		newCleanCommand(),
+		newCheckIgnoreCommand(),
 		newTagCommand(),
+		newReplaceCommand(),
+		newNotesCommand(),
+		newInterpretTrailersCommand(),
+		newShortlogCommand(),
+		newDescribeCommand(),
+		newArchiveCommand(),
+		newVerifyCommitCommand(),
+		newVerifyTagCommand(),
 		newRemoteCommand(),
 		newFetchCommand(),
 		newPushCommand(),
 		newPullCommand(),
+		newShareCommand(),
+		newServeCommand(),
+		newDaemonCommand(),
 		newStashCommand(),
+		newWorktreeCommand(),
+		newSubmoduleCommand(),
+		newLFSCommand(),
+		newUndoCommand(),
+		newTimelineCommand(),
+		newCredentialCommand(),
+		newPRCommand(),
+		newConfigCommand(),
+		newGCCommand(),
+		newPackRefsCommand(),
+		newFsckCommand(),
+		newMaintenanceCommand(),
+		newForEachRepoCommand(),
+		newMetricsCommand(),
+		newSelftestCommand(),
 		newBenchmarkCommand(),
 	)
 
-	if err := rootCmd.Execute(); err != nil {
+	// Apply -C, --git-dir and --work-tree before anything reads the repository
+	args, err := parseGlobalOptions(os.Args[1:])
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "vcs: %v\n", err)
+		os.Exit(1)
+	}
+
+	limitMemory()
+
+	// Expand aliases and correct mistyped command names before dispatch
+	args, err = newAutocorrector(rootCmd).resolve(args)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	rootCmd.SetArgs(args)
+	if globalOptions.paginate {
+		startPager(currentGitDir(), "", true)
+	}
+
+	trace.General.Printf("trace: built-in: vcs %s", strings.Join(args, " "))
+	start := time.Now()
+	recorder := recordCommand(rootCmd, args)
+	err = rootCmd.Execute()
+	stopPager()
+	recorder.finish(os.Stderr)
+	trace.Performance.Since(start, "vcs command: vcs %s", strings.Join(args, " "))
+	if globalOptions.perf {
+		printPerfStats(os.Stderr)
+	}
+	if err != nil {
 		os.Exit(1)
 	}
 }
\ No newline at end of file
@@ -0,0 +1,456 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/commitgraph"
+	"github.com/fenilsonani/vcs/internal/core/config"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/packfile"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/internal/metrics"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// maxLoosePacked bounds how many loose objects one run of the
+// loose-objects task packs, as in Git, so that a scheduled run stays short
+const maxLoosePacked = 50000
+
+// maintenanceSchedule is how often a task runs from the scheduler. The
+// values are ordered so that a run for one schedule also runs the tasks of
+// the more frequent ones.
+type maintenanceSchedule int
+
+const (
+	scheduleNone maintenanceSchedule = iota
+	scheduleWeekly
+	scheduleDaily
+	scheduleHourly
+)
+
+// maintenanceScheduleNames are the names of the schedules, by value
+var maintenanceScheduleNames = []string{"none", "weekly", "daily", "hourly"}
+
+func (s maintenanceSchedule) String() string {
+	return maintenanceScheduleNames[s]
+}
+
+// parseMaintenanceSchedule parses the name of a schedule
+func parseMaintenanceSchedule(name string) (maintenanceSchedule, error) {
+	for i, n := range maintenanceScheduleNames {
+		if n == name {
+			return maintenanceSchedule(i), nil
+		}
+	}
+	return scheduleNone, fmt.Errorf("unknown schedule '%s'", name)
+}
+
+// maintenanceTask is one job of vcs maintenance run
+type maintenanceTask struct {
+	name string
+	// enabled says whether the task runs when maintenance.<name>.enabled
+	// is not set
+	enabled bool
+	// schedule is how often the scheduler runs the task when
+	// maintenance.<name>.schedule is not set
+	schedule maintenanceSchedule
+	run      func(out, errOut io.Writer, repo *vcs.Repository, quiet bool) error
+}
+
+// maintenanceTasks are the tasks in the order they run
+var maintenanceTasks = []maintenanceTask{
+	{name: "prefetch", enabled: true, schedule: scheduleHourly, run: func(out, errOut io.Writer, repo *vcs.Repository, quiet bool) error {
+		return prefetchRemotes(out, repo, quiet)
+	}},
+	{name: "gc", run: func(out, errOut io.Writer, repo *vcs.Repository, quiet bool) error {
+		return gcRepository(out, errOut, repo, gcOptions{quiet: quiet})
+	}},
+	{name: "commit-graph", enabled: true, schedule: scheduleHourly, run: func(out, errOut io.Writer, repo *vcs.Repository, quiet bool) error {
+		n, err := writeCommitGraph(repo)
+		if err == nil && !quiet {
+			fmt.Fprintf(out, "Wrote commit-graph of %d commits\n", n)
+		}
+		return err
+	}},
+	{name: "loose-objects", enabled: true, schedule: scheduleDaily, run: func(out, errOut io.Writer, repo *vcs.Repository, quiet bool) error {
+		n, err := packLooseObjects(repo)
+		if err == nil && !quiet {
+			fmt.Fprintf(out, "Packed %d loose objects\n", n)
+		}
+		return err
+	}},
+	{name: "multi-pack-index", enabled: true, schedule: scheduleDaily, run: func(out, errOut io.Writer, repo *vcs.Repository, quiet bool) error {
+		n, err := packfile.WriteMultiPackIndex(repo.Storage().PackDir())
+		if err == nil && !quiet {
+			fmt.Fprintf(out, "Wrote multi-pack-index of %d packs\n", n)
+		}
+		return err
+	}},
+}
+
+func newMaintenanceCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "maintenance",
+		Short: "Run tasks to optimize repository data",
+		Long: `Runs tasks that keep a repository fast to work in, either now with run or
+in the background with start.
+
+start registers the current repository and schedules vcs maintenance run
+to be run in every registered repository each hour, with the daily and
+weekly tasks added once a day and once a week. stop removes the schedule,
+and unregister takes the current repository off it.`,
+	}
+
+	var (
+		tasks    []string
+		schedule string
+		quiet    bool
+	)
+	run := &cobra.Command{
+		Use:   "run",
+		Short: "Run maintenance tasks",
+		Long: `Runs the maintenance tasks given with --task, in the order given, or
+else every enabled task. The tasks are:
+
+  prefetch          fetch the branches of each remote into
+                    refs/prefetch/remotes/<remote>/, so that the next fetch
+                    has the objects already; remote-tracking branches are
+                    left alone
+  gc                collect garbage as vcs gc does
+  commit-graph      write objects/info/commit-graph, which caches the
+                    parents and generation numbers of every commit reachable
+                    from the refs so that log and merge-base queries need
+                    not read each commit
+  loose-objects     remove loose objects that are also packed, and pack up
+                    to 50000 of the others
+  multi-pack-index  write objects/pack/multi-pack-index, a single index of
+                    the objects of every pack
+
+All tasks but gc are enabled by default; maintenance.<task>.enabled
+changes that. The commit-graph is not written in shallow repositories.
+
+--schedule=hourly, daily or weekly runs the enabled tasks due at that
+frequency, as the scheduler set up by vcs maintenance start does: a daily
+run includes the hourly tasks and a weekly run every scheduled task.
+prefetch and commit-graph run hourly, loose-objects and multi-pack-index
+daily, and gc not at all; maintenance.<task>.schedule changes that, and
+may also be none.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			runSchedule := scheduleNone
+			if schedule != "" {
+				var err error
+				if runSchedule, err = parseMaintenanceSchedule(schedule); err != nil || runSchedule == scheduleNone {
+					return fmt.Errorf("invalid --schedule '%s': expected hourly, daily or weekly", schedule)
+				}
+				if len(tasks) > 0 {
+					return fmt.Errorf("options '--task' and '--schedule' cannot be used together")
+				}
+			}
+
+			repoPath, err := findRepository()
+			if err != nil {
+				return fmt.Errorf("not a git repository: %w", err)
+			}
+			repo, err := openStoredRepository(repoPath)
+			if err != nil {
+				return err
+			}
+			if readOnlyRepository(repo.GitDir()) {
+				return fmt.Errorf("cannot run maintenance in a read-only repository")
+			}
+
+			selected, err := selectMaintenanceTasks(repo.GitDir(), tasks, runSchedule)
+			if err != nil {
+				return err
+			}
+			for _, task := range selected {
+				start := time.Now()
+				err := task.run(cmd.OutOrStdout(), cmd.ErrOrStderr(), repo, quiet)
+				// gc records its own runs
+				if task.name != "gc" {
+					recordRun(cmd.ErrOrStderr(), repo, metrics.KindMaintenance, task.name, start, err)
+				}
+				if err != nil {
+					return fmt.Errorf("task '%s' failed: %w", task.name, err)
+				}
+			}
+			return nil
+		},
+	}
+	run.Flags().StringArrayVar(&tasks, "task", nil, "Run only the given task (repeatable)")
+	run.Flags().StringVar(&schedule, "schedule", "", "Run the tasks due at this frequency: hourly, daily or weekly")
+	run.Flags().BoolVarP(&quiet, "quiet", "q", false, "Do not report what the tasks did")
+	cmd.AddCommand(run)
+
+	cmd.AddCommand(newMaintenanceRegisterCommand(), newMaintenanceUnregisterCommand(),
+		newMaintenanceStartCommand(), newMaintenanceStopCommand(), newMaintenanceDaemonCommand())
+
+	return cmd
+}
+
+// selectMaintenanceTasks returns the tasks named, or else the enabled ones,
+// only those due at schedule when it is not scheduleNone
+func selectMaintenanceTasks(gitDir string, names []string, schedule maintenanceSchedule) ([]maintenanceTask, error) {
+	var selected []maintenanceTask
+	if len(names) > 0 {
+		for _, name := range names {
+			found := false
+			for _, task := range maintenanceTasks {
+				if task.name == name {
+					selected = append(selected, task)
+					found = true
+					break
+				}
+			}
+			if !found {
+				return nil, fmt.Errorf("'%s' is not a valid task", name)
+			}
+		}
+		return selected, nil
+	}
+
+	cfg := loadConfig(gitDir)
+	for _, task := range maintenanceTasks {
+		enabled := task.enabled
+		if value, ok := cfg.Get("maintenance." + task.name + ".enabled"); ok {
+			b, err := config.ParseBool(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid maintenance.%s.enabled: %w", task.name, err)
+			}
+			enabled = b
+		}
+		if !enabled {
+			continue
+		}
+		if schedule != scheduleNone {
+			due := task.schedule
+			if value, ok := cfg.Get("maintenance." + task.name + ".schedule"); ok {
+				var err error
+				if due, err = parseMaintenanceSchedule(value); err != nil {
+					return nil, fmt.Errorf("invalid maintenance.%s.schedule: %w", task.name, err)
+				}
+			}
+			if due < schedule {
+				continue
+			}
+		}
+		selected = append(selected, task)
+	}
+	return selected, nil
+}
+
+// prefetchRemotes fetches the branches of every remote into
+// refs/prefetch/remotes/<remote>/. Remotes are fetched from as vcs fetch
+// does, and those it cannot fetch from over the Git protocol are skipped.
+func prefetchRemotes(out io.Writer, repo *vcs.Repository, quiet bool) error {
+	remotes, err := getRemotes(repo)
+	if err != nil {
+		return fmt.Errorf("failed to get remotes: %w", err)
+	}
+	names := make([]string, 0, len(remotes))
+	for name := range remotes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		remoteURL := remotes[name]
+		if !isLocalRepository(remoteURL) && !isBundleURL(remoteURL) && !isHTTPURL(remoteURL) {
+			continue
+		}
+		n, err := prefetchRemote(repo, name, remoteURL)
+		if err != nil {
+			return fmt.Errorf("failed to prefetch %s: %w", name, err)
+		}
+		if !quiet {
+			fmt.Fprintf(out, "Prefetched %d branches from %s\n", n, name)
+		}
+	}
+	return nil
+}
+
+// prefetchRemote fetches the branches of one remote, pointing the refs
+// under refs/prefetch/remotes/<remote>/ at them, and returns how many there
+// are
+func prefetchRemote(repo *vcs.Repository, remoteName, remoteURL string) (int, error) {
+	// There is no command line to take --limit-rate or --progress from
+	cmd := &cobra.Command{}
+	cmd.SetOut(io.Discard)
+	cmd.SetErr(io.Discard)
+
+	httpTransport, err := openHTTPTransport(cmd, repo, remoteName, remoteURL)
+	if err != nil {
+		return 0, err
+	}
+	discovery, err := httpTransport.DiscoverRefs(context.Background(), "git-upload-pack")
+	if err != nil {
+		return 0, fmt.Errorf("failed to read refs of %s: %w", remoteURL, err)
+	}
+	heads := make(map[string]objects.ObjectID)
+	for refName, objectID := range discovery.Refs {
+		if !strings.HasPrefix(refName, "refs/heads/") {
+			continue
+		}
+		if id, err := objects.NewObjectID(objectID); err == nil {
+			heads[strings.TrimPrefix(refName, "refs/heads/")] = id
+		}
+	}
+	if err := fetchPackForHeads(cmd, repo, httpTransport, discovery, heads, shallowOptions{}, false); err != nil {
+		return 0, err
+	}
+
+	refManager := refs.NewRefManager(repo.GitDir())
+	prefix := "refs/prefetch/remotes/" + remoteName + "/"
+	existing, err := refManager.AllRefs()
+	if err != nil {
+		return 0, fmt.Errorf("failed to list refs: %w", err)
+	}
+	// Branches deleted on the remote are dropped
+	for refName := range existing {
+		if strings.HasPrefix(refName, prefix) {
+			if _, ok := heads[strings.TrimPrefix(refName, prefix)]; !ok {
+				if err := refManager.DeleteRef(refName); err != nil {
+					return 0, fmt.Errorf("failed to delete %s: %w", refName, err)
+				}
+			}
+		}
+	}
+	for branch, id := range heads {
+		if err := refManager.UpdateRef(prefix+branch, id); err != nil {
+			return 0, fmt.Errorf("failed to update %s: %w", prefix+branch, err)
+		}
+	}
+	return len(heads), nil
+}
+
+// packLooseObjects removes the loose objects a pack also holds, then packs
+// up to maxLoosePacked of the rest into a new pack and removes them, and
+// returns how many it packed
+func packLooseObjects(repo *vcs.Repository) (int, error) {
+	storage := repo.Storage()
+	loose, err := storage.LooseObjects()
+	if err != nil {
+		return 0, err
+	}
+	packed := storage.PackedObjects()
+
+	var toPack []*packfile.Object
+	for _, id := range loose {
+		if packed != nil && packed.HasPackedObject(id) {
+			if err := storage.RemoveLooseObject(id); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		if len(toPack) == maxLoosePacked {
+			continue
+		}
+		objType, data, err := storage.ReadRawObject(id)
+		if err != nil {
+			return 0, fmt.Errorf("failed to read object %s: %w", id, err)
+		}
+		toPack = append(toPack, &packfile.Object{ID: id, Type: objType, Data: data})
+	}
+	if len(toPack) == 0 {
+		return 0, nil
+	}
+
+	if _, _, err := packfile.SavePack(storage.PackDir(), toPack, packWriterOptions(repo.GitDir())); err != nil {
+		return 0, err
+	}
+	for _, obj := range toPack {
+		if err := storage.RemoveLooseObject(obj.ID); err != nil {
+			return 0, err
+		}
+	}
+	return len(toPack), nil
+}
+
+// gcWritesCommitGraph reports whether gc writes the commit-graph, as it
+// does unless gc.writeCommitGraph is false
+func gcWritesCommitGraph(gitDir string) bool {
+	value, ok := loadConfig(gitDir).Get("gc.writeCommitGraph")
+	if !ok {
+		return true
+	}
+	enabled, err := config.ParseBool(value)
+	return err != nil || enabled
+}
+
+// writeCommitGraph writes the commit-graph of the commits reachable from
+// the refs and the HEAD of every worktree, reusing what the previous graph
+// knows, and returns the number of commits in it. Shallow repositories get
+// no graph and lose any they had.
+func writeCommitGraph(repo *vcs.Repository) (int, error) {
+	objectsDir := filepath.Join(repo.CommonDir(), "objects")
+	if fileExists(filepath.Join(repo.CommonDir(), "shallow")) {
+		if err := os.Remove(commitgraph.Path(objectsDir)); err != nil && !os.IsNotExist(err) {
+			return 0, fmt.Errorf("failed to remove commit-graph: %w", err)
+		}
+		return 0, nil
+	}
+
+	tips, err := commitGraphTips(repo)
+	if err != nil {
+		return 0, err
+	}
+	// A damaged graph is rebuilt from the commits
+	previous, _ := commitgraph.Load(objectsDir)
+	commits, err := commitgraph.Build(repo, tips, previous)
+	if err != nil {
+		return 0, err
+	}
+	if err := commitgraph.Save(objectsDir, commits); err != nil {
+		return 0, err
+	}
+	return len(commits), nil
+}
+
+// commitGraphTips returns the commits the refs and worktree HEADs point
+// at, peeling tags
+func commitGraphTips(repo *vcs.Repository) ([]objects.ObjectID, error) {
+	allRefs, err := refs.NewRefManager(repo.GitDir()).AllRefs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list refs: %w", err)
+	}
+	var ids []objects.ObjectID
+	for _, id := range allRefs {
+		ids = append(ids, id)
+	}
+	for _, gitDir := range worktreeGitDirs(repo.CommonDir()) {
+		if id, _, err := refs.NewRefManager(gitDir).HEAD(); err == nil {
+			ids = append(ids, id)
+		}
+	}
+
+	var tips []objects.ObjectID
+	seen := make(map[objects.ObjectID]bool)
+	for _, id := range ids {
+		for !seen[id] {
+			seen[id] = true
+			obj, err := repo.ReadObject(id)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read object %s: %w", id, err)
+			}
+			if tag, ok := obj.(*objects.Tag); ok {
+				id = tag.Object()
+				continue
+			}
+			// Tags of trees and blobs have no history
+			if obj.Type() == objects.TypeCommit {
+				tips = append(tips, id)
+			}
+		}
+	}
+	return tips, nil
+}
@@ -0,0 +1,754 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/config"
+)
+
+// maintenanceRepoKey lists, in the global config, the repositories that
+// scheduled maintenance runs in
+const maintenanceRepoKey = "maintenance.repo"
+
+// vcsExecutable returns the path of the running vcs, which the scheduled
+// jobs run. Tests replace it.
+var vcsExecutable = os.Executable
+
+// lookPath finds the scheduler commands; tests replace it
+var lookPath = exec.LookPath
+
+// runSchedulerCommand runs a command of the system's scheduler with stdin
+// as its input and returns its output. Tests replace it, so that they never
+// touch the real scheduler.
+var runSchedulerCommand = func(stdin, name string, args ...string) (string, error) {
+	cmd := exec.Command(name, args...)
+	cmd.Stdin = strings.NewReader(stdin)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%s failed: %s", name, msg)
+		}
+		return "", fmt.Errorf("%s failed: %w", name, err)
+	}
+	return string(out), nil
+}
+
+// maintenanceScheduler installs and removes the jobs that run vcs
+// maintenance run --schedule in the registered repositories
+type maintenanceScheduler struct {
+	name string
+	// install schedules the jobs to run exe, replacing any it scheduled
+	// before
+	install func(exe string) error
+	// remove unschedules the jobs, reporting whether there were any
+	remove func() (bool, error)
+}
+
+// maintenanceSchedulers are the schedulers start can use
+var maintenanceSchedulers = []maintenanceScheduler{
+	{name: "crontab", install: installCrontab, remove: removeCrontab},
+	{name: "systemd-timer", install: installSystemdTimers, remove: removeSystemdTimers},
+	{name: "launchctl", install: installLaunchAgents, remove: removeLaunchAgents},
+	{name: "internal", install: startInternalScheduler, remove: stopInternalScheduler},
+}
+
+func newMaintenanceRegisterCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "register",
+		Short: "Add the current repository to scheduled maintenance",
+		Long: `Adds the current repository to the maintenance.repo values of the
+global config, which the jobs scheduled by vcs maintenance start run in.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path, err := maintenanceRepoPath()
+			if err != nil {
+				return err
+			}
+			cmd.SilenceUsage = true
+			_, err = registerMaintenance(path)
+			return err
+		},
+	}
+}
+
+func newMaintenanceUnregisterCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "unregister",
+		Short: "Remove the current repository from scheduled maintenance",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path, err := maintenanceRepoPath()
+			if err != nil {
+				return err
+			}
+			cmd.SilenceUsage = true
+			_, err = unregisterMaintenance(path)
+			return err
+		},
+	}
+}
+
+func newMaintenanceStartCommand() *cobra.Command {
+	var scheduler string
+
+	cmd := &cobra.Command{
+		Use:   "start",
+		Short: "Register the current repository and schedule maintenance",
+		Long: `Registers the current repository as vcs maintenance register does and
+schedules maintenance of every registered repository with one of these
+schedulers:
+
+  crontab        the user's crontab
+  systemd-timer  timers of the user's systemd instance, whose units are
+                 written under ~/.config/systemd/user
+  launchctl      launchd agents, written under ~/Library/LaunchAgents
+  internal       a vcs maintenance daemon started in the background, for
+                 systems with none of the others; it does not survive a
+                 reboot
+
+The default, auto, picks launchctl on macOS, the internal scheduler on
+Windows, and elsewhere systemd-timer when the user's systemd runs, else
+crontab when it is installed, else the internal scheduler. Maintenance
+scheduled with another scheduler is removed.
+
+The jobs run vcs for-each-repo --config=maintenance.repo maintenance run
+--schedule=<frequency>, hourly, and daily and weekly at midnight.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			chosen, err := chooseMaintenanceScheduler(scheduler)
+			if err != nil {
+				return err
+			}
+			path, err := maintenanceRepoPath()
+			if err != nil {
+				return err
+			}
+			cmd.SilenceUsage = true
+
+			exe, err := vcsExecutable()
+			if err != nil {
+				return fmt.Errorf("failed to find the vcs executable: %w", err)
+			}
+			if _, err := registerMaintenance(path); err != nil {
+				return err
+			}
+			for _, s := range maintenanceSchedulers {
+				if s.name == chosen.name {
+					continue
+				}
+				if _, err := s.remove(); err != nil {
+					return fmt.Errorf("failed to remove the %s schedule: %w", s.name, err)
+				}
+			}
+			if err := chosen.install(exe); err != nil {
+				return fmt.Errorf("failed to schedule maintenance with %s: %w", chosen.name, err)
+			}
+			fmt.Fprintf(cmd.OutOrStdout(), "Scheduled maintenance of %s with %s\n", path, chosen.name)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&scheduler, "scheduler", "auto", "Scheduler to use: auto, crontab, systemd-timer, launchctl or internal")
+
+	return cmd
+}
+
+func newMaintenanceStopCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "stop",
+		Short: "Stop scheduled maintenance",
+		Long: `Removes the jobs vcs maintenance start scheduled, with whichever
+scheduler holds them. The repositories stay registered, so a later start
+schedules them again.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			stopped := false
+			for _, s := range maintenanceSchedulers {
+				removed, err := s.remove()
+				if err != nil {
+					return fmt.Errorf("failed to remove the %s schedule: %w", s.name, err)
+				}
+				if removed {
+					fmt.Fprintf(cmd.OutOrStdout(), "Removed the %s schedule\n", s.name)
+					stopped = true
+				}
+			}
+			if !stopped {
+				fmt.Fprintln(cmd.OutOrStdout(), "No maintenance was scheduled")
+			}
+			return nil
+		},
+	}
+}
+
+func newMaintenanceDaemonCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "daemon",
+		Short: "Run scheduled maintenance in the foreground",
+		Long: `Runs the maintenance of the registered repositories on the schedule the
+other schedulers follow, until interrupted. vcs maintenance start
+--scheduler=internal runs it in the background.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			exe, err := vcsExecutable()
+			if err != nil {
+				return fmt.Errorf("failed to find the vcs executable: %w", err)
+			}
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			return runMaintenanceDaemon(ctx, cmd.OutOrStdout(), cmd.ErrOrStderr(), exe)
+		},
+	}
+}
+
+// maintenanceRepoPath returns the path the current repository is
+// registered under
+func maintenanceRepoPath() (string, error) {
+	repoPath, err := findRepository()
+	if err != nil {
+		return "", fmt.Errorf("not a git repository: %w", err)
+	}
+	path, err := filepath.Abs(repoPath)
+	if err != nil {
+		return "", fmt.Errorf("failed to resolve repository path: %w", err)
+	}
+	return path, nil
+}
+
+// globalConfigFile reads the global config file for changing
+func globalConfigFile() (*config.File, error) {
+	path := config.Path(config.ScopeGlobal, "")
+	if path == "" {
+		return nil, fmt.Errorf("no global config file: the home directory is unknown")
+	}
+	return config.ReadFile(path)
+}
+
+// registerMaintenance adds path to maintenance.repo, reporting whether it
+// was not there yet
+func registerMaintenance(path string) (bool, error) {
+	file, err := globalConfigFile()
+	if err != nil {
+		return false, err
+	}
+	for _, registered := range file.GetAll(maintenanceRepoKey) {
+		if registered == path {
+			return false, nil
+		}
+	}
+	if err := file.Add(maintenanceRepoKey, path); err != nil {
+		return false, err
+	}
+	if err := file.Save(); err != nil {
+		return false, fmt.Errorf("failed to save config: %w", err)
+	}
+	return true, nil
+}
+
+// unregisterMaintenance removes path from maintenance.repo, reporting
+// whether it was there
+func unregisterMaintenance(path string) (bool, error) {
+	file, err := globalConfigFile()
+	if err != nil {
+		return false, err
+	}
+	registered := file.GetAll(maintenanceRepoKey)
+	var kept []string
+	for _, p := range registered {
+		if p != path {
+			kept = append(kept, p)
+		}
+	}
+	if len(kept) == len(registered) {
+		return false, nil
+	}
+	if _, err := file.UnsetAll(maintenanceRepoKey); err != nil {
+		return false, err
+	}
+	for _, p := range kept {
+		if err := file.Add(maintenanceRepoKey, p); err != nil {
+			return false, err
+		}
+	}
+	if err := file.Save(); err != nil {
+		return false, fmt.Errorf("failed to save config: %w", err)
+	}
+	return true, nil
+}
+
+// chooseMaintenanceScheduler returns the scheduler called name, or for
+// auto the one the system has
+func chooseMaintenanceScheduler(name string) (maintenanceScheduler, error) {
+	if name == "auto" {
+		switch {
+		case runtime.GOOS == "darwin":
+			name = "launchctl"
+		case runtime.GOOS == "windows":
+			name = "internal"
+		case systemdAvailable():
+			name = "systemd-timer"
+		case commandAvailable("crontab"):
+			name = "crontab"
+		default:
+			name = "internal"
+		}
+	}
+	for _, s := range maintenanceSchedulers {
+		if s.name == name {
+			return s, nil
+		}
+	}
+	return maintenanceScheduler{}, fmt.Errorf("unknown scheduler '%s'", name)
+}
+
+// commandAvailable reports whether the command name is on the PATH
+func commandAvailable(name string) bool {
+	_, err := lookPath(name)
+	return err == nil
+}
+
+// systemdAvailable reports whether the user's systemd instance runs
+func systemdAvailable() bool {
+	if !commandAvailable("systemctl") {
+		return false
+	}
+	_, err := runSchedulerCommand("", "systemctl", "--user", "list-timers")
+	return err == nil
+}
+
+// scheduledMaintenanceArgs returns the command line of the job run for
+// schedule
+func scheduledMaintenanceArgs(exe string, schedule maintenanceSchedule) []string {
+	return []string{exe, "for-each-repo", "--config=" + maintenanceRepoKey, "maintenance", "run", "--schedule=" + schedule.String()}
+}
+
+// scheduleMinute picks the minute of the hour the jobs run at, spread so
+// that machines scheduling at once do not all run together
+func scheduleMinute() int {
+	return rand.Intn(60)
+}
+
+// quoteShellWord quotes s as a single word for sh
+func quoteShellWord(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// userHome returns the home directory, under which the systemd and launchd
+// schedulers write their files
+func userHome() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find the home directory: %w", err)
+	}
+	return home, nil
+}
+
+const (
+	crontabBegin = "# BEGIN VCS MAINTENANCE SCHEDULE"
+	crontabEnd   = "# END VCS MAINTENANCE SCHEDULE"
+)
+
+// installCrontab adds the jobs to the user's crontab, between markers so
+// they can be told from the user's own entries
+func installCrontab(exe string) error {
+	if !commandAvailable("crontab") {
+		return fmt.Errorf("crontab is not installed")
+	}
+	// crontab -l fails when the user has no crontab yet
+	current, _ := runSchedulerCommand("", "crontab", "-l")
+	lines, _ := withoutCrontabSchedule(current)
+
+	minute := scheduleMinute()
+	entries := []struct {
+		hours, weekdays string
+		schedule        maintenanceSchedule
+	}{
+		{"1-23", "*", scheduleHourly},
+		{"0", "1-6", scheduleDaily},
+		{"0", "0", scheduleWeekly},
+	}
+	lines = append(lines, crontabBegin)
+	for _, e := range entries {
+		var words []string
+		for _, arg := range scheduledMaintenanceArgs(exe, e.schedule) {
+			words = append(words, quoteShellWord(arg))
+		}
+		lines = append(lines, fmt.Sprintf("%d %s * * %s %s", minute, e.hours, e.weekdays, strings.Join(words, " ")))
+	}
+	lines = append(lines, crontabEnd)
+
+	_, err := runSchedulerCommand(strings.Join(lines, "\n")+"\n", "crontab", "-")
+	return err
+}
+
+// removeCrontab removes the jobs from the user's crontab
+func removeCrontab() (bool, error) {
+	if !commandAvailable("crontab") {
+		return false, nil
+	}
+	current, err := runSchedulerCommand("", "crontab", "-l")
+	if err != nil {
+		return false, nil
+	}
+	lines, found := withoutCrontabSchedule(current)
+	if !found {
+		return false, nil
+	}
+	content := ""
+	if len(lines) > 0 {
+		content = strings.Join(lines, "\n") + "\n"
+	}
+	_, err = runSchedulerCommand(content, "crontab", "-")
+	return err == nil, err
+}
+
+// withoutCrontabSchedule returns the lines of crontab outside the block of
+// maintenance jobs, and whether there was one
+func withoutCrontabSchedule(crontab string) ([]string, bool) {
+	var lines []string
+	inBlock, found := false, false
+	for _, line := range strings.Split(strings.TrimRight(crontab, "\n"), "\n") {
+		switch {
+		case line == crontabBegin:
+			inBlock, found = true, true
+		case line == crontabEnd:
+			inBlock = false
+		case !inBlock && line != "":
+			lines = append(lines, line)
+		}
+	}
+	return lines, found
+}
+
+// systemdUnitDir returns the directory of the user's systemd units
+func systemdUnitDir() (string, error) {
+	if xdg := os.Getenv("XDG_CONFIG_HOME"); xdg != "" {
+		return filepath.Join(xdg, "systemd", "user"), nil
+	}
+	home, err := userHome()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".config", "systemd", "user"), nil
+}
+
+// systemdCalendars are the OnCalendar times of the timers, with %d for the
+// minute
+var systemdCalendars = map[maintenanceSchedule]string{
+	scheduleHourly: "*-*-* 1..23:%02d:00",
+	scheduleDaily:  "Mon..Sat *-*-* 0:%02d:00",
+	scheduleWeekly: "Sun *-*-* 0:%02d:00",
+}
+
+// systemdTimers returns the names of the timer units, one per schedule
+func systemdTimers() []string {
+	var timers []string
+	for _, schedule := range []maintenanceSchedule{scheduleHourly, scheduleDaily, scheduleWeekly} {
+		timers = append(timers, "vcs-maintenance@"+schedule.String()+".timer")
+	}
+	return timers
+}
+
+// installSystemdTimers writes a service template and a timer per schedule
+// and enables the timers
+func installSystemdTimers(exe string) error {
+	dir, err := systemdUnitDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+
+	// The instance name, %i, is the schedule
+	var words []string
+	for _, arg := range scheduledMaintenanceArgs(exe, scheduleNone) {
+		words = append(words, strconv.Quote(arg))
+	}
+	execStart := strings.Replace(strings.Join(words, " "), "--schedule=none", "--schedule=%i", 1)
+	service := "[Unit]\nDescription=Optimize the data of vcs repositories\n\n" +
+		"[Service]\nType=oneshot\nExecStart=" + execStart + "\nNice=19\nIOSchedulingClass=idle\n"
+	if err := os.WriteFile(filepath.Join(dir, "vcs-maintenance@.service"), []byte(service), 0644); err != nil {
+		return fmt.Errorf("failed to write service unit: %w", err)
+	}
+
+	minute := scheduleMinute()
+	for _, schedule := range []maintenanceSchedule{scheduleHourly, scheduleDaily, scheduleWeekly} {
+		timer := "[Unit]\nDescription=Optimize the data of vcs repositories " + schedule.String() + "\n\n" +
+			"[Timer]\nOnCalendar=" + fmt.Sprintf(systemdCalendars[schedule], minute) + "\nPersistent=true\n\n" +
+			"[Install]\nWantedBy=timers.target\n"
+		path := filepath.Join(dir, "vcs-maintenance@"+schedule.String()+".timer")
+		if err := os.WriteFile(path, []byte(timer), 0644); err != nil {
+			return fmt.Errorf("failed to write timer unit: %w", err)
+		}
+	}
+
+	if _, err := runSchedulerCommand("", "systemctl", "--user", "daemon-reload"); err != nil {
+		return err
+	}
+	_, err = runSchedulerCommand("", "systemctl", append([]string{"--user", "enable", "--now"}, systemdTimers()...)...)
+	return err
+}
+
+// removeSystemdTimers disables the timers and removes their units
+func removeSystemdTimers() (bool, error) {
+	dir, err := systemdUnitDir()
+	if err != nil {
+		return false, nil
+	}
+	service := filepath.Join(dir, "vcs-maintenance@.service")
+	if !fileExists(service) {
+		return false, nil
+	}
+
+	if _, err := runSchedulerCommand("", "systemctl", append([]string{"--user", "disable", "--now"}, systemdTimers()...)...); err != nil {
+		return false, err
+	}
+	for _, timer := range systemdTimers() {
+		if err := os.Remove(filepath.Join(dir, timer)); err != nil && !os.IsNotExist(err) {
+			return false, fmt.Errorf("failed to remove timer unit: %w", err)
+		}
+	}
+	if err := os.Remove(service); err != nil {
+		return false, fmt.Errorf("failed to remove service unit: %w", err)
+	}
+	_, err = runSchedulerCommand("", "systemctl", "--user", "daemon-reload")
+	return err == nil, err
+}
+
+// launchAgentPath returns the path of the launchd agent for schedule
+func launchAgentPath(schedule maintenanceSchedule) (string, error) {
+	home, err := userHome()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, "Library", "LaunchAgents", "org.vcs.maintenance."+schedule.String()+".plist"), nil
+}
+
+// launchdDomain is the domain of the user's agents
+func launchdDomain() string {
+	return fmt.Sprintf("gui/%d", os.Getuid())
+}
+
+// launchAgent returns the property list of the agent running the job for
+// schedule at minute
+func launchAgent(exe string, schedule maintenanceSchedule, minute int) string {
+	var b strings.Builder
+	b.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+<key>Label</key><string>org.vcs.maintenance.` + schedule.String() + `</string>
+<key>ProgramArguments</key>
+<array>
+`)
+	for _, arg := range scheduledMaintenanceArgs(exe, schedule) {
+		b.WriteString("<string>" + xmlEscape(arg) + "</string>\n")
+	}
+	b.WriteString("</array>\n<key>StartCalendarInterval</key>\n<array>\n")
+	interval := func(fields string) {
+		b.WriteString("<dict>" + fields + fmt.Sprintf("<key>Minute</key><integer>%d</integer></dict>\n", minute))
+	}
+	switch schedule {
+	case scheduleHourly:
+		for hour := 1; hour <= 23; hour++ {
+			interval(fmt.Sprintf("<key>Hour</key><integer>%d</integer>", hour))
+		}
+	case scheduleDaily:
+		for day := 1; day <= 6; day++ {
+			interval(fmt.Sprintf("<key>Weekday</key><integer>%d</integer><key>Hour</key><integer>0</integer>", day))
+		}
+	case scheduleWeekly:
+		interval("<key>Weekday</key><integer>0</integer><key>Hour</key><integer>0</integer>")
+	}
+	b.WriteString("</array>\n<key>StandardOutPath</key><string>/dev/null</string>\n" +
+		"<key>StandardErrorPath</key><string>/dev/null</string>\n</dict>\n</plist>\n")
+	return b.String()
+}
+
+// xmlEscape escapes s for the text of an XML element
+func xmlEscape(s string) string {
+	return strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;").Replace(s)
+}
+
+// installLaunchAgents writes an agent per schedule and loads them,
+// replacing the agents loaded before
+func installLaunchAgents(exe string) error {
+	minute := scheduleMinute()
+	for _, schedule := range []maintenanceSchedule{scheduleHourly, scheduleDaily, scheduleWeekly} {
+		path, err := launchAgentPath(schedule)
+		if err != nil {
+			return err
+		}
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			return fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+		}
+		// An agent loaded earlier has to be unloaded to pick up the change
+		runSchedulerCommand("", "launchctl", "bootout", launchdDomain(), path)
+		if err := os.WriteFile(path, []byte(launchAgent(exe, schedule, minute)), 0644); err != nil {
+			return fmt.Errorf("failed to write launch agent: %w", err)
+		}
+		if _, err := runSchedulerCommand("", "launchctl", "bootstrap", launchdDomain(), path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// removeLaunchAgents unloads and removes the agents
+func removeLaunchAgents() (bool, error) {
+	removed := false
+	for _, schedule := range []maintenanceSchedule{scheduleHourly, scheduleDaily, scheduleWeekly} {
+		path, err := launchAgentPath(schedule)
+		if err != nil || !fileExists(path) {
+			continue
+		}
+		// An agent that is not loaded only needs its file removed
+		runSchedulerCommand("", "launchctl", "bootout", launchdDomain(), path)
+		if err := os.Remove(path); err != nil {
+			return removed, fmt.Errorf("failed to remove launch agent: %w", err)
+		}
+		removed = true
+	}
+	return removed, nil
+}
+
+// maintenanceStateDir returns the directory holding the pid file and log
+// of the internal scheduler
+func maintenanceStateDir() (string, error) {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to find the cache directory: %w", err)
+	}
+	return filepath.Join(dir, "vcs", "maintenance"), nil
+}
+
+// startInternalScheduler starts vcs maintenance daemon in the background,
+// logging to daemon.log, after stopping one started before
+func startInternalScheduler(exe string) error {
+	if _, err := stopInternalScheduler(); err != nil {
+		return err
+	}
+	dir, err := maintenanceStateDir()
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	logFile, err := os.OpenFile(filepath.Join(dir, "daemon.log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open daemon log: %w", err)
+	}
+	defer logFile.Close()
+
+	daemon := exec.Command(exe, "maintenance", "daemon")
+	daemon.Dir = dir
+	daemon.Stdout = logFile
+	daemon.Stderr = logFile
+	detachProcess(daemon)
+	if err := daemon.Start(); err != nil {
+		return fmt.Errorf("failed to start maintenance daemon: %w", err)
+	}
+	pid := daemon.Process.Pid
+	daemon.Process.Release()
+	if err := os.WriteFile(filepath.Join(dir, "daemon.pid"), []byte(strconv.Itoa(pid)+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write daemon pid: %w", err)
+	}
+	return nil
+}
+
+// stopInternalScheduler stops the daemon startInternalScheduler started
+func stopInternalScheduler() (bool, error) {
+	dir, err := maintenanceStateDir()
+	if err != nil {
+		return false, nil
+	}
+	pidPath := filepath.Join(dir, "daemon.pid")
+	data, err := os.ReadFile(pidPath)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("failed to read daemon pid: %w", err)
+	}
+	if pid, err := strconv.Atoi(strings.TrimSpace(string(data))); err == nil {
+		// A daemon that already exited leaves nothing to stop
+		if process, err := os.FindProcess(pid); err == nil {
+			process.Kill()
+		}
+	}
+	if err := os.Remove(pidPath); err != nil {
+		return false, fmt.Errorf("failed to remove daemon pid: %w", err)
+	}
+	return true, nil
+}
+
+// runMaintenanceDaemon runs the scheduled jobs at their times until ctx is
+// done
+func runMaintenanceDaemon(ctx context.Context, out, errOut io.Writer, exe string) error {
+	minute := scheduleMinute()
+	for {
+		next := nextMaintenanceRun(time.Now(), minute)
+		timer := time.NewTimer(time.Until(next))
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return nil
+		case <-timer.C:
+		}
+
+		schedule := maintenanceScheduleAt(next)
+		args := scheduledMaintenanceArgs(exe, schedule)
+		fmt.Fprintf(out, "%s: running %s maintenance\n", next.Format(time.RFC3339), schedule)
+		job := exec.CommandContext(ctx, args[0], args[1:]...)
+		job.Stdout = out
+		job.Stderr = errOut
+		if err := job.Run(); err != nil && ctx.Err() == nil {
+			fmt.Fprintf(errOut, "%s maintenance failed: %v\n", schedule, err)
+		}
+	}
+}
+
+// nextMaintenanceRun returns the first time after now at minute past an
+// hour
+func nextMaintenanceRun(now time.Time, minute int) time.Time {
+	next := time.Date(now.Year(), now.Month(), now.Day(), now.Hour(), minute, 0, 0, now.Location())
+	if !next.After(now) {
+		next = next.Add(time.Hour)
+	}
+	return next
+}
+
+// maintenanceScheduleAt returns the schedule whose jobs run at t, the way
+// the external schedulers are set up: weekly at midnight on Sunday, daily
+// at the other midnights and hourly otherwise
+func maintenanceScheduleAt(t time.Time) maintenanceSchedule {
+	switch {
+	case t.Hour() != 0:
+		return scheduleHourly
+	case t.Weekday() == time.Sunday:
+		return scheduleWeekly
+	default:
+		return scheduleDaily
+	}
+}
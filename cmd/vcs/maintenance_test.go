@@ -0,0 +1,270 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/commitgraph"
+	"github.com/fenilsonani/vcs/internal/core/config"
+	"github.com/fenilsonani/vcs/internal/core/packfile"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+func runMaintenanceArgs(args ...string) (string, error) {
+	cmd := newMaintenanceCommand()
+	var buf bytes.Buffer
+	cmd.SetOut(&buf)
+	cmd.SetErr(&buf)
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+	return buf.String(), err
+}
+
+func TestMaintenanceRun(t *testing.T) {
+	repo, head := setupGCRepo(t)
+	objectsDir := filepath.Join(repo.GitDir(), "objects")
+
+	// gc packs the objects and writes the commit-graph
+	_, err := runMaintenanceArgs("run", "--task=gc")
+	require.NoError(t, err)
+	graph, err := commitgraph.Load(objectsDir)
+	require.NoError(t, err)
+	require.NotNil(t, graph, "gc wrote no commit-graph")
+	assert.Equal(t, 3, graph.Len())
+	tip, ok := graph.Lookup(head)
+	require.True(t, ok)
+	assert.Equal(t, uint32(3), tip.Generation)
+
+	out, err := runMaintenanceArgs("run")
+	require.NoError(t, err)
+	assert.Contains(t, out, "Wrote commit-graph of 3 commits")
+	assert.Contains(t, out, "Wrote multi-pack-index of 1 packs")
+	midx, err := packfile.LoadMultiPackIndex(repo.Storage().PackDir())
+	require.NoError(t, err)
+	require.NotNil(t, midx)
+
+	// History still reads through both files
+	_, err = newResolver(repo).MergeBases(head, tip.Parents[0])
+	require.NoError(t, err)
+	_, err = repo.GetCommit(head)
+	require.NoError(t, err)
+
+	// gc keeps the multi-pack-index it found in step with the packs
+	_, err = runGCArgs("-q")
+	require.NoError(t, err)
+	midx, err = packfile.LoadMultiPackIndex(repo.Storage().PackDir())
+	require.NoError(t, err)
+	require.NotNil(t, midx)
+	assert.Len(t, midx.PackNames(), 1)
+
+	_, err = runMaintenanceArgs("run", "--task=unknown")
+	assert.ErrorContains(t, err, "not a valid task")
+}
+
+func TestMaintenanceSkipsCommitGraphWhenShallow(t *testing.T) {
+	repo, head := setupGCRepo(t)
+	require.NoError(t, os.WriteFile(filepath.Join(repo.GitDir(), "shallow"), []byte(head.String()+"\n"), 0644))
+
+	_, err := runMaintenanceArgs("run", "--task=commit-graph")
+	require.NoError(t, err)
+	graph, err := commitgraph.Load(filepath.Join(repo.GitDir(), "objects"))
+	require.NoError(t, err)
+	assert.Nil(t, graph)
+}
+
+func TestMaintenanceRunSchedule(t *testing.T) {
+	setupGCRepo(t)
+	isolateConfig(t)
+
+	out, err := runMaintenanceArgs("run", "--schedule=hourly")
+	require.NoError(t, err)
+	assert.Contains(t, out, "Wrote commit-graph")
+	assert.NotContains(t, out, "loose objects")
+	assert.NotContains(t, out, "multi-pack-index")
+
+	out, err = runMaintenanceArgs("run", "--schedule=daily")
+	require.NoError(t, err)
+	assert.Contains(t, out, "Wrote commit-graph")
+	assert.Contains(t, out, "Packed")
+	assert.Contains(t, out, "multi-pack-index")
+
+	_, err = runConfigArgs("maintenance.commit-graph.schedule", "weekly")
+	require.NoError(t, err)
+	out, err = runMaintenanceArgs("run", "--schedule=daily")
+	require.NoError(t, err)
+	assert.NotContains(t, out, "commit-graph")
+
+	_, err = runMaintenanceArgs("run", "--schedule=monthly")
+	assert.ErrorContains(t, err, "invalid --schedule")
+	_, err = runMaintenanceArgs("run", "--schedule=hourly", "--task=gc")
+	assert.ErrorContains(t, err, "cannot be used together")
+}
+
+func TestMaintenanceLooseObjects(t *testing.T) {
+	repo, head := setupGCRepo(t)
+	storage := repo.Storage()
+	loose, err := storage.LooseObjects()
+	require.NoError(t, err)
+	require.NotEmpty(t, loose)
+
+	out, err := runMaintenanceArgs("run", "--task=loose-objects")
+	require.NoError(t, err)
+	assert.Contains(t, out, "Packed 9 loose objects")
+	loose, err = storage.LooseObjects()
+	require.NoError(t, err)
+	assert.Empty(t, loose)
+	_, err = repo.GetCommit(head)
+	require.NoError(t, err)
+
+	// A loose copy of a packed object is removed without packing it again
+	objType, data, err := repo.ReadRawObject(head)
+	require.NoError(t, err)
+	_, err = storage.WriteLooseObject(objType, data)
+	require.NoError(t, err)
+	out, err = runMaintenanceArgs("run", "--task=loose-objects")
+	require.NoError(t, err)
+	assert.Contains(t, out, "Packed 0 loose objects")
+	assert.False(t, fileExists(storage.LooseObjectPath(head)))
+}
+
+func TestMaintenancePrefetch(t *testing.T) {
+	src, _ := setupConfigRepo(t)
+	_, err := stageAndCommit(t, src, "a.txt", "-m", "one")
+	require.NoError(t, err)
+	head := headCommit(t, src)
+
+	dst, err := vcs.Init(t.TempDir())
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dst.Path()))
+	_, err = runConfigArgs("remote.origin.url", src.Path())
+	require.NoError(t, err)
+
+	out, err := runMaintenanceArgs("run", "--task=prefetch")
+	require.NoError(t, err)
+	assert.Contains(t, out, "Prefetched 1 branches from origin")
+	rm := refs.NewRefManager(dst.GitDir())
+	id, err := rm.ResolveRef("refs/prefetch/remotes/origin/main")
+	require.NoError(t, err)
+	assert.Equal(t, head, id.String())
+	assert.True(t, dst.HasObject(id))
+	// Remote-tracking branches are left to fetch
+	_, err = rm.ResolveRef("refs/remotes/origin/main")
+	assert.Error(t, err)
+}
+
+// fakeScheduler stands in for the system's scheduler commands, keeping the
+// crontab in memory and recording every command run
+type fakeScheduler struct {
+	crontab  string
+	commands []string
+}
+
+func setupFakeScheduler(t *testing.T) *fakeScheduler {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	t.Setenv("XDG_CONFIG_HOME", filepath.Join(home, ".config"))
+	t.Setenv("XDG_CACHE_HOME", filepath.Join(home, ".cache"))
+
+	fake := &fakeScheduler{crontab: "0 5 * * * backup\n"}
+	oldRun, oldLookPath, oldExe := runSchedulerCommand, lookPath, vcsExecutable
+	t.Cleanup(func() { runSchedulerCommand, lookPath, vcsExecutable = oldRun, oldLookPath, oldExe })
+	runSchedulerCommand = func(stdin, name string, args ...string) (string, error) {
+		fake.commands = append(fake.commands, strings.Join(append([]string{name}, args...), " "))
+		if name == "crontab" && args[0] == "-" {
+			fake.crontab = stdin
+		}
+		return fake.crontab, nil
+	}
+	lookPath = func(name string) (string, error) { return "/usr/bin/" + name, nil }
+	vcsExecutable = func() (string, error) { return "/opt/vcs/bin/vcs", nil }
+	return fake
+}
+
+func TestMaintenanceStartStop(t *testing.T) {
+	repo, global := setupConfigRepo(t)
+	fake := setupFakeScheduler(t)
+
+	out, err := runMaintenanceArgs("start", "--scheduler=crontab")
+	require.NoError(t, err)
+	assert.Contains(t, out, "with crontab")
+	assert.Contains(t, fake.crontab, "0 5 * * * backup\n"+crontabBegin+"\n")
+	assert.Contains(t, fake.crontab, " 1-23 * * * '/opt/vcs/bin/vcs' 'for-each-repo' '--config=maintenance.repo' 'maintenance' 'run' '--schedule=hourly'\n")
+	assert.Contains(t, fake.crontab, " 0 * * 0 '/opt/vcs/bin/vcs' 'for-each-repo' '--config=maintenance.repo' 'maintenance' 'run' '--schedule=weekly'\n")
+
+	// Starting again replaces the schedule and registers once
+	_, err = runMaintenanceArgs("start", "--scheduler=crontab")
+	require.NoError(t, err)
+	assert.Equal(t, 1, strings.Count(fake.crontab, crontabBegin))
+	file, err := config.ReadFile(global)
+	require.NoError(t, err)
+	assert.Equal(t, []string{repo.Path()}, file.GetAll("maintenance.repo"))
+
+	// Moving to systemd removes the crontab entries
+	out, err = runMaintenanceArgs("start", "--scheduler=systemd-timer")
+	require.NoError(t, err)
+	assert.Contains(t, out, "with systemd-timer")
+	assert.Equal(t, "0 5 * * * backup\n", fake.crontab)
+	unitDir := filepath.Join(os.Getenv("XDG_CONFIG_HOME"), "systemd", "user")
+	service, err := os.ReadFile(filepath.Join(unitDir, "vcs-maintenance@.service"))
+	require.NoError(t, err)
+	assert.Contains(t, string(service), `ExecStart="/opt/vcs/bin/vcs" "for-each-repo" "--config=maintenance.repo" "maintenance" "run" "--schedule=%i"`)
+	timer, err := os.ReadFile(filepath.Join(unitDir, "vcs-maintenance@daily.timer"))
+	require.NoError(t, err)
+	assert.Contains(t, string(timer), "OnCalendar=Mon..Sat *-*-* 0:")
+	assert.Contains(t, fake.commands, "systemctl --user enable --now vcs-maintenance@hourly.timer vcs-maintenance@daily.timer vcs-maintenance@weekly.timer")
+
+	out, err = runMaintenanceArgs("stop")
+	require.NoError(t, err)
+	assert.Equal(t, "Removed the systemd-timer schedule\n", out)
+	assert.NoFileExists(t, filepath.Join(unitDir, "vcs-maintenance@.service"))
+	out, err = runMaintenanceArgs("stop")
+	require.NoError(t, err)
+	assert.Equal(t, "No maintenance was scheduled\n", out)
+
+	_, err = runMaintenanceArgs("unregister")
+	require.NoError(t, err)
+	file, err = config.ReadFile(global)
+	require.NoError(t, err)
+	assert.Empty(t, file.GetAll("maintenance.repo"))
+
+	_, err = runMaintenanceArgs("start", "--scheduler=at")
+	assert.ErrorContains(t, err, "unknown scheduler")
+}
+
+func TestMaintenanceLaunchAgents(t *testing.T) {
+	setupConfigRepo(t)
+	fake := setupFakeScheduler(t)
+
+	_, err := runMaintenanceArgs("start", "--scheduler=launchctl")
+	require.NoError(t, err)
+	path, err := launchAgentPath(scheduleHourly)
+	require.NoError(t, err)
+	plist, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(plist), "<string>--schedule=hourly</string>")
+	assert.Equal(t, 23, strings.Count(string(plist), "<key>Hour</key>"))
+	assert.Contains(t, fake.commands, "launchctl bootstrap "+launchdDomain()+" "+path)
+
+	out, err := runMaintenanceArgs("stop")
+	require.NoError(t, err)
+	assert.Equal(t, "Removed the launchctl schedule\n", out)
+	assert.NoFileExists(t, path)
+}
+
+func TestMaintenanceDaemonSchedule(t *testing.T) {
+	now := time.Date(2026, 3, 14, 22, 40, 0, 0, time.UTC) // a Saturday
+	assert.Equal(t, time.Date(2026, 3, 14, 23, 17, 0, 0, time.UTC), nextMaintenanceRun(now, 17))
+	assert.Equal(t, time.Date(2026, 3, 14, 22, 50, 0, 0, time.UTC), nextMaintenanceRun(now, 50))
+
+	assert.Equal(t, scheduleHourly, maintenanceScheduleAt(now))
+	assert.Equal(t, scheduleWeekly, maintenanceScheduleAt(time.Date(2026, 3, 15, 0, 17, 0, 0, time.UTC)))
+	assert.Equal(t, scheduleDaily, maintenanceScheduleAt(time.Date(2026, 3, 16, 0, 17, 0, 0, time.UTC)))
+}
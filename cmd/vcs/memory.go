@@ -0,0 +1,149 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"strings"
+
+	"github.com/fenilsonani/vcs/internal/core/packfile"
+)
+
+const (
+	// defaultPackedGitWindowSize is used when only core.packedGitLimit is set
+	defaultPackedGitWindowSize = 1 << 20
+	// defaultPackedGitLimit is used when only core.packedGitWindowSize is set
+	defaultPackedGitLimit = 256 << 20
+	// minThreadWindowMemory is the least window memory worth a delta thread
+	// of its own when memory is limited
+	minThreadWindowMemory = 64 << 20
+)
+
+// cgroupRoot is where the cgroup filesystem is mounted
+var cgroupRoot = "/sys/fs/cgroup"
+
+// cgroupMemoryLimit returns the memory limit of the cgroup vcs runs in, or
+// zero when there is none. Containers see their own cgroup at the root, so
+// only the v2 memory.max and v1 memory.limit_in_bytes files there are read.
+func cgroupMemoryLimit() int64 {
+	for _, name := range []string{"memory.max", filepath.Join("memory", "memory.limit_in_bytes")} {
+		data, err := os.ReadFile(filepath.Join(cgroupRoot, name))
+		if err != nil {
+			continue
+		}
+		limit, err := strconv.ParseInt(strings.TrimSpace(string(data)), 10, 64)
+		// v2 writes "max" and v1 a huge page-aligned number when unlimited
+		if err != nil || limit <= 0 || limit >= 1<<62 {
+			return 0
+		}
+		return limit
+	}
+	return 0
+}
+
+// limitMemory sets the Go runtime's soft memory limit just under the cgroup
+// limit so garbage is collected before the container runs out, unless
+// GOMEMLIMIT already chose one
+func limitMemory() {
+	if os.Getenv("GOMEMLIMIT") != "" {
+		return
+	}
+	if limit := cgroupMemoryLimit(); limit > 0 {
+		debug.SetMemoryLimit(limit / 10 * 9)
+	}
+}
+
+// packWriterOptions returns the options for packing the repository at
+// gitDir, honouring pack.windowMemory and pack.threads
+func packWriterOptions(gitDir string) packfile.WriterOptions {
+	cfg := loadConfigSections(gitDir, "pack")
+	opts := packfile.DefaultWriterOptions()
+
+	if value, ok := cfg["pack.windowmemory"]; ok {
+		size, err := parseConfigSize(value)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: ignoring pack.windowMemory: %v\n", err)
+		} else {
+			opts.WindowMemory = size
+		}
+	}
+
+	// As in Git, zero or no setting means one thread per CPU
+	threads := 0
+	if value, ok := cfg["pack.threads"]; ok {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			fmt.Fprintf(os.Stderr, "warning: ignoring pack.threads: invalid number %q\n", value)
+		} else {
+			threads = n
+		}
+	}
+	opts.Threads = threads
+	if threads == 0 {
+		opts.Threads = runtime.NumCPU()
+	}
+
+	fitMemoryLimit(&opts, cgroupMemoryLimit(), threads == 0)
+	return opts
+}
+
+// fitMemoryLimit keeps the delta search within half of a memory limit, if
+// any. Automatically chosen threads are dropped until each has enough
+// window memory, and without pack.windowMemory the budget is shared out
+// between the threads.
+func fitMemoryLimit(opts *packfile.WriterOptions, limit int64, autoThreads bool) {
+	if limit <= 0 {
+		return
+	}
+	budget := limit / 2
+
+	perThread := opts.WindowMemory
+	if perThread < minThreadWindowMemory {
+		perThread = minThreadWindowMemory
+	}
+	if autoThreads && int64(opts.Threads)*perThread > budget {
+		opts.Threads = int(budget / perThread)
+		if opts.Threads < 1 {
+			opts.Threads = 1
+		}
+	}
+
+	if opts.WindowMemory == 0 {
+		opts.WindowMemory = budget / int64(opts.Threads)
+	}
+}
+
+// packWindowLimits returns the window size and total limit for reading
+// packs, from core.packedGitWindowSize and core.packedGitLimit. Without
+// either setting packs are read directly and the window size is zero.
+func packWindowLimits(gitDir string) (windowSize, limit int64) {
+	cfg := loadConfigSections(gitDir, "core")
+	size := func(key, name string) int64 {
+		value, ok := cfg[key]
+		if !ok {
+			return 0
+		}
+		n, err := parseConfigSize(value)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: ignoring %s: %v\n", name, err)
+			return 0
+		}
+		return n
+	}
+
+	windowSize = size("core.packedgitwindowsize", "core.packedGitWindowSize")
+	limit = size("core.packedgitlimit", "core.packedGitLimit")
+	if windowSize == 0 && limit == 0 {
+		return 0, 0
+	}
+	if windowSize == 0 {
+		windowSize = defaultPackedGitWindowSize
+	}
+	if limit == 0 {
+		limit = defaultPackedGitLimit
+	}
+	return windowSize, limit
+}
@@ -1,298 +1,940 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"io"
+	"os"
 	"path/filepath"
-	"time"
+	"sort"
+	"strings"
 
 	"github.com/spf13/cobra"
 
+	"github.com/fenilsonani/vcs/internal/core/config"
+	"github.com/fenilsonani/vcs/internal/core/convert"
 	"github.com/fenilsonani/vcs/internal/core/index"
+	"github.com/fenilsonani/vcs/internal/core/merge"
 	"github.com/fenilsonani/vcs/internal/core/objects"
 	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/internal/revparse"
 	"github.com/fenilsonani/vcs/pkg/vcs"
 )
 
+// errMergeConflict is returned when a merge stops with conflicts
+var errMergeConflict = errors.New("Automatic merge failed; fix conflicts and then commit the result.")
+
 func newMergeCommand() *cobra.Command {
 	var (
-		noCommit   bool
-		fastForward string
-		strategy   string
-		message    string
+		noCommit        bool
+		fastForward     string
+		strategy        string
+		strategyOptions []string
+		message         string
+		reportFormat    string
 	)
 
 	cmd := &cobra.Command{
-		Use:   "merge [flags] <branch>",
+		Use:   "merge [flags] <branch>...",
 		Short: "Join two or more development histories together",
 		Long: `Incorporates changes from the named commits (since the time their
-histories diverged from the current branch) into the current branch.`,
-		Args: cobra.ExactArgs(1),
+histories diverged from the current branch) into the current branch.
+
+With --report=json a summary of the merge (merged commits, conflicted
+paths with their conflict type, and whether the result is resolved) is
+written to stdout and other messages go to stderr. A merge that stops
+with conflicts exits non-zero.
+
+Progress updating the working tree is shown when standard error is a
+terminal, and the merge ends with the paths it left conflicted, updated
+and deleted, grouped by directory. --porcelain prints them to stdout as
+"<status> <path>" lines instead, with U, M or D as the status, and sends
+other messages to stderr.
+
+The recursive strategy merges against the common ancestor of the two
+commits; when there are several, as after criss-cross merges, they are
+merged first into a virtual ancestor. Files renamed on one side take the
+other side's changes along. The resolve strategy uses a single common
+ancestor instead, and the ours strategy records the merge while keeping
+the current tree unchanged. The ort strategy is recursive that also moves
+files the other side added to a directory one side renamed.
+
+Given several branches, the octopus strategy merges them one after another
+into a single merge commit with all of them as parents. It is the default
+then, and stops before changing anything if a branch other than the last
+does not merge cleanly.
+
+Strategy options given with -X adjust the merge:
+
+  ours, theirs          resolve conflicting hunks in favor of that side
+  ignore-space-change   treat changes in the amount of whitespace as none
+  no-renames            do not detect renames
+  find-renames[=<n>]    detect renames of files at least n% similar, with
+                        n read as in "vcs diff -M<n>"
+  diff-algorithm=<a>    match lines with myers, minimal, patience or
+                        histogram, as "vcs diff --diff-algorithm" does
+  patience              short for diff-algorithm=patience
+
+Renames are detected unless merge.renames, or failing that diff.renames,
+is false.
+
+Conflicts are written in the style set by merge.conflictStyle: merge,
+diff3 (with the base lines too) or zdiff3 (diff3 with lines both sides
+agree on moved outside the markers).
+
+With --autostash, or when merge.autoStash is true, local changes are
+stashed before merging and applied again afterwards; a merge that stops
+for conflicts or --no-commit applies them once "vcs commit" concludes it.
+Changes that no longer apply cleanly are kept in the stash list instead.
+
+FETCH_HEAD merges the branch the last fetch marked for merging, with the
+message "Merge branch '<branch>' of <url>". A merge that stops, for
+conflicts or --no-commit, leaves its message in MERGE_MSG, with the
+conflicted paths in comments, for "vcs commit" to use when it is given
+none.`,
+		Args: cobra.MinimumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := validateReportFormat(reportFormat); err != nil {
+				return err
+			}
+			update := newWorktreeUpdate(cmd)
+			if reportFormat != "" && update.porcelain {
+				return fmt.Errorf("--porcelain cannot be used with --report")
+			}
+
 			repo, err := findRepository()
 			if err != nil {
 				return err
 			}
 
-			vcsRepo, err := vcs.Open(repo)
+			vcsRepo, err := openRepository(repo)
 			if err != nil {
 				return err
 			}
 
 			refManager := refs.NewRefManager(vcsRepo.GitDir())
 
-			return runMerge(vcsRepo, refManager, args[0], noCommit, fastForward, strategy, message)
+			// Conflicts are not usage errors, and usage text would corrupt
+			// a report on stdout
+			cmd.SilenceUsage = true
+
+			// With --porcelain only the changed paths go to stdout
+			out := reportOutput(cmd, reportFormat)
+			summaryOut := out
+			if update.porcelain {
+				out, summaryOut = cmd.ErrOrStderr(), cmd.OutOrStdout()
+			}
+			opts, err := mergeOptions(vcsRepo, strategyOptions)
+			if err != nil {
+				return err
+			}
+			opts.OursLabel, opts.TheirsLabel = "HEAD", args[0]
+			if len(args) == 1 && args[0] == "FETCH_HEAD" {
+				description, err := fetchHeadMerge(vcsRepo)
+				if err != nil {
+					return err
+				}
+				if message == "" {
+					message = "Merge " + description
+				}
+			}
+
+			autostash, err := autostashRequested(cmd, loadConfig(vcsRepo.GitDir()), "merge.autoStash")
+			if err != nil {
+				return err
+			}
+			var stashID objects.ObjectID
+			if autostash {
+				if _, ok, err := readMergeHead(vcsRepo); err != nil {
+					return err
+				} else if ok {
+					return fmt.Errorf("you have not concluded your merge (MERGE_HEAD exists)")
+				}
+				if stashID, err = createAutostash(out, vcsRepo, refManager); err != nil {
+					return err
+				}
+			}
+
+			var report *operationReport
+			if len(args) > 1 || strategy == "octopus" {
+				if !cmd.Flags().Changed("strategy") {
+					strategy = "octopus"
+				}
+				report, err = runOctopusMerge(out, vcsRepo, refManager, update, args, noCommit, fastForward, strategy, opts, message)
+			} else {
+				report, err = runMerge(out, vcsRepo, refManager, update, args[0], noCommit, fastForward, strategy, opts, message)
+			}
+			if report != nil {
+				update.printSummary(summaryOut)
+			}
+			if !stashID.IsZero() {
+				if stashErr := finishMergeAutostash(out, vcsRepo, refManager, stashID); stashErr != nil && err == nil {
+					err = stashErr
+				}
+			}
+			if err == nil {
+				// post-merge is told whether the merge was a squash, which
+				// merge never makes
+				if report.Status == reportFastForward || report.Status == reportCompleted {
+					runPostHook(cmd.ErrOrStderr(), repo, vcsRepo.GitDir(), "post-merge", "0")
+				}
+				autoGC(cmd.ErrOrStderr(), vcsRepo)
+			}
+			if reportErr := writeReport(cmd.OutOrStdout(), reportFormat, report); reportErr != nil && err == nil {
+				err = reportErr
+			}
+			return err
 		},
 	}
 
 	cmd.Flags().BoolVar(&noCommit, "no-commit", false, "Perform merge but don't commit")
 	cmd.Flags().StringVar(&fastForward, "ff", "auto", "Fast-forward mode (auto, no, only)")
-	cmd.Flags().StringVar(&strategy, "strategy", "recursive", "Merge strategy to use")
+	cmd.Flags().StringVarP(&strategy, "strategy", "s", "recursive", "Merge strategy to use (recursive, ort, resolve, ours, octopus)")
+	cmd.Flags().StringArrayVarP(&strategyOptions, "strategy-option", "X", nil, "Pass an option to the merge strategy")
 	cmd.Flags().StringVarP(&message, "message", "m", "", "Merge commit message")
+	addReportFlag(cmd, &reportFormat)
+	addWorktreeOutputFlags(cmd)
+	addAutostashFlags(cmd)
 
 	return cmd
 }
 
-func runMerge(repo *vcs.Repository, refManager *refs.RefManager, branchName string, noCommit bool, fastForward, strategy, message string) error {
+// runMerge merges branchName into the current branch. The returned report
+// is set whenever the merge got far enough to describe, including when it
+// stops with conflicts.
+func runMerge(out io.Writer, repo *vcs.Repository, refManager *refs.RefManager, update *worktreeUpdate, branchName string, noCommit bool, fastForward, strategy string, opts merge.Options, message string) (*operationReport, error) {
+	switch fastForward {
+	case "auto", "no", "only":
+	default:
+		return nil, fmt.Errorf("invalid --ff mode %q (expected auto, no or only)", fastForward)
+	}
+
+	bases := newResolver(repo).MergeBasesOf
+	switch strategy {
+	case "recursive", "ours":
+	case "ort":
+		opts.DirectoryRenames = true
+	case "resolve":
+		bases = firstMergeBase(bases)
+	default:
+		return nil, fmt.Errorf("unknown merge strategy %q (expected recursive, ort, resolve, ours or octopus)", strategy)
+	}
+
+	if _, ok, err := readMergeHead(repo); err != nil {
+		return nil, err
+	} else if ok {
+		return nil, fmt.Errorf("you have not concluded your merge (MERGE_HEAD exists)")
+	}
+
 	// Get current branch
 	currentBranch, err := refManager.CurrentBranch()
 	if err != nil {
-		return fmt.Errorf("failed to get current branch: %w", err)
+		return nil, fmt.Errorf("failed to get current branch: %w", err)
 	}
 
 	// Get current commit
 	currentRef := "refs/heads/" + currentBranch
 	currentCommitID, err := refManager.ResolveRef(currentRef)
 	if err != nil {
-		return fmt.Errorf("failed to resolve current branch: %w", err)
+		return nil, fmt.Errorf("failed to resolve current branch: %w", err)
 	}
 
 	// Get target commit
 	targetCommitID, err := refManager.ResolveRef(branchName)
 	if err != nil {
-		return fmt.Errorf("failed to resolve target branch %q: %w", branchName, err)
+		return nil, fmt.Errorf("failed to resolve target branch %q: %w", branchName, err)
 	}
 
+	report := &operationReport{Operation: "merge", Head: currentCommitID.String()}
+
 	// Check if already up to date
-	if currentCommitID.Equal(targetCommitID) {
-		fmt.Printf("Already up to date.\n")
-		return nil
+	targetIsAncestor, err := isAncestor(repo, targetCommitID, currentCommitID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to check ancestry: %w", err)
+	}
+	if targetIsAncestor {
+		fmt.Fprintf(out, "Already up to date.\n")
+		report.Status = reportUpToDate
+		return report, nil
+	}
+
+	applied, err := commitsBetween(repo, currentCommitID, targetCommitID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list merged commits: %w", err)
+	}
+	for _, commit := range applied {
+		report.Applied = append(report.Applied, newReportCommit(commit))
 	}
 
 	currentCommit, err := repo.GetCommit(currentCommitID)
 	if err != nil {
-		return fmt.Errorf("failed to get current commit: %w", err)
+		return nil, fmt.Errorf("failed to get current commit: %w", err)
 	}
 
 	targetCommit, err := repo.GetCommit(targetCommitID)
 	if err != nil {
-		return fmt.Errorf("failed to get target commit: %w", err)
+		return nil, fmt.Errorf("failed to get target commit: %w", err)
 	}
 
 	// Check for fast-forward merge
 	canFastForward, err := isAncestor(repo, currentCommitID, targetCommitID)
 	if err != nil {
-		return fmt.Errorf("failed to check ancestry: %w", err)
+		return nil, fmt.Errorf("failed to check ancestry: %w", err)
 	}
 
-	if canFastForward {
-		return performFastForwardMerge(repo, refManager, currentRef, targetCommitID, branchName)
+	if canFastForward && fastForward != "no" {
+		if err := performFastForwardMerge(out, repo, refManager, update, currentRef, currentCommit, targetCommit); err != nil {
+			return nil, err
+		}
+		report.Status = reportFastForward
+		report.Head = targetCommitID.String()
+		return report, nil
 	}
-
-	// Check if target is ancestor of current (nothing to merge)
-	targetIsAncestor, err := isAncestor(repo, targetCommitID, currentCommitID)
-	if err != nil {
-		return fmt.Errorf("failed to check ancestry: %w", err)
+	if fastForward == "only" {
+		return nil, fmt.Errorf("not possible to fast-forward, aborting")
 	}
 
-	if targetIsAncestor {
-		fmt.Printf("Already up to date.\n")
-		return nil
-	}
+	// Perform three-way merge
+	return report, performThreeWayMerge(out, repo, refManager, update, report, currentRef, currentCommit, targetCommit, bases, strategy, opts, branchName, noCommit, fastForward, message)
+}
 
-	// Find merge base
-	mergeBase, err := findMergeBase(repo, currentCommitID, targetCommitID)
+func performFastForwardMerge(out io.Writer, repo *vcs.Repository, refManager *refs.RefManager, update *worktreeUpdate, currentRef string, currentCommit, targetCommit *objects.Commit) error {
+	targetFiles, err := merge.ReadTree(repo, targetCommit.Tree())
 	if err != nil {
-		return fmt.Errorf("failed to find merge base: %w", err)
+		return fmt.Errorf("failed to read target tree: %w", err)
 	}
 
-	// Perform three-way merge
-	return performThreeWayMerge(repo, refManager, currentCommit, targetCommit, mergeBase, branchName, noCommit, message)
-}
-
-func performFastForwardMerge(repo *vcs.Repository, refManager *refs.RefManager, currentRef string, targetCommitID objects.ObjectID, branchName string) error {
-	// Update the current branch to point to target commit
-	if err := refManager.WriteRef(currentRef, targetCommitID, nil); err != nil {
-		return fmt.Errorf("failed to update branch: %w", err)
+	result := &merge.Result{Entries: targetFiles}
+	if err := checkMergeOverwrites(repo, currentCommit.Tree(), result); err != nil {
+		return err
 	}
 
 	// Update working directory
-	targetCommit, err := repo.GetCommit(targetCommitID)
-	if err != nil {
-		return fmt.Errorf("failed to get target commit: %w", err)
+	if err := updateWorktree(repo, currentCommit.Tree(), result, update); err != nil {
+		return fmt.Errorf("failed to update working directory: %w", err)
 	}
 
-	if err := updateWorkingDirectoryFromCommit(repo, targetCommit); err != nil {
-		return fmt.Errorf("failed to update working directory: %w", err)
+	// Update the current branch to point to target commit
+	if err := refManager.WriteRef(currentRef, targetCommit.ID(), nil); err != nil {
+		return fmt.Errorf("failed to update branch: %w", err)
+	}
+	logRefUpdate(refManager, currentRef, currentCommit.ID(), targetCommit.ID(), "merge: Fast-forward")
+	if err := resetIndexToHead(repo); err != nil {
+		return err
 	}
 
-	fmt.Printf("Fast-forward\n")
-	fmt.Printf("Updating %s..%s\n", targetCommitID.Short(), targetCommitID.Short())
+	fmt.Fprintf(out, "Updating %s..%s\n", currentCommit.ID().Short(), targetCommit.ID().Short())
+	fmt.Fprintf(out, "Fast-forward\n")
 
 	return nil
 }
 
-func performThreeWayMerge(repo *vcs.Repository, refManager *refs.RefManager, currentCommit, targetCommit *objects.Commit, mergeBase objects.ObjectID, branchName string, noCommit bool, message string) error {
-	// Get target tree for merge
-	targetTree, err := repo.GetTree(targetCommit.Tree())
-	if err != nil {
-		return fmt.Errorf("failed to get target tree: %w", err)
+func performThreeWayMerge(out io.Writer, repo *vcs.Repository, refManager *refs.RefManager, update *worktreeUpdate, report *operationReport, currentRef string, currentCommit, targetCommit *objects.Commit, bases merge.BaseFinder, strategy string, opts merge.Options, branchName string, noCommit bool, fastForward, message string) error {
+	var result *merge.Result
+	if strategy == "ours" {
+		// The ours strategy records the merge but keeps our tree as it is
+		entries, err := merge.ReadTree(repo, currentCommit.Tree())
+		if err != nil {
+			return fmt.Errorf("failed to read current tree: %w", err)
+		}
+		result = &merge.Result{Entries: entries}
+	} else {
+		var err error
+		if result, err = merge.Commits(repo, bases, currentCommit.ID(), targetCommit.ID(), opts); err != nil {
+			return fmt.Errorf("failed to merge trees: %w", err)
+		}
 	}
 
-	// Simple merge: just use target tree
-	// In a real implementation, this would do proper 3-way merge with conflict resolution
-	_ = mergeBase // Not used in simple implementation
+	return concludeMerge(out, repo, refManager, update, report, currentRef, currentCommit, result, []objects.ObjectID{targetCommit.ID()}, []string{branchName}, strategy, noCommit, fastForward, message)
+}
 
-	// Perform simple merge (just use target tree for now)
-	// In a real implementation, this would do proper 3-way merge
-	mergedTree := targetTree
+// runOctopusMerge merges several branches into the current branch at once
+func runOctopusMerge(out io.Writer, repo *vcs.Repository, refManager *refs.RefManager, update *worktreeUpdate, names []string, noCommit bool, fastForward, strategy string, opts merge.Options, message string) (*operationReport, error) {
+	switch fastForward {
+	case "auto", "no":
+	case "only":
+		return nil, fmt.Errorf("not possible to fast-forward, aborting")
+	default:
+		return nil, fmt.Errorf("invalid --ff mode %q (expected auto, no or only)", fastForward)
+	}
+	switch strategy {
+	case "octopus", "ours":
+	case "recursive", "ort", "resolve":
+		return nil, fmt.Errorf("the %s strategy merges a single branch; use --strategy=octopus", strategy)
+	default:
+		return nil, fmt.Errorf("unknown merge strategy %q (expected recursive, ort, resolve, ours or octopus)", strategy)
+	}
 
-	// Create merge commit if not no-commit
-	if !noCommit {
-		if message == "" {
-			message = fmt.Sprintf("Merge branch '%s'", branchName)
-		}
+	if _, ok, err := readMergeHead(repo); err != nil {
+		return nil, err
+	} else if ok {
+		return nil, fmt.Errorf("you have not concluded your merge (MERGE_HEAD exists)")
+	}
 
-		parents := []objects.ObjectID{currentCommit.ID(), targetCommit.ID()}
-		sig := objects.Signature{
-			Name:  "VCS User",
-			Email: "user@example.com",
-			When:  time.Now(),
+	currentBranch, err := refManager.CurrentBranch()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current branch: %w", err)
+	}
+	currentRef := "refs/heads/" + currentBranch
+	currentCommitID, err := refManager.ResolveRef(currentRef)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve current branch: %w", err)
+	}
+	currentCommit, err := repo.GetCommit(currentCommitID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get current commit: %w", err)
+	}
+
+	heads := make([]objects.ObjectID, len(names))
+	for i, name := range names {
+		if heads[i], err = refManager.ResolveRef(name); err != nil {
+			return nil, fmt.Errorf("failed to resolve target branch %q: %w", name, err)
 		}
+	}
 
-		mergeCommit, err := repo.CreateCommit(mergedTree.ID(), parents, sig, sig, message)
+	report := &operationReport{Operation: "merge", Head: currentCommitID.String()}
+
+	var result *merge.Result
+	var merged []objects.ObjectID
+	if strategy == "ours" {
+		entries, err := merge.ReadTree(repo, currentCommit.Tree())
 		if err != nil {
-			return fmt.Errorf("failed to create merge commit: %w", err)
+			return nil, fmt.Errorf("failed to read current tree: %w", err)
+		}
+		result, merged = &merge.Result{Entries: entries}, heads
+	} else {
+		result, merged, err = merge.Octopus(repo, newResolver(repo).MergeBasesOf, currentCommitID, heads, names, opts)
+		if errors.Is(err, merge.ErrOctopusConflict) {
+			fmt.Fprintf(out, "Merge with strategy octopus failed.\n")
+			return nil, fmt.Errorf("octopus merge stopped at a conflict before the last branch; merge the branches one at a time")
 		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to merge trees: %w", err)
+		}
+	}
+	if len(merged) == 0 {
+		fmt.Fprintf(out, "Already up to date.\n")
+		report.Status = reportUpToDate
+		return report, nil
+	}
 
-		// Update current branch
-		currentBranch, err := refManager.CurrentBranch()
+	// Name the branches that are merged, and the commits they bring
+	var mergedNames []string
+	seen := make(map[objects.ObjectID]bool)
+	for i, head := range heads {
+		if !containsObjectID(merged, head) {
+			fmt.Fprintf(out, "Already up to date with %s\n", names[i])
+			continue
+		}
+		mergedNames = append(mergedNames, names[i])
+		applied, err := commitsBetween(repo, currentCommitID, head)
 		if err != nil {
-			return fmt.Errorf("failed to get current branch: %w", err)
+			return nil, fmt.Errorf("failed to list merged commits: %w", err)
 		}
+		for _, commit := range applied {
+			if !seen[commit.ID()] {
+				seen[commit.ID()] = true
+				report.Applied = append(report.Applied, newReportCommit(commit))
+			}
+		}
+	}
 
-		currentRef := "refs/heads/" + currentBranch
-		if err := refManager.WriteRef(currentRef, mergeCommit.ID(), nil); err != nil {
-			return fmt.Errorf("failed to update branch: %w", err)
+	return report, concludeMerge(out, repo, refManager, update, report, currentRef, currentCommit, result, merged, mergedNames, strategy, noCommit, fastForward, message)
+}
+
+// concludeMerge writes the result of merging heads, named by names, into
+// the working directory and commits it, or leaves it in the index,
+// MERGE_HEAD and MERGE_MSG when it has conflicts or noCommit is set
+func concludeMerge(out io.Writer, repo *vcs.Repository, refManager *refs.RefManager, update *worktreeUpdate, report *operationReport, currentRef string, currentCommit *objects.Commit, result *merge.Result, heads []objects.ObjectID, names []string, strategy string, noCommit bool, fastForward, message string) error {
+	if err := checkMergeOverwrites(repo, currentCommit.Tree(), result); err != nil {
+		return err
+	}
+	if err := updateWorktree(repo, currentCommit.Tree(), result, update); err != nil {
+		return fmt.Errorf("failed to update working directory: %w", err)
+	}
+
+	if message == "" {
+		message = mergeMessage(names)
+	}
+	if !strings.HasSuffix(message, "\n") {
+		message += "\n"
+	}
+
+	// A merge that does not commit leaves its result in the index,
+	// MERGE_HEAD and MERGE_MSG for the next commit to pick up
+	if !result.Clean() || noCommit {
+		if err := writeMergeIndex(repo, result); err != nil {
+			return err
+		}
+		if err := writeMergeHead(repo, heads...); err != nil {
+			return err
 		}
+		if err := writeMergeMsg(repo, message, result.Conflicts, fastForward); err != nil {
+			return err
+		}
+	}
 
-		fmt.Printf("Merge made by the 'recursive' strategy.\n")
-	} else {
-		fmt.Printf("Automatic merge went well; stopped before committing as requested\n")
+	if !result.Clean() {
+		printMergeConflicts(out, result.Conflicts, names[len(names)-1])
+		if err := rerereConflicts(out, repo, result.Conflicts); err != nil {
+			return err
+		}
+		report.Status = reportConflicted
+		report.Conflicts = newReportConflicts(result.Conflicts)
+		return errMergeConflict
 	}
 
-	// Update working directory and index
-	if err := updateWorkingDirectoryFromCommit(repo, &objects.Commit{}); err != nil {
-		return fmt.Errorf("failed to update working directory: %w", err)
+	if noCommit {
+		fmt.Fprintf(out, "Automatic merge went well; stopped before committing as requested\n")
+		report.Status = reportStopped
+		return nil
+	}
+
+	treeID, err := merge.WriteTree(repo, result.Entries)
+	if err != nil {
+		return fmt.Errorf("failed to write merged tree: %w", err)
 	}
 
+	sig, err := getSignature("")
+	if err != nil {
+		return err
+	}
+
+	parents := append([]objects.ObjectID{currentCommit.ID()}, heads...)
+	mergeCommit, err := repo.CreateCommit(treeID, parents, sig, sig, message)
+	if err != nil {
+		return fmt.Errorf("failed to create merge commit: %w", err)
+	}
+
+	if err := refManager.WriteRef(currentRef, mergeCommit.ID(), nil); err != nil {
+		return fmt.Errorf("failed to update branch: %w", err)
+	}
+	logRefUpdate(refManager, currentRef, currentCommit.ID(), mergeCommit.ID(), "merge "+strings.Join(names, " ")+": Merge made by the '"+strategy+"' strategy.")
+	if err := resetIndexToHead(repo); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "Merge made by the '%s' strategy.\n", strategy)
+	report.Status = reportCompleted
+	report.Head = mergeCommit.ID().String()
+
 	return nil
 }
 
-func isAncestor(repo *vcs.Repository, ancestor, descendant objects.ObjectID) (bool, error) {
-	if ancestor.Equal(descendant) {
-		return true, nil
+// mergeMessage is the default message of a merge of the named branches
+func mergeMessage(names []string) string {
+	if len(names) == 1 {
+		return fmt.Sprintf("Merge branch '%s'", names[0])
+	}
+	quoted := make([]string, len(names))
+	for i, name := range names {
+		quoted[i] = "'" + name + "'"
 	}
+	return fmt.Sprintf("Merge branches %s and %s", strings.Join(quoted[:len(quoted)-1], ", "), quoted[len(quoted)-1])
+}
 
-	// Simple implementation: check if ancestor is in the history of descendant
-	current := descendant
-	visited := make(map[objects.ObjectID]bool)
+func containsObjectID(ids []objects.ObjectID, id objects.ObjectID) bool {
+	for _, other := range ids {
+		if other == id {
+			return true
+		}
+	}
+	return false
+}
 
-	for !current.IsZero() && !visited[current] {
-		visited[current] = true
+// mergeOptions returns the merge options set by merge.conflictStyle and
+// the -X strategy options
+func mergeOptions(repo *vcs.Repository, strategyOptions []string) (merge.Options, error) {
+	var opts merge.Options
+	cfg := loadConfig(repo.GitDir())
+	if style, ok := cfg.Get("merge.conflictStyle"); ok && style != "" {
+		parsed, err := merge.ParseConflictStyle(style)
+		if err != nil {
+			return opts, fmt.Errorf("bad merge.conflictStyle: %w", err)
+		}
+		opts.Style = parsed
+	}
+	for _, key := range []string{"merge.renames", "diff.renames"} {
+		value, ok := cfg.Get(key)
+		if !ok {
+			continue
+		}
+		if strings.EqualFold(value, "copies") || strings.EqualFold(value, "copy") {
+			break
+		}
+		enabled, err := config.ParseBool(value)
+		if err != nil {
+			return opts, fmt.Errorf("bad %s: %w", key, err)
+		}
+		opts.NoRenames = !enabled
+		break
+	}
 
-		if current.Equal(ancestor) {
-			return true, nil
+	for _, option := range strategyOptions {
+		name, value, hasValue := strings.Cut(option, "=")
+		switch {
+		case name == "ours" && !hasValue:
+			opts.Favor = merge.FavorOurs
+		case name == "theirs" && !hasValue:
+			opts.Favor = merge.FavorTheirs
+		case (name == "ignore-space-change" || name == "ignore-all-space") && !hasValue:
+			opts.IgnoreSpaceChange = true
+		case name == "no-renames" && !hasValue:
+			opts.NoRenames = true
+		case name == "patience" && !hasValue:
+			opts.DiffAlgorithm = merge.DiffPatience
+		case name == "diff-algorithm" && hasValue:
+			algorithm, err := merge.ParseDiffAlgorithm(value)
+			if err != nil {
+				return opts, err
+			}
+			opts.DiffAlgorithm = algorithm
+		case name == "find-renames" || (name == "rename-threshold" && hasValue):
+			opts.NoRenames = false
+			if !hasValue {
+				continue
+			}
+			threshold, err := parseRenameThreshold(value)
+			if err != nil || threshold < 1 {
+				return opts, fmt.Errorf("invalid rename threshold %q", value)
+			}
+			opts.RenameThreshold = threshold
+		default:
+			return opts, fmt.Errorf("unknown strategy option: -X%s", option)
 		}
+	}
+	return opts, nil
+}
 
-		commit, err := repo.GetCommit(current)
-		if err != nil {
-			return false, err
+// firstMergeBase narrows bases to the first merge base found, so no
+// virtual ancestor is built
+func firstMergeBase(bases merge.BaseFinder) merge.BaseFinder {
+	return func(a []objects.ObjectID, b objects.ObjectID) ([]objects.ObjectID, error) {
+		found, err := bases(a, b)
+		if len(found) > 1 {
+			found = found[:1]
 		}
+		return found, err
+	}
+}
 
-		parents := commit.Parents()
-		if len(parents) == 0 {
-			break
+// printMergeConflicts describes each conflict the way git does
+func printMergeConflicts(out io.Writer, conflicts []merge.Conflict, theirs string) {
+	for _, c := range conflicts {
+		switch c.Type {
+		case merge.ConflictModifyDelete:
+			fmt.Fprintf(out, "CONFLICT (%s): %s deleted in %s and modified in HEAD. Version HEAD of %s left in tree.\n",
+				c.Type, c.Path, theirs, c.Path)
+		case merge.ConflictDeleteModify:
+			fmt.Fprintf(out, "CONFLICT (%s): %s deleted in HEAD and modified in %s. Version %s of %s left in tree.\n",
+				c.Type, c.Path, theirs, theirs, c.Path)
+		default:
+			fmt.Fprintf(out, "Auto-merging %s\n", c.Path)
+			fmt.Fprintf(out, "CONFLICT (%s): Merge conflict in %s\n", c.Type, c.Path)
 		}
+	}
+}
 
-		// Follow first parent for simplicity
-		current = parents[0]
+// checkMergeOverwrites refuses a merge into oursTree that would lose local
+// work: staged changes, which the index of the merge replaces, and local
+// changes to or untracked files at the paths result changes
+func checkMergeOverwrites(repo *vcs.Repository, oursTree objects.ObjectID, result *merge.Result) error {
+	idx, err := readIndex(repo)
+	if err != nil {
+		return err
+	}
+	if len(idx.Unmerged()) > 0 {
+		return fmt.Errorf("you need to resolve your current index first")
+	}
+	entries, err := merge.ReadTree(repo, oursTree)
+	if err != nil {
+		return fmt.Errorf("failed to read current tree: %w", err)
 	}
 
-	return false, nil
+	committed := make(map[string]merge.Entry, len(entries))
+	for _, entry := range entries {
+		committed[entry.Path] = entry
+	}
+	var staged []string
+	for _, entry := range idx.Entries() {
+		if c, ok := committed[entry.Path]; !ok || c.ID != entry.ID || c.Mode != entry.Mode {
+			staged = append(staged, entry.Path)
+		}
+		delete(committed, entry.Path)
+	}
+	for path := range committed {
+		staged = append(staged, path)
+	}
+	if len(staged) > 0 {
+		sort.Strings(staged)
+		return localChangesError(staged)
+	}
+	return checkWorktreeOverwrites(repo, idx, oursTree, result)
+}
+
+// checkoutMergeResult updates the working directory from the files of
+// oursTree to those of result, writing conflicted files with their
+// conflict markers
+func checkoutMergeResult(repo *vcs.Repository, oursTree objects.ObjectID, result *merge.Result) error {
+	return updateWorktree(repo, oursTree, result, nil)
 }
 
-func findMergeBase(repo *vcs.Repository, commit1, commit2 objects.ObjectID) (objects.ObjectID, error) {
-	// Simple implementation: find common ancestor
-	ancestors1 := make(map[objects.ObjectID]bool)
+// updateWorktree is checkoutMergeResult, showing its progress and recording
+// the paths it changes in update when that is not nil
+func updateWorktree(repo *vcs.Repository, oursTree objects.ObjectID, result *merge.Result, update *worktreeUpdate) error {
+	current, err := merge.ReadTree(repo, oursTree)
+	if err != nil {
+		return fmt.Errorf("failed to read current tree: %w", err)
+	}
+
+	remaining := make(map[string]bool)
+	for _, entry := range result.Entries {
+		remaining[entry.Path] = true
+	}
+	for _, c := range result.Conflicts {
+		remaining[c.Path] = true
+	}
+
+	var removed, written, conflicted []string
+	unchanged := make(map[merge.Entry]bool)
+	for _, entry := range current {
+		if !remaining[entry.Path] {
+			removed = append(removed, entry.Path)
+			continue
+		}
+		unchanged[entry] = true
+	}
+	var writes []merge.Entry
+	for _, entry := range result.Entries {
+		if unchanged[entry] || entry.Mode == objects.ModeCommit {
+			continue
+		}
+		writes = append(writes, entry)
+		written = append(written, entry.Path)
+	}
+	var conflictWrites []merge.Conflict
+	for _, c := range result.Conflicts {
+		conflicted = append(conflicted, c.Path)
+		if c.Content != nil {
+			conflictWrites = append(conflictWrites, c)
+		}
+	}
 
-	// Collect all ancestors of commit1
-	current := commit1
-	visited := make(map[objects.ObjectID]bool)
+	meter := update.meter(len(removed) + len(writes) + len(conflictWrites))
+	for _, p := range removed {
+		if err := os.Remove(filepath.Join(repo.WorkDir(), p)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", p, err)
+		}
+		meter.Add(1)
+	}
 
-	for !current.IsZero() && !visited[current] {
-		visited[current] = true
-		ancestors1[current] = true
+	// A partial clone fetches the blobs it lacks in one batch
+	ids := make([]objects.ObjectID, len(writes))
+	for i, entry := range writes {
+		ids[i] = entry.ID
+	}
+	if err := repo.Storage().FetchMissing(ids); err != nil {
+		return fmt.Errorf("failed to fetch missing objects: %w", err)
+	}
 
-		commit, err := repo.GetCommit(current)
+	conv := newConverter(repo, os.Stderr, nil)
+	for _, entry := range writes {
+		blob, err := repo.GetBlob(entry.ID)
 		if err != nil {
-			return objects.ObjectID{}, err
+			return fmt.Errorf("failed to read %s: %w", entry.Path, err)
 		}
+		if err := writeWorkingFile(repo, conv, entry.Path, entry.Mode, blob.Data()); err != nil {
+			return err
+		}
+		meter.Add(1)
+	}
 
-		parents := commit.Parents()
-		if len(parents) == 0 {
-			break
+	for _, c := range conflictWrites {
+		if err := writeWorkingFile(repo, conv, c.Path, c.Mode, c.Content); err != nil {
+			return err
 		}
+		meter.Add(1)
+	}
+	meter.Done()
+
+	update.record(written, removed, conflicted)
+	return nil
+}
 
-		current = parents[0]
+// writeWorkingFile writes a file of the working directory, converted by conv
+func writeWorkingFile(repo *vcs.Repository, conv *convert.Converter, path string, mode objects.FileMode, data []byte) error {
+	data, err := conv.Smudge(path, data)
+	if err != nil {
+		return err
 	}
 
-	// Find first common ancestor in commit2's history
-	current = commit2
-	visited = make(map[objects.ObjectID]bool)
+	fullPath := filepath.Join(repo.WorkDir(), path)
+	if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
+		return fmt.Errorf("failed to create parent directory for %s: %w", path, err)
+	}
 
-	for !current.IsZero() && !visited[current] {
-		visited[current] = true
+	var fileMode os.FileMode = 0644
+	if mode == objects.ModeExec {
+		fileMode = 0755
+	}
+	if err := os.WriteFile(fullPath, data, fileMode); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
 
-		if ancestors1[current] {
-			return current, nil
+// writeMergeIndex records the merged files in the index, with conflicted
+// paths at stages 1 (base), 2 (ours) and 3 (theirs)
+func writeMergeIndex(repo *vcs.Repository, result *merge.Result) error {
+	idx := index.New()
+	for _, entry := range result.Entries {
+		if err := idx.Add(&index.Entry{Mode: entry.Mode, ID: entry.ID, Path: entry.Path}); err != nil {
+			return fmt.Errorf("failed to add %s to index: %w", entry.Path, err)
 		}
+	}
+
+	for _, c := range result.Conflicts {
+		for stage, side := range []*merge.Entry{c.Base, c.Ours, c.Theirs} {
+			if side == nil {
+				continue
+			}
+			entry := &index.Entry{Mode: side.Mode, ID: side.ID, Path: c.Path}
+			entry.SetStage(stage + 1)
+			if err := idx.Add(entry); err != nil {
+				return fmt.Errorf("failed to add %s to index: %w", c.Path, err)
+			}
+		}
+	}
+
+	if err := idx.WriteToFile(filepath.Join(repo.GitDir(), "index")); err != nil {
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+	return nil
+}
+
+// writeMergeHead records the commits being merged for the next commit
+func writeMergeHead(repo *vcs.Repository, ids ...objects.ObjectID) error {
+	var data strings.Builder
+	for _, id := range ids {
+		data.WriteString(id.String() + "\n")
+	}
+	if err := os.WriteFile(filepath.Join(repo.GitDir(), "MERGE_HEAD"), []byte(data.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write MERGE_HEAD: %w", err)
+	}
+	return nil
+}
 
-		commit, err := repo.GetCommit(current)
+// readMergeHead returns the commits recorded in MERGE_HEAD, if any
+func readMergeHead(repo *vcs.Repository) ([]objects.ObjectID, bool, error) {
+	data, err := os.ReadFile(filepath.Join(repo.GitDir(), "MERGE_HEAD"))
+	if os.IsNotExist(err) {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read MERGE_HEAD: %w", err)
+	}
+
+	var ids []objects.ObjectID
+	for _, line := range strings.Fields(string(data)) {
+		id, err := objects.ParseObjectID(line)
 		if err != nil {
-			return objects.ObjectID{}, err
+			return nil, false, fmt.Errorf("invalid MERGE_HEAD: %w", err)
 		}
+		ids = append(ids, id)
+	}
+	return ids, len(ids) > 0, nil
+}
 
-		parents := commit.Parents()
-		if len(parents) == 0 {
-			break
+// writeMergeMsg records message in MERGE_MSG, followed by the conflicted
+// paths in comments, and in MERGE_MODE whether the merge was --no-ff
+func writeMergeMsg(repo *vcs.Repository, message string, conflicts []merge.Conflict, fastForward string) error {
+	if len(conflicts) > 0 {
+		message += "\n# Conflicts:\n"
+		for _, c := range conflicts {
+			message += "#\t" + c.Path + "\n"
 		}
+	}
+	if err := os.WriteFile(filepath.Join(repo.GitDir(), "MERGE_MSG"), []byte(message), 0644); err != nil {
+		return fmt.Errorf("failed to write MERGE_MSG: %w", err)
+	}
 
-		current = parents[0]
+	var mode string
+	if fastForward == "no" {
+		mode = "no-ff"
 	}
+	if err := os.WriteFile(filepath.Join(repo.GitDir(), "MERGE_MODE"), []byte(mode), 0644); err != nil {
+		return fmt.Errorf("failed to write MERGE_MODE: %w", err)
+	}
+	return nil
+}
 
-	return objects.ObjectID{}, nil // No common ancestor found
+// readMergeMsg returns the message a stopped merge left in MERGE_MSG
+// without its comments, or "" when there is none
+func readMergeMsg(repo *vcs.Repository) (string, error) {
+	data, err := os.ReadFile(filepath.Join(repo.GitDir(), "MERGE_MSG"))
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to read MERGE_MSG: %w", err)
+	}
+	return stripComments(string(data)), nil
 }
 
-func updateWorkingDirectoryFromCommit(repo *vcs.Repository, commit *objects.Commit) error {
-	// Simple implementation: clear index and working directory
-	indexPath := filepath.Join(repo.GitDir(), "index")
-	idx := index.New()
+// removeMergeState removes what a stopped merge left for the commit
+// concluding it
+func removeMergeState(repo *vcs.Repository) error {
+	for _, name := range []string{"MERGE_HEAD", "MERGE_MSG", "MERGE_MODE"} {
+		if err := os.Remove(filepath.Join(repo.GitDir(), name)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", name, err)
+		}
+	}
+	return nil
+}
 
-	if err := idx.WriteToFile(indexPath); err != nil {
-		return fmt.Errorf("failed to write index: %w", err)
+// fetchHeadMerge returns the description, as "branch '<name>' of <url>",
+// of the branch FETCH_HEAD marks for merging first. Those come first in
+// FETCH_HEAD, so it is the commit FETCH_HEAD resolves to.
+func fetchHeadMerge(repo *vcs.Repository) (string, error) {
+	data, err := os.ReadFile(filepath.Join(repo.GitDir(), "FETCH_HEAD"))
+	if err != nil {
+		return "", fmt.Errorf("failed to read FETCH_HEAD: %w", err)
 	}
+	line, _, _ := strings.Cut(string(data), "\n")
+	if fields := strings.SplitN(line, "\t", 3); len(fields) == 3 && fields[1] == "" {
+		return fields[2], nil
+	}
+	return "", fmt.Errorf("FETCH_HEAD does not list a branch to merge")
+}
 
-	// In a real implementation, this would extract all files from the commit's tree
-	// For now, just clear everything
-	return nil
-}
\ No newline at end of file
+// commitsBetween returns the commits reachable from to but not from from,
+// oldest first
+func commitsBetween(repo *vcs.Repository, from, to objects.ObjectID) ([]*objects.Commit, error) {
+	if to.IsZero() {
+		return nil, nil
+	}
+	rng := &revparse.Range{Include: []objects.ObjectID{to}}
+	if !from.IsZero() {
+		rng.Exclude = []objects.ObjectID{from}
+	}
+	ids, err := newResolver(repo).RangeCommits(rng)
+	if err != nil {
+		return nil, err
+	}
+
+	commits := make([]*objects.Commit, len(ids))
+	for i, id := range ids {
+		commit, err := repo.GetCommit(id)
+		if err != nil {
+			return nil, err
+		}
+		commits[len(ids)-1-i] = commit
+	}
+	return commits, nil
+}
+
+// isAncestor reports whether ancestor is descendant or one of its
+// ancestors
+func isAncestor(repo *vcs.Repository, ancestor, descendant objects.ObjectID) (bool, error) {
+	return newResolver(repo).IsAncestor(ancestor, descendant)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+// mergeBaseOptions holds the flags of merge-base
+type mergeBaseOptions struct {
+	all        bool
+	isAncestor bool
+	octopus    bool
+}
+
+func newMergeBaseCommand() *cobra.Command {
+	var opts mergeBaseOptions
+
+	cmd := &cobra.Command{
+		Use:   "merge-base [flags] <commit> <commit>...",
+		Short: "Find the best common ancestors of commits",
+		Long: `Prints a best common ancestor of two commits, one that is not an
+ancestor of another common ancestor, as a three-way merge would use for
+its base. --all prints every one, newest first. With more than two
+commits it is the merge base of the first and a merge of the others.
+
+--octopus finds the best common ancestors of all the commits, as an
+octopus merge of them does. --is-ancestor prints nothing and exits with
+status 0 when the first of its two commits is an ancestor of the second
+and 1 when it is not. Without any common ancestor merge-base exits with
+status 1 too.
+
+Generation numbers from the commit-graph bound the search, so only the
+history above the merge bases is read.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			switch {
+			case opts.isAncestor && (opts.all || opts.octopus):
+				return fmt.Errorf("--is-ancestor cannot be used with --all or --octopus")
+			case opts.isAncestor && len(args) != 2:
+				return fmt.Errorf("--is-ancestor takes exactly two commits")
+			case !opts.octopus && len(args) < 2:
+				return fmt.Errorf("merge-base needs at least two commits")
+			}
+
+			found, err := runMergeBase(cmd, args, opts)
+			if err == nil && !found {
+				cmd.SilenceErrors = true
+				cmd.SilenceUsage = true
+				return fmt.Errorf("no merge base")
+			}
+			return err
+		},
+	}
+
+	cmd.Flags().BoolVarP(&opts.all, "all", "a", false, "Print every best common ancestor")
+	cmd.Flags().BoolVar(&opts.isAncestor, "is-ancestor", false, "Check whether the first commit is an ancestor of the second")
+	cmd.Flags().BoolVar(&opts.octopus, "octopus", false, "Find the merge bases of all the commits at once")
+
+	return cmd
+}
+
+// runMergeBase prints the merge bases of args and reports whether there
+// was any, or for --is-ancestor whether the first is an ancestor
+func runMergeBase(cmd *cobra.Command, args []string, opts mergeBaseOptions) (bool, error) {
+	repoPath, err := findRepository()
+	if err != nil {
+		return false, fmt.Errorf("not a git repository: %w", err)
+	}
+	repo, err := openRepository(repoPath)
+	if err != nil {
+		return false, fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	resolver := newResolver(repo)
+	ids := make([]objects.ObjectID, len(args))
+	for i, arg := range args {
+		if ids[i], err = resolver.ResolveCommit(arg); err != nil {
+			return false, fmt.Errorf("not a valid commit: %s", arg)
+		}
+	}
+
+	if opts.isAncestor {
+		return resolver.IsAncestor(ids[0], ids[1])
+	}
+
+	var bases []objects.ObjectID
+	if opts.octopus {
+		bases, err = resolver.OctopusMergeBases(ids)
+	} else {
+		bases, err = resolver.MergeBasesOf(ids[1:], ids[0])
+	}
+	if err != nil {
+		return false, err
+	}
+	if !opts.all && len(bases) > 1 {
+		bases = bases[:1]
+	}
+	for _, id := range bases {
+		fmt.Fprintln(cmd.OutOrStdout(), id)
+	}
+	return len(bases) > 0, nil
+}
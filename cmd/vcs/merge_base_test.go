@@ -0,0 +1,105 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// setupMergeBaseRepo builds this history, oldest first, with main at c3:
+//
+//	c1 - c2 - c3 (main)
+//	      \ - s1 (side)
+//	      \ - o1 (other)
+func setupMergeBaseRepo(t *testing.T) map[string]objects.ObjectID {
+	repoPath := t.TempDir()
+	repo, err := vcs.Init(repoPath)
+	require.NoError(t, err)
+
+	ids := make(map[string]objects.ObjectID)
+	ids["c1"] = commitFiles(t, repo, map[string]string{"a.txt": "1\n"}, nil, "c1\n")
+	ids["c2"] = commitFiles(t, repo, map[string]string{"a.txt": "2\n"}, []objects.ObjectID{ids["c1"]}, "c2\n")
+	ids["c3"] = commitFiles(t, repo, map[string]string{"a.txt": "3\n"}, []objects.ObjectID{ids["c2"]}, "c3\n")
+	ids["s1"] = commitFiles(t, repo, map[string]string{"s.txt": "s\n"}, []objects.ObjectID{ids["c2"]}, "s1\n")
+	ids["o1"] = commitFiles(t, repo, map[string]string{"o.txt": "o\n"}, []objects.ObjectID{ids["c2"]}, "o1\n")
+
+	refManager := refs.NewRefManager(repo.GitDir())
+	require.NoError(t, refManager.UpdateRef("refs/heads/main", ids["c3"]))
+	require.NoError(t, refManager.UpdateRef("refs/heads/side", ids["s1"]))
+	require.NoError(t, refManager.UpdateRef("refs/heads/other", ids["o1"]))
+	require.NoError(t, refManager.SetHEAD("refs/heads/main"))
+
+	oldWd, _ := os.Getwd()
+	t.Cleanup(func() { os.Chdir(oldWd) })
+	require.NoError(t, os.Chdir(repoPath))
+	return ids
+}
+
+func runMergeBaseArgs(args ...string) (string, error) {
+	cmd := newMergeBaseCommand()
+	var stdout bytes.Buffer
+	cmd.SetOut(&stdout)
+	cmd.SetErr(&stdout)
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+	return stdout.String(), err
+}
+
+func TestMergeBase(t *testing.T) {
+	ids := setupMergeBaseRepo(t)
+
+	out, err := runMergeBaseArgs("main", "side")
+	require.NoError(t, err)
+	assert.Equal(t, ids["c2"].String()+"\n", out)
+
+	out, err = runMergeBaseArgs("--all", "main", ids["c1"].String())
+	require.NoError(t, err)
+	assert.Equal(t, ids["c1"].String()+"\n", out)
+
+	// With more commits, the base of the first and a merge of the others
+	out, err = runMergeBaseArgs("side", "main", "other")
+	require.NoError(t, err)
+	assert.Equal(t, ids["c2"].String()+"\n", out)
+
+	out, err = runMergeBaseArgs("--octopus", "main", "side", "other")
+	require.NoError(t, err)
+	assert.Equal(t, ids["c2"].String()+"\n", out)
+
+	_, err = runMergeBaseArgs("main")
+	assert.ErrorContains(t, err, "at least two commits")
+}
+
+func TestMergeBaseIsAncestor(t *testing.T) {
+	ids := setupMergeBaseRepo(t)
+
+	out, err := runMergeBaseArgs("--is-ancestor", ids["c1"].String(), "main")
+	require.NoError(t, err)
+	assert.Empty(t, out)
+
+	out, err = runMergeBaseArgs("--is-ancestor", "side", "main")
+	assert.Error(t, err)
+	assert.Empty(t, out)
+
+	_, err = runMergeBaseArgs("--is-ancestor", "--all", "side", "main")
+	assert.ErrorContains(t, err, "cannot be used")
+	_, err = runMergeBaseArgs("--is-ancestor", "side")
+	assert.ErrorContains(t, err, "exactly two commits")
+}
+
+func TestMergeBaseUnrelated(t *testing.T) {
+	setupMergeBaseRepo(t)
+	repo, err := openRepository(".")
+	require.NoError(t, err)
+	root := commitFiles(t, repo, map[string]string{"x.txt": "x\n"}, nil, "unrelated\n")
+
+	out, err := runMergeBaseArgs("main", root.String())
+	assert.Error(t, err)
+	assert.Empty(t, out)
+}
@@ -2,13 +2,19 @@ package main
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
 	"os"
 	"path/filepath"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/fenilsonani/vcs/internal/core/index"
+	"github.com/fenilsonani/vcs/internal/core/merge"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/refs"
 	"github.com/fenilsonani/vcs/pkg/vcs"
 )
 
@@ -613,4 +619,421 @@ func TestMergeContinue(t *testing.T) {
 	
 	output := buf.String()
 	assert.Contains(t, output, "No merge in progress")
-}
\ No newline at end of file
+}
+// commitFiles creates a commit of files given as name to content
+func commitFiles(t *testing.T, repo *vcs.Repository, files map[string]string, parents []objects.ObjectID, message string) objects.ObjectID {
+	sig := objects.Signature{Name: "Test", Email: "test@example.com", When: time.Now()}
+
+	var entries []objects.TreeEntry
+	for name, content := range files {
+		blob, err := repo.CreateBlob([]byte(content))
+		require.NoError(t, err)
+		entries = append(entries, objects.TreeEntry{Mode: objects.ModeBlob, Name: name, ID: blob.ID()})
+	}
+	tree, err := repo.CreateTree(entries)
+	require.NoError(t, err)
+	commit, err := repo.CreateCommit(tree.ID(), parents, sig, sig, message)
+	require.NoError(t, err)
+	return commit.ID()
+}
+
+// setupMergeRepo creates a repository whose main branch and topic branch
+// both change the base files, checks out main and changes into it
+func setupMergeRepo(t *testing.T, base, ours, theirs map[string]string) *vcs.Repository {
+	repoPath := t.TempDir()
+	repo, err := vcs.Init(repoPath)
+	require.NoError(t, err)
+
+	baseID := commitFiles(t, repo, base, nil, "base\n")
+	mainID := commitFiles(t, repo, ours, []objects.ObjectID{baseID}, "main change\n")
+	topicID := commitFiles(t, repo, theirs, []objects.ObjectID{baseID}, "topic change\n\nWith a body.\n")
+
+	refManager := refs.NewRefManager(repo.GitDir())
+	require.NoError(t, refManager.UpdateRef("refs/heads/main", mainID))
+	require.NoError(t, refManager.UpdateRef("refs/heads/topic", topicID))
+	require.NoError(t, refManager.SetHEAD("refs/heads/main"))
+
+	for name, content := range ours {
+		require.NoError(t, os.WriteFile(filepath.Join(repoPath, name), []byte(content), 0644))
+	}
+
+	oldWd, _ := os.Getwd()
+	t.Cleanup(func() { os.Chdir(oldWd) })
+	require.NoError(t, os.Chdir(repoPath))
+
+	return repo
+}
+
+func runMergeArgs(args ...string) (string, string, error) {
+	cmd := newMergeCommand()
+	var stdout, stderr bytes.Buffer
+	cmd.SetOut(&stdout)
+	cmd.SetErr(&stderr)
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+	return stdout.String(), stderr.String(), err
+}
+
+func TestMergeReportConflicts(t *testing.T) {
+	repo := setupMergeRepo(t,
+		map[string]string{"a.txt": "base\n", "b.txt": "base\n", "c.txt": "base\n"},
+		map[string]string{"a.txt": "ours\n", "b.txt": "ours\n", "c.txt": "base\n"},
+		map[string]string{"a.txt": "theirs\n", "c.txt": "theirs\n"},
+	)
+
+	stdout, stderr, err := runMergeArgs("--report=json", "topic")
+	require.ErrorIs(t, err, errMergeConflict)
+	assert.Contains(t, stderr, "CONFLICT (content): Merge conflict in a.txt")
+	assert.Contains(t, stderr, "CONFLICT (modify/delete): b.txt deleted in topic")
+
+	var report operationReport
+	require.NoError(t, json.Unmarshal([]byte(stdout), &report))
+	assert.Equal(t, "merge", report.Operation)
+	assert.Equal(t, reportConflicted, report.Status)
+	assert.False(t, report.Resolved)
+	require.Len(t, report.Applied, 1)
+	assert.Equal(t, "topic change", report.Applied[0].Subject)
+	require.Len(t, report.Conflicts, 2)
+	assert.Equal(t, reportConflict{
+		Path:   "a.txt",
+		Type:   "content",
+		Base:   objects.ComputeHash(objects.TypeBlob, []byte("base\n")).String(),
+		Ours:   objects.ComputeHash(objects.TypeBlob, []byte("ours\n")).String(),
+		Theirs: objects.ComputeHash(objects.TypeBlob, []byte("theirs\n")).String(),
+	}, report.Conflicts[0])
+	assert.Equal(t, "b.txt", report.Conflicts[1].Path)
+	assert.Equal(t, "modify/delete", report.Conflicts[1].Type)
+	assert.Empty(t, report.Conflicts[1].Theirs)
+
+	// The working tree and index carry the conflict
+	data, err := os.ReadFile(filepath.Join(repo.WorkDir(), "a.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "<<<<<<< HEAD\nours\n=======\ntheirs\n>>>>>>> topic\n", string(data))
+	data, err = os.ReadFile(filepath.Join(repo.WorkDir(), "c.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "theirs\n", string(data))
+	assert.FileExists(t, filepath.Join(repo.GitDir(), "MERGE_HEAD"))
+
+	idx := index.New()
+	require.NoError(t, idx.ReadFromFile(filepath.Join(repo.GitDir(), "index")))
+	assert.Equal(t, []string{"a.txt", "b.txt"}, idx.Unmerged())
+
+	// A second merge is refused until the first is concluded
+	_, _, err = runMergeArgs("topic")
+	assert.ErrorContains(t, err, "MERGE_HEAD exists")
+}
+
+func TestMergeReportClean(t *testing.T) {
+	repo := setupMergeRepo(t,
+		map[string]string{"a.txt": "one\ntwo\nthree\n", "b.txt": "base\n"},
+		map[string]string{"a.txt": "ONE\ntwo\nthree\n", "b.txt": "base\n"},
+		map[string]string{"a.txt": "one\ntwo\nTHREE\n", "b.txt": "base\n", "new.txt": "new\n"},
+	)
+
+	stdout, stderr, err := runMergeArgs("--report", "json", "topic")
+	require.NoError(t, err)
+	assert.Contains(t, stderr, "Merge made by")
+
+	var report operationReport
+	require.NoError(t, json.Unmarshal([]byte(stdout), &report))
+	assert.Equal(t, reportCompleted, report.Status)
+	assert.True(t, report.Resolved)
+	assert.NotNil(t, report.Conflicts)
+	assert.Empty(t, report.Conflicts)
+
+	head, err := objects.NewObjectID(report.Head)
+	require.NoError(t, err)
+	commit, err := repo.GetCommit(head)
+	require.NoError(t, err)
+	assert.Len(t, commit.Parents(), 2)
+
+	data, err := os.ReadFile(filepath.Join(repo.WorkDir(), "a.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "ONE\ntwo\nTHREE\n", string(data))
+	assert.FileExists(t, filepath.Join(repo.WorkDir(), "new.txt"))
+	assert.NoFileExists(t, filepath.Join(repo.GitDir(), "MERGE_HEAD"))
+}
+
+func TestMergeReportUpToDate(t *testing.T) {
+	setupMergeRepo(t,
+		map[string]string{"a.txt": "base\n"},
+		map[string]string{"a.txt": "ours\n"},
+		map[string]string{"a.txt": "theirs\n"},
+	)
+
+	stdout, _, err := runMergeArgs("--report=json", "main")
+	require.NoError(t, err)
+	assert.Contains(t, stdout, `"status": "up-to-date"`)
+	assert.Contains(t, stdout, `"applied": []`)
+}
+
+func TestMergeReportInvalidFormat(t *testing.T) {
+	_, _, err := runMergeArgs("--report=xml", "topic")
+	assert.ErrorContains(t, err, `unsupported report format "xml"`)
+}
+
+func TestMergeConflictResolvedByCommit(t *testing.T) {
+	repo := setupMergeRepo(t,
+		map[string]string{"a.txt": "base\n"},
+		map[string]string{"a.txt": "ours\n"},
+		map[string]string{"a.txt": "theirs\n"},
+	)
+
+	_, _, err := runMergeArgs("topic")
+	require.ErrorIs(t, err, errMergeConflict)
+
+	commitCmd := newCommitCommand()
+	commitCmd.SetOut(&bytes.Buffer{})
+	commitCmd.SetArgs([]string{"-m", "merge topic"})
+	assert.ErrorContains(t, commitCmd.Execute(), "unresolved conflicts in: a.txt")
+
+	require.NoError(t, os.WriteFile(filepath.Join(repo.WorkDir(), "a.txt"), []byte("resolved\n"), 0644))
+	addCmd := newAddCommand()
+	addCmd.SetOut(&bytes.Buffer{})
+	addCmd.SetArgs([]string{"a.txt"})
+	require.NoError(t, addCmd.Execute())
+
+	commitCmd = newCommitCommand()
+	commitCmd.SetOut(&bytes.Buffer{})
+	commitCmd.SetArgs([]string{"-m", "merge topic"})
+	require.NoError(t, commitCmd.Execute())
+
+	head, err := refs.NewRefManager(repo.GitDir()).ResolveRef("main")
+	require.NoError(t, err)
+	commit, err := repo.GetCommit(head)
+	require.NoError(t, err)
+	assert.Len(t, commit.Parents(), 2)
+	assert.NoFileExists(t, filepath.Join(repo.GitDir(), "MERGE_HEAD"))
+}
+
+func TestMergeStrategyOptionTheirs(t *testing.T) {
+	repo := setupMergeRepo(t,
+		map[string]string{"a.txt": "one\nbase\nthree\n"},
+		map[string]string{"a.txt": "ONE\nours\nthree\n"},
+		map[string]string{"a.txt": "one\ntheirs\nthree\n"},
+	)
+
+	_, _, err := runMergeArgs("-X", "theirs", "topic")
+	require.NoError(t, err)
+	data, err := os.ReadFile(filepath.Join(repo.WorkDir(), "a.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "theirs\nthree\n", string(data)[len("ONE\n"):])
+
+	_, _, err = runMergeArgs("-X", "subtree-shift", "topic")
+	assert.ErrorContains(t, err, "unknown strategy option: -Xsubtree-shift")
+}
+
+func TestMergeConflictStyleDiff3(t *testing.T) {
+	repo := setupMergeRepo(t,
+		map[string]string{"a.txt": "base\n"},
+		map[string]string{"a.txt": "ours\n"},
+		map[string]string{"a.txt": "theirs\n"},
+	)
+	_, err := runConfigArgs("merge.conflictStyle", "diff3")
+	require.NoError(t, err)
+
+	_, _, err = runMergeArgs("topic")
+	require.ErrorIs(t, err, errMergeConflict)
+	data, err := os.ReadFile(filepath.Join(repo.WorkDir(), "a.txt"))
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "<<<<<<< HEAD\nours\n||||||| ")
+	assert.Contains(t, string(data), "\nbase\n=======\ntheirs\n>>>>>>> topic\n")
+}
+
+func TestMergeFollowsRename(t *testing.T) {
+	content := "one\ntwo\nthree\nfour\nfive\n"
+	repo := setupMergeRepo(t,
+		map[string]string{"old.txt": content},
+		map[string]string{"new.txt": content},
+		map[string]string{"old.txt": "one\ntwo\nthree\nfour\nFIVE\n"},
+	)
+
+	_, _, err := runMergeArgs("topic")
+	require.NoError(t, err)
+	data, err := os.ReadFile(filepath.Join(repo.WorkDir(), "new.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "one\ntwo\nthree\nfour\nFIVE\n", string(data))
+	assert.NoFileExists(t, filepath.Join(repo.WorkDir(), "old.txt"))
+}
+
+func TestMergeOptionsRenames(t *testing.T) {
+	repo, _ := setupConfigRepo(t)
+
+	opts, err := mergeOptions(repo, []string{"find-renames=75%"})
+	require.NoError(t, err)
+	assert.False(t, opts.NoRenames)
+	assert.Equal(t, 75, opts.RenameThreshold)
+	opts, err = mergeOptions(repo, []string{"rename-threshold=9"})
+	require.NoError(t, err)
+	assert.Equal(t, 90, opts.RenameThreshold)
+	_, err = mergeOptions(repo, []string{"find-renames=0"})
+	assert.ErrorContains(t, err, "invalid rename threshold")
+
+	// merge.renames wins over diff.renames, and -X over both
+	_, err = runConfigArgs("diff.renames", "false")
+	require.NoError(t, err)
+	opts, err = mergeOptions(repo, nil)
+	require.NoError(t, err)
+	assert.True(t, opts.NoRenames)
+	_, err = runConfigArgs("merge.renames", "copies")
+	require.NoError(t, err)
+	opts, err = mergeOptions(repo, nil)
+	require.NoError(t, err)
+	assert.False(t, opts.NoRenames)
+	_, err = runConfigArgs("merge.renames", "false")
+	require.NoError(t, err)
+	opts, err = mergeOptions(repo, []string{"find-renames"})
+	require.NoError(t, err)
+	assert.False(t, opts.NoRenames)
+}
+
+func TestMergeOptionsDiffAlgorithm(t *testing.T) {
+	repo, _ := setupConfigRepo(t)
+
+	opts, err := mergeOptions(repo, nil)
+	require.NoError(t, err)
+	assert.Empty(t, opts.DiffAlgorithm)
+	opts, err = mergeOptions(repo, []string{"diff-algorithm=histogram"})
+	require.NoError(t, err)
+	assert.Equal(t, merge.DiffHistogram, opts.DiffAlgorithm)
+	opts, err = mergeOptions(repo, []string{"patience"})
+	require.NoError(t, err)
+	assert.Equal(t, merge.DiffPatience, opts.DiffAlgorithm)
+	_, err = mergeOptions(repo, []string{"diff-algorithm=fast"})
+	assert.ErrorContains(t, err, "unknown diff algorithm")
+}
+
+func TestMergeOctopus(t *testing.T) {
+	repo := setupMergeRepo(t,
+		map[string]string{"a.txt": "base\n", "b.txt": "base\n", "c.txt": "base\n"},
+		map[string]string{"a.txt": "ours\n", "b.txt": "base\n", "c.txt": "base\n"},
+		map[string]string{"a.txt": "base\n", "b.txt": "topic\n", "c.txt": "base\n"},
+	)
+	refManager := refs.NewRefManager(repo.GitDir())
+	topicID, err := refManager.ResolveRef("topic")
+	require.NoError(t, err)
+	topic, err := repo.GetCommit(topicID)
+	require.NoError(t, err)
+	otherID := commitFiles(t, repo, map[string]string{"a.txt": "base\n", "b.txt": "base\n", "c.txt": "other\n"},
+		topic.Parents(), "other change\n")
+	require.NoError(t, refManager.UpdateRef("refs/heads/other", otherID))
+
+	stdout, _, err := runMergeArgs("topic", "other")
+	require.NoError(t, err)
+	assert.Contains(t, stdout, "Merge made by the 'octopus' strategy.")
+
+	head, err := refManager.ResolveRef("main")
+	require.NoError(t, err)
+	commit, err := repo.GetCommit(head)
+	require.NoError(t, err)
+	require.Len(t, commit.Parents(), 3)
+	assert.Equal(t, []objects.ObjectID{topicID, otherID}, commit.Parents()[1:])
+	assert.Equal(t, "Merge branches 'topic' and 'other'\n", commit.Message())
+	for name, want := range map[string]string{"a.txt": "ours\n", "b.txt": "topic\n", "c.txt": "other\n"} {
+		data, err := os.ReadFile(filepath.Join(repo.WorkDir(), name))
+		require.NoError(t, err)
+		assert.Equal(t, want, string(data), name)
+	}
+
+	_, _, err = runMergeArgs("-s", "recursive", "topic", "other")
+	assert.ErrorContains(t, err, "the recursive strategy merges a single branch")
+}
+
+func TestMergeStrategyOrt(t *testing.T) {
+	setupMergeRepo(t,
+		map[string]string{"a.txt": "base\n", "b.txt": "base\n"},
+		map[string]string{"a.txt": "ours\n", "b.txt": "base\n"},
+		map[string]string{"a.txt": "base\n", "b.txt": "theirs\n"},
+	)
+
+	stdout, _, err := runMergeArgs("-s", "ort", "topic")
+	require.NoError(t, err)
+	assert.Contains(t, stdout, "Merge made by the 'ort' strategy.")
+}
+
+func TestMergeMsg(t *testing.T) {
+	repo := setupMergeRepo(t,
+		map[string]string{"a.txt": "base\n", "b.txt": "b\n"},
+		map[string]string{"a.txt": "ours\n", "b.txt": "b\n"},
+		map[string]string{"a.txt": "theirs\n", "b.txt": "b\n"},
+	)
+	require.NoError(t, resetIndexToHead(repo))
+
+	_, _, err := runMergeArgs("topic")
+	require.ErrorIs(t, err, errMergeConflict)
+	data, err := os.ReadFile(filepath.Join(repo.GitDir(), "MERGE_MSG"))
+	require.NoError(t, err)
+	assert.Equal(t, "Merge branch 'topic'\n\n# Conflicts:\n#\ta.txt\n", string(data))
+	data, err = os.ReadFile(filepath.Join(repo.GitDir(), "MERGE_MODE"))
+	require.NoError(t, err)
+	assert.Empty(t, data)
+
+	// Without -m the commit concluding the merge takes MERGE_MSG
+	stageFile(t, "a.txt", "resolved\n")
+	_, err = runCommandArgs(newCommitCommand(), []string{}...)
+	require.NoError(t, err)
+	headID, err := objects.NewObjectID(headCommit(t, repo))
+	require.NoError(t, err)
+	head, err := repo.GetCommit(headID)
+	require.NoError(t, err)
+	assert.Equal(t, "Merge branch 'topic'\n", head.Message())
+	assert.Len(t, head.Parents(), 2)
+	for _, name := range []string{"MERGE_HEAD", "MERGE_MSG", "MERGE_MODE"} {
+		assert.NoFileExists(t, filepath.Join(repo.GitDir(), name))
+	}
+}
+
+func TestMergeMsgNoFastForward(t *testing.T) {
+	repo := setupMergeRepo(t,
+		map[string]string{"a.txt": "base\n"},
+		map[string]string{"a.txt": "base\n"},
+		map[string]string{"a.txt": "base\n", "t.txt": "t\n"},
+	)
+	require.NoError(t, resetIndexToHead(repo))
+
+	_, _, err := runMergeArgs("--no-commit", "--ff=no", "-m", "custom", "topic")
+	require.NoError(t, err)
+	data, err := os.ReadFile(filepath.Join(repo.GitDir(), "MERGE_MSG"))
+	require.NoError(t, err)
+	assert.Equal(t, "custom\n", string(data))
+	data, err = os.ReadFile(filepath.Join(repo.GitDir(), "MERGE_MODE"))
+	require.NoError(t, err)
+	assert.Equal(t, "no-ff", string(data))
+}
+
+func TestMergeRefusesToOverwriteLocalChanges(t *testing.T) {
+	repo := setupMergeRepo(t,
+		map[string]string{"f.txt": "base\n", "g.txt": "g\n"},
+		map[string]string{"f.txt": "base\n", "g.txt": "ours\n"},
+		map[string]string{"f.txt": "theirs\n", "g.txt": "g\n", "n.txt": "new\n"},
+	)
+	require.NoError(t, resetIndexToHead(repo))
+	head := headCommit(t, repo)
+
+	require.NoError(t, os.WriteFile("f.txt", []byte("local\n"), 0644))
+	_, _, err := runMergeArgs("topic")
+	assert.ErrorContains(t, err, "your local changes to the following files would be overwritten by merge:\n\tf.txt\n")
+	assert.Equal(t, "local\n", readWorkFile(t, "f.txt"))
+	assert.Equal(t, head, headCommit(t, repo))
+	require.NoError(t, os.WriteFile("f.txt", []byte("base\n"), 0644))
+
+	require.NoError(t, os.WriteFile("n.txt", []byte("untracked\n"), 0644))
+	_, _, err = runMergeArgs("topic")
+	assert.ErrorContains(t, err, "the following untracked working tree files would be overwritten by merge:\n\tn.txt")
+	assert.Equal(t, "untracked\n", readWorkFile(t, "n.txt"))
+	require.NoError(t, os.Remove("n.txt"))
+
+	// Staged changes are lost to the index of the merge wherever they are
+	stageFile(t, "g.txt", "staged\n")
+	_, _, err = runMergeArgs("topic")
+	assert.ErrorContains(t, err, "your local changes to the following files would be overwritten by merge:\n\tg.txt\n")
+	assert.Equal(t, "staged\n", stagedContent(t, repo, "g.txt"))
+	assert.Equal(t, head, headCommit(t, repo))
+
+	// Local changes the merge does not touch are kept
+	require.NoError(t, resetIndexToHead(repo))
+	_, _, err = runMergeArgs("topic")
+	require.NoError(t, err)
+	assert.Equal(t, "theirs\n", readWorkFile(t, "f.txt"))
+	assert.Equal(t, "staged\n", readWorkFile(t, "g.txt"))
+}
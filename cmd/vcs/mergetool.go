@@ -0,0 +1,285 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/config"
+	"github.com/fenilsonani/vcs/internal/core/convert"
+	"github.com/fenilsonani/vcs/internal/core/index"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// Commands of the merge tools known without configuration, run by sh with
+// BASE, LOCAL, REMOTE and MERGED set
+var builtinMergeTools = map[string]string{
+	"vimdiff":  `vim -d -c "wincmd J" "$MERGED" "$LOCAL" "$BASE" "$REMOTE"`,
+	"nvimdiff": `nvim -d -c "wincmd J" "$MERGED" "$LOCAL" "$BASE" "$REMOTE"`,
+	"meld":     `meld "$LOCAL" "$BASE" "$REMOTE" --output "$MERGED"`,
+	"kdiff3":   `kdiff3 --auto "$BASE" "$LOCAL" "$REMOTE" -o "$MERGED"`,
+	"opendiff": `opendiff "$LOCAL" "$REMOTE" -ancestor "$BASE" -merge "$MERGED"`,
+	"vscode":   `code --wait --merge "$REMOTE" "$LOCAL" "$BASE" "$MERGED"`,
+}
+
+// mergeTool is an external program resolving one conflicted file
+type mergeTool struct {
+	name    string
+	command string
+	// trustExitCode makes the exit status alone decide whether the file
+	// was resolved; otherwise the file must also have changed
+	trustExitCode bool
+}
+
+func newMergetoolCommand() *cobra.Command {
+	var (
+		toolName string
+		noPrompt bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "mergetool [flags] [<path>...]",
+		Short: "Run merge conflict resolution tools to resolve merge conflicts",
+		Long: `Runs a merge tool on each conflicted file, or on the given ones, and
+stages the file once the tool resolved it.
+
+The tool is named with --tool or merge.tool, and its command is
+mergetool.<tool>.cmd, run by the shell with $BASE, $LOCAL and $REMOTE
+naming temporary files holding the common ancestor and the two sides,
+and $MERGED the file in the working tree. vimdiff, nvimdiff, meld,
+kdiff3, opendiff and vscode are known without a command. The file counts
+as resolved when the tool exits with status 0 and, unless
+mergetool.<tool>.trustExitCode is set, changed the file.
+
+The conflicted file is kept as <path>.orig unless mergetool.keepBackup is
+false. Before each file mergetool asks whether to run the tool, unless
+--no-prompt is given or mergetool.prompt is false.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repoPath, err := findRepository()
+			if err != nil {
+				return fmt.Errorf("not a git repository: %w", err)
+			}
+			repo, err := openRepository(repoPath)
+			if err != nil {
+				return err
+			}
+
+			tool, err := findMergeTool(repo.GitDir(), toolName)
+			if err != nil {
+				return err
+			}
+			prompt := !noPrompt && mergetoolSetting(repo.GitDir(), "mergetool.prompt", true)
+			return runMergetool(cmd.InOrStdin(), cmd.OutOrStdout(), repo, tool, args, prompt)
+		},
+	}
+
+	cmd.Flags().StringVarP(&toolName, "tool", "t", "", "Use the merge resolution program specified by <tool>")
+	cmd.Flags().BoolVarP(&noPrompt, "no-prompt", "y", false, "Do not prompt before launching the merge tool")
+
+	return cmd
+}
+
+// findMergeTool returns the tool named, or the one merge.tool names
+func findMergeTool(gitDir, name string) (*mergeTool, error) {
+	cfg := loadConfig(gitDir)
+	if name == "" {
+		name, _ = cfg.Get("merge.tool")
+	}
+	if name == "" {
+		return nil, fmt.Errorf("no merge tool configured; set merge.tool or use --tool")
+	}
+
+	tool := &mergeTool{name: name}
+	if command, ok := cfg.Get("mergetool." + name + ".cmd"); ok && command != "" {
+		tool.command = command
+	} else if command, ok := builtinMergeTools[name]; ok {
+		tool.command = command
+	} else {
+		return nil, fmt.Errorf("unknown merge tool %s; set mergetool.%s.cmd", name, name)
+	}
+	tool.trustExitCode = mergetoolSetting(gitDir, "mergetool."+name+".trustExitCode", false)
+	return tool, nil
+}
+
+// mergetoolSetting reads a boolean setting, def when it is unset or invalid
+func mergetoolSetting(gitDir, key string, def bool) bool {
+	value, ok := loadConfig(gitDir).Get(key)
+	if !ok {
+		return def
+	}
+	b, err := config.ParseBool(value)
+	if err != nil {
+		return def
+	}
+	return b
+}
+
+// runMergetool runs tool on each conflicted path of the index, limited to
+// paths when given, staging the ones it resolves
+func runMergetool(in io.Reader, out io.Writer, repo *vcs.Repository, tool *mergeTool, paths []string, prompt bool) error {
+	indexPath := filepath.Join(repo.GitDir(), "index")
+	idx := index.New()
+	if err := idx.ReadFromFile(indexPath); err != nil {
+		return fmt.Errorf("failed to read index: %w", err)
+	}
+
+	unmerged := idx.Unmerged()
+	if len(paths) > 0 {
+		wanted := make(map[string]bool)
+		for _, arg := range paths {
+			path, err := pathspecPath(repo.WorkDir(), arg)
+			if err != nil {
+				return err
+			}
+			wanted[path] = true
+		}
+		var selected []string
+		for _, path := range unmerged {
+			if wanted[path] {
+				selected = append(selected, path)
+			}
+		}
+		unmerged = selected
+	}
+	if len(unmerged) == 0 {
+		fmt.Fprintln(out, "No files need merging")
+		return nil
+	}
+
+	fmt.Fprintf(out, "Merging:\n%s\n\n", strings.Join(unmerged, "\n"))
+
+	stages := make(map[string][3]*index.Entry)
+	for _, e := range idx.Entries() {
+		if s := e.Stage(); s > 0 {
+			entries := stages[e.Path]
+			entries[s-1] = e
+			stages[e.Path] = entries
+		}
+	}
+
+	conv := newConverter(repo, out, nil)
+	keepBackup := mergetoolSetting(repo.GitDir(), "mergetool.keepBackup", true)
+	input := bufio.NewReader(in)
+	var failed []string
+	for _, path := range unmerged {
+		entries := stages[path]
+		if entries[1] == nil || entries[2] == nil {
+			// Deleted on one side; there is nothing for the tool to merge
+			fmt.Fprintf(out, "Deleted merge conflict for '%s': resolve it with vcs add or vcs rm\n", path)
+			failed = append(failed, path)
+			continue
+		}
+
+		fmt.Fprintf(out, "Normal merge conflict for '%s':\n", path)
+		if prompt {
+			fmt.Fprintf(out, "Hit return to start merge resolution tool (%s): ", tool.name)
+			answer, _ := input.ReadString('\n')
+			if strings.TrimSpace(answer) == "n" {
+				failed = append(failed, path)
+				continue
+			}
+		}
+
+		resolved, err := mergeFile(repo, conv, tool, path, entries, keepBackup)
+		if err != nil {
+			return err
+		}
+		if !resolved {
+			fmt.Fprintf(out, "merge of %s failed\n", path)
+			failed = append(failed, path)
+			continue
+		}
+		if err := stageWorkingFile(repo, conv, idx, path, entries[1].Mode); err != nil {
+			return err
+		}
+		if err := idx.WriteToFile(indexPath); err != nil {
+			return fmt.Errorf("failed to write index: %w", err)
+		}
+	}
+
+	if err := recordRerereResolutions(out, repo); err != nil {
+		return err
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("merge conflicts remain in: %s", strings.Join(failed, ", "))
+	}
+	return nil
+}
+
+// mergeFile runs tool on the conflicted file at path, with the stages of
+// the index in temporary files beside it, and reports whether the tool
+// resolved it
+func mergeFile(repo *vcs.Repository, conv *convert.Converter, tool *mergeTool, path string, stages [3]*index.Entry, keepBackup bool) (bool, error) {
+	merged := filepath.Join(repo.WorkDir(), path)
+	before, err := os.ReadFile(merged)
+	if err != nil && !os.IsNotExist(err) {
+		return false, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+
+	ext := filepath.Ext(merged)
+	stem := strings.TrimSuffix(merged, ext)
+	files := make(map[string]string)
+	for i, name := range []string{"BASE", "LOCAL", "REMOTE"} {
+		file := fmt.Sprintf("%s_%s_%d%s", stem, name, os.Getpid(), ext)
+		files[name] = file
+		defer os.Remove(file)
+
+		var data []byte
+		if stages[i] != nil {
+			if data, err = readStage(repo, conv, path, stages[i].ID); err != nil {
+				return false, err
+			}
+		}
+		if err := os.WriteFile(file, data, 0644); err != nil {
+			return false, fmt.Errorf("failed to write %s: %w", file, err)
+		}
+	}
+
+	cmd := exec.Command("sh", "-c", tool.command)
+	cmd.Dir = repo.WorkDir()
+	cmd.Env = append(os.Environ(),
+		"BASE="+files["BASE"],
+		"LOCAL="+files["LOCAL"],
+		"REMOTE="+files["REMOTE"],
+		"MERGED="+merged,
+	)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		if _, ok := err.(*exec.ExitError); !ok {
+			return false, fmt.Errorf("failed to run merge tool %s: %w", tool.name, err)
+		}
+		return false, nil
+	}
+	if !tool.trustExitCode {
+		after, err := os.ReadFile(merged)
+		if err != nil || bytes.Equal(before, after) {
+			return false, nil
+		}
+	}
+
+	if keepBackup {
+		if err := os.WriteFile(merged+".orig", before, 0644); err != nil {
+			return false, fmt.Errorf("failed to write %s.orig: %w", path, err)
+		}
+	}
+	return true, nil
+}
+
+// readStage returns the content of blob id as checked out at path
+func readStage(repo *vcs.Repository, conv *convert.Converter, path string, id objects.ObjectID) ([]byte, error) {
+	blob, err := repo.GetBlob(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	return conv.Smudge(path, blob.Data())
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/index"
+)
+
+func runMergetoolArgs(args ...string) (string, error) {
+	cmd := newMergetoolCommand()
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+	cmd.SetErr(&out)
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+	return out.String(), err
+}
+
+func TestMergetoolResolvesConflicts(t *testing.T) {
+	repo := setupMergeRepo(t,
+		map[string]string{"a.txt": "base\n"},
+		map[string]string{"a.txt": "ours\n"},
+		map[string]string{"a.txt": "theirs\n"},
+	)
+	_, _, err := runMergeArgs("topic")
+	require.ErrorIs(t, err, errMergeConflict)
+
+	// The tool joins the two sides, checking it was given the base
+	_, err = runConfigArgs("mergetool.join.cmd", `grep -q base "$BASE" && cat "$LOCAL" "$REMOTE" > "$MERGED"`)
+	require.NoError(t, err)
+
+	out, err := runMergetoolArgs("--tool", "join", "--no-prompt")
+	require.NoError(t, err)
+	assert.Contains(t, out, "Normal merge conflict for 'a.txt'")
+
+	content, err := os.ReadFile(filepath.Join(repo.WorkDir(), "a.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, "ours\ntheirs\n", string(content))
+	assert.FileExists(t, filepath.Join(repo.WorkDir(), "a.txt.orig"))
+
+	matches, _ := filepath.Glob(filepath.Join(repo.WorkDir(), "a_*"))
+	assert.Empty(t, matches, "temporary files are removed")
+
+	idx := index.New()
+	require.NoError(t, idx.ReadFromFile(filepath.Join(repo.GitDir(), "index")))
+	assert.Empty(t, idx.Unmerged())
+
+	out, err = runMergetoolArgs("--tool", "join")
+	require.NoError(t, err)
+	assert.Contains(t, out, "No files need merging")
+}
+
+func TestMergetoolUnchangedFileFails(t *testing.T) {
+	setupMergeRepo(t,
+		map[string]string{"a.txt": "base\n"},
+		map[string]string{"a.txt": "ours\n"},
+		map[string]string{"a.txt": "theirs\n"},
+	)
+	_, _, err := runMergeArgs("topic")
+	require.ErrorIs(t, err, errMergeConflict)
+
+	_, err = runConfigArgs("mergetool.noop.cmd", "true")
+	require.NoError(t, err)
+	out, err := runMergetoolArgs("-t", "noop", "-y")
+	assert.Error(t, err)
+	assert.Contains(t, out, "merge of a.txt failed")
+
+	_, err = runConfigArgs("mergetool.noop.trustExitCode", "true")
+	require.NoError(t, err)
+	_, err = runMergetoolArgs("-t", "noop", "-y")
+	assert.NoError(t, err)
+}
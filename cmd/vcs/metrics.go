@@ -0,0 +1,334 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sort"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/internal/metrics"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+func newMetricsCommand() *cobra.Command {
+	var listen, output string
+
+	cmd := &cobra.Command{
+		Use:   "metrics [flags] [<repository>...]",
+		Short: "Export repository metrics for monitoring",
+		Long: `Reports the health of repositories in the Prometheus text format: loose
+and packed object counts, pack sizes, refs, and the duration and result of
+the latest fetches and maintenance tasks. Each sample is labeled with the
+repository's path, so one exporter can cover many repositories.
+
+Without a repository, reports on the current one. With --listen, serves the
+metrics over HTTP at /metrics, collecting them anew on each scrape, until
+interrupted. With --output, writes them to a file, replaced atomically, for
+the node exporter's textfile collector. Otherwise prints them.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if listen != "" && output != "" {
+				return fmt.Errorf("--listen and --output cannot be used together")
+			}
+			repos, err := openMetricsRepositories(args)
+			if err != nil {
+				return err
+			}
+
+			switch {
+			case listen != "":
+				return serveMetrics(cmd, listen, repos)
+			case output != "":
+				return writeMetricsFile(output, repos)
+			}
+			_, err = collectMetrics(repos).WriteTo(cmd.OutOrStdout())
+			return err
+		},
+	}
+
+	cmd.Flags().StringVar(&listen, "listen", "", "Serve metrics over HTTP on this address, such as :9100")
+	cmd.Flags().StringVarP(&output, "output", "o", "", "Write metrics to this file")
+	return cmd
+}
+
+// openMetricsRepositories opens the repositories at paths, or the current
+// one when there are none
+func openMetricsRepositories(paths []string) ([]*vcs.Repository, error) {
+	if len(paths) == 0 {
+		repoPath, err := findRepository()
+		if err != nil {
+			return nil, fmt.Errorf("not a git repository: %w", err)
+		}
+		repo, err := openRepository(repoPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open repository: %w", err)
+		}
+		return []*vcs.Repository{repo}, nil
+	}
+
+	repos := make([]*vcs.Repository, 0, len(paths))
+	for _, path := range paths {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			return nil, err
+		}
+		repo, err := vcs.Open(abs)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open repository %s: %w", path, err)
+		}
+		repos = append(repos, repo)
+	}
+	return repos, nil
+}
+
+// serveMetrics serves the metrics of repos at /metrics on addr until
+// interrupted
+func serveMetrics(cmd *cobra.Command, addr string, repos []*vcs.Repository) error {
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", metrics.ContentType)
+		collectMetrics(repos).WriteTo(w)
+	})
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	fmt.Fprintf(cmd.OutOrStdout(), "Serving metrics at http://%s/metrics\n", listener.Addr())
+	return serveShare(ctx, listener, mux)
+}
+
+// writeMetricsFile writes the metrics of repos to path. Collectors reading
+// the file never see it half written.
+func writeMetricsFile(path string, repos []*vcs.Repository) error {
+	var buf bytes.Buffer
+	if _, err := collectMetrics(repos).WriteTo(&buf); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-")
+	if err != nil {
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = buf.WriteTo(tmp)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0644)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write metrics: %w", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// collectMetrics gathers the metrics of repos. A repository that cannot be
+// read is reported down rather than failing the whole collection.
+func collectMetrics(repos []*vcs.Repository) *metrics.Set {
+	set := metrics.NewSet()
+	for _, repo := range repos {
+		label := repo.Path()
+		err := collectRepositoryMetrics(set, repo, label)
+		up := 1.0
+		if err != nil {
+			up = 0
+		}
+		set.Add("vcs_repository_up", metrics.Gauge, "Whether the repository's metrics could be collected", up, "repo", label)
+	}
+	return set
+}
+
+func collectRepositoryMetrics(set *metrics.Set, repo *vcs.Repository, label string) error {
+	objectsDir := filepath.Join(repo.CommonDir(), "objects")
+	loose, looseBytes, err := looseObjectStats(objectsDir)
+	if err != nil {
+		return err
+	}
+	set.Add("vcs_loose_objects", metrics.Gauge, "Number of loose objects", float64(loose), "repo", label)
+	set.Add("vcs_loose_objects_bytes", metrics.Gauge, "Size of the loose objects on disk", float64(looseBytes), "repo", label)
+
+	packs, packBytes, packed, err := packStats(repo.Storage().PackDir())
+	if err != nil {
+		return err
+	}
+	set.Add("vcs_packs", metrics.Gauge, "Number of packfiles", float64(packs), "repo", label)
+	set.Add("vcs_packs_bytes", metrics.Gauge, "Size of the packfiles on disk", float64(packBytes), "repo", label)
+	set.Add("vcs_packed_objects", metrics.Gauge, "Number of objects in packfiles", float64(packed), "repo", label)
+
+	allRefs, err := refs.NewRefManager(repo.GitDir()).AllRefs()
+	if err != nil {
+		return fmt.Errorf("failed to list refs: %w", err)
+	}
+	counts := map[string]int{"branch": 0, "tag": 0, "remote": 0, "other": 0}
+	for name := range allRefs {
+		counts[refKind(name)]++
+	}
+	for _, kind := range []string{"branch", "tag", "remote", "other"} {
+		set.Add("vcs_refs", metrics.Gauge, "Number of refs by kind", float64(counts[kind]), "repo", label, "kind", kind)
+	}
+
+	journal, err := metrics.ReadJournal(repo.CommonDir())
+	if err != nil {
+		return err
+	}
+	addRunMetrics(set, journal, metrics.KindFetch, "remote", label)
+	addRunMetrics(set, journal, metrics.KindMaintenance, "task", label)
+	return nil
+}
+
+// addRunMetrics adds the journal's runs of kind, as vcs_<kind>_* metrics
+// with each run's name under nameLabel
+func addRunMetrics(set *metrics.Set, journal *metrics.Journal, kind, nameLabel, repoLabel string) {
+	runs := journal.Operations[kind]
+	names := make([]string, 0, len(runs))
+	for name := range runs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	prefix := "vcs_" + kind + "_"
+	for _, name := range names {
+		run := runs[name]
+		labels := []string{"repo", repoLabel, nameLabel, name}
+		success := 0.0
+		if run.LastSuccess {
+			success = 1
+		}
+		set.Add(prefix+"last_run_timestamp_seconds", metrics.Gauge, "When the latest "+kind+" started, in seconds since the epoch", unixSeconds(run.LastStart), labels...)
+		set.Add(prefix+"last_duration_seconds", metrics.Gauge, "How long the latest "+kind+" took", run.LastDuration.Seconds(), labels...)
+		set.Add(prefix+"last_success", metrics.Gauge, "Whether the latest "+kind+" succeeded", success, labels...)
+		if !run.LastSuccessTime.IsZero() {
+			set.Add(prefix+"last_success_timestamp_seconds", metrics.Gauge, "When the latest successful "+kind+" ended, in seconds since the epoch", unixSeconds(run.LastSuccessTime), labels...)
+		}
+		set.Add(prefix+"runs_total", metrics.Counter, "Number of "+kind+" runs", float64(run.Runs), labels...)
+		set.Add(prefix+"failures_total", metrics.Counter, "Number of failed "+kind+" runs", float64(run.Failures), labels...)
+	}
+}
+
+func unixSeconds(t time.Time) float64 {
+	return float64(t.UnixNano()) / 1e9
+}
+
+// refKind classifies a ref for the vcs_refs metric
+func refKind(name string) string {
+	switch {
+	case strings.HasPrefix(name, "refs/heads/"):
+		return "branch"
+	case strings.HasPrefix(name, "refs/tags/"):
+		return "tag"
+	case strings.HasPrefix(name, "refs/remotes/"):
+		return "remote"
+	}
+	return "other"
+}
+
+// looseObjectStats counts the loose objects in objectsDir and their size
+func looseObjectStats(objectsDir string) (count, size int64, err error) {
+	dirs, err := os.ReadDir(objectsDir)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, 0, fmt.Errorf("failed to count loose objects: %w", err)
+	}
+	for _, dir := range dirs {
+		if !dir.IsDir() || len(dir.Name()) != 2 || !isHex(dir.Name()) {
+			continue
+		}
+		entries, err := os.ReadDir(filepath.Join(objectsDir, dir.Name()))
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to count loose objects: %w", err)
+		}
+		for _, entry := range entries {
+			if len(entry.Name()) != 38 || entry.IsDir() {
+				continue
+			}
+			info, err := entry.Info()
+			if err != nil {
+				continue // pruned meanwhile
+			}
+			count++
+			size += info.Size()
+		}
+	}
+	return count, size, nil
+}
+
+// packStats counts the packfiles in packDir, their size and the objects
+// their indexes list
+func packStats(packDir string) (packs, size, objectCount int64, err error) {
+	paths, err := filepath.Glob(filepath.Join(packDir, "*.pack"))
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue // repacked meanwhile
+		}
+		n, err := packIndexCount(strings.TrimSuffix(path, ".pack") + ".idx")
+		if err != nil {
+			return 0, 0, 0, err
+		}
+		packs++
+		size += info.Size()
+		objectCount += n
+	}
+	return packs, size, objectCount, nil
+}
+
+// packIndexCount returns the number of objects a pack index lists: the last
+// entry of its fan-out table, which follows the header in version 2
+func packIndexCount(idxPath string) (int64, error) {
+	file, err := os.Open(idxPath)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read pack index: %w", err)
+	}
+	defer file.Close()
+
+	header := make([]byte, 8)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return 0, fmt.Errorf("failed to read pack index %s: %w", filepath.Base(idxPath), err)
+	}
+	offset := int64(255 * 4)
+	if bytes.Equal(header[:4], []byte{0xff, 't', 'O', 'c'}) {
+		offset += 8
+	}
+	last := make([]byte, 4)
+	if _, err := file.ReadAt(last, offset); err != nil {
+		return 0, fmt.Errorf("failed to read pack index %s: %w", filepath.Base(idxPath), err)
+	}
+	return int64(binary.BigEndian.Uint32(last)), nil
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return false
+		}
+	}
+	return true
+}
+
+// recordRun notes in the metrics journal a run of the operation kind/name
+// that started at start. Metrics are advisory, so failing to record is only
+// a warning.
+func recordRun(errOut io.Writer, repo *vcs.Repository, kind, name string, start time.Time, err error) {
+	if rerr := metrics.Record(repo.CommonDir(), kind, name, start, err != nil); rerr != nil {
+		fmt.Fprintf(errOut, "warning: %v\n", rerr)
+	}
+}
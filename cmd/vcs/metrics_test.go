@@ -0,0 +1,70 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/config"
+)
+
+func TestMetricsCommand(t *testing.T) {
+	repo, _ := setupGCRepo(t)
+	_, err := runGCArgs("-q")
+	require.NoError(t, err)
+	writeAgedBlob(t, repo, "loose\n", 0)
+
+	out, err := runCommandArgs(newMetricsCommand())
+	require.NoError(t, err)
+
+	label := `{repo="` + repo.Path() + `"`
+	assert.Contains(t, out, "# TYPE vcs_loose_objects gauge\n")
+	assert.Contains(t, out, "vcs_loose_objects"+label+"} 1\n")
+	assert.Contains(t, out, "vcs_packs"+label+"} 1\n")
+	assert.Contains(t, out, "vcs_packed_objects"+label+"} 9\n")
+	assert.Contains(t, out, "vcs_refs"+label+`,kind="branch"} 1`+"\n")
+	assert.Contains(t, out, "vcs_maintenance_last_success"+label+`,task="gc"} 1`+"\n")
+	assert.Contains(t, out, "vcs_maintenance_runs_total"+label+`,task="gc"} 1`+"\n")
+	assert.Contains(t, out, "vcs_repository_up"+label+"} 1\n")
+}
+
+func TestMetricsRecordsFailedFetch(t *testing.T) {
+	repo, _ := setupConfigRepo(t)
+	cfg, err := config.ReadFile(config.Path(config.ScopeLocal, repo.GitDir()))
+	require.NoError(t, err)
+	cfg.Set("remote.origin.url", "http://127.0.0.1:1/missing.git")
+	require.NoError(t, cfg.Save())
+
+	_, err = runCommandArgs(newFetchCommand(), "--limit-rate", "fast", "origin")
+	require.Error(t, err)
+
+	out, err := runCommandArgs(newMetricsCommand())
+	require.NoError(t, err)
+	label := `{repo="` + repo.Path() + `",remote="origin"}`
+	assert.Contains(t, out, "vcs_fetch_last_success"+label+" 0\n")
+	assert.Contains(t, out, "vcs_fetch_failures_total"+label+" 1\n")
+}
+
+func TestMetricsOutputFile(t *testing.T) {
+	repo, _ := setupConfigRepo(t)
+	other := t.TempDir()
+	_, err := runCommandArgs(newInitCommand(), other)
+	require.NoError(t, err)
+
+	path := filepath.Join(t.TempDir(), "vcs.prom")
+	_, err = runCommandArgs(newMetricsCommand(), "-o", path, repo.Path(), other)
+	require.NoError(t, err)
+
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), `vcs_repository_up{repo="`+repo.Path()+`"} 1`)
+	assert.Contains(t, string(data), `vcs_repository_up{repo="`+other+`"} 1`)
+
+	_, err = runCommandArgs(newMetricsCommand(), "--listen", ":0", "-o", path)
+	assert.Error(t, err)
+	_, err = runCommandArgs(newMetricsCommand(), filepath.Join(other, "missing"))
+	assert.Error(t, err)
+}
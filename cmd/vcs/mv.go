@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/index"
+)
+
+type mvOptions struct {
+	force     bool
+	dryRun    bool
+	skipError bool
+	verbose   bool
+}
+
+func newMvCommand() *cobra.Command {
+	var opts mvOptions
+
+	cmd := &cobra.Command{
+		Use:   "mv [flags] <source>... <destination>",
+		Short: "Move or rename a file or a directory",
+		Long: `Renames source to destination, or moves each source into destination
+when that is an existing directory, in the working tree and in the
+index. The rename is staged, so status shows it as one and the next
+commit records it without the files having to be removed and added
+again. A directory is moved with everything tracked under it.
+
+An existing destination file is only overwritten with -f. With -k
+sources that cannot be moved are skipped instead of failing the whole
+move.`,
+		Args: cobra.MinimumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			return runMv(cmd, args, opts)
+		},
+	}
+
+	cmd.Flags().BoolVarP(&opts.force, "force", "f", false, "Overwrite existing destination files")
+	cmd.Flags().BoolVarP(&opts.dryRun, "dry-run", "n", false, "Only show what would be moved")
+	cmd.Flags().BoolVarP(&opts.skipError, "skip-errors", "k", false, "Skip sources that cannot be moved")
+	cmd.Flags().BoolVarP(&opts.verbose, "verbose", "v", false, "Report the names of moved files")
+
+	return cmd
+}
+
+// mvMove is one source of mv and where it goes, with the tracked files it
+// holds
+type mvMove struct {
+	src, dst string
+	files    []string
+}
+
+func runMv(cmd *cobra.Command, args []string, opts mvOptions) error {
+	repoPath, err := findRepository()
+	if err != nil {
+		return fmt.Errorf("not a git repository: %w", err)
+	}
+	repo, err := openRepository(repoPath)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	idx, err := readIndex(repo)
+	if err != nil {
+		return err
+	}
+	indexPath := filepath.Join(repo.GitDir(), "index")
+	tracked := trackedPaths(idx)
+
+	sources, target := args[:len(args)-1], args[len(args)-1]
+	dest, err := pathspecPath(repoPath, target)
+	if err != nil {
+		return err
+	}
+	destPath := filepath.Join(repoPath, filepath.FromSlash(dest))
+	intoDir := false
+	if info, err := os.Stat(destPath); err == nil && info.IsDir() {
+		intoDir = true
+	} else if len(sources) > 1 {
+		return fmt.Errorf("destination '%s' is not a directory", target)
+	}
+
+	// Every source is checked before anything is moved
+	var moves []mvMove
+	for _, arg := range sources {
+		src, err := pathspecPath(repoPath, arg)
+		if err != nil {
+			return err
+		}
+		dst := dest
+		if intoDir {
+			dst = path.Join(dest, path.Base(src))
+		}
+
+		move, err := planMove(repoPath, tracked, src, dst, opts.force)
+		if err != nil {
+			err = fmt.Errorf("%w, source=%s, destination=%s", err, src, dst)
+			if opts.skipError {
+				continue
+			}
+			return err
+		}
+		moves = append(moves, move)
+	}
+
+	out := cmd.OutOrStdout()
+	for _, move := range moves {
+		if opts.verbose || opts.dryRun {
+			fmt.Fprintf(out, "Renaming %s to %s\n", move.src, move.dst)
+		}
+	}
+	if opts.dryRun {
+		return nil
+	}
+
+	// Moves done so far are undone when one fails, leaving the working
+	// tree as it was
+	var done []mvMove
+	undo := func() {
+		for i := len(done) - 1; i >= 0; i-- {
+			os.Rename(filepath.Join(repoPath, filepath.FromSlash(done[i].dst)), filepath.Join(repoPath, filepath.FromSlash(done[i].src)))
+		}
+	}
+	for _, move := range moves {
+		from := filepath.Join(repoPath, filepath.FromSlash(move.src))
+		to := filepath.Join(repoPath, filepath.FromSlash(move.dst))
+		err := os.MkdirAll(filepath.Dir(to), 0755)
+		if err == nil {
+			err = os.Rename(from, to)
+		}
+		if err != nil {
+			undo()
+			return fmt.Errorf("failed to move %s to %s: %w", move.src, move.dst, err)
+		}
+		done = append(done, move)
+	}
+
+	for _, move := range moves {
+		for _, file := range move.files {
+			mode, id, _ := trackedFile(idx, file)
+			to := move.dst + strings.TrimPrefix(file, move.src)
+			idx.Remove(file)
+			if err := idx.Add(&index.Entry{Mode: mode, ID: id, Path: to}); err != nil {
+				undo()
+				return fmt.Errorf("failed to add %s to index: %w", to, err)
+			}
+		}
+	}
+	if err := idx.WriteToFile(indexPath); err != nil {
+		undo()
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+	return nil
+}
+
+// planMove checks that src can be moved to dst and returns the move, with
+// the tracked files under src when it is a directory
+func planMove(repoPath string, tracked []string, src, dst string, force bool) (mvMove, error) {
+	move := mvMove{src: src, dst: dst}
+	if src == "" {
+		return move, fmt.Errorf("cannot move the repository root")
+	}
+	srcInfo, err := os.Lstat(filepath.Join(repoPath, filepath.FromSlash(src)))
+	if err != nil {
+		return move, fmt.Errorf("bad source")
+	}
+	if dst == src || strings.HasPrefix(dst, src+"/") {
+		return move, fmt.Errorf("can not move directory into itself")
+	}
+
+	move.files = matchTracked(tracked, src)
+	if len(move.files) == 0 {
+		return move, fmt.Errorf("not under version control")
+	}
+	if srcInfo.IsDir() != (move.files[0] != src) {
+		return move, fmt.Errorf("not under version control")
+	}
+
+	dstInfo, err := os.Lstat(filepath.Join(repoPath, filepath.FromSlash(dst)))
+	if err == nil {
+		if srcInfo.IsDir() || dstInfo.IsDir() || !force {
+			return move, fmt.Errorf("destination exists")
+		}
+	}
+	return move, nil
+}
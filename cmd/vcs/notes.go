@@ -0,0 +1,451 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/notes"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// notesMessageOptions holds the flags giving the text of a note
+type notesMessageOptions struct {
+	messages []string
+	file     string
+}
+
+func newNotesCommand() *cobra.Command {
+	var ref string
+
+	cmd := &cobra.Command{
+		Use:   "notes [--ref <notes-ref>] [list | add | append | show | remove]",
+		Short: "Add or inspect object notes",
+		Long: `Notes attach text to commits and other objects without changing them, so
+CI results or review metadata can be recorded after the fact. They are
+kept in a notes ref, refs/notes/commits unless --ref, GIT_NOTES_REF or
+core.notesRef names another, and shown by "vcs log" under the message.
+
+Without a subcommand the notes are listed, as the note blob followed by
+the object it annotates.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runNotes(cmd, ref, func(s *notesSession) error {
+				return s.list(cmd.OutOrStdout(), "")
+			})
+		},
+	}
+	cmd.PersistentFlags().StringVar(&ref, "ref", "", "Use the notes ref given instead of refs/notes/commits")
+
+	list := &cobra.Command{
+		Use:   "list [<object>]",
+		Short: "List the notes, or the note blob of an object",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runNotes(cmd, ref, func(s *notesSession) error {
+				object := ""
+				if len(args) > 0 {
+					object = args[0]
+				}
+				return s.list(cmd.OutOrStdout(), object)
+			})
+		},
+	}
+
+	var addOpts notesMessageOptions
+	var force bool
+	add := &cobra.Command{
+		Use:   "add [-f] [-m <msg> | -F <file>] [<object>]",
+		Short: "Add a note to an object, HEAD by default",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runNotes(cmd, ref, func(s *notesSession) error {
+				return s.add(cmd, objectArg(args), addOpts, force)
+			})
+		},
+	}
+	add.Flags().BoolVarP(&force, "force", "f", false, "Replace the note the object already has")
+	addNotesMessageFlags(add, &addOpts)
+
+	var appendOpts notesMessageOptions
+	appendCmd := &cobra.Command{
+		Use:   "append [-m <msg> | -F <file>] [<object>]",
+		Short: "Append to the note of an object, HEAD by default",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runNotes(cmd, ref, func(s *notesSession) error {
+				return s.append(cmd, objectArg(args), appendOpts)
+			})
+		},
+	}
+	addNotesMessageFlags(appendCmd, &appendOpts)
+
+	show := &cobra.Command{
+		Use:   "show [<object>]",
+		Short: "Show the note of an object, HEAD by default",
+		Args:  cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runNotes(cmd, ref, func(s *notesSession) error {
+				return s.show(cmd.OutOrStdout(), objectArg(args))
+			})
+		},
+	}
+
+	var ignoreMissing bool
+	remove := &cobra.Command{
+		Use:   "remove [--ignore-missing] [<object>...]",
+		Short: "Remove the notes of objects, HEAD by default",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if len(args) == 0 {
+				args = []string{"HEAD"}
+			}
+			return runNotes(cmd, ref, func(s *notesSession) error {
+				return s.remove(cmd.ErrOrStderr(), args, ignoreMissing)
+			})
+		},
+	}
+	remove.Flags().BoolVar(&ignoreMissing, "ignore-missing", false, "Do not fail for an object without a note")
+
+	cmd.AddCommand(list, add, appendCmd, show, remove)
+	return cmd
+}
+
+func addNotesMessageFlags(cmd *cobra.Command, opts *notesMessageOptions) {
+	cmd.Flags().StringArrayVarP(&opts.messages, "message", "m", nil, "Use the given note message; several become paragraphs")
+	cmd.Flags().StringVarP(&opts.file, "file", "F", "", "Take the note message from the given file, - for standard input")
+}
+
+// objectArg returns the object named by args, HEAD when there is none
+func objectArg(args []string) string {
+	if len(args) == 0 {
+		return "HEAD"
+	}
+	return args[0]
+}
+
+// notesSession is an open repository and the notes of one notes ref
+type notesSession struct {
+	repo       *vcs.Repository
+	refManager *refs.RefManager
+	ref        string
+	notes      *notes.Notes
+}
+
+// runNotes reads the notes of ref, or of the configured notes ref, and
+// runs fn on them
+func runNotes(cmd *cobra.Command, ref string, fn func(s *notesSession) error) error {
+	repoPath, err := findRepository()
+	if err != nil {
+		return fmt.Errorf("not a git repository: %w", err)
+	}
+	repo, err := openRepository(repoPath)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+	cmd.SilenceUsage = true
+
+	if ref == "" {
+		ref = defaultNotesRef(repo.GitDir())
+	}
+	s := &notesSession{repo: repo, refManager: refs.NewRefManager(repo.GitDir()), ref: expandNotesRef(ref)}
+	if s.notes, err = readNotes(repo, s.refManager, s.ref); err != nil {
+		return err
+	}
+	return fn(s)
+}
+
+// defaultNotesRef returns the notes ref of GIT_NOTES_REF or core.notesRef,
+// or refs/notes/commits
+func defaultNotesRef(gitDir string) string {
+	if ref := os.Getenv("GIT_NOTES_REF"); ref != "" {
+		return ref
+	}
+	if ref, ok := loadConfig(gitDir).Get("core.notesRef"); ok && ref != "" {
+		return ref
+	}
+	return notes.DefaultRef
+}
+
+// expandNotesRef returns the full name of a notes ref given as foo,
+// notes/foo or refs/notes/foo
+func expandNotesRef(ref string) string {
+	switch {
+	case strings.HasPrefix(ref, "refs/"):
+		return ref
+	case strings.HasPrefix(ref, "notes/"):
+		return "refs/" + ref
+	default:
+		return "refs/notes/" + ref
+	}
+}
+
+// readNotes reads the notes of ref, none when it does not exist yet
+func readNotes(repo *vcs.Repository, refManager *refs.RefManager, ref string) (*notes.Notes, error) {
+	var tip objects.ObjectID
+	if refManager.RefExists(ref) {
+		id, err := refManager.ResolveRef(ref)
+		if err != nil {
+			return nil, fmt.Errorf("failed to resolve %s: %w", ref, err)
+		}
+		tip = id
+	}
+	return notes.Read(repo, tip)
+}
+
+// resolve returns the object name names
+func (s *notesSession) resolve(name string) (objects.ObjectID, error) {
+	id, err := newResolver(s.repo).Resolve(name)
+	if err != nil {
+		return objects.ObjectID{}, fmt.Errorf("failed to resolve '%s' as a valid ref: %w", name, err)
+	}
+	return id, nil
+}
+
+// commit commits the notes with message and moves the notes ref to it
+func (s *notesSession) commit(message string) error {
+	sig, err := getSignature("")
+	if err != nil {
+		return err
+	}
+	old := s.notes.Tip()
+	tip, err := s.notes.Commit(sig, sig, message+"\n")
+	if err != nil {
+		return err
+	}
+	if err := s.refManager.UpdateRef(s.ref, tip); err != nil {
+		return fmt.Errorf("failed to update %s: %w", s.ref, err)
+	}
+	logRefUpdate(s.refManager, s.ref, old, tip, "notes: "+message)
+	return nil
+}
+
+func (s *notesSession) list(w io.Writer, object string) error {
+	if object == "" {
+		for _, note := range s.notes.List() {
+			fmt.Fprintf(w, "%s %s\n", note.Blob, note.Object)
+		}
+		return nil
+	}
+
+	id, err := s.resolve(object)
+	if err != nil {
+		return err
+	}
+	blob, ok := s.notes.Get(id)
+	if !ok {
+		return fmt.Errorf("no note found for object %s", id)
+	}
+	fmt.Fprintln(w, blob)
+	return nil
+}
+
+func (s *notesSession) add(cmd *cobra.Command, object string, opts notesMessageOptions, force bool) error {
+	id, err := s.resolve(object)
+	if err != nil {
+		return err
+	}
+	existing, exists, err := s.notes.Text(id)
+	if err != nil {
+		return err
+	}
+	if exists && !force {
+		return fmt.Errorf("cannot add notes: found existing notes for object %s; use '-f' to overwrite existing notes", id)
+	}
+
+	// The editor starts from the note being replaced
+	text, err := s.noteText(cmd, opts, id, existing)
+	if err != nil {
+		return err
+	}
+	if exists {
+		fmt.Fprintf(cmd.ErrOrStderr(), "Overwriting existing notes for object %s\n", id)
+	}
+	return s.write(cmd.ErrOrStderr(), id, text, exists, "Notes added by 'vcs notes add'")
+}
+
+func (s *notesSession) append(cmd *cobra.Command, object string, opts notesMessageOptions) error {
+	id, err := s.resolve(object)
+	if err != nil {
+		return err
+	}
+	existing, exists, err := s.notes.Text(id)
+	if err != nil {
+		return err
+	}
+
+	text, err := s.noteText(cmd, opts, id, "")
+	if err != nil {
+		return err
+	}
+	if text == "" {
+		return nil
+	}
+	if exists {
+		text = strings.TrimRight(existing, "\n") + "\n\n" + text
+	}
+	return s.write(cmd.ErrOrStderr(), id, text, exists, "Notes added by 'vcs notes append'")
+}
+
+// write sets the note on id to text, removing it when text is empty
+func (s *notesSession) write(w io.Writer, id objects.ObjectID, text string, exists bool, message string) error {
+	if text == "" {
+		if !exists {
+			return nil
+		}
+		fmt.Fprintf(w, "Removing note for object %s\n", id)
+		s.notes.Remove(id)
+		return s.commit("Notes removed by 'vcs notes add'")
+	}
+	if err := s.notes.Set(id, text); err != nil {
+		return err
+	}
+	return s.commit(message)
+}
+
+// noteText returns the note text of the -m and -F flags, or else of the
+// editor started on current, cleaned of trailing whitespace and
+// surrounding blank lines, and in the editor of comments
+func (s *notesSession) noteText(cmd *cobra.Command, opts notesMessageOptions, id objects.ObjectID, current string) (string, error) {
+	if len(opts.messages) > 0 && opts.file != "" {
+		return "", fmt.Errorf("-m and -F cannot be used together")
+	}
+
+	if len(opts.messages) > 0 {
+		var paragraphs []string
+		for _, message := range opts.messages {
+			if p := stripSpace(message); p != "" {
+				paragraphs = append(paragraphs, p)
+			}
+		}
+		return strings.Join(paragraphs, "\n"), nil
+	}
+
+	if opts.file != "" {
+		var data []byte
+		var err error
+		if opts.file == "-" {
+			data, err = io.ReadAll(cmd.InOrStdin())
+		} else {
+			data, err = os.ReadFile(opts.file)
+		}
+		if err != nil {
+			return "", fmt.Errorf("failed to read note file: %w", err)
+		}
+		return stripSpace(string(data)), nil
+	}
+
+	template := current + "\n#\n# Write/edit the notes for the following object:\n# " + id.String() + "\n#\n"
+	return editText(s.repo.GitDir(), "NOTES_EDITMSG", template)
+}
+
+func (s *notesSession) show(w io.Writer, object string) error {
+	id, err := s.resolve(object)
+	if err != nil {
+		return err
+	}
+	text, ok, err := s.notes.Text(id)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("no note found for object %s", id)
+	}
+	fmt.Fprint(w, text)
+	return nil
+}
+
+func (s *notesSession) remove(w io.Writer, objectNames []string, ignoreMissing bool) error {
+	removed := false
+	for _, name := range objectNames {
+		id, err := s.resolve(name)
+		if err != nil {
+			return err
+		}
+		if !s.notes.Remove(id) {
+			if ignoreMissing {
+				continue
+			}
+			return fmt.Errorf("object %s has no note", id)
+		}
+		fmt.Fprintf(w, "Removing note for object %s\n", name)
+		removed = true
+	}
+	if !removed {
+		return nil
+	}
+	return s.commit("Notes removed by 'vcs notes remove'")
+}
+
+// stripSpace removes trailing whitespace from the lines of text and the
+// blank lines around it, ending it with a newline unless it is empty
+func stripSpace(text string) string {
+	lines := strings.Split(text, "\n")
+	for i, line := range lines {
+		lines[i] = strings.TrimRight(line, " \t\r")
+	}
+	text = strings.Trim(strings.Join(lines, "\n"), "\n")
+	if text == "" {
+		return ""
+	}
+	return text + "\n"
+}
+
+// logNotes is a notes ref whose notes log shows
+type logNotes struct {
+	ref   string
+	notes *notes.Notes
+}
+
+// readLogNotes reads the notes refs of the --notes flags of log, the
+// default notes ref for a bare --notes
+func readLogNotes(repo *vcs.Repository, refNames []string) ([]logNotes, error) {
+	refManager := refs.NewRefManager(repo.GitDir())
+	var sets []logNotes
+	seen := make(map[string]bool)
+	for _, name := range refNames {
+		if name == notes.DefaultRef {
+			name = defaultNotesRef(repo.GitDir())
+		}
+		ref := expandNotesRef(name)
+		if seen[ref] {
+			continue
+		}
+		seen[ref] = true
+		n, err := readNotes(repo, refManager, ref)
+		if err != nil {
+			return nil, err
+		}
+		sets = append(sets, logNotes{ref: ref, notes: n})
+	}
+	return sets, nil
+}
+
+// commitNotes returns the notes on id as log shows them under the message:
+// a "Notes:" line, or "Notes (<name>):" for refs other than
+// refs/notes/commits, then the note indented
+func commitNotes(sets []logNotes, id objects.ObjectID) (string, error) {
+	var b strings.Builder
+	for _, set := range sets {
+		text, ok, err := set.notes.Text(id)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			continue
+		}
+		if set.ref == notes.DefaultRef {
+			b.WriteString("\nNotes:\n")
+		} else {
+			fmt.Fprintf(&b, "\nNotes (%s):\n", strings.TrimPrefix(set.ref, "refs/notes/"))
+		}
+		for _, line := range strings.Split(strings.TrimRight(text, "\n"), "\n") {
+			fmt.Fprintf(&b, "    %s\n", line)
+		}
+	}
+	return b.String(), nil
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/notes"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+func TestNotes(t *testing.T) {
+	repo, refManager := setupSeriesRepo(t, map[string]string{"a.txt": "a\n"})
+	base, err := refManager.ResolveRef("refs/heads/main")
+	require.NoError(t, err)
+	tip := commitFiles(t, repo, map[string]string{"a.txt": "b\n"}, []objects.ObjectID{base}, "tip\n")
+	require.NoError(t, refManager.UpdateRef("refs/heads/main", tip))
+
+	_, err = runCommandArgs(newNotesCommand(), "add", "-m", "ci: passed  ", "-m", "build 42")
+	require.NoError(t, err)
+	out, err := runCommandArgs(newNotesCommand(), "show")
+	require.NoError(t, err)
+	assert.Equal(t, "ci: passed\n\nbuild 42\n", out)
+
+	_, err = runCommandArgs(newNotesCommand(), "add", "-m", "again")
+	assert.ErrorContains(t, err, "found existing notes")
+	_, err = runCommandArgs(newNotesCommand(), "add", "-f", "-m", "ci: failed")
+	require.NoError(t, err)
+	_, err = runCommandArgs(newNotesCommand(), "append", "-m", "rerun")
+	require.NoError(t, err)
+	_, err = runCommandArgs(newNotesCommand(), "append", "-m", "first", "HEAD~1")
+	require.NoError(t, err)
+	out, err = runCommandArgs(newNotesCommand(), "show", tip.String())
+	require.NoError(t, err)
+	assert.Equal(t, "ci: failed\n\nrerun\n", out)
+
+	out, err = runCommandArgs(newNotesCommand())
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	require.Len(t, lines, 2)
+	blob := objects.NewBlob([]byte("ci: failed\n\nrerun\n")).ID()
+	assert.Contains(t, lines, blob.String()+" "+tip.String())
+	out, err = runCommandArgs(newNotesCommand(), "list", "HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, blob.String()+"\n", out)
+
+	// Every change is a commit on the notes ref
+	notesTip, err := refManager.ResolveRef(notes.DefaultRef)
+	require.NoError(t, err)
+	commit, err := repo.GetCommit(notesTip)
+	require.NoError(t, err)
+	assert.Equal(t, "Notes added by 'vcs notes append'\n", commit.Message())
+	require.Len(t, commit.Parents(), 1)
+
+	_, err = runCommandArgs(newNotesCommand(), "remove", "HEAD", "HEAD~1")
+	require.NoError(t, err)
+	_, err = runCommandArgs(newNotesCommand(), "show")
+	assert.ErrorContains(t, err, "no note found")
+	_, err = runCommandArgs(newNotesCommand(), "remove")
+	assert.ErrorContains(t, err, "has no note")
+	_, err = runCommandArgs(newNotesCommand(), "remove", "--ignore-missing")
+	require.NoError(t, err)
+}
+
+func TestLogShowsNotes(t *testing.T) {
+	setupSeriesRepo(t, map[string]string{"a.txt": "a\n"})
+	_, err := runCommandArgs(newNotesCommand(), "add", "-m", "ci: passed\nbuild 42")
+	require.NoError(t, err)
+	_, err = runCommandArgs(newNotesCommand(), "--ref", "review", "add", "-m", "lgtm")
+	require.NoError(t, err)
+
+	out, err := captureStdout(t, func() error {
+		_, err := runCommandArgs(newLogCommand())
+		return err
+	})
+	require.NoError(t, err)
+	assert.Contains(t, out, "    base\n\nNotes:\n    ci: passed\n    build 42\n\n")
+	assert.NotContains(t, out, "lgtm")
+
+	out, err = captureStdout(t, func() error {
+		_, err := runCommandArgs(newLogCommand(), "--notes=review", "--notes")
+		return err
+	})
+	require.NoError(t, err)
+	assert.Contains(t, out, "    base\n\nNotes (review):\n    lgtm\n\nNotes:\n    ci: passed\n")
+
+	for _, args := range [][]string{{"--no-notes"}, {"--oneline"}} {
+		out, err = captureStdout(t, func() error {
+			_, err := runCommandArgs(newLogCommand(), args...)
+			return err
+		})
+		require.NoError(t, err)
+		assert.NotContains(t, out, "Notes", args)
+	}
+}
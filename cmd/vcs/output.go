@@ -0,0 +1,310 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/config"
+)
+
+// colorDefaults are the colors of the slots of each color.<section>, each
+// of which color.<section>.<slot> can change
+var colorDefaults = map[string]map[string]string{
+	"diff": {
+		"meta":    "bold",
+		"frag":    "cyan",
+		"old":     "red",
+		"new":     "green",
+		"commit":  "yellow",
+		"context": "normal",
+	},
+	"status": {
+		"header":       "normal",
+		"added":        "green",
+		"changed":      "red",
+		"untracked":    "red",
+		"branch":       "green",
+		"localBranch":  "green",
+		"remoteBranch": "red",
+		"nobranch":     "red",
+	},
+	"branch": {
+		"current":  "green",
+		"local":    "normal",
+		"upstream": "blue",
+	},
+	"grep": {
+		"filename":   "magenta",
+		"lineNumber": "green",
+		"separator":  "cyan",
+		"match":      "bold red",
+	},
+}
+
+var colorNames = []string{"black", "red", "green", "yellow", "blue", "magenta", "cyan", "white"}
+
+var colorAttributes = map[string]int{
+	"bold": 1, "dim": 2, "italic": 3, "ul": 4, "blink": 5, "reverse": 7, "strike": 9,
+	"nobold": 22, "nodim": 22, "noitalic": 23, "noul": 24, "noblink": 25, "noreverse": 27, "nostrike": 29,
+}
+
+// palette holds the escape sequences of the color slots of a section. A
+// nil palette paints nothing, so output is colored only when it is set.
+type palette struct {
+	slots map[string]string
+}
+
+// addColorFlags adds the flags newPalette reads
+func addColorFlags(cmd *cobra.Command) {
+	cmd.Flags().String("color", "", "Color the output: always, never or auto, when writing to a terminal")
+	cmd.Flags().Lookup("color").NoOptDefVal = "always"
+	cmd.Flags().Bool("no-color", false, "Do not color the output")
+}
+
+// newPalette returns the colors of section for cmd, or nil when its output
+// is not to be colored. --color and --no-color decide, then
+// color.<section>, then color.ui; auto colors output going to a terminal
+// or through the pager.
+func newPalette(cmd *cobra.Command, gitDir, section string) (*palette, error) {
+	cfg := loadConfig(gitDir)
+	when, source := "auto", ""
+	for _, key := range []string{"color.ui", "color." + section} {
+		if value, ok := cfg.Get(key); ok {
+			when, source = value, key
+		}
+	}
+	if cmd.Flags().Changed("color") {
+		when, _ = cmd.Flags().GetString("color")
+		source = "--color"
+	}
+	if noColor, _ := cmd.Flags().GetBool("no-color"); noColor {
+		when = "never"
+	}
+
+	on, err := colorWanted(when)
+	if err != nil {
+		return nil, fmt.Errorf("invalid %s value: %s", source, when)
+	}
+	if !on {
+		return nil, nil
+	}
+	return readPalette(cfg, section)
+}
+
+// colorWanted reports whether when, a --color argument or color.* setting,
+// asks for color on the current standard output
+func colorWanted(when string) (bool, error) {
+	switch strings.ToLower(when) {
+	case "always":
+		return true, nil
+	case "never":
+		return false, nil
+	case "auto":
+		return activePager != nil || isTerminal(os.Stdout), nil
+	}
+	on, err := config.ParseBool(when)
+	if err != nil {
+		return false, err
+	}
+	// true means auto, as in Git
+	return on && (activePager != nil || isTerminal(os.Stdout)), nil
+}
+
+// readPalette returns the colors of the slots of section, as set by
+// color.<section>.<slot> or by default
+func readPalette(cfg *config.Config, section string) (*palette, error) {
+	p := &palette{slots: make(map[string]string)}
+	for slot, value := range colorDefaults[section] {
+		key := "color." + section + "." + slot
+		if set, ok := cfg.Get(key); ok {
+			value = set
+		}
+		code, err := parseColor(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid color value for %s: %w", key, err)
+		}
+		p.slots[slot] = code
+	}
+	return p, nil
+}
+
+// color returns the escape sequence of slot, empty for none
+func (p *palette) color(slot string) string {
+	if p == nil {
+		return ""
+	}
+	return p.slots[slot]
+}
+
+// paint returns text in the color of slot, reset after it
+func (p *palette) paint(slot, text string) string {
+	code := p.color(slot)
+	if code == "" || text == "" {
+		return text
+	}
+	return code + text + colorReset
+}
+
+// parseColor returns the escape sequence of a color setting as Git writes
+// it: attributes such as bold or ul, each of which no turns off, and a
+// foreground and a background color, written attributes first. Colors are named, bright named, a
+// number from 0 to 255 or #rrggbb; normal leaves the color as it is.
+func parseColor(value string) (string, error) {
+	var attributes, codes []string
+	colors := 0
+	for _, word := range strings.Fields(strings.ToLower(value)) {
+		if code, ok := colorAttributes[strings.Replace(word, "no-", "no", 1)]; ok {
+			attributes = append(attributes, strconv.Itoa(code))
+			continue
+		}
+		if colors == 2 {
+			return "", fmt.Errorf("too many colors in %q", value)
+		}
+		code, err := colorCode(word, colors == 1)
+		if err != nil {
+			return "", err
+		}
+		if code != "" {
+			codes = append(codes, code)
+		}
+		colors++
+	}
+	codes = append(attributes, codes...)
+	if len(codes) == 0 {
+		return "", nil
+	}
+	return "\033[" + strings.Join(codes, ";") + "m", nil
+}
+
+// colorCode returns the SGR parameters of a color word, for the background
+// when background is set
+func colorCode(word string, background bool) (string, error) {
+	base, bright, extended := 30, 90, 38
+	if background {
+		base, bright, extended = 40, 100, 48
+	}
+	switch {
+	case word == "normal":
+		return "", nil
+	case word == "default":
+		return strconv.Itoa(base + 9), nil
+	case strings.HasPrefix(word, "#") && len(word) == 7:
+		rgb, err := strconv.ParseUint(word[1:], 16, 32)
+		if err != nil {
+			return "", fmt.Errorf("invalid color %q", word)
+		}
+		return fmt.Sprintf("%d;2;%d;%d;%d", extended, rgb>>16, rgb>>8&0xff, rgb&0xff), nil
+	}
+	for i, name := range colorNames {
+		switch word {
+		case name:
+			return strconv.Itoa(base + i), nil
+		case "bright" + name:
+			return strconv.Itoa(bright + i), nil
+		}
+	}
+	n, err := strconv.Atoi(word)
+	if err != nil || n < 0 || n > 255 {
+		return "", fmt.Errorf("invalid color %q", word)
+	}
+	if n < 8 {
+		return strconv.Itoa(base + n), nil
+	}
+	return fmt.Sprintf("%d;5;%d", extended, n), nil
+}
+
+// pagerProcess is the running pager and the standard output it replaced
+type pagerProcess struct {
+	cmd    *exec.Cmd
+	stdout *os.File
+}
+
+// activePager is the pager standard output goes through, nil for none
+var activePager *pagerProcess
+
+// startPager sends standard output through the pager for the rest of the
+// command when it is a terminal. paged is whether command pages by
+// default; pager.<command> turns that on or off or names another pager,
+// and -p and -P override both. main starts it for -p with no command.
+func startPager(gitDir, command string, paged bool) {
+	if activePager != nil || globalOptions.noPager || !isTerminal(os.Stdout) {
+		return
+	}
+	cfg := loadConfig(gitDir)
+	program := ""
+	if value, ok := cfg.Get("pager." + command); ok && command != "" {
+		if on, err := config.ParseBool(value); err == nil {
+			paged = on
+		} else {
+			paged, program = true, value
+		}
+	}
+	if !paged && !globalOptions.paginate {
+		return
+	}
+	if program == "" {
+		program = pagerCommand(cfg)
+	}
+	if program == "" || program == "cat" {
+		return
+	}
+
+	r, w, err := os.Pipe()
+	if err != nil {
+		return
+	}
+	cmd := exec.Command("sh", "-c", program)
+	cmd.Stdin = r
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	// less quits when the output fits on the screen and passes colors
+	// through, unless the user set options of their own
+	cmd.Env = os.Environ()
+	if _, ok := os.LookupEnv("LESS"); !ok {
+		cmd.Env = append(cmd.Env, "LESS=FRX")
+	}
+	if _, ok := os.LookupEnv("LV"); !ok {
+		cmd.Env = append(cmd.Env, "LV=-c")
+	}
+	if err := cmd.Start(); err != nil {
+		r.Close()
+		w.Close()
+		fmt.Fprintf(os.Stderr, "warning: cannot run pager '%s': %v\n", program, err)
+		return
+	}
+	r.Close()
+	activePager = &pagerProcess{cmd: cmd, stdout: os.Stdout}
+	os.Stdout = w
+}
+
+// pagerCommand returns the pager to run, checked in Git's order:
+// GIT_PAGER, core.pager, PAGER, then less
+func pagerCommand(cfg *config.Config) string {
+	if pager, ok := os.LookupEnv("GIT_PAGER"); ok {
+		return pager
+	}
+	if pager, ok := cfg.Get("core.pager"); ok {
+		return pager
+	}
+	if pager, ok := os.LookupEnv("PAGER"); ok {
+		return pager
+	}
+	return "less"
+}
+
+// stopPager ends the pager's input and waits for the user to quit it
+func stopPager() {
+	if activePager == nil {
+		return
+	}
+	w := os.Stdout
+	os.Stdout = activePager.stdout
+	w.Close()
+	activePager.cmd.Wait()
+	activePager = nil
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"os"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseColor(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{"red", "\033[31m"},
+		{"bold red", "\033[1;31m"},
+		{"red bold", "\033[1;31m"},
+		{"yellow blue", "\033[33;44m"},
+		{"brightgreen", "\033[92m"},
+		{"normal", ""},
+		{"normal red", "\033[41m"},
+		{"default", "\033[39m"},
+		{"3", "\033[33m"},
+		{"208", "\033[38;5;208m"},
+		{"#ff8000 black", "\033[38;2;255;128;0;40m"},
+		{"ul no-bold noreverse", "\033[4;22;27m"},
+		{"Bold Cyan", "\033[1;36m"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.value, func(t *testing.T) {
+			got, err := parseColor(tt.value)
+			require.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+
+	for _, value := range []string{"purple", "red green blue", "256", "#12345", "#gggggg"} {
+		_, err := parseColor(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestNewPalette(t *testing.T) {
+	repo, _ := setupConfigRepo(t)
+	palette := func(args ...string) (*palette, error) {
+		cmd := &cobra.Command{}
+		addColorFlags(cmd)
+		require.NoError(t, cmd.ParseFlags(args))
+		return newPalette(cmd, repo.GitDir(), "diff")
+	}
+
+	// Standard output is not a terminal, so auto colors nothing
+	p, err := palette()
+	require.NoError(t, err)
+	assert.Nil(t, p)
+	assert.Equal(t, "x", p.paint("old", "x"))
+
+	p, err = palette("--color")
+	require.NoError(t, err)
+	assert.Equal(t, "\033[31mx\033[m", p.paint("old", "x"))
+	assert.Equal(t, "x", p.paint("context", "x"))
+
+	_, err = runConfigArgs("color.ui", "always")
+	require.NoError(t, err)
+	p, err = palette()
+	require.NoError(t, err)
+	assert.NotNil(t, p)
+	p, err = palette("--no-color")
+	require.NoError(t, err)
+	assert.Nil(t, p)
+
+	// color.diff takes precedence over color.ui, and --color over both
+	_, err = runConfigArgs("color.diff", "never")
+	require.NoError(t, err)
+	p, err = palette()
+	require.NoError(t, err)
+	assert.Nil(t, p)
+	p, err = palette("--color=always")
+	require.NoError(t, err)
+	assert.NotNil(t, p)
+
+	_, err = runConfigArgs("color.diff.old", "magenta reverse")
+	require.NoError(t, err)
+	p, err = palette("--color=always")
+	require.NoError(t, err)
+	assert.Equal(t, "\033[7;35mx\033[m", p.paint("old", "x"))
+
+	_, err = palette("--color=sometimes")
+	assert.ErrorContains(t, err, "invalid --color value: sometimes")
+	_, err = runConfigArgs("color.diff.new", "purple")
+	require.NoError(t, err)
+	_, err = palette("--color")
+	assert.ErrorContains(t, err, "invalid color value for color.diff.new")
+}
+
+func TestPagerCommand(t *testing.T) {
+	repo, _ := setupConfigRepo(t)
+	for _, name := range []string{"GIT_PAGER", "PAGER"} {
+		if value, ok := os.LookupEnv(name); ok {
+			t.Cleanup(func() { os.Setenv(name, value) })
+		} else {
+			t.Cleanup(func() { os.Unsetenv(name) })
+		}
+		os.Unsetenv(name)
+	}
+
+	assert.Equal(t, "less", pagerCommand(loadConfig(repo.GitDir())))
+	os.Setenv("PAGER", "more")
+	assert.Equal(t, "more", pagerCommand(loadConfig(repo.GitDir())))
+	_, err := runConfigArgs("core.pager", "less -S")
+	require.NoError(t, err)
+	assert.Equal(t, "less -S", pagerCommand(loadConfig(repo.GitDir())))
+	os.Setenv("GIT_PAGER", "cat")
+	assert.Equal(t, "cat", pagerCommand(loadConfig(repo.GitDir())))
+}
+
+func TestColoredOutput(t *testing.T) {
+	setupTreeRepo(t, map[string]string{"f.txt": "keep\nold line\n"})
+	require.NoError(t, os.WriteFile("f.txt", []byte("keep\nnew line\n"), 0644))
+
+	out, err := captureStdout(t, func() error {
+		cmd := newDiffCommand()
+		cmd.SetArgs([]string{"--color"})
+		return cmd.Execute()
+	})
+	require.NoError(t, err)
+	assert.Contains(t, out, "\033[1mdiff --git a/f.txt b/f.txt\033[m\n")
+	assert.Contains(t, out, "\033[1m+++ b/f.txt\033[m\n\033[36m@@ -1,2 +1,2 @@\033[m\n keep\n\033[31m-old line\033[m\n\033[32m+new line\033[m\n")
+
+	out, err = captureStdout(t, func() error {
+		cmd := newDiffCommand()
+		return cmd.Execute()
+	})
+	require.NoError(t, err)
+	assert.NotContains(t, out, "\033[")
+
+	out, err = runLogArgs(t, "--oneline", "--color")
+	require.NoError(t, err)
+	assert.Regexp(t, "^\033\\[33m[0-9a-f]{7}\033\\[m base\n$", out)
+
+	out = runStatusArgs(t, "-s", "-b", "--color")
+	assert.Contains(t, out, "## \033[32mmain\033[m\n")
+	assert.Contains(t, out, " \033[31mM\033[m f.txt\n")
+	assert.NotContains(t, runStatusArgs(t, "--porcelain", "--color"), "\033[")
+
+	out, err = runBranchArgs(t, "--color")
+	require.NoError(t, err)
+	assert.Equal(t, "* \033[32mmain\033[m\n", out)
+
+	lines, err := runGrepArgs(t, "--color", "-n", "line")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"\033[35mf.txt\033[m\033[36m:\033[m\033[32m2\033[m\033[36m:\033[mnew \033[1;31mline\033[m"}, lines)
+}
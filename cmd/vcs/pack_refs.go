@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/refs"
+)
+
+func newPackRefsCommand() *cobra.Command {
+	var all, prune, noPrune bool
+
+	cmd := &cobra.Command{
+		Use:   "pack-refs [flags]",
+		Short: "Pack refs into a single file for efficient access",
+		Long: `Moves refs out of their own files into packed-refs, which is much faster
+to read and list in repositories with many refs. By default only tags
+and refs already packed are packed, as branches are expected to move;
+--all packs every ref. The files of the refs packed are removed unless
+--no-prune is given. Symbolic refs such as HEAD are never packed.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repoPath, err := findRepository()
+			if err != nil {
+				return fmt.Errorf("not a git repository: %w", err)
+			}
+			repo, err := openRepository(repoPath)
+			if err != nil {
+				return fmt.Errorf("failed to open repository: %w", err)
+			}
+
+			refManager := refs.NewRefManager(repo.GitDir())
+			if _, err := refManager.Pack(refs.PackOptions{All: all, Prune: prune && !noPrune}); err != nil {
+				return fmt.Errorf("failed to pack refs: %w", err)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&all, "all", false, "Pack all refs, not only tags and refs already packed")
+	cmd.Flags().BoolVar(&prune, "prune", true, "Remove the files of the refs packed")
+	cmd.Flags().BoolVar(&noPrune, "no-prune", false, "Keep the files of the refs packed")
+
+	return cmd
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/refs"
+)
+
+func TestPackRefs(t *testing.T) {
+	setupTreeRepo(t, map[string]string{"a.txt": "a\n"})
+	refManager := refs.NewRefManager(".git")
+	head, err := refManager.ResolveRef("refs/heads/main")
+	require.NoError(t, err)
+	require.NoError(t, refManager.CreateTag("v1.0", head))
+
+	_, err = runCommandArgs(newPackRefsCommand(), "--no-prune")
+	require.NoError(t, err)
+	packed, err := os.ReadFile(filepath.Join(".git", "packed-refs"))
+	require.NoError(t, err)
+	assert.Contains(t, string(packed), head.String()+" refs/tags/v1.0\n")
+	assert.NotContains(t, string(packed), "refs/heads/main")
+	assert.FileExists(t, filepath.Join(".git", "refs", "tags", "v1.0"))
+
+	_, err = runCommandArgs(newPackRefsCommand(), "--all", "--prune")
+	require.NoError(t, err)
+	assert.NoFileExists(t, filepath.Join(".git", "refs", "tags", "v1.0"))
+	assert.NoFileExists(t, filepath.Join(".git", "refs", "heads", "main"))
+
+	id, err := refManager.ResolveRef("HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, head, id)
+	branches, err := refManager.ListBranches()
+	require.NoError(t, err)
+	assert.Equal(t, []string{"refs/heads/main"}, branches)
+}
@@ -0,0 +1,392 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/convert"
+	"github.com/fenilsonani/vcs/internal/core/index"
+	"github.com/fenilsonani/vcs/internal/core/merge"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/internal/interactive"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// patchSession returns a session asking prompt about hunks on cmd's input
+// and output, editing hunks in the user's editor
+func patchSession(cmd *cobra.Command, repo *vcs.Repository, prompt string, revert bool) *interactive.Session {
+	session := interactive.NewSession(cmd.InOrStdin(), cmd.OutOrStdout(), prompt)
+	session.Revert = revert
+	session.Edit = func(text string) (string, error) {
+		// Comments are left to the session, as stripping trailing blanks
+		// would change the lines of the hunk
+		path := filepath.Join(repo.GitDir(), "addp-hunk-edit.diff")
+		if err := os.WriteFile(path, []byte(text), 0644); err != nil {
+			return "", fmt.Errorf("failed to write hunk: %w", err)
+		}
+		defer os.Remove(path)
+		if err := launchEditor(repo.GitDir(), path); err != nil {
+			return "", err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read hunk: %w", err)
+		}
+		return string(data), nil
+	}
+	return session
+}
+
+// patchIndex reads the index of repo, with the files of HEAD
+func patchIndex(repo *vcs.Repository) (*index.Index, map[string]index.HeadEntry, error) {
+	idx, err := readIndex(repo)
+	if err != nil {
+		return nil, nil, err
+	}
+	head, _, err := headFiles(repo, readStatusCache(repo))
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read HEAD: %w", err)
+	}
+	return idx, head, nil
+}
+
+// patchPaths returns the files of tracked the pathspecs in args cover, all
+// of them when there are none
+func patchPaths(repo *vcs.Repository, tracked []string, args []string) ([]string, error) {
+	if len(args) == 0 {
+		return tracked, nil
+	}
+	var paths []string
+	seen := make(map[string]bool)
+	for _, arg := range args {
+		spec, err := pathspecPath(repo.WorkDir(), arg)
+		if err != nil {
+			return nil, err
+		}
+		for _, p := range matchTracked(tracked, spec) {
+			if !seen[p] {
+				seen[p] = true
+				paths = append(paths, p)
+			}
+		}
+	}
+	return paths, nil
+}
+
+// workingContent returns the content of the working tree file path as it
+// would be stored, and false when it is not a regular file
+func workingContent(repo *vcs.Repository, conv *convert.Converter, path string) ([]byte, bool, error) {
+	full := filepath.Join(repo.WorkDir(), filepath.FromSlash(path))
+	info, err := os.Lstat(full)
+	if err != nil || !info.Mode().IsRegular() {
+		return nil, false, nil
+	}
+	data, err := os.ReadFile(full)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if data, err = conv.Clean(path, data); err != nil {
+		return nil, false, err
+	}
+	return data, true, nil
+}
+
+// writeWorkingContent replaces the working tree file path with content,
+// keeping its permissions
+func writeWorkingContent(repo *vcs.Repository, conv *convert.Converter, path string, content []byte) error {
+	full := filepath.Join(repo.WorkDir(), filepath.FromSlash(path))
+	data, err := conv.Smudge(path, content)
+	if err != nil {
+		return err
+	}
+	info, err := os.Stat(full)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(full, data, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to write %s: %w", path, err)
+	}
+	return nil
+}
+
+// textChange reports whether old and new differ and can be split into
+// hunks, neither being binary by the attributes of path
+func textChange(conv *convert.Converter, path string, old, new []byte) bool {
+	return !bytes.Equal(old, new) && !conv.DiffBinary(path, old) && !conv.DiffBinary(path, new)
+}
+
+// stageHunks asks about the hunks of the working tree changes to the
+// tracked files args cover, for add -p and commit -p, and stages those
+// taken
+func stageHunks(cmd *cobra.Command, repo *vcs.Repository, args []string) error {
+	idx, _, err := patchIndex(repo)
+	if err != nil {
+		return err
+	}
+	paths, err := patchPaths(repo, trackedPaths(idx), args)
+	if err != nil {
+		return err
+	}
+
+	conv := newConverter(repo, cmd.ErrOrStderr(), nil)
+	session := patchSession(cmd, repo, "Stage this hunk", false)
+	modified := false
+	for _, p := range paths {
+		mode, id, _ := trackedFile(idx, p)
+		work, ok, err := workingContent(repo, conv, p)
+		if err != nil {
+			return err
+		}
+		staged := getObjectContent(repo, id)
+		if !ok || !textChange(conv, p, staged, work) {
+			continue
+		}
+
+		hunks, err := session.Select(p, staged, work)
+		if err != nil {
+			return err
+		}
+		content := interactive.Apply(staged, hunks)
+		if bytes.Equal(content, staged) {
+			continue
+		}
+		blob, err := repo.CreateBlob(content)
+		if err != nil {
+			return fmt.Errorf("failed to write blob for %s: %w", p, err)
+		}
+		if err := idx.Add(&index.Entry{Mode: mode, ID: blob.ID(), Path: p, Size: uint32(len(content))}); err != nil {
+			return fmt.Errorf("failed to add %s to index: %w", p, err)
+		}
+		modified = true
+	}
+
+	if !session.Asked() {
+		fmt.Fprintln(cmd.OutOrStdout(), "No changes.")
+	}
+	if modified {
+		if err := idx.WriteToFile(filepath.Join(repo.GitDir(), "index")); err != nil {
+			return fmt.Errorf("failed to write index: %w", err)
+		}
+	}
+	return nil
+}
+
+// unstageHunks asks about the hunks of the staged changes to the files
+// args cover, relative to the commit target, for reset -p, and takes those
+// chosen back out of the index
+func unstageHunks(cmd *cobra.Command, repo *vcs.Repository, target string, args []string) error {
+	targetID, err := resolveCommitish(repo, target)
+	if err != nil {
+		return fmt.Errorf("failed to resolve %q: %w", target, err)
+	}
+	commit, err := repo.GetCommit(targetID)
+	if err != nil {
+		return fmt.Errorf("failed to get commit %s: %w", targetID.Short(), err)
+	}
+	entries, err := merge.ReadTree(repo, commit.Tree())
+	if err != nil {
+		return fmt.Errorf("failed to read tree: %w", err)
+	}
+	targetFiles := make(map[string]merge.Entry)
+	for _, entry := range entries {
+		targetFiles[entry.Path] = entry
+	}
+
+	idx, _, err := patchIndex(repo)
+	if err != nil {
+		return err
+	}
+	paths, err := patchPaths(repo, trackedPaths(idx), args)
+	if err != nil {
+		return err
+	}
+
+	conv := newConverter(repo, cmd.ErrOrStderr(), nil)
+	session := patchSession(cmd, repo, "Unstage this hunk", true)
+	modified := false
+	for _, p := range paths {
+		mode, id, _ := trackedFile(idx, p)
+		entry, ok := targetFiles[p]
+		if !ok || entry.ID == id {
+			continue
+		}
+		old, staged := getObjectContent(repo, entry.ID), getObjectContent(repo, id)
+		if !textChange(conv, p, old, staged) {
+			continue
+		}
+
+		hunks, err := session.Select(p, old, staged)
+		if err != nil {
+			return err
+		}
+		content := interactive.Revert(staged, hunks)
+		if bytes.Equal(content, staged) {
+			continue
+		}
+		modified = true
+
+		blob, err := repo.CreateBlob(content)
+		if err != nil {
+			return fmt.Errorf("failed to write blob for %s: %w", p, err)
+		}
+		if err := idx.Add(&index.Entry{Mode: mode, ID: blob.ID(), Path: p, Size: uint32(len(content))}); err != nil {
+			return fmt.Errorf("failed to add %s to index: %w", p, err)
+		}
+	}
+
+	if !session.Asked() {
+		fmt.Fprintln(cmd.OutOrStdout(), "No changes.")
+	}
+	if modified {
+		if err := idx.WriteToFile(filepath.Join(repo.GitDir(), "index")); err != nil {
+			return fmt.Errorf("failed to write index: %w", err)
+		}
+	}
+	return nil
+}
+
+// discardHunks asks about the hunks of the working tree changes to the
+// files args cover, for checkout -p, and takes those chosen out of the
+// working tree
+func discardHunks(cmd *cobra.Command, repo *vcs.Repository, args []string) error {
+	idx, _, err := patchIndex(repo)
+	if err != nil {
+		return err
+	}
+	paths, err := patchPaths(repo, trackedPaths(idx), args)
+	if err != nil {
+		return err
+	}
+
+	conv := newConverter(repo, cmd.ErrOrStderr(), nil)
+	session := patchSession(cmd, repo, "Discard this hunk from worktree", true)
+	for _, p := range paths {
+		_, id, _ := trackedFile(idx, p)
+		work, ok, err := workingContent(repo, conv, p)
+		if err != nil {
+			return err
+		}
+		staged := getObjectContent(repo, id)
+		if !ok || !textChange(conv, p, staged, work) {
+			continue
+		}
+
+		hunks, err := session.Select(p, staged, work)
+		if err != nil {
+			return err
+		}
+		if content := interactive.Revert(work, hunks); !bytes.Equal(content, work) {
+			if err := writeWorkingContent(repo, conv, p, content); err != nil {
+				return err
+			}
+		}
+	}
+
+	if !session.Asked() {
+		fmt.Fprintln(cmd.OutOrStdout(), "No changes.")
+	}
+	return nil
+}
+
+// stashHunks asks about the hunks of the working tree changes to the files
+// of HEAD args cover, for stash -p, and saves those chosen on top of HEAD
+// in a stash entry before taking them out of the working tree
+func stashHunks(cmd *cobra.Command, repo *vcs.Repository, message string, args []string) error {
+	refManager := refs.NewRefManager(repo.GitDir())
+	headID, _, err := refManager.HEAD()
+	if err != nil || headID.IsZero() {
+		return fmt.Errorf("you do not have the initial commit yet")
+	}
+	headCommit, err := repo.GetCommit(headID)
+	if err != nil {
+		return fmt.Errorf("failed to get HEAD commit: %w", err)
+	}
+	entries, err := merge.ReadTree(repo, headCommit.Tree())
+	if err != nil {
+		return fmt.Errorf("failed to read tree: %w", err)
+	}
+
+	idx, head, err := patchIndex(repo)
+	if err != nil {
+		return err
+	}
+	paths, err := patchPaths(repo, trackedPaths(idx), args)
+	if err != nil {
+		return err
+	}
+
+	conv := newConverter(repo, cmd.ErrOrStderr(), nil)
+	session := patchSession(cmd, repo, "Stash this hunk", false)
+	stashed := make(map[string]objects.ObjectID)
+	remaining := make(map[string][]byte)
+	for _, p := range paths {
+		committed, ok := head[p]
+		if !ok {
+			continue
+		}
+		work, ok, err := workingContent(repo, conv, p)
+		if err != nil {
+			return err
+		}
+		old := getObjectContent(repo, committed.ID)
+		if !ok || !textChange(conv, p, old, work) {
+			continue
+		}
+
+		hunks, err := session.Select(p, old, work)
+		if err != nil {
+			return err
+		}
+		content := interactive.Apply(old, hunks)
+		if bytes.Equal(content, old) {
+			continue
+		}
+		blob, err := repo.CreateBlob(content)
+		if err != nil {
+			return fmt.Errorf("failed to write blob for %s: %w", p, err)
+		}
+		stashed[p] = blob.ID()
+		remaining[p] = interactive.Revert(work, hunks)
+	}
+	if len(stashed) == 0 {
+		fmt.Fprintln(cmd.OutOrStdout(), "No changes selected")
+		return nil
+	}
+
+	for i := range entries {
+		if id, ok := stashed[entries[i].Path]; ok {
+			entries[i].ID = id
+		}
+	}
+	treeID, err := merge.WriteTree(repo, entries)
+	if err != nil {
+		return fmt.Errorf("failed to write tree: %w", err)
+	}
+
+	indexTree, err := writeIndexTree(repo, idx)
+	if err != nil {
+		return fmt.Errorf("cannot save the current index state: %w", err)
+	}
+	stashID, message, err := createStash(repo, refManager, headCommit, indexTree, treeID, objects.ObjectID{}, message)
+	if err != nil {
+		return err
+	}
+	if err := storeStash(refManager, stashID, message); err != nil {
+		return err
+	}
+
+	for _, p := range paths {
+		if content, ok := remaining[p]; ok {
+			if err := writeWorkingContent(repo, conv, p, content); err != nil {
+				return err
+			}
+		}
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Saved working directory and index state %s\n", message)
+	return nil
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/merge"
+)
+
+// patchContent returns twenty numbered lines, with the lines in changes
+// replaced
+func patchContent(changes map[int]string) string {
+	var b strings.Builder
+	for i := 1; i <= 20; i++ {
+		if line, ok := changes[i]; ok {
+			b.WriteString(line + "\n")
+		} else {
+			fmt.Fprintf(&b, "%d\n", i)
+		}
+	}
+	return b.String()
+}
+
+// The working tree changes of the patch tests, in two hunks
+var (
+	firstChange  = map[int]string{2: "two"}
+	secondChange = map[int]string{18: "eighteen"}
+	bothChanges  = map[int]string{2: "two", 18: "eighteen"}
+)
+
+// runPatchArgs runs cmd with args, answering its prompts with input
+func runPatchArgs(cmd *cobra.Command, input string, args ...string) (string, error) {
+	cmd.SetIn(strings.NewReader(input))
+	return runCommandArgs(cmd, args...)
+}
+
+func readWorkFile(t *testing.T, path string) string {
+	data, err := os.ReadFile(path)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestAddPatchMode(t *testing.T) {
+	repo, _ := setupSeriesRepo(t, map[string]string{"f.txt": patchContent(nil)})
+	require.NoError(t, os.WriteFile("f.txt", []byte(patchContent(bothChanges)), 0644))
+
+	out, err := runPatchArgs(newAddCommand(), "?\ny\nn\n", "-p")
+	require.NoError(t, err)
+	assert.Contains(t, out, "diff --git a/f.txt b/f.txt\n--- a/f.txt\n+++ b/f.txt\n@@ -1,5 +1,5 @@\n 1\n-2\n+two\n")
+	assert.Contains(t, out, "(1/2) Stage this hunk [y,n,q,a,d,e,?]? y - stage this hunk")
+	assert.Contains(t, out, "(2/2) Stage this hunk")
+	assert.Equal(t, patchContent(firstChange), stagedContent(t, repo, "f.txt"))
+	assert.Equal(t, []string{"MM f.txt"}, shortStatus(t))
+
+	out, err = runPatchArgs(newAddCommand(), "", "-p", "f.txt")
+	require.NoError(t, err)
+	assert.Contains(t, out, "(1/1) Stage this hunk")
+	out, err = runPatchArgs(newAddCommand(), "a\n", "-p")
+	require.NoError(t, err)
+	assert.Equal(t, patchContent(bothChanges), stagedContent(t, repo, "f.txt"))
+	out, err = runPatchArgs(newAddCommand(), "", "-p")
+	require.NoError(t, err)
+	assert.Equal(t, "No changes.\n", out)
+}
+
+func TestResetPatchMode(t *testing.T) {
+	repo, _ := setupSeriesRepo(t, map[string]string{"f.txt": patchContent(nil)})
+	stageFile(t, "f.txt", patchContent(bothChanges))
+
+	out, err := runPatchArgs(newResetCommand(), "n\ny\n", "-p")
+	require.NoError(t, err)
+	assert.Contains(t, out, "(2/2) Unstage this hunk")
+	assert.Equal(t, patchContent(firstChange), stagedContent(t, repo, "f.txt"))
+
+	// Unstaging the rest leaves nothing staged
+	_, err = runPatchArgs(newResetCommand(), "y\n", "-p", "HEAD", "--", "f.txt")
+	require.NoError(t, err)
+	assert.Equal(t, []string{" M f.txt"}, shortStatus(t))
+}
+
+func TestCheckoutPatchMode(t *testing.T) {
+	setupSeriesRepo(t, map[string]string{"f.txt": patchContent(nil)})
+	require.NoError(t, os.WriteFile("f.txt", []byte(patchContent(bothChanges)), 0644))
+
+	out, err := runPatchArgs(newCheckoutCommand(), "y\nn\n", "-p")
+	require.NoError(t, err)
+	assert.Contains(t, out, "(1/2) Discard this hunk from worktree")
+	assert.Equal(t, patchContent(secondChange), readWorkFile(t, "f.txt"))
+}
+
+func TestStashPatchMode(t *testing.T) {
+	repo, refManager := setupSeriesRepo(t, map[string]string{"f.txt": patchContent(nil), "g.txt": "g\n"})
+	require.NoError(t, os.WriteFile("f.txt", []byte(patchContent(bothChanges)), 0644))
+	require.NoError(t, os.WriteFile("g.txt", []byte("changed\n"), 0644))
+
+	// Quitting in f.txt leaves g.txt alone
+	out, err := runPatchArgs(newStashCommand(), "y\nq\n", "push", "-p", "-m", "first")
+	require.NoError(t, err)
+	assert.Contains(t, out, "Saved working directory and index state On main: first\n")
+	assert.Equal(t, patchContent(secondChange), readWorkFile(t, "f.txt"))
+	assert.Equal(t, "changed\n", readWorkFile(t, "g.txt"))
+
+	stashID, err := refManager.ResolveRef("refs/stash")
+	require.NoError(t, err)
+	stash, err := repo.GetCommit(stashID)
+	require.NoError(t, err)
+	entries, err := merge.ReadTree(repo, stash.Tree())
+	require.NoError(t, err)
+	files := make(map[string]string)
+	for _, entry := range entries {
+		files[entry.Path] = string(getObjectContent(repo, entry.ID))
+	}
+	assert.Equal(t, map[string]string{"f.txt": patchContent(firstChange), "g.txt": "g\n"}, files)
+
+	out, err = runCommandArgs(newStashCommand(), "list")
+	require.NoError(t, err)
+	assert.Equal(t, "stash@{0}: On main: first\n", out)
+}
+
+func TestCommitPatchMode(t *testing.T) {
+	repo, refManager := setupSeriesRepo(t, map[string]string{"f.txt": patchContent(nil)})
+	require.NoError(t, os.WriteFile("f.txt", []byte(patchContent(bothChanges)), 0644))
+
+	_, err := runPatchArgs(newCommitCommand(), "n\ny\n", "-p", "-m", "Second change")
+	require.NoError(t, err)
+	headID, _, err := refManager.HEAD()
+	require.NoError(t, err)
+	commit, err := repo.GetCommit(headID)
+	require.NoError(t, err)
+	assert.Equal(t, "Second change\n", commit.Message())
+	entries, err := merge.ReadTree(repo, commit.Tree())
+	require.NoError(t, err)
+	require.Len(t, entries, 1)
+	assert.Equal(t, patchContent(secondChange), string(getObjectContent(repo, entries[0].ID)))
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+// objectCaches holds the object cache of each repository opened by this
+// process, keyed by git directory
+var objectCaches = struct {
+	sync.Mutex
+	byGitDir map[string]*objects.Cache
+}{byGitDir: make(map[string]*objects.Cache)}
+
+// objectCache returns the cache shared by every open of the repository at
+// gitDir, sized by core.objectCacheSize
+func objectCache(gitDir string) *objects.Cache {
+	if abs, err := filepath.Abs(gitDir); err == nil {
+		gitDir = abs
+	}
+
+	objectCaches.Lock()
+	defer objectCaches.Unlock()
+
+	if cache, ok := objectCaches.byGitDir[gitDir]; ok {
+		return cache
+	}
+
+	limit := int64(objects.DefaultCacheSize)
+	if value, ok := loadConfigSections(gitDir, "core")["core.objectcachesize"]; ok {
+		size, err := parseConfigSize(value)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: ignoring core.objectCacheSize: %v\n", err)
+		} else {
+			limit = size
+		}
+	}
+
+	cache := objects.NewCache(limit)
+	objectCaches.byGitDir[gitDir] = cache
+	return cache
+}
+
+// parseConfigSize parses a size in bytes with an optional k, m or g suffix
+func parseConfigSize(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	digits, multiplier := value, int64(1)
+	if value != "" {
+		switch strings.ToLower(value[len(value)-1:]) {
+		case "k":
+			multiplier = 1 << 10
+		case "m":
+			multiplier = 1 << 20
+		case "g":
+			multiplier = 1 << 30
+		}
+		if multiplier > 1 {
+			digits = value[:len(value)-1]
+		}
+	}
+
+	n, err := strconv.ParseInt(digits, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q", value)
+	}
+	return n * multiplier, nil
+}
+
+// printPerfStats writes the object cache statistics of each repository
+// opened by this process, as requested with --perf
+func printPerfStats(w io.Writer) {
+	objectCaches.Lock()
+	defer objectCaches.Unlock()
+
+	gitDirs := make([]string, 0, len(objectCaches.byGitDir))
+	for gitDir := range objectCaches.byGitDir {
+		gitDirs = append(gitDirs, gitDir)
+	}
+	sort.Strings(gitDirs)
+
+	for _, gitDir := range gitDirs {
+		stats := objectCaches.byGitDir[gitDir].Stats()
+		fmt.Fprintf(w, "perf: object cache (%s): %d hits, %d misses, %.1f%% hit rate\n",
+			gitDir, stats.Hits, stats.Misses, stats.HitRate()*100)
+		fmt.Fprintf(w, "perf: object cache (%s): %d objects, %d of %d bytes, %d evictions\n",
+			gitDir, stats.Entries, stats.Size, stats.Limit, stats.Evictions)
+	}
+}
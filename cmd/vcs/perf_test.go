@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/packfile"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+func TestParseConfigSize(t *testing.T) {
+	tests := []struct {
+		value string
+		want  int64
+	}{
+		{"0", 0},
+		{"4096", 4096},
+		{"512k", 512 << 10},
+		{"64m", 64 << 20},
+		{" 2G ", 2 << 30},
+	}
+	for _, tt := range tests {
+		got, err := parseConfigSize(tt.value)
+		require.NoError(t, err, tt.value)
+		assert.Equal(t, tt.want, got, tt.value)
+	}
+
+	for _, value := range []string{"", "m", "-1", "12x", "1.5m"} {
+		_, err := parseConfigSize(value)
+		assert.Error(t, err, value)
+	}
+}
+
+func TestObjectCacheShared(t *testing.T) {
+	repoPath := t.TempDir()
+	repo, err := vcs.Init(repoPath)
+	require.NoError(t, err)
+
+	config := filepath.Join(repo.GitDir(), "config")
+	f, err := os.OpenFile(config, os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteString("\tobjectCacheSize = 1m\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	commitID := commitFiles(t, repo, map[string]string{"a.txt": "a\n"}, nil, "first\n")
+
+	first, err := openRepository(repoPath)
+	require.NoError(t, err)
+	second, err := openRepository(repoPath)
+	require.NoError(t, err)
+	assert.Same(t, first.Storage().Cache(), second.Storage().Cache())
+	assert.Equal(t, int64(1<<20), first.Storage().Cache().Stats().Limit)
+
+	// The second open hits what the first one read
+	_, err = first.GetCommit(commitID)
+	require.NoError(t, err)
+	_, err = second.GetCommit(commitID)
+	require.NoError(t, err)
+
+	stats := first.Storage().Cache().Stats()
+	assert.Equal(t, uint64(1), stats.Hits)
+	assert.Equal(t, uint64(1), stats.Misses)
+
+	var out bytes.Buffer
+	printPerfStats(&out)
+	assert.Contains(t, out.String(), "1 hits, 1 misses, 50.0% hit rate")
+}
+
+// setCgroupMemory points cgroupRoot at a directory whose memory.max holds
+// value
+func setCgroupMemory(t *testing.T, value string) {
+	root := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(root, "memory.max"), []byte(value+"\n"), 0644))
+	old := cgroupRoot
+	cgroupRoot = root
+	t.Cleanup(func() { cgroupRoot = old })
+}
+
+func TestCgroupMemoryLimit(t *testing.T) {
+	setCgroupMemory(t, "536870912")
+	assert.Equal(t, int64(512<<20), cgroupMemoryLimit())
+
+	setCgroupMemory(t, "max")
+	assert.Zero(t, cgroupMemoryLimit())
+
+	// cgroup v1 keeps the limit one directory down
+	root := t.TempDir()
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "memory"), 0755))
+	require.NoError(t, os.WriteFile(filepath.Join(root, "memory", "memory.limit_in_bytes"), []byte("9223372036854771712\n"), 0644))
+	cgroupRoot = root
+	assert.Zero(t, cgroupMemoryLimit())
+	require.NoError(t, os.WriteFile(filepath.Join(root, "memory", "memory.limit_in_bytes"), []byte("268435456\n"), 0644))
+	assert.Equal(t, int64(256<<20), cgroupMemoryLimit())
+}
+
+func TestFitMemoryLimit(t *testing.T) {
+	opts := packfile.DefaultWriterOptions()
+	opts.Threads = 8
+	fitMemoryLimit(&opts, 0, true)
+	assert.Equal(t, 8, opts.Threads)
+	assert.Zero(t, opts.WindowMemory)
+
+	// 256m of budget leaves room for four threads of 64m
+	fitMemoryLimit(&opts, 512<<20, true)
+	assert.Equal(t, 4, opts.Threads)
+	assert.Equal(t, int64(64<<20), opts.WindowMemory)
+
+	opts = packfile.DefaultWriterOptions()
+	opts.Threads = 8
+	opts.WindowMemory = 128 << 20
+	fitMemoryLimit(&opts, 512<<20, true)
+	assert.Equal(t, 2, opts.Threads)
+	assert.Equal(t, int64(128<<20), opts.WindowMemory)
+
+	// A tiny container still gets one thread
+	opts = packfile.DefaultWriterOptions()
+	opts.Threads = 8
+	fitMemoryLimit(&opts, 64<<20, true)
+	assert.Equal(t, 1, opts.Threads)
+	assert.Equal(t, int64(32<<20), opts.WindowMemory)
+
+	// An explicit pack.threads is kept
+	opts = packfile.DefaultWriterOptions()
+	opts.Threads = 8
+	fitMemoryLimit(&opts, 512<<20, false)
+	assert.Equal(t, 8, opts.Threads)
+	assert.Equal(t, int64(32<<20), opts.WindowMemory)
+}
+
+func TestPackConfig(t *testing.T) {
+	setCgroupMemory(t, "max")
+	repo, err := vcs.Init(t.TempDir())
+	require.NoError(t, err)
+
+	opts := packWriterOptions(repo.GitDir())
+	assert.Zero(t, opts.WindowMemory)
+	assert.Equal(t, runtime.NumCPU(), opts.Threads)
+	windowSize, limit := packWindowLimits(repo.GitDir())
+	assert.Zero(t, windowSize)
+	assert.Zero(t, limit)
+
+	f, err := os.OpenFile(filepath.Join(repo.GitDir(), "config"), os.O_APPEND|os.O_WRONLY, 0644)
+	require.NoError(t, err)
+	_, err = f.WriteString("\tpackedGitLimit = 8m\n[pack]\n\twindowMemory = 10m\n\tthreads = 3\n")
+	require.NoError(t, err)
+	require.NoError(t, f.Close())
+
+	opts = packWriterOptions(repo.GitDir())
+	assert.Equal(t, int64(10<<20), opts.WindowMemory)
+	assert.Equal(t, 3, opts.Threads)
+	windowSize, limit = packWindowLimits(repo.GitDir())
+	assert.Equal(t, int64(defaultPackedGitWindowSize), windowSize)
+	assert.Equal(t, int64(8<<20), limit)
+}
@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/internal/credential"
+	"github.com/fenilsonani/vcs/internal/transport"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+func newPRCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "pr",
+		Short: "Work with GitHub pull requests",
+	}
+	cmd.AddCommand(newPRCreateCommand())
+	return cmd
+}
+
+func newPRCreateCommand() *cobra.Command {
+	var (
+		title      string
+		body       string
+		base       string
+		head       string
+		remoteName string
+		draft      bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "create",
+		Short: "Open a pull request for the current branch",
+		Long: `Opens a pull request on the GitHub repository of the remote, from the
+current branch, which should already be pushed, into the remote's default
+branch, as fetched or else as the API reports it. The title and body default to the subject and body of the
+branch's last commit. The URL of the pull request is printed.
+
+The GitHub API is called with the token in GH_TOKEN or GITHUB_TOKEN, or
+else the password the credential helpers have for the remote's host.
+For GitHub Enterprise, set remote.<name>.provider to github and
+remote.<name>.apiUrl or github.apiUrl to the server's API root.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repo, err := openSuperproject()
+			if err != nil {
+				return err
+			}
+			remotes, err := getRemotes(repo)
+			if err != nil {
+				return fmt.Errorf("failed to get remotes: %w", err)
+			}
+			remoteURL, ok := remotes[remoteName]
+			if !ok {
+				return fmt.Errorf("remote '%s' does not exist", remoteName)
+			}
+
+			if head == "" {
+				if head, err = getCurrentBranch(repo); err != nil {
+					return fmt.Errorf("failed to get current branch: %w", err)
+				}
+			}
+			if base == "" {
+				base, _ = remoteDefaultBranch(repo, remoteName)
+			}
+			if head == base {
+				return fmt.Errorf("head branch '%s' is the same as the base branch", head)
+			}
+
+			if title == "" {
+				subject, rest, err := branchMessage(repo, head)
+				if err != nil {
+					return err
+				}
+				title = subject
+				if !cmd.Flags().Changed("body") {
+					body = rest
+				}
+			}
+
+			pr, err := createPullRequest(repo, remoteName, remoteURL, &transport.NewPullRequest{
+				Title: title,
+				Body:  body,
+				Head:  head,
+				Base:  base,
+				Draft: draft,
+			})
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), pr.HTMLURL)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&title, "title", "t", "", "Title of the pull request")
+	cmd.Flags().StringVarP(&body, "body", "b", "", "Description of the pull request")
+	cmd.Flags().StringVarP(&base, "base", "B", "", "Branch to merge into (default: the remote's default branch)")
+	cmd.Flags().StringVarP(&head, "head", "H", "", "Branch holding the changes, as owner:branch for a fork (default: the current branch)")
+	cmd.Flags().StringVarP(&remoteName, "remote", "R", "origin", "Remote of the GitHub repository")
+	cmd.Flags().BoolVarP(&draft, "draft", "d", false, "Open the pull request as a draft")
+	return cmd
+}
+
+// createPullRequest opens pr on the GitHub repository at remoteURL. A pull
+// request without a base targets the repository's default branch.
+func createPullRequest(repo *vcs.Repository, remoteName, remoteURL string, pr *transport.NewPullRequest) (*transport.PullRequest, error) {
+	if remoteProvider(repo, remoteName, remoteURL) != transport.ProviderGitHub {
+		return nil, fmt.Errorf("'%s' is not a GitHub repository", remoteURL)
+	}
+	remote, err := transport.ParseRemoteRepository(remoteURL)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse remote URL: %w", err)
+	}
+
+	token, helpers, err := githubToken(repo.GitDir(), remote.Host)
+	if err != nil {
+		return nil, err
+	}
+	provider, err := openProvider(repo, transport.ProviderGitHub, remoteName, remoteURL, token.Password)
+	if err != nil {
+		return nil, err
+	}
+	github := provider.(*transport.GitHubTransport)
+
+	ctx := context.Background()
+	if pr.Base == "" {
+		if pr.Base, err = github.DefaultBranch(ctx); err != nil {
+			return nil, fmt.Errorf("failed to find the default branch: %w", err)
+		}
+		if pr.Head == pr.Base {
+			return nil, fmt.Errorf("head branch '%s' is the same as the base branch", pr.Head)
+		}
+	}
+	created, err := github.CreatePullRequest(ctx, pr)
+	if helpers != nil {
+		// Tell the helpers whether the token still works
+		var apiErr *transport.APIError
+		if err == nil {
+			helpers.Approve(token)
+		} else if errors.As(err, &apiErr) && apiErr.StatusCode == http.StatusUnauthorized {
+			helpers.Reject(token)
+		}
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to create pull request: %w", err)
+	}
+	return created, nil
+}
+
+// githubToken returns the token to call the API of host with: from
+// GH_TOKEN or GITHUB_TOKEN, or else the password the credential helpers
+// have for host, in which case the manager it came from is returned too
+func githubToken(gitDir, host string) (*credential.Credential, *credential.Manager, error) {
+	if token := providerToken(transport.ProviderGitHub); token != "" {
+		return &credential.Credential{Password: token}, nil, nil
+	}
+
+	manager := newCredentialManager(gitDir)
+	c := &credential.Credential{Protocol: "https", Host: host}
+	if err := manager.Fill(c); err != nil {
+		return nil, nil, fmt.Errorf("no GitHub token; set GH_TOKEN or GITHUB_TOKEN, or configure a credential helper: %w", err)
+	}
+	return c, manager, nil
+}
+
+// remoteDefaultBranch returns the branch refs/remotes/<remote>/HEAD points
+// to, as clone records it, and whether it is known
+func remoteDefaultBranch(repo *vcs.Repository, remoteName string) (string, bool) {
+	prefix := "refs/remotes/" + remoteName + "/"
+	data, err := os.ReadFile(filepath.Join(repo.CommonDir(), "refs", "remotes", remoteName, "HEAD"))
+	if err != nil {
+		return "", false
+	}
+	target := strings.TrimSpace(strings.TrimPrefix(string(data), "ref:"))
+	return strings.CutPrefix(target, prefix)
+}
+
+// branchMessage returns the subject and body of the last commit of branch,
+// which may name a fork's branch as owner:branch
+func branchMessage(repo *vcs.Repository, branch string) (subject, body string, err error) {
+	if _, name, ok := strings.Cut(branch, ":"); ok {
+		branch = name
+	}
+	id, err := refs.NewRefManager(repo.GitDir()).ResolveRef("refs/heads/" + branch)
+	if err != nil {
+		return "", "", fmt.Errorf("failed to resolve branch '%s': %w", branch, err)
+	}
+	commit, err := repo.GetCommit(id)
+	if err != nil {
+		return "", "", err
+	}
+	subject, body, _ = strings.Cut(strings.TrimSpace(commit.Message()), "\n")
+	return strings.TrimSpace(subject), strings.TrimSpace(body), nil
+}
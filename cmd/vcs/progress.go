@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/progress"
+)
+
+// isTerminal reports whether w writes to a terminal
+func isTerminal(w io.Writer) bool {
+	f, ok := w.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// addWorktreeOutputFlags registers the flags that control what a command
+// updating the working tree says about it: --quiet, --progress and
+// --porcelain
+func addWorktreeOutputFlags(cmd *cobra.Command) {
+	cmd.Flags().BoolP("quiet", "q", false, "Suppress progress and the summary of changed paths")
+	cmd.Flags().Bool("progress", false, "Show progress even when standard error is not a terminal")
+	cmd.Flags().Bool("porcelain", false, "Print the changed paths in a stable format for scripts, without progress")
+}
+
+// worktreeUpdate shows the progress of updating the working tree and
+// collects the paths that changed, for the summary printed at the end
+type worktreeUpdate struct {
+	quiet     bool
+	porcelain bool
+	// progress is where the meter is drawn, nil to hide it
+	progress   io.Writer
+	updated    []string
+	deleted    []string
+	conflicted []string
+}
+
+// newWorktreeUpdate returns the update for cmd, configured by the flags of
+// addWorktreeOutputFlags. Progress goes to standard error when it is a
+// terminal or --progress is given.
+func newWorktreeUpdate(cmd *cobra.Command) *worktreeUpdate {
+	quiet, _ := cmd.Flags().GetBool("quiet")
+	porcelain, _ := cmd.Flags().GetBool("porcelain")
+	progress, _ := cmd.Flags().GetBool("progress")
+
+	update := &worktreeUpdate{quiet: quiet, porcelain: porcelain}
+	if !quiet && !porcelain && (progress || isTerminal(cmd.ErrOrStderr())) {
+		update.progress = cmd.ErrOrStderr()
+	}
+	return update
+}
+
+// meter returns the progress meter for total file updates, nil when
+// there are none
+func (u *worktreeUpdate) meter(total int) *progress.Meter {
+	if u == nil || total == 0 {
+		return nil
+	}
+	return progress.New(u.progress, "Updating files", total)
+}
+
+// record notes the paths an update changed
+func (u *worktreeUpdate) record(updated, deleted, conflicted []string) {
+	if u == nil {
+		return
+	}
+	u.updated = append(u.updated, updated...)
+	u.deleted = append(u.deleted, deleted...)
+	u.conflicted = append(u.conflicted, conflicted...)
+}
+
+// printSummary lists the changed paths: conflicted, updated and deleted,
+// each grouped by directory, or one "<status> <path>" line per path with
+// --porcelain, where the status is U, M or D. --quiet prints nothing.
+func (u *worktreeUpdate) printSummary(out io.Writer) {
+	if u.quiet {
+		return
+	}
+	groups := []struct {
+		title  string
+		status string
+		paths  []string
+	}{
+		{"Conflicted", "U", u.conflicted},
+		{"Updated", "M", u.updated},
+		{"Deleted", "D", u.deleted},
+	}
+
+	for _, group := range groups {
+		paths := append([]string(nil), group.paths...)
+		sort.Strings(paths)
+		if u.porcelain {
+			for _, p := range paths {
+				fmt.Fprintf(out, "%s %s\n", group.status, p)
+			}
+			continue
+		}
+		if len(paths) == 0 {
+			continue
+		}
+
+		fmt.Fprintf(out, "%s paths (%d):\n", group.title, len(paths))
+		var dirs []string
+		names := make(map[string][]string)
+		for _, p := range paths {
+			dir := path.Dir(p) + "/"
+			if _, ok := names[dir]; !ok {
+				dirs = append(dirs, dir)
+			}
+			names[dir] = append(names[dir], path.Base(p))
+		}
+		sort.Strings(dirs)
+		for _, dir := range dirs {
+			fmt.Fprintf(out, "  %s: %s\n", dir, strings.Join(names[dir], ", "))
+		}
+	}
+}
+
+// transferProgress returns where the progress of a fetch, clone or push
+// is drawn: standard error when it is a terminal or --progress is given,
+// and otherwise nowhere
+func transferProgress(cmd *cobra.Command) io.Writer {
+	show, _ := cmd.Flags().GetBool("progress")
+	if !show && !isTerminal(cmd.ErrOrStderr()) {
+		return nil
+	}
+	return cmd.ErrOrStderr()
+}
+
+// remoteProgress returns where the progress messages of a remote go while
+// fetching: where transferProgress draws, each line prefixed with
+// "remote: ", or nowhere
+func remoteProgress(cmd *cobra.Command) io.Writer {
+	out := transferProgress(cmd)
+	if out == nil {
+		return nil
+	}
+	return progress.NewRemoteWriter(out, isTerminal(out))
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWorktreeUpdateSummary(t *testing.T) {
+	update := &worktreeUpdate{}
+	update.record([]string{"src/b.go", "README", "src/a.go"}, []string{"docs/old.md"}, nil)
+	update.record(nil, nil, []string{"src/c.go"})
+
+	var out bytes.Buffer
+	update.printSummary(&out)
+	assert.Equal(t, "Conflicted paths (1):\n  src/: c.go\n"+
+		"Updated paths (3):\n  ./: README\n  src/: a.go, b.go\n"+
+		"Deleted paths (1):\n  docs/: old.md\n", out.String())
+
+	out.Reset()
+	update.porcelain = true
+	update.printSummary(&out)
+	assert.Equal(t, "U src/c.go\nM README\nM src/a.go\nM src/b.go\nD docs/old.md\n", out.String())
+
+	out.Reset()
+	update.quiet = true
+	update.printSummary(&out)
+	assert.Empty(t, out.String())
+}
+
+func TestMergeProgressAndSummary(t *testing.T) {
+	setupMergeRepo(t,
+		map[string]string{"a.txt": "one\ntwo\nthree\n", "b.txt": "base\n"},
+		map[string]string{"a.txt": "ONE\ntwo\nthree\n", "b.txt": "base\n"},
+		map[string]string{"a.txt": "one\ntwo\nTHREE\n", "b.txt": "base\n", "new.txt": "new\n"},
+	)
+
+	stdout, stderr, err := runMergeArgs("--progress", "topic")
+	require.NoError(t, err)
+	assert.Contains(t, stderr, "Updating files: 100% (2/2), done.\n")
+	assert.Contains(t, stdout, "Merge made by")
+	assert.Contains(t, stdout, "Updated paths (2):\n  ./: a.txt, new.txt\n")
+}
+
+func TestMergePorcelainConflicts(t *testing.T) {
+	setupMergeRepo(t,
+		map[string]string{"a.txt": "base\n", "b.txt": "base\n", "c.txt": "base\n"},
+		map[string]string{"a.txt": "ours\n", "b.txt": "ours\n", "c.txt": "base\n"},
+		map[string]string{"a.txt": "theirs\n", "c.txt": "theirs\n"},
+	)
+
+	stdout, stderr, err := runMergeArgs("--porcelain", "--progress", "topic")
+	require.ErrorIs(t, err, errMergeConflict)
+	assert.Contains(t, stderr, "CONFLICT (content): Merge conflict in a.txt")
+	assert.NotContains(t, stderr, "Updating files")
+	assert.Contains(t, stdout, "U a.txt\nU b.txt\n")
+	assert.Contains(t, stdout, "M c.txt\n")
+	assert.NotContains(t, stdout, "CONFLICT")
+
+	_, _, err = runMergeArgs("--porcelain", "--report=json", "topic")
+	assert.ErrorContains(t, err, "--porcelain cannot be used with --report")
+}
+
+func TestMergeQuiet(t *testing.T) {
+	setupMergeRepo(t,
+		map[string]string{"a.txt": "base\n"},
+		map[string]string{"a.txt": "base\n", "b.txt": "ours\n"},
+		map[string]string{"a.txt": "theirs\n"},
+	)
+
+	stdout, stderr, err := runMergeArgs("--quiet", "--progress", "topic")
+	require.NoError(t, err)
+	assert.NotContains(t, stdout, "paths")
+	assert.NotContains(t, stderr, "Updating files")
+}
+
+func TestCheckoutSummary(t *testing.T) {
+	_, commits := setupRenameRepo(t)
+
+	var stdout, stderr bytes.Buffer
+	cmd := newCheckoutCommand()
+	cmd.SetOut(&stdout)
+	cmd.SetErr(&stderr)
+	cmd.SetArgs([]string{"--force", "--progress", commits[0].String()})
+	require.NoError(t, cmd.Execute())
+
+	assert.Contains(t, stderr.String(), "Updating files: 100% (3/3), done.\n")
+	assert.Contains(t, stdout.String(), "HEAD is now at "+commits[0].String()[:7])
+	assert.Contains(t, stdout.String(), "Updated paths (2):\n  ./: old.txt, other.txt\n"+
+		"Deleted paths (1):\n  ./: new.txt\n")
+
+	out, err := runCommandArgs(newCheckoutCommand(), "--force", "--porcelain", "main")
+	require.NoError(t, err)
+	assert.Equal(t, "M new.txt\nM other.txt\nD old.txt\n", out)
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/config"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/packfile"
+	"github.com/fenilsonani/vcs/internal/core/promisor"
+	"github.com/fenilsonani/vcs/internal/transport"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// promisorRemote returns the remote that objects missing from a repository
+// with config cfg are fetched from: the one extensions.partialClone names,
+// or else the first with remote.<name>.promisor set. It returns "" when the
+// repository has none.
+func promisorRemote(cfg *config.Config) string {
+	if name, ok := cfg.Get("extensions.partialClone"); ok && name != "" {
+		return name
+	}
+	for _, name := range cfg.Subsections("remote") {
+		if value, ok := cfg.Get("remote." + name + ".promisor"); ok {
+			if promised, err := config.ParseBool(value); err == nil && promised {
+				return name
+			}
+		}
+	}
+	return ""
+}
+
+// setupPromisorFetcher makes reads of objects repo lacks fetch them from
+// its promisor remote, at most fetch.promisorConcurrency requests at once
+func setupPromisorFetcher(repo *vcs.Repository, cfg *config.Config) {
+	remote := promisorRemote(cfg)
+	if remote == "" {
+		return
+	}
+
+	concurrency := 0
+	if value, ok := cfg.Get("fetch.promisorConcurrency"); ok {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 1 {
+			fmt.Fprintf(os.Stderr, "warning: ignoring invalid fetch.promisorConcurrency '%s'\n", value)
+		} else {
+			concurrency = n
+		}
+	}
+
+	repo.Storage().SetFetcher(promisor.NewFetcher(func(ids []objects.ObjectID) error {
+		return fetchPromisedObjects(repo, remote, ids)
+	}, concurrency))
+}
+
+// fetchPromisedObjects fetches the objects ids from the promisor remote.
+// Nothing is sent as a have, so the pack holds the objects themselves
+// rather than deltas against objects repo may lack too.
+func fetchPromisedObjects(repo *vcs.Repository, remote string, ids []objects.ObjectID) error {
+	remotes, err := getRemotes(repo)
+	if err != nil {
+		return fmt.Errorf("failed to get remotes: %w", err)
+	}
+	remoteURL, ok := remotes[remote]
+	if !ok {
+		return fmt.Errorf("promisor remote '%s' does not exist", remote)
+	}
+
+	// Lazy fetches happen inside other commands, so no command's flags
+	// apply to them
+	httpTransport, err := openHTTPTransport(&cobra.Command{}, repo, remote, remoteURL)
+	if err != nil {
+		return err
+	}
+	ctx := context.Background()
+	discovery, err := httpTransport.DiscoverRefs(ctx, "git-upload-pack")
+	if err != nil {
+		return fmt.Errorf("failed to read refs of %s: %w", remoteURL, err)
+	}
+	if !discovery.HasCapability("allow-reachable-sha1-in-want") && !discovery.HasCapability("allow-any-sha1-in-want") {
+		return fmt.Errorf("%s does not allow fetching objects by ID", remoteURL)
+	}
+
+	req := &transport.FetchRequest{}
+	for _, id := range ids {
+		req.Wants = append(req.Wants, id.String())
+	}
+	if req.Capabilities, err = negotiateCapabilities(discovery, req, false); err != nil {
+		return err
+	}
+
+	resp, err := httpTransport.Fetch(ctx, req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch missing objects from %s: %w", remote, err)
+	}
+	defer resp.Close()
+	if _, err := packfile.Unpack(resp.Pack, repo); err != nil {
+		return fmt.Errorf("failed to unpack objects: %w", err)
+	}
+	return nil
+}
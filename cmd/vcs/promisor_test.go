@@ -0,0 +1,56 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+func TestPromisorRemote(t *testing.T) {
+	repo, _ := setupConfigRepo(t)
+	set := func(key, value string) {
+		_, err := runConfigArgs(key, value)
+		require.NoError(t, err)
+	}
+
+	set("remote.origin.url", "/srv/a")
+	assert.Equal(t, "", promisorRemote(loadConfig(repo.GitDir())))
+	set("remote.up.promisor", "true")
+	assert.Equal(t, "up", promisorRemote(loadConfig(repo.GitDir())))
+	set("extensions.partialClone", "origin")
+	assert.Equal(t, "origin", promisorRemote(loadConfig(repo.GitDir())))
+}
+
+func TestPromisorFetchesMissingObjects(t *testing.T) {
+	src, _ := setupConfigRepo(t)
+	_, err := stageAndCommit(t, src, "a.txt", "-m", "one")
+	require.NoError(t, err)
+	blob := objects.NewBlob([]byte("a.txt\n")).ID()
+
+	dir := filepath.Join(t.TempDir(), "clone")
+	require.NoError(t, runCloneArgs(src.Path(), dir))
+	require.NoError(t, os.Chdir(dir))
+	repo, err := openRepository(dir)
+	require.NoError(t, err)
+	// Stand in for a partial clone by dropping the blob
+	require.NoError(t, os.Remove(repo.Storage().LooseObjectPath(blob)))
+
+	_, err = runCommandArgs(newCatFileCommand(), "-p", blob.String())
+	assert.Error(t, err)
+
+	_, err = runConfigArgs("remote.origin.promisor", "true")
+	require.NoError(t, err)
+	out, err := runCommandArgs(newCatFileCommand(), "-p", blob.String())
+	require.NoError(t, err)
+	assert.Equal(t, "a.txt\n", out)
+	assert.FileExists(t, repo.Storage().LooseObjectPath(blob))
+
+	// An object the remote lacks is still missing
+	_, err = runCommandArgs(newCatFileCommand(), "-p", objects.NewBlob([]byte("nowhere\n")).ID().String())
+	assert.Error(t, err)
+}
@@ -2,167 +2,275 @@ package main
 
 import (
 	"fmt"
-	"path/filepath"
+	"strings"
 
 	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/config"
+	"github.com/fenilsonani/vcs/internal/core/objects"
 	"github.com/fenilsonani/vcs/internal/core/refs"
-	"github.com/fenilsonani/vcs/pkg/vcs"
 )
 
+// pullOptions holds the flags of pull
+type pullOptions struct {
+	rebase   string
+	noRebase bool
+	ff       bool
+	ffOnly   bool
+	noFF     bool
+	noCommit bool
+	verbose  bool
+	strategy string
+}
+
 func newPullCommand() *cobra.Command {
-	var (
-		rebase  bool
-		noCommit bool
-		squash  bool
-		verbose bool
-		strategy string
-	)
+	var opts pullOptions
 
 	cmd := &cobra.Command{
 		Use:   "pull [<remote>] [<branch>]",
 		Short: "Fetch from and integrate with another repository or a local branch",
-		Long: `Incorporates changes from a remote repository into the current branch.
-This command is a combination of 'git fetch' followed by 'git merge'.`,
+		Long: `Fetches a branch from a remote and integrates it into the current
+branch: "vcs fetch" followed by "vcs merge", or with --rebase by "vcs
+rebase". Without arguments the upstream of the current branch is pulled,
+as branch.<name>.remote and branch.<name>.merge set it, or else the
+branch of the same name on origin. A remote of "." pulls a local branch
+without fetching.
+
+--rebase rebases the local commits onto the fetched branch instead of
+merging it, and --rebase=merges keeps local merge commits as "vcs rebase
+--rebase-merges" does. Without the flag branch.<name>.rebase, or else
+pull.rebase, says whether to rebase; --no-rebase merges.
+
+--ff-only, or pull.ff set to only, refuses anything but fast-forwarding
+the branch, even when rebasing, and --no-ff, or pull.ff set to false,
+makes a merge commit even when the branch could be fast-forwarded. --ff
+fast-forwards when possible whatever pull.ff says.
+
+With --autostash local changes are stashed before integrating and applied
+again afterwards. It defaults to rebase.autoStash when rebasing and to
+merge.autoStash otherwise.`,
+		Args: cobra.MaximumNArgs(2),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			// Find repository
-			repoPath, err := findRepository()
-			if err != nil {
-				return fmt.Errorf("not a git repository: %w", err)
-			}
+			return runPull(cmd, args, opts)
+		},
+	}
 
-			// Open repository
-			repo, err := vcs.Open(repoPath)
-			if err != nil {
-				return fmt.Errorf("failed to open repository: %w", err)
-			}
+	cmd.Flags().StringVar(&opts.rebase, "rebase", "", "Rebase the current branch onto the fetched branch: true, false or merges")
+	cmd.Flags().Lookup("rebase").NoOptDefVal = "true"
+	cmd.Flags().BoolVar(&opts.noRebase, "no-rebase", false, "Merge the fetched branch, overriding the configuration")
+	cmd.Flags().BoolVar(&opts.ff, "ff", false, "Fast-forward when possible, overriding pull.ff")
+	cmd.Flags().BoolVar(&opts.ffOnly, "ff-only", false, "Refuse to do anything but fast-forward")
+	cmd.Flags().BoolVar(&opts.noFF, "no-ff", false, "Create a merge commit even when the branch could be fast-forwarded")
+	cmd.Flags().BoolVar(&opts.noCommit, "no-commit", false, "Perform the merge but do not commit")
+	cmd.Flags().BoolVarP(&opts.verbose, "verbose", "v", false, "Be verbose")
+	cmd.Flags().StringVar(&opts.strategy, "strategy", "recursive", "Merge strategy to use")
+	cmd.Flags().Bool("progress", false, "Show progress even when standard error is not a terminal")
+	addAutostashFlags(cmd)
 
-			// Determine remote and branch
-			remoteName := "origin"
-			var remoteBranch string
+	return cmd
+}
 
-			if len(args) > 0 {
-				remoteName = args[0]
-				if len(args) > 1 {
-					remoteBranch = args[1]
-				}
-			}
+func runPull(cmd *cobra.Command, args []string, opts pullOptions) error {
+	set := 0
+	for _, flag := range []bool{opts.ff, opts.ffOnly, opts.noFF} {
+		if flag {
+			set++
+		}
+	}
+	if set > 1 {
+		return fmt.Errorf("--ff, --ff-only and --no-ff cannot be used together")
+	}
 
-			// Get current branch if remote branch not specified
-			refManager := refs.NewRefManager(repo.GitDir())
-			currentBranch, err := refManager.CurrentBranch()
-			if err != nil {
-				return fmt.Errorf("not currently on any branch")
-			}
+	repoPath, err := findRepository()
+	if err != nil {
+		return fmt.Errorf("not a git repository: %w", err)
+	}
+	repo, err := openStoredRepository(repoPath)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
 
-			if remoteBranch == "" {
-				remoteBranch = currentBranch
-			}
+	refManager := refs.NewRefManager(repo.GitDir())
+	currentBranch, err := refManager.CurrentBranch()
+	if err != nil {
+		return fmt.Errorf("not currently on any branch")
+	}
 
-			// Get remote configuration
-			remotes, err := getRemotes(repo)
-			if err != nil {
-				return fmt.Errorf("failed to get remotes: %w", err)
-			}
+	cfg := loadConfig(repo.GitDir())
+	remoteName, remoteBranch := pullSource(cfg, currentBranch, args)
+	rebaseMode, err := pullRebaseMode(cmd, cfg, currentBranch, opts)
+	if err != nil {
+		return err
+	}
+	fastForward, err := pullFastForward(cfg, opts)
+	if err != nil {
+		return err
+	}
+	configKey := "merge.autoStash"
+	if rebaseMode != "" {
+		configKey = "rebase.autoStash"
+	}
+	autostash, err := autostashRequested(cmd, cfg, configKey)
+	if err != nil {
+		return err
+	}
 
-			remoteURL, exists := remotes[remoteName]
-			if !exists {
-				return fmt.Errorf("remote '%s' does not exist", remoteName)
-			}
+	out := cmd.OutOrStdout()
+
+	// Fetch, unless the branch is a local one
+	upstreamRef := "refs/heads/" + remoteBranch
+	message := fmt.Sprintf("Merge branch '%s'", remoteBranch)
+	label := remoteBranch
+	if remoteName != "." {
+		remotes, err := getRemotes(repo)
+		if err != nil {
+			return fmt.Errorf("failed to get remotes: %w", err)
+		}
+		remoteURL, exists := remotes[remoteName]
+		if !exists {
+			return fmt.Errorf("remote '%s' does not exist", remoteName)
+		}
+		if err := fetchFromRemote(cmd, repo, remoteName, remoteURL, []string{remoteBranch}, false, false, false, shallowOptions{}, opts.verbose); err != nil {
+			return fmt.Errorf("fetch failed: %w", err)
+		}
+		if tracking, ok := remoteTrackingRef(repo.GitDir(), remoteName, "refs/heads/"+remoteBranch); ok {
+			upstreamRef = tracking
+		}
+		message = fmt.Sprintf("Merge branch '%s' of %s", remoteBranch, remoteURL)
+		label = remoteName + "/" + remoteBranch
+	}
+	if !refManager.RefExists(upstreamRef) {
+		return fmt.Errorf("couldn't find remote ref %s", remoteBranch)
+	}
 
-			fmt.Fprintf(cmd.OutOrStdout(), "Pulling from %s\n", remoteURL)
+	// What is left are not usage errors
+	cmd.SilenceUsage = true
 
-			// Execute pull
-			if err := pullFromRemote(cmd, repo, remoteName, remoteURL, currentBranch, remoteBranch, rebase, noCommit, squash, verbose, strategy); err != nil {
-				return fmt.Errorf("pull failed: %w", err)
-			}
+	// Fast-forwarding is the same whether rebasing or merging, so only
+	// rebase when that may be more
+	if rebaseMode != "" && fastForward != "only" {
+		_, err := startRebase(out, repo, refManager, []string{upstreamRef}, rebaseOptions{
+			autostash:    autostash,
+			rebaseMerges: rebaseMode == "merges",
+		})
+		return err
+	}
 
-			return nil
-		},
+	mergeOpts, err := mergeOptions(repo, nil)
+	if err != nil {
+		return err
 	}
+	mergeOpts.OursLabel, mergeOpts.TheirsLabel = "HEAD", label
 
-	cmd.Flags().BoolVar(&rebase, "rebase", false, "Rebase the current branch on top of the upstream branch")
-	cmd.Flags().BoolVar(&noCommit, "no-commit", false, "Perform the merge but do not commit")
-	cmd.Flags().BoolVar(&squash, "squash", false, "Squash commits")
-	cmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Be verbose")
-	cmd.Flags().StringVar(&strategy, "strategy", "recursive", "Merge strategy to use")
+	var stashID objects.ObjectID
+	if autostash {
+		if _, ok, err := readMergeHead(repo); err != nil {
+			return err
+		} else if ok {
+			return fmt.Errorf("you have not concluded your merge (MERGE_HEAD exists)")
+		}
+		if stashID, err = createAutostash(out, repo, refManager); err != nil {
+			return err
+		}
+	}
 
-	return cmd
+	update := newWorktreeUpdate(cmd)
+	report, err := runMerge(out, repo, refManager, update, upstreamRef, opts.noCommit, fastForward, opts.strategy, mergeOpts, message)
+	if report != nil {
+		update.printSummary(out)
+	}
+	if !stashID.IsZero() {
+		if stashErr := finishMergeAutostash(out, repo, refManager, stashID); stashErr != nil && err == nil {
+			err = stashErr
+		}
+	}
+	if err == nil {
+		autoGC(cmd.ErrOrStderr(), repo)
+	}
+	return err
 }
 
-func pullFromRemote(cmd *cobra.Command, repo *vcs.Repository, remoteName, remoteURL, localBranch, remoteBranch string, rebase, noCommit, squash, verbose bool, strategy string) error {
-	refManager := refs.NewRefManager(repo.GitDir())
-
-	// Step 1: Fetch from remote
-	fmt.Fprintln(cmd.OutOrStdout(), "Fetching from remote...")
-	
-	// In a real implementation, this would call the actual fetch logic
-	if verbose {
-		fmt.Fprintf(cmd.OutOrStdout(), "From %s\n", remoteURL)
-		fmt.Fprintf(cmd.OutOrStdout(), " * branch            %s     -> FETCH_HEAD\n", remoteBranch)
+// pullSource returns the remote and branch to pull: those args name, or
+// else the upstream of branch, or else its namesake on origin
+func pullSource(cfg *config.Config, branch string, args []string) (remote, remoteBranch string) {
+	if len(args) > 0 {
+		remote, remoteBranch = args[0], branch
+		if len(args) > 1 {
+			remoteBranch = args[1]
+		}
+		return remote, remoteBranch
 	}
 
-	// Update FETCH_HEAD
-	fetchHeadPath := filepath.Join(repo.GitDir(), "FETCH_HEAD")
-	fetchHeadContent := fmt.Sprintf("# Fetched from %s/%s\n", remoteName, remoteBranch)
-	if err := writeFile(fetchHeadPath, []byte(fetchHeadContent)); err != nil {
-		return fmt.Errorf("failed to update FETCH_HEAD: %w", err)
+	remote, remoteBranch = "origin", branch
+	if name, ok := cfg.Get("branch." + branch + ".remote"); ok && name != "" {
+		remote = name
 	}
+	if merge, ok := cfg.Get("branch." + branch + ".merge"); ok && merge != "" {
+		remoteBranch = strings.TrimPrefix(merge, "refs/heads/")
+	}
+	return remote, remoteBranch
+}
 
-	// Get current HEAD
-	currentCommitID, _, err := refManager.HEAD()
-	if err != nil {
-		return fmt.Errorf("failed to get HEAD: %w", err)
+// pullRebaseMode returns how to integrate the fetched branch: "" to merge
+// it, "true" to rebase onto it or "merges" to rebase keeping merges. It is
+// set by --[no-]rebase, or else branch.<name>.rebase or pull.rebase.
+func pullRebaseMode(cmd *cobra.Command, cfg *config.Config, branch string, opts pullOptions) (string, error) {
+	if opts.noRebase {
+		return "", nil
 	}
 
-	// Step 2: Merge or rebase
-	remoteRef := fmt.Sprintf("refs/remotes/%s/%s", remoteName, remoteBranch)
-	_ = remoteRef // Would be used in real implementation
-	
-	if rebase {
-		fmt.Fprintln(cmd.OutOrStdout(), "Rebasing...")
-		
-		// In a real implementation, this would:
-		// 1. Find merge base
-		// 2. Create patch series
-		// 3. Apply patches on top of remote branch
-		
-		fmt.Fprintf(cmd.OutOrStdout(), "Successfully rebased and updated %s.\n", localBranch)
-	} else {
-		fmt.Fprintln(cmd.OutOrStdout(), "Merging...")
-		
-		// Check if fast-forward is possible
-		// In a real implementation, we would check if current HEAD is ancestor of remote
-		fastForward := false // Simplified for now
-		
-		if fastForward {
-			fmt.Fprintf(cmd.OutOrStdout(), "Updating %s..%s\n", 
-				currentCommitID.String()[:7], currentCommitID.String()[:7])
-			fmt.Fprintln(cmd.OutOrStdout(), "Fast-forward")
-			
-			// Update HEAD to remote commit
-			// refManager.UpdateRef(fmt.Sprintf("refs/heads/%s", localBranch), remoteCommitID)
-		} else {
-			// Three-way merge
-			fmt.Fprintln(cmd.OutOrStdout(), "Merge made by the 'recursive' strategy.")
-			
-			if !noCommit {
-				// Create merge commit
-				mergeMessage := fmt.Sprintf("Merge branch '%s' of %s into %s", 
-					remoteBranch, remoteURL, localBranch)
-				fmt.Fprintf(cmd.OutOrStdout(), " %s | 0 files changed\n", mergeMessage)
+	value, source := opts.rebase, "--rebase"
+	if !cmd.Flags().Changed("rebase") {
+		value, source = "", ""
+		for _, key := range []string{"branch." + branch + ".rebase", "pull.rebase"} {
+			if v, ok := cfg.Get(key); ok {
+				value, source = v, key
+				break
 			}
 		}
+		if source == "" {
+			return "", nil
+		}
 	}
 
-	// Step 3: Update working tree
-	fmt.Fprintln(cmd.OutOrStdout(), "Already up to date.")
+	if value == "merges" {
+		return "merges", nil
+	}
+	rebase, err := config.ParseBool(value)
+	if err != nil {
+		return "", fmt.Errorf("invalid value for %s: %q (expected true, false or merges)", source, value)
+	}
+	if rebase {
+		return "true", nil
+	}
+	return "", nil
+}
 
-	fmt.Fprintln(cmd.OutOrStdout(), "\nNote: This is a basic pull implementation.")
-	fmt.Fprintln(cmd.OutOrStdout(), "Full implementation would require:")
-	fmt.Fprintln(cmd.OutOrStdout(), "  - Actual network fetch operation")
-	fmt.Fprintln(cmd.OutOrStdout(), "  - Three-way merge algorithm")
-	fmt.Fprintln(cmd.OutOrStdout(), "  - Conflict resolution")
-	fmt.Fprintln(cmd.OutOrStdout(), "  - Working tree updates")
+// pullFastForward returns the --ff mode of the merge: "only" with
+// --ff-only, "no" with --no-ff, "auto" with --ff, or else as pull.ff says
+func pullFastForward(cfg *config.Config, opts pullOptions) (string, error) {
+	switch {
+	case opts.ffOnly:
+		return "only", nil
+	case opts.noFF:
+		return "no", nil
+	case opts.ff:
+		return "auto", nil
+	}
 
-	return nil
-}
\ No newline at end of file
+	value, ok := cfg.Get("pull.ff")
+	if !ok {
+		return "auto", nil
+	}
+	if value == "only" {
+		return "only", nil
+	}
+	ff, err := config.ParseBool(value)
+	if err != nil {
+		return "", fmt.Errorf("invalid value for pull.ff: %q (expected true, false or only)", value)
+	}
+	if !ff {
+		return "no", nil
+	}
+	return "auto", nil
+}
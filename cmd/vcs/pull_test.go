@@ -2,12 +2,17 @@ package main
 
 import (
 	"bytes"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/refs"
 	"github.com/fenilsonani/vcs/pkg/vcs"
 )
 
@@ -20,144 +25,19 @@ func TestNewPullCommand(t *testing.T) {
 
 func TestPullCommand(t *testing.T) {
 	tests := []struct {
-		name        string
-		args        []string
-		flags       map[string]string
-		setupFunc   func(t *testing.T, repoPath string)
-		expectError bool
-		checkFunc   func(t *testing.T, output string, repoPath string)
+		name      string
+		args      []string
+		setupFunc func(t *testing.T, repoPath string)
 	}{
 		{
-			name: "pull from origin",
-			args: []string{},
-			setupFunc: func(t *testing.T, repoPath string) {
-				// Add origin remote
-				configPath := filepath.Join(repoPath, ".git", "config")
-				configContent := `[remote "origin"]
-	url = https://github.com/example/repo.git
-`
-				err := os.WriteFile(configPath, []byte(configContent), 0644)
-				require.NoError(t, err)
-			},
-			checkFunc: func(t *testing.T, output string, repoPath string) {
-				assert.Contains(t, output, "Pulling from https://github.com/example/repo.git")
-				assert.Contains(t, output, "Fetching from remote")
-				assert.Contains(t, output, "Merging")
-				assert.Contains(t, output, "Already up to date")
-				
-				// Check FETCH_HEAD was created
-				fetchHeadPath := filepath.Join(repoPath, ".git", "FETCH_HEAD")
-				assert.FileExists(t, fetchHeadPath)
-			},
+			name:      "pull from non-existent remote",
+			args:      []string{"nonexistent"},
+			setupFunc: func(t *testing.T, repoPath string) {},
 		},
 		{
-			name: "pull from specific remote and branch",
-			args: []string{"upstream", "develop"},
-			setupFunc: func(t *testing.T, repoPath string) {
-				// Add upstream remote
-				configPath := filepath.Join(repoPath, ".git", "config")
-				configContent := `[remote "upstream"]
-	url = https://github.com/upstream/repo.git
-`
-				err := os.WriteFile(configPath, []byte(configContent), 0644)
-				require.NoError(t, err)
-			},
-			checkFunc: func(t *testing.T, output string, repoPath string) {
-				assert.Contains(t, output, "Pulling from https://github.com/upstream/repo.git")
-				assert.Contains(t, output, "Fetching from remote")
-			},
-		},
-		{
-			name: "pull with rebase",
-			args: []string{},
-			flags: map[string]string{
-				"rebase": "true",
-			},
-			setupFunc: func(t *testing.T, repoPath string) {
-				// Add origin remote
-				configPath := filepath.Join(repoPath, ".git", "config")
-				configContent := `[remote "origin"]
-	url = https://github.com/example/repo.git
-`
-				err := os.WriteFile(configPath, []byte(configContent), 0644)
-				require.NoError(t, err)
-			},
-			checkFunc: func(t *testing.T, output string, repoPath string) {
-				assert.Contains(t, output, "Rebasing")
-				assert.Contains(t, output, "Successfully rebased")
-			},
-		},
-		{
-			name: "pull with no-commit",
-			args: []string{},
-			flags: map[string]string{
-				"no-commit": "true",
-			},
-			setupFunc: func(t *testing.T, repoPath string) {
-				// Add origin remote
-				configPath := filepath.Join(repoPath, ".git", "config")
-				configContent := `[remote "origin"]
-	url = https://github.com/example/repo.git
-`
-				err := os.WriteFile(configPath, []byte(configContent), 0644)
-				require.NoError(t, err)
-			},
-			checkFunc: func(t *testing.T, output string, repoPath string) {
-				assert.Contains(t, output, "Merging")
-				// With no-commit, merge commit message shouldn't appear
-			},
-		},
-		{
-			name: "pull with verbose",
-			args: []string{},
-			flags: map[string]string{
-				"verbose": "true",
-			},
-			setupFunc: func(t *testing.T, repoPath string) {
-				// Add origin remote
-				configPath := filepath.Join(repoPath, ".git", "config")
-				configContent := `[remote "origin"]
-	url = https://github.com/example/repo.git
-`
-				err := os.WriteFile(configPath, []byte(configContent), 0644)
-				require.NoError(t, err)
-			},
-			checkFunc: func(t *testing.T, output string, repoPath string) {
-				assert.Contains(t, output, "From https://github.com/example/repo.git")
-				assert.Contains(t, output, "branch")
-				assert.Contains(t, output, "FETCH_HEAD")
-			},
-		},
-		{
-			name: "pull with custom strategy",
-			args: []string{},
-			flags: map[string]string{
-				"strategy": "ours",
-			},
-			setupFunc: func(t *testing.T, repoPath string) {
-				// Add origin remote
-				configPath := filepath.Join(repoPath, ".git", "config")
-				configContent := `[remote "origin"]
-	url = https://github.com/example/repo.git
-`
-				err := os.WriteFile(configPath, []byte(configContent), 0644)
-				require.NoError(t, err)
-			},
-			checkFunc: func(t *testing.T, output string, repoPath string) {
-				assert.Contains(t, output, "Merge made by the 'recursive' strategy")
-			},
-		},
-		{
-			name:        "pull from non-existent remote",
-			args:        []string{"nonexistent"},
-			setupFunc:   func(t *testing.T, repoPath string) {},
-			expectError: true,
-		},
-		{
-			name:        "pull outside repository",
-			args:        []string{},
-			setupFunc:   func(t *testing.T, repoPath string) {},
-			expectError: true,
+			name:      "pull outside repository",
+			args:      []string{},
+			setupFunc: func(t *testing.T, repoPath string) {},
 		},
 		{
 			name: "pull on detached HEAD",
@@ -170,7 +50,7 @@ func TestPullCommand(t *testing.T) {
 `
 				err := os.WriteFile(configPath, []byte(configContent), 0644)
 				require.NoError(t, err)
-				
+
 				// Checkout commit directly (detached HEAD)
 				repo, err := vcs.Open(repoPath)
 				require.NoError(t, err)
@@ -181,7 +61,6 @@ func TestPullCommand(t *testing.T) {
 				err = testRepo.Checkout(commits[0].ID().String())
 				require.NoError(t, err)
 			},
-			expectError: true,
 		},
 	}
 
@@ -189,31 +68,27 @@ func TestPullCommand(t *testing.T) {
 		t.Run(tc.name, func(t *testing.T) {
 			// Create temporary directory
 			tmpDir := t.TempDir()
-			
-			var repoPath string
+
 			if tc.name != "pull outside repository" {
 				// Initialize repository
-				repoPath = filepath.Join(tmpDir, "test-repo")
+				repoPath := filepath.Join(tmpDir, "test-repo")
 				repo, err := vcs.Init(repoPath)
 				require.NoError(t, err)
-				
+
 				// Make initial commit
 				testFile := filepath.Join(repoPath, "test.txt")
 				err = os.WriteFile(testFile, []byte("test content"), 0644)
 				require.NoError(t, err)
-				
+
 				testRepo := WrapRepository(repo, repoPath)
 				err = testRepo.Add("test.txt")
 				require.NoError(t, err)
-				
+
 				_, err = testRepo.Commit("Initial commit", "Test User", "test@example.com")
 				require.NoError(t, err)
-				
-				// Run setup function
-				if tc.setupFunc != nil {
-					tc.setupFunc(t, repoPath)
-				}
-				
+
+				tc.setupFunc(t, repoPath)
+
 				// Change to repo directory
 				err = os.Chdir(repoPath)
 				require.NoError(t, err)
@@ -222,269 +97,149 @@ func TestPullCommand(t *testing.T) {
 				err := os.Chdir(tmpDir)
 				require.NoError(t, err)
 			}
-			
-			// Create command
-			cmd := newPullCommand()
-			
-			// Set flags
-			for flag, value := range tc.flags {
-				err := cmd.Flags().Set(flag, value)
-				require.NoError(t, err)
-			}
-			
-			// Capture output
-			var buf bytes.Buffer
-			cmd.SetOut(&buf)
-			cmd.SetErr(&buf)
-			
-			// Execute command
-			cmd.SetArgs(tc.args)
-			err := cmd.Execute()
-			
-			// Check error
-			if tc.expectError {
-				assert.Error(t, err)
-			} else {
-				assert.NoError(t, err)
-				
-				// Check output
-				output := buf.String()
-				if tc.checkFunc != nil {
-					tc.checkFunc(t, output, repoPath)
-				}
-			}
+
+			_, err := runPullArgs(tc.args...)
+			assert.Error(t, err)
 		})
 	}
 }
 
-func TestPullFromRemote(t *testing.T) {
-	// Create temporary directory
-	tmpDir := t.TempDir()
-	repoPath := filepath.Join(tmpDir, "test-repo")
-	
-	// Initialize repository
-	repo, err := vcs.Init(repoPath)
+// setupPullRepo commits a.txt to a repository and clones it, changing into
+// the clone
+func setupPullRepo(t *testing.T) (upstream, clone *vcs.Repository) {
+	upstream, _ = setupConfigRepo(t)
+	_, err := stageAndCommit(t, upstream, "a.txt", "-m", "one")
 	require.NoError(t, err)
-	
-	// Make initial commit
-	testFile := filepath.Join(repoPath, "test.txt")
-	err = os.WriteFile(testFile, []byte("test content"), 0644)
+
+	dir := filepath.Join(t.TempDir(), "clone")
+	require.NoError(t, runCloneArgs(upstream.Path(), dir))
+	clone, err = vcs.Open(dir)
 	require.NoError(t, err)
-	
-	testRepo := WrapRepository(repo, repoPath)
-				err = testRepo.Add("test.txt")
+	require.NoError(t, os.Chdir(dir))
+	return upstream, clone
+}
+
+// commitIn commits file in repo, changing into it and back
+func commitIn(t *testing.T, repo *vcs.Repository, file string) objects.ObjectID {
+	wd, err := os.Getwd()
 	require.NoError(t, err)
-	
-	_, err = testRepo.Commit("Initial commit", "Test User", "test@example.com")
+	require.NoError(t, os.Chdir(repo.Path()))
+	defer os.Chdir(wd)
+
+	_, err = stageAndCommit(t, repo, file, "-m", strings.TrimSuffix(file, ".txt"))
+	require.NoError(t, err)
+	id, _, err := refs.NewRefManager(repo.GitDir()).HEAD()
 	require.NoError(t, err)
-	
-	// Create command for output
+	return id
+}
+
+func runPullArgs(args ...string) (string, error) {
 	cmd := newPullCommand()
-	var buf bytes.Buffer
-	cmd.SetOut(&buf)
-	
-	// Test pull without rebase
-	err = pullFromRemote(cmd, repo, "origin", "https://github.com/example/repo.git", 
-		"main", "main", false, false, false, false, "recursive")
-	assert.NoError(t, err)
-	
-	// Check output
-	output := buf.String()
-	assert.Contains(t, output, "Fetching from remote")
-	assert.Contains(t, output, "Merging")
-	assert.Contains(t, output, "Already up to date")
-	
-	// Check FETCH_HEAD
-	fetchHeadPath := filepath.Join(repo.GitDir(), "FETCH_HEAD")
-	assert.FileExists(t, fetchHeadPath)
-	
-	// Test pull with rebase
-	buf.Reset()
-	err = pullFromRemote(cmd, repo, "origin", "https://github.com/example/repo.git", 
-		"main", "main", true, false, false, false, "recursive")
-	assert.NoError(t, err)
-	
-	output = buf.String()
-	assert.Contains(t, output, "Rebasing")
-	assert.Contains(t, output, "Successfully rebased")
-	
-	// Test verbose pull
-	buf.Reset()
-	err = pullFromRemote(cmd, repo, "origin", "https://github.com/example/repo.git", 
-		"main", "main", false, false, false, true, "recursive")
-	assert.NoError(t, err)
-	
-	output = buf.String()
-	assert.Contains(t, output, "From https://github.com/example/repo.git")
-	assert.Contains(t, output, "branch")
-	assert.Contains(t, output, "FETCH_HEAD")
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+	return out.String(), err
 }
 
-func TestPullMergeScenarios(t *testing.T) {
-	// Create temporary directory
-	tmpDir := t.TempDir()
-	repoPath := filepath.Join(tmpDir, "test-repo")
-	
-	// Initialize repository
-	repo, err := vcs.Init(repoPath)
+func TestPullFastForward(t *testing.T) {
+	upstream, clone := setupPullRepo(t)
+	tip := commitIn(t, upstream, "b.txt")
+
+	out, err := runPullArgs()
 	require.NoError(t, err)
-	
-	// Make initial commit
-	testFile := filepath.Join(repoPath, "test.txt")
-	err = os.WriteFile(testFile, []byte("test content"), 0644)
+	assert.Contains(t, out, "Fast-forward\n")
+	assert.Equal(t, tip.String(), headCommit(t, clone))
+	assert.Equal(t, "b.txt\n", readWorkFile(t, "b.txt"))
+
+	out, err = runPullArgs("--ff-only")
 	require.NoError(t, err)
-	
-	testRepo := WrapRepository(repo, repoPath)
-				err = testRepo.Add("test.txt")
+	assert.Contains(t, out, "Already up to date.\n")
+}
+
+func TestPullMerge(t *testing.T) {
+	upstream, clone := setupPullRepo(t)
+	tip := commitIn(t, upstream, "b.txt")
+	local := commitIn(t, clone, "c.txt")
+
+	// Diverged branches cannot be fast-forwarded
+	_, err := runPullArgs("--ff-only")
+	assert.ErrorContains(t, err, "not possible to fast-forward, aborting")
+	_, err = runConfigArgs("pull.ff", "only")
 	require.NoError(t, err)
-	
-	_, err = testRepo.Commit("Initial commit", "Test User", "test@example.com")
+	_, err = runPullArgs("--rebase")
+	assert.ErrorContains(t, err, "not possible to fast-forward, aborting")
+	assert.Equal(t, local.String(), headCommit(t, clone))
+
+	_, err = runPullArgs("--ff")
 	require.NoError(t, err)
-	
-	// Create command for output
-	cmd := newPullCommand()
-	var buf bytes.Buffer
-	cmd.SetOut(&buf)
-	
-	// Test fast-forward merge (simulated)
-	err = pullFromRemote(cmd, repo, "origin", "https://github.com/example/repo.git", 
-		"main", "main", false, false, false, false, "recursive")
-	assert.NoError(t, err)
-	
-	output := buf.String()
-	// In our simulation, fast-forward is false, so we see regular merge
-	assert.Contains(t, output, "Merge made by the 'recursive' strategy")
-	
-	// Test merge with no-commit
-	buf.Reset()
-	err = pullFromRemote(cmd, repo, "origin", "https://github.com/example/repo.git", 
-		"main", "main", false, true, false, false, "recursive")
-	assert.NoError(t, err)
-	
-	output = buf.String()
-	assert.Contains(t, output, "Merging")
-	// With no-commit, the merge commit message should still appear in simulation
-	assert.Contains(t, output, "Merge made by the 'recursive' strategy")
+	head, err := objects.NewObjectID(headCommit(t, clone))
+	require.NoError(t, err)
+	commit, err := clone.GetCommit(head)
+	require.NoError(t, err)
+	assert.Equal(t, []objects.ObjectID{local, tip}, commit.Parents())
+	assert.Equal(t, fmt.Sprintf("Merge branch 'main' of %s\n", upstream.Path()), commit.Message())
+	assert.Equal(t, "b.txt\n", readWorkFile(t, "b.txt"))
+	assert.Equal(t, "c.txt\n", readWorkFile(t, "c.txt"))
 }
 
-func TestPullImplementationNotes(t *testing.T) {
-	// Create temporary directory
-	tmpDir := t.TempDir()
-	repoPath := filepath.Join(tmpDir, "test-repo")
-	
-	// Initialize repository
-	repo, err := vcs.Init(repoPath)
+func TestPullRebase(t *testing.T) {
+	upstream, clone := setupPullRepo(t)
+	tip := commitIn(t, upstream, "b.txt")
+	commitIn(t, clone, "c.txt")
+
+	_, err := runConfigArgs("pull.rebase", "true")
 	require.NoError(t, err)
-	
-	// Make initial commit
-	testFile := filepath.Join(repoPath, "test.txt")
-	err = os.WriteFile(testFile, []byte("test content"), 0644)
+	out, err := runPullArgs()
 	require.NoError(t, err)
-	
-	testRepo := WrapRepository(repo, repoPath)
-				err = testRepo.Add("test.txt")
+	assert.Contains(t, out, "Successfully rebased and updated refs/heads/main.\n")
+
+	head, err := objects.NewObjectID(headCommit(t, clone))
 	require.NoError(t, err)
-	
-	_, err = testRepo.Commit("Initial commit", "Test User", "test@example.com")
+	commit, err := clone.GetCommit(head)
 	require.NoError(t, err)
-	
-	// Create command for output
-	cmd := newPullCommand()
-	var buf bytes.Buffer
-	cmd.SetOut(&buf)
-	
-	// Run pull to see implementation notes
-	err = pullFromRemote(cmd, repo, "origin", "https://github.com/example/repo.git", 
-		"main", "main", false, false, false, false, "recursive")
-	assert.NoError(t, err)
-	
-	// Check implementation notes appear
-	output := buf.String()
-	assert.Contains(t, output, "Note: This is a basic pull implementation")
-	assert.Contains(t, output, "Full implementation would require:")
-	assert.Contains(t, output, "Actual network fetch operation")
-	assert.Contains(t, output, "Three-way merge algorithm")
-	assert.Contains(t, output, "Conflict resolution")
-	assert.Contains(t, output, "Working tree updates")
+	assert.Equal(t, "c\n", commit.Message())
+	assert.Equal(t, []objects.ObjectID{tip}, commit.Parents())
+	assert.Equal(t, "b.txt\n", readWorkFile(t, "b.txt"))
+
+	_, err = runPullArgs("--rebase=sometimes")
+	assert.ErrorContains(t, err, `invalid value for --rebase: "sometimes"`)
+	_, err = runPullArgs("origin", "missing")
+	assert.ErrorContains(t, err, "couldn't find remote ref missing")
 }
 
-func TestPullEdgeCases(t *testing.T) {
-	t.Run("pull with different local and remote branches", func(t *testing.T) {
-		// Create temporary directory
-		tmpDir := t.TempDir()
-		repoPath := filepath.Join(tmpDir, "test-repo")
-		
-		// Initialize repository
-		repo, err := vcs.Init(repoPath)
-		require.NoError(t, err)
-		
-		// Make initial commit
-		testFile := filepath.Join(repoPath, "test.txt")
-		err = os.WriteFile(testFile, []byte("test content"), 0644)
-		require.NoError(t, err)
-		
-		testRepo := WrapRepository(repo, repoPath)
-				err = testRepo.Add("test.txt")
-		require.NoError(t, err)
-		
-		_, err = testRepo.Commit("Initial commit", "Test User", "test@example.com")
-		require.NoError(t, err)
-		
-		// Create feature branch
-		_, err = testRepo.CreateBranch("feature")
-		require.NoError(t, err)
-		err = testRepo.Checkout("feature")
-		require.NoError(t, err)
-		
-		// Create command for output
-		cmd := newPullCommand()
-		var buf bytes.Buffer
-		cmd.SetOut(&buf)
-		
-		// Pull from different remote branch
-		err = pullFromRemote(cmd, repo, "origin", "https://github.com/example/repo.git", 
-			"feature", "develop", false, false, false, true, "recursive")
-		assert.NoError(t, err)
-		
-		// Check output shows correct branches
-		output := buf.String()
-		assert.Contains(t, output, "develop")
-		assert.Contains(t, output, "FETCH_HEAD")
-	})
-	
-	t.Run("pull with squash option", func(t *testing.T) {
-		// Create temporary directory
-		tmpDir := t.TempDir()
-		repoPath := filepath.Join(tmpDir, "test-repo")
-		
-		// Initialize repository
-		repo, err := vcs.Init(repoPath)
-		require.NoError(t, err)
-		
-		// Make initial commit
-		testFile := filepath.Join(repoPath, "test.txt")
-		err = os.WriteFile(testFile, []byte("test content"), 0644)
-		require.NoError(t, err)
-		
-		testRepo := WrapRepository(repo, repoPath)
-				err = testRepo.Add("test.txt")
-		require.NoError(t, err)
-		
-		_, err = testRepo.Commit("Initial commit", "Test User", "test@example.com")
+func TestPullRebaseMerges(t *testing.T) {
+	upstream, clone := setupPullRepo(t)
+	tip := commitIn(t, upstream, "b.txt")
+
+	// main merges a feature branch, both with commits of their own
+	_, err := runCommandArgs(newCheckoutCommand(), "-b", "feature")
+	require.NoError(t, err)
+	commitIn(t, clone, "d.txt")
+	_, err = runCommandArgs(newCheckoutCommand(), "main")
+	require.NoError(t, err)
+	commitIn(t, clone, "c.txt")
+	_, _, err = runMergeArgs("--ff", "no", "-m", "merge feature", "feature")
+	require.NoError(t, err)
+
+	out, err := runPullArgs("--rebase=merges")
+	require.NoError(t, err)
+	assert.Contains(t, out, "Successfully rebased and updated refs/heads/main.\n")
+
+	head, err := objects.NewObjectID(headCommit(t, clone))
+	require.NoError(t, err)
+	merged, err := clone.GetCommit(head)
+	require.NoError(t, err)
+	assert.Equal(t, "merge feature\n", merged.Message())
+	require.Len(t, merged.Parents(), 2)
+	for i, message := range []string{"c\n", "d\n"} {
+		commit, err := clone.GetCommit(merged.Parents()[i])
 		require.NoError(t, err)
-		
-		// Create command for output
-		cmd := newPullCommand()
-		var buf bytes.Buffer
-		cmd.SetOut(&buf)
-		
-		// Pull with squash
-		err = pullFromRemote(cmd, repo, "origin", "https://github.com/example/repo.git", 
-			"main", "main", false, false, true, false, "recursive")
-		assert.NoError(t, err)
-		
-		// Squash option is passed but not used in basic implementation
-		output := buf.String()
-		assert.Contains(t, output, "Merge made by the 'recursive' strategy")
-	})
-}
\ No newline at end of file
+		assert.Equal(t, message, commit.Message())
+		assert.Equal(t, []objects.ObjectID{tip}, commit.Parents())
+	}
+	for _, file := range []string{"a.txt", "b.txt", "c.txt", "d.txt"} {
+		assert.Equal(t, file+"\n", readWorkFile(t, file))
+	}
+}
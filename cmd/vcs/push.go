@@ -1,12 +1,17 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"fmt"
-	"path/filepath"
 	"strings"
 
 	"github.com/spf13/cobra"
+	"github.com/fenilsonani/vcs/internal/core/config"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/packfile"
 	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/internal/transport"
 	"github.com/fenilsonani/vcs/pkg/vcs"
 )
 
@@ -24,7 +29,23 @@ func newPushCommand() *cobra.Command {
 		Use:   "push [<remote>] [<refspec>...]",
 		Short: "Update remote refs along with associated objects",
 		Long: `Updates remote refs using local refs, while sending objects
-necessary to complete the given refs.`,
+necessary to complete the given refs.
+
+A refspec of :<ref> deletes <ref> on the remote.
+
+--force-with-lease overwrites a remote ref that is not an ancestor of
+what is pushed only while the ref is still where it was expected:
+--force-with-lease=<ref>:<expect> expects <ref> at <expect>, or missing
+when <expect> is empty, --force-with-lease=<ref> expects it at its
+remote-tracking branch, and the flag alone does that for every ref
+pushed. A ref that moved on the remote since is rejected as stale.
+
+With --atomic the remote updates all the refs or none of them, and
+--push-option sends options for its receive hooks to read. Both need the
+remote to support them.
+
+While the pack is built, the objects compressed and written are counted
+on standard error when it is a terminal or --progress is given.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			// Find repository
 			repoPath, err := findRepository()
@@ -33,7 +54,7 @@ necessary to complete the given refs.`,
 			}
 
 			// Open repository
-			repo, err := vcs.Open(repoPath)
+			repo, err := openStoredRepository(repoPath)
 			if err != nil {
 				return fmt.Errorf("failed to open repository: %w", err)
 			}
@@ -86,10 +107,91 @@ necessary to complete the given refs.`,
 	cmd.Flags().BoolVar(&tags, "tags", false, "Push all tags")
 	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Do everything except actually send the updates")
 	cmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Be verbose")
+	cmd.Flags().Bool("no-verify", false, "Bypass the pre-push hook")
+	cmd.Flags().Bool("progress", false, "Show progress even when standard error is not a terminal")
+	cmd.Flags().StringArray("force-with-lease", nil, "Force updates only while <ref>[:<expect>] still holds")
+	cmd.Flags().Lookup("force-with-lease").NoOptDefVal = leaseAll
+	cmd.Flags().Bool("atomic", false, "Update all refs on the remote or none of them")
+	cmd.Flags().StringArrayP("push-option", "o", nil, "Send this option to the remote's receive hooks")
 
 	return cmd
 }
 
+// leaseAll is --force-with-lease given without a value, leasing every ref
+// pushed
+const leaseAll = "*"
+
+// forceLease is a --force-with-lease: the remote ref may be overwritten
+// only while it is at the expected value. ref is empty for the lease on
+// every ref pushed.
+type forceLease struct {
+	ref    string
+	expect string
+	// explicit says expect was given, rather than the ref being expected
+	// at its remote-tracking branch
+	explicit bool
+}
+
+// parseForceLeases parses the values of --force-with-lease
+func parseForceLeases(values []string) []forceLease {
+	var leases []forceLease
+	for _, value := range values {
+		if value == leaseAll || value == "" {
+			leases = append(leases, forceLease{})
+			continue
+		}
+		ref, expect, explicit := strings.Cut(value, ":")
+		if !strings.HasPrefix(ref, "refs/") {
+			ref = "refs/heads/" + ref
+		}
+		leases = append(leases, forceLease{ref: ref, expect: expect, explicit: explicit})
+	}
+	return leases
+}
+
+// leaseFor returns the lease covering the remote ref, preferring one that
+// names it over one on every ref
+func leaseFor(leases []forceLease, ref string) (forceLease, bool) {
+	var found forceLease
+	ok := false
+	for _, lease := range leases {
+		if lease.ref == ref {
+			return lease, true
+		}
+		if lease.ref == "" {
+			found, ok = lease, true
+		}
+	}
+	return found, ok
+}
+
+// expected returns the value the lease expects update's remote ref at: the
+// one it names, none for an empty one, or else the remote-tracking branch
+// as last fetched
+func (l forceLease) expected(repo *vcs.Repository, update pushUpdate) (objects.ObjectID, error) {
+	if !l.explicit {
+		return update.remoteID, nil
+	}
+	if l.expect == "" {
+		return objects.ObjectID{}, nil
+	}
+	if id, err := objects.NewObjectID(l.expect); err == nil {
+		return id, nil
+	}
+	id, err := resolveCommitish(repo, l.expect)
+	if err != nil {
+		return objects.ObjectID{}, fmt.Errorf("cannot parse expected object name '%s'", l.expect)
+	}
+	return id, nil
+}
+
+// pushNegotiation is what a push asks of the remote besides the updates
+type pushNegotiation struct {
+	leases  []forceLease
+	atomic  bool
+	options []string
+}
+
 func pushToRemote(cmd *cobra.Command, repo *vcs.Repository, remoteName, remoteURL string, refspecs []string, force, setUpstream, all, tags, dryRun, verbose bool) error {
 	refManager := refs.NewRefManager(repo.GitDir())
 
@@ -97,67 +199,388 @@ func pushToRemote(cmd *cobra.Command, repo *vcs.Repository, remoteName, remoteUR
 		fmt.Fprintln(cmd.OutOrStdout(), "Dry run mode - no changes will be made")
 	}
 
-	// In a real implementation, this would:
-	// 1. Connect to remote repository
-	// 2. Negotiate refs (git-receive-pack)
-	// 3. Determine objects to send
-	// 4. Create and send pack files
-	// 5. Update remote refs
-
-	// For now, simulate the push process
-	if verbose {
-		fmt.Fprintln(cmd.OutOrStdout(), "Enumerating objects...")
-		fmt.Fprintln(cmd.OutOrStdout(), "Counting objects: 100% (0/0), done.")
-		fmt.Fprintln(cmd.OutOrStdout(), "Writing objects: 100% (0/0), done.")
-		fmt.Fprintln(cmd.OutOrStdout(), "Total 0 (delta 0), reused 0 (delta 0)")
-	}
-
 	fmt.Fprintf(cmd.OutOrStdout(), "To %s\n", remoteURL)
 
-	// Process each refspec
+	// Resolve each refspec, whose source may be any revision
+	resolver := newResolver(repo)
+	var updates []pushUpdate
+	missing := false
 	for _, refspec := range refspecs {
 		localRef, remoteRef := parseRefspec(refspec)
-		
-		// Get local commit ID
-		localCommitID, err := refManager.ResolveRef(localRef)
+		if localRef == "" {
+			// :<ref> deletes the remote ref
+			updates = append(updates, newPushUpdate(refManager, remoteName, "", remoteRef, objects.ObjectID{}))
+			continue
+		}
+
+		localID, err := resolver.Resolve(localRef)
 		if err != nil {
 			fmt.Fprintf(cmd.OutOrStderr(), " ! [rejected]        %s -> %s (no such ref)\n", localRef, remoteRef)
+			missing = true
 			continue
 		}
+		updates = append(updates, newPushUpdate(refManager, remoteName, localRef, remoteRef, localID))
+	}
 
-		// Simulate push result
-		if dryRun {
-			fmt.Fprintf(cmd.OutOrStdout(), " * [dry-run]         %s -> %s\n", localRef, remoteRef)
-		} else if force {
-			fmt.Fprintf(cmd.OutOrStdout(), " + %s...%s %s -> %s (forced update)\n", 
-				localCommitID.String()[:7], localCommitID.String()[:7], localRef, remoteRef)
-		} else {
-			fmt.Fprintf(cmd.OutOrStdout(), "   %s..%s  %s -> %s\n", 
-				localCommitID.String()[:7], localCommitID.String()[:7], localRef, remoteRef)
-		}
-
-		// Set upstream if requested
-		if setUpstream && !dryRun {
-			if err := setUpstreamBranch(repo, localRef, remoteName, remoteRef); err != nil {
-				return fmt.Errorf("failed to set upstream: %w", err)
+	noVerify, _ := cmd.Flags().GetBool("no-verify")
+	if !noVerify && len(updates) > 0 {
+		if err := runPrePushHook(cmd, repo, remoteName, remoteURL, updates); err != nil {
+			return err
+		}
+	}
+
+	remote, err := openPushTransport(cmd, repo, remoteName, remoteURL)
+	if err != nil {
+		return err
+	}
+	leases, _ := cmd.Flags().GetStringArray("force-with-lease")
+	atomic, _ := cmd.Flags().GetBool("atomic")
+	options, _ := cmd.Flags().GetStringArray("push-option")
+	negotiation := pushNegotiation{leases: parseForceLeases(leases), atomic: atomic, options: options}
+	return pushToTransport(cmd, repo, remote, remoteName, remoteURL, updates, missing, force, setUpstream, dryRun, negotiation)
+}
+
+// openPushTransport opens the receive-pack of remoteURL, a repository on
+// this machine, which applies its own receive.* settings, or one served
+// over HTTP
+func openPushTransport(cmd *cobra.Command, repo *vcs.Repository, remoteName, remoteURL string) (*transport.HTTPTransport, error) {
+	if isLocalRepository(remoteURL) {
+		return newLocalTransport(remoteURL)
+	}
+	if !isHTTPURL(remoteURL) {
+		return nil, fmt.Errorf("cannot push to '%s': unsupported protocol", remoteURL)
+	}
+	return openHTTPTransport(cmd, repo, remoteName, remoteURL)
+}
+
+// pushToTransport sends updates to the receive-pack of remote. Updates
+// that are not fast-forwards are rejected unless force is set or a lease
+// allows them, and the pack sent holds only the objects the remote's refs
+// do not already reach. rejected says whether some refs were already
+// refused, so the push fails.
+func pushToTransport(cmd *cobra.Command, repo *vcs.Repository, remote *transport.HTTPTransport, remoteName, remoteURL string, updates []pushUpdate, rejected, force, setUpstream, dryRun bool, negotiation pushNegotiation) error {
+	out := cmd.OutOrStdout()
+	ctx := context.Background()
+	discovery, err := remote.DiscoverRefs(ctx, "git-receive-pack")
+	if err != nil {
+		return fmt.Errorf("failed to read refs of %s: %w", remoteURL, err)
+	}
+	if negotiation.atomic && !discovery.HasCapability("atomic") {
+		return fmt.Errorf("the receiving end does not support --atomic push")
+	}
+	if len(negotiation.options) > 0 && !discovery.HasCapability("push-options") {
+		return fmt.Errorf("the receiving end does not support push options")
+	}
+
+	// The remote's tips we have are what it needs no objects for
+	var haves []objects.ObjectID
+	for _, hex := range discovery.Refs {
+		if id, err := objects.NewObjectID(hex); err == nil && repo.HasObject(id) {
+			haves = append(haves, id)
+		}
+	}
+
+	type command struct {
+		update pushUpdate
+		ref    string
+		old    objects.ObjectID
+		forced bool
+	}
+	var commands []command
+	for _, update := range updates {
+		ref := update.remoteRef
+		if !strings.HasPrefix(ref, "refs/") {
+			ref = "refs/heads/" + ref
+		}
+		var old objects.ObjectID
+		if hex, ok := discovery.Refs[ref]; ok {
+			old, _ = objects.NewObjectID(hex)
+		}
+
+		deleting := update.localID.IsZero()
+		switch {
+		case deleting && old.IsZero():
+			fmt.Fprintf(cmd.ErrOrStderr(), "error: unable to delete '%s': remote ref does not exist\n", update.remoteRef)
+			rejected = true
+			continue
+		case deleting && !discovery.HasCapability("delete-refs"):
+			fmt.Fprintf(out, " ! [rejected]        %s (remote does not support deleting refs)\n", update.label())
+			rejected = true
+			continue
+		case old.Equal(update.localID):
+			fmt.Fprintf(out, " = [up to date]      %s\n", update.label())
+			continue
+		}
+
+		lease, leased := leaseFor(negotiation.leases, ref)
+		if leased {
+			expected, err := lease.expected(repo, update)
+			if err != nil {
+				return err
+			}
+			if !old.Equal(expected) {
+				fmt.Fprintf(out, " ! [rejected]        %s (stale info)\n", update.label())
+				rejected = true
+				continue
 			}
-			fmt.Fprintf(cmd.OutOrStdout(), "Branch '%s' set up to track remote branch '%s' from '%s'.\n", 
-				localRef, remoteRef, remoteName)
 		}
+
+		forced := false
+		if !old.IsZero() && !deleting {
+			fastForward := false
+			if repo.HasObject(old) {
+				if fastForward, err = isAncestor(repo, old, update.localID); err != nil {
+					return err
+				}
+			}
+			if !fastForward && !force && !leased {
+				reason := "non-fast-forward"
+				if !repo.HasObject(old) {
+					reason = "fetch first"
+				}
+				fmt.Fprintf(out, " ! [rejected]        %s (%s)\n", update.label(), reason)
+				rejected = true
+				continue
+			}
+			forced = !fastForward
+		}
+		commands = append(commands, command{update: update, ref: ref, old: old, forced: forced})
+	}
+
+	// An atomic push is all or nothing, so sends nothing once a ref failed
+	if negotiation.atomic && rejected {
+		for _, c := range commands {
+			fmt.Fprintf(out, " ! [rejected]        %s (atomic push failed)\n", c.update.label())
+		}
+		return fmt.Errorf("failed to push some refs to '%s'", remoteURL)
+	}
+
+	if len(commands) == 0 {
+		if rejected {
+			return fmt.Errorf("failed to push some refs to '%s'", remoteURL)
+		}
+		fmt.Fprintln(out, "Everything up-to-date")
+		return nil
+	}
+
+	req := &transport.PushRequest{}
+	var tips []objects.ObjectID
+	for _, c := range commands {
+		req.Updates = append(req.Updates, transport.RefUpdate{Ref: c.ref, Old: c.old.String(), New: c.update.localID.String()})
+		if !c.update.localID.IsZero() {
+			tips = append(tips, c.update.localID)
+		}
+	}
+	for _, capability := range []string{"report-status-v2", "ofs-delta"} {
+		if discovery.HasCapability(capability) {
+			req.Capabilities = append(req.Capabilities, capability)
+		}
+	}
+	if negotiation.atomic {
+		req.Capabilities = append(req.Capabilities, "atomic")
+	}
+	if len(negotiation.options) > 0 {
+		req.Capabilities = append(req.Capabilities, "push-options")
+		req.Options = negotiation.options
+	}
+
+	// Deletions need no objects
+	if !dryRun && len(tips) > 0 {
+		ids, err := objectsToSend(repo, tips, haves)
+		if err != nil {
+			return err
+		}
+		objs, err := packfile.ReadObjects(repo.Storage(), ids)
+		if err != nil {
+			return err
+		}
+		opts := packWriterOptions(repo.GitDir())
+		opts.OffsetDeltas = discovery.HasCapability("ofs-delta")
+		opts.Progress = transferProgress(cmd)
+		var pack bytes.Buffer
+		if _, err := packfile.WritePack(&pack, objs, opts); err != nil {
+			return fmt.Errorf("failed to write pack: %w", err)
+		}
+		req.Pack = &pack
 	}
 
+	var result *transport.PushResult
 	if !dryRun {
-		fmt.Fprintln(cmd.OutOrStdout(), "\nNote: This is a basic push implementation.")
-		fmt.Fprintln(cmd.OutOrStdout(), "Full network protocol support would require:")
-		fmt.Fprintln(cmd.OutOrStdout(), "  - HTTP/SSH transport implementation")
-		fmt.Fprintln(cmd.OutOrStdout(), "  - Pack protocol negotiation")
-		fmt.Fprintln(cmd.OutOrStdout(), "  - Atomic ref updates")
-		fmt.Fprintln(cmd.OutOrStdout(), "  - Pre-receive and post-receive hooks")
+		result, err = remote.Push(ctx, req)
+		if err != nil {
+			return err
+		}
+		if result.UnpackError != "" {
+			return fmt.Errorf("remote unpack failed: %s", result.UnpackError)
+		}
+	}
+
+	refManager := refs.NewRefManager(repo.GitDir())
+	for _, c := range commands {
+		localRef, remoteRef := c.update.localRef, c.update.remoteRef
+		if result != nil {
+			status, ok := pushStatus(result, c.ref)
+			if !ok || status.Reason != "" {
+				reason := status.Reason
+				if !ok {
+					reason = "no status reported"
+				}
+				fmt.Fprintf(out, " ! [remote rejected] %s (%s)\n", c.update.label(), reason)
+				rejected = true
+				continue
+			}
+			c.forced = c.forced || status.ForcedUpdate
+		}
+
+		oldHex, newHex := c.old.String()[:7], c.update.localID.String()[:7]
+		switch {
+		case c.update.localID.IsZero():
+			fmt.Fprintf(out, " - %-18s %s\n", "[deleted]", remoteRef)
+		case c.old.IsZero():
+			kind := "[new branch]"
+			if !strings.HasPrefix(c.ref, "refs/heads/") {
+				kind = "[new reference]"
+			}
+			fmt.Fprintf(out, " * %-18s %s\n", kind, c.update.label())
+		case c.forced:
+			fmt.Fprintf(out, " + %s...%s %s (forced update)\n", oldHex, newHex, c.update.label())
+		default:
+			fmt.Fprintf(out, "   %s..%s  %s\n", oldHex, newHex, c.update.label())
+		}
+		if dryRun {
+			continue
+		}
+
+		// The remote-tracking branch follows what the remote now has
+		if trackingRef, ok := remoteTrackingRef(repo.GitDir(), remoteName, c.ref); ok {
+			oldID, _ := refManager.ResolveRef(trackingRef)
+			if c.update.localID.IsZero() {
+				if refManager.RefExists(trackingRef) {
+					if err := refManager.DeleteRef(trackingRef); err != nil {
+						return fmt.Errorf("failed to delete %s: %w", trackingRef, err)
+					}
+				}
+				continue
+			}
+			if err := refManager.UpdateRef(trackingRef, c.update.localID); err != nil {
+				return fmt.Errorf("failed to update %s: %w", trackingRef, err)
+			}
+			logRefUpdate(refManager, trackingRef, oldID, c.update.localID, "update by push")
+		}
+
+		// Only a branch pushed by name gets an upstream, not a revision
+		localBranch := strings.TrimPrefix(localRef, "refs/heads/")
+		if setUpstream && !c.update.localID.IsZero() && refManager.RefExists("refs/heads/"+localBranch) {
+			if err := setUpstreamBranch(repo, localBranch, remoteName, strings.TrimPrefix(remoteRef, "refs/heads/")); err != nil {
+				return fmt.Errorf("failed to set upstream: %w", err)
+			}
+			fmt.Fprintf(out, "Branch '%s' set up to track remote branch '%s' from '%s'.\n",
+				localBranch, strings.TrimPrefix(remoteRef, "refs/heads/"), remoteName)
+		}
 	}
 
+	if rejected {
+		return fmt.Errorf("failed to push some refs to '%s'", remoteURL)
+	}
 	return nil
 }
 
+// pushStatus returns the status the remote reported for ref, the last
+// when there are several
+func pushStatus(result *transport.PushResult, ref string) (transport.RefStatus, bool) {
+	for i := len(result.Statuses) - 1; i >= 0; i-- {
+		if result.Statuses[i].Ref == ref {
+			return result.Statuses[i], true
+		}
+	}
+	return transport.RefStatus{}, false
+}
+
+// objectsToSend returns the objects reachable from tips that are not
+// reachable from haves, counted with the pack bitmap when there is one
+func objectsToSend(repo *vcs.Repository, tips, haves []objects.ObjectID) ([]objects.ObjectID, error) {
+	bitmap, err := packfile.StorageBitmap(repo.Storage())
+	if err != nil {
+		return nil, err
+	}
+	if bitmap != nil {
+		return bitmap.CountObjects(repo.Storage(), tips, haves)
+	}
+
+	wanted, err := reachableObjects(repo, tips)
+	if err != nil {
+		return nil, err
+	}
+	present, err := reachableObjects(repo, haves)
+	if err != nil {
+		return nil, err
+	}
+	var ids []objects.ObjectID
+	for _, id := range wanted.order {
+		if !present.seen[id] {
+			ids = append(ids, id)
+		}
+	}
+	return ids, nil
+}
+
+// pushUpdate is a ref to update on the remote, or to delete when localRef
+// is empty and localID zero
+type pushUpdate struct {
+	localRef  string
+	remoteRef string
+	localID   objects.ObjectID
+	// remoteID is the ref's value on the remote as last fetched, or the
+	// null ID when unknown
+	remoteID objects.ObjectID
+}
+
+// newPushUpdate describes pushing localRef, at localID, to remoteRef
+func newPushUpdate(refManager *refs.RefManager, remoteName, localRef, remoteRef string, localID objects.ObjectID) pushUpdate {
+	update := pushUpdate{localRef: localRef, remoteRef: remoteRef, localID: localID}
+	ref := remoteRef
+	if !strings.HasPrefix(ref, "refs/") {
+		ref = "refs/heads/" + ref
+	}
+	if trackingRef, ok := remoteTrackingRef(refManager.GitDir(), remoteName, ref); ok {
+		update.remoteID, _ = refManager.ResolveRef(trackingRef)
+	}
+	return update
+}
+
+// label names the update in the summary: "<local> -> <remote>", or the
+// remote ref alone for a deletion
+func (u pushUpdate) label() string {
+	if u.localID.IsZero() {
+		return u.remoteRef
+	}
+	return u.localRef + " -> " + u.remoteRef
+}
+
+// runPrePushHook runs the pre-push hook with the remote's name and URL as
+// arguments and a line per ref to update on its standard input, as in Git:
+// "<local ref> <local id> <remote ref> <remote id>", with "(delete)" and
+// the null ID for a deletion. The hook exiting non-zero stops the push
+// before anything is sent.
+func runPrePushHook(cmd *cobra.Command, repo *vcs.Repository, remoteName, remoteURL string, updates []pushUpdate) error {
+	refManager := refs.NewRefManager(repo.GitDir())
+	var input strings.Builder
+	for _, update := range updates {
+		localRef, err := refManager.ExpandRef(update.localRef)
+		if update.localID.IsZero() {
+			localRef = "(delete)"
+		} else if err != nil {
+			localRef = update.localRef
+		}
+		remoteRef := update.remoteRef
+		if !strings.HasPrefix(remoteRef, "refs/") {
+			remoteRef = "refs/heads/" + remoteRef
+		}
+		fmt.Fprintf(&input, "%s %s %s %s\n", localRef, update.localID, remoteRef, update.remoteID)
+	}
+	return runHookWithInput(cmd.ErrOrStderr(), strings.NewReader(input.String()), repo.WorkDir(), repo.GitDir(), "pre-push", remoteName, remoteURL)
+}
+
 func getCurrentBranch(repo *vcs.Repository) (string, error) {
 	refManager := refs.NewRefManager(repo.GitDir())
 	branch, err := refManager.CurrentBranch()
@@ -186,14 +609,18 @@ func parseRefspec(refspec string) (local, remote string) {
 	return refspec, refspec
 }
 
+// setUpstreamBranch records remoteName/remoteBranch as the upstream of
+// localBranch in the repository config
 func setUpstreamBranch(repo *vcs.Repository, localBranch, remoteName, remoteBranch string) error {
-	// In a real implementation, this would update .git/config
-	// For now, we'll create a simple tracking file
-	configPath := filepath.Join(repo.GitDir(), "config")
-	
-	// This is a simplified version - real Git config has more complex format
-	trackingConfig := fmt.Sprintf("\n[branch \"%s\"]\n\tremote = %s\n\tmerge = refs/heads/%s\n", 
-		localBranch, remoteName, remoteBranch)
-	
-	return appendToFile(configPath, []byte(trackingConfig))
+	file, err := config.ReadFile(config.Path(config.ScopeLocal, repo.GitDir()))
+	if err != nil {
+		return err
+	}
+	if err := file.Set("branch."+localBranch+".remote", remoteName); err != nil {
+		return err
+	}
+	if err := file.Set("branch."+localBranch+".merge", "refs/heads/"+remoteBranch); err != nil {
+		return err
+	}
+	return file.Save()
 }
\ No newline at end of file
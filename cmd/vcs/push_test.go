@@ -2,14 +2,19 @@ package main
 
 import (
 	"bytes"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/internal/serve"
+	"github.com/fenilsonani/vcs/pkg/vcs"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
-	"github.com/fenilsonani/vcs/pkg/vcs"
 )
 
 func TestNewPushCommand(t *testing.T) {
@@ -19,69 +24,60 @@ func TestNewPushCommand(t *testing.T) {
 	assert.Contains(t, cmd.Short, "Update remote refs")
 }
 
+// servePushOrigin serves a bare repository that accepts pushes over HTTP,
+// returning it and its URL
+func servePushOrigin(t *testing.T) (*vcs.Repository, string) {
+	bare, err := initBareRepository(filepath.Join(t.TempDir(), "origin.git"))
+	require.NoError(t, err)
+	policy, err := receivePolicy(bare.GitDir())
+	require.NoError(t, err)
+	server := serve.NewServer(bare.GitDir(), bare.Storage())
+	server.AllowPush(policy)
+	hs := httptest.NewServer(server)
+	t.Cleanup(hs.Close)
+	return bare, hs.URL
+}
+
 func TestPushCommand(t *testing.T) {
 	tests := []struct {
 		name        string
 		args        []string
 		flags       map[string]string
-		setupFunc   func(t *testing.T, repoPath string)
+		setupFunc   func(t *testing.T, repoPath string, origin *vcs.Repository)
 		expectError bool
-		checkFunc   func(t *testing.T, output string, repoPath string)
+		checkFunc   func(t *testing.T, output string, repoPath string, origin *vcs.Repository)
 	}{
 		{
 			name: "push to origin",
 			args: []string{},
-			setupFunc: func(t *testing.T, repoPath string) {
-				// Add origin remote
-				configPath := filepath.Join(repoPath, ".git", "config")
-				configContent := `[remote "origin"]
-	url = https://github.com/example/repo.git
-`
-				err := os.WriteFile(configPath, []byte(configContent), 0644)
-				require.NoError(t, err)
-			},
-			checkFunc: func(t *testing.T, output string, repoPath string) {
-				assert.Contains(t, output, "Pushing to https://github.com/example/repo.git")
+			checkFunc: func(t *testing.T, output string, repoPath string, origin *vcs.Repository) {
+				assert.Contains(t, output, "Pushing to http://")
 				assert.Contains(t, output, "main -> main")
+				assert.True(t, origin.HasObject(headOf(t, filepath.Join(repoPath, ".git"), "refs/heads/main")))
+				assert.Equal(t, headOf(t, filepath.Join(repoPath, ".git"), "refs/heads/main"), headOf(t, origin.GitDir(), "refs/heads/main"))
 			},
 		},
 		{
 			name: "push specific branch",
 			args: []string{"origin", "feature"},
-			setupFunc: func(t *testing.T, repoPath string) {
-				// Add origin remote
-				configPath := filepath.Join(repoPath, ".git", "config")
-				configContent := `[remote "origin"]
-	url = https://github.com/example/repo.git
-`
-				err := os.WriteFile(configPath, []byte(configContent), 0644)
-				require.NoError(t, err)
-				
-				// Create feature branch
+			setupFunc: func(t *testing.T, repoPath string, origin *vcs.Repository) {
 				repo, err := vcs.Open(repoPath)
 				require.NoError(t, err)
 				testRepo := WrapRepository(repo, repoPath)
 				_, err = testRepo.CreateBranch("feature")
 				require.NoError(t, err)
 			},
-			checkFunc: func(t *testing.T, output string, repoPath string) {
+			checkFunc: func(t *testing.T, output string, repoPath string, origin *vcs.Repository) {
 				assert.Contains(t, output, "feature -> feature")
+				assert.Equal(t, headOf(t, filepath.Join(repoPath, ".git"), "refs/heads/feature"), headOf(t, origin.GitDir(), "refs/heads/feature"))
 			},
 		},
 		{
 			name: "push with refspec",
 			args: []string{"origin", "main:develop"},
-			setupFunc: func(t *testing.T, repoPath string) {
-				// Add origin remote
-				configPath := filepath.Join(repoPath, ".git", "config")
-				configContent := `[remote "origin"]
-	url = https://github.com/example/repo.git
-`
-				err := os.WriteFile(configPath, []byte(configContent), 0644)
-				require.NoError(t, err)
-			},
-			checkFunc: func(t *testing.T, output string, repoPath string) {
+			checkFunc: func(t *testing.T, output string, repoPath string, origin *vcs.Repository) {
 				assert.Contains(t, output, "main -> develop")
+				assert.Equal(t, headOf(t, filepath.Join(repoPath, ".git"), "refs/heads/main"), headOf(t, origin.GitDir(), "refs/heads/develop"))
 			},
 		},
 		{
@@ -90,17 +86,20 @@ func TestPushCommand(t *testing.T) {
 			flags: map[string]string{
 				"force": "true",
 			},
-			setupFunc: func(t *testing.T, repoPath string) {
-				// Add origin remote
-				configPath := filepath.Join(repoPath, ".git", "config")
-				configContent := `[remote "origin"]
-	url = https://github.com/example/repo.git
-`
-				err := os.WriteFile(configPath, []byte(configContent), 0644)
+			setupFunc: func(t *testing.T, repoPath string, origin *vcs.Repository) {
+				// The origin's main shares no history with ours
+				blob, err := origin.CreateBlob([]byte("other\n"))
 				require.NoError(t, err)
+				tree, err := origin.CreateTree([]objects.TreeEntry{{Mode: objects.ModeBlob, Name: "other.txt", ID: blob.ID()}})
+				require.NoError(t, err)
+				sig := objects.Signature{Name: "Test", Email: "test@example.com", When: time.Unix(1700000000, 0)}
+				commit, err := origin.CreateCommit(tree.ID(), nil, sig, sig, "Other root\n")
+				require.NoError(t, err)
+				require.NoError(t, refs.NewRefManager(origin.GitDir()).UpdateRef("refs/heads/main", commit.ID()))
 			},
-			checkFunc: func(t *testing.T, output string, repoPath string) {
+			checkFunc: func(t *testing.T, output string, repoPath string, origin *vcs.Repository) {
 				assert.Contains(t, output, "forced update")
+				assert.Equal(t, headOf(t, filepath.Join(repoPath, ".git"), "refs/heads/main"), headOf(t, origin.GitDir(), "refs/heads/main"))
 			},
 		},
 		{
@@ -109,18 +108,9 @@ func TestPushCommand(t *testing.T) {
 			flags: map[string]string{
 				"set-upstream": "true",
 			},
-			setupFunc: func(t *testing.T, repoPath string) {
-				// Add origin remote
-				configPath := filepath.Join(repoPath, ".git", "config")
-				configContent := `[remote "origin"]
-	url = https://github.com/example/repo.git
-`
-				err := os.WriteFile(configPath, []byte(configContent), 0644)
-				require.NoError(t, err)
-			},
-			checkFunc: func(t *testing.T, output string, repoPath string) {
+			checkFunc: func(t *testing.T, output string, repoPath string, origin *vcs.Repository) {
 				assert.Contains(t, output, "Branch 'main' set up to track remote branch")
-				
+
 				// Check config was updated
 				configPath := filepath.Join(repoPath, ".git", "config")
 				content, err := os.ReadFile(configPath)
@@ -134,54 +124,29 @@ func TestPushCommand(t *testing.T) {
 			flags: map[string]string{
 				"dry-run": "true",
 			},
-			setupFunc: func(t *testing.T, repoPath string) {
-				// Add origin remote
-				configPath := filepath.Join(repoPath, ".git", "config")
-				configContent := `[remote "origin"]
-	url = https://github.com/example/repo.git
-`
-				err := os.WriteFile(configPath, []byte(configContent), 0644)
-				require.NoError(t, err)
-			},
-			checkFunc: func(t *testing.T, output string, repoPath string) {
+			checkFunc: func(t *testing.T, output string, repoPath string, origin *vcs.Repository) {
 				assert.Contains(t, output, "Dry run mode")
-				assert.Contains(t, output, "[dry-run]")
+				assert.Contains(t, output, "[new branch]")
+				assert.False(t, refs.NewRefManager(origin.GitDir()).RefExists("refs/heads/main"))
 			},
 		},
 		{
 			name: "verbose push",
 			args: []string{},
 			flags: map[string]string{
-				"verbose": "true",
+				"verbose":  "true",
+				"progress": "true",
 			},
-			setupFunc: func(t *testing.T, repoPath string) {
-				// Add origin remote
-				configPath := filepath.Join(repoPath, ".git", "config")
-				configContent := `[remote "origin"]
-	url = https://github.com/example/repo.git
-`
-				err := os.WriteFile(configPath, []byte(configContent), 0644)
-				require.NoError(t, err)
-			},
-			checkFunc: func(t *testing.T, output string, repoPath string) {
-				assert.Contains(t, output, "Enumerating objects")
-				assert.Contains(t, output, "Counting objects")
+			checkFunc: func(t *testing.T, output string, repoPath string, origin *vcs.Repository) {
+				assert.Contains(t, output, "Pushing to http://")
 				assert.Contains(t, output, "Writing objects")
 			},
 		},
 		{
-			name: "push non-existent branch",
-			args: []string{"origin", "nonexistent"},
-			setupFunc: func(t *testing.T, repoPath string) {
-				// Add origin remote
-				configPath := filepath.Join(repoPath, ".git", "config")
-				configContent := `[remote "origin"]
-	url = https://github.com/example/repo.git
-`
-				err := os.WriteFile(configPath, []byte(configContent), 0644)
-				require.NoError(t, err)
-			},
-			checkFunc: func(t *testing.T, output string, repoPath string) {
+			name:        "push non-existent branch",
+			args:        []string{"origin", "nonexistent"},
+			expectError: true,
+			checkFunc: func(t *testing.T, output string, repoPath string, origin *vcs.Repository) {
 				assert.Contains(t, output, "[rejected]")
 				assert.Contains(t, output, "no such ref")
 			},
@@ -189,89 +154,79 @@ func TestPushCommand(t *testing.T) {
 		{
 			name:        "push to non-existent remote",
 			args:        []string{"nonexistent"},
-			setupFunc:   func(t *testing.T, repoPath string) {},
 			expectError: true,
 		},
 		{
 			name:        "push outside repository",
 			args:        []string{},
-			setupFunc:   func(t *testing.T, repoPath string) {},
 			expectError: true,
 		},
 	}
 
 	for _, tc := range tests {
 		t.Run(tc.name, func(t *testing.T) {
-			// Create temporary directory
-			tmpDir := t.TempDir()
-			
+			origin, url := servePushOrigin(t)
+
 			var repoPath string
 			if tc.name != "push outside repository" {
-				// Initialize repository
-				repoPath = filepath.Join(tmpDir, "test-repo")
-				repo, err := vcs.Init(repoPath)
+				// Initialize repository with an initial commit
+				repo, _ := setupConfigRepo(t)
+				repoPath = repo.Path()
+				_, err := stageAndCommit(t, repo, "test.txt", "-m", "Initial commit")
 				require.NoError(t, err)
-				
-				// Make initial commit
-				testFile := filepath.Join(repoPath, "test.txt")
-				err = os.WriteFile(testFile, []byte("test content"), 0644)
+				_, err = runConfigArgs("remote.origin.url", url)
 				require.NoError(t, err)
-				
-				testRepo := WrapRepository(repo, repoPath)
-				err = testRepo.Add("test.txt")
-				require.NoError(t, err)
-				
-				_, err = testRepo.Commit("Initial commit", "Test User", "test@example.com")
-				require.NoError(t, err)
-				
+
 				// Run setup function
 				if tc.setupFunc != nil {
-					tc.setupFunc(t, repoPath)
+					tc.setupFunc(t, repoPath, origin)
 				}
-				
-				// Change to repo directory
-				err = os.Chdir(repoPath)
-				require.NoError(t, err)
 			} else {
 				// Stay in temp directory (outside repository)
-				err := os.Chdir(tmpDir)
+				oldWd, _ := os.Getwd()
+				t.Cleanup(func() { os.Chdir(oldWd) })
+				err := os.Chdir(t.TempDir())
 				require.NoError(t, err)
 			}
-			
+
 			// Create command
 			cmd := newPushCommand()
-			
+
 			// Set flags
 			for flag, value := range tc.flags {
 				err := cmd.Flags().Set(flag, value)
 				require.NoError(t, err)
 			}
-			
+
 			// Capture output
 			var buf bytes.Buffer
 			cmd.SetOut(&buf)
 			cmd.SetErr(&buf)
-			
+
 			// Execute command
 			cmd.SetArgs(tc.args)
 			err := cmd.Execute()
-			
+
 			// Check error
 			if tc.expectError {
 				assert.Error(t, err)
 			} else {
 				assert.NoError(t, err)
-				
-				// Check output
-				output := buf.String()
-				if tc.checkFunc != nil {
-					tc.checkFunc(t, output, repoPath)
-				}
+			}
+			if tc.checkFunc != nil {
+				tc.checkFunc(t, buf.String(), repoPath, origin)
 			}
 		})
 	}
 }
 
+// headOf resolves ref in the repository whose Git directory is gitDir
+func headOf(t *testing.T, gitDir, ref string) objects.ObjectID {
+	id, err := refs.NewRefManager(gitDir).ResolveRef(ref)
+	require.NoError(t, err)
+	return id
+}
+
 func TestGetCurrentBranch(t *testing.T) {
 	// Create temporary directory
 	tmpDir := t.TempDir()
@@ -400,115 +355,140 @@ func TestSetUpstreamBranch(t *testing.T) {
 }
 
 func TestPushToRemote(t *testing.T) {
-	// Create temporary directory
-	tmpDir := t.TempDir()
-	repoPath := filepath.Join(tmpDir, "test-repo")
-	
-	// Initialize repository
-	repo, err := vcs.Init(repoPath)
-	require.NoError(t, err)
-	
-	// Make initial commit
-	testFile := filepath.Join(repoPath, "test.txt")
-	err = os.WriteFile(testFile, []byte("test content"), 0644)
-	require.NoError(t, err)
-	
-	testRepo := WrapRepository(repo, repoPath)
-	err = testRepo.Add("test.txt")
-	require.NoError(t, err)
-	
-	_, err = testRepo.Commit("Initial commit", "Test User", "test@example.com")
+	// Initialize repository with an initial commit
+	repo, _ := setupConfigRepo(t)
+	repoPath := repo.Path()
+	_, err := stageAndCommit(t, repo, "test.txt", "-m", "Initial commit")
 	require.NoError(t, err)
-	
+	origin, url := servePushOrigin(t)
+
 	// Create command for output
 	cmd := newPushCommand()
 	var buf bytes.Buffer
 	cmd.SetOut(&buf)
 	cmd.SetErr(&buf)
-	
-	// Test push
-	err = pushToRemote(cmd, repo, "origin", "https://github.com/example/repo.git", 
-		[]string{"main"}, false, false, false, false, false, true)
+
+	// Test dry run
+	err = pushToRemote(cmd, repo, "origin", url,
+		[]string{"main"}, false, false, false, false, true, false)
 	assert.NoError(t, err)
-	
-	// Check output
+
 	output := buf.String()
-	assert.Contains(t, output, "Enumerating objects")
-	assert.Contains(t, output, "To https://github.com/example/repo.git")
+	assert.Contains(t, output, "Dry run mode")
 	assert.Contains(t, output, "main -> main")
-	
-	// Test dry run
+	assert.False(t, refs.NewRefManager(origin.GitDir()).RefExists("refs/heads/main"))
+
+	// Test push
 	buf.Reset()
-	err = pushToRemote(cmd, repo, "origin", "https://github.com/example/repo.git", 
-		[]string{"main"}, false, false, false, false, true, false)
+	err = pushToRemote(cmd, repo, "origin", url,
+		[]string{"main"}, false, false, false, false, false, true)
 	assert.NoError(t, err)
-	
+
 	output = buf.String()
-	assert.Contains(t, output, "Dry run mode")
-	assert.Contains(t, output, "[dry-run]")
-	
-	// Test force push
+	assert.Contains(t, output, "To "+url)
+	assert.Contains(t, output, "[new branch]")
+	assert.Contains(t, output, "main -> main")
+	first := headOf(t, filepath.Join(repoPath, ".git"), "refs/heads/main")
+	assert.Equal(t, first, headOf(t, origin.GitDir(), "refs/heads/main"))
+	assert.Equal(t, first, headOf(t, filepath.Join(repoPath, ".git"), "refs/remotes/origin/main"))
+
+	// Test up to date
 	buf.Reset()
-	err = pushToRemote(cmd, repo, "origin", "https://github.com/example/repo.git", 
-		[]string{"main"}, true, false, false, false, false, false)
+	err = pushToRemote(cmd, repo, "origin", url,
+		[]string{"main"}, false, false, false, false, false, false)
 	assert.NoError(t, err)
-	
+	assert.Contains(t, buf.String(), "[up to date]")
+
+	// Test a revision as the source
+	_, err = stageAndCommit(t, repo, "two.txt", "-m", "Second commit")
+	require.NoError(t, err)
+
+	buf.Reset()
+	err = pushToRemote(cmd, repo, "origin", url,
+		[]string{"HEAD~1:refs/heads/previous", "main"}, false, false, false, false, false, false)
+	assert.NoError(t, err)
+
 	output = buf.String()
-	assert.Contains(t, output, "forced update")
-	
+	assert.Contains(t, output, "HEAD~1 -> refs/heads/previous")
+	assert.Equal(t, first, headOf(t, origin.GitDir(), "refs/heads/previous"))
+	assert.Equal(t, headOf(t, filepath.Join(repoPath, ".git"), "refs/heads/main"), headOf(t, origin.GitDir(), "refs/heads/main"))
+
+	// Test a rewind, refused unless forced
+	buf.Reset()
+	err = pushToRemote(cmd, repo, "origin", url,
+		[]string{"HEAD~1:main"}, false, false, false, false, false, false)
+	assert.Error(t, err)
+	assert.Contains(t, buf.String(), "[rejected]")
+
+	buf.Reset()
+	err = pushToRemote(cmd, repo, "origin", url,
+		[]string{"HEAD~1:main"}, true, false, false, false, false, false)
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), "forced update")
+	assert.Equal(t, first, headOf(t, origin.GitDir(), "refs/heads/main"))
+
+	// Test delete
+	buf.Reset()
+	err = pushToRemote(cmd, repo, "origin", url,
+		[]string{":previous"}, false, false, false, false, false, false)
+	assert.NoError(t, err)
+	assert.Contains(t, buf.String(), "[deleted]")
+	assert.False(t, refs.NewRefManager(origin.GitDir()).RefExists("refs/heads/previous"))
+
 	// Test with non-existent branch
 	buf.Reset()
-	err = pushToRemote(cmd, repo, "origin", "https://github.com/example/repo.git", 
+	err = pushToRemote(cmd, repo, "origin", url,
 		[]string{"nonexistent"}, false, false, false, false, false, false)
-	assert.NoError(t, err) // No error at command level
-	
+	assert.Error(t, err)
+
 	output = buf.String()
 	assert.Contains(t, output, "[rejected]")
 	assert.Contains(t, output, "no such ref")
 }
 
-func TestMultipleRefspecs(t *testing.T) {
-	// Create temporary directory
-	tmpDir := t.TempDir()
-	repoPath := filepath.Join(tmpDir, "test-repo")
-	
-	// Initialize repository
-	repo, err := vcs.Init(repoPath)
-	require.NoError(t, err)
-	
-	// Make initial commit
-	testFile := filepath.Join(repoPath, "test.txt")
-	err = os.WriteFile(testFile, []byte("test content"), 0644)
+func TestPushToUnsupportedRemote(t *testing.T) {
+	repo, _ := setupConfigRepo(t)
+	_, err := stageAndCommit(t, repo, "test.txt", "-m", "Initial commit")
 	require.NoError(t, err)
-	
-	testRepo := WrapRepository(repo, repoPath)
-	err = testRepo.Add("test.txt")
-	require.NoError(t, err)
-	
-	_, err = testRepo.Commit("Initial commit", "Test User", "test@example.com")
+
+	cmd := newPushCommand()
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetErr(&bytes.Buffer{})
+	err = pushToRemote(cmd, repo, "origin", "ftp://example.com/repo.git",
+		[]string{"main"}, false, false, false, false, false, false)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unsupported protocol")
+}
+
+func TestMultipleRefspecs(t *testing.T) {
+	// Initialize repository with an initial commit
+	repo, _ := setupConfigRepo(t)
+	_, err := stageAndCommit(t, repo, "test.txt", "-m", "Initial commit")
 	require.NoError(t, err)
-	
+	testRepo := WrapRepository(repo, repo.Path())
+	origin, url := servePushOrigin(t)
+
 	// Create additional branches
 	_, err = testRepo.CreateBranch("feature1")
 	require.NoError(t, err)
 	_, err = testRepo.CreateBranch("feature2")
 	require.NoError(t, err)
-	
+
 	// Create command for output
 	cmd := newPushCommand()
 	var buf bytes.Buffer
 	cmd.SetOut(&buf)
 	cmd.SetErr(&buf)
-	
+
 	// Test push with multiple refspecs
-	err = pushToRemote(cmd, repo, "origin", "https://github.com/example/repo.git", 
+	err = pushToRemote(cmd, repo, "origin", url,
 		[]string{"main", "feature1:feat1", "feature2"}, false, false, false, false, false, false)
 	assert.NoError(t, err)
-	
+
 	// Check output
 	output := buf.String()
 	lines := strings.Split(output, "\n")
-	
+
 	// Count push results
 	pushCount := 0
 	for _, line := range lines {
@@ -517,8 +497,11 @@ func TestMultipleRefspecs(t *testing.T) {
 		}
 	}
 	assert.Equal(t, 3, pushCount)
-	
+
 	assert.Contains(t, output, "main -> main")
 	assert.Contains(t, output, "feature1 -> feat1")
 	assert.Contains(t, output, "feature2 -> feature2")
-}
\ No newline at end of file
+	for _, ref := range []string{"refs/heads/main", "refs/heads/feat1", "refs/heads/feature2"} {
+		assert.True(t, refs.NewRefManager(origin.GitDir()).RefExists(ref), ref)
+	}
+}
@@ -0,0 +1,407 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/index"
+	"github.com/fenilsonani/vcs/internal/core/merge"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// readTreeOptions holds the flags of read-tree
+type readTreeOptions struct {
+	merge  bool
+	reset  bool
+	update bool
+	empty  bool
+	prefix string
+}
+
+func newReadTreeCommand() *cobra.Command {
+	var opts readTreeOptions
+
+	cmd := &cobra.Command{
+		Use:   "read-tree [flags] (--empty | <tree-ish> [<tree-ish> [<tree-ish>]])",
+		Short: "Read tree information into the index",
+		Long: `Reads the files of a tree into the index, replacing what it holds;
+several trees are overlaid, later ones winning. --prefix=<dir>/ reads the
+tree below that directory instead, which must not be in the index yet.
+--empty empties the index.
+
+With -m the trees are merged with the index:
+
+  one tree      files unchanged keep their stat data, so they are not
+                rehashed
+  two trees     a switch from the first to the second; a file differing
+                between them takes the second one's, which fails when the
+                index changed it too
+  three trees   a merge of the second and third with the first as base;
+                files changed on one side only are taken from it and
+                files changed on both are left as stages 1, 2 and 3
+
+-m refuses an index with unmerged paths or changes the merge would
+lose; --reset is -m discarding them instead. With -u the working tree is
+updated to match, refusing to overwrite changes or untracked files
+unless --reset is given.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			switch {
+			case opts.empty && len(args) > 0:
+				return fmt.Errorf("--empty cannot be given with trees")
+			case !opts.empty && len(args) == 0:
+				return fmt.Errorf("no tree given; use --empty to empty the index")
+			case opts.merge && opts.reset:
+				return fmt.Errorf("-m and --reset are mutually exclusive")
+			case opts.update && !opts.merge && !opts.reset && opts.prefix == "":
+				return fmt.Errorf("-u is meaningless without -m, --reset or --prefix")
+			case opts.prefix != "" && len(args) != 1:
+				return fmt.Errorf("--prefix reads exactly one tree")
+			case (opts.merge || opts.reset) && len(args) > 3:
+				return fmt.Errorf("cannot merge more than 3 trees")
+			}
+			return runReadTree(cmd, args, opts)
+		},
+	}
+
+	cmd.Flags().BoolVarP(&opts.merge, "merge", "m", false, "Merge the trees with the index")
+	cmd.Flags().BoolVar(&opts.reset, "reset", false, "Merge, discarding unmerged paths and changes in the way")
+	cmd.Flags().BoolVarP(&opts.update, "update", "u", false, "Update the working tree to match the index")
+	cmd.Flags().BoolVar(&opts.empty, "empty", false, "Empty the index")
+	cmd.Flags().StringVar(&opts.prefix, "prefix", "", "Read the tree below this directory")
+
+	return cmd
+}
+
+func runReadTree(cmd *cobra.Command, args []string, opts readTreeOptions) error {
+	repoPath, err := findRepository()
+	if err != nil {
+		return fmt.Errorf("not a git repository: %w", err)
+	}
+	repo, err := openRepository(repoPath)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+	idx, err := readIndex(repo)
+	if err != nil {
+		return err
+	}
+
+	resolver := newResolver(repo)
+	trees := make([][]*index.Entry, len(args))
+	for i, arg := range args {
+		treeID, err := resolver.ResolveTree(arg)
+		if err != nil {
+			return fmt.Errorf("failed to unpack tree object %s", arg)
+		}
+		if trees[i], err = readTreeEntries(repo, treeID); err != nil {
+			return err
+		}
+	}
+
+	if opts.reset {
+		for _, p := range idx.Unmerged() {
+			dropUnmerged(idx, p)
+		}
+	} else if opts.merge && len(idx.Unmerged()) > 0 {
+		return fmt.Errorf("you need to resolve your current index first")
+	}
+
+	var result *index.Index
+	switch {
+	case opts.empty:
+		result = index.New()
+	case opts.prefix != "":
+		result, err = readTreePrefix(idx, trees[0], opts.prefix)
+	case !opts.merge && !opts.reset:
+		result = readTreeOverlay(trees)
+	case len(trees) == 1:
+		result = readTreeOneWay(idx, trees[0])
+	case len(trees) == 2:
+		result, err = readTreeTwoWay(idx, trees[0], trees[1], opts.reset)
+	default:
+		result, err = readTreeThreeWay(idx, trees[0], trees[1], trees[2], opts.reset)
+	}
+	if err != nil {
+		return err
+	}
+
+	if opts.update {
+		if err := checkoutReadTree(repo, idx, result, opts.reset); err != nil {
+			return err
+		}
+	}
+	if err := result.WriteToFile(filepath.Join(repo.GitDir(), "index")); err != nil {
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+	return nil
+}
+
+// readTreeEntries returns the files of the tree id as index entries
+func readTreeEntries(repo *vcs.Repository, id objects.ObjectID) ([]*index.Entry, error) {
+	files, err := merge.ReadTree(repo, id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tree %s: %w", id.Short(), err)
+	}
+	entries := make([]*index.Entry, len(files))
+	for i, file := range files {
+		entries[i] = &index.Entry{Mode: file.Mode, ID: file.ID, Path: file.Path}
+	}
+	return entries, nil
+}
+
+// dropUnmerged removes the conflict stages of p from idx
+func dropUnmerged(idx *index.Index, p string) {
+	var kept []*index.Entry
+	for _, entry := range idx.Entries() {
+		if entry.Path == p && entry.Stage() == 0 {
+			kept = append(kept, entry)
+		}
+	}
+	idx.Remove(p)
+	for _, entry := range kept {
+		idx.Add(entry)
+	}
+}
+
+// readTreePrefix returns idx with the files of tree added below prefix,
+// where idx must have nothing yet
+func readTreePrefix(idx *index.Index, tree []*index.Entry, prefix string) (*index.Index, error) {
+	prefix = strings.Trim(filepath.ToSlash(prefix), "/")
+	result := index.New()
+	for _, entry := range idx.Entries() {
+		if entry.Path == prefix || strings.HasPrefix(entry.Path, prefix+"/") {
+			return nil, fmt.Errorf("subdirectory '%s' already exists", prefix)
+		}
+		result.Add(entry)
+	}
+	for _, entry := range tree {
+		entry.Path = prefix + "/" + entry.Path
+		result.Add(entry)
+	}
+	return result, nil
+}
+
+// readTreeOverlay returns an index of the files of trees, later trees
+// replacing the files of earlier ones
+func readTreeOverlay(trees [][]*index.Entry) *index.Index {
+	result := index.New()
+	for _, tree := range trees {
+		for _, entry := range tree {
+			result.Add(entry)
+		}
+	}
+	return result
+}
+
+// readTreeOneWay returns an index of the files of tree, keeping the entries
+// of idx for files unchanged so that their stat data is not lost
+func readTreeOneWay(idx *index.Index, tree []*index.Entry) *index.Index {
+	result := index.New()
+	for _, entry := range tree {
+		if old, ok := idx.Get(entry.Path); ok && sameIndexEntry(old, entry) {
+			entry = old
+		}
+		result.Add(entry)
+	}
+	return result
+}
+
+// readTreeTwoWay switches idx from the files of head to those of next. A
+// file changed between them takes next's version when the index has head's
+// or already next's; otherwise the change staged would be lost, which
+// fails unless force is set.
+func readTreeTwoWay(idx *index.Index, head, next []*index.Entry, force bool) (*index.Index, error) {
+	result := index.New()
+	for _, p := range treeSides(idx, head, next) {
+		cur, h, m := p.sides[0], p.sides[1], p.sides[2]
+		switch {
+		case sameIndexEntry(h, m) && !force:
+			// Unchanged by the switch, so whatever is staged stays
+		case sameIndexEntry(cur, h) || sameIndexEntry(cur, m) || force:
+			cur = m
+		default:
+			return nil, fmt.Errorf("entry '%s' would be overwritten by merge. Cannot merge", p.path)
+		}
+		if cur != nil {
+			result.Add(keepStat(idx, cur))
+		}
+	}
+	return result, nil
+}
+
+// readTreeThreeWay merges the files of ours and theirs, with those of base
+// as their common ancestor. A file changed on one side only is taken from
+// it and one changed on both is left as stages 1, 2 and 3 for the sides it
+// is in. The index must have ours' version of each file the merge changes
+// unless force is set; others keep what is staged.
+func readTreeThreeWay(idx *index.Index, base, ours, theirs []*index.Entry, force bool) (*index.Index, error) {
+	result := index.New()
+	for _, p := range treeSides(idx, base, ours, theirs) {
+		cur, o, a, b := p.sides[0], p.sides[1], p.sides[2], p.sides[3]
+		merged, conflict := a, false
+		switch {
+		case sameIndexEntry(a, b) || sameIndexEntry(o, b):
+		case sameIndexEntry(o, a):
+			merged = b
+		default:
+			conflict = true
+		}
+
+		if !conflict && sameIndexEntry(merged, a) && !force {
+			if cur != nil {
+				result.Add(cur)
+			}
+			continue
+		}
+		if !sameIndexEntry(cur, a) && !force {
+			return nil, fmt.Errorf("entry '%s' would be overwritten by merge. Cannot merge", p.path)
+		}
+		if !conflict {
+			if merged != nil {
+				result.Add(keepStat(idx, merged))
+			}
+			continue
+		}
+		for stage, side := range []*index.Entry{o, a, b} {
+			if side == nil {
+				continue
+			}
+			entry := &index.Entry{Mode: side.Mode, ID: side.ID, Path: side.Path}
+			entry.SetStage(stage + 1)
+			result.Add(entry)
+		}
+	}
+	return result, nil
+}
+
+// treePath is a path with its entry in the index, first, and in each tree
+// read, nil where it is missing
+type treePath struct {
+	path  string
+	sides []*index.Entry
+}
+
+// treeSides pairs up the resolved files of idx with the files of trees, by
+// path
+func treeSides(idx *index.Index, trees ...[]*index.Entry) []treePath {
+	paths := make(map[string][]*index.Entry)
+	side := func(p string) []*index.Entry {
+		sides, ok := paths[p]
+		if !ok {
+			sides = make([]*index.Entry, len(trees)+1)
+			paths[p] = sides
+		}
+		return sides
+	}
+	for _, entry := range idx.Entries() {
+		if entry.Stage() == 0 {
+			side(entry.Path)[0] = entry
+		}
+	}
+	for i, tree := range trees {
+		for _, entry := range tree {
+			side(entry.Path)[i+1] = entry
+		}
+	}
+
+	sorted := make([]treePath, 0, len(paths))
+	for p, sides := range paths {
+		sorted = append(sorted, treePath{path: p, sides: sides})
+	}
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].path < sorted[j].path })
+	return sorted
+}
+
+// sameIndexEntry reports whether a and b stage the same content, either
+// being nil for a missing file
+func sameIndexEntry(a, b *index.Entry) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Mode == b.Mode && a.ID == b.ID
+}
+
+// keepStat returns the entry of idx for the path of entry when it stages
+// the same content, keeping its stat data, or else entry
+func keepStat(idx *index.Index, entry *index.Entry) *index.Entry {
+	if old, ok := idx.Get(entry.Path); ok && old.Stage() == 0 && sameIndexEntry(old, entry) {
+		return old
+	}
+	return entry
+}
+
+// checkoutReadTree updates the working tree from the resolved files of old
+// to those of result. Files changed or missing in the working tree, and
+// untracked files in the way, are not overwritten unless force is set.
+// The stat data of the files written is recorded in result.
+func checkoutReadTree(repo *vcs.Repository, old, result *index.Index, force bool) error {
+	hashes := newStatCache(repo, newConverter(repo, io.Discard, nil), readStatusCache(repo), nil, time.Now())
+	var writes []*index.Entry
+	var removes []string
+	for _, p := range treeSides(old, readTreeResolved(result)) {
+		before, after := p.sides[0], p.sides[1]
+		if sameIndexEntry(before, after) {
+			continue
+		}
+		if after == nil {
+			// Conflicted files are left as they are
+			if _, unmerged := result.Get(p.path); unmerged {
+				continue
+			}
+		}
+		if !force {
+			full := filepath.Join(repo.WorkDir(), filepath.FromSlash(p.path))
+			if before != nil {
+				if entryWorkTreeState(repo, hashes, before) == workTreeModified {
+					return fmt.Errorf("entry '%s' not uptodate. Cannot merge", p.path)
+				}
+			} else if _, err := os.Lstat(full); err == nil {
+				return fmt.Errorf("untracked working tree file '%s' would be overwritten by merge", p.path)
+			}
+		}
+		if after == nil {
+			removes = append(removes, p.path)
+		} else if after.Mode != objects.ModeCommit {
+			writes = append(writes, after)
+		}
+	}
+
+	for _, p := range removes {
+		if err := os.Remove(filepath.Join(repo.WorkDir(), filepath.FromSlash(p))); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", p, err)
+		}
+	}
+	conv := newConverter(repo, os.Stderr, nil)
+	for _, entry := range writes {
+		blob, err := repo.GetBlob(entry.ID)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", entry.Path, err)
+		}
+		if err := writeWorkingFile(repo, conv, entry.Path, entry.Mode, blob.Data()); err != nil {
+			return err
+		}
+		if info, err := os.Lstat(filepath.Join(repo.WorkDir(), filepath.FromSlash(entry.Path))); err == nil {
+			entry.CTime, entry.MTime, entry.Size = info.ModTime(), info.ModTime(), uint32(info.Size())
+		}
+	}
+	return nil
+}
+
+// readTreeResolved returns the stage 0 entries of idx
+func readTreeResolved(idx *index.Index) []*index.Entry {
+	var entries []*index.Entry
+	for _, entry := range idx.Entries() {
+		if entry.Stage() == 0 {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/merge"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// writeFilesTree writes a tree of files, mapping paths to content
+func writeFilesTree(t *testing.T, repo *vcs.Repository, files map[string]string) objects.ObjectID {
+	var entries []merge.Entry
+	for p, content := range files {
+		blob, err := repo.CreateBlob([]byte(content))
+		require.NoError(t, err)
+		entries = append(entries, merge.Entry{Path: p, Mode: objects.ModeBlob, ID: blob.ID()})
+	}
+	id, err := merge.WriteTree(repo, entries)
+	require.NoError(t, err)
+	return id
+}
+
+// stagedFiles returns the index as path:stage:content lines
+func stagedFiles(t *testing.T, repo *vcs.Repository) []string {
+	idx, err := readIndex(repo)
+	require.NoError(t, err)
+	var files []string
+	for _, entry := range idx.Entries() {
+		blob, err := repo.GetBlob(entry.ID)
+		require.NoError(t, err)
+		files = append(files, entry.Path+":"+string(rune('0'+entry.Stage()))+":"+strings.TrimSpace(string(blob.Data())))
+	}
+	return files
+}
+
+func TestReadTreeAndWriteTree(t *testing.T) {
+	setupTreeRepo(t, map[string]string{"a.txt": "a\n", "src/b.txt": "b\n"})
+	repo, _ := openRepository(".")
+	head, err := newResolver(repo).ResolveTree("HEAD")
+	require.NoError(t, err)
+
+	out, err := runCommandArgs(newWriteTreeCommand())
+	require.NoError(t, err)
+	assert.Equal(t, head.String()+"\n", out)
+
+	src, err := newResolver(repo).Resolve("HEAD:src")
+	require.NoError(t, err)
+	out, err = runCommandArgs(newWriteTreeCommand(), "--prefix=src/")
+	require.NoError(t, err)
+	assert.Equal(t, src.String()+"\n", out)
+
+	_, err = runCommandArgs(newReadTreeCommand(), "--empty")
+	require.NoError(t, err)
+	assert.Empty(t, stagedFiles(t, repo))
+
+	_, err = runCommandArgs(newReadTreeCommand(), "HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a.txt:0:a", "src/b.txt:0:b"}, stagedFiles(t, repo))
+
+	_, err = runCommandArgs(newReadTreeCommand(), "--prefix=lib/", "HEAD:src")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a.txt:0:a", "lib/b.txt:0:b", "src/b.txt:0:b"}, stagedFiles(t, repo))
+	_, err = runCommandArgs(newReadTreeCommand(), "--prefix=lib/", "HEAD:src")
+	assert.ErrorContains(t, err, "already exists")
+
+	// Blobs missing from the repository are only written with --missing-ok
+	missing := strings.Repeat("1", 40)
+	_, err = runCommandArgs(newUpdateIndexCommand(), "--add", "--cacheinfo", "100644,"+missing+",gone.txt")
+	require.NoError(t, err)
+	_, err = runCommandArgs(newWriteTreeCommand())
+	assert.ErrorContains(t, err, "invalid object")
+	_, err = runCommandArgs(newWriteTreeCommand(), "--missing-ok")
+	assert.NoError(t, err)
+}
+
+func TestReadTreeTwoWay(t *testing.T) {
+	setupTreeRepo(t, map[string]string{"a.txt": "a\n", "b.txt": "b\n", "c.txt": "c\n"})
+	repo, _ := openRepository(".")
+	head, err := newResolver(repo).ResolveTree("HEAD")
+	require.NoError(t, err)
+	next := writeFilesTree(t, repo, map[string]string{"a.txt": "a2\n", "b.txt": "b\n", "d.txt": "d\n"})
+
+	// A change staged to a file the switch leaves alone is kept
+	require.NoError(t, os.WriteFile("b.txt", []byte("staged\n"), 0644))
+	_, err = runCommandArgs(newAddCommand(), "b.txt")
+	require.NoError(t, err)
+
+	_, err = runCommandArgs(newReadTreeCommand(), "-m", "-u", head.String(), next.String())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a.txt:0:a2", "b.txt:0:staged", "d.txt:0:d"}, stagedFiles(t, repo))
+	data, err := os.ReadFile("a.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "a2\n", string(data))
+	assert.NoFileExists(t, "c.txt")
+	assert.FileExists(t, "d.txt")
+
+	// A staged change the switch would replace is refused
+	require.NoError(t, os.WriteFile("a.txt", []byte("mine\n"), 0644))
+	_, err = runCommandArgs(newAddCommand(), "a.txt")
+	require.NoError(t, err)
+	_, err = runCommandArgs(newReadTreeCommand(), "-m", next.String(), head.String())
+	assert.ErrorContains(t, err, "would be overwritten")
+
+	_, err = runCommandArgs(newReadTreeCommand(), "--reset", "-u", next.String(), head.String())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a.txt:0:a", "b.txt:0:b", "c.txt:0:c"}, stagedFiles(t, repo))
+}
+
+func TestReadTreeThreeWay(t *testing.T) {
+	setupTreeRepo(t, map[string]string{"same.txt": "s\n", "ours.txt": "o2\n", "both.txt": "ours\n"})
+	repo, _ := openRepository(".")
+	base := writeFilesTree(t, repo, map[string]string{"same.txt": "s\n", "ours.txt": "o\n", "theirs.txt": "t\n", "both.txt": "base\n"})
+	theirs := writeFilesTree(t, repo, map[string]string{"same.txt": "s\n", "ours.txt": "o\n", "theirs.txt": "t2\n", "both.txt": "theirs\n"})
+
+	// theirs.txt is deleted by ours and changed by theirs
+	_, err := runCommandArgs(newReadTreeCommand(), "-m", base.String(), "HEAD", theirs.String())
+	require.NoError(t, err)
+	assert.Equal(t, []string{
+		"both.txt:1:base", "both.txt:2:ours", "both.txt:3:theirs",
+		"ours.txt:0:o2",
+		"same.txt:0:s",
+		"theirs.txt:1:t", "theirs.txt:3:t2",
+	}, stagedFiles(t, repo))
+
+	_, err = runCommandArgs(newReadTreeCommand(), "-m", "HEAD")
+	assert.ErrorContains(t, err, "resolve your current index")
+
+	idx, err := readIndex(repo)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"both.txt", "theirs.txt"}, idx.Unmerged())
+	_, err = runCommandArgs(newReadTreeCommand(), "--reset", "HEAD")
+	require.NoError(t, err)
+	idx, err = readIndex(repo)
+	require.NoError(t, err)
+	assert.Empty(t, idx.Unmerged())
+}
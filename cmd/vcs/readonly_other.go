@@ -0,0 +1,12 @@
+//go:build !unix
+
+package main
+
+import "os"
+
+// writableDir reports whether files can be created in dir, going by its
+// permission bits
+func writableDir(dir string) bool {
+	info, err := os.Stat(dir)
+	return err == nil && info.Mode().Perm()&0200 != 0
+}
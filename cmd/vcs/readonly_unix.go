@@ -0,0 +1,12 @@
+//go:build unix
+
+package main
+
+import "syscall"
+
+// writableDir reports whether files can be created in dir, which also
+// catches read-only mounts, without writing anything
+func writableDir(dir string) bool {
+	const wOK = 0x2
+	return syscall.Access(dir, wOK) == nil
+}
@@ -0,0 +1,1217 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/index"
+	"github.com/fenilsonani/vcs/internal/core/merge"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// errRebaseConflict is returned when a rebase stops on a conflict
+var errRebaseConflict = errors.New(`rebase stopped on a conflict; resolve it and run "vcs rebase --continue"`)
+
+// detachedHeadName is the head-name recorded when rebasing a detached HEAD
+const detachedHeadName = "detached HEAD"
+
+// rebaseTodoHelp is appended to the todo list shown by rebase -i
+const rebaseTodoHelp = `
+# Commands:
+# p, pick <commit> = use commit
+# r, reword <commit> = use commit, but edit the commit message
+# s, squash <commit> = use commit, but meld into previous commit
+# f, fixup <commit> = like "squash", but discard this commit's message
+# d, drop <commit> = remove commit
+# x, exec <command> = run command (the rest of the line) using shell
+# t, reset <commit> = reset HEAD to <commit>, or to what it was rebased to
+# m, merge <commit> = redo the merge <commit> on HEAD and its other
+#                     parent, or what that was rebased to
+#
+# These lines can be re-ordered; they are executed from top to bottom.
+#
+# If you remove a line here THAT COMMIT WILL BE LOST.
+#
+# However, if you remove everything, the rebase will be aborted.
+`
+
+type rebaseOptions struct {
+	onto         string
+	interactive  bool
+	continueOp   bool
+	abort        bool
+	skip         bool
+	editTodo     bool
+	exec         []string
+	reportFormat string
+	autostash    bool
+	rebaseMerges bool
+}
+
+func newRebaseCommand() *cobra.Command {
+	var opts rebaseOptions
+
+	cmd := &cobra.Command{
+		Use:   "rebase [flags] [<upstream> [<branch>]]",
+		Short: "Reapply commits on top of another base tip",
+		Long: `Replays the commits of the current branch that are not in <upstream> on
+top of <upstream>, or of the commit given with --onto. When <branch> is
+given it is checked out first.
+
+If a commit cannot be applied cleanly the rebase stops with the
+conflicts in the working tree. Resolve them, mark them with "vcs add" and
+run "vcs rebase --continue", or use --skip to drop the commit or --abort
+to return to the original branch. Progress is kept in .git/rebase-merge.
+
+With -i the list of commits is opened in the editor first. Each line
+may be changed to pick, reword, squash, fixup or drop, and lines may be
+reordered or removed; exec lines run a shell command at that point. The
+list is edited with GIT_SEQUENCE_EDITOR or sequence.editor when set, so
+tools can rewrite it without a user. --edit-todo edits the rest of the
+list of a rebase in progress.
+
+Merge commits are dropped unless --rebase-merges is given. Then each line
+of development is rebased on its own and the merges joining them are
+redone, so the shape of the history is kept; the todo list resets HEAD
+to where a line starts with "reset" steps and redoes a merge with a
+"merge" step.
+
+With --exec the command runs after each rebased commit, and the rebase
+stops when it fails, to be resumed with --continue once the problem is
+fixed.
+
+With --autostash, or when rebase.autoStash is true, local changes are
+stashed before the rebase starts and applied again once it ends or is
+aborted. Changes that no longer apply cleanly are kept in the stash list
+instead.
+
+With --report=json a summary (rebased commits, conflicted paths with
+their conflict type, and whether the result is resolved) is written to
+stdout and other messages go to stderr.`,
+		Args: cobra.MaximumNArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := validateReportFormat(opts.reportFormat); err != nil {
+				return err
+			}
+			cmd.SilenceUsage = true
+
+			report, err := runRebase(cmd, args, opts)
+			if reportErr := writeReport(cmd.OutOrStdout(), opts.reportFormat, report); reportErr != nil && err == nil {
+				err = reportErr
+			}
+			return err
+		},
+	}
+
+	cmd.Flags().StringVar(&opts.onto, "onto", "", "Starting point at which to create the new commits")
+	cmd.Flags().BoolVarP(&opts.interactive, "interactive", "i", false, "Edit the list of commits to rebase")
+	cmd.Flags().BoolVar(&opts.continueOp, "continue", false, "Continue the rebase after resolving conflicts")
+	cmd.Flags().BoolVar(&opts.abort, "abort", false, "Abort the rebase and restore the original branch")
+	cmd.Flags().BoolVar(&opts.skip, "skip", false, "Skip the current commit and continue")
+	cmd.Flags().BoolVar(&opts.editTodo, "edit-todo", false, "Edit the todo list of the rebase in progress")
+	cmd.Flags().StringArrayVarP(&opts.exec, "exec", "x", nil, "Run a command after each rebased commit; may be given more than once")
+	cmd.Flags().BoolVarP(&opts.rebaseMerges, "rebase-merges", "r", false, "Keep merge commits, rebasing the lines of development they join")
+	addReportFlag(cmd, &opts.reportFormat)
+	addAutostashFlags(cmd)
+
+	return cmd
+}
+
+func runRebase(cmd *cobra.Command, args []string, opts rebaseOptions) (*operationReport, error) {
+	actions := 0
+	for _, set := range []bool{opts.continueOp, opts.abort, opts.skip, opts.editTodo} {
+		if set {
+			actions++
+		}
+	}
+	if actions > 1 {
+		return nil, fmt.Errorf("--continue, --abort, --skip and --edit-todo cannot be used together")
+	}
+	if actions == 1 && (len(args) > 0 || opts.onto != "" || opts.interactive || opts.rebaseMerges || len(opts.exec) > 0) {
+		return nil, fmt.Errorf("--continue, --abort, --skip and --edit-todo take no other arguments")
+	}
+
+	repoPath, err := findRepository()
+	if err != nil {
+		return nil, err
+	}
+	repo, err := openRepository(repoPath)
+	if err != nil {
+		return nil, err
+	}
+	refManager := refs.NewRefManager(repo.GitDir())
+	out := reportOutput(cmd, opts.reportFormat)
+
+	if actions == 0 {
+		if len(args) == 0 {
+			return nil, fmt.Errorf("no upstream given; specify the branch to rebase against")
+		}
+		if opts.autostash, err = autostashRequested(cmd, loadConfig(repo.GitDir()), "rebase.autoStash"); err != nil {
+			return nil, err
+		}
+		return startRebase(out, repo, refManager, args, opts)
+	}
+
+	state, err := loadRebaseState(repo)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case opts.abort:
+		return abortRebase(out, repo, refManager, state)
+	case opts.skip:
+		return skipRebase(out, repo, refManager, state)
+	case opts.editTodo:
+		return nil, editRemainingTodo(repo, state)
+	default:
+		return continueRebase(out, repo, refManager, state)
+	}
+}
+
+// rebaseStep is one line of the todo list
+type rebaseStep struct {
+	action  string
+	id      objects.ObjectID
+	subject string
+	// command is the shell command of an exec step
+	command string
+}
+
+func (s rebaseStep) String() string {
+	switch s.action {
+	case "exec":
+		return "exec " + s.command
+	case "reset":
+		return "reset " + s.id.String()
+	}
+	return fmt.Sprintf("%s %s %s", s.action, s.id, s.subject)
+}
+
+// rebaseState is the progress of a rebase, kept in .git/rebase-merge so it
+// can be continued after stopping on a conflict
+type rebaseState struct {
+	dir         string
+	headName    string
+	onto        objects.ObjectID
+	origHead    objects.ObjectID
+	interactive bool
+	todo        []rebaseStep
+	done        []rebaseStep
+	// stopped is set while the last done step waits for its conflicts to
+	// be resolved
+	stopped bool
+	// autostash is the stash of the local changes to apply when the
+	// rebase ends, if any
+	autostash objects.ObjectID
+	// rewritten maps the commits rebased so far to the commits they
+	// became, for reset and merge steps to find
+	rewritten map[objects.ObjectID]objects.ObjectID
+}
+
+// rewrittenID returns the commit id was rebased to, or id itself when it
+// was not rebased
+func (s *rebaseState) rewrittenID(id objects.ObjectID) objects.ObjectID {
+	if rewritten, ok := s.rewritten[id]; ok {
+		return rewritten
+	}
+	return id
+}
+
+func rebaseStateDir(repo *vcs.Repository) string {
+	return filepath.Join(repo.GitDir(), "rebase-merge")
+}
+
+// loadRebaseState reads the state of the rebase in progress
+func loadRebaseState(repo *vcs.Repository) (*rebaseState, error) {
+	dir := rebaseStateDir(repo)
+	if _, err := os.Stat(dir); os.IsNotExist(err) {
+		return nil, fmt.Errorf("no rebase in progress")
+	}
+
+	read := func(name string) (string, error) {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return "", fmt.Errorf("failed to read rebase state: %w", err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+
+	state := &rebaseState{dir: dir, rewritten: make(map[objects.ObjectID]objects.ObjectID)}
+	var err error
+	if state.headName, err = read("head-name"); err != nil {
+		return nil, err
+	}
+	for name, id := range map[string]*objects.ObjectID{"onto": &state.onto, "orig-head": &state.origHead} {
+		value, err := read(name)
+		if err != nil {
+			return nil, err
+		}
+		if *id, err = objects.NewObjectID(value); err != nil {
+			return nil, fmt.Errorf("invalid rebase state %s: %w", name, err)
+		}
+	}
+
+	todo, err := read("git-rebase-todo")
+	if err != nil {
+		return nil, err
+	}
+	if state.todo, err = parseRebaseTodo(todo, nil); err != nil {
+		return nil, err
+	}
+	done, err := read("done")
+	if err != nil {
+		return nil, err
+	}
+	if state.done, err = parseRebaseTodo(done, nil); err != nil {
+		return nil, err
+	}
+
+	if fileExists(filepath.Join(dir, "rewritten-list")) {
+		list, err := read("rewritten-list")
+		if err != nil {
+			return nil, err
+		}
+		for _, line := range strings.Split(list, "\n") {
+			fields := strings.Fields(line)
+			if len(fields) != 2 {
+				continue
+			}
+			old, oldErr := objects.NewObjectID(fields[0])
+			rewritten, newErr := objects.NewObjectID(fields[1])
+			if oldErr != nil || newErr != nil {
+				return nil, fmt.Errorf("invalid rebase state rewritten-list: %q", line)
+			}
+			state.rewritten[old] = rewritten
+		}
+	}
+	if fileExists(filepath.Join(dir, "autostash")) {
+		value, err := read("autostash")
+		if err != nil {
+			return nil, err
+		}
+		if state.autostash, err = objects.NewObjectID(value); err != nil {
+			return nil, fmt.Errorf("invalid rebase state autostash: %w", err)
+		}
+	}
+	state.interactive = fileExists(filepath.Join(dir, "interactive"))
+	state.stopped = fileExists(filepath.Join(dir, "stopped-sha"))
+	return state, nil
+}
+
+// save writes the state to .git/rebase-merge
+func (s *rebaseState) save() error {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create rebase state: %w", err)
+	}
+
+	formatSteps := func(steps []rebaseStep) string {
+		var b strings.Builder
+		for _, step := range steps {
+			b.WriteString(step.String() + "\n")
+		}
+		return b.String()
+	}
+
+	files := map[string]string{
+		"head-name":       s.headName + "\n",
+		"onto":            s.onto.String() + "\n",
+		"orig-head":       s.origHead.String() + "\n",
+		"git-rebase-todo": formatSteps(s.todo),
+		"done":            formatSteps(s.done),
+	}
+	if s.interactive {
+		files["interactive"] = ""
+	}
+	if !s.autostash.IsZero() {
+		files["autostash"] = s.autostash.String() + "\n"
+	}
+	if len(s.rewritten) > 0 {
+		// Listed in the order the commits were rebased, as Git lists them
+		var b strings.Builder
+		listed := make(map[objects.ObjectID]bool)
+		for _, step := range s.done {
+			if rewritten, ok := s.rewritten[step.id]; ok && !listed[step.id] {
+				listed[step.id] = true
+				fmt.Fprintf(&b, "%s %s\n", step.id, rewritten)
+			}
+		}
+		files["rewritten-list"] = b.String()
+	}
+	if s.stopped && len(s.done) > 0 {
+		files["stopped-sha"] = s.done[len(s.done)-1].id.String() + "\n"
+	} else if err := os.Remove(filepath.Join(s.dir, "stopped-sha")); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to update rebase state: %w", err)
+	}
+
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(s.dir, name), []byte(content), 0644); err != nil {
+			return fmt.Errorf("failed to write rebase state: %w", err)
+		}
+	}
+	return nil
+}
+
+// parseRebaseTodo parses a todo list. Abbreviated commit IDs are expanded
+// against candidates.
+func parseRebaseTodo(content string, candidates []*objects.Commit) ([]rebaseStep, error) {
+	actions := map[string]string{
+		"p": "pick", "pick": "pick",
+		"r": "reword", "reword": "reword",
+		"s": "squash", "squash": "squash",
+		"f": "fixup", "fixup": "fixup",
+		"d": "drop", "drop": "drop",
+		"t": "reset", "reset": "reset",
+		"m": "merge", "merge": "merge",
+	}
+
+	var steps []rebaseStep
+	for n, line := range strings.Split(content, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		fields := strings.SplitN(line, " ", 3)
+		if fields[0] == "x" || fields[0] == "exec" {
+			command := strings.TrimSpace(strings.TrimPrefix(line, fields[0]))
+			if command == "" {
+				return nil, fmt.Errorf("invalid todo line %d: missing command", n+1)
+			}
+			steps = append(steps, rebaseStep{action: "exec", command: command})
+			continue
+		}
+		action, ok := actions[fields[0]]
+		if !ok {
+			return nil, fmt.Errorf("invalid todo line %d: unknown command %q", n+1, fields[0])
+		}
+		if len(fields) < 2 {
+			return nil, fmt.Errorf("invalid todo line %d: missing commit", n+1)
+		}
+
+		id, err := expandCommitID(fields[1], candidates)
+		if err != nil {
+			return nil, fmt.Errorf("invalid todo line %d: %w", n+1, err)
+		}
+
+		step := rebaseStep{action: action, id: id}
+		if len(fields) == 3 {
+			step.subject = fields[2]
+		}
+		steps = append(steps, step)
+	}
+
+	return steps, nil
+}
+
+// expandCommitID resolves a full or abbreviated commit ID among candidates
+func expandCommitID(hex string, candidates []*objects.Commit) (objects.ObjectID, error) {
+	if id, err := objects.NewObjectID(hex); err == nil {
+		return id, nil
+	}
+
+	var found []objects.ObjectID
+	for _, commit := range candidates {
+		if len(hex) >= 4 && strings.HasPrefix(commit.ID().String(), strings.ToLower(hex)) {
+			found = append(found, commit.ID())
+		}
+	}
+	switch len(found) {
+	case 1:
+		return found[0], nil
+	case 0:
+		return objects.ObjectID{}, fmt.Errorf("unknown commit %q", hex)
+	default:
+		return objects.ObjectID{}, fmt.Errorf("ambiguous commit %q", hex)
+	}
+}
+
+func startRebase(out io.Writer, repo *vcs.Repository, refManager *refs.RefManager, args []string, opts rebaseOptions) (_ *operationReport, err error) {
+	if fileExists(rebaseStateDir(repo)) {
+		return nil, fmt.Errorf(`a rebase is already in progress; use "vcs rebase --continue", "--skip" or "--abort"`)
+	}
+	if !opts.autostash {
+		if dirty, err := hasUncommittedChanges(repo, refManager); err != nil {
+			return nil, err
+		} else if dirty {
+			return nil, fmt.Errorf("cannot rebase: your index contains uncommitted changes")
+		}
+	}
+
+	upstreamID, err := resolveCommitish(repo, args[0])
+	if err != nil {
+		return nil, err
+	}
+	ontoID := upstreamID
+	if opts.onto != "" {
+		if ontoID, err = resolveCommitish(repo, opts.onto); err != nil {
+			return nil, err
+		}
+	}
+
+	var autostash objects.ObjectID
+	if opts.autostash {
+		if autostash, err = createAutostash(out, repo, refManager); err != nil {
+			return nil, err
+		}
+	}
+	// Once the rebase state is saved the autostash is applied when the
+	// rebase ends; a rebase that stops before that applies it here
+	started := false
+	if !autostash.IsZero() {
+		defer func() {
+			if started {
+				return
+			}
+			if applyErr := applyAutostash(out, repo, refManager, autostash); applyErr != nil && err == nil {
+				err = applyErr
+			}
+		}()
+	}
+
+	// Check out the branch to rebase when one is named
+	if len(args) == 2 {
+		branchRef := "refs/heads/" + args[1]
+		branchID, err := refManager.ResolveRef(branchRef)
+		if err != nil {
+			return nil, fmt.Errorf("invalid branch %q", args[1])
+		}
+		headID, _, err := refManager.HEAD()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read HEAD: %w", err)
+		}
+		if err := checkoutTree(repo, headID, branchID); err != nil {
+			return nil, err
+		}
+		if err := refManager.SetHEAD(branchRef); err != nil {
+			return nil, fmt.Errorf("failed to update HEAD: %w", err)
+		}
+	}
+
+	headID, headName, err := refManager.HEAD()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read HEAD: %w", err)
+	}
+	if headName == "" {
+		headName = detachedHeadName
+	}
+
+	commits, err := commitsBetween(repo, upstreamID, headID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list commits to rebase: %w", err)
+	}
+
+	var todo []rebaseStep
+	var candidates []*objects.Commit
+	var upToDate bool
+	if opts.rebaseMerges {
+		candidates = commits
+		todo = rebaseMergesTodo(commits, headID, ontoID)
+		upToDate = linesStartAt(commits, headID, ontoID)
+	} else {
+		for _, commit := range commits {
+			// Merge commits are dropped, as git does without --rebase-merges
+			if len(commit.Parents()) > 1 {
+				continue
+			}
+			candidates = append(candidates, commit)
+			todo = append(todo, rebaseStep{action: "pick", id: commit.ID(), subject: commitSubject(commit)})
+		}
+		upToDate = len(todo) == 0 && ontoID == headID || len(todo) > 0 && candidates[0].Parents()[0] == ontoID
+	}
+	todo = addExecSteps(todo, opts.exec)
+
+	report := &operationReport{Operation: "rebase", Head: headID.String()}
+
+	if !opts.interactive && len(opts.exec) == 0 && upToDate {
+		fmt.Fprintf(out, "Current branch %s is up to date.\n", strings.TrimPrefix(headName, "refs/heads/"))
+		report.Status = reportUpToDate
+		return report, nil
+	}
+
+	state := &rebaseState{
+		dir:         rebaseStateDir(repo),
+		headName:    headName,
+		onto:        ontoID,
+		origHead:    headID,
+		interactive: opts.interactive,
+		autostash:   autostash,
+		todo:        todo,
+		rewritten:   make(map[objects.ObjectID]objects.ObjectID),
+	}
+
+	if opts.interactive {
+		if err := os.MkdirAll(state.dir, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create rebase state: %w", err)
+		}
+		state.todo, err = editRebaseTodo(repo, state, candidates)
+		if err != nil || len(state.todo) == 0 {
+			os.RemoveAll(state.dir)
+			if err == nil {
+				err = fmt.Errorf("nothing to do")
+			}
+			return nil, err
+		}
+	}
+
+	if err := state.save(); err != nil {
+		return nil, err
+	}
+	started = true
+
+	// Replay on a detached HEAD; the branch moves only when the rebase ends
+	if err := checkoutTree(repo, headID, ontoID); err != nil {
+		return nil, err
+	}
+	if err := refManager.SetHEADToCommit(ontoID); err != nil {
+		return nil, fmt.Errorf("failed to update HEAD: %w", err)
+	}
+
+	return runRebaseSteps(out, repo, refManager, state)
+}
+
+// rebaseMergesTodo returns the steps recreating commits, which lead to
+// head, merges included, on top of onto. Each line of development is
+// picked after a reset to where it starts, and a merge is redone once the
+// lines it joins are.
+func rebaseMergesTodo(commits []*objects.Commit, head, onto objects.ObjectID) []rebaseStep {
+	byID := make(map[objects.ObjectID]*objects.Commit, len(commits))
+	for _, commit := range commits {
+		byID[commit.ID()] = commit
+	}
+	// Commits outside the range start from onto once rebased
+	start := func(id objects.ObjectID) objects.ObjectID {
+		if byID[id] == nil {
+			return onto
+		}
+		return id
+	}
+
+	var todo []rebaseStep
+	at := onto
+	resetTo := func(id objects.ObjectID) {
+		if id != at {
+			todo = append(todo, rebaseStep{action: "reset", id: id})
+			at = id
+		}
+	}
+
+	planned := make(map[objects.ObjectID]bool)
+	var addLine func(tip objects.ObjectID)
+	addLine = func(tip objects.ObjectID) {
+		// Follow first parents back to the start of the line
+		var line []*objects.Commit
+		for id := tip; byID[id] != nil && !planned[id]; {
+			commit := byID[id]
+			planned[id] = true
+			line = append(line, commit)
+			if len(commit.Parents()) == 0 {
+				break
+			}
+			id = commit.Parents()[0]
+		}
+		if len(line) == 0 {
+			return
+		}
+		if parents := line[len(line)-1].Parents(); len(parents) > 0 {
+			resetTo(start(parents[0]))
+		} else {
+			resetTo(onto)
+		}
+
+		for i := len(line) - 1; i >= 0; i-- {
+			commit := line[i]
+			step := rebaseStep{action: "pick", id: commit.ID(), subject: commitSubject(commit)}
+			if parents := commit.Parents(); len(parents) > 1 {
+				for _, parent := range parents[1:] {
+					addLine(parent)
+				}
+				resetTo(start(parents[0]))
+				step.action = "merge"
+			}
+			todo = append(todo, step)
+			at = commit.ID()
+		}
+	}
+	addLine(head)
+	return todo
+}
+
+// linesStartAt reports whether every line of development of commits, which
+// lead to head, already starts at onto, so rebasing them onto it with
+// --rebase-merges would change nothing
+func linesStartAt(commits []*objects.Commit, head, onto objects.ObjectID) bool {
+	if len(commits) == 0 {
+		return head == onto
+	}
+	inRange := make(map[objects.ObjectID]bool, len(commits))
+	for _, commit := range commits {
+		inRange[commit.ID()] = true
+	}
+	for _, commit := range commits {
+		parents := commit.Parents()
+		if len(parents) == 0 || !inRange[parents[0]] && parents[0] != onto {
+			return false
+		}
+	}
+	return true
+}
+
+// addExecSteps adds an exec step running each of commands after every
+// commit of todo, once any squash or fixup into it is done
+func addExecSteps(todo []rebaseStep, commands []string) []rebaseStep {
+	if len(commands) == 0 {
+		return todo
+	}
+	var steps []rebaseStep
+	for i, step := range todo {
+		steps = append(steps, step)
+		if step.action == "exec" || step.action == "drop" || step.action == "reset" {
+			continue
+		}
+		if i+1 < len(todo) && (todo[i+1].action == "squash" || todo[i+1].action == "fixup") {
+			continue
+		}
+		for _, command := range commands {
+			steps = append(steps, rebaseStep{action: "exec", command: command})
+		}
+	}
+	return steps
+}
+
+// editRebaseTodo lets the user edit the todo list of an interactive rebase
+func editRebaseTodo(repo *vcs.Repository, state *rebaseState, candidates []*objects.Commit) ([]rebaseStep, error) {
+	var b strings.Builder
+	for _, step := range state.todo {
+		if step.action == "exec" || step.action == "reset" {
+			b.WriteString(step.String() + "\n")
+			continue
+		}
+		fmt.Fprintf(&b, "%s %s %s\n", step.action, step.id.Short(), step.subject)
+	}
+	fmt.Fprintf(&b, "\n# Rebase %s onto %s (%d commands)\n", state.onto.Short(), state.onto.Short(), len(state.todo))
+	b.WriteString(rebaseTodoHelp)
+
+	edited, err := editTextWith(sequenceEditorCommand(repo.GitDir()), state.dir, "git-rebase-todo", b.String())
+	if err != nil {
+		return nil, err
+	}
+
+	steps, err := parseRebaseTodo(edited, candidates)
+	if err != nil {
+		return nil, err
+	}
+	for _, step := range steps {
+		if step.action == "drop" || step.action == "exec" || step.action == "reset" {
+			continue
+		}
+		if step.action == "squash" || step.action == "fixup" {
+			return nil, fmt.Errorf("cannot '%s' without a previous commit", step.action)
+		}
+		break
+	}
+	return steps, nil
+}
+
+// editRemainingTodo lets the user edit the steps of the rebase in progress
+// that are still to be done
+func editRemainingTodo(repo *vcs.Repository, state *rebaseState) error {
+	candidates, err := commitsBetween(repo, state.onto, state.origHead)
+	if err != nil {
+		return fmt.Errorf("failed to list commits to rebase: %w", err)
+	}
+	if state.todo, err = editRebaseTodo(repo, state, candidates); err != nil {
+		return err
+	}
+	return state.save()
+}
+
+// runRebaseSteps applies the remaining todo steps and finishes the rebase
+func runRebaseSteps(out io.Writer, repo *vcs.Repository, refManager *refs.RefManager, state *rebaseState) (*operationReport, error) {
+	report := &operationReport{Operation: "rebase"}
+
+	for len(state.todo) > 0 {
+		step := state.todo[0]
+		state.todo = state.todo[1:]
+		state.done = append(state.done, step)
+
+		if step.action == "drop" {
+			continue
+		}
+		if step.action == "exec" {
+			if err := state.save(); err != nil {
+				return nil, err
+			}
+			if err := runRebaseExec(out, repo, step.command); err != nil {
+				fmt.Fprintf(out, "warning: execution failed: %s\n", step.command)
+				fmt.Fprintf(out, "You can fix the problem, and then run\n\n  vcs rebase --continue\n\n")
+				if err := fillRebaseReport(repo, refManager, state, report); err != nil {
+					return nil, err
+				}
+				report.Status = reportStopped
+				return report, fmt.Errorf("rebase stopped: %q failed: %w", step.command, err)
+			}
+			continue
+		}
+		if step.action == "reset" {
+			if err := resetRebaseHead(repo, refManager, state.rewrittenID(step.id)); err != nil {
+				return nil, err
+			}
+			if err := state.save(); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		conflicts, err := applyRebaseStep(out, repo, refManager, state, step)
+		if err != nil {
+			return nil, err
+		}
+		if len(conflicts) > 0 {
+			state.stopped = true
+			if err := state.save(); err != nil {
+				return nil, err
+			}
+
+			label := step.id.Short() + " (" + step.subject + ")"
+			printMergeConflicts(out, conflicts, label)
+			if err := rerereConflicts(out, repo, conflicts); err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(out, "error: could not apply %s... %s\n", step.id.Short(), step.subject)
+			fmt.Fprintf(out, "hint: Resolve all conflicts manually, mark them as resolved with\n")
+			fmt.Fprintf(out, "hint: \"vcs add <conflicted_files>\", then run \"vcs rebase --continue\".\n")
+			fmt.Fprintf(out, "hint: You can instead skip this commit: run \"vcs rebase --skip\".\n")
+			fmt.Fprintf(out, "hint: To abort and get back to the state before \"vcs rebase\", run \"vcs rebase --abort\".\n")
+
+			if err := fillRebaseReport(repo, refManager, state, report); err != nil {
+				return nil, err
+			}
+			report.Status = reportConflicted
+			report.Conflicts = newReportConflicts(conflicts)
+			return report, errRebaseConflict
+		}
+
+		if err := state.save(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := fillRebaseReport(repo, refManager, state, report); err != nil {
+		return nil, err
+	}
+	if err := finishRebase(out, repo, refManager, state); err != nil {
+		return nil, err
+	}
+	report.Status = reportCompleted
+	return report, nil
+}
+
+// runRebaseExec runs the command of an exec step with the shell at the top
+// of the working tree
+func runRebaseExec(out io.Writer, repo *vcs.Repository, command string) error {
+	fmt.Fprintf(out, "Executing: %s\n", command)
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Dir = repo.WorkDir()
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = out
+	cmd.Stderr = out
+	return cmd.Run()
+}
+
+// resetRebaseHead checks out id on the detached HEAD of the rebase
+func resetRebaseHead(repo *vcs.Repository, refManager *refs.RefManager, id objects.ObjectID) error {
+	headID, _, err := refManager.HEAD()
+	if err != nil {
+		return fmt.Errorf("failed to read HEAD: %w", err)
+	}
+	if headID == id {
+		return nil
+	}
+	if err := checkoutTree(repo, headID, id); err != nil {
+		return err
+	}
+	if err := refManager.SetHEADToCommit(id); err != nil {
+		return fmt.Errorf("failed to update HEAD: %w", err)
+	}
+	return nil
+}
+
+// applyRebaseStep cherry-picks the commit of step onto HEAD, or for a merge
+// step merges into HEAD what the merge's other parent was rebased to. On
+// conflict the working tree and index are left with the conflicts and they
+// are returned.
+func applyRebaseStep(out io.Writer, repo *vcs.Repository, refManager *refs.RefManager, state *rebaseState, step rebaseStep) ([]merge.Conflict, error) {
+	headID, _, err := refManager.HEAD()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read HEAD: %w", err)
+	}
+	head, err := repo.GetCommit(headID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read HEAD commit: %w", err)
+	}
+	commit, err := repo.GetCommit(step.id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read commit %s: %w", step.id.Short(), err)
+	}
+
+	var result *merge.Result
+	if step.action == "merge" {
+		parents := commit.Parents()
+		if len(parents) != 2 {
+			return nil, fmt.Errorf("cannot rebase %s: only merges of two parents can be redone", step.id.Short())
+		}
+		other := state.rewrittenID(parents[1])
+		if merged, err := isAncestor(repo, other, headID); err != nil {
+			return nil, fmt.Errorf("failed to check ancestry: %w", err)
+		} else if merged {
+			fmt.Fprintf(out, "dropping %s %s -- merged changes already upstream\n", step.id.Short(), step.subject)
+			state.rewritten[step.id] = headID
+			return nil, nil
+		}
+		result, err = merge.Commits(repo, newResolver(repo).MergeBasesOf, headID, other, merge.Options{
+			OursLabel:   "HEAD",
+			TheirsLabel: other.Short(),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to merge %s: %w", other.Short(), err)
+		}
+	} else {
+		var baseTree objects.ObjectID
+		if parents := commit.Parents(); len(parents) > 0 {
+			parent, err := repo.GetCommit(parents[0])
+			if err != nil {
+				return nil, fmt.Errorf("failed to read parent of %s: %w", step.id.Short(), err)
+			}
+			baseTree = parent.Tree()
+		}
+
+		result, err = merge.Trees(repo, baseTree, head.Tree(), commit.Tree(), merge.Options{
+			OursLabel:   "HEAD",
+			TheirsLabel: step.id.Short() + " (" + step.subject + ")",
+		})
+		if err != nil {
+			return nil, fmt.Errorf("failed to apply %s: %w", step.id.Short(), err)
+		}
+	}
+
+	if err := checkoutMergeResult(repo, head.Tree(), result); err != nil {
+		return nil, fmt.Errorf("failed to update working directory: %w", err)
+	}
+
+	if !result.Clean() {
+		if err := writeMergeIndex(repo, result); err != nil {
+			return nil, err
+		}
+		return result.Conflicts, nil
+	}
+
+	treeID, err := merge.WriteTree(repo, result.Entries)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write tree: %w", err)
+	}
+	return nil, commitRebaseStep(out, repo, refManager, state, step, head, commit, treeID)
+}
+
+// commitRebaseStep records the result of applying step with tree treeID
+func commitRebaseStep(out io.Writer, repo *vcs.Repository, refManager *refs.RefManager, state *rebaseState, step rebaseStep, head, commit *objects.Commit, treeID objects.ObjectID) error {
+	committer, err := getSignature("")
+	if err != nil {
+		return err
+	}
+
+	author := commit.Author()
+	parents := []objects.ObjectID{head.ID()}
+	message := commit.Message()
+
+	switch step.action {
+	case "merge":
+		// A merge is recorded even when it changes nothing
+		parents = append(parents, state.rewrittenID(commit.Parents()[1]))
+
+	case "squash", "fixup":
+		// Meld into the commit made by the previous step
+		author = head.Author()
+		parents = head.Parents()
+		message = head.Message()
+		if step.action == "squash" {
+			combined := fmt.Sprintf("# This is a combination of 2 commits.\n# This is the 1st commit message:\n\n%s\n# This is the commit message #2:\n\n%s", head.Message(), commit.Message())
+			if message, err = editText(state.dir, "message", combined); err != nil {
+				return err
+			}
+		}
+
+	default:
+		if treeID == head.Tree() {
+			fmt.Fprintf(out, "dropping %s %s -- patch contents already upstream\n", step.id.Short(), step.subject)
+			state.rewritten[step.id] = head.ID()
+			return resetIndexToHead(repo)
+		}
+		if step.action == "reword" {
+			if message, err = editText(state.dir, "message", commit.Message()); err != nil {
+				return err
+			}
+		}
+	}
+
+	if strings.TrimSpace(message) == "" {
+		return fmt.Errorf("aborting commit due to empty commit message")
+	}
+
+	newCommit, err := repo.CreateCommit(treeID, parents, author, committer, message)
+	if err != nil {
+		return fmt.Errorf("failed to create commit: %w", err)
+	}
+	if err := refManager.SetHEADToCommit(newCommit.ID()); err != nil {
+		return fmt.Errorf("failed to update HEAD: %w", err)
+	}
+	state.rewritten[step.id] = newCommit.ID()
+	return resetIndexToHead(repo)
+}
+
+// continueRebase commits the resolved conflicts of the stopped step and
+// applies the rest of the todo list
+func continueRebase(out io.Writer, repo *vcs.Repository, refManager *refs.RefManager, state *rebaseState) (*operationReport, error) {
+	if state.stopped {
+		idx := index.New()
+		if err := idx.ReadFromFile(filepath.Join(repo.GitDir(), "index")); err != nil {
+			return nil, fmt.Errorf("failed to read index: %w", err)
+		}
+		if unmerged := idx.Unmerged(); len(unmerged) > 0 {
+			return nil, fmt.Errorf("you must edit all merge conflicts and then mark them as resolved using vcs add: %s", strings.Join(unmerged, ", "))
+		}
+		if err := recordRerereResolutions(out, repo); err != nil {
+			return nil, err
+		}
+
+		var entries []merge.Entry
+		for _, e := range idx.Entries() {
+			entries = append(entries, merge.Entry{Path: e.Path, Mode: e.Mode, ID: e.ID})
+		}
+		treeID, err := merge.WriteTree(repo, entries)
+		if err != nil {
+			return nil, fmt.Errorf("failed to write tree: %w", err)
+		}
+
+		step := state.done[len(state.done)-1]
+		headID, _, err := refManager.HEAD()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read HEAD: %w", err)
+		}
+		head, err := repo.GetCommit(headID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read HEAD commit: %w", err)
+		}
+		commit, err := repo.GetCommit(step.id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read commit %s: %w", step.id.Short(), err)
+		}
+
+		if err := commitRebaseStep(out, repo, refManager, state, step, head, commit, treeID); err != nil {
+			return nil, err
+		}
+		state.stopped = false
+		if err := state.save(); err != nil {
+			return nil, err
+		}
+	}
+
+	return runRebaseSteps(out, repo, refManager, state)
+}
+
+// skipRebase drops the stopped step and applies the rest of the todo list
+func skipRebase(out io.Writer, repo *vcs.Repository, refManager *refs.RefManager, state *rebaseState) (*operationReport, error) {
+	headID, _, err := refManager.HEAD()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read HEAD: %w", err)
+	}
+	if err := restoreWorkingTree(repo, headID); err != nil {
+		return nil, err
+	}
+	if err := clearRerere(repo); err != nil {
+		return nil, err
+	}
+
+	// Later steps build on a skipped commit as on the one it was to go on
+	if state.stopped && len(state.done) > 0 {
+		state.rewritten[state.done[len(state.done)-1].id] = headID
+	}
+	state.stopped = false
+	if err := state.save(); err != nil {
+		return nil, err
+	}
+	return runRebaseSteps(out, repo, refManager, state)
+}
+
+// abortRebase restores the branch and working tree from before the rebase
+func abortRebase(out io.Writer, repo *vcs.Repository, refManager *refs.RefManager, state *rebaseState) (*operationReport, error) {
+	if err := restoreWorkingTree(repo, state.origHead); err != nil {
+		return nil, err
+	}
+	if err := clearRerere(repo); err != nil {
+		return nil, err
+	}
+
+	if state.headName == detachedHeadName {
+		if err := refManager.SetHEADToCommit(state.origHead); err != nil {
+			return nil, fmt.Errorf("failed to update HEAD: %w", err)
+		}
+	} else if err := refManager.SetHEAD(state.headName); err != nil {
+		return nil, fmt.Errorf("failed to update HEAD: %w", err)
+	}
+
+	if err := os.RemoveAll(state.dir); err != nil {
+		return nil, fmt.Errorf("failed to remove rebase state: %w", err)
+	}
+	if !state.autostash.IsZero() {
+		if err := applyAutostash(out, repo, refManager, state.autostash); err != nil {
+			return nil, err
+		}
+	}
+
+	return &operationReport{
+		Operation: "rebase",
+		Status:    reportAborted,
+		Head:      state.origHead.String(),
+	}, nil
+}
+
+// finishRebase moves the rebased branch to HEAD and removes the state
+func finishRebase(out io.Writer, repo *vcs.Repository, refManager *refs.RefManager, state *rebaseState) error {
+	headID, _, err := refManager.HEAD()
+	if err != nil {
+		return fmt.Errorf("failed to read HEAD: %w", err)
+	}
+
+	if state.headName != detachedHeadName {
+		if err := refManager.WriteRef(state.headName, headID, nil); err != nil {
+			return fmt.Errorf("failed to update %s: %w", state.headName, err)
+		}
+		if err := refManager.SetHEAD(state.headName); err != nil {
+			return fmt.Errorf("failed to update HEAD: %w", err)
+		}
+	}
+
+	if err := os.RemoveAll(state.dir); err != nil {
+		return fmt.Errorf("failed to remove rebase state: %w", err)
+	}
+	if !state.autostash.IsZero() {
+		if err := applyAutostash(out, repo, refManager, state.autostash); err != nil {
+			return err
+		}
+	}
+
+	if state.headName == detachedHeadName {
+		fmt.Fprintf(out, "Successfully rebased.\n")
+	} else {
+		fmt.Fprintf(out, "Successfully rebased and updated %s.\n", state.headName)
+	}
+	return nil
+}
+
+// fillRebaseReport records the commits rebased so far and HEAD in report
+func fillRebaseReport(repo *vcs.Repository, refManager *refs.RefManager, state *rebaseState, report *operationReport) error {
+	headID, _, err := refManager.HEAD()
+	if err != nil {
+		return fmt.Errorf("failed to read HEAD: %w", err)
+	}
+	report.Head = headID.String()
+
+	applied, err := commitsBetween(repo, state.onto, headID)
+	if err != nil {
+		return fmt.Errorf("failed to list rebased commits: %w", err)
+	}
+	report.Applied = nil
+	for _, commit := range applied {
+		report.Applied = append(report.Applied, newReportCommit(commit))
+	}
+	return nil
+}
+
+// checkoutTree updates the working directory and index from commit from to
+// commit to
+func checkoutTree(repo *vcs.Repository, from, to objects.ObjectID) error {
+	fromCommit, err := repo.GetCommit(from)
+	if err != nil {
+		return fmt.Errorf("failed to read commit %s: %w", from.Short(), err)
+	}
+	toCommit, err := repo.GetCommit(to)
+	if err != nil {
+		return fmt.Errorf("failed to read commit %s: %w", to.Short(), err)
+	}
+
+	files, err := merge.ReadTree(repo, toCommit.Tree())
+	if err != nil {
+		return fmt.Errorf("failed to read tree: %w", err)
+	}
+	result := &merge.Result{Entries: files}
+	if err := checkoutMergeResult(repo, fromCommit.Tree(), result); err != nil {
+		return fmt.Errorf("failed to update working directory: %w", err)
+	}
+	return writeMergeIndex(repo, result)
+}
+
+// restoreWorkingTree makes the working directory match commit id after an
+// interrupted operation. Files the operation added are found through the
+// index, which then gets the files of id.
+func restoreWorkingTree(repo *vcs.Repository, id objects.ObjectID) error {
+	commit, err := repo.GetCommit(id)
+	if err != nil {
+		return fmt.Errorf("failed to read commit %s: %w", id.Short(), err)
+	}
+	files, err := merge.ReadTree(repo, commit.Tree())
+	if err != nil {
+		return fmt.Errorf("failed to read tree: %w", err)
+	}
+
+	keep := make(map[string]bool)
+	for _, file := range files {
+		keep[file.Path] = true
+	}
+
+	idx := index.New()
+	if err := idx.ReadFromFile(filepath.Join(repo.GitDir(), "index")); err == nil {
+		for _, e := range idx.Entries() {
+			if keep[e.Path] {
+				continue
+			}
+			if err := os.Remove(filepath.Join(repo.WorkDir(), e.Path)); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove %s: %w", e.Path, err)
+			}
+		}
+	}
+
+	conv := newConverter(repo, os.Stderr, nil)
+	for _, file := range files {
+		if file.Mode == objects.ModeCommit {
+			continue
+		}
+		blob, err := repo.GetBlob(file.ID)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", file.Path, err)
+		}
+		if err := writeWorkingFile(repo, conv, file.Path, file.Mode, blob.Data()); err != nil {
+			return err
+		}
+	}
+
+	return writeMergeIndex(repo, &merge.Result{Entries: files})
+}
+
+// commitSubject returns the first line of a commit message
+func commitSubject(commit *objects.Commit) string {
+	subject := strings.TrimSpace(commit.Message())
+	if i := strings.IndexByte(subject, '\n'); i >= 0 {
+		subject = subject[:i]
+	}
+	return subject
+}
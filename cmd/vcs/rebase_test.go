@@ -0,0 +1,396 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// rebaseFixture is a repository with a topic branch forked from main
+type rebaseFixture struct {
+	repo   *vcs.Repository
+	refs   *refs.RefManager
+	main   objects.ObjectID
+	topic  []objects.ObjectID
+	topics []map[string]string
+}
+
+// setupRebaseRepo creates base, one commit on main and a topic branch with
+// one commit per entry of topic, each holding the full file set. The topic
+// branch is checked out.
+func setupRebaseRepo(t *testing.T, base, main map[string]string, topic ...map[string]string) *rebaseFixture {
+	repoPath := t.TempDir()
+	repo, err := vcs.Init(repoPath)
+	require.NoError(t, err)
+
+	f := &rebaseFixture{repo: repo, refs: refs.NewRefManager(repo.GitDir()), topics: topic}
+	baseID := commitFiles(t, repo, base, nil, "base\n")
+	f.main = commitFiles(t, repo, main, []objects.ObjectID{baseID}, "main change\n")
+
+	parent := baseID
+	for i, files := range topic {
+		parent = commitFiles(t, repo, files, []objects.ObjectID{parent}, "topic "+string(rune('1'+i))+"\n")
+		f.topic = append(f.topic, parent)
+	}
+
+	require.NoError(t, f.refs.UpdateRef("refs/heads/main", f.main))
+	require.NoError(t, f.refs.UpdateRef("refs/heads/topic", parent))
+	require.NoError(t, f.refs.SetHEAD("refs/heads/topic"))
+	for name, content := range topic[len(topic)-1] {
+		require.NoError(t, os.WriteFile(filepath.Join(repoPath, name), []byte(content), 0644))
+	}
+
+	oldWd, _ := os.Getwd()
+	t.Cleanup(func() { os.Chdir(oldWd) })
+	require.NoError(t, os.Chdir(repoPath))
+
+	return f
+}
+
+func runRebaseArgs(args ...string) (string, string, error) {
+	cmd := newRebaseCommand()
+	var stdout, stderr bytes.Buffer
+	cmd.SetOut(&stdout)
+	cmd.SetErr(&stderr)
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+	return stdout.String(), stderr.String(), err
+}
+
+// history returns the commits from the topic branch back to stop
+func (f *rebaseFixture) history(t *testing.T, stop objects.ObjectID) []*objects.Commit {
+	return f.branchHistory(t, "refs/heads/topic", stop)
+}
+
+// branchHistory returns the commits from ref back to stop
+func (f *rebaseFixture) branchHistory(t *testing.T, ref string, stop objects.ObjectID) []*objects.Commit {
+	id, err := f.refs.ResolveRef(ref)
+	require.NoError(t, err)
+
+	var commits []*objects.Commit
+	for id != stop {
+		commit, err := f.repo.GetCommit(id)
+		require.NoError(t, err)
+		require.Len(t, commit.Parents(), 1)
+		commits = append(commits, commit)
+		id = commit.Parents()[0]
+	}
+	return commits
+}
+
+func (f *rebaseFixture) readFile(t *testing.T, name string) string {
+	data, err := os.ReadFile(filepath.Join(f.repo.WorkDir(), name))
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestRebaseOntoUpstream(t *testing.T) {
+	f := setupRebaseRepo(t,
+		map[string]string{"a.txt": "base\n"},
+		map[string]string{"a.txt": "base\n", "main.txt": "main\n"},
+		map[string]string{"a.txt": "topic\n"},
+		map[string]string{"a.txt": "topic\n", "t.txt": "t\n"},
+	)
+
+	out, _, err := runRebaseArgs("main")
+	require.NoError(t, err)
+	assert.Contains(t, out, "Successfully rebased and updated refs/heads/topic.")
+
+	commits := f.history(t, f.main)
+	require.Len(t, commits, 2)
+	assert.Equal(t, "topic 2\n", commits[0].Message())
+	assert.Equal(t, "topic 1\n", commits[1].Message())
+	assert.Equal(t, "Test", commits[1].Author().Name)
+
+	assert.Equal(t, "topic\n", f.readFile(t, "a.txt"))
+	assert.Equal(t, "main\n", f.readFile(t, "main.txt"))
+	assert.Equal(t, "t\n", f.readFile(t, "t.txt"))
+
+	head, err := f.refs.SymbolicHEAD()
+	require.NoError(t, err)
+	assert.Equal(t, "refs/heads/topic", head)
+	assert.NoDirExists(t, filepath.Join(f.repo.GitDir(), "rebase-merge"))
+
+	out, _, err = runRebaseArgs("main")
+	require.NoError(t, err)
+	assert.Contains(t, out, "Current branch topic is up to date.")
+}
+
+func TestRebaseConflictContinue(t *testing.T) {
+	f := setupRebaseRepo(t,
+		map[string]string{"a.txt": "base\n"},
+		map[string]string{"a.txt": "main\n"},
+		map[string]string{"a.txt": "topic\n"},
+		map[string]string{"a.txt": "topic\n", "t.txt": "t\n"},
+	)
+
+	stdout, stderr, err := runRebaseArgs("--report=json", "main")
+	require.ErrorIs(t, err, errRebaseConflict)
+	assert.Contains(t, stderr, "CONFLICT (content): Merge conflict in a.txt")
+	assert.Contains(t, stderr, "could not apply "+f.topic[0].Short()+"... topic 1")
+
+	var report operationReport
+	require.NoError(t, json.Unmarshal([]byte(stdout), &report))
+	assert.Equal(t, "rebase", report.Operation)
+	assert.Equal(t, reportConflicted, report.Status)
+	assert.Equal(t, f.main.String(), report.Head)
+	assert.False(t, report.Resolved)
+	require.Len(t, report.Conflicts, 1)
+	assert.Equal(t, "a.txt", report.Conflicts[0].Path)
+
+	assert.FileExists(t, filepath.Join(f.repo.GitDir(), "rebase-merge", "stopped-sha"))
+	assert.Contains(t, f.readFile(t, "a.txt"), "<<<<<<< HEAD\nmain\n=======\ntopic\n>>>>>>> "+f.topic[0].Short())
+
+	_, _, err = runRebaseArgs("--continue")
+	assert.ErrorContains(t, err, "mark them as resolved")
+
+	require.NoError(t, os.WriteFile(filepath.Join(f.repo.WorkDir(), "a.txt"), []byte("resolved\n"), 0644))
+	addCmd := newAddCommand()
+	addCmd.SetOut(&bytes.Buffer{})
+	addCmd.SetArgs([]string{"a.txt"})
+	require.NoError(t, addCmd.Execute())
+
+	stdout, _, err = runRebaseArgs("--continue", "--report=json")
+	require.NoError(t, err)
+	report = operationReport{}
+	require.NoError(t, json.Unmarshal([]byte(stdout), &report))
+	assert.Equal(t, reportCompleted, report.Status)
+	assert.True(t, report.Resolved)
+	require.Len(t, report.Applied, 2)
+	assert.Equal(t, "topic 1", report.Applied[0].Subject)
+
+	commits := f.history(t, f.main)
+	require.Len(t, commits, 2)
+	assert.Equal(t, "resolved\n", f.readFile(t, "a.txt"))
+	assert.Equal(t, "t\n", f.readFile(t, "t.txt"))
+	assert.NoDirExists(t, filepath.Join(f.repo.GitDir(), "rebase-merge"))
+}
+
+func TestRebaseAbort(t *testing.T) {
+	f := setupRebaseRepo(t,
+		map[string]string{"a.txt": "base\n"},
+		map[string]string{"a.txt": "main\n"},
+		map[string]string{"a.txt": "topic\n", "new.txt": "new\n"},
+	)
+
+	_, _, err := runRebaseArgs("main")
+	require.ErrorIs(t, err, errRebaseConflict)
+	assert.FileExists(t, filepath.Join(f.repo.WorkDir(), "new.txt"))
+
+	_, _, err = runRebaseArgs("main")
+	assert.ErrorContains(t, err, "already in progress")
+
+	_, _, err = runRebaseArgs("--abort")
+	require.NoError(t, err)
+
+	head, err := f.refs.SymbolicHEAD()
+	require.NoError(t, err)
+	assert.Equal(t, "refs/heads/topic", head)
+	id, err := f.refs.ResolveRef("refs/heads/topic")
+	require.NoError(t, err)
+	assert.Equal(t, f.topic[0], id)
+	assert.Equal(t, "topic\n", f.readFile(t, "a.txt"))
+	assert.Equal(t, "new\n", f.readFile(t, "new.txt"))
+	assert.NoDirExists(t, filepath.Join(f.repo.GitDir(), "rebase-merge"))
+
+	_, _, err = runRebaseArgs("--continue")
+	assert.ErrorContains(t, err, "no rebase in progress")
+}
+
+func TestRebaseSkip(t *testing.T) {
+	f := setupRebaseRepo(t,
+		map[string]string{"a.txt": "base\n"},
+		map[string]string{"a.txt": "main\n"},
+		map[string]string{"a.txt": "topic\n", "new.txt": "new\n"},
+		map[string]string{"a.txt": "topic\n", "new.txt": "new\n", "t.txt": "t\n"},
+	)
+
+	_, _, err := runRebaseArgs("main")
+	require.ErrorIs(t, err, errRebaseConflict)
+
+	_, _, err = runRebaseArgs("--skip")
+	require.NoError(t, err)
+
+	commits := f.history(t, f.main)
+	require.Len(t, commits, 1)
+	assert.Equal(t, "topic 2\n", commits[0].Message())
+	assert.Equal(t, "main\n", f.readFile(t, "a.txt"))
+	assert.Equal(t, "t\n", f.readFile(t, "t.txt"))
+}
+
+func TestRebaseInteractive(t *testing.T) {
+	f := setupRebaseRepo(t,
+		map[string]string{"a.txt": "base\n"},
+		map[string]string{"a.txt": "base\n", "main.txt": "main\n"},
+		map[string]string{"a.txt": "one\n"},
+		map[string]string{"a.txt": "one\n", "b.txt": "b\n"},
+		map[string]string{"a.txt": "one\n", "b.txt": "b fixed\n"},
+		map[string]string{"a.txt": "one\n", "b.txt": "b fixed\n", "dropped.txt": "x\n"},
+	)
+
+	editor := filepath.Join(t.TempDir(), "editor.sh")
+	script := `#!/bin/sh
+case "$1" in
+*git-rebase-todo) sed -i -e '1s/^pick/reword/' -e '3s/^pick/fixup/' -e '4s/^pick/drop/' "$1" ;;
+*) printf 'reworded\n' > "$1" ;;
+esac
+`
+	require.NoError(t, os.WriteFile(editor, []byte(script), 0755))
+	t.Setenv("GIT_EDITOR", editor)
+
+	_, _, err := runRebaseArgs("-i", "main")
+	require.NoError(t, err)
+
+	commits := f.history(t, f.main)
+	require.Len(t, commits, 2)
+	assert.Equal(t, "topic 2\n", commits[0].Message())
+	assert.Equal(t, "reworded\n", commits[1].Message())
+	assert.Equal(t, "b fixed\n", f.readFile(t, "b.txt"))
+	assert.NoFileExists(t, filepath.Join(f.repo.WorkDir(), "dropped.txt"))
+}
+
+func TestRebaseInteractiveEmptyTodo(t *testing.T) {
+	f := setupRebaseRepo(t,
+		map[string]string{"a.txt": "base\n"},
+		map[string]string{"a.txt": "base\n", "main.txt": "main\n"},
+		map[string]string{"a.txt": "topic\n"},
+	)
+	t.Setenv("GIT_EDITOR", "sed -i -e '/^pick/d'")
+
+	_, _, err := runRebaseArgs("-i", "main")
+	assert.ErrorContains(t, err, "nothing to do")
+
+	id, err := f.refs.ResolveRef("refs/heads/topic")
+	require.NoError(t, err)
+	assert.Equal(t, f.topic[0], id)
+	assert.NoDirExists(t, filepath.Join(f.repo.GitDir(), "rebase-merge"))
+}
+
+func TestParseRebaseTodo(t *testing.T) {
+	repo, err := vcs.Init(t.TempDir())
+	require.NoError(t, err)
+	sig := objects.Signature{Name: "Test", Email: "test@example.com"}
+	tree, err := repo.CreateTree(nil)
+	require.NoError(t, err)
+	first, err := repo.CreateCommit(tree.ID(), nil, sig, sig, "first\n")
+	require.NoError(t, err)
+	second, err := repo.CreateCommit(tree.ID(), []objects.ObjectID{first.ID()}, sig, sig, "second\n")
+	require.NoError(t, err)
+	candidates := []*objects.Commit{first, second}
+
+	todo := "# comment\n\np " + first.ID().Short() + " first\nf " + second.ID().String() + "\n"
+	steps, err := parseRebaseTodo(todo, candidates)
+	require.NoError(t, err)
+	require.Len(t, steps, 2)
+	assert.Equal(t, rebaseStep{action: "pick", id: first.ID(), subject: "first"}, steps[0])
+	assert.Equal(t, rebaseStep{action: "fixup", id: second.ID()}, steps[1])
+
+	_, err = parseRebaseTodo("edit "+first.ID().Short(), candidates)
+	assert.ErrorContains(t, err, `unknown command "edit"`)
+
+	_, err = parseRebaseTodo("pick 0000000", candidates)
+	assert.ErrorContains(t, err, "unknown commit")
+
+	_, err = parseRebaseTodo("pick", candidates)
+	assert.ErrorContains(t, err, "missing commit")
+
+	steps, err = parseRebaseTodo("x make test\nexec  go vet ./...\n", candidates)
+	require.NoError(t, err)
+	assert.Equal(t, []rebaseStep{{action: "exec", command: "make test"}, {action: "exec", command: "go vet ./..."}}, steps)
+	assert.Equal(t, "exec make test", steps[0].String())
+
+	_, err = parseRebaseTodo("exec", candidates)
+	assert.ErrorContains(t, err, "missing command")
+}
+
+func TestRebaseExec(t *testing.T) {
+	f := setupRebaseRepo(t,
+		map[string]string{"a.txt": "base\n"},
+		map[string]string{"a.txt": "base\n", "main.txt": "main\n"},
+		map[string]string{"a.txt": "one\n"},
+		map[string]string{"a.txt": "two\n"},
+	)
+	log := filepath.Join(t.TempDir(), "log")
+
+	out, _, err := runRebaseArgs("main", "--exec", "cat a.txt >> "+log, "-x", "echo done >> "+log)
+	require.NoError(t, err)
+	assert.Contains(t, out, "Executing: cat a.txt >> "+log)
+
+	data, err := os.ReadFile(log)
+	require.NoError(t, err)
+	assert.Equal(t, "one\ndone\ntwo\ndone\n", string(data))
+	assert.Len(t, f.history(t, f.main), 2)
+}
+
+func TestRebaseExecFailureContinue(t *testing.T) {
+	f := setupRebaseRepo(t,
+		map[string]string{"a.txt": "base\n"},
+		map[string]string{"a.txt": "base\n", "main.txt": "main\n"},
+		map[string]string{"a.txt": "one\n"},
+		map[string]string{"a.txt": "two\n"},
+		map[string]string{"a.txt": "three\n"},
+	)
+	marker := filepath.Join(t.TempDir(), "ok")
+
+	out, _, err := runRebaseArgs("main", "--report=json", "-x", "test -f "+marker)
+	require.Error(t, err)
+	var report operationReport
+	require.NoError(t, json.Unmarshal([]byte(out), &report))
+	assert.Equal(t, reportStopped, report.Status)
+	assert.Len(t, report.Applied, 1)
+	assert.Equal(t, "one\n", f.readFile(t, "a.txt"))
+
+	// Tooling drops the remaining checks from the todo list
+	t.Setenv("GIT_SEQUENCE_EDITOR", "sed -i -e '/^exec/d'")
+	t.Setenv("GIT_EDITOR", "false")
+	_, _, err = runRebaseArgs("--edit-todo")
+	require.NoError(t, err)
+	todo, err := os.ReadFile(filepath.Join(f.repo.GitDir(), "rebase-merge", "git-rebase-todo"))
+	require.NoError(t, err)
+	assert.NotContains(t, string(todo), "exec")
+
+	out, _, err = runRebaseArgs("--continue")
+	require.NoError(t, err)
+	assert.Contains(t, out, "Successfully rebased")
+	assert.Len(t, f.history(t, f.main), 3)
+	assert.Equal(t, "three\n", f.readFile(t, "a.txt"))
+}
+
+func TestRebaseSequenceEditor(t *testing.T) {
+	f := setupRebaseRepo(t,
+		map[string]string{"a.txt": "base\n"},
+		map[string]string{"a.txt": "base\n", "main.txt": "main\n"},
+		map[string]string{"a.txt": "one\n"},
+		map[string]string{"a.txt": "one\n", "b.txt": "b\n"},
+	)
+	log := filepath.Join(t.TempDir(), "log")
+
+	// The todo list goes to sequence.editor, and GIT_EDITOR is never run
+	t.Setenv("GIT_EDITOR", "false")
+	cfg := filepath.Join(f.repo.GitDir(), "config")
+	data, err := os.ReadFile(cfg)
+	require.NoError(t, err)
+	editor := `sed -i -e '2s/^pick/fixup/' -e '$a exec echo ran >> ` + log + `'`
+	data = append(data, []byte("[sequence]\n\teditor = \""+editor+"\"\n")...)
+	require.NoError(t, os.WriteFile(cfg, data, 0644))
+
+	_, _, err = runRebaseArgs("-i", "main")
+	require.NoError(t, err)
+
+	commits := f.history(t, f.main)
+	require.Len(t, commits, 1)
+	assert.Equal(t, "topic 1\n", commits[0].Message())
+	assert.Equal(t, "b\n", f.readFile(t, "b.txt"))
+	ran, err := os.ReadFile(log)
+	require.NoError(t, err)
+	assert.Equal(t, "ran\n", string(ran))
+}
@@ -0,0 +1,563 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/internal/core/signing"
+	"github.com/fenilsonani/vcs/internal/revparse"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// refAtoms are the fields a ref format may name as %(field). Each but
+// refname, HEAD, symref and upstream also reads the object a tag points
+// to when written %(*field).
+var refAtoms = map[string]bool{
+	"refname": true, "objectname": true, "objecttype": true, "objectsize": true,
+	"HEAD": true, "symref": true, "upstream": true,
+	"subject": true, "body": true, "contents": true,
+	"authorname": true, "authoremail": true, "authordate": true,
+	"committername": true, "committeremail": true, "committerdate": true,
+	"taggername": true, "taggeremail": true, "taggerdate": true,
+	"creator": true, "creatordate": true,
+}
+
+// refFormatPart is literal text or a %(name:modifier) field of a format
+type refFormatPart struct {
+	literal  string
+	field    string
+	modifier string
+	deref    bool
+}
+
+// refFormat is a parsed ref format, as for-each-ref --format takes it
+type refFormat []refFormatPart
+
+// parseRefFormat parses format: text with %(field) and %(field:modifier)
+// placeholders, %% for a percent sign and %xx for the byte of hex xx
+func parseRefFormat(format string) (refFormat, error) {
+	var parts refFormat
+	var literal strings.Builder
+	for i := 0; i < len(format); i++ {
+		c := format[i]
+		if c != '%' || i+1 == len(format) {
+			literal.WriteByte(c)
+			continue
+		}
+		switch next := format[i+1]; {
+		case next == '%':
+			literal.WriteByte('%')
+			i++
+		case next == '(':
+			end := strings.IndexByte(format[i:], ')')
+			if end < 0 {
+				return nil, fmt.Errorf("malformed format string %s", format[i:])
+			}
+			part, err := parseRefField(format[i+2 : i+end])
+			if err != nil {
+				return nil, err
+			}
+			if literal.Len() > 0 {
+				parts = append(parts, refFormatPart{literal: literal.String()})
+				literal.Reset()
+			}
+			parts = append(parts, part)
+			i += end
+		case i+2 < len(format):
+			if b, err := hex.DecodeString(format[i+1 : i+3]); err == nil {
+				literal.Write(b)
+				i += 2
+				continue
+			}
+			literal.WriteByte(c)
+		default:
+			literal.WriteByte(c)
+		}
+	}
+	if literal.Len() > 0 {
+		parts = append(parts, refFormatPart{literal: literal.String()})
+	}
+	return parts, nil
+}
+
+// parseRefField parses the inside of a %(...) placeholder
+func parseRefField(spec string) (refFormatPart, error) {
+	field, modifier, _ := strings.Cut(spec, ":")
+	part := refFormatPart{field: strings.TrimPrefix(field, "*"), modifier: modifier, deref: strings.HasPrefix(field, "*")}
+	if part.field == "contents" && (modifier == "subject" || modifier == "body") {
+		part.field, part.modifier = modifier, ""
+	}
+	if !refAtoms[part.field] {
+		return part, fmt.Errorf("unknown field name: %s", field)
+	}
+	return part, nil
+}
+
+// refItem is a ref being formatted, with the objects it leads to read
+// when first needed
+type refItem struct {
+	name   string
+	id     objects.ObjectID
+	symref string
+
+	obj, peeled objects.Object
+	loaded      bool
+}
+
+// newRefItems reads the refs under prefix, resolving symbolic ones
+func newRefItems(refManager *refs.RefManager, prefix string) ([]*refItem, error) {
+	found, err := refManager.Store().ListRefs(prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list refs: %w", err)
+	}
+	items := make([]*refItem, 0, len(found))
+	for _, ref := range found {
+		item := &refItem{name: ref.Name, id: ref.ID, symref: ref.Target}
+		if ref.IsSymbolic() {
+			if item.id, err = refManager.ResolveRef(ref.Name); err != nil {
+				continue
+			}
+		}
+		items = append(items, item)
+	}
+	return items, nil
+}
+
+// refFormatter expands ref formats and sorts and filters refs for
+// for-each-ref, branch and tag
+type refFormatter struct {
+	repo     *vcs.Repository
+	resolver *revparse.Resolver
+	head     string
+}
+
+// newRefFormatter returns a formatter for the refs of repo
+func newRefFormatter(repo *vcs.Repository, refManager *refs.RefManager) *refFormatter {
+	head, _ := refManager.SymbolicHEAD()
+	return &refFormatter{repo: repo, resolver: newResolver(repo), head: head}
+}
+
+// load reads the object of item and, when that is a tag, the object the
+// tag leads to
+func (f *refFormatter) load(item *refItem) {
+	if item.loaded {
+		return
+	}
+	item.loaded = true
+	obj, err := f.repo.GetObject(item.id)
+	if err != nil {
+		return
+	}
+	item.obj = obj
+	for {
+		tag, ok := obj.(*objects.Tag)
+		if !ok {
+			break
+		}
+		if obj, err = f.repo.GetObject(tag.Object()); err != nil {
+			return
+		}
+		item.peeled = obj
+	}
+}
+
+// expand writes item as format says
+func (f *refFormatter) expand(format refFormat, item *refItem) (string, error) {
+	var out strings.Builder
+	for _, part := range format {
+		if part.field == "" {
+			out.WriteString(part.literal)
+			continue
+		}
+		value, err := f.field(item, part)
+		if err != nil {
+			return "", err
+		}
+		out.WriteString(value)
+	}
+	return out.String(), nil
+}
+
+// field returns the value of one field of item, empty when it does not
+// apply to the ref
+func (f *refFormatter) field(item *refItem, part refFormatPart) (string, error) {
+	switch part.field {
+	case "refname":
+		return formatRefName(item.name, part.modifier)
+	case "symref":
+		if item.symref == "" {
+			return "", nil
+		}
+		return formatRefName(item.symref, part.modifier)
+	case "HEAD":
+		if item.name == f.head {
+			return "*", nil
+		}
+		return " ", nil
+	case "upstream":
+		return f.upstream(item, part.modifier)
+	}
+
+	f.load(item)
+	obj := item.obj
+	if part.deref {
+		obj = item.peeled
+	}
+	if obj == nil {
+		return "", nil
+	}
+
+	switch part.field {
+	case "objectname":
+		switch {
+		case part.modifier == "":
+			return obj.ID().String(), nil
+		case part.modifier == "short":
+			return obj.ID().Short(), nil
+		case strings.HasPrefix(part.modifier, "short="):
+			n, err := strconv.Atoi(strings.TrimPrefix(part.modifier, "short="))
+			if err != nil || n < 1 {
+				return "", fmt.Errorf("positive value expected objectname:%s", part.modifier)
+			}
+			return obj.ID().String()[:min(n, len(obj.ID().String()))], nil
+		}
+		return "", fmt.Errorf("unrecognized %%(objectname) argument: %s", part.modifier)
+	case "objecttype":
+		return string(obj.Type()), nil
+	case "objectsize":
+		return strconv.FormatInt(obj.Size(), 10), nil
+	case "subject", "body", "contents":
+		return messageField(obj, part.field), nil
+	}
+
+	person, field := strings.CutSuffix(part.field, "name")
+	if !field {
+		if person, field = strings.CutSuffix(part.field, "email"); field {
+			sig, ok := objectSignature(obj, person)
+			if !ok {
+				return "", nil
+			}
+			if part.modifier == "trim" {
+				return sig.Email, nil
+			}
+			return "<" + sig.Email + ">", nil
+		}
+	}
+	if field {
+		sig, ok := objectSignature(obj, person)
+		if !ok {
+			return "", nil
+		}
+		return sig.Name, nil
+	}
+	if person, field = strings.CutSuffix(part.field, "date"); field {
+		sig, ok := objectSignature(obj, person)
+		if !ok {
+			return "", nil
+		}
+		return formatRefDate(sig.When, part.modifier)
+	}
+	// creator
+	sig, ok := objectSignature(obj, "creator")
+	if !ok {
+		return "", nil
+	}
+	return fmt.Sprintf("%s <%s> %d %s", sig.Name, sig.Email, sig.When.Unix(), sig.When.Format("-0700")), nil
+}
+
+// upstream returns the upstream field of a branch: its full name, or with
+// short its short name, with track how the branch stands against it as
+// "[ahead 1, behind 2]" and with trackshort as ">", "<", "<>" or "="
+func (f *refFormatter) upstream(item *refItem, modifier string) (string, error) {
+	branch, ok := strings.CutPrefix(item.name, "refs/heads/")
+	if !ok {
+		return "", nil
+	}
+	upstream, err := f.resolver.Upstream(branch)
+	if err != nil {
+		return "", nil
+	}
+
+	modifier, nobracket := strings.CutSuffix(modifier, ",nobracket")
+	switch modifier {
+	case "":
+		return upstream, nil
+	case "short":
+		return shortRefName(upstream), nil
+	case "track", "trackshort":
+	default:
+		return "", fmt.Errorf("unrecognized %%(upstream) argument: %s", modifier)
+	}
+
+	t, err := branchTracking(f.resolver, branch, true)
+	if err != nil || t == nil {
+		return "", err
+	}
+	if modifier == "trackshort" {
+		switch {
+		case t.gone:
+			return "", nil
+		case t.ahead > 0 && t.behind > 0:
+			return "<>", nil
+		case t.ahead > 0:
+			return ">", nil
+		case t.behind > 0:
+			return "<", nil
+		}
+		return "=", nil
+	}
+	counts := t.counts()
+	if counts == "" || nobracket {
+		return counts, nil
+	}
+	return "[" + counts + "]", nil
+}
+
+// formatRefName returns name as modifier asks: in full, with short as the
+// shortest usual name, or with lstrip=n or rstrip=n without n components
+// from the left or right, a negative n keeping that many instead
+func formatRefName(name, modifier string) (string, error) {
+	switch {
+	case modifier == "":
+		return name, nil
+	case modifier == "short":
+		return shortRefName(name), nil
+	}
+
+	key, value, _ := strings.Cut(modifier, "=")
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return "", fmt.Errorf("unrecognized %%(refname) argument: %s", modifier)
+	}
+	components := strings.Split(name, "/")
+	if n < 0 {
+		n = max(len(components)+n, 0)
+	}
+	n = min(n, len(components))
+	switch key {
+	case "lstrip", "strip":
+		return strings.Join(components[n:], "/"), nil
+	case "rstrip":
+		return strings.Join(components[:len(components)-n], "/"), nil
+	}
+	return "", fmt.Errorf("unrecognized %%(refname) argument: %s", modifier)
+}
+
+// formatRefDate writes a date in the format modifier names: Git's default
+// when empty, or short, iso, iso-strict, rfc, unix or raw
+func formatRefDate(when time.Time, modifier string) (string, error) {
+	switch modifier {
+	case "", "default":
+		return formatDate(when), nil
+	case "short":
+		return when.Format("2006-01-02"), nil
+	case "iso", "iso8601":
+		return when.Format("2006-01-02 15:04:05 -0700"), nil
+	case "iso-strict", "iso8601-strict":
+		return when.Format(time.RFC3339), nil
+	case "rfc", "rfc2822":
+		return when.Format("Mon, 2 Jan 2006 15:04:05 -0700"), nil
+	case "unix":
+		return strconv.FormatInt(when.Unix(), 10), nil
+	case "raw":
+		return fmt.Sprintf("%d %s", when.Unix(), when.Format("-0700")), nil
+	}
+	return "", fmt.Errorf("unknown date format %s", modifier)
+}
+
+// objectSignature returns the author, committer or tagger of obj, the
+// creator being the tagger of a tag and the committer of a commit
+func objectSignature(obj objects.Object, person string) (objects.Signature, bool) {
+	switch obj := obj.(type) {
+	case *objects.Commit:
+		switch person {
+		case "author":
+			return obj.Author(), true
+		case "committer", "creator":
+			return obj.Committer(), true
+		}
+	case *objects.Tag:
+		if person == "tagger" || person == "creator" {
+			return obj.Tagger(), true
+		}
+	}
+	return objects.Signature{}, false
+}
+
+// messageField returns the subject, the body or the whole contents of the
+// message of a commit or tag. The signature of a signed tag is left out
+// of its subject and body.
+func messageField(obj objects.Object, field string) string {
+	var message string
+	switch obj := obj.(type) {
+	case *objects.Commit:
+		message = obj.Message()
+	case *objects.Tag:
+		message = obj.Message()
+		if field != "contents" {
+			payload, _ := signing.SplitTag([]byte(message))
+			message = string(payload)
+		}
+	default:
+		return ""
+	}
+	if field == "contents" {
+		return message
+	}
+
+	subject, body, _ := strings.Cut(strings.TrimLeft(message, "\n"), "\n\n")
+	if field == "subject" {
+		return strings.Join(strings.Fields(strings.ReplaceAll(subject, "\n", " ")), " ")
+	}
+	return strings.TrimLeft(body, "\n")
+}
+
+// refSortKey is a --sort key: a field, compared as a version with a
+// version: or v: prefix, and in reverse with a leading "-"
+type refSortKey struct {
+	part    refFormatPart
+	version bool
+	reverse bool
+}
+
+// parseRefSortKeys parses --sort keys, the last given being the primary
+// one. Without any refs sort by name.
+func parseRefSortKeys(specs []string) ([]refSortKey, error) {
+	var keys []refSortKey
+	for i := len(specs) - 1; i >= 0; i-- {
+		spec := specs[i]
+		var key refSortKey
+		spec, key.reverse = strings.CutPrefix(spec, "-")
+		for _, prefix := range []string{"version:", "v:"} {
+			if rest, ok := strings.CutPrefix(spec, prefix); ok {
+				spec, key.version = rest, true
+			}
+		}
+		part, err := parseRefField(spec)
+		if err != nil {
+			return nil, fmt.Errorf("unsupported sort specification '%s'", specs[i])
+		}
+		key.part = part
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// sort orders items by keys, ties going by name
+func (f *refFormatter) sort(items []*refItem, keys []refSortKey) error {
+	var sortErr error
+	sort.SliceStable(items, func(i, j int) bool {
+		for _, key := range keys {
+			c, err := f.compare(items[i], items[j], key)
+			if err != nil && sortErr == nil {
+				sortErr = err
+			}
+			if c != 0 {
+				return c < 0
+			}
+		}
+		return items[i].name < items[j].name
+	})
+	return sortErr
+}
+
+// compare compares a and b by key
+func (f *refFormatter) compare(a, b *refItem, key refSortKey) (int, error) {
+	var c int
+	if person, ok := strings.CutSuffix(key.part.field, "date"); ok {
+		f.load(a)
+		f.load(b)
+		aObj, bObj := a.obj, b.obj
+		if key.part.deref {
+			aObj, bObj = a.peeled, b.peeled
+		}
+		aSig, _ := objectSignature(aObj, person)
+		bSig, _ := objectSignature(bObj, person)
+		c = aSig.When.Compare(bSig.When)
+	} else {
+		aValue, err := f.field(a, key.part)
+		if err != nil {
+			return 0, err
+		}
+		bValue, err := f.field(b, key.part)
+		if err != nil {
+			return 0, err
+		}
+		switch {
+		case key.version:
+			c = compareVersions(aValue, bValue)
+		case key.part.field == "objectsize":
+			aSize, _ := strconv.ParseInt(aValue, 10, 64)
+			bSize, _ := strconv.ParseInt(bValue, 10, 64)
+			c = int(aSize - bSize)
+		default:
+			c = strings.Compare(aValue, bValue)
+		}
+	}
+	if key.reverse {
+		c = -c
+	}
+	return c, nil
+}
+
+// containsAny reports whether the commit item leads to contains any of
+// commits
+func (f *refFormatter) containsAny(item *refItem, commits []objects.ObjectID) (bool, error) {
+	f.load(item)
+	commit := item.obj
+	if item.peeled != nil {
+		commit = item.peeled
+	}
+	if commit == nil || commit.Type() != objects.TypeCommit {
+		return false, nil
+	}
+	for _, id := range commits {
+		if ok, err := f.resolver.IsAncestor(id, commit.ID()); err != nil || ok {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+// pointsAtAny reports whether item points at any of ids, directly or
+// through a tag
+func (f *refFormatter) pointsAtAny(item *refItem, ids []objects.ObjectID) bool {
+	f.load(item)
+	for _, id := range ids {
+		if item.id == id || (item.peeled != nil && item.peeled.ID() == id) {
+			return true
+		}
+	}
+	return false
+}
+
+// write writes items as format says, a line each
+func (f *refFormatter) write(w io.Writer, format refFormat, items []*refItem) error {
+	for _, item := range items {
+		line, err := f.expand(format, item)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintln(w, line)
+	}
+	return nil
+}
+
+// matchRefPattern reports whether ref name matches pattern as
+// for-each-ref matches them: as a shell pattern, or as a prefix of whole
+// components
+func matchRefPattern(pattern, name string) bool {
+	if ok, _ := path.Match(pattern, name); ok {
+		return true
+	}
+	pattern = strings.TrimSuffix(pattern, "/")
+	return name == pattern || strings.HasPrefix(name, pattern+"/")
+}
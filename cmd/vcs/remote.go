@@ -1,13 +1,21 @@
 package main
 
 import (
+	"context"
 	"fmt"
+	"io"
 	"os"
-	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	"github.com/spf13/cobra"
 
+	"github.com/fenilsonani/vcs/internal/core/config"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/internal/revparse"
+	"github.com/fenilsonani/vcs/internal/serve"
+	"github.com/fenilsonani/vcs/internal/transport"
 	"github.com/fenilsonani/vcs/pkg/vcs"
 )
 
@@ -23,6 +31,7 @@ func newRemoteCommand() *cobra.Command {
 		newRemoteRemoveCommand(),
 		newRemoteListCommand(),
 		newRemoteShowCommand(),
+		newRemotePruneCommand(),
 	)
 
 	return cmd
@@ -39,7 +48,7 @@ func newRemoteAddCommand() *cobra.Command {
 				return err
 			}
 
-			vcsRepo, err := vcs.Open(repo)
+			vcsRepo, err := openRepository(repo)
 			if err != nil {
 				return err
 			}
@@ -61,7 +70,7 @@ func newRemoteRemoveCommand() *cobra.Command {
 				return err
 			}
 
-			vcsRepo, err := vcs.Open(repo)
+			vcsRepo, err := openRepository(repo)
 			if err != nil {
 				return err
 			}
@@ -78,44 +87,99 @@ func newRemoteListCommand() *cobra.Command {
 		Use:     "list",
 		Aliases: []string{"ls"},
 		Short:   "List remote repositories",
+		Long: `Lists the remotes, with their URLs given -v. --json, or --format=json,
+lists them as JSON, each with its fetch and push URL.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			repo, err := findRepository()
 			if err != nil {
 				return err
 			}
 
-			vcsRepo, err := vcs.Open(repo)
+			vcsRepo, err := openRepository(repo)
 			if err != nil {
 				return err
 			}
 
+			asJSON, err := jsonRequested(cmd)
+			if err != nil {
+				return err
+			}
+			if asJSON {
+				return listRemotesJSON(cmd.OutOrStdout(), vcsRepo)
+			}
 			return listRemotes(vcsRepo, verbose)
 		},
 	}
 
 	cmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Show URLs")
+	addJSONFlags(cmd)
 	return cmd
 }
 
 func newRemoteShowCommand() *cobra.Command {
-	return &cobra.Command{
+	var noQuery bool
+
+	cmd := &cobra.Command{
 		Use:   "show <name>",
 		Short: "Show information about a remote",
-		Args:  cobra.ExactArgs(1),
+		Long: `Shows the URLs of a remote and its HEAD branch. When the branch has not
+been fetched and the remote is on GitHub, GitLab or Bitbucket, whether by
+its host or as remote.<name>.provider says, the provider's API is asked for
+it, with the token in GH_TOKEN or GITHUB_TOKEN, GITLAB_TOKEN or
+BITBUCKET_TOKEN. remote.<name>.apiUrl sets the API root of a self-hosted
+server.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repo, err := findRepository()
+			if err != nil {
+				return err
+			}
+
+			vcsRepo, err := openRepository(repo)
+			if err != nil {
+				return err
+			}
+
+			return showRemote(vcsRepo, args[0], !noQuery)
+		},
+	}
+
+	cmd.Flags().BoolVarP(&noQuery, "no-query", "n", false, "Do not ask the provider's API for the HEAD branch")
+	return cmd
+}
+
+func newRemotePruneCommand() *cobra.Command {
+	var dryRun bool
+
+	cmd := &cobra.Command{
+		Use:   "prune [-n] <name>...",
+		Short: "Delete remote-tracking branches no longer on the remote",
+		Long: `Asks each remote for its branches and deletes the remote-tracking
+branches under refs/remotes/<name>/ whose branch it no longer has. With
+--dry-run they are only listed.`,
+		Args: cobra.MinimumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			repo, err := findRepository()
 			if err != nil {
 				return err
 			}
 
-			vcsRepo, err := vcs.Open(repo)
+			vcsRepo, err := openRepository(repo)
 			if err != nil {
 				return err
 			}
 
-			return showRemote(vcsRepo, args[0])
+			for _, name := range args {
+				if err := pruneRemote(cmd, vcsRepo, name, dryRun); err != nil {
+					return err
+				}
+			}
+			return nil
 		},
 	}
+
+	cmd.Flags().BoolVarP(&dryRun, "dry-run", "n", false, "List the branches that would be pruned without deleting them")
+	return cmd
 }
 
 func addRemote(repo *vcs.Repository, name, url string) error {
@@ -172,7 +236,26 @@ func listRemotes(repo *vcs.Repository, verbose bool) error {
 	return nil
 }
 
-func showRemote(repo *vcs.Repository, name string) error {
+// listRemotesJSON writes the remotes as JSON, sorted by name
+func listRemotesJSON(w io.Writer, repo *vcs.Repository) error {
+	remotes, err := getRemotes(repo)
+	if err != nil {
+		return fmt.Errorf("failed to list remotes: %w", err)
+	}
+	names := make([]string, 0, len(remotes))
+	for name := range remotes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	listed := make([]jsonRemote, 0, len(names))
+	for _, name := range names {
+		listed = append(listed, jsonRemote{Name: name, FetchURL: remotes[name], PushURL: remotes[name]})
+	}
+	return writeJSON(w, listed)
+}
+
+func showRemote(repo *vcs.Repository, name string, query bool) error {
 	if !remoteExists(repo, name) {
 		return fmt.Errorf("remote '%s' does not exist", name)
 	}
@@ -186,11 +269,126 @@ func showRemote(repo *vcs.Repository, name string) error {
 	fmt.Printf("* remote %s\n", name)
 	fmt.Printf("  Fetch URL: %s\n", url)
 	fmt.Printf("  Push  URL: %s\n", url)
-	fmt.Printf("  HEAD branch: (unknown)\n")
+	if provider := remoteProvider(repo, name, url); provider != "" {
+		fmt.Printf("  Provider: %s\n", provider)
+	}
+	fmt.Printf("  HEAD branch: %s\n", remoteHeadBranch(repo, name, url, query))
 
 	return nil
 }
 
+// remoteHeadBranch returns the default branch of a remote: the one
+// refs/remotes/<name>/HEAD points to, or else, when query is set, the one
+// the provider's API reports, or "(unknown)"
+func remoteHeadBranch(repo *vcs.Repository, name, url string, query bool) string {
+	if branch, ok := remoteDefaultBranch(repo, name); ok {
+		return branch
+	}
+	provider := remoteProvider(repo, name, url)
+	if provider == "" || !query {
+		return "(unknown)"
+	}
+	client, err := openProvider(repo, provider, name, url, providerToken(provider))
+	if err != nil {
+		return "(unknown)"
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), providerAPITimeout)
+	defer cancel()
+	branch, err := client.DefaultBranch(ctx)
+	if err != nil || branch == "" {
+		return "(unknown)"
+	}
+	return branch
+}
+
+// pruneRemote deletes the remote-tracking branches of a remote whose
+// branches it no longer advertises, or only lists them when dryRun is set
+func pruneRemote(cmd *cobra.Command, repo *vcs.Repository, name string, dryRun bool) error {
+	remotes, err := getRemotes(repo)
+	if err != nil {
+		return fmt.Errorf("failed to get remotes: %w", err)
+	}
+	url, ok := remotes[name]
+	if !ok {
+		return fmt.Errorf("remote '%s' does not exist", name)
+	}
+
+	remote, err := openHTTPTransport(cmd, repo, name, url)
+	if err != nil {
+		return err
+	}
+	discovery, err := remote.DiscoverRefs(context.Background(), "git-upload-pack")
+	if err != nil {
+		return fmt.Errorf("failed to read refs of %s: %w", url, err)
+	}
+
+	refManager := refs.NewRefManager(repo.GitDir())
+	stale, err := staleRefs(refManager, name, discovery.Refs, false)
+	if err != nil {
+		return err
+	}
+	if len(stale) == 0 {
+		return nil
+	}
+
+	out := cmd.OutOrStdout()
+	fmt.Fprintf(out, "Pruning %s\nURL: %s\n", name, url)
+	for _, ref := range stale {
+		if dryRun {
+			fmt.Fprintf(out, " * [would prune] %s\n", shortRemoteRef(ref))
+			continue
+		}
+		if err := refManager.DeleteRef(ref); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", ref, err)
+		}
+		fmt.Fprintf(out, " * [pruned] %s\n", shortRemoteRef(ref))
+	}
+	return nil
+}
+
+// staleRefs returns, in order, the remote-tracking branches of remoteName
+// whose branch is not among the refs the remote advertised, and with tags
+// the tags it does not advertise. Symbolic refs such as
+// refs/remotes/<name>/HEAD are left alone.
+func staleRefs(refManager *refs.RefManager, remoteName string, advertised map[string]string, tags bool) ([]string, error) {
+	prefix := "refs/remotes/" + remoteName + "/"
+	tracking, err := refManager.Store().ListRefs(prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list remote-tracking branches: %w", err)
+	}
+	var stale []string
+	for _, ref := range tracking {
+		if ref.IsSymbolic() {
+			continue
+		}
+		if _, ok := advertised["refs/heads/"+strings.TrimPrefix(ref.Name, prefix)]; !ok {
+			stale = append(stale, ref.Name)
+		}
+	}
+
+	if tags {
+		names, err := refManager.ListTags()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list tags: %w", err)
+		}
+		for _, name := range names {
+			if _, ok := advertised[name]; !ok {
+				stale = append(stale, name)
+			}
+		}
+	}
+	return stale, nil
+}
+
+// shortRemoteRef shortens a remote-tracking branch to <remote>/<branch>
+// and a tag to its name
+func shortRemoteRef(ref string) string {
+	if short, ok := strings.CutPrefix(ref, "refs/remotes/"); ok {
+		return short
+	}
+	return strings.TrimPrefix(ref, "refs/tags/")
+}
+
 func validateRemoteName(name string) error {
 	if name == "" {
 		return fmt.Errorf("remote name cannot be empty")
@@ -221,6 +419,8 @@ func validateRemoteURL(url string) error {
 		!strings.HasPrefix(url, "https://") && 
 		!strings.HasPrefix(url, "git://") && 
 		!strings.HasPrefix(url, "ssh://") &&
+		!strings.HasPrefix(url, serve.URLScheme) &&
+		!isLocalURL(url) &&
 		!strings.Contains(url, "@") { // git@github.com:user/repo.git format
 		return fmt.Errorf("invalid URL format")
 	}
@@ -237,87 +437,98 @@ func remoteExists(repo *vcs.Repository, name string) bool {
 	return exists
 }
 
+// getRemotes returns the URL of each configured remote by name
 func getRemotes(repo *vcs.Repository) (map[string]string, error) {
-	configPath := filepath.Join(repo.GitDir(), "config")
-	content, err := os.ReadFile(configPath)
+	cfg, err := config.Load(repo.GitDir())
 	if err != nil {
-		return make(map[string]string), nil // No config file yet
+		return nil, fmt.Errorf("failed to read config: %w", err)
 	}
 
-	return parseRemotesFromConfig(string(content)), nil
-}
-
-func parseRemotesFromConfig(content string) map[string]string {
 	remotes := make(map[string]string)
-	lines := strings.Split(content, "\n")
-	
-	var currentRemote string
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		
-		// Look for [remote "name"] sections
-		if strings.HasPrefix(line, "[remote \"") && strings.HasSuffix(line, "\"]") {
-			start := strings.Index(line, "\"") + 1
-			end := strings.LastIndex(line, "\"")
-			if start < end {
-				currentRemote = line[start:end]
-			}
-		} else if currentRemote != "" && strings.HasPrefix(line, "url = ") {
-			url := strings.TrimPrefix(line, "url = ")
-			remotes[currentRemote] = url
-			currentRemote = "" // Reset after finding URL
+	for _, name := range cfg.Subsections("remote") {
+		// The first URL of a remote is the one fetched from
+		if urls := cfg.GetAll("remote." + name + ".url"); len(urls) > 0 {
+			remotes[name] = urls[0]
 		}
 	}
-
-	return remotes
+	return remotes, nil
 }
 
+// writeRemoteConfig sets the URL of a remote in the repository config,
+// with the fetch refspec Git gives a new remote, so both find the
+// remote-tracking branches of its branches
 func writeRemoteConfig(repo *vcs.Repository, name, url string) error {
-	configPath := filepath.Join(repo.GitDir(), "config")
-	
-	// Read existing config
-	var content string
-	if data, err := os.ReadFile(configPath); err == nil {
-		content = string(data)
+	file, err := config.ReadFile(config.Path(config.ScopeLocal, repo.GitDir()))
+	if err != nil {
+		return err
 	}
-
-	// Append remote configuration
-	remoteConfig := fmt.Sprintf("\n[remote \"%s\"]\n\turl = %s\n", name, url)
-	content += remoteConfig
-
-	return os.WriteFile(configPath, []byte(content), 0644)
+	if err := file.Set("remote."+name+".url", url); err != nil {
+		return err
+	}
+	if err := file.Set("remote."+name+".fetch", revparse.DefaultFetchRefspec(name)); err != nil {
+		return err
+	}
+	return file.Save()
 }
 
+// removeRemoteConfig removes a remote's section from the repository config
 func removeRemoteConfig(repo *vcs.Repository, name string) error {
-	configPath := filepath.Join(repo.GitDir(), "config")
-	
-	content, err := os.ReadFile(configPath)
+	file, err := config.ReadFile(config.Path(config.ScopeLocal, repo.GitDir()))
 	if err != nil {
 		return err
 	}
+	file.RemoveSection("remote", name)
+	return file.Save()
+}
 
-	lines := strings.Split(string(content), "\n")
-	var newLines []string
-	
-	inRemoteSection := false
-	targetSection := fmt.Sprintf("[remote \"%s\"]", name)
-	
-	for _, line := range lines {
-		trimmed := strings.TrimSpace(line)
-		
-		if trimmed == targetSection {
-			inRemoteSection = true
-			continue // Skip this line
-		}
-		
-		if inRemoteSection && strings.HasPrefix(trimmed, "[") && trimmed != targetSection {
-			inRemoteSection = false // Entered a new section
-		}
-		
-		if !inRemoteSection {
-			newLines = append(newLines, line)
+// providerAPITimeout bounds API calls made only to show information
+const providerAPITimeout = 10 * time.Second
+
+// providerTokenVars are the environment variables holding the API token of
+// each hosting provider, in the order they are tried
+var providerTokenVars = map[string][]string{
+	transport.ProviderGitHub:    {"GH_TOKEN", "GITHUB_TOKEN"},
+	transport.ProviderGitLab:    {"GITLAB_TOKEN"},
+	transport.ProviderBitbucket: {"BITBUCKET_TOKEN"},
+}
+
+// remoteProvider returns the hosting provider of a remote: the one
+// remote.<name>.provider names, or else the one serving the URL's host, or
+// "" when neither is known
+func remoteProvider(repo *vcs.Repository, remoteName, remoteURL string) string {
+	if name, ok := loadConfig(repo.GitDir()).Get("remote." + remoteName + ".provider"); ok {
+		return strings.ToLower(name)
+	}
+	remote, err := transport.ParseRemoteRepository(remoteURL)
+	if err != nil {
+		return ""
+	}
+	return transport.DetectProvider(remote.Host)
+}
+
+// providerToken returns the API token of provider from the environment
+func providerToken(provider string) string {
+	for _, name := range providerTokenVars[provider] {
+		if token := os.Getenv(name); token != "" {
+			return token
 		}
 	}
+	return ""
+}
 
-	return os.WriteFile(configPath, []byte(strings.Join(newLines, "\n")), 0644)
-}
\ No newline at end of file
+// openProvider returns the client of provider for the repository at
+// remoteURL, calling the API root remote.<name>.apiUrl or
+// <provider>.apiUrl sets, if any
+func openProvider(repo *vcs.Repository, provider, remoteName, remoteURL, token string) (transport.Provider, error) {
+	client, err := transport.NewProvider(provider, remoteURL, token)
+	if err != nil {
+		return nil, err
+	}
+	cfg := loadConfig(repo.GitDir())
+	if apiURL, ok := cfg.Get("remote." + remoteName + ".apiurl"); ok {
+		client.SetAPIURL(apiURL)
+	} else if apiURL, ok := cfg.Get(provider + ".apiurl"); ok {
+		client.SetAPIURL(apiURL)
+	}
+	return client, nil
+}
@@ -2,6 +2,8 @@ package main
 
 import (
 	"bytes"
+	"net/http"
+	"net/http/httptest"
 	"os"
 	"path/filepath"
 	"strings"
@@ -9,6 +11,7 @@ import (
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"github.com/fenilsonani/vcs/internal/core/refs"
 	"github.com/fenilsonani/vcs/pkg/vcs"
 )
 
@@ -157,6 +160,7 @@ func TestRemoteAddCommand(t *testing.T) {
 				require.NoError(t, err)
 				assert.Contains(t, string(content), "[remote \"origin\"]")
 				assert.Contains(t, string(content), "url = https://github.com/user/repo.git")
+				assert.Contains(t, string(content), "fetch = +refs/heads/*:refs/remotes/origin/*")
 			},
 		},
 		{
@@ -661,4 +665,81 @@ func TestGetRemotesFunction(t *testing.T) {
 	assert.Len(t, remotes, 2)
 	assert.Equal(t, "https://github.com/user/repo.git", remotes["origin"])
 	assert.Equal(t, "git@github.com:upstream/repo.git", remotes["upstream"])
-}
\ No newline at end of file
+}
+func TestRemoteProvider(t *testing.T) {
+	repo, _ := setupConfigRepo(t)
+
+	assert.Equal(t, "github", remoteProvider(repo, "origin", "git@github.com:user/repo.git"))
+	assert.Equal(t, "gitlab", remoteProvider(repo, "origin", "ssh://git@gitlab.example.com/group/repo.git"))
+	assert.Equal(t, "bitbucket", remoteProvider(repo, "origin", "https://bitbucket.org/team/repo.git"))
+	assert.Equal(t, "", remoteProvider(repo, "origin", "https://git.example.com/team/repo.git"))
+
+	_, err := runConfigArgs("remote.origin.provider", "GitLab")
+	require.NoError(t, err)
+	assert.Equal(t, "gitlab", remoteProvider(repo, "origin", "https://git.example.com/team/repo.git"))
+}
+
+func TestRemoteShowAsksProvider(t *testing.T) {
+	setupConfigRepo(t)
+	t.Setenv("GITLAB_TOKEN", "secret")
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/projects/group%2Fsub%2Frepo", r.URL.EscapedPath())
+		assert.Equal(t, "secret", r.Header.Get("PRIVATE-TOKEN"))
+		w.Write([]byte(`{"default_branch": "trunk"}`))
+	}))
+	defer server.Close()
+
+	_, err := runConfigArgs("remote.origin.url", "git@gitlab.example.com:group/sub/repo.git")
+	require.NoError(t, err)
+	_, err = runConfigArgs("remote.origin.apiUrl", server.URL)
+	require.NoError(t, err)
+
+	out, err := captureStdout(t, func() error {
+		return runRemoteArgs("show", "origin")
+	})
+	require.NoError(t, err)
+	assert.Contains(t, out, "  Provider: gitlab\n")
+	assert.Contains(t, out, "  HEAD branch: trunk\n")
+
+	out, err = captureStdout(t, func() error {
+		return runRemoteArgs("show", "-n", "origin")
+	})
+	require.NoError(t, err)
+	assert.Contains(t, out, "  HEAD branch: (unknown)\n")
+}
+
+func TestRemotePrune(t *testing.T) {
+	repo, bare := setupPushRepo(t)
+	_, err := runCommandArgs(newPushCommand(), "origin", "main", "main:topic")
+	require.NoError(t, err)
+	require.NoError(t, refs.NewRefManager(bare.GitDir()).DeleteBranch("topic"))
+	localRefs := refs.NewRefManager(repo.GitDir())
+
+	out, err := runCommandArgs(newRemoteCommand(), "prune", "--dry-run", "origin")
+	require.NoError(t, err)
+	assert.Equal(t, "Pruning origin\nURL: "+bare.GitDir()+"\n * [would prune] origin/topic\n", out)
+	assert.True(t, localRefs.RefExists("refs/remotes/origin/topic"))
+
+	out, err = runCommandArgs(newRemoteCommand(), "prune", "origin")
+	require.NoError(t, err)
+	assert.Contains(t, out, " * [pruned] origin/topic\n")
+	assert.False(t, localRefs.RefExists("refs/remotes/origin/topic"))
+	assert.True(t, localRefs.RefExists("refs/remotes/origin/main"))
+
+	// Nothing is left to prune
+	out, err = runCommandArgs(newRemoteCommand(), "prune", "origin")
+	require.NoError(t, err)
+	assert.Empty(t, out)
+
+	_, err = runCommandArgs(newRemoteCommand(), "prune", "missing")
+	assert.ErrorContains(t, err, "remote 'missing' does not exist")
+}
+
+func runRemoteArgs(args ...string) error {
+	cmd := newRemoteCommand()
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs(args)
+	return cmd.Execute()
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/config"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// replaceOptions holds the flags of vcs replace
+type replaceOptions struct {
+	force            bool
+	delete           bool
+	list             bool
+	graft            bool
+	convertGraftFile bool
+	format           string
+}
+
+func newReplaceCommand() *cobra.Command {
+	var opts replaceOptions
+
+	cmd := &cobra.Command{
+		Use:   "replace [-f] <object> <replacement> | -d <object>... | -g <commit> [<parent>...] | -l [<pattern>]",
+		Short: "Create, list and delete refs to replace objects",
+		Long: `Replacement refs rewrite history virtually: once refs/replace/<object>
+names a replacement, every command reading <object> reads the replacement
+instead, while the object itself and the history made of it stay as they
+are. A commit can so be given other parents, to join a history imported
+in parts or hide a large one, without rewriting any commit after it.
+
+"vcs replace <object> <replacement>" creates a replacement of the same
+type, "--graft <commit> <parent>..." a copy of the commit with the
+parents given, and "--convert-graft-file" turns each line of the
+obsolete .git/info/grafts file into such a replacement. With -l or no
+arguments the replaced objects matching the pattern are listed, as
+their names alone (--format=short), with their replacement (medium) or
+with the types of both (long).
+
+Commands that copy, check or prune objects, such as fetch, push, gc and
+fsck, always read objects as stored, as do all commands given the global
+--no-replace-objects option, the GIT_NO_REPLACE_OBJECTS environment
+variable or a false core.useReplaceRefs.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runReplace(cmd, args, opts)
+		},
+	}
+
+	cmd.Flags().BoolVarP(&opts.force, "force", "f", false, "Replace an existing replacement, or with an object of another type")
+	cmd.Flags().BoolVarP(&opts.delete, "delete", "d", false, "Delete the replacement refs of the objects given")
+	cmd.Flags().BoolVarP(&opts.list, "list", "l", false, "List the replaced objects matching a pattern")
+	cmd.Flags().BoolVarP(&opts.graft, "graft", "g", false, "Replace a commit with a copy having the parents given")
+	cmd.Flags().BoolVar(&opts.convertGraftFile, "convert-graft-file", false, "Convert .git/info/grafts into replacement refs")
+	cmd.Flags().StringVar(&opts.format, "format", "short", "Format of the list: short, medium or long")
+	return cmd
+}
+
+func runReplace(cmd *cobra.Command, args []string, opts replaceOptions) error {
+	modes := 0
+	for _, set := range []bool{opts.delete, opts.list, opts.graft, opts.convertGraftFile} {
+		if set {
+			modes++
+		}
+	}
+	if modes > 1 {
+		return fmt.Errorf("-d, -l, --graft and --convert-graft-file cannot be used together")
+	}
+	list := opts.list || (modes == 0 && len(args) == 0)
+	if cmd.Flags().Changed("format") && !list {
+		return fmt.Errorf("--format cannot be used when not listing")
+	}
+	if opts.force && (list || opts.delete) {
+		return fmt.Errorf("-f only makes sense when writing a replacement")
+	}
+	switch {
+	case list && len(args) > 1:
+		return fmt.Errorf("only one pattern can be given with -l")
+	case opts.delete && len(args) == 0:
+		return fmt.Errorf("-d needs at least one argument")
+	case opts.graft && len(args) == 0:
+		return fmt.Errorf("-g needs at least one argument")
+	case opts.convertGraftFile && len(args) > 0:
+		return fmt.Errorf("--convert-graft-file takes no argument")
+	case modes == 0 && !list && len(args) != 2:
+		return fmt.Errorf("bad number of arguments")
+	}
+
+	repoPath, err := findRepository()
+	if err != nil {
+		return fmt.Errorf("not a git repository: %w", err)
+	}
+	// Objects are read as stored, or the objects replaced would seem to be
+	// their replacements
+	repo, err := openStoredRepository(repoPath)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+	cmd.SilenceUsage = true
+	refManager := refs.NewRefManager(repo.GitDir())
+	out := cmd.OutOrStdout()
+
+	switch {
+	case list:
+		pattern := "*"
+		if len(args) == 1 {
+			pattern = args[0]
+		}
+		return listReplaceRefs(out, repo, refManager, pattern, opts.format)
+	case opts.delete:
+		return deleteReplaceRefs(out, cmd.ErrOrStderr(), repo, refManager, args)
+	case opts.graft:
+		return graftCommit(repo, refManager, args[0], args[1:], opts.force)
+	case opts.convertGraftFile:
+		return convertGraftFile(cmd.ErrOrStderr(), repo, refManager)
+	}
+
+	resolver := newResolver(repo)
+	object, err := resolver.Resolve(args[0])
+	if err != nil {
+		return fmt.Errorf("failed to resolve '%s' as a valid ref: %w", args[0], err)
+	}
+	replacement, err := resolver.Resolve(args[1])
+	if err != nil {
+		return fmt.Errorf("failed to resolve '%s' as a valid ref: %w", args[1], err)
+	}
+	return createReplaceRef(repo, refManager, object, replacement, opts.force)
+}
+
+// createReplaceRef points refs/replace/<object> at replacement, which must
+// have the type of object unless force is set. An existing replacement is
+// only overwritten with force.
+func createReplaceRef(repo *vcs.Repository, refManager *refs.RefManager, object, replacement objects.ObjectID, force bool) error {
+	if object == replacement {
+		return fmt.Errorf("new object is the same as the old one: '%s'", object)
+	}
+
+	objType, err := storedObjectType(repo, object)
+	if err != nil {
+		return err
+	}
+	replacementType, err := storedObjectType(repo, replacement)
+	if err != nil {
+		return err
+	}
+	if objType != replacementType && !force {
+		return fmt.Errorf("objects must be of the same type: '%s' is a %s while its replacement '%s' is a %s",
+			object, objType, replacement, replacementType)
+	}
+
+	refName := refs.ReplacePrefix + object.String()
+	if refManager.RefExists(refName) && !force {
+		return fmt.Errorf("replace ref '%s' already exists", refName)
+	}
+	if err := refManager.UpdateRef(refName, replacement); err != nil {
+		return fmt.Errorf("failed to update %s: %w", refName, err)
+	}
+	return nil
+}
+
+// storedObjectType returns the type of object id as stored
+func storedObjectType(repo *vcs.Repository, id objects.ObjectID) (objects.ObjectType, error) {
+	objType, _, err := repo.Storage().ReadRawObject(id)
+	if err != nil {
+		return "", fmt.Errorf("failed to read object %s: %w", id, err)
+	}
+	return objType, nil
+}
+
+// listReplaceRefs lists the replaced objects whose names match pattern, in
+// format short, medium or long
+func listReplaceRefs(w io.Writer, repo *vcs.Repository, refManager *refs.RefManager, pattern, format string) error {
+	if format != "short" && format != "medium" && format != "long" {
+		return fmt.Errorf("invalid replace format '%s': valid formats are 'short', 'medium' and 'long'", format)
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return fmt.Errorf("invalid pattern '%s': %w", pattern, err)
+	}
+
+	replacements, err := refManager.ReplaceRefs()
+	if err != nil {
+		return fmt.Errorf("failed to list replace refs: %w", err)
+	}
+	replaced := make([]objects.ObjectID, 0, len(replacements))
+	for id := range replacements {
+		if ok, _ := path.Match(pattern, id.String()); ok {
+			replaced = append(replaced, id)
+		}
+	}
+	sort.Slice(replaced, func(i, j int) bool { return replaced[i].String() < replaced[j].String() })
+
+	for _, id := range replaced {
+		replacement := replacements[id]
+		switch format {
+		case "short":
+			fmt.Fprintln(w, id)
+		case "medium":
+			fmt.Fprintf(w, "%s -> %s\n", id, replacement)
+		case "long":
+			objType, err := storedObjectType(repo, id)
+			if err != nil {
+				return err
+			}
+			replacementType, err := storedObjectType(repo, replacement)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "%s (%s) -> %s (%s)\n", id, objType, replacement, replacementType)
+		}
+	}
+	return nil
+}
+
+// deleteReplaceRefs deletes the replacement refs of the objects names. The
+// rest are still deleted when one fails.
+func deleteReplaceRefs(w, errW io.Writer, repo *vcs.Repository, refManager *refs.RefManager, names []string) error {
+	resolver := newResolver(repo)
+	failed := 0
+	for _, name := range names {
+		id, err := resolver.Resolve(name)
+		if err != nil {
+			fmt.Fprintf(errW, "error: failed to resolve '%s' as a valid ref\n", name)
+			failed++
+			continue
+		}
+		refName := refs.ReplacePrefix + id.String()
+		if !refManager.RefExists(refName) {
+			fmt.Fprintf(errW, "error: replace ref '%s' not found\n", name)
+			failed++
+			continue
+		}
+		if err := refManager.DeleteRef(refName); err != nil {
+			fmt.Fprintf(errW, "error: failed to delete %s: %v\n", refName, err)
+			failed++
+			continue
+		}
+		fmt.Fprintf(w, "Deleted replace ref '%s'\n", name)
+	}
+	if failed > 0 {
+		return fmt.Errorf("failed to delete %d replace ref%s", failed, plural(failed))
+	}
+	return nil
+}
+
+// graftCommit replaces commit with a copy whose parents are those named.
+// The copy drops any signature, which would no longer match.
+func graftCommit(repo *vcs.Repository, refManager *refs.RefManager, commit string, parents []string, force bool) error {
+	resolver := newResolver(repo)
+	id, err := resolver.ResolveCommit(commit)
+	if err != nil {
+		return fmt.Errorf("could not parse %s as a commit: %w", commit, err)
+	}
+	parentIDs := make([]objects.ObjectID, len(parents))
+	for i, parent := range parents {
+		if parentIDs[i], err = resolver.ResolveCommit(parent); err != nil {
+			return fmt.Errorf("could not parse %s as a commit: %w", parent, err)
+		}
+	}
+
+	objType, data, err := repo.Storage().ReadRawObject(id)
+	if err != nil {
+		return fmt.Errorf("failed to read commit %s: %w", id, err)
+	}
+	if objType != objects.TypeCommit {
+		return fmt.Errorf("%s is a %s, not a commit", id, objType)
+	}
+	grafted, signed := graftCommitData(data, parentIDs)
+	if signed {
+		fmt.Fprintf(os.Stderr, "warning: the original commit '%s' has a gpg signature\n", commit)
+		fmt.Fprintln(os.Stderr, "warning: the signature will be removed in the replacement commit!")
+	}
+
+	graftID, err := repo.WriteRawObject(objects.TypeCommit, grafted)
+	if err != nil {
+		return fmt.Errorf("failed to write replacement commit: %w", err)
+	}
+	if graftID == id {
+		return fmt.Errorf("new commit is the same as the old one: '%s'", id)
+	}
+	return createReplaceRef(repo, refManager, id, graftID, force)
+}
+
+// graftCommitData returns the raw commit data with its parent headers
+// replaced by parents and its signature dropped, reporting whether there
+// was one
+func graftCommitData(data []byte, parents []objects.ObjectID) ([]byte, bool) {
+	headerEnd := bytes.Index(data, []byte("\n\n"))
+	if headerEnd < 0 {
+		headerEnd = len(data)
+	}
+	header, body := data[:headerEnd], data[headerEnd:]
+
+	var out bytes.Buffer
+	signed, inSignature := false, false
+	for _, line := range strings.Split(string(header), "\n") {
+		// Continuation lines of a multi-line header start with a space
+		if strings.HasPrefix(line, " ") && inSignature {
+			continue
+		}
+		inSignature = false
+		switch {
+		case strings.HasPrefix(line, "parent "):
+			continue
+		case strings.HasPrefix(line, "gpgsig ") || strings.HasPrefix(line, "gpgsig-sha256 "):
+			signed, inSignature = true, true
+			continue
+		}
+		out.WriteString(line)
+		out.WriteByte('\n')
+		if strings.HasPrefix(line, "tree ") {
+			for _, parent := range parents {
+				fmt.Fprintf(&out, "parent %s\n", parent)
+			}
+		}
+	}
+	out.Truncate(out.Len() - 1)
+	out.Write(body)
+	return out.Bytes(), signed
+}
+
+// convertGraftFile turns each line of .git/info/grafts, a commit followed
+// by its parents, into a replacement. The file is removed once every graft
+// is converted.
+func convertGraftFile(w io.Writer, repo *vcs.Repository, refManager *refs.RefManager) error {
+	graftPath := filepath.Join(repo.CommonDir(), "info", "grafts")
+	file, err := os.Open(graftPath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read graft file: %w", err)
+	}
+	defer file.Close()
+
+	var failed []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if err := graftCommit(repo, refManager, fields[0], fields[1:], true); err != nil {
+			fmt.Fprintf(w, "error: %v\n", err)
+			failed = append(failed, line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read graft file: %w", err)
+	}
+
+	if len(failed) > 0 {
+		return fmt.Errorf("could not convert the following graft(s):\n%s", strings.Join(failed, "\n"))
+	}
+	file.Close()
+	if err := os.Remove(graftPath); err != nil {
+		return fmt.Errorf("failed to remove graft file: %w", err)
+	}
+	return nil
+}
+
+// useReplaceRefs makes repo read the replacement of each object that has
+// one, unless --no-replace-objects, GIT_NO_REPLACE_OBJECTS or a false
+// core.useReplaceRefs says to read objects as stored
+func useReplaceRefs(repo *vcs.Repository, cfg *config.Config) {
+	if globalOptions.noReplaceObjects || os.Getenv("GIT_NO_REPLACE_OBJECTS") != "" {
+		return
+	}
+	if value, ok := cfg.Get("core.useReplaceRefs"); ok {
+		if use, err := config.ParseBool(value); err == nil && !use {
+			return
+		}
+	}
+
+	replacements, err := refs.NewRefManager(repo.GitDir()).ReplaceRefs()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "warning: ignoring replace refs: %v\n", err)
+		return
+	}
+	if len(replacements) > 0 {
+		repo.Storage().SetReplacements(replacements)
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+)
+
+func TestReplace(t *testing.T) {
+	repo, refManager := setupSeriesRepo(t, map[string]string{"a.txt": "a\n"})
+	original, err := repo.CreateBlob([]byte("original\n"))
+	require.NoError(t, err)
+	replacement, err := repo.CreateBlob([]byte("replacement\n"))
+	require.NoError(t, err)
+	head, err := refManager.ResolveRef("refs/heads/main")
+	require.NoError(t, err)
+
+	_, err = runCommandArgs(newReplaceCommand(), original.ID().String(), replacement.ID().String())
+	require.NoError(t, err)
+	out, err := runCommandArgs(newCatFileCommand(), "-p", original.ID().String())
+	require.NoError(t, err)
+	assert.Equal(t, "replacement\n", out)
+
+	out, err = runCommandArgs(newReplaceCommand())
+	require.NoError(t, err)
+	assert.Equal(t, original.ID().String()+"\n", out)
+	out, err = runCommandArgs(newReplaceCommand(), "-l", "--format=long", original.ID().String()[:4]+"*")
+	require.NoError(t, err)
+	assert.Equal(t, original.ID().String()+" (blob) -> "+replacement.ID().String()+" (blob)\n", out)
+	out, err = runCommandArgs(newReplaceCommand(), "-l", "0000*")
+	require.NoError(t, err)
+	assert.Empty(t, out)
+
+	_, err = runCommandArgs(newReplaceCommand(), original.ID().String(), replacement.ID().String())
+	assert.ErrorContains(t, err, "already exists")
+	_, err = runCommandArgs(newReplaceCommand(), original.ID().String(), head.String())
+	assert.ErrorContains(t, err, "must be of the same type")
+	_, err = runCommandArgs(newReplaceCommand(), original.ID().String(), original.ID().String())
+	assert.ErrorContains(t, err, "same as the old one")
+	_, err = runCommandArgs(newReplaceCommand(), "-d", "-l")
+	assert.Error(t, err)
+
+	// The stored object is still read with --no-replace-objects
+	globalOptions.noReplaceObjects = true
+	out, err = runCommandArgs(newCatFileCommand(), "-p", original.ID().String())
+	globalOptions.noReplaceObjects = false
+	require.NoError(t, err)
+	assert.Equal(t, "original\n", out)
+
+	out, err = runCommandArgs(newReplaceCommand(), "-d", original.ID().String())
+	require.NoError(t, err)
+	assert.Equal(t, "Deleted replace ref '"+original.ID().String()+"'\n", out)
+	assert.False(t, refManager.RefExists(refs.ReplacePrefix+original.ID().String()))
+	_, err = runCommandArgs(newReplaceCommand(), "-d", original.ID().String())
+	assert.ErrorContains(t, err, "failed to delete 1 replace ref")
+}
+
+// revList returns the commits rev-list prints for args, one per line
+func revList(t *testing.T, args ...string) []string {
+	out, err := runCommandArgs(newRevListCommand(), args...)
+	require.NoError(t, err)
+	return strings.Fields(out)
+}
+
+func TestReplaceGraft(t *testing.T) {
+	repo, refManager := setupSeriesRepo(t, map[string]string{"a.txt": "a\n"})
+	base, err := refManager.ResolveRef("refs/heads/main")
+	require.NoError(t, err)
+	middle := commitFiles(t, repo, map[string]string{"a.txt": "b\n"}, []objects.ObjectID{base}, "middle\n")
+	tip := commitFiles(t, repo, map[string]string{"a.txt": "c\n"}, []objects.ObjectID{middle}, "tip\n")
+	require.NoError(t, refManager.UpdateRef("refs/heads/main", tip))
+
+	// Grafting the tip onto the base hides the middle commit
+	_, err = runCommandArgs(newReplaceCommand(), "--graft", "HEAD", "HEAD~2")
+	require.NoError(t, err)
+	assert.Equal(t, []string{tip.String(), base.String()}, revList(t, "HEAD"))
+
+	commit, err := repo.GetCommit(tip)
+	require.NoError(t, err)
+	assert.Equal(t, []objects.ObjectID{middle}, commit.Parents(), "the stored commit is unchanged")
+	grafted, err := refManager.ResolveRef(refs.ReplacePrefix + tip.String())
+	require.NoError(t, err)
+	commit, err = repo.GetCommit(grafted)
+	require.NoError(t, err)
+	assert.Equal(t, []objects.ObjectID{base}, commit.Parents())
+	assert.Equal(t, "tip\n", commit.Message())
+
+	globalOptions.noReplaceObjects = true
+	full := revList(t, "HEAD")
+	globalOptions.noReplaceObjects = false
+	assert.Equal(t, []string{tip.String(), middle.String(), base.String()}, full)
+
+	_, err = runCommandArgs(newReplaceCommand(), "--graft", tip.String(), middle.String())
+	assert.ErrorContains(t, err, "same as the old one")
+}
+
+func TestReplaceConvertGraftFile(t *testing.T) {
+	repo, refManager := setupSeriesRepo(t, map[string]string{"a.txt": "a\n"})
+	base, err := refManager.ResolveRef("refs/heads/main")
+	require.NoError(t, err)
+	middle := commitFiles(t, repo, map[string]string{"a.txt": "b\n"}, []objects.ObjectID{base}, "middle\n")
+	tip := commitFiles(t, repo, map[string]string{"a.txt": "c\n"}, []objects.ObjectID{middle}, "tip\n")
+	require.NoError(t, refManager.UpdateRef("refs/heads/main", tip))
+
+	grafts := filepath.Join(repo.GitDir(), "info", "grafts")
+	require.NoError(t, os.MkdirAll(filepath.Dir(grafts), 0755))
+	// The middle commit becomes a root
+	require.NoError(t, os.WriteFile(grafts, []byte("# grafts\n"+middle.String()+"\n"), 0644))
+
+	_, err = runCommandArgs(newReplaceCommand(), "--convert-graft-file")
+	require.NoError(t, err)
+	assert.NoFileExists(t, grafts)
+	assert.Equal(t, []string{tip.String(), middle.String()}, revList(t, "HEAD"))
+}
+
+func TestGraftCommitData(t *testing.T) {
+	parent := objects.NewBlob([]byte("parent")).ID()
+	data := "tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\n" +
+		"parent 0123456789abcdef0123456789abcdef01234567\n" +
+		"author A <a@example.com> 1700000000 +0000\n" +
+		"committer A <a@example.com> 1700000000 +0000\n" +
+		"gpgsig -----BEGIN PGP SIGNATURE-----\n \n abc\n -----END PGP SIGNATURE-----\n" +
+		"\nmessage\n"
+
+	grafted, signed := graftCommitData([]byte(data), []objects.ObjectID{parent})
+	assert.True(t, signed)
+	assert.Equal(t, "tree 4b825dc642cb6eb9a060e54bf8d69288fbee4904\n"+
+		"parent "+parent.String()+"\n"+
+		"author A <a@example.com> 1700000000 +0000\n"+
+		"committer A <a@example.com> 1700000000 +0000\n"+
+		"\nmessage\n", string(grafted))
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/merge"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+// Status values of an operation report
+const (
+	reportUpToDate    = "up-to-date"
+	reportFastForward = "fast-forward"
+	reportCompleted   = "completed"
+	reportStopped     = "stopped"
+	reportConflicted  = "conflicted"
+	reportAborted     = "aborted"
+)
+
+// operationReport is the machine-readable summary written by --report for
+// commands that apply other commits, so automation can react to conflicts
+// without parsing human-readable output
+type operationReport struct {
+	Operation string           `json:"operation"`
+	Status    string           `json:"status"`
+	Head      string           `json:"head,omitempty"`
+	Applied   []reportCommit   `json:"applied"`
+	Conflicts []reportConflict `json:"conflicts"`
+	Resolved  bool             `json:"resolved"`
+}
+
+// reportCommit identifies a commit applied by the operation
+type reportCommit struct {
+	ID      string `json:"id"`
+	Subject string `json:"subject"`
+}
+
+// reportConflict is a path left unmerged, with the object IDs of each side
+type reportConflict struct {
+	Path   string `json:"path"`
+	Type   string `json:"type"`
+	Base   string `json:"base,omitempty"`
+	Ours   string `json:"ours,omitempty"`
+	Theirs string `json:"theirs,omitempty"`
+}
+
+// addReportFlag registers --report on a command
+func addReportFlag(cmd *cobra.Command, format *string) {
+	cmd.Flags().StringVar(format, "report", "", "Write a machine-readable summary to stdout (json)")
+}
+
+// validateReportFormat checks a --report value before any work is done
+func validateReportFormat(format string) error {
+	switch format {
+	case "", "json":
+		return nil
+	default:
+		return fmt.Errorf("unsupported report format %q (supported: json)", format)
+	}
+}
+
+// reportOutput returns where human-readable output goes. With a report
+// requested stdout is reserved for it, so messages move to stderr.
+func reportOutput(cmd *cobra.Command, format string) io.Writer {
+	if format != "" {
+		return cmd.ErrOrStderr()
+	}
+	return cmd.OutOrStdout()
+}
+
+// writeReport writes report in format. Nothing is written when no format
+// was requested.
+func writeReport(w io.Writer, format string, report *operationReport) error {
+	if format == "" || report == nil {
+		return nil
+	}
+
+	if report.Applied == nil {
+		report.Applied = []reportCommit{}
+	}
+	if report.Conflicts == nil {
+		report.Conflicts = []reportConflict{}
+	}
+	report.Resolved = len(report.Conflicts) == 0
+
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	if err := enc.Encode(report); err != nil {
+		return fmt.Errorf("failed to write report: %w", err)
+	}
+	return nil
+}
+
+// newReportCommit describes commit for a report
+func newReportCommit(commit *objects.Commit) reportCommit {
+	return reportCommit{ID: commit.ID().String(), Subject: commitSubject(commit)}
+}
+
+// newReportConflicts describes the conflicts of a merge result for a report
+func newReportConflicts(conflicts []merge.Conflict) []reportConflict {
+	reported := make([]reportConflict, 0, len(conflicts))
+	for _, c := range conflicts {
+		reported = append(reported, reportConflict{
+			Path:   c.Path,
+			Type:   string(c.Type),
+			Base:   entryID(c.Base),
+			Ours:   entryID(c.Ours),
+			Theirs: entryID(c.Theirs),
+		})
+	}
+	return reported
+}
+
+func entryID(entry *merge.Entry) string {
+	if entry == nil {
+		return ""
+	}
+	return entry.ID.String()
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/config"
+	"github.com/fenilsonani/vcs/internal/core/convert"
+	"github.com/fenilsonani/vcs/internal/core/index"
+	"github.com/fenilsonani/vcs/internal/core/merge"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/rerere"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// Default ages after which rerere gc drops records, in days, as in Git
+const (
+	defaultRerereResolvedDays   = 60
+	defaultRerereUnresolvedDays = 15
+)
+
+func newRerereCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "rerere",
+		Short: "Reuse recorded resolution of conflicted merges",
+		Long: `Records how conflicts are resolved and resolves them the same way when
+they come up again. With rerere.enabled set, or once the rr-cache
+directory exists, merge, rebase and cherry-pick record each conflicted
+file they stop at, and commit, rebase --continue and cherry-pick
+--continue record the resolutions. A conflict seen before is resolved in
+the working tree as it was then; with rerere.autoUpdate it is staged too.
+
+Run without a subcommand, rerere records the resolutions of the files
+resolved so far.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repo, err := openRerereRepository()
+			if err != nil {
+				return err
+			}
+			return recordRerereResolutions(cmd.ErrOrStderr(), repo)
+		},
+	}
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "status",
+		Short: "List the files whose conflicts rerere recorded",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repo, err := openRerereRepository()
+			if err != nil {
+				return err
+			}
+			entries, err := rerere.New(repo.GitDir()).Conflicts()
+			if err != nil {
+				return err
+			}
+			for _, e := range entries {
+				fmt.Fprintln(cmd.OutOrStdout(), e.Path)
+			}
+			return nil
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "forget <path>...",
+		Short: "Forget the recorded resolution of conflicts in files",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repo, err := openRerereRepository()
+			if err != nil {
+				return err
+			}
+			cache := rerere.New(repo.GitDir())
+			for _, arg := range args {
+				path, err := pathspecPath(repo.WorkDir(), arg)
+				if err != nil {
+					return err
+				}
+				data, err := os.ReadFile(filepath.Join(repo.WorkDir(), path))
+				if err != nil && !os.IsNotExist(err) {
+					return fmt.Errorf("failed to read %s: %w", path, err)
+				}
+				if err := cache.Forget(path, data); err != nil {
+					return err
+				}
+				fmt.Fprintf(cmd.ErrOrStderr(), "Forgot resolution for '%s'\n", path)
+			}
+			return nil
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "clear",
+		Short: "Abandon the conflicts of the merge in progress",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repo, err := openRerereRepository()
+			if err != nil {
+				return err
+			}
+			return rerere.New(repo.GitDir()).Clear()
+		},
+	})
+
+	cmd.AddCommand(&cobra.Command{
+		Use:   "gc",
+		Short: "Drop old records of conflicts and resolutions",
+		Long: `Drops resolutions not used in gc.rerereResolved days, 60 by default, and
+conflicts never resolved recorded more than gc.rerereUnresolved days ago,
+15 by default.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repo, err := openRerereRepository()
+			if err != nil {
+				return err
+			}
+			cfg := loadConfig(repo.GitDir())
+			resolved, err := rerereDays(cfg, "gc.rerereResolved", defaultRerereResolvedDays)
+			if err != nil {
+				return err
+			}
+			unresolved, err := rerereDays(cfg, "gc.rerereUnresolved", defaultRerereUnresolvedDays)
+			if err != nil {
+				return err
+			}
+			_, err = rerere.New(repo.GitDir()).GC(time.Now(), resolved, unresolved)
+			return err
+		},
+	})
+
+	return cmd
+}
+
+func openRerereRepository() (*vcs.Repository, error) {
+	repoPath, err := findRepository()
+	if err != nil {
+		return nil, fmt.Errorf("not a git repository: %w", err)
+	}
+	return openRepository(repoPath)
+}
+
+// rerereDays reads a config setting given in days
+func rerereDays(cfg *config.Config, key string, def int) (time.Duration, error) {
+	days := def
+	if value, ok := cfg.Get(key); ok && value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid %s: %q", key, value)
+		}
+		days = n
+	}
+	return time.Duration(days) * 24 * time.Hour, nil
+}
+
+// rerereEnabled reports whether conflicts and their resolutions are
+// recorded: rerere.enabled decides, and when it is not set rerere is on
+// once the rr-cache directory exists
+func rerereEnabled(repo *vcs.Repository) bool {
+	if value, ok := loadConfig(repo.GitDir()).Get("rerere.enabled"); ok {
+		enabled, err := config.ParseBool(value)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: ignoring rerere.enabled: %v\n", err)
+			return false
+		}
+		return enabled
+	}
+	return fileExists(filepath.Join(repo.GitDir(), rerere.CacheDir))
+}
+
+// rerereConflicts records the conflicted files a merge left in the working
+// tree and resolves those seen before as they were then, staging them with
+// rerere.autoUpdate
+func rerereConflicts(out io.Writer, repo *vcs.Repository, conflicts []merge.Conflict) error {
+	if !rerereEnabled(repo) {
+		return nil
+	}
+	cache := rerere.New(repo.GitDir())
+
+	autoUpdate := false
+	if value, ok := loadConfig(repo.GitDir()).Get("rerere.autoUpdate"); ok {
+		autoUpdate, _ = config.ParseBool(value)
+	}
+	var idx *index.Index
+	indexPath := filepath.Join(repo.GitDir(), "index")
+	conv := newConverter(repo, out, nil)
+
+	for _, c := range conflicts {
+		fullPath := filepath.Join(repo.WorkDir(), c.Path)
+		data, err := os.ReadFile(fullPath)
+		if err != nil {
+			continue
+		}
+		resolved, recorded, replayed, err := cache.Record(c.Path, data)
+		if err != nil {
+			return err
+		}
+		if !recorded {
+			continue
+		}
+		if !replayed {
+			fmt.Fprintf(out, "Recorded preimage for '%s'\n", c.Path)
+			continue
+		}
+
+		if err := os.WriteFile(fullPath, resolved, 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", c.Path, err)
+		}
+		fmt.Fprintf(out, "Resolved '%s' using previous resolution.\n", c.Path)
+		if !autoUpdate {
+			continue
+		}
+
+		if idx == nil {
+			idx = index.New()
+			if err := idx.ReadFromFile(indexPath); err != nil {
+				return fmt.Errorf("failed to read index: %w", err)
+			}
+		}
+		if err := stageWorkingFile(repo, conv, idx, c.Path, c.Mode); err != nil {
+			return err
+		}
+		fmt.Fprintf(out, "Staged '%s' using previous resolution.\n", c.Path)
+	}
+
+	if idx != nil {
+		if err := idx.WriteToFile(indexPath); err != nil {
+			return fmt.Errorf("failed to write index: %w", err)
+		}
+	}
+	return nil
+}
+
+// recordRerereResolutions records how the conflicts rerere noted were
+// resolved in the working tree
+func recordRerereResolutions(out io.Writer, repo *vcs.Repository) error {
+	if !rerereEnabled(repo) {
+		return nil
+	}
+	recorded, err := rerere.New(repo.GitDir()).RecordResolutions(func(path string) ([]byte, error) {
+		return os.ReadFile(filepath.Join(repo.WorkDir(), path))
+	})
+	if err != nil {
+		return err
+	}
+	for _, path := range recorded {
+		fmt.Fprintf(out, "Recorded resolution for '%s'.\n", path)
+	}
+	return nil
+}
+
+// clearRerere abandons the conflicts rerere noted for an operation that
+// was aborted or skipped
+func clearRerere(repo *vcs.Repository) error {
+	if !rerereEnabled(repo) {
+		return nil
+	}
+	return rerere.New(repo.GitDir()).Clear()
+}
+
+// stageWorkingFile stages the working tree file at path with mode,
+// resolving any conflict stages it has in idx
+func stageWorkingFile(repo *vcs.Repository, conv *convert.Converter, idx *index.Index, path string, mode objects.FileMode) error {
+	fullPath := filepath.Join(repo.WorkDir(), path)
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", path, err)
+	}
+	data, err := os.ReadFile(fullPath)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if data, err = conv.Clean(path, data); err != nil {
+		return err
+	}
+	blob, err := repo.CreateBlob(data)
+	if err != nil {
+		return fmt.Errorf("failed to store %s: %w", path, err)
+	}
+	entry := &index.Entry{CTime: info.ModTime(), MTime: info.ModTime(), Mode: mode, Size: uint32(info.Size()), ID: blob.ID(), Path: path}
+	if err := idx.Add(entry); err != nil {
+		return fmt.Errorf("failed to add %s to index: %w", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/index"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/internal/rerere"
+)
+
+func runRerereArgs(args ...string) (string, string, error) {
+	cmd := newRerereCommand()
+	var stdout, stderr bytes.Buffer
+	cmd.SetOut(&stdout)
+	cmd.SetErr(&stderr)
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+	return stdout.String(), stderr.String(), err
+}
+
+func TestRerereReplaysResolution(t *testing.T) {
+	repo := setupMergeRepo(t,
+		map[string]string{"a.txt": "one\nbase\nthree\n"},
+		map[string]string{"a.txt": "one\nours\nthree\n"},
+		map[string]string{"a.txt": "one\ntheirs\nthree\n"},
+	)
+	_, err := runConfigArgs("rerere.enabled", "true")
+	require.NoError(t, err)
+	_, err = runConfigArgs("rerere.autoUpdate", "true")
+	require.NoError(t, err)
+
+	stdout, _, err := runMergeArgs("topic")
+	require.ErrorIs(t, err, errMergeConflict)
+	assert.Contains(t, stdout, "Recorded preimage for 'a.txt'")
+
+	stdout, _, err = runRerereArgs("status")
+	require.NoError(t, err)
+	assert.Equal(t, "a.txt\n", stdout)
+
+	resolution := "one\nours and theirs\nthree\n"
+	require.NoError(t, os.WriteFile(filepath.Join(repo.WorkDir(), "a.txt"), []byte(resolution), 0644))
+	_, stderr, err := runRerereArgs()
+	require.NoError(t, err)
+	assert.Contains(t, stderr, "Recorded resolution for 'a.txt'.")
+	assert.NoFileExists(t, filepath.Join(repo.GitDir(), rerere.MergeRR))
+
+	// Start over and merge again: the conflict is resolved and staged
+	mainID, err := refs.NewRefManager(repo.GitDir()).ResolveRef("refs/heads/main")
+	require.NoError(t, err)
+	require.NoError(t, restoreWorkingTree(repo, mainID))
+	require.NoError(t, os.Remove(filepath.Join(repo.GitDir(), "MERGE_HEAD")))
+
+	stdout, _, err = runMergeArgs("topic")
+	require.ErrorIs(t, err, errMergeConflict)
+	assert.Contains(t, stdout, "Resolved 'a.txt' using previous resolution.")
+	content, err := os.ReadFile(filepath.Join(repo.WorkDir(), "a.txt"))
+	require.NoError(t, err)
+	assert.Equal(t, resolution, string(content))
+
+	idx := index.New()
+	require.NoError(t, idx.ReadFromFile(filepath.Join(repo.GitDir(), "index")))
+	assert.Empty(t, idx.Unmerged())
+}
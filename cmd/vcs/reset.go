@@ -3,13 +3,17 @@ package main
 import (
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
 
 	"github.com/spf13/cobra"
 
+	"github.com/fenilsonani/vcs/internal/core/convert"
 	"github.com/fenilsonani/vcs/internal/core/index"
 	"github.com/fenilsonani/vcs/internal/core/objects"
 	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/internal/core/workdir"
+	"github.com/fenilsonani/vcs/internal/progress"
 	"github.com/fenilsonani/vcs/pkg/vcs"
 )
 
@@ -18,6 +22,7 @@ func newResetCommand() *cobra.Command {
 		soft  bool
 		mixed bool
 		hard  bool
+		patch bool
 	)
 
 	cmd := &cobra.Command{
@@ -34,13 +39,25 @@ The three modes are:
 				return err
 			}
 
-			vcsRepo, err := vcs.Open(repo)
+			vcsRepo, err := openRepository(repo)
 			if err != nil {
 				return err
 			}
 
 			refManager := refs.NewRefManager(vcsRepo.GitDir())
 
+			// With -p the arguments are an optional commit and paths
+			if patch {
+				target, paths := "HEAD", args
+				dash := cmd.ArgsLenAtDash()
+				if dash > 0 || (dash < 0 && len(args) > 0) {
+					if _, err := resolveCommitish(vcsRepo, args[0]); err == nil {
+						target, paths = args[0], args[1:]
+					}
+				}
+				return unstageHunks(cmd, vcsRepo, target, paths)
+			}
+
 			// Determine reset mode
 			mode := ResetMixed // default
 			if soft {
@@ -62,6 +79,7 @@ The three modes are:
 	cmd.Flags().BoolVar(&soft, "soft", false, "Only move HEAD pointer")
 	cmd.Flags().BoolVar(&mixed, "mixed", false, "Move HEAD and reset index (default)")
 	cmd.Flags().BoolVar(&hard, "hard", false, "Move HEAD, reset index and working tree")
+	cmd.Flags().BoolVarP(&patch, "patch", "p", false, "Interactively choose hunks of staged changes to unstage")
 
 	return cmd
 }
@@ -76,7 +94,7 @@ const (
 
 func runReset(repo *vcs.Repository, refManager *refs.RefManager, target string, mode ResetMode) error {
 	// Resolve target commit
-	targetID, err := refManager.ResolveRef(target)
+	targetID, err := resolveCommitish(repo, target)
 	if err != nil {
 		return fmt.Errorf("failed to resolve %q: %w", target, err)
 	}
@@ -94,9 +112,11 @@ func runReset(repo *vcs.Repository, refManager *refs.RefManager, target string,
 
 	// Update HEAD to point to target commit
 	currentRef := "refs/heads/" + currentBranch
+	oldID, _ := refManager.ResolveRef(currentRef)
 	if err := refManager.WriteRef(currentRef, targetID, nil); err != nil {
 		return fmt.Errorf("failed to update %s: %w", currentRef, err)
 	}
+	logRefUpdate(refManager, currentRef, oldID, targetID, "reset: moving to "+target)
 
 	switch mode {
 	case ResetSoft:
@@ -150,6 +170,51 @@ func resetIndex(repo *vcs.Repository, commit *objects.Commit) error {
 	return nil
 }
 
+// resetIndexToHead makes the index match the commit HEAD is on, as after a
+// successful commit
+func resetIndexToHead(repo *vcs.Repository) error {
+	headID, _, err := refs.NewRefManager(repo.GitDir()).HEAD()
+	if err != nil {
+		return fmt.Errorf("failed to read HEAD: %w", err)
+	}
+	commit, err := repo.GetCommit(headID)
+	if err != nil {
+		return fmt.Errorf("failed to read HEAD commit: %w", err)
+	}
+	return resetIndex(repo, commit)
+}
+
+// readIndex reads the index of repo. A repository with commits but no index
+// file, as when they were written without being checked out, reads as
+// having the files of HEAD staged.
+func readIndex(repo *vcs.Repository) (*index.Index, error) {
+	idx := index.New()
+	indexPath := filepath.Join(repo.GitDir(), "index")
+	if _, err := os.Stat(indexPath); err == nil {
+		if err := idx.ReadFromFile(indexPath); err != nil {
+			return nil, fmt.Errorf("failed to read index: %w", err)
+		}
+		return idx, nil
+	}
+
+	headID, _, err := refs.NewRefManager(repo.GitDir()).HEAD()
+	if err != nil || headID.IsZero() {
+		return idx, nil
+	}
+	commit, err := repo.GetCommit(headID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read HEAD commit: %w", err)
+	}
+	tree, err := repo.GetTree(commit.Tree())
+	if err != nil {
+		return nil, fmt.Errorf("failed to get tree: %w", err)
+	}
+	if err := populateIndexFromTree(repo, idx, tree, ""); err != nil {
+		return nil, fmt.Errorf("failed to populate index: %w", err)
+	}
+	return idx, nil
+}
+
 func resetWorkingTree(repo *vcs.Repository, commit *objects.Commit) error {
 	// Get the tree from commit
 	tree, err := repo.GetTree(commit.Tree())
@@ -179,6 +244,12 @@ func populateIndexFromTree(repo *vcs.Repository, idx *index.Index, tree *objects
 			if err := populateIndexFromTree(repo, idx, subtree, fullPath); err != nil {
 				return err
 			}
+		} else if entry.Mode == objects.ModeCommit {
+			// A submodule's commit is in its own repository
+			indexEntry := &index.Entry{Mode: entry.Mode, ID: entry.ID, Path: fullPath}
+			if err := idx.Add(indexEntry); err != nil {
+				return fmt.Errorf("failed to add entry to index: %w", err)
+			}
 		} else {
 			// Add file to index
 			blob, err := repo.GetBlob(entry.ID)
@@ -226,6 +297,11 @@ func clearWorkingDirectory(repo *vcs.Repository) error {
 			return nil
 		}
 
+		// Leave submodules and other nested repositories alone
+		if info.IsDir() && workdir.IsRepository(path) {
+			return filepath.SkipDir
+		}
+
 		// Remove file
 		if !info.IsDir() {
 			if err := os.Remove(path); err != nil {
@@ -238,29 +314,50 @@ func clearWorkingDirectory(repo *vcs.Repository) error {
 }
 
 func extractTreeToWorkingDirectory(repo *vcs.Repository, tree *objects.Tree, basePath string) error {
+	// The tree's own .gitattributes decide how its files are converted,
+	// since they may not be in the working tree yet
+	conv := newConverter(repo, os.Stderr, treeAttributes(repo, tree))
+	return extractTree(repo, conv, tree, basePath, "", nil)
+}
+
+// extractTree writes tree, at prefix in the repository, to basePath,
+// counting each file and submodule on meter
+func extractTree(repo *vcs.Repository, conv *convert.Converter, tree *objects.Tree, basePath, prefix string, meter *progress.Meter) error {
 	for _, entry := range tree.Entries() {
 		fullPath := filepath.Join(basePath, entry.Name)
-		
+		relPath := path.Join(prefix, entry.Name)
+
 		if entry.Mode == objects.ModeTree {
 			// Create directory and recursively extract subtree
 			if err := os.MkdirAll(fullPath, 0755); err != nil {
 				return fmt.Errorf("failed to create directory %s: %w", fullPath, err)
 			}
-			
+
 			subtree, err := repo.GetTree(entry.ID)
 			if err != nil {
 				return fmt.Errorf("failed to get subtree %s: %w", entry.ID.Short(), err)
 			}
-			
-			if err := extractTreeToWorkingDirectory(repo, subtree, fullPath); err != nil {
+
+			if err := extractTree(repo, conv, subtree, fullPath, relPath, meter); err != nil {
 				return err
 			}
+		} else if entry.Mode == objects.ModeCommit {
+			// A submodule is checked out by submodule update; until then
+			// its directory is empty
+			if err := os.MkdirAll(fullPath, 0755); err != nil {
+				return fmt.Errorf("failed to create directory %s: %w", fullPath, err)
+			}
+			meter.Add(1)
 		} else {
 			// Extract file
 			blob, err := repo.GetBlob(entry.ID)
 			if err != nil {
 				return fmt.Errorf("failed to get blob %s: %w", entry.ID.Short(), err)
 			}
+			data, err := conv.Smudge(relPath, blob.Data())
+			if err != nil {
+				return err
+			}
 
 			// Ensure parent directory exists
 			if err := os.MkdirAll(filepath.Dir(fullPath), 0755); err != nil {
@@ -273,9 +370,10 @@ func extractTreeToWorkingDirectory(repo *vcs.Repository, tree *objects.Tree, bas
 				fileMode = 0755
 			}
 
-			if err := os.WriteFile(fullPath, blob.Data(), fileMode); err != nil {
+			if err := os.WriteFile(fullPath, data, fileMode); err != nil {
 				return fmt.Errorf("failed to write file %s: %w", fullPath, err)
 			}
+			meter.Add(1)
 		}
 	}
 	return nil
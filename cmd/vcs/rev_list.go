@@ -0,0 +1,167 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// revListOptions holds the flags of rev-list
+type revListOptions struct {
+	objects   bool
+	count     bool
+	diskUsage string
+}
+
+func newRevListCommand() *cobra.Command {
+	var opts revListOptions
+
+	cmd := &cobra.Command{
+		Use:   "rev-list [flags] <revision-range>...",
+		Short: "List commits in reverse chronological order",
+		Long: `Lists the commits reachable from the given revisions, newest first,
+leaving out those reachable from excluded ones: A..B, A...B and ^A work as
+in log. With --objects the trees and blobs the listed commits introduce
+follow them.
+
+--count prints how many entries would be listed instead. --disk-usage
+prints how many bytes the objects take in the repository, loose or packed,
+and always counts trees and blobs; --disk-usage=human prints the size in
+KiB, MiB or GiB. Given both, the count and the size are printed on one line
+separated by a tab, so "vcs rev-list --count --disk-usage origin/main..HEAD"
+tells how much a push would send.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runRevList(cmd, args, opts)
+		},
+	}
+
+	cmd.Flags().BoolVar(&opts.objects, "objects", false, "Also list the trees and blobs the commits introduce")
+	cmd.Flags().BoolVar(&opts.count, "count", false, "Print the number of entries instead of listing them")
+	cmd.Flags().StringVar(&opts.diskUsage, "disk-usage", "", "Print the size the objects take on disk (bytes or human)")
+	cmd.Flags().Lookup("disk-usage").NoOptDefVal = "bytes"
+
+	return cmd
+}
+
+func runRevList(cmd *cobra.Command, args []string, opts revListOptions) error {
+	if opts.diskUsage != "" && opts.diskUsage != "bytes" && opts.diskUsage != "human" {
+		return fmt.Errorf("invalid value for --disk-usage: '%s'", opts.diskUsage)
+	}
+
+	repoPath, err := findRepository()
+	if err != nil {
+		return fmt.Errorf("not a git repository: %w", err)
+	}
+	repo, err := openRepository(repoPath)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	resolver := newResolver(repo)
+	var starts, excluded []objects.ObjectID
+	for _, arg := range args {
+		r, err := resolver.ResolveRange(arg)
+		if err != nil {
+			return err
+		}
+		starts = append(starts, r.Include...)
+		excluded = append(excluded, r.Exclude...)
+	}
+
+	listed, err := listRevisions(repo, starts, excluded, opts.objects || opts.diskUsage != "")
+	if err != nil {
+		return err
+	}
+
+	out := cmd.OutOrStdout()
+	if opts.diskUsage == "" {
+		if opts.count {
+			fmt.Fprintln(out, len(listed))
+			return nil
+		}
+		for _, id := range listed {
+			fmt.Fprintln(out, id)
+		}
+		return nil
+	}
+
+	var total int64
+	for _, id := range listed {
+		size, err := repo.Storage().DiskSize(id)
+		if err != nil {
+			return fmt.Errorf("failed to get size of %s: %w", id, err)
+		}
+		total += size
+	}
+	usage := fmt.Sprint(total)
+	if opts.diskUsage == "human" {
+		usage = humanSize(total)
+	}
+	if opts.count {
+		fmt.Fprintf(out, "%d\t%s\n", len(listed), usage)
+	} else {
+		fmt.Fprintln(out, usage)
+	}
+	return nil
+}
+
+// listRevisions returns the commits reachable from starts but not from
+// excluded, newest first, followed by the trees and blobs they introduce
+// when withObjects is set
+func listRevisions(repo *vcs.Repository, starts, excluded []objects.ObjectID, withObjects bool) ([]objects.ObjectID, error) {
+	wanted, err := reachableObjects(repo, starts)
+	if err != nil {
+		return nil, err
+	}
+	present, err := reachableObjects(repo, excluded)
+	if err != nil {
+		return nil, err
+	}
+
+	var commits []*objects.Commit
+	var others []objects.ObjectID
+	for _, id := range wanted.order {
+		if present.seen[id] {
+			continue
+		}
+		obj, err := repo.ReadObject(id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read object %s: %w", id, err)
+		}
+		if commit, ok := obj.(*objects.Commit); ok {
+			commits = append(commits, commit)
+		} else if withObjects {
+			others = append(others, id)
+		}
+	}
+
+	sort.SliceStable(commits, func(i, j int) bool {
+		return commits[i].Committer().When.After(commits[j].Committer().When)
+	})
+	listed := make([]objects.ObjectID, 0, len(commits)+len(others))
+	for _, commit := range commits {
+		listed = append(listed, commit.ID())
+	}
+	return append(listed, others...), nil
+}
+
+// humanSize formats a number of bytes with a binary unit, as Git's
+// --disk-usage=human does
+func humanSize(size int64) string {
+	units := []string{"KiB", "MiB", "GiB", "TiB"}
+	if size < 1024 {
+		return fmt.Sprintf("%d bytes", size)
+	}
+	value := float64(size) / 1024
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	return fmt.Sprintf("%.2f %s", value, units[unit])
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+func TestRevList(t *testing.T) {
+	repo, commits := setupRenameRepo(t)
+
+	out, err := runCommandArgs(newRevListCommand(), "HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, commits[3].String()+"\n"+commits[2].String()+"\n"+commits[1].String()+"\n"+commits[0].String()+"\n", out)
+
+	out, err = runCommandArgs(newRevListCommand(), "--count", "HEAD~2..HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, "2\n", out)
+
+	// The last commit brings a commit, its root tree and the changed blob
+	out, err = runCommandArgs(newRevListCommand(), "--objects", "HEAD~1..HEAD")
+	require.NoError(t, err)
+	listed := strings.Fields(out)
+	require.Len(t, listed, 3)
+	assert.Equal(t, commits[3].String(), listed[0])
+
+	var loose int64
+	for _, id := range listed {
+		objectID, err := objects.NewObjectID(id)
+		require.NoError(t, err)
+		info, err := os.Stat(repo.Storage().LooseObjectPath(objectID))
+		require.NoError(t, err)
+		loose += info.Size()
+	}
+	out, err = runCommandArgs(newRevListCommand(), "--count", "--disk-usage", "HEAD", "^HEAD~1")
+	require.NoError(t, err)
+	assert.Equal(t, "3\t"+strconv.FormatInt(loose, 10)+"\n", out)
+
+	// Packed objects count the size of their pack entries
+	_, err = runGCArgs()
+	require.NoError(t, err)
+	out, err = runCommandArgs(newRevListCommand(), "--disk-usage", "HEAD")
+	require.NoError(t, err)
+	packed, err := strconv.ParseInt(strings.TrimSpace(out), 10, 64)
+	require.NoError(t, err)
+	assert.Greater(t, packed, int64(0))
+
+	out, err = runCommandArgs(newRevListCommand(), "--disk-usage=human", "HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, humanSize(packed)+"\n", out)
+	_, err = runCommandArgs(newRevListCommand(), "--disk-usage=kb", "HEAD")
+	assert.ErrorContains(t, err, "invalid value for --disk-usage")
+}
+
+func TestHumanSize(t *testing.T) {
+	assert.Equal(t, "512 bytes", humanSize(512))
+	assert.Equal(t, "1.50 KiB", humanSize(1536))
+	assert.Equal(t, "2.00 MiB", humanSize(2<<20))
+}
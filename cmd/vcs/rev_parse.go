@@ -0,0 +1,178 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/revparse"
+	"github.com/spf13/cobra"
+)
+
+// revParseOptions holds the flags of rev-parse
+type revParseOptions struct {
+	verify           bool
+	quiet            bool
+	short            int
+	abbrevRef        bool
+	symbolicFullName bool
+	gitDir           bool
+	gitCommonDir     bool
+	showToplevel     bool
+	isInsideWorkTree bool
+}
+
+func newRevParseCommand() *cobra.Command {
+	var opts revParseOptions
+
+	cmd := &cobra.Command{
+		Use:   "rev-parse [flags] <revision>...",
+		Short: "Pick out and massage parameters",
+		Long: `Prints the object name of each revision, which may use any of Git's revision
+syntax: HEAD~2, main^2, v1.0^{}, abc1234, main@{1}, main@{yesterday},
+@{upstream}, @{-1}, :/fix typo, HEAD:README.md or :README.md. Ranges print
+their included commits, then their excluded ones prefixed with ^, so A..B
+prints B and ^A.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runRevParse(cmd, args, opts)
+		},
+	}
+
+	cmd.Flags().BoolVar(&opts.verify, "verify", false, "Check that exactly one argument names an object")
+	cmd.Flags().BoolVarP(&opts.quiet, "quiet", "q", false, "With --verify, fail silently")
+	cmd.Flags().IntVar(&opts.short, "short", 0, "Abbreviate object names to at least n characters")
+	cmd.Flags().Lookup("short").NoOptDefVal = "7"
+	cmd.Flags().BoolVar(&opts.abbrevRef, "abbrev-ref", false, "Print the short name of the ref each argument names")
+	cmd.Flags().BoolVar(&opts.symbolicFullName, "symbolic-full-name", false, "Print the full name of the ref each argument names")
+	cmd.Flags().BoolVar(&opts.gitDir, "git-dir", false, "Print the path of the git directory")
+	cmd.Flags().BoolVar(&opts.gitCommonDir, "git-common-dir", false, "Print the path of the directory shared by all worktrees")
+	cmd.Flags().BoolVar(&opts.showToplevel, "show-toplevel", false, "Print the top-level directory of the working tree")
+	cmd.Flags().BoolVar(&opts.isInsideWorkTree, "is-inside-work-tree", false, "Print whether the current directory is inside the working tree")
+
+	return cmd
+}
+
+func runRevParse(cmd *cobra.Command, args []string, opts revParseOptions) error {
+	repoPath, err := findRepository()
+	if err != nil {
+		return fmt.Errorf("not a git repository: %w", err)
+	}
+	repo, err := openRepository(repoPath)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	out := cmd.OutOrStdout()
+	if opts.gitDir {
+		fmt.Fprintln(out, relativeToCwd(repo.GitDir()))
+	}
+	if opts.gitCommonDir {
+		fmt.Fprintln(out, relativeToCwd(repo.CommonDir()))
+	}
+	if opts.showToplevel {
+		fmt.Fprintln(out, repo.WorkDir())
+	}
+	if opts.isInsideWorkTree {
+		fmt.Fprintln(out, "true")
+	}
+
+	resolver := newResolver(repo)
+	if opts.verify {
+		if opts.quiet {
+			cmd.SilenceErrors = true
+			cmd.SilenceUsage = true
+		}
+		if len(args) != 1 || revparse.IsRange(args[0]) {
+			return fmt.Errorf("needed a single revision")
+		}
+		id, err := resolver.Resolve(args[0])
+		if err != nil {
+			return fmt.Errorf("needed a single revision: %w", err)
+		}
+		return printRevision(out, resolver, "", id, opts.short)
+	}
+
+	for _, arg := range args {
+		if opts.abbrevRef || opts.symbolicFullName {
+			name, err := resolver.SymbolicFullName(arg)
+			if err != nil {
+				return err
+			}
+			if opts.abbrevRef {
+				name = shortRefName(name)
+			}
+			fmt.Fprintln(out, name)
+			continue
+		}
+
+		if revparse.IsRange(arg) {
+			r, err := resolver.ResolveRange(arg)
+			if err != nil {
+				return err
+			}
+			for _, id := range r.Include {
+				if err := printRevision(out, resolver, "", id, opts.short); err != nil {
+					return err
+				}
+			}
+			for _, id := range r.Exclude {
+				if err := printRevision(out, resolver, "^", id, opts.short); err != nil {
+					return err
+				}
+			}
+			continue
+		}
+
+		id, err := resolver.Resolve(arg)
+		if err != nil {
+			return err
+		}
+		if err := printRevision(out, resolver, "", id, opts.short); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// printRevision prints id after prefix, abbreviated to at least short
+// characters when short is set
+func printRevision(out io.Writer, resolver *revparse.Resolver, prefix string, id objects.ObjectID, short int) error {
+	name := id.String()
+	if short > 0 {
+		var err error
+		if name, err = resolver.Abbrev(id, short); err != nil {
+			return err
+		}
+	}
+	fmt.Fprintln(out, prefix+name)
+	return nil
+}
+
+// shortRefName drops the refs/heads/, refs/tags/ or refs/remotes/ prefix
+// from a full ref name
+func shortRefName(name string) string {
+	for _, prefix := range []string{"refs/heads/", "refs/tags/", "refs/remotes/", "refs/"} {
+		if strings.HasPrefix(name, prefix) {
+			return strings.TrimPrefix(name, prefix)
+		}
+	}
+	return name
+}
+
+// relativeToCwd returns path relative to the current directory when it is
+// below it, as Git prints .git from the top of the working tree
+func relativeToCwd(path string) string {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return path
+	}
+	rel, err := filepath.Rel(cwd, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return path
+	}
+	return rel
+}
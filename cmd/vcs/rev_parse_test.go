@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/refs"
+)
+
+func runRevParseArgs(args ...string) (string, error) {
+	cmd := newRevParseCommand()
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+	return out.String(), err
+}
+
+func TestRevParse(t *testing.T) {
+	_, commits := setupRenameRepo(t)
+
+	out, err := runRevParseArgs("HEAD~1", "main^^", "HEAD~3:other.txt")
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	require.Len(t, lines, 3)
+	assert.Equal(t, commits[2].String(), lines[0])
+	assert.Equal(t, commits[1].String(), lines[1])
+	assert.Len(t, lines[2], 40)
+
+	out, err = runRevParseArgs("HEAD~2..HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, commits[3].String()+"\n^"+commits[1].String()+"\n", out)
+
+	out, err = runRevParseArgs("--short", "HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, commits[3].String()[:7]+"\n", out)
+
+	out, err = runRevParseArgs("--short=10", commits[0].String()[:8])
+	require.NoError(t, err)
+	assert.Equal(t, commits[0].String()[:10]+"\n", out)
+
+	out, err = runRevParseArgs("--abbrev-ref", "HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, "main\n", out)
+
+	out, err = runRevParseArgs("--symbolic-full-name", "@")
+	require.NoError(t, err)
+	assert.Equal(t, "refs/heads/main\n", out)
+
+	out, err = runRevParseArgs("--git-dir")
+	require.NoError(t, err)
+	assert.Equal(t, ".git\n", out)
+
+	_, err = runRevParseArgs("--verify", "HEAD~1..HEAD")
+	assert.ErrorContains(t, err, "needed a single revision")
+	_, err = runRevParseArgs("--verify", "-q", "nope")
+	assert.Error(t, err)
+	_, err = runRevParseArgs("HEAD~9")
+	assert.ErrorContains(t, err, "has no parent")
+}
+
+func TestLogRevisionRange(t *testing.T) {
+	_, commits := setupRenameRepo(t)
+	short := func(i int) string { return commits[i].String()[:7] }
+
+	out, err := runLogArgs(t, "--oneline", "HEAD~2..HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, short(3)+" edit other\n"+short(2)+" rename old to new\n", out)
+
+	out, err = runLogArgs(t, "--oneline", "^HEAD~1", "main")
+	require.NoError(t, err)
+	assert.Equal(t, short(3)+" edit other\n", out)
+
+	out, err = runLogArgs(t, "--oneline", "HEAD~2", "--", "old.txt")
+	require.NoError(t, err)
+	assert.Equal(t, short(1)+" edit old\n"+short(0)+" add old\n", out)
+}
+
+func TestDiffRevisionRange(t *testing.T) {
+	setupRenameRepo(t)
+
+	out, err := captureStdout(t, func() error {
+		cmd := newDiffCommand()
+		cmd.SetArgs([]string{"--name-only", "HEAD~1..HEAD"})
+		return cmd.Execute()
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "other.txt\n", out)
+}
+
+func TestCheckoutPreviousBranch(t *testing.T) {
+	repo := setupMergeRepo(t, map[string]string{"a.txt": "base\n"}, map[string]string{"a.txt": "ours\n"}, map[string]string{"a.txt": "theirs\n"})
+	refManager := refs.NewRefManager(repo.GitDir())
+
+	runCheckoutArgs := func(args ...string) error {
+		cmd := newCheckoutCommand()
+		cmd.SetOut(&bytes.Buffer{})
+		cmd.SetArgs(args)
+		return cmd.Execute()
+	}
+
+	require.NoError(t, runCheckoutArgs("-f", "topic"))
+	require.NoError(t, runCheckoutArgs("-f", "main~1"))
+	head, err := refManager.SymbolicHEAD()
+	require.NoError(t, err)
+	assert.Empty(t, head)
+
+	// Leaving topic for a detached commit still returns to topic
+	require.NoError(t, runCheckoutArgs("-f", "@{-1}"))
+	head, err = refManager.SymbolicHEAD()
+	require.NoError(t, err)
+	assert.Equal(t, "refs/heads/topic", head)
+
+	require.NoError(t, runCheckoutArgs("-f", "main"))
+	require.NoError(t, runCheckoutArgs("-f", "-"))
+	head, err = refManager.SymbolicHEAD()
+	require.NoError(t, err)
+	assert.Equal(t, "refs/heads/topic", head)
+}
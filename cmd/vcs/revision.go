@@ -0,0 +1,34 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/internal/revparse"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// newResolver returns a resolver for revisions in repo
+func newResolver(repo *vcs.Repository) *revparse.Resolver {
+	return revparse.NewResolver(repo.GitDir(), repo.Storage())
+}
+
+// resolveCommitish resolves any revision Git accepts, such as HEAD~2,
+// v1.0^{}, main@{yesterday} or :/message, to a commit
+func resolveCommitish(repo *vcs.Repository, name string) (objects.ObjectID, error) {
+	return newResolver(repo).ResolveCommit(name)
+}
+
+// logRefUpdate records a ref update in the reflog as the configured user.
+// The update itself has already happened, so failing only warns.
+func logRefUpdate(refManager *refs.RefManager, refName string, oldID, newID objects.ObjectID, message string) {
+	if oldID == newID {
+		return
+	}
+	who, _ := getSignature("")
+	if err := refManager.LogUpdate(refName, oldID, newID, who, message); err != nil {
+		fmt.Fprintf(os.Stderr, "warning: %v\n", err)
+	}
+}
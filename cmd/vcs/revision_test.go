@@ -0,0 +1,132 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+)
+
+func TestResolveReflogDate(t *testing.T) {
+	repo, commits := setupRenameRepo(t)
+	refManager := refs.NewRefManager(repo.GitDir())
+
+	who := objects.Signature{Name: "Test", Email: "test@example.com"}
+	for i, at := range []string{"2024-01-01", "2024-01-05", "2024-01-09", "2024-01-12"} {
+		when, err := time.ParseInLocation("2006-01-02", at, time.Local)
+		require.NoError(t, err)
+		who.When = when
+		var old objects.ObjectID
+		if i > 0 {
+			old = commits[i-1]
+		}
+		require.NoError(t, refManager.LogUpdate("refs/heads/main", old, commits[i], who, "commit"))
+	}
+
+	tests := map[string]objects.ObjectID{
+		"main@{2024-01-01}":            commits[0],
+		"main@{2024-01-06}":            commits[1],
+		"refs/heads/main@{2024-01-10}": commits[2],
+		"@{2024-02-01}":                commits[3],
+		"HEAD@{2024-01-06}":            commits[1],
+	}
+	for rev, want := range tests {
+		id, err := resolveCommitish(repo, rev)
+		require.NoError(t, err, rev)
+		assert.Equal(t, want, id, rev)
+	}
+
+	_, err := resolveCommitish(repo, "main@{2023-06-01}")
+	assert.ErrorContains(t, err, "only goes back to")
+	_, err = resolveCommitish(repo, "main@{whenever}")
+	assert.ErrorContains(t, err, "invalid date")
+}
+
+func TestCheckoutReflogDate(t *testing.T) {
+	repo, commits := setupRenameRepo(t)
+	refManager := refs.NewRefManager(repo.GitDir())
+
+	who := objects.Signature{Name: "Test", Email: "test@example.com", When: time.Now().AddDate(0, 0, -10)}
+	require.NoError(t, refManager.LogUpdate("refs/heads/main", objects.ObjectID{}, commits[1], who, "commit"))
+	who.When = time.Now().Add(-time.Hour)
+	require.NoError(t, refManager.LogUpdate("refs/heads/main", commits[1], commits[3], who, "commit"))
+
+	cmd := newCheckoutCommand()
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"main@{1.week.ago}"})
+	require.NoError(t, cmd.Execute())
+
+	head, branch, err := refManager.HEAD()
+	require.NoError(t, err)
+	assert.Equal(t, "", branch, "HEAD should be detached")
+	assert.Equal(t, commits[1], head)
+	assert.FileExists(t, "old.txt")
+
+	// Checking out records the move of HEAD
+	entries, err := refManager.ReadReflog("HEAD")
+	require.NoError(t, err)
+	require.NotEmpty(t, entries)
+	last := entries[len(entries)-1]
+	assert.Equal(t, commits[1], last.New)
+	assert.Contains(t, last.Message, "checkout: moving from main to ")
+}
+
+func TestResolveUpstreamAndPush(t *testing.T) {
+	repo, commits := setupRenameRepo(t)
+	refManager := refs.NewRefManager(repo.GitDir())
+	require.NoError(t, refManager.UpdateRef("refs/remotes/origin/main", commits[1]))
+	require.NoError(t, refManager.UpdateRef("refs/remotes/fork/main", commits[2]))
+	require.NoError(t, refManager.UpdateRef("refs/remotes/origin/trunk", commits[0]))
+
+	_, err := resolveCommitish(repo, "@{upstream}")
+	assert.ErrorContains(t, err, "no upstream configured for branch 'main'")
+
+	require.NoError(t, setUpstreamBranch(repo, "main", "origin", "main"))
+	for _, rev := range []string{"@{upstream}", "@{u}", "main@{UPSTREAM}", "@{push}"} {
+		id, err := resolveCommitish(repo, rev)
+		require.NoError(t, err, rev)
+		assert.Equal(t, commits[1], id, rev)
+	}
+
+	_, err = runConfigArgs("remote.pushDefault", "fork")
+	require.NoError(t, err)
+	id, err := resolveCommitish(repo, "main@{push}")
+	require.NoError(t, err)
+	assert.Equal(t, commits[2], id)
+
+	_, err = runConfigArgs("branch.main.merge", "refs/heads/trunk")
+	require.NoError(t, err)
+	_, err = runConfigArgs("push.default", "upstream")
+	require.NoError(t, err)
+	id, err = resolveCommitish(repo, "@{push}")
+	require.NoError(t, err)
+	assert.Equal(t, commits[0], id)
+
+	_, err = resolveCommitish(repo, "nope@{u}")
+	assert.ErrorContains(t, err, "no such branch")
+}
+
+func TestLogRefUpdate(t *testing.T) {
+	repo, _ := setupConfigRepo(t)
+	refManager := refs.NewRefManager(repo.GitDir())
+	require.NoError(t, refManager.SetHEAD("refs/heads/main"))
+
+	head := commitFiles(t, repo, map[string]string{"a.txt": "a\n"}, nil, "first\n")
+	logRefUpdate(refManager, "refs/heads/main", objects.ObjectID{}, head, "commit (initial): first")
+	logRefUpdate(refManager, "refs/heads/main", head, head, "no-op")
+
+	for _, ref := range []string{"refs/heads/main", "HEAD"} {
+		entries, err := refManager.ReadReflog(ref)
+		require.NoError(t, err)
+		require.Len(t, entries, 1, ref)
+		assert.Equal(t, head, entries[0].New)
+		assert.Equal(t, "commit (initial): first", entries[0].Message)
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/index"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+type rmOptions struct {
+	cached    bool
+	recursive bool
+	force     bool
+	dryRun    bool
+	quiet     bool
+}
+
+func newRmCommand() *cobra.Command {
+	var opts rmOptions
+
+	cmd := &cobra.Command{
+		Use:   "rm [flags] <pathspec>...",
+		Short: "Remove files from the working tree and from the index",
+		Long: `Stages the removal of the given tracked files and deletes them from the
+working tree. With --cached they are only removed from the index and
+stay in the working tree as untracked files. A directory is removed
+with everything tracked under it when -r is given.
+
+Files whose staged or working tree content differs from HEAD are not
+removed, as that would lose changes, unless -f is given. With --cached
+only files that differ from both HEAD and the working tree are kept.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			return runRm(cmd, args, opts)
+		},
+	}
+
+	cmd.Flags().BoolVar(&opts.cached, "cached", false, "Only remove from the index, keeping the working tree files")
+	cmd.Flags().BoolVarP(&opts.recursive, "recursive", "r", false, "Allow recursive removal of directories")
+	cmd.Flags().BoolVarP(&opts.force, "force", "f", false, "Remove files even when they have changes")
+	cmd.Flags().BoolVarP(&opts.dryRun, "dry-run", "n", false, "Only show which files would be removed")
+	cmd.Flags().BoolVarP(&opts.quiet, "quiet", "q", false, "Do not list the removed files")
+
+	return cmd
+}
+
+func runRm(cmd *cobra.Command, args []string, opts rmOptions) error {
+	repoPath, err := findRepository()
+	if err != nil {
+		return fmt.Errorf("not a git repository: %w", err)
+	}
+	repo, err := openRepository(repoPath)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	idx, err := readIndex(repo)
+	if err != nil {
+		return err
+	}
+	indexPath := filepath.Join(repo.GitDir(), "index")
+	head, _, err := headFiles(repo, readStatusCache(repo))
+	if err != nil {
+		return fmt.Errorf("failed to read HEAD: %w", err)
+	}
+	tracked := trackedPaths(idx)
+
+	// Every path is checked before anything is removed
+	var paths []string
+	seen := make(map[string]bool)
+	for _, arg := range args {
+		spec, err := pathspecPath(repoPath, arg)
+		if err != nil {
+			return err
+		}
+		matched := matchTracked(tracked, spec)
+		if len(matched) == 0 {
+			return fmt.Errorf("pathspec '%s' did not match any files", arg)
+		}
+		if !opts.recursive && (len(matched) > 1 || matched[0] != spec) {
+			return fmt.Errorf("not removing '%s' recursively without -r", arg)
+		}
+		for _, p := range matched {
+			if !seen[p] {
+				seen[p] = true
+				paths = append(paths, p)
+			}
+		}
+	}
+	sort.Strings(paths)
+
+	if !opts.force {
+		if err := checkRemovable(repo, idx, head, paths, opts.cached); err != nil {
+			return err
+		}
+	}
+
+	out := cmd.OutOrStdout()
+	for _, p := range paths {
+		if !opts.quiet {
+			fmt.Fprintf(out, "rm '%s'\n", p)
+		}
+	}
+	if opts.dryRun {
+		return nil
+	}
+
+	for _, p := range paths {
+		idx.Remove(p)
+	}
+	if err := idx.WriteToFile(indexPath); err != nil {
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+
+	if opts.cached {
+		return nil
+	}
+	for _, p := range paths {
+		full := filepath.Join(repoPath, filepath.FromSlash(p))
+		if err := os.Remove(full); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", p, err)
+		}
+		removeEmptyParents(repoPath, filepath.Dir(full))
+	}
+	return nil
+}
+
+// trackedPaths returns the paths of the files in the index, sorted
+func trackedPaths(idx *index.Index) []string {
+	var paths []string
+	for _, entry := range idx.Entries() {
+		if len(paths) == 0 || paths[len(paths)-1] != entry.Path {
+			paths = append(paths, entry.Path)
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// trackedFile returns the mode and ID the index has for path
+func trackedFile(idx *index.Index, path string) (objects.FileMode, objects.ObjectID, bool) {
+	if entry, ok := idx.Get(path); ok {
+		return entry.Mode, entry.ID, true
+	}
+	return 0, objects.ObjectID{}, false
+}
+
+// matchTracked returns the tracked paths that are spec or under it, the
+// root being ""
+func matchTracked(tracked []string, spec string) []string {
+	var matched []string
+	for _, p := range tracked {
+		if spec == "" || p == spec || strings.HasPrefix(p, spec+"/") {
+			matched = append(matched, p)
+		}
+	}
+	return matched
+}
+
+// pathspecPath converts a path given on the command line into one relative
+// to the repository root, "" for the root itself
+func pathspecPath(repoPath, arg string) (string, error) {
+	abs, err := filepath.Abs(arg)
+	if err != nil {
+		return "", err
+	}
+	if abs == filepath.Clean(repoPath) {
+		return "", nil
+	}
+	return repoRelativePath(repoPath, arg)
+}
+
+// checkRemovable fails when removing paths would lose changes: staged
+// content that differs from HEAD, or working tree content that differs
+// from what is staged. With cached only content differing from both is
+// kept.
+func checkRemovable(repo *vcs.Repository, idx *index.Index, head map[string]index.HeadEntry, paths []string, cached bool) error {
+	conv := newConverter(repo, os.Stderr, nil)
+	var both, staged, local []string
+	for _, p := range paths {
+		_, id, _ := trackedFile(idx, p)
+		committed, inHead := head[p]
+		stagedChanges := !inHead || committed.ID != id
+
+		localChanges := false
+		full := filepath.Join(repo.WorkDir(), filepath.FromSlash(p))
+		if info, err := os.Lstat(full); err == nil && info.Mode().IsRegular() {
+			workID, err := hashFile(repo, conv, hashJob{file: full, path: p}, false)
+			if err != nil {
+				return err
+			}
+			localChanges = workID != id
+		}
+
+		switch {
+		case stagedChanges && localChanges:
+			both = append(both, p)
+		case cached:
+		case stagedChanges:
+			staged = append(staged, p)
+		case localChanges:
+			local = append(local, p)
+		}
+	}
+
+	switch {
+	case len(both) > 0:
+		return fmt.Errorf("the following files have staged content different from both the file and the HEAD: %s (use -f to force removal)", strings.Join(both, ", "))
+	case len(staged) > 0:
+		return fmt.Errorf("the following files have changes staged in the index: %s (use --cached to keep the files, or -f to force removal)", strings.Join(staged, ", "))
+	case len(local) > 0:
+		return fmt.Errorf("the following files have local modifications: %s (use --cached to keep the files, or -f to force removal)", strings.Join(local, ", "))
+	}
+	return nil
+}
+
+// removeEmptyParents removes dir and the directories above it that are
+// left empty, up to the repository root
+func removeEmptyParents(repoPath, dir string) {
+	root := filepath.Clean(repoPath)
+	for dir != root && strings.HasPrefix(dir, root+string(filepath.Separator)) {
+		if os.Remove(dir) != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/index"
+	"github.com/fenilsonani/vcs/internal/core/merge"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// setupTreeRepo creates a repository with main checked out at a commit
+// holding files, which may be in subdirectories
+func setupTreeRepo(t *testing.T, files map[string]string) {
+	repo, _ := setupConfigRepo(t)
+	var entries []merge.Entry
+	for name, content := range files {
+		blob, err := repo.CreateBlob([]byte(content))
+		require.NoError(t, err)
+		entries = append(entries, merge.Entry{Path: name, Mode: objects.ModeBlob, ID: blob.ID()})
+		require.NoError(t, os.MkdirAll(filepath.Dir(name), 0755))
+		require.NoError(t, os.WriteFile(name, []byte(content), 0644))
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	treeID, err := merge.WriteTree(repo, entries)
+	require.NoError(t, err)
+	sig := objects.Signature{Name: "Test", Email: "test@example.com", When: time.Now()}
+	commit, err := repo.CreateCommit(treeID, nil, sig, sig, "base\n")
+	require.NoError(t, err)
+
+	refManager := refs.NewRefManager(repo.GitDir())
+	require.NoError(t, refManager.UpdateRef("refs/heads/main", commit.ID()))
+	require.NoError(t, refManager.SetHEAD("refs/heads/main"))
+}
+
+// shortStatus returns the lines of status -s
+func shortStatus(t *testing.T) []string {
+	out, err := captureStdout(t, func() error {
+		cmd := newStatusCommand()
+		cmd.SetArgs([]string{"-s"})
+		return cmd.Execute()
+	})
+	require.NoError(t, err)
+	return strings.Split(strings.TrimRight(out, "\n"), "\n")
+}
+
+func TestRmCommand(t *testing.T) {
+	setupTreeRepo(t, map[string]string{
+		"a.txt":         "a\n",
+		"b.txt":         "b\n",
+		"keep.txt":      "keep\n",
+		"dir/c.txt":     "c\n",
+		"dir/sub/d.txt": "d\n",
+	})
+
+	out, err := runCommandArgs(newRmCommand(), "a.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "rm 'a.txt'\n", out)
+	assert.NoFileExists(t, "a.txt")
+	assert.Equal(t, []string{"D  a.txt"}, shortStatus(t))
+
+	_, err = runCommandArgs(newRmCommand(), "dir")
+	assert.ErrorContains(t, err, "not removing 'dir' recursively without -r")
+	_, err = runCommandArgs(newRmCommand(), "missing.txt")
+	assert.ErrorContains(t, err, "pathspec 'missing.txt' did not match any files")
+
+	out, err = runCommandArgs(newRmCommand(), "-r", "-n", "dir")
+	require.NoError(t, err)
+	assert.Equal(t, "rm 'dir/c.txt'\nrm 'dir/sub/d.txt'\n", out)
+	assert.FileExists(t, "dir/sub/d.txt")
+	_, err = runCommandArgs(newRmCommand(), "-r", "dir")
+	require.NoError(t, err)
+	assert.NoDirExists(t, "dir")
+
+	// With --cached the file stays, untracked
+	_, err = runCommandArgs(newRmCommand(), "--cached", "b.txt")
+	require.NoError(t, err)
+	assert.FileExists(t, "b.txt")
+	assert.Equal(t, []string{"D  a.txt", "D  b.txt", "?? b.txt", "D  dir/c.txt", "D  dir/sub/d.txt"}, shortStatus(t))
+
+	// Changes that would be lost need -f
+	require.NoError(t, os.WriteFile("keep.txt", []byte("changed\n"), 0644))
+	_, err = runCommandArgs(newRmCommand(), "keep.txt")
+	assert.ErrorContains(t, err, "the following files have local modifications: keep.txt")
+	stageFile(t, "new.txt", "new\n")
+	_, err = runCommandArgs(newRmCommand(), "new.txt")
+	assert.ErrorContains(t, err, "the following files have changes staged in the index: new.txt")
+	_, err = runCommandArgs(newRmCommand(), "--cached", "new.txt")
+	require.NoError(t, err)
+	_, err = runCommandArgs(newRmCommand(), "-f", "keep.txt")
+	require.NoError(t, err)
+	assert.NoFileExists(t, "keep.txt")
+
+	// Adding a removed file again undoes its removal
+	_, err = runCommandArgs(newAddCommand(), "b.txt")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"D  a.txt", "D  dir/c.txt", "D  dir/sub/d.txt", "D  keep.txt", "?? new.txt"}, shortStatus(t))
+
+	// Removed files are simply missing from the index, and are left out of
+	// the next commit
+	idx := index.New()
+	require.NoError(t, idx.ReadFromFile(filepath.Join(".git", "index")))
+	require.Len(t, idx.Entries(), 1)
+	assert.Equal(t, "b.txt", idx.Entries()[0].Path)
+	_, err = runCommandArgs(newCommitCommand(), "-m", "remove")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"?? new.txt"}, shortStatus(t))
+
+	repo, err := vcs.Open(".")
+	require.NoError(t, err)
+	headID, _, err := refs.NewRefManager(repo.GitDir()).HEAD()
+	require.NoError(t, err)
+	head, err := repo.GetCommit(headID)
+	require.NoError(t, err)
+	files, err := merge.ReadTree(repo, head.Tree())
+	require.NoError(t, err)
+	require.Len(t, files, 1)
+	assert.Equal(t, "b.txt", files[0].Path)
+}
+
+func TestMvCommand(t *testing.T) {
+	setupTreeRepo(t, map[string]string{
+		"a.txt":     "a\n",
+		"other.txt": "other\n",
+		"dir/c.txt": "c\n",
+		"dir/d.txt": "d\n",
+	})
+
+	_, err := runCommandArgs(newMvCommand(), "a.txt", "b.txt")
+	require.NoError(t, err)
+	assert.NoFileExists(t, "a.txt")
+	assert.FileExists(t, "b.txt")
+	assert.Equal(t, []string{"R  a.txt -> b.txt"}, shortStatus(t))
+
+	// The rename is kept through changes and further moves, and undone by
+	// moving the file back
+	require.NoError(t, os.WriteFile("b.txt", []byte("changed\n"), 0644))
+	_, err = runCommandArgs(newMvCommand(), "b.txt", "c.txt")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"RM a.txt -> c.txt"}, shortStatus(t))
+	_, err = runCommandArgs(newMvCommand(), "c.txt", "a.txt")
+	require.NoError(t, err)
+	assert.Equal(t, []string{" M a.txt"}, shortStatus(t))
+
+	out, err := runCommandArgs(newMvCommand(), "-v", "dir", "lib")
+	require.NoError(t, err)
+	assert.Equal(t, "Renaming dir to lib\n", out)
+	assert.NoDirExists(t, "dir")
+	_, err = runCommandArgs(newMvCommand(), "other.txt", "lib")
+	require.NoError(t, err)
+	assert.FileExists(t, "lib/other.txt")
+	assert.Equal(t, []string{
+		" M a.txt",
+		"R  dir/c.txt -> lib/c.txt",
+		"R  dir/d.txt -> lib/d.txt",
+		"R  other.txt -> lib/other.txt",
+	}, shortStatus(t))
+
+	_, err = runCommandArgs(newMvCommand(), "missing.txt", "x.txt")
+	assert.ErrorContains(t, err, "bad source, source=missing.txt, destination=x.txt")
+	_, err = runCommandArgs(newMvCommand(), "a.txt", "lib/c.txt")
+	assert.ErrorContains(t, err, "destination exists")
+	require.NoError(t, os.WriteFile("untracked.txt", nil, 0644))
+	_, err = runCommandArgs(newMvCommand(), "untracked.txt", "x.txt")
+	assert.ErrorContains(t, err, "not under version control")
+	_, err = runCommandArgs(newMvCommand(), "a.txt", "other.txt", "x.txt")
+	assert.ErrorContains(t, err, "destination 'x.txt' is not a directory")
+
+	// With -k the sources that cannot be moved are skipped
+	_, err = runCommandArgs(newMvCommand(), "-k", "missing.txt", "a.txt", "lib")
+	require.NoError(t, err)
+	assert.FileExists(t, "lib/a.txt")
+}
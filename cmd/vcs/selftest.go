@@ -0,0 +1,359 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/fenilsonani/vcs/internal/core/fault"
+	"github.com/fenilsonani/vcs/internal/core/index"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+	"github.com/spf13/cobra"
+)
+
+func newSelftestCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "selftest",
+		Short: "Check the robustness of this build",
+	}
+
+	cmd.AddCommand(newSelftestCrashCommand())
+	return cmd
+}
+
+// selftestCrashOptions holds the flags of selftest crash
+type selftestCrashOptions struct {
+	errors bool
+	keep   bool
+}
+
+func newSelftestCrashCommand() *cobra.Command {
+	var opts selftestCrashOptions
+
+	cmd := &cobra.Command{
+		Use:   "crash",
+		Short: "Check that a crash at any write leaves the repository consistent",
+		Long: `Builds a scratch repository by running a scenario of commands (add,
+commit, branch, tag, checkout, merge and gc), and runs each command again
+and again on a copy of the repository as it was, crashing it at its first
+write, fsync or rename of an object, ref or index file, then its second,
+and so on until it completes. After every crash the copy is checked: each
+ref and the index must be readable, every loose object must match its
+name, and every object reachable from HEAD, a ref or the index must exist.
+
+Faults are injected through the ` + fault.EnvVar + ` environment variable,
+which can also be set by hand to make any command fail at random.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runSelftestCrash(cmd.OutOrStdout(), opts)
+		},
+	}
+
+	cmd.Flags().BoolVar(&opts.errors, "errors", false, "Inject write errors instead of crashes")
+	cmd.Flags().BoolVar(&opts.keep, "keep", false, "Keep the scratch directory, with a copy of each inconsistent repository")
+	return cmd
+}
+
+// crashStep is a command of the selftest scenario, run after writing files
+// into the working tree
+type crashStep struct {
+	files map[string]string
+	args  []string
+}
+
+var crashScenario = []crashStep{
+	{files: map[string]string{"README": "hello\n", "main.c": "int main(void) { return 0; }\n"}, args: []string{"add", "README", "main.c"}},
+	{args: []string{"commit", "-m", "Initial commit"}},
+	{args: []string{"branch", "topic"}},
+	{args: []string{"tag", "-a", "-m", "First release", "v1.0"}},
+	{args: []string{"checkout", "topic"}},
+	{files: map[string]string{"util.c": "int util(void) { return 1; }\n"}, args: []string{"add", "util.c"}},
+	{args: []string{"commit", "-m", "Add util"}},
+	{args: []string{"checkout", "main"}},
+	{files: map[string]string{"NEWS": "First release\n"}, args: []string{"add", "NEWS"}},
+	{args: []string{"commit", "-m", "Add news"}},
+	{args: []string{"merge", "--ff", "no", "-m", "Merge topic", "topic"}},
+	{args: []string{"branch", "-d", "topic"}},
+	{args: []string{"gc", "--prune", "now"}},
+}
+
+func runSelftestCrash(out io.Writer, opts selftestCrashOptions) error {
+	exe, err := os.Executable()
+	if err != nil {
+		return fmt.Errorf("failed to find the vcs executable: %w", err)
+	}
+	dir, err := os.MkdirTemp("", "vcs-selftest-")
+	if err != nil {
+		return fmt.Errorf("failed to create scratch directory: %w", err)
+	}
+	if opts.keep {
+		fmt.Fprintf(out, "Scratch directory: %s\n", dir)
+	} else {
+		defer os.RemoveAll(dir)
+	}
+
+	base := filepath.Join(dir, "base")
+	if _, err := vcs.Init(base); err != nil {
+		return fmt.Errorf("failed to create scratch repository: %w", err)
+	}
+	// The scenario must not depend on the user's config or inherit faults
+	var env []string
+	for _, kv := range os.Environ() {
+		if !strings.HasPrefix(kv, fault.EnvVar+"=") {
+			env = append(env, kv)
+		}
+	}
+	env = append(env, "GIT_CONFIG_GLOBAL="+filepath.Join(dir, "gitconfig"), "GIT_CONFIG_NOSYSTEM=1")
+
+	mode := "crash"
+	if opts.errors {
+		mode = "error"
+	}
+	total, inconsistent := 0, 0
+	for i, step := range crashScenario {
+		for name, content := range step.files {
+			if err := os.WriteFile(filepath.Join(base, name), []byte(content), 0644); err != nil {
+				return err
+			}
+		}
+
+		faults, failed := 0, 0
+		for n := 1; ; n++ {
+			trial := filepath.Join(dir, "trial")
+			if err := os.RemoveAll(trial); err != nil {
+				return err
+			}
+			if err := copyDir(base, trial); err != nil {
+				return fmt.Errorf("failed to copy scratch repository: %w", err)
+			}
+
+			spec := fmt.Sprintf("nth=%d", n)
+			if !opts.errors {
+				spec += ",crash"
+			}
+			hit, _, err := runSelftestStep(exe, trial, append(env, fault.EnvVar+"="+spec), step.args)
+			if err != nil {
+				return err
+			}
+			if !hit {
+				break
+			}
+			faults++
+
+			if problems := checkRepository(trial); len(problems) > 0 {
+				failed++
+				fmt.Fprintf(out, "     vcs %s: inconsistent after %s at operation %d\n", strings.Join(step.args, " "), mode, n)
+				for _, problem := range problems {
+					fmt.Fprintf(out, "       %s\n", problem)
+				}
+				if opts.keep {
+					os.Rename(trial, filepath.Join(dir, fmt.Sprintf("failed-%d-%d", i+1, n)))
+				}
+			}
+		}
+		total += faults
+		inconsistent += failed
+
+		// Move on from the state the command leaves when it completes
+		if _, stderr, err := runSelftestStep(exe, base, env, step.args); err != nil {
+			return fmt.Errorf("vcs %s failed without faults: %w\n%s", strings.Join(step.args, " "), err, stderr)
+		}
+		status := "ok  "
+		if failed > 0 {
+			status = "FAIL"
+		}
+		fmt.Fprintf(out, "%s vcs %s (%d faults)\n", status, strings.Join(step.args, " "), faults)
+	}
+
+	if inconsistent > 0 {
+		return fmt.Errorf("%d of %d injected faults left the repository inconsistent", inconsistent, total)
+	}
+	fmt.Fprintf(out, "Repository consistent after each of %d injected faults\n", total)
+	return nil
+}
+
+// runSelftestStep runs vcs with args in dir and reports whether it reached
+// an injected fault. An exit status other than zero is returned as an error
+// along with the command's stderr.
+func runSelftestStep(exe, dir string, env, args []string) (bool, string, error) {
+	cmd := exec.Command(exe, args...)
+	cmd.Dir = dir
+	cmd.Env = env
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	if _, ok := err.(*exec.ExitError); err != nil && !ok {
+		return false, "", fmt.Errorf("failed to run vcs: %w", err)
+	}
+
+	hit := false
+	scanner := bufio.NewScanner(bytes.NewReader(stderr.Bytes()))
+	for scanner.Scan() {
+		hit = hit || strings.HasPrefix(scanner.Text(), "fault: ")
+	}
+	if hit {
+		return true, stderr.String(), nil
+	}
+	return false, stderr.String(), err
+}
+
+// copyDir copies the files under src to dst
+func copyDir(src, dst string) error {
+	return filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dst, rel)
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return os.MkdirAll(target, info.Mode().Perm()|0700)
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, data, info.Mode().Perm())
+	})
+}
+
+// checkRepository returns the inconsistencies in the repository at path:
+// corrupt loose objects, refs, HEAD or index that cannot be read or name
+// missing objects, and objects missing from the history they lead to
+func checkRepository(path string) []string {
+	// Not openRepository, whose object cache outlives the files checked
+	repo, err := vcs.Open(path)
+	if err != nil {
+		return []string{fmt.Sprintf("cannot open repository: %v", err)}
+	}
+	var problems []string
+	report := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+
+	ids, err := repo.Storage().LooseObjects()
+	if err != nil {
+		report("cannot list objects: %v", err)
+	}
+	for _, id := range ids {
+		objType, data, err := repo.ReadRawObject(id)
+		if err != nil {
+			report("object %s is corrupt: %v", id, err)
+		} else if objects.ComputeHash(objType, data) != id {
+			report("object %s does not match its content", id)
+		}
+	}
+
+	// Refs, HEAD and the index are the roots of history
+	var roots []objects.ObjectID
+	checkRef := func(name, value string) {
+		value = strings.TrimSpace(value)
+		if strings.HasPrefix(value, "ref: ") {
+			return
+		}
+		id, err := objects.NewObjectID(value)
+		if err != nil {
+			report("ref %s is corrupt: %q", name, value)
+			return
+		}
+		roots = append(roots, id)
+	}
+
+	refsDir := filepath.Join(repo.CommonDir(), "refs")
+	err = filepath.WalkDir(refsDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || strings.HasSuffix(path, ".lock") {
+			return err
+		}
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(repo.CommonDir(), path)
+		checkRef(filepath.ToSlash(rel), string(data))
+		return nil
+	})
+	if err != nil {
+		report("cannot read refs: %v", err)
+	}
+
+	if data, err := os.ReadFile(filepath.Join(repo.CommonDir(), "packed-refs")); err == nil {
+		for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+			if strings.HasPrefix(line, "#") {
+				continue
+			}
+			value, name, ok := strings.Cut(strings.TrimPrefix(line, "^"), " ")
+			if !ok {
+				report("packed-refs line is corrupt: %q", line)
+				continue
+			}
+			checkRef(name, value)
+		}
+	} else if !os.IsNotExist(err) {
+		report("cannot read packed-refs: %v", err)
+	}
+
+	if data, err := os.ReadFile(filepath.Join(repo.GitDir(), "HEAD")); err == nil {
+		checkRef("HEAD", string(data))
+	} else {
+		report("cannot read HEAD: %v", err)
+	}
+
+	indexPath := filepath.Join(repo.GitDir(), "index")
+	if _, err := os.Stat(indexPath); err == nil {
+		idx := index.New()
+		if err := idx.ReadFromFile(indexPath); err != nil {
+			report("index is corrupt: %v", err)
+		}
+		for _, entry := range idx.Entries() {
+			if entry.Mode != objects.ModeCommit {
+				roots = append(roots, entry.ID)
+			}
+		}
+	}
+
+	// Everything reachable from the roots must be present
+	seen := make(map[objects.ObjectID]bool)
+	stack := roots
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		obj, err := repo.ReadObject(id)
+		if err != nil {
+			report("object %s is missing or unreadable: %v", id, err)
+			continue
+		}
+		switch o := obj.(type) {
+		case *objects.Commit:
+			stack = append(stack, o.Tree())
+			stack = append(stack, o.Parents()...)
+		case *objects.Tree:
+			for _, entry := range o.Entries() {
+				if entry.Mode != objects.ModeCommit {
+					stack = append(stack, entry.ID)
+				}
+			}
+		case *objects.Tag:
+			stack = append(stack, o.Object())
+		}
+	}
+
+	return problems
+}
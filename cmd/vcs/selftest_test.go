@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/fault"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+)
+
+func TestCheckRepository(t *testing.T) {
+	repo, _ := setupConfigRepo(t)
+	_, err := stageAndCommit(t, repo, "a.txt", "-m", "first")
+	require.NoError(t, err)
+	assert.Empty(t, checkRepository(repo.WorkDir()))
+
+	head, _, err := refs.NewRefManager(repo.GitDir()).HEAD()
+	require.NoError(t, err)
+	commit, err := repo.GetCommit(head)
+	require.NoError(t, err)
+
+	// A torn ref, and a tree that was never written
+	require.NoError(t, os.WriteFile(filepath.Join(repo.GitDir(), "refs", "heads", "torn"), []byte(head.String()[:20]), 0644))
+	treePath := filepath.Join(repo.GitDir(), "objects", commit.Tree().String()[:2], commit.Tree().String()[2:])
+	require.NoError(t, os.Remove(treePath))
+
+	problems := checkRepository(repo.WorkDir())
+	assert.Contains(t, problems, `ref refs/heads/torn is corrupt: "`+head.String()[:20]+`"`)
+	require.Len(t, problems, 2)
+	assert.Contains(t, problems[1], "object "+commit.Tree().String()+" is missing")
+}
+
+// TestCommitFaults fails each write, fsync and rename of a commit in turn
+// and checks the repository it leaves behind
+func TestCommitFaults(t *testing.T) {
+	repo, _ := setupConfigRepo(t)
+	_, err := stageAndCommit(t, repo, "a.txt", "-m", "first")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile("b.txt", []byte("b\n"), 0644))
+	add := newAddCommand()
+	add.SetArgs([]string{"b.txt"})
+	require.NoError(t, add.Execute())
+
+	base := repo.WorkDir()
+	for n := 1; ; n++ {
+		trial := filepath.Join(t.TempDir(), "trial")
+		require.NoError(t, copyDir(base, trial))
+		require.NoError(t, os.Chdir(trial))
+
+		fault.Enable(fault.Config{Nth: n})
+		cmd := newCommitCommand()
+		cmd.SetErr(&bytes.Buffer{})
+		cmd.SetArgs([]string{"-m", "second"})
+		_, err := captureStdout(t, cmd.Execute)
+		count := fault.Count()
+		fault.Disable()
+
+		if count < n {
+			require.NoError(t, err)
+			require.Greater(t, n, 1, "commit writes nothing")
+			break
+		}
+		var injected *fault.Error
+		assert.ErrorAs(t, err, &injected, "fault %d", n)
+		assert.Empty(t, checkRepository(trial), "fault %d", n)
+
+		// Whatever was interrupted, main is either unchanged or the new commit
+		head, _, err := refs.NewRefManager(filepath.Join(trial, ".git")).HEAD()
+		require.NoError(t, err)
+		obj, err := repo.ReadObject(head)
+		if err == nil {
+			assert.Contains(t, []string{"first\n", "second\n"}, obj.(*objects.Commit).Message())
+		}
+	}
+}
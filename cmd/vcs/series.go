@@ -0,0 +1,678 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/index"
+	"github.com/fenilsonani/vcs/internal/core/merge"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// errSeriesConflict is returned when pushing a patch stops on a conflict
+var errSeriesConflict = errors.New(`push stopped on a conflict; resolve it and run "vcs series refresh"`)
+
+func newSeriesCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "series",
+		Short: "Manage a stack of patches on the current branch",
+		Long: `Keeps the commits on top of the current branch as a stack of named
+patches, one commit each, that can be taken off the branch and put back
+and amended in place, for stacked-diff workflows.
+
+"vcs series create" records the staged changes as a new patch on top of
+the stack, and "vcs series refresh" amends the top patch with them.
+"vcs series pop" takes patches off the branch, keeping them in the
+series, and "vcs series push" applies them again on top of whatever the
+branch then holds, as cherry-pick does. When a patch does not apply
+cleanly, push stops with the conflicts in the working tree; resolve
+them, mark them with "vcs add" and run "vcs series refresh", or run
+"vcs series pop" to leave the patch unapplied. While no patch is
+applied the branch may move, say by a pull, and the patches are then
+pushed onto its new tip.
+
+Without a subcommand the patches are listed, bottom first: "+" marks an
+applied patch, ">" the top one and "-" one that is not applied. Each
+patch is also kept as refs/patches/<branch>/<patch>, and the series
+itself in .git/series/<branch>.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runSeriesList(cmd.OutOrStdout())
+		},
+	}
+
+	cmd.AddCommand(
+		newSeriesCreateCommand(),
+		newSeriesRefreshCommand(),
+		newSeriesPushCommand(),
+		newSeriesPopCommand(),
+	)
+	return cmd
+}
+
+func newSeriesCreateCommand() *cobra.Command {
+	var message string
+
+	cmd := &cobra.Command{
+		Use:   "create [flags] <patch>",
+		Short: "Record the staged changes as a new patch on top of the stack",
+		Long: `Commits the staged changes, if any, as a new patch named <patch> on top
+of the applied patches. The first patch starts the series at the
+current tip of the branch. The message defaults to the patch name.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withSeries(func(repo *vcs.Repository, refManager *refs.RefManager, state *seriesState) error {
+				return createPatch(cmd.OutOrStdout(), repo, refManager, state, args[0], message)
+			})
+		},
+	}
+
+	cmd.Flags().StringVarP(&message, "message", "m", "", "Commit message of the patch")
+	return cmd
+}
+
+func newSeriesRefreshCommand() *cobra.Command {
+	var message string
+
+	cmd := &cobra.Command{
+		Use:   "refresh [flags]",
+		Short: "Amend the top patch with the staged changes",
+		Long: `Amends the top patch with the staged changes, or with a new message given
+with -m. After a push stopped on a conflict, records the resolved
+result as the pushed patch.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withSeries(func(repo *vcs.Repository, refManager *refs.RefManager, state *seriesState) error {
+				return refreshPatch(cmd.OutOrStdout(), repo, refManager, state, message)
+			})
+		},
+	}
+
+	cmd.Flags().StringVarP(&message, "message", "m", "", "New commit message of the patch")
+	return cmd
+}
+
+func newSeriesPushCommand() *cobra.Command {
+	var all bool
+
+	cmd := &cobra.Command{
+		Use:   "push [flags]",
+		Short: "Apply the next unapplied patch",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cmd.SilenceUsage = true
+			return withSeries(func(repo *vcs.Repository, refManager *refs.RefManager, state *seriesState) error {
+				return pushPatches(cmd.OutOrStdout(), repo, refManager, state, all)
+			})
+		},
+	}
+
+	cmd.Flags().BoolVarP(&all, "all", "a", false, "Apply all unapplied patches")
+	return cmd
+}
+
+func newSeriesPopCommand() *cobra.Command {
+	var all bool
+
+	cmd := &cobra.Command{
+		Use:   "pop [flags]",
+		Short: "Take the top patch off the branch",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return withSeries(func(repo *vcs.Repository, refManager *refs.RefManager, state *seriesState) error {
+				return popPatches(cmd.OutOrStdout(), repo, refManager, state, all)
+			})
+		},
+	}
+
+	cmd.Flags().BoolVarP(&all, "all", "a", false, "Take all applied patches off the branch")
+	return cmd
+}
+
+// seriesPatch is one patch of a series
+type seriesPatch struct {
+	name    string
+	commit  objects.ObjectID
+	applied bool
+}
+
+// seriesState is the series of a branch, kept in .git/series/<branch>.
+// Applied patches come first, bottom of the stack first, and are the
+// commits on top of base on the branch.
+type seriesState struct {
+	dir string
+	// branch is the short name of the branch the series is on
+	branch  string
+	base    objects.ObjectID
+	patches []*seriesPatch
+	// pushing is the applied patch whose push stopped on a conflict, ""
+	// when there is none
+	pushing string
+}
+
+func seriesDir(repo *vcs.Repository, branch string) string {
+	return filepath.Join(repo.GitDir(), "series", filepath.FromSlash(branch))
+}
+
+// loadSeriesState reads the series of branch, which is empty when there
+// is none yet
+func loadSeriesState(repo *vcs.Repository, branch string) (*seriesState, error) {
+	state := &seriesState{dir: seriesDir(repo, branch), branch: branch}
+	if !fileExists(state.dir) {
+		return state, nil
+	}
+
+	read := func(name string) (string, error) {
+		data, err := os.ReadFile(filepath.Join(state.dir, name))
+		if err != nil {
+			return "", fmt.Errorf("failed to read series state: %w", err)
+		}
+		return strings.TrimSpace(string(data)), nil
+	}
+
+	base, err := read("base")
+	if err != nil {
+		return nil, err
+	}
+	if state.base, err = objects.NewObjectID(base); err != nil {
+		return nil, fmt.Errorf("invalid series base: %w", err)
+	}
+
+	patches, err := read("patches")
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(patches, "\n") {
+		if line == "" {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) != 3 || (fields[0] != "applied" && fields[0] != "unapplied") {
+			return nil, fmt.Errorf("invalid series patch line: %q", line)
+		}
+		id, err := objects.NewObjectID(fields[1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid series patch line: %q", line)
+		}
+		state.patches = append(state.patches, &seriesPatch{name: fields[2], commit: id, applied: fields[0] == "applied"})
+	}
+
+	if fileExists(filepath.Join(state.dir, "pushing")) {
+		if state.pushing, err = read("pushing"); err != nil {
+			return nil, err
+		}
+	}
+	return state, nil
+}
+
+// save writes the series to .git/series/<branch> and points the ref of
+// each patch at its commit
+func (s *seriesState) save(refManager *refs.RefManager) error {
+	if err := os.MkdirAll(s.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create series state: %w", err)
+	}
+
+	var patches strings.Builder
+	for _, patch := range s.patches {
+		status := "unapplied"
+		if patch.applied {
+			status = "applied"
+		}
+		fmt.Fprintf(&patches, "%s %s %s\n", status, patch.commit, patch.name)
+		if err := refManager.WriteRef(s.patchRef(patch.name), patch.commit, nil); err != nil {
+			return fmt.Errorf("failed to update patch %s: %w", patch.name, err)
+		}
+	}
+
+	files := map[string]string{
+		"base":    s.base.String() + "\n",
+		"patches": patches.String(),
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(s.dir, name), []byte(content), 0644); err != nil {
+			return fmt.Errorf("failed to write series state: %w", err)
+		}
+	}
+
+	pushing := filepath.Join(s.dir, "pushing")
+	if s.pushing == "" {
+		if err := os.Remove(pushing); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to write series state: %w", err)
+		}
+		return nil
+	}
+	if err := os.WriteFile(pushing, []byte(s.pushing+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write series state: %w", err)
+	}
+	return nil
+}
+
+// patchRef returns the ref kept for the patch called name
+func (s *seriesState) patchRef(name string) string {
+	return "refs/patches/" + s.branch + "/" + name
+}
+
+// applied returns the applied patches, bottom of the stack first
+func (s *seriesState) applied() []*seriesPatch {
+	var applied []*seriesPatch
+	for _, patch := range s.patches {
+		if patch.applied {
+			applied = append(applied, patch)
+		}
+	}
+	return applied
+}
+
+// tip returns the commit the branch is expected at: the top applied patch
+// or the base, leaving out a patch whose push has not been concluded
+func (s *seriesState) tip() objects.ObjectID {
+	tip := s.base
+	for _, patch := range s.applied() {
+		if patch.name != s.pushing {
+			tip = patch.commit
+		}
+	}
+	return tip
+}
+
+func (s *seriesState) find(name string) *seriesPatch {
+	for _, patch := range s.patches {
+		if patch.name == name {
+			return patch
+		}
+	}
+	return nil
+}
+
+// withSeries runs fn with the series of the current branch, once the
+// branch is checked to be where the series left it
+func withSeries(fn func(repo *vcs.Repository, refManager *refs.RefManager, state *seriesState) error) error {
+	repoPath, err := findRepository()
+	if err != nil {
+		return fmt.Errorf("not a git repository: %w", err)
+	}
+	repo, err := openRepository(repoPath)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+	refManager := refs.NewRefManager(repo.GitDir())
+
+	headID, branchRef, err := refManager.HEAD()
+	if err != nil {
+		return fmt.Errorf("failed to read HEAD: %w", err)
+	}
+	if branchRef == "" {
+		return fmt.Errorf("HEAD is detached; a series needs a branch")
+	}
+	if headID.IsZero() {
+		return fmt.Errorf("branch %s has no commits to start a series on", strings.TrimPrefix(branchRef, "refs/heads/"))
+	}
+
+	state, err := loadSeriesState(repo, strings.TrimPrefix(branchRef, "refs/heads/"))
+	if err != nil {
+		return err
+	}
+	// With no patches applied the series moves along with the branch, so
+	// it can be updated before pushing the patches again
+	if len(state.applied()) == 0 {
+		state.base = headID
+	} else if tip := state.tip(); tip != headID {
+		return fmt.Errorf("branch %s has moved since the series last changed it; expected it at %s", state.branch, tip.Short())
+	}
+	return fn(repo, refManager, state)
+}
+
+// runSeriesList prints the patches of the series of the current branch
+func runSeriesList(out io.Writer) error {
+	return withSeries(func(repo *vcs.Repository, refManager *refs.RefManager, state *seriesState) error {
+		applied := state.applied()
+		for _, patch := range state.patches {
+			mark := "-"
+			if patch.applied {
+				mark = "+"
+				if patch == applied[len(applied)-1] {
+					mark = ">"
+				}
+			}
+			fmt.Fprintf(out, "%s %s\n", mark, patch.name)
+		}
+		return nil
+	})
+}
+
+// readSeriesIndex reads the index, which must have no unresolved conflicts
+func readSeriesIndex(repo *vcs.Repository) (*index.Index, error) {
+	idx, err := readIndex(repo)
+	if err != nil {
+		return nil, err
+	}
+	if unmerged := idx.Unmerged(); len(unmerged) > 0 {
+		return nil, fmt.Errorf("you must edit all merge conflicts and then mark them as resolved using vcs add: %s", strings.Join(unmerged, ", "))
+	}
+	return idx, nil
+}
+
+// updateSeriesBranch points the branch of the series at id
+func updateSeriesBranch(refManager *refs.RefManager, state *seriesState, oldID, id objects.ObjectID, action string) error {
+	branchRef := "refs/heads/" + state.branch
+	if err := refManager.UpdateRef(branchRef, id); err != nil {
+		return fmt.Errorf("failed to update branch %s: %w", state.branch, err)
+	}
+	logRefUpdate(refManager, branchRef, oldID, id, "series "+action)
+	return nil
+}
+
+// printSeriesTop reports the patch now on top of the stack
+func printSeriesTop(out io.Writer, state *seriesState) {
+	applied := state.applied()
+	if len(applied) == 0 {
+		fmt.Fprintf(out, "No patches applied\n")
+		return
+	}
+	fmt.Fprintf(out, "Now at patch %s\n", applied[len(applied)-1].name)
+}
+
+// createPatch commits the staged changes as patch name on top of the
+// applied patches
+func createPatch(out io.Writer, repo *vcs.Repository, refManager *refs.RefManager, state *seriesState, name, message string) error {
+	if state.pushing != "" {
+		return fmt.Errorf(`the push of patch %s has not been concluded; use "vcs series refresh" or "vcs series pop"`, state.pushing)
+	}
+	if strings.Contains(name, "/") || !refManager.IsValidRef(name) {
+		return fmt.Errorf("invalid patch name: %s", name)
+	}
+	if state.find(name) != nil {
+		return fmt.Errorf("patch %s already exists", name)
+	}
+
+	idx, err := readSeriesIndex(repo)
+	if err != nil {
+		return err
+	}
+	headID := state.tip()
+	treeID, err := writeIndexTree(repo, idx)
+	if err != nil {
+		return err
+	}
+
+	if message == "" {
+		message = name
+	}
+	if !strings.HasSuffix(message, "\n") {
+		message += "\n"
+	}
+	author, err := getSignature("")
+	if err != nil {
+		return err
+	}
+	commit, err := repo.CreateCommit(treeID, []objects.ObjectID{headID}, author, author, message)
+	if err != nil {
+		return fmt.Errorf("failed to create commit: %w", err)
+	}
+	if err := updateSeriesBranch(refManager, state, headID, commit.ID(), "create: "+name); err != nil {
+		return err
+	}
+
+	// The new patch goes right above the applied ones
+	patch := &seriesPatch{name: name, commit: commit.ID(), applied: true}
+	at := len(state.applied())
+	state.patches = append(state.patches[:at], append([]*seriesPatch{patch}, state.patches[at:]...)...)
+	if err := state.save(refManager); err != nil {
+		return err
+	}
+	if err := resetIndexToHead(repo); err != nil {
+		return err
+	}
+
+	printSeriesTop(out, state)
+	return nil
+}
+
+// refreshPatch amends the top patch with the staged changes or message,
+// or concludes the push of a patch that stopped on a conflict
+func refreshPatch(out io.Writer, repo *vcs.Repository, refManager *refs.RefManager, state *seriesState, message string) error {
+	applied := state.applied()
+	if len(applied) == 0 {
+		return fmt.Errorf("no patches applied")
+	}
+	top := applied[len(applied)-1]
+
+	idx, err := readSeriesIndex(repo)
+	if err != nil {
+		return err
+	}
+	patchCommit, err := repo.GetCommit(top.commit)
+	if err != nil {
+		return fmt.Errorf("failed to read patch %s: %w", top.name, err)
+	}
+
+	headID := state.tip()
+	var treeID objects.ObjectID
+	var parents []objects.ObjectID
+	if state.pushing != "" {
+		if treeID, err = writeIndexTree(repo, idx); err != nil {
+			return err
+		}
+		parents = []objects.ObjectID{headID}
+	} else {
+		if treeID, err = writeIndexTree(repo, idx); err != nil {
+			return err
+		}
+		if treeID == patchCommit.Tree() && message == "" {
+			return fmt.Errorf("nothing to refresh; stage changes with vcs add or give a new message with -m")
+		}
+		parents = patchCommit.Parents()
+	}
+
+	if message == "" {
+		message = patchCommit.Message()
+	} else if !strings.HasSuffix(message, "\n") {
+		message += "\n"
+	}
+	committer, err := getSignature("")
+	if err != nil {
+		return err
+	}
+	commit, err := repo.CreateCommit(treeID, parents, patchCommit.Author(), committer, message)
+	if err != nil {
+		return fmt.Errorf("failed to create commit: %w", err)
+	}
+	if err := updateSeriesBranch(refManager, state, headID, commit.ID(), "refresh: "+top.name); err != nil {
+		return err
+	}
+
+	top.commit = commit.ID()
+	state.pushing = ""
+	if err := state.save(refManager); err != nil {
+		return err
+	}
+	if err := resetIndexToHead(repo); err != nil {
+		return err
+	}
+
+	fmt.Fprintf(out, "Refreshed patch %s\n", top.name)
+	return nil
+}
+
+// pushPatches applies the next unapplied patch, or all of them, on top of
+// the branch
+func pushPatches(out io.Writer, repo *vcs.Repository, refManager *refs.RefManager, state *seriesState, all bool) error {
+	if state.pushing != "" {
+		return fmt.Errorf(`the push of patch %s has not been concluded; use "vcs series refresh" or "vcs series pop"`, state.pushing)
+	}
+	if dirty, err := hasUncommittedChanges(repo, refManager); err != nil {
+		return err
+	} else if dirty {
+		return fmt.Errorf("cannot push: your index contains uncommitted changes")
+	}
+
+	pushed := 0
+	for _, patch := range state.patches {
+		if patch.applied {
+			continue
+		}
+		if pushed > 0 && !all {
+			break
+		}
+		if err := pushPatch(out, repo, refManager, state, patch); err != nil {
+			return err
+		}
+		pushed++
+	}
+	if pushed == 0 {
+		return fmt.Errorf("no patches to push")
+	}
+
+	printSeriesTop(out, state)
+	return nil
+}
+
+// pushPatch applies patch on top of the branch, recording it as a new
+// commit unless the branch is still where the patch was made
+func pushPatch(out io.Writer, repo *vcs.Repository, refManager *refs.RefManager, state *seriesState, patch *seriesPatch) error {
+	headID := state.tip()
+	commit, err := repo.GetCommit(patch.commit)
+	if err != nil {
+		return fmt.Errorf("failed to read patch %s: %w", patch.name, err)
+	}
+	head, err := repo.GetCommit(headID)
+	if err != nil {
+		return fmt.Errorf("failed to read HEAD commit: %w", err)
+	}
+
+	newID := patch.commit
+	if parents := commit.Parents(); len(parents) != 1 || parents[0] != headID {
+		var baseTree objects.ObjectID
+		if len(parents) > 0 {
+			parent, err := repo.GetCommit(parents[0])
+			if err != nil {
+				return fmt.Errorf("failed to read parent of patch %s: %w", patch.name, err)
+			}
+			baseTree = parent.Tree()
+		}
+
+		label := "patch " + patch.name
+		result, err := merge.Trees(repo, baseTree, head.Tree(), commit.Tree(), merge.Options{
+			OursLabel:   "HEAD",
+			TheirsLabel: label,
+		})
+		if err != nil {
+			return fmt.Errorf("failed to apply patch %s: %w", patch.name, err)
+		}
+		if err := checkoutMergeResult(repo, head.Tree(), result); err != nil {
+			return fmt.Errorf("failed to update working directory: %w", err)
+		}
+
+		if !result.Clean() {
+			if err := writeMergeIndex(repo, result); err != nil {
+				return err
+			}
+			patch.applied = true
+			state.pushing = patch.name
+			if err := state.save(refManager); err != nil {
+				return err
+			}
+
+			printMergeConflicts(out, result.Conflicts, label)
+			fmt.Fprintf(out, "error: could not push patch %s\n", patch.name)
+			fmt.Fprintf(out, "hint: After resolving the conflicts, mark them with\n")
+			fmt.Fprintf(out, "hint: \"vcs add <paths>\", then run \"vcs series refresh\".\n")
+			fmt.Fprintf(out, "hint: To leave the patch unapplied, run \"vcs series pop\".\n")
+			return errSeriesConflict
+		}
+
+		treeID, err := merge.WriteTree(repo, result.Entries)
+		if err != nil {
+			return fmt.Errorf("failed to write tree: %w", err)
+		}
+		committer, err := getSignature("")
+		if err != nil {
+			return err
+		}
+		pushedCommit, err := repo.CreateCommit(treeID, []objects.ObjectID{headID}, commit.Author(), committer, commit.Message())
+		if err != nil {
+			return fmt.Errorf("failed to create commit: %w", err)
+		}
+		newID = pushedCommit.ID()
+		if err := resetIndexToHead(repo); err != nil {
+			return err
+		}
+	} else if err := checkoutTree(repo, headID, newID); err != nil {
+		return err
+	}
+
+	if err := updateSeriesBranch(refManager, state, headID, newID, "push: "+patch.name); err != nil {
+		return err
+	}
+	patch.commit = newID
+	patch.applied = true
+	if err := state.save(refManager); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "Pushed patch %s\n", patch.name)
+	return nil
+}
+
+// popPatches takes the top patch, or all applied ones, off the branch. A
+// push stopped on a conflict is undone instead.
+func popPatches(out io.Writer, repo *vcs.Repository, refManager *refs.RefManager, state *seriesState, all bool) error {
+	applied := state.applied()
+	if len(applied) == 0 {
+		return fmt.Errorf("no patches applied")
+	}
+
+	if state.pushing != "" {
+		if err := restoreWorkingTree(repo, state.tip()); err != nil {
+			return err
+		}
+		applied[len(applied)-1].applied = false
+		fmt.Fprintf(out, "Popped patch %s\n", state.pushing)
+		state.pushing = ""
+		applied = applied[:len(applied)-1]
+		if !all || len(applied) == 0 {
+			if err := state.save(refManager); err != nil {
+				return err
+			}
+			printSeriesTop(out, state)
+			return nil
+		}
+	} else if dirty, err := hasUncommittedChanges(repo, refManager); err != nil {
+		return err
+	} else if dirty {
+		return fmt.Errorf("cannot pop: your index contains uncommitted changes")
+	}
+
+	keep := len(applied) - 1
+	if all {
+		keep = 0
+	}
+	headID := state.tip()
+	newID := state.base
+	if keep > 0 {
+		newID = applied[keep-1].commit
+	}
+	if err := checkoutTree(repo, headID, newID); err != nil {
+		return err
+	}
+	if err := updateSeriesBranch(refManager, state, headID, newID, "pop"); err != nil {
+		return err
+	}
+
+	for i := len(applied) - 1; i >= keep; i-- {
+		applied[i].applied = false
+		fmt.Fprintf(out, "Popped patch %s\n", applied[i].name)
+	}
+	if err := state.save(refManager); err != nil {
+		return err
+	}
+	printSeriesTop(out, state)
+	return nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/merge"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// setupSeriesRepo creates a repository with main checked out at a commit
+// holding files
+func setupSeriesRepo(t *testing.T, files map[string]string) (*vcs.Repository, *refs.RefManager) {
+	repo, _ := setupConfigRepo(t)
+	refManager := refs.NewRefManager(repo.GitDir())
+	require.NoError(t, refManager.UpdateRef("refs/heads/main", commitFiles(t, repo, files, nil, "base\n")))
+	require.NoError(t, refManager.SetHEAD("refs/heads/main"))
+	for name, content := range files {
+		require.NoError(t, os.WriteFile(name, []byte(content), 0644))
+	}
+	return repo, refManager
+}
+
+// stageFile writes content to name and adds it to the index
+func stageFile(t *testing.T, name, content string) {
+	require.NoError(t, os.WriteFile(name, []byte(content), 0644))
+	_, err := runCommandArgs(newAddCommand(), name)
+	require.NoError(t, err)
+}
+
+// moveMain commits files on top of main outside the series and checks the
+// result out
+func moveMain(t *testing.T, repo *vcs.Repository, refManager *refs.RefManager, files map[string]string) objects.ObjectID {
+	old, err := refManager.ResolveRef("refs/heads/main")
+	require.NoError(t, err)
+	id := commitFiles(t, repo, files, []objects.ObjectID{old}, "upstream\n")
+	require.NoError(t, checkoutTree(repo, old, id))
+	require.NoError(t, refManager.UpdateRef("refs/heads/main", id))
+	return id
+}
+
+// patchFiles returns the files of the commit of patch name on main
+func patchFiles(t *testing.T, repo *vcs.Repository, refManager *refs.RefManager, name string) (*objects.Commit, map[string]string) {
+	id, err := refManager.ResolveRef("refs/patches/main/" + name)
+	require.NoError(t, err)
+	commit, err := repo.GetCommit(id)
+	require.NoError(t, err)
+	entries, err := merge.ReadTree(repo, commit.Tree())
+	require.NoError(t, err)
+	files := make(map[string]string)
+	for _, entry := range entries {
+		blob, err := repo.GetBlob(entry.ID)
+		require.NoError(t, err)
+		files[entry.Path] = string(blob.Data())
+	}
+	return commit, files
+}
+
+func TestSeriesStack(t *testing.T) {
+	repo, refManager := setupSeriesRepo(t, map[string]string{"a.txt": "a\n"})
+
+	stageFile(t, "one.txt", "one\n")
+	out, err := runCommandArgs(newSeriesCommand(), "create", "one", "-m", "Add one")
+	require.NoError(t, err)
+	assert.Equal(t, "Now at patch one\n", out)
+	stageFile(t, "two.txt", "two\n")
+	_, err = runCommandArgs(newSeriesCommand(), "create", "two")
+	require.NoError(t, err)
+
+	out, err = runCommandArgs(newSeriesCommand())
+	require.NoError(t, err)
+	assert.Equal(t, "+ one\n> two\n", out)
+	one, files := patchFiles(t, repo, refManager, "one")
+	assert.Equal(t, "Add one\n", one.Message())
+	assert.Equal(t, map[string]string{"a.txt": "a\n", "one.txt": "one\n"}, files)
+
+	_, err = runCommandArgs(newSeriesCommand(), "create", "one")
+	assert.ErrorContains(t, err, "patch one already exists")
+
+	// Popping takes the patch off the branch and out of the working tree
+	out, err = runCommandArgs(newSeriesCommand(), "pop")
+	require.NoError(t, err)
+	assert.Equal(t, "Popped patch two\nNow at patch one\n", out)
+	assert.NoFileExists(t, "two.txt")
+	head, _, err := refManager.HEAD()
+	require.NoError(t, err)
+	assert.Equal(t, one.ID(), head)
+	out, err = runCommandArgs(newSeriesCommand())
+	require.NoError(t, err)
+	assert.Equal(t, "> one\n- two\n", out)
+
+	// With nothing applied the branch may move, and the patches are pushed
+	// onto its new tip
+	_, err = runCommandArgs(newSeriesCommand(), "pop", "--all")
+	require.NoError(t, err)
+	upstream := moveMain(t, repo, refManager, map[string]string{"a.txt": "a\n", "b.txt": "b\n"})
+
+	out, err = runCommandArgs(newSeriesCommand(), "push", "--all")
+	require.NoError(t, err)
+	assert.Equal(t, "Pushed patch one\nPushed patch two\nNow at patch two\n", out)
+	assert.FileExists(t, "two.txt")
+
+	one, files = patchFiles(t, repo, refManager, "one")
+	assert.Equal(t, []objects.ObjectID{upstream}, one.Parents())
+	assert.Equal(t, map[string]string{"a.txt": "a\n", "b.txt": "b\n", "one.txt": "one\n"}, files)
+	two, _ := patchFiles(t, repo, refManager, "two")
+	assert.Equal(t, []objects.ObjectID{one.ID()}, two.Parents())
+
+	// Refreshing amends the top patch in place
+	stageFile(t, "two.txt", "two, refreshed\n")
+	out, err = runCommandArgs(newSeriesCommand(), "refresh")
+	require.NoError(t, err)
+	assert.Equal(t, "Refreshed patch two\n", out)
+	two, files = patchFiles(t, repo, refManager, "two")
+	assert.Equal(t, []objects.ObjectID{one.ID()}, two.Parents())
+	assert.Equal(t, "two\n", two.Message())
+	assert.Equal(t, "two, refreshed\n", files["two.txt"])
+	head, _, err = refManager.HEAD()
+	require.NoError(t, err)
+	assert.Equal(t, two.ID(), head)
+
+	_, err = runCommandArgs(newSeriesCommand(), "refresh")
+	assert.ErrorContains(t, err, "nothing to refresh")
+
+	// The branch moving under applied patches is caught
+	moveMain(t, repo, refManager, map[string]string{"a.txt": "moved\n"})
+	_, err = runCommandArgs(newSeriesCommand(), "pop")
+	assert.ErrorContains(t, err, "branch main has moved")
+}
+
+func TestSeriesPushConflict(t *testing.T) {
+	repo, refManager := setupSeriesRepo(t, map[string]string{"a.txt": "a\n"})
+
+	stageFile(t, "a.txt", "patched\n")
+	_, err := runCommandArgs(newSeriesCommand(), "create", "edit")
+	require.NoError(t, err)
+	_, err = runCommandArgs(newSeriesCommand(), "pop")
+	require.NoError(t, err)
+	upstream := moveMain(t, repo, refManager, map[string]string{"a.txt": "upstream\n"})
+
+	out, err := runCommandArgs(newSeriesCommand(), "push")
+	assert.ErrorIs(t, err, errSeriesConflict)
+	assert.Contains(t, out, "CONFLICT (content): Merge conflict in a.txt")
+	data, err := os.ReadFile("a.txt")
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "<<<<<<< HEAD")
+
+	_, err = runCommandArgs(newSeriesCommand(), "create", "other")
+	assert.ErrorContains(t, err, "push of patch edit has not been concluded")
+	_, err = runCommandArgs(newSeriesCommand(), "refresh")
+	assert.ErrorContains(t, err, "you must edit all merge conflicts")
+
+	// Popping gives up on the push
+	out, err = runCommandArgs(newSeriesCommand(), "pop")
+	require.NoError(t, err)
+	assert.Equal(t, "Popped patch edit\nNo patches applied\n", out)
+	data, err = os.ReadFile("a.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "upstream\n", string(data))
+	assert.False(t, fileExists(filepath.Join(repo.GitDir(), "series", "main", "pushing")))
+
+	// Resolving and refreshing concludes it
+	_, err = runCommandArgs(newSeriesCommand(), "push")
+	require.ErrorIs(t, err, errSeriesConflict)
+	stageFile(t, "a.txt", "resolved\n")
+	_, err = runCommandArgs(newSeriesCommand(), "refresh")
+	require.NoError(t, err)
+
+	edit, files := patchFiles(t, repo, refManager, "edit")
+	assert.Equal(t, []objects.ObjectID{upstream}, edit.Parents())
+	assert.Equal(t, map[string]string{"a.txt": "resolved\n"}, files)
+	out, err = runCommandArgs(newSeriesCommand())
+	require.NoError(t, err)
+	assert.Equal(t, "> edit\n", out)
+}
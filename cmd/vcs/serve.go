@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/config"
+	"github.com/fenilsonani/vcs/internal/serve"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+func newServeCommand() *cobra.Command {
+	var root string
+	var port int
+	var allowPush bool
+
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve repositories over HTTP",
+		Long: `Serves every repository under the root directory over Git's smart HTTP
+protocol, so that vcs and git can clone, fetch and push to them. Each
+repository is served at its path under the root, and a bare repository's
+.git suffix may be left out:
+
+  vcs serve --root /repos
+  git clone http://localhost:8080/team/project.git
+
+Clones and fetches may be shallow, cut with --depth, --shallow-since or
+--shallow-exclude; fetches deepening by a number of commits with --deepen
+are refused.
+
+Repositories are read-only unless --allow-push is given. A repository's
+http.receivePack setting overrides the flag for that repository, enabling
+or disabling pushes to it. Pushes are checked against the repository's
+receive.* settings, described in vcs help share.
+
+Serving stops on Ctrl-C. There is no authentication: serve only on
+networks whose clients are trusted, or behind a proxy that authenticates.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runServe(cmd, root, port, allowPush)
+		},
+	}
+
+	cmd.Flags().StringVar(&root, "root", ".", "Directory holding the repositories to serve")
+	cmd.Flags().IntVar(&port, "port", 8080, "Port to serve on")
+	cmd.Flags().BoolVar(&allowPush, "allow-push", false, "Accept pushes, subject to each repository's receive.* settings")
+
+	return cmd
+}
+
+func runServe(cmd *cobra.Command, root string, port int, allowPush bool) error {
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return fmt.Errorf("failed to resolve root: %w", err)
+	}
+	if info, err := os.Stat(root); err != nil {
+		return fmt.Errorf("failed to read root: %w", err)
+	} else if !info.IsDir() {
+		return fmt.Errorf("'%s' is not a directory", root)
+	}
+	cmd.SilenceUsage = true
+
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+	port = listener.Addr().(*net.TCPAddr).Port
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	mode := "read-only"
+	if allowPush {
+		mode = "with pushes allowed"
+	}
+	out := cmd.OutOrStdout()
+	fmt.Fprintf(out, "Serving repositories under %s %s on port %d\n", root, mode, port)
+	fmt.Fprintln(out, "Press Ctrl-C to stop serving.")
+
+	return serveShare(ctx, listener, serve.NewRootServer(root, func(dir string) (*serve.Server, error) {
+		return openServedRepository(dir, allowPush, "http.receivePack")
+	}))
+}
+
+// openServedRepository returns the server for the repository in dir, which
+// may be bare. Pushes are accepted when allowPush is set, unless the
+// repository's pushKey setting, such as http.receivePack, says otherwise,
+// and run the repository's receive hooks.
+func openServedRepository(dir string, allowPush bool, pushKey string) (*serve.Server, error) {
+	gitDir, err := localGitDir(dir)
+	if err != nil {
+		return nil, serve.ErrNotRepository
+	}
+	repo, err := vcs.OpenWithGitDir(dir, gitDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	server := serve.NewServer(repo.GitDir(), repo.Storage())
+	server.SetPackOptions(packWriterOptions(repo.GitDir()))
+
+	cfg, err := config.Load(repo.GitDir())
+	if err != nil {
+		return nil, fmt.Errorf("failed to read config: %w", err)
+	}
+	if value, ok := cfg.Get(pushKey); ok {
+		if allowPush, err = config.ParseBool(value); err != nil {
+			return nil, fmt.Errorf("invalid %s: %w", pushKey, err)
+		}
+	}
+	if allowPush {
+		policy, err := receivePolicy(repo.GitDir())
+		if err != nil {
+			return nil, err
+		}
+		server.AllowPush(policy)
+		server.SetHooks(receiveHooks(os.Stderr, repo.Path(), repo.GitDir()))
+	}
+	return server, nil
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/serve"
+)
+
+func TestOpenServedRepository(t *testing.T) {
+	repo, _ := setupConfigRepo(t)
+	repoPath := repo.Path()
+
+	canPush := func(allowPush bool) bool {
+		server, err := openServedRepository(repoPath, allowPush, "http.receivePack")
+		require.NoError(t, err)
+		rec := httptest.NewRecorder()
+		server.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/repo/info/refs?service=git-receive-pack", nil))
+		return rec.Code == http.StatusOK
+	}
+	assert.False(t, canPush(false))
+	assert.True(t, canPush(true))
+
+	// http.receivePack overrides --allow-push either way
+	_, err := runConfigArgs("http.receivePack", "false")
+	require.NoError(t, err)
+	assert.False(t, canPush(true))
+	_, err = runConfigArgs("http.receivePack", "true")
+	require.NoError(t, err)
+	assert.True(t, canPush(false))
+
+	_, err = runConfigArgs("http.receivePack", "maybe")
+	require.NoError(t, err)
+	_, err = openServedRepository(repoPath, false, "http.receivePack")
+	assert.ErrorContains(t, err, "invalid http.receivePack")
+
+	_, err = openServedRepository(t.TempDir(), true, "http.receivePack")
+	assert.ErrorIs(t, err, serve.ErrNotRepository)
+}
+
+func TestServeInvalidRoot(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(file, nil, 0644))
+
+	cmd := newServeCommand()
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"--root", file, "--port", "0"})
+	assert.ErrorContains(t, cmd.Execute(), "is not a directory")
+}
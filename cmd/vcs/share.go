@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"regexp"
+	"syscall"
+	"time"
+
+	"github.com/fenilsonani/vcs/internal/core/config"
+	"github.com/fenilsonani/vcs/internal/serve"
+	"github.com/spf13/cobra"
+)
+
+// localShareTimeout bounds the wait for a vcs-local:// share to answer
+const localShareTimeout = 5 * time.Second
+
+// resolveLocalShare finds a repository shared with vcs share on the local
+// network
+var resolveLocalShare = serve.Resolve
+
+func newShareCommand() *cobra.Command {
+	var name string
+	var port int
+	var allowPush bool
+
+	cmd := &cobra.Command{
+		Use:   "share",
+		Short: "Share the repository on the local network",
+		Long: `Serves the repository read-only over HTTP and advertises it on the local
+network with multicast DNS, over both IPv4 and IPv6. Anyone on the same
+network can then clone or fetch it by name, without any server setup:
+
+  vcs clone vcs-local://<name>
+
+The name defaults to the repository's directory name. Sharing stops on
+Ctrl-C.
+
+With --allow-push, pushes are accepted too, subject to these settings:
+
+  receive.maxBlobSize      largest blob a push may add, e.g. 10m
+  receive.blockedPath      file pattern a push may not add, e.g. *.pem
+                           (may be given several times)
+  receive.refFormat        regular expression every pushed ref must match
+  receive.fastForwardOnly  ref pattern that may only be fast-forwarded,
+                           e.g. refs/heads/main (may be given several times)
+
+A refused ref is reported to the pusher with the rule it broke, and its
+objects are not stored. The checked out branch of a non-bare repository
+cannot be pushed to. The repository's pre-receive, update and post-receive
+hooks are run as in Git.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runShare(cmd, name, port, allowPush)
+		},
+	}
+
+	cmd.Flags().StringVar(&name, "name", "", "Name to share the repository as")
+	cmd.Flags().IntVar(&port, "port", 0, "Port to serve on (default: any free port)")
+	cmd.Flags().BoolVar(&allowPush, "allow-push", false, "Accept pushes, subject to the receive.* policy settings")
+
+	return cmd
+}
+
+func runShare(cmd *cobra.Command, name string, port int, allowPush bool) error {
+	repoPath, err := findRepository()
+	if err != nil {
+		return err
+	}
+	repo, err := openStoredRepository(repoPath)
+	if err != nil {
+		return err
+	}
+
+	server := serve.NewServer(repo.GitDir(), repo.Storage())
+	server.SetPackOptions(packWriterOptions(repo.GitDir()))
+	mode := "read-only"
+	if allowPush {
+		// A policy that cannot be read must not be silently dropped
+		policy, err := receivePolicy(repo.GitDir())
+		if err != nil {
+			return err
+		}
+		server.AllowPush(policy)
+		server.SetHooks(receiveHooks(cmd.ErrOrStderr(), repoPath, repo.GitDir()))
+		mode = "with pushes allowed"
+	}
+
+	if name == "" {
+		name = serve.ShareName(filepath.Base(repoPath))
+	} else if err := serve.ValidateShareName(name); err != nil {
+		return err
+	}
+
+	// Listening on all addresses accepts both IPv4 and IPv6 clients
+	listener, err := net.Listen("tcp", fmt.Sprintf(":%d", port))
+	if err != nil {
+		return fmt.Errorf("failed to listen: %w", err)
+	}
+	port = listener.Addr().(*net.TCPAddr).Port
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	responder, err := serve.NewResponder(name, port)
+	if err != nil {
+		listener.Close()
+		return err
+	}
+	go func() {
+		if err := responder.Serve(ctx); err != nil {
+			fmt.Fprintf(cmd.ErrOrStderr(), "warning: not advertised on the local network: %v\n", err)
+		}
+	}()
+
+	out := cmd.OutOrStdout()
+	fmt.Fprintf(out, "Sharing %s %s as '%s' on port %d\n", repoPath, mode, name, port)
+	fmt.Fprintf(out, "Clone it with: vcs clone %s%s\n", serve.URLScheme, name)
+	fmt.Fprintln(out, "Press Ctrl-C to stop sharing.")
+
+	return serveShare(ctx, listener, server)
+}
+
+// receivePolicy reads the push policy from the receive.* settings
+func receivePolicy(gitDir string) (serve.Policy, error) {
+	var policy serve.Policy
+	cfg, err := config.Load(gitDir)
+	if err != nil {
+		return policy, fmt.Errorf("failed to read config: %w", err)
+	}
+
+	if value, ok := cfg.Get("receive.maxBlobSize"); ok {
+		if policy.MaxBlobSize, err = parseConfigSize(value); err != nil {
+			return policy, fmt.Errorf("invalid receive.maxBlobSize: %w", err)
+		}
+	}
+	if value, ok := cfg.Get("receive.refFormat"); ok && value != "" {
+		if policy.RefFormat, err = regexp.Compile(value); err != nil {
+			return policy, fmt.Errorf("invalid receive.refFormat: %w", err)
+		}
+	}
+	policy.BlockedPaths = cfg.GetAll("receive.blockedPath")
+	policy.FastForwardOnly = cfg.GetAll("receive.fastForwardOnly")
+	for _, pattern := range append(policy.BlockedPaths, policy.FastForwardOnly...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return policy, fmt.Errorf("invalid pattern %q: %w", pattern, err)
+		}
+	}
+	return policy, nil
+}
+
+// serveShare serves handler on listener until ctx is done
+func serveShare(ctx context.Context, listener net.Listener, handler http.Handler) error {
+	server := &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+
+	errc := make(chan error, 1)
+	go func() {
+		errc <- server.Serve(listener)
+	}()
+
+	select {
+	case err := <-errc:
+		return fmt.Errorf("failed to serve: %w", err)
+	case <-ctx.Done():
+	}
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(shutdownCtx); err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("failed to stop sharing: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/internal/serve"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+func TestCloneFromLocalShare(t *testing.T) {
+	isolateConfig(t)
+	src, err := vcs.Init(filepath.Join(t.TempDir(), "project"))
+	require.NoError(t, err)
+
+	sig := objects.Signature{Name: "Test", Email: "test@example.com", When: time.Unix(1700000000, 0)}
+	blob, err := src.CreateBlob([]byte("shared\n"))
+	require.NoError(t, err)
+	tree, err := src.CreateTree([]objects.TreeEntry{{Mode: objects.ModeBlob, Name: "README", ID: blob.ID()}})
+	require.NoError(t, err)
+	commit, err := src.CreateCommit(tree.ID(), nil, sig, sig, "initial\n")
+	require.NoError(t, err)
+	srcRefs := refs.NewRefManager(src.GitDir())
+	require.NoError(t, srcRefs.UpdateRef("refs/heads/main", commit.ID()))
+	require.NoError(t, srcRefs.SetHEAD("refs/heads/main"))
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() {
+		served <- serveShare(ctx, listener, serve.NewServer(src.GitDir(), src.Storage()))
+	}()
+
+	oldResolve := resolveLocalShare
+	t.Cleanup(func() { resolveLocalShare = oldResolve })
+	var resolved string
+	resolveLocalShare = func(ctx context.Context, name string) (*serve.Share, error) {
+		resolved = name
+		return &serve.Share{Name: name, Addr: listener.Addr().(*net.TCPAddr)}, nil
+	}
+
+	dir := filepath.Join(t.TempDir(), "clone")
+	require.NoError(t, runCloneArgs("vcs-local://project", dir))
+	assert.Equal(t, "project", resolved)
+
+	content, err := os.ReadFile(filepath.Join(dir, "README"))
+	require.NoError(t, err)
+	assert.Equal(t, "shared\n", string(content))
+	id, err := refs.NewRefManager(filepath.Join(dir, ".git")).ResolveRef("refs/heads/main")
+	require.NoError(t, err)
+	assert.Equal(t, commit.ID(), id)
+
+	cancel()
+	select {
+	case err := <-served:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("sharing did not stop")
+	}
+}
+
+func TestShareInvalidName(t *testing.T) {
+	setupConfigRepo(t)
+
+	cmd := newShareCommand()
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs([]string{"--name", "My Project"})
+	assert.ErrorContains(t, cmd.Execute(), "invalid share name")
+}
+
+func TestReceivePolicy(t *testing.T) {
+	repo, _ := setupConfigRepo(t)
+
+	policy, err := receivePolicy(repo.GitDir())
+	require.NoError(t, err)
+	assert.Equal(t, serve.Policy{}, policy, "no settings allow any push")
+
+	for _, args := range [][]string{
+		{"receive.maxBlobSize", "10m"},
+		{"--add", "receive.blockedPath", "*.pem"},
+		{"--add", "receive.blockedPath", "secrets/*"},
+		{"receive.refFormat", "^refs/heads/[a-z]+$"},
+		{"receive.fastForwardOnly", "refs/heads/main"},
+	} {
+		_, err := runConfigArgs(args...)
+		require.NoError(t, err)
+	}
+	policy, err = receivePolicy(repo.GitDir())
+	require.NoError(t, err)
+	assert.Equal(t, int64(10<<20), policy.MaxBlobSize)
+	assert.Equal(t, []string{"*.pem", "secrets/*"}, policy.BlockedPaths)
+	assert.Equal(t, "^refs/heads/[a-z]+$", policy.RefFormat.String())
+	assert.Equal(t, []string{"refs/heads/main"}, policy.FastForwardOnly)
+
+	_, err = runConfigArgs("receive.refFormat", "(")
+	require.NoError(t, err)
+	_, err = receivePolicy(repo.GitDir())
+	assert.ErrorContains(t, err, "invalid receive.refFormat")
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/internal/core/trailer"
+)
+
+// shortlogOptions holds the flags of shortlog
+type shortlogOptions struct {
+	summary  bool
+	numbered bool
+	email    bool
+	groups   []string
+}
+
+func newShortlogCommand() *cobra.Command {
+	var opts shortlogOptions
+	var committer bool
+
+	cmd := &cobra.Command{
+		Use:   "shortlog [flags] [<revision-range>...]",
+		Short: "Summarize commits by author",
+		Long: `Groups the commits reachable from the given revisions, or from HEAD, by
+author and lists the subjects of each group's commits, oldest first.
+Ranges such as A..B, A...B and ^A leave out the commits they exclude, so
+"vcs shortlog -sn v1.0..v2.0" counts the commits of each author between
+two releases.
+
+Groups are sorted by name, or with -n by how many commits they have. -s
+prints only the counts and -e adds each email address.
+
+--group picks what commits are grouped by: author, committer, or
+trailer:<token> for the people named by a trailer such as
+"trailer:co-authored-by", where a commit counts once for each. It may be
+repeated to count a commit in each group it falls in; -c is short for
+--group=committer.`,
+		Args: cobra.ArbitraryArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if committer {
+				opts.groups = append(opts.groups, "committer")
+			}
+			return runShortlog(cmd, args, opts)
+		},
+	}
+
+	cmd.Flags().BoolVarP(&opts.summary, "summary", "s", false, "Print only the number of commits of each group")
+	cmd.Flags().BoolVarP(&opts.numbered, "numbered", "n", false, "Sort groups by their number of commits")
+	cmd.Flags().BoolVarP(&opts.email, "email", "e", false, "Show the email address of each author")
+	cmd.Flags().StringArrayVar(&opts.groups, "group", nil, "Group commits by author, committer or trailer:<token>")
+	cmd.Flags().BoolVarP(&committer, "committer", "c", false, "Group commits by committer")
+
+	return cmd
+}
+
+// shortlogGroup is the commits of an author, committer or trailer value
+type shortlogGroup struct {
+	name     string
+	subjects []string
+}
+
+func runShortlog(cmd *cobra.Command, args []string, opts shortlogOptions) error {
+	for _, group := range opts.groups {
+		if group != "author" && group != "committer" && !strings.HasPrefix(group, "trailer:") {
+			return fmt.Errorf("unknown group type: %s", group)
+		}
+	}
+	if len(opts.groups) == 0 {
+		opts.groups = []string{"author"}
+	}
+
+	repoPath, err := findRepository()
+	if err != nil {
+		return fmt.Errorf("not a git repository: %w", err)
+	}
+	repo, err := openRepository(repoPath)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	resolver := newResolver(repo)
+	var starts, excluded []objects.ObjectID
+	for _, arg := range args {
+		r, err := resolver.ResolveRange(arg)
+		if err != nil {
+			return err
+		}
+		starts = append(starts, r.Include...)
+		excluded = append(excluded, r.Exclude...)
+	}
+	if len(args) == 0 {
+		head, _, err := refs.NewRefManager(repo.GitDir()).HEAD()
+		if err != nil {
+			return fmt.Errorf("failed to get HEAD: %w", err)
+		}
+		if head.IsZero() {
+			return nil
+		}
+		starts = []objects.ObjectID{head}
+	}
+
+	listed, err := listRevisions(repo, starts, excluded, false)
+	if err != nil {
+		return err
+	}
+
+	groups := make(map[string]*shortlogGroup)
+	// Commits are listed newest first and each group's shown oldest first
+	for i := len(listed) - 1; i >= 0; i-- {
+		commit, err := repo.GetCommit(listed[i])
+		if err != nil {
+			return fmt.Errorf("failed to read commit %s: %w", listed[i], err)
+		}
+		subject, _ := splitCommitMessage(commit.Message())
+		for _, name := range shortlogNames(commit, opts) {
+			group, ok := groups[name]
+			if !ok {
+				group = &shortlogGroup{name: name}
+				groups[name] = group
+			}
+			group.subjects = append(group.subjects, subject)
+		}
+	}
+
+	sorted := make([]*shortlogGroup, 0, len(groups))
+	for _, group := range groups {
+		sorted = append(sorted, group)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		if opts.numbered && len(sorted[i].subjects) != len(sorted[j].subjects) {
+			return len(sorted[i].subjects) > len(sorted[j].subjects)
+		}
+		return sorted[i].name < sorted[j].name
+	})
+
+	startPager(repo.GitDir(), "shortlog", true)
+	out := cmd.OutOrStdout()
+	for _, group := range sorted {
+		if opts.summary {
+			fmt.Fprintf(out, "%6d\t%s\n", len(group.subjects), group.name)
+			continue
+		}
+		fmt.Fprintf(out, "%s (%d):\n", group.name, len(group.subjects))
+		for _, subject := range group.subjects {
+			fmt.Fprintf(out, "      %s\n", subject)
+		}
+		fmt.Fprintln(out)
+	}
+	return nil
+}
+
+// shortlogNames returns the names of the groups commit counts in, each
+// once, as "Name" or with opts.email as "Name <email>"
+func shortlogNames(commit *objects.Commit, opts shortlogOptions) []string {
+	seen := make(map[string]bool)
+	var names []string
+	add := func(name, email string) {
+		if opts.email && email != "" {
+			name = fmt.Sprintf("%s <%s>", name, email)
+		}
+		if name != "" && !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	for _, group := range opts.groups {
+		switch group {
+		case "author":
+			add(commit.Author().Name, commit.Author().Email)
+		case "committer":
+			add(commit.Committer().Name, commit.Committer().Email)
+		default:
+			token := strings.TrimPrefix(group, "trailer:")
+			for _, t := range trailer.Parse(commit.Message(), trailer.Options{}).Trailers {
+				if t.Token == "" || !strings.EqualFold(t.Token, token) {
+					continue
+				}
+				// Values that are not "Name <email>" are grouped whole
+				name, email := t.Value, ""
+				if open := strings.LastIndex(t.Value, " <"); open >= 0 && strings.HasSuffix(t.Value, ">") {
+					name, email = t.Value[:open], t.Value[open+2:len(t.Value)-1]
+				}
+				add(name, email)
+			}
+		}
+	}
+	return names
+}
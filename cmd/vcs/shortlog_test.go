@@ -0,0 +1,48 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShortlog(t *testing.T) {
+	repo, _ := setupConfigRepo(t)
+	_, err := runConfigArgs("user.name", "Committer")
+	require.NoError(t, err)
+	_, err = runConfigArgs("user.email", "c@example.com")
+	require.NoError(t, err)
+
+	_, err = stageAndCommit(t, repo, "a.txt", "-m", "first", "--author", "Bob <bob@example.com>")
+	require.NoError(t, err)
+	_, err = runCommandArgs(newTagCommand(), "v1")
+	require.NoError(t, err)
+	_, err = stageAndCommit(t, repo, "b.txt", "-m", "second\n\nCo-authored-by: Carol <carol@example.com>", "--author", "Alice <alice@example.com>")
+	require.NoError(t, err)
+	_, err = stageAndCommit(t, repo, "c.txt", "-m", "third", "--author", "Bob <bob@example.com>")
+	require.NoError(t, err)
+
+	out, err := runCommandArgs(newShortlogCommand())
+	require.NoError(t, err)
+	assert.Equal(t, "Alice (1):\n      second\n\nBob (2):\n      first\n      third\n\n", out)
+
+	out, err = runCommandArgs(newShortlogCommand(), "-sne")
+	require.NoError(t, err)
+	assert.Equal(t, "     2\tBob <bob@example.com>\n     1\tAlice <alice@example.com>\n", out)
+
+	out, err = runCommandArgs(newShortlogCommand(), "-s", "v1..HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, "     1\tAlice\n     1\tBob\n", out)
+
+	out, err = runCommandArgs(newShortlogCommand(), "-sc")
+	require.NoError(t, err)
+	assert.Equal(t, "     3\tCommitter\n", out)
+
+	out, err = runCommandArgs(newShortlogCommand(), "-s", "--group=author", "--group=trailer:co-authored-by")
+	require.NoError(t, err)
+	assert.Equal(t, "     1\tAlice\n     2\tBob\n     1\tCarol\n", out)
+
+	_, err = runCommandArgs(newShortlogCommand(), "--group=reviewer")
+	assert.ErrorContains(t, err, "unknown group type: reviewer")
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/config"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/signing"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// signingKeyFlag is the value of -S or -u given without a key, meaning the
+// configured one
+const signingKeyFlag = " "
+
+// newSigner returns a signer for the configured gpg.format, signing with key
+// or else user.signingKey. OpenPGP signing falls back to the key of the
+// committer's identity, as in Git; SSH signing needs a key configured.
+func newSigner(repo *vcs.Repository, key string) (*signing.Signer, error) {
+	cfg := loadConfig(repo.GitDir())
+	value, _ := cfg.Get("gpg.format")
+	format, err := signing.ParseFormat(value)
+	if err != nil {
+		return nil, err
+	}
+	signer := &signing.Signer{Format: format, Key: strings.TrimSpace(key)}
+
+	if format == signing.FormatSSH {
+		signer.Program, _ = cfg.Get("gpg.ssh.program")
+	} else if signer.Program, _ = cfg.Get("gpg.openpgp.program"); signer.Program == "" {
+		signer.Program, _ = cfg.Get("gpg.program")
+	}
+
+	if signer.Key == "" {
+		signer.Key, _ = cfg.Get("user.signingKey")
+	}
+	if signer.Key == "" && format == signing.FormatOpenPGP {
+		who, err := getSignature("")
+		if err != nil {
+			return nil, err
+		}
+		signer.Key = fmt.Sprintf("%s <%s>", who.Name, who.Email)
+	}
+	return signer, nil
+}
+
+// newVerifier returns a verifier using the configured programs and SSH
+// allowed signers file
+func newVerifier(repo *vcs.Repository) *signing.Verifier {
+	cfg := loadConfig(repo.GitDir())
+	verifier := &signing.Verifier{}
+	if verifier.GPGProgram, _ = cfg.Get("gpg.openpgp.program"); verifier.GPGProgram == "" {
+		verifier.GPGProgram, _ = cfg.Get("gpg.program")
+	}
+	verifier.SSHProgram, _ = cfg.Get("gpg.ssh.program")
+	verifier.AllowedSigners, _ = cfg.Get("gpg.ssh.allowedSignersFile")
+	return verifier
+}
+
+// signingRequested reports whether to sign, and with which key: that given
+// with flag, or the configured one when flag is given bare or configKey is
+// true. noFlag turns signing off.
+func signingRequested(cmd *cobra.Command, cfg *config.Config, flag, noFlag, configKey string) (bool, string, error) {
+	if noFlag != "" {
+		if off, _ := cmd.Flags().GetBool(noFlag); off {
+			return false, "", nil
+		}
+	}
+	if cmd.Flags().Changed(flag) {
+		key, _ := cmd.Flags().GetString(flag)
+		return true, key, nil
+	}
+	if value, ok := cfg.Get(configKey); ok {
+		sign, err := config.ParseBool(value)
+		if err != nil {
+			return false, "", fmt.Errorf("invalid %s: %w", configKey, err)
+		}
+		return sign, "", nil
+	}
+	return false, "", nil
+}
+
+// writeSignedCommit writes the commit of tree with a signature by signer
+// and returns its ID
+func writeSignedCommit(repo *vcs.Repository, signer *signing.Signer, tree objects.ObjectID, parents []objects.ObjectID, author, committer objects.Signature, message string) (objects.ObjectID, error) {
+	payload, err := objects.NewCommit(tree, parents, author, committer, message).Serialize()
+	if err != nil {
+		return objects.ObjectID{}, err
+	}
+	signature, err := signer.Sign(payload)
+	if err != nil {
+		return objects.ObjectID{}, err
+	}
+	return repo.WriteRawObject(objects.TypeCommit, signing.AddCommitSignature(payload, signature))
+}
+
+// writeSignedTag writes the tag with a signature by signer appended to its
+// message and returns its ID
+func writeSignedTag(repo *vcs.Repository, signer *signing.Signer, target objects.ObjectID, objType objects.ObjectType, name string, tagger objects.Signature, message string) (objects.ObjectID, error) {
+	if !strings.HasSuffix(message, "\n") {
+		message += "\n"
+	}
+	payload, err := objects.NewTag(target, objType, name, tagger, message).Serialize()
+	if err != nil {
+		return objects.ObjectID{}, err
+	}
+	signature, err := signer.Sign(payload)
+	if err != nil {
+		return objects.ObjectID{}, err
+	}
+	return repo.WriteRawObject(objects.TypeTag, append(payload, signature...))
+}
+
+func newVerifyCommitCommand() *cobra.Command {
+	var verbose bool
+
+	cmd := &cobra.Command{
+		Use:   "verify-commit [flags] <commit>...",
+		Short: "Check the signature of commits",
+		Long: `Checks the GPG or SSH signature of each commit, printing what gpg or
+ssh-keygen reports. Fails unless every commit has a good signature.
+
+OpenPGP signatures are checked against the gpg keyring. SSH signatures are
+checked against the signers listed in the file gpg.ssh.allowedSignersFile
+names, in the format of ssh-keygen's ALLOWED SIGNERS section.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runVerify(cmd, args, objects.TypeCommit, verbose)
+		},
+	}
+
+	cmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Print the contents of the commit before verifying it")
+	return cmd
+}
+
+func newVerifyTagCommand() *cobra.Command {
+	var verbose bool
+
+	cmd := &cobra.Command{
+		Use:   "verify-tag [flags] <tag>...",
+		Short: "Check the signature of tags",
+		Long: `Checks the GPG or SSH signature of each annotated tag, printing what gpg or
+ssh-keygen reports. Fails unless every tag has a good signature. Keys are
+trusted as for verify-commit.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runVerify(cmd, args, objects.TypeTag, verbose)
+		},
+	}
+
+	cmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Print the contents of the tag before verifying it")
+	return cmd
+}
+
+func runVerify(cmd *cobra.Command, names []string, objType objects.ObjectType, verbose bool) error {
+	repoPath, err := findRepository()
+	if err != nil {
+		return fmt.Errorf("not a git repository: %w", err)
+	}
+	repo, err := openRepository(repoPath)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	resolver := newResolver(repo)
+	verifier := newVerifier(repo)
+	failed := 0
+	for _, name := range names {
+		var id objects.ObjectID
+		if objType == objects.TypeTag {
+			id, err = resolver.Resolve("refs/tags/" + name)
+			if err != nil {
+				id, err = resolver.Resolve(name)
+			}
+		} else {
+			id, err = resolver.ResolveCommit(name)
+		}
+		if err != nil {
+			return fmt.Errorf("%s: cannot resolve: %w", name, err)
+		}
+		if err := verifyObject(cmd, repo, verifier, id, objType, verbose); err != nil {
+			fmt.Fprintf(cmd.ErrOrStderr(), "error: %s: %v\n", name, err)
+			failed++
+		}
+	}
+	if failed > 0 {
+		return fmt.Errorf("%d of %d signatures could not be verified", failed, len(names))
+	}
+	return nil
+}
+
+// verifyObject checks the signature of the commit or tag id, reporting on
+// stderr as gpg or ssh-keygen does
+func verifyObject(cmd *cobra.Command, repo *vcs.Repository, verifier *signing.Verifier, id objects.ObjectID, objType objects.ObjectType, verbose bool) error {
+	actual, data, err := repo.ReadRawObject(id)
+	if err != nil {
+		return fmt.Errorf("failed to read object %s: %w", id.Short(), err)
+	}
+	if actual != objType {
+		return fmt.Errorf("cannot verify a non-%s object of type %s", objType, actual)
+	}
+
+	var payload, signature []byte
+	if objType == objects.TypeTag {
+		payload, signature = signing.SplitTag(data)
+	} else {
+		payload, signature = signing.SplitCommit(data)
+	}
+	if verbose {
+		fmt.Fprint(cmd.OutOrStdout(), string(payload))
+	}
+	if signature == nil {
+		return fmt.Errorf("no signature found")
+	}
+
+	result, err := verifier.Verify(payload, signature)
+	if err != nil {
+		return err
+	}
+	fmt.Fprint(cmd.ErrOrStderr(), result.Output)
+	if !result.Good {
+		return fmt.Errorf("bad or untrusted %s signature", result.Format)
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/internal/core/signing"
+)
+
+// setupSSHSigning configures repo to sign with a new SSH key trusted for
+// test@example.com
+func setupSSHSigning(t *testing.T) {
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen is not installed")
+	}
+	dir := t.TempDir()
+	key := filepath.Join(dir, "key")
+	out, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-C", "test", "-f", key).CombinedOutput()
+	require.NoError(t, err, string(out))
+	public, err := os.ReadFile(key + ".pub")
+	require.NoError(t, err)
+	allowed := filepath.Join(dir, "allowed_signers")
+	require.NoError(t, os.WriteFile(allowed, []byte("test@example.com "+string(public)), 0644))
+
+	for _, args := range [][]string{
+		{"gpg.format", "ssh"},
+		{"user.signingKey", key},
+		{"gpg.ssh.allowedSignersFile", allowed},
+	} {
+		_, err := runConfigArgs(args...)
+		require.NoError(t, err)
+	}
+}
+
+func TestCommitSigning(t *testing.T) {
+	repo, _ := setupConfigRepo(t)
+	setupSSHSigning(t)
+
+	_, err := stageAndCommit(t, repo, "a.txt", "-S", "-m", "signed")
+	require.NoError(t, err)
+	head, _, err := refs.NewRefManager(repo.GitDir()).HEAD()
+	require.NoError(t, err)
+	_, data, err := repo.ReadRawObject(head)
+	require.NoError(t, err)
+	payload, signature := signing.SplitCommit(data)
+	assert.Contains(t, string(signature), "-----BEGIN SSH SIGNATURE-----")
+	assert.Contains(t, string(payload), "\n\nsigned\n")
+
+	cmd := newVerifyCommitCommand()
+	var stderr bytes.Buffer
+	cmd.SetErr(&stderr)
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetArgs([]string{"HEAD"})
+	require.NoError(t, cmd.Execute())
+	assert.Contains(t, stderr.String(), `Good "git" signature for test@example.com`)
+
+	// commit.gpgSign signs without -S, and --no-gpg-sign overrides it
+	_, err = runConfigArgs("commit.gpgSign", "true")
+	require.NoError(t, err)
+	_, err = stageAndCommit(t, repo, "b.txt", "-m", "configured")
+	require.NoError(t, err)
+	_, err = runCommandArgs(newVerifyCommitCommand(), "HEAD", "HEAD~1")
+	assert.NoError(t, err)
+
+	_, err = stageAndCommit(t, repo, "c.txt", "--no-gpg-sign", "-m", "unsigned")
+	require.NoError(t, err)
+	_, err = runCommandArgs(newVerifyCommitCommand(), "HEAD")
+	assert.ErrorContains(t, err, "1 of 1 signatures could not be verified")
+}
+
+func TestTagSigning(t *testing.T) {
+	repo, _ := setupConfigRepo(t)
+	setupSSHSigning(t)
+	_, err := stageAndCommit(t, repo, "a.txt", "-m", "first")
+	require.NoError(t, err)
+
+	_, err = captureStdout(t, func() error {
+		_, err := runCommandArgs(newTagCommand(), "-s", "-m", "Release", "v1.0")
+		return err
+	})
+	require.NoError(t, err)
+	_, err = runCommandArgs(newVerifyTagCommand(), "v1.0")
+	assert.NoError(t, err)
+	_, err = runCommandArgs(newTagCommand(), "-v", "v1.0")
+	assert.NoError(t, err)
+
+	// A tag whose signature does not match its content
+	tagRef, err := refs.NewRefManager(repo.GitDir()).ResolveRef("refs/tags/v1.0")
+	require.NoError(t, err)
+	_, data, err := repo.ReadRawObject(tagRef)
+	require.NoError(t, err)
+	forged, err := repo.WriteRawObject(objects.TypeTag, bytes.Replace(data, []byte("Release"), []byte("Forgery"), 1))
+	require.NoError(t, err)
+	require.NoError(t, refs.NewRefManager(repo.GitDir()).UpdateRef("refs/tags/forged", forged))
+	_, err = runCommandArgs(newVerifyTagCommand(), "forged")
+	assert.Error(t, err)
+
+	_, err = captureStdout(t, func() error {
+		_, err := runCommandArgs(newTagCommand(), "light")
+		return err
+	})
+	require.NoError(t, err)
+	_, err = runCommandArgs(newVerifyTagCommand(), "light")
+	assert.Error(t, err)
+}
+
+func TestCommitSigningWithGPG(t *testing.T) {
+	if _, err := exec.LookPath("gpg"); err != nil {
+		t.Skip("gpg is not installed")
+	}
+	repo, _ := setupConfigRepo(t)
+	t.Setenv("GNUPGHOME", t.TempDir())
+	out, err := exec.Command("gpg", "--batch", "--passphrase", "", "--quick-gen-key", "Test <test@example.com>", "ed25519", "sign", "never").CombinedOutput()
+	require.NoError(t, err, string(out))
+	_, err = runConfigArgs("user.name", "Test")
+	require.NoError(t, err)
+	_, err = runConfigArgs("user.email", "test@example.com")
+	require.NoError(t, err)
+
+	// The key defaults to the committer's identity
+	_, err = stageAndCommit(t, repo, "a.txt", "-S", "-m", "signed")
+	require.NoError(t, err)
+	cmd := newVerifyCommitCommand()
+	var stderr bytes.Buffer
+	cmd.SetErr(&stderr)
+	cmd.SetOut(&bytes.Buffer{})
+	cmd.SetArgs([]string{"HEAD"})
+	require.NoError(t, cmd.Execute())
+	assert.Contains(t, stderr.String(), `Good signature from "Test <test@example.com>"`)
+}
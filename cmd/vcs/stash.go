@@ -1,35 +1,81 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/history"
 	"github.com/fenilsonani/vcs/internal/core/index"
+	"github.com/fenilsonani/vcs/internal/core/merge"
 	"github.com/fenilsonani/vcs/internal/core/objects"
 	"github.com/fenilsonani/vcs/internal/core/refs"
 	"github.com/fenilsonani/vcs/pkg/vcs"
 )
 
+// stashRef holds the newest stash entry, and its reflog the whole list
+const stashRef = "refs/stash"
+
+// errStashConflict is returned when applying a stash leaves conflicts
+var errStashConflict = errors.New("conflicts in the stashed changes; resolve them and drop the entry")
+
+// stashPushOptions holds the flags of stash push
+type stashPushOptions struct {
+	message          string
+	keepIndex        bool
+	includeUntracked bool
+	patch            bool
+}
+
+// stashEntry is a stash commit W, whose tree is the stashed working tree.
+// Its first parent is the commit the stash was made on, its second records
+// the index and its third, if any, the untracked files, as Git has them.
 type stashEntry struct {
-	ID       objects.ObjectID
-	Message  string
-	Branch   string
-	Parent   objects.ObjectID
-	Tree     objects.ObjectID
-	Date     time.Time
+	// name is how the entry was named, such as "refs/stash@{0}"
+	name string
+	// position is where the entry is in the stash list, -1 when the
+	// commit was named otherwise
+	position      int
+	commit        *objects.Commit
+	base          *objects.Commit
+	indexTree     objects.ObjectID
+	untrackedTree objects.ObjectID
 }
 
 func newStashCommand() *cobra.Command {
+	var opts stashPushOptions
 	cmd := &cobra.Command{
 		Use:   "stash",
 		Short: "Stash the changes in a dirty working directory away",
-		Long:  `Use git stash when you want to record the current state of the working directory and the index, but want to go back to a clean working directory.`,
-		RunE:  runStashSave,
+		Long: `Records the changes of the working directory and the index in a stash
+entry and takes them out of the working directory, going back to HEAD.
+"vcs stash" alone is "vcs stash push".
+
+Each entry is a commit on refs/stash, made as Git makes it: its tree is
+the working tree, its first parent the commit it was made on, its second
+a commit of the index and, with -u, its third a commit of the untracked
+files. The stash list is the reflog of refs/stash, so stash@{0} is the
+newest entry, and stashes can be shared with Git.
+
+pop and apply take the changes of an entry back to the working tree,
+with --index to the index too; pop then drops the entry unless applying
+it conflicted. branch checks out a new branch at the commit an entry was
+made on and pops it there.`,
+		Args: cobra.ArbitraryArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runStashPush(cmd, args, opts)
+		},
 	}
+	addStashPushFlags(cmd, &opts)
 
 	// Subcommands
 	cmd.AddCommand(
@@ -37,6 +83,7 @@ func newStashCommand() *cobra.Command {
 		newStashShowCommand(),
 		newStashPopCommand(),
 		newStashApplyCommand(),
+		newStashBranchCommand(),
 		newStashDropCommand(),
 		newStashClearCommand(),
 		newStashPushCommand(),
@@ -45,79 +92,96 @@ func newStashCommand() *cobra.Command {
 	return cmd
 }
 
-func newStashPushCommand() *cobra.Command {
-	var (
-		message      string
-		keepIndex    bool
-		includeUntracked bool
-	)
+// addStashPushFlags adds the flags of stash push, which stash alone takes
+// too
+func addStashPushFlags(cmd *cobra.Command, opts *stashPushOptions) {
+	cmd.Flags().StringVarP(&opts.message, "message", "m", "", "Stash message")
+	cmd.Flags().BoolVarP(&opts.keepIndex, "keep-index", "k", false, "Keep changes in the index")
+	cmd.Flags().BoolVarP(&opts.includeUntracked, "include-untracked", "u", false, "Include untracked files")
+	cmd.Flags().BoolVarP(&opts.patch, "patch", "p", false, "Interactively choose hunks of changes to stash")
+}
 
+func newStashPushCommand() *cobra.Command {
+	var opts stashPushOptions
 	cmd := &cobra.Command{
 		Use:   "push [-m <message>] [--] [<pathspec>...]",
 		Short: "Save your local modifications to a new stash entry",
+		Long: `Saves the changes of the index and of the tracked files the pathspecs
+cover, or of all of them, in a new stash entry and resets those files to
+HEAD. --keep-index leaves the staged changes in the index and working
+tree, and -u stashes and removes the untracked files too.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			return runStashSave(cmd, args)
+			return runStashPush(cmd, args, opts)
 		},
 	}
-
-	cmd.Flags().StringVarP(&message, "message", "m", "", "Stash message")
-	cmd.Flags().BoolVarP(&keepIndex, "keep-index", "k", false, "Keep changes in the index")
-	cmd.Flags().BoolVarP(&includeUntracked, "include-untracked", "u", false, "Include untracked files")
-
+	addStashPushFlags(cmd, &opts)
 	return cmd
 }
 
 func newStashListCommand() *cobra.Command {
-	return &cobra.Command{
+	cmd := &cobra.Command{
 		Use:   "list",
 		Short: "List the stash entries",
+		Long: `Lists the stash entries, newest first. --json, or --format=json, lists
+them as JSON, each with its stash@{<n>} name, the branch it was made on,
+its message and when it was made.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return runStashList(cmd)
 		},
 	}
+	addJSONFlags(cmd)
+	return cmd
 }
 
 func newStashShowCommand() *cobra.Command {
-	return &cobra.Command{
+	cmd := &cobra.Command{
 		Use:   "show [<stash>]",
 		Short: "Show the changes recorded in the stash entry",
-		Args:  cobra.MaximumNArgs(1),
+		Long: `Shows the changes of a stash entry to the commit it was made on, as a
+diffstat or with -p as a patch.`,
+		Args: cobra.MaximumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			stashRef := "stash@{0}"
-			if len(args) > 0 {
-				stashRef = args[0]
-			}
-			return runStashShow(cmd, stashRef)
+			return runStashShow(cmd, stashArg(args))
 		},
 	}
+	cmd.Flags().BoolP("patch", "p", false, "Show the changes as a patch")
+	cmd.Flags().Bool("stat", false, "Show a diffstat of the changes")
+	return cmd
 }
 
 func newStashPopCommand() *cobra.Command {
-	return &cobra.Command{
+	cmd := &cobra.Command{
 		Use:   "pop [<stash>]",
 		Short: "Apply a stash entry and remove it from the stash list",
 		Args:  cobra.MaximumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			stashRef := "stash@{0}"
-			if len(args) > 0 {
-				stashRef = args[0]
-			}
-			return runStashPop(cmd, stashRef)
+			return runStashPop(cmd, stashArg(args))
 		},
 	}
+	cmd.Flags().Bool("index", false, "Restore the changes of the index too")
+	return cmd
 }
 
 func newStashApplyCommand() *cobra.Command {
-	return &cobra.Command{
+	cmd := &cobra.Command{
 		Use:   "apply [<stash>]",
 		Short: "Apply a stash entry on top of the current working tree",
 		Args:  cobra.MaximumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			stashRef := "stash@{0}"
-			if len(args) > 0 {
-				stashRef = args[0]
-			}
-			return runStashApply(cmd, stashRef)
+			return runStashApply(cmd, stashArg(args))
+		},
+	}
+	cmd.Flags().Bool("index", false, "Restore the changes of the index too")
+	return cmd
+}
+
+func newStashBranchCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "branch <branch> [<stash>]",
+		Short: "Create a branch at the commit a stash entry was made on and pop it there",
+		Args:  cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runStashBranch(cmd, args[0], stashArg(args[1:]))
 		},
 	}
 }
@@ -128,11 +192,7 @@ func newStashDropCommand() *cobra.Command {
 		Short: "Remove a single stash entry from the list",
 		Args:  cobra.MaximumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			stashRef := "stash@{0}"
-			if len(args) > 0 {
-				stashRef = args[0]
-			}
-			return runStashDrop(cmd, stashRef)
+			return runStashDrop(cmd, stashArg(args))
 		},
 	}
 }
@@ -147,185 +207,804 @@ func newStashClearCommand() *cobra.Command {
 	}
 }
 
-func runStashSave(cmd *cobra.Command, args []string) error {
-	// Find repository
+// stashArg returns the stash named by args, "" for the newest
+func stashArg(args []string) string {
+	if len(args) > 0 {
+		return args[0]
+	}
+	return ""
+}
+
+func runStashPush(cmd *cobra.Command, args []string, opts stashPushOptions) error {
 	repoPath, err := findRepository()
 	if err != nil {
 		return fmt.Errorf("not a git repository: %w", err)
 	}
-
-	// Open repository
-	repo, err := vcs.Open(repoPath)
+	repo, err := openRepository(repoPath)
 	if err != nil {
 		return fmt.Errorf("failed to open repository: %w", err)
 	}
 
-	// Check if there are changes to stash
-	hasChanges, err := hasLocalChanges(repo)
-	if err != nil {
-		return fmt.Errorf("failed to check for changes: %w", err)
+	if opts.patch {
+		return stashHunks(cmd, repo, opts.message, args)
 	}
 
-	if !hasChanges {
+	stashID, message, err := saveStash(repo, refs.NewRefManager(repo.GitDir()), args, opts, true)
+	if err != nil {
+		return err
+	}
+	if stashID.IsZero() {
 		fmt.Fprintln(cmd.OutOrStdout(), "No local changes to save")
 		return nil
 	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Saved working directory and index state %s\n", message)
+	return nil
+}
 
-	// Get current branch
-	refManager := refs.NewRefManager(repo.GitDir())
-	currentBranch, _ := refManager.CurrentBranch()
-	if currentBranch == "" {
-		currentBranch = "HEAD"
+// saveStash makes a stash entry of the changes of the index, of the
+// tracked files args cover and, with opts.includeUntracked, of the
+// untracked files, and takes them out of the working tree. The entry is
+// added to the stash list when store is set. It returns the stash commit
+// and its message, or a zero ID when there is nothing to stash.
+func saveStash(repo *vcs.Repository, refManager *refs.RefManager, args []string, opts stashPushOptions, store bool) (objects.ObjectID, string, error) {
+	headID, _, err := refManager.HEAD()
+	if err != nil || headID.IsZero() {
+		return objects.ObjectID{}, "", fmt.Errorf("you do not have the initial commit yet")
+	}
+	head, err := repo.GetCommit(headID)
+	if err != nil {
+		return objects.ObjectID{}, "", fmt.Errorf("failed to get HEAD commit: %w", err)
+	}
+	headEntries, err := merge.ReadTree(repo, head.Tree())
+	if err != nil {
+		return objects.ObjectID{}, "", fmt.Errorf("failed to read tree: %w", err)
+	}
+	headFiles := make(map[string]merge.Entry, len(headEntries))
+	for _, entry := range headEntries {
+		headFiles[entry.Path] = entry
 	}
 
-	// Get current commit
-	currentCommitID, _, err := refManager.HEAD()
+	idx, err := readIndex(repo)
 	if err != nil {
-		return fmt.Errorf("failed to get HEAD: %w", err)
+		return objects.ObjectID{}, "", err
+	}
+	indexTree, err := writeIndexTree(repo, idx)
+	if err != nil {
+		return objects.ObjectID{}, "", fmt.Errorf("cannot save the current index state: %w", err)
 	}
 
-	// Create stash message
-	message := fmt.Sprintf("WIP on %s: %s", currentBranch, currentCommitID.String()[:7])
-	
-	// In a real implementation, we would:
-	// 1. Create a tree object with current working tree state
-	// 2. Create a commit object with that tree
-	// 3. Store it in refs/stash
-	// 4. Update the stash reflog
-	// 5. Reset working tree to HEAD state
+	// The pathspecs cover files of the index and files deleted from it
+	tracked := trackedPaths(idx)
+	for p := range headFiles {
+		if _, ok := idx.Get(p); !ok {
+			tracked = append(tracked, p)
+		}
+	}
+	sort.Strings(tracked)
+	paths, err := patchPaths(repo, tracked, args)
+	if err != nil {
+		return objects.ObjectID{}, "", err
+	}
+	matched := make(map[string]bool, len(paths))
+	for _, p := range paths {
+		matched[p] = true
+	}
 
-	// For now, create a simple stash structure
-	stashDir := filepath.Join(repo.GitDir(), "stash")
-	if err := ensureDir(stashDir); err != nil {
-		return fmt.Errorf("failed to create stash directory: %w", err)
+	workTree, err := stashWorkTree(repo, idx, matched)
+	if err != nil {
+		return objects.ObjectID{}, "", err
+	}
+	var untracked []string
+	var untrackedTree objects.ObjectID
+	if opts.includeUntracked {
+		if untracked, err = untrackedFiles(repo, idx, args); err != nil {
+			return objects.ObjectID{}, "", err
+		}
+		if len(untracked) > 0 {
+			if untrackedTree, err = writeWorkFilesTree(repo, untracked); err != nil {
+				return objects.ObjectID{}, "", err
+			}
+		}
+	}
+	if indexTree == head.Tree() && workTree == head.Tree() && untrackedTree.IsZero() {
+		return objects.ObjectID{}, "", nil
 	}
 
-	// Save stash metadata
-	stashFile := filepath.Join(stashDir, "stash_list")
-	stashEntry := fmt.Sprintf("%s %s %s\n", time.Now().Format(time.RFC3339), currentBranch, message)
-	if err := appendToFile(stashFile, []byte(stashEntry)); err != nil {
-		return fmt.Errorf("failed to save stash: %w", err)
+	stashID, message, err := createStash(repo, refManager, head, indexTree, workTree, untrackedTree, opts.message)
+	if err != nil {
+		return objects.ObjectID{}, "", err
+	}
+	if store {
+		if err := storeStash(refManager, stashID, message); err != nil {
+			return objects.ObjectID{}, "", err
+		}
 	}
 
-	fmt.Fprintf(cmd.OutOrStdout(), "Saved working directory and index state %s\n", message)
-	fmt.Fprintln(cmd.OutOrStdout(), "\nNote: This is a basic stash implementation.")
-	fmt.Fprintln(cmd.OutOrStdout(), "Full implementation would:")
-	fmt.Fprintln(cmd.OutOrStdout(), "  - Create tree objects for working directory and index")
-	fmt.Fprintln(cmd.OutOrStdout(), "  - Create stash commits")
-	fmt.Fprintln(cmd.OutOrStdout(), "  - Reset working directory")
-	fmt.Fprintln(cmd.OutOrStdout(), "  - Maintain stash reflog")
+	// Take the stashed changes out: the covered files go back to HEAD, or
+	// with --keep-index to the index
+	workEntries, err := merge.ReadTree(repo, workTree)
+	if err != nil {
+		return objects.ObjectID{}, "", fmt.Errorf("failed to read tree: %w", err)
+	}
+	var target []merge.Entry
+	for _, entry := range workEntries {
+		if !matched[entry.Path] {
+			target = append(target, entry)
+		}
+	}
+	for _, p := range paths {
+		if opts.keepIndex {
+			if entry, ok := idx.Get(p); ok {
+				target = append(target, merge.Entry{Path: p, Mode: entry.Mode, ID: entry.ID})
+			}
+		} else if entry, ok := headFiles[p]; ok {
+			target = append(target, entry)
+		}
+	}
+	if err := checkoutMergeResult(repo, workTree, &merge.Result{Entries: target}); err != nil {
+		return objects.ObjectID{}, "", fmt.Errorf("failed to reset working directory: %w", err)
+	}
+	for _, p := range untracked {
+		full := filepath.Join(repo.WorkDir(), filepath.FromSlash(p))
+		if err := os.Remove(full); err != nil && !os.IsNotExist(err) {
+			return objects.ObjectID{}, "", fmt.Errorf("failed to remove %s: %w", p, err)
+		}
+		removeEmptyParents(repo.WorkDir(), filepath.Dir(full))
+	}
+
+	if !opts.keepIndex {
+		for _, p := range paths {
+			entry, inIndex := idx.Get(p)
+			committed, inHead := headFiles[p]
+			switch {
+			case !inHead:
+				if inIndex {
+					idx.Remove(p)
+				}
+			case !inIndex || entry.ID != committed.ID || entry.Mode != committed.Mode:
+				if err := idx.Add(&index.Entry{Mode: committed.Mode, ID: committed.ID, Path: p}); err != nil {
+					return objects.ObjectID{}, "", fmt.Errorf("failed to add %s to index: %w", p, err)
+				}
+			}
+		}
+		if err := idx.WriteToFile(filepath.Join(repo.GitDir(), "index")); err != nil {
+			return objects.ObjectID{}, "", fmt.Errorf("failed to write index: %w", err)
+		}
+	}
+
+	return stashID, message, nil
+}
+
+// stashWorkTree writes the tree of the files of idx with the working tree
+// content of those in matched, leaving out the matched files deleted from
+// the working tree
+func stashWorkTree(repo *vcs.Repository, idx *index.Index, matched map[string]bool) (objects.ObjectID, error) {
+	conv := newConverter(repo, io.Discard, nil)
+	hashes := newStatCache(repo, conv, readStatusCache(repo), nil, time.Now())
+	scanner := newScanner(repo)
+
+	var entries []merge.Entry
+	var jobs []hashJob
+	// changed holds the position in entries of the file each job hashes
+	var changed []int
+	for _, entry := range idx.Entries() {
+		file := merge.Entry{Path: entry.Path, Mode: entry.Mode, ID: entry.ID}
+		if !matched[entry.Path] || entry.Mode == objects.ModeCommit {
+			entries = append(entries, file)
+			continue
+		}
+		state := entryWorkTreeState(repo, hashes, entry)
+		if state == workTreeDeleted {
+			continue
+		}
+		full := filepath.Join(repo.WorkDir(), filepath.FromSlash(entry.Path))
+		if entry.Mode == objects.ModeSymlink {
+			if target, err := os.Readlink(full); err == nil {
+				if state == workTreeModified {
+					blob, err := repo.CreateBlob([]byte(target))
+					if err != nil {
+						return objects.ObjectID{}, fmt.Errorf("failed to write blob for %s: %w", entry.Path, err)
+					}
+					file.ID = blob.ID()
+				}
+				entries = append(entries, file)
+				continue
+			}
+		}
+		if mode, err := scanner.GetFileMode(entry.Path); err == nil {
+			file.Mode = mode
+		}
+		if state == workTreeModified || file.Mode != entry.Mode {
+			changed = append(changed, len(entries))
+			jobs = append(jobs, hashJob{file: full, path: entry.Path})
+		}
+		entries = append(entries, file)
+	}
+
+	err := hashFiles(repo, conv, jobs, hashThreads(repo.GitDir()), true, func(i int, id objects.ObjectID) error {
+		entries[changed[i]].ID = id
+		return nil
+	})
+	if err != nil {
+		return objects.ObjectID{}, err
+	}
+	treeID, err := merge.WriteTree(repo, entries)
+	if err != nil {
+		return objects.ObjectID{}, fmt.Errorf("failed to write tree: %w", err)
+	}
+	return treeID, nil
+}
+
+// untrackedFiles returns the files of the working tree that are neither in
+// idx nor ignored, those args cover when there are any
+func untrackedFiles(repo *vcs.Repository, idx *index.Index, args []string) ([]string, error) {
+	scanner := newScanner(repo)
+	files, err := scanner.ScanWorkingDirectory()
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan working directory: %w", err)
+	}
+	var paths []string
+	for _, file := range files {
+		if file.IsDir {
+			continue
+		}
+		if _, ok := idx.Get(file.Path); ok || scanner.IsIgnored(file.Path) {
+			continue
+		}
+		paths = append(paths, file.Path)
+	}
+	return patchPaths(repo, paths, args)
+}
+
+// writeWorkFilesTree writes the blobs of the working tree files paths and
+// a tree of them
+func writeWorkFilesTree(repo *vcs.Repository, paths []string) (objects.ObjectID, error) {
+	scanner := newScanner(repo)
+	entries := make([]merge.Entry, len(paths))
+	jobs := make([]hashJob, len(paths))
+	for i, p := range paths {
+		mode, err := scanner.GetFileMode(p)
+		if err != nil {
+			return objects.ObjectID{}, fmt.Errorf("failed to get file mode for %s: %w", p, err)
+		}
+		entries[i] = merge.Entry{Path: p, Mode: mode}
+		jobs[i] = hashJob{file: filepath.Join(repo.WorkDir(), filepath.FromSlash(p)), path: p}
+	}
+	conv := newConverter(repo, io.Discard, nil)
+	err := hashFiles(repo, conv, jobs, hashThreads(repo.GitDir()), true, func(i int, id objects.ObjectID) error {
+		entries[i].ID = id
+		return nil
+	})
+	if err != nil {
+		return objects.ObjectID{}, err
+	}
+	treeID, err := merge.WriteTree(repo, entries)
+	if err != nil {
+		return objects.ObjectID{}, fmt.Errorf("failed to write tree: %w", err)
+	}
+	return treeID, nil
+}
+
+// createStash makes the commits of a stash entry on head and returns the
+// stash commit with the message it is listed with: message on the current
+// branch, or "WIP on" the branch and head when it is empty
+func createStash(repo *vcs.Repository, refManager *refs.RefManager, head *objects.Commit, indexTree, workTree, untrackedTree objects.ObjectID, message string) (objects.ObjectID, string, error) {
+	branch, _ := refManager.CurrentBranch()
+	if branch == "" {
+		branch = "(no branch)"
+	}
+	onto := fmt.Sprintf("%s: %s %s", branch, head.ID().Short(), commitSubject(head))
+	sig, err := getSignature("")
+	if err != nil {
+		return objects.ObjectID{}, "", err
+	}
+
+	indexCommit, err := repo.CreateCommit(indexTree, []objects.ObjectID{head.ID()}, sig, sig, "index on "+onto+"\n")
+	if err != nil {
+		return objects.ObjectID{}, "", fmt.Errorf("failed to create the index commit: %w", err)
+	}
+	parents := []objects.ObjectID{head.ID(), indexCommit.ID()}
+	if !untrackedTree.IsZero() {
+		untracked, err := repo.CreateCommit(untrackedTree, nil, sig, sig, "untracked files on "+onto+"\n")
+		if err != nil {
+			return objects.ObjectID{}, "", fmt.Errorf("failed to create the untracked files commit: %w", err)
+		}
+		parents = append(parents, untracked.ID())
+	}
+
+	if message == "" {
+		message = "WIP on " + onto
+	} else {
+		message = fmt.Sprintf("On %s: %s", branch, message)
+	}
+	stash, err := repo.CreateCommit(workTree, parents, sig, sig, message+"\n")
+	if err != nil {
+		return objects.ObjectID{}, "", fmt.Errorf("failed to create stash commit: %w", err)
+	}
+	return stash.ID(), message, nil
+}
 
+// storeStash makes id the newest stash entry, listed with message
+func storeStash(refManager *refs.RefManager, id objects.ObjectID, message string) error {
+	oldID, _ := refManager.ResolveRef(stashRef)
+	if err := refManager.UpdateRef(stashRef, id); err != nil {
+		return fmt.Errorf("failed to update %s: %w", stashRef, err)
+	}
+	// Every entry is logged, even one stashing the same changes again
+	who, err := getSignature("")
+	if err != nil {
+		return err
+	}
+	if err := refManager.LogUpdate(stashRef, oldID, id, who, message); err != nil {
+		return fmt.Errorf("failed to record the stash entry: %w", err)
+	}
 	return nil
 }
 
+// stashPosition matches the names of stash list entries
+var stashPosition = regexp.MustCompile(`^(?:refs/)?stash@\{(\d+)\}$`)
+
+// readStash returns the stash entry rev names: the newest when rev is
+// empty, stash@{<n>} or <n> alone for the nth newest, or any other
+// revision of a stash commit
+func readStash(repo *vcs.Repository, refManager *refs.RefManager, rev string) (*stashEntry, error) {
+	entry := &stashEntry{name: rev, position: -1}
+	var id objects.ObjectID
+	position := -1
+	switch m := stashPosition.FindStringSubmatch(rev); {
+	case rev == "":
+		position, entry.name = 0, stashRef+"@{0}"
+	case m != nil:
+		position, _ = strconv.Atoi(m[1])
+	default:
+		if n, err := strconv.Atoi(rev); err == nil && n >= 0 {
+			position, entry.name = n, fmt.Sprintf("%s@{%d}", stashRef, n)
+		}
+	}
+
+	if position >= 0 {
+		listed, err := refManager.ReadReflog(stashRef)
+		if err != nil {
+			return nil, err
+		}
+		if len(listed) == 0 {
+			return nil, fmt.Errorf("no stash entries found")
+		}
+		if position >= len(listed) {
+			return nil, fmt.Errorf("%s is not a valid reference", entry.name)
+		}
+		id = listed[len(listed)-1-position].New
+		entry.position = position
+	} else {
+		var err error
+		if id, err = newResolver(repo).ResolveCommit(rev); err != nil {
+			return nil, fmt.Errorf("%s is not a valid reference", rev)
+		}
+	}
+
+	if err := loadStashEntry(repo, entry, id); err != nil {
+		return nil, err
+	}
+	return entry, nil
+}
+
+// loadStashEntry reads the stash commit id and the commits it records into
+// entry
+func loadStashEntry(repo *vcs.Repository, entry *stashEntry, id objects.ObjectID) error {
+	commit, err := repo.GetCommit(id)
+	if err != nil {
+		return fmt.Errorf("failed to read stash commit %s: %w", id.Short(), err)
+	}
+	parents := commit.Parents()
+	if len(parents) < 2 || len(parents) > 3 {
+		return fmt.Errorf("'%s' is not a stash-like commit", entry.name)
+	}
+	entry.commit = commit
+	if entry.base, err = repo.GetCommit(parents[0]); err != nil {
+		return fmt.Errorf("failed to read commit %s: %w", parents[0].Short(), err)
+	}
+	indexCommit, err := repo.GetCommit(parents[1])
+	if err != nil {
+		return fmt.Errorf("failed to read commit %s: %w", parents[1].Short(), err)
+	}
+	entry.indexTree = indexCommit.Tree()
+	if len(parents) == 3 {
+		untracked, err := repo.GetCommit(parents[2])
+		if err != nil {
+			return fmt.Errorf("failed to read commit %s: %w", parents[2].Short(), err)
+		}
+		entry.untrackedTree = untracked.Tree()
+	}
+	return nil
+}
+
+// openStash opens the repository and reads the stash entry rev names
+func openStash(rev string) (*vcs.Repository, *refs.RefManager, *stashEntry, error) {
+	repoPath, err := findRepository()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("not a git repository: %w", err)
+	}
+	repo, err := openRepository(repoPath)
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("failed to open repository: %w", err)
+	}
+	refManager := refs.NewRefManager(repo.GitDir())
+	entry, err := readStash(repo, refManager, rev)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return repo, refManager, entry, nil
+}
+
 func runStashList(cmd *cobra.Command) error {
-	// Find repository
 	repoPath, err := findRepository()
 	if err != nil {
 		return fmt.Errorf("not a git repository: %w", err)
 	}
-
-	// Open repository
-	repo, err := vcs.Open(repoPath)
+	repo, err := openRepository(repoPath)
 	if err != nil {
 		return fmt.Errorf("failed to open repository: %w", err)
 	}
 
-	// Read stash list
-	stashFile := filepath.Join(repo.GitDir(), "stash", "stash_list")
-	if !fileExists(stashFile) {
-		return nil // No stashes
+	asJSON, err := jsonRequested(cmd)
+	if err != nil {
+		return err
 	}
 
-	data, err := readFile(stashFile)
+	listed, err := refs.NewRefManager(repo.GitDir()).ReadReflog(stashRef)
 	if err != nil {
-		return fmt.Errorf("failed to read stash list: %w", err)
+		return err
 	}
-
-	// Display stashes
-	lines := strings.Split(string(data), "\n")
-	for i, line := range lines {
-		if line == "" {
+	stashes := []jsonStash{}
+	for i := len(listed) - 1; i >= 0; i-- {
+		n := len(listed) - 1 - i
+		entry := listed[i]
+		if asJSON {
+			stashes = append(stashes, jsonStash{
+				Index:   n,
+				Ref:     fmt.Sprintf("stash@{%d}", n),
+				Branch:  stashBranch(entry.Message),
+				Message: entry.Message,
+				Date:    entry.Who.When.Format(time.RFC3339),
+			})
 			continue
 		}
-		parts := strings.SplitN(line, " ", 3)
-		if len(parts) >= 3 {
-			fmt.Fprintf(cmd.OutOrStdout(), "stash@{%d}: %s\n", i, parts[2])
-		}
+		fmt.Fprintf(cmd.OutOrStdout(), "stash@{%d}: %s\n", n, entry.Message)
 	}
 
+	if asJSON {
+		return writeJSON(cmd.OutOrStdout(), stashes)
+	}
 	return nil
 }
 
-func runStashShow(cmd *cobra.Command, stashRef string) error {
-	fmt.Fprintf(cmd.OutOrStdout(), "Showing stash %s\n", stashRef)
-	fmt.Fprintln(cmd.OutOrStdout(), "Note: Full stash show would display the diff of the stashed changes")
+// stashBranch returns the branch a stash entry listed with message was
+// made on
+func stashBranch(message string) string {
+	for _, prefix := range []string{"WIP on ", "On "} {
+		if rest, ok := strings.CutPrefix(message, prefix); ok {
+			if colon := strings.Index(rest, ": "); colon >= 0 {
+				return rest[:colon]
+			}
+		}
+	}
+	return ""
+}
+
+func runStashShow(cmd *cobra.Command, rev string) error {
+	repo, _, entry, err := openStash(rev)
+	if err != nil {
+		return err
+	}
+	patch, _ := cmd.Flags().GetBool("patch")
+	stat, _ := cmd.Flags().GetBool("stat")
+
+	diffs, err := treeFileDiffs(repo, newConverter(repo, io.Discard, nil), entry.base.Tree(), entry.commit.Tree(),
+		history.Detection{Renames: history.DefaultRenameThreshold})
+	if err != nil {
+		return err
+	}
+	out := cmd.OutOrStdout()
+	if stat || !patch {
+		writeDiffStat(out, diffs)
+	}
+	if patch {
+		for _, diff := range diffs {
+			writeFileDiff(out, diff, false)
+		}
+	}
 	return nil
 }
 
-func runStashPop(cmd *cobra.Command, stashRef string) error {
-	// Apply the stash
-	if err := runStashApply(cmd, stashRef); err != nil {
+func runStashApply(cmd *cobra.Command, rev string) error {
+	repo, _, entry, err := openStash(rev)
+	if err != nil {
+		return err
+	}
+	restoreIndex, _ := cmd.Flags().GetBool("index")
+	return applyStash(cmd.OutOrStdout(), repo, entry, restoreIndex)
+}
+
+func runStashPop(cmd *cobra.Command, rev string) error {
+	repo, refManager, entry, err := openStash(rev)
+	if err != nil {
+		return err
+	}
+	if entry.position < 0 {
+		return fmt.Errorf("'%s' is not a stash reference", entry.name)
+	}
+	restoreIndex, _ := cmd.Flags().GetBool("index")
+	if err := applyStash(cmd.OutOrStdout(), repo, entry, restoreIndex); err != nil {
+		if errors.Is(err, errStashConflict) {
+			fmt.Fprintln(cmd.OutOrStdout(), "The stash entry is kept in case you need it again.")
+		}
+		return err
+	}
+	return dropStash(cmd.OutOrStdout(), refManager, entry)
+}
+
+func runStashBranch(cmd *cobra.Command, branch, rev string) error {
+	repo, refManager, entry, err := openStash(rev)
+	if err != nil {
+		return err
+	}
+	if !refManager.IsValidRef("refs/heads/" + branch) {
+		return fmt.Errorf("invalid branch name: %s", branch)
+	}
+	if refManager.RefExists("refs/heads/" + branch) {
+		return fmt.Errorf("a branch named '%s' already exists", branch)
+	}
+
+	base := entry.base.ID()
+	if err := refManager.CreateBranch(branch, base); err != nil {
+		return fmt.Errorf("failed to create branch: %w", err)
+	}
+	logRefUpdate(refManager, "refs/heads/"+branch, objects.ObjectID{}, base, "branch: Created from "+base.String())
+	if err := checkoutCommit(cmd, repo, refManager, repo.WorkDir(), base, branch, false); err != nil {
+		refManager.DeleteBranch(branch)
 		return err
 	}
 
-	// Then drop it
-	return runStashDrop(cmd, stashRef)
+	if err := applyStash(cmd.OutOrStdout(), repo, entry, true); err != nil {
+		return err
+	}
+	if entry.position < 0 {
+		return nil
+	}
+	return dropStash(cmd.OutOrStdout(), refManager, entry)
 }
 
-func runStashApply(cmd *cobra.Command, stashRef string) error {
-	fmt.Fprintf(cmd.OutOrStdout(), "Applying stash %s\n", stashRef)
-	fmt.Fprintln(cmd.OutOrStdout(), "Note: Full stash apply would:")
-	fmt.Fprintln(cmd.OutOrStdout(), "  - Retrieve stashed tree objects")
-	fmt.Fprintln(cmd.OutOrStdout(), "  - Apply changes to working directory")
-	fmt.Fprintln(cmd.OutOrStdout(), "  - Handle conflicts if any")
+// applyStash brings the changes of entry to the working tree, merging them
+// with the files of the index, and with restoreIndex its staged changes to
+// the index too. Otherwise only the files it adds are staged.
+func applyStash(out io.Writer, repo *vcs.Repository, entry *stashEntry, restoreIndex bool) error {
+	idx, err := readIndex(repo)
+	if err != nil {
+		return err
+	}
+	if len(idx.Unmerged()) > 0 {
+		return fmt.Errorf("cannot apply a stash in the middle of a merge")
+	}
+	current, err := writeIndexTree(repo, idx)
+	if err != nil {
+		return err
+	}
+	baseTree := entry.base.Tree()
+
+	indexTree := current
+	if restoreIndex && entry.indexTree != baseTree {
+		staged, err := merge.Trees(repo, baseTree, current, entry.indexTree, merge.Options{})
+		if err != nil {
+			return fmt.Errorf("failed to merge the stashed index: %w", err)
+		}
+		if !staged.Clean() {
+			return fmt.Errorf("conflicts in index. Try without --index")
+		}
+		if indexTree, err = merge.WriteTree(repo, staged.Entries); err != nil {
+			return fmt.Errorf("failed to write tree: %w", err)
+		}
+	}
+
+	result, err := merge.Trees(repo, baseTree, current, entry.commit.Tree(), merge.Options{
+		OursLabel:   "Updated upstream",
+		TheirsLabel: "Stashed changes",
+	})
+	if err != nil {
+		return fmt.Errorf("failed to merge the stashed changes: %w", err)
+	}
+	if err := checkWorktreeOverwrites(repo, idx, current, result); err != nil {
+		return err
+	}
+
+	var untracked []merge.Entry
+	if !entry.untrackedTree.IsZero() {
+		if untracked, err = merge.ReadTree(repo, entry.untrackedTree); err != nil {
+			return fmt.Errorf("failed to read tree: %w", err)
+		}
+		for _, file := range untracked {
+			if _, err := os.Lstat(filepath.Join(repo.WorkDir(), filepath.FromSlash(file.Path))); err == nil {
+				return fmt.Errorf("%s already exists, no checkout; could not restore untracked files from stash", file.Path)
+			}
+		}
+	}
+
+	if err := checkoutMergeResult(repo, current, result); err != nil {
+		return fmt.Errorf("failed to update working directory: %w", err)
+	}
+	conv := newConverter(repo, os.Stderr, nil)
+	for _, file := range untracked {
+		blob, err := repo.GetBlob(file.ID)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", file.Path, err)
+		}
+		if err := writeWorkingFile(repo, conv, file.Path, file.Mode, blob.Data()); err != nil {
+			return err
+		}
+	}
+
+	if !result.Clean() {
+		if err := writeMergeIndex(repo, result); err != nil {
+			return err
+		}
+		printMergeConflicts(out, result.Conflicts, "Stashed changes")
+		return errStashConflict
+	}
+
+	if restoreIndex {
+		staged, err := merge.ReadTree(repo, indexTree)
+		if err != nil {
+			return fmt.Errorf("failed to read tree: %w", err)
+		}
+		// Entries that stay the same keep their stat data
+		restored := index.New()
+		for _, file := range staged {
+			if old, ok := idx.Get(file.Path); ok && old.ID == file.ID && old.Mode == file.Mode {
+				err = restored.Add(old)
+			} else {
+				err = restored.Add(&index.Entry{Mode: file.Mode, ID: file.ID, Path: file.Path})
+			}
+			if err != nil {
+				return fmt.Errorf("failed to add %s to index: %w", file.Path, err)
+			}
+		}
+		idx = restored
+	} else {
+		for _, file := range result.Entries {
+			if _, ok := idx.Get(file.Path); ok {
+				continue
+			}
+			if err := idx.Add(&index.Entry{Mode: file.Mode, ID: file.ID, Path: file.Path}); err != nil {
+				return fmt.Errorf("failed to add %s to index: %w", file.Path, err)
+			}
+		}
+	}
+	if err := idx.WriteToFile(filepath.Join(repo.GitDir(), "index")); err != nil {
+		return fmt.Errorf("failed to write index: %w", err)
+	}
 	return nil
 }
 
-func runStashDrop(cmd *cobra.Command, stashRef string) error {
-	fmt.Fprintf(cmd.OutOrStdout(), "Dropped %s\n", stashRef)
-	fmt.Fprintln(cmd.OutOrStdout(), "Note: Full stash drop would remove the stash entry from refs/stash")
+// checkWorktreeOverwrites refuses a merge, such as that of a stash being
+// applied, whose result changes files of the current tree with local
+// changes, or untracked files
+func checkWorktreeOverwrites(repo *vcs.Repository, idx *index.Index, current objects.ObjectID, result *merge.Result) error {
+	entries, err := merge.ReadTree(repo, current)
+	if err != nil {
+		return fmt.Errorf("failed to read tree: %w", err)
+	}
+	unchanged := make(map[merge.Entry]bool, len(entries))
+	for _, entry := range entries {
+		unchanged[entry] = true
+	}
+	var paths []string
+	merged := make(map[string]bool, len(result.Entries))
+	for _, entry := range result.Entries {
+		merged[entry.Path] = true
+		if !unchanged[entry] {
+			paths = append(paths, entry.Path)
+		}
+	}
+	for _, c := range result.Conflicts {
+		merged[c.Path] = true
+		paths = append(paths, c.Path)
+	}
+	// Files the stash deletes are changed too
+	for _, entry := range entries {
+		if !merged[entry.Path] {
+			paths = append(paths, entry.Path)
+		}
+	}
+
+	hashes := newStatCache(repo, newConverter(repo, io.Discard, nil), readStatusCache(repo), nil, time.Now())
+	var local, untracked []string
+	for _, p := range paths {
+		if entry, ok := idx.Get(p); ok {
+			if entry.Mode != objects.ModeCommit && entryWorkTreeState(repo, hashes, entry) != workTreeUnchanged {
+				local = append(local, p)
+			}
+		} else if _, err := os.Lstat(filepath.Join(repo.WorkDir(), filepath.FromSlash(p))); err == nil {
+			untracked = append(untracked, p)
+		}
+	}
+	sort.Strings(local)
+	sort.Strings(untracked)
+	if len(local) > 0 {
+		return localChangesError(local)
+	}
+	if len(untracked) > 0 {
+		return fmt.Errorf("the following untracked working tree files would be overwritten by merge:\n\t%s", strings.Join(untracked, "\n\t"))
+	}
 	return nil
 }
 
-func runStashClear(cmd *cobra.Command) error {
-	// Find repository
-	repoPath, err := findRepository()
+// localChangesError is the error refusing a merge that would overwrite the
+// local changes to paths
+func localChangesError(paths []string) error {
+	return fmt.Errorf("your local changes to the following files would be overwritten by merge:\n\t%s\nPlease commit your changes or stash them before you merge", strings.Join(paths, "\n\t"))
+}
+
+func runStashDrop(cmd *cobra.Command, rev string) error {
+	_, refManager, entry, err := openStash(rev)
 	if err != nil {
-		return fmt.Errorf("not a git repository: %w", err)
+		return err
 	}
+	if entry.position < 0 {
+		return fmt.Errorf("'%s' is not a stash reference", entry.name)
+	}
+	return dropStash(cmd.OutOrStdout(), refManager, entry)
+}
 
-	// Open repository
-	repo, err := vcs.Open(repoPath)
+// dropStash removes entry from the stash list, moving refs/stash to the
+// newest entry left or deleting it with the last
+func dropStash(out io.Writer, refManager *refs.RefManager, entry *stashEntry) error {
+	listed, err := refManager.ReadReflog(stashRef)
 	if err != nil {
-		return fmt.Errorf("failed to open repository: %w", err)
+		return err
+	}
+	i := len(listed) - 1 - entry.position
+	if i < 0 || listed[i].New != entry.commit.ID() {
+		return fmt.Errorf("%s is not a valid reference", entry.name)
+	}
+	listed = append(listed[:i], listed[i+1:]...)
+	// The entry after the one dropped now follows the one before it
+	if i < len(listed) {
+		listed[i].Old = objects.ObjectID{}
+		if i > 0 {
+			listed[i].Old = listed[i-1].New
+		}
 	}
 
-	// Clear stash file
-	stashFile := filepath.Join(repo.GitDir(), "stash", "stash_list")
-	if fileExists(stashFile) {
-		if err := os.Remove(stashFile); err != nil {
-			return fmt.Errorf("failed to clear stash: %w", err)
+	if err := refManager.WriteReflog(stashRef, listed); err != nil {
+		return err
+	}
+	if len(listed) == 0 {
+		if err := refManager.DeleteRef(stashRef); err != nil {
+			return fmt.Errorf("failed to delete %s: %w", stashRef, err)
 		}
+	} else if err := refManager.UpdateRef(stashRef, listed[len(listed)-1].New); err != nil {
+		return fmt.Errorf("failed to update %s: %w", stashRef, err)
 	}
 
-	fmt.Fprintln(cmd.OutOrStdout(), "Cleared all stashes")
+	fmt.Fprintf(out, "Dropped %s (%s)\n", entry.name, entry.commit.ID())
 	return nil
 }
 
-func hasLocalChanges(repo *vcs.Repository) (bool, error) {
-	// Check index for staged changes
-	indexPath := filepath.Join(repo.GitDir(), "index")
-	if fileExists(indexPath) {
-		idx := index.New()
-		if err := idx.ReadFromFile(indexPath); err == nil && len(idx.Entries()) > 0 {
-			return true, nil
-		}
+func runStashClear(cmd *cobra.Command) error {
+	repoPath, err := findRepository()
+	if err != nil {
+		return fmt.Errorf("not a git repository: %w", err)
+	}
+	repo, err := openRepository(repoPath)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
 	}
 
-	// In a real implementation, we would also check:
-	// - Working tree changes
-	// - Untracked files (if --include-untracked)
-	
-	return false, nil
-}
\ No newline at end of file
+	refManager := refs.NewRefManager(repo.GitDir())
+	if err := refManager.DeleteRef(stashRef); err != nil {
+		return fmt.Errorf("failed to clear stash: %w", err)
+	}
+	if err := refManager.WriteReflog(stashRef, nil); err != nil {
+		return fmt.Errorf("failed to clear stash: %w", err)
+	}
+	return nil
+}
@@ -1,14 +1,16 @@
 package main
 
 import (
-	"bytes"
+	"fmt"
 	"os"
-	"path/filepath"
+	"os/exec"
 	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/refs"
 	"github.com/fenilsonani/vcs/pkg/vcs"
 )
 
@@ -21,9 +23,9 @@ func TestNewStashCommand(t *testing.T) {
 
 func TestStashSubcommands(t *testing.T) {
 	cmd := newStashCommand()
-	
+
 	// Check all subcommands are registered
-	subcommands := []string{"push", "list", "show", "pop", "apply", "drop", "clear"}
+	subcommands := []string{"push", "list", "show", "pop", "apply", "branch", "drop", "clear"}
 	for _, subcmd := range subcommands {
 		found := false
 		for _, c := range cmd.Commands() {
@@ -36,597 +38,226 @@ func TestStashSubcommands(t *testing.T) {
 	}
 }
 
-func TestStashSave(t *testing.T) {
-	tests := []struct {
-		name        string
-		args        []string
-		setupFunc   func(t *testing.T, repo *vcs.Repository, repoPath string)
-		expectError bool
-		checkFunc   func(t *testing.T, output string, repoPath string)
-	}{
-		{
-			name: "stash with changes",
-			args: []string{},
-			setupFunc: func(t *testing.T, repo *vcs.Repository, repoPath string) {
-				// Create a file and stage it
-				testFile := filepath.Join(repoPath, "modified.txt")
-				err := os.WriteFile(testFile, []byte("modified content"), 0644)
-				require.NoError(t, err)
-				testRepo := WrapRepository(repo, repoPath)
-				err = testRepo.Add("modified.txt")
-				require.NoError(t, err)
-			},
-			checkFunc: func(t *testing.T, output string, repoPath string) {
-				assert.Contains(t, output, "Saved working directory and index state")
-				assert.Contains(t, output, "WIP on main:")
-				
-				// Check stash directory was created
-				stashDir := filepath.Join(repoPath, ".git", "stash")
-				assert.DirExists(t, stashDir)
-				
-				// Check stash list file exists
-				stashFile := filepath.Join(stashDir, "stash_list")
-				assert.FileExists(t, stashFile)
-			},
-		},
-		{
-			name:      "stash without changes",
-			args:      []string{},
-			setupFunc: func(t *testing.T, repo *vcs.Repository, repoPath string) {},
-			checkFunc: func(t *testing.T, output string, repoPath string) {
-				assert.Contains(t, output, "No local changes to save")
-			},
-		},
-		{
-			name:        "stash outside repository",
-			args:        []string{},
-			setupFunc:   func(t *testing.T, repo *vcs.Repository, repoPath string) {},
-			expectError: true,
-		},
-	}
-
-	for _, tc := range tests {
-		t.Run(tc.name, func(t *testing.T) {
-			// Create temporary directory
-			tmpDir := t.TempDir()
-			
-			var repoPath string
-			var repo *vcs.Repository
-			
-			if tc.name != "stash outside repository" {
-				// Initialize repository
-				repoPath = filepath.Join(tmpDir, "test-repo")
-				var err error
-				repo, err = vcs.Init(repoPath)
-				require.NoError(t, err)
-				
-				// Make initial commit
-				testFile := filepath.Join(repoPath, "test.txt")
-				err = os.WriteFile(testFile, []byte("test content"), 0644)
-				require.NoError(t, err)
-				
-				testRepo := WrapRepository(repo, repoPath)
-				err = testRepo.Add("test.txt")
-				require.NoError(t, err)
-				
-				_, err = testRepo.Commit("Initial commit", "Test User", "test@example.com")
-				require.NoError(t, err)
-				
-				// Run setup function
-				if tc.setupFunc != nil {
-					tc.setupFunc(t, repo, repoPath)
-				}
-				
-				// Change to repo directory
-				err = os.Chdir(repoPath)
-				require.NoError(t, err)
-			} else {
-				// Stay in temp directory (outside repository)
-				err := os.Chdir(tmpDir)
-				require.NoError(t, err)
-			}
-			
-			// Create command
-			cmd := newStashCommand()
-			
-			// Capture output
-			var buf bytes.Buffer
-			cmd.SetOut(&buf)
-			cmd.SetErr(&buf)
-			
-			// Execute command
-			cmd.SetArgs(tc.args)
-			err := cmd.Execute()
-			
-			// Check error
-			if tc.expectError {
-				assert.Error(t, err)
-			} else {
-				assert.NoError(t, err)
-				
-				// Check output
-				output := buf.String()
-				if tc.checkFunc != nil {
-					tc.checkFunc(t, output, repoPath)
-				}
-			}
-		})
-	}
+// setupStashRepo commits a.txt and b.txt on main and checks them out
+func setupStashRepo(t *testing.T) (*vcs.Repository, *refs.RefManager) {
+	return setupSeriesRepo(t, map[string]string{"a.txt": "a\n", "b.txt": "b\n"})
 }
 
-func TestStashPush(t *testing.T) {
-	// Create temporary directory
-	tmpDir := t.TempDir()
-	repoPath := filepath.Join(tmpDir, "test-repo")
-	
-	// Initialize repository
-	repo, err := vcs.Init(repoPath)
-	require.NoError(t, err)
-	
-	// Make initial commit
-	testFile := filepath.Join(repoPath, "test.txt")
-	err = os.WriteFile(testFile, []byte("test content"), 0644)
-	require.NoError(t, err)
-	
-	testRepo := WrapRepository(repo, repoPath)
-				err = testRepo.Add("test.txt")
-	require.NoError(t, err)
-	
-	_, err = testRepo.Commit("Initial commit", "Test User", "test@example.com")
-	require.NoError(t, err)
-	
-	// Change to repo directory
-	err = os.Chdir(repoPath)
-	require.NoError(t, err)
-	
-	// Create changes
-	modifiedFile := filepath.Join(repoPath, "modified.txt")
-	err = os.WriteFile(modifiedFile, []byte("modified content"), 0644)
-	require.NoError(t, err)
-				err = testRepo.Add("modified.txt")
-	require.NoError(t, err)
-	
-	// Create stash push command
-	cmd := newStashPushCommand()
-	
-	// Test with message flag
-	err = cmd.Flags().Set("message", "My stash message")
-	require.NoError(t, err)
-	
-	// Capture output
-	var buf bytes.Buffer
-	cmd.SetOut(&buf)
-	
-	// Execute command
-	err = cmd.Execute()
-	assert.NoError(t, err)
-	
-	// Check output
-	output := buf.String()
-	assert.Contains(t, output, "Saved working directory")
+// runStash runs stash with args
+func runStash(args ...string) (string, error) {
+	return runCommandArgs(newStashCommand(), args...)
 }
 
-func TestStashList(t *testing.T) {
-	tests := []struct {
-		name        string
-		setupFunc   func(t *testing.T, repo *vcs.Repository, repoPath string)
-		expectError bool
-		checkFunc   func(t *testing.T, output string)
-	}{
-		{
-			name: "list with stashes",
-			setupFunc: func(t *testing.T, repo *vcs.Repository, repoPath string) {
-				// Create stash directory and list
-				stashDir := filepath.Join(repoPath, ".git", "stash")
-				err := os.MkdirAll(stashDir, 0755)
-				require.NoError(t, err)
-				
-				stashFile := filepath.Join(stashDir, "stash_list")
-				stashContent := `2024-01-01T10:00:00Z main WIP on main: abc1234
-2024-01-01T11:00:00Z feature WIP on feature: def5678
-2024-01-01T12:00:00Z main Stash before merge
-`
-				err = os.WriteFile(stashFile, []byte(stashContent), 0644)
-				require.NoError(t, err)
-			},
-			checkFunc: func(t *testing.T, output string) {
-				assert.Contains(t, output, "stash@{0}: Stash before merge")
-				assert.Contains(t, output, "stash@{1}: WIP on feature: def5678")
-				assert.Contains(t, output, "stash@{2}: WIP on main: abc1234")
-			},
-		},
-		{
-			name:      "list with no stashes",
-			setupFunc: func(t *testing.T, repo *vcs.Repository, repoPath string) {},
-			checkFunc: func(t *testing.T, output string) {
-				assert.Empty(t, strings.TrimSpace(output))
-			},
-		},
-	}
+func TestStashPushAndPop(t *testing.T) {
+	repo, refManager := setupStashRepo(t)
+	head, err := refManager.ResolveRef("refs/heads/main")
+	require.NoError(t, err)
 
-	for _, tc := range tests {
-		t.Run(tc.name, func(t *testing.T) {
-			// Create temporary directory
-			tmpDir := t.TempDir()
-			repoPath := filepath.Join(tmpDir, "test-repo")
-			
-			// Initialize repository
-			repo, err := vcs.Init(repoPath)
-			require.NoError(t, err)
-			
-			// Make initial commit
-			testFile := filepath.Join(repoPath, "test.txt")
-			err = os.WriteFile(testFile, []byte("test content"), 0644)
-			require.NoError(t, err)
-			
-			testRepo := WrapRepository(repo, repoPath)
-				err = testRepo.Add("test.txt")
-			require.NoError(t, err)
-			
-			_, err = testRepo.Commit("Initial commit", "Test User", "test@example.com")
-			require.NoError(t, err)
-			
-			// Run setup function
-			if tc.setupFunc != nil {
-				tc.setupFunc(t, repo, repoPath)
-			}
-			
-			// Change to repo directory
-			err = os.Chdir(repoPath)
-			require.NoError(t, err)
-			
-			// Create command
-			cmd := newStashCommand()
-			
-			// Capture output
-			var buf bytes.Buffer
-			cmd.SetOut(&buf)
-			
-			// Execute list subcommand
-			cmd.SetArgs([]string{"list"})
-			err = cmd.Execute()
-			
-			// Check error
-			if tc.expectError {
-				assert.Error(t, err)
-			} else {
-				assert.NoError(t, err)
-				
-				// Check output
-				if tc.checkFunc != nil {
-					tc.checkFunc(t, buf.String())
-				}
-			}
-		})
-	}
-}
+	require.NoError(t, os.WriteFile("a.txt", []byte("a changed\n"), 0644))
+	stageFile(t, "c.txt", "c\n")
+	require.NoError(t, os.WriteFile("u.txt", []byte("u\n"), 0644))
+
+	out, err := runStash("push", "-u", "-m", "work")
+	require.NoError(t, err)
+	assert.Equal(t, "Saved working directory and index state On main: work\n", out)
+	assert.Equal(t, "a\n", readWorkFile(t, "a.txt"))
+	assert.NoFileExists(t, "c.txt")
+	assert.NoFileExists(t, "u.txt")
+	assert.Equal(t, []string{"a.txt:0:a", "b.txt:0:b"}, stagedFiles(t, repo))
 
-func TestStashShow(t *testing.T) {
-	// Create temporary directory
-	tmpDir := t.TempDir()
-	repoPath := filepath.Join(tmpDir, "test-repo")
-	
-	// Initialize repository
-	repo, err := vcs.Init(repoPath)
+	// The stash commit is made as Git makes it
+	stashID, err := refManager.ResolveRef("refs/stash")
 	require.NoError(t, err)
-	
-	// Make initial commit
-	testFile := filepath.Join(repoPath, "test.txt")
-	err = os.WriteFile(testFile, []byte("test content"), 0644)
+	stash, err := repo.GetCommit(stashID)
 	require.NoError(t, err)
-	
-	testRepo := WrapRepository(repo, repoPath)
-				err = testRepo.Add("test.txt")
+	require.Len(t, stash.Parents(), 3)
+	assert.Equal(t, head, stash.Parents()[0])
+	assert.Equal(t, map[string]string{"a.txt": "a changed\n", "b.txt": "b\n", "c.txt": "c\n"}, readCommitFiles(t, repo, stashID))
+	assert.Equal(t, map[string]string{"a.txt": "a\n", "b.txt": "b\n", "c.txt": "c\n"}, readCommitFiles(t, repo, stash.Parents()[1]))
+	assert.Equal(t, map[string]string{"u.txt": "u\n"}, readCommitFiles(t, repo, stash.Parents()[2]))
+	indexCommit, err := repo.GetCommit(stash.Parents()[1])
 	require.NoError(t, err)
-	
-	_, err = testRepo.Commit("Initial commit", "Test User", "test@example.com")
+	assert.Equal(t, fmt.Sprintf("index on main: %s base\n", head.Short()), indexCommit.Message())
+
+	out, err = runStash("list")
 	require.NoError(t, err)
-	
-	// Change to repo directory
-	err = os.Chdir(repoPath)
+	assert.Equal(t, "stash@{0}: On main: work\n", out)
+
+	out, err = runStash("pop")
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("Dropped refs/stash@{0} (%s)\n", stashID), out)
+	assert.Equal(t, "a changed\n", readWorkFile(t, "a.txt"))
+	assert.Equal(t, "c\n", readWorkFile(t, "c.txt"))
+	assert.Equal(t, "u\n", readWorkFile(t, "u.txt"))
+	// Without --index only the added file is staged
+	assert.Equal(t, []string{"a.txt:0:a", "b.txt:0:b", "c.txt:0:c"}, stagedFiles(t, repo))
+
+	assert.False(t, refManager.RefExists("refs/stash"))
+	out, err = runStash("list")
 	require.NoError(t, err)
-	
-	// Create command
-	cmd := newStashCommand()
-	
-	// Test default (stash@{0})
-	var buf bytes.Buffer
-	cmd.SetOut(&buf)
-	cmd.SetArgs([]string{"show"})
-	err = cmd.Execute()
-	assert.NoError(t, err)
-	output := buf.String()
-	assert.Contains(t, output, "Showing stash stash@{0}")
-	assert.Contains(t, output, "Full stash show would display the diff")
-	
-	// Test specific stash
-	buf.Reset()
-	cmd.SetArgs([]string{"show", "stash@{2}"})
-	err = cmd.Execute()
-	assert.NoError(t, err)
-	output = buf.String()
-	assert.Contains(t, output, "Showing stash stash@{2}")
+	assert.Empty(t, out)
+	_, err = runStash("pop")
+	assert.ErrorContains(t, err, "no stash entries found")
 }
 
-func TestStashPop(t *testing.T) {
-	// Create temporary directory
-	tmpDir := t.TempDir()
-	repoPath := filepath.Join(tmpDir, "test-repo")
-	
-	// Initialize repository
-	repo, err := vcs.Init(repoPath)
-	require.NoError(t, err)
-	
-	// Make initial commit
-	testFile := filepath.Join(repoPath, "test.txt")
-	err = os.WriteFile(testFile, []byte("test content"), 0644)
+func TestStashNoChanges(t *testing.T) {
+	setupStashRepo(t)
+	out, err := runStash()
 	require.NoError(t, err)
-	
-	testRepo := WrapRepository(repo, repoPath)
-				err = testRepo.Add("test.txt")
+	assert.Equal(t, "No local changes to save\n", out)
+
+	setupConfigRepo(t)
+	_, err = runStash("push")
+	assert.ErrorContains(t, err, "you do not have the initial commit yet")
+}
+
+func TestStashPathspecAndKeepIndex(t *testing.T) {
+	repo, _ := setupStashRepo(t)
+	require.NoError(t, os.WriteFile("a.txt", []byte("a changed\n"), 0644))
+	require.NoError(t, os.WriteFile("b.txt", []byte("b changed\n"), 0644))
+
+	// Only the files the pathspecs cover are stashed
+	_, err := runStash("push", "a.txt")
 	require.NoError(t, err)
-	
-	_, err = testRepo.Commit("Initial commit", "Test User", "test@example.com")
+	assert.Equal(t, "a\n", readWorkFile(t, "a.txt"))
+	assert.Equal(t, "b changed\n", readWorkFile(t, "b.txt"))
+
+	stageFile(t, "b.txt", "b staged\n")
+	require.NoError(t, os.WriteFile("b.txt", []byte("b unstaged\n"), 0644))
+	_, err = runStash("push", "--keep-index")
 	require.NoError(t, err)
-	
-	// Change to repo directory
-	err = os.Chdir(repoPath)
+	assert.Equal(t, "b staged\n", readWorkFile(t, "b.txt"))
+	assert.Equal(t, []string{"a.txt:0:a", "b.txt:0:b staged"}, stagedFiles(t, repo))
+
+	out, err := runStash("list")
 	require.NoError(t, err)
-	
-	// Create command
-	cmd := newStashCommand()
-	
-	// Test pop
-	var buf bytes.Buffer
-	cmd.SetOut(&buf)
-	cmd.SetArgs([]string{"pop"})
-	err = cmd.Execute()
-	assert.NoError(t, err)
-	
-	output := buf.String()
-	assert.Contains(t, output, "Applying stash stash@{0}")
-	assert.Contains(t, output, "Dropped stash@{0}")
-	
-	// Test pop specific stash
-	buf.Reset()
-	cmd.SetArgs([]string{"pop", "stash@{1}"})
-	err = cmd.Execute()
-	assert.NoError(t, err)
-	
-	output = buf.String()
-	assert.Contains(t, output, "Applying stash stash@{1}")
-	assert.Contains(t, output, "Dropped stash@{1}")
+	assert.Regexp(t, `^stash@\{0\}: WIP on main: [0-9a-f]{7} base\nstash@\{1\}: WIP on main: [0-9a-f]{7} base\n$`, out)
 }
 
-func TestStashApply(t *testing.T) {
-	// Create temporary directory
-	tmpDir := t.TempDir()
-	repoPath := filepath.Join(tmpDir, "test-repo")
-	
-	// Initialize repository
-	repo, err := vcs.Init(repoPath)
-	require.NoError(t, err)
-	
-	// Make initial commit
-	testFile := filepath.Join(repoPath, "test.txt")
-	err = os.WriteFile(testFile, []byte("test content"), 0644)
+func TestStashApplyIndex(t *testing.T) {
+	repo, _ := setupStashRepo(t)
+	stageFile(t, "a.txt", "a staged\n")
+	require.NoError(t, os.WriteFile("b.txt", []byte("b changed\n"), 0644))
+	_, err := runStash()
 	require.NoError(t, err)
-	
-	testRepo := WrapRepository(repo, repoPath)
-				err = testRepo.Add("test.txt")
-	require.NoError(t, err)
-	
-	_, err = testRepo.Commit("Initial commit", "Test User", "test@example.com")
+
+	_, err = runStash("apply", "--index")
 	require.NoError(t, err)
-	
-	// Change to repo directory
-	err = os.Chdir(repoPath)
+	assert.Equal(t, "a staged\n", readWorkFile(t, "a.txt"))
+	assert.Equal(t, "b changed\n", readWorkFile(t, "b.txt"))
+	assert.Equal(t, []string{"a.txt:0:a staged", "b.txt:0:b"}, stagedFiles(t, repo))
+
+	// Applying keeps the entry, and refuses to overwrite local changes
+	_, err = runStash("apply", "stash@{0}")
+	assert.ErrorContains(t, err, "your local changes to the following files would be overwritten by merge:\n\tb.txt\n")
+}
+
+func TestStashPopConflict(t *testing.T) {
+	repo, refManager := setupStashRepo(t)
+	require.NoError(t, os.WriteFile("a.txt", []byte("stashed\n"), 0644))
+	_, err := runStash()
 	require.NoError(t, err)
-	
-	// Create command
-	cmd := newStashCommand()
-	
-	// Test apply
-	var buf bytes.Buffer
-	cmd.SetOut(&buf)
-	cmd.SetArgs([]string{"apply"})
-	err = cmd.Execute()
-	assert.NoError(t, err)
-	
-	output := buf.String()
-	assert.Contains(t, output, "Applying stash stash@{0}")
-	assert.Contains(t, output, "Note: Full stash apply would:")
-	assert.NotContains(t, output, "Dropped") // Apply doesn't drop
+
+	moveMain(t, repo, refManager, map[string]string{"a.txt": "upstream\n", "b.txt": "b\n"})
+	require.NoError(t, resetIndexToHead(repo))
+
+	out, err := runStash("pop")
+	assert.ErrorIs(t, err, errStashConflict)
+	assert.Contains(t, out, "CONFLICT (content): Merge conflict in a.txt\n")
+	assert.Contains(t, out, "The stash entry is kept in case you need it again.\n")
+	assert.Equal(t, "<<<<<<< Updated upstream\nupstream\n=======\nstashed\n>>>>>>> Stashed changes\n", readWorkFile(t, "a.txt"))
+	assert.True(t, refManager.RefExists("refs/stash"))
 }
 
-func TestStashDrop(t *testing.T) {
-	// Create temporary directory
-	tmpDir := t.TempDir()
-	repoPath := filepath.Join(tmpDir, "test-repo")
-	
-	// Initialize repository
-	repo, err := vcs.Init(repoPath)
+func TestStashShowDropAndClear(t *testing.T) {
+	_, refManager := setupStashRepo(t)
+	require.NoError(t, os.WriteFile("a.txt", []byte("a\nmore\n"), 0644))
+	_, err := runStash("push", "-m", "first")
 	require.NoError(t, err)
-	
-	// Make initial commit
-	testFile := filepath.Join(repoPath, "test.txt")
-	err = os.WriteFile(testFile, []byte("test content"), 0644)
+	first, err := refManager.ResolveRef("refs/stash")
 	require.NoError(t, err)
-	
-	testRepo := WrapRepository(repo, repoPath)
-				err = testRepo.Add("test.txt")
+	require.NoError(t, os.WriteFile("b.txt", []byte("b changed\n"), 0644))
+	_, err = runStash("push", "-m", "second")
 	require.NoError(t, err)
-	
-	_, err = testRepo.Commit("Initial commit", "Test User", "test@example.com")
+
+	out, err := runStash("show", "1")
 	require.NoError(t, err)
-	
-	// Change to repo directory
-	err = os.Chdir(repoPath)
+	assert.Equal(t, " a.txt | 1 +\n 1 file changed, 1 insertion(+)\n", out)
+	out, err = runStash("show", "-p")
 	require.NoError(t, err)
-	
-	// Create command
-	cmd := newStashCommand()
-	
-	// Test drop
-	var buf bytes.Buffer
-	cmd.SetOut(&buf)
-	cmd.SetArgs([]string{"drop"})
-	err = cmd.Execute()
-	assert.NoError(t, err)
-	
-	output := buf.String()
-	assert.Contains(t, output, "Dropped stash@{0}")
-	assert.Contains(t, output, "Note: Full stash drop would remove")
-}
+	assert.Contains(t, out, "diff --git a/b.txt b/b.txt\n")
+	assert.Contains(t, out, "-b\n+b changed\n")
 
-func TestStashClear(t *testing.T) {
-	// Create temporary directory
-	tmpDir := t.TempDir()
-	repoPath := filepath.Join(tmpDir, "test-repo")
-	
-	// Initialize repository
-	repo, err := vcs.Init(repoPath)
-	require.NoError(t, err)
-	
-	// Make initial commit
-	testFile := filepath.Join(repoPath, "test.txt")
-	err = os.WriteFile(testFile, []byte("test content"), 0644)
-	require.NoError(t, err)
-	
-	testRepo := WrapRepository(repo, repoPath)
-				err = testRepo.Add("test.txt")
-	require.NoError(t, err)
-	
-	_, err = testRepo.Commit("Initial commit", "Test User", "test@example.com")
-	require.NoError(t, err)
-	
-	// Create stash file
-	stashDir := filepath.Join(repoPath, ".git", "stash")
-	err = os.MkdirAll(stashDir, 0755)
-	require.NoError(t, err)
-	
-	stashFile := filepath.Join(stashDir, "stash_list")
-	err = os.WriteFile(stashFile, []byte("some stash entries"), 0644)
-	require.NoError(t, err)
-	
-	// Change to repo directory
-	err = os.Chdir(repoPath)
-	require.NoError(t, err)
-	
-	// Create command
-	cmd := newStashCommand()
-	
-	// Test clear
-	var buf bytes.Buffer
-	cmd.SetOut(&buf)
-	cmd.SetArgs([]string{"clear"})
-	err = cmd.Execute()
-	assert.NoError(t, err)
-	
-	output := buf.String()
-	assert.Contains(t, output, "Cleared all stashes")
-	
-	// Verify file was removed
-	assert.NoFileExists(t, stashFile)
-}
+	out, err = runStash("drop", "stash@{1}")
+	require.NoError(t, err)
+	assert.Equal(t, fmt.Sprintf("Dropped stash@{1} (%s)\n", first), out)
+	out, err = runStash("list")
+	require.NoError(t, err)
+	assert.Equal(t, "stash@{0}: On main: second\n", out)
+	_, err = runStash("drop", "stash@{1}")
+	assert.ErrorContains(t, err, "stash@{1} is not a valid reference")
+	_, err = runStash("drop", "main")
+	assert.ErrorContains(t, err, "'main' is not a stash-like commit")
 
-func TestHasLocalChanges(t *testing.T) {
-	// Create temporary directory
-	tmpDir := t.TempDir()
-	repoPath := filepath.Join(tmpDir, "test-repo")
-	
-	// Initialize repository
-	repo, err := vcs.Init(repoPath)
-	require.NoError(t, err)
-	
-	// Test with no changes
-	hasChanges, err := hasLocalChanges(repo)
-	assert.NoError(t, err)
-	assert.False(t, hasChanges)
-	
-	// Make initial commit
-	testFile := filepath.Join(repoPath, "test.txt")
-	err = os.WriteFile(testFile, []byte("test content"), 0644)
-	require.NoError(t, err)
-	
-	testRepo := WrapRepository(repo, repoPath)
-				err = testRepo.Add("test.txt")
-	require.NoError(t, err)
-	
-	// Test with staged changes (before commit)
-	hasChanges, err = hasLocalChanges(repo)
-	assert.NoError(t, err)
-	assert.True(t, hasChanges)
-	
-	// Commit the changes
-	_, err = testRepo.Commit("Initial commit", "Test User", "test@example.com")
-	require.NoError(t, err)
-	
-	// Test after commit (no changes)
-	hasChanges, err = hasLocalChanges(repo)
-	assert.NoError(t, err)
-	assert.False(t, hasChanges)
-	
-	// Add new file and stage it
-	newFile := filepath.Join(repoPath, "new.txt")
-	err = os.WriteFile(newFile, []byte("new content"), 0644)
-	require.NoError(t, err)
-	
-				err = testRepo.Add("new.txt")
-	require.NoError(t, err)
-	
-	// Test with new staged changes
-	hasChanges, err = hasLocalChanges(repo)
-	assert.NoError(t, err)
-	assert.True(t, hasChanges)
+	_, err = runStash("clear")
+	require.NoError(t, err)
+	assert.False(t, refManager.RefExists("refs/stash"))
+	out, err = runStash("list")
+	require.NoError(t, err)
+	assert.Empty(t, out)
 }
 
-func TestStashImplementationNotes(t *testing.T) {
-	// Create temporary directory
-	tmpDir := t.TempDir()
-	repoPath := filepath.Join(tmpDir, "test-repo")
-	
-	// Initialize repository
-	repo, err := vcs.Init(repoPath)
+func TestStashBranch(t *testing.T) {
+	repo, refManager := setupStashRepo(t)
+	base, err := refManager.ResolveRef("refs/heads/main")
 	require.NoError(t, err)
-	
-	// Make initial commit
-	testFile := filepath.Join(repoPath, "test.txt")
-	err = os.WriteFile(testFile, []byte("test content"), 0644)
+	stageFile(t, "a.txt", "a staged\n")
+	_, err = runStash()
 	require.NoError(t, err)
-	
-	testRepo := WrapRepository(repo, repoPath)
-				err = testRepo.Add("test.txt")
+	moveMain(t, repo, refManager, map[string]string{"a.txt": "upstream\n", "b.txt": "b\n"})
+	require.NoError(t, resetIndexToHead(repo))
+
+	_, err = runStash("branch", "saved")
 	require.NoError(t, err)
-	
-	_, err = testRepo.Commit("Initial commit", "Test User", "test@example.com")
+	branch, err := refManager.CurrentBranch()
 	require.NoError(t, err)
-	
-	// Create changes to stash
-	modifiedFile := filepath.Join(repoPath, "modified.txt")
-	err = os.WriteFile(modifiedFile, []byte("modified content"), 0644)
+	assert.Equal(t, "saved", branch)
+	id, err := refManager.ResolveRef("refs/heads/saved")
 	require.NoError(t, err)
-				err = testRepo.Add("modified.txt")
+	assert.Equal(t, base, id)
+	assert.Equal(t, "a staged\n", readWorkFile(t, "a.txt"))
+	assert.Equal(t, []string{"a.txt:0:a staged", "b.txt:0:b"}, stagedFiles(t, repo))
+	assert.False(t, refManager.RefExists("refs/stash"))
+}
+
+func TestStashReadByGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	setupStashRepo(t)
+	require.NoError(t, os.WriteFile("a.txt", []byte("a changed\n"), 0644))
+	_, err := runStash("push", "-m", "work")
 	require.NoError(t, err)
-	
-	// Change to repo directory
-	err = os.Chdir(repoPath)
+
+	git := func(args ...string) string {
+		out, err := exec.Command("git", args...).CombinedOutput()
+		require.NoError(t, err, string(out))
+		return string(out)
+	}
+	assert.Equal(t, "stash@{0}: On main: work\n", git("stash", "list"))
+	assert.Contains(t, git("stash", "show", "--stat"), " a.txt | 2 +-\n")
+	git("stash", "pop")
+	assert.Equal(t, "a changed\n", readWorkFile(t, "a.txt"))
+	assert.False(t, strings.Contains(git("stash", "list"), "stash@"))
+
+	// An entry stashed by Git is applied and dropped
+	require.NoError(t, os.WriteFile("b.txt", []byte("b changed\n"), 0644))
+	git("-c", "user.name=Test", "-c", "user.email=test@example.com", "stash", "push", "-q")
+	assert.Equal(t, "b\n", readWorkFile(t, "b.txt"))
+	out, err := runStash("list")
 	require.NoError(t, err)
-	
-	// Run stash save
-	cmd := newStashCommand()
-	var buf bytes.Buffer
-	cmd.SetOut(&buf)
-	
-	err = cmd.Execute()
-	assert.NoError(t, err)
-	
-	// Check implementation notes
-	output := buf.String()
-	assert.Contains(t, output, "Note: This is a basic stash implementation")
-	assert.Contains(t, output, "Full implementation would:")
-	assert.Contains(t, output, "Create tree objects for working directory and index")
-	assert.Contains(t, output, "Create stash commits")
-	assert.Contains(t, output, "Reset working directory")
-	assert.Contains(t, output, "Maintain stash reflog")
-}
\ No newline at end of file
+	assert.Regexp(t, `^stash@\{0\}: WIP on main: [0-9a-f]{7} base\n$`, out)
+	_, err = runStash("pop")
+	require.NoError(t, err)
+	assert.Equal(t, "b changed\n", readWorkFile(t, "b.txt"))
+}
@@ -2,11 +2,23 @@ package main
 
 import (
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
+	"reflect"
 	"sort"
-
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fenilsonani/vcs/internal/core/config"
+	"github.com/fenilsonani/vcs/internal/core/convert"
+	"github.com/fenilsonani/vcs/internal/core/fsmonitor"
+	"github.com/fenilsonani/vcs/internal/core/history"
 	"github.com/fenilsonani/vcs/internal/core/index"
+	"github.com/fenilsonani/vcs/internal/core/merge"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/refs"
 	"github.com/fenilsonani/vcs/internal/core/workdir"
 	"github.com/fenilsonani/vcs/pkg/vcs"
 	"github.com/spf13/cobra"
@@ -18,13 +30,56 @@ func newStatusCommand() *cobra.Command {
 		Short: "Show working tree status",
 		Long: `Shows paths that have differences between the index file and the current HEAD commit,
 paths that have differences between the working tree and the index file, and paths in the
-working tree that are not tracked by Git.`,
+working tree that are not tracked by Git.
+
+With --explain <path>, reports why that path has its status: the ignore
+rule that matches it, how it differs from its index entry, and the
+attributes that apply to it.
+
+Files committed in HEAD are tracked whether or not they are staged. The
+list of them is kept in a cache file beside the index, so status only
+walks the commit's trees after HEAD moves.
+
+The cache also keeps the stat data and hash of each file status looked
+at, so files whose size and modification time did not change are not
+read again. With core.untrackedCache set it keeps the listing of each
+directory too, and directories whose modification time did not change
+are not read again. core.fsmonitor names a hook speaking Git's fsmonitor
+protocol, such as Git's watchman hook; status then only looks at the
+paths the hook reports changed since the previous status.
+
+The long format, and the short one with --branch, tell how many commits
+the current branch is ahead of and behind its upstream, as set by
+branch.<name>.remote and branch.<name>.merge. Counting walks the history
+down to where the two meet; --no-ahead-behind, or status.aheadBehind set
+to false, only tells whether they differ.
+
+A file staged as deleted and one staged as added at least 50% similar to
+it are shown as a rename. --find-renames=<n> sets the similarity needed
+and --no-renames turns this off, as does status.renames, or failing that
+diff.renames, set to false.
+
+The long and short formats are colored on a terminal, staged changes in
+color.status.added and the others in color.status.changed and
+color.status.untracked. status is paged only with pager.status or -p.
+
+--json, or --format=json, writes the branch and the files that differ as
+JSON, with the staged and unstaged status of each file by name.`,
 		RunE: runStatus,
 	}
 
 	cmd.Flags().BoolP("short", "s", false, "Give the output in the short-format")
 	cmd.Flags().Bool("porcelain", false, "Give the output in an easy-to-parse format for scripts")
 	cmd.Flags().Bool("ignored", false, "Show ignored files as well")
+	cmd.Flags().String("explain", "", "Explain why a path has the status it is shown with")
+	cmd.Flags().BoolP("branch", "b", false, "Show the branch and tracking info in the short-format")
+	cmd.Flags().Bool("ahead-behind", true, "Count the commits the branch is ahead and behind its upstream")
+	cmd.Flags().Bool("no-ahead-behind", false, "Only tell whether the branch differs from its upstream")
+	cmd.Flags().StringP("find-renames", "M", "", "Detect renames, optionally with a similarity threshold such as 90%")
+	cmd.Flags().Lookup("find-renames").NoOptDefVal = fmt.Sprintf("%d%%", history.DefaultRenameThreshold)
+	cmd.Flags().Bool("no-renames", false, "Do not detect renames")
+	addColorFlags(cmd)
+	addJSONFlags(cmd)
 
 	return cmd
 }
@@ -37,7 +92,7 @@ func runStatus(cmd *cobra.Command, args []string) error {
 	}
 
 	// Open repository
-	repo, err := vcs.Open(repoPath)
+	repo, err := openRepository(repoPath)
 	if err != nil {
 		return fmt.Errorf("failed to open repository: %w", err)
 	}
@@ -46,41 +101,96 @@ func runStatus(cmd *cobra.Command, args []string) error {
 	shortFormat, _ := cmd.Flags().GetBool("short")
 	porcelain, _ := cmd.Flags().GetBool("porcelain")
 	showIgnored, _ := cmd.Flags().GetBool("ignored")
+	explainPath, _ := cmd.Flags().GetString("explain")
+	showBranch, _ := cmd.Flags().GetBool("branch")
+	aheadBehind, _ := cmd.Flags().GetBool("ahead-behind")
+	noAheadBehind, _ := cmd.Flags().GetBool("no-ahead-behind")
+	detection, err := readDetection(cmd, repo.GitDir(), true, "status.renames", "diff.renames")
+	if err != nil {
+		return err
+	}
+	asJSON, err := jsonRequested(cmd)
+	if err != nil {
+		return err
+	}
 
 	// Create scanner for working directory
-	scanner := workdir.NewScanner(repoPath, repo.GitDir())
-	
-	// Load .gitignore file if it exists
-	gitignorePath := filepath.Join(repoPath, ".gitignore")
-	scanner.LoadIgnoreFile(gitignorePath)
+	scanner := newScanner(repo)
+	scanner.LoadAttributesFile(filepath.Join(repoPath, ".gitattributes"))
 
 	// Get index
-	idx := index.New()
-	indexPath := filepath.Join(repo.GitDir(), "index")
-	if _, err := os.Stat(indexPath); err == nil {
-		if err := idx.ReadFromFile(indexPath); err != nil {
-			// If we can't read the index file, assume it's empty (e.g., different format)
-			idx = index.New()
-		}
+	idx, err := readIndex(repo)
+	if err != nil {
+		return err
+	}
+
+	if explainPath != "" {
+		return explainStatus(cmd.OutOrStdout(), repo, repoPath, scanner, idx, explainPath)
 	}
 
+	// Files and directories changed after this are not trusted by their
+	// modification time next time
+	before := time.Now()
+	cache := readStatusCache(repo)
+	token := cache.FSMonitorToken()
+	monitor := queryFSMonitor(cmd.ErrOrStderr(), repo, cache)
+
 	// Scan working directory files
-	files, err := scanner.ScanFiles()
+	var files []workdir.FileInfo
+	listings := cache.UntrackedCache()
+	if untrackedCacheEnabled(repo.GitDir()) {
+		var unchanged func(dir string) bool
+		if monitor != nil {
+			unchanged = func(dir string) bool { return !monitor.DirChanged(dir) }
+		}
+		var updated *index.UntrackedCache
+		files, updated, err = scanner.ScanFilesCached(listings, unchanged, before)
+		cache.SetUntrackedCache(updated)
+	} else {
+		files, err = scanner.ScanFiles()
+		cache.SetUntrackedCache(nil)
+	}
 	if err != nil {
 		return fmt.Errorf("failed to scan working directory: %w", err)
 	}
 
+	conv := newConverter(repo, io.Discard, nil)
+	hashes := newStatCache(repo, conv, cache, monitor, before)
+
+	head, refreshed, err := headFiles(repo, cache)
+	if err != nil {
+		return fmt.Errorf("failed to read HEAD: %w", err)
+	}
+
 	// Analyze file statuses
 	statusMap := make(map[string]*FileStatusInfo)
 
-	// Check for staged files
+	// Check for staged files: the index holds every tracked file, so ones
+	// differing from HEAD are staged and ones of HEAD missing from it are
+	// staged for removal
 	for _, entry := range idx.Entries() {
+		indexStatus := StatusStaged
+		if file, ok := head[entry.Path]; ok {
+			if file.ID == entry.ID && file.Mode == entry.Mode {
+				continue
+			}
+			indexStatus = StatusModified
+		}
 		statusMap[entry.Path] = &FileStatusInfo{
 			Path:        entry.Path,
-			IndexStatus: StatusStaged,
+			IndexStatus: indexStatus,
 			WorkStatus:  StatusUnmodified,
 		}
 	}
+	for path := range head {
+		if _, staged := idx.Get(path); !staged {
+			statusMap[path] = &FileStatusInfo{
+				Path:        path,
+				IndexStatus: StatusDeleted,
+				WorkStatus:  StatusUnmodified,
+			}
+		}
+	}
 
 	// Check working directory files
 	for _, file := range files {
@@ -95,9 +205,14 @@ func runStatus(cmd *cobra.Command, args []string) error {
 			continue
 		}
 
+		// Files are compared with their staged version
 		entry, exists := idx.Get(file.Path)
 		if !exists {
 			// Untracked file
+			if existing, ok := statusMap[file.Path]; ok {
+				existing.WorkStatus = StatusUntracked
+				continue
+			}
 			statusMap[file.Path] = &FileStatusInfo{
 				Path:        file.Path,
 				IndexStatus: StatusUnmodified,
@@ -105,13 +220,10 @@ func runStatus(cmd *cobra.Command, args []string) error {
 			}
 		} else {
 			// Check if file is modified
-			content, err := scanner.GetFileContent(file.Path)
+			currentHash, err := hashes.hash(file.Path)
 			if err != nil {
 				continue
 			}
-
-			// Compare with index
-			currentHash := repo.HashData(content)
 			if currentHash != entry.ID {
 				if existing, exists := statusMap[file.Path]; exists {
 					existing.WorkStatus = StatusModified
@@ -133,34 +245,129 @@ func runStatus(cmd *cobra.Command, args []string) error {
 	}
 
 	for _, entry := range idx.Entries() {
-		if !workFileMap[entry.Path] {
+		if workFileMap[entry.Path] || entry.SkipWorktree {
+			continue
+		}
+		if existing, ok := statusMap[entry.Path]; ok {
+			existing.WorkStatus = StatusDeleted
+		} else {
 			statusMap[entry.Path] = &FileStatusInfo{
 				Path:        entry.Path,
-				IndexStatus: StatusStaged,
+				IndexStatus: StatusUnmodified,
 				WorkStatus:  StatusDeleted,
 			}
 		}
 	}
 
+	changed := hashes.store(cache) || refreshed || cache.FSMonitorToken() != token ||
+		!reflect.DeepEqual(cache.UntrackedCache(), listings)
+	if changed && !readOnlyRepository(repo.GitDir()) {
+		// Keep the caches for the next status; failing to write them is
+		// not an error
+		cache.WriteToFile(statusCachePath(repo))
+	}
+
+	if detection.Renames > 0 {
+		if err := detectStagedRenames(repo, statusMap, head, idx, detection.Renames); err != nil {
+			return err
+		}
+	}
+
 	// Sort files for consistent output
 	var sortedFiles []string
 	for path := range statusMap {
 		sortedFiles = append(sortedFiles, path)
 	}
 	sort.Strings(sortedFiles)
+	count := aheadBehindEnabled(repo.GitDir(), aheadBehind, noAheadBehind, cmd.Flags().Changed("ahead-behind"))
+	if asJSON {
+		branch, err := readBranchStatus(repo, count)
+		if err != nil {
+			return err
+		}
+		return writeJSON(cmd.OutOrStdout(), newJSONStatus(branch, sortedFiles, statusMap))
+	}
 
-	// Output results
-	if shortFormat || porcelain {
-		printShortStatus(sortedFiles, statusMap)
+	// Output results, never colored for scripts
+	short := shortFormat || porcelain
+	startPager(repo.GitDir(), "status", false)
+	var colors *palette
+	if !porcelain {
+		if colors, err = newPalette(cmd, repo.GitDir(), "status"); err != nil {
+			return err
+		}
+	}
+	var branch *branchStatus
+	if showBranch || !short {
+		if branch, err = readBranchStatus(repo, count); err != nil {
+			return err
+		}
+	}
+	if short {
+		if branch != nil {
+			printBranchHeader(branch, colors)
+		}
+		printShortStatus(sortedFiles, statusMap, colors)
 	} else {
-		printLongStatus(sortedFiles, statusMap)
+		printBranchLines(branch, colors)
+		printLongStatus(sortedFiles, statusMap, colors)
 	}
 
 	return nil
 }
 
+// detectStagedRenames shows a file staged as deleted and one staged as
+// added at least threshold similar to it as a rename, as "vcs mv" stages
+// it
+func detectStagedRenames(repo *vcs.Repository, statusMap map[string]*FileStatusInfo, head map[string]index.HeadEntry, idx *index.Index, threshold int) error {
+	var deleted, added []history.Candidate
+	for path, status := range statusMap {
+		switch status.IndexStatus {
+		case StatusDeleted:
+			deleted = append(deleted, history.Candidate{Path: path, ID: head[path].ID})
+		case StatusStaged:
+			if entry, ok := idx.Get(path); ok {
+				added = append(added, history.Candidate{Path: path, ID: entry.ID})
+			}
+		}
+	}
+	if len(deleted) == 0 || len(added) == 0 {
+		return nil
+	}
+
+	// Same content is found by ID alone; the rest is compared line by line
+	for _, candidates := range [][]history.Candidate{deleted, added} {
+		sort.Slice(candidates, func(i, j int) bool { return candidates[i].Path < candidates[j].Path })
+		if threshold == 100 {
+			continue
+		}
+		for i := range candidates {
+			data, err := history.ReadBlob(repo, candidates[i].ID)
+			if err != nil {
+				return err
+			}
+			candidates[i].Content = data
+		}
+	}
+
+	for _, rename := range history.MatchRenames(deleted, added, threshold) {
+		status := statusMap[rename.To.Path]
+		status.IndexStatus = StatusRenamed
+		status.From = rename.From.Path
+		// The old path may be untracked again
+		if old := statusMap[rename.From.Path]; old.WorkStatus == StatusUnmodified {
+			delete(statusMap, rename.From.Path)
+		} else {
+			old.IndexStatus = StatusUnmodified
+		}
+	}
+	return nil
+}
+
 type FileStatusInfo struct {
-	Path        string
+	Path string
+	// From is the path a renamed file was staged from
+	From        string
 	IndexStatus FileStatus
 	WorkStatus  FileStatus
 }
@@ -174,6 +381,7 @@ const (
 	StatusUntracked
 	StatusDeleted
 	StatusIgnored
+	StatusRenamed
 )
 
 func (s FileStatus) IndexChar() string {
@@ -184,6 +392,8 @@ func (s FileStatus) IndexChar() string {
 		return "M"
 	case StatusDeleted:
 		return "D"
+	case StatusRenamed:
+		return "R"
 	default:
 		return " "
 	}
@@ -204,7 +414,124 @@ func (s FileStatus) WorkChar() string {
 	}
 }
 
-func printShortStatus(sortedFiles []string, statusMap map[string]*FileStatusInfo) {
+// Name names s as status --json does
+func (s FileStatus) Name() string {
+	switch s {
+	case StatusStaged:
+		return "added"
+	case StatusModified:
+		return "modified"
+	case StatusUntracked:
+		return "untracked"
+	case StatusDeleted:
+		return "deleted"
+	case StatusIgnored:
+		return "ignored"
+	case StatusRenamed:
+		return "renamed"
+	default:
+		return "unmodified"
+	}
+}
+
+// branchStatus is the branch status reports on
+type branchStatus struct {
+	// name is the short name of the branch, empty when HEAD is detached
+	name string
+	// head is the commit HEAD points to, zero on an unborn branch
+	head     objects.ObjectID
+	tracking *tracking
+}
+
+// readBranchStatus reads the current branch and how it stands against its
+// upstream, counting the commits between them when count is set
+func readBranchStatus(repo *vcs.Repository, count bool) (*branchStatus, error) {
+	refManager := refs.NewRefManager(repo.GitDir())
+	head, err := refManager.SymbolicHEAD()
+	if err != nil {
+		return nil, err
+	}
+	b := &branchStatus{name: strings.TrimPrefix(head, "refs/heads/")}
+	b.head, _, _ = refManager.HEAD()
+	if b.name == "" {
+		return b, nil
+	}
+	if b.tracking, err = branchTracking(newResolver(repo), b.name, count); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// newJSONStatus describes the status of branch and of the files that
+// differ for --json
+func newJSONStatus(b *branchStatus, sortedFiles []string, statusMap map[string]*FileStatusInfo) jsonStatus {
+	status := jsonStatus{
+		Branch: jsonBranchStatus{Name: b.name, Detached: b.name == "", Upstream: newJSONUpstream(b.tracking)},
+		Files:  []jsonFileStatus{},
+	}
+	if !b.head.IsZero() {
+		status.Branch.Head = b.head.String()
+	}
+	for _, path := range sortedFiles {
+		file := statusMap[path]
+		if file.IndexStatus == StatusUnmodified && file.WorkStatus == StatusUnmodified {
+			continue
+		}
+		status.Files = append(status.Files, jsonFileStatus{
+			Path:     path,
+			From:     file.From,
+			Index:    file.IndexStatus.Name(),
+			Worktree: file.WorkStatus.Name(),
+		})
+	}
+	return status
+}
+
+// printBranchHeader prints the "## main...origin/main [ahead 1]" line
+// starting the short format
+func printBranchHeader(b *branchStatus, colors *palette) {
+	switch {
+	case b.name == "":
+		fmt.Printf("## %s\n", colors.paint("nobranch", "HEAD (no branch)"))
+		return
+	case b.head.IsZero():
+		fmt.Printf("## No commits yet on %s\n", colors.paint("localBranch", b.name))
+		return
+	case b.tracking == nil:
+		fmt.Printf("## %s\n", colors.paint("localBranch", b.name))
+		return
+	}
+	line := fmt.Sprintf("## %s...%s", colors.paint("localBranch", b.name), colors.paint("remoteBranch", b.tracking.upstream))
+	if counts := b.tracking.counts(); counts != "" {
+		line += " [" + counts + "]"
+	}
+	fmt.Println(line)
+}
+
+// printBranchLines prints the branch and tracking lines starting the long
+// format
+func printBranchLines(b *branchStatus, colors *palette) {
+	if b.name == "" {
+		fmt.Println(colors.paint("nobranch", "HEAD detached at "+b.head.Short()))
+	} else {
+		fmt.Printf("On branch %s\n", colors.paint("branch", b.name))
+	}
+	if b.tracking != nil {
+		for _, line := range b.tracking.describe() {
+			fmt.Println(line)
+		}
+		fmt.Println()
+	}
+	if b.name != "" && b.head.IsZero() {
+		fmt.Println("No commits yet")
+		fmt.Println()
+	}
+}
+
+// printShortStatus prints the staged and unstaged status of each file as
+// two columns, the first in the added color and the second in the changed
+// one
+func printShortStatus(sortedFiles []string, statusMap map[string]*FileStatusInfo, colors *palette) {
 	for _, path := range sortedFiles {
 		status := statusMap[path]
 		indexChar := status.IndexStatus.IndexChar()
@@ -213,70 +540,90 @@ func printShortStatus(sortedFiles []string, statusMap map[string]*FileStatusInfo
 		if indexChar == " " && workChar == " " {
 			continue // Skip unmodified files
 		}
+		if status.WorkStatus == StatusUntracked {
+			// A file removed with rm --cached is also untracked
+			if status.IndexStatus == StatusDeleted {
+				fmt.Printf("%s  %s\n", colors.paint("added", "D"), path)
+			}
+			fmt.Printf("%s %s\n", colors.paint("untracked", "??"), path)
+			continue
+		}
+		if status.IndexStatus == StatusRenamed {
+			path = status.From + " -> " + path
+		}
 		
-		fmt.Printf("%s%s %s\n", indexChar, workChar, path)
+		fmt.Printf("%s%s %s\n", statusChar(colors, "added", indexChar), statusChar(colors, "changed", workChar), path)
+	}
+}
+
+// statusChar returns the status letter c in the color of slot, leaving a
+// blank one as it is
+func statusChar(colors *palette, slot, c string) string {
+	if c == " " {
+		return c
 	}
+	return colors.paint(slot, c)
 }
 
-func printLongStatus(sortedFiles []string, statusMap map[string]*FileStatusInfo) {
+func printLongStatus(sortedFiles []string, statusMap map[string]*FileStatusInfo, colors *palette) {
 	var staged []string
 	var modified []string
 	var untracked []string
-	var deleted []string
 	var ignored []string
 
 	for _, path := range sortedFiles {
 		status := statusMap[path]
-		
-		switch {
-		case status.IndexStatus == StatusStaged && status.WorkStatus == StatusUnmodified:
-			staged = append(staged, path)
-		case status.IndexStatus == StatusStaged && status.WorkStatus == StatusDeleted:
-			deleted = append(deleted, path)
-		case status.WorkStatus == StatusModified:
-			modified = append(modified, path)
-		case status.WorkStatus == StatusUntracked:
+
+		switch status.IndexStatus {
+		case StatusStaged:
+			staged = append(staged, fmt.Sprintf("new file:   %s", path))
+		case StatusModified:
+			staged = append(staged, fmt.Sprintf("modified:   %s", path))
+		case StatusDeleted:
+			staged = append(staged, fmt.Sprintf("deleted:    %s", path))
+		case StatusRenamed:
+			staged = append(staged, fmt.Sprintf("renamed:    %s -> %s", status.From, path))
+		}
+
+		switch status.WorkStatus {
+		case StatusModified:
+			modified = append(modified, fmt.Sprintf("modified:   %s", path))
+		case StatusDeleted:
+			modified = append(modified, fmt.Sprintf("deleted:    %s", path))
+		case StatusUntracked:
 			untracked = append(untracked, path)
-		case status.WorkStatus == StatusIgnored:
+		case StatusIgnored:
 			ignored = append(ignored, path)
 		}
 	}
 
 	// Print status sections
 	if len(staged) > 0 {
-		fmt.Println("Changes to be committed:")
-		for _, path := range staged {
-			fmt.Printf("  new file:   %s\n", path)
+		fmt.Println(colors.paint("header", "Changes to be committed:"))
+		for _, line := range staged {
+			fmt.Printf("  %s\n", colors.paint("added", line))
 		}
 		fmt.Println()
 	}
 
 	if len(modified) > 0 {
-		fmt.Println("Changes not staged for commit:")
-		for _, path := range modified {
-			fmt.Printf("  modified:   %s\n", path)
-		}
-		fmt.Println()
-	}
-
-	if len(deleted) > 0 {
-		fmt.Println("Changes not staged for commit:")
-		for _, path := range deleted {
-			fmt.Printf("  deleted:    %s\n", path)
+		fmt.Println(colors.paint("header", "Changes not staged for commit:"))
+		for _, line := range modified {
+			fmt.Printf("  %s\n", colors.paint("changed", line))
 		}
 		fmt.Println()
 	}
 
 	if len(untracked) > 0 {
-		fmt.Println("Untracked files:")
+		fmt.Println(colors.paint("header", "Untracked files:"))
 		for _, path := range untracked {
-			fmt.Printf("  %s\n", path)
+			fmt.Printf("  %s\n", colors.paint("untracked", path))
 		}
 		fmt.Println()
 	}
 
 	if len(ignored) > 0 {
-		fmt.Println("Ignored files:")
+		fmt.Println(colors.paint("header", "Ignored files:"))
 		for _, path := range ignored {
 			fmt.Printf("  %s\n", path)
 		}
@@ -289,26 +636,273 @@ func printLongStatus(sortedFiles []string, statusMap map[string]*FileStatusInfo)
 	}
 }
 
-func findRepository() (string, error) {
-	cwd, err := os.Getwd()
+// statusCachePath returns where status keeps its cache of repo
+func statusCachePath(repo *vcs.Repository) string {
+	return filepath.Join(repo.GitDir(), "vcs-status-cache")
+}
+
+// readStatusCache reads the status cache of repo. A damaged one is as good
+// as none, since everything in it can be worked out again.
+func readStatusCache(repo *vcs.Repository) *index.Cache {
+	cache, err := index.ReadCacheFile(statusCachePath(repo))
+	if err != nil {
+		return &index.Cache{}
+	}
+	return cache
+}
+
+// headFiles returns the files of the HEAD commit by path, or nil on an
+// unborn branch. While HEAD stays on the commit the HEAD files of cache
+// were read from they come from there; otherwise the commit's trees are
+// walked and those of cache replaced, and refreshed is set.
+func headFiles(repo *vcs.Repository, cache *index.Cache) (files map[string]index.HeadEntry, refreshed bool, err error) {
+	headID, _, err := refs.NewRefManager(repo.GitDir()).HEAD()
+	if err != nil || headID.IsZero() {
+		return nil, false, nil
+	}
+
+	cached := cache.HeadFiles
+	if cached == nil || cached.Commit != headID {
+		commit, err := repo.GetCommit(headID)
+		if err != nil {
+			return nil, false, err
+		}
+		entries, err := merge.ReadTree(repo, commit.Tree())
+		if err != nil {
+			return nil, false, err
+		}
+		cached = &index.HeadFiles{Commit: headID}
+		for _, entry := range entries {
+			cached.Entries = append(cached.Entries, index.HeadEntry{Path: entry.Path, Mode: entry.Mode, ID: entry.ID})
+		}
+		sort.Slice(cached.Entries, func(i, j int) bool {
+			return cached.Entries[i].Path < cached.Entries[j].Path
+		})
+		cache.HeadFiles = cached
+		refreshed = true
+	}
+
+	files = make(map[string]index.HeadEntry, len(cached.Entries))
+	for _, entry := range cached.Entries {
+		files[entry.Path] = entry
+	}
+	return files, refreshed, nil
+}
+
+// queryFSMonitor asks the hook core.fsmonitor names which paths changed
+// since the token recorded in cache, recording the new one. It returns nil
+// when there is no monitor or it cannot tell, and every path is checked.
+func queryFSMonitor(errOut io.Writer, repo *vcs.Repository, cache *index.Cache) *fsmonitor.Changes {
+	cfg := loadConfig(repo.GitDir())
+	hook, _ := cfg.Get("core.fsmonitor")
+	if enabled, err := config.ParseBool(hook); err == nil {
+		if enabled {
+			fmt.Fprintf(errOut, "warning: core.fsmonitor must name a hook; ignoring it\n")
+		}
+		cache.SetFSMonitorToken("")
+		return nil
+	}
+
+	version := fsmonitor.VersionAny
+	if value, ok := cfg.Get("core.fsmonitorHookVersion"); ok {
+		if v, err := strconv.Atoi(value); err == nil {
+			version = v
+		}
+	}
+	changes, err := fsmonitor.Query(hook, repo.WorkDir(), cache.FSMonitorToken(), version)
+	if err != nil {
+		fmt.Fprintf(errOut, "warning: %v\n", err)
+		cache.SetFSMonitorToken("")
+		return nil
+	}
+	cache.SetFSMonitorToken(changes.Token)
+	if changes.All {
+		return nil
+	}
+	return changes
+}
+
+// untrackedCacheEnabled reports whether core.untrackedCache keeps the
+// directory listings of the working tree in the status cache
+func untrackedCacheEnabled(gitDir string) bool {
+	value, _ := loadConfig(gitDir).Get("core.untrackedCache")
+	enabled, _ := config.ParseBool(value)
+	return enabled
+}
+
+// statCache hashes working tree files for status, reusing the hash the
+// status cache recorded for a file while its stat data is unchanged, or while the
+// filesystem monitor reports no change to it
+type statCache struct {
+	repo    *vcs.Repository
+	conv    *convert.Converter
+	monitor *fsmonitor.Changes
+	before  time.Time
+	old     map[string]index.StatEntry
+	entries []index.StatEntry
+	hashed  bool
+}
+
+func newStatCache(repo *vcs.Repository, conv *convert.Converter, cache *index.Cache, monitor *fsmonitor.Changes, before time.Time) *statCache {
+	old := make(map[string]index.StatEntry, len(cache.StatCache()))
+	for _, entry := range cache.StatCache() {
+		old[entry.Path] = entry
+	}
+	return &statCache{repo: repo, conv: conv, monitor: monitor, before: before, old: old}
+}
+
+// hash returns the object ID of the content of the file at path as it
+// would be stored
+func (c *statCache) hash(path string) (objects.ObjectID, error) {
+	old, cached := c.old[path]
+	if cached && c.monitor != nil && !c.monitor.FileChanged(path) {
+		c.keep(old)
+		return old.ID, nil
+	}
+
+	fullPath := filepath.Join(c.repo.WorkDir(), path)
+	info, err := os.Stat(fullPath)
+	if err != nil {
+		return objects.ObjectID{}, err
+	}
+	if cached && old.MTime.Equal(info.ModTime()) && old.Size == info.Size() {
+		c.keep(old)
+		return old.ID, nil
+	}
+
+	content, err := os.ReadFile(fullPath)
+	if err != nil {
+		return objects.ObjectID{}, err
+	}
+	if content, err = c.conv.Clean(path, content); err != nil {
+		return objects.ObjectID{}, err
+	}
+	id := c.repo.HashData(content)
+	c.hashed = true
+	// A file modified within the time granularity of this run may change
+	// again without its stat data changing
+	if info.ModTime().Before(c.before) {
+		c.keep(index.StatEntry{Path: path, MTime: info.ModTime(), Size: info.Size(), ID: id})
+	}
+	return id, nil
+}
+
+func (c *statCache) keep(entry index.StatEntry) {
+	c.entries = append(c.entries, entry)
+}
+
+// store replaces the records of cache with those of the files hashed, and
+// reports whether they changed
+func (c *statCache) store(cache *index.Cache) bool {
+	cache.SetStatCache(c.entries)
+	return c.hashed || len(c.entries) != len(c.old)
+}
+
+// explainStatus reports why path has the status runStatus gives it, going
+// through the same checks in the same order: ignore rules, the index, then
+// the working tree file against its index entry
+func explainStatus(out io.Writer, repo *vcs.Repository, repoPath string, scanner *workdir.Scanner, idx *index.Index, path string) error {
+	relPath, err := repoRelativePath(repoPath, path)
 	if err != nil {
-		return "", err
+		return err
 	}
+	absPath := filepath.Join(repoPath, filepath.FromSlash(relPath))
 
-	// Walk up directory tree looking for .git
-	dir := cwd
-	for {
-		gitDir := filepath.Join(dir, ".git")
-		if info, err := os.Stat(gitDir); err == nil && info.IsDir() {
-			return dir, nil
+	info, statErr := os.Lstat(absPath)
+	if statErr == nil && info.IsDir() {
+		return fmt.Errorf("'%s' is a directory; status is reported for files", path)
+	}
+	exists := statErr == nil
+	entry, tracked := idx.Get(relPath)
+	if !exists && !tracked {
+		return fmt.Errorf("pathspec '%s' did not match any file", path)
+	}
+
+	conv := newConverter(repo, io.Discard, nil)
+
+	// Show where rules came from relative to the repository
+	source := func(ps workdir.PatternSource) string {
+		if rel, err := filepath.Rel(repoPath, ps.File); err == nil {
+			ps.File = filepath.ToSlash(rel)
+		}
+		return ps.String()
+	}
+
+	var status string
+	var reasons []string
+	ignoreSource, ignored := scanner.IgnoreSource(relPath)
+
+	switch {
+	case tracked && entry.Stage() != 0:
+		status = "unmerged"
+		reasons = append(reasons, "the index holds conflict stages for it; resolve the conflict and run \"vcs add\"")
+	case !exists:
+		status = "deleted"
+		reasons = append(reasons, fmt.Sprintf("the index has it as %s but it is missing from the working tree", entry.ID.Short()))
+	case ignored:
+		status = "ignored"
+		reasons = append(reasons, "matches "+source(ignoreSource))
+		if tracked {
+			reasons = append(reasons, "it is also in the index, but changes to ignored paths are not reported")
 		}
+	case !tracked:
+		status = "untracked"
+		reasons = append(reasons, "not in the index and no ignore rule matches it")
+	default:
+		content, err := os.ReadFile(absPath)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", relPath, err)
+		}
+		if content, err = conv.Clean(relPath, content); err != nil {
+			return err
+		}
+		currentID := repo.HashData(content)
 
-		parent := filepath.Dir(dir)
-		if parent == dir {
-			break // reached root
+		var stat []string
+		if entry.MTime.IsZero() {
+			stat = append(stat, "the index entry has no stat data")
+		} else {
+			if int64(entry.Size) != info.Size() {
+				stat = append(stat, fmt.Sprintf("size differs: index has %d bytes, working tree has %d", entry.Size, info.Size()))
+			}
+			if entry.MTime.Unix() != info.ModTime().Unix() {
+				stat = append(stat, fmt.Sprintf("mtime differs: index has %s, working tree has %s",
+					entry.MTime.Format(time.RFC3339), info.ModTime().Format(time.RFC3339)))
+			}
+		}
+
+		if currentID != entry.ID {
+			status = "modified"
+			reasons = append(reasons, stat...)
+			reasons = append(reasons, fmt.Sprintf("content differs: index has %s, working tree has %s", entry.ID.Short(), currentID.Short()))
+		} else {
+			status = "staged"
+			reasons = append(reasons, fmt.Sprintf("the index has it as %s, matching the working tree", entry.ID.Short()))
+			if len(stat) > 0 {
+				reasons = append(reasons, strings.Join(stat, "; ")+" (content is unchanged)")
+			}
 		}
-		dir = parent
 	}
 
-	return "", fmt.Errorf("not a git repository")
+	fmt.Fprintf(out, "%s: %s\n", relPath, status)
+	for _, reason := range reasons {
+		fmt.Fprintf(out, "  %s\n", reason)
+	}
+	attrs := conv.Attributes(relPath)
+	names := make([]string, 0, len(attrs))
+	for name := range attrs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		attr := attrs[name]
+		fmt.Fprintf(out, "  attribute %s: %s (%s)\n", attr.Name, attr.Value, source(attr.Source))
+	}
+	return nil
+}
+
+// findRepository returns the working tree of the current repository
+func findRepository() (string, error) {
+	workTree, _, err := discoverRepository()
+	return workTree, err
 }
\ No newline at end of file
@@ -7,8 +7,10 @@ import (
 	"path/filepath"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/fenilsonani/vcs/internal/core/index"
+	"github.com/fenilsonani/vcs/internal/core/objects"
 	"github.com/fenilsonani/vcs/pkg/vcs"
 )
 
@@ -248,7 +250,7 @@ func TestPrintShortStatus(t *testing.T) {
 	r, w, _ := os.Pipe()
 	os.Stdout = w
 
-	printShortStatus(sortedFiles, statusMap)
+	printShortStatus(sortedFiles, statusMap, nil)
 
 	w.Close()
 	os.Stdout = oldStdout
@@ -268,4 +270,301 @@ func TestPrintShortStatus(t *testing.T) {
 			t.Errorf("Output missing expected line %q\nGot: %s", expected, output)
 		}
 	}
-}
\ No newline at end of file
+}
+func TestStatusExplain(t *testing.T) {
+	tmpDir := t.TempDir()
+	if _, err := vcs.Init(tmpDir); err != nil {
+		t.Fatalf("Failed to init repo: %v", err)
+	}
+	oldDir, _ := os.Getwd()
+	defer os.Chdir(oldDir)
+	os.Chdir(tmpDir)
+
+	files := map[string]string{
+		".gitignore":     "# logs\n*.log\n",
+		".gitattributes": "*.txt text eol=lf\n",
+		"debug.log":      "log\n",
+		"new.txt":        "new\n",
+		"tracked.txt":    "one\n",
+		"gone.txt":       "gone\n",
+	}
+	for name, content := range files {
+		if err := os.WriteFile(name, []byte(content), 0644); err != nil {
+			t.Fatalf("Failed to write %s: %v", name, err)
+		}
+	}
+
+	addCmd := newAddCommand()
+	addCmd.SetArgs([]string{"tracked.txt", "gone.txt"})
+	if err := addCmd.Execute(); err != nil {
+		t.Fatalf("add failed: %v", err)
+	}
+	os.Remove("gone.txt")
+
+	explain := func(path string) (string, error) {
+		cmd := newStatusCommand()
+		var out bytes.Buffer
+		cmd.SetOut(&out)
+		cmd.SetErr(&out)
+		cmd.SetArgs([]string{"--explain", path})
+		err := cmd.Execute()
+		return out.String(), err
+	}
+
+	tests := []struct {
+		path string
+		want []string
+	}{
+		{"debug.log", []string{"debug.log: ignored", "matches .gitignore:2: *.log"}},
+		{"new.txt", []string{"new.txt: untracked", "not in the index", "attribute eol: lf (.gitattributes:1: *.txt text eol=lf)", "attribute text: set"}},
+		{"tracked.txt", []string{"tracked.txt: staged", "matching the working tree"}},
+		{"gone.txt", []string{"gone.txt: deleted", "missing from the working tree"}},
+	}
+	for _, tt := range tests {
+		out, err := explain(tt.path)
+		if err != nil {
+			t.Fatalf("status --explain %s failed: %v", tt.path, err)
+		}
+		for _, want := range tt.want {
+			if !strings.Contains(out, want) {
+				t.Errorf("status --explain %s output missing %q:\n%s", tt.path, want, out)
+			}
+		}
+	}
+
+	if err := os.WriteFile("tracked.txt", []byte("one two\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	out, err := explain("tracked.txt")
+	if err != nil {
+		t.Fatalf("status --explain failed: %v", err)
+	}
+	for _, want := range []string{"tracked.txt: modified", "size differs: index has 4 bytes, working tree has 8", "content differs: index has "} {
+		if !strings.Contains(out, want) {
+			t.Errorf("output missing %q:\n%s", want, out)
+		}
+	}
+
+	if _, err := explain("missing.txt"); err == nil || !strings.Contains(err.Error(), "did not match any file") {
+		t.Errorf("expected pathspec error, got %v", err)
+	}
+}
+
+func TestStatusComparesWithHead(t *testing.T) {
+	repo, _ := setupConfigRepo(t)
+	if err := os.WriteFile("a.txt", []byte("a\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	add := func(paths ...string) {
+		cmd := newAddCommand()
+		cmd.SetArgs(paths)
+		if err := cmd.Execute(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	add("a.txt")
+	if _, err := stageAndCommit(t, repo, "b.txt", "-m", "add a and b"); err != nil {
+		t.Fatal(err)
+	}
+	status := func() string {
+		out, err := captureStdout(t, func() error {
+			cmd := newStatusCommand()
+			cmd.SetArgs([]string{"--short"})
+			return cmd.Execute()
+		})
+		if err != nil {
+			t.Fatalf("status failed: %v", err)
+		}
+		return out
+	}
+	cachePath := filepath.Join(repo.GitDir(), "vcs-status-cache")
+	readCache := func() *index.Cache {
+		cache, err := index.ReadCacheFile(cachePath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cache
+	}
+
+	// Committed files are tracked and match HEAD
+	if out := status(); out != "" {
+		t.Errorf("status of a clean tree = %q", out)
+	}
+	head, err := objects.NewObjectID(headCommit(t, repo))
+	if err != nil {
+		t.Fatal(err)
+	}
+	cached := readCache().HeadFiles
+	if cached == nil || cached.Commit != head || len(cached.Entries) != 2 {
+		t.Fatalf("HeadFiles after status = %+v", cached)
+	}
+
+	// Staged and unstaged changes are told from HEAD's files
+	if err := os.WriteFile("a.txt", []byte("changed\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("c.txt", []byte("new\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	add("a.txt", "c.txt")
+	if err := os.Remove("b.txt"); err != nil {
+		t.Fatal(err)
+	}
+	want := "M  a.txt\n D b.txt\nA  c.txt\n"
+	if out := status(); out != want {
+		t.Errorf("status = %q, want %q", out, want)
+	}
+	if cached := readCache().HeadFiles; cached == nil || cached.Commit != head {
+		t.Errorf("add should keep the HEAD files, got %+v", cached)
+	}
+
+	// While HEAD stays, its files come from the cache and not its trees
+	cache := &index.Cache{HeadFiles: &index.HeadFiles{Commit: head}}
+	if err := cache.WriteToFile(cachePath); err != nil {
+		t.Fatal(err)
+	}
+	if out := status(); out != "A  a.txt\nAD b.txt\nA  c.txt\n" {
+		t.Errorf("status with cached HEAD files = %q", out)
+	}
+
+	// Once HEAD moves they are read again
+	cache.HeadFiles = &index.HeadFiles{Commit: objects.ObjectID{1}}
+	if err := cache.WriteToFile(cachePath); err != nil {
+		t.Fatal(err)
+	}
+	if out := status(); out != want {
+		t.Errorf("status after HEAD moved = %q, want %q", out, want)
+	}
+	if cached := readCache().HeadFiles; cached.Commit != head {
+		t.Errorf("HeadFiles.Commit = %s, want %s", cached.Commit, head)
+	}
+}
+
+func TestStatusCaches(t *testing.T) {
+	repo, _ := setupConfigRepo(t)
+	if _, err := stageAndCommit(t, repo, "a.txt", "-m", "add a"); err != nil {
+		t.Fatal(err)
+	}
+	old := time.Now().Add(-time.Hour)
+	os.Chtimes("a.txt", old, old)
+
+	status := func() string {
+		out, err := captureStdout(t, func() error {
+			cmd := newStatusCommand()
+			cmd.SetArgs([]string{"--short"})
+			return cmd.Execute()
+		})
+		if err != nil {
+			t.Fatalf("status failed: %v", err)
+		}
+		return out
+	}
+	readCache := func() *index.Cache {
+		cache, err := index.ReadCacheFile(filepath.Join(repo.GitDir(), "vcs-status-cache"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cache
+	}
+
+	// A file whose stat data did not change is not read again
+	if out := status(); out != "" {
+		t.Fatalf("status of a clean tree = %q", out)
+	}
+	if stats := readCache().StatCache(); len(stats) != 1 || stats[0].Path != "a.txt" {
+		t.Fatalf("StatCache() = %+v", stats)
+	}
+	if err := os.WriteFile("a.txt", []byte("A.txt\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	os.Chtimes("a.txt", old, old)
+	if out := status(); out != "" {
+		t.Errorf("status with unchanged stat data = %q, want the cached hash used", out)
+	}
+	now := time.Now()
+	os.Chtimes("a.txt", now, now)
+	if out := status(); out != " M a.txt\n" {
+		t.Errorf("status = %q, want a.txt modified", out)
+	}
+
+	// With a monitor, only the paths it reports are looked at
+	changes := filepath.Join(t.TempDir(), "changes")
+	hook := filepath.Join(repo.GitDir(), "fsmonitor")
+	if err := os.WriteFile(hook, []byte("#!/bin/sh\nprintf 'next\\0'\ncat "+changes+" 2>/dev/null || true\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	for key, value := range map[string]string{"core.fsmonitor": hook, "core.untrackedCache": "true"} {
+		if _, err := runConfigArgs(key, value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile("a.txt", []byte("a.txt\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if out := status(); out != "" {
+		t.Errorf("status on the first query = %q", out)
+	}
+	cache := readCache()
+	if cache.FSMonitorToken() != "next" || cache.UntrackedCache() == nil {
+		t.Errorf("cached token = %q, untracked cache = %+v", cache.FSMonitorToken(), cache.UntrackedCache())
+	}
+
+	os.WriteFile("a.txt", []byte("changed\n"), 0644)
+	os.WriteFile("new.txt", []byte("new\n"), 0644)
+	if out := status(); out != "" {
+		t.Errorf("status with nothing reported = %q", out)
+	}
+	os.WriteFile(changes, []byte("a.txt\x00new.txt\x00"), 0644)
+	if out := status(); out != " M a.txt\n?? new.txt\n" {
+		t.Errorf("status with changes reported = %q", out)
+	}
+}
+
+func TestStatusFindRenames(t *testing.T) {
+	body := "one\ntwo\nthree\nfour\nfive\n"
+	setupTreeRepo(t, map[string]string{"old.txt": body})
+	if err := os.Rename("old.txt", "new.txt"); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile("new.txt", []byte(body+"six\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	add := newAddCommand()
+	add.SetArgs([]string{"new.txt"})
+	if err := add.Execute(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := runCommandArgs(newRmCommand(), "old.txt"); err != nil {
+		t.Fatal(err)
+	}
+	status := func(args ...string) string {
+		out, err := captureStdout(t, func() error {
+			cmd := newStatusCommand()
+			cmd.SetArgs(append([]string{"--short"}, args...))
+			return cmd.Execute()
+		})
+		if err != nil {
+			t.Fatalf("status failed: %v", err)
+		}
+		return out
+	}
+
+	if out := status(); out != "R  old.txt -> new.txt\n" {
+		t.Errorf("status = %q", out)
+	}
+	if out := status("--find-renames=90%"); out != "A  new.txt\nD  old.txt\n" {
+		t.Errorf("status --find-renames=90%% = %q", out)
+	}
+	if out := status("--no-renames"); out != "A  new.txt\nD  old.txt\n" {
+		t.Errorf("status --no-renames = %q", out)
+	}
+	if _, err := runConfigArgs("status.renames", "false"); err != nil {
+		t.Fatal(err)
+	}
+	if out := status(); out != "A  new.txt\nD  old.txt\n" {
+		t.Errorf("status with status.renames=false = %q", out)
+	}
+	if out := status("-M"); out != "R  old.txt -> new.txt\n" {
+		t.Errorf("status -M = %q", out)
+	}
+}
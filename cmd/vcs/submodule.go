@@ -0,0 +1,769 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/config"
+	"github.com/fenilsonani/vcs/internal/core/index"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/internal/core/submodule"
+	"github.com/fenilsonani/vcs/internal/core/workdir"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+func newSubmoduleCommand() *cobra.Command {
+	var recursive bool
+
+	cmd := &cobra.Command{
+		Use:   "submodule",
+		Short: "Initialize, update or inspect submodules",
+		Long: `Manages submodules, repositories embedded in this one. Each is listed in
+.gitmodules with its path and URL, and the tree records the commit it is
+checked out at as a gitlink entry. The repository directory of a
+submodule is kept under modules/ in this one's, and its working tree has a
+.git file pointing there.
+
+Without a subcommand, shows the status of the submodules.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runSubmoduleStatus(cmd, nil, recursive)
+		},
+	}
+
+	cmd.Flags().BoolVar(&recursive, "recursive", false, "Recurse into nested submodules")
+	cmd.AddCommand(
+		newSubmoduleAddCommand(),
+		newSubmoduleInitCommand(),
+		newSubmoduleUpdateCommand(),
+		newSubmoduleStatusCommand(),
+		newSubmoduleForeachCommand(),
+	)
+	return cmd
+}
+
+// openSuperproject opens the repository of the current directory
+func openSuperproject() (*vcs.Repository, error) {
+	repoPath, err := findRepository()
+	if err != nil {
+		return nil, fmt.Errorf("not a git repository: %w", err)
+	}
+	repo, err := openRepository(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository: %w", err)
+	}
+	return repo, nil
+}
+
+// submoduleHead returns the commit checked out in the repository at dir
+func submoduleHead(dir string) (objects.ObjectID, error) {
+	repo, err := vcs.Open(dir)
+	if err != nil {
+		return objects.ObjectID{}, err
+	}
+	id, _, err := refs.NewRefManager(repo.GitDir()).HEAD()
+	if err != nil {
+		return objects.ObjectID{}, err
+	}
+	if id.IsZero() {
+		return objects.ObjectID{}, fmt.Errorf("no commit checked out in %s", dir)
+	}
+	return id, nil
+}
+
+// recordedGitlinks returns the commit recorded for each submodule path: the
+// gitlinks of the HEAD tree, overridden by those staged in the index
+func recordedGitlinks(repo *vcs.Repository) (map[string]objects.ObjectID, error) {
+	gitlinks := make(map[string]objects.ObjectID)
+
+	if head, _, err := refs.NewRefManager(repo.GitDir()).HEAD(); err == nil && !head.IsZero() {
+		commit, err := repo.GetCommit(head)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read HEAD commit: %w", err)
+		}
+		if err := collectGitlinks(repo, commit.Tree(), "", gitlinks); err != nil {
+			return nil, err
+		}
+	}
+
+	idx := index.New()
+	indexPath := filepath.Join(repo.GitDir(), "index")
+	if _, err := os.Stat(indexPath); err == nil {
+		if err := idx.ReadFromFile(indexPath); err != nil {
+			return nil, fmt.Errorf("failed to read index: %w", err)
+		}
+	}
+	for _, entry := range idx.Entries() {
+		if entry.Mode == objects.ModeCommit {
+			gitlinks[entry.Path] = entry.ID
+		}
+	}
+	return gitlinks, nil
+}
+
+// collectGitlinks adds the gitlinks under the tree treeID, at prefix, to
+// gitlinks
+func collectGitlinks(repo *vcs.Repository, treeID objects.ObjectID, prefix string, gitlinks map[string]objects.ObjectID) error {
+	tree, err := repo.GetTree(treeID)
+	if err != nil {
+		return fmt.Errorf("failed to read tree %s: %w", treeID.Short(), err)
+	}
+	for _, entry := range tree.Entries() {
+		switch entry.Mode {
+		case objects.ModeCommit:
+			gitlinks[path.Join(prefix, entry.Name)] = entry.ID
+		case objects.ModeTree:
+			if err := collectGitlinks(repo, entry.ID, path.Join(prefix, entry.Name), gitlinks); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// selectSubmodules returns the submodules of repo named by paths, relative
+// to the current directory, or all of them when paths is empty
+func selectSubmodules(repo *vcs.Repository, modules *submodule.Modules, paths []string) ([]*submodule.Submodule, error) {
+	if len(paths) == 0 {
+		return modules.List(), nil
+	}
+	var selected []*submodule.Submodule
+	for _, p := range paths {
+		abs, err := filepath.Abs(p)
+		if err != nil {
+			return nil, err
+		}
+		rel, err := filepath.Rel(repo.WorkDir(), abs)
+		if err != nil {
+			return nil, err
+		}
+		sm := modules.ByPath(rel)
+		if sm == nil {
+			return nil, fmt.Errorf("pathspec '%s' did not match any submodule", p)
+		}
+		selected = append(selected, sm)
+	}
+	return selected, nil
+}
+
+// superprojectURL returns the URL relative submodule URLs are resolved
+// against: that of the remote the current branch tracks, or origin, or
+// else the superproject's working tree
+func superprojectURL(repo *vcs.Repository) string {
+	cfg := loadConfig(repo.GitDir())
+	remote := "origin"
+	if branch := getCurrentBranchName(refs.NewRefManager(repo.GitDir())); branch != "HEAD" {
+		if name, ok := cfg.Get("branch." + branch + ".remote"); ok && name != "" {
+			remote = name
+		}
+	}
+	if url, ok := cfg.Get("remote." + remote + ".url"); ok && url != "" {
+		return url
+	}
+	return repo.WorkDir()
+}
+
+// setSubmoduleConfig registers the submodule called name in the local
+// config with url, marking it active
+func setSubmoduleConfig(repo *vcs.Repository, name, url string) error {
+	file, err := config.ReadFile(config.Path(config.ScopeLocal, repo.GitDir()))
+	if err != nil {
+		return err
+	}
+	if err := file.Set("submodule."+name+".url", url); err != nil {
+		return err
+	}
+	if err := file.Set("submodule."+name+".active", "true"); err != nil {
+		return err
+	}
+	return file.Save()
+}
+
+// submoduleGitDir returns the repository directory of the submodule called
+// name
+func submoduleGitDir(repo *vcs.Repository, name string) string {
+	return filepath.Join(repo.CommonDir(), "modules", filepath.FromSlash(name))
+}
+
+// connectSubmodule points the working tree of a submodule at its repository
+// directory: a .git file there, and core.worktree back
+func connectSubmodule(workTree, gitDir string) error {
+	rel, err := filepath.Rel(workTree, gitDir)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(workTree, ".git"), []byte("gitdir: "+filepath.ToSlash(rel)+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write .git: %w", err)
+	}
+	back, err := filepath.Rel(gitDir, workTree)
+	if err != nil {
+		return err
+	}
+	file, err := config.ReadFile(filepath.Join(gitDir, "config"))
+	if err != nil {
+		return err
+	}
+	if err := file.Set("core.worktree", filepath.ToSlash(back)); err != nil {
+		return err
+	}
+	return file.Save()
+}
+
+// cloneSubmodule clones url as the submodule called name, with its
+// repository directory under modules/ and its working tree at smPath. The
+// branch given, or else the remote's default branch, is created, and
+// checked out when checkout is set; otherwise no files are written. An
+// existing repository directory of the submodule is reused.
+func cloneSubmodule(cmd *cobra.Command, super *vcs.Repository, name, smPath, url, branch string, checkout bool) (*vcs.Repository, error) {
+	workTree := filepath.Join(super.WorkDir(), filepath.FromSlash(smPath))
+	gitDir := submoduleGitDir(super, name)
+	if entries, err := os.ReadDir(workTree); err == nil && len(entries) > 0 {
+		return nil, fmt.Errorf("destination path '%s' already exists and is not an empty directory", smPath)
+	}
+	if err := os.MkdirAll(workTree, 0755); err != nil {
+		return nil, fmt.Errorf("could not create directory '%s': %w", smPath, err)
+	}
+
+	if fileExists(gitDir) {
+		if err := connectSubmodule(workTree, gitDir); err != nil {
+			return nil, err
+		}
+		return vcs.Open(workTree)
+	}
+
+	if !isHTTPURL(url) {
+		return nil, fmt.Errorf("cannot clone '%s' into submodule path '%s': only HTTP(S) and shared repository URLs can be fetched", url, smPath)
+	}
+	fmt.Fprintf(cmd.OutOrStdout(), "Cloning into '%s'...\n", workTree)
+
+	// Initialize in place, then move the repository directory under modules/
+	if _, err := vcs.Init(workTree); err != nil {
+		return nil, fmt.Errorf("failed to initialize submodule: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(gitDir), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", filepath.Dir(gitDir), err)
+	}
+	if err := os.Rename(filepath.Join(workTree, ".git"), gitDir); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", gitDir, err)
+	}
+	if err := connectSubmodule(workTree, gitDir); err != nil {
+		return nil, err
+	}
+	repo, err := vcs.Open(workTree)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := writeRemoteConfig(repo, "origin", url); err != nil {
+		return nil, fmt.Errorf("failed to add remote: %w", err)
+	}
+	discovery, err := fetchRefsWithHTTPTransport(cmd, repo, "origin", url, shallowOptions{}, false)
+	if err != nil {
+		return nil, fmt.Errorf("clone of '%s' into submodule path '%s' failed: %w", url, smPath, err)
+	}
+	if _, err := checkoutClonedBranch(repo, discovery, branch, !checkout, nil); err != nil {
+		return nil, err
+	}
+	return repo, nil
+}
+
+// checkoutSubmoduleCommit detaches HEAD of a submodule at id, replacing the
+// files of the commit it had checked out
+func checkoutSubmoduleCommit(repo *vcs.Repository, id objects.ObjectID) error {
+	refManager := refs.NewRefManager(repo.GitDir())
+	commit, err := repo.GetCommit(id)
+	if err != nil {
+		return fmt.Errorf("failed to read commit %s: %w", id.Short(), err)
+	}
+	tree, err := repo.GetTree(commit.Tree())
+	if err != nil {
+		return fmt.Errorf("failed to read tree: %w", err)
+	}
+
+	oldID, _, _ := refManager.HEAD()
+	from := getCurrentBranchName(refManager)
+	if from == "HEAD" {
+		from = oldID.String()
+	}
+	if old, err := repo.GetCommit(oldID); err == nil {
+		if oldTree, err := repo.GetTree(old.Tree()); err == nil {
+			if err := removeTreeFromWorkingDirectory(repo, oldTree, repo.WorkDir()); err != nil {
+				return err
+			}
+		}
+	}
+	if err := extractTreeToWorkingDirectory(repo, tree, repo.WorkDir()); err != nil {
+		return err
+	}
+	if err := resetIndex(repo, commit); err != nil {
+		return err
+	}
+	if err := refManager.SetHEADToCommit(id); err != nil {
+		return fmt.Errorf("failed to update HEAD: %w", err)
+	}
+	logRefUpdate(refManager, "HEAD", oldID, id, "checkout: moving from "+from+" to "+id.String())
+	return nil
+}
+
+// describeCommit names id for submodule status: a tag pointing at it, or
+// else a branch or remote-tracking branch, or else its short name
+func describeCommit(repo *vcs.Repository, id objects.ObjectID) string {
+	refManager := refs.NewRefManager(repo.GitDir())
+	allRefs, err := refManager.AllRefs()
+	if err != nil {
+		return id.Short()
+	}
+	var names []string
+	for name, target := range allRefs {
+		if strings.HasSuffix(name, "/HEAD") {
+			continue
+		}
+		if target != id {
+			if obj, err := repo.ReadObject(target); err != nil {
+				continue
+			} else if tag, ok := obj.(*objects.Tag); !ok || tag.Object() != id {
+				continue
+			}
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, prefix := range []string{"refs/tags/", "refs/heads/", "refs/remotes/"} {
+		for _, name := range names {
+			if strings.HasPrefix(name, prefix) {
+				if prefix == "refs/tags/" {
+					return strings.TrimPrefix(name, prefix)
+				}
+				return strings.TrimPrefix(name, "refs/")
+			}
+		}
+	}
+	return id.Short()
+}
+
+func newSubmoduleAddCommand() *cobra.Command {
+	var branch, name string
+
+	cmd := &cobra.Command{
+		Use:   "add [flags] <repository> [<path>]",
+		Short: "Add a submodule",
+		Long: `Clones the repository at <path>, named after the repository by default,
+records it in .gitmodules and stages .gitmodules and the gitlink. A URL
+starting with ./ or ../ is relative to the URL of this repository's
+remote. An existing repository at <path> is added as it is.`,
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runSubmoduleAdd(cmd, args, branch, name)
+		},
+	}
+
+	cmd.Flags().StringVarP(&branch, "branch", "b", "", "Branch of the repository to check out and track")
+	cmd.Flags().StringVar(&name, "name", "", "Name of the submodule, by default its path")
+	return cmd
+}
+
+func runSubmoduleAdd(cmd *cobra.Command, args []string, branch, name string) error {
+	repo, err := openSuperproject()
+	if err != nil {
+		return err
+	}
+
+	url := args[0]
+	smPath := getDirectoryNameFromURL(url)
+	if len(args) > 1 {
+		smPath = args[1]
+	}
+	abs, err := filepath.Abs(smPath)
+	if err != nil {
+		return err
+	}
+	rel, err := filepath.Rel(repo.WorkDir(), abs)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return fmt.Errorf("'%s' is outside the repository", smPath)
+	}
+	smPath = filepath.ToSlash(rel)
+	if name == "" {
+		name = smPath
+	}
+	if err := submodule.ValidateName(name); err != nil {
+		return err
+	}
+
+	modules, err := submodule.Read(repo.WorkDir())
+	if err != nil {
+		return err
+	}
+	gitlinks, err := recordedGitlinks(repo)
+	if err != nil {
+		return err
+	}
+	if _, ok := gitlinks[smPath]; ok || modules.ByPath(smPath) != nil {
+		return fmt.Errorf("'%s' already exists in the index", smPath)
+	}
+
+	resolved, err := submodule.ResolveURL(url, superprojectURL(repo))
+	if err != nil {
+		return err
+	}
+	if workdir.IsRepository(abs) {
+		fmt.Fprintf(cmd.OutOrStdout(), "Adding existing repo at '%s' to the index\n", smPath)
+	} else if _, err := cloneSubmodule(cmd, repo, name, smPath, resolved, branch, true); err != nil {
+		return err
+	}
+	head, err := submoduleHead(abs)
+	if err != nil {
+		return fmt.Errorf("'%s' does not have a commit checked out", smPath)
+	}
+
+	if err := modules.Add(&submodule.Submodule{Name: name, Path: smPath, URL: url, Branch: branch}); err != nil {
+		return err
+	}
+	if err := modules.Save(); err != nil {
+		return fmt.Errorf("failed to write %s: %w", submodule.ModulesFile, err)
+	}
+	if err := setSubmoduleConfig(repo, name, resolved); err != nil {
+		return fmt.Errorf("failed to register submodule '%s': %w", name, err)
+	}
+
+	// Stage .gitmodules and the gitlink
+	idx, err := readIndex(repo)
+	if err != nil {
+		return err
+	}
+	indexPath := filepath.Join(repo.GitDir(), "index")
+	modulesPath := filepath.Join(repo.WorkDir(), submodule.ModulesFile)
+	data, err := os.ReadFile(modulesPath)
+	if err != nil {
+		return err
+	}
+	blob, err := repo.CreateBlob(data)
+	if err != nil {
+		return fmt.Errorf("failed to write blob for %s: %w", submodule.ModulesFile, err)
+	}
+	info, err := os.Stat(modulesPath)
+	if err != nil {
+		return err
+	}
+	entries := []*index.Entry{
+		{CTime: info.ModTime(), MTime: info.ModTime(), Mode: objects.ModeBlob, Size: uint32(len(data)), ID: blob.ID(), Path: submodule.ModulesFile},
+		{Mode: objects.ModeCommit, ID: head, Path: smPath},
+	}
+	for _, entry := range entries {
+		if err := idx.Add(entry); err != nil {
+			return fmt.Errorf("failed to add entry to index: %w", err)
+		}
+	}
+	if err := idx.WriteToFile(indexPath); err != nil {
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+	return nil
+}
+
+func newSubmoduleInitCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "init [<path>...]",
+		Short: "Register submodules in the repository config",
+		Long: `Copies the URL of each submodule, or of those given, from .gitmodules to
+the repository config, where update reads it. Submodules already
+registered keep their URL, so it can be changed locally.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repo, err := openSuperproject()
+			if err != nil {
+				return err
+			}
+			return initSubmodules(cmd.OutOrStdout(), repo, args)
+		},
+	}
+}
+
+// initSubmodules registers the submodules named by paths, or all of them
+func initSubmodules(out io.Writer, repo *vcs.Repository, paths []string) error {
+	modules, err := submodule.Read(repo.WorkDir())
+	if err != nil {
+		return err
+	}
+	selected, err := selectSubmodules(repo, modules, paths)
+	if err != nil {
+		return err
+	}
+
+	cfg := loadConfig(repo.GitDir())
+	for _, sm := range selected {
+		if url, ok := cfg.Get("submodule." + sm.Name + ".url"); ok && url != "" {
+			continue
+		}
+		if sm.URL == "" {
+			return fmt.Errorf("no url found for submodule path '%s' in %s", sm.Path, submodule.ModulesFile)
+		}
+		url, err := submodule.ResolveURL(sm.URL, superprojectURL(repo))
+		if err != nil {
+			return err
+		}
+		if err := setSubmoduleConfig(repo, sm.Name, url); err != nil {
+			return fmt.Errorf("failed to register submodule '%s': %w", sm.Name, err)
+		}
+		fmt.Fprintf(out, "Submodule '%s' (%s) registered for path '%s'\n", sm.Name, url, sm.Path)
+	}
+	return nil
+}
+
+// submoduleUpdateOptions holds the flags of submodule update
+type submoduleUpdateOptions struct {
+	init      bool
+	recursive bool
+}
+
+func newSubmoduleUpdateCommand() *cobra.Command {
+	var opts submoduleUpdateOptions
+
+	cmd := &cobra.Command{
+		Use:   "update [flags] [<path>...]",
+		Short: "Check out the recorded commit of each submodule",
+		Long: `Clones each registered submodule that is missing and checks out, with a
+detached HEAD, the commit this repository records for it. Submodules that
+are not registered with init are skipped, unless --init is given.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repo, err := openSuperproject()
+			if err != nil {
+				return err
+			}
+			return updateSubmodules(cmd, repo, "", args, opts)
+		},
+	}
+
+	cmd.Flags().BoolVar(&opts.init, "init", false, "Register the submodules first, as init does")
+	cmd.Flags().BoolVar(&opts.recursive, "recursive", false, "Update nested submodules too")
+	return cmd
+}
+
+// updateSubmodules updates the submodules of repo named by paths, or all of
+// them. Paths are reported after prefix, the display path of repo.
+func updateSubmodules(cmd *cobra.Command, repo *vcs.Repository, prefix string, paths []string, opts submoduleUpdateOptions) error {
+	out := cmd.OutOrStdout()
+	if opts.init {
+		if err := initSubmodules(out, repo, paths); err != nil {
+			return err
+		}
+	}
+	modules, err := submodule.Read(repo.WorkDir())
+	if err != nil {
+		return err
+	}
+	selected, err := selectSubmodules(repo, modules, paths)
+	if err != nil {
+		return err
+	}
+	gitlinks, err := recordedGitlinks(repo)
+	if err != nil {
+		return err
+	}
+
+	cfg := loadConfig(repo.GitDir())
+	for _, sm := range selected {
+		recorded, ok := gitlinks[sm.Path]
+		url, _ := cfg.Get("submodule." + sm.Name + ".url")
+		if !ok || url == "" {
+			continue
+		}
+		displayPath := path.Join(prefix, sm.Path)
+		workTree := filepath.Join(repo.WorkDir(), filepath.FromSlash(sm.Path))
+
+		// A submodule cloned or reconnected here has no files checked out yet
+		var subRepo *vcs.Repository
+		populated := workdir.IsRepository(workTree)
+		if populated {
+			subRepo, err = vcs.Open(workTree)
+		} else {
+			subRepo, err = cloneSubmodule(cmd, repo, sm.Name, sm.Path, url, sm.Branch, false)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to open submodule path '%s': %w", displayPath, err)
+		}
+
+		if !subRepo.HasObject(recorded) {
+			originURL, _ := loadConfig(subRepo.GitDir()).Get("remote.origin.url")
+			if _, err := fetchRefsWithHTTPTransport(cmd, subRepo, "origin", originURL, shallowOptions{}, false); err != nil {
+				return fmt.Errorf("failed to fetch in submodule path '%s': %w", displayPath, err)
+			}
+			if !subRepo.HasObject(recorded) {
+				return fmt.Errorf("fetched in submodule path '%s', but it did not contain %s", displayPath, recorded)
+			}
+		}
+		if head, err := submoduleHead(workTree); err != nil || head != recorded || !populated {
+			if err := checkoutSubmoduleCommit(subRepo, recorded); err != nil {
+				return fmt.Errorf("unable to checkout '%s' in submodule path '%s': %w", recorded, displayPath, err)
+			}
+			fmt.Fprintf(out, "Submodule path '%s': checked out '%s'\n", displayPath, recorded)
+		}
+
+		if opts.recursive {
+			if err := updateSubmodules(cmd, subRepo, displayPath, nil, opts); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func newSubmoduleStatusCommand() *cobra.Command {
+	var recursive bool
+
+	cmd := &cobra.Command{
+		Use:   "status [flags] [<path>...]",
+		Short: "Show the status of the submodules",
+		Long: `Shows the commit checked out in each submodule with its path and a name
+for the commit. The line starts with - when the submodule is not checked
+out, and with + when it is at another commit than the one recorded.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runSubmoduleStatus(cmd, args, recursive)
+		},
+	}
+
+	cmd.Flags().BoolVar(&recursive, "recursive", false, "Recurse into nested submodules")
+	return cmd
+}
+
+func runSubmoduleStatus(cmd *cobra.Command, paths []string, recursive bool) error {
+	repo, err := openSuperproject()
+	if err != nil {
+		return err
+	}
+	return printSubmoduleStatus(cmd.OutOrStdout(), repo, "", paths, recursive)
+}
+
+// printSubmoduleStatus prints a status line for each submodule of repo
+// named by paths, or for all of them
+func printSubmoduleStatus(out io.Writer, repo *vcs.Repository, prefix string, paths []string, recursive bool) error {
+	modules, err := submodule.Read(repo.WorkDir())
+	if err != nil {
+		return err
+	}
+	selected, err := selectSubmodules(repo, modules, paths)
+	if err != nil {
+		return err
+	}
+	gitlinks, err := recordedGitlinks(repo)
+	if err != nil {
+		return err
+	}
+
+	for _, sm := range selected {
+		recorded, ok := gitlinks[sm.Path]
+		if !ok {
+			continue
+		}
+		displayPath := path.Join(prefix, sm.Path)
+		workTree := filepath.Join(repo.WorkDir(), filepath.FromSlash(sm.Path))
+		head, err := submoduleHead(workTree)
+		if err != nil || !workdir.IsRepository(workTree) {
+			fmt.Fprintf(out, "-%s %s\n", recorded, displayPath)
+			continue
+		}
+
+		subRepo, err := vcs.Open(workTree)
+		if err != nil {
+			return err
+		}
+		mark := " "
+		if head != recorded {
+			mark = "+"
+		}
+		fmt.Fprintf(out, "%s%s %s (%s)\n", mark, head, displayPath, describeCommit(subRepo, head))
+
+		if recursive {
+			if err := printSubmoduleStatus(out, subRepo, displayPath, nil, true); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func newSubmoduleForeachCommand() *cobra.Command {
+	var recursive bool
+
+	cmd := &cobra.Command{
+		Use:   "foreach [flags] <command>...",
+		Short: "Run a shell command in each checked-out submodule",
+		Long: `Runs the shell command in each checked-out submodule, with $name,
+$sm_path, $displaypath, $sha1 (the recorded commit) and $toplevel (the
+working tree of the repository containing the submodule) set. A command
+exiting non-zero stops the iteration.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repo, err := openSuperproject()
+			if err != nil {
+				return err
+			}
+			return foreachSubmodule(cmd, repo, "", strings.Join(args, " "), recursive)
+		},
+	}
+
+	cmd.Flags().BoolVar(&recursive, "recursive", false, "Run in nested submodules too")
+	cmd.Flags().SetInterspersed(false)
+	return cmd
+}
+
+// foreachSubmodule runs script in each checked-out submodule of repo
+func foreachSubmodule(cmd *cobra.Command, repo *vcs.Repository, prefix, script string, recursive bool) error {
+	modules, err := submodule.Read(repo.WorkDir())
+	if err != nil {
+		return err
+	}
+	gitlinks, err := recordedGitlinks(repo)
+	if err != nil {
+		return err
+	}
+
+	out := cmd.OutOrStdout()
+	for _, sm := range modules.List() {
+		recorded, ok := gitlinks[sm.Path]
+		workTree := filepath.Join(repo.WorkDir(), filepath.FromSlash(sm.Path))
+		if !ok || !workdir.IsRepository(workTree) {
+			continue
+		}
+		displayPath := path.Join(prefix, sm.Path)
+		fmt.Fprintf(out, "Entering '%s'\n", displayPath)
+
+		sh := exec.Command("sh", "-c", script)
+		sh.Dir = workTree
+		sh.Env = append(os.Environ(),
+			"name="+sm.Name,
+			"sm_path="+sm.Path,
+			"displaypath="+displayPath,
+			"sha1="+recorded.String(),
+			"toplevel="+repo.WorkDir(),
+		)
+		sh.Stdout = out
+		sh.Stderr = cmd.ErrOrStderr()
+		if err := sh.Run(); err != nil {
+			return fmt.Errorf("stopping at '%s'; script returned non-zero status", displayPath)
+		}
+
+		if recursive {
+			subRepo, err := vcs.Open(workTree)
+			if err != nil {
+				return err
+			}
+			if err := foreachSubmodule(cmd, subRepo, displayPath, script, true); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// updateClonedSubmodules checks out the submodules of a fresh clone, for
+// clone --recurse-submodules
+func updateClonedSubmodules(cmd *cobra.Command, repo *vcs.Repository) error {
+	return updateSubmodules(cmd, repo, "", nil, submoduleUpdateOptions{init: true, recursive: true})
+}
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/index"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/internal/serve"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// serveLibrary creates a repository with a commit of README on main and
+// serves it over HTTP, returning the repository, the commit and the URL
+func serveLibrary(t *testing.T) (*vcs.Repository, objects.ObjectID, string) {
+	lib, err := vcs.Init(filepath.Join(t.TempDir(), "lib"))
+	require.NoError(t, err)
+	blob, err := lib.CreateBlob([]byte("library\n"))
+	require.NoError(t, err)
+	tree, err := lib.CreateTree([]objects.TreeEntry{{Mode: objects.ModeBlob, Name: "README", ID: blob.ID()}})
+	require.NoError(t, err)
+	sig := objects.Signature{Name: "Test", Email: "test@example.com", When: time.Unix(1700000000, 0)}
+	commit, err := lib.CreateCommit(tree.ID(), nil, sig, sig, "Initial commit\n")
+	require.NoError(t, err)
+	require.NoError(t, refs.NewRefManager(lib.GitDir()).UpdateRef("refs/heads/main", commit.ID()))
+
+	hs := httptest.NewServer(serve.NewServer(lib.GitDir(), lib.Storage()))
+	t.Cleanup(hs.Close)
+	return lib, commit.ID(), hs.URL
+}
+
+func runSubmoduleArgs(args ...string) (string, error) {
+	return runCommandArgs(newSubmoduleCommand(), args...)
+}
+
+// runCommandArgs runs cmd with args, returning what it wrote to stdout
+func runCommandArgs(cmd *cobra.Command, args ...string) (string, error) {
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+	return out.String(), err
+}
+
+func TestSubmoduleAddAndStatus(t *testing.T) {
+	repo, _ := setupConfigRepo(t)
+	_, libHead, url := serveLibrary(t)
+
+	_, err := runSubmoduleArgs("add", url, "deps/lib")
+	require.NoError(t, err)
+
+	content, err := os.ReadFile("deps/lib/README")
+	require.NoError(t, err)
+	assert.Equal(t, "library\n", string(content))
+	gitFile, err := os.ReadFile("deps/lib/.git")
+	require.NoError(t, err)
+	assert.Equal(t, "gitdir: ../../.git/modules/deps/lib\n", string(gitFile))
+	assert.DirExists(t, filepath.Join(repo.GitDir(), "modules", "deps", "lib", "objects"))
+
+	modules, err := os.ReadFile(".gitmodules")
+	require.NoError(t, err)
+	assert.Contains(t, string(modules), `[submodule "deps/lib"]`)
+	assert.Contains(t, string(modules), "path = deps/lib")
+	assert.Contains(t, string(modules), "url = "+url)
+	value, _ := loadConfig(repo.GitDir()).Get("submodule.deps/lib.url")
+	assert.Equal(t, url, value)
+
+	idx := index.New()
+	require.NoError(t, idx.ReadFromFile(filepath.Join(repo.GitDir(), "index")))
+	entry, ok := idx.Get("deps/lib")
+	require.True(t, ok)
+	assert.Equal(t, objects.ModeCommit, entry.Mode)
+	assert.Equal(t, libHead, entry.ID)
+
+	out, err := runSubmoduleArgs("status")
+	require.NoError(t, err)
+	assert.Equal(t, " "+libHead.String()+" deps/lib (heads/main)\n", out)
+
+	_, err = runSubmoduleArgs("add", url, "deps/lib")
+	assert.ErrorContains(t, err, "already exists in the index")
+}
+
+func TestSubmoduleUpdate(t *testing.T) {
+	repo, _ := setupConfigRepo(t)
+	lib, libHead, url := serveLibrary(t)
+
+	_, err := runSubmoduleArgs("add", url, "lib")
+	require.NoError(t, err)
+	_, err = captureStdout(t, func() error {
+		_, err := runCommandArgs(newCommitCommand(), "-m", "Add lib")
+		return err
+	})
+	require.NoError(t, err)
+
+	// The gitlink is read back from the committed tree
+	gitlinks, err := recordedGitlinks(repo)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]objects.ObjectID{"lib": libHead}, gitlinks)
+
+	// A removed working tree is restored from modules/
+	require.NoError(t, os.RemoveAll("lib"))
+	out, err := runSubmoduleArgs("status")
+	require.NoError(t, err)
+	assert.Equal(t, "-"+libHead.String()+" lib\n", out)
+
+	out, err = runSubmoduleArgs("update")
+	require.NoError(t, err)
+	assert.Equal(t, "Submodule path 'lib': checked out '"+libHead.String()+"'\n", out)
+	content, err := os.ReadFile("lib/README")
+	require.NoError(t, err)
+	assert.Equal(t, "library\n", string(content))
+
+	// A commit the submodule does not have yet is fetched
+	blob, err := lib.CreateBlob([]byte("library v2\n"))
+	require.NoError(t, err)
+	tree, err := lib.CreateTree([]objects.TreeEntry{{Mode: objects.ModeBlob, Name: "README", ID: blob.ID()}})
+	require.NoError(t, err)
+	sig := objects.Signature{Name: "Test", Email: "test@example.com", When: time.Unix(1700000100, 0)}
+	next, err := lib.CreateCommit(tree.ID(), []objects.ObjectID{libHead}, sig, sig, "Update\n")
+	require.NoError(t, err)
+	require.NoError(t, refs.NewRefManager(lib.GitDir()).UpdateRef("refs/heads/main", next.ID()))
+
+	idx := index.New()
+	require.NoError(t, idx.Add(&index.Entry{Mode: objects.ModeCommit, ID: next.ID(), Path: "lib"}))
+	require.NoError(t, idx.WriteToFile(filepath.Join(repo.GitDir(), "index")))
+
+	out, err = runSubmoduleArgs("status")
+	require.NoError(t, err)
+	assert.Equal(t, "+"+libHead.String()+" lib (heads/main)\n", out)
+
+	_, err = runSubmoduleArgs("update")
+	require.NoError(t, err)
+	content, err = os.ReadFile("lib/README")
+	require.NoError(t, err)
+	assert.Equal(t, "library v2\n", string(content))
+	out, err = runSubmoduleArgs("status")
+	require.NoError(t, err)
+	assert.Equal(t, " "+next.ID().String()+" lib (remotes/origin/main)\n", out)
+}
+
+func TestSubmoduleInitAndForeach(t *testing.T) {
+	repo, _ := setupConfigRepo(t)
+	_, libHead, url := serveLibrary(t)
+
+	_, err := runSubmoduleArgs("add", "--name", "library", url, "lib")
+	require.NoError(t, err)
+
+	// Drop the registration, as a fresh clone has none
+	file, err := os.ReadFile(filepath.Join(repo.GitDir(), "config"))
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile(filepath.Join(repo.GitDir(), "config"), []byte(strings.Split(string(file), `[submodule "library"]`)[0]), 0644))
+	out, err := runSubmoduleArgs("init")
+	require.NoError(t, err)
+	assert.Equal(t, "Submodule 'library' ("+url+") registered for path 'lib'\n", out)
+	out, err = runSubmoduleArgs("init")
+	require.NoError(t, err)
+	assert.Empty(t, out)
+
+	out, err = runSubmoduleArgs("foreach", `echo "$name $sm_path $sha1"; test -f README`)
+	require.NoError(t, err)
+	assert.Equal(t, "Entering 'lib'\nlibrary lib "+libHead.String()+"\n", out)
+
+	_, err = runSubmoduleArgs("foreach", "false")
+	assert.ErrorContains(t, err, "stopping at 'lib'")
+}
+
+func TestSubmoduleRelativeURL(t *testing.T) {
+	repo, _ := setupConfigRepo(t)
+	_, _, url := serveLibrary(t)
+	require.NoError(t, writeRemoteConfig(repo, "origin", url+"/app.git"))
+
+	_, err := runSubmoduleArgs("add", "../", "lib")
+	require.NoError(t, err)
+	modules, err := os.ReadFile(".gitmodules")
+	require.NoError(t, err)
+	assert.Contains(t, string(modules), "url = ../")
+	value, _ := loadConfig(repo.GitDir()).Get("submodule.lib.url")
+	assert.Equal(t, url, value)
+}
+
+func TestCloneRecurseSubmodules(t *testing.T) {
+	setupConfigRepo(t)
+	_, libHead, libURL := serveLibrary(t)
+
+	app, err := vcs.Init(filepath.Join(t.TempDir(), "app"))
+	require.NoError(t, err)
+	modules, err := app.CreateBlob([]byte("[submodule \"lib\"]\n\tpath = lib\n\turl = " + libURL + "\n"))
+	require.NoError(t, err)
+	tree, err := app.CreateTree([]objects.TreeEntry{
+		{Mode: objects.ModeBlob, Name: ".gitmodules", ID: modules.ID()},
+		{Mode: objects.ModeCommit, Name: "lib", ID: libHead},
+	})
+	require.NoError(t, err)
+	sig := objects.Signature{Name: "Test", Email: "test@example.com", When: time.Unix(1700000000, 0)}
+	commit, err := app.CreateCommit(tree.ID(), nil, sig, sig, "Add lib\n")
+	require.NoError(t, err)
+	require.NoError(t, refs.NewRefManager(app.GitDir()).UpdateRef("refs/heads/main", commit.ID()))
+	hs := httptest.NewServer(serve.NewServer(app.GitDir(), app.Storage()))
+	t.Cleanup(hs.Close)
+
+	out, err := captureStdout(t, func() error {
+		_, err := runCommandArgs(newCloneCommand(), "--recurse-submodules", hs.URL, "app")
+		return err
+	})
+	require.NoError(t, err)
+	assert.Contains(t, out, "Cloning into 'app'...")
+
+	content, err := os.ReadFile("app/lib/README")
+	require.NoError(t, err)
+	assert.Equal(t, "library\n", string(content))
+	head, err := submoduleHead("app/lib")
+	require.NoError(t, err)
+	assert.Equal(t, libHead, head)
+	assert.DirExists(t, filepath.Join("app", ".git", "modules", "lib"))
+}
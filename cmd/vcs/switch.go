@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/fenilsonani/vcs/internal/core/index"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+	"github.com/spf13/cobra"
+)
+
+func newSwitchCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "switch [flags] <branch>",
+		Short: "Switch branches",
+		Long: `Switch to a specified branch. The working tree and the index are updated
+to match the branch. With --orphan, a new branch with no history is created
+and the index and tracked files are cleared, ready for an unrelated first commit.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: runSwitch,
+	}
+
+	cmd.Flags().StringP("create", "c", "", "Create a new branch and switch to it")
+	cmd.Flags().String("orphan", "", "Create a new unborn branch with an empty index and working tree")
+	cmd.Flags().BoolP("force", "f", false, "Discard local changes")
+	addWorktreeOutputFlags(cmd)
+
+	return cmd
+}
+
+func runSwitch(cmd *cobra.Command, args []string) error {
+	// Find repository
+	repoPath, err := findRepository()
+	if err != nil {
+		return fmt.Errorf("not a git repository: %w", err)
+	}
+
+	// Open repository
+	repo, err := openRepository(repoPath)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+
+	// Get flags
+	create, _ := cmd.Flags().GetString("create")
+	orphan, _ := cmd.Flags().GetString("orphan")
+	force, _ := cmd.Flags().GetBool("force")
+
+	refManager := refs.NewRefManager(repo.GitDir())
+
+	switch {
+	case orphan != "":
+		if create != "" {
+			return fmt.Errorf("options '--orphan' and '--create' cannot be used together")
+		}
+		if len(args) > 0 {
+			return fmt.Errorf("--orphan does not take a start point")
+		}
+		return createOrphanBranch(cmd, repo, refManager, orphan, true, force)
+
+	case create != "":
+		if len(args) == 0 {
+			return createAndCheckoutBranch(cmd, repo, refManager, create, force)
+		}
+
+		// Create the branch at the given start point, then switch to it
+		startID, err := resolveCommitish(repo, args[0])
+		if err != nil {
+			return fmt.Errorf("invalid start point: %s: %w", args[0], err)
+		}
+		if !refManager.IsValidRef("refs/heads/" + create) {
+			return fmt.Errorf("invalid branch name: %s", create)
+		}
+		if refManager.RefExists("refs/heads/"+create) && !force {
+			return fmt.Errorf("a branch named '%s' already exists", create)
+		}
+		if err := refManager.CreateBranch(create, startID); err != nil {
+			return fmt.Errorf("failed to create branch: %w", err)
+		}
+		return checkoutCommit(cmd, repo, refManager, repoPath, startID, create, force)
+
+	default:
+		if len(args) == 0 {
+			return fmt.Errorf("missing branch name")
+		}
+
+		branch := args[0]
+		targetID, err := refManager.ResolveRef("refs/heads/" + branch)
+		if err != nil {
+			return fmt.Errorf("invalid reference: %s", branch)
+		}
+		return checkoutCommit(cmd, repo, refManager, repoPath, targetID, branch, force)
+	}
+}
+
+// createOrphanBranch points HEAD at a new unborn branch. The next commit on
+// it has no parents. With clear set, the index is emptied and files tracked
+// by the previous HEAD are removed; otherwise they stay staged so the first
+// commit records the current tree.
+func createOrphanBranch(cmd *cobra.Command, repo *vcs.Repository, refManager *refs.RefManager, branchName string, clear, force bool) error {
+	branchRef := "refs/heads/" + branchName
+	if !refManager.IsValidRef(branchRef) {
+		return fmt.Errorf("invalid branch name: %s", branchName)
+	}
+	if refManager.RefExists(branchRef) {
+		return fmt.Errorf("a branch named '%s' already exists", branchName)
+	}
+
+	// Tree of the commit we are leaving, if any
+	var headTree *objects.Tree
+	headID, _, err := refManager.HEAD()
+	if err == nil && !headID.IsZero() {
+		commit, err := repo.GetCommit(headID)
+		if err != nil {
+			return fmt.Errorf("failed to read HEAD commit: %w", err)
+		}
+		if headTree, err = repo.GetTree(commit.Tree()); err != nil {
+			return fmt.Errorf("failed to read HEAD tree: %w", err)
+		}
+	}
+
+	indexPath := filepath.Join(repo.GitDir(), "index")
+	idx := index.New()
+	if clear {
+		if !force {
+			hasChanges, err := hasUncommittedChanges(repo, refManager)
+			if err != nil {
+				return fmt.Errorf("failed to check for changes: %w", err)
+			}
+			if hasChanges {
+				return fmt.Errorf("your local changes would be overwritten by switch. Use -f to force")
+			}
+		}
+
+		if headTree != nil {
+			if err := removeTreeFromWorkingDirectory(repo, headTree, repo.WorkDir()); err != nil {
+				return fmt.Errorf("failed to clear working directory: %w", err)
+			}
+		}
+	} else {
+		// The files of the current index stay staged
+		if idx, err = readIndex(repo); err != nil {
+			return err
+		}
+	}
+
+	if err := idx.WriteToFile(indexPath); err != nil {
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+
+	if err := refManager.SetHEAD(branchRef); err != nil {
+		return fmt.Errorf("failed to update HEAD: %w", err)
+	}
+
+	fmt.Fprintf(cmd.OutOrStdout(), "Switched to a new branch '%s'\n", branchName)
+	runPostCheckoutHook(cmd.ErrOrStderr(), repo.WorkDir(), repo.GitDir(), headID, objects.ObjectID{}, true)
+	return nil
+}
+
+// removeTreeFromWorkingDirectory deletes the files recorded in tree, leaving
+// untracked files alone. Directories are removed once they become empty.
+func removeTreeFromWorkingDirectory(repo *vcs.Repository, tree *objects.Tree, basePath string) error {
+	for _, entry := range tree.Entries() {
+		fullPath := filepath.Join(basePath, entry.Name)
+
+		if entry.Mode == objects.ModeTree {
+			subtree, err := repo.GetTree(entry.ID)
+			if err != nil {
+				return fmt.Errorf("failed to get subtree %s: %w", entry.ID.Short(), err)
+			}
+			if err := removeTreeFromWorkingDirectory(repo, subtree, fullPath); err != nil {
+				return err
+			}
+			// Only succeeds when no untracked files remain
+			os.Remove(fullPath)
+			continue
+		}
+		if entry.Mode == objects.ModeCommit {
+			// A checked-out submodule stays, as in Git
+			os.Remove(fullPath)
+			continue
+		}
+
+		if err := os.Remove(fullPath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", fullPath, err)
+		}
+	}
+	return nil
+}
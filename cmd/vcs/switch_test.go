@@ -0,0 +1,160 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/index"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// setupSwitchRepo creates a repository with one commit on main tracking a.txt
+// and an untracked notes.txt, and changes into it
+func setupSwitchRepo(t *testing.T) (*vcs.Repository, objects.ObjectID) {
+	repoPath := t.TempDir()
+	repo, err := vcs.Init(repoPath)
+	require.NoError(t, err)
+
+	sig := objects.Signature{Name: "Test", Email: "test@example.com", When: time.Now()}
+	blob, err := repo.CreateBlob([]byte("a\n"))
+	require.NoError(t, err)
+	tree, err := repo.CreateTree([]objects.TreeEntry{{Mode: objects.ModeBlob, Name: "a.txt", ID: blob.ID()}})
+	require.NoError(t, err)
+	commit, err := repo.CreateCommit(tree.ID(), nil, sig, sig, "initial\n")
+	require.NoError(t, err)
+
+	refManager := refs.NewRefManager(repo.GitDir())
+	require.NoError(t, refManager.UpdateRef("refs/heads/main", commit.ID()))
+	require.NoError(t, refManager.SetHEAD("refs/heads/main"))
+
+	require.NoError(t, os.WriteFile(filepath.Join(repoPath, "a.txt"), []byte("a\n"), 0644))
+	require.NoError(t, os.WriteFile(filepath.Join(repoPath, "notes.txt"), []byte("untracked\n"), 0644))
+
+	oldWd, _ := os.Getwd()
+	t.Cleanup(func() { os.Chdir(oldWd) })
+	require.NoError(t, os.Chdir(repoPath))
+
+	return repo, commit.ID()
+}
+
+func runSwitchArgs(args ...string) (string, error) {
+	cmd := newSwitchCommand()
+	var buf bytes.Buffer
+	cmd.SetOut(&buf)
+	cmd.SetErr(&buf)
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+	return buf.String(), err
+}
+
+func TestSwitchOrphan(t *testing.T) {
+	repo, _ := setupSwitchRepo(t)
+
+	output, err := runSwitchArgs("--orphan", "gh-pages")
+	require.NoError(t, err)
+	assert.Contains(t, output, "Switched to a new branch 'gh-pages'")
+
+	head, err := os.ReadFile(filepath.Join(repo.GitDir(), "HEAD"))
+	require.NoError(t, err)
+	assert.Equal(t, "ref: refs/heads/gh-pages\n", string(head))
+	assert.NoFileExists(t, filepath.Join(repo.GitDir(), "refs", "heads", "gh-pages"))
+
+	// Tracked files are removed, untracked ones are kept
+	assert.NoFileExists(t, filepath.Join(repo.WorkDir(), "a.txt"))
+	assert.FileExists(t, filepath.Join(repo.WorkDir(), "notes.txt"))
+
+	// The first commit on the orphan branch has no parents and stays on it
+	blob, err := repo.CreateBlob([]byte("<html></html>\n"))
+	require.NoError(t, err)
+	idx := index.New()
+	require.NoError(t, idx.Add(&index.Entry{Mode: objects.ModeBlob, ID: blob.ID(), Path: "index.html", Size: 14}))
+	require.NoError(t, idx.WriteToFile(filepath.Join(repo.GitDir(), "index")))
+
+	commitCmd := newCommitCommand()
+	commitCmd.SetArgs([]string{"-m", "Pages root"})
+	require.NoError(t, commitCmd.Execute())
+
+	refManager := refs.NewRefManager(repo.GitDir())
+	branch, err := refManager.CurrentBranch()
+	require.NoError(t, err)
+	assert.Equal(t, "gh-pages", branch)
+
+	tipID, err := refManager.ResolveRef("refs/heads/gh-pages")
+	require.NoError(t, err)
+	tip, err := repo.GetCommit(tipID)
+	require.NoError(t, err)
+	assert.Empty(t, tip.Parents())
+}
+
+func TestCheckoutOrphanKeepsFiles(t *testing.T) {
+	repo, _ := setupSwitchRepo(t)
+
+	cmd := newCheckoutCommand()
+	var buf bytes.Buffer
+	cmd.SetOut(&buf)
+	cmd.SetArgs([]string{"--orphan", "fresh"})
+	require.NoError(t, cmd.Execute())
+
+	assert.FileExists(t, filepath.Join(repo.WorkDir(), "a.txt"))
+
+	idx := index.New()
+	require.NoError(t, idx.ReadFromFile(filepath.Join(repo.GitDir(), "index")))
+	_, staged := idx.Get("a.txt")
+	assert.True(t, staged, "files from the previous HEAD should stay staged")
+}
+
+func TestSwitchErrors(t *testing.T) {
+	setupSwitchRepo(t)
+
+	_, err := runSwitchArgs("--orphan", "main")
+	assert.ErrorContains(t, err, "already exists")
+
+	_, err = runSwitchArgs("--orphan", "new", "main")
+	assert.ErrorContains(t, err, "start point")
+
+	_, err = runSwitchArgs("missing")
+	assert.ErrorContains(t, err, "invalid reference")
+}
+
+func TestSwitchCreateFromStartPoint(t *testing.T) {
+	repo, commitID := setupSwitchRepo(t)
+
+	output, err := runSwitchArgs("-c", "topic", "main")
+	require.NoError(t, err)
+	assert.Contains(t, output, "Switched to branch 'topic'")
+
+	ref, err := os.ReadFile(filepath.Join(repo.GitDir(), "refs", "heads", "topic"))
+	require.NoError(t, err)
+	assert.Equal(t, commitID.String(), strings.TrimSpace(string(ref)))
+}
+
+func TestCreateBranchFromRevision(t *testing.T) {
+	repo, first := setupSwitchRepo(t)
+	refManager := refs.NewRefManager(repo.GitDir())
+	second := commitFiles(t, repo, map[string]string{"a.txt": "a\n"}, []objects.ObjectID{first}, "second\n")
+	require.NoError(t, refManager.UpdateRef("refs/heads/main", second))
+
+	// Start points are any revision, as elsewhere
+	require.NoError(t, createBranchOperation(repo, refManager, "parent", "HEAD^"))
+	_, err := runSwitchArgs("-c", "back", "HEAD~1")
+	require.NoError(t, err)
+	_, err = runSwitchArgs("-c", "short", second.Short())
+	require.NoError(t, err)
+	for name, want := range map[string]objects.ObjectID{"parent": first, "back": first, "short": second} {
+		id, err := refManager.ResolveRef("refs/heads/" + name)
+		require.NoError(t, err)
+		assert.Equal(t, want, id, name)
+	}
+
+	_, err = runSwitchArgs("-c", "bad", "HEAD~5")
+	assert.ErrorContains(t, err, "invalid start point: HEAD~5")
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/refs"
+)
+
+// symbolicRefOptions holds the flags of symbolic-ref
+type symbolicRefOptions struct {
+	quiet   bool
+	short   bool
+	delete  bool
+	message string
+}
+
+func newSymbolicRefCommand() *cobra.Command {
+	var opts symbolicRefOptions
+
+	cmd := &cobra.Command{
+		Use:   "symbolic-ref [flags] <name> [<ref>]",
+		Short: "Read, modify and delete symbolic refs",
+		Long: `Prints the ref the symbolic ref name points to, such as the branch of
+HEAD, or with a ref points name at it; the ref must be under refs/. -d
+deletes the symbolic ref, which HEAD cannot be.
+
+Reading a ref that is not symbolic, such as a detached HEAD, fails; -q
+makes it fail silently. --short prints the shortest unambiguous name, as
+main for refs/heads/main. With -m the change is recorded in the reflog
+of name with that message.`,
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repoPath, err := findRepository()
+			if err != nil {
+				return fmt.Errorf("not a git repository: %w", err)
+			}
+			repo, err := openRepository(repoPath)
+			if err != nil {
+				return fmt.Errorf("failed to open repository: %w", err)
+			}
+			refManager := refs.NewRefManager(repo.GitDir())
+			name := args[0]
+
+			switch {
+			case opts.delete:
+				if len(args) > 1 {
+					return fmt.Errorf("-d takes only the name of the ref to delete")
+				}
+				if name == "HEAD" {
+					return fmt.Errorf("deleting '%s' is not allowed", name)
+				}
+				if _, ok, err := refManager.SymbolicRef(name); err != nil {
+					return err
+				} else if !ok {
+					return fmt.Errorf("ref %s is not a symbolic ref", name)
+				}
+				return refManager.DeleteRef(name)
+
+			case len(args) == 2:
+				target := args[1]
+				if !strings.HasPrefix(target, "refs/") {
+					return fmt.Errorf("refusing to point %s outside of refs/", name)
+				}
+				oldID, _ := refManager.ResolveRef(name)
+				if err := refManager.SetSymbolicRef(name, target); err != nil {
+					return fmt.Errorf("failed to update %s: %w", name, err)
+				}
+				if opts.message != "" {
+					newID, _ := refManager.ResolveRef(target)
+					logRefUpdate(refManager, name, oldID, newID, opts.message)
+				}
+				return nil
+			}
+
+			target, ok, err := refManager.SymbolicRef(name)
+			if err != nil {
+				return err
+			}
+			if !ok {
+				if opts.quiet {
+					cmd.SilenceErrors = true
+					cmd.SilenceUsage = true
+				}
+				return fmt.Errorf("ref %s is not a symbolic ref", name)
+			}
+			if opts.short {
+				target = shortRefName(target)
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), target)
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVarP(&opts.quiet, "quiet", "q", false, "Fail silently when name is not a symbolic ref")
+	cmd.Flags().BoolVar(&opts.short, "short", false, "Print the short name of the ref")
+	cmd.Flags().BoolVarP(&opts.delete, "delete", "d", false, "Delete the symbolic ref")
+	cmd.Flags().StringVarP(&opts.message, "message", "m", "", "Record the change in the reflog with this message")
+
+	return cmd
+}
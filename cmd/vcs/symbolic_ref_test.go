@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/refs"
+)
+
+func TestSymbolicRef(t *testing.T) {
+	setupTreeRepo(t, map[string]string{"a.txt": "a\n"})
+
+	out, err := runCommandArgs(newSymbolicRefCommand(), "HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, "refs/heads/main\n", out)
+
+	_, err = runCommandArgs(newSymbolicRefCommand(), "HEAD", "refs/heads/topic")
+	require.NoError(t, err)
+	out, err = runCommandArgs(newSymbolicRefCommand(), "--short", "HEAD")
+	require.NoError(t, err)
+	assert.Equal(t, "topic\n", out)
+
+	_, err = runCommandArgs(newSymbolicRefCommand(), "HEAD", "topic")
+	assert.ErrorContains(t, err, "outside of refs/")
+	_, err = runCommandArgs(newSymbolicRefCommand(), "-d", "HEAD")
+	assert.ErrorContains(t, err, "not allowed")
+
+	_, err = runCommandArgs(newSymbolicRefCommand(), "refs/heads/alias", "refs/heads/main")
+	require.NoError(t, err)
+	_, err = runCommandArgs(newSymbolicRefCommand(), "-d", "refs/heads/alias")
+	require.NoError(t, err)
+	assert.False(t, refs.NewRefManager(".git").RefExists("refs/heads/alias"))
+
+	_, err = runCommandArgs(newSymbolicRefCommand(), "-q", "refs/heads/main")
+	assert.Error(t, err)
+}
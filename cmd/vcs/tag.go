@@ -2,16 +2,18 @@ package main
 
 import (
 	"fmt"
-	"os"
-	"path/filepath"
+	"io"
+	"path"
 	"sort"
 	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
 
+	"github.com/fenilsonani/vcs/internal/core/config"
 	"github.com/fenilsonani/vcs/internal/core/objects"
 	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/internal/core/signing"
 	"github.com/fenilsonani/vcs/pkg/vcs"
 )
 
@@ -22,28 +24,111 @@ func newTagCommand() *cobra.Command {
 		annotated bool
 		message   string
 		force     bool
+		sign      bool
+		localUser string
+		verify    bool
+		lines     int
+		sortKey   string
+		contains  *revisionsFlag
+		pointsAt  *revisionsFlag
 	)
 
 	cmd := &cobra.Command{
 		Use:   "tag [flags] [<tagname>] [<commit>]",
 		Short: "Create, list, delete or verify a tag object signed with GPG",
 		Long: `Create, list, delete tags. Tags are refs that point to specific points in Git history.
-Lightweight tags are simple references to commits, while annotated tags are objects with metadata.`,
+Lightweight tags are simple references to commits, while annotated tags are objects with metadata.
+
+-s makes a signed annotated tag with the key configured as for commit -S,
+and -u with the key given; tag.gpgSign signs every annotated tag. -v checks
+the signature of the tags named, as verify-tag does.
+
+An annotated tag, made with -a or -m, is a tag object. It may tag any
+object, such as a tree or blob named "HEAD:file", as a lightweight tag may.
+
+Tags are listed by name, or those matching the patterns given with -l.
+-n lists each with the first line of its message, or of the commit it
+tags when it is lightweight; -n=<num> shows that many lines. --sort orders
+them by refname, version:refname (v:refname), creatordate, taggerdate or
+objectname, reversed when the key starts with "-", and defaults to
+tag.sort. --contains lists only the tags whose commit contains the one
+given, and --points-at those pointing at the object given, directly or
+through a tag object; both default to HEAD, and take the argument after
+them when it names a commit or object, as in "--contains v1.0". -n,
+--contains and --points-at imply -l.
+
+--format lists each tag as the format says, with the fields "vcs
+for-each-ref" takes, such as "%(refname:short) %(creatordate:short)".
+--json, or --format=json, lists the tags as JSON, each with the object
+it points to, the commit it comes to and, for an annotated tag, its
+tagger and message.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			repo, err := findRepository()
 			if err != nil {
 				return err
 			}
 
-			vcsRepo, err := vcs.Open(repo)
+			vcsRepo, err := openRepository(repo)
 			if err != nil {
 				return err
 			}
 
 			refManager := refs.NewRefManager(vcsRepo.GitDir())
 
-			if list || len(args) == 0 {
-				return listTags(vcsRepo, refManager)
+			if verify {
+				if len(args) == 0 {
+					return fmt.Errorf("no tag given to verify")
+				}
+				return runVerify(cmd, args, objects.TypeTag, false)
+			}
+
+			implied := cmd.Flags().Changed("lines") || cmd.Flags().Changed("contains") || cmd.Flags().Changed("points-at")
+			if list || implied || len(args) == 0 {
+				filter := tagFilter{patterns: args, sortKey: sortKey}
+				if !cmd.Flags().Changed("sort") {
+					filter.sortKey, _ = loadConfig(vcsRepo.GitDir()).Get("tag.sort")
+				}
+				resolver := newResolver(vcsRepo)
+				taken := make(map[int]bool)
+				containsRevs := contains.claimArgs(args, taken, func(arg string) bool {
+					_, err := resolver.ResolveCommit(arg)
+					return err == nil
+				})
+				pointsAtRevs := pointsAt.claimArgs(args, taken, func(arg string) bool {
+					_, err := resolver.Resolve(arg)
+					return err == nil
+				})
+				filter.patterns = unclaimedArgs(args, taken)
+				for _, rev := range containsRevs {
+					id, err := resolver.ResolveCommit(rev)
+					if err != nil {
+						return fmt.Errorf("malformed object name %s: %w", rev, err)
+					}
+					filter.contains = append(filter.contains, id)
+				}
+				for _, rev := range pointsAtRevs {
+					id, err := resolver.Resolve(rev)
+					if err != nil {
+						return fmt.Errorf("malformed object name %s: %w", rev, err)
+					}
+					filter.pointsAt = append(filter.pointsAt, id)
+				}
+				tags, err := selectTags(vcsRepo, refManager, filter)
+				if err != nil {
+					return err
+				}
+
+				if format, _ := cmd.Flags().GetString("format"); format != "" && format != "json" {
+					return formatTags(cmd.OutOrStdout(), vcsRepo, refManager, tags, format)
+				}
+				asJSON, err := jsonRequested(cmd)
+				if err != nil {
+					return err
+				}
+				if asJSON {
+					return listTagsJSON(cmd.OutOrStdout(), tags)
+				}
+				return listTags(cmd.OutOrStdout(), vcsRepo, tags, lines)
 			}
 
 			tagName := args[0]
@@ -58,7 +143,21 @@ Lightweight tags are simple references to commits, while annotated tags are obje
 				target = args[1]
 			}
 
-			return createTag(vcsRepo, refManager, tagName, target, annotated, message, force)
+			var signer *signing.Signer
+			if !sign && localUser == "" && (annotated || message != "") {
+				if value, ok := loadConfig(vcsRepo.GitDir()).Get("tag.gpgSign"); ok {
+					if sign, err = config.ParseBool(value); err != nil {
+						return fmt.Errorf("invalid tag.gpgSign: %w", err)
+					}
+				}
+			}
+			if sign || localUser != "" {
+				if signer, err = newSigner(vcsRepo, localUser); err != nil {
+					return err
+				}
+			}
+
+			return createTag(cmd.OutOrStdout(), vcsRepo, refManager, tagName, target, annotated, message, force, signer)
 		},
 	}
 
@@ -67,47 +166,292 @@ Lightweight tags are simple references to commits, while annotated tags are obje
 	cmd.Flags().BoolVarP(&annotated, "annotate", "a", false, "Create annotated tag")
 	cmd.Flags().StringVarP(&message, "message", "m", "", "Tag message")
 	cmd.Flags().BoolVarP(&force, "force", "f", false, "Replace existing tag")
+	cmd.Flags().BoolVarP(&sign, "sign", "s", false, "Make a signed annotated tag with the configured key")
+	cmd.Flags().StringVarP(&localUser, "local-user", "u", "", "Make a signed annotated tag with the given key")
+	cmd.Flags().BoolVarP(&verify, "verify", "v", false, "Verify the signature of the given tags")
+	cmd.Flags().IntVarP(&lines, "lines", "n", 0, "List each tag with that many lines of its message")
+	cmd.Flags().Lookup("lines").NoOptDefVal = "1"
+	cmd.Flags().StringVar(&sortKey, "sort", "", "Sort the listed tags by refname, version:refname, creatordate, taggerdate or objectname")
+	contains = newRevisionsFlag(cmd, "contains", "List only the tags containing the commit")
+	pointsAt = newRevisionsFlag(cmd, "points-at", "List only the tags pointing at the object")
+	addJSONFlags(cmd)
+	cmd.Flags().Lookup("format").Usage = "Format of each tag, as for-each-ref takes it, or json"
 
 	return cmd
 }
 
-func listTags(repo *vcs.Repository, refManager *refs.RefManager) error {
-	tagsDir := filepath.Join(repo.GitDir(), "refs", "tags")
-	
-	// Check if tags directory exists
-	if _, err := os.Stat(tagsDir); os.IsNotExist(err) {
-		return nil // No tags
+// tagFilter selects and orders the tags listed
+type tagFilter struct {
+	patterns []string
+	sortKey  string
+	contains []objects.ObjectID
+	pointsAt []objects.ObjectID
+}
+
+// listedTag is a tag with the objects it leads to
+type listedTag struct {
+	name   string
+	id     objects.ObjectID
+	tag    *objects.Tag // the tag object id names, if any
+	target objects.Object
+}
+
+// date is when the tag was made: by its tagger, or else when the commit
+// it points to was
+func (t listedTag) date() time.Time {
+	if t.tag != nil {
+		return t.tag.Tagger().When
+	}
+	if commit, ok := t.target.(*objects.Commit); ok {
+		return commit.Committer().When
 	}
+	return time.Time{}
+}
 
-	var tags []string
-	err := filepath.Walk(tagsDir, func(path string, info os.FileInfo, err error) error {
+// selectTags reads the tags filter selects, in its order
+func selectTags(repo *vcs.Repository, refManager *refs.RefManager, filter tagFilter) ([]listedTag, error) {
+	names, err := refManager.ListTags()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list tags: %w", err)
+	}
+	less, err := tagOrder(filter.sortKey)
+	if err != nil {
+		return nil, err
+	}
+
+	var listed []listedTag
+	for _, tagRef := range names {
+		name := strings.TrimPrefix(tagRef, "refs/tags/")
+		if !matchesAnyPattern(filter.patterns, name) {
+			continue
+		}
+		tag, err := readListedTag(repo, refManager, tagRef)
 		if err != nil {
-			return err
+			return nil, err
 		}
-
-		if !info.IsDir() {
-			relPath, err := filepath.Rel(tagsDir, path)
-			if err != nil {
-				return err
+		if len(filter.pointsAt) > 0 && !containsObjectID(filter.pointsAt, tag.id) && !containsObjectID(filter.pointsAt, tag.target.ID()) {
+			continue
+		}
+		if len(filter.contains) > 0 {
+			if ok, err := tagContains(repo, tag, filter.contains); err != nil {
+				return nil, err
+			} else if !ok {
+				continue
 			}
-			tags = append(tags, relPath)
 		}
-		return nil
-	})
+		listed = append(listed, tag)
+	}
+
+	sort.SliceStable(listed, func(i, j int) bool { return less(listed[i], listed[j]) })
+	return listed, nil
+}
 
+// readListedTag reads the tag tagRef, following tag objects to the object
+// they come to
+func readListedTag(repo *vcs.Repository, refManager *refs.RefManager, tagRef string) (listedTag, error) {
+	id, err := refManager.ResolveRef(tagRef)
 	if err != nil {
-		return fmt.Errorf("failed to list tags: %w", err)
+		return listedTag{}, fmt.Errorf("failed to resolve %s: %w", tagRef, err)
 	}
+	tag := listedTag{name: strings.TrimPrefix(tagRef, "refs/tags/"), id: id}
+	for {
+		obj, err := repo.GetObject(id)
+		if err != nil {
+			return listedTag{}, fmt.Errorf("failed to read %s: %w", tagRef, err)
+		}
+		annotated, ok := obj.(*objects.Tag)
+		if !ok {
+			tag.target = obj
+			return tag, nil
+		}
+		if tag.tag == nil {
+			tag.tag = annotated
+		}
+		id = annotated.Object()
+	}
+}
 
-	sort.Strings(tags)
-	for _, tag := range tags {
-		fmt.Println(tag)
+// tagContains reports whether the commit tag comes to contains any of
+// commits
+func tagContains(repo *vcs.Repository, tag listedTag, commits []objects.ObjectID) (bool, error) {
+	if tag.target.Type() != objects.TypeCommit {
+		return false, nil
+	}
+	for _, commit := range commits {
+		if ok, err := isAncestor(repo, commit, tag.target.ID()); err != nil || ok {
+			return ok, err
+		}
+	}
+	return false, nil
+}
+
+// tagOrder returns how the sort key orders tags, ties going by name. A
+// key starting with "-" reverses the order.
+func tagOrder(key string) (func(a, b listedTag) bool, error) {
+	field, reverse := strings.TrimPrefix(key, "-"), strings.HasPrefix(key, "-")
+	var less func(a, b listedTag) bool
+	switch field {
+	case "", "refname":
+		less = func(a, b listedTag) bool { return a.name < b.name }
+	case "version:refname", "v:refname":
+		less = func(a, b listedTag) bool {
+			if c := compareVersions(a.name, b.name); c != 0 {
+				return c < 0
+			}
+			return a.name < b.name
+		}
+	case "creatordate", "taggerdate":
+		less = func(a, b listedTag) bool {
+			if !a.date().Equal(b.date()) {
+				return a.date().Before(b.date())
+			}
+			return a.name < b.name
+		}
+	case "objectname":
+		less = func(a, b listedTag) bool {
+			if a.id != b.id {
+				return a.id.String() < b.id.String()
+			}
+			return a.name < b.name
+		}
+	default:
+		return nil, fmt.Errorf("unsupported sort specification '%s'", key)
+	}
+	if reverse {
+		return func(a, b listedTag) bool { return less(b, a) }, nil
+	}
+	return less, nil
+}
+
+// compareVersions compares two tag names as versions, the runs of digits
+// in them by their value, so that v1.10 comes after v1.9
+func compareVersions(a, b string) int {
+	for a != "" && b != "" {
+		aRun, aRest := splitVersionRun(a)
+		bRun, bRest := splitVersionRun(b)
+		if isDigit(aRun[0]) && isDigit(bRun[0]) {
+			aNum, bNum := strings.TrimLeft(aRun, "0"), strings.TrimLeft(bRun, "0")
+			if len(aNum) != len(bNum) {
+				return len(aNum) - len(bNum)
+			}
+			if c := strings.Compare(aNum, bNum); c != 0 {
+				return c
+			}
+		} else if c := strings.Compare(aRun, bRun); c != 0 {
+			return c
+		}
+		a, b = aRest, bRest
+	}
+	return len(a) - len(b)
+}
+
+// splitVersionRun splits off the leading run of digits or of other
+// characters of s
+func splitVersionRun(s string) (run, rest string) {
+	digits := isDigit(s[0])
+	i := 1
+	for i < len(s) && isDigit(s[i]) == digits {
+		i++
+	}
+	return s[:i], s[i:]
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
+
+// matchesAnyPattern reports whether name matches one of the shell
+// patterns, or whether there are none
+func matchesAnyPattern(patterns []string, name string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
 	}
+	return false
+}
 
+// listTags writes the names of tags, each followed by the first lines of
+// its message when lines is set
+func listTags(w io.Writer, repo *vcs.Repository, tags []listedTag, lines int) error {
+	for _, tag := range tags {
+		if lines <= 0 {
+			fmt.Fprintln(w, tag.name)
+			continue
+		}
+		message, err := tagMessageLines(repo, tag, lines)
+		if err != nil {
+			return err
+		}
+		var first string
+		var rest []string
+		if len(message) > 0 {
+			first, rest = message[0], message[1:]
+		}
+		fmt.Fprintln(w, strings.TrimRight(fmt.Sprintf("%-15s %s", tag.name, first), " "))
+		for _, line := range rest {
+			fmt.Fprintln(w, strings.TrimRight("    "+line, " "))
+		}
+	}
 	return nil
 }
 
-func createTag(repo *vcs.Repository, refManager *refs.RefManager, tagName, target string, annotated bool, message string, force bool) error {
+// tagMessageLines returns up to n lines of the message of tag without its
+// signature, or of the commit a lightweight tag points to
+func tagMessageLines(repo *vcs.Repository, tag listedTag, n int) ([]string, error) {
+	var message string
+	switch {
+	case tag.tag != nil:
+		payload, _ := signing.SplitTag([]byte(tag.tag.Message()))
+		message = string(payload)
+	case tag.target.Type() == objects.TypeCommit:
+		message = tag.target.(*objects.Commit).Message()
+	}
+	message = strings.TrimRight(message, "\n")
+	if message == "" {
+		return nil, nil
+	}
+	lines := strings.Split(message, "\n")
+	if len(lines) > n {
+		lines = lines[:n]
+	}
+	return lines, nil
+}
+
+// formatTags writes tags, in the order given, as the for-each-ref format
+// says
+func formatTags(w io.Writer, repo *vcs.Repository, refManager *refs.RefManager, tags []listedTag, format string) error {
+	parsed, err := parseRefFormat(format)
+	if err != nil {
+		return err
+	}
+	items := make([]*refItem, len(tags))
+	for i, t := range tags {
+		items[i] = &refItem{name: "refs/tags/" + t.name, id: t.id}
+	}
+	return newRefFormatter(repo, refManager).write(w, parsed, items)
+}
+
+// listTagsJSON writes tags as JSON
+func listTagsJSON(w io.Writer, tags []listedTag) error {
+	listed := []jsonTag{}
+	for _, tag := range tags {
+		entry := jsonTag{Name: tag.name, Object: tag.id.String(), Target: tag.target.ID().String()}
+		if tag.tag != nil {
+			tagger := newJSONSignature(tag.tag.Tagger())
+			entry.Annotated, entry.Tagger, entry.Message = true, &tagger, tag.tag.Message()
+		}
+		listed = append(listed, entry)
+	}
+	return writeJSON(w, listed)
+}
+
+// createTag creates the tag tagName on the object target names, annotated
+// when annotated or message is set or when signer is given to sign it
+func createTag(w io.Writer, repo *vcs.Repository, refManager *refs.RefManager, tagName, target string, annotated bool, message string, force bool, signer *signing.Signer) error {
 	// Validate tag name
 	if err := validateTagName(tagName); err != nil {
 		return err
@@ -119,43 +463,51 @@ func createTag(repo *vcs.Repository, refManager *refs.RefManager, tagName, targe
 		return fmt.Errorf("tag '%s' already exists", tagName)
 	}
 
-	// Resolve target commit
-	targetID, err := refManager.ResolveRef(target)
+	// Resolve the target, which may be an object of any type
+	targetID, err := newResolver(repo).Resolve(target)
 	if err != nil {
 		return fmt.Errorf("failed to resolve %q: %w", target, err)
 	}
-
-	// Verify target is a commit
-	_, err = repo.GetCommit(targetID)
+	targetObj, err := repo.GetObject(targetID)
 	if err != nil {
-		return fmt.Errorf("target %s is not a commit: %w", target, err)
+		return fmt.Errorf("failed to read %s: %w", target, err)
 	}
 
 	var tagObjectID objects.ObjectID
 
-	if annotated || message != "" {
+	if annotated || message != "" || signer != nil {
 		// Create annotated tag
 		if message == "" {
 			message = fmt.Sprintf("Tag %s", tagName)
 		}
-
-		tagger := objects.Signature{
-			Name:  "VCS User",
-			Email: "user@example.com",
-			When:  time.Now(),
+		// As in Git, the message ends with a newline
+		if !strings.HasSuffix(message, "\n") {
+			message += "\n"
 		}
 
-		tagObj, err := repo.CreateTag(targetID, objects.TypeCommit, tagName, tagger, message)
+		tagger, err := getSignature("")
 		if err != nil {
-			return fmt.Errorf("failed to create tag object: %w", err)
+			return err
 		}
 
-		tagObjectID = tagObj.ID()
-		fmt.Printf("Created annotated tag %s\n", tagName)
+		if signer != nil {
+			if tagObjectID, err = writeSignedTag(repo, signer, targetID, targetObj.Type(), tagName, tagger, message); err != nil {
+				return fmt.Errorf("failed to sign tag: %w", err)
+			}
+			fmt.Fprintf(w, "Created signed tag %s\n", tagName)
+		} else {
+			tagObj, err := repo.CreateTag(targetID, targetObj.Type(), tagName, tagger, message)
+			if err != nil {
+				return fmt.Errorf("failed to create tag object: %w", err)
+			}
+
+			tagObjectID = tagObj.ID()
+			fmt.Fprintf(w, "Created annotated tag %s\n", tagName)
+		}
 	} else {
 		// Create lightweight tag (just a ref)
 		tagObjectID = targetID
-		fmt.Printf("Created lightweight tag %s\n", tagName)
+		fmt.Fprintf(w, "Created lightweight tag %s\n", tagName)
 	}
 
 	// Write tag reference
@@ -167,14 +519,11 @@ func createTag(repo *vcs.Repository, refManager *refs.RefManager, tagName, targe
 }
 
 func deleteTag(repo *vcs.Repository, refManager *refs.RefManager, tagName string) error {
-	tagRef := "refs/tags/" + tagName
-	
-	if !refManager.RefExists(tagName) {
+	if !refManager.RefExists("refs/tags/" + tagName) {
 		return fmt.Errorf("tag '%s' not found", tagName)
 	}
 
-	tagPath := filepath.Join(repo.GitDir(), tagRef)
-	if err := os.Remove(tagPath); err != nil {
+	if err := refManager.DeleteTag(tagName); err != nil {
 		return fmt.Errorf("failed to delete tag: %w", err)
 	}
 
@@ -209,4 +558,65 @@ func validateTagName(name string) error {
 	}
 
 	return nil
-}
\ No newline at end of file
+}
+// revisionsFlag holds the revisions given by a flag such as --contains,
+// whose value may be left out for HEAD. Git takes the argument after such
+// a flag as its value, which the flag parser cannot, so a value left out
+// remembers how many arguments came before it, and claimArgs gives it the
+// argument there when that names a revision.
+type revisionsFlag struct {
+	cmd    *cobra.Command
+	values []string
+	at     []int // for each value, the index of the next argument, or -1
+}
+
+// newRevisionsFlag defines the flag name on cmd
+func newRevisionsFlag(cmd *cobra.Command, name, usage string) *revisionsFlag {
+	f := &revisionsFlag{cmd: cmd}
+	cmd.Flags().Var(f, name, usage)
+	cmd.Flags().Lookup(name).NoOptDefVal = "HEAD"
+	return f
+}
+
+func (f *revisionsFlag) Set(value string) error {
+	at := -1
+	if value == "HEAD" {
+		at = len(f.cmd.Flags().Args())
+	}
+	f.values = append(f.values, value)
+	f.at = append(f.at, at)
+	return nil
+}
+
+func (f *revisionsFlag) String() string {
+	return strings.Join(f.values, ",")
+}
+
+func (f *revisionsFlag) Type() string {
+	return "stringArray"
+}
+
+// claimArgs returns the revisions given, each value left out replaced by
+// the argument after it when isRevision accepts that argument and no other
+// flag has claimed it. Claimed arguments are marked in taken.
+func (f *revisionsFlag) claimArgs(args []string, taken map[int]bool, isRevision func(string) bool) []string {
+	revs := append([]string(nil), f.values...)
+	for i, at := range f.at {
+		if at >= 0 && at < len(args) && !taken[at] && isRevision(args[at]) {
+			revs[i] = args[at]
+			taken[at] = true
+		}
+	}
+	return revs
+}
+
+// unclaimedArgs returns the arguments no revisionsFlag claimed
+func unclaimedArgs(args []string, taken map[int]bool) []string {
+	rest := make([]string, 0, len(args))
+	for i, arg := range args {
+		if !taken[i] {
+			rest = append(rest, arg)
+		}
+	}
+	return rest
+}
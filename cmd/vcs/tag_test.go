@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -599,4 +600,63 @@ func TestTagValidation(t *testing.T) {
 			// Just ensure the command doesn't crash
 		})
 	}
-}
\ No newline at end of file
+}
+func TestTagListing(t *testing.T) {
+	repo, refManager := setupSeriesRepo(t, map[string]string{"a.txt": "a\n"})
+	tag := func(args ...string) string {
+		out, err := runCommandArgs(newTagCommand(), args...)
+		require.NoError(t, err)
+		return out
+	}
+	tag("v1.9")
+	head := moveMain(t, repo, refManager, map[string]string{"a.txt": "a\n", "b.txt": "b\n"})
+	tag("-m", "Release 1.10\n\nWith notes", "v1.10")
+	assert.Equal(t, "Created annotated tag blob-tag\n", tag("-m", "A file", "blob-tag", "HEAD:a.txt"))
+
+	// An annotated tag may tag any object
+	id, err := refManager.ResolveRef("refs/tags/blob-tag")
+	require.NoError(t, err)
+	obj, err := repo.GetObject(id)
+	require.NoError(t, err)
+	require.IsType(t, &objects.Tag{}, obj)
+	assert.Equal(t, objects.TypeBlob, obj.(*objects.Tag).ObjectType())
+
+	// As in Git, the message is stored ending with a newline
+	blobID, err := newResolver(repo).Resolve("HEAD:a.txt")
+	require.NoError(t, err)
+	typ, raw, err := repo.ReadRawObject(id)
+	require.NoError(t, err)
+	assert.Equal(t, objects.TypeTag, typ)
+	assert.Regexp(t, "^object "+blobID.String()+"\ntype blob\ntag blob-tag\ntagger [^\n]+\n\nA file\n$", string(raw))
+	id, err = refManager.ResolveRef("refs/tags/v1.10")
+	require.NoError(t, err)
+	_, raw, err = repo.ReadRawObject(id)
+	require.NoError(t, err)
+	assert.True(t, strings.HasSuffix(string(raw), "\n\nRelease 1.10\n\nWith notes\n"), "tag object %q", raw)
+
+	assert.Equal(t, "blob-tag\nv1.10\nv1.9\n", tag())
+	assert.Equal(t, "v1.9\nv1.10\n", tag("--sort=version:refname", "-l", "v*"))
+	assert.Equal(t, "v1.10\nv1.9\n", tag("--sort=-v:refname", "-l", "v*"))
+	_, err = runConfigArgs("--", "tag.sort", "-refname")
+	require.NoError(t, err)
+	assert.Equal(t, "v1.9\nv1.10\nblob-tag\n", tag())
+	_, err = runCommandArgs(newTagCommand(), "--sort=size")
+	assert.ErrorContains(t, err, "unsupported sort specification 'size'")
+
+	// -n shows the tag message, or the commit's for a lightweight tag
+	assert.Equal(t, "v1.9            base\nv1.10           Release 1.10\nblob-tag        A file\n", tag("-n"))
+	assert.Equal(t, "v1.10           Release 1.10\n\n    With notes\n", tag("-n=3", "v1.10"))
+
+	assert.Equal(t, "v1.10\n", tag("--contains"))
+	assert.Equal(t, "v1.9\nv1.10\n", tag("--contains=v1.9"))
+	assert.Equal(t, "v1.10\n", tag("--points-at="+head.String()))
+	assert.Equal(t, "blob-tag\n", tag("--points-at=HEAD:a.txt"))
+
+	// The value may follow as the next argument, which is otherwise a pattern
+	assert.Equal(t, "v1.10\n", tag("--contains", "HEAD"))
+	assert.Equal(t, "v1.9\nv1.10\n", tag("--contains", "v1.9"))
+	assert.Equal(t, "v1.10\n", tag("--contains", "v1.9", "v1.1*"))
+	assert.Equal(t, "v1.10\n", tag("--contains", "v1.1*"))
+	assert.Equal(t, "blob-tag\n", tag("--points-at", "HEAD:a.txt"))
+	assert.Equal(t, "v1.10\n", tag("--points-at", "HEAD", "--contains", "v1.9"))
+}
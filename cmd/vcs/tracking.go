@@ -0,0 +1,208 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/fenilsonani/vcs/internal/core/config"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/internal/revparse"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// tracking is how a branch stands against its upstream
+type tracking struct {
+	// upstream is the short name of the upstream, such as origin/main
+	upstream string
+	// gone is set when the upstream is configured but does not exist
+	gone bool
+	// different is set when the two differ but were not counted
+	different     bool
+	ahead, behind int
+}
+
+// branchTracking compares branch with its upstream, or returns nil when it
+// has none, or none fetched into a remote-tracking branch, or has no
+// commits yet. Without count it only finds whether they
+// point to the same commit, which needs no walk.
+func branchTracking(resolver *revparse.Resolver, branch string, count bool) (*tracking, error) {
+	upstream, err := resolver.Upstream(branch)
+	if errors.Is(err, revparse.ErrNoUpstream) || errors.Is(err, revparse.ErrNotTracked) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ours, err := resolver.ResolveCommit("refs/heads/" + branch)
+	if err != nil {
+		return nil, nil
+	}
+	t := &tracking{upstream: shortRefName(upstream)}
+	theirs, err := resolver.ResolveCommit(upstream)
+	if err != nil {
+		t.gone = true
+		return t, nil
+	}
+	if ours == theirs {
+		return t, nil
+	}
+	if !count {
+		t.different = true
+		return t, nil
+	}
+	t.ahead, t.behind, err = resolver.AheadBehind(ours, theirs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to compare with %s: %w", t.upstream, err)
+	}
+	return t, nil
+}
+
+// counts returns "ahead 1, behind 2" as the short status and branch -v
+// show it, empty when the branch and its upstream are equal
+func (t *tracking) counts() string {
+	switch {
+	case t.gone:
+		return "gone"
+	case t.different:
+		return "different"
+	}
+	var parts []string
+	if t.ahead > 0 {
+		parts = append(parts, fmt.Sprintf("ahead %d", t.ahead))
+	}
+	if t.behind > 0 {
+		parts = append(parts, fmt.Sprintf("behind %d", t.behind))
+	}
+	return strings.Join(parts, ", ")
+}
+
+// describe returns the lines of the long status telling how the branch
+// stands against its upstream
+func (t *tracking) describe() []string {
+	switch {
+	case t.gone:
+		return []string{
+			fmt.Sprintf("Your branch is based on '%s', but the upstream is gone.", t.upstream),
+			`  (use "vcs branch --unset-upstream" to fixup)`,
+		}
+	case t.different:
+		return []string{
+			fmt.Sprintf("Your branch and '%s' refer to different commits.", t.upstream),
+			`  (use "vcs status --ahead-behind" for details)`,
+		}
+	case t.ahead > 0 && t.behind > 0:
+		return []string{
+			fmt.Sprintf("Your branch and '%s' have diverged,", t.upstream),
+			fmt.Sprintf("and have %d and %d different commits each, respectively.", t.ahead, t.behind),
+			`  (use "vcs pull" to merge the remote branch into yours)`,
+		}
+	case t.ahead > 0:
+		return []string{
+			fmt.Sprintf("Your branch is ahead of '%s' by %s.", t.upstream, commitCount(t.ahead)),
+			`  (use "vcs push" to publish your local commits)`,
+		}
+	case t.behind > 0:
+		return []string{
+			fmt.Sprintf("Your branch is behind '%s' by %s, and can be fast-forwarded.", t.upstream, commitCount(t.behind)),
+			`  (use "vcs pull" to update your local branch)`,
+		}
+	}
+	return []string{fmt.Sprintf("Your branch is up to date with '%s'.", t.upstream)}
+}
+
+// commitCount returns "1 commit" or "n commits"
+func commitCount(n int) string {
+	if n == 1 {
+		return "1 commit"
+	}
+	return fmt.Sprintf("%d commits", n)
+}
+
+// aheadBehindEnabled reports whether status counts the commits a branch
+// is ahead and behind its upstream: --[no-]ahead-behind when given, else
+// status.aheadBehind, which defaults to true
+func aheadBehindEnabled(gitDir string, flag, noFlag, flagSet bool) bool {
+	if noFlag {
+		return false
+	}
+	if flagSet {
+		return flag
+	}
+	cfg, err := config.Load(gitDir)
+	if err != nil {
+		return true
+	}
+	value, ok := cfg.Get("status.aheadBehind")
+	if !ok {
+		return true
+	}
+	enabled, err := config.ParseBool(value)
+	return err != nil || enabled
+}
+
+// remoteTrackingRef returns the remote-tracking ref the fetch refspecs of
+// remote map ref, a ref of the remote, to, and whether there is one
+func remoteTrackingRef(gitDir, remote, ref string) (string, bool) {
+	cfg, err := config.Load(gitDir)
+	if err != nil {
+		return "", false
+	}
+	return revparse.TrackingRef(cfg, remote, ref)
+}
+
+// splitUpstream splits name, a remote-tracking branch such as origin/main
+// or a local branch, into the remote and branch a branch tracking it
+// records, with "." as the remote of a local branch
+func splitUpstream(repo *vcs.Repository, refManager *refs.RefManager, name string) (remote, branch string, err error) {
+	if name = strings.TrimPrefix(name, "refs/heads/"); refManager.RefExists("refs/heads/" + name) {
+		return ".", name, nil
+	}
+	name = strings.TrimPrefix(name, "refs/remotes/")
+	if !refManager.RefExists("refs/remotes/" + name) {
+		return "", "", fmt.Errorf("the requested upstream branch '%s' does not exist", name)
+	}
+
+	// The remote whose fetch refspecs map a branch to the name is tracked.
+	// Remote names may contain slashes, so the longest configured remote
+	// wins.
+	cfg, err := config.Load(repo.GitDir())
+	if err != nil {
+		return "", "", fmt.Errorf("failed to read config: %w", err)
+	}
+	remotes := cfg.Subsections("remote")
+	sort.Slice(remotes, func(i, j int) bool { return len(remotes[i]) > len(remotes[j]) })
+	for _, r := range remotes {
+		ref, ok := revparse.RemoteRef(cfg, r, "refs/remotes/"+name)
+		if rest, isBranch := strings.CutPrefix(ref, "refs/heads/"); ok && isBranch && rest != "" {
+			return r, rest, nil
+		}
+	}
+	remote, branch, ok := strings.Cut(name, "/")
+	if !ok || branch == "" {
+		return "", "", fmt.Errorf("'%s' is not a remote-tracking branch", name)
+	}
+	return remote, branch, nil
+}
+
+// unsetUpstream removes the upstream of branch from the repository config
+func unsetUpstream(repo *vcs.Repository, branch string) error {
+	file, err := config.ReadFile(config.Path(config.ScopeLocal, repo.GitDir()))
+	if err != nil {
+		return err
+	}
+	hadRemote, err := file.Unset("branch." + branch + ".remote")
+	if err != nil {
+		return err
+	}
+	hadMerge, err := file.Unset("branch." + branch + ".merge")
+	if err != nil {
+		return err
+	}
+	if !hadRemote && !hadMerge {
+		return fmt.Errorf("branch '%s' has no upstream information", branch)
+	}
+	return file.Save()
+}
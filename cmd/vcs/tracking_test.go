@@ -0,0 +1,178 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/config"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// setupTrackingRepo makes main two commits ahead of and one behind
+// origin/main, which it tracks
+func setupTrackingRepo(t *testing.T) (*vcs.Repository, *refs.RefManager) {
+	repo, _ := setupConfigRepo(t)
+	refManager := refs.NewRefManager(repo.GitDir())
+
+	base := commitFiles(t, repo, map[string]string{"a.txt": "a\n"}, nil, "base")
+	m1 := commitFiles(t, repo, map[string]string{"a.txt": "b\n"}, []objects.ObjectID{base}, "m1")
+	m2 := commitFiles(t, repo, map[string]string{"a.txt": "c\n"}, []objects.ObjectID{m1}, "m2")
+	o1 := commitFiles(t, repo, map[string]string{"o.txt": "o\n"}, []objects.ObjectID{base}, "o1")
+	require.NoError(t, refManager.UpdateRef("refs/heads/main", m2))
+	require.NoError(t, refManager.UpdateRef("refs/remotes/origin/main", o1))
+	require.NoError(t, refManager.SetHEAD("refs/heads/main"))
+	_, err := runConfigArgs("remote.origin.url", "https://example.com/repo.git")
+	require.NoError(t, err)
+	require.NoError(t, setUpstreamBranch(repo, "main", "origin", "main"))
+	return repo, refManager
+}
+
+func runStatusArgs(t *testing.T, args ...string) string {
+	out, err := captureStdout(t, func() error {
+		cmd := newStatusCommand()
+		cmd.SetArgs(args)
+		return cmd.Execute()
+	})
+	require.NoError(t, err)
+	return out
+}
+
+func runBranchArgs(t *testing.T, args ...string) (string, error) {
+	return captureStdout(t, func() error {
+		cmd := newBranchCommand()
+		cmd.SetArgs(args)
+		return cmd.Execute()
+	})
+}
+
+func TestStatusAheadBehind(t *testing.T) {
+	setupTrackingRepo(t)
+
+	out := runStatusArgs(t, "-s", "-b")
+	assert.Contains(t, out, "## main...origin/main [ahead 2, behind 1]\n")
+	out = runStatusArgs(t, "--porcelain", "-b", "--no-ahead-behind")
+	assert.Contains(t, out, "## main...origin/main [different]\n")
+	assert.NotContains(t, runStatusArgs(t, "-s"), "##")
+
+	out = runStatusArgs(t)
+	assert.Contains(t, out, "On branch main\n")
+	assert.Contains(t, out, "Your branch and 'origin/main' have diverged,\nand have 2 and 1 different commits each, respectively.\n")
+
+	_, err := runConfigArgs("status.aheadBehind", "false")
+	require.NoError(t, err)
+	assert.Contains(t, runStatusArgs(t), "Your branch and 'origin/main' refer to different commits.\n")
+	assert.Contains(t, runStatusArgs(t, "-sb", "--ahead-behind"), "[ahead 2, behind 1]")
+}
+
+func TestStatusUpstreamStates(t *testing.T) {
+	repo, refManager := setupTrackingRepo(t)
+	main, err := refManager.ResolveRef("refs/heads/main")
+	require.NoError(t, err)
+	origin, err := refManager.ResolveRef("refs/remotes/origin/main")
+	require.NoError(t, err)
+
+	require.NoError(t, refManager.UpdateRef("refs/remotes/origin/main", main))
+	assert.Contains(t, runStatusArgs(t), "Your branch is up to date with 'origin/main'.\n")
+	assert.Contains(t, runStatusArgs(t, "-sb"), "## main...origin/main\n")
+
+	require.NoError(t, refManager.UpdateRef("refs/heads/main", origin))
+	require.NoError(t, refManager.UpdateRef("refs/remotes/origin/main", commitFiles(t, repo, nil, []objects.ObjectID{origin}, "o2")))
+	assert.Contains(t, runStatusArgs(t), "Your branch is behind 'origin/main' by 1 commit, and can be fast-forwarded.\n")
+
+	require.NoError(t, refManager.DeleteRef("refs/remotes/origin/main"))
+	assert.Contains(t, runStatusArgs(t, "-sb"), "## main...origin/main [gone]\n")
+	assert.Contains(t, runStatusArgs(t), "Your branch is based on 'origin/main', but the upstream is gone.\n")
+}
+
+func TestTrackingFollowsFetchRefspec(t *testing.T) {
+	_, refManager := setupTrackingRepo(t)
+	main, err := refManager.ResolveRef("refs/heads/main")
+	require.NoError(t, err)
+
+	// The upstream is where the remote's refspec fetches the merge ref to
+	_, err = runConfigArgs("remote.origin.fetch", "+refs/heads/*:refs/remotes/mirror/*")
+	require.NoError(t, err)
+	require.NoError(t, refManager.UpdateRef("refs/remotes/mirror/main", main))
+	assert.Contains(t, runStatusArgs(t), "Your branch is up to date with 'mirror/main'.\n")
+	assert.Contains(t, runStatusArgs(t, "-sb"), "## main...mirror/main\n")
+
+	// Tracking a remote-tracking branch records the branch it is fetched from
+	out, err := runBranchArgs(t, "topic", "mirror/main")
+	require.NoError(t, err)
+	assert.Contains(t, out, "branch 'topic' set up to track 'mirror/main'.")
+	cfg, err := config.Load(refManager.GitDir())
+	require.NoError(t, err)
+	remote, _ := cfg.Get("branch.topic.remote")
+	merge, _ := cfg.Get("branch.topic.merge")
+	assert.Equal(t, "origin", remote)
+	assert.Equal(t, "refs/heads/main", merge)
+
+	// A merge ref no refspec fetches has no remote-tracking branch to show
+	_, err = runConfigArgs("remote.origin.fetch", "+refs/heads/other:refs/remotes/origin/other")
+	require.NoError(t, err)
+	assert.Contains(t, runStatusArgs(t, "-sb"), "## main\n")
+}
+
+func TestBranchVerboseTracking(t *testing.T) {
+	setupTrackingRepo(t)
+
+	out, err := runBranchArgs(t, "-v")
+	require.NoError(t, err)
+	assert.Contains(t, out, "[ahead 2, behind 1] m2")
+	out, err = runBranchArgs(t, "-vv")
+	require.NoError(t, err)
+	assert.Contains(t, out, "[origin/main: ahead 2, behind 1] m2")
+}
+
+func TestBranchUpstream(t *testing.T) {
+	repo, _ := setupTrackingRepo(t)
+	upstream := func(branch string) (string, string) {
+		cfg, err := config.Load(repo.GitDir())
+		require.NoError(t, err)
+		remote, _ := cfg.Get("branch." + branch + ".remote")
+		merge, _ := cfg.Get("branch." + branch + ".merge")
+		return remote, merge
+	}
+
+	// Starting from a remote-tracking branch sets up tracking
+	out, err := runBranchArgs(t, "topic", "origin/main")
+	require.NoError(t, err)
+	assert.Contains(t, out, "branch 'topic' set up to track 'origin/main'.")
+	remote, merge := upstream("topic")
+	assert.Equal(t, "origin", remote)
+	assert.Equal(t, "refs/heads/main", merge)
+
+	_, err = runBranchArgs(t, "--no-track", "plain", "origin/main")
+	require.NoError(t, err)
+	remote, _ = upstream("plain")
+	assert.Empty(t, remote)
+
+	// A local start point only with --track
+	_, err = runBranchArgs(t, "local")
+	require.NoError(t, err)
+	remote, _ = upstream("local")
+	assert.Empty(t, remote)
+	_, err = runBranchArgs(t, "--track", "local2", "main")
+	require.NoError(t, err)
+	remote, merge = upstream("local2")
+	assert.Equal(t, ".", remote)
+	assert.Equal(t, "refs/heads/main", merge)
+
+	_, err = runBranchArgs(t, "-u", "main", "plain")
+	require.NoError(t, err)
+	remote, _ = upstream("plain")
+	assert.Equal(t, ".", remote)
+	_, err = runBranchArgs(t, "--unset-upstream", "plain")
+	require.NoError(t, err)
+	remote, _ = upstream("plain")
+	assert.Empty(t, remote)
+	_, err = runBranchArgs(t, "--unset-upstream", "plain")
+	assert.ErrorContains(t, err, "has no upstream information")
+
+	_, err = runBranchArgs(t, "-u", "origin/missing")
+	assert.ErrorContains(t, err, "does not exist")
+}
@@ -0,0 +1,603 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/convert"
+	"github.com/fenilsonani/vcs/internal/core/index"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/internal/oplog"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+func newUndoCommand() *cobra.Command {
+	var force, dryRun bool
+
+	cmd := &cobra.Command{
+		Use:   "undo [<n>]",
+		Short: "Undo the last operations",
+		Long: `Reverts the last <n> operations of the operation log, 1 by default,
+putting HEAD, the refs, the index and the stash back as they were before
+them. Files of the working tree the restored index changes are updated
+when they have no changes of their own, and left with a warning
+otherwise; files it no longer has are kept. Operations already undone, and undos themselves, are skipped;
+vcs timeline lists them all.
+
+Undo refuses when something an operation changed has changed since
+without being logged, unless --force is given.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			n := 1
+			if len(args) == 1 {
+				var err error
+				if n, err = strconv.Atoi(args[0]); err != nil || n < 1 {
+					return fmt.Errorf("invalid number of operations: %s", args[0])
+				}
+			}
+
+			repoPath, err := findRepository()
+			if err != nil {
+				return fmt.Errorf("not a vcs repository: %w", err)
+			}
+			repo, err := openRepository(repoPath)
+			if err != nil {
+				return fmt.Errorf("failed to open repository: %w", err)
+			}
+			return runUndo(cmd.OutOrStdout(), cmd.ErrOrStderr(), repo, n, force, dryRun)
+		},
+	}
+
+	cmd.Flags().BoolVarP(&force, "force", "f", false, "Undo even what has changed since without being logged")
+	cmd.Flags().BoolVarP(&dryRun, "dry-run", "n", false, "Show what would be undone without undoing it")
+	return cmd
+}
+
+func newTimelineCommand() *cobra.Command {
+	var maxCount int
+
+	cmd := &cobra.Command{
+		Use:   "timeline",
+		Short: "Show the operation log",
+		Long: `Lists the operations of the operation log, newest first: each command
+that changed HEAD, the refs, the index or the stash, when it ran and what
+it changed. Operations that were undone are marked; vcs undo reverts the
+latest of the others.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repoPath, err := findRepository()
+			if err != nil {
+				return fmt.Errorf("not a vcs repository: %w", err)
+			}
+			repo, err := openRepository(repoPath)
+			if err != nil {
+				return fmt.Errorf("failed to open repository: %w", err)
+			}
+			ops, err := oplog.Read(repo.GitDir())
+			if err != nil {
+				return err
+			}
+
+			out := cmd.OutOrStdout()
+			for i, shown := len(ops)-1, 0; i >= 0 && (maxCount <= 0 || shown < maxCount); i, shown = i-1, shown+1 {
+				printOperation(out, ops[i])
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().IntVarP(&maxCount, "max-count", "n", 0, "Show at most this many operations")
+	return cmd
+}
+
+// printOperation writes op and what it changed for timeline
+func printOperation(out io.Writer, op *oplog.Operation) {
+	fmt.Fprintf(out, "#%d %s %s", op.ID, op.Time.Local().Format("2006-01-02 15:04:05"), op.Command)
+	if op.UndoneBy != 0 {
+		fmt.Fprintf(out, " (undone by #%d)", op.UndoneBy)
+	}
+	fmt.Fprintln(out)
+
+	if op.Before.Head != op.After.Head {
+		fmt.Fprintf(out, "    HEAD %s -> %s\n", describeHead(op.Before.Head), describeHead(op.After.Head))
+	}
+	names := op.Before.ChangedRefs(op.After)
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(out, "    %s %s -> %s\n", name, shortOperationID(op.Before.Refs[name]), shortOperationID(op.After.Refs[name]))
+	}
+	if op.Before.Index != op.After.Index {
+		fmt.Fprintln(out, "    index")
+	}
+	if op.Before.Stash != op.After.Stash {
+		fmt.Fprintln(out, "    stash list")
+	}
+}
+
+// describeHead shows the content of HEAD as the branch or commit it names
+func describeHead(head string) string {
+	if name, ok := strings.CutPrefix(head, "ref: "); ok {
+		return name
+	}
+	return shortOperationID(head)
+}
+
+func shortOperationID(id string) string {
+	if id == "" {
+		return "(none)"
+	}
+	if len(id) > 7 {
+		return id[:7]
+	}
+	return id
+}
+
+// repoSnapshot is the state of a repository as the operation log records
+// it, with the index it names so that it is only stored when it changed
+type repoSnapshot struct {
+	state oplog.State
+	index []byte
+}
+
+// takeSnapshot reads the state of HEAD, the refs, the index and the stash.
+// The index is recorded by its entries alone, so refreshing file stats is
+// not a change.
+func takeSnapshot(repo *vcs.Repository) (*repoSnapshot, error) {
+	head, err := os.ReadFile(filepath.Join(repo.GitDir(), "HEAD"))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read HEAD: %w", err)
+	}
+	snap := &repoSnapshot{state: oplog.State{Head: strings.TrimSpace(string(head)), Refs: make(map[string]string)}}
+
+	allRefs, err := refs.NewRefManager(repo.GitDir()).AllRefs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list refs: %w", err)
+	}
+	for name, id := range allRefs {
+		snap.state.Refs[name] = id.String()
+	}
+
+	indexPath := filepath.Join(repo.GitDir(), "index")
+	if _, err := os.Stat(indexPath); err == nil {
+		idx := index.New()
+		if err := idx.ReadFromFile(indexPath); err != nil {
+			return nil, fmt.Errorf("failed to read index: %w", err)
+		}
+		canonical := index.New()
+		for _, entry := range idx.Entries() {
+			if err := canonical.Add(&index.Entry{Mode: entry.Mode, ID: entry.ID, Path: entry.Path, Flags: entry.Flags,
+				SkipWorktree: entry.SkipWorktree, IntentToAdd: entry.IntentToAdd}); err != nil {
+				return nil, fmt.Errorf("failed to read index: %w", err)
+			}
+		}
+		var buf bytes.Buffer
+		if err := canonical.WriteTo(&buf); err != nil {
+			return nil, fmt.Errorf("failed to read index: %w", err)
+		}
+		snap.index = buf.Bytes()
+		snap.state.Index = repo.HashData(snap.index).String()
+	}
+
+	stash, err := os.ReadFile(stashReflogPath(repo))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read stash list: %w", err)
+	}
+	snap.state.Stash = string(stash)
+	return snap, nil
+}
+
+func stashReflogPath(repo *vcs.Repository) string {
+	return filepath.Join(repo.CommonDir(), "logs", "refs", "stash")
+}
+
+// storeIndex writes the index of snap to the object database, where the
+// operation log finds it to restore it
+func (snap *repoSnapshot) storeIndex(repo *vcs.Repository) error {
+	if snap.index == nil {
+		return nil
+	}
+	if _, err := repo.CreateBlob(snap.index); err != nil {
+		return fmt.Errorf("failed to store index: %w", err)
+	}
+	return nil
+}
+
+// operationRecorder logs one command in the operation log
+type operationRecorder struct {
+	repo    *vcs.Repository
+	command string
+	start   time.Time
+	before  *repoSnapshot
+}
+
+// beginOperation snapshots repo before command runs. It returns nil when
+// the repository cannot be read, so nothing is logged.
+func beginOperation(repo *vcs.Repository, command string) *operationRecorder {
+	before, err := takeSnapshot(repo)
+	if err != nil {
+		return nil
+	}
+	return &operationRecorder{repo: repo, command: command, start: time.Now(), before: before}
+}
+
+// recordCommand begins logging the command args run, unless it runs
+// outside a repository or is one that logs itself
+func recordCommand(root *cobra.Command, args []string) *operationRecorder {
+	cmd, _, err := root.Find(args)
+	if err != nil || cmd == root || cmd.Name() == "undo" {
+		return nil
+	}
+	repoPath, err := findRepository()
+	if err != nil {
+		return nil
+	}
+	repo, err := openRepository(repoPath)
+	if err != nil || readOnlyRepository(repo.GitDir()) {
+		return nil
+	}
+	return beginOperation(repo, commandLine(args))
+}
+
+// commandLine joins args, quoting those with spaces
+func commandLine(args []string) string {
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		if arg == "" || strings.ContainsAny(arg, " \t\n\"'") {
+			arg = strconv.Quote(arg)
+		}
+		quoted[i] = arg
+	}
+	return strings.Join(quoted, " ")
+}
+
+// finish logs the operation if it changed anything, warning on errOut when
+// it cannot
+func (r *operationRecorder) finish(errOut io.Writer) {
+	if r == nil {
+		return
+	}
+	after, err := takeSnapshot(r.repo)
+	if err == nil && after.state.Equal(r.before.state) {
+		return
+	}
+	if err == nil {
+		err = r.before.storeIndex(r.repo)
+	}
+	if err == nil {
+		err = after.storeIndex(r.repo)
+	}
+	if err == nil {
+		op := &oplog.Operation{Time: r.start.UTC(), Command: r.command}
+		op.Before, op.After = oplog.Trim(r.before.state, after.state)
+		err = oplog.Append(r.repo.GitDir(), op)
+	}
+	if err != nil {
+		fmt.Fprintf(errOut, "warning: failed to log operation: %v\n", err)
+	}
+}
+
+// runUndo reverts the last n operations not undone yet
+func runUndo(out, errOut io.Writer, repo *vcs.Repository, n int, force, dryRun bool) error {
+	ops, err := oplog.Read(repo.GitDir())
+	if err != nil {
+		return err
+	}
+	var targets []*oplog.Operation
+	for i := len(ops) - 1; i >= 0 && len(targets) < n; i-- {
+		if ops[i].UndoneBy == 0 && len(ops[i].Undoes) == 0 {
+			targets = append(targets, ops[i])
+		}
+	}
+	if len(targets) == 0 {
+		return fmt.Errorf("nothing to undo")
+	}
+	if len(targets) < n {
+		return fmt.Errorf("only %d operations can be undone", len(targets))
+	}
+
+	current, err := takeSnapshot(repo)
+	if err != nil {
+		return err
+	}
+	want, changed := undoState(current.state, targets)
+	if len(changed) > 0 && !force {
+		return fmt.Errorf("%s changed since '%s' without being logged; use --force to undo anyway", changed[0], targets[len(targets)-1].Command)
+	}
+
+	verb := "Undid"
+	if dryRun {
+		verb = "Would undo"
+	} else if err := restoreState(repo, errOut, current, want); err != nil {
+		return err
+	}
+	for _, op := range targets {
+		fmt.Fprintf(out, "%s #%d %s\n", verb, op.ID, op.Command)
+	}
+	if dryRun {
+		return nil
+	}
+
+	after, err := takeSnapshot(repo)
+	if err != nil {
+		return err
+	}
+	if err := current.storeIndex(repo); err != nil {
+		return err
+	}
+	if err := after.storeIndex(repo); err != nil {
+		return err
+	}
+	undo := &oplog.Operation{ID: oplog.NextID(ops), Time: time.Now().UTC(), Command: "undo " + strconv.Itoa(n)}
+	undo.Before, undo.After = oplog.Trim(current.state, after.state)
+	for _, op := range targets {
+		op.UndoneBy = undo.ID
+		undo.Undoes = append(undo.Undoes, op.ID)
+	}
+	return oplog.Write(repo.GitDir(), append(ops, undo))
+}
+
+// undoState returns current with what targets, newest first, changed put
+// back as it was before them, and the parts that no longer are as the
+// newest target to change them left them
+func undoState(current oplog.State, targets []*oplog.Operation) (oplog.State, []string) {
+	want := current
+	want.Refs = make(map[string]string, len(current.Refs))
+	for name, id := range current.Refs {
+		want.Refs[name] = id
+	}
+
+	var changed []string
+	seen := make(map[string]bool)
+	check := func(what, have, after string) {
+		if !seen[what] {
+			seen[what] = true
+			if have != after {
+				changed = append(changed, what)
+			}
+		}
+	}
+
+	for _, op := range targets {
+		names := op.Before.ChangedRefs(op.After)
+		sort.Strings(names)
+		for _, name := range names {
+			check(name, current.Refs[name], op.After.Refs[name])
+			if id := op.Before.Refs[name]; id != "" {
+				want.Refs[name] = id
+			} else {
+				delete(want.Refs, name)
+			}
+		}
+		if op.Before.Head != op.After.Head {
+			check("HEAD", current.Head, op.After.Head)
+			want.Head = op.Before.Head
+		}
+		if op.Before.Index != op.After.Index {
+			check("the index", current.Index, op.After.Index)
+			want.Index = op.Before.Index
+		}
+		if op.Before.Stash != op.After.Stash {
+			check("the stash list", current.Stash, op.After.Stash)
+			want.Stash = op.Before.Stash
+		}
+	}
+	return want, changed
+}
+
+// restoreState changes the repository from current to want
+func restoreState(repo *vcs.Repository, errOut io.Writer, current *repoSnapshot, want oplog.State) error {
+	refManager := refs.NewRefManager(repo.GitDir())
+	for _, name := range current.state.ChangedRefs(want) {
+		if want.Refs[name] == "" {
+			if err := refManager.DeleteRef(name); err != nil {
+				return err
+			}
+			continue
+		}
+		id, err := objects.NewObjectID(want.Refs[name])
+		if err != nil {
+			return fmt.Errorf("invalid object ID for %s: %w", name, err)
+		}
+		if err := refManager.UpdateRef(name, id); err != nil {
+			return fmt.Errorf("failed to update %s: %w", name, err)
+		}
+	}
+
+	if want.Head != current.state.Head {
+		var err error
+		if target, ok := strings.CutPrefix(want.Head, "ref: "); ok {
+			err = refManager.SetHEAD(target)
+		} else {
+			var id objects.ObjectID
+			if id, err = objects.NewObjectID(want.Head); err == nil {
+				err = refManager.SetHEADToCommit(id)
+			}
+		}
+		if err != nil {
+			return fmt.Errorf("failed to update HEAD: %w", err)
+		}
+	}
+
+	if want.Index != current.state.Index {
+		if err := restoreIndex(repo, errOut, current.index, want.Index); err != nil {
+			return err
+		}
+	}
+
+	if want.Stash != current.state.Stash {
+		path := stashReflogPath(repo)
+		var err error
+		if want.Stash == "" {
+			if err = os.Remove(path); os.IsNotExist(err) {
+				err = nil
+			}
+		} else if err = os.MkdirAll(filepath.Dir(path), 0755); err == nil {
+			err = os.WriteFile(path, []byte(want.Stash), 0644)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to restore stash list: %w", err)
+		}
+	}
+	return nil
+}
+
+// restoreIndex replaces the index, whose entries are in data, with the one
+// stored as the blob wantID, and brings the working tree along: files the
+// restored index has are rewritten when they match the index they leave.
+// Files only the current index has are kept, as after unstaging them.
+func restoreIndex(repo *vcs.Repository, errOut io.Writer, data []byte, wantID string) error {
+	var wantData []byte
+	if wantID != "" {
+		id, err := objects.NewObjectID(wantID)
+		if err != nil {
+			return fmt.Errorf("invalid index ID: %w", err)
+		}
+		blob, err := repo.GetBlob(id)
+		if err != nil {
+			return fmt.Errorf("failed to read logged index: %w", err)
+		}
+		wantData = blob.Data()
+	}
+
+	have, err := stageZeroEntries(data)
+	if err != nil {
+		return err
+	}
+	want, err := stageZeroEntries(wantData)
+	if err != nil {
+		return err
+	}
+
+	indexPath := filepath.Join(repo.GitDir(), "index")
+	if wantData == nil {
+		err = os.Remove(indexPath)
+		if os.IsNotExist(err) {
+			err = nil
+		}
+	} else {
+		err = os.WriteFile(indexPath, wantData, 0644)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to restore index: %w", err)
+	}
+
+	paths := make([]string, 0, len(want))
+	for path := range want {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	conv := newConverter(repo, errOut, nil)
+	for _, path := range paths {
+		from, to := have[path], want[path]
+		if to == nil || (from != nil && from.ID == to.ID && from.Mode == to.Mode) {
+			continue
+		}
+		fullPath := filepath.Join(repo.WorkDir(), filepath.FromSlash(path))
+		if workingFileMatches(repo, conv, fullPath, path, to) {
+			continue
+		}
+		if !workingFileMatches(repo, conv, fullPath, path, from) {
+			fmt.Fprintf(errOut, "warning: not updating '%s', which has local changes\n", path)
+			continue
+		}
+		blob, err := repo.GetBlob(to.ID)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		if err := writeWorkingFile(repo, conv, path, to.Mode, blob.Data()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// stageZeroEntries returns the merged file entries of the index in data by
+// path
+func stageZeroEntries(data []byte) (map[string]*index.Entry, error) {
+	entries := make(map[string]*index.Entry)
+	if data == nil {
+		return entries, nil
+	}
+	idx := index.New()
+	if err := idx.ReadFrom(bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("failed to read logged index: %w", err)
+	}
+	for _, entry := range idx.Entries() {
+		if entry.Stage() == 0 && entry.Mode != objects.ModeCommit {
+			entries[entry.Path] = entry
+		}
+	}
+	return entries, nil
+}
+
+// workingFileMatches reports whether the working file at fullPath has the
+// content of entry, or is missing when entry is nil
+func workingFileMatches(repo *vcs.Repository, conv *convert.Converter, fullPath, path string, entry *index.Entry) bool {
+	data, err := os.ReadFile(fullPath)
+	if entry == nil {
+		return os.IsNotExist(err)
+	}
+	if err != nil {
+		return false
+	}
+	if data, err = conv.Clean(path, data); err != nil {
+		return false
+	}
+	return repo.HashData(data) == entry.ID
+}
+
+// oplogGCRoots returns the objects the operation log in gitDir refers to,
+// so that gc keeps what undo may restore
+func oplogGCRoots(repo *vcs.Repository, gitDir string) ([]objects.ObjectID, error) {
+	ops, err := oplog.Read(gitDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var roots []objects.ObjectID
+	add := func(hex string) {
+		if id, err := objects.NewObjectID(hex); err == nil && !id.IsZero() {
+			roots = append(roots, id)
+		}
+	}
+	indexes := make(map[string]bool)
+	for _, op := range ops {
+		for _, state := range []oplog.State{op.Before, op.After} {
+			add(state.Head)
+			for _, id := range state.Refs {
+				add(id)
+			}
+			if state.Index != "" && !indexes[state.Index] {
+				indexes[state.Index] = true
+				add(state.Index)
+				id, err := objects.NewObjectID(state.Index)
+				if err != nil {
+					continue
+				}
+				blob, err := repo.GetBlob(id)
+				if err != nil {
+					continue
+				}
+				entries, err := stageZeroEntries(blob.Data())
+				if err != nil {
+					continue
+				}
+				for _, entry := range entries {
+					roots = append(roots, entry.ID)
+				}
+			}
+		}
+	}
+	return roots, nil
+}
@@ -0,0 +1,163 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/spf13/cobra"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/internal/oplog"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// logged runs cmd with args as main does, logging it in the operation log
+func logged(t *testing.T, repo *vcs.Repository, cmd *cobra.Command, args ...string) string {
+	recorder := beginOperation(repo, commandLine(append([]string{cmd.Name()}, args...)))
+	require.NotNil(t, recorder)
+	out, err := captureStdout(t, func() error {
+		cmd.SetOut(os.Stdout)
+		cmd.SetErr(&bytes.Buffer{})
+		cmd.SetArgs(args)
+		return cmd.Execute()
+	})
+	require.NoError(t, err)
+	recorder.finish(os.Stderr)
+	return out
+}
+
+func runUndoArgs(args ...string) (string, error) {
+	return runCommandArgs(newUndoCommand(), args...)
+}
+
+func headCommit(t *testing.T, repo *vcs.Repository) string {
+	id, _, err := refs.NewRefManager(repo.GitDir()).HEAD()
+	require.NoError(t, err)
+	return id.String()
+}
+
+func TestUndoCommit(t *testing.T) {
+	repo, _ := setupConfigRepo(t)
+	require.NoError(t, os.WriteFile("a.txt", []byte("one\n"), 0644))
+	logged(t, repo, newAddCommand(), "a.txt")
+	logged(t, repo, newCommitCommand(), "-m", "one")
+	first := headCommit(t, repo)
+
+	require.NoError(t, os.WriteFile("b.txt", []byte("two\n"), 0644))
+	logged(t, repo, newAddCommand(), "b.txt")
+	logged(t, repo, newCommitCommand(), "-m", "two")
+	require.NotEqual(t, first, headCommit(t, repo))
+
+	out, err := runUndoArgs()
+	require.NoError(t, err)
+	assert.Equal(t, "Undid #4 commit -m two\n", out)
+	assert.Equal(t, first, headCommit(t, repo))
+	assert.Equal(t, "two\n", stagedContent(t, repo, "b.txt"), "the index is as it was before the commit")
+
+	// The undo is logged, and the commit marked as undone
+	ops, err := oplog.Read(repo.GitDir())
+	require.NoError(t, err)
+	require.Len(t, ops, 5)
+	assert.Equal(t, []int{4}, ops[4].Undoes)
+	assert.Equal(t, 5, ops[3].UndoneBy)
+
+	// The next undo skips both and undoes the add
+	out, err = runUndoArgs()
+	require.NoError(t, err)
+	assert.Equal(t, "Undid #3 add b.txt\n", out)
+	_, err = os.Stat("b.txt")
+	assert.NoError(t, err, "the working file is kept")
+}
+
+func TestUndoBranchAndDryRun(t *testing.T) {
+	repo, _ := setupConfigRepo(t)
+	_, err := stageAndCommit(t, repo, "a.txt", "-m", "one")
+	require.NoError(t, err)
+	logged(t, repo, newBranchCommand(), "topic")
+	logged(t, repo, newBranchCommand(), "other")
+
+	out, err := runUndoArgs("--dry-run", "2")
+	require.NoError(t, err)
+	assert.Equal(t, "Would undo #2 branch other\nWould undo #1 branch topic\n", out)
+	refManager := refs.NewRefManager(repo.GitDir())
+	assert.True(t, refManager.RefExists("refs/heads/topic"))
+
+	_, err = runUndoArgs("2")
+	require.NoError(t, err)
+	assert.False(t, refManager.RefExists("refs/heads/topic"))
+	assert.False(t, refManager.RefExists("refs/heads/other"))
+
+	_, err = runUndoArgs()
+	assert.EqualError(t, err, "nothing to undo")
+}
+
+func TestUndoRefusesUnloggedChanges(t *testing.T) {
+	repo, _ := setupConfigRepo(t)
+	_, err := stageAndCommit(t, repo, "a.txt", "-m", "one")
+	require.NoError(t, err)
+	logged(t, repo, newBranchCommand(), "topic")
+
+	// Move the branch behind the log's back
+	_, err = stageAndCommit(t, repo, "b.txt", "-m", "two")
+	require.NoError(t, err)
+	refManager := refs.NewRefManager(repo.GitDir())
+	head, _, err := refManager.HEAD()
+	require.NoError(t, err)
+	require.NoError(t, refManager.UpdateRef("refs/heads/topic", head))
+
+	_, err = runUndoArgs()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "refs/heads/topic changed since 'branch topic'")
+
+	_, err = runUndoArgs("--force")
+	require.NoError(t, err)
+	assert.False(t, refManager.RefExists("refs/heads/topic"))
+}
+
+func TestUndoRestoresWorkingTree(t *testing.T) {
+	repo, _ := setupConfigRepo(t)
+	_, err := stageAndCommit(t, repo, "a.txt", "-m", "one")
+	require.NoError(t, err)
+	require.NoError(t, os.WriteFile("b.txt", []byte("staged\n"), 0644))
+	_, err = runCommandArgs(newAddCommand(), "b.txt")
+	require.NoError(t, err)
+
+	logged(t, repo, newResetCommand(), "--hard")
+	_, err = os.Stat("b.txt")
+	require.True(t, os.IsNotExist(err), "reset --hard removes the staged file")
+
+	_, err = runUndoArgs()
+	require.NoError(t, err)
+	data, err := os.ReadFile("b.txt")
+	require.NoError(t, err)
+	assert.Equal(t, "staged\n", string(data))
+	assert.Equal(t, "staged\n", stagedContent(t, repo, "b.txt"))
+}
+
+func TestTimeline(t *testing.T) {
+	repo, _ := setupConfigRepo(t)
+	_, err := stageAndCommit(t, repo, "a.txt", "-m", "one")
+	require.NoError(t, err)
+	head := headCommit(t, repo)
+	logged(t, repo, newBranchCommand(), "topic")
+	logged(t, repo, newBranchCommand(), "-d", "topic")
+	_, err = runUndoArgs()
+	require.NoError(t, err)
+
+	out, err := runCommandArgs(newTimelineCommand())
+	require.NoError(t, err)
+	lines := strings.Split(out, "\n")
+	require.GreaterOrEqual(t, len(lines), 6)
+	assert.Regexp(t, `^#3 \S+ \S+ undo 1$`, lines[0])
+	assert.Equal(t, "    refs/heads/topic (none) -> "+head[:7], lines[1])
+	assert.Regexp(t, `^#2 \S+ \S+ branch -d topic \(undone by #3\)$`, lines[2])
+	assert.Regexp(t, `^#1 \S+ \S+ branch topic$`, lines[4])
+
+	out, err = runCommandArgs(newTimelineCommand(), "-n", "1")
+	require.NoError(t, err)
+	assert.NotContains(t, out, "#2")
+}
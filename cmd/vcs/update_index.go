@@ -0,0 +1,221 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/index"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/workdir"
+)
+
+// updateIndexOptions holds the flags of update-index
+type updateIndexOptions struct {
+	add            bool
+	remove         bool
+	forceRemove    bool
+	cacheInfo      []string
+	chmod          string
+	skipWorktree   bool
+	noSkipWorktree bool
+}
+
+func newUpdateIndexCommand() *cobra.Command {
+	var opts updateIndexOptions
+
+	cmd := &cobra.Command{
+		Use:   "update-index [flags] [--] [<file>...]",
+		Short: "Register file contents in the working tree to the index",
+		Long: `Stages the current content of each file in the index, writing its blob.
+
+Files not in the index are only added with --add, and files missing from
+the working tree are only removed from the index with --remove;
+--force-remove removes them even when present. --cacheinfo stages an
+object already in the repository as <mode>,<object>,<path> without
+reading the working tree.
+
+--chmod=+x or --chmod=-x sets the executable bit of the files staged.
+--skip-worktree and --no-skip-worktree only set or clear that bit on
+files already in the index, leaving their content alone.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if opts.chmod != "" && opts.chmod != "+x" && opts.chmod != "-x" {
+				return fmt.Errorf("option 'chmod' expects \"+x\" or \"-x\"")
+			}
+			if opts.skipWorktree && opts.noSkipWorktree {
+				return fmt.Errorf("--skip-worktree and --no-skip-worktree are mutually exclusive")
+			}
+			return runUpdateIndex(cmd, args, opts)
+		},
+	}
+
+	cmd.Flags().BoolVar(&opts.add, "add", false, "Add files not yet in the index")
+	cmd.Flags().BoolVar(&opts.remove, "remove", false, "Remove files missing from the working tree")
+	cmd.Flags().BoolVar(&opts.forceRemove, "force-remove", false, "Remove files from the index even if present in the working tree")
+	cmd.Flags().StringArrayVar(&opts.cacheInfo, "cacheinfo", nil, "Stage <mode>,<object>,<path> directly")
+	cmd.Flags().StringVar(&opts.chmod, "chmod", "", "Set the executable bit of the files staged, as +x or -x")
+	cmd.Flags().BoolVar(&opts.skipWorktree, "skip-worktree", false, "Mark files to be left out of the working tree")
+	cmd.Flags().BoolVar(&opts.noSkipWorktree, "no-skip-worktree", false, "Clear the skip-worktree bit of files")
+
+	return cmd
+}
+
+func runUpdateIndex(cmd *cobra.Command, args []string, opts updateIndexOptions) error {
+	repoPath, err := findRepository()
+	if err != nil {
+		return fmt.Errorf("not a git repository: %w", err)
+	}
+	repo, err := openRepository(repoPath)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+	idx, err := readIndex(repo)
+	if err != nil {
+		return err
+	}
+
+	for _, info := range opts.cacheInfo {
+		entry, err := parseCacheInfo(info)
+		if err != nil {
+			return err
+		}
+		if _, exists := idx.Get(entry.Path); !exists && !opts.add {
+			return fmt.Errorf("--cacheinfo cannot add %s: missing --add option?", entry.Path)
+		}
+		if err := idx.Add(entry); err != nil {
+			return fmt.Errorf("failed to add entry to index: %w", err)
+		}
+	}
+
+	// Files are checked in order, then those to stage hashed in parallel
+	// and staged in the same order, as add does
+	scanner := newScanner(repo)
+	var updates []addUpdate
+	var jobs []hashJob
+	for _, arg := range args {
+		relPath, err := repoRelativePath(repoPath, arg)
+		if err != nil {
+			return err
+		}
+		absPath := filepath.Join(repoPath, filepath.FromSlash(relPath))
+		existing, tracked := idx.Get(relPath)
+
+		if opts.skipWorktree || opts.noSkipWorktree {
+			if !tracked {
+				return fmt.Errorf("unable to mark file %s", relPath)
+			}
+			existing.SkipWorktree = opts.skipWorktree
+			continue
+		}
+		if opts.forceRemove {
+			if tracked {
+				updates = append(updates, addUpdate{path: relPath, job: -1})
+			}
+			continue
+		}
+
+		info, err := os.Lstat(absPath)
+		if os.IsNotExist(err) {
+			if !opts.remove {
+				return fmt.Errorf("%s: does not exist and --remove not passed", relPath)
+			}
+			if tracked {
+				updates = append(updates, addUpdate{path: relPath, job: -1})
+			}
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to stat file %s: %w", relPath, err)
+		}
+		if !tracked && !opts.add {
+			return fmt.Errorf("%s: cannot add to the index - missing --add option?", relPath)
+		}
+
+		// A nested repository is staged as a gitlink to its checked-out
+		// commit
+		if info.IsDir() {
+			if !workdir.IsRepository(absPath) {
+				return fmt.Errorf("%s: is a directory - add files inside instead", relPath)
+			}
+			head, err := submoduleHead(absPath)
+			if err != nil {
+				return fmt.Errorf("'%s' does not have a commit checked out", relPath)
+			}
+			entry := &index.Entry{CTime: info.ModTime(), MTime: info.ModTime(), Mode: objects.ModeCommit, ID: head, Path: relPath}
+			updates = append(updates, addUpdate{path: relPath, entry: entry, job: -1})
+			continue
+		}
+
+		mode, err := scanner.GetFileMode(relPath)
+		if err != nil {
+			return fmt.Errorf("failed to get file mode for %s: %w", relPath, err)
+		}
+		switch opts.chmod {
+		case "+x":
+			mode = objects.ModeExec
+		case "-x":
+			mode = objects.ModeBlob
+		}
+		entry := &index.Entry{
+			CTime: info.ModTime(),
+			MTime: info.ModTime(),
+			Mode:  mode,
+			Size:  uint32(info.Size()),
+			Path:  relPath,
+		}
+		updates = append(updates, addUpdate{path: relPath, entry: entry, job: len(jobs)})
+		jobs = append(jobs, hashJob{file: absPath, path: relPath})
+	}
+
+	ids := make([]objects.ObjectID, len(jobs))
+	err = hashFiles(repo, newConverter(repo, cmd.ErrOrStderr(), nil), jobs, hashThreads(repo.GitDir()), true, func(i int, id objects.ObjectID) error {
+		ids[i] = id
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	for _, update := range updates {
+		if update.entry == nil {
+			idx.Remove(update.path)
+			continue
+		}
+		if update.job >= 0 {
+			update.entry.ID = ids[update.job]
+		}
+		if err := idx.Add(update.entry); err != nil {
+			return fmt.Errorf("failed to add entry to index: %w", err)
+		}
+	}
+
+	if err := idx.WriteToFile(filepath.Join(repo.GitDir(), "index")); err != nil {
+		return fmt.Errorf("failed to write index: %w", err)
+	}
+	return nil
+}
+
+// parseCacheInfo parses the <mode>,<object>,<path> of --cacheinfo
+func parseCacheInfo(info string) (*index.Entry, error) {
+	fields := strings.SplitN(info, ",", 3)
+	if len(fields) != 3 || fields[2] == "" {
+		return nil, fmt.Errorf("--cacheinfo expects <mode>,<object>,<path>: %s", info)
+	}
+	mode, err := strconv.ParseUint(fields[0], 8, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mode in --cacheinfo: %s", fields[0])
+	}
+	id, err := objects.NewObjectID(fields[1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid object in --cacheinfo: %s", fields[1])
+	}
+	switch objects.FileMode(mode) {
+	case objects.ModeBlob, objects.ModeExec, objects.ModeSymlink, objects.ModeCommit:
+	default:
+		return nil, fmt.Errorf("invalid mode in --cacheinfo: %s", fields[0])
+	}
+	return &index.Entry{Mode: objects.FileMode(mode), ID: id, Path: fields[2]}, nil
+}
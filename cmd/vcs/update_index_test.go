@@ -0,0 +1,76 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/index"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+// stagedEntry returns the index entry of p
+func stagedEntry(t *testing.T, p string) (*index.Entry, bool) {
+	idx := index.New()
+	require.NoError(t, idx.ReadFromFile(filepath.Join(".git", "index")))
+	return idx.Get(p)
+}
+
+func TestUpdateIndex(t *testing.T) {
+	setupTreeRepo(t, map[string]string{"a.txt": "a\n", "b.txt": "b\n"})
+	require.NoError(t, os.WriteFile("a.txt", []byte("changed\n"), 0644))
+	require.NoError(t, os.WriteFile("new.txt", []byte("new\n"), 0644))
+
+	_, err := runCommandArgs(newUpdateIndexCommand(), "new.txt")
+	assert.ErrorContains(t, err, "missing --add option")
+
+	_, err = runCommandArgs(newUpdateIndexCommand(), "--add", "a.txt", "new.txt")
+	require.NoError(t, err)
+	entry, ok := stagedEntry(t, "a.txt")
+	require.True(t, ok)
+	assert.Equal(t, objects.NewBlob([]byte("changed\n")).ID(), entry.ID)
+	_, ok = stagedEntry(t, "new.txt")
+	assert.True(t, ok)
+
+	_, err = runCommandArgs(newUpdateIndexCommand(), "--chmod=+x", "a.txt")
+	require.NoError(t, err)
+	entry, _ = stagedEntry(t, "a.txt")
+	assert.Equal(t, objects.ModeExec, entry.Mode)
+
+	require.NoError(t, os.Remove("b.txt"))
+	_, err = runCommandArgs(newUpdateIndexCommand(), "b.txt")
+	assert.ErrorContains(t, err, "--remove not passed")
+	_, err = runCommandArgs(newUpdateIndexCommand(), "--remove", "b.txt")
+	require.NoError(t, err)
+	_, ok = stagedEntry(t, "b.txt")
+	assert.False(t, ok)
+
+	_, err = runCommandArgs(newUpdateIndexCommand(), "--force-remove", "new.txt")
+	require.NoError(t, err)
+	_, ok = stagedEntry(t, "new.txt")
+	assert.False(t, ok)
+
+	_, err = runCommandArgs(newUpdateIndexCommand(), "--skip-worktree", "a.txt")
+	require.NoError(t, err)
+	entry, _ = stagedEntry(t, "a.txt")
+	assert.True(t, entry.SkipWorktree)
+	_, err = runCommandArgs(newUpdateIndexCommand(), "--no-skip-worktree", "a.txt")
+	require.NoError(t, err)
+	entry, _ = stagedEntry(t, "a.txt")
+	assert.False(t, entry.SkipWorktree)
+
+	id := objects.NewBlob([]byte("b\n")).ID()
+	_, err = runCommandArgs(newUpdateIndexCommand(), "--cacheinfo", "100644,"+id.String()+",dir/b.txt")
+	assert.ErrorContains(t, err, "missing --add option")
+	_, err = runCommandArgs(newUpdateIndexCommand(), "--add", "--cacheinfo", "100644,"+id.String()+",dir/b.txt")
+	require.NoError(t, err)
+	entry, ok = stagedEntry(t, "dir/b.txt")
+	require.True(t, ok)
+	assert.Equal(t, id, entry.ID)
+
+	_, err = runCommandArgs(newUpdateIndexCommand(), "--chmod=x", "a.txt")
+	assert.Error(t, err)
+}
@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/internal/revparse"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// updateRefOptions holds the flags of update-ref
+type updateRefOptions struct {
+	message string
+	delete  bool
+	noDeref bool
+	stdin   bool
+	nul     bool
+}
+
+func newUpdateRefCommand() *cobra.Command {
+	var opts updateRefOptions
+
+	cmd := &cobra.Command{
+		Use:   "update-ref [flags] (<ref> <new> [<old>] | -d <ref> [<old>] | --stdin [-z])",
+		Short: "Update the object name stored in a ref safely",
+		Long: `Sets ref to new, or deletes it with -d, after checking that it points to
+old when that is given; an old value of 40 zeros requires the ref not to
+exist. A symbolic ref such as HEAD has the ref it points to updated,
+unless --no-deref is given. With -m the update is recorded in the reflog
+with that message.
+
+With --stdin, commands are read one per line and applied as a single
+transaction, so either every ref changes or none does:
+
+  update <ref> <new> [<old>]
+  create <ref> <new>
+  delete <ref> [<old>]
+  verify <ref> [<old>]
+  option no-deref
+  start
+  prepare
+  commit
+  abort
+
+Without start, the commands read are committed at the end of input. With
+start they are only committed by commit; prepare locks the refs and checks
+their old values early, and abort drops the transaction. start, prepare,
+commit and abort print "<command>: ok". With -z each command and each of
+its values ends with NUL instead, and an empty value stands for zeros.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if opts.nul && !opts.stdin {
+				return fmt.Errorf("-z only makes sense with --stdin")
+			}
+			if opts.stdin && len(args) > 0 {
+				return fmt.Errorf("--stdin takes no arguments")
+			}
+			if !opts.stdin && (len(args) < 1 || len(args) > 3 || opts.delete && len(args) > 2 || !opts.delete && len(args) < 2) {
+				return fmt.Errorf("usage: %s", cmd.Use)
+			}
+			return runUpdateRef(cmd, args, opts)
+		},
+	}
+
+	cmd.Flags().StringVarP(&opts.message, "message", "m", "", "Record the update in the reflog with this message")
+	cmd.Flags().BoolVarP(&opts.delete, "delete", "d", false, "Delete the ref")
+	cmd.Flags().BoolVar(&opts.noDeref, "no-deref", false, "Update a symbolic ref itself rather than the ref it points to")
+	cmd.Flags().BoolVar(&opts.stdin, "stdin", false, "Read updates from stdin and apply them as one transaction")
+	cmd.Flags().BoolVarP(&opts.nul, "null", "z", false, "Read NUL-terminated commands with --stdin")
+
+	return cmd
+}
+
+func runUpdateRef(cmd *cobra.Command, args []string, opts updateRefOptions) error {
+	repoPath, err := findRepository()
+	if err != nil {
+		return fmt.Errorf("not a git repository: %w", err)
+	}
+	repo, err := openRepository(repoPath)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+	refManager := refs.NewRefManager(repo.GitDir())
+	u := &refUpdater{repo: repo, refManager: refManager, resolver: newResolver(repo), message: opts.message, noDeref: opts.noDeref}
+
+	if opts.stdin {
+		return u.runStdin(cmd.InOrStdin(), cmd.OutOrStdout(), opts.nul)
+	}
+
+	tx := refManager.NewTransaction()
+	var update *refs.RefUpdate
+	if opts.delete {
+		var old *objects.ObjectID
+		if len(args) > 1 {
+			if old, err = u.parseOld(args[1]); err != nil {
+				return err
+			}
+		}
+		update, err = tx.Delete(args[0], old)
+	} else {
+		update, err = u.queueUpdate(tx, args[0], args[1], args[2:])
+	}
+	if err != nil {
+		return err
+	}
+	update.NoDeref = opts.noDeref
+	return u.commit(tx)
+}
+
+// refUpdater applies the updates of update-ref
+type refUpdater struct {
+	repo       *vcs.Repository
+	refManager *refs.RefManager
+	resolver   *revparse.Resolver
+	message    string
+	noDeref    bool
+}
+
+// zeroValue reports whether value stands for no object
+func zeroValue(value string) bool {
+	return value == "" || strings.Trim(value, "0") == "" && len(value) == len(objects.ObjectID{})*2
+}
+
+// parseNew resolves the value a ref is set to, zero to delete it
+func (u *refUpdater) parseNew(value string) (objects.ObjectID, error) {
+	if zeroValue(value) {
+		return objects.ObjectID{}, nil
+	}
+	id, err := u.resolver.Resolve(value)
+	if err != nil {
+		return objects.ObjectID{}, fmt.Errorf("%s: not a valid SHA1", value)
+	}
+	return id, nil
+}
+
+// parseOld resolves the value a ref must have, zero for none
+func (u *refUpdater) parseOld(value string) (*objects.ObjectID, error) {
+	id, err := u.parseNew(value)
+	if err != nil {
+		return nil, err
+	}
+	return &id, nil
+}
+
+// queueUpdate queues setting name to value, when it has the first of old
+// if given. A zero value deletes the ref.
+func (u *refUpdater) queueUpdate(tx *refs.Transaction, name, value string, old []string) (*refs.RefUpdate, error) {
+	newID, err := u.parseNew(value)
+	if err != nil {
+		return nil, err
+	}
+	var oldID *objects.ObjectID
+	if len(old) > 0 {
+		if oldID, err = u.parseOld(old[0]); err != nil {
+			return nil, err
+		}
+	}
+	if newID.IsZero() {
+		return tx.Delete(name, oldID)
+	}
+	return tx.Update(name, newID, oldID)
+}
+
+// commit applies tx and records its updates in the reflog
+func (u *refUpdater) commit(tx *refs.Transaction) error {
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	for _, update := range tx.Updates() {
+		if !update.IsDelete() && !update.IsVerify() {
+			logRefUpdate(u.refManager, update.Ref, update.Previous, update.NewID, u.message)
+		}
+	}
+	return nil
+}
+
+// runStdin applies the commands read from in, printing the replies to
+// start, prepare, commit and abort to out
+func (u *refUpdater) runStdin(in io.Reader, out io.Writer, nul bool) error {
+	data, err := io.ReadAll(in)
+	if err != nil {
+		return fmt.Errorf("failed to read commands: %w", err)
+	}
+	tokens := strings.Split(string(data), "\n")
+	if nul {
+		tokens = strings.Split(string(data), "\x00")
+	}
+	// The input ends with a terminator, which leaves an empty last token
+	if len(tokens) > 0 && tokens[len(tokens)-1] == "" {
+		tokens = tokens[:len(tokens)-1]
+	}
+
+	w := bufio.NewWriter(out)
+	defer w.Flush()
+	tx := u.refManager.NewTransaction()
+	started, noDeref := false, false
+	for len(tokens) > 0 {
+		line := tokens[0]
+		tokens = tokens[1:]
+		if !nul && strings.TrimSpace(line) == "" {
+			continue
+		}
+
+		// Without -z a command and its values share a line; with -z the
+		// command and ref come first and each value follows on its own
+		command, rest, _ := strings.Cut(line, " ")
+		var fields []string
+		if nul {
+			if rest != "" {
+				fields = []string{rest}
+			}
+			want := map[string]int{"update": 2, "create": 1, "delete": 1, "verify": 1}[command]
+			if want > len(tokens) {
+				return fmt.Errorf("%s %s: missing values", command, rest)
+			}
+			fields = append(fields, tokens[:want]...)
+			tokens = tokens[want:]
+		} else {
+			fields = strings.Fields(rest)
+		}
+
+		var update *refs.RefUpdate
+		switch command {
+		case "start":
+			if started || len(tx.Updates()) > 0 {
+				return fmt.Errorf("start: transaction already started")
+			}
+			started = true
+		case "prepare":
+			if err := tx.Prepare(); err != nil {
+				return err
+			}
+		case "commit":
+			if err := u.commit(tx); err != nil {
+				return err
+			}
+			tx, started = u.refManager.NewTransaction(), false
+		case "abort":
+			tx.Abort()
+			tx, started = u.refManager.NewTransaction(), false
+		case "option":
+			if len(fields) != 1 || fields[0] != "no-deref" {
+				return fmt.Errorf("option unknown: %s", rest)
+			}
+			noDeref = true
+			continue
+		case "update":
+			if len(fields) < 2 || len(fields) > 3 {
+				return fmt.Errorf("update: expected <ref> <new> [<old>]")
+			}
+			old := fields[2:]
+			if nul && old[0] == "" {
+				old = nil
+			}
+			update, err = u.queueUpdate(tx, fields[0], fields[1], old)
+		case "create":
+			if len(fields) != 2 {
+				return fmt.Errorf("create: expected <ref> <new>")
+			}
+			var newID objects.ObjectID
+			if newID, err = u.parseNew(fields[1]); err == nil {
+				if newID.IsZero() {
+					return fmt.Errorf("create %s: zero <new>", fields[0])
+				}
+				update, err = tx.Create(fields[0], newID)
+			}
+		case "delete":
+			if len(fields) < 1 || len(fields) > 2 {
+				return fmt.Errorf("delete: expected <ref> [<old>]")
+			}
+			var old *objects.ObjectID
+			if len(fields) == 2 && !(nul && fields[1] == "") {
+				if old, err = u.parseOld(fields[1]); err == nil && old.IsZero() {
+					return fmt.Errorf("delete %s: zero <old>", fields[0])
+				}
+			}
+			if err == nil {
+				update, err = tx.Delete(fields[0], old)
+			}
+		case "verify":
+			if len(fields) < 1 || len(fields) > 2 {
+				return fmt.Errorf("verify: expected <ref> [<old>]")
+			}
+			old := &objects.ObjectID{}
+			if len(fields) == 2 {
+				old, err = u.parseOld(fields[1])
+			}
+			if err == nil {
+				update, err = tx.Verify(fields[0], *old)
+			}
+		default:
+			return fmt.Errorf("unknown command: %s", line)
+		}
+		if err != nil {
+			tx.Abort()
+			return err
+		}
+		if update != nil {
+			update.NoDeref = u.noDeref || noDeref
+		} else {
+			fmt.Fprintf(w, "%s: ok\n", command)
+		}
+		noDeref = false
+	}
+
+	// An explicit transaction left open is dropped; otherwise what was
+	// read is committed
+	if started {
+		tx.Abort()
+		return nil
+	}
+	if len(tx.Updates()) == 0 {
+		return nil
+	}
+	return u.commit(tx)
+}
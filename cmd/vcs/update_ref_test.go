@@ -0,0 +1,100 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/refs"
+)
+
+// headID returns what refs/heads/main points to in the repository of
+// setupTreeRepo
+func headID(t *testing.T) string {
+	id, err := refs.NewRefManager(".git").ResolveRef("refs/heads/main")
+	require.NoError(t, err)
+	return id.String()
+}
+
+// refValue returns what ref points to, or "" when it does not exist
+func refValue(ref string) string {
+	id, err := refs.NewRefManager(".git").ResolveRef(ref)
+	if err != nil {
+		return ""
+	}
+	return id.String()
+}
+
+func TestUpdateRef(t *testing.T) {
+	setupTreeRepo(t, map[string]string{"a.txt": "a\n"})
+	head := headID(t)
+	zero := strings.Repeat("0", 40)
+
+	_, err := runCommandArgs(newUpdateRefCommand(), "refs/heads/topic", head, zero)
+	require.NoError(t, err)
+	assert.Equal(t, head, refValue("refs/heads/topic"))
+
+	_, err = runCommandArgs(newUpdateRefCommand(), "refs/heads/topic", head, zero)
+	assert.ErrorContains(t, err, "reference already exists")
+
+	_, err = runCommandArgs(newUpdateRefCommand(), "-d", "refs/heads/topic", head)
+	require.NoError(t, err)
+	assert.Empty(t, refValue("refs/heads/topic"))
+
+	// HEAD is followed to the branch, and logged with -m
+	_, err = runCommandArgs(newUpdateRefCommand(), "-d", "refs/heads/main")
+	require.NoError(t, err)
+	_, err = runCommandArgs(newUpdateRefCommand(), "-m", "moved", "HEAD", head)
+	require.NoError(t, err)
+	assert.Equal(t, head, refValue("refs/heads/main"))
+	entries, err := refs.NewRefManager(".git").ReadReflog("refs/heads/main")
+	require.NoError(t, err)
+	require.NotEmpty(t, entries)
+	assert.Equal(t, "moved", entries[len(entries)-1].Message)
+
+	_, err = runCommandArgs(newUpdateRefCommand(), "--no-deref", "HEAD", head)
+	require.NoError(t, err)
+	_, ok, err := refs.NewRefManager(".git").SymbolicRef("HEAD")
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func TestUpdateRefStdin(t *testing.T) {
+	setupTreeRepo(t, map[string]string{"a.txt": "a\n"})
+	head := headID(t)
+
+	run := func(input string, args ...string) (string, error) {
+		cmd := newUpdateRefCommand()
+		cmd.SetIn(strings.NewReader(input))
+		return runCommandArgs(cmd, append([]string{"--stdin"}, args...)...)
+	}
+
+	_, err := run("create refs/heads/a " + head + "\nupdate refs/tags/v1 " + head + "\n")
+	require.NoError(t, err)
+	assert.Equal(t, head, refValue("refs/heads/a"))
+	assert.Equal(t, head, refValue("refs/tags/v1"))
+
+	// A failed check leaves every ref as it was
+	_, err = run("delete refs/heads/a\ncreate refs/tags/v1 " + head + "\n")
+	assert.ErrorContains(t, err, "reference already exists")
+	assert.Equal(t, head, refValue("refs/heads/a"))
+	assert.NoFileExists(t, filepath.Join(".git", "refs", "heads", "a.lock"))
+
+	out, err := run("start\ndelete refs/heads/a\nprepare\nabort\n")
+	require.NoError(t, err)
+	assert.Equal(t, "start: ok\nprepare: ok\nabort: ok\n", out)
+	assert.Equal(t, head, refValue("refs/heads/a"))
+
+	out, err = run("start\nverify refs/heads/main " + head + "\ndelete refs/heads/a\ncommit\n")
+	require.NoError(t, err)
+	assert.Equal(t, "start: ok\ncommit: ok\n", out)
+	assert.Empty(t, refValue("refs/heads/a"))
+
+	_, err = run("create refs/heads/z\x00"+head+"\x00delete refs/tags/v1\x00\x00", "-z")
+	require.NoError(t, err)
+	assert.Equal(t, head, refValue("refs/heads/z"))
+	assert.Empty(t, refValue("refs/tags/v1"))
+}
@@ -0,0 +1,267 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/merge"
+	"github.com/fenilsonani/vcs/internal/interactive"
+)
+
+// defaultWordRegex matches the words found without a word regex, runs of
+// non-space characters. --color-words without a value takes it.
+const defaultWordRegex = `[^[:space:]]+`
+
+const (
+	colorOld   = "\033[31m"
+	colorNew   = "\033[32m"
+	colorReset = "\033[m"
+)
+
+// wordStyle is how a run of removed, added or unchanged words is marked
+type wordStyle struct {
+	prefix, suffix, color string
+}
+
+// wordDiffStyle is how a --word-diff mode marks words. Newline ends each
+// line of the output.
+type wordDiffStyle struct {
+	old, new, context wordStyle
+	newline           string
+}
+
+var wordDiffStyles = map[string]wordDiffStyle{
+	"plain": {
+		old:     wordStyle{prefix: "[-", suffix: "-]"},
+		new:     wordStyle{prefix: "{+", suffix: "+}"},
+		newline: "\n",
+	},
+	"color": {
+		old:     wordStyle{color: colorOld},
+		new:     wordStyle{color: colorNew},
+		newline: "\n",
+	},
+	"porcelain": {
+		old:     wordStyle{prefix: "-", suffix: "\n"},
+		new:     wordStyle{prefix: "+", suffix: "\n"},
+		context: wordStyle{prefix: " ", suffix: "\n"},
+		newline: "~\n",
+	},
+}
+
+// wordDiff shows the changes of a file word by word, as --word-diff does.
+// Each run of removed and added lines is compared as two sequences of
+// words, so only the words that changed are marked.
+type wordDiff struct {
+	style wordDiffStyle
+	// re matches a word; without it words are runs of non-space
+	re *regexp.Regexp
+}
+
+// addWordDiffFlags adds the flags readWordDiff reads
+func addWordDiffFlags(cmd *cobra.Command) {
+	cmd.Flags().String("word-diff", "", "Show a word diff: plain, color, porcelain or none")
+	cmd.Flags().Lookup("word-diff").NoOptDefVal = "plain"
+	cmd.Flags().String("word-diff-regex", "", "Regular expression matching a word, implying --word-diff")
+	cmd.Flags().String("color-words", "", "Show a word diff in color, optionally with a word regex")
+	cmd.Flags().Lookup("color-words").NoOptDefVal = defaultWordRegex
+}
+
+// readWordDiff returns how --word-diff, --word-diff-regex and --color-words
+// ask for words to be shown, or nil for a line diff. Without a word regex
+// diff.wordRegex is used.
+func readWordDiff(cmd *cobra.Command, gitDir string) (*wordDiff, error) {
+	mode, _ := cmd.Flags().GetString("word-diff")
+	pattern, _ := cmd.Flags().GetString("word-diff-regex")
+	if cmd.Flags().Changed("color-words") {
+		mode = "color"
+		if value, _ := cmd.Flags().GetString("color-words"); value != defaultWordRegex {
+			pattern = value
+		}
+	}
+	if mode == "" && pattern != "" {
+		mode = "plain"
+	}
+	if mode == "" || mode == "none" {
+		return nil, nil
+	}
+
+	style, ok := wordDiffStyles[mode]
+	if !ok {
+		return nil, fmt.Errorf("bad --word-diff argument: %s", mode)
+	}
+	cfg := loadConfig(gitDir)
+	if pattern == "" {
+		pattern, _ = cfg.Get("diff.wordRegex")
+	}
+	// The color mode takes its colors from color.diff.old and new
+	if style.old.color != "" {
+		colors, err := readPalette(cfg, "diff")
+		if err != nil {
+			return nil, err
+		}
+		style.old.color, style.new.color = colors.color("old"), colors.color("new")
+	}
+	words := &wordDiff{style: style}
+	if pattern != "" {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid word regex %q: %w", pattern, err)
+		}
+		words.re = re
+	}
+	return words, nil
+}
+
+// write writes hunks with their changed lines shown word by word
+func (d *wordDiff) write(w io.Writer, hunks []*interactive.Hunk) {
+	for _, hunk := range hunks {
+		fmt.Fprintln(w, hunk.Header())
+		var removed, added strings.Builder
+		flush := func() {
+			if removed.Len() > 0 || added.Len() > 0 {
+				d.writeChange(w, removed.String(), added.String())
+				removed.Reset()
+				added.Reset()
+			}
+		}
+		for _, line := range hunk.Lines {
+			switch line.Op {
+			case '-':
+				removed.WriteString(line.Text)
+			case '+':
+				added.WriteString(line.Text)
+			default:
+				flush()
+				d.writeText(w, d.style.context, line.Text)
+				d.endLine(w, line.Text)
+			}
+		}
+		flush()
+	}
+}
+
+// writeChange writes a run of removed lines and the lines added in their
+// place, marking the words that differ and showing the rest as added
+func (d *wordDiff) writeChange(w io.Writer, removed, added string) {
+	if added == "" {
+		d.writeText(w, d.style.old, removed)
+		d.endLine(w, removed)
+		return
+	}
+
+	oldWords, newWords := d.splitWords(removed), d.splitWords(added)
+	text := func(s string, words [][2]int) []string {
+		texts := make([]string, len(words))
+		for i, word := range words {
+			texts[i] = s[word[0]:word[1]]
+		}
+		return texts
+	}
+	matches := append(merge.MatchLines(text(removed, oldWords), text(added, newWords)),
+		merge.LineMatch{A: len(oldWords), B: len(newWords)})
+
+	// Between changes the added text is shown as it is, spacing included
+	shown, i, j := 0, 0, 0
+	for _, m := range matches {
+		if m.A > i || m.B > j {
+			// An empty side of a change is at the end of the word before it
+			oldStart, oldEnd := 0, 0
+			if m.A > i {
+				oldStart, oldEnd = oldWords[i][0], oldWords[m.A-1][1]
+			}
+			newStart, newEnd := shown, shown
+			if m.B > j {
+				newStart, newEnd = newWords[j][0], newWords[m.B-1][1]
+			} else if j > 0 {
+				newStart, newEnd = newWords[j-1][1], newWords[j-1][1]
+			}
+			d.writeText(w, d.style.context, added[shown:newStart])
+			d.writeText(w, d.style.old, removed[oldStart:oldEnd])
+			d.writeText(w, d.style.new, added[newStart:newEnd])
+			shown = newEnd
+		}
+		i, j = m.A+1, m.B+1
+	}
+	d.writeText(w, d.style.context, added[shown:])
+	d.endLine(w, added)
+}
+
+// writeText writes text in style, marking each of its lines on its own
+func (d *wordDiff) writeText(w io.Writer, style wordStyle, text string) {
+	for text != "" {
+		line, rest, found := strings.Cut(text, "\n")
+		if line != "" {
+			if style.color != "" {
+				fmt.Fprintf(w, "%s%s%s%s%s", style.color, style.prefix, line, style.suffix, colorReset)
+			} else {
+				fmt.Fprintf(w, "%s%s%s", style.prefix, line, style.suffix)
+			}
+		}
+		if !found {
+			return
+		}
+		fmt.Fprint(w, d.style.newline)
+		text = rest
+	}
+}
+
+// endLine ends the output line when text, the last text written, did not
+func (d *wordDiff) endLine(w io.Writer, text string) {
+	if !strings.HasSuffix(text, "\n") {
+		fmt.Fprint(w, d.style.newline)
+	}
+}
+
+// splitWords returns the start and end of each word of text. Words never
+// span lines.
+func (d *wordDiff) splitWords(text string) [][2]int {
+	var words [][2]int
+	if d.re == nil {
+		for i := 0; i < len(text); {
+			for i < len(text) && isWordSpace(text[i]) {
+				i++
+			}
+			start := i
+			for i < len(text) && !isWordSpace(text[i]) {
+				i++
+			}
+			if i > start {
+				words = append(words, [2]int{start, i})
+			}
+		}
+		return words
+	}
+
+	for pos := 0; pos < len(text); {
+		loc := d.re.FindStringIndex(text[pos:])
+		if loc == nil {
+			break
+		}
+		start, end := pos+loc[0], pos+loc[1]
+		if nl := strings.IndexByte(text[start:end], '\n'); nl >= 0 {
+			end = start + nl
+		}
+		if end == start {
+			pos = start + 1
+			continue
+		}
+		words = append(words, [2]int{start, end})
+		pos = end
+	}
+	return words
+}
+
+// isWordSpace reports whether c separates words when there is no word
+// regex
+func isWordSpace(c byte) bool {
+	switch c {
+	case ' ', '\t', '\n', '\r', '\v', '\f':
+		return true
+	}
+	return false
+}
@@ -0,0 +1,92 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/interactive"
+)
+
+func TestWordDiffWrite(t *testing.T) {
+	old := "one\nthe quick brown fox\njumps over\nthe lazy dog\nend\n"
+	new := "one\nthe slow brown fox\njumps\nover the lazy cat\nend\nnew line\n"
+	write := func(d *wordDiff, old, new string) string {
+		var out bytes.Buffer
+		d.write(&out, interactive.Diff([]byte(old), []byte(new), 3))
+		return out.String()
+	}
+
+	tests := []struct {
+		name  string
+		words *wordDiff
+		want  string
+	}{
+		{"plain", &wordDiff{style: wordDiffStyles["plain"]},
+			"one\nthe [-quick-]{+slow+} brown fox\njumps\nover the lazy [-dog-]{+cat+}\nend\n{+new line+}\n"},
+		{"color", &wordDiff{style: wordDiffStyles["color"]},
+			"one\nthe \033[31mquick\033[m\033[32mslow\033[m brown fox\njumps\nover the lazy \033[31mdog\033[m\033[32mcat\033[m\nend\n\033[32mnew line\033[m\n"},
+		{"porcelain", &wordDiff{style: wordDiffStyles["porcelain"]},
+			" one\n~\n the \n-quick\n+slow\n  brown fox\n~\n jumps\n~\n over the lazy \n-dog\n+cat\n~\n end\n~\n+new line\n~\n"},
+		{"regex", &wordDiff{style: wordDiffStyles["plain"], re: regexp.MustCompile(".")},
+			"one\nthe [-quick-]{+slow+} brown fox\njumps[- -]\nover{+ +}the lazy [-dog-]{+cat+}\nend\n{+new line+}\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, "@@ -1,5 +1,6 @@\n"+tt.want, write(tt.words, old, new))
+		})
+	}
+
+	// Removed lines alone are marked whole, line by line
+	plain := &wordDiff{style: wordDiffStyles["plain"]}
+	assert.Equal(t, "@@ -1,3 +1 @@\na\n[-b c-]\n[-d-]\n", write(plain, "a\nb c\nd\n", "a\n"))
+	// A last line without a newline still ends its output line
+	assert.Equal(t, "@@ -1 +1 @@\n[-a-]{+b+}\n", write(plain, "a", "b"))
+}
+
+func TestDiffWordDiffFlags(t *testing.T) {
+	setupTreeRepo(t, map[string]string{"f.txt": "key = old value\n"})
+	require.NoError(t, os.WriteFile("f.txt", []byte("key = new value\n"), 0644))
+
+	runDiffArgs := func(args ...string) (string, error) {
+		return captureStdout(t, func() error {
+			cmd := newDiffCommand()
+			cmd.SetArgs(args)
+			return cmd.Execute()
+		})
+	}
+	body := func(out string) string {
+		_, hunk, _ := strings.Cut(out, "+++ b/f.txt\n")
+		return hunk
+	}
+
+	out, err := runDiffArgs("--word-diff")
+	require.NoError(t, err)
+	assert.Equal(t, "@@ -1 +1 @@\nkey = [-old-]{+new+} value\n\n", body(out))
+	out, err = runDiffArgs("--color-words")
+	require.NoError(t, err)
+	assert.Equal(t, "@@ -1 +1 @@\nkey = \033[31mold\033[m\033[32mnew\033[m value\n\n", body(out))
+	out, err = runDiffArgs("--word-diff-regex=[a-z]")
+	require.NoError(t, err)
+	assert.Equal(t, "@@ -1 +1 @@\nkey = [-old-]{+new+} value\n\n", body(out))
+	out, err = runDiffArgs("--word-diff=none")
+	require.NoError(t, err)
+	assert.Contains(t, out, "-key = old value\n+key = new value\n")
+
+	// diff.wordRegex applies when no regex is given
+	_, err = runConfigArgs("diff.wordRegex", "[a-z]+|=")
+	require.NoError(t, err)
+	out, err = runDiffArgs("--word-diff=porcelain")
+	require.NoError(t, err)
+	assert.Equal(t, "@@ -1 +1 @@\n key = \n-old\n+new\n  value\n~\n\n", body(out))
+
+	_, err = runDiffArgs("--word-diff=fancy")
+	assert.ErrorContains(t, err, "bad --word-diff argument: fancy")
+	_, err = runDiffArgs("--word-diff-regex=[")
+	assert.ErrorContains(t, err, "invalid word regex")
+}
@@ -0,0 +1,693 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/gitdir"
+	"github.com/fenilsonani/vcs/internal/core/history"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// worktree is one working tree of a repository: the main one, or a linked
+// one whose repository directory is under worktrees/ in the common
+// directory
+type worktree struct {
+	path   string
+	gitDir string
+	head   objects.ObjectID
+	// branch is the ref HEAD is attached to, "" when detached
+	branch     string
+	main       bool
+	bare       bool
+	locked     bool
+	lockReason string
+	// prunable is why the worktree's directory is gone, "" when it is not
+	prunable string
+}
+
+// worktreeGitDirs returns the repository directories of every worktree:
+// commonDir itself for the main one, then those of linked worktrees
+func worktreeGitDirs(commonDir string) []string {
+	dirs := []string{commonDir}
+	entries, _ := os.ReadDir(filepath.Join(commonDir, "worktrees"))
+	for _, entry := range entries {
+		if entry.IsDir() {
+			dirs = append(dirs, filepath.Join(commonDir, "worktrees", entry.Name()))
+		}
+	}
+	return dirs
+}
+
+// listWorktrees returns the main worktree followed by the linked ones,
+// sorted by path
+func listWorktrees(commonDir string) ([]*worktree, error) {
+	var worktrees []*worktree
+	for _, gitDir := range worktreeGitDirs(commonDir) {
+		wt := &worktree{gitDir: gitDir, main: gitDir == commonDir}
+		if wt.main {
+			// A repository directory not named .git has no working tree
+			wt.path = filepath.Dir(commonDir)
+			if filepath.Base(commonDir) != ".git" {
+				wt.path, wt.bare = commonDir, true
+			}
+		} else {
+			data, err := os.ReadFile(filepath.Join(gitDir, "gitdir"))
+			if err != nil {
+				wt.path = gitDir
+				wt.prunable = "gitdir file does not exist"
+			} else {
+				dotGit := strings.TrimSpace(string(data))
+				wt.path = filepath.Dir(dotGit)
+				if _, err := os.Stat(dotGit); err != nil {
+					wt.prunable = "gitdir file points to non-existent location"
+				}
+			}
+			if reason, err := os.ReadFile(filepath.Join(gitDir, "locked")); err == nil {
+				wt.locked, wt.lockReason = true, strings.TrimSpace(string(reason))
+			}
+		}
+
+		refManager := refs.NewRefManager(gitDir)
+		wt.branch, _ = refManager.SymbolicHEAD()
+		wt.head, _, _ = refManager.HEAD()
+		worktrees = append(worktrees, wt)
+	}
+
+	linked := worktrees[1:]
+	sort.Slice(linked, func(i, j int) bool { return linked[i].path < linked[j].path })
+	return worktrees, nil
+}
+
+// findWorktree returns the worktree at path arg, or whose directory name is
+// arg
+func findWorktree(commonDir, arg string) (*worktree, error) {
+	worktrees, err := listWorktrees(commonDir)
+	if err != nil {
+		return nil, err
+	}
+	abs, err := filepath.Abs(arg)
+	if err != nil {
+		return nil, err
+	}
+	for _, wt := range worktrees {
+		if wt.path == abs {
+			return wt, nil
+		}
+	}
+	for _, wt := range worktrees {
+		if !wt.main && (filepath.Base(wt.path) == arg || filepath.Base(wt.gitDir) == arg) {
+			return wt, nil
+		}
+	}
+	return nil, fmt.Errorf("'%s' is not a working tree", arg)
+}
+
+// branchWorktree returns the worktree other than the one at gitDir that has
+// branchRef checked out, or nil
+func branchWorktree(commonDir, gitDir, branchRef string) *worktree {
+	worktrees, err := listWorktrees(commonDir)
+	if err != nil {
+		return nil
+	}
+	for _, wt := range worktrees {
+		if wt.branch == branchRef && !wt.bare && !sameDir(wt.gitDir, gitDir) {
+			return wt
+		}
+	}
+	return nil
+}
+
+// sameDir reports whether a and b name the same directory
+func sameDir(a, b string) bool {
+	absA, errA := filepath.Abs(a)
+	absB, errB := filepath.Abs(b)
+	return errA == nil && errB == nil && absA == absB
+}
+
+func newWorktreeCommand() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "worktree",
+		Short: "Manage multiple working trees",
+		Long: `Manages working trees attached to the same repository. A linked worktree
+has its own HEAD and index, kept under worktrees/ in the repository
+directory, and shares objects, refs and config with the main one. Its .git
+is a file pointing to its repository directory. A branch can only be
+checked out in one worktree at a time.`,
+	}
+
+	cmd.AddCommand(
+		newWorktreeAddCommand(),
+		newWorktreeListCommand(),
+		newWorktreeRemoveCommand(),
+		newWorktreeLockCommand(),
+		newWorktreeUnlockCommand(),
+		newWorktreePruneCommand(),
+	)
+	return cmd
+}
+
+// worktreeAddOptions holds the flags of worktree add
+type worktreeAddOptions struct {
+	newBranch   string
+	resetBranch string
+	detach      bool
+	force       bool
+	lock        bool
+	reason      string
+}
+
+func newWorktreeAddCommand() *cobra.Command {
+	var opts worktreeAddOptions
+
+	cmd := &cobra.Command{
+		Use:   "add [flags] <path> [<commit-ish>]",
+		Short: "Create a working tree at path",
+		Long: `Creates a working tree at path and checks out commit-ish in it. A branch
+name checks out that branch; any other revision detaches HEAD. Without
+commit-ish, the branch named after the last component of path is checked
+out, and created from HEAD if it does not exist.`,
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runWorktreeAdd(cmd, args, opts)
+		},
+	}
+
+	cmd.Flags().StringVarP(&opts.newBranch, "branch", "b", "", "Create a new branch and check it out")
+	cmd.Flags().StringVarP(&opts.resetBranch, "force-branch", "B", "", "Create or reset a branch and check it out")
+	cmd.Flags().BoolVarP(&opts.detach, "detach", "d", false, "Detach HEAD in the new working tree")
+	cmd.Flags().BoolVarP(&opts.force, "force", "f", false, "Check out a branch even if it is checked out in another working tree")
+	cmd.Flags().BoolVar(&opts.lock, "lock", false, "Lock the working tree after creation")
+	cmd.Flags().StringVar(&opts.reason, "reason", "", "Reason for locking, with --lock")
+
+	return cmd
+}
+
+func runWorktreeAdd(cmd *cobra.Command, args []string, opts worktreeAddOptions) error {
+	repoPath, err := findRepository()
+	if err != nil {
+		return fmt.Errorf("not a git repository: %w", err)
+	}
+	repo, err := openRepository(repoPath)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+	commonDir := repo.CommonDir()
+	refManager := refs.NewRefManager(repo.GitDir())
+
+	if opts.newBranch != "" && opts.resetBranch != "" {
+		return fmt.Errorf("-b and -B are mutually exclusive")
+	}
+	path, err := filepath.Abs(args[0])
+	if err != nil {
+		return err
+	}
+	if entries, err := os.ReadDir(path); err == nil && len(entries) > 0 || err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("'%s' already exists", args[0])
+	}
+
+	// Decide what to check out: a new or reset branch, an existing branch or
+	// a detached commit
+	start := "HEAD"
+	if len(args) > 1 {
+		start = args[1]
+	}
+	branch := opts.newBranch
+	if opts.resetBranch != "" {
+		branch = opts.resetBranch
+	}
+	create := branch != ""
+	switch {
+	case create:
+	case opts.detach:
+	case len(args) > 1:
+		if refManager.RefExists("refs/heads/" + args[1]) {
+			branch = args[1]
+		}
+	default:
+		branch = filepath.Base(path)
+		create = !refManager.RefExists("refs/heads/" + branch)
+	}
+
+	var commitID objects.ObjectID
+	var preparing string
+	switch {
+	case create:
+		if !refManager.IsValidRef("refs/heads/" + branch) {
+			return fmt.Errorf("'%s' is not a valid branch name", branch)
+		}
+		if commitID, err = resolveCommitish(repo, start); err != nil {
+			return fmt.Errorf("invalid reference: %s", start)
+		}
+		preparing = fmt.Sprintf("new branch '%s'", branch)
+		if oldID, err := refManager.ResolveRef("refs/heads/" + branch); err == nil {
+			if opts.resetBranch == "" {
+				return fmt.Errorf("a branch named '%s' already exists", branch)
+			}
+			preparing = fmt.Sprintf("resetting branch '%s'; was at %s", branch, oldID.Short())
+		}
+	case branch != "":
+		if commitID, err = refManager.ResolveRef("refs/heads/" + branch); err != nil {
+			return fmt.Errorf("invalid reference: %s", branch)
+		}
+		preparing = fmt.Sprintf("checking out '%s'", branch)
+	default:
+		if commitID, err = resolveCommitish(repo, start); err != nil {
+			return fmt.Errorf("invalid reference: %s", start)
+		}
+		preparing = fmt.Sprintf("detached HEAD %s", commitID.Short())
+	}
+	if branch != "" && !opts.force {
+		if wt := branchWorktree(commonDir, "", "refs/heads/"+branch); wt != nil {
+			return fmt.Errorf("'%s' is already checked out at '%s'", branch, wt.path)
+		}
+	}
+	commit, err := repo.GetCommit(commitID)
+	if err != nil {
+		return fmt.Errorf("failed to read commit %s: %w", commitID.Short(), err)
+	}
+
+	out := cmd.OutOrStdout()
+	fmt.Fprintf(out, "Preparing worktree (%s)\n", preparing)
+	if create {
+		oldID, _ := refManager.ResolveRef("refs/heads/" + branch)
+		if err := refManager.UpdateRef("refs/heads/"+branch, commitID); err != nil {
+			return fmt.Errorf("failed to create branch: %w", err)
+		}
+		logRefUpdate(refManager, "refs/heads/"+branch, oldID, commitID, "branch: Created from "+start)
+	}
+
+	if err := createWorktree(repo, path, branch, commit, opts); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "HEAD is now at %s %s\n", commitID.Short(), strings.SplitN(commit.Message(), "\n", 2)[0])
+	if wtGitDir, err := gitdir.Resolve(filepath.Join(path, ".git")); err == nil {
+		runPostCheckoutHook(cmd.ErrOrStderr(), path, wtGitDir, objects.ObjectID{}, commitID, true)
+	}
+	return nil
+}
+
+// createWorktree sets up the repository directory of a linked worktree at
+// path and checks out commit, attached to branch unless it is empty. The
+// worktree stays locked while it is set up, so prune leaves it alone.
+func createWorktree(repo *vcs.Repository, path, branch string, commit *objects.Commit, opts worktreeAddOptions) (err error) {
+	worktreesDir := filepath.Join(repo.CommonDir(), "worktrees")
+	name := filepath.Base(path)
+	for i := 1; fileExists(filepath.Join(worktreesDir, name)); i++ {
+		name = filepath.Base(path) + strconv.Itoa(i)
+	}
+	gitDir := filepath.Join(worktreesDir, name)
+	if err := os.MkdirAll(gitDir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", gitDir, err)
+	}
+	_, statErr := os.Stat(path)
+	createdPath := os.IsNotExist(statErr)
+	defer func() {
+		if err != nil {
+			os.RemoveAll(gitDir)
+			if createdPath {
+				os.RemoveAll(path)
+			}
+		}
+	}()
+
+	lockPath := filepath.Join(gitDir, "locked")
+	files := []struct{ name, content string }{
+		{"locked", "initializing\n"},
+		{"gitdir", filepath.Join(path, ".git") + "\n"},
+		{"commondir", filepath.Join("..", "..") + "\n"},
+	}
+	for _, f := range files {
+		if err := os.WriteFile(filepath.Join(gitDir, f.name), []byte(f.content), 0644); err != nil {
+			return fmt.Errorf("failed to write %s: %w", f.name, err)
+		}
+	}
+	if err := os.MkdirAll(path, 0755); err != nil {
+		return fmt.Errorf("could not create directory of '%s': %w", path, err)
+	}
+	if err := os.WriteFile(filepath.Join(path, ".git"), []byte("gitdir: "+gitDir+"\n"), 0644); err != nil {
+		return fmt.Errorf("failed to write .git: %w", err)
+	}
+
+	refManager := refs.NewRefManager(gitDir)
+	if branch != "" {
+		err = refManager.SetHEAD("refs/heads/" + branch)
+	} else {
+		err = refManager.SetHEADToCommit(commit.ID())
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write HEAD: %w", err)
+	}
+
+	wtRepo, err := vcs.OpenWithGitDir(path, gitDir)
+	if err != nil {
+		return err
+	}
+	tree, err := wtRepo.GetTree(commit.Tree())
+	if err != nil {
+		return fmt.Errorf("failed to read tree: %w", err)
+	}
+	if err := extractTreeToWorkingDirectory(wtRepo, tree, path); err != nil {
+		return err
+	}
+	if err := resetIndex(wtRepo, commit); err != nil {
+		return err
+	}
+
+	if opts.lock {
+		return os.WriteFile(lockPath, []byte(opts.reason), 0644)
+	}
+	return os.Remove(lockPath)
+}
+
+func newWorktreeListCommand() *cobra.Command {
+	var porcelain bool
+
+	cmd := &cobra.Command{
+		Use:   "list",
+		Short: "List the working trees of the repository",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repoPath, err := findRepository()
+			if err != nil {
+				return fmt.Errorf("not a git repository: %w", err)
+			}
+			repo, err := openRepository(repoPath)
+			if err != nil {
+				return fmt.Errorf("failed to open repository: %w", err)
+			}
+			worktrees, err := listWorktrees(repo.CommonDir())
+			if err != nil {
+				return err
+			}
+			if porcelain {
+				printWorktreesPorcelain(cmd.OutOrStdout(), worktrees)
+			} else {
+				printWorktrees(cmd.OutOrStdout(), worktrees)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&porcelain, "porcelain", false, "Give the output in a stable, machine-readable format")
+	return cmd
+}
+
+// printWorktrees prints one aligned line per worktree: its path, HEAD and
+// branch, and whether it is locked or prunable
+func printWorktrees(out io.Writer, worktrees []*worktree) {
+	width := 0
+	for _, wt := range worktrees {
+		width = max(width, len(wt.path))
+	}
+	for _, wt := range worktrees {
+		line := fmt.Sprintf("%-*s ", width+1, wt.path)
+		switch {
+		case wt.bare:
+			line += "(bare)"
+		case wt.branch != "":
+			line += fmt.Sprintf("%s [%s]", wt.head.Short(), strings.TrimPrefix(wt.branch, "refs/heads/"))
+		default:
+			line += fmt.Sprintf("%s (detached HEAD)", wt.head.Short())
+		}
+		if wt.locked {
+			line += " locked"
+		}
+		if wt.prunable != "" {
+			line += " prunable"
+		}
+		fmt.Fprintln(out, line)
+	}
+}
+
+// printWorktreesPorcelain prints the attributes of each worktree on lines
+// of their own, with a blank line after each worktree
+func printWorktreesPorcelain(out io.Writer, worktrees []*worktree) {
+	for _, wt := range worktrees {
+		fmt.Fprintf(out, "worktree %s\n", wt.path)
+		if wt.bare {
+			fmt.Fprintln(out, "bare")
+		} else {
+			fmt.Fprintf(out, "HEAD %s\n", wt.head)
+			if wt.branch != "" {
+				fmt.Fprintf(out, "branch %s\n", wt.branch)
+			} else {
+				fmt.Fprintln(out, "detached")
+			}
+		}
+		if wt.locked {
+			fmt.Fprintln(out, strings.TrimSpace("locked "+wt.lockReason))
+		}
+		if wt.prunable != "" {
+			fmt.Fprintf(out, "prunable %s\n", wt.prunable)
+		}
+		fmt.Fprintln(out)
+	}
+}
+
+func newWorktreeRemoveCommand() *cobra.Command {
+	var force int
+
+	cmd := &cobra.Command{
+		Use:   "remove [flags] <worktree>",
+		Short: "Remove a working tree",
+		Long: `Removes a linked working tree and its repository directory. A working tree
+with modified or untracked files is only removed with --force, and a
+locked one only with --force given twice.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runWorktreeRemove(args[0], force)
+		},
+	}
+
+	cmd.Flags().CountVarP(&force, "force", "f", "Remove even with local changes; twice to remove a locked working tree")
+	return cmd
+}
+
+func runWorktreeRemove(arg string, force int) error {
+	repoPath, err := findRepository()
+	if err != nil {
+		return fmt.Errorf("not a git repository: %w", err)
+	}
+	repo, err := openRepository(repoPath)
+	if err != nil {
+		return fmt.Errorf("failed to open repository: %w", err)
+	}
+	wt, err := findWorktree(repo.CommonDir(), arg)
+	if err != nil {
+		return err
+	}
+
+	if wt.main {
+		return fmt.Errorf("'%s' is a main working tree", arg)
+	}
+	if wt.locked && force < 2 {
+		if wt.lockReason != "" {
+			return fmt.Errorf("cannot remove a locked working tree, lock reason: %s\nuse 'remove -f -f' to override or unlock first", wt.lockReason)
+		}
+		return fmt.Errorf("cannot remove a locked working tree;\nuse 'remove -f -f' to override or unlock first")
+	}
+	if force == 0 && wt.prunable == "" {
+		dirty, err := worktreeHasChanges(wt)
+		if err != nil {
+			return fmt.Errorf("failed to check '%s' for changes: %w", arg, err)
+		}
+		if dirty {
+			return fmt.Errorf("'%s' contains modified or untracked files, use --force to delete it", arg)
+		}
+	}
+
+	if err := os.RemoveAll(wt.path); err != nil {
+		return fmt.Errorf("failed to delete '%s': %w", wt.path, err)
+	}
+	return removeWorktreeGitDir(wt.gitDir)
+}
+
+// removeWorktreeGitDir deletes the repository directory of a linked
+// worktree, and worktrees/ once it is empty
+func removeWorktreeGitDir(gitDir string) error {
+	if err := os.RemoveAll(gitDir); err != nil {
+		return fmt.Errorf("failed to delete '%s': %w", gitDir, err)
+	}
+	os.Remove(filepath.Dir(gitDir))
+	return nil
+}
+
+// worktreeHasChanges reports whether the files of wt differ from its HEAD
+// commit, counting untracked files that are not ignored
+func worktreeHasChanges(wt *worktree) (bool, error) {
+	repo, err := vcs.OpenWithGitDir(wt.path, wt.gitDir)
+	if err != nil {
+		return false, err
+	}
+	return filesDifferFromCommit(repo, wt.head, true)
+}
+
+// filesDifferFromCommit reports whether the files of the working tree of
+// repo differ from those of commit head, counting untracked files that are
+// not ignored when untracked is set
+func filesDifferFromCommit(repo *vcs.Repository, head objects.ObjectID, untracked bool) (bool, error) {
+	tracked := make(map[string]objects.ObjectID)
+	if !head.IsZero() {
+		commit, err := repo.GetCommit(head)
+		if err != nil {
+			return false, err
+		}
+		changes, err := history.DiffTrees(repo, objects.ObjectID{}, commit.Tree(), true)
+		if err != nil {
+			return false, err
+		}
+		for _, change := range changes {
+			tracked[change.Path] = change.NewID
+		}
+	}
+
+	scanner := newScanner(repo)
+	files, err := scanner.ScanFiles()
+	if err != nil {
+		return false, err
+	}
+	conv := newConverter(repo, io.Discard, nil)
+	seen := 0
+	for _, file := range files {
+		id, ok := tracked[file.Path]
+		if !ok {
+			if !untracked || scanner.IsIgnored(file.Path) {
+				continue
+			}
+			return true, nil
+		}
+		content, err := scanner.GetFileContent(file.Path)
+		if err != nil {
+			return false, err
+		}
+		if content, err = conv.Clean(file.Path, content); err != nil {
+			return false, err
+		}
+		if repo.HashData(content) != id {
+			return true, nil
+		}
+		seen++
+	}
+	return seen != len(tracked), nil
+}
+
+func newWorktreeLockCommand() *cobra.Command {
+	var reason string
+
+	cmd := &cobra.Command{
+		Use:   "lock [flags] <worktree>",
+		Short: "Prevent a working tree from being pruned or removed",
+		Long: `Locks a linked working tree, such as one on a removable disk, so that
+prune leaves it alone while it is not mounted and remove refuses it.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			wt, err := findLinkedWorktree(args[0])
+			if err != nil {
+				return err
+			}
+			if wt.locked {
+				if wt.lockReason != "" {
+					return fmt.Errorf("'%s' is already locked, reason: %s", args[0], wt.lockReason)
+				}
+				return fmt.Errorf("'%s' is already locked", args[0])
+			}
+			return os.WriteFile(filepath.Join(wt.gitDir, "locked"), []byte(reason), 0644)
+		},
+	}
+
+	cmd.Flags().StringVar(&reason, "reason", "", "Reason the working tree is locked")
+	return cmd
+}
+
+func newWorktreeUnlockCommand() *cobra.Command {
+	return &cobra.Command{
+		Use:   "unlock <worktree>",
+		Short: "Allow a working tree to be pruned or removed again",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			wt, err := findLinkedWorktree(args[0])
+			if err != nil {
+				return err
+			}
+			if !wt.locked {
+				return fmt.Errorf("'%s' is not locked", args[0])
+			}
+			return os.Remove(filepath.Join(wt.gitDir, "locked"))
+		},
+	}
+}
+
+// findLinkedWorktree finds the worktree named by arg for lock and unlock,
+// which do not apply to the main worktree
+func findLinkedWorktree(arg string) (*worktree, error) {
+	repoPath, err := findRepository()
+	if err != nil {
+		return nil, fmt.Errorf("not a git repository: %w", err)
+	}
+	repo, err := openRepository(repoPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open repository: %w", err)
+	}
+	wt, err := findWorktree(repo.CommonDir(), arg)
+	if err != nil {
+		return nil, err
+	}
+	if wt.main {
+		return nil, fmt.Errorf("the main working tree cannot be locked or unlocked")
+	}
+	return wt, nil
+}
+
+func newWorktreePruneCommand() *cobra.Command {
+	var dryRun, verbose bool
+
+	cmd := &cobra.Command{
+		Use:   "prune",
+		Short: "Prune the records of working trees that no longer exist",
+		Long: `Removes the repository directories of linked working trees whose
+directories have been deleted. Locked working trees are kept.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repoPath, err := findRepository()
+			if err != nil {
+				return fmt.Errorf("not a git repository: %w", err)
+			}
+			repo, err := openRepository(repoPath)
+			if err != nil {
+				return fmt.Errorf("failed to open repository: %w", err)
+			}
+			worktrees, err := listWorktrees(repo.CommonDir())
+			if err != nil {
+				return err
+			}
+			for _, wt := range worktrees {
+				if wt.main || wt.locked || wt.prunable == "" {
+					continue
+				}
+				if verbose || dryRun {
+					fmt.Fprintf(cmd.OutOrStdout(), "Removing worktrees/%s: %s\n", filepath.Base(wt.gitDir), wt.prunable)
+				}
+				if !dryRun {
+					if err := removeWorktreeGitDir(wt.gitDir); err != nil {
+						return err
+					}
+				}
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVarP(&dryRun, "dry-run", "n", false, "Only report what would be removed")
+	cmd.Flags().BoolVarP(&verbose, "verbose", "v", false, "Report all removals")
+	return cmd
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+func runWorktreeArgs(args ...string) (string, error) {
+	cmd := newWorktreeCommand()
+	var out bytes.Buffer
+	cmd.SetOut(&out)
+	cmd.SetErr(&bytes.Buffer{})
+	cmd.SetArgs(args)
+	err := cmd.Execute()
+	return out.String(), err
+}
+
+// setupWorktreeRepo creates a repository with one commit on main and a
+// feature branch pointing at it
+func setupWorktreeRepo(t *testing.T) *vcs.Repository {
+	repo, _ := setupConfigRepo(t)
+	_, err := stageAndCommit(t, repo, "a.txt", "-m", "first")
+	require.NoError(t, err)
+	rm := refs.NewRefManager(repo.GitDir())
+	head, _, err := rm.HEAD()
+	require.NoError(t, err)
+	require.NoError(t, rm.UpdateRef("refs/heads/feature", head))
+	return repo
+}
+
+func TestWorktreeAddAndList(t *testing.T) {
+	repo := setupWorktreeRepo(t)
+	head, _, err := refs.NewRefManager(repo.GitDir()).HEAD()
+	require.NoError(t, err)
+	base := t.TempDir()
+
+	out, err := runWorktreeArgs("add", filepath.Join(base, "feature"), "feature")
+	require.NoError(t, err)
+	assert.Equal(t, "Preparing worktree (checking out 'feature')\nHEAD is now at "+head.Short()+" first\n", out)
+	assert.FileExists(t, filepath.Join(base, "feature", "a.txt"))
+
+	_, err = runWorktreeArgs("add", "-b", "topic", filepath.Join(base, "topic"))
+	require.NoError(t, err)
+	_, err = runWorktreeArgs("add", "--detach", filepath.Join(base, "detached"))
+	require.NoError(t, err)
+	assert.True(t, refs.NewRefManager(repo.GitDir()).RefExists("refs/heads/topic"))
+
+	// The linked worktree keeps its own HEAD and shares the branches
+	gitDir, err := os.ReadFile(filepath.Join(base, "topic", ".git"))
+	require.NoError(t, err)
+	assert.Equal(t, "gitdir: "+filepath.Join(repo.GitDir(), "worktrees", "topic")+"\n", string(gitDir))
+	wtRefs := refs.NewRefManager(filepath.Join(repo.GitDir(), "worktrees", "topic"))
+	_, branch, err := wtRefs.HEAD()
+	require.NoError(t, err)
+	assert.Equal(t, "refs/heads/topic", branch)
+	assert.True(t, wtRefs.RefExists("refs/heads/feature"))
+
+	out, err = runWorktreeArgs("list", "--porcelain")
+	require.NoError(t, err)
+	blocks := strings.Split(strings.TrimSpace(out), "\n\n")
+	require.Len(t, blocks, 4)
+	assert.Equal(t, "worktree "+repo.WorkDir()+"\nHEAD "+head.String()+"\nbranch refs/heads/main", blocks[0])
+	assert.Equal(t, "worktree "+filepath.Join(base, "detached")+"\nHEAD "+head.String()+"\ndetached", blocks[1])
+	assert.Equal(t, "worktree "+filepath.Join(base, "feature")+"\nHEAD "+head.String()+"\nbranch refs/heads/feature", blocks[2])
+
+	out, err = runWorktreeArgs("list")
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	require.Len(t, lines, 4)
+	assert.True(t, strings.HasSuffix(lines[0], head.Short()+" [main]"))
+	assert.True(t, strings.HasSuffix(lines[1], head.Short()+" (detached HEAD)"))
+}
+
+func TestWorktreeBranchInUse(t *testing.T) {
+	repo := setupWorktreeRepo(t)
+	path := filepath.Join(t.TempDir(), "feature")
+	_, err := runWorktreeArgs("add", path, "feature")
+	require.NoError(t, err)
+
+	_, err = runWorktreeArgs("add", filepath.Join(t.TempDir(), "again"), "feature")
+	assert.ErrorContains(t, err, "'feature' is already checked out at '"+path+"'")
+	_, err = runWorktreeArgs("add", filepath.Join(t.TempDir(), "main"), "main")
+	assert.ErrorContains(t, err, "'main' is already checked out at '"+repo.WorkDir()+"'")
+
+	checkout := newCheckoutCommand()
+	checkout.SetErr(&bytes.Buffer{})
+	checkout.SetArgs([]string{"feature"})
+	_, err = captureStdout(t, checkout.Execute)
+	assert.ErrorContains(t, err, "already checked out at")
+
+	branch := newBranchCommand()
+	branch.SetErr(&bytes.Buffer{})
+	branch.SetArgs([]string{"-d", "feature"})
+	_, err = captureStdout(t, branch.Execute)
+	assert.ErrorContains(t, err, "cannot delete branch 'feature' checked out at")
+}
+
+func TestWorktreeCommandsInLinkedWorktree(t *testing.T) {
+	repo := setupWorktreeRepo(t)
+	path := filepath.Join(t.TempDir(), "feature")
+	_, err := runWorktreeArgs("add", path, "feature")
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(path))
+
+	// The commit advances the shared branch, not the main worktree's HEAD
+	message, err := stageAndCommit(t, repo, "b.txt", "-m", "on feature")
+	require.NoError(t, err)
+	assert.Equal(t, "first\n", message)
+
+	rm := refs.NewRefManager(repo.GitDir())
+	feature, err := rm.ResolveRef("refs/heads/feature")
+	require.NoError(t, err)
+	main, err := rm.ResolveRef("refs/heads/main")
+	require.NoError(t, err)
+	assert.NotEqual(t, main, feature)
+	_, branch, err := rm.HEAD()
+	require.NoError(t, err)
+	assert.Equal(t, "refs/heads/main", branch)
+}
+
+func TestWorktreeRemoveLockPrune(t *testing.T) {
+	repo := setupWorktreeRepo(t)
+	base := t.TempDir()
+	clean := filepath.Join(base, "clean")
+	dirty := filepath.Join(base, "dirty")
+	gone := filepath.Join(base, "gone")
+	for _, path := range []string{clean, dirty, gone} {
+		_, err := runWorktreeArgs("add", "--detach", path)
+		require.NoError(t, err)
+	}
+
+	_, err := runWorktreeArgs("remove", repo.WorkDir())
+	assert.ErrorContains(t, err, "is a main working tree")
+
+	_, err = runWorktreeArgs("lock", "--reason", "on a usb stick", clean)
+	require.NoError(t, err)
+	_, err = runWorktreeArgs("remove", clean)
+	assert.ErrorContains(t, err, "lock reason: on a usb stick")
+	_, err = runWorktreeArgs("unlock", clean)
+	require.NoError(t, err)
+	_, err = runWorktreeArgs("remove", clean)
+	require.NoError(t, err)
+	assert.NoDirExists(t, clean)
+	assert.NoDirExists(t, filepath.Join(repo.GitDir(), "worktrees", "clean"))
+
+	require.NoError(t, os.WriteFile(filepath.Join(dirty, "new.txt"), []byte("new\n"), 0644))
+	_, err = runWorktreeArgs("remove", dirty)
+	assert.ErrorContains(t, err, "contains modified or untracked files")
+	_, err = runWorktreeArgs("remove", "--force", dirty)
+	require.NoError(t, err)
+
+	require.NoError(t, os.RemoveAll(gone))
+	out, err := runWorktreeArgs("prune", "--dry-run")
+	require.NoError(t, err)
+	assert.Equal(t, "Removing worktrees/gone: gitdir file points to non-existent location\n", out)
+	assert.DirExists(t, filepath.Join(repo.GitDir(), "worktrees", "gone"))
+	_, err = runWorktreeArgs("prune")
+	require.NoError(t, err)
+	assert.NoDirExists(t, filepath.Join(repo.GitDir(), "worktrees"))
+}
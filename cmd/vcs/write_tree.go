@@ -0,0 +1,76 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/fenilsonani/vcs/internal/core/index"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+func newWriteTreeCommand() *cobra.Command {
+	var prefix string
+	var missingOK bool
+
+	cmd := &cobra.Command{
+		Use:   "write-tree [flags]",
+		Short: "Create a tree object from the current index",
+		Long: `Writes the trees of the files in the index and prints the name of the
+root one, or with --prefix of the tree of that directory. The index must
+have no unmerged paths, and every blob it names must be in the
+repository unless --missing-ok is given.`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			repoPath, err := findRepository()
+			if err != nil {
+				return fmt.Errorf("not a git repository: %w", err)
+			}
+			repo, err := openRepository(repoPath)
+			if err != nil {
+				return fmt.Errorf("failed to open repository: %w", err)
+			}
+			idx, err := readIndex(repo)
+			if err != nil {
+				return err
+			}
+
+			if !missingOK {
+				for _, entry := range idx.Entries() {
+					if entry.Mode != objects.ModeCommit && !repo.HasObject(entry.ID) {
+						return fmt.Errorf("invalid object %s for '%s'", entry.ID, entry.Path)
+					}
+				}
+			}
+
+			// A prefix writes the tree of the files below it alone
+			if prefix = strings.Trim(prefix, "/"); prefix != "" {
+				sub := index.New()
+				for _, entry := range idx.Entries() {
+					if rest, ok := strings.CutPrefix(entry.Path, prefix+"/"); ok {
+						copied := *entry
+						copied.Path = rest
+						sub.Add(&copied)
+					}
+				}
+				if len(sub.Entries()) == 0 {
+					return fmt.Errorf("prefix %s not found", prefix)
+				}
+				idx = sub
+			}
+
+			treeID, err := writeIndexTree(repo, idx)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintln(cmd.OutOrStdout(), treeID)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&prefix, "prefix", "", "Write the tree of this directory")
+	cmd.Flags().BoolVar(&missingOK, "missing-ok", false, "Allow objects missing from the repository")
+
+	return cmd
+}
@@ -0,0 +1,181 @@
+// Package bundle reads and writes Git bundle files, which carry refs and
+// the pack of objects they need in one file, so history can be moved
+// between repositories without a network. A bundle may leave out history
+// the receiving repository is expected to have already; the commits it
+// builds on are listed as prerequisites.
+package bundle
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+// Signatures start the first line of a bundle
+const (
+	SignatureV2 = "# v2 git bundle"
+	SignatureV3 = "# v3 git bundle"
+)
+
+// Ref is a ref a bundle carries
+type Ref struct {
+	Name string
+	ID   objects.ObjectID
+}
+
+// Prerequisite is a commit the receiving repository must already have
+type Prerequisite struct {
+	ID objects.ObjectID
+	// Comment is free text, by convention the subject of the commit
+	Comment string
+}
+
+// Header is what precedes the pack in a bundle
+type Header struct {
+	Prerequisites []Prerequisite
+	Refs          []Ref
+}
+
+// Ref returns the ID of the ref called name, and whether the bundle has it
+func (h *Header) Ref(name string) (objects.ObjectID, bool) {
+	for _, ref := range h.Refs {
+		if ref.Name == name {
+			return ref.ID, true
+		}
+	}
+	return objects.ObjectID{}, false
+}
+
+// ReadHeader reads a bundle header from r, leaving r at the start of the
+// pack. It returns the number of bytes the header took.
+func ReadHeader(r *bufio.Reader) (*Header, int64, error) {
+	var n int64
+	readLine := func() (string, error) {
+		line, err := r.ReadString('\n')
+		n += int64(len(line))
+		if err == io.EOF {
+			return "", fmt.Errorf("truncated bundle header")
+		}
+		return strings.TrimSuffix(line, "\n"), err
+	}
+
+	signature, err := readLine()
+	if err != nil {
+		return nil, n, err
+	}
+	if signature != SignatureV2 && signature != SignatureV3 {
+		return nil, n, fmt.Errorf("not a bundle: unrecognized header %q", signature)
+	}
+
+	header := &Header{}
+	for {
+		line, err := readLine()
+		if err != nil {
+			return nil, n, err
+		}
+		if line == "" {
+			return header, n, nil
+		}
+
+		switch {
+		case strings.HasPrefix(line, "@") && signature == SignatureV3:
+			// Only SHA-1 bundles of whole objects are understood
+			if line != "@object-format=sha1" {
+				return nil, n, fmt.Errorf("unsupported bundle capability %q", line[1:])
+			}
+		case strings.HasPrefix(line, "-"):
+			hex, comment, _ := strings.Cut(line[1:], " ")
+			id, err := objects.NewObjectID(hex)
+			if err != nil {
+				return nil, n, fmt.Errorf("invalid prerequisite %q: %w", line, err)
+			}
+			header.Prerequisites = append(header.Prerequisites, Prerequisite{ID: id, Comment: comment})
+		default:
+			hex, name, ok := strings.Cut(line, " ")
+			id, err := objects.NewObjectID(hex)
+			if !ok || err != nil {
+				return nil, n, fmt.Errorf("invalid ref line %q", line)
+			}
+			header.Refs = append(header.Refs, Ref{Name: name, ID: id})
+		}
+	}
+}
+
+// WriteHeader writes a version 2 bundle header; the pack is to follow
+func WriteHeader(w io.Writer, h *Header) error {
+	bw := bufio.NewWriter(w)
+	bw.WriteString(SignatureV2 + "\n")
+	for _, prereq := range h.Prerequisites {
+		if prereq.Comment != "" {
+			fmt.Fprintf(bw, "-%s %s\n", prereq.ID, prereq.Comment)
+		} else {
+			fmt.Fprintf(bw, "-%s\n", prereq.ID)
+		}
+	}
+	for _, ref := range h.Refs {
+		fmt.Fprintf(bw, "%s %s\n", ref.ID, ref.Name)
+	}
+	bw.WriteString("\n")
+	return bw.Flush()
+}
+
+// Bundle is a bundle file whose header has been read
+type Bundle struct {
+	Header
+	path string
+	// offset is where the pack starts
+	offset int64
+}
+
+// Open reads the header of the bundle at path
+func Open(path string) (*Bundle, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	header, n, err := ReadHeader(bufio.NewReader(f))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return &Bundle{Header: *header, path: path, offset: n}, nil
+}
+
+// IsBundle reports whether path is a file starting with a bundle signature
+func IsBundle(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	line, err := bufio.NewReader(f).ReadString('\n')
+	if err != nil {
+		return false
+	}
+	line = strings.TrimSuffix(line, "\n")
+	return line == SignatureV2 || line == SignatureV3
+}
+
+// Path returns the path of the bundle file
+func (b *Bundle) Path() string {
+	return b.path
+}
+
+// Pack opens the pack the bundle carries
+func (b *Bundle) Pack() (io.ReadCloser, error) {
+	f, err := os.Open(b.path)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := f.Seek(b.offset, io.SeekStart); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
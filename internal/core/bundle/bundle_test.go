@@ -0,0 +1,122 @@
+package bundle
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+func TestHeaderRoundTrip(t *testing.T) {
+	a := objects.ComputeHash(objects.TypeBlob, []byte("a"))
+	b := objects.ComputeHash(objects.TypeBlob, []byte("b"))
+	header := &Header{
+		Prerequisites: []Prerequisite{{ID: a, Comment: "first commit"}, {ID: b}},
+		Refs:          []Ref{{Name: "HEAD", ID: b}, {Name: "refs/heads/main", ID: b}},
+	}
+
+	var buf bytes.Buffer
+	if err := WriteHeader(&buf, header); err != nil {
+		t.Fatalf("WriteHeader() error = %v", err)
+	}
+	want := "# v2 git bundle\n-" + a.String() + " first commit\n-" + b.String() + "\n" +
+		b.String() + " HEAD\n" + b.String() + " refs/heads/main\n\n"
+	if buf.String() != want {
+		t.Errorf("WriteHeader() wrote %q, want %q", buf.String(), want)
+	}
+
+	buf.WriteString("PACK")
+	read, n, err := ReadHeader(bufio.NewReader(&buf))
+	if err != nil {
+		t.Fatalf("ReadHeader() error = %v", err)
+	}
+	if !reflect.DeepEqual(read, header) {
+		t.Errorf("ReadHeader() = %+v, want %+v", read, header)
+	}
+	if n != int64(len(want)) {
+		t.Errorf("ReadHeader() read %d bytes, want %d", n, len(want))
+	}
+
+	if id, ok := read.Ref("refs/heads/main"); !ok || id != b {
+		t.Errorf("Ref(main) = %s, %v", id, ok)
+	}
+	if _, ok := read.Ref("refs/heads/other"); ok {
+		t.Errorf("Ref(other) found a ref")
+	}
+}
+
+func TestReadHeaderErrors(t *testing.T) {
+	id := objects.ComputeHash(objects.TypeBlob, []byte("a")).String()
+	tests := []struct {
+		header string
+		err    string
+	}{
+		{"PACK", "truncated"},
+		{"# v9 git bundle\n\n", "not a bundle"},
+		{"# v2 git bundle\n" + id + " refs/heads/main\n", "truncated"},
+		{"# v2 git bundle\nxyz refs/heads/main\n\n", "invalid ref line"},
+		{"# v2 git bundle\n-xyz\n\n", "invalid prerequisite"},
+		{"# v2 git bundle\n@object-format=sha1\n\n", "invalid ref line"},
+		{"# v3 git bundle\n@filter=blob:none\n\n", "unsupported bundle capability"},
+	}
+	for _, tt := range tests {
+		_, _, err := ReadHeader(bufio.NewReader(strings.NewReader(tt.header)))
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("ReadHeader(%q) error = %v, want %q", tt.header, err, tt.err)
+		}
+	}
+
+	header, _, err := ReadHeader(bufio.NewReader(strings.NewReader("# v3 git bundle\n@object-format=sha1\n" + id + " HEAD\n\n")))
+	if err != nil {
+		t.Fatalf("ReadHeader() of a v3 bundle error = %v", err)
+	}
+	if len(header.Refs) != 1 {
+		t.Errorf("ReadHeader() of a v3 bundle read %d refs, want 1", len(header.Refs))
+	}
+}
+
+func TestOpen(t *testing.T) {
+	id := objects.ComputeHash(objects.TypeBlob, []byte("a"))
+	path := filepath.Join(t.TempDir(), "repo.bundle")
+	var buf bytes.Buffer
+	if err := WriteHeader(&buf, &Header{Refs: []Ref{{Name: "refs/heads/main", ID: id}}}); err != nil {
+		t.Fatal(err)
+	}
+	buf.WriteString("PACK data")
+	if err := os.WriteFile(path, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if !IsBundle(path) {
+		t.Errorf("IsBundle(%s) = false", path)
+	}
+	if IsBundle(filepath.Dir(path)) || IsBundle(filepath.Join(t.TempDir(), "missing")) {
+		t.Errorf("IsBundle() is true for something else than a bundle")
+	}
+
+	b, err := Open(path)
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	if b.Path() != path {
+		t.Errorf("Path() = %s, want %s", b.Path(), path)
+	}
+	pack, err := b.Pack()
+	if err != nil {
+		t.Fatalf("Pack() error = %v", err)
+	}
+	defer pack.Close()
+	data, err := io.ReadAll(pack)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "PACK data" {
+		t.Errorf("Pack() read %q, want the pack", data)
+	}
+}
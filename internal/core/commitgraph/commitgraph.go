@@ -0,0 +1,420 @@
+// Package commitgraph reads and writes the commit-graph file, Git's cache
+// of the parents, tree, committer time and generation number of every
+// commit reachable from the refs. Walking history through it needs no
+// commit to be read and parsed, and generation numbers bound ancestry
+// searches: a commit is never an ancestor of one with a lower generation.
+package commitgraph
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+
+	"github.com/fenilsonani/vcs/internal/core/fault"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+// File is the path of the commit-graph under the objects directory
+const File = "info/commit-graph"
+
+// Limits of the version 1 format
+const (
+	// MaxGeneration is the largest generation number stored; deeper
+	// commits all get it
+	MaxGeneration = 0x3fffffff
+	// GenerationInfinity is the generation of commits not in the graph,
+	// above that of any commit in it, since the graph holds every
+	// ancestor of its commits
+	GenerationInfinity = 0xffffffff
+)
+
+const (
+	signature     = "CGPH"
+	headerSize    = 8
+	chunkEntry    = 12
+	cdatEntrySize = sha1.Size + 16
+
+	parentNone       = 0x70000000
+	parentOctopus    = 0x80000000
+	lastEdge         = 0x80000000
+	edgePositionMask = 0x7fffffff
+)
+
+// Chunk IDs
+const (
+	chunkFanout = 0x4f494446 // OIDF
+	chunkLookup = 0x4f49444c // OIDL
+	chunkData   = 0x43444154 // CDAT
+	chunkEdges  = 0x45444745 // EDGE
+)
+
+// Commit is what the graph records about a commit
+type Commit struct {
+	ID      objects.ObjectID
+	Tree    objects.ObjectID
+	Parents []objects.ObjectID
+	// Generation is one more than the largest generation of the parents,
+	// 1 for a root commit
+	Generation uint32
+	// Time is the committer time in seconds since the epoch
+	Time int64
+}
+
+// Store reads the commits the graph is built from
+type Store interface {
+	ReadObject(id objects.ObjectID) (objects.Object, error)
+}
+
+// Graph is a commit-graph file read into memory
+type Graph struct {
+	fanout [256]uint32
+	ids    []byte
+	data   []byte
+	edges  []byte
+}
+
+// Path returns the path of the commit-graph of the objects directory
+func Path(objectsDir string) string {
+	return filepath.Join(objectsDir, filepath.FromSlash(File))
+}
+
+// Load reads the commit-graph of the objects directory, or returns nil when
+// there is none
+func Load(objectsDir string) (*Graph, error) {
+	data, err := os.ReadFile(Path(objectsDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read commit-graph: %w", err)
+	}
+	graph, err := Parse(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid commit-graph: %w", err)
+	}
+	return graph, nil
+}
+
+// Parse reads a commit-graph file
+func Parse(data []byte) (*Graph, error) {
+	if len(data) < headerSize+chunkEntry+sha1.Size || string(data[:4]) != signature {
+		return nil, fmt.Errorf("not a commit-graph file")
+	}
+	if data[4] != 1 {
+		return nil, fmt.Errorf("unsupported version %d", data[4])
+	}
+	if data[5] != 1 {
+		return nil, fmt.Errorf("unsupported hash version %d", data[5])
+	}
+	if data[7] != 0 {
+		return nil, fmt.Errorf("split commit-graphs are not supported")
+	}
+	body := data[:len(data)-sha1.Size]
+	if sum := sha1.Sum(body); !bytes.Equal(sum[:], data[len(body):]) {
+		return nil, fmt.Errorf("checksum mismatch")
+	}
+
+	chunks, err := readChunks(body, int(data[6]), headerSize)
+	if err != nil {
+		return nil, err
+	}
+	fanout, ok := chunks[chunkFanout]
+	if !ok || len(fanout) != 256*4 {
+		return nil, fmt.Errorf("missing or invalid OIDF chunk")
+	}
+
+	g := &Graph{ids: chunks[chunkLookup], data: chunks[chunkData], edges: chunks[chunkEdges]}
+	for i := range g.fanout {
+		g.fanout[i] = binary.BigEndian.Uint32(fanout[i*4:])
+	}
+	count := int(g.fanout[255])
+	if len(g.ids) != count*sha1.Size {
+		return nil, fmt.Errorf("missing or invalid OIDL chunk")
+	}
+	if len(g.data) != count*cdatEntrySize {
+		return nil, fmt.Errorf("missing or invalid CDAT chunk")
+	}
+	return g, nil
+}
+
+// readChunks reads the table of count chunks at offset into the chunks of
+// data it points to
+func readChunks(data []byte, count, offset int) (map[uint32][]byte, error) {
+	if offset+(count+1)*chunkEntry > len(data) {
+		return nil, fmt.Errorf("truncated chunk table")
+	}
+	chunks := make(map[uint32][]byte, count)
+	for i := 0; i < count; i++ {
+		entry := data[offset+i*chunkEntry:]
+		id := binary.BigEndian.Uint32(entry)
+		start := binary.BigEndian.Uint64(entry[4:])
+		end := binary.BigEndian.Uint64(entry[4+chunkEntry:])
+		if start > end || end > uint64(len(data)) {
+			return nil, fmt.Errorf("invalid offset of chunk %08x", id)
+		}
+		chunks[id] = data[start:end]
+	}
+	return chunks, nil
+}
+
+// Len returns the number of commits in the graph
+func (g *Graph) Len() int {
+	return int(g.fanout[255])
+}
+
+// id returns the ID of the commit at position i
+func (g *Graph) id(i uint32) objects.ObjectID {
+	var id objects.ObjectID
+	copy(id[:], g.ids[int(i)*sha1.Size:])
+	return id
+}
+
+// position finds id in the graph
+func (g *Graph) position(id objects.ObjectID) (uint32, bool) {
+	lo := uint32(0)
+	if id[0] > 0 {
+		lo = g.fanout[id[0]-1]
+	}
+	hi := g.fanout[id[0]]
+	i := lo + uint32(sort.Search(int(hi-lo), func(i int) bool {
+		return bytes.Compare(g.ids[int(lo+uint32(i))*sha1.Size:int(lo+uint32(i)+1)*sha1.Size], id[:]) >= 0
+	}))
+	return i, i < hi && g.id(i) == id
+}
+
+// Lookup returns what the graph records about commit id
+func (g *Graph) Lookup(id objects.ObjectID) (*Commit, bool) {
+	i, ok := g.position(id)
+	if !ok {
+		return nil, false
+	}
+	entry := g.data[int(i)*cdatEntrySize:]
+	commit := &Commit{ID: id}
+	copy(commit.Tree[:], entry)
+	entry = entry[sha1.Size:]
+
+	first := binary.BigEndian.Uint32(entry)
+	second := binary.BigEndian.Uint32(entry[4:])
+	if first != parentNone {
+		commit.Parents = append(commit.Parents, g.id(first))
+	}
+	switch {
+	case second == parentNone:
+	case second&parentOctopus != 0:
+		for e := int(second &^ parentOctopus); (e+1)*4 <= len(g.edges); e++ {
+			edge := binary.BigEndian.Uint32(g.edges[e*4:])
+			commit.Parents = append(commit.Parents, g.id(edge&edgePositionMask))
+			if edge&lastEdge != 0 {
+				break
+			}
+		}
+	default:
+		commit.Parents = append(commit.Parents, g.id(second))
+	}
+
+	genTime := binary.BigEndian.Uint32(entry[8:])
+	commit.Generation = genTime >> 2
+	commit.Time = int64(genTime&3)<<32 | int64(binary.BigEndian.Uint32(entry[12:]))
+	return commit, true
+}
+
+// Build collects the commits reachable from tips with their generation
+// numbers, sorted by ID. Commits already in previous, which may be nil, are
+// taken from it instead of being read.
+func Build(store Store, tips []objects.ObjectID, previous *Graph) ([]*Commit, error) {
+	commits := make(map[objects.ObjectID]*Commit)
+	stack := append([]objects.ObjectID(nil), tips...)
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if _, ok := commits[id]; ok {
+			continue
+		}
+
+		var commit *Commit
+		if previous != nil {
+			commit, _ = previous.Lookup(id)
+		}
+		if commit == nil {
+			obj, err := store.ReadObject(id)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read commit %s: %w", id, err)
+			}
+			c, ok := obj.(*objects.Commit)
+			if !ok {
+				return nil, fmt.Errorf("object %s is a %s, not a commit", id, obj.Type())
+			}
+			commit = &Commit{ID: id, Tree: c.Tree(), Parents: c.Parents(), Time: c.Committer().When.Unix()}
+		}
+		commits[id] = commit
+		stack = append(stack, commit.Parents...)
+	}
+
+	// Generations are computed parents first, without recursion since
+	// history can be deep
+	for _, commit := range commits {
+		stack := []*Commit{commit}
+		for len(stack) > 0 {
+			top := stack[len(stack)-1]
+			if top.Generation != 0 {
+				stack = stack[:len(stack)-1]
+				continue
+			}
+			generation := uint32(1)
+			pending := false
+			for _, parent := range top.Parents {
+				p := commits[parent]
+				if p.Generation == 0 {
+					stack = append(stack, p)
+					pending = true
+				} else if p.Generation >= generation {
+					generation = p.Generation + 1
+				}
+			}
+			if !pending {
+				if generation > MaxGeneration {
+					generation = MaxGeneration
+				}
+				top.Generation = generation
+				stack = stack[:len(stack)-1]
+			}
+		}
+	}
+
+	sorted := make([]*Commit, 0, len(commits))
+	for _, commit := range commits {
+		sorted = append(sorted, commit)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i].ID[:], sorted[j].ID[:]) < 0
+	})
+	return sorted, nil
+}
+
+// Write writes a commit-graph of commits, which are sorted by ID and hold
+// the parents of each of them
+func Write(w io.Writer, commits []*Commit) error {
+	positions := make(map[objects.ObjectID]uint32, len(commits))
+	for i, commit := range commits {
+		positions[commit.ID] = uint32(i)
+	}
+	position := func(id objects.ObjectID) (uint32, error) {
+		i, ok := positions[id]
+		if !ok {
+			return 0, fmt.Errorf("parent %s is not in the commit-graph", id)
+		}
+		return i, nil
+	}
+
+	var fanout, lookup, data, edges bytes.Buffer
+	var counts [256]uint32
+	var word [4]byte
+	put := func(buf *bytes.Buffer, v uint32) {
+		binary.BigEndian.PutUint32(word[:], v)
+		buf.Write(word[:])
+	}
+	for _, commit := range commits {
+		counts[commit.ID[0]]++
+		lookup.Write(commit.ID[:])
+		data.Write(commit.Tree[:])
+
+		first, second := uint32(parentNone), uint32(parentNone)
+		var err error
+		if len(commit.Parents) > 0 {
+			if first, err = position(commit.Parents[0]); err != nil {
+				return err
+			}
+		}
+		switch {
+		case len(commit.Parents) == 2:
+			if second, err = position(commit.Parents[1]); err != nil {
+				return err
+			}
+		case len(commit.Parents) > 2:
+			second = parentOctopus | uint32(edges.Len()/4)
+			for i, parent := range commit.Parents[1:] {
+				p, err := position(parent)
+				if err != nil {
+					return err
+				}
+				if i == len(commit.Parents)-2 {
+					p |= lastEdge
+				}
+				put(&edges, p)
+			}
+		}
+		put(&data, first)
+		put(&data, second)
+		generation := commit.Generation
+		if generation > MaxGeneration {
+			generation = MaxGeneration
+		}
+		put(&data, generation<<2|uint32(commit.Time>>32)&3)
+		put(&data, uint32(commit.Time))
+	}
+	total := uint32(0)
+	for _, n := range counts {
+		total += n
+		put(&fanout, total)
+	}
+
+	type chunk struct {
+		id   uint32
+		data []byte
+	}
+	chunks := []chunk{{chunkFanout, fanout.Bytes()}, {chunkLookup, lookup.Bytes()}, {chunkData, data.Bytes()}}
+	if edges.Len() > 0 {
+		chunks = append(chunks, chunk{chunkEdges, edges.Bytes()})
+	}
+
+	var out bytes.Buffer
+	out.WriteString(signature)
+	out.Write([]byte{1, 1, byte(len(chunks)), 0})
+	offset := uint64(headerSize + (len(chunks)+1)*chunkEntry)
+	var entry [chunkEntry]byte
+	for _, c := range chunks {
+		binary.BigEndian.PutUint32(entry[:], c.id)
+		binary.BigEndian.PutUint64(entry[4:], offset)
+		out.Write(entry[:])
+		offset += uint64(len(c.data))
+	}
+	binary.BigEndian.PutUint32(entry[:], 0)
+	binary.BigEndian.PutUint64(entry[4:], offset)
+	out.Write(entry[:])
+	for _, c := range chunks {
+		out.Write(c.data)
+	}
+	sum := sha1.Sum(out.Bytes())
+	out.Write(sum[:])
+
+	_, err := w.Write(out.Bytes())
+	return err
+}
+
+// Save writes a commit-graph of commits into the objects directory,
+// replacing any there
+func Save(objectsDir string, commits []*Commit) error {
+	var buf bytes.Buffer
+	if err := Write(&buf, commits); err != nil {
+		return err
+	}
+	path := Path(objectsDir)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", filepath.Dir(path), err)
+	}
+	tmp := path + ".lock"
+	if err := fault.WriteFile(tmp, buf.Bytes(), 0444); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write commit-graph: %w", err)
+	}
+	if err := fault.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write commit-graph: %w", err)
+	}
+	return nil
+}
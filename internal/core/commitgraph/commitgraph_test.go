@@ -0,0 +1,115 @@
+package commitgraph
+
+import (
+	"fmt"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+type memStore map[objects.ObjectID]objects.Object
+
+func (s memStore) ReadObject(id objects.ObjectID) (objects.Object, error) {
+	obj, ok := s[id]
+	if !ok {
+		return nil, fmt.Errorf("object not found: %s", id)
+	}
+	return obj, nil
+}
+
+func (s memStore) commit(n int, parents ...objects.ObjectID) objects.ObjectID {
+	sig := objects.Signature{Name: "A", Email: "a@example.com", When: time.Unix(int64(1700000000+n), 0)}
+	c := objects.NewCommit(objects.ComputeHash(objects.TypeTree, nil), parents, sig, sig, fmt.Sprintf("commit %d", n))
+	s[c.ID()] = c
+	return c.ID()
+}
+
+func TestWriteAndLookup(t *testing.T) {
+	store := memStore{}
+	root := store.commit(1)
+	left := store.commit(2, root)
+	right := store.commit(3, root)
+	other := store.commit(4, root)
+	merge := store.commit(5, left, right)
+	octopus := store.commit(6, merge, right, other)
+
+	commits, err := Build(store, []objects.ObjectID{octopus}, nil)
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	if len(commits) != 6 {
+		t.Fatalf("Build() = %d commits, want 6", len(commits))
+	}
+
+	dir := t.TempDir()
+	if err := Save(dir, commits); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	graph, err := Load(dir)
+	if err != nil || graph == nil {
+		t.Fatalf("Load() = %v, %v", graph, err)
+	}
+
+	want := map[objects.ObjectID]struct {
+		parents    []objects.ObjectID
+		generation uint32
+	}{
+		root:    {nil, 1},
+		left:    {[]objects.ObjectID{root}, 2},
+		merge:   {[]objects.ObjectID{left, right}, 3},
+		octopus: {[]objects.ObjectID{merge, right, other}, 4},
+	}
+	for id, w := range want {
+		c, ok := graph.Lookup(id)
+		if !ok {
+			t.Fatalf("Lookup(%s) found nothing", id)
+		}
+		if fmt.Sprint(c.Parents) != fmt.Sprint(w.parents) {
+			t.Errorf("Lookup(%s) parents = %v, want %v", id, c.Parents, w.parents)
+		}
+		if c.Generation != w.generation {
+			t.Errorf("Lookup(%s) generation = %d, want %d", id, c.Generation, w.generation)
+		}
+		commit := store[id].(*objects.Commit)
+		if c.Tree != commit.Tree() || c.Time != commit.Committer().When.Unix() {
+			t.Errorf("Lookup(%s) = tree %s at %d, want %s at %d", id, c.Tree, c.Time, commit.Tree(), commit.Committer().When.Unix())
+		}
+	}
+	if _, ok := graph.Lookup(objects.ComputeHash(objects.TypeBlob, []byte("x"))); ok {
+		t.Errorf("Lookup() found a commit not in the graph")
+	}
+
+	// A rebuild takes known commits from the graph
+	next := store.commit(7, octopus)
+	delete(store, root)
+	commits, err = Build(store, []objects.ObjectID{next}, graph)
+	if err != nil || len(commits) != 7 {
+		t.Fatalf("Build() with a previous graph = %d commits, %v; want 7", len(commits), err)
+	}
+}
+
+func TestLoadRejectsCorruptFiles(t *testing.T) {
+	dir := t.TempDir()
+	if graph, err := Load(dir); graph != nil || err != nil {
+		t.Fatalf("Load() without a file = %v, %v; want nil", graph, err)
+	}
+
+	store := memStore{}
+	commits, err := Build(store, []objects.ObjectID{store.commit(1)}, nil)
+	if err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	if err := Save(dir, commits); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+	data, err := os.ReadFile(Path(dir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)/2] ^= 0xff
+	if _, err := Parse(data); err == nil {
+		t.Errorf("Parse() accepted a file with a bad checksum")
+	}
+}
@@ -0,0 +1,301 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/fenilsonani/vcs/internal/core/gitdir"
+)
+
+// maxIncludeDepth limits nested include.path directives, so that a file
+// including itself fails instead of recursing forever
+const maxIncludeDepth = 10
+
+// Scope is the level a config file applies at. Later scopes take
+// precedence over earlier ones.
+type Scope int
+
+const (
+	// ScopeSystem applies to every user of the machine
+	ScopeSystem Scope = iota
+	// ScopeGlobal applies to every repository of the current user
+	ScopeGlobal
+	// ScopeLocal applies to a single repository
+	ScopeLocal
+)
+
+// Scopes lists the scopes in the order they are read
+var Scopes = []Scope{ScopeSystem, ScopeGlobal, ScopeLocal}
+
+// String returns the name of the scope
+func (s Scope) String() string {
+	switch s {
+	case ScopeSystem:
+		return "system"
+	case ScopeGlobal:
+		return "global"
+	case ScopeLocal:
+		return "local"
+	default:
+		return "unknown"
+	}
+}
+
+// Path returns the file holding the settings of scope, or "" when the scope
+// is not available. The system and global files can be moved with
+// GIT_CONFIG_SYSTEM and GIT_CONFIG_GLOBAL, and GIT_CONFIG_NOSYSTEM skips
+// the system file, as in Git.
+func Path(scope Scope, gitDir string) string {
+	switch scope {
+	case ScopeSystem:
+		if noSystem, err := ParseBool(os.Getenv("GIT_CONFIG_NOSYSTEM")); err == nil && noSystem {
+			return ""
+		}
+		if path := os.Getenv("GIT_CONFIG_SYSTEM"); path != "" {
+			return path
+		}
+		return "/etc/gitconfig"
+	case ScopeGlobal:
+		if path := os.Getenv("GIT_CONFIG_GLOBAL"); path != "" {
+			return path
+		}
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return ""
+		}
+		return filepath.Join(home, ".gitconfig")
+	case ScopeLocal:
+		if gitDir == "" {
+			return ""
+		}
+		// Linked worktrees share the config of the main repository
+		return filepath.Join(gitdir.CommonDir(gitDir), "config")
+	default:
+		return ""
+	}
+}
+
+// ParseBool parses a boolean config value. An empty value is false.
+func ParseBool(value string) (bool, error) {
+	switch strings.ToLower(strings.TrimSpace(value)) {
+	case "true", "yes", "on", "1":
+		return true, nil
+	case "false", "no", "off", "0", "":
+		return false, nil
+	default:
+		return false, fmt.Errorf("invalid boolean value %q", value)
+	}
+}
+
+// Config is the merged view of the system, global and repository config
+// files, with includes expanded
+type Config struct {
+	gitDir  string
+	entries []Entry
+}
+
+// Load reads the config that applies to the repository at gitDir, or only
+// the system and global config when gitDir is empty. Missing files are
+// skipped. On a parse error the entries read so far are still returned.
+func Load(gitDir string) (*Config, error) {
+	c := &Config{gitDir: gitDir}
+	for _, scope := range Scopes {
+		path := Path(scope, gitDir)
+		if path == "" {
+			continue
+		}
+		if err := c.loadFile(path, scope, 0); err != nil {
+			return c, err
+		}
+	}
+	return c, nil
+}
+
+// loadFile adds the entries of the file at path, expanding its includes
+func (c *Config) loadFile(path string, scope Scope, depth int) error {
+	file, err := ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range file.Entries() {
+		entry.Scope = scope
+		c.entries = append(c.entries, entry)
+
+		if !c.includes(entry) {
+			continue
+		}
+		if depth+1 > maxIncludeDepth {
+			return fmt.Errorf("exceeded maximum include depth (%d) while including %s from %s",
+				maxIncludeDepth, entry.Value, path)
+		}
+		if err := c.loadFile(includePath(entry.Value, path), scope, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// includes reports whether entry is an include.path, or an includeIf.path
+// whose condition holds
+func (c *Config) includes(entry Entry) bool {
+	if entry.Key == "include.path" {
+		return true
+	}
+	if !strings.HasPrefix(entry.Key, "includeif.") || !strings.HasSuffix(entry.Key, ".path") {
+		return false
+	}
+
+	condition := strings.TrimSuffix(strings.TrimPrefix(entry.Key, "includeif."), ".path")
+	kind, pattern, ok := strings.Cut(condition, ":")
+	if !ok || c.gitDir == "" {
+		return false
+	}
+
+	switch kind {
+	case "gitdir", "gitdir/i":
+		gitDir, err := filepath.Abs(c.gitDir)
+		if err != nil {
+			return false
+		}
+		return matchGlob(gitDirPattern(pattern, entry.File), filepath.ToSlash(gitDir), kind == "gitdir/i")
+	case "onbranch":
+		head, err := os.ReadFile(filepath.Join(c.gitDir, "HEAD"))
+		if err != nil {
+			return false
+		}
+		branch := strings.TrimPrefix(strings.TrimSpace(string(head)), "ref: refs/heads/")
+		if strings.HasSuffix(pattern, "/") {
+			pattern += "**"
+		}
+		return matchGlob(pattern, branch, false)
+	default:
+		return false
+	}
+}
+
+// includePath resolves an included path relative to the including file
+func includePath(path, from string) string {
+	path = expandHome(path)
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(filepath.Dir(from), path)
+	}
+	return path
+}
+
+// gitDirPattern expands a gitdir: condition into a pattern over absolute
+// paths: ./ is relative to the including file, a pattern not anchored at /
+// may match at any depth, and a trailing / matches everything below it
+func gitDirPattern(pattern, from string) string {
+	dir := strings.HasSuffix(pattern, "/")
+	pattern = expandHome(pattern)
+	if strings.HasPrefix(pattern, "./") {
+		pattern = filepath.Join(filepath.Dir(from), pattern[2:])
+	}
+	pattern = strings.TrimSuffix(filepath.ToSlash(pattern), "/")
+	if !strings.HasPrefix(pattern, "/") {
+		pattern = "**/" + pattern
+	}
+	if dir {
+		pattern += "/**"
+	}
+	return pattern
+}
+
+func expandHome(path string) string {
+	if !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[2:])
+}
+
+// matchGlob matches name against a pattern where * and ? stay within a
+// path component and ** spans components
+func matchGlob(pattern, name string, foldCase bool) bool {
+	var b strings.Builder
+	if foldCase {
+		b.WriteString("(?i)")
+	}
+	b.WriteString("^")
+	for i := 0; i < len(pattern); i++ {
+		switch {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			b.WriteString("(.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case pattern[i] == '*':
+			b.WriteString("[^/]*")
+		case pattern[i] == '?':
+			b.WriteString("[^/]")
+		default:
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	b.WriteString("$")
+
+	re, err := regexp.Compile(b.String())
+	return err == nil && re.MatchString(name)
+}
+
+// Entries returns every entry in the order it was read, so later entries
+// take precedence
+func (c *Config) Entries() []Entry {
+	return c.entries
+}
+
+// Get returns the value of key that takes precedence, which is the last
+// one read
+func (c *Config) Get(key string) (string, bool) {
+	values := c.GetAll(key)
+	if len(values) == 0 {
+		return "", false
+	}
+	return values[len(values)-1], true
+}
+
+// GetAll returns every value of a multi-valued key, in the order read
+func (c *Config) GetAll(key string) []string {
+	key, err := NormalizeKey(key)
+	if err != nil {
+		return nil
+	}
+
+	var values []string
+	for _, entry := range c.entries {
+		if entry.Key == key {
+			values = append(values, entry.Value)
+		}
+	}
+	return values
+}
+
+// Subsections returns the subsections of section that have entries, such
+// as the names of the configured remotes for "remote"
+func (c *Config) Subsections(section string) []string {
+	prefix := strings.ToLower(section) + "."
+	seen := make(map[string]bool)
+
+	var names []string
+	for _, entry := range c.entries {
+		if !strings.HasPrefix(entry.Key, prefix) {
+			continue
+		}
+		rest := entry.Key[len(prefix):]
+		idx := strings.LastIndex(rest, ".")
+		if idx < 0 || seen[rest[:idx]] {
+			continue
+		}
+		seen[rest[:idx]] = true
+		names = append(names, rest[:idx])
+	}
+	return names
+}
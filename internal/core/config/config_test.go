@@ -0,0 +1,349 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// isolate points the system and global config at files in a temporary
+// directory, returning their paths
+func isolate(t *testing.T) (system, global string) {
+	dir := t.TempDir()
+	system = filepath.Join(dir, "system")
+	global = filepath.Join(dir, "global")
+	t.Setenv("GIT_CONFIG_SYSTEM", system)
+	t.Setenv("GIT_CONFIG_GLOBAL", global)
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "")
+	t.Setenv("HOME", dir)
+	return system, global
+}
+
+func writeConfig(t *testing.T, path, content string) {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParse(t *testing.T) {
+	content := `# leading comment
+[core]
+	bare = false ; trailing comment
+	Editor = "vim -f"
+	filemode
+[remote "Origin"]
+	url = https://example.com/repo.git
+	fetch = +refs/heads/*:refs/remotes/Origin/*
+[alias]
+	lg = log \
+--oneline
+	say = "echo \"hi\"\tthere # not a comment"
+[Branch.Main]
+	remote = origin
+`
+	f, err := Parse("config", []byte(content))
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+
+	tests := []struct {
+		key  string
+		want string
+	}{
+		{"core.bare", "false"},
+		{"core.editor", "vim -f"},
+		{"CORE.EDITOR", "vim -f"},
+		{"core.filemode", "true"},
+		{"remote.Origin.url", "https://example.com/repo.git"},
+		{"alias.lg", "log --oneline"},
+		{"alias.say", "echo \"hi\"\tthere # not a comment"},
+		{"branch.main.remote", "origin"},
+	}
+	for _, tt := range tests {
+		if got, ok := f.Get(tt.key); !ok || got != tt.want {
+			t.Errorf("Get(%q) = %q, %v, want %q", tt.key, got, ok, tt.want)
+		}
+	}
+
+	if _, ok := f.Get("remote.origin.url"); ok {
+		t.Error("subsections should be case-sensitive")
+	}
+
+	entries := f.Entries()
+	if len(entries) != 8 {
+		t.Fatalf("Entries() returned %d entries, want 8", len(entries))
+	}
+	if entries[5].Key != "alias.lg" || entries[5].Line != 10 {
+		t.Errorf("entry 5 = %+v, want alias.lg on line 10", entries[5])
+	}
+
+	// Rewriting an unchanged file keeps it byte for byte
+	if got := string(f.Bytes()); got != content {
+		t.Errorf("Bytes() = %q, want %q", got, content)
+	}
+}
+
+func TestParse_Errors(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+	}{
+		{"variable outside section", "bare = true\n"},
+		{"unterminated header", "[core\n"},
+		{"bad subsection", "[remote origin]\n"},
+		{"unterminated quote", "[core]\n\teditor = \"vim\n"},
+		{"bad escape", "[core]\n\teditor = vi\\m\n"},
+		{"bad name", "[core]\n\t1bare = true\n"},
+		{"continued past end", "[core]\n\teditor = vim \\"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := Parse("config", []byte(tt.content)); err == nil {
+				t.Error("Parse() succeeded, want error")
+			}
+		})
+	}
+}
+
+func TestFile_SetAddUnset(t *testing.T) {
+	f, err := Parse("config", []byte("[core]\n\tbare = false\n# keep me\n[user]\n\tname = Old\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := f.Set("user.name", "New Name"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Set("core.objectCacheSize", "1m"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Set("remote.origin.url", "/srv/repo.git"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Add("remote.origin.fetch", "+refs/heads/*:refs/remotes/origin/*"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Add("remote.origin.fetch", "+refs/tags/*:refs/tags/*"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Set("user.signature", " padded; value "); err != nil {
+		t.Fatal(err)
+	}
+
+	want := `[core]
+	bare = false
+	objectCacheSize = 1m
+# keep me
+[user]
+	name = New Name
+	signature = " padded; value "
+[remote "origin"]
+	url = /srv/repo.git
+	fetch = +refs/heads/*:refs/remotes/origin/*
+	fetch = +refs/tags/*:refs/tags/*
+`
+	if got := string(f.Bytes()); got != want {
+		t.Errorf("Bytes() =\n%s\nwant\n%s", got, want)
+	}
+
+	// The written file parses back to the same values
+	reparsed, err := Parse("config", f.Bytes())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, _ := reparsed.Get("user.signature"); got != " padded; value " {
+		t.Errorf("user.signature = %q", got)
+	}
+
+	if err := f.Set("remote.origin.fetch", "x"); err == nil {
+		t.Error("Set() on a multi-valued key succeeded, want error")
+	}
+	if _, err := f.Unset("remote.origin.fetch"); err == nil {
+		t.Error("Unset() on a multi-valued key succeeded, want error")
+	}
+	if n, err := f.UnsetAll("remote.origin.fetch"); err != nil || n != 2 {
+		t.Errorf("UnsetAll() = %d, %v, want 2", n, err)
+	}
+	if ok, err := f.Unset("user.missing"); err != nil || ok {
+		t.Errorf("Unset(missing) = %v, %v, want false", ok, err)
+	}
+	if ok, err := f.Unset("user.name"); err != nil || !ok {
+		t.Errorf("Unset(user.name) = %v, %v, want true", ok, err)
+	}
+	if ok, _ := f.Unset("user.signature"); !ok {
+		t.Error("Unset(user.signature) = false, want true")
+	}
+	if !f.RemoveSection("remote", "origin") {
+		t.Error("RemoveSection() = false, want true")
+	}
+
+	want = "[core]\n\tbare = false\n\tobjectCacheSize = 1m\n# keep me\n"
+	if got := string(f.Bytes()); got != want {
+		t.Errorf("Bytes() = %q, want %q", got, want)
+	}
+
+	if err := f.Set("nosection", "x"); err == nil {
+		t.Error("Set() without a section succeeded, want error")
+	}
+}
+
+func TestFile_Save(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "sub", "config")
+	f, err := ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Set("user.email", "me@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	if err := f.Save(); err != nil {
+		t.Fatalf("Save() error = %v", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "[user]\n\temail = me@example.com\n" {
+		t.Errorf("saved %q", data)
+	}
+	if _, err := os.Stat(path + ".lock"); !os.IsNotExist(err) {
+		t.Error("lock file left behind")
+	}
+}
+
+func TestLoad_Scopes(t *testing.T) {
+	system, global := isolate(t)
+	gitDir := filepath.Join(t.TempDir(), ".git")
+
+	writeConfig(t, system, "[user]\n\tname = System\n[core]\n\teditor = ed\n")
+	writeConfig(t, global, "[user]\n\tname = Global\n\temail = global@example.com\n")
+	writeConfig(t, filepath.Join(gitDir, "config"), "[user]\n\tname = Local\n")
+
+	c, err := Load(gitDir)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+
+	if got, _ := c.Get("user.name"); got != "Local" {
+		t.Errorf("user.name = %q, want Local", got)
+	}
+	if got, _ := c.Get("user.email"); got != "global@example.com" {
+		t.Errorf("user.email = %q", got)
+	}
+	if got, _ := c.Get("core.editor"); got != "ed" {
+		t.Errorf("core.editor = %q", got)
+	}
+	if got := c.GetAll("user.name"); !reflect.DeepEqual(got, []string{"System", "Global", "Local"}) {
+		t.Errorf("GetAll(user.name) = %v", got)
+	}
+
+	var scopes []Scope
+	for _, entry := range c.Entries() {
+		if entry.Key == "user.name" {
+			scopes = append(scopes, entry.Scope)
+		}
+	}
+	if !reflect.DeepEqual(scopes, []Scope{ScopeSystem, ScopeGlobal, ScopeLocal}) {
+		t.Errorf("scopes = %v", scopes)
+	}
+
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	c, err = Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c.GetAll("user.name"); !reflect.DeepEqual(got, []string{"Global"}) {
+		t.Errorf("without system and repository, GetAll(user.name) = %v", got)
+	}
+}
+
+func TestLoad_Includes(t *testing.T) {
+	_, global := isolate(t)
+	home := filepath.Dir(global)
+	work := filepath.Join(home, "work", "project")
+	gitDir := filepath.Join(work, ".git")
+	if err := os.MkdirAll(gitDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	writeConfig(t, filepath.Join(gitDir, "HEAD"), "ref: refs/heads/release/1.0\n")
+
+	writeConfig(t, global, `[include]
+	path = common.inc
+[includeIf "gitdir:~/work/"]
+	path = ~/work.inc
+[includeIf "gitdir:~/other/"]
+	path = other.inc
+[includeIf "onbranch:release/"]
+	path = release.inc
+[user]
+	name = After Includes
+`)
+	writeConfig(t, filepath.Join(home, "common.inc"), "[user]\n\tname = Common\n\temail = common@example.com\n")
+	writeConfig(t, filepath.Join(home, "work.inc"), "[user]\n\temail = work@example.com\n")
+	writeConfig(t, filepath.Join(home, "other.inc"), "[user]\n\temail = other@example.com\n")
+	writeConfig(t, filepath.Join(home, "release.inc"), "[core]\n\tabbrev = 12\n")
+
+	c, err := Load(gitDir)
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if got, _ := c.Get("user.name"); got != "After Includes" {
+		t.Errorf("user.name = %q", got)
+	}
+	if got, _ := c.Get("user.email"); got != "work@example.com" {
+		t.Errorf("user.email = %q, want the gitdir include", got)
+	}
+	if got, _ := c.Get("core.abbrev"); got != "12" {
+		t.Errorf("core.abbrev = %q, want the onbranch include", got)
+	}
+
+	for _, entry := range c.Entries() {
+		if entry.Value == "work@example.com" && (entry.Scope != ScopeGlobal || !strings.HasSuffix(entry.File, "work.inc")) {
+			t.Errorf("included entry = %+v", entry)
+		}
+	}
+}
+
+func TestLoad_IncludeLoop(t *testing.T) {
+	_, global := isolate(t)
+	writeConfig(t, global, "[include]\n\tpath = global\n")
+
+	if _, err := Load(""); err == nil || !strings.Contains(err.Error(), "maximum include depth") {
+		t.Errorf("Load() error = %v, want include depth error", err)
+	}
+}
+
+func TestConfig_Subsections(t *testing.T) {
+	_, global := isolate(t)
+	writeConfig(t, global, "[remote \"origin\"]\n\turl = a\n\tfetch = b\n[remote \"up.stream\"]\n\turl = c\n[remote]\n\tpushDefault = origin\n")
+
+	c, err := Load("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c.Subsections("remote"); !reflect.DeepEqual(got, []string{"origin", "up.stream"}) {
+		t.Errorf("Subsections() = %v", got)
+	}
+	if got, _ := c.Get("remote.up.stream.url"); got != "c" {
+		t.Errorf("remote.up.stream.url = %q", got)
+	}
+}
+
+func TestParseBool(t *testing.T) {
+	for value, want := range map[string]bool{"true": true, "Yes": true, "on": true, "1": true, "false": false, "off": false, "": false} {
+		got, err := ParseBool(value)
+		if err != nil || got != want {
+			t.Errorf("ParseBool(%q) = %v, %v, want %v", value, got, err, want)
+		}
+	}
+	if _, err := ParseBool("maybe"); err == nil {
+		t.Error("ParseBool(maybe) succeeded, want error")
+	}
+}
@@ -0,0 +1,601 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// Entry is one variable read from a config file
+type Entry struct {
+	Key   string // section[.subsection].name, section and name lowercased
+	Value string
+	Scope Scope
+	File  string
+	Line  int
+}
+
+// File is a single config file. It keeps the file line by line so that
+// changes can be written back without disturbing comments and layout.
+type File struct {
+	path  string
+	lines []line
+}
+
+// line is one logical line of a config file. A value continued with a
+// trailing backslash spans several physical lines, all kept in text.
+type line struct {
+	text       string
+	number     int
+	section    string // lowercased; set for every line after a header
+	subsection string
+	name       string // lowercased; empty for headers, comments and blanks
+	value      string
+	header     bool
+}
+
+// key parts of a config key such as remote.origin.url
+type key struct {
+	section    string
+	subsection string
+	name       string
+}
+
+// NormalizeKey returns key with its section and name lowercased
+func NormalizeKey(name string) (string, error) {
+	k, err := parseKey(name)
+	if err != nil {
+		return "", err
+	}
+	return k.String(), nil
+}
+
+// parseKey splits name, keeping the case it was given in
+func parseKey(name string) (key, error) {
+	first := strings.Index(name, ".")
+	last := strings.LastIndex(name, ".")
+	if first <= 0 {
+		return key{}, fmt.Errorf("key does not contain a section: %s", name)
+	}
+	if last == len(name)-1 {
+		return key{}, fmt.Errorf("key does not contain variable name: %s", name)
+	}
+
+	k := key{section: name[:first], name: name[last+1:]}
+	if first < last {
+		k.subsection = name[first+1 : last]
+	}
+	if !validSection(k.section) || !validName(k.name) {
+		return key{}, fmt.Errorf("invalid key: %s", name)
+	}
+	return k, nil
+}
+
+// String returns the normalized form of the key
+func (k key) String() string {
+	s := strings.ToLower(k.section)
+	if k.subsection != "" {
+		s += "." + k.subsection
+	}
+	return s + "." + strings.ToLower(k.name)
+}
+
+// matchesSection reports whether l is a header or variable of the key's section
+func (k key) matchesSection(l line) bool {
+	return l.section == strings.ToLower(k.section) && l.subsection == k.subsection
+}
+
+func validSection(s string) bool {
+	for _, c := range s {
+		if !isAlnum(c) && c != '-' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+func validName(s string) bool {
+	if s == "" || !isAlpha(rune(s[0])) {
+		return false
+	}
+	for _, c := range s {
+		if !isAlnum(c) && c != '-' {
+			return false
+		}
+	}
+	return true
+}
+
+func isAlpha(c rune) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isAlnum(c rune) bool {
+	return isAlpha(c) || (c >= '0' && c <= '9')
+}
+
+// NewFile creates an empty config file that will be saved to path
+func NewFile(path string) *File {
+	return &File{path: path}
+}
+
+// ReadFile reads the config file at path. A missing file reads as empty.
+func ReadFile(path string) (*File, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return NewFile(path), nil
+		}
+		return nil, fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+	return Parse(path, data)
+}
+
+// Parse parses the content of the config file at path
+func Parse(path string, data []byte) (*File, error) {
+	f := NewFile(path)
+
+	content := strings.ReplaceAll(string(data), "\r\n", "\n")
+	content = strings.TrimSuffix(content, "\n")
+	if content == "" {
+		return f, nil
+	}
+	physical := strings.Split(content, "\n")
+
+	var section, subsection string
+	for i := 0; i < len(physical); i++ {
+		l := line{text: physical[i], number: i + 1, section: section, subsection: subsection}
+		trimmed := strings.TrimSpace(l.text)
+
+		switch {
+		case trimmed == "" || trimmed[0] == '#' || trimmed[0] == ';':
+		case trimmed[0] == '[':
+			var err error
+			if section, subsection, err = parseHeader(trimmed); err != nil {
+				return nil, f.lineError(l.number, err)
+			}
+			l.header, l.section, l.subsection = true, section, subsection
+		default:
+			if section == "" {
+				return nil, f.lineError(l.number, fmt.Errorf("variable outside of a section"))
+			}
+			name, rest := splitName(trimmed)
+			if !validName(name) {
+				return nil, f.lineError(l.number, fmt.Errorf("invalid variable name"))
+			}
+			l.name = strings.ToLower(name)
+
+			rest = strings.TrimSpace(rest)
+			switch {
+			case rest == "" || rest[0] == '#' || rest[0] == ';':
+				// A bare variable name is a boolean true
+				l.value = "true"
+			case rest[0] == '=':
+				value, used, err := parseValue(rest[1:], physical[i+1:])
+				if err != nil {
+					return nil, f.lineError(l.number, err)
+				}
+				l.value = value
+				for ; used > 0; used-- {
+					i++
+					l.text += "\n" + physical[i]
+				}
+			default:
+				return nil, f.lineError(l.number, fmt.Errorf("expected '=' after variable name"))
+			}
+		}
+
+		f.lines = append(f.lines, l)
+	}
+
+	return f, nil
+}
+
+func (f *File) lineError(number int, err error) error {
+	name := f.path
+	if name == "" {
+		name = "config"
+	}
+	return fmt.Errorf("bad config line %d in %s: %w", number, name, err)
+}
+
+// parseHeader parses a [section], [section "subsection"] or legacy
+// [section.subsection] header
+func parseHeader(text string) (section, subsection string, err error) {
+	end := strings.LastIndex(text, "]")
+	if end < 0 {
+		return "", "", fmt.Errorf("unterminated section header")
+	}
+	if rest := strings.TrimSpace(text[end+1:]); rest != "" && rest[0] != '#' && rest[0] != ';' {
+		return "", "", fmt.Errorf("unexpected text after section header")
+	}
+	inner := text[1:end]
+
+	if idx := strings.IndexAny(inner, " \t"); idx >= 0 {
+		section = inner[:idx]
+		quoted := strings.TrimSpace(inner[idx:])
+		if len(quoted) < 2 || quoted[0] != '"' || quoted[len(quoted)-1] != '"' {
+			return "", "", fmt.Errorf("invalid subsection in section header")
+		}
+		var b strings.Builder
+		for i := 1; i < len(quoted)-1; i++ {
+			if quoted[i] == '\\' && i+1 < len(quoted)-1 {
+				i++
+			}
+			b.WriteByte(quoted[i])
+		}
+		subsection = b.String()
+	} else if idx := strings.Index(inner, "."); idx >= 0 {
+		// Legacy syntax, where the subsection is case-insensitive
+		section, subsection = inner[:idx], strings.ToLower(inner[idx+1:])
+	} else {
+		section = inner
+	}
+
+	if !validSection(section) {
+		return "", "", fmt.Errorf("invalid section name %q", section)
+	}
+	return strings.ToLower(section), subsection, nil
+}
+
+// splitName splits a variable line into its name and the text after it
+func splitName(text string) (string, string) {
+	end := 0
+	for end < len(text) && (isAlnum(rune(text[end])) || text[end] == '-') {
+		end++
+	}
+	return text[:end], text[end:]
+}
+
+// parseValue parses the value after '='. Quotes and escapes are removed,
+// comments and surrounding whitespace dropped, and a trailing backslash
+// continues the value on the next line. It returns the number of
+// following lines the value used.
+func parseValue(text string, next []string) (string, int, error) {
+	var b strings.Builder
+	var pending strings.Builder // whitespace, kept only if more text follows
+	inQuote := false
+	used := 0
+
+	for {
+		continued := false
+	scan:
+		for i := 0; i < len(text); i++ {
+			c := text[i]
+			switch {
+			case c == '\\':
+				if i+1 == len(text) {
+					continued = true
+					break scan
+				}
+				i++
+				switch text[i] {
+				case 'n':
+					c = '\n'
+				case 't':
+					c = '\t'
+				case 'b':
+					c = '\b'
+				case '\\', '"':
+					c = text[i]
+				default:
+					return "", 0, fmt.Errorf("invalid escape sequence \\%c", text[i])
+				}
+				flushPending(&b, &pending)
+				b.WriteByte(c)
+			case c == '"':
+				inQuote = !inQuote
+			case !inQuote && (c == '#' || c == ';'):
+				break scan
+			case !inQuote && (c == ' ' || c == '\t'):
+				pending.WriteByte(c)
+			default:
+				flushPending(&b, &pending)
+				b.WriteByte(c)
+			}
+		}
+
+		if !continued {
+			break
+		}
+		if used == len(next) {
+			return "", 0, fmt.Errorf("value continued past the end of the file")
+		}
+		text = next[used]
+		used++
+	}
+
+	if inQuote {
+		return "", 0, fmt.Errorf("unterminated quoted value")
+	}
+	return b.String(), used, nil
+}
+
+func flushPending(b, pending *strings.Builder) {
+	if b.Len() > 0 {
+		b.WriteString(pending.String())
+	}
+	pending.Reset()
+}
+
+// formatValue quotes and escapes value for writing
+func formatValue(value string) string {
+	var b strings.Builder
+	for _, c := range value {
+		switch c {
+		case '\\', '"':
+			b.WriteRune('\\')
+			b.WriteRune(c)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\b':
+			b.WriteString(`\b`)
+		default:
+			b.WriteRune(c)
+		}
+	}
+
+	s := b.String()
+	if strings.TrimSpace(value) != value || strings.ContainsAny(value, "#;") {
+		s = `"` + s + `"`
+	}
+	return s
+}
+
+// formatHeader returns the header line of a section
+func formatHeader(section, subsection string) string {
+	if subsection == "" {
+		return "[" + section + "]"
+	}
+	escaped := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(subsection)
+	return fmt.Sprintf("[%s \"%s\"]", section, escaped)
+}
+
+// Path returns the path the file is read from and saved to
+func (f *File) Path() string {
+	return f.path
+}
+
+// Entries returns the file's variables in the order they appear
+func (f *File) Entries() []Entry {
+	var entries []Entry
+	for _, l := range f.lines {
+		if l.name == "" {
+			continue
+		}
+		entries = append(entries, Entry{
+			Key:   key{section: l.section, subsection: l.subsection, name: l.name}.String(),
+			Value: l.value,
+			File:  f.path,
+			Line:  l.number,
+		})
+	}
+	return entries
+}
+
+// Get returns the last value of key in the file
+func (f *File) Get(name string) (string, bool) {
+	values := f.GetAll(name)
+	if len(values) == 0 {
+		return "", false
+	}
+	return values[len(values)-1], true
+}
+
+// GetAll returns every value of key in the file
+func (f *File) GetAll(name string) []string {
+	k, err := parseKey(name)
+	if err != nil {
+		return nil
+	}
+
+	var values []string
+	for _, i := range f.find(k) {
+		values = append(values, f.lines[i].value)
+	}
+	return values
+}
+
+// find returns the indexes of the lines holding key
+func (f *File) find(k key) []int {
+	var found []int
+	for i, l := range f.lines {
+		if l.name == strings.ToLower(k.name) && k.matchesSection(l) {
+			found = append(found, i)
+		}
+	}
+	return found
+}
+
+// Set sets key to value, replacing its current value. It fails when the
+// key has several values.
+func (f *File) Set(name, value string) error {
+	k, err := parseKey(name)
+	if err != nil {
+		return err
+	}
+
+	found := f.find(k)
+	switch len(found) {
+	case 0:
+		f.add(k, value)
+	case 1:
+		l := &f.lines[found[0]]
+		l.text = formatVariable(k, value)
+		l.value = value
+	default:
+		return fmt.Errorf("cannot overwrite multiple values of %s with a single value", k)
+	}
+	return nil
+}
+
+// Add adds a value to key, keeping any values it already has
+func (f *File) Add(name, value string) error {
+	k, err := parseKey(name)
+	if err != nil {
+		return err
+	}
+	f.add(k, value)
+	return nil
+}
+
+// add inserts key = value at the end of the last block of its section,
+// starting a new section at the end of the file when there is none
+func (f *File) add(k key, value string) {
+	l := line{
+		text:       formatVariable(k, value),
+		section:    strings.ToLower(k.section),
+		subsection: k.subsection,
+		name:       strings.ToLower(k.name),
+		value:      value,
+	}
+
+	at := -1
+	for i, existing := range f.lines {
+		if (existing.header || existing.name != "") && k.matchesSection(existing) {
+			at = i + 1
+		}
+	}
+
+	if at < 0 {
+		header := line{
+			text:       formatHeader(strings.ToLower(k.section), k.subsection),
+			section:    l.section,
+			subsection: l.subsection,
+			header:     true,
+		}
+		f.lines = append(f.lines, header, l)
+		return
+	}
+
+	f.lines = append(f.lines, line{})
+	copy(f.lines[at+1:], f.lines[at:])
+	f.lines[at] = l
+}
+
+func formatVariable(k key, value string) string {
+	return "\t" + k.name + " = " + formatValue(value)
+}
+
+// Unset removes key, reporting whether it was set. It fails when the key
+// has several values.
+func (f *File) Unset(name string) (bool, error) {
+	k, err := parseKey(name)
+	if err != nil {
+		return false, err
+	}
+
+	found := f.find(k)
+	if len(found) > 1 {
+		return false, fmt.Errorf("%s has multiple values", k)
+	}
+	f.remove(found)
+	return len(found) == 1, nil
+}
+
+// UnsetAll removes every value of key and returns how many there were
+func (f *File) UnsetAll(name string) (int, error) {
+	k, err := parseKey(name)
+	if err != nil {
+		return 0, err
+	}
+
+	found := f.find(k)
+	f.remove(found)
+	return len(found), nil
+}
+
+// remove drops the given lines, along with the headers of their sections
+// when nothing is left in them
+func (f *File) remove(indexes []int) {
+	if len(indexes) == 0 {
+		return
+	}
+
+	drop := make(map[int]bool, len(indexes))
+	touched := make(map[key]bool)
+	for _, i := range indexes {
+		drop[i] = true
+		touched[key{section: f.lines[i].section, subsection: f.lines[i].subsection}] = true
+	}
+
+	var kept []line
+	for i, l := range f.lines {
+		if !drop[i] {
+			kept = append(kept, l)
+		}
+	}
+
+	f.lines = kept[:0]
+	for i, l := range kept {
+		empty := i+1 == len(kept) || kept[i+1].header
+		if l.header && empty && touched[key{section: l.section, subsection: l.subsection}] {
+			continue
+		}
+		f.lines = append(f.lines, l)
+	}
+}
+
+// RemoveSection removes every block of the given section, reporting
+// whether there was one
+func (f *File) RemoveSection(section, subsection string) bool {
+	section = strings.ToLower(section)
+
+	var kept []line
+	removed, inSection := false, false
+	for _, l := range f.lines {
+		if l.header {
+			inSection = l.section == section && l.subsection == subsection
+			removed = removed || inSection
+		}
+		if !inSection {
+			kept = append(kept, l)
+		}
+	}
+
+	f.lines = kept
+	return removed
+}
+
+// Bytes returns the content of the file
+func (f *File) Bytes() []byte {
+	var b strings.Builder
+	for _, l := range f.lines {
+		b.WriteString(l.text)
+		b.WriteByte('\n')
+	}
+	return []byte(b.String())
+}
+
+// Save writes the file through a lock file, so readers never see it half
+// written
+func (f *File) Save() error {
+	if err := os.MkdirAll(filepath.Dir(f.path), 0755); err != nil {
+		return fmt.Errorf("failed to create config directory: %w", err)
+	}
+
+	lockPath := f.path + ".lock"
+	lock, err := os.OpenFile(lockPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to lock config file %s: %w", f.path, err)
+	}
+
+	if _, err := lock.Write(f.Bytes()); err != nil {
+		lock.Close()
+		os.Remove(lockPath)
+		return fmt.Errorf("failed to write config file %s: %w", f.path, err)
+	}
+	if err := lock.Close(); err != nil {
+		os.Remove(lockPath)
+		return fmt.Errorf("failed to write config file %s: %w", f.path, err)
+	}
+
+	if err := os.Rename(lockPath, f.path); err != nil {
+		os.Remove(lockPath)
+		return fmt.Errorf("failed to write config file %s: %w", f.path, err)
+	}
+	return nil
+}
@@ -0,0 +1,355 @@
+// Package convert converts file content between the working tree and the
+// repository as the attributes of each path ask: filter drivers clean
+// content on its way in and smudge it on its way out, and text has its line
+// endings normalized to LF in the repository and converted to the
+// configured ones in the working tree.
+package convert
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/fenilsonani/vcs/internal/core/workdir"
+)
+
+// Driver is a filter driver, configured as filter.<name>.*
+type Driver struct {
+	// Clean and Smudge are shell commands run with the content on stdin,
+	// where %f stands for the path being filtered
+	Clean  string
+	Smudge string
+	// CleanFunc and SmudgeFunc filter in process, for drivers built in
+	// rather than configured; the commands take precedence
+	CleanFunc  func(path string, data []byte) ([]byte, error)
+	SmudgeFunc func(path string, data []byte) ([]byte, error)
+	// Required makes a filter that is missing or fails an error instead
+	// of passing content through unchanged
+	Required bool
+}
+
+// DiffDriver is a diff driver, configured as diff.<name>.* and chosen by
+// the diff attribute
+type DiffDriver struct {
+	// Textconv is a command run with the path of a temporary file holding
+	// the content, whose output diff shows in place of the content
+	Textconv string
+	// CacheTextconv keeps the output of Textconv for each blob
+	CacheTextconv bool
+	// Command is an external diff program that replaces the built-in diff
+	Command string
+	// Binary makes diff treat the content as binary
+	Binary bool
+}
+
+// Options are the settings that govern conversion
+type Options struct {
+	// AutoCRLF is core.autocrlf: "true", "input" or "false"
+	AutoCRLF string
+	// EOL is core.eol: "lf", "crlf" or "native"
+	EOL string
+	// Drivers are the filter drivers by name
+	Drivers map[string]Driver
+	// DiffDrivers are the diff drivers by name
+	DiffDrivers map[string]DiffDriver
+	// LargeFileFilter, when LargeFileThreshold is positive, is the driver
+	// for paths without a filter attribute: content larger than the
+	// threshold is cleaned with it, and all content smudged with it
+	LargeFileFilter    string
+	LargeFileThreshold int64
+	// WorkTree is where filter commands run
+	WorkTree string
+	// Stderr receives the stderr of filter commands and warnings about
+	// filters that failed
+	Stderr io.Writer
+}
+
+// Converter converts content for the attributes of each path
+type Converter struct {
+	attrs *workdir.AttributeResolver
+	opts  Options
+}
+
+// New returns a converter resolving attributes with attrs
+func New(attrs *workdir.AttributeResolver, opts Options) *Converter {
+	if opts.Stderr == nil {
+		opts.Stderr = io.Discard
+	}
+	return &Converter{attrs: attrs, opts: opts}
+}
+
+// Attributes returns the attributes of path
+func (c *Converter) Attributes(path string) workdir.AttributeSet {
+	return c.attrs.Lookup(path)
+}
+
+// Clean converts the working tree content of path to what is stored in the
+// repository: the filter driver's clean command runs, then the line endings
+// of text are normalized to LF
+func (c *Converter) Clean(path string, data []byte) ([]byte, error) {
+	attrs := c.attrs.Lookup(path)
+	data, err := c.filter(attrs, path, data, false)
+	if err != nil {
+		return nil, err
+	}
+
+	switch c.textMode(attrs) {
+	case textNone:
+		return data, nil
+	case textAuto:
+		if IsBinary(data) {
+			return data, nil
+		}
+	}
+	if !bytes.Contains(data, []byte("\r\n")) {
+		return data, nil
+	}
+	return bytes.ReplaceAll(data, []byte("\r\n"), []byte("\n")), nil
+}
+
+// Smudge converts the content of path stored in the repository to what is
+// written in the working tree: text gets the configured line endings, then
+// the filter driver's smudge command runs
+func (c *Converter) Smudge(path string, data []byte) ([]byte, error) {
+	attrs := c.attrs.Lookup(path)
+
+	convert := true
+	switch c.textMode(attrs) {
+	case textNone:
+		convert = false
+	case textAuto:
+		// Content committed with CRLF is left as it is
+		convert = !IsBinary(data) && !bytes.Contains(data, []byte("\r\n"))
+	}
+	if convert && c.crlfOutput(attrs) {
+		data = toCRLF(data)
+	}
+
+	return c.filter(attrs, path, data, true)
+}
+
+// DiffBinary reports whether diff shows the content of path as binary: when
+// the diff attribute is unset, as by binary, or names a driver that is
+// binary or has a textconv, or otherwise unless it is set when the content
+// looks binary. Content converted by a textconv is never binary.
+func (c *Converter) DiffBinary(path string, data []byte) bool {
+	attrs := c.attrs.Lookup(path)
+	switch {
+	case attrs.IsUnset("diff"):
+		return true
+	case attrs.IsSet("diff"):
+		return false
+	}
+	if _, driver, ok := c.DiffDriver(path); ok && (driver.Binary || driver.Textconv != "") {
+		return true
+	}
+	return IsBinary(data)
+}
+
+// DiffDriver returns the configured diff driver the diff attribute of path
+// names, and its name
+func (c *Converter) DiffDriver(path string) (string, DiffDriver, bool) {
+	name, ok := c.attrs.Lookup(path).Value("diff")
+	if !ok {
+		return "", DiffDriver{}, false
+	}
+	driver, ok := c.opts.DiffDrivers[name]
+	return name, driver, ok
+}
+
+// Textconv runs the textconv command of path's diff driver on data and
+// returns its output, or reports false when the driver has none. The
+// command is given a temporary file named after path, so that tools going
+// by the extension work.
+func (c *Converter) Textconv(path string, data []byte) ([]byte, bool, error) {
+	name, driver, ok := c.DiffDriver(path)
+	if !ok || driver.Textconv == "" {
+		return nil, false, nil
+	}
+
+	tmp, err := os.CreateTemp("", "*_"+filepath.Base(path))
+	if err != nil {
+		return nil, false, err
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(data)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, false, err
+	}
+
+	cmd := exec.Command("sh", "-c", driver.Textconv+` "$@"`, driver.Textconv, tmp.Name())
+	cmd.Dir = c.opts.WorkTree
+	cmd.Stderr = c.opts.Stderr
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		return nil, false, fmt.Errorf("%s: textconv '%s' failed: %w", path, name, err)
+	}
+	return out.Bytes(), true, nil
+}
+
+// IsBinary reports whether data looks binary as Git guesses it: it has a NUL
+// byte in its first 8000 bytes
+func IsBinary(data []byte) bool {
+	if len(data) > 8000 {
+		data = data[:8000]
+	}
+	return bytes.IndexByte(data, 0) >= 0
+}
+
+type textMode int
+
+const (
+	textNone textMode = iota
+	textSet
+	textAuto
+)
+
+// textMode returns how the text attribute, or core.autocrlf when it is
+// unspecified, has line endings converted. An eol attribute makes a path
+// text.
+func (c *Converter) textMode(attrs workdir.AttributeSet) textMode {
+	switch {
+	case attrs.IsUnset("text"):
+		return textNone
+	case attrs.IsSet("text"):
+		return textSet
+	}
+	if value, ok := attrs.Value("text"); ok && value == "auto" {
+		return textAuto
+	}
+	if eol, ok := attrs.Value("eol"); ok && (eol == "lf" || eol == "crlf") {
+		return textSet
+	}
+	switch c.opts.AutoCRLF {
+	case "true", "input":
+		return textAuto
+	}
+	return textNone
+}
+
+// crlfOutput reports whether text is written to the working tree with CRLF
+// line endings: as the eol attribute says, or else core.autocrlf or core.eol
+func (c *Converter) crlfOutput(attrs workdir.AttributeSet) bool {
+	if eol, ok := attrs.Value("eol"); ok {
+		return eol == "crlf"
+	}
+	switch c.opts.AutoCRLF {
+	case "true":
+		return true
+	case "input":
+		return false
+	}
+	switch c.opts.EOL {
+	case "crlf":
+		return true
+	case "lf":
+		return false
+	}
+	return runtime.GOOS == "windows"
+}
+
+// toCRLF converts the LF line endings in data to CRLF
+func toCRLF(data []byte) []byte {
+	var out bytes.Buffer
+	out.Grow(len(data) + bytes.Count(data, []byte("\n")))
+	for i, b := range data {
+		if b == '\n' && (i == 0 || data[i-1] != '\r') {
+			out.WriteByte('\r')
+		}
+		out.WriteByte(b)
+	}
+	return out.Bytes()
+}
+
+// filter runs the clean or smudge command of the filter driver path's
+// filter attribute names. A driver that is not configured, or has no
+// command for the direction, passes content through unless required.
+func (c *Converter) filter(attrs workdir.AttributeSet, path string, data []byte, smudge bool) ([]byte, error) {
+	name, ok := attrs.Value("filter")
+	if !ok {
+		if c.opts.LargeFileFilter == "" || c.opts.LargeFileThreshold <= 0 {
+			return data, nil
+		}
+		if !smudge && int64(len(data)) <= c.opts.LargeFileThreshold {
+			return data, nil
+		}
+		name = c.opts.LargeFileFilter
+	}
+	driver, ok := c.opts.Drivers[name]
+	if !ok {
+		return data, nil
+	}
+
+	direction, command, fn := "clean", driver.Clean, driver.CleanFunc
+	if smudge {
+		direction, command, fn = "smudge", driver.Smudge, driver.SmudgeFunc
+	}
+	if command == "" && fn == nil {
+		if driver.Required {
+			return nil, fmt.Errorf("%s: filter.%s.%s is required but not configured", path, name, direction)
+		}
+		return data, nil
+	}
+
+	var out []byte
+	var err error
+	if command != "" {
+		out, err = c.run(command, path, data)
+	} else {
+		out, err = fn(path, data)
+	}
+	if err != nil {
+		if driver.Required {
+			return nil, fmt.Errorf("%s: %s filter '%s' failed: %w", path, direction, name, err)
+		}
+		fmt.Fprintf(c.opts.Stderr, "warning: %s: %s filter '%s' failed: %v\n", path, direction, name, err)
+		return data, nil
+	}
+	return out, nil
+}
+
+// run runs command with the shell, %f replaced with path quoted and %% with
+// a single %
+func (c *Converter) run(command, path string, data []byte) ([]byte, error) {
+	var expanded strings.Builder
+	for i := 0; i < len(command); i++ {
+		if command[i] == '%' && i+1 < len(command) {
+			switch command[i+1] {
+			case 'f':
+				expanded.WriteString(shellQuote(path))
+				i++
+				continue
+			case '%':
+				expanded.WriteByte('%')
+				i++
+				continue
+			}
+		}
+		expanded.WriteByte(command[i])
+	}
+
+	cmd := exec.Command("sh", "-c", expanded.String())
+	cmd.Dir = c.opts.WorkTree
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stderr = c.opts.Stderr
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+// shellQuote quotes s as a single word for sh
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,190 @@
+package convert
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/fenilsonani/vcs/internal/core/workdir"
+)
+
+// newTestConverter returns a converter for a working tree whose only
+// .gitattributes has content
+func newTestConverter(t *testing.T, content string, opts Options) *Converter {
+	read := func(dir string) ([]byte, bool) {
+		return []byte(content), dir == ""
+	}
+	if opts.WorkTree == "" {
+		opts.WorkTree = t.TempDir()
+	}
+	return New(workdir.NewAttributeResolver(read, "", ""), opts)
+}
+
+func TestCleanNormalizesText(t *testing.T) {
+	c := newTestConverter(t, "*.txt text\n*.raw -text\n*.auto text=auto\n", Options{})
+
+	tests := []struct {
+		path string
+		in   string
+		want string
+	}{
+		{"a.txt", "one\r\ntwo\r\n", "one\ntwo\n"},
+		{"a.raw", "one\r\ntwo\r\n", "one\r\ntwo\r\n"},
+		{"a.auto", "one\r\ntwo\r\n", "one\ntwo\n"},
+		{"a.auto", "bin\x00\r\n", "bin\x00\r\n"},
+		{"a.other", "one\r\n", "one\r\n"},
+	}
+	for _, tt := range tests {
+		got, err := c.Clean(tt.path, []byte(tt.in))
+		if err != nil {
+			t.Fatalf("Clean(%s) error = %v", tt.path, err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("Clean(%s, %q) = %q, want %q", tt.path, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSmudgeLineEndings(t *testing.T) {
+	c := newTestConverter(t, "*.bat text eol=crlf\n*.sh eol=lf\n", Options{EOL: "lf"})
+
+	tests := []struct {
+		path string
+		in   string
+		want string
+	}{
+		{"run.bat", "one\ntwo\n", "one\r\ntwo\r\n"},
+		{"run.bat", "one\r\ntwo\n", "one\r\ntwo\r\n"},
+		{"run.sh", "one\ntwo\n", "one\ntwo\n"},
+		{"notes.txt", "one\n", "one\n"},
+	}
+	for _, tt := range tests {
+		got, err := c.Smudge(tt.path, []byte(tt.in))
+		if err != nil {
+			t.Fatalf("Smudge(%s) error = %v", tt.path, err)
+		}
+		if string(got) != tt.want {
+			t.Errorf("Smudge(%s, %q) = %q, want %q", tt.path, tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestAutoCRLF(t *testing.T) {
+	c := newTestConverter(t, "", Options{AutoCRLF: "true"})
+	got, err := c.Clean("a.txt", []byte("one\r\n"))
+	if err != nil || string(got) != "one\n" {
+		t.Errorf("Clean() = %q, %v, want one\\n", got, err)
+	}
+	got, err = c.Smudge("a.txt", []byte("one\n"))
+	if err != nil || string(got) != "one\r\n" {
+		t.Errorf("Smudge() = %q, %v, want one\\r\\n", got, err)
+	}
+	got, err = c.Smudge("a.bin", []byte("one\x00\n"))
+	if err != nil || string(got) != "one\x00\n" {
+		t.Errorf("Smudge(binary) = %q, %v, want it unchanged", got, err)
+	}
+
+	c = newTestConverter(t, "", Options{AutoCRLF: "input"})
+	got, err = c.Smudge("a.txt", []byte("one\n"))
+	if err != nil || string(got) != "one\n" {
+		t.Errorf("Smudge() with autocrlf=input = %q, %v, want one\\n", got, err)
+	}
+}
+
+func TestFilterDriver(t *testing.T) {
+	var stderr bytes.Buffer
+	c := newTestConverter(t, "*.secret filter=rot\n*.broken filter=broken\n*.strict filter=strict\n", Options{
+		Drivers: map[string]Driver{
+			"rot":    {Clean: "tr a-z n-za-m", Smudge: "tr n-za-m a-z"},
+			"broken": {Clean: "exit 3"},
+			"strict": {Clean: "exit 3", Required: true},
+		},
+		Stderr: &stderr,
+	})
+
+	cleaned, err := c.Clean("key.secret", []byte("hello\n"))
+	if err != nil || string(cleaned) != "uryyb\n" {
+		t.Fatalf("Clean() = %q, %v, want uryyb", cleaned, err)
+	}
+	smudged, err := c.Smudge("key.secret", cleaned)
+	if err != nil || string(smudged) != "hello\n" {
+		t.Fatalf("Smudge() = %q, %v, want hello", smudged, err)
+	}
+
+	got, err := c.Clean("a.broken", []byte("data"))
+	if err != nil || string(got) != "data" {
+		t.Errorf("Clean() with a failing filter = %q, %v, want data passed through", got, err)
+	}
+	if !strings.Contains(stderr.String(), "clean filter 'broken' failed") {
+		t.Errorf("stderr = %q, want a warning", stderr.String())
+	}
+
+	if _, err := c.Clean("a.strict", []byte("data")); err == nil {
+		t.Error("Clean() with a failing required filter succeeded")
+	}
+}
+
+func TestFilterPathArgument(t *testing.T) {
+	c := newTestConverter(t, "* filter=name\n", Options{
+		Drivers: map[string]Driver{"name": {Clean: "printf '%%s' %f"}},
+	})
+	got, err := c.Clean("it's here.txt", nil)
+	if err != nil || string(got) != "it's here.txt" {
+		t.Errorf("Clean() = %q, %v, want the quoted path", got, err)
+	}
+}
+
+func TestDiffBinary(t *testing.T) {
+	c := newTestConverter(t, "*.dat binary\n*.svg diff\n", Options{})
+
+	if !c.DiffBinary("a.dat", []byte("text\n")) {
+		t.Error("DiffBinary(a.dat) = false, want true for binary")
+	}
+	if c.DiffBinary("a.svg", []byte("x\x00y")) {
+		t.Error("DiffBinary(a.svg) = true, want false when diff is set")
+	}
+	if !c.DiffBinary("a.o", []byte("x\x00y")) {
+		t.Error("DiffBinary(a.o) = false, want true for content with NUL")
+	}
+	if c.DiffBinary("a.txt", []byte("text\n")) {
+		t.Error("DiffBinary(a.txt) = true, want false for text")
+	}
+}
+
+func TestDiffDriver(t *testing.T) {
+	c := newTestConverter(t, "*.pdf diff=pdf\n*.bin diff=bin\n*.other diff=missing\n", Options{
+		DiffDrivers: map[string]DiffDriver{
+			"pdf": {Textconv: "tr a-z A-Z <", CacheTextconv: true},
+			"bin": {Binary: true},
+		},
+	})
+
+	name, driver, ok := c.DiffDriver("doc.pdf")
+	if !ok || name != "pdf" || !driver.CacheTextconv {
+		t.Errorf("DiffDriver(doc.pdf) = %q, %+v, %v", name, driver, ok)
+	}
+	if _, _, ok := c.DiffDriver("a.other"); ok {
+		t.Error("DiffDriver(a.other) found a driver that is not configured")
+	}
+
+	got, ok, err := c.Textconv("doc.pdf", []byte("hello\n"))
+	if err != nil || !ok || string(got) != "HELLO\n" {
+		t.Errorf("Textconv() = %q, %v, %v, want HELLO", got, ok, err)
+	}
+	if _, ok, err := c.Textconv("a.bin", []byte("x")); ok || err != nil {
+		t.Errorf("Textconv() without a textconv = %v, %v", ok, err)
+	}
+
+	// Content a textconv converts, or of a binary driver, is binary as it is
+	if !c.DiffBinary("doc.pdf", []byte("text\n")) || !c.DiffBinary("a.bin", []byte("text\n")) {
+		t.Error("DiffBinary() = false for a textconv or binary driver")
+	}
+	if c.DiffBinary("a.other", []byte("text\n")) {
+		t.Error("DiffBinary(a.other) = true for text")
+	}
+
+	failing := newTestConverter(t, "* diff=fail\n", Options{DiffDrivers: map[string]DiffDriver{"fail": {Textconv: "exit 1;"}}})
+	if _, _, err := failing.Textconv("a", nil); err == nil {
+		t.Error("Textconv() with a failing command succeeded")
+	}
+}
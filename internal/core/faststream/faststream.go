@@ -0,0 +1,195 @@
+// Package faststream reads and writes the stream format of git fast-import
+// and fast-export, which describes history as a sequence of commands that
+// create blobs, commits, tags and refs. Objects in a stream refer to each
+// other by marks, small integers the stream assigns, so a stream can be
+// produced by tools that know nothing of object IDs and replayed into any
+// repository.
+package faststream
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+// Command is one command of a stream
+type Command interface {
+	command() string
+}
+
+// Blob creates a blob
+type Blob struct {
+	Mark int
+	// OriginalOID is the ID the object had where it was exported from
+	OriginalOID string
+	Data        []byte
+}
+
+// FileOp is what a file command does
+type FileOp byte
+
+// File commands of a commit
+const (
+	FileModify    FileOp = 'M'
+	FileDelete    FileOp = 'D'
+	FileRename    FileOp = 'R'
+	FileCopy      FileOp = 'C'
+	FileDeleteAll FileOp = 'A'
+)
+
+// FileChange is a file command of a commit
+type FileChange struct {
+	Op FileOp
+	// Mode and DataRef are set for FileModify. DataRef is a mark such as
+	// ":3", an object ID, or "inline" with the content in Data.
+	Mode    objects.FileMode
+	DataRef string
+	Data    []byte
+	Path    string
+	// Source is the path FileRename and FileCopy read from
+	Source string
+}
+
+// Commit creates a commit on a branch, by default on top of the commit the
+// stream last made on it
+type Commit struct {
+	Ref         string
+	Mark        int
+	OriginalOID string
+	// Author and Committer are identities as Git writes them in commits:
+	// name, email in angle brackets, Unix time and zone. Author may be
+	// empty, meaning the committer.
+	Author    string
+	Committer string
+	Encoding  string
+	Message   []byte
+	// From names the first parent and Merge the others, as a mark, an
+	// object ID or a ref
+	From  string
+	Merge []string
+	Files []FileChange
+}
+
+// Tag creates an annotated tag
+type Tag struct {
+	Name        string
+	Mark        int
+	From        string
+	OriginalOID string
+	Tagger      string
+	Message     []byte
+}
+
+// Reset points a branch at a commit, or when From is empty makes its next
+// commit a root commit
+type Reset struct {
+	Ref  string
+	From string
+}
+
+// Progress asks the importer to echo a message once everything before it
+// is done
+type Progress struct {
+	Message string
+}
+
+// Checkpoint asks the importer to update refs and marks so far
+type Checkpoint struct{}
+
+// Done ends a stream
+type Done struct{}
+
+// Feature requires the importer to support a feature, such as done or
+// export-marks=<file>
+type Feature struct {
+	Name string
+	// Arg is what follows an equals sign, or empty
+	Arg string
+}
+
+// Option passes an option to the importer. Options start with the name of
+// the tool they are meant for and the others ignore them.
+type Option struct {
+	Value string
+}
+
+func (*Blob) command() string       { return "blob" }
+func (*Commit) command() string     { return "commit" }
+func (*Tag) command() string        { return "tag" }
+func (*Reset) command() string      { return "reset" }
+func (*Progress) command() string   { return "progress" }
+func (*Checkpoint) command() string { return "checkpoint" }
+func (*Done) command() string       { return "done" }
+func (*Feature) command() string    { return "feature" }
+func (*Option) command() string     { return "option" }
+
+// MarkRef returns the way a stream refers to mark
+func MarkRef(mark int) string {
+	return ":" + strconv.Itoa(mark)
+}
+
+// ParseMarkRef returns the mark ref names, and whether it names one
+func ParseMarkRef(ref string) (int, bool) {
+	n, ok := strings.CutPrefix(ref, ":")
+	if !ok {
+		return 0, false
+	}
+	mark, err := strconv.Atoi(n)
+	if err != nil || mark <= 0 {
+		return 0, false
+	}
+	return mark, true
+}
+
+// parseMark parses the argument of a mark command
+func parseMark(arg string) (int, error) {
+	mark, ok := ParseMarkRef(arg)
+	if !ok {
+		return 0, fmt.Errorf("invalid mark '%s'", arg)
+	}
+	return mark, nil
+}
+
+// ParseMode parses the mode of a file command, which may leave out the
+// leading digits Git writes
+func ParseMode(s string) (objects.FileMode, error) {
+	switch s {
+	case "100644", "644":
+		return objects.ModeBlob, nil
+	case "100755", "755":
+		return objects.ModeExec, nil
+	case "120000":
+		return objects.ModeSymlink, nil
+	case "160000":
+		return objects.ModeCommit, nil
+	case "040000", "40000":
+		return objects.ModeTree, nil
+	}
+	return 0, fmt.Errorf("invalid file mode '%s'", s)
+}
+
+// checkIdent checks an identity has the form Git writes in commits
+func checkIdent(ident string) error {
+	lt := strings.IndexByte(ident, '<')
+	gt := strings.LastIndexByte(ident, '>')
+	if lt < 0 || gt < lt {
+		return fmt.Errorf("missing email in identity '%s'", ident)
+	}
+	fields := strings.Fields(ident[gt+1:])
+	if len(fields) != 2 {
+		return fmt.Errorf("invalid date in identity '%s'", ident)
+	}
+	if _, err := strconv.ParseInt(fields[0], 10, 64); err != nil {
+		return fmt.Errorf("invalid date in identity '%s'", ident)
+	}
+	zone := fields[1]
+	if len(zone) != 5 || zone[0] != '+' && zone[0] != '-' {
+		return fmt.Errorf("invalid time zone in identity '%s'", ident)
+	}
+	if _, err := strconv.Atoi(zone[1:]); err != nil {
+		return fmt.Errorf("invalid time zone in identity '%s'", ident)
+	}
+	return nil
+}
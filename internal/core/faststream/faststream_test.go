@@ -0,0 +1,199 @@
+package faststream
+
+import (
+	"bytes"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+// readAll returns every command of stream
+func readAll(t *testing.T, stream string) []Command {
+	t.Helper()
+	r := NewReader(strings.NewReader(stream))
+	var cmds []Command
+	for {
+		cmd, err := r.Next()
+		if err == io.EOF {
+			return cmds
+		}
+		if err != nil {
+			t.Fatalf("Next() error = %v", err)
+		}
+		cmds = append(cmds, cmd)
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	ident := "A U Thor <author@example.com> 1700000000 +0100"
+	cmds := []Command{
+		&Feature{Name: "done"},
+		&Blob{Mark: 1, Data: []byte("hello\n")},
+		&Reset{Ref: "refs/heads/main"},
+		&Commit{
+			Ref:       "refs/heads/main",
+			Mark:      2,
+			Author:    ident,
+			Committer: ident,
+			Message:   []byte("first\n"),
+			Files: []FileChange{
+				{Op: FileModify, Mode: objects.ModeBlob, DataRef: ":1", Path: "a b.txt"},
+				{Op: FileModify, Mode: objects.ModeExec, DataRef: "inline", Data: []byte("#!/bin/sh\n"), Path: "run\tme"},
+			},
+		},
+		&Commit{
+			Ref:         "refs/heads/main",
+			Mark:        3,
+			OriginalOID: strings.Repeat("ab", 20),
+			Committer:   ident,
+			Encoding:    "ISO-8859-1",
+			Message:     []byte("no newline"),
+			From:        ":2",
+			Merge:       []string{":1"},
+			Files: []FileChange{
+				{Op: FileRename, Source: "a b.txt", Path: "c.txt"},
+				{Op: FileCopy, Source: "c.txt", Path: `"quoted"`},
+				{Op: FileDelete, Path: "run\tme"},
+				{Op: FileDeleteAll},
+			},
+		},
+		&Tag{Name: "v1.0", From: ":3", Tagger: ident, Message: []byte("release\n")},
+		&Reset{Ref: "refs/heads/other", From: ":2"},
+		&Progress{Message: "halfway"},
+		&Checkpoint{},
+		&Option{Value: "git quiet"},
+		&Done{},
+	}
+
+	var buf bytes.Buffer
+	w := NewWriter(&buf)
+	for _, cmd := range cmds {
+		if err := w.Write(cmd); err != nil {
+			t.Fatalf("Write(%T) error = %v", cmd, err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatalf("Flush() error = %v", err)
+	}
+	if !strings.Contains(buf.String(), `M 100755 inline "run\tme"`) {
+		t.Errorf("path with a tab is not quoted:\n%s", buf.String())
+	}
+	if !strings.Contains(buf.String(), `R "a b.txt" c.txt`) {
+		t.Errorf("rename source with a space is not quoted:\n%s", buf.String())
+	}
+
+	got := readAll(t, buf.String())
+	if !reflect.DeepEqual(got, cmds) {
+		t.Errorf("read back %d commands, want %d", len(got), len(cmds))
+		for i := range got {
+			if i < len(cmds) && !reflect.DeepEqual(got[i], cmds[i]) {
+				t.Errorf("command %d = %+v, want %+v", i, got[i], cmds[i])
+			}
+		}
+	}
+}
+
+func TestReaderGitSyntax(t *testing.T) {
+	// Comments, delimited data, short modes and empty lines as Git accepts
+	stream := `# exported by hand
+blob
+mark :1
+data <<EOF
+line one
+line two
+EOF
+
+commit refs/heads/main
+committer C O Mitter <c@example.com> 1700000000 -0500
+data 4
+msg
+M 644 :1 "dir/\303\251t\303\251.txt"
+M 160000 ` + strings.Repeat("1", 40) + ` sub
+
+tag v1
+from :2
+data 0
+`
+	cmds := readAll(t, stream)
+	if len(cmds) != 3 {
+		t.Fatalf("read %d commands, want 3", len(cmds))
+	}
+	blob := cmds[0].(*Blob)
+	if string(blob.Data) != "line one\nline two\n" || blob.Mark != 1 {
+		t.Errorf("blob = %+v", blob)
+	}
+	commit := cmds[1].(*Commit)
+	if string(commit.Message) != "msg\n" || commit.Author != "" {
+		t.Errorf("commit = %+v", commit)
+	}
+	want := []FileChange{
+		{Op: FileModify, Mode: objects.ModeBlob, DataRef: ":1", Path: "dir/été.txt"},
+		{Op: FileModify, Mode: objects.ModeCommit, DataRef: strings.Repeat("1", 40), Path: "sub"},
+	}
+	if !reflect.DeepEqual(commit.Files, want) {
+		t.Errorf("files = %+v, want %+v", commit.Files, want)
+	}
+	if tag := cmds[2].(*Tag); tag.Tagger != "" || tag.From != ":2" {
+		t.Errorf("tag = %+v", tag)
+	}
+}
+
+func TestReaderErrors(t *testing.T) {
+	tests := []struct {
+		stream string
+		err    string
+	}{
+		{"frobnicate\n", "unsupported command"},
+		{"blob\nmark 1\ndata 0\n", "invalid mark"},
+		{"blob\ndata 10\nshort", "shorter than 10 bytes"},
+		{"blob\ndata <<END\nno end\n", "missing delimiter"},
+		{"commit refs/heads/main\ndata 0\n", "expected committer"},
+		{"commit refs/heads/main\ncommitter nobody 1 +0000\ndata 0\n", "missing email"},
+		{"commit refs/heads/main\ncommitter a <b> soon +0000\ndata 0\n", "invalid date"},
+		{"commit refs/heads/main\ncommitter a <b> 1 +0000\ndata 0\nM 100600 :1 f\n", "invalid file mode"},
+		{"commit refs/heads/main\ncommitter a <b> 1 +0000\ndata 0\nR onlyone\n", "missing destination"},
+		{"tag v1\ndata 0\n", "expected from"},
+	}
+	for _, tt := range tests {
+		r := NewReader(strings.NewReader(tt.stream))
+		var err error
+		for err == nil {
+			_, err = r.Next()
+		}
+		if err == io.EOF || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("Next(%q) error = %v, want %q", tt.stream, err, tt.err)
+		}
+	}
+}
+
+func TestMarks(t *testing.T) {
+	marks := Marks{
+		10: objects.ComputeHash(objects.TypeBlob, []byte("a")),
+		2:  objects.ComputeHash(objects.TypeBlob, []byte("b")),
+	}
+	var buf bytes.Buffer
+	if err := WriteMarks(&buf, marks); err != nil {
+		t.Fatalf("WriteMarks() error = %v", err)
+	}
+	if !strings.HasPrefix(buf.String(), ":2 "+marks[2].String()+"\n") {
+		t.Errorf("WriteMarks() wrote %q, want mark 2 first", buf.String())
+	}
+
+	read, err := ReadMarks(&buf)
+	if err != nil {
+		t.Fatalf("ReadMarks() error = %v", err)
+	}
+	if !reflect.DeepEqual(read, marks) {
+		t.Errorf("ReadMarks() = %v, want %v", read, marks)
+	}
+	if read.Max() != 10 {
+		t.Errorf("Max() = %d, want 10", read.Max())
+	}
+
+	if _, err := ReadMarks(strings.NewReader("2 abc\n")); err == nil {
+		t.Errorf("ReadMarks() accepted a line without a colon")
+	}
+}
@@ -0,0 +1,67 @@
+package faststream
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+// Marks maps the marks of a stream to the objects they stand for
+type Marks map[int]objects.ObjectID
+
+// ReadMarks reads a marks file, which has a line ":<mark> <id>" for each
+// mark, as written by --export-marks
+func ReadMarks(r io.Reader) (Marks, error) {
+	marks := make(Marks)
+	scanner := bufio.NewScanner(r)
+	for n := 1; scanner.Scan(); n++ {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		ref, hex, ok := strings.Cut(line, " ")
+		mark, isMark := ParseMarkRef(ref)
+		if !ok || !isMark {
+			return nil, fmt.Errorf("line %d: invalid mark line: %s", n, line)
+		}
+		id, err := objects.NewObjectID(hex)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: invalid object ID: %s", n, hex)
+		}
+		marks[mark] = id
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return marks, nil
+}
+
+// WriteMarks writes marks in the form ReadMarks reads, in mark order
+func WriteMarks(w io.Writer, marks Marks) error {
+	list := make([]int, 0, len(marks))
+	for mark := range marks {
+		list = append(list, mark)
+	}
+	sort.Ints(list)
+
+	bw := bufio.NewWriter(w)
+	for _, mark := range list {
+		fmt.Fprintf(bw, "%s %s\n", MarkRef(mark), marks[mark])
+	}
+	return bw.Flush()
+}
+
+// Max returns the highest mark in use, or 0
+func (m Marks) Max() int {
+	max := 0
+	for mark := range m {
+		if mark > max {
+			max = mark
+		}
+	}
+	return max
+}
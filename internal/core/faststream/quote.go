@@ -0,0 +1,144 @@
+package faststream
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// needsQuote reports whether path must be quoted in a file command
+func needsQuote(path string) bool {
+	if strings.HasPrefix(path, `"`) {
+		return true
+	}
+	for i := 0; i < len(path); i++ {
+		if c := path[i]; c < 0x20 || c == 0x7f || c == '\\' {
+			return true
+		}
+	}
+	return false
+}
+
+// quotePath quotes path the way Git quotes paths in C style
+func quotePath(path string) string {
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(path); i++ {
+		switch c := path[i]; c {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\a':
+			b.WriteString(`\a`)
+		case '\b':
+			b.WriteString(`\b`)
+		case '\f':
+			b.WriteString(`\f`)
+		case '\n':
+			b.WriteString(`\n`)
+		case '\r':
+			b.WriteString(`\r`)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\v':
+			b.WriteString(`\v`)
+		default:
+			if c < 0x20 || c == 0x7f {
+				fmt.Fprintf(&b, `\%03o`, c)
+			} else {
+				b.WriteByte(c)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// unquotePath reads a C-style quoted path from the start of s and returns
+// it with the rest of s
+func unquotePath(s string) (string, string, error) {
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		c := s[i]
+		if c == '"' {
+			return b.String(), s[i+1:], nil
+		}
+		if c != '\\' {
+			b.WriteByte(c)
+			continue
+		}
+		if i++; i == len(s) {
+			break
+		}
+		switch c = s[i]; c {
+		case 'a':
+			b.WriteByte('\a')
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'v':
+			b.WriteByte('\v')
+		case '"', '\\':
+			b.WriteByte(c)
+		case '0', '1', '2', '3':
+			if i+3 > len(s) {
+				return "", "", fmt.Errorf("invalid escape in path %s", s)
+			}
+			n, err := strconv.ParseUint(s[i:i+3], 8, 8)
+			if err != nil {
+				return "", "", fmt.Errorf("invalid escape in path %s", s)
+			}
+			b.WriteByte(byte(n))
+			i += 2
+		default:
+			return "", "", fmt.Errorf("invalid escape in path %s", s)
+		}
+	}
+	return "", "", fmt.Errorf("unterminated path %s", s)
+}
+
+// parsePath parses a path that runs to the end of the line
+func parsePath(s string) (string, error) {
+	if !strings.HasPrefix(s, `"`) {
+		return s, nil
+	}
+	path, rest, err := unquotePath(s)
+	if err != nil {
+		return "", err
+	}
+	if rest != "" {
+		return "", fmt.Errorf("garbage after path: %s", rest)
+	}
+	return path, nil
+}
+
+// parsePathPair parses the source and destination of a rename or copy;
+// an unquoted source ends at the first space
+func parsePathPair(s string) (string, string, error) {
+	var source, rest string
+	if strings.HasPrefix(s, `"`) {
+		var err error
+		if source, rest, err = unquotePath(s); err != nil {
+			return "", "", err
+		}
+	} else {
+		var ok bool
+		if source, rest, ok = strings.Cut(s, " "); !ok {
+			return "", "", fmt.Errorf("missing destination path: %s", s)
+		}
+		rest = " " + rest
+	}
+	rest, ok := strings.CutPrefix(rest, " ")
+	if !ok || rest == "" {
+		return "", "", fmt.Errorf("missing destination path: %s", s)
+	}
+	dest, err := parsePath(rest)
+	return source, dest, err
+}
@@ -0,0 +1,352 @@
+package faststream
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// Reader parses the commands of a stream one at a time, so streams of any
+// size can be imported without holding them in memory
+type Reader struct {
+	r *bufio.Reader
+	// line is a line read ahead that is yet to be parsed
+	line    string
+	pending bool
+	lineNum int
+}
+
+// NewReader returns a Reader reading the stream r
+func NewReader(r io.Reader) *Reader {
+	return &Reader{r: bufio.NewReaderSize(r, 64*1024)}
+}
+
+// readLine returns the next line without its LF, skipping comments. It
+// returns io.EOF at the end of the stream.
+func (r *Reader) readLine() (string, error) {
+	if r.pending {
+		r.pending = false
+		return r.line, nil
+	}
+	for {
+		line, err := r.r.ReadString('\n')
+		if err == io.EOF && line == "" {
+			return "", io.EOF
+		}
+		if err != nil && err != io.EOF {
+			return "", err
+		}
+		r.lineNum++
+		line = strings.TrimSuffix(line, "\n")
+		if strings.HasPrefix(line, "#") {
+			continue
+		}
+		r.line = line
+		return line, nil
+	}
+}
+
+// unreadLine makes the line last read the next one read
+func (r *Reader) unreadLine() {
+	r.pending = true
+}
+
+func (r *Reader) errorf(format string, args ...any) error {
+	return fmt.Errorf("line %d: %s", r.lineNum, fmt.Sprintf(format, args...))
+}
+
+// Next returns the next command of the stream, or io.EOF at its end
+func (r *Reader) Next() (Command, error) {
+	for {
+		line, err := r.readLine()
+		if err != nil {
+			return nil, err
+		}
+		// Commands may be followed by an empty line
+		if line == "" {
+			continue
+		}
+
+		name, arg, _ := strings.Cut(line, " ")
+		switch name {
+		case "blob":
+			return r.readBlob()
+		case "commit":
+			return r.readCommit(arg)
+		case "tag":
+			return r.readTag(arg)
+		case "reset":
+			return r.readReset(arg)
+		case "progress":
+			return &Progress{Message: arg}, nil
+		case "checkpoint":
+			return &Checkpoint{}, nil
+		case "done":
+			return &Done{}, nil
+		case "feature":
+			feature, value, _ := strings.Cut(arg, "=")
+			return &Feature{Name: feature, Arg: value}, nil
+		case "option":
+			return &Option{Value: arg}, nil
+		}
+		return nil, r.errorf("unsupported command: %s", line)
+	}
+}
+
+// readOptional returns the argument of the next line if it is the command
+// name, leaving any other line to be read again
+func (r *Reader) readOptional(name string) (string, bool, error) {
+	line, err := r.readLine()
+	if err == io.EOF {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	if arg, ok := strings.CutPrefix(line, name+" "); ok {
+		return arg, true, nil
+	}
+	r.unreadLine()
+	return "", false, nil
+}
+
+// readMark reads an optional mark command
+func (r *Reader) readMark() (int, error) {
+	arg, ok, err := r.readOptional("mark")
+	if err != nil || !ok {
+		return 0, err
+	}
+	mark, err := parseMark(arg)
+	if err != nil {
+		return 0, r.errorf("%v", err)
+	}
+	return mark, nil
+}
+
+// readData reads a data command, in either its counted or its delimited
+// form
+func (r *Reader) readData() ([]byte, error) {
+	line, err := r.readLine()
+	if err == io.EOF {
+		return nil, r.errorf("expected data, got end of stream")
+	}
+	if err != nil {
+		return nil, err
+	}
+	arg, ok := strings.CutPrefix(line, "data ")
+	if !ok {
+		return nil, r.errorf("expected data, got: %s", line)
+	}
+
+	if delim, ok := strings.CutPrefix(arg, "<<"); ok {
+		if delim == "" {
+			return nil, r.errorf("missing delimiter in: %s", line)
+		}
+		var data []byte
+		for {
+			l, err := r.r.ReadString('\n')
+			if err != nil {
+				return nil, r.errorf("missing delimiter %s before end of stream", delim)
+			}
+			r.lineNum++
+			if l == delim+"\n" {
+				return data, nil
+			}
+			data = append(data, l...)
+		}
+	}
+
+	n, err := strconv.ParseInt(arg, 10, 64)
+	if err != nil || n < 0 {
+		return nil, r.errorf("invalid data length: %s", arg)
+	}
+	data := make([]byte, n)
+	if _, err := io.ReadFull(r.r, data); err != nil {
+		return nil, r.errorf("data is shorter than %d bytes", n)
+	}
+	r.lineNum += strings.Count(string(data), "\n")
+	// The data may be followed by an LF that is not part of it
+	if b, err := r.r.Peek(1); err == nil && b[0] == '\n' {
+		r.r.ReadByte()
+		r.lineNum++
+	}
+	return data, nil
+}
+
+func (r *Reader) readBlob() (*Blob, error) {
+	blob := &Blob{}
+	var err error
+	if blob.Mark, err = r.readMark(); err != nil {
+		return nil, err
+	}
+	if blob.OriginalOID, _, err = r.readOptional("original-oid"); err != nil {
+		return nil, err
+	}
+	if blob.Data, err = r.readData(); err != nil {
+		return nil, err
+	}
+	return blob, nil
+}
+
+// readIdent reads an optional identity command such as author
+func (r *Reader) readIdent(name string) (string, error) {
+	ident, ok, err := r.readOptional(name)
+	if err != nil || !ok {
+		return "", err
+	}
+	if err := checkIdent(ident); err != nil {
+		return "", r.errorf("%v", err)
+	}
+	return ident, nil
+}
+
+func (r *Reader) readCommit(ref string) (*Commit, error) {
+	if ref == "" {
+		return nil, r.errorf("missing ref in commit")
+	}
+	commit := &Commit{Ref: ref}
+	var err error
+	if commit.Mark, err = r.readMark(); err != nil {
+		return nil, err
+	}
+	if commit.OriginalOID, _, err = r.readOptional("original-oid"); err != nil {
+		return nil, err
+	}
+	if commit.Author, err = r.readIdent("author"); err != nil {
+		return nil, err
+	}
+	if commit.Committer, err = r.readIdent("committer"); err != nil {
+		return nil, err
+	}
+	if commit.Committer == "" {
+		return nil, r.errorf("expected committer in commit %s", ref)
+	}
+	if commit.Encoding, _, err = r.readOptional("encoding"); err != nil {
+		return nil, err
+	}
+	if commit.Message, err = r.readData(); err != nil {
+		return nil, err
+	}
+	if commit.From, _, err = r.readOptional("from"); err != nil {
+		return nil, err
+	}
+	for {
+		merge, ok, err := r.readOptional("merge")
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		commit.Merge = append(commit.Merge, merge)
+	}
+
+	for {
+		change, ok, err := r.readFileChange()
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			break
+		}
+		commit.Files = append(commit.Files, *change)
+	}
+	return commit, nil
+}
+
+// readFileChange reads a file command, and reports false at the first line
+// that is not one, which ends the commit
+func (r *Reader) readFileChange() (*FileChange, bool, error) {
+	line, err := r.readLine()
+	if err == io.EOF {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if line == "deleteall" {
+		return &FileChange{Op: FileDeleteAll}, true, nil
+	}
+	if len(line) < 2 || line[1] != ' ' {
+		if line != "" {
+			r.unreadLine()
+		}
+		return nil, false, nil
+	}
+
+	change := &FileChange{Op: FileOp(line[0])}
+	arg := line[2:]
+	switch change.Op {
+	case FileModify:
+		fields := strings.SplitN(arg, " ", 3)
+		if len(fields) != 3 {
+			return nil, false, r.errorf("invalid file command: %s", line)
+		}
+		if change.Mode, err = ParseMode(fields[0]); err != nil {
+			return nil, false, r.errorf("%v", err)
+		}
+		change.DataRef = fields[1]
+		if change.Path, err = parsePath(fields[2]); err != nil {
+			return nil, false, r.errorf("%v", err)
+		}
+		if change.DataRef == "inline" {
+			if change.Data, err = r.readData(); err != nil {
+				return nil, false, err
+			}
+		}
+	case FileDelete:
+		if change.Path, err = parsePath(arg); err != nil {
+			return nil, false, r.errorf("%v", err)
+		}
+	case FileRename, FileCopy:
+		if change.Source, change.Path, err = parsePathPair(arg); err != nil {
+			return nil, false, r.errorf("%v", err)
+		}
+	default:
+		r.unreadLine()
+		return nil, false, nil
+	}
+	return change, true, nil
+}
+
+func (r *Reader) readTag(name string) (*Tag, error) {
+	if name == "" {
+		return nil, r.errorf("missing name in tag")
+	}
+	tag := &Tag{Name: name}
+	var err error
+	if tag.Mark, err = r.readMark(); err != nil {
+		return nil, err
+	}
+	var ok bool
+	if tag.From, ok, err = r.readOptional("from"); err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, r.errorf("expected from in tag %s", name)
+	}
+	if tag.OriginalOID, _, err = r.readOptional("original-oid"); err != nil {
+		return nil, err
+	}
+	if tag.Tagger, err = r.readIdent("tagger"); err != nil {
+		return nil, err
+	}
+	if tag.Message, err = r.readData(); err != nil {
+		return nil, err
+	}
+	return tag, nil
+}
+
+func (r *Reader) readReset(ref string) (*Reset, error) {
+	if ref == "" {
+		return nil, r.errorf("missing ref in reset")
+	}
+	reset := &Reset{Ref: ref}
+	var err error
+	if reset.From, _, err = r.readOptional("from"); err != nil {
+		return nil, err
+	}
+	return reset, nil
+}
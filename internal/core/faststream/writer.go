@@ -0,0 +1,133 @@
+package faststream
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Writer writes commands in the form git fast-import reads
+type Writer struct {
+	w *bufio.Writer
+}
+
+// NewWriter returns a Writer writing to w. Flush must be called when done.
+func NewWriter(w io.Writer) *Writer {
+	return &Writer{w: bufio.NewWriterSize(w, 64*1024)}
+}
+
+// Flush writes any buffered commands
+func (w *Writer) Flush() error {
+	return w.w.Flush()
+}
+
+// Write writes one command
+func (w *Writer) Write(cmd Command) error {
+	switch cmd := cmd.(type) {
+	case *Blob:
+		w.line("blob")
+		w.mark(cmd.Mark)
+		w.optional("original-oid", cmd.OriginalOID)
+		w.data(cmd.Data)
+	case *Commit:
+		w.line("commit " + cmd.Ref)
+		w.mark(cmd.Mark)
+		w.optional("original-oid", cmd.OriginalOID)
+		w.optional("author", cmd.Author)
+		w.line("committer " + cmd.Committer)
+		w.optional("encoding", cmd.Encoding)
+		w.data(cmd.Message)
+		w.optional("from", cmd.From)
+		for _, merge := range cmd.Merge {
+			w.line("merge " + merge)
+		}
+		for _, change := range cmd.Files {
+			if err := w.fileChange(change); err != nil {
+				return err
+			}
+		}
+		w.line("")
+	case *Tag:
+		w.line("tag " + cmd.Name)
+		w.mark(cmd.Mark)
+		w.line("from " + cmd.From)
+		w.optional("original-oid", cmd.OriginalOID)
+		w.optional("tagger", cmd.Tagger)
+		w.data(cmd.Message)
+	case *Reset:
+		w.line("reset " + cmd.Ref)
+		if cmd.From != "" {
+			w.line("from " + cmd.From)
+			w.line("")
+		}
+	case *Progress:
+		w.line("progress " + cmd.Message)
+	case *Checkpoint:
+		w.line("checkpoint")
+	case *Done:
+		w.line("done")
+	case *Feature:
+		if cmd.Arg != "" {
+			w.line("feature " + cmd.Name + "=" + cmd.Arg)
+		} else {
+			w.line("feature " + cmd.Name)
+		}
+	case *Option:
+		w.line("option " + cmd.Value)
+	default:
+		return fmt.Errorf("unknown command %T", cmd)
+	}
+	return nil
+}
+
+func (w *Writer) line(s string) {
+	w.w.WriteString(s)
+	w.w.WriteByte('\n')
+}
+
+func (w *Writer) optional(name, arg string) {
+	if arg != "" {
+		w.line(name + " " + arg)
+	}
+}
+
+func (w *Writer) mark(mark int) {
+	if mark > 0 {
+		w.line("mark " + MarkRef(mark))
+	}
+}
+
+func (w *Writer) data(data []byte) {
+	fmt.Fprintf(w.w, "data %d\n", len(data))
+	w.w.Write(data)
+	w.w.WriteByte('\n')
+}
+
+// path returns p as a file command writes it; sources of renames and
+// copies are also quoted when they hold a space
+func path(p string, source bool) string {
+	if needsQuote(p) || source && strings.Contains(p, " ") {
+		return quotePath(p)
+	}
+	return p
+}
+
+func (w *Writer) fileChange(change FileChange) error {
+	switch change.Op {
+	case FileModify:
+		w.line(fmt.Sprintf("M %06o %s %s", uint32(change.Mode), change.DataRef, path(change.Path, false)))
+		if change.DataRef == "inline" {
+			w.data(change.Data)
+		}
+	case FileDelete:
+		w.line("D " + path(change.Path, false))
+	case FileRename, FileCopy:
+		w.line(fmt.Sprintf("%c %s %s", change.Op, path(change.Source, true), path(change.Path, false)))
+	case FileDeleteAll:
+		w.line("deleteall")
+	default:
+		return fmt.Errorf("unknown file command %q", change.Op)
+	}
+	return nil
+}
@@ -0,0 +1,261 @@
+// Package fault injects failures into the writes, fsyncs and renames the
+// storage layer uses to update objects, refs and the index, to check that an
+// operation interrupted at any point leaves the repository consistent.
+//
+// Injection is off unless the VCS_FAULT_INJECT environment variable holds a
+// spec of comma-separated settings:
+//
+//	rate=0.05   fail each operation with this probability
+//	seed=42     seed the random choice, for reproducible runs
+//	nth=7       fail only the seventh operation
+//	ops=write+rename
+//	            only count and fail these operations: write, sync, rename
+//	crash       exit the process at the failure instead of returning an error
+//
+// A failed write first writes half of its data, as a write torn by a crash
+// would.
+package fault
+
+import (
+	"fmt"
+	"io"
+	"math/rand"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// EnvVar is the environment variable holding the injection spec
+const EnvVar = "VCS_FAULT_INJECT"
+
+// CrashExitCode is the exit status of a process crashed by an injected
+// fault, that of a process killed by SIGKILL
+const CrashExitCode = 137
+
+// Op is a kind of file operation faults are injected into
+type Op string
+
+const (
+	OpWrite  Op = "write"
+	OpSync   Op = "sync"
+	OpRename Op = "rename"
+)
+
+// Config says which operations fail
+type Config struct {
+	// Rate is the probability that each operation fails
+	Rate float64
+	// Seed seeds the random choice made with Rate
+	Seed int64
+	// Nth, when positive, fails only the nth operation, counting from 1
+	Nth int
+	// Ops are the operations counted and failed; all when empty
+	Ops []Op
+	// Crash exits the process with CrashExitCode at the failure
+	Crash bool
+}
+
+// Error is the error returned by an injected failure
+type Error struct {
+	Op   Op
+	Path string
+}
+
+func (e *Error) Error() string {
+	return fmt.Sprintf("injected %s failure: %s", e.Op, e.Path)
+}
+
+// Parse parses an injection spec
+func Parse(spec string) (Config, error) {
+	cfg := Config{Seed: time.Now().UnixNano()}
+	for _, field := range strings.Split(spec, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		key, value, _ := strings.Cut(field, "=")
+		var err error
+		switch key {
+		case "rate":
+			cfg.Rate, err = strconv.ParseFloat(value, 64)
+			if err == nil && (cfg.Rate < 0 || cfg.Rate > 1) {
+				err = fmt.Errorf("not between 0 and 1")
+			}
+		case "seed":
+			cfg.Seed, err = strconv.ParseInt(value, 10, 64)
+		case "nth":
+			cfg.Nth, err = strconv.Atoi(value)
+			if err == nil && cfg.Nth < 1 {
+				err = fmt.Errorf("not positive")
+			}
+		case "ops":
+			for _, op := range strings.Split(value, "+") {
+				switch Op(op) {
+				case OpWrite, OpSync, OpRename:
+					cfg.Ops = append(cfg.Ops, Op(op))
+				default:
+					err = fmt.Errorf("unknown operation %q", op)
+				}
+			}
+		case "crash":
+			cfg.Crash = true
+		default:
+			return Config{}, fmt.Errorf("unknown fault setting %q", key)
+		}
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid fault setting %q: %w", field, err)
+		}
+	}
+	if cfg.Rate == 0 && cfg.Nth == 0 {
+		return Config{}, fmt.Errorf("fault spec %q sets neither rate nor nth", spec)
+	}
+	return cfg, nil
+}
+
+// injector decides which operations fail
+type injector struct {
+	cfg   Config
+	rng   *rand.Rand
+	count int
+}
+
+var (
+	mu       sync.Mutex
+	active   *injector
+	loadOnce sync.Once
+)
+
+// Enable turns injection on with cfg, replacing any spec from the
+// environment
+func Enable(cfg Config) {
+	loadOnce.Do(func() {})
+	mu.Lock()
+	defer mu.Unlock()
+	active = &injector{cfg: cfg, rng: rand.New(rand.NewSource(cfg.Seed))}
+}
+
+// Disable turns injection off
+func Disable() {
+	loadOnce.Do(func() {})
+	mu.Lock()
+	defer mu.Unlock()
+	active = nil
+}
+
+// Count returns how many operations have been counted since injection was
+// enabled
+func Count() int {
+	mu.Lock()
+	defer mu.Unlock()
+	if active == nil {
+		return 0
+	}
+	return active.count
+}
+
+// loadEnv enables injection from EnvVar, once. An invalid spec is reported
+// and ignored.
+func loadEnv() {
+	loadOnce.Do(func() {
+		spec := os.Getenv(EnvVar)
+		if spec == "" {
+			return
+		}
+		cfg, err := Parse(spec)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: ignoring %s: %v\n", EnvVar, err)
+			return
+		}
+		active = &injector{cfg: cfg, rng: rand.New(rand.NewSource(cfg.Seed))}
+	})
+}
+
+// inject reports whether op should fail
+func inject(op Op) bool {
+	loadEnv()
+	mu.Lock()
+	defer mu.Unlock()
+	if active == nil {
+		return false
+	}
+	if len(active.cfg.Ops) > 0 {
+		counted := false
+		for _, o := range active.cfg.Ops {
+			counted = counted || o == op
+		}
+		if !counted {
+			return false
+		}
+	}
+	active.count++
+	if active.cfg.Nth > 0 {
+		return active.count == active.cfg.Nth
+	}
+	return active.rng.Float64() < active.cfg.Rate
+}
+
+// fail crashes the process or returns the error of a failed op. Either is
+// reported on stderr, so a caller can tell the fault was reached even when
+// the error is not passed on.
+func fail(op Op, path string) error {
+	mu.Lock()
+	crash := active != nil && active.cfg.Crash
+	mu.Unlock()
+	if crash {
+		fmt.Fprintf(os.Stderr, "fault: crashing at %s of %s\n", op, path)
+		os.Exit(CrashExitCode)
+	}
+	fmt.Fprintf(os.Stderr, "fault: failing %s of %s\n", op, path)
+	return &Error{Op: op, Path: path}
+}
+
+// writer injects failures into the writes to a file
+type writer struct {
+	w    io.Writer
+	path string
+}
+
+// NewWriter returns a writer to w, the file at path, each of whose writes
+// may fail
+func NewWriter(w io.Writer, path string) io.Writer {
+	return &writer{w: w, path: path}
+}
+
+func (fw *writer) Write(p []byte) (int, error) {
+	if !inject(OpWrite) {
+		return fw.w.Write(p)
+	}
+	n, _ := fw.w.Write(p[:len(p)/2])
+	return n, fail(OpWrite, fw.path)
+}
+
+// WriteFile is os.WriteFile with a write that may fail
+func WriteFile(path string, data []byte, perm os.FileMode) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, perm)
+	if err != nil {
+		return err
+	}
+	_, err = NewWriter(f, path).Write(data)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Sync is f.Sync that may fail
+func Sync(f *os.File) error {
+	if inject(OpSync) {
+		return fail(OpSync, f.Name())
+	}
+	return f.Sync()
+}
+
+// Rename is os.Rename that may fail, leaving both paths as they were
+func Rename(oldPath, newPath string) error {
+	if inject(OpRename) {
+		return fail(OpRename, newPath)
+	}
+	return os.Rename(oldPath, newPath)
+}
@@ -0,0 +1,90 @@
+package fault
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	cfg, err := Parse("nth=3,ops=write+rename,crash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Nth != 3 || !cfg.Crash || len(cfg.Ops) != 2 || cfg.Ops[0] != OpWrite || cfg.Ops[1] != OpRename {
+		t.Errorf("Parse() = %+v", cfg)
+	}
+
+	cfg, err = Parse("rate=0.25,seed=7")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Rate != 0.25 || cfg.Seed != 7 {
+		t.Errorf("Parse() = %+v", cfg)
+	}
+
+	for _, spec := range []string{"", "crash", "rate=2", "nth=0", "ops=chmod,nth=1", "when=now"} {
+		if _, err := Parse(spec); err == nil {
+			t.Errorf("Parse(%q) succeeded", spec)
+		}
+	}
+}
+
+func TestNthWriteIsTorn(t *testing.T) {
+	Enable(Config{Nth: 2})
+	defer Disable()
+
+	dir := t.TempDir()
+	first := filepath.Join(dir, "first")
+	if err := WriteFile(first, []byte("complete"), 0644); err != nil {
+		t.Fatalf("first write failed: %v", err)
+	}
+
+	second := filepath.Join(dir, "second")
+	err := WriteFile(second, []byte("complete"), 0644)
+	var injected *Error
+	if !errors.As(err, &injected) || injected.Op != OpWrite || injected.Path != second {
+		t.Fatalf("second write returned %v", err)
+	}
+	if data, _ := os.ReadFile(second); string(data) != "comp" {
+		t.Errorf("torn write left %q", data)
+	}
+
+	if err := WriteFile(filepath.Join(dir, "third"), []byte("complete"), 0644); err != nil {
+		t.Errorf("third write failed: %v", err)
+	}
+	if Count() != 3 {
+		t.Errorf("Count() = %d, want 3", Count())
+	}
+}
+
+func TestOpsFilter(t *testing.T) {
+	Enable(Config{Nth: 1, Ops: []Op{OpRename}})
+	defer Disable()
+
+	dir := t.TempDir()
+	path := filepath.Join(dir, "file")
+	if err := WriteFile(path, []byte("data"), 0644); err != nil {
+		t.Fatalf("write is not counted but failed: %v", err)
+	}
+	if err := Rename(path, path+".new"); err == nil {
+		t.Fatal("first rename did not fail")
+	}
+	if _, err := os.Stat(path); err != nil {
+		t.Errorf("failed rename moved the file: %v", err)
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	Disable()
+	path := filepath.Join(t.TempDir(), "file")
+	for i := 0; i < 10; i++ {
+		if err := WriteFile(path, []byte("data"), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if Count() != 0 {
+		t.Errorf("Count() = %d while disabled", Count())
+	}
+}
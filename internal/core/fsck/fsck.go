@@ -0,0 +1,391 @@
+// Package fsck checks the content of objects for the problems Git's fsck
+// looks for: headers missing or out of order, malformed identities and
+// object names, and trees that are unsorted, hold duplicate or dangerous
+// names or use unknown file modes. Each problem has a message ID, named as
+// Git names them, so tools can act on specific problems.
+package fsck
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+// Severity is how serious a problem is
+type Severity int
+
+const (
+	// Warning is a problem Git tolerates, such as a tree entry named .git
+	Warning Severity = iota
+	// Error is an object Git would refuse to write
+	Error
+)
+
+func (s Severity) String() string {
+	if s == Error {
+		return "error"
+	}
+	return "warning"
+}
+
+// Problem is something wrong with an object
+type Problem struct {
+	// ID names the kind of problem, as badTimezone or treeNotSorted
+	ID       string
+	Severity Severity
+	Message  string
+}
+
+func (p Problem) String() string {
+	return fmt.Sprintf("%s: %s", p.ID, p.Message)
+}
+
+// Options control how strict the checks are
+type Options struct {
+	// Strict reports group-writable file modes, which old versions of Git
+	// wrote, and turns every warning into an error
+	Strict bool
+}
+
+// Check returns the problems with an object of objType holding data
+func Check(objType objects.ObjectType, data []byte, opts Options) []Problem {
+	c := &checker{opts: opts}
+	switch objType {
+	case objects.TypeCommit:
+		c.commit(data)
+	case objects.TypeTag:
+		c.tag(data)
+	case objects.TypeTree:
+		c.tree(data)
+	case objects.TypeBlob:
+	default:
+		c.report(Error, "badObjectType", "unknown object type %q", objType)
+	}
+	return c.problems
+}
+
+// checker collects the problems of one object
+type checker struct {
+	opts     Options
+	problems []Problem
+}
+
+func (c *checker) report(severity Severity, id, format string, args ...interface{}) {
+	if c.opts.Strict {
+		severity = Error
+	}
+	c.problems = append(c.problems, Problem{ID: id, Severity: severity, Message: fmt.Sprintf(format, args...)})
+}
+
+// headers splits the header lines of a commit or tag from its message,
+// reporting NUL bytes and a missing end of the headers
+func (c *checker) headers(data []byte) ([]string, bool) {
+	end := bytes.Index(data, []byte("\n\n"))
+	head := data
+	if end >= 0 {
+		head = data[:end+1]
+	} else if !bytes.HasSuffix(data, []byte("\n")) {
+		c.report(Error, "unterminatedHeader", "unterminated header")
+		return nil, false
+	}
+	if bytes.IndexByte(head, 0) >= 0 {
+		c.report(Error, "nulInHeader", "NUL at offset %d", bytes.IndexByte(head, 0))
+		return nil, false
+	}
+	return strings.Split(strings.TrimSuffix(string(head), "\n"), "\n"), true
+}
+
+// commit checks that a commit has a tree, parents, an author and a
+// committer, in that order
+func (c *checker) commit(data []byte) {
+	lines, ok := c.headers(data)
+	if !ok {
+		return
+	}
+
+	i := 0
+	next := func(key string) (string, bool) {
+		if i < len(lines) && strings.HasPrefix(lines[i], key+" ") {
+			i++
+			return lines[i-1][len(key)+1:], true
+		}
+		return "", false
+	}
+
+	tree, ok := next("tree")
+	if !ok {
+		c.report(Error, "missingTree", "invalid format - expected 'tree' line")
+		return
+	}
+	if !validObjectName(tree) {
+		c.report(Error, "badTreeSha1", "invalid 'tree' line format - bad sha1")
+		return
+	}
+	for {
+		parent, ok := next("parent")
+		if !ok {
+			break
+		}
+		if !validObjectName(parent) {
+			c.report(Error, "badParentSha1", "invalid 'parent' line format - bad sha1")
+			return
+		}
+	}
+
+	author, ok := next("author")
+	if !ok {
+		c.report(Error, "missingAuthor", "invalid format - expected 'author' line")
+		return
+	}
+	if !c.ident(author) {
+		return
+	}
+	for {
+		if _, ok := next("author"); !ok {
+			break
+		}
+		c.report(Error, "multipleAuthors", "invalid format - multiple 'author' lines")
+	}
+
+	committer, ok := next("committer")
+	if !ok {
+		c.report(Error, "missingCommitter", "invalid format - expected 'committer' line")
+		return
+	}
+	c.ident(committer)
+}
+
+// tag checks that a tag names its object, the object's type, the tag name
+// and the tagger, in that order
+func (c *checker) tag(data []byte) {
+	lines, ok := c.headers(data)
+	if !ok {
+		return
+	}
+
+	i := 0
+	next := func(key string) (string, bool) {
+		if i < len(lines) && strings.HasPrefix(lines[i], key+" ") {
+			i++
+			return lines[i-1][len(key)+1:], true
+		}
+		return "", false
+	}
+
+	object, ok := next("object")
+	if !ok {
+		c.report(Error, "missingObject", "invalid format - expected 'object' line")
+		return
+	}
+	if !validObjectName(object) {
+		c.report(Error, "badObjectSha1", "invalid 'object' line format - bad sha1")
+		return
+	}
+
+	typ, ok := next("type")
+	if !ok {
+		c.report(Error, "missingTypeEntry", "invalid format - expected 'type' line")
+		return
+	}
+	if !objects.ObjectType(typ).IsValid() {
+		c.report(Error, "badType", "invalid 'type' value")
+		return
+	}
+
+	name, ok := next("tag")
+	if !ok {
+		c.report(Error, "missingTagEntry", "invalid format - expected 'tag' line")
+		return
+	}
+	if !validRefName("refs/tags/" + name) {
+		c.report(Warning, "badTagName", "invalid 'tag' name: %s", name)
+	}
+
+	tagger, ok := next("tagger")
+	if !ok {
+		c.report(Warning, "missingTaggerEntry", "invalid format - expected 'tagger' line")
+		return
+	}
+	c.ident(tagger)
+}
+
+// ident checks an identity of the form "Name <email> 1234567890 +0000"
+// and reports whether it is well formed
+func (c *checker) ident(s string) bool {
+	fail := func(id, message string) bool {
+		c.report(Error, id, "invalid author/committer line - %s", message)
+		return false
+	}
+
+	if strings.HasPrefix(s, "<") {
+		return fail("missingNameBeforeEmail", "missing space before email")
+	}
+	lt := strings.IndexAny(s, "<>")
+	switch {
+	case lt < 0:
+		return fail("missingEmail", "missing email")
+	case s[lt] == '>':
+		return fail("badName", "bad name")
+	case s[lt-1] != ' ':
+		return fail("missingSpaceBeforeEmail", "missing space before email")
+	}
+	s = s[lt+1:]
+	gt := strings.IndexAny(s, "<>")
+	if gt < 0 || s[gt] != '>' {
+		return fail("badEmail", "bad email")
+	}
+	s = s[gt+1:]
+	if !strings.HasPrefix(s, " ") {
+		return fail("missingSpaceBeforeDate", "missing space before date")
+	}
+	s = s[1:]
+
+	date, zone, ok := strings.Cut(s, " ")
+	if len(date) > 1 && date[0] == '0' {
+		return fail("zeroPaddedDate", "zero-padded date")
+	}
+	if _, err := strconv.ParseUint(date, 10, 64); err != nil || !ok {
+		if numErr, isNum := err.(*strconv.NumError); isNum && numErr.Err == strconv.ErrRange {
+			return fail("badDateOverflow", "date causes integer overflow")
+		}
+		return fail("badDate", "bad date")
+	}
+	if len(zone) != 5 || zone[0] != '+' && zone[0] != '-' || !digits(zone[1:]) {
+		return fail("badTimezone", "bad time zone")
+	}
+	return true
+}
+
+// treeEntry is a tree entry with its mode as written
+type treeEntry struct {
+	mode string
+	name string
+	id   objects.ObjectID
+}
+
+// tree checks the entries of a tree: their modes and names, and that
+// they are sorted as Git sorts them, with no name twice
+func (c *checker) tree(data []byte) {
+	var entries []treeEntry
+	for len(data) > 0 {
+		space := bytes.IndexByte(data, ' ')
+		nul := bytes.IndexByte(data, 0)
+		if space <= 0 || nul < space || len(data) < nul+1+20 {
+			c.report(Error, "badTree", "cannot be parsed as a tree")
+			return
+		}
+		entry := treeEntry{mode: string(data[:space]), name: string(data[space+1 : nul])}
+		copy(entry.id[:], data[nul+1:])
+		entries = append(entries, entry)
+		data = data[nul+1+20:]
+	}
+
+	seen := make(map[string]bool)
+	names := make(map[string]bool, len(entries))
+	for i, entry := range entries {
+		c.treeEntry(entry, seen)
+		// A file and a directory of one name need not be adjacent
+		if names[entry.name] {
+			c.once(seen, Error, "duplicateEntries", "contains duplicate file entries")
+		}
+		names[entry.name] = true
+		if i > 0 && treeOrder(entries[i-1]) > treeOrder(entry) {
+			c.once(seen, Error, "treeNotSorted", "not properly sorted")
+		}
+	}
+}
+
+// treeEntry checks the mode and name of one tree entry, reporting each
+// kind of problem once per tree
+func (c *checker) treeEntry(entry treeEntry, seen map[string]bool) {
+	switch entry.mode {
+	case "100644", "100755", "120000", "40000", "160000":
+	case "100664":
+		if c.opts.Strict {
+			c.once(seen, Warning, "badFilemode", "contains bad file modes")
+		}
+	default:
+		if strings.HasPrefix(entry.mode, "0") {
+			c.once(seen, Warning, "zeroPaddedFilemode", "contains zero-padded file modes")
+		} else {
+			c.once(seen, Warning, "badFilemode", "contains bad file modes")
+		}
+	}
+
+	switch {
+	case entry.name == "":
+		c.once(seen, Warning, "emptyName", "contains empty pathname")
+	case strings.Contains(entry.name, "/"):
+		c.once(seen, Warning, "fullPathname", "contains full pathnames")
+	case entry.name == ".":
+		c.once(seen, Warning, "hasDot", "contains '.'")
+	case entry.name == "..":
+		c.once(seen, Warning, "hasDotdot", "contains '..'")
+	case strings.EqualFold(entry.name, ".git"):
+		c.once(seen, Warning, "hasDotgit", "contains '.git'")
+	}
+	if entry.id.IsZero() {
+		c.once(seen, Warning, "nullSha1", "contains entries pointing to null sha1")
+	}
+}
+
+// once reports a problem of a tree unless seen records it as reported
+func (c *checker) once(seen map[string]bool, severity Severity, id, message string) {
+	if !seen[id] {
+		seen[id] = true
+		c.report(severity, id, "%s", message)
+	}
+}
+
+// treeOrder returns the name tree entries are sorted by: their name, with
+// a slash after the names of trees
+func treeOrder(entry treeEntry) string {
+	if strings.TrimLeft(entry.mode, "0") == "40000" {
+		return entry.name + "/"
+	}
+	return entry.name
+}
+
+// validObjectName reports whether s is a full lowercase hex object name
+func validObjectName(s string) bool {
+	return len(s) == 40 && isHex(s)
+}
+
+func isHex(s string) bool {
+	for _, r := range s {
+		if !('0' <= r && r <= '9' || 'a' <= r && r <= 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func digits(s string) bool {
+	for _, r := range s {
+		if r < '0' || r > '9' {
+			return false
+		}
+	}
+	return s != ""
+}
+
+// validRefName reports whether name is a well formed ref name
+func validRefName(name string) bool {
+	if strings.HasSuffix(name, "/") || strings.HasSuffix(name, ".") || strings.Contains(name, "..") || strings.Contains(name, "@{") {
+		return false
+	}
+	for _, component := range strings.Split(name, "/") {
+		if component == "" || strings.HasPrefix(component, ".") || strings.HasSuffix(component, ".lock") {
+			return false
+		}
+	}
+	for _, r := range name {
+		if r < 0x20 || r == 0x7f || strings.ContainsRune(" ~^:?*[\\", r) {
+			return false
+		}
+	}
+	return true
+}
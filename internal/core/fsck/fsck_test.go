@@ -0,0 +1,121 @@
+package fsck
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+const (
+	treeID   = "4b825dc642cb6eb9a060e54bf8d69288fbee4904"
+	parentID = "3c4e9cd789d88d8d89c1073707c3585e41b0e614"
+	ident    = "A U Thor <author@example.com> 1700000000 +0100"
+)
+
+// problemIDs returns the IDs of the problems Check finds, with the
+// severity of each as a prefix
+func problemIDs(objType objects.ObjectType, data string, opts Options) []string {
+	var ids []string
+	for _, p := range Check(objType, []byte(data), opts) {
+		ids = append(ids, p.Severity.String()+" "+p.ID)
+	}
+	return ids
+}
+
+func TestCheckCommit(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		want string
+	}{
+		{"valid", "tree " + treeID + "\nparent " + parentID + "\nauthor " + ident + "\ncommitter " + ident + "\n\nmessage\n", ""},
+		{"no message", "tree " + treeID + "\nauthor " + ident + "\ncommitter " + ident + "\n", ""},
+		{"missing tree", "author " + ident + "\ncommitter " + ident + "\n\nm\n", "error missingTree"},
+		{"bad tree", "tree 123\nauthor " + ident + "\ncommitter " + ident + "\n\nm\n", "error badTreeSha1"},
+		{"bad parent", "tree " + treeID + "\nparent " + strings.ToUpper(parentID) + "\nauthor " + ident + "\ncommitter " + ident + "\n\nm\n", "error badParentSha1"},
+		{"missing author", "tree " + treeID + "\ncommitter " + ident + "\n\nm\n", "error missingAuthor"},
+		{"two authors", "tree " + treeID + "\nauthor " + ident + "\nauthor " + ident + "\ncommitter " + ident + "\n\nm\n", "error multipleAuthors"},
+		{"missing committer", "tree " + treeID + "\nauthor " + ident + "\n\nm\n", "error missingCommitter"},
+		{"missing email", "tree " + treeID + "\nauthor A U Thor 1700000000 +0100\ncommitter " + ident + "\n\nm\n", "error missingEmail"},
+		{"bad timezone", "tree " + treeID + "\nauthor " + ident + "\ncommitter A <a@b> 1700000000 0100\n\nm\n", "error badTimezone"},
+		{"zero-padded date", "tree " + treeID + "\nauthor A <a@b> 01700000000 +0100\ncommitter " + ident + "\n\nm\n", "error zeroPaddedDate"},
+		{"NUL in header", "tree " + treeID + "\nauthor A\x00 <a@b> 1 +0100\ncommitter " + ident + "\n\nm\n", "error nulInHeader"},
+		{"unterminated", "tree " + treeID, "error unterminatedHeader"},
+	}
+	for _, tt := range tests {
+		got := strings.Join(problemIDs(objects.TypeCommit, tt.data, Options{}), ", ")
+		if got != tt.want {
+			t.Errorf("%s: Check() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestCheckTag(t *testing.T) {
+	valid := "object " + parentID + "\ntype commit\ntag v1.0\ntagger " + ident + "\n\nrelease\n"
+	if got := problemIDs(objects.TypeTag, valid, Options{}); len(got) != 0 {
+		t.Errorf("Check() of a valid tag = %v", got)
+	}
+
+	tests := []struct {
+		data string
+		want string
+	}{
+		{"type commit\ntag v1\ntagger " + ident + "\n\nm\n", "error missingObject"},
+		{"object " + parentID + "\ntype thing\ntag v1\ntagger " + ident + "\n\nm\n", "error badType"},
+		{"object " + parentID + "\ntype commit\ntagger " + ident + "\n\nm\n", "error missingTagEntry"},
+		{"object " + parentID + "\ntype commit\ntag v1..2\ntagger " + ident + "\n\nm\n", "warning badTagName"},
+		{"object " + parentID + "\ntype commit\ntag v1\n\nm\n", "warning missingTaggerEntry"},
+	}
+	for _, tt := range tests {
+		got := strings.Join(problemIDs(objects.TypeTag, tt.data, Options{}), ", ")
+		if got != tt.want {
+			t.Errorf("Check(%q) = %q, want %q", tt.data, got, tt.want)
+		}
+	}
+
+	// Strict makes warnings errors
+	got := problemIDs(objects.TypeTag, "object "+parentID+"\ntype commit\ntag v1\n\nm\n", Options{Strict: true})
+	if strings.Join(got, ", ") != "error missingTaggerEntry" {
+		t.Errorf("Check() with Strict = %v", got)
+	}
+}
+
+// tree serializes entries given as mode and name pairs
+func tree(entries ...string) string {
+	var b strings.Builder
+	for i := 0; i < len(entries); i += 2 {
+		b.WriteString(entries[i] + " " + entries[i+1] + "\x00")
+		b.WriteString(strings.Repeat("\x01", 20))
+	}
+	return b.String()
+}
+
+func TestCheckTree(t *testing.T) {
+	tests := []struct {
+		name string
+		data string
+		opts Options
+		want string
+	}{
+		{"valid", tree("100644", "a", "40000", "a.d", "100755", "b.sh", "40000", "b", "120000", "link", "160000", "sub"), Options{}, ""},
+		// "a-" sorts before the tree "a", compared as "a/"
+		{"tree order", tree("100644", "a-", "40000", "a"), Options{}, ""},
+		{"not sorted", tree("100644", "b", "100644", "a"), Options{}, "error treeNotSorted"},
+		{"duplicate", tree("100644", "a", "100644", "a.txt", "40000", "a"), Options{}, "error duplicateEntries"},
+		{"bad mode", tree("100600", "a"), Options{}, "warning badFilemode"},
+		{"zero-padded", tree("040000", "a"), Options{}, "warning zeroPaddedFilemode"},
+		{"group-writable", tree("100664", "a"), Options{}, ""},
+		{"group-writable strict", tree("100664", "a"), Options{Strict: true}, "error badFilemode"},
+		{"dot git", tree("40000", ".GIT"), Options{}, "warning hasDotgit"},
+		{"dot dot", tree("40000", ".."), Options{}, "warning hasDotdot"},
+		{"full path", tree("100644", "a/b"), Options{}, "warning fullPathname"},
+		{"truncated", tree("100644", "a")[:10], Options{}, "error badTree"},
+	}
+	for _, tt := range tests {
+		got := strings.Join(problemIDs(objects.TypeTree, tt.data, tt.opts), ", ")
+		if got != tt.want {
+			t.Errorf("%s: Check() = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,141 @@
+// Package fsmonitor asks a filesystem monitor which paths of a working tree
+// changed since a previous query, so that status need not look at the
+// others. The monitor is a hook speaking Git's fsmonitor protocol, such as
+// the one Git ships for watchman: version 2 is given the token of the
+// previous query and prints a new token followed by the changed paths,
+// version 1 is given a time in nanoseconds and prints the paths changed
+// since then.
+package fsmonitor
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Hook protocol versions. VersionAny tries version 2, then version 1.
+const (
+	VersionAny = 0
+	Version1   = 1
+	Version2   = 2
+)
+
+// Changes are the paths a monitor reported changed since a token
+type Changes struct {
+	// Token identifies this query, to be passed to the next one
+	Token string
+	// All is set when the monitor could not tell what changed, so every
+	// path must be checked
+	All bool
+	// changed holds the paths reported, without a trailing slash, and dirs
+	// the directories holding them
+	changed map[string]bool
+	dirs    map[string]bool
+}
+
+// Query runs hook in workTree to find the paths changed since token, which
+// is "" on a first query. version selects the protocol.
+func Query(hook, workTree, token string, version int) (*Changes, error) {
+	if version != VersionAny && version != Version1 && version != Version2 {
+		return nil, fmt.Errorf("unsupported fsmonitor hook version %d", version)
+	}
+
+	if version != Version1 {
+		out, err := run(hook, workTree, Version2, token)
+		if err == nil {
+			newToken, rest, ok := bytes.Cut(out, []byte{0})
+			if !ok || len(newToken) == 0 {
+				return nil, fmt.Errorf("fsmonitor hook %s returned no token", hook)
+			}
+			changes := parse(rest)
+			changes.Token = string(newToken)
+			if token == "" {
+				changes.All = true
+			}
+			return changes, nil
+		}
+		if version == Version2 {
+			return nil, err
+		}
+	}
+
+	// Version 1 tokens are times; a version 2 token cannot be used
+	now := strconv.FormatInt(time.Now().UnixNano(), 10)
+	if _, err := strconv.ParseInt(token, 10, 64); err != nil {
+		return &Changes{Token: now, All: true}, nil
+	}
+	out, err := run(hook, workTree, Version1, token)
+	if err != nil {
+		return nil, err
+	}
+	changes := parse(out)
+	changes.Token = now
+	return changes, nil
+}
+
+// run runs hook with the version and token as arguments and returns what it
+// printed
+func run(hook, workTree string, version int, token string) ([]byte, error) {
+	cmd := exec.Command("sh", "-c", hook+` "$@"`, hook, strconv.Itoa(version), token)
+	cmd.Dir = workTree
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("fsmonitor hook %s failed: %w", hook, err)
+	}
+	return out.Bytes(), nil
+}
+
+// parse reads the NUL separated paths a hook printed. A path of "/" means
+// everything may have changed.
+func parse(data []byte) *Changes {
+	changes := &Changes{changed: make(map[string]bool), dirs: make(map[string]bool)}
+	for _, field := range bytes.Split(data, []byte{0}) {
+		if len(field) == 0 {
+			continue
+		}
+		p := strings.TrimSuffix(string(field), "/")
+		if p == "" {
+			changes.All = true
+			continue
+		}
+		changes.changed[p] = true
+		changes.dirs[parent(p)] = true
+	}
+	return changes
+}
+
+// FileChanged reports whether the file at path may have changed
+func (c *Changes) FileChanged(p string) bool {
+	if c.All {
+		return true
+	}
+	for {
+		if c.changed[p] {
+			return true
+		}
+		if p == "" {
+			return false
+		}
+		p = parent(p)
+	}
+}
+
+// DirChanged reports whether the listing of the directory at dir, "" for
+// the top of the working tree, may have changed
+func (c *Changes) DirChanged(dir string) bool {
+	return c.All || c.dirs[dir] || c.FileChanged(dir)
+}
+
+// parent returns the directory holding p, "" at the top of the tree
+func parent(p string) string {
+	dir := path.Dir(p)
+	if dir == "." {
+		return ""
+	}
+	return dir
+}
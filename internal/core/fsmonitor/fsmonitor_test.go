@@ -0,0 +1,92 @@
+package fsmonitor
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// writeHook writes an executable hook running script
+func writeHook(t *testing.T, dir, script string) string {
+	t.Helper()
+	hook := filepath.Join(dir, "hook")
+	if err := os.WriteFile(hook, []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return hook
+}
+
+func TestQueryVersion2(t *testing.T) {
+	dir := t.TempDir()
+	hook := writeHook(t, dir, `echo "$@" > args
+printf 'token-2\0src/main.go\0docs/\0'
+`)
+
+	changes, err := Query(hook, dir, "token-1", VersionAny)
+	if err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	if args, _ := os.ReadFile(filepath.Join(dir, "args")); string(args) != "2 token-1\n" {
+		t.Errorf("hook arguments = %q", args)
+	}
+	if changes.Token != "token-2" || changes.All {
+		t.Errorf("Query() = %+v", changes)
+	}
+
+	for path, want := range map[string]bool{
+		"src/main.go":    true,
+		"src/other.go":   false,
+		"docs/guide.md":  true,
+		"docs/a/b/c.md":  true,
+		"README":         false,
+		"docsplus/x.txt": false,
+	} {
+		if got := changes.FileChanged(path); got != want {
+			t.Errorf("FileChanged(%s) = %v, want %v", path, got, want)
+		}
+	}
+	for dir, want := range map[string]bool{"src": true, "": true, "docs/a": true, "lib": false} {
+		if got := changes.DirChanged(dir); got != want {
+			t.Errorf("DirChanged(%q) = %v, want %v", dir, got, want)
+		}
+	}
+
+	// Without a previous token everything must be checked
+	if changes, err := Query(hook, dir, "", VersionAny); err != nil || !changes.All {
+		t.Errorf("Query() without a token = %+v, %v", changes, err)
+	}
+}
+
+func TestQueryVersion1(t *testing.T) {
+	dir := t.TempDir()
+	hook := writeHook(t, dir, `[ "$1" = 1 ] || exit 1
+printf 'a.txt\0'
+`)
+
+	// A token from version 2 cannot be used, so everything changed
+	changes, err := Query(hook, dir, "token-1", VersionAny)
+	if err != nil || !changes.All {
+		t.Fatalf("Query() = %+v, %v; want everything changed", changes, err)
+	}
+
+	changes, err = Query(hook, dir, changes.Token, VersionAny)
+	if err != nil {
+		t.Fatalf("Query() error = %v", err)
+	}
+	if changes.All || !changes.FileChanged("a.txt") || changes.FileChanged("b.txt") {
+		t.Errorf("Query() = %+v", changes)
+	}
+
+	if _, err := Query(hook, dir, "token-1", Version2); err == nil {
+		t.Errorf("Query() with version 2 of a version 1 hook should fail")
+	}
+}
+
+func TestQueryEverything(t *testing.T) {
+	dir := t.TempDir()
+	hook := writeHook(t, dir, `printf 'token\0/\0'`)
+	changes, err := Query(hook, dir, "old", Version2)
+	if err != nil || !changes.All || !changes.FileChanged("any") {
+		t.Errorf("Query() = %+v, %v; want everything changed", changes, err)
+	}
+}
@@ -0,0 +1,78 @@
+// Package gitdir locates the files of a repository directory, which for a
+// linked worktree is split between the worktree's own directory (HEAD, the
+// index and other per-worktree state) and the common directory of the main
+// repository (objects, refs and config).
+package gitdir
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// CommonDir returns the directory holding the objects, refs and config of
+// the repository at gitDir. For a linked worktree it is named by the
+// commondir file, relative to gitDir; otherwise it is gitDir itself.
+func CommonDir(gitDir string) string {
+	data, err := os.ReadFile(filepath.Join(gitDir, "commondir"))
+	if err != nil {
+		return gitDir
+	}
+	dir := strings.TrimSpace(string(data))
+	if dir == "" {
+		return gitDir
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(gitDir, dir)
+	}
+	return filepath.Clean(dir)
+}
+
+// IsPerWorktreeRef reports whether refName is kept in each worktree's own
+// directory rather than shared: HEAD and the other pseudo-refs, and refs
+// under refs/worktree/, refs/bisect/ and refs/rewritten/
+func IsPerWorktreeRef(refName string) bool {
+	if !strings.Contains(refName, "/") {
+		return true
+	}
+	for _, prefix := range []string{"refs/worktree/", "refs/bisect/", "refs/rewritten/"} {
+		if strings.HasPrefix(refName, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadGitFile returns the repository directory named by a .git file, which
+// a linked worktree has in place of a .git directory. A relative path is
+// relative to the directory holding the file.
+func ReadGitFile(path string) (string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	line := strings.TrimSpace(string(data))
+	dir, ok := strings.CutPrefix(line, "gitdir:")
+	if !ok {
+		return "", fmt.Errorf("invalid gitfile format: %s", path)
+	}
+	dir = strings.TrimSpace(dir)
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(filepath.Dir(path), dir)
+	}
+	return filepath.Clean(dir), nil
+}
+
+// Resolve returns the repository directory of a working tree's .git entry,
+// which is either the directory itself or a .git file pointing to it
+func Resolve(dotGit string) (string, error) {
+	info, err := os.Stat(dotGit)
+	if err != nil {
+		return "", err
+	}
+	if info.IsDir() {
+		return dotGit, nil
+	}
+	return ReadGitFile(dotGit)
+}
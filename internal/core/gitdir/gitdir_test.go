@@ -0,0 +1,76 @@
+package gitdir
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCommonDir(t *testing.T) {
+	main := t.TempDir()
+	if got := CommonDir(main); got != main {
+		t.Errorf("CommonDir() = %q, want %q", got, main)
+	}
+
+	linked := filepath.Join(main, "worktrees", "wt")
+	if err := os.MkdirAll(linked, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(linked, "commondir"), []byte("../..\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got := CommonDir(linked); got != main {
+		t.Errorf("CommonDir() = %q, want %q", got, main)
+	}
+}
+
+func TestIsPerWorktreeRef(t *testing.T) {
+	tests := map[string]bool{
+		"HEAD":                true,
+		"ORIG_HEAD":           true,
+		"refs/worktree/x":     true,
+		"refs/bisect/bad":     true,
+		"refs/heads/main":     false,
+		"refs/tags/v1":        false,
+		"refs/remotes/o/main": false,
+		"refs/rewritten/onto": true,
+	}
+	for name, want := range tests {
+		if got := IsPerWorktreeRef(name); got != want {
+			t.Errorf("IsPerWorktreeRef(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
+
+func TestResolve(t *testing.T) {
+	dir := t.TempDir()
+	dotGit := filepath.Join(dir, ".git")
+	if err := os.Mkdir(dotGit, 0755); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := Resolve(dotGit); err != nil || got != dotGit {
+		t.Errorf("Resolve(dir) = %q, %v", got, err)
+	}
+
+	wt := t.TempDir()
+	if err := os.WriteFile(filepath.Join(wt, ".git"), []byte("gitdir: "+filepath.Join(dotGit, "worktrees", "wt")+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := Resolve(filepath.Join(wt, ".git")); err != nil || got != filepath.Join(dotGit, "worktrees", "wt") {
+		t.Errorf("Resolve(file) = %q, %v", got, err)
+	}
+
+	if err := os.WriteFile(filepath.Join(wt, "rel"), []byte("gitdir: ../x/.git"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if got, err := ReadGitFile(filepath.Join(wt, "rel")); err != nil || got != filepath.Join(filepath.Dir(wt), "x", ".git") {
+		t.Errorf("ReadGitFile(relative) = %q, %v", got, err)
+	}
+
+	if err := os.WriteFile(filepath.Join(wt, "bad"), []byte("nonsense"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadGitFile(filepath.Join(wt, "bad")); err == nil {
+		t.Error("ReadGitFile() accepted a file without gitdir:")
+	}
+}
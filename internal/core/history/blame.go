@@ -0,0 +1,129 @@
+package history
+
+import (
+	"fmt"
+
+	"github.com/fenilsonani/vcs/internal/core/merge"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+// BlameLine is a line of a file together with the commit that introduced it
+type BlameLine struct {
+	Commit objects.ObjectID
+	// Path and Number locate the line in that commit, where the file may
+	// have had another name
+	Path   string
+	Number int
+	Text   string
+	// Boundary is set when history ends before the line's origin, at a
+	// root or shallow commit, so the commit may not have written it
+	Boundary bool
+}
+
+// BlameOptions controls how Blame walks history
+type BlameOptions struct {
+	// RenameThreshold is the similarity used to follow renames, or zero to
+	// stop at them
+	RenameThreshold int
+	// Boundary reports commits whose parents must not be visited, such as
+	// shallow commits
+	Boundary func(objects.ObjectID) bool
+}
+
+// pendingLine is a line not yet attributed, with its index in the file as
+// of the commit being examined
+type pendingLine struct {
+	final, current int
+}
+
+// Blame attributes each line of the file at p in commit start to the commit
+// that last changed it, walking first parents
+func Blame(store Store, start objects.ObjectID, p string, opts BlameOptions) ([]BlameLine, error) {
+	commit, err := ReadCommit(store, start)
+	if err != nil {
+		return nil, err
+	}
+	file, err := lookupFile(store, commit.Tree(), p)
+	if err != nil {
+		return nil, err
+	}
+	if file == nil {
+		return nil, fmt.Errorf("no such path %s in %s", p, start.Short())
+	}
+	content, err := ReadBlob(store, file.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	lines := splitLines(content)
+	result := make([]BlameLine, len(lines))
+	pending := make([]pendingLine, len(lines))
+	for i, line := range lines {
+		result[i].Text = line
+		pending[i] = pendingLine{final: i, current: i}
+	}
+
+	id := start
+	attribute := func(line pendingLine, boundary bool) {
+		result[line.final].Commit = id
+		result[line.final].Path = p
+		result[line.final].Number = line.current + 1
+		result[line.final].Boundary = boundary
+	}
+
+	for len(pending) > 0 {
+		parents := commit.Parents()
+		if len(parents) == 0 || (opts.Boundary != nil && opts.Boundary(id)) {
+			for _, line := range pending {
+				attribute(line, true)
+			}
+			break
+		}
+
+		parent, err := ReadCommit(store, parents[0])
+		if err != nil {
+			return nil, err
+		}
+		change, err := FileChange(store, parent.Tree(), commit.Tree(), p, opts.RenameThreshold)
+		if err != nil {
+			return nil, err
+		}
+
+		if change != nil {
+			if change.Type == Added {
+				for _, line := range pending {
+					attribute(line, false)
+				}
+				break
+			}
+
+			parentContent, err := ReadBlob(store, change.OldID)
+			if err != nil {
+				return nil, err
+			}
+			parentLines := splitLines(parentContent)
+
+			// Lines the parent already had pass the blame on to it
+			origin := make(map[int]int, len(lines))
+			for _, m := range merge.MatchLines(parentLines, lines) {
+				origin[m.B] = m.A
+			}
+
+			var remaining []pendingLine
+			for _, line := range pending {
+				if index, ok := origin[line.current]; ok {
+					remaining = append(remaining, pendingLine{final: line.final, current: index})
+				} else {
+					attribute(line, false)
+				}
+			}
+			pending = remaining
+			lines = parentLines
+			p = change.OldPath
+		}
+
+		id, commit = parents[0], parent
+	}
+
+	return result, nil
+}
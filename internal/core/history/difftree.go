@@ -0,0 +1,121 @@
+package history
+
+import (
+	"path"
+	"sort"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+// DiffTrees returns the entries that differ between oldTree and newTree,
+// in tree order. Either tree may be zero, meaning empty. When recursive is
+// set, changed subtrees are descended into and only files are reported;
+// otherwise a changed subtree is reported as a single modified entry. An
+// entry that changes between a tree and a file is reported as deleted and
+// added.
+func DiffTrees(store Store, oldTree, newTree objects.ObjectID, recursive bool) ([]Change, error) {
+	var changes []Change
+	if err := diffTrees(store, oldTree, newTree, "", recursive, &changes); err != nil {
+		return nil, err
+	}
+	sort.SliceStable(changes, func(i, j int) bool {
+		return sortKey(changes[i]) < sortKey(changes[j])
+	})
+	return changes, nil
+}
+
+// sortKey orders a subtree as its name followed by a slash, as trees do
+func sortKey(change Change) string {
+	if change.OldMode == objects.ModeTree || change.NewMode == objects.ModeTree {
+		return change.Path + "/"
+	}
+	return change.Path
+}
+
+func diffTrees(store Store, oldTree, newTree objects.ObjectID, prefix string, recursive bool, changes *[]Change) error {
+	if oldTree == newTree {
+		return nil
+	}
+	oldEntries, err := treeEntries(store, oldTree)
+	if err != nil {
+		return err
+	}
+	newEntries, err := treeEntries(store, newTree)
+	if err != nil {
+		return err
+	}
+
+	names := make([]string, 0, len(oldEntries)+len(newEntries))
+	for name := range oldEntries {
+		names = append(names, name)
+	}
+	for name := range newEntries {
+		if _, ok := oldEntries[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		p := path.Join(prefix, name)
+		oldEntry, inOld := oldEntries[name]
+		newEntry, inNew := newEntries[name]
+
+		if inOld && inNew {
+			if oldEntry.Mode == newEntry.Mode && oldEntry.ID == newEntry.ID {
+				continue
+			}
+			oldIsTree, newIsTree := oldEntry.Mode == objects.ModeTree, newEntry.Mode == objects.ModeTree
+			if oldIsTree == newIsTree {
+				if oldIsTree && recursive {
+					if err := diffTrees(store, oldEntry.ID, newEntry.ID, p, recursive, changes); err != nil {
+						return err
+					}
+					continue
+				}
+				change := Change{Type: Modified, Path: p, OldPath: p, OldMode: oldEntry.Mode, NewMode: newEntry.Mode, OldID: oldEntry.ID, NewID: newEntry.ID}
+				if (oldEntry.Mode == objects.ModeSymlink) != (newEntry.Mode == objects.ModeSymlink) {
+					change.Type = TypeChanged
+				}
+				*changes = append(*changes, change)
+				continue
+			}
+		}
+
+		if inOld {
+			if oldEntry.Mode == objects.ModeTree && recursive {
+				if err := diffTrees(store, oldEntry.ID, objects.ObjectID{}, p, recursive, changes); err != nil {
+					return err
+				}
+			} else {
+				*changes = append(*changes, Change{Type: Deleted, Path: p, OldPath: p, OldMode: oldEntry.Mode, OldID: oldEntry.ID})
+			}
+		}
+		if inNew {
+			if newEntry.Mode == objects.ModeTree && recursive {
+				if err := diffTrees(store, objects.ObjectID{}, newEntry.ID, p, recursive, changes); err != nil {
+					return err
+				}
+			} else {
+				*changes = append(*changes, Change{Type: Added, Path: p, NewMode: newEntry.Mode, NewID: newEntry.ID})
+			}
+		}
+	}
+	return nil
+}
+
+// treeEntries returns the entries of a tree by name. A zero tree has none.
+func treeEntries(store Store, id objects.ObjectID) (map[string]objects.TreeEntry, error) {
+	entries := make(map[string]objects.TreeEntry)
+	if id.IsZero() {
+		return entries, nil
+	}
+	tree, err := readTree(store, id)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range tree.Entries() {
+		entries[entry.Name] = entry
+	}
+	return entries, nil
+}
@@ -0,0 +1,271 @@
+// Package history follows files through commit history: which commits
+// changed a file, where it was renamed from, and which commit each of its
+// lines comes from.
+package history
+
+import (
+	"fmt"
+	"path"
+	"strings"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+// Store reads the objects history is built from
+type Store interface {
+	ReadObject(id objects.ObjectID) (objects.Object, error)
+}
+
+// ChangeType is what a commit did to a file
+type ChangeType int
+
+const (
+	// Added means the file did not exist before
+	Added ChangeType = iota
+	// Modified means the content or mode of the file changed
+	Modified
+	// Deleted means the file no longer exists
+	Deleted
+	// Renamed means the file was moved, possibly with changes
+	Renamed
+	// TypeChanged means the file became a symlink or a symlink a file
+	TypeChanged
+	// Copied means the file was added as a copy of another, possibly with
+	// changes
+	Copied
+)
+
+// String returns the status letter Git uses for the change
+func (t ChangeType) String() string {
+	switch t {
+	case Added:
+		return "A"
+	case Modified:
+		return "M"
+	case Deleted:
+		return "D"
+	case Renamed:
+		return "R"
+	case TypeChanged:
+		return "T"
+	case Copied:
+		return "C"
+	default:
+		return "?"
+	}
+}
+
+// Change is what happened to a file between two trees
+type Change struct {
+	Type ChangeType
+	// Path is the file's path in the new tree, and OldPath in the old one.
+	// They only differ for renames and copies.
+	Path    string
+	OldPath string
+	OldMode objects.FileMode
+	NewMode objects.FileMode
+	OldID   objects.ObjectID
+	NewID   objects.ObjectID
+	// Score is the similarity of a renamed or copied file to its source
+	// in percent
+	Score int
+}
+
+// FileChange reports how the file at p changed from oldTree to newTree, or
+// nil when it did not. Either tree may be zero, meaning empty. When
+// threshold is positive, a file added in newTree is compared with the files
+// deleted from oldTree, and reported as renamed from the most similar one
+// scoring at least threshold.
+func FileChange(store Store, oldTree, newTree objects.ObjectID, p string, threshold int) (*Change, error) {
+	oldEntry, err := lookupFile(store, oldTree, p)
+	if err != nil {
+		return nil, err
+	}
+	newEntry, err := lookupFile(store, newTree, p)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case oldEntry == nil && newEntry == nil:
+		return nil, nil
+	case oldEntry != nil && newEntry != nil:
+		if oldEntry.ID == newEntry.ID && oldEntry.Mode == newEntry.Mode {
+			return nil, nil
+		}
+		return &Change{Type: Modified, Path: p, OldPath: p, OldMode: oldEntry.Mode, NewMode: newEntry.Mode, OldID: oldEntry.ID, NewID: newEntry.ID}, nil
+	case newEntry == nil:
+		return &Change{Type: Deleted, Path: p, OldPath: p, OldMode: oldEntry.Mode, OldID: oldEntry.ID}, nil
+	}
+
+	change := &Change{Type: Added, Path: p, NewMode: newEntry.Mode, NewID: newEntry.ID}
+	if threshold <= 0 || oldTree.IsZero() {
+		return change, nil
+	}
+
+	source, err := findRenameSource(store, oldTree, newTree, p, newEntry.ID, threshold)
+	if err != nil || source == nil {
+		return change, err
+	}
+	change.Type = Renamed
+	change.OldPath = source.From.Path
+	change.OldID = source.From.ID
+	change.Score = source.Score
+	return change, nil
+}
+
+// findRenameSource looks among the files deleted between oldTree and newTree
+// for the one the file added at p was renamed from
+func findRenameSource(store Store, oldTree, newTree objects.ObjectID, p string, id objects.ObjectID, threshold int) (*Rename, error) {
+	oldFiles, err := ReadFiles(store, oldTree)
+	if err != nil {
+		return nil, err
+	}
+	newFiles, err := ReadFiles(store, newTree)
+	if err != nil {
+		return nil, err
+	}
+
+	content, err := ReadBlob(store, id)
+	if err != nil {
+		return nil, err
+	}
+	added := []Candidate{{Path: p, ID: id, Content: content}}
+
+	var deleted []Candidate
+	for _, file := range oldFiles {
+		if _, ok := newFiles[file.Path]; ok {
+			continue
+		}
+		candidate := Candidate{Path: file.Path, ID: file.ID}
+		if file.ID != id {
+			if candidate.Content, err = ReadBlob(store, file.ID); err != nil {
+				return nil, err
+			}
+		}
+		deleted = append(deleted, candidate)
+	}
+
+	renames := MatchRenames(deleted, added, threshold)
+	if len(renames) == 0 {
+		return nil, nil
+	}
+	return &renames[0], nil
+}
+
+// File is a file in a flattened tree
+type File struct {
+	Path string
+	Mode objects.FileMode
+	ID   objects.ObjectID
+}
+
+// ReadFiles returns the files of a tree and its subtrees by path. A zero
+// tree has no files.
+func ReadFiles(store Store, tree objects.ObjectID) (map[string]File, error) {
+	files := make(map[string]File)
+	if tree.IsZero() {
+		return files, nil
+	}
+	return files, readFiles(store, tree, "", files)
+}
+
+func readFiles(store Store, id objects.ObjectID, prefix string, files map[string]File) error {
+	tree, err := readTree(store, id)
+	if err != nil {
+		return err
+	}
+	for _, entry := range tree.Entries() {
+		p := path.Join(prefix, entry.Name)
+		switch entry.Mode {
+		case objects.ModeTree:
+			if err := readFiles(store, entry.ID, p, files); err != nil {
+				return err
+			}
+		case objects.ModeCommit:
+			// Submodules have no content in this repository
+		default:
+			files[p] = File{Path: p, Mode: entry.Mode, ID: entry.ID}
+		}
+	}
+	return nil
+}
+
+// lookupFile finds the file at p in tree without reading unrelated subtrees,
+// returning nil when there is none
+func lookupFile(store Store, tree objects.ObjectID, p string) (*File, error) {
+	if tree.IsZero() {
+		return nil, nil
+	}
+
+	parts := strings.Split(p, "/")
+	id := tree
+	for i, name := range parts {
+		t, err := readTree(store, id)
+		if err != nil {
+			return nil, err
+		}
+		entry, ok := findEntry(t, name)
+		if !ok {
+			return nil, nil
+		}
+		if i == len(parts)-1 {
+			if entry.Mode == objects.ModeTree || entry.Mode == objects.ModeCommit {
+				return nil, nil
+			}
+			return &File{Path: p, Mode: entry.Mode, ID: entry.ID}, nil
+		}
+		if entry.Mode != objects.ModeTree {
+			return nil, nil
+		}
+		id = entry.ID
+	}
+	return nil, nil
+}
+
+func findEntry(tree *objects.Tree, name string) (objects.TreeEntry, bool) {
+	for _, entry := range tree.Entries() {
+		if entry.Name == name {
+			return entry, true
+		}
+	}
+	return objects.TreeEntry{}, false
+}
+
+func readTree(store Store, id objects.ObjectID) (*objects.Tree, error) {
+	obj, err := store.ReadObject(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read tree %s: %w", id.Short(), err)
+	}
+	tree, ok := obj.(*objects.Tree)
+	if !ok {
+		return nil, fmt.Errorf("object %s is not a tree", id.Short())
+	}
+	return tree, nil
+}
+
+// ReadCommit reads the commit with the given ID
+func ReadCommit(store Store, id objects.ObjectID) (*objects.Commit, error) {
+	obj, err := store.ReadObject(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read commit %s: %w", id.Short(), err)
+	}
+	commit, ok := obj.(*objects.Commit)
+	if !ok {
+		return nil, fmt.Errorf("object %s is not a commit", id.Short())
+	}
+	return commit, nil
+}
+
+// ReadBlob returns the content of the blob with the given ID
+func ReadBlob(store Store, id objects.ObjectID) ([]byte, error) {
+	obj, err := store.ReadObject(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read blob %s: %w", id.Short(), err)
+	}
+	blob, ok := obj.(*objects.Blob)
+	if !ok {
+		return nil, fmt.Errorf("object %s is not a blob", id.Short())
+	}
+	return blob.Data(), nil
+}
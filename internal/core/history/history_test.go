@@ -0,0 +1,377 @@
+package history
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+func newStore(t *testing.T) *objects.Storage {
+	store := objects.NewStorage(t.TempDir())
+	if err := store.Init(); err != nil {
+		t.Fatalf("Storage.Init() error = %v", err)
+	}
+	return store
+}
+
+// writeTree stores files given as slash-separated path to content
+func writeTree(t *testing.T, store *objects.Storage, files map[string]string) objects.ObjectID {
+	tree := objects.NewTree()
+	subtrees := make(map[string]map[string]string)
+	for p, content := range files {
+		if dir, rest, ok := strings.Cut(p, "/"); ok {
+			if subtrees[dir] == nil {
+				subtrees[dir] = make(map[string]string)
+			}
+			subtrees[dir][rest] = content
+			continue
+		}
+		blob := objects.NewBlob([]byte(content))
+		if err := store.WriteObject(blob); err != nil {
+			t.Fatalf("WriteObject() error = %v", err)
+		}
+		if err := tree.AddEntry(objects.ModeBlob, p, blob.ID()); err != nil {
+			t.Fatalf("AddEntry() error = %v", err)
+		}
+	}
+	for dir, files := range subtrees {
+		if err := tree.AddEntry(objects.ModeTree, dir, writeTree(t, store, files)); err != nil {
+			t.Fatalf("AddEntry() error = %v", err)
+		}
+	}
+	if err := store.WriteObject(tree); err != nil {
+		t.Fatalf("WriteObject() error = %v", err)
+	}
+	return tree.ID()
+}
+
+// writeCommit stores a commit of files on top of parent, if not zero
+func writeCommit(t *testing.T, store *objects.Storage, files map[string]string, parent objects.ObjectID, message string) objects.ObjectID {
+	sig := objects.Signature{Name: "Test", Email: "test@example.com", When: time.Unix(1700000000, 0)}
+	var parents []objects.ObjectID
+	if !parent.IsZero() {
+		parents = []objects.ObjectID{parent}
+	}
+	commit := objects.NewCommit(writeTree(t, store, files), parents, sig, sig, message)
+	if err := store.WriteObject(commit); err != nil {
+		t.Fatalf("WriteObject() error = %v", err)
+	}
+	return commit.ID()
+}
+
+func lines(ls ...string) string {
+	return strings.Join(ls, "\n") + "\n"
+}
+
+func TestSimilarity(t *testing.T) {
+	tests := []struct {
+		name string
+		a, b string
+		want int
+	}{
+		{"identical", lines("a", "b"), lines("a", "b"), 100},
+		{"disjoint", lines("a", "b"), lines("c", "d"), 0},
+		{"empty", "", lines("a"), 0},
+		{"half changed", lines("aaa", "bbb"), lines("aaa", "ccc"), 50},
+		{"appended", lines("aaa"), lines("aaa", "bbb"), 50},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Similarity([]byte(tt.a), []byte(tt.b)); got != tt.want {
+				t.Errorf("Similarity() = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchRenames(t *testing.T) {
+	body := lines("one", "two", "three", "four", "five", "six", "seven", "eight")
+	exact := objects.NewBlob([]byte("exact\n")).ID()
+
+	deleted := []Candidate{
+		{Path: "old.txt", Content: []byte(body)},
+		{Path: "moved.txt", ID: exact, Content: []byte("exact\n")},
+		{Path: "gone.txt", Content: []byte(lines("nothing", "alike"))},
+	}
+	added := []Candidate{
+		{Path: "new.txt", Content: []byte(strings.Replace(body, "eight", "EIGHT", 1))},
+		{Path: "renamed.txt", ID: exact, Content: []byte("exact\n")},
+		{Path: "fresh.txt", Content: []byte(lines("brand", "new"))},
+	}
+
+	renames := MatchRenames(deleted, added, DefaultRenameThreshold)
+	if len(renames) != 2 {
+		t.Fatalf("MatchRenames() = %d renames, want 2", len(renames))
+	}
+	if renames[0].From.Path != "old.txt" || renames[0].To.Path != "new.txt" || renames[0].Score >= 100 {
+		t.Errorf("renames[0] = %s -> %s (%d), want old.txt -> new.txt below 100",
+			renames[0].From.Path, renames[0].To.Path, renames[0].Score)
+	}
+	if renames[1].From.Path != "moved.txt" || renames[1].To.Path != "renamed.txt" || renames[1].Score != 100 {
+		t.Errorf("renames[1] = %s -> %s (%d), want moved.txt -> renamed.txt at 100",
+			renames[1].From.Path, renames[1].To.Path, renames[1].Score)
+	}
+
+	if renames := MatchRenames(deleted[:1], added[:1], 95); len(renames) != 0 {
+		t.Errorf("MatchRenames() at 95%% = %d renames, want 0", len(renames))
+	}
+}
+
+func TestMatchCopies(t *testing.T) {
+	body := lines("one", "two", "three", "four", "five", "six", "seven", "eight")
+	exact := objects.NewBlob([]byte("exact\n")).ID()
+
+	sources := []Candidate{
+		{Path: "a.txt", Content: []byte(body)},
+		{Path: "b.txt", ID: exact, Content: []byte("exact\n")},
+	}
+	added := []Candidate{
+		{Path: "a2.txt", Content: []byte(strings.Replace(body, "eight", "EIGHT", 1))},
+		{Path: "a3.txt", Content: []byte(body)},
+		{Path: "b2.txt", ID: exact, Content: []byte("exact\n")},
+		{Path: "fresh.txt", Content: []byte(lines("brand", "new"))},
+	}
+
+	copies := MatchCopies(sources, added, DefaultRenameThreshold)
+	var got []string
+	for _, c := range copies {
+		if !c.Copy {
+			t.Errorf("copy %s -> %s not marked as a copy", c.From.Path, c.To.Path)
+		}
+		got = append(got, c.From.Path+">"+c.To.Path)
+	}
+	if strings.Join(got, ",") != "a.txt>a2.txt,a.txt>a3.txt,b.txt>b2.txt" {
+		t.Errorf("MatchCopies() = %v", got)
+	}
+	if copies[1].Score != 100 || copies[0].Score >= 100 {
+		t.Errorf("MatchCopies() scores = %d, %d", copies[0].Score, copies[1].Score)
+	}
+}
+
+func TestDetectRenames(t *testing.T) {
+	store := newStore(t)
+	body := lines("one", "two", "three", "four", "five", "six", "seven", "eight")
+	oldTree := writeTree(t, store, map[string]string{
+		"old.txt":  body,
+		"keep.txt": lines("k1", "k2", "k3", "k4"),
+		"gone.txt": "gone\n",
+	})
+	newTree := writeTree(t, store, map[string]string{
+		"dir/new.txt": strings.Replace(body, "eight", "EIGHT", 1),
+		"keep.txt":    lines("k1", "k2", "k3", "k4", "k5"),
+		"copy.txt":    lines("k1", "k2", "k3", "k4"),
+		"fresh.txt":   "fresh\n",
+	})
+	changes, err := DiffTrees(store, oldTree, newTree, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	describe := func(changes []Change) string {
+		var out []string
+		for _, c := range changes {
+			desc := c.Type.String() + " " + c.Path
+			if c.Type == Renamed || c.Type == Copied {
+				desc = fmt.Sprintf("%s%d %s %s", c.Type, c.Score, c.OldPath, c.Path)
+			}
+			out = append(out, desc)
+		}
+		return strings.Join(out, ",")
+	}
+
+	tests := []struct {
+		d    Detection
+		want string
+	}{
+		{Detection{}, "A copy.txt,A dir/new.txt,A fresh.txt,D gone.txt,M keep.txt,D old.txt"},
+		{Detection{Renames: 50}, "A copy.txt,R85 old.txt dir/new.txt,A fresh.txt,D gone.txt,M keep.txt"},
+		{Detection{Renames: 50, Copies: 50}, "C100 keep.txt copy.txt,R85 old.txt dir/new.txt,A fresh.txt,D gone.txt,M keep.txt"},
+		{Detection{Renames: 95, Copies: 50}, "C100 keep.txt copy.txt,C85 old.txt dir/new.txt,A fresh.txt,D gone.txt,M keep.txt,D old.txt"},
+	}
+	for _, tt := range tests {
+		got, err := DetectRenames(store, changes, tt.d)
+		if err != nil {
+			t.Fatalf("DetectRenames(%+v) error = %v", tt.d, err)
+		}
+		if describe(got) != tt.want {
+			t.Errorf("DetectRenames(%+v) = %s, want %s", tt.d, describe(got), tt.want)
+		}
+	}
+}
+
+func TestFileChange(t *testing.T) {
+	store := newStore(t)
+	body := lines("one", "two", "three", "four", "five")
+
+	first := writeCommit(t, store, map[string]string{"src/a.txt": body, "b.txt": "b\n"}, objects.ObjectID{}, "first\n")
+	second := writeCommit(t, store, map[string]string{"lib/a.txt": body + "six\n", "b.txt": "b\n"}, first, "move\n")
+
+	firstCommit, err := ReadCommit(store, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secondCommit, err := ReadCommit(store, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	oldTree, newTree := firstCommit.Tree(), secondCommit.Tree()
+
+	change, err := FileChange(store, oldTree, newTree, "lib/a.txt", DefaultRenameThreshold)
+	if err != nil {
+		t.Fatalf("FileChange() error = %v", err)
+	}
+	if change == nil || change.Type != Renamed || change.OldPath != "src/a.txt" || change.Score < DefaultRenameThreshold {
+		t.Fatalf("FileChange() = %+v, want rename from src/a.txt", change)
+	}
+
+	change, err = FileChange(store, oldTree, newTree, "lib/a.txt", 0)
+	if err != nil {
+		t.Fatalf("FileChange() error = %v", err)
+	}
+	if change == nil || change.Type != Added {
+		t.Errorf("FileChange() without threshold = %+v, want added", change)
+	}
+
+	change, err = FileChange(store, oldTree, newTree, "b.txt", DefaultRenameThreshold)
+	if err != nil {
+		t.Fatalf("FileChange() error = %v", err)
+	}
+	if change != nil {
+		t.Errorf("FileChange() of unchanged file = %+v, want nil", change)
+	}
+
+	change, err = FileChange(store, objects.ObjectID{}, oldTree, "src/a.txt", DefaultRenameThreshold)
+	if err != nil {
+		t.Fatalf("FileChange() error = %v", err)
+	}
+	if change == nil || change.Type != Added {
+		t.Errorf("FileChange() from empty tree = %+v, want added", change)
+	}
+}
+
+func TestBlame(t *testing.T) {
+	store := newStore(t)
+
+	first := writeCommit(t, store, map[string]string{"old.txt": lines("one", "two", "three", "four")}, objects.ObjectID{}, "first\n")
+	second := writeCommit(t, store, map[string]string{"old.txt": lines("one", "TWO", "three", "four")}, first, "edit\n")
+	third := writeCommit(t, store, map[string]string{"new.txt": lines("one", "TWO", "three", "four", "five")}, second, "rename\n")
+
+	result, err := Blame(store, third, "new.txt", BlameOptions{RenameThreshold: DefaultRenameThreshold})
+	if err != nil {
+		t.Fatalf("Blame() error = %v", err)
+	}
+
+	want := []struct {
+		commit   objects.ObjectID
+		path     string
+		number   int
+		boundary bool
+	}{
+		{first, "old.txt", 1, true},
+		{second, "old.txt", 2, false},
+		{first, "old.txt", 3, true},
+		{first, "old.txt", 4, true},
+		{third, "new.txt", 5, false},
+	}
+	if len(result) != len(want) {
+		t.Fatalf("Blame() = %d lines, want %d", len(result), len(want))
+	}
+	for i, w := range want {
+		got := result[i]
+		if got.Commit != w.commit || got.Path != w.path || got.Number != w.number || got.Boundary != w.boundary {
+			t.Errorf("line %d = %s %s:%d boundary=%v, want %s %s:%d boundary=%v", i+1,
+				got.Commit.Short(), got.Path, got.Number, got.Boundary,
+				w.commit.Short(), w.path, w.number, w.boundary)
+		}
+	}
+
+	// Without rename detection the whole file starts at the rename
+	result, err = Blame(store, third, "new.txt", BlameOptions{})
+	if err != nil {
+		t.Fatalf("Blame() error = %v", err)
+	}
+	for i, line := range result {
+		if line.Commit != third {
+			t.Errorf("line %d blamed on %s, want %s", i+1, line.Commit.Short(), third.Short())
+		}
+	}
+
+	// A boundary stops the walk early
+	result, err = Blame(store, third, "new.txt", BlameOptions{
+		RenameThreshold: DefaultRenameThreshold,
+		Boundary:        func(id objects.ObjectID) bool { return id == second },
+	})
+	if err != nil {
+		t.Fatalf("Blame() error = %v", err)
+	}
+	if result[0].Commit != second || !result[0].Boundary {
+		t.Errorf("line 1 = %s boundary=%v, want %s boundary", result[0].Commit.Short(), result[0].Boundary, second.Short())
+	}
+}
+
+func TestDiffTrees(t *testing.T) {
+	store := newStore(t)
+	oldTree := writeTree(t, store, map[string]string{
+		"a.txt":     "a\n",
+		"gone.txt":  "gone\n",
+		"src/x.go":  "x\n",
+		"src/y.go":  "y\n",
+		"docs/d.md": "d\n",
+		"lib":       "file\n",
+	})
+	newTree := writeTree(t, store, map[string]string{
+		"a.txt":     "a changed\n",
+		"new.txt":   "new\n",
+		"src/x.go":  "x\n",
+		"src/y.go":  "y changed\n",
+		"docs/d.md": "d\n",
+		"lib/z.go":  "z\n",
+	})
+
+	describe := func(changes []Change) []string {
+		var out []string
+		for _, c := range changes {
+			out = append(out, c.Type.String()+" "+c.Path)
+		}
+		return out
+	}
+
+	changes, err := DiffTrees(store, oldTree, newTree, true)
+	if err != nil {
+		t.Fatalf("DiffTrees() error = %v", err)
+	}
+	want := []string{"M a.txt", "D gone.txt", "D lib", "A lib/z.go", "A new.txt", "M src/y.go"}
+	if got := describe(changes); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("DiffTrees(recursive) = %v, want %v", got, want)
+	}
+	if changes[0].OldMode != objects.ModeBlob || changes[0].NewMode != objects.ModeBlob || changes[0].OldID == changes[0].NewID {
+		t.Errorf("DiffTrees() modified entry = %+v", changes[0])
+	}
+	if changes[1].NewMode != 0 || !changes[1].NewID.IsZero() {
+		t.Errorf("DiffTrees() deleted entry = %+v", changes[1])
+	}
+
+	changes, err = DiffTrees(store, oldTree, newTree, false)
+	if err != nil {
+		t.Fatalf("DiffTrees() error = %v", err)
+	}
+	want = []string{"M a.txt", "D gone.txt", "D lib", "A lib", "A new.txt", "M src"}
+	if got := describe(changes); strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("DiffTrees() = %v, want %v", got, want)
+	}
+	if src := changes[5]; src.OldMode != objects.ModeTree || src.NewMode != objects.ModeTree {
+		t.Errorf("DiffTrees() subtree entry = %+v", src)
+	}
+
+	changes, err = DiffTrees(store, objects.ObjectID{}, oldTree, true)
+	if err != nil {
+		t.Fatalf("DiffTrees() from empty error = %v", err)
+	}
+	if len(changes) != 6 {
+		t.Errorf("DiffTrees() from empty = %v, want 6 added files", describe(changes))
+	}
+}
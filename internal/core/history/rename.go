@@ -0,0 +1,160 @@
+package history
+
+import (
+	"bytes"
+
+	"github.com/fenilsonani/vcs/internal/core/merge"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+// DefaultRenameThreshold is the similarity, in percent, a deleted and an
+// added file need to be treated as a rename, as in Git
+const DefaultRenameThreshold = merge.DefaultRenameThreshold
+
+// Candidate is a deleted or added file considered for rename detection
+type Candidate = merge.Candidate
+
+// Rename pairs a deleted file with the added file it became
+type Rename = merge.Rename
+
+// Similarity scores how much of two file contents is the same, from 0 to
+// 100. Rename detection lives in merge, which merges renamed files too.
+func Similarity(a, b []byte) int {
+	return merge.Similarity(a, b)
+}
+
+// MatchRenames pairs deleted files with added files; see merge.MatchRenames
+func MatchRenames(deleted, added []Candidate, threshold int) []Rename {
+	return merge.MatchRenames(deleted, added, threshold)
+}
+
+// MatchCopies pairs added files with the files they were copied from; see
+// merge.MatchCopies
+func MatchCopies(sources, added []Candidate, threshold int) []Rename {
+	return merge.MatchCopies(sources, added, threshold)
+}
+
+// Detection holds the similarities in percent DetectRenames needs, zero
+// turning that detection off
+type Detection struct {
+	// Renames pairs a deleted and an added file as a rename
+	Renames int
+	// Copies pairs an added file with the old version of a modified or
+	// deleted file as a copy of it
+	Copies int
+}
+
+// DetectRenames rewrites changes, as DiffTrees returns them, so that
+// deleted and added files similar enough are a single rename, and with
+// copy detection added files similar enough to a file modified or deleted
+// are a copy of it. Each change is kept at its new path; submodules are
+// left alone.
+func DetectRenames(store Store, changes []Change, d Detection) ([]Change, error) {
+	if d.Renames <= 0 && d.Copies <= 0 {
+		return changes, nil
+	}
+
+	contents := make(map[objects.ObjectID][]byte)
+	candidate := func(p string, id objects.ObjectID) (Candidate, error) {
+		data, ok := contents[id]
+		if !ok {
+			var err error
+			if data, err = ReadBlob(store, id); err != nil {
+				return Candidate{}, err
+			}
+			contents[id] = data
+		}
+		return Candidate{Path: p, ID: id, Content: data}, nil
+	}
+
+	var deleted, added, sources []Candidate
+	oldModes := make(map[string]objects.FileMode)
+	for _, change := range changes {
+		switch {
+		case change.Type == Added && isFile(change.NewMode):
+			c, err := candidate(change.Path, change.NewID)
+			if err != nil {
+				return nil, err
+			}
+			added = append(added, c)
+		case change.Type == Deleted && isFile(change.OldMode):
+			c, err := candidate(change.Path, change.OldID)
+			if err != nil {
+				return nil, err
+			}
+			deleted = append(deleted, c)
+			sources = append(sources, c)
+			oldModes[c.Path] = change.OldMode
+		case change.Type == Modified && d.Copies > 0 && isFile(change.OldMode):
+			c, err := candidate(change.OldPath, change.OldID)
+			if err != nil {
+				return nil, err
+			}
+			sources = append(sources, c)
+			oldModes[c.Path] = change.OldMode
+		}
+	}
+
+	found := make(map[string]Rename)
+	renamed := make(map[string]bool)
+	if d.Renames > 0 && len(deleted) > 0 && len(added) > 0 {
+		for _, r := range MatchRenames(deleted, added, d.Renames) {
+			found[r.To.Path] = r
+			renamed[r.From.Path] = true
+		}
+	}
+	if d.Copies > 0 && len(sources) > 0 {
+		var rest []Candidate
+		for _, c := range added {
+			if _, ok := found[c.Path]; !ok {
+				rest = append(rest, c)
+			}
+		}
+		for _, r := range MatchCopies(sources, rest, d.Copies) {
+			found[r.To.Path] = r
+		}
+	}
+	if len(found) == 0 {
+		return changes, nil
+	}
+
+	var result []Change
+	for _, change := range changes {
+		if change.Type == Deleted && renamed[change.Path] {
+			continue
+		}
+		if r, ok := found[change.Path]; ok && change.Type == Added {
+			change.Type = Renamed
+			if r.Copy {
+				change.Type = Copied
+			}
+			change.OldPath = r.From.Path
+			change.OldMode = oldModes[r.From.Path]
+			change.OldID = r.From.ID
+			change.Score = r.Score
+		}
+		result = append(result, change)
+	}
+	return result, nil
+}
+
+// isFile reports whether mode is that of a file or symlink, whose content
+// can be compared
+func isFile(mode objects.FileMode) bool {
+	return mode != objects.ModeTree && mode != objects.ModeCommit
+}
+
+// splitLines splits data after each newline, keeping the terminators
+func splitLines(data []byte) []string {
+	var lines []string
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			lines = append(lines, string(data))
+			break
+		}
+		lines = append(lines, string(data[:i+1]))
+		data = data[i+1:]
+	}
+	return lines
+}
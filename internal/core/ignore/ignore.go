@@ -0,0 +1,375 @@
+// Package ignore decides which paths of a working tree are ignored, as Git
+// does from the .gitignore files of each directory, the repository's
+// info/exclude and core.excludesFile.
+package ignore
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// Source records where a pattern was read from. File is empty for
+// patterns added directly.
+type Source struct {
+	File    string
+	Line    int
+	Pattern string
+}
+
+func (s Source) String() string {
+	if s.File == "" {
+		return s.Pattern
+	}
+	return fmt.Sprintf("%s:%d: %s", s.File, s.Line, s.Pattern)
+}
+
+// Pattern is one line of an ignore file
+type Pattern struct {
+	Source
+	// Negated patterns, written with a leading !, re-include paths that
+	// patterns before them exclude
+	Negated bool
+	// DirOnly patterns, written with a trailing /, match only directories
+	DirOnly bool
+	// base is the directory of the file the pattern is from, relative to
+	// the top of the working tree; the pattern matches paths below it
+	base string
+	re   *regexp.Regexp
+}
+
+// parsePattern parses line, read from the file in the directory base. It
+// reports false for blank lines, comments and patterns that cannot match.
+func parsePattern(line, base string, source Source) (*Pattern, bool) {
+	line = strings.TrimSuffix(line, "\r")
+	line = trimTrailingSpaces(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil, false
+	}
+	source.Pattern = line
+
+	p := &Pattern{Source: source, base: base}
+	if strings.HasPrefix(line, "!") {
+		p.Negated = true
+		line = line[1:]
+	} else if strings.HasPrefix(line, `\!`) || strings.HasPrefix(line, `\#`) {
+		line = line[1:]
+	}
+	if strings.HasSuffix(line, "/") {
+		p.DirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return nil, false
+	}
+	p.re = compilePattern(line)
+	return p, p.re != nil
+}
+
+// trimTrailingSpaces drops the spaces ending line unless they are escaped
+// with a backslash
+func trimTrailingSpaces(line string) string {
+	end := len(line)
+	for end > 0 && line[end-1] == ' ' {
+		if end > 1 && line[end-2] == '\\' {
+			return line[:end-2] + " "
+		}
+		end--
+	}
+	return line[:end]
+}
+
+// compilePattern compiles pattern as Git matches it: without a slash it
+// matches the name at any depth, otherwise the path relative to the
+// directory of its file, with ** spanning directories
+func compilePattern(pattern string) *regexp.Regexp {
+	anchored := strings.Contains(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+
+	var b strings.Builder
+	b.WriteString("^")
+	if !anchored {
+		b.WriteString("(?:.*/)?")
+	}
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case strings.HasPrefix(pattern[i:], "**/") && (i == 0 || pattern[i-1] == '/'):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**") && i > 0 && pattern[i-1] == '/' && i+2 == len(pattern):
+			// A trailing /** matches everything inside
+			b.WriteString(".+")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		default:
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	b.WriteString("$")
+
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return nil
+	}
+	return re
+}
+
+// matches reports whether the pattern applies to path, relative to the top
+// of the working tree, which names a directory when isDir is set. Negation
+// is left to the caller.
+func (p *Pattern) matches(path string, isDir bool) bool {
+	if p.DirOnly && !isDir {
+		return false
+	}
+	if p.base != "" {
+		rest, ok := strings.CutPrefix(path, p.base+"/")
+		if !ok {
+			return false
+		}
+		path = rest
+	}
+	return p.re.MatchString(path)
+}
+
+// List is the patterns of one ignore file, in order
+type List struct {
+	patterns []*Pattern
+}
+
+// NewList creates an empty list
+func NewList() *List {
+	return &List{}
+}
+
+// Patterns returns the patterns of the list in order
+func (l *List) Patterns() []*Pattern {
+	return l.patterns
+}
+
+// AddPattern adds a pattern that applies to the whole working tree
+func (l *List) AddPattern(pattern string) {
+	if p, ok := parsePattern(pattern, "", Source{}); ok {
+		l.patterns = append(l.patterns, p)
+	}
+}
+
+// LoadFile adds the patterns of filename, which apply to the whole working
+// tree. A missing file adds none.
+func (l *List) LoadFile(filename string) error {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	l.parse(content, filename, "")
+	return nil
+}
+
+// parse adds the patterns of content, read from filename in the directory
+// base
+func (l *List) parse(content []byte, filename, base string) {
+	for i, line := range strings.Split(string(content), "\n") {
+		if p, ok := parsePattern(line, base, Source{File: filename, Line: i + 1}); ok {
+			l.patterns = append(l.patterns, p)
+		}
+	}
+}
+
+// Match returns the last pattern of the list matching path, which decides
+// for the list, or nil when none does
+func (l *List) Match(path string, isDir bool) *Pattern {
+	for i := len(l.patterns) - 1; i >= 0; i-- {
+		if l.patterns[i].matches(path, isDir) {
+			return l.patterns[i]
+		}
+	}
+	return nil
+}
+
+// Reader returns the .gitignore file of dir, relative to the top of the
+// working tree with "" for the top itself, and whether it has one
+type Reader func(dir string) ([]byte, bool)
+
+// WorkTreeReader reads the .gitignore files in workTree
+func WorkTreeReader(workTree string) Reader {
+	return func(dir string) ([]byte, bool) {
+		data, err := os.ReadFile(filepath.Join(workTree, filepath.FromSlash(dir), ".gitignore"))
+		return data, err == nil
+	}
+}
+
+// Matcher decides whether paths are ignored as Git does. Patterns added
+// directly take precedence over the .gitignore files, those of deeper
+// directories over those above them, then come info/exclude and last
+// core.excludesFile; within one file the last matching pattern wins.
+// Nothing inside an ignored directory can be re-included, since Git does
+// not look into it. The .gitignore files are read once, on first use. A
+// matcher may be used from several goroutines.
+type Matcher struct {
+	read     Reader
+	extra    *List
+	info     *List
+	excludes *List
+	mu       sync.Mutex
+	dirs     map[string]*List
+	// excluded holds the pattern ignoring each directory looked at, nil
+	// for those not ignored
+	excluded map[string]*Pattern
+}
+
+// NewMatcher returns a matcher reading .gitignore files with read, which
+// may be nil to read none, and the info/exclude file at infoExclude, which
+// may be "" or missing
+func NewMatcher(read Reader, infoExclude string) *Matcher {
+	m := &Matcher{
+		read:     read,
+		extra:    NewList(),
+		info:     NewList(),
+		excludes: NewList(),
+		dirs:     make(map[string]*List),
+		excluded: make(map[string]*Pattern),
+	}
+	if infoExclude != "" {
+		m.info.LoadFile(infoExclude)
+	}
+	return m
+}
+
+// AddPattern adds a pattern taking precedence over every file
+func (m *Matcher) AddPattern(pattern string) {
+	m.extra.AddPattern(pattern)
+	m.forget()
+}
+
+// LoadFile adds the patterns of filename, taking precedence over every
+// ignore file as AddPattern does
+func (m *Matcher) LoadFile(filename string) error {
+	defer m.forget()
+	return m.extra.LoadFile(filename)
+}
+
+// LoadExcludesFile reads filename, the core.excludesFile, whose patterns
+// come after every other
+func (m *Matcher) LoadExcludesFile(filename string) error {
+	defer m.forget()
+	return m.excludes.LoadFile(filename)
+}
+
+// forget drops the directories found ignored, after patterns are added
+func (m *Matcher) forget() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.excluded = make(map[string]*Pattern)
+}
+
+// dir returns the patterns of the .gitignore file in dir
+func (m *Matcher) dir(dir string) *List {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	list, ok := m.dirs[dir]
+	if !ok {
+		list = NewList()
+		if m.read != nil {
+			if data, found := m.read(dir); found {
+				list.parse(data, path.Join(dir, ".gitignore"), dir)
+			}
+		}
+		m.dirs[dir] = list
+	}
+	return list
+}
+
+// Match returns the pattern deciding whether name, relative to the top of
+// the working tree, is ignored, and whether it is; the pattern is nil when
+// none matches. isDir says whether name is a directory. A path inside an
+// ignored directory is ignored by the pattern ignoring the directory.
+func (m *Matcher) Match(name string, isDir bool) (*Pattern, bool) {
+	name = strings.Trim(filepath.ToSlash(name), "/")
+	if name == "" {
+		return nil, false
+	}
+	if dir := path.Dir(name); dir != "." {
+		if p := m.excludedDir(dir); p != nil {
+			return p, true
+		}
+	}
+	p := m.match(name, isDir)
+	return p, p != nil && !p.Negated
+}
+
+// excludedDir returns the pattern ignoring dir or a directory above it, or
+// nil when none is ignored
+func (m *Matcher) excludedDir(dir string) *Pattern {
+	m.mu.Lock()
+	p, ok := m.excluded[dir]
+	m.mu.Unlock()
+	if ok {
+		return p
+	}
+
+	if parent := path.Dir(dir); parent != "." {
+		p = m.excludedDir(parent)
+	}
+	if p == nil {
+		if p = m.match(dir, true); p != nil && p.Negated {
+			p = nil
+		}
+	}
+	m.mu.Lock()
+	m.excluded[dir] = p
+	m.mu.Unlock()
+	return p
+}
+
+// IsIgnored reports whether name is ignored
+func (m *Matcher) IsIgnored(name string, isDir bool) bool {
+	_, ignored := m.Match(name, isDir)
+	return ignored
+}
+
+// match returns the pattern deciding for name alone, without looking at the
+// directories above it
+func (m *Matcher) match(name string, isDir bool) *Pattern {
+	if p := m.extra.Match(name, isDir); p != nil {
+		return p
+	}
+	for dir := path.Dir(name); ; dir = path.Dir(dir) {
+		if dir == "." {
+			dir = ""
+		}
+		if p := m.dir(dir).Match(name, isDir); p != nil {
+			return p
+		}
+		if dir == "" {
+			break
+		}
+	}
+	if p := m.info.Match(name, isDir); p != nil {
+		return p
+	}
+	return m.excludes.Match(name, isDir)
+}
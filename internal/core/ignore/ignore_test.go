@@ -0,0 +1,204 @@
+package ignore
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestList_AddPattern(t *testing.T) {
+	l := NewList()
+
+	tests := []struct {
+		pattern string
+		want    int
+	}{
+		{"*.txt", 1},
+		{"*.log  ", 2}, // trailing spaces are dropped
+		{"# comment", 2},
+		{"", 2},
+		{"build/", 3},
+		{`\#hash`, 4},
+		{"/", 4}, // matches nothing
+	}
+
+	for _, tt := range tests {
+		l.AddPattern(tt.pattern)
+		if len(l.Patterns()) != tt.want {
+			t.Errorf("After adding %q, patterns count = %d, want %d", tt.pattern, len(l.Patterns()), tt.want)
+		}
+	}
+}
+
+func TestList_LoadFile(t *testing.T) {
+	tmpDir := t.TempDir()
+	content := "# This is a comment\n*.log\n*.tmp\n\n# Another comment\nbuild/\nnode_modules/\n"
+	path := filepath.Join(tmpDir, ".gitignore")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write .gitignore: %v", err)
+	}
+
+	l := NewList()
+	if err := l.LoadFile(path); err != nil {
+		t.Fatalf("LoadFile() error = %v", err)
+	}
+	want := []Source{
+		{File: path, Line: 2, Pattern: "*.log"},
+		{File: path, Line: 3, Pattern: "*.tmp"},
+		{File: path, Line: 6, Pattern: "build/"},
+		{File: path, Line: 7, Pattern: "node_modules/"},
+	}
+	if len(l.Patterns()) != len(want) {
+		t.Fatalf("LoadFile() loaded %d patterns, want %d", len(l.Patterns()), len(want))
+	}
+	for i, p := range l.Patterns() {
+		if p.Source != want[i] {
+			t.Errorf("Pattern %d = %+v, want %+v", i, p.Source, want[i])
+		}
+	}
+
+	if err := NewList().LoadFile(filepath.Join(tmpDir, "missing")); err != nil {
+		t.Errorf("LoadFile() should not error for a missing file, got: %v", err)
+	}
+}
+
+func TestMatcher_Patterns(t *testing.T) {
+	tests := []struct {
+		pattern string
+		path    string
+		isDir   bool
+		want    bool
+	}{
+		{"*.log", "file.log", false, true},
+		{"*.log", "logs/error.log", false, true},
+		{"*.log", "file.txt", false, false},
+		{"build/", "build", true, true},
+		{"build/", "build", false, false},
+		{"build/", "build/output", false, true},
+		{"build/", "src/build/output", false, true},
+		{"/config.json", "config.json", false, true},
+		{"/config.json", "src/config.json", false, false},
+		{"doc/*.txt", "doc/notes.txt", false, true},
+		{"doc/*.txt", "doc/server/arch.txt", false, false},
+		{"doc/*.txt", "src/doc/notes.txt", false, false},
+		{"test*", "testing", false, true},
+		{"*test*", "mytest.txt", false, true},
+		{"*test*", "file.txt", false, false},
+		{"**/foo", "foo", false, true},
+		{"**/foo", "a/b/foo", false, true},
+		{"**/foo/bar", "a/foo/bar", false, true},
+		{"abc/**", "abc/x/y", false, true},
+		{"abc/**", "abc", true, false},
+		{"a/**/b", "a/b", false, true},
+		{"a/**/b", "a/x/y/b", false, true},
+		{"a/**/b", "x/a/b", false, false},
+		{"file?.[ch]", "file1.c", false, true},
+		{"file?.[!ch]", "file1.c", false, false},
+		{`\!important`, "!important", false, true},
+		{`trailing\ `, "trailing ", false, true},
+	}
+
+	for _, tt := range tests {
+		m := NewMatcher(nil, "")
+		m.AddPattern(tt.pattern)
+		if got := m.IsIgnored(tt.path, tt.isDir); got != tt.want {
+			t.Errorf("%q: IsIgnored(%q, %v) = %v, want %v", tt.pattern, tt.path, tt.isDir, got, tt.want)
+		}
+	}
+}
+
+func TestMatcher_Negation(t *testing.T) {
+	m := NewMatcher(nil, "")
+	m.AddPattern("*.log")
+	m.AddPattern("!keep.log")
+	m.AddPattern("out/")
+	m.AddPattern("!out/keep.txt")
+	m.AddPattern("/gen/*")
+	m.AddPattern("!/gen/keep")
+
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{"debug.log", true},
+		{"keep.log", false},
+		{"sub/keep.log", false},
+		// Nothing inside an ignored directory can be re-included
+		{"out/keep.txt", true},
+		{"gen/other", true},
+		{"gen/keep", false},
+	}
+	for _, tt := range tests {
+		if got := m.IsIgnored(tt.path, false); got != tt.want {
+			t.Errorf("IsIgnored(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+
+	p, ignored := m.Match("out/keep.txt", false)
+	if !ignored || p.Pattern != "out/" {
+		t.Errorf("Match(out/keep.txt) = %+v, %v, want the out/ pattern", p, ignored)
+	}
+	p, ignored = m.Match("keep.log", false)
+	if ignored || p == nil || !p.Negated {
+		t.Errorf("Match(keep.log) = %+v, %v, want the negated pattern", p, ignored)
+	}
+}
+
+func TestMatcher_Precedence(t *testing.T) {
+	tmpDir := t.TempDir()
+	files := map[string]string{
+		"":        "*.log\n/top.txt\n",
+		"src":     "!debug.log\nlocal.txt\n",
+		"src/sub": "*.log\n",
+	}
+	read := func(dir string) ([]byte, bool) {
+		content, ok := files[dir]
+		return []byte(content), ok
+	}
+	info := filepath.Join(tmpDir, "exclude")
+	if err := os.WriteFile(info, []byte("*.txt\n!readme.txt\n"), 0644); err != nil {
+		t.Fatalf("Failed to write exclude: %v", err)
+	}
+	excludes := filepath.Join(tmpDir, "ignore")
+	if err := os.WriteFile(excludes, []byte("*.swp\nreadme.txt\n"), 0644); err != nil {
+		t.Fatalf("Failed to write excludes file: %v", err)
+	}
+
+	m := NewMatcher(read, info)
+	if err := m.LoadExcludesFile(excludes); err != nil {
+		t.Fatalf("LoadExcludesFile() error = %v", err)
+	}
+
+	tests := []struct {
+		path   string
+		want   bool
+		source string
+	}{
+		{"debug.log", true, ".gitignore:1: *.log"},
+		// A deeper .gitignore overrides the top one
+		{"src/debug.log", false, "src/.gitignore:1: !debug.log"},
+		{"src/sub/debug.log", true, "src/sub/.gitignore:1: *.log"},
+		{"top.txt", true, ".gitignore:2: /top.txt"},
+		// Patterns are relative to the directory of their file
+		{"src/top.txt", true, info + ":1: *.txt"},
+		{"src/local.txt", true, "src/.gitignore:2: local.txt"},
+		{"local.txt", true, info + ":1: *.txt"},
+		// info/exclude takes precedence over core.excludesFile
+		{"readme.txt", false, info + ":2: !readme.txt"},
+		{"notes.swp", true, excludes + ":1: *.swp"},
+		{"main.go", false, ""},
+	}
+	for _, tt := range tests {
+		p, ignored := m.Match(tt.path, false)
+		if ignored != tt.want {
+			t.Errorf("Match(%q) ignored = %v, want %v", tt.path, ignored, tt.want)
+		}
+		source := ""
+		if p != nil {
+			source = p.Source.String()
+		}
+		if source != tt.source {
+			t.Errorf("Match(%q) source = %q, want %q", tt.path, source, tt.source)
+		}
+	}
+}
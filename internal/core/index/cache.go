@@ -0,0 +1,460 @@
+package index
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/fenilsonani/vcs/internal/core/fault"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+const (
+	// CacheSignature is the signature for status cache files
+	CacheSignature = "VCSC"
+	// CacheVersion is the status cache format version
+	CacheVersion = 1
+)
+
+// Section signatures of the status cache
+const (
+	cacheHeadFiles = "HEAD"
+	cacheStats     = "STAT"
+	cacheUntracked = "DIRS"
+	cacheFSMonitor = "TOKN"
+)
+
+// Cache is what status keeps between runs to save work. It lives in a file
+// of its own under the Git directory rather than in index extensions,
+// which Git would warn about and drop, so the index holds nothing Git
+// does not know. Nothing in it describes the index: the HEAD files are
+// keyed by their commit, and the stat records and directory listings by
+// the stat data of what they describe, so Git rewriting the index leaves
+// the cache valid.
+type Cache struct {
+	// HeadFiles are the files of the commit the index was last compared
+	// with, or nil
+	HeadFiles *HeadFiles
+
+	stats     []StatEntry
+	untracked *UntrackedCache
+	fsmonitor string
+}
+
+// HeadFiles is a copy of the files of a commit, so that comparing the index
+// with it again while HEAD has not moved needs no walk of its trees
+type HeadFiles struct {
+	Commit objects.ObjectID
+	// Entries are the non-tree entries of the commit's tree, sorted by path
+	Entries []HeadEntry
+}
+
+// HeadEntry is a file of the commit of HeadFiles
+type HeadEntry struct {
+	Path string
+	Mode objects.FileMode
+	ID   objects.ObjectID
+}
+
+// StatEntry is a record of the stat cache: the stat data a working
+// tree file had when its content was last hashed, so that status need not
+// read it again while they are unchanged. Unlike the stat data of entries,
+// it covers the files taken from HEAD as well.
+type StatEntry struct {
+	Path  string
+	MTime time.Time
+	Size  int64
+	// ID is the object ID of the file's content as it would be stored
+	ID objects.ObjectID
+}
+
+// UntrackedCache is the listing of each directory of
+// the working tree as last read, so that finding untracked files does not
+// read directories that have not changed
+type UntrackedCache struct {
+	// Dirs are sorted by path, the root directory being ""
+	Dirs []UntrackedDir
+}
+
+// UntrackedDir is the listing of a directory of UntrackedCache
+type UntrackedDir struct {
+	Path string
+	// MTime is the modification time of the directory when it was read,
+	// zero when it changed too recently for the listing to be trusted by
+	// its modification time
+	MTime time.Time
+	// Repo marks the working tree of a nested repository, whose files
+	// are not listed
+	Repo bool
+	// Files and Dirs are the names of the files and subdirectories
+	Files []string
+	Dirs  []string
+}
+
+// Find returns the listing of the directory at path
+func (c *UntrackedCache) Find(path string) (*UntrackedDir, bool) {
+	i := sort.Search(len(c.Dirs), func(i int) bool { return c.Dirs[i].Path >= path })
+	if i < len(c.Dirs) && c.Dirs[i].Path == path {
+		return &c.Dirs[i], true
+	}
+	return nil, false
+}
+
+// StatCache returns the stat cache records, sorted by path
+func (c *Cache) StatCache() []StatEntry {
+	return c.stats
+}
+
+// SetStatCache replaces the stat cache records
+func (c *Cache) SetStatCache(entries []StatEntry) {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	c.stats = entries
+}
+
+// UntrackedCache returns the cached directory listings, or nil
+func (c *Cache) UntrackedCache() *UntrackedCache {
+	return c.untracked
+}
+
+// SetUntrackedCache replaces the cached directory listings, nil to drop them
+func (c *Cache) SetUntrackedCache(listings *UntrackedCache) {
+	if listings != nil {
+		sort.Slice(listings.Dirs, func(i, j int) bool { return listings.Dirs[i].Path < listings.Dirs[j].Path })
+	}
+	c.untracked = listings
+}
+
+// FSMonitorToken returns the token of the filesystem monitor as of the
+// last time the working tree was checked, or ""
+func (c *Cache) FSMonitorToken() string {
+	return c.fsmonitor
+}
+
+// SetFSMonitorToken records the token of the filesystem monitor, "" to
+// drop it
+func (c *Cache) SetFSMonitorToken(token string) {
+	c.fsmonitor = token
+}
+
+// write writes the cache as a header of the signature and version, its
+// sections framed as index extensions are, and a checksum of everything
+// before it
+func (c *Cache) write(w io.Writer) error {
+	header := make([]byte, 8)
+	copy(header, CacheSignature)
+	binary.BigEndian.PutUint32(header[4:], CacheVersion)
+
+	h := sha1.New()
+	mw := io.MultiWriter(w, h)
+	if _, err := mw.Write(header); err != nil {
+		return fmt.Errorf("failed to write header: %w", err)
+	}
+	if c.HeadFiles != nil {
+		if err := writeExtension(mw, cacheHeadFiles, encodeHeadFiles(c.HeadFiles)); err != nil {
+			return err
+		}
+	}
+	if len(c.stats) > 0 {
+		if err := writeExtension(mw, cacheStats, encodeStatCache(c.stats)); err != nil {
+			return err
+		}
+	}
+	if c.untracked != nil {
+		if err := writeExtension(mw, cacheUntracked, encodeUntracked(c.untracked)); err != nil {
+			return err
+		}
+	}
+	if c.fsmonitor != "" {
+		if err := writeExtension(mw, cacheFSMonitor, []byte(c.fsmonitor)); err != nil {
+			return err
+		}
+	}
+	if _, err := w.Write(h.Sum(nil)); err != nil {
+		return fmt.Errorf("failed to write checksum: %w", err)
+	}
+	return nil
+}
+
+// read reads a cache written by write, skipping unknown sections
+func (c *Cache) read(r io.Reader) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("failed to read cache: %w", err)
+	}
+	if len(data) < 8+sha1.Size {
+		return fmt.Errorf("failed to read cache: %w", io.ErrUnexpectedEOF)
+	}
+	if string(data[:4]) != CacheSignature {
+		return fmt.Errorf("invalid cache signature")
+	}
+	if version := binary.BigEndian.Uint32(data[4:8]); version != CacheVersion {
+		return fmt.Errorf("unsupported cache version: %d", version)
+	}
+	body, checksum := data[:len(data)-sha1.Size], data[len(data)-sha1.Size:]
+	if sum := sha1.Sum(body); !bytes.Equal(sum[:], checksum) {
+		return fmt.Errorf("checksum mismatch")
+	}
+
+	*c = Cache{}
+	body = body[8:]
+	for len(body) > 0 {
+		if len(body) < 8 {
+			return fmt.Errorf("truncated cache section")
+		}
+		signature := string(body[:4])
+		size := binary.BigEndian.Uint32(body[4:8])
+		if uint64(size) > uint64(len(body)-8) {
+			return fmt.Errorf("truncated %s section", signature)
+		}
+		payload := body[8 : 8+size]
+		body = body[8+size:]
+
+		switch signature {
+		case cacheHeadFiles:
+			files, err := decodeHeadFiles(payload)
+			if err != nil {
+				return fmt.Errorf("invalid HEAD section: %w", err)
+			}
+			c.HeadFiles = files
+		case cacheStats:
+			entries, err := decodeStatCache(payload)
+			if err != nil {
+				return fmt.Errorf("invalid STAT section: %w", err)
+			}
+			c.stats = entries
+		case cacheUntracked:
+			listings, err := decodeUntracked(payload)
+			if err != nil {
+				return fmt.Errorf("invalid DIRS section: %w", err)
+			}
+			c.untracked = listings
+		case cacheFSMonitor:
+			c.fsmonitor = string(payload)
+		}
+	}
+	return nil
+}
+
+// WriteToFile writes the cache to a file, replacing it atomically
+func (c *Cache) WriteToFile(path string) error {
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".cache-*")
+	if err != nil {
+		return fmt.Errorf("failed to create temp file: %w", err)
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
+
+	bw := bufio.NewWriter(fault.NewWriter(tmp, tmpPath))
+	if err := c.write(bw); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := bw.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write cache: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close temp file: %w", err)
+	}
+	if err := fault.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to rename temp file: %w", err)
+	}
+	return nil
+}
+
+// ReadCacheFile reads the cache file at path, a missing one being empty
+func ReadCacheFile(path string) (*Cache, error) {
+	c := &Cache{}
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open cache file: %w", err)
+	}
+	defer file.Close()
+	if err := c.read(file); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// encodeHeadFiles serializes the commit ID followed by each entry as a tree
+// object would: octal mode, space, path, NUL and the object ID
+func encodeHeadFiles(files *HeadFiles) []byte {
+	var buf bytes.Buffer
+	buf.Write(files.Commit[:])
+	for _, entry := range files.Entries {
+		fmt.Fprintf(&buf, "%o %s\x00", uint32(entry.Mode), entry.Path)
+		buf.Write(entry.ID[:])
+	}
+	return buf.Bytes()
+}
+
+// decodeHeadFiles parses the HEAD section
+func decodeHeadFiles(data []byte) (*HeadFiles, error) {
+	files := &HeadFiles{}
+	if len(data) < len(files.Commit) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	copy(files.Commit[:], data)
+	data = data[len(files.Commit):]
+
+	for len(data) > 0 {
+		space := bytes.IndexByte(data, ' ')
+		nul := bytes.IndexByte(data, 0)
+		if space < 0 || nul < space {
+			return nil, io.ErrUnexpectedEOF
+		}
+		mode, err := strconv.ParseUint(string(data[:space]), 8, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mode %q", data[:space])
+		}
+		entry := HeadEntry{Path: string(data[space+1 : nul]), Mode: objects.FileMode(mode)}
+		data = data[nul+1:]
+		if len(data) < len(entry.ID) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		copy(entry.ID[:], data)
+		data = data[len(entry.ID):]
+		files.Entries = append(files.Entries, entry)
+	}
+	return files, nil
+}
+
+// encodeStatCache serializes each record as its path ended by a NUL, the
+// modification time in nanoseconds and the size as 64-bit integers, and the
+// object ID
+func encodeStatCache(entries []StatEntry) []byte {
+	var buf bytes.Buffer
+	var word [8]byte
+	for _, entry := range entries {
+		buf.WriteString(entry.Path)
+		buf.WriteByte(0)
+		binary.BigEndian.PutUint64(word[:], uint64(entry.MTime.UnixNano()))
+		buf.Write(word[:])
+		binary.BigEndian.PutUint64(word[:], uint64(entry.Size))
+		buf.Write(word[:])
+		buf.Write(entry.ID[:])
+	}
+	return buf.Bytes()
+}
+
+// decodeStatCache parses the STAT section
+func decodeStatCache(data []byte) ([]StatEntry, error) {
+	var entries []StatEntry
+	for len(data) > 0 {
+		nul := bytes.IndexByte(data, 0)
+		if nul <= 0 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		entry := StatEntry{Path: string(data[:nul])}
+		data = data[nul+1:]
+		if len(data) < 16+len(entry.ID) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		entry.MTime = time.Unix(0, int64(binary.BigEndian.Uint64(data)))
+		entry.Size = int64(binary.BigEndian.Uint64(data[8:]))
+		copy(entry.ID[:], data[16:])
+		data = data[16+len(entry.ID):]
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// encodeUntracked serializes each directory as its path ended by a NUL,
+// the modification time in nanoseconds as a 64-bit integer (0 for none), a
+// flags byte, then the number of files and of subdirectories as 32-bit
+// integers followed by their names, each ended by a NUL
+func encodeUntracked(cache *UntrackedCache) []byte {
+	var buf bytes.Buffer
+	var word [8]byte
+	for _, dir := range cache.Dirs {
+		buf.WriteString(dir.Path)
+		buf.WriteByte(0)
+		var mtime int64
+		if !dir.MTime.IsZero() {
+			mtime = dir.MTime.UnixNano()
+		}
+		binary.BigEndian.PutUint64(word[:], uint64(mtime))
+		buf.Write(word[:])
+		var flags byte
+		if dir.Repo {
+			flags |= 1
+		}
+		buf.WriteByte(flags)
+		for _, names := range [][]string{dir.Files, dir.Dirs} {
+			binary.BigEndian.PutUint32(word[:4], uint32(len(names)))
+			buf.Write(word[:4])
+			for _, name := range names {
+				buf.WriteString(name)
+				buf.WriteByte(0)
+			}
+		}
+	}
+	return buf.Bytes()
+}
+
+// decodeUntracked parses the DIRS section
+func decodeUntracked(data []byte) (*UntrackedCache, error) {
+	next := func() (string, error) {
+		nul := bytes.IndexByte(data, 0)
+		if nul < 0 {
+			return "", io.ErrUnexpectedEOF
+		}
+		field := string(data[:nul])
+		data = data[nul+1:]
+		return field, nil
+	}
+	names := func() ([]string, error) {
+		if len(data) < 4 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		count := binary.BigEndian.Uint32(data)
+		data = data[4:]
+		if uint64(count) > uint64(len(data)) {
+			return nil, io.ErrUnexpectedEOF
+		}
+		var list []string
+		for i := uint32(0); i < count; i++ {
+			name, err := next()
+			if err != nil {
+				return nil, err
+			}
+			list = append(list, name)
+		}
+		return list, nil
+	}
+
+	cache := &UntrackedCache{}
+	for len(data) > 0 {
+		path, err := next()
+		if err != nil {
+			return nil, err
+		}
+		if len(data) < 9 {
+			return nil, io.ErrUnexpectedEOF
+		}
+		dir := UntrackedDir{Path: path, Repo: data[8]&1 != 0}
+		if mtime := int64(binary.BigEndian.Uint64(data)); mtime != 0 {
+			dir.MTime = time.Unix(0, mtime)
+		}
+		data = data[9:]
+		if dir.Files, err = names(); err != nil {
+			return nil, err
+		}
+		if dir.Dirs, err = names(); err != nil {
+			return nil, err
+		}
+		cache.Dirs = append(cache.Dirs, dir)
+	}
+	return cache, nil
+}
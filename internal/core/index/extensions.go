@@ -0,0 +1,304 @@
+package index
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+// Extension signatures
+const (
+	extTree        = "TREE"
+	extResolveUndo = "REUC"
+)
+
+// CacheTree is a node of the TREE extension, which caches the tree object
+// of each directory whose entries have not changed since it was written
+type CacheTree struct {
+	// Name is the path component of the directory, empty for the root
+	Name string
+	// EntryCount is the number of index entries the tree covers, or -1
+	// when the tree is invalid and must be written again
+	EntryCount int
+	ID         objects.ObjectID
+	Subtrees   []*CacheTree
+}
+
+// Valid reports whether the cached tree ID can be used
+func (t *CacheTree) Valid() bool {
+	return t.EntryCount >= 0
+}
+
+// Invalidate marks the trees containing path as needing to be written again
+func (t *CacheTree) Invalidate(path string) {
+	for t != nil {
+		t.EntryCount = -1
+		slash := strings.IndexByte(path, '/')
+		if slash < 0 {
+			return
+		}
+		name := path[:slash]
+		path = path[slash+1:]
+		next := t
+		t = nil
+		for _, sub := range next.Subtrees {
+			if sub.Name == name {
+				t = sub
+				break
+			}
+		}
+	}
+}
+
+// Find returns the node of the directory dir, "" being the root, or nil
+func (t *CacheTree) Find(dir string) *CacheTree {
+	if t == nil || dir == "" {
+		return t
+	}
+	for _, name := range strings.Split(dir, "/") {
+		var next *CacheTree
+		for _, sub := range t.Subtrees {
+			if sub.Name == name {
+				next = sub
+				break
+			}
+		}
+		if next == nil {
+			return nil
+		}
+		t = next
+	}
+	return t
+}
+
+// ResolveUndo is a REUC extension record of the conflict stages a path had
+// before it was resolved. Stages 1 to 3 are at positions 0 to 2 and an
+// absent stage has mode 0.
+type ResolveUndo struct {
+	Path  string
+	Modes [3]objects.FileMode
+	IDs   [3]objects.ObjectID
+}
+
+// CacheTree returns the cached trees of the index, or nil
+func (idx *Index) CacheTree() *CacheTree {
+	return idx.tree
+}
+
+// SetCacheTree replaces the cached trees of the index
+func (idx *Index) SetCacheTree(tree *CacheTree) {
+	idx.tree = tree
+}
+
+// ResolveUndo returns the resolve-undo records, sorted by path
+func (idx *Index) ResolveUndo() []*ResolveUndo {
+	return idx.resolveUndo
+}
+
+// ClearResolveUndo forgets the resolve-undo records
+func (idx *Index) ClearResolveUndo() {
+	idx.resolveUndo = nil
+}
+
+// addResolveUndo records undo, replacing any record of the same path
+func (idx *Index) addResolveUndo(undo *ResolveUndo) {
+	i := sort.Search(len(idx.resolveUndo), func(i int) bool {
+		return idx.resolveUndo[i].Path >= undo.Path
+	})
+	if i < len(idx.resolveUndo) && idx.resolveUndo[i].Path == undo.Path {
+		idx.resolveUndo[i] = undo
+		return
+	}
+	idx.resolveUndo = append(idx.resolveUndo, nil)
+	copy(idx.resolveUndo[i+1:], idx.resolveUndo[i:])
+	idx.resolveUndo[i] = undo
+}
+
+// writeExtension writes an extension header and its data
+func writeExtension(w io.Writer, signature string, data []byte) error {
+	header := make([]byte, 8)
+	copy(header, signature)
+	binary.BigEndian.PutUint32(header[4:], uint32(len(data)))
+	if _, err := w.Write(header); err != nil {
+		return fmt.Errorf("failed to write %s extension: %w", signature, err)
+	}
+	if _, err := w.Write(data); err != nil {
+		return fmt.Errorf("failed to write %s extension: %w", signature, err)
+	}
+	return nil
+}
+
+// readExtensions parses the extensions between the entries and the
+// checksum. Unknown extensions whose signature starts with an uppercase
+// letter are optional and skipped.
+func (idx *Index) readExtensions(data []byte) error {
+	for len(data) > 0 {
+		if len(data) < 8 {
+			return fmt.Errorf("truncated index extension")
+		}
+		signature := string(data[:4])
+		size := binary.BigEndian.Uint32(data[4:8])
+		if uint64(size) > uint64(len(data)-8) {
+			return fmt.Errorf("truncated %s extension", signature)
+		}
+		payload := data[8 : 8+size]
+		data = data[8+size:]
+
+		switch signature {
+		case extTree:
+			tree, rest, err := decodeCacheTree(payload)
+			if err != nil {
+				return fmt.Errorf("invalid TREE extension: %w", err)
+			}
+			if len(rest) != 0 {
+				return fmt.Errorf("invalid TREE extension: trailing data")
+			}
+			idx.tree = tree
+		case extResolveUndo:
+			undo, err := decodeResolveUndo(payload)
+			if err != nil {
+				return fmt.Errorf("invalid REUC extension: %w", err)
+			}
+			idx.resolveUndo = undo
+		default:
+			if signature[0] < 'A' || signature[0] > 'Z' {
+				return fmt.Errorf("unsupported index extension: %q", signature)
+			}
+		}
+	}
+	return nil
+}
+
+// encodeCacheTree serializes t and its subtrees depth first
+func encodeCacheTree(t *CacheTree) []byte {
+	var buf bytes.Buffer
+	var encode func(*CacheTree)
+	encode = func(t *CacheTree) {
+		fmt.Fprintf(&buf, "%s\x00%d %d\n", t.Name, t.EntryCount, len(t.Subtrees))
+		if t.Valid() {
+			buf.Write(t.ID[:])
+		}
+		for _, sub := range t.Subtrees {
+			encode(sub)
+		}
+	}
+	encode(t)
+	return buf.Bytes()
+}
+
+// decodeCacheTree parses a node and its subtrees, returning the data after
+// them
+func decodeCacheTree(data []byte) (*CacheTree, []byte, error) {
+	nul := bytes.IndexByte(data, 0)
+	if nul < 0 {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+	t := &CacheTree{Name: string(data[:nul])}
+	data = data[nul+1:]
+
+	newline := bytes.IndexByte(data, '\n')
+	if newline < 0 {
+		return nil, nil, io.ErrUnexpectedEOF
+	}
+	fields := strings.Fields(string(data[:newline]))
+	data = data[newline+1:]
+	if len(fields) != 2 {
+		return nil, nil, fmt.Errorf("invalid counts for %q", t.Name)
+	}
+	count, err := strconv.Atoi(fields[0])
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid entry count for %q", t.Name)
+	}
+	subtrees, err := strconv.Atoi(fields[1])
+	if err != nil || subtrees < 0 {
+		return nil, nil, fmt.Errorf("invalid subtree count for %q", t.Name)
+	}
+	t.EntryCount = count
+
+	if t.Valid() {
+		if len(data) < len(t.ID) {
+			return nil, nil, io.ErrUnexpectedEOF
+		}
+		copy(t.ID[:], data)
+		data = data[len(t.ID):]
+	}
+
+	for i := 0; i < subtrees; i++ {
+		var sub *CacheTree
+		if sub, data, err = decodeCacheTree(data); err != nil {
+			return nil, nil, err
+		}
+		t.Subtrees = append(t.Subtrees, sub)
+	}
+	return t, data, nil
+}
+
+// encodeResolveUndo serializes resolve-undo records
+func encodeResolveUndo(records []*ResolveUndo) []byte {
+	var buf bytes.Buffer
+	for _, undo := range records {
+		buf.WriteString(undo.Path)
+		buf.WriteByte(0)
+		for _, mode := range undo.Modes {
+			buf.WriteString(strconv.FormatUint(uint64(mode), 8))
+			buf.WriteByte(0)
+		}
+		for i, mode := range undo.Modes {
+			if mode != 0 {
+				buf.Write(undo.IDs[i][:])
+			}
+		}
+	}
+	return buf.Bytes()
+}
+
+// decodeResolveUndo parses resolve-undo records
+func decodeResolveUndo(data []byte) ([]*ResolveUndo, error) {
+	var records []*ResolveUndo
+	next := func() (string, error) {
+		nul := bytes.IndexByte(data, 0)
+		if nul < 0 {
+			return "", io.ErrUnexpectedEOF
+		}
+		field := string(data[:nul])
+		data = data[nul+1:]
+		return field, nil
+	}
+
+	for len(data) > 0 {
+		path, err := next()
+		if err != nil {
+			return nil, err
+		}
+		undo := &ResolveUndo{Path: path}
+		for i := range undo.Modes {
+			field, err := next()
+			if err != nil {
+				return nil, err
+			}
+			mode, err := strconv.ParseUint(field, 8, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid mode %q for %s", field, path)
+			}
+			undo.Modes[i] = objects.FileMode(mode)
+		}
+		for i, mode := range undo.Modes {
+			if mode == 0 {
+				continue
+			}
+			if len(data) < len(undo.IDs[i]) {
+				return nil, io.ErrUnexpectedEOF
+			}
+			copy(undo.IDs[i][:], data)
+			data = data[len(undo.IDs[i]):]
+		}
+		records = append(records, undo)
+	}
+	return records, nil
+}
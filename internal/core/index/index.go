@@ -1,6 +1,7 @@
 package index
 
 import (
+	"bufio"
 	"bytes"
 	"crypto/sha1"
 	"encoding/binary"
@@ -11,14 +12,17 @@ import (
 	"sort"
 	"time"
 
+	"github.com/fenilsonani/vcs/internal/core/fault"
 	"github.com/fenilsonani/vcs/internal/core/objects"
 )
 
 const (
 	// IndexSignature is the signature for index files
 	IndexSignature = "DIRC"
-	// IndexVersion is the current index format version
+	// IndexVersion is the default index format version
 	IndexVersion = 2
+	// MaxIndexVersion is the newest index format version supported
+	MaxIndexVersion = 4
 	// EntrySize is the minimum size of an index entry
 	EntrySize = 62
 )
@@ -31,6 +35,12 @@ const (
 	FlagNameMask    = 0x0FFF
 )
 
+// Extended flags of version 3 entries
+const (
+	ExtFlagSkipWorktree = 0x4000
+	ExtFlagIntentToAdd  = 0x2000
+)
+
 // Entry represents a single entry in the index
 type Entry struct {
 	CTime     time.Time
@@ -60,9 +70,11 @@ func (e *Entry) SetStage(stage int) {
 
 // Index represents the git index (staging area)
 type Index struct {
-	version int32
-	entries []*Entry
-	cache   map[string]*Entry
+	version     int32
+	entries     []*Entry
+	cache       map[string]*Entry
+	tree        *CacheTree
+	resolveUndo []*ResolveUndo
 }
 
 // New creates a new empty index
@@ -79,6 +91,15 @@ func (idx *Index) Version() int32 {
 	return idx.version
 }
 
+// SetVersion sets the format version the index is written in
+func (idx *Index) SetVersion(version int32) error {
+	if version < 2 || version > MaxIndexVersion {
+		return fmt.Errorf("unsupported index version: %d", version)
+	}
+	idx.version = version
+	return nil
+}
+
 // Entries returns all entries in the index
 func (idx *Index) Entries() []*Entry {
 	return idx.entries
@@ -92,15 +113,36 @@ func (idx *Index) Add(entry *Entry) error {
 
 	// Update cache
 	idx.cache[entry.Path] = entry
+	idx.tree.Invalidate(entry.Path)
 
-	// Find existing entry
+	// Find existing entry. A resolved (stage 0) entry replaces the conflict
+	// stages of its path, which are remembered for resolve-undo, and a
+	// conflict stage replaces the resolved entry.
 	found := false
-	for i, e := range idx.entries {
+	stage := entry.Stage()
+	var undo *ResolveUndo
+	kept := idx.entries[:0]
+	for _, e := range idx.entries {
 		if e.Path == entry.Path {
-			idx.entries[i] = entry
-			found = true
-			break
+			if e.Stage() == stage {
+				e = entry
+				found = true
+			} else if stage == 0 || e.Stage() == 0 {
+				if stage == 0 {
+					if undo == nil {
+						undo = &ResolveUndo{Path: entry.Path}
+					}
+					undo.Modes[e.Stage()-1] = e.Mode
+					undo.IDs[e.Stage()-1] = e.ID
+				}
+				continue
+			}
 		}
+		kept = append(kept, e)
+	}
+	idx.entries = kept
+	if undo != nil {
+		idx.addResolveUndo(undo)
 	}
 
 	if !found {
@@ -115,15 +157,23 @@ func (idx *Index) Add(entry *Entry) error {
 // Remove removes an entry from the index
 func (idx *Index) Remove(path string) error {
 	delete(idx.cache, path)
+	idx.tree.Invalidate(path)
 
-	for i, e := range idx.entries {
+	found := false
+	kept := idx.entries[:0]
+	for _, e := range idx.entries {
 		if e.Path == path {
-			idx.entries = append(idx.entries[:i], idx.entries[i+1:]...)
-			return nil
+			found = true
+			continue
 		}
+		kept = append(kept, e)
 	}
+	idx.entries = kept
 
-	return fmt.Errorf("entry not found: %s", path)
+	if !found {
+		return fmt.Errorf("entry not found: %s", path)
+	}
+	return nil
 }
 
 // Get returns an entry by path
@@ -132,16 +182,35 @@ func (idx *Index) Get(path string) (*Entry, bool) {
 	return entry, ok
 }
 
+// Unmerged returns the paths that have conflict stages, sorted
+func (idx *Index) Unmerged() []string {
+	var paths []string
+	for _, e := range idx.entries {
+		if e.Stage() == 0 {
+			continue
+		}
+		if len(paths) == 0 || paths[len(paths)-1] != e.Path {
+			paths = append(paths, e.Path)
+		}
+	}
+	return paths
+}
+
 // Clear removes all entries from the index
 func (idx *Index) Clear() {
 	idx.entries = idx.entries[:0]
 	idx.cache = make(map[string]*Entry)
+	idx.tree = nil
+	idx.resolveUndo = nil
 }
 
-// sort sorts entries by path
+// sort sorts entries by path and stage
 func (idx *Index) sort() {
-	sort.Slice(idx.entries, func(i, j int) bool {
-		return idx.entries[i].Path < idx.entries[j].Path
+	sort.SliceStable(idx.entries, func(i, j int) bool {
+		if idx.entries[i].Path != idx.entries[j].Path {
+			return idx.entries[i].Path < idx.entries[j].Path
+		}
+		return idx.entries[i].Stage() < idx.entries[j].Stage()
 	})
 }
 
@@ -150,6 +219,16 @@ func (idx *Index) WriteTo(w io.Writer) error {
 	// Sort entries before writing
 	idx.sort()
 
+	// Skip-worktree and intent-to-add need the extended flags of version 3
+	if idx.version < 3 {
+		for _, entry := range idx.entries {
+			if entry.SkipWorktree || entry.IntentToAdd {
+				idx.version = 3
+				break
+			}
+		}
+	}
+
 	// Write header
 	header := make([]byte, 12)
 	copy(header[0:4], IndexSignature)
@@ -168,10 +247,24 @@ func (idx *Index) WriteTo(w io.Writer) error {
 	h.Write(header)
 
 	// Write entries
+	previous := ""
 	for _, entry := range idx.entries {
-		if err := idx.writeEntry(mw, entry); err != nil {
+		if err := idx.writeEntry(mw, entry, previous); err != nil {
 			return fmt.Errorf("failed to write entry: %w", err)
 		}
+		previous = entry.Path
+	}
+
+	// Write extensions
+	if idx.tree != nil {
+		if err := writeExtension(mw, extTree, encodeCacheTree(idx.tree)); err != nil {
+			return err
+		}
+	}
+	if len(idx.resolveUndo) > 0 {
+		if err := writeExtension(mw, extResolveUndo, encodeResolveUndo(idx.resolveUndo)); err != nil {
+			return err
+		}
 	}
 
 	// Write checksum
@@ -183,8 +276,9 @@ func (idx *Index) WriteTo(w io.Writer) error {
 	return nil
 }
 
-// writeEntry writes a single entry
-func (idx *Index) writeEntry(w io.Writer, entry *Entry) error {
+// writeEntry writes a single entry. Version 4 stores the path as the
+// number of bytes to drop from the end of previous and the suffix to append.
+func (idx *Index) writeEntry(w io.Writer, entry *Entry, previous string) error {
 	// Create entry buffer
 	buf := new(bytes.Buffer)
 
@@ -202,21 +296,45 @@ func (idx *Index) writeEntry(w io.Writer, entry *Entry) error {
 	buf.Write(entry.ID[:])
 
 	// Calculate flags
-	flags := entry.Flags
+	flags := entry.Flags &^ FlagExtended
 	nameLen := len(entry.Path)
 	if nameLen > FlagNameMask {
 		nameLen = FlagNameMask
 	}
 	flags = (flags &^ FlagNameMask) | uint16(nameLen)
-	binary.Write(buf, binary.BigEndian, flags)
+
+	var extended uint16
+	if entry.SkipWorktree {
+		extended |= ExtFlagSkipWorktree
+	}
+	if entry.IntentToAdd {
+		extended |= ExtFlagIntentToAdd
+	}
+	if extended != 0 && idx.version >= 3 {
+		binary.Write(buf, binary.BigEndian, flags|FlagExtended)
+		binary.Write(buf, binary.BigEndian, extended)
+	} else {
+		binary.Write(buf, binary.BigEndian, flags)
+	}
+
+	if idx.version >= 4 {
+		common := 0
+		for common < len(previous) && common < len(entry.Path) && previous[common] == entry.Path[common] {
+			common++
+		}
+		buf.Write(encodeVarint(uint64(len(previous) - common)))
+		buf.WriteString(entry.Path[common:])
+		buf.WriteByte(0)
+		_, err := w.Write(buf.Bytes())
+		return err
+	}
 
 	// Write path
 	buf.WriteString(entry.Path)
 	buf.WriteByte(0) // null terminator
 
 	// Pad to 8-byte boundary
-	entrySize := EntrySize + len(entry.Path) + 1
-	padding := (8 - (entrySize % 8)) % 8
+	padding := (8 - (buf.Len() % 8)) % 8
 	for i := 0; i < padding; i++ {
 		buf.WriteByte(0)
 	}
@@ -247,41 +365,49 @@ func (idx *Index) ReadFrom(r io.Reader) error {
 	// Read entry count
 	entryCount := binary.BigEndian.Uint32(header[8:12])
 
-	// Hash reader to verify checksum
-	h := sha1.New()
-	h.Write(header)
-	tr := io.TeeReader(r, h)
+	// The rest is entries, extensions and the checksum of everything before it
+	rest, err := io.ReadAll(r)
+	if err != nil {
+		return fmt.Errorf("failed to read index: %w", err)
+	}
+	if len(rest) < sha1.Size {
+		return fmt.Errorf("failed to read checksum: %w", io.ErrUnexpectedEOF)
+	}
+	body, expectedChecksum := rest[:len(rest)-sha1.Size], rest[len(rest)-sha1.Size:]
 
 	// Clear existing entries
 	idx.Clear()
 
 	// Read entries
+	br := bytes.NewReader(body)
+	previous := ""
 	for i := uint32(0); i < entryCount; i++ {
-		entry, err := idx.readEntry(tr)
+		entry, err := idx.readEntry(br, previous)
 		if err != nil {
 			return fmt.Errorf("failed to read entry %d: %w", i, err)
 		}
 		idx.entries = append(idx.entries, entry)
 		idx.cache[entry.Path] = entry
+		previous = entry.Path
 	}
 
-	// Read and verify checksum
-	expectedChecksum := make([]byte, 20)
-	if _, err := io.ReadFull(r, expectedChecksum); err != nil {
-		return fmt.Errorf("failed to read checksum: %w", err)
-	}
-
-	actualChecksum := h.Sum(nil)
-	if !bytes.Equal(expectedChecksum, actualChecksum) {
+	// Verify checksum
+	h := sha1.New()
+	h.Write(header)
+	h.Write(body)
+	if !bytes.Equal(expectedChecksum, h.Sum(nil)) {
 		return fmt.Errorf("checksum mismatch")
 	}
 
-	return nil
+	// Read extensions
+	return idx.readExtensions(body[len(body)-br.Len():])
 }
 
-// readEntry reads a single entry
-func (idx *Index) readEntry(r io.Reader) (*Entry, error) {
+// readEntry reads a single entry, decompressing its path against previous
+// in version 4
+func (idx *Index) readEntry(r *bytes.Reader, previous string) (*Entry, error) {
 	entry := &Entry{}
+	start := r.Len()
 
 	// Read fixed-size fields
 	var cTimeSec, cTimeNsec uint32
@@ -298,61 +424,122 @@ func (idx *Index) readEntry(r io.Reader) (*Entry, error) {
 	binary.Read(r, binary.BigEndian, &entry.UID)
 	binary.Read(r, binary.BigEndian, &entry.GID)
 	binary.Read(r, binary.BigEndian, &entry.Size)
-	
+
 	if _, err := io.ReadFull(r, entry.ID[:]); err != nil {
 		return nil, err
 	}
-	
-	binary.Read(r, binary.BigEndian, &entry.Flags)
+
+	if err := binary.Read(r, binary.BigEndian, &entry.Flags); err != nil {
+		return nil, err
+	}
 
 	entry.CTime = time.Unix(int64(cTimeSec), int64(cTimeNsec))
 	entry.MTime = time.Unix(int64(mTimeSec), int64(mTimeNsec))
 	entry.Mode = objects.FileMode(mode)
 
+	// Extended flags follow the flags in version 3 and later
+	if entry.Flags&FlagExtended != 0 {
+		if idx.version < 3 {
+			return nil, fmt.Errorf("extended flags in a version %d index", idx.version)
+		}
+		var extended uint16
+		if err := binary.Read(r, binary.BigEndian, &extended); err != nil {
+			return nil, err
+		}
+		entry.SkipWorktree = extended&ExtFlagSkipWorktree != 0
+		entry.IntentToAdd = extended&ExtFlagIntentToAdd != 0
+		entry.Flags &^= FlagExtended
+	}
+
+	if idx.version >= 4 {
+		strip, err := decodeVarint(r)
+		if err != nil {
+			return nil, err
+		}
+		if strip > uint64(len(previous)) {
+			return nil, fmt.Errorf("invalid path prefix length %d", strip)
+		}
+		suffix, err := readCString(r)
+		if err != nil {
+			return nil, err
+		}
+		entry.Path = previous[:len(previous)-int(strip)] + suffix
+		return entry, nil
+	}
+
 	// Read path
 	nameLen := int(entry.Flags & FlagNameMask)
 	if nameLen == FlagNameMask {
 		// Long path, read until null
-		var pathBuf bytes.Buffer
-		for {
-			b := make([]byte, 1)
-			if _, err := r.Read(b); err != nil {
-				return nil, err
-			}
-			if b[0] == 0 {
-				break
-			}
-			pathBuf.WriteByte(b[0])
+		path, err := readCString(r)
+		if err != nil {
+			return nil, err
 		}
-		entry.Path = pathBuf.String()
-		nameLen = len(entry.Path) + 1
+		entry.Path = path
 	} else {
 		// Normal path
-		pathBuf := make([]byte, nameLen)
+		pathBuf := make([]byte, nameLen+1)
 		if _, err := io.ReadFull(r, pathBuf); err != nil {
 			return nil, err
 		}
-		entry.Path = string(pathBuf)
-		
-		// Read null terminator
-		if _, err := r.Read(make([]byte, 1)); err != nil {
-			return nil, err
-		}
-		nameLen++
+		entry.Path = string(pathBuf[:nameLen])
 	}
 
 	// Skip padding
-	entrySize := EntrySize + nameLen
-	padding := (8 - (entrySize % 8)) % 8
-	if padding > 0 {
-		if _, err := r.Read(make([]byte, padding)); err != nil {
-			return nil, err
-		}
+	padding := (8 - ((start - r.Len()) % 8)) % 8
+	if padding > r.Len() {
+		return nil, io.ErrUnexpectedEOF
 	}
+	r.Seek(int64(padding), io.SeekCurrent)
 
 	return entry, nil
 }
 
+// readCString reads bytes up to and excluding a NUL terminator
+func readCString(r *bytes.Reader) (string, error) {
+	var buf bytes.Buffer
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return "", io.ErrUnexpectedEOF
+		}
+		if b == 0 {
+			return buf.String(), nil
+		}
+		buf.WriteByte(b)
+	}
+}
+
+// encodeVarint encodes n in the offset encoding version 4 uses for path
+// prefix lengths, where each continuation also adds one
+func encodeVarint(n uint64) []byte {
+	var buf [16]byte
+	pos := len(buf) - 1
+	buf[pos] = byte(n & 0x7f)
+	for n >>= 7; n != 0; n >>= 7 {
+		n--
+		pos--
+		buf[pos] = 0x80 | byte(n&0x7f)
+	}
+	return buf[pos:]
+}
+
+// decodeVarint decodes a value written by encodeVarint
+func decodeVarint(r *bytes.Reader) (uint64, error) {
+	b, err := r.ReadByte()
+	if err != nil {
+		return 0, io.ErrUnexpectedEOF
+	}
+	n := uint64(b & 0x7f)
+	for b&0x80 != 0 {
+		if b, err = r.ReadByte(); err != nil {
+			return 0, io.ErrUnexpectedEOF
+		}
+		n = ((n + 1) << 7) | uint64(b&0x7f)
+	}
+	return n, nil
+}
+
 // WriteToFile writes the index to a file
 func (idx *Index) WriteToFile(path string) error {
 	// Create temporary file
@@ -365,17 +552,22 @@ func (idx *Index) WriteToFile(path string) error {
 	defer os.Remove(tmpPath)
 
 	// Write index
-	if err := idx.WriteTo(tmp); err != nil {
+	bw := bufio.NewWriter(fault.NewWriter(tmp, tmpPath))
+	if err := idx.WriteTo(bw); err != nil {
 		tmp.Close()
 		return err
 	}
+	if err := bw.Flush(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write index: %w", err)
+	}
 
 	if err := tmp.Close(); err != nil {
 		return fmt.Errorf("failed to close temp file: %w", err)
 	}
 
 	// Atomically replace
-	if err := os.Rename(tmpPath, path); err != nil {
+	if err := fault.Rename(tmpPath, path); err != nil {
 		return fmt.Errorf("failed to rename temp file: %w", err)
 	}
 
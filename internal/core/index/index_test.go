@@ -2,8 +2,10 @@ package index
 
 import (
 	"bytes"
+	"crypto/sha1"
 	"os"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -208,6 +210,47 @@ func TestIndex_Sort(t *testing.T) {
 	}
 }
 
+func TestIndex_ConflictStages(t *testing.T) {
+	idx := New()
+	idx.Add(&Entry{Path: "a.txt"})
+	idx.Add(&Entry{Path: "b.txt"})
+
+	for stage := 3; stage >= 1; stage-- {
+		entry := &Entry{Path: "b.txt", ID: objects.ObjectID{byte(stage)}}
+		entry.SetStage(stage)
+		idx.Add(entry)
+	}
+
+	entries := idx.Entries()
+	if len(entries) != 4 {
+		t.Fatalf("entries length = %v, want 4", len(entries))
+	}
+	for i, stage := range []int{0, 1, 2, 3} {
+		if entries[i].Stage() != stage {
+			t.Errorf("entries[%d].Stage() = %v, want %v", i, entries[i].Stage(), stage)
+		}
+	}
+	if got := idx.Unmerged(); len(got) != 1 || got[0] != "b.txt" {
+		t.Errorf("Unmerged() = %v, want [b.txt]", got)
+	}
+
+	// Adding the resolved file replaces its conflict stages
+	idx.Add(&Entry{Path: "b.txt", ID: objects.ObjectID{9}})
+	if len(idx.Entries()) != 2 || len(idx.Unmerged()) != 0 {
+		t.Errorf("after resolving: entries = %v, unmerged = %v", len(idx.Entries()), idx.Unmerged())
+	}
+
+	entry := &Entry{Path: "a.txt"}
+	entry.SetStage(2)
+	idx.Add(entry)
+	if err := idx.Remove("a.txt"); err != nil {
+		t.Fatalf("Remove() error = %v", err)
+	}
+	if len(idx.Entries()) != 1 {
+		t.Errorf("Remove() left %v entries, want 1", len(idx.Entries()))
+	}
+}
+
 func TestIndex_WriteToAndReadFrom(t *testing.T) {
 	// Create index with entries
 	idx1 := New()
@@ -529,4 +572,296 @@ func TestIndex_InvalidData(t *testing.T) {
 			}
 		})
 	}
-}
\ No newline at end of file
+}
+
+func roundTrip(t *testing.T, idx *Index) (*Index, []byte) {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := idx.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo() error = %v", err)
+	}
+	data := buf.Bytes()
+	read := New()
+	if err := read.ReadFrom(bytes.NewReader(data)); err != nil {
+		t.Fatalf("ReadFrom() error = %v", err)
+	}
+	return read, data
+}
+
+func TestIndex_Version4PathCompression(t *testing.T) {
+	idx := New()
+	if err := idx.SetVersion(4); err != nil {
+		t.Fatalf("SetVersion() error = %v", err)
+	}
+	paths := []string{"src/a/x.txt", "src/a/y.txt", "src/b/z.txt", "README"}
+	for i, path := range paths {
+		idx.Add(&Entry{Path: path, Mode: objects.ModeBlob, ID: objects.ObjectID{byte(i + 1)}})
+	}
+
+	read, data := roundTrip(t, idx)
+	if read.Version() != 4 {
+		t.Errorf("Version() = %d, want 4", read.Version())
+	}
+	for i, path := range paths {
+		entry, ok := read.Get(path)
+		if !ok || entry.ID != (objects.ObjectID{byte(i + 1)}) {
+			t.Errorf("Get(%q) = %v, %v", path, entry, ok)
+		}
+	}
+
+	// src/a/y.txt drops "x.txt" from src/a/x.txt and appends "y.txt"
+	if !bytes.Contains(data, []byte("\x05y.txt\x00")) {
+		t.Errorf("version 4 index does not compress src/a/y.txt")
+	}
+	if bytes.Contains(data, []byte("src/a/y.txt")) {
+		t.Errorf("version 4 index stores src/a/y.txt in full")
+	}
+
+	if err := idx.SetVersion(5); err == nil {
+		t.Errorf("SetVersion(5) should fail")
+	}
+}
+
+func TestIndex_Varint(t *testing.T) {
+	for _, n := range []uint64{0, 1, 127, 128, 255, 16511, 16512, 1 << 20} {
+		got, err := decodeVarint(bytes.NewReader(encodeVarint(n)))
+		if err != nil || got != n {
+			t.Errorf("decodeVarint(encodeVarint(%d)) = %d, %v", n, got, err)
+		}
+	}
+	if got := encodeVarint(128); !bytes.Equal(got, []byte{0x80, 0x00}) {
+		t.Errorf("encodeVarint(128) = %x, want 8000", got)
+	}
+}
+
+func TestIndex_ExtendedFlags(t *testing.T) {
+	idx := New()
+	idx.Add(&Entry{Path: "a.txt", Mode: objects.ModeBlob, SkipWorktree: true})
+	idx.Add(&Entry{Path: "b.txt", Mode: objects.ModeBlob, IntentToAdd: true})
+	idx.Add(&Entry{Path: "c.txt", Mode: objects.ModeBlob})
+
+	read, _ := roundTrip(t, idx)
+	if read.Version() != 3 {
+		t.Errorf("Version() = %d, want 3 for extended flags", read.Version())
+	}
+	a, _ := read.Get("a.txt")
+	b, _ := read.Get("b.txt")
+	c, _ := read.Get("c.txt")
+	if !a.SkipWorktree || a.IntentToAdd || b.SkipWorktree || !b.IntentToAdd || c.SkipWorktree || c.IntentToAdd {
+		t.Errorf("extended flags not preserved: %+v %+v %+v", a, b, c)
+	}
+	if a.Flags&FlagExtended != 0 {
+		t.Errorf("Flags = %04x, extended bit should not be kept", a.Flags)
+	}
+}
+
+func TestIndex_CacheTree(t *testing.T) {
+	idx := New()
+	for _, path := range []string{"README", "src/a/x.txt", "src/b/y.txt"} {
+		idx.Add(&Entry{Path: path, Mode: objects.ModeBlob})
+	}
+	idx.SetCacheTree(&CacheTree{EntryCount: 3, ID: objects.ObjectID{1}, Subtrees: []*CacheTree{
+		{Name: "src", EntryCount: 2, ID: objects.ObjectID{2}, Subtrees: []*CacheTree{
+			{Name: "a", EntryCount: 1, ID: objects.ObjectID{3}},
+			{Name: "b", EntryCount: 1, ID: objects.ObjectID{4}},
+		}},
+	}})
+
+	read, _ := roundTrip(t, idx)
+	tree := read.CacheTree()
+	if tree == nil || tree.ID != (objects.ObjectID{1}) || tree.EntryCount != 3 {
+		t.Fatalf("CacheTree() = %+v", tree)
+	}
+	if b := tree.Find("src/b"); b == nil || b.ID != (objects.ObjectID{4}) {
+		t.Errorf("Find(src/b) = %+v", b)
+	}
+	if tree.Find("src/c") != nil {
+		t.Errorf("Find(src/c) should be nil")
+	}
+
+	// Changing src/a/x.txt invalidates the root, src and src/a only
+	read.Add(&Entry{Path: "src/a/x.txt", Mode: objects.ModeBlob, ID: objects.ObjectID{9}})
+	for dir, valid := range map[string]bool{"": false, "src": false, "src/a": false, "src/b": true} {
+		if got := tree.Find(dir).Valid(); got != valid {
+			t.Errorf("Find(%q).Valid() = %v, want %v", dir, got, valid)
+		}
+	}
+
+	// Invalid trees are written without an ID
+	read, _ = roundTrip(t, read)
+	if tree = read.CacheTree(); tree.Valid() || !tree.Find("src/b").Valid() {
+		t.Errorf("CacheTree() after invalidation = %+v", tree)
+	}
+
+	read.Clear()
+	if read.CacheTree() != nil {
+		t.Errorf("Clear() should drop the cache tree")
+	}
+}
+
+func TestIndex_ResolveUndo(t *testing.T) {
+	idx := New()
+	// Added on both sides, so there is no base stage
+	for stage := 2; stage <= 3; stage++ {
+		entry := &Entry{Path: "f.txt", Mode: objects.ModeBlob, ID: objects.ObjectID{byte(stage)}}
+		entry.SetStage(stage)
+		idx.Add(entry)
+	}
+	idx.Add(&Entry{Path: "f.txt", Mode: objects.ModeBlob, ID: objects.ObjectID{9}})
+
+	read, _ := roundTrip(t, idx)
+	undo := read.ResolveUndo()
+	if len(undo) != 1 || undo[0].Path != "f.txt" {
+		t.Fatalf("ResolveUndo() = %+v", undo)
+	}
+	if undo[0].Modes != [3]objects.FileMode{0, objects.ModeBlob, objects.ModeBlob} {
+		t.Errorf("Modes = %v", undo[0].Modes)
+	}
+	if undo[0].IDs[1] != (objects.ObjectID{2}) || undo[0].IDs[2] != (objects.ObjectID{3}) {
+		t.Errorf("IDs = %v", undo[0].IDs)
+	}
+	if len(read.Unmerged()) != 0 {
+		t.Errorf("Unmerged() = %v", read.Unmerged())
+	}
+
+	read.ClearResolveUndo()
+	if read, _ = roundTrip(t, read); len(read.ResolveUndo()) != 0 {
+		t.Errorf("ClearResolveUndo() left %+v", read.ResolveUndo())
+	}
+}
+
+func TestCache_HeadFiles(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache")
+	cache, err := ReadCacheFile(path)
+	if err != nil || cache.HeadFiles != nil {
+		t.Fatalf("ReadCacheFile() of a missing file = %+v, %v", cache, err)
+	}
+
+	files := &HeadFiles{Commit: objects.ObjectID{7}, Entries: []HeadEntry{
+		{Path: "README", Mode: objects.ModeBlob, ID: objects.ObjectID{1}},
+		{Path: "bin/run", Mode: objects.ModeExec, ID: objects.ObjectID{2}},
+		{Path: "lib", Mode: objects.ModeCommit, ID: objects.ObjectID{3}},
+	}}
+	cache.HeadFiles = files
+	if err := cache.WriteToFile(path); err != nil {
+		t.Fatalf("WriteToFile() error = %v", err)
+	}
+	read, err := ReadCacheFile(path)
+	if err != nil {
+		t.Fatalf("ReadCacheFile() error = %v", err)
+	}
+	if !reflect.DeepEqual(read.HeadFiles, files) {
+		t.Errorf("HeadFiles = %+v, want %+v", read.HeadFiles, files)
+	}
+
+	// A commit without files keeps its ID
+	read.HeadFiles = &HeadFiles{Commit: objects.ObjectID{8}}
+	if err := read.WriteToFile(path); err != nil {
+		t.Fatalf("WriteToFile() error = %v", err)
+	}
+	if read, err = ReadCacheFile(path); err != nil || read.HeadFiles == nil || read.HeadFiles.Commit != (objects.ObjectID{8}) {
+		t.Errorf("HeadFiles of an empty commit = %+v, %v", read.HeadFiles, err)
+	}
+
+	// A damaged cache is an error rather than wrong files
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[len(data)-1] ^= 0xff
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ReadCacheFile(path); err == nil || !strings.Contains(err.Error(), "checksum mismatch") {
+		t.Errorf("ReadCacheFile() of a damaged file error = %v", err)
+	}
+}
+
+func TestIndex_SkipsHeadExtension(t *testing.T) {
+	// Indexes written before the HEAD files moved out still read
+	idx := New()
+	idx.Add(&Entry{Path: "README", Mode: objects.ModeBlob, ID: objects.ObjectID{1}})
+	var buf bytes.Buffer
+	if err := idx.WriteTo(&buf); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()[:buf.Len()-sha1.Size]
+	data = append(data, "HEAD\x00\x00\x00\x14"...)
+	data = append(data, make([]byte, 20)...)
+	sum := sha1.Sum(data)
+	data = append(data, sum[:]...)
+
+	read := New()
+	if err := read.ReadFrom(bytes.NewReader(data)); err != nil {
+		t.Fatalf("ReadFrom() error = %v", err)
+	}
+	if len(read.Entries()) != 1 {
+		t.Errorf("Entries() = %+v", read.Entries())
+	}
+}
+
+func TestCache_WorkingTreeCaches(t *testing.T) {
+	cache := &Cache{}
+	mtime := time.Unix(1700000000, 123456789)
+	cache.SetStatCache([]StatEntry{
+		{Path: "z.txt", MTime: mtime, Size: 12, ID: objects.ObjectID{2}},
+		{Path: "a.txt", MTime: mtime, Size: 0, ID: objects.ObjectID{1}},
+	})
+	cache.SetUntrackedCache(&UntrackedCache{Dirs: []UntrackedDir{
+		{Path: "src", MTime: mtime, Files: []string{"main.go"}},
+		{Path: "", Files: []string{"a.txt", "z.txt"}, Dirs: []string{"src", "vendor"}},
+		{Path: "vendor", MTime: mtime, Repo: true},
+	}})
+	cache.SetFSMonitorToken("c:1700000000:42")
+
+	path := filepath.Join(t.TempDir(), "cache")
+	if err := cache.WriteToFile(path); err != nil {
+		t.Fatalf("WriteToFile() error = %v", err)
+	}
+	read, err := ReadCacheFile(path)
+	if err != nil {
+		t.Fatalf("ReadCacheFile() error = %v", err)
+	}
+	stats := read.StatCache()
+	if len(stats) != 2 || stats[0].Path != "a.txt" || !stats[1].MTime.Equal(mtime) || stats[1].Size != 12 || stats[1].ID != (objects.ObjectID{2}) {
+		t.Errorf("StatCache() = %+v", stats)
+	}
+	got := read.UntrackedCache()
+	if got == nil || len(got.Dirs) != 3 {
+		t.Fatalf("UntrackedCache() = %+v", got)
+	}
+	if root, ok := got.Find(""); !ok || !root.MTime.IsZero() || !reflect.DeepEqual(root.Dirs, []string{"src", "vendor"}) {
+		t.Errorf("Find(\"\") = %+v, %v", root, ok)
+	}
+	if vendor, ok := got.Find("vendor"); !ok || !vendor.Repo || !vendor.MTime.Equal(mtime) || len(vendor.Files) != 0 {
+		t.Errorf("Find(vendor) = %+v, %v", vendor, ok)
+	}
+	if _, ok := got.Find("missing"); ok {
+		t.Errorf("Find(missing) found a directory")
+	}
+	if token := read.FSMonitorToken(); token != "c:1700000000:42" {
+		t.Errorf("FSMonitorToken() = %q", token)
+	}
+}
+
+func TestIndex_UnknownExtensions(t *testing.T) {
+	build := func(signature string) []byte {
+		var buf bytes.Buffer
+		if err := New().WriteTo(&buf); err != nil {
+			t.Fatal(err)
+		}
+		body := buf.Bytes()[:buf.Len()-20]
+		body = append(body, signature...)
+		body = append(body, 0, 0, 0, 2, 'h', 'i')
+		sum := sha1.Sum(body)
+		return append(body, sum[:]...)
+	}
+
+	if err := New().ReadFrom(bytes.NewReader(build("UNTR"))); err != nil {
+		t.Errorf("ReadFrom() with an optional extension error = %v", err)
+	}
+	err := New().ReadFrom(bytes.NewReader(build("link")))
+	if err == nil || !strings.Contains(err.Error(), "unsupported index extension") {
+		t.Errorf("ReadFrom() with a mandatory extension error = %v", err)
+	}
+}
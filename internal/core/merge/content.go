@@ -0,0 +1,266 @@
+package merge
+
+import (
+	"bytes"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// Conflict marker lines written around unresolved regions
+const (
+	markerOurs   = "<<<<<<<"
+	markerBase   = "|||||||"
+	markerSep    = "======="
+	markerTheirs = ">>>>>>>"
+)
+
+// binaryProbe is how much of a file is checked for NUL bytes
+const binaryProbe = 8000
+
+// hunk is a changed region of one side relative to the base
+type hunk struct {
+	baseStart, baseEnd int
+	sideStart, sideEnd int
+	theirs             bool
+}
+
+// MergeContent performs a line-based three-way merge of ours and theirs
+// against their common base. Regions changed differently on both sides are
+// wrapped in conflict markers in opts.Style and clean is false, unless
+// opts.Favor picks a side for them. Binary content is never merged; ours is
+// returned as a conflict unless one side is unchanged or favored.
+func MergeContent(base, ours, theirs []byte, opts Options) (merged []byte, clean bool) {
+	switch {
+	case bytes.Equal(ours, theirs), bytes.Equal(base, theirs):
+		return ours, true
+	case bytes.Equal(base, ours):
+		return theirs, true
+	case isBinary(base) || isBinary(ours) || isBinary(theirs):
+		if opts.Favor == FavorTheirs {
+			return theirs, true
+		}
+		return ours, opts.Favor == FavorOurs
+	}
+
+	o, a, b := splitLines(base), splitLines(ours), splitLines(theirs)
+
+	// Lines are compared by key, which ignores whitespace changes when asked
+	// to; the original lines are what gets written
+	ko, ka, kb := o, a, b
+	if opts.IgnoreSpaceChange {
+		ko, ka, kb = spaceKeys(o), spaceKeys(a), spaceKeys(b)
+	}
+	oursMatches := diffLinesWith(ko, ka, opts.DiffAlgorithm)
+
+	// Unchanged lines come from the base, or from ours when whitespace
+	// changes are not changes
+	kept := o
+	if opts.IgnoreSpaceChange {
+		kept = make([]string, len(o))
+		copy(kept, o)
+		for _, m := range oursMatches {
+			kept[m.a] = a[m.b]
+		}
+	}
+
+	hunks := append(changedHunks(oursMatches, len(o), len(a), false),
+		changedHunks(diffLinesWith(ko, kb, opts.DiffAlgorithm), len(o), len(b), true)...)
+	sort.SliceStable(hunks, func(i, j int) bool {
+		return hunks[i].baseStart < hunks[j].baseStart
+	})
+
+	var out bytes.Buffer
+	clean = true
+	pos := 0
+	for i := 0; i < len(hunks); {
+		// Group hunks that overlap or touch in the base
+		lo, hi := hunks[i].baseStart, hunks[i].baseEnd
+		j := i + 1
+		for j < len(hunks) && hunks[j].baseStart <= hi {
+			if hunks[j].baseEnd > hi {
+				hi = hunks[j].baseEnd
+			}
+			j++
+		}
+		group := hunks[i:j]
+
+		writeLines(&out, kept[pos:lo])
+		oursLines, oursChanged := sideRange(kept, a, group, false, lo, hi)
+		theirsLines, theirsChanged := sideRange(o, b, group, true, lo, hi)
+
+		switch {
+		case !theirsChanged:
+			writeLines(&out, oursLines)
+		case !oursChanged:
+			writeLines(&out, theirsLines)
+		default:
+			if !writeConflict(&out, o[lo:hi], oursLines, theirsLines, opts) {
+				clean = false
+			}
+		}
+
+		pos = hi
+		i = j
+	}
+	writeLines(&out, kept[pos:])
+
+	return out.Bytes(), clean
+}
+
+// changedHunks returns the regions where a side of sideLen lines differs
+// from a base of baseLen lines, given the lines they have in common
+func changedHunks(matches []match, baseLen, sideLen int, theirs bool) []hunk {
+	matches = append(matches, match{baseLen, sideLen})
+
+	var hunks []hunk
+	i, j := 0, 0
+	for _, m := range matches {
+		if m.a > i || m.b > j {
+			hunks = append(hunks, hunk{i, m.a, j, m.b, theirs})
+		}
+		i, j = m.a+1, m.b+1
+	}
+	return hunks
+}
+
+// sideRange returns the lines one side has in place of base[lo:hi] and
+// whether that side changed anything there
+func sideRange(base, side []string, group []hunk, theirs bool, lo, hi int) ([]string, bool) {
+	var first, last *hunk
+	for i := range group {
+		if group[i].theirs != theirs {
+			continue
+		}
+		if first == nil {
+			first = &group[i]
+		}
+		last = &group[i]
+	}
+	if first == nil {
+		return base[lo:hi], false
+	}
+
+	start := first.sideStart - (first.baseStart - lo)
+	end := last.sideEnd + (hi - last.baseEnd)
+	return side[start:end], true
+}
+
+// writeConflict writes the lines of a region both sides changed, replacing
+// base. It reports whether the region was resolved without markers: the
+// sides turned out to be identical or opts.Favor picked one. Except in the
+// diff3 style, lines the sides agree on at either end are kept outside the
+// markers.
+func writeConflict(out *bytes.Buffer, base, ours, theirs []string, opts Options) bool {
+	same := func(x, y string) bool { return x == y }
+	if opts.IgnoreSpaceChange {
+		same = func(x, y string) bool { return spaceKey(x) == spaceKey(y) }
+	}
+
+	prefix := 0
+	for prefix < len(ours) && prefix < len(theirs) && same(ours[prefix], theirs[prefix]) {
+		prefix++
+	}
+	if prefix == len(ours) && prefix == len(theirs) {
+		writeLines(out, ours)
+		return true
+	}
+
+	switch opts.Favor {
+	case FavorOurs:
+		writeLines(out, ours)
+		return true
+	case FavorTheirs:
+		writeLines(out, theirs)
+		return true
+	}
+
+	suffix := 0
+	for suffix < len(ours)-prefix && suffix < len(theirs)-prefix &&
+		same(ours[len(ours)-1-suffix], theirs[len(theirs)-1-suffix]) {
+		suffix++
+	}
+	if opts.Style == StyleDiff3 {
+		prefix, suffix = 0, 0
+	}
+
+	writeLines(out, ours[:prefix])
+	out.WriteString(markerOurs + " " + opts.oursLabel() + "\n")
+	writeMarkedLines(out, ours[prefix:len(ours)-suffix])
+	if opts.Style == StyleDiff3 || opts.Style == StyleZdiff3 {
+		out.WriteString(markerBase + " " + opts.baseLabel() + "\n")
+		writeMarkedLines(out, base)
+	}
+	out.WriteString(markerSep + "\n")
+	writeMarkedLines(out, theirs[prefix:len(theirs)-suffix])
+	out.WriteString(markerTheirs + " " + opts.theirsLabel() + "\n")
+	writeLines(out, ours[len(ours)-suffix:])
+	return false
+}
+
+// writeMarkedLines writes lines that are followed by a marker, terminating
+// a final line that has no newline
+func writeMarkedLines(out *bytes.Buffer, lines []string) {
+	writeLines(out, lines)
+	if len(lines) > 0 && !strings.HasSuffix(lines[len(lines)-1], "\n") {
+		out.WriteByte('\n')
+	}
+}
+
+func writeLines(out *bytes.Buffer, lines []string) {
+	for _, line := range lines {
+		out.WriteString(line)
+	}
+}
+
+// splitLines splits data after each newline, keeping the terminators
+func splitLines(data []byte) []string {
+	var lines []string
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			lines = append(lines, string(data))
+			break
+		}
+		lines = append(lines, string(data[:i+1]))
+		data = data[i+1:]
+	}
+	return lines
+}
+
+// spaceKeys returns the lines with whitespace changes ignored
+func spaceKeys(lines []string) []string {
+	keys := make([]string, len(lines))
+	for i, line := range lines {
+		keys[i] = spaceKey(line)
+	}
+	return keys
+}
+
+// spaceKey collapses each run of whitespace in line to one space and drops
+// whitespace at the end of it, so lines that differ only in the amount of
+// whitespace get the same key
+func spaceKey(line string) string {
+	var key strings.Builder
+	space := false
+	for _, r := range strings.TrimRightFunc(line, unicode.IsSpace) {
+		if unicode.IsSpace(r) {
+			space = true
+			continue
+		}
+		if space {
+			key.WriteByte(' ')
+			space = false
+		}
+		key.WriteRune(r)
+	}
+	return key.String()
+}
+
+// isBinary reports whether data looks like binary content
+func isBinary(data []byte) bool {
+	if len(data) > binaryProbe {
+		data = data[:binaryProbe]
+	}
+	return bytes.IndexByte(data, 0) >= 0
+}
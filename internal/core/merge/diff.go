@@ -0,0 +1,356 @@
+package merge
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+)
+
+// DiffAlgorithm is how the lines two texts have in common are found
+type DiffAlgorithm string
+
+const (
+	// DiffMyers finds a shortest edit script with Myers' algorithm, but
+	// settles for a longer one where the texts differ so much that the
+	// search gets expensive
+	DiffMyers DiffAlgorithm = "myers"
+	// DiffMinimal always finds a shortest edit script, however long that
+	// takes
+	DiffMinimal DiffAlgorithm = "minimal"
+	// DiffPatience first matches the lines that appear exactly once in each
+	// text, so common lines such as blank ones and lone braces do not pair
+	// up unrelated code
+	DiffPatience DiffAlgorithm = "patience"
+	// DiffHistogram is patience for lines that are rare rather than unique,
+	// matching around the least common lines first
+	DiffHistogram DiffAlgorithm = "histogram"
+)
+
+// ParseDiffAlgorithm checks the name of a diff algorithm, in any case.
+// "default" is DiffMyers.
+func ParseDiffAlgorithm(name string) (DiffAlgorithm, error) {
+	switch algorithm := DiffAlgorithm(strings.ToLower(name)); algorithm {
+	case "default":
+		return DiffMyers, nil
+	case DiffMyers, DiffMinimal, DiffPatience, DiffHistogram:
+		return algorithm, nil
+	}
+	return "", fmt.Errorf("unknown diff algorithm %q (expected myers, minimal, patience or histogram)", name)
+}
+
+// minMaxCost is the fewest edits Myers' algorithm searches before it may
+// give up on a shortest edit script
+const minMaxCost = 256
+
+// maxCost is how many edits Myers' algorithm searches for a shortest edit
+// script between texts of n lines in all, the square root of n but at
+// least minMaxCost as in Git
+func maxCost(n int) int {
+	if cost := int(math.Sqrt(float64(n))); cost > minMaxCost {
+		return cost
+	}
+	return minMaxCost
+}
+
+// match pairs a line of one sequence with an equal line of another
+type match struct {
+	a, b int
+}
+
+// diffLinesWith returns the lines a and b have in common as index pairs, as
+// algorithm finds them after trimming the common prefix and suffix. An
+// empty algorithm is DiffMyers.
+func diffLinesWith(a, b []string, algorithm DiffAlgorithm) []match {
+	var matches []match
+
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		matches = append(matches, match{prefix, prefix})
+		prefix++
+	}
+
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix &&
+		a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	middleA, middleB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	var middle []match
+	switch algorithm {
+	case DiffMinimal:
+		middle = myers(middleA, middleB, 0)
+	case DiffPatience:
+		middle = patience(middleA, middleB)
+	case DiffHistogram:
+		middle = histogram(middleA, middleB)
+	default:
+		middle = myers(middleA, middleB, maxCost(len(middleA)+len(middleB)))
+	}
+	for _, m := range middle {
+		matches = append(matches, match{m.a + prefix, m.b + prefix})
+	}
+
+	for i := suffix; i > 0; i-- {
+		matches = append(matches, match{len(a) - i, len(b) - i})
+	}
+
+	return matches
+}
+
+// myers finds the matching lines of a shortest edit script from a to b.
+// With limit above zero, once more than limit edits are needed it takes
+// the path that got furthest and goes on from its end, which bounds the
+// time and memory spent at the cost of a longer script.
+func myers(a, b []string, limit int) []match {
+	n, m := len(a), len(b)
+	if n == 0 || m == 0 {
+		return nil
+	}
+
+	max := n + m
+	offset := max + 1
+	v := make([]int, 2*max+3)
+	var trace [][]int
+
+search:
+	for d := 0; d <= max; d++ {
+		if limit > 0 && d > limit {
+			x, y := furthest(v, offset, d-1, n, m)
+			matches := backtrack(trace, offset, x, y)
+			for _, rest := range myers(a[x:], b[y:], limit) {
+				matches = append(matches, match{rest.a + x, rest.b + y})
+			}
+			return matches
+		}
+		trace = append(trace, append([]int(nil), v...))
+		for k := -d; k <= d; k += 2 {
+			var x int
+			if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+				x = v[offset+k+1]
+			} else {
+				x = v[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+			v[offset+k] = x
+			if x >= n && y >= m {
+				break search
+			}
+		}
+	}
+
+	return backtrack(trace, offset, n, m)
+}
+
+// furthest returns the end of the path of d edits in v that got furthest
+// through a, of n lines, and b, of m lines
+func furthest(v []int, offset, d, n, m int) (x, y int) {
+	best := -1
+	for k := -d; k <= d; k += 2 {
+		kx := v[offset+k]
+		ky := kx - k
+		if kx <= n && ky >= 0 && ky <= m && kx+ky > best {
+			best, x, y = kx+ky, kx, ky
+		}
+	}
+	return x, y
+}
+
+// backtrack walks the trace of a Myers search back from x, y to the start,
+// collecting the diagonal moves as matches in order
+func backtrack(trace [][]int, offset, x, y int) []match {
+	var matches []match
+	for d := len(trace) - 1; d > 0; d-- {
+		v := trace[d]
+		k := x - y
+
+		var prevK int
+		if k == -d || (k != d && v[offset+k-1] < v[offset+k+1]) {
+			prevK = k + 1
+		} else {
+			prevK = k - 1
+		}
+		prevX := v[offset+prevK]
+		prevY := prevX - prevK
+
+		for x > prevX && y > prevY {
+			x--
+			y--
+			matches = append(matches, match{x, y})
+		}
+		x, y = prevX, prevY
+	}
+	for x > 0 && y > 0 {
+		x--
+		y--
+		matches = append(matches, match{x, y})
+	}
+
+	for i, j := 0, len(matches)-1; i < j; i, j = i+1, j-1 {
+		matches[i], matches[j] = matches[j], matches[i]
+	}
+	return matches
+}
+
+// LineMatch pairs line A of one sequence with the equal line B of another
+type LineMatch struct {
+	A, B int
+}
+
+// MatchLines returns the lines a and b have in common, in order, as found by
+// a Myers line diff
+func MatchLines(a, b []string) []LineMatch {
+	return MatchLinesWith(a, b, DiffMyers)
+}
+
+// MatchLinesWith returns the lines a and b have in common, in order, as
+// found by algorithm
+func MatchLinesWith(a, b []string, algorithm DiffAlgorithm) []LineMatch {
+	matches := diffLinesWith(a, b, algorithm)
+	result := make([]LineMatch, len(matches))
+	for i, m := range matches {
+		result[i] = LineMatch{A: m.a, B: m.b}
+	}
+	return result
+}
+
+// patience matches the lines that appear exactly once in both a and b, in
+// the longest run that keeps them in order, then diffs between them the
+// same way. Without such lines it falls back to Myers.
+func patience(a, b []string) []match {
+	if len(a) == 0 || len(b) == 0 {
+		return nil
+	}
+
+	type occurrence struct {
+		countA, countB int
+		posA, posB     int
+	}
+	seen := make(map[string]*occurrence)
+	for i, line := range a {
+		o := seen[line]
+		if o == nil {
+			o = &occurrence{}
+			seen[line] = o
+		}
+		o.countA++
+		o.posA = i
+	}
+	for j, line := range b {
+		if o := seen[line]; o != nil {
+			o.countB++
+			o.posB = j
+		}
+	}
+	var unique []match
+	for i, line := range a {
+		if o := seen[line]; o.countA == 1 && o.countB == 1 {
+			unique = append(unique, match{i, o.posB})
+		}
+	}
+	if len(unique) == 0 {
+		return myers(a, b, maxCost(len(a)+len(b)))
+	}
+
+	var matches []match
+	prevA, prevB := 0, 0
+	for _, anchor := range append(longestIncreasing(unique), match{len(a), len(b)}) {
+		for _, m := range diffLinesWith(a[prevA:anchor.a], b[prevB:anchor.b], DiffPatience) {
+			matches = append(matches, match{m.a + prevA, m.b + prevB})
+		}
+		if anchor.a < len(a) {
+			matches = append(matches, anchor)
+		}
+		prevA, prevB = anchor.a+1, anchor.b+1
+	}
+	return matches
+}
+
+// longestIncreasing returns the longest run of ms, which are in order of a,
+// that is in order of b too, found by patience sorting
+func longestIncreasing(ms []match) []match {
+	// tails[n] is the match ending the best run of n+1 matches so far
+	var tails []int
+	prev := make([]int, len(ms))
+	for i, m := range ms {
+		n := sort.Search(len(tails), func(k int) bool { return ms[tails[k]].b >= m.b })
+		prev[i] = -1
+		if n > 0 {
+			prev[i] = tails[n-1]
+		}
+		if n == len(tails) {
+			tails = append(tails, i)
+		} else {
+			tails[n] = i
+		}
+	}
+
+	run := make([]match, len(tails))
+	for i, k := len(tails)-1, tails[len(tails)-1]; i >= 0; i, k = i-1, prev[k] {
+		run[i] = ms[k]
+	}
+	return run
+}
+
+// maxChain is how often a line may appear in a to anchor a histogram diff
+const maxChain = 64
+
+// histogram matches the run of lines a and b share whose rarest line appears
+// least often in a, preferring the longest run among equals, then diffs
+// either side of it the same way. When every shared line appears more than
+// maxChain times it falls back to Myers.
+func histogram(a, b []string) []match {
+	if len(a) == 0 || len(b) == 0 {
+		return nil
+	}
+
+	positions := make(map[string][]int)
+	for i, line := range a {
+		positions[line] = append(positions[line], i)
+	}
+
+	bestCount := maxChain + 1
+	bestA, bestB, bestLen := 0, 0, 0
+	for j := range b {
+		occurrences := positions[b[j]]
+		if len(occurrences) == 0 || len(occurrences) > bestCount {
+			continue
+		}
+		for _, i := range occurrences {
+			startA, startB := i, j
+			for startA > 0 && startB > 0 && a[startA-1] == b[startB-1] {
+				startA, startB = startA-1, startB-1
+			}
+			endA, endB := i+1, j+1
+			for endA < len(a) && endB < len(b) && a[endA] == b[endB] {
+				endA, endB = endA+1, endB+1
+			}
+
+			count := len(occurrences)
+			for k := startA; k < endA; k++ {
+				count = min(count, len(positions[a[k]]))
+			}
+			if count < bestCount || (count == bestCount && endA-startA > bestLen) {
+				bestCount, bestA, bestB, bestLen = count, startA, startB, endA-startA
+			}
+		}
+	}
+	if bestLen == 0 {
+		return myers(a, b, maxCost(len(a)+len(b)))
+	}
+
+	matches := diffLinesWith(a[:bestA], b[:bestB], DiffHistogram)
+	for k := 0; k < bestLen; k++ {
+		matches = append(matches, match{bestA + k, bestB + k})
+	}
+	endA, endB := bestA+bestLen, bestB+bestLen
+	for _, m := range diffLinesWith(a[endA:], b[endB:], DiffHistogram) {
+		matches = append(matches, match{m.a + endA, m.b + endB})
+	}
+	return matches
+}
@@ -0,0 +1,431 @@
+// Package merge implements three-way merging of trees and file content.
+package merge
+
+import (
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+// Store reads and writes the objects a merge works on
+type Store interface {
+	ReadObject(id objects.ObjectID) (objects.Object, error)
+	WriteObject(obj objects.Object) error
+}
+
+// ConflictStyle is how a conflicted region is written
+type ConflictStyle string
+
+const (
+	// StyleMerge shows our and their lines
+	StyleMerge ConflictStyle = "merge"
+	// StyleDiff3 also shows the base lines between them
+	StyleDiff3 ConflictStyle = "diff3"
+	// StyleZdiff3 is diff3 with lines both sides agree on at either end
+	// moved outside the markers
+	StyleZdiff3 ConflictStyle = "zdiff3"
+)
+
+// ParseConflictStyle checks the name of a conflict style
+func ParseConflictStyle(name string) (ConflictStyle, error) {
+	switch style := ConflictStyle(name); style {
+	case StyleMerge, StyleDiff3, StyleZdiff3:
+		return style, nil
+	}
+	return "", fmt.Errorf("unknown conflict style %q (expected merge, diff3 or zdiff3)", name)
+}
+
+// Favor picks the side whose lines win regions both sides changed
+type Favor int
+
+const (
+	// FavorNone leaves such regions as conflicts
+	FavorNone Favor = iota
+	// FavorOurs takes our lines
+	FavorOurs
+	// FavorTheirs takes their lines
+	FavorTheirs
+)
+
+// Options controls how conflicts are presented and resolved
+type Options struct {
+	// OursLabel, TheirsLabel and BaseLabel name the sides in conflict
+	// markers
+	OursLabel   string
+	TheirsLabel string
+	BaseLabel   string
+	// Style is how conflicts are written, StyleMerge when empty
+	Style ConflictStyle
+	// Favor resolves conflicting regions in favor of one side
+	Favor Favor
+	// IgnoreSpaceChange treats lines that differ only in the amount of
+	// whitespace as equal, keeping our version of them
+	IgnoreSpaceChange bool
+	// NoRenames turns off rename detection
+	NoRenames bool
+	// RenameThreshold is the similarity in percent a deleted and an added
+	// file need to be merged as a rename, DefaultRenameThreshold when zero
+	RenameThreshold int
+	// DirectoryRenames moves files one side adds to a directory the other
+	// side renamed into the directory's new name
+	DirectoryRenames bool
+	// DiffAlgorithm finds the lines each side kept from the base,
+	// DiffMyers when empty
+	DiffAlgorithm DiffAlgorithm
+}
+
+func (o Options) oursLabel() string {
+	if o.OursLabel == "" {
+		return "ours"
+	}
+	return o.OursLabel
+}
+
+func (o Options) theirsLabel() string {
+	if o.TheirsLabel == "" {
+		return "theirs"
+	}
+	return o.TheirsLabel
+}
+
+func (o Options) baseLabel() string {
+	if o.BaseLabel == "" {
+		return "base"
+	}
+	return o.BaseLabel
+}
+
+func (o Options) renameThreshold() int {
+	if o.RenameThreshold == 0 {
+		return DefaultRenameThreshold
+	}
+	return o.RenameThreshold
+}
+
+// Entry is a file in a flattened tree
+type Entry struct {
+	Path string
+	Mode objects.FileMode
+	ID   objects.ObjectID
+}
+
+// ConflictType describes how the two sides of a merge disagree about a path
+type ConflictType string
+
+const (
+	// ConflictContent means both sides changed the file differently
+	ConflictContent ConflictType = "content"
+	// ConflictAddAdd means both sides added the file with different content
+	ConflictAddAdd ConflictType = "add/add"
+	// ConflictModifyDelete means ours changed the file and theirs deleted it
+	ConflictModifyDelete ConflictType = "modify/delete"
+	// ConflictDeleteModify means ours deleted the file and theirs changed it
+	ConflictDeleteModify ConflictType = "delete/modify"
+	// ConflictRenameRename means the sides renamed the file to different
+	// paths; both are kept with the merged content
+	ConflictRenameRename ConflictType = "rename/rename"
+)
+
+// Conflict is a path the merge could not resolve
+type Conflict struct {
+	Path string
+	Type ConflictType
+	// Base, Ours and Theirs are the versions on each side, nil where the
+	// file does not exist
+	Base, Ours, Theirs *Entry
+	// Mode and Content are what belongs in the working tree: the file with
+	// conflict markers or the surviving side of a delete. Content is nil
+	// when the entry is not a regular file.
+	Mode    objects.FileMode
+	Content []byte
+}
+
+// Result is the outcome of merging two trees
+type Result struct {
+	// Entries are the cleanly merged files, sorted by path
+	Entries []Entry
+	// Conflicts are the unresolved paths, sorted by path
+	Conflicts []Conflict
+}
+
+// Clean reports whether the merge had no conflicts
+func (r *Result) Clean() bool {
+	return len(r.Conflicts) == 0
+}
+
+// Trees merges the changes between base and theirs into ours. A zero base
+// is treated as an empty tree. Files one side renamed are merged with the
+// other side's changes to the old path unless opts.NoRenames is set.
+func Trees(store Store, base, ours, theirs objects.ObjectID, opts Options) (*Result, error) {
+	baseFiles, err := readTreeMap(store, base)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read base tree: %w", err)
+	}
+	oursFiles, err := readTreeMap(store, ours)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read our tree: %w", err)
+	}
+	theirsFiles, err := readTreeMap(store, theirs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read their tree: %w", err)
+	}
+
+	paths := make(map[string]*sides)
+	for _, files := range []map[string]*Entry{baseFiles, oursFiles, theirsFiles} {
+		for p := range files {
+			paths[p] = &sides{b: baseFiles[p], o: oursFiles[p], t: theirsFiles[p]}
+		}
+	}
+	if !opts.NoRenames {
+		if err := pairRenames(store, paths, baseFiles, oursFiles, theirsFiles, opts); err != nil {
+			return nil, err
+		}
+	}
+
+	sorted := make([]string, 0, len(paths))
+	for p := range paths {
+		sorted = append(sorted, p)
+	}
+	sort.Strings(sorted)
+
+	result := &Result{}
+	for _, p := range sorted {
+		s := paths[p]
+		b, o, t := s.b, s.o, s.t
+
+		if s.other != nil {
+			conflict, err := renameConflict(store, p, s, opts)
+			if err != nil {
+				return nil, err
+			}
+			result.Conflicts = append(result.Conflicts, *conflict)
+			continue
+		}
+
+		switch {
+		case sameEntry(o, t), sameEntry(b, t):
+			if o != nil {
+				result.Entries = append(result.Entries, entryAt(o, p))
+			}
+			continue
+		case sameEntry(b, o):
+			if t != nil {
+				result.Entries = append(result.Entries, entryAt(t, p))
+			}
+			continue
+		}
+
+		entry, conflict, err := mergeEntry(store, p, b, o, t, opts)
+		if err != nil {
+			return nil, err
+		}
+		if conflict != nil {
+			result.Conflicts = append(result.Conflicts, *conflict)
+		} else {
+			result.Entries = append(result.Entries, *entry)
+		}
+	}
+
+	return result, nil
+}
+
+// mergeEntry resolves a path that both sides changed
+func mergeEntry(store Store, p string, b, o, t *Entry, opts Options) (*Entry, *Conflict, error) {
+	conflict := &Conflict{Path: p, Base: b, Ours: o, Theirs: t}
+
+	switch {
+	case o == nil:
+		conflict.Type = ConflictDeleteModify
+		conflict.Mode = t.Mode
+		data, err := readBlob(store, t)
+		if err != nil {
+			return nil, nil, err
+		}
+		conflict.Content = data
+		return nil, conflict, nil
+
+	case t == nil:
+		conflict.Type = ConflictModifyDelete
+		conflict.Mode = o.Mode
+		data, err := readBlob(store, o)
+		if err != nil {
+			return nil, nil, err
+		}
+		conflict.Content = data
+		return nil, conflict, nil
+
+	case b == nil:
+		conflict.Type = ConflictAddAdd
+	default:
+		conflict.Type = ConflictContent
+	}
+
+	// Take a mode change from whichever side made it
+	mode := o.Mode
+	if b != nil && o.Mode == b.Mode {
+		mode = t.Mode
+	}
+	conflict.Mode = mode
+
+	if !isRegular(o.Mode) || !isRegular(t.Mode) || (b != nil && !isRegular(b.Mode)) {
+		data, err := readBlob(store, o)
+		if err != nil {
+			return nil, nil, err
+		}
+		conflict.Mode = o.Mode
+		conflict.Content = data
+		return nil, conflict, nil
+	}
+
+	baseData, err := readBlob(store, b)
+	if err != nil {
+		return nil, nil, err
+	}
+	oursData, err := readBlob(store, o)
+	if err != nil {
+		return nil, nil, err
+	}
+	theirsData, err := readBlob(store, t)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	merged, clean := MergeContent(baseData, oursData, theirsData, opts)
+	if !clean {
+		conflict.Content = merged
+		return nil, conflict, nil
+	}
+
+	blob := objects.NewBlob(merged)
+	if err := store.WriteObject(blob); err != nil {
+		return nil, nil, fmt.Errorf("failed to write merged %s: %w", p, err)
+	}
+	return &Entry{Path: p, Mode: mode, ID: blob.ID()}, nil, nil
+}
+
+// ReadTree returns the files of a tree and its subtrees, sorted by path
+func ReadTree(store Store, id objects.ObjectID) ([]Entry, error) {
+	var entries []Entry
+	if id.IsZero() {
+		return entries, nil
+	}
+	if err := readTree(store, id, "", &entries); err != nil {
+		return nil, err
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	return entries, nil
+}
+
+func readTree(store Store, id objects.ObjectID, prefix string, entries *[]Entry) error {
+	obj, err := store.ReadObject(id)
+	if err != nil {
+		return fmt.Errorf("failed to read tree %s: %w", id.Short(), err)
+	}
+	tree, ok := obj.(*objects.Tree)
+	if !ok {
+		return fmt.Errorf("object %s is not a tree", id.Short())
+	}
+
+	for _, entry := range tree.Entries() {
+		p := path.Join(prefix, entry.Name)
+		if entry.Mode == objects.ModeTree {
+			if err := readTree(store, entry.ID, p, entries); err != nil {
+				return err
+			}
+			continue
+		}
+		*entries = append(*entries, Entry{Path: p, Mode: entry.Mode, ID: entry.ID})
+	}
+	return nil
+}
+
+func readTreeMap(store Store, id objects.ObjectID) (map[string]*Entry, error) {
+	entries, err := ReadTree(store, id)
+	if err != nil {
+		return nil, err
+	}
+	files := make(map[string]*Entry, len(entries))
+	for i := range entries {
+		files[entries[i].Path] = &entries[i]
+	}
+	return files, nil
+}
+
+// WriteTree writes the tree objects for a set of files and returns the ID
+// of the root tree
+func WriteTree(store Store, entries []Entry) (objects.ObjectID, error) {
+	return writeTree(store, entries, "")
+}
+
+func writeTree(store Store, entries []Entry, prefix string) (objects.ObjectID, error) {
+	tree := objects.NewTree()
+	subdirs := make(map[string][]Entry)
+	var names []string
+
+	for _, entry := range entries {
+		name := strings.TrimPrefix(entry.Path, prefix)
+		if i := strings.IndexByte(name, '/'); i >= 0 {
+			dir := name[:i]
+			if _, ok := subdirs[dir]; !ok {
+				names = append(names, dir)
+			}
+			subdirs[dir] = append(subdirs[dir], entry)
+			continue
+		}
+		if err := tree.AddEntry(entry.Mode, name, entry.ID); err != nil {
+			return objects.ObjectID{}, fmt.Errorf("failed to add %s: %w", entry.Path, err)
+		}
+	}
+
+	for _, dir := range names {
+		id, err := writeTree(store, subdirs[dir], prefix+dir+"/")
+		if err != nil {
+			return objects.ObjectID{}, err
+		}
+		if err := tree.AddEntry(objects.ModeTree, dir, id); err != nil {
+			return objects.ObjectID{}, fmt.Errorf("failed to add %s: %w", prefix+dir, err)
+		}
+	}
+
+	if err := store.WriteObject(tree); err != nil {
+		return objects.ObjectID{}, fmt.Errorf("failed to write tree: %w", err)
+	}
+	return tree.ID(), nil
+}
+
+// readBlob returns the content of a file entry, or nil for a missing one
+func readBlob(store Store, entry *Entry) ([]byte, error) {
+	if entry == nil || entry.Mode == objects.ModeCommit {
+		return nil, nil
+	}
+	obj, err := store.ReadObject(entry.ID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", entry.Path, err)
+	}
+	blob, ok := obj.(*objects.Blob)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a blob", entry.Path)
+	}
+	return blob.Data(), nil
+}
+
+// entryAt returns a copy of entry placed at path p
+func entryAt(entry *Entry, p string) Entry {
+	e := *entry
+	e.Path = p
+	return e
+}
+
+func sameEntry(a, b *Entry) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+	return a.Mode == b.Mode && a.ID == b.ID
+}
+
+func isRegular(mode objects.FileMode) bool {
+	return mode == objects.ModeBlob || mode == objects.ModeExec
+}
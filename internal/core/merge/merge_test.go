@@ -0,0 +1,680 @@
+package merge
+
+import (
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+func newStore(t *testing.T) *objects.Storage {
+	store := objects.NewStorage(t.TempDir())
+	if err := store.Init(); err != nil {
+		t.Fatalf("Storage.Init() error = %v", err)
+	}
+	return store
+}
+
+// lines joins its arguments as newline-terminated lines
+func lines(ls ...string) string {
+	if len(ls) == 0 {
+		return ""
+	}
+	return strings.Join(ls, "\n") + "\n"
+}
+
+func TestDiffLines(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"abcabba", "cbabac", 4},
+		{"abc", "abc", 3},
+		{"", "abc", 0},
+		{"abc", "", 0},
+		{"axbycz", "abc", 3},
+	}
+
+	for _, tt := range tests {
+		a, b := strings.Split(tt.a, ""), strings.Split(tt.b, "")
+		matches := diffLinesWith(a, b, DiffMyers)
+		if len(matches) != tt.want {
+			t.Errorf("diffLinesWith(%q, %q) = %d matches, want %d", tt.a, tt.b, len(matches), tt.want)
+		}
+		prev := match{-1, -1}
+		for _, m := range matches {
+			if m.a <= prev.a || m.b <= prev.b || a[m.a] != b[m.b] {
+				t.Errorf("diffLinesWith(%q, %q) has invalid match %v", tt.a, tt.b, m)
+			}
+			prev = m
+		}
+	}
+}
+
+// lcsLength returns the length of the longest common subsequence of a and b
+func lcsLength(a, b []string) int {
+	dp := make([][]int, len(a)+1)
+	for i := range dp {
+		dp[i] = make([]int, len(b)+1)
+	}
+	for i := len(a) - 1; i >= 0; i-- {
+		for j := len(b) - 1; j >= 0; j-- {
+			if a[i] == b[j] {
+				dp[i][j] = dp[i+1][j+1] + 1
+			} else {
+				dp[i][j] = max(dp[i+1][j], dp[i][j+1])
+			}
+		}
+	}
+	return dp[0][0]
+}
+
+func TestDiffAlgorithms(t *testing.T) {
+	valid := func(name string, a, b []string, matches []match) {
+		prev := match{-1, -1}
+		for _, m := range matches {
+			if m.a <= prev.a || m.b <= prev.b || a[m.a] != b[m.b] {
+				t.Fatalf("%s(%q, %q) has invalid match %v", name, a, b, m)
+			}
+			prev = m
+		}
+	}
+
+	r := rand.New(rand.NewSource(1))
+	alphabet := []string{"{", "}", "", "x", "y"}
+	random := func() []string {
+		s := make([]string, r.Intn(30))
+		for i := range s {
+			s[i] = alphabet[r.Intn(len(alphabet))]
+		}
+		return s
+	}
+	for i := 0; i < 300; i++ {
+		a, b := random(), random()
+		for _, algorithm := range []DiffAlgorithm{DiffMyers, DiffMinimal, DiffPatience, DiffHistogram} {
+			valid(string(algorithm), a, b, diffLinesWith(a, b, algorithm))
+		}
+		if got, want := len(diffLinesWith(a, b, DiffMinimal)), lcsLength(a, b); got != want {
+			t.Fatalf("minimal(%q, %q) = %d matches, want %d", a, b, got, want)
+		}
+		// Giving up early still leaves a valid script
+		valid("myers limited", a, b, myers(a, b, 1))
+	}
+
+	// The lines unique to both anchor patience and histogram, so x pairs
+	// with the x before A rather than with the first one
+	a, b := strings.Split("B } x A", " "), strings.Split("x x A }", " ")
+	if got := fmt.Sprint(diffLinesWith(a, b, DiffMyers)); got != "[{2 0} {3 2}]" {
+		t.Errorf("myers = %s", got)
+	}
+	for _, algorithm := range []DiffAlgorithm{DiffPatience, DiffHistogram} {
+		if got := fmt.Sprint(diffLinesWith(a, b, algorithm)); got != "[{2 1} {3 2}]" {
+			t.Errorf("%s = %s", algorithm, got)
+		}
+	}
+}
+
+func TestParseDiffAlgorithm(t *testing.T) {
+	for name, want := range map[string]DiffAlgorithm{
+		"myers": DiffMyers, "default": DiffMyers, "Minimal": DiffMinimal,
+		"patience": DiffPatience, "HISTOGRAM": DiffHistogram,
+	} {
+		if got, err := ParseDiffAlgorithm(name); err != nil || got != want {
+			t.Errorf("ParseDiffAlgorithm(%q) = %q, %v, want %q", name, got, err, want)
+		}
+	}
+	if _, err := ParseDiffAlgorithm("fast"); err == nil {
+		t.Error("ParseDiffAlgorithm(fast) succeeded")
+	}
+}
+
+func TestMergeContent(t *testing.T) {
+	base := lines("one", "two", "three", "four", "five")
+
+	tests := []struct {
+		name   string
+		ours   string
+		theirs string
+		want   string
+		clean  bool
+	}{
+		{
+			name:   "disjoint edits",
+			ours:   lines("ONE", "two", "three", "four", "five"),
+			theirs: lines("one", "two", "three", "four", "FIVE"),
+			want:   lines("ONE", "two", "three", "four", "FIVE"),
+			clean:  true,
+		},
+		{
+			name:   "same edit on both sides",
+			ours:   lines("one", "two", "THREE", "four", "five"),
+			theirs: lines("one", "two", "THREE", "four", "five"),
+			want:   lines("one", "two", "THREE", "four", "five"),
+			clean:  true,
+		},
+		{
+			name:   "insertions in different places",
+			ours:   lines("zero", "one", "two", "three", "four", "five"),
+			theirs: lines("one", "two", "three", "four", "five", "six"),
+			want:   lines("zero", "one", "two", "three", "four", "five", "six"),
+			clean:  true,
+		},
+		{
+			name:   "conflicting edit",
+			ours:   lines("one", "two", "ours", "four", "five"),
+			theirs: lines("one", "two", "theirs", "four", "five"),
+			want: lines("one", "two", "<<<<<<< HEAD", "ours", "=======", "theirs",
+				">>>>>>> topic", "four", "five"),
+		},
+		{
+			name:   "common lines kept outside markers",
+			ours:   lines("one", "two", "x", "ours", "y", "five"),
+			theirs: lines("one", "two", "x", "theirs", "y", "five"),
+			want: lines("one", "two", "x", "<<<<<<< HEAD", "ours", "=======", "theirs",
+				">>>>>>> topic", "y", "five"),
+		},
+		{
+			name:   "edit against deletion",
+			ours:   lines("one", "two", "THREE", "four", "five"),
+			theirs: lines("one", "two", "four", "five"),
+			want: lines("one", "two", "<<<<<<< HEAD", "THREE", "=======",
+				">>>>>>> topic", "four", "five"),
+		},
+		{
+			name:   "missing final newline",
+			ours:   "one\ntwo\nthree\nfour\nours",
+			theirs: "one\ntwo\nthree\nfour\ntheirs",
+			want: lines("one", "two", "three", "four", "<<<<<<< HEAD", "ours", "=======", "theirs",
+				">>>>>>> topic"),
+		},
+	}
+
+	opts := Options{OursLabel: "HEAD", TheirsLabel: "topic"}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged, clean := MergeContent([]byte(base), []byte(tt.ours), []byte(tt.theirs), opts)
+			if clean != tt.clean {
+				t.Errorf("MergeContent() clean = %v, want %v", clean, tt.clean)
+			}
+			if string(merged) != tt.want {
+				t.Errorf("MergeContent() =\n%s\nwant\n%s", merged, tt.want)
+			}
+		})
+	}
+}
+
+func TestMergeContentStyles(t *testing.T) {
+	base := lines("one", "two", "three", "four")
+	ours := lines("one", "x", "ours", "four")
+	theirs := lines("one", "x", "theirs", "four")
+
+	tests := []struct {
+		style ConflictStyle
+		want  string
+	}{
+		{
+			style: StyleMerge,
+			want: lines("one", "x", "<<<<<<< HEAD", "ours", "=======", "theirs",
+				">>>>>>> topic", "four"),
+		},
+		{
+			style: StyleDiff3,
+			want: lines("one", "<<<<<<< HEAD", "x", "ours", "||||||| base", "two", "three",
+				"=======", "x", "theirs", ">>>>>>> topic", "four"),
+		},
+		{
+			style: StyleZdiff3,
+			want: lines("one", "x", "<<<<<<< HEAD", "ours", "||||||| base", "two", "three",
+				"=======", "theirs", ">>>>>>> topic", "four"),
+		},
+	}
+
+	for _, tt := range tests {
+		opts := Options{OursLabel: "HEAD", TheirsLabel: "topic", Style: tt.style}
+		merged, clean := MergeContent([]byte(base), []byte(ours), []byte(theirs), opts)
+		if clean || string(merged) != tt.want {
+			t.Errorf("MergeContent(%s) = %v,\n%s\nwant\n%s", tt.style, clean, merged, tt.want)
+		}
+	}
+
+	if _, err := ParseConflictStyle("diff4"); err == nil {
+		t.Errorf("ParseConflictStyle(diff4) succeeded")
+	}
+}
+
+func TestMergeContentFavor(t *testing.T) {
+	base := lines("one", "two", "three", "four", "five")
+	ours := lines("ONE", "two", "ours", "four", "five")
+	theirs := lines("one", "two", "theirs", "four", "FIVE")
+
+	merged, clean := MergeContent([]byte(base), []byte(ours), []byte(theirs), Options{Favor: FavorOurs})
+	if want := lines("ONE", "two", "ours", "four", "FIVE"); !clean || string(merged) != want {
+		t.Errorf("MergeContent(ours) = %q, %v; want %q", merged, clean, want)
+	}
+	merged, clean = MergeContent([]byte(base), []byte(ours), []byte(theirs), Options{Favor: FavorTheirs})
+	if want := lines("ONE", "two", "theirs", "four", "FIVE"); !clean || string(merged) != want {
+		t.Errorf("MergeContent(theirs) = %q, %v; want %q", merged, clean, want)
+	}
+
+	binBase, binOurs, binTheirs := []byte("bin\x00ary"), []byte("bin\x00ours"), []byte("bin\x00theirs")
+	merged, clean = MergeContent(binBase, binOurs, binTheirs, Options{Favor: FavorTheirs})
+	if !clean || string(merged) != string(binTheirs) {
+		t.Errorf("MergeContent(binary, theirs) = %q, %v; want theirs", merged, clean)
+	}
+}
+
+func TestMergeContentIgnoreSpaceChange(t *testing.T) {
+	base := lines("func f() {", "\treturn 1", "}", "", "end")
+	ours := lines("func f() {", "    return 1", "}  ", "", "end")
+	theirs := lines("func f() {", "\treturn 2", "}", "", "END")
+
+	merged, clean := MergeContent([]byte(base), []byte(ours), []byte(theirs), Options{})
+	if clean {
+		t.Errorf("MergeContent() = %q, want a conflict without ignore-space-change", merged)
+	}
+
+	merged, clean = MergeContent([]byte(base), []byte(ours), []byte(theirs), Options{IgnoreSpaceChange: true})
+	if want := lines("func f() {", "\treturn 2", "}  ", "", "END"); !clean || string(merged) != want {
+		t.Errorf("MergeContent() = %q, %v; want %q", merged, clean, want)
+	}
+}
+
+func TestMergeContentBinary(t *testing.T) {
+	base := []byte("bin\x00ary")
+	ours := []byte("bin\x00ours")
+	theirs := []byte("bin\x00theirs")
+
+	merged, clean := MergeContent(base, ours, theirs, Options{})
+	if clean || string(merged) != string(ours) {
+		t.Errorf("MergeContent() = %q, %v; want ours as a conflict", merged, clean)
+	}
+
+	merged, clean = MergeContent(base, base, theirs, Options{})
+	if !clean || string(merged) != string(theirs) {
+		t.Errorf("MergeContent() = %q, %v; want theirs", merged, clean)
+	}
+}
+
+// buildTree writes files given as path to content and returns the tree ID
+func buildTree(t *testing.T, store Store, files map[string]string) objects.ObjectID {
+	var entries []Entry
+	for p, content := range files {
+		blob := objects.NewBlob([]byte(content))
+		if err := store.WriteObject(blob); err != nil {
+			t.Fatalf("WriteObject() error = %v", err)
+		}
+		entries = append(entries, Entry{Path: p, Mode: objects.ModeBlob, ID: blob.ID()})
+	}
+	id, err := WriteTree(store, entries)
+	if err != nil {
+		t.Fatalf("WriteTree() error = %v", err)
+	}
+	return id
+}
+
+// readEntries returns the content of merged entries by path
+func readEntries(t *testing.T, store Store, entries []Entry) map[string]string {
+	files := make(map[string]string)
+	for _, e := range entries {
+		data, err := readBlob(store, &e)
+		if err != nil {
+			t.Fatalf("readBlob(%s) error = %v", e.Path, err)
+		}
+		files[e.Path] = string(data)
+	}
+	return files
+}
+
+func TestWriteTreeRoundTrip(t *testing.T) {
+	store := newStore(t)
+	id := buildTree(t, store, map[string]string{
+		"README":         "readme\n",
+		"src/main.go":    "package main\n",
+		"src/lib/lib.go": "package lib\n",
+	})
+
+	entries, err := ReadTree(store, id)
+	if err != nil {
+		t.Fatalf("ReadTree() error = %v", err)
+	}
+	var paths []string
+	for _, e := range entries {
+		paths = append(paths, e.Path)
+	}
+	if got, want := strings.Join(paths, ","), "README,src/lib/lib.go,src/main.go"; got != want {
+		t.Errorf("ReadTree() paths = %s, want %s", got, want)
+	}
+
+	again, err := WriteTree(store, entries)
+	if err != nil {
+		t.Fatalf("WriteTree() error = %v", err)
+	}
+	if again != id {
+		t.Errorf("WriteTree() = %s, want %s", again, id)
+	}
+}
+
+func TestTrees(t *testing.T) {
+	store := newStore(t)
+	base := buildTree(t, store, map[string]string{
+		"same.txt":     "same\n",
+		"edit.txt":     lines("a", "b", "c", "d"),
+		"both.txt":     "base\n",
+		"deleted.txt":  "deleted by theirs\n",
+		"modified.txt": "base\n",
+		"dir/file.txt": "in dir\n",
+	})
+	ours := buildTree(t, store, map[string]string{
+		"same.txt":     "same\n",
+		"edit.txt":     lines("A", "b", "c", "d"),
+		"both.txt":     "ours\n",
+		"deleted.txt":  "changed by ours\n",
+		"added.txt":    "ours\n",
+		"dir/file.txt": "in dir\n",
+	})
+	theirs := buildTree(t, store, map[string]string{
+		"same.txt":     "same\n",
+		"edit.txt":     lines("a", "b", "c", "D"),
+		"both.txt":     "theirs\n",
+		"modified.txt": "theirs\n",
+		"added.txt":    "theirs\n",
+		"dir/file.txt": "in dir\n",
+		"dir/new.txt":  "new\n",
+	})
+
+	result, err := Trees(store, base, ours, theirs, Options{})
+	if err != nil {
+		t.Fatalf("Trees() error = %v", err)
+	}
+
+	clean := make(map[string]string)
+	for _, e := range result.Entries {
+		data, err := readBlob(store, &e)
+		if err != nil {
+			t.Fatalf("readBlob(%s) error = %v", e.Path, err)
+		}
+		clean[e.Path] = string(data)
+	}
+	wantClean := map[string]string{
+		"same.txt":     "same\n",
+		"edit.txt":     lines("A", "b", "c", "D"),
+		"dir/file.txt": "in dir\n",
+		"dir/new.txt":  "new\n",
+	}
+	if len(clean) != len(wantClean) {
+		t.Errorf("Trees() clean entries = %v, want %v", clean, wantClean)
+	}
+	for p, want := range wantClean {
+		if clean[p] != want {
+			t.Errorf("Trees() %s = %q, want %q", p, clean[p], want)
+		}
+	}
+
+	wantConflicts := map[string]ConflictType{
+		"added.txt":    ConflictAddAdd,
+		"both.txt":     ConflictContent,
+		"deleted.txt":  ConflictModifyDelete,
+		"modified.txt": ConflictDeleteModify,
+	}
+	if result.Clean() || len(result.Conflicts) != len(wantConflicts) {
+		t.Fatalf("Trees() conflicts = %v, want %v", result.Conflicts, wantConflicts)
+	}
+	for _, c := range result.Conflicts {
+		if c.Type != wantConflicts[c.Path] {
+			t.Errorf("conflict %s type = %s, want %s", c.Path, c.Type, wantConflicts[c.Path])
+		}
+	}
+
+	byPath := make(map[string]Conflict)
+	for _, c := range result.Conflicts {
+		byPath[c.Path] = c
+	}
+	if got := string(byPath["both.txt"].Content); !strings.Contains(got, "<<<<<<< ours\nours\n=======\ntheirs\n>>>>>>> theirs\n") {
+		t.Errorf("both.txt content = %q, want conflict markers", got)
+	}
+	if got := string(byPath["deleted.txt"].Content); got != "changed by ours\n" {
+		t.Errorf("deleted.txt content = %q, want our version", got)
+	}
+	if got := string(byPath["modified.txt"].Content); got != "theirs\n" {
+		t.Errorf("modified.txt content = %q, want their version", got)
+	}
+	if byPath["added.txt"].Base != nil || byPath["modified.txt"].Ours != nil {
+		t.Errorf("conflict sides not recorded as missing")
+	}
+}
+
+func TestTreesRenames(t *testing.T) {
+	store := newStore(t)
+	content := lines("one", "two", "three", "four", "five", "six")
+	base := buildTree(t, store, map[string]string{
+		"old.txt":   content,
+		"moved.txt": content,
+		"split.txt": "split\n" + content,
+	})
+	ours := buildTree(t, store, map[string]string{
+		"new.txt":        content,
+		"moved.txt":      lines("ONE", "two", "three", "four", "five", "six"),
+		"split-ours.txt": "split\n" + content,
+	})
+	theirs := buildTree(t, store, map[string]string{
+		"old.txt":          lines("one", "two", "three", "four", "five", "SIX"),
+		"dir/moved.txt":    lines("one", "two", "three", "four", "five", "SIX"),
+		"split-theirs.txt": "split\n" + content,
+	})
+
+	result, err := Trees(store, base, ours, theirs, Options{})
+	if err != nil {
+		t.Fatalf("Trees() error = %v", err)
+	}
+
+	got := readEntries(t, store, result.Entries)
+	want := map[string]string{
+		"new.txt":       lines("one", "two", "three", "four", "five", "SIX"),
+		"dir/moved.txt": lines("ONE", "two", "three", "four", "five", "SIX"),
+	}
+	if len(got) != len(want) {
+		t.Errorf("Trees() entries = %v, want %v", got, want)
+	}
+	for p, content := range want {
+		if got[p] != content {
+			t.Errorf("Trees() %s = %q, want %q", p, got[p], content)
+		}
+	}
+
+	if len(result.Conflicts) != 2 {
+		t.Fatalf("Trees() conflicts = %v, want rename/rename at both new paths", result.Conflicts)
+	}
+	for _, c := range result.Conflicts {
+		if c.Type != ConflictRenameRename || c.Base == nil || c.Base.Path != "split.txt" {
+			t.Errorf("conflict %s = %s from %v, want rename/rename from split.txt", c.Path, c.Type, c.Base)
+		}
+	}
+
+	result, err = Trees(store, base, ours, theirs, Options{NoRenames: true})
+	if err != nil {
+		t.Fatalf("Trees() error = %v", err)
+	}
+	if got := readEntries(t, store, result.Entries); got["new.txt"] != content {
+		t.Errorf("Trees() without renames new.txt = %q, want it unchanged", got["new.txt"])
+	}
+}
+
+// commit writes a commit of files with the given parents
+func commit(t *testing.T, store Store, files map[string]string, parents ...objects.ObjectID) objects.ObjectID {
+	sig := objects.Signature{Name: "Test", Email: "test@example.com", When: time.Unix(1700000000, 0)}
+	c := objects.NewCommit(buildTree(t, store, files), parents, sig, sig, "commit\n")
+	if err := store.WriteObject(c); err != nil {
+		t.Fatalf("WriteObject() error = %v", err)
+	}
+	return c.ID()
+}
+
+// mergeBases finds the best common ancestors by walking the whole history
+func mergeBases(store Store) BaseFinder {
+	ancestors := func(starts ...objects.ObjectID) map[objects.ObjectID]bool {
+		seen := make(map[objects.ObjectID]bool)
+		queue := append([]objects.ObjectID(nil), starts...)
+		for len(queue) > 0 {
+			id := queue[0]
+			queue = queue[1:]
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			obj, err := store.ReadObject(id)
+			if err != nil {
+				panic(err)
+			}
+			queue = append(queue, obj.(*objects.Commit).Parents()...)
+		}
+		return seen
+	}
+
+	return func(a []objects.ObjectID, b objects.ObjectID) ([]objects.ObjectID, error) {
+		ofA, ofB := ancestors(a...), ancestors(b)
+		var common []objects.ObjectID
+		for id := range ofA {
+			if ofB[id] {
+				common = append(common, id)
+			}
+		}
+
+		var best []objects.ObjectID
+		for _, id := range common {
+			dominated := false
+			for _, other := range common {
+				if other != id && ancestors(other)[id] {
+					dominated = true
+					break
+				}
+			}
+			if !dominated {
+				best = append(best, id)
+			}
+		}
+		sort.Slice(best, func(i, j int) bool { return best[i].String() < best[j].String() })
+		return best, nil
+	}
+}
+
+func TestCommitsCrissCross(t *testing.T) {
+	store := newStore(t)
+	root := commit(t, store, map[string]string{"f": lines("1", "2", "3", "4", "5")})
+	left := commit(t, store, map[string]string{"f": lines("one", "2", "3", "4", "5")}, root)
+	right := commit(t, store, map[string]string{"f": lines("1", "2", "3", "4", "five")}, root)
+
+	// Each side merges the other, then keeps going
+	ours := commit(t, store, map[string]string{"f": lines("one", "2", "B", "4", "five")}, left, right)
+	theirs := commit(t, store, map[string]string{"f": lines("one", "2", "3", "4", "five", "six")}, right, left)
+
+	bases := mergeBases(store)
+	found, err := bases([]objects.ObjectID{ours}, theirs)
+	if err != nil || len(found) != 2 {
+		t.Fatalf("merge bases = %v, %v; want two", found, err)
+	}
+
+	result, err := Commits(store, bases, ours, theirs, Options{})
+	if err != nil {
+		t.Fatalf("Commits() error = %v", err)
+	}
+	if !result.Clean() {
+		t.Fatalf("Commits() conflicts = %v, want a clean merge", result.Conflicts)
+	}
+	if got, want := readEntries(t, store, result.Entries)["f"], lines("one", "2", "B", "4", "five", "six"); got != want {
+		t.Errorf("Commits() f = %q, want %q", got, want)
+	}
+
+	// With only the left ancestor as the base both sides change the end
+	single := func(a []objects.ObjectID, b objects.ObjectID) ([]objects.ObjectID, error) {
+		return []objects.ObjectID{left}, nil
+	}
+	result, err = Commits(store, single, ours, theirs, Options{})
+	if err != nil {
+		t.Fatalf("Commits() error = %v", err)
+	}
+	if result.Clean() {
+		t.Errorf("Commits() with one base is clean, want a conflict")
+	}
+}
+
+func TestVirtualBaseKeepsConflicts(t *testing.T) {
+	store := newStore(t)
+	root := commit(t, store, map[string]string{"f": "base\n"})
+	left := commit(t, store, map[string]string{"f": "left\n"}, root)
+	right := commit(t, store, map[string]string{"f": "right\n"}, root)
+
+	tree, err := VirtualBase(store, mergeBases(store), []objects.ObjectID{left, right}, Options{Style: StyleDiff3, Favor: FavorOurs})
+	if err != nil {
+		t.Fatalf("VirtualBase() error = %v", err)
+	}
+	entries, err := ReadTree(store, tree)
+	if err != nil {
+		t.Fatalf("ReadTree() error = %v", err)
+	}
+	want := "<<<<<<< Temporary merge branch 1\nleft\n=======\nright\n>>>>>>> Temporary merge branch 2\n"
+	if got := readEntries(t, store, entries)["f"]; got != want {
+		t.Errorf("VirtualBase() f = %q, want %q", got, want)
+	}
+}
+
+func TestTreesDirectoryRenames(t *testing.T) {
+	store := newStore(t)
+	a, b := lines("a1", "a2", "a3", "a4"), lines("b1", "b2", "b3", "b4")
+	base := buildTree(t, store, map[string]string{"lib/a.go": a, "lib/b.go": b})
+	ours := buildTree(t, store, map[string]string{"pkg/a.go": a, "pkg/b.go": b})
+	theirs := buildTree(t, store, map[string]string{"lib/a.go": a, "lib/b.go": b, "lib/c.go": "new\n"})
+
+	result, err := Trees(store, base, ours, theirs, Options{DirectoryRenames: true})
+	if err != nil {
+		t.Fatalf("Trees() error = %v", err)
+	}
+	got := readEntries(t, store, result.Entries)
+	if got["pkg/c.go"] != "new\n" || got["lib/c.go"] != "" {
+		t.Errorf("Trees() entries = %v, want lib/c.go moved to pkg/c.go", got)
+	}
+
+	result, err = Trees(store, base, ours, theirs, Options{})
+	if err != nil {
+		t.Fatalf("Trees() error = %v", err)
+	}
+	if got := readEntries(t, store, result.Entries); got["lib/c.go"] != "new\n" {
+		t.Errorf("Trees() entries = %v, want lib/c.go left in place", got)
+	}
+}
+
+func TestOctopus(t *testing.T) {
+	store := newStore(t)
+	root := commit(t, store, map[string]string{"a": "a\n", "b": "b\n", "c": "c\n"})
+	ours := commit(t, store, map[string]string{"a": "A\n", "b": "b\n", "c": "c\n"}, root)
+	one := commit(t, store, map[string]string{"a": "a\n", "b": "B\n", "c": "c\n"}, root)
+	two := commit(t, store, map[string]string{"a": "a\n", "b": "b\n", "c": "C\n"}, root)
+
+	bases := mergeBases(store)
+	result, merged, err := Octopus(store, bases, ours, []objects.ObjectID{one, root, two}, nil, Options{})
+	if err != nil {
+		t.Fatalf("Octopus() error = %v", err)
+	}
+	if len(merged) != 2 || merged[0] != one || merged[1] != two {
+		t.Errorf("Octopus() merged = %v, want the two heads not yet contained", merged)
+	}
+	got := readEntries(t, store, result.Entries)
+	if got["a"] != "A\n" || got["b"] != "B\n" || got["c"] != "C\n" {
+		t.Errorf("Octopus() entries = %v, want every change", got)
+	}
+
+	clash := commit(t, store, map[string]string{"a": "clash\n", "b": "b\n", "c": "c\n"}, root)
+	if _, _, err := Octopus(store, bases, ours, []objects.ObjectID{clash, one}, nil, Options{}); err != ErrOctopusConflict {
+		t.Errorf("Octopus() error = %v, want ErrOctopusConflict", err)
+	}
+	result, _, err = Octopus(store, bases, ours, []objects.ObjectID{one, clash}, []string{"one", "clash"}, Options{})
+	if err != nil {
+		t.Fatalf("Octopus() error = %v", err)
+	}
+	if len(result.Conflicts) != 1 || !strings.Contains(string(result.Conflicts[0].Content), ">>>>>>> clash\n") {
+		t.Errorf("Octopus() conflicts = %v, want a conflict in a labeled clash", result.Conflicts)
+	}
+}
@@ -0,0 +1,83 @@
+package merge
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+// ErrOctopusConflict is returned when a head other than the last one does
+// not merge cleanly. An octopus merge only leaves the conflicts of its last
+// head to be resolved by hand.
+var ErrOctopusConflict = errors.New("merge with strategy octopus failed")
+
+// Octopus merges several heads into commit ours, one after another, each
+// against its merge base with ours and the heads merged before it. Heads
+// already contained in those are skipped; merged lists the others in
+// order, the parents the merge commit gets besides ours. names labels the
+// heads in conflict markers.
+func Octopus(store Store, bases BaseFinder, ours objects.ObjectID, heads []objects.ObjectID, names []string, opts Options) (result *Result, merged []objects.ObjectID, err error) {
+	tree, err := commitTree(store, ours)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	done := []objects.ObjectID{ours}
+	for i, head := range heads {
+		found, err := bases(done, head)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to find merge base: %w", err)
+		}
+		if containsID(found, head) {
+			continue
+		}
+
+		baseTree, err := VirtualBase(store, bases, found, opts)
+		if err != nil {
+			return nil, nil, err
+		}
+		headTree, err := commitTree(store, head)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		headOpts := opts
+		if i < len(names) {
+			headOpts.TheirsLabel = names[i]
+		}
+		if result, err = Trees(store, baseTree, tree, headTree, headOpts); err != nil {
+			return nil, nil, err
+		}
+		merged = append(merged, head)
+		done = append(done, head)
+
+		if !result.Clean() {
+			if i < len(heads)-1 {
+				return nil, nil, ErrOctopusConflict
+			}
+			return result, merged, nil
+		}
+		if tree, err = WriteTree(store, result.Entries); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if result == nil {
+		entries, err := ReadTree(store, tree)
+		if err != nil {
+			return nil, nil, err
+		}
+		result = &Result{Entries: entries}
+	}
+	return result, merged, nil
+}
+
+func containsID(ids []objects.ObjectID, id objects.ObjectID) bool {
+	for _, other := range ids {
+		if other == id {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,136 @@
+package merge
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+// BaseFinder returns the best common ancestors of commit b and any of the
+// commits in a: those that are not ancestors of another common ancestor
+type BaseFinder func(a []objects.ObjectID, b objects.ObjectID) ([]objects.ObjectID, error)
+
+// Commits merges commit theirs into commit ours with the recursive
+// strategy. When the commits have more than one best common ancestor, as
+// after criss-cross merges, the ancestors are merged with each other into a
+// virtual ancestor first, and that is the base of the merge.
+func Commits(store Store, bases BaseFinder, ours, theirs objects.ObjectID, opts Options) (*Result, error) {
+	found, err := bases([]objects.ObjectID{ours}, theirs)
+	if err != nil {
+		return nil, fmt.Errorf("failed to find merge base: %w", err)
+	}
+	if opts.BaseLabel == "" {
+		switch {
+		case len(found) == 1:
+			opts.BaseLabel = found[0].Short()
+		case len(found) > 1:
+			opts.BaseLabel = "merged common ancestors"
+		}
+	}
+	baseTree, err := VirtualBase(store, bases, found, opts)
+	if err != nil {
+		return nil, err
+	}
+
+	oursTree, err := commitTree(store, ours)
+	if err != nil {
+		return nil, err
+	}
+	theirsTree, err := commitTree(store, theirs)
+	if err != nil {
+		return nil, err
+	}
+	return Trees(store, baseTree, oursTree, theirsTree, opts)
+}
+
+// VirtualBase returns the tree to use as the base of a merge with the given
+// merge bases: an empty tree for none, the tree of a single one, and
+// otherwise the merge of all of them. Conflicts in that merge are kept,
+// markers and all, so they show up again in the merge that uses it.
+func VirtualBase(store Store, bases BaseFinder, commits []objects.ObjectID, opts Options) (objects.ObjectID, error) {
+	if len(commits) == 0 {
+		return objects.ObjectID{}, nil
+	}
+	tree, err := commitTree(store, commits[0])
+	if err != nil {
+		return objects.ObjectID{}, err
+	}
+
+	// Merges between ancestors are never resolved by favoring a side and
+	// use plain markers, as in Git
+	inner := opts
+	inner.OursLabel = "Temporary merge branch 1"
+	inner.TheirsLabel = "Temporary merge branch 2"
+	inner.BaseLabel = ""
+	inner.Style = StyleMerge
+	inner.Favor = FavorNone
+
+	merged := commits[:1]
+	for _, next := range commits[1:] {
+		found, err := bases(merged, next)
+		if err != nil {
+			return objects.ObjectID{}, fmt.Errorf("failed to find merge base: %w", err)
+		}
+		innerBase, err := VirtualBase(store, bases, found, opts)
+		if err != nil {
+			return objects.ObjectID{}, err
+		}
+		nextTree, err := commitTree(store, next)
+		if err != nil {
+			return objects.ObjectID{}, err
+		}
+
+		result, err := Trees(store, innerBase, tree, nextTree, inner)
+		if err != nil {
+			return objects.ObjectID{}, err
+		}
+		entries, err := virtualEntries(store, result)
+		if err != nil {
+			return objects.ObjectID{}, err
+		}
+		if tree, err = WriteTree(store, entries); err != nil {
+			return objects.ObjectID{}, err
+		}
+		merged = append(merged[:len(merged):len(merged)], next)
+	}
+	return tree, nil
+}
+
+// virtualEntries returns the files of a merge between ancestors. Files both
+// sides changed keep their conflict markers; for other conflicts the base
+// version is kept, so the disagreement is left to the outer merge.
+func virtualEntries(store Store, result *Result) ([]Entry, error) {
+	entries := append([]Entry(nil), result.Entries...)
+	for _, c := range result.Conflicts {
+		switch {
+		case c.Type != ConflictModifyDelete && c.Type != ConflictDeleteModify && isRegular(c.Mode) && c.Content != nil:
+			blob := objects.NewBlob(c.Content)
+			if err := store.WriteObject(blob); err != nil {
+				return nil, fmt.Errorf("failed to write merged %s: %w", c.Path, err)
+			}
+			entries = append(entries, Entry{Path: c.Path, Mode: c.Mode, ID: blob.ID()})
+		case c.Base != nil:
+			entries = append(entries, entryAt(c.Base, c.Path))
+		case c.Ours != nil:
+			entries = append(entries, entryAt(c.Ours, c.Path))
+		case c.Theirs != nil:
+			entries = append(entries, entryAt(c.Theirs, c.Path))
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	return entries, nil
+}
+
+// commitTree returns the root tree of a commit
+func commitTree(store Store, id objects.ObjectID) (objects.ObjectID, error) {
+	obj, err := store.ReadObject(id)
+	if err != nil {
+		return objects.ObjectID{}, fmt.Errorf("failed to read commit %s: %w", id.Short(), err)
+	}
+	commit, ok := obj.(*objects.Commit)
+	if !ok {
+		return objects.ObjectID{}, fmt.Errorf("object %s is not a commit", id.Short())
+	}
+	return commit.Tree(), nil
+}
@@ -0,0 +1,375 @@
+package merge
+
+import (
+	"bytes"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+// DefaultRenameThreshold is the similarity, in percent, a deleted and an
+// added file need to be treated as a rename, as in Git
+const DefaultRenameThreshold = 50
+
+// Similarity scores how much of two file contents is the same, from 0 to
+// 100. It is the size of the lines they share relative to the larger of the
+// two, so appending to a file lowers the score as much as removing from it.
+func Similarity(a, b []byte) int {
+	if bytes.Equal(a, b) {
+		return 100
+	}
+	if len(a) == 0 || len(b) == 0 {
+		return 0
+	}
+
+	counts := make(map[string]int)
+	for _, line := range splitLines(a) {
+		counts[line]++
+	}
+
+	common := 0
+	for _, line := range splitLines(b) {
+		if counts[line] > 0 {
+			counts[line]--
+			common += len(line)
+		}
+	}
+
+	return common * 100 / maxInt(len(a), len(b))
+}
+
+// Candidate is a deleted or added file considered for rename detection
+type Candidate struct {
+	Path    string
+	ID      objects.ObjectID
+	Content []byte
+}
+
+// Rename pairs a deleted file with the added file it became, or for a
+// copy a file that is kept with an added file made from it
+type Rename struct {
+	From, To Candidate
+	// Score is the similarity of the two files in percent
+	Score int
+	Copy  bool
+}
+
+// MatchRenames pairs deleted files with added files. Identical files are
+// paired first, then the most similar pairs scoring at least threshold.
+// Each file is used at most once. The renames are sorted by new path.
+func MatchRenames(deleted, added []Candidate, threshold int) []Rename {
+	usedDeleted := make([]bool, len(deleted))
+	usedAdded := make([]bool, len(added))
+	var renames []Rename
+
+	for i, to := range added {
+		for j, from := range deleted {
+			if !usedDeleted[j] && !from.ID.IsZero() && from.ID == to.ID {
+				renames = append(renames, Rename{From: from, To: to, Score: 100})
+				usedAdded[i], usedDeleted[j] = true, true
+				break
+			}
+		}
+	}
+
+	type pair struct{ added, deleted, score int }
+	var pairs []pair
+	for i, to := range added {
+		if usedAdded[i] {
+			continue
+		}
+		for j, from := range deleted {
+			if usedDeleted[j] {
+				continue
+			}
+			if !similarSize(len(from.Content), len(to.Content), threshold) {
+				continue
+			}
+			if score := Similarity(from.Content, to.Content); score >= threshold {
+				pairs = append(pairs, pair{i, j, score})
+			}
+		}
+	}
+
+	sort.SliceStable(pairs, func(i, j int) bool { return pairs[i].score > pairs[j].score })
+	for _, p := range pairs {
+		if usedAdded[p.added] || usedDeleted[p.deleted] {
+			continue
+		}
+		renames = append(renames, Rename{From: deleted[p.deleted], To: added[p.added], Score: p.score})
+		usedAdded[p.added], usedDeleted[p.deleted] = true, true
+	}
+
+	sort.Slice(renames, func(i, j int) bool { return renames[i].To.Path < renames[j].To.Path })
+	return renames
+}
+
+// MatchCopies pairs each added file with the source it was copied from:
+// one with the same content, else the most similar scoring at least
+// threshold, the first in order on ties. A source may be copied many
+// times. The copies are sorted by new path.
+func MatchCopies(sources, added []Candidate, threshold int) []Rename {
+	var copies []Rename
+	for _, to := range added {
+		best := Rename{Score: -1}
+		for _, from := range sources {
+			score := 0
+			switch {
+			case !from.ID.IsZero() && from.ID == to.ID:
+				score = 100
+			case !similarSize(len(from.Content), len(to.Content), threshold):
+				continue
+			default:
+				score = Similarity(from.Content, to.Content)
+			}
+			if score >= threshold && score > best.Score {
+				best = Rename{From: from, To: to, Score: score, Copy: true}
+			}
+			if score == 100 {
+				break
+			}
+		}
+		if best.Score >= 0 {
+			copies = append(copies, best)
+		}
+	}
+	sort.Slice(copies, func(i, j int) bool { return copies[i].To.Path < copies[j].To.Path })
+	return copies
+}
+
+// similarSize reports whether files of the two sizes could be similar
+// enough to score threshold, which the size difference alone may rule out
+func similarSize(a, b, threshold int) bool {
+	if a > b {
+		a, b = b, a
+	}
+	return b > 0 && a*100/b >= threshold
+}
+
+// sides are the versions of a file merged at one path
+type sides struct {
+	b, o, t *Entry
+	// other is set when the sides renamed the file to different paths: it
+	// is the version the other side has at its new path
+	other *Entry
+}
+
+// pairRenames lines up the files either side renamed with the other side's
+// version of the old path, so changes made on one side follow the file to
+// its new name on the other. With opts.DirectoryRenames, files one side
+// adds to a directory the other side renamed follow the directory too.
+func pairRenames(store Store, paths map[string]*sides, base, ours, theirs map[string]*Entry, opts Options) error {
+	oursRenames, err := findRenames(store, base, ours, opts.renameThreshold())
+	if err != nil {
+		return err
+	}
+	theirsRenames, err := findRenames(store, base, theirs, opts.renameThreshold())
+	if err != nil {
+		return err
+	}
+
+	for old, to := range oursRenames {
+		b := base[old]
+		theirsTo, renamed := theirsRenames[old]
+		switch {
+		case renamed && theirsTo == to:
+			paths[to] = &sides{b: b, o: ours[to], t: theirs[to]}
+		case renamed:
+			if theirs[to] != nil || ours[theirsTo] != nil {
+				continue
+			}
+			paths[to] = &sides{b: b, o: ours[to], other: theirs[theirsTo]}
+			paths[theirsTo] = &sides{b: b, t: theirs[theirsTo], other: ours[to]}
+		case theirs[old] != nil && theirs[to] == nil:
+			paths[to] = &sides{b: b, o: ours[to], t: theirs[old]}
+		default:
+			continue
+		}
+		delete(paths, old)
+	}
+
+	for old, to := range theirsRenames {
+		if _, ok := oursRenames[old]; ok {
+			continue
+		}
+		if ours[old] != nil && ours[to] == nil {
+			paths[to] = &sides{b: base[old], o: ours[old], t: theirs[to]}
+			delete(paths, old)
+		}
+	}
+
+	if opts.DirectoryRenames {
+		moveIntoRenamedDirs(paths, directoryRenames(oursRenames, ours), true)
+		moveIntoRenamedDirs(paths, directoryRenames(theirsRenames, theirs), false)
+	}
+	return nil
+}
+
+// directoryRenames returns the directories a side renamed: those it left
+// empty whose renamed files mostly went to one other directory
+func directoryRenames(renames map[string]string, side map[string]*Entry) map[string]string {
+	targets := make(map[string]map[string]int)
+	for old, to := range renames {
+		from, dest := path.Dir(old), path.Dir(to)
+		if from == dest || from == "." || dest == "." {
+			continue
+		}
+		if targets[from] == nil {
+			targets[from] = make(map[string]int)
+		}
+		targets[from][dest]++
+	}
+
+	// A directory the side still has files in was not renamed
+	for p := range side {
+		for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+			delete(targets, dir)
+		}
+	}
+
+	dirs := make(map[string]string)
+	for from, counts := range targets {
+		best, bestCount, tied := "", 0, false
+		for dest, n := range counts {
+			switch {
+			case n > bestCount:
+				best, bestCount, tied = dest, n, false
+			case n == bestCount:
+				tied = true
+			}
+		}
+		if !tied {
+			dirs[from] = best
+		}
+	}
+	return dirs
+}
+
+// moveIntoRenamedDirs moves files the other side added to a directory the
+// renaming side renamed into the directory's new name, unless something is
+// already there. oursRenamed tells which side renamed the directories.
+func moveIntoRenamedDirs(paths map[string]*sides, dirs map[string]string, oursRenamed bool) {
+	if len(dirs) == 0 {
+		return
+	}
+
+	var moves []string
+	for p, s := range paths {
+		added := s.b == nil && s.other == nil &&
+			((oursRenamed && s.o == nil && s.t != nil) || (!oursRenamed && s.t == nil && s.o != nil))
+		if added {
+			moves = append(moves, p)
+		}
+	}
+	sort.Strings(moves)
+
+	for _, p := range moves {
+		// The deepest renamed directory holding the file decides
+		for dir := path.Dir(p); dir != "."; dir = path.Dir(dir) {
+			dest, ok := dirs[dir]
+			if !ok {
+				continue
+			}
+			to := dest + strings.TrimPrefix(p, dir)
+			if _, taken := paths[to]; !taken {
+				paths[to] = paths[p]
+				delete(paths, p)
+			}
+			break
+		}
+	}
+}
+
+// findRenames returns the regular files of base that side renamed, mapped
+// to their new paths
+func findRenames(store Store, base, side map[string]*Entry, threshold int) (map[string]string, error) {
+	var deleted, added []*Entry
+	for p, e := range base {
+		if side[p] == nil && isRegular(e.Mode) {
+			deleted = append(deleted, e)
+		}
+	}
+	for p, e := range side {
+		if base[p] == nil && isRegular(e.Mode) {
+			added = append(added, e)
+		}
+	}
+	if len(deleted) == 0 || len(added) == 0 {
+		return nil, nil
+	}
+
+	deletedCandidates, err := readCandidates(store, deleted)
+	if err != nil {
+		return nil, err
+	}
+	addedCandidates, err := readCandidates(store, added)
+	if err != nil {
+		return nil, err
+	}
+
+	renames := make(map[string]string)
+	for _, r := range MatchRenames(deletedCandidates, addedCandidates, threshold) {
+		renames[r.From.Path] = r.To.Path
+	}
+	return renames, nil
+}
+
+// readCandidates reads the content of entries, sorted by path so matching
+// them does not depend on map order
+func readCandidates(store Store, entries []*Entry) ([]Candidate, error) {
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	candidates := make([]Candidate, len(entries))
+	for i, e := range entries {
+		data, err := readBlob(store, e)
+		if err != nil {
+			return nil, err
+		}
+		candidates[i] = Candidate{Path: e.Path, ID: e.ID, Content: data}
+	}
+	return candidates, nil
+}
+
+// renameConflict describes one of the two paths the sides renamed a file
+// to. Both get the content merged from the two renamed versions.
+func renameConflict(store Store, p string, s *sides, opts Options) (*Conflict, error) {
+	conflict := &Conflict{Path: p, Type: ConflictRenameRename, Base: s.b, Ours: s.o, Theirs: s.t}
+
+	ours, theirs, kept := s.o, s.other, s.o
+	if ours == nil {
+		ours, theirs, kept = s.other, s.t, s.t
+	}
+	conflict.Mode = kept.Mode
+
+	if !isRegular(ours.Mode) || !isRegular(theirs.Mode) {
+		data, err := readBlob(store, kept)
+		if err != nil {
+			return nil, err
+		}
+		conflict.Content = data
+		return conflict, nil
+	}
+
+	baseData, err := readBlob(store, s.b)
+	if err != nil {
+		return nil, err
+	}
+	oursData, err := readBlob(store, ours)
+	if err != nil {
+		return nil, err
+	}
+	theirsData, err := readBlob(store, theirs)
+	if err != nil {
+		return nil, err
+	}
+	conflict.Content, _ = MergeContent(baseData, oursData, theirsData, opts)
+	return conflict, nil
+}
+
+func maxInt(a, b int) int {
+	if a > b {
+		return a
+	}
+	return b
+}
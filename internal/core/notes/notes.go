@@ -0,0 +1,189 @@
+// Package notes reads and writes Git notes: text attached to objects
+// without changing them. A notes ref such as refs/notes/commits points to
+// a commit whose tree holds one blob per annotated object, named after the
+// object's hex ID. Large note trees fan out into directories named after
+// the leading digits, as in ab/cdef..., which reading follows; written
+// trees are flat.
+package notes
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+// DefaultRef is the notes ref used unless another is configured
+const DefaultRef = "refs/notes/commits"
+
+// Store reads and writes the objects of notes
+type Store interface {
+	ReadObject(id objects.ObjectID) (objects.Object, error)
+	WriteObject(obj objects.Object) error
+}
+
+// Note is a note and the object it annotates
+type Note struct {
+	Object objects.ObjectID
+	Blob   objects.ObjectID
+}
+
+// Notes is the set of notes of one notes commit, changed in memory until
+// committed
+type Notes struct {
+	store Store
+	tip   objects.ObjectID
+	notes map[objects.ObjectID]objects.ObjectID
+	// other holds the top-level entries that are not notes, kept as they
+	// are
+	other []objects.TreeEntry
+}
+
+// Read reads the notes of the notes commit tip, none when tip is zero
+func Read(store Store, tip objects.ObjectID) (*Notes, error) {
+	n := &Notes{store: store, tip: tip, notes: make(map[objects.ObjectID]objects.ObjectID)}
+	if tip.IsZero() {
+		return n, nil
+	}
+
+	obj, err := store.ReadObject(tip)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read notes commit %s: %w", tip, err)
+	}
+	commit, ok := obj.(*objects.Commit)
+	if !ok {
+		return nil, fmt.Errorf("notes ref points to a %s, not a commit", obj.Type())
+	}
+	if err := n.readTree(commit.Tree(), "", true); err != nil {
+		return nil, err
+	}
+	return n, nil
+}
+
+// readTree adds the notes of tree, whose entries' names continue prefix
+func (n *Notes) readTree(treeID objects.ObjectID, prefix string, top bool) error {
+	obj, err := n.store.ReadObject(treeID)
+	if err != nil {
+		return fmt.Errorf("failed to read notes tree %s: %w", treeID, err)
+	}
+	tree, ok := obj.(*objects.Tree)
+	if !ok {
+		return fmt.Errorf("notes tree %s is a %s", treeID, obj.Type())
+	}
+
+	for _, entry := range tree.Entries() {
+		name := prefix + entry.Name
+		switch {
+		case entry.Mode == objects.ModeTree && len(entry.Name) == 2 && len(name) < 40 && isHex(entry.Name):
+			if err := n.readTree(entry.ID, name, false); err != nil {
+				return err
+			}
+		case entry.Mode != objects.ModeTree && len(name) == 40 && isHex(entry.Name):
+			id, err := objects.NewObjectID(strings.ToLower(name))
+			if err != nil {
+				return err
+			}
+			n.notes[id] = entry.ID
+		case top:
+			n.other = append(n.other, entry)
+		}
+	}
+	return nil
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if !('0' <= c && c <= '9' || 'a' <= c && c <= 'f' || 'A' <= c && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// Tip returns the notes commit the notes were read from, zero for none
+func (n *Notes) Tip() objects.ObjectID {
+	return n.tip
+}
+
+// Get returns the blob of the note on id
+func (n *Notes) Get(id objects.ObjectID) (objects.ObjectID, bool) {
+	blob, ok := n.notes[id]
+	return blob, ok
+}
+
+// Text returns the text of the note on id
+func (n *Notes) Text(id objects.ObjectID) (string, bool, error) {
+	blobID, ok := n.notes[id]
+	if !ok {
+		return "", false, nil
+	}
+	obj, err := n.store.ReadObject(blobID)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to read note %s: %w", blobID, err)
+	}
+	blob, ok := obj.(*objects.Blob)
+	if !ok {
+		return "", false, fmt.Errorf("note %s is a %s, not a blob", blobID, obj.Type())
+	}
+	return string(blob.Data()), true, nil
+}
+
+// Set writes text as the note on id, replacing any note it had
+func (n *Notes) Set(id objects.ObjectID, text string) error {
+	blob := objects.NewBlob([]byte(text))
+	if err := n.store.WriteObject(blob); err != nil {
+		return fmt.Errorf("failed to write note: %w", err)
+	}
+	n.notes[id] = blob.ID()
+	return nil
+}
+
+// Remove removes the note on id, reporting whether there was one
+func (n *Notes) Remove(id objects.ObjectID) bool {
+	if _, ok := n.notes[id]; !ok {
+		return false
+	}
+	delete(n.notes, id)
+	return true
+}
+
+// List returns the notes in the order of the objects they annotate
+func (n *Notes) List() []Note {
+	list := make([]Note, 0, len(n.notes))
+	for id, blob := range n.notes {
+		list = append(list, Note{Object: id, Blob: blob})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Object.String() < list[j].Object.String() })
+	return list
+}
+
+// Commit writes the notes as a commit on top of the one they were read
+// from and returns it; the caller updates the notes ref
+func (n *Notes) Commit(author, committer objects.Signature, message string) (objects.ObjectID, error) {
+	tree := objects.NewTree()
+	for _, note := range n.List() {
+		if err := tree.AddEntry(objects.ModeBlob, note.Object.String(), note.Blob); err != nil {
+			return objects.ObjectID{}, err
+		}
+	}
+	for _, entry := range n.other {
+		if err := tree.AddEntry(entry.Mode, entry.Name, entry.ID); err != nil {
+			return objects.ObjectID{}, err
+		}
+	}
+	if err := n.store.WriteObject(tree); err != nil {
+		return objects.ObjectID{}, fmt.Errorf("failed to write notes tree: %w", err)
+	}
+
+	var parents []objects.ObjectID
+	if !n.tip.IsZero() {
+		parents = []objects.ObjectID{n.tip}
+	}
+	commit := objects.NewCommit(tree.ID(), parents, author, committer, message)
+	if err := n.store.WriteObject(commit); err != nil {
+		return objects.ObjectID{}, fmt.Errorf("failed to write notes commit: %w", err)
+	}
+	n.tip = commit.ID()
+	return commit.ID(), nil
+}
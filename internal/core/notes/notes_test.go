@@ -0,0 +1,119 @@
+package notes
+
+import (
+	"testing"
+	"time"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+func newStore(t *testing.T) *objects.Storage {
+	store := objects.NewStorage(t.TempDir())
+	if err := store.Init(); err != nil {
+		t.Fatalf("Storage.Init() error = %v", err)
+	}
+	return store
+}
+
+func TestNotesRoundTrip(t *testing.T) {
+	store := newStore(t)
+	sig := objects.Signature{Name: "Test", Email: "test@example.com", When: time.Unix(1700000000, 0)}
+	a := objects.NewBlob([]byte("a")).ID()
+	b := objects.NewBlob([]byte("b")).ID()
+
+	n, err := Read(store, objects.ObjectID{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Set(a, "first\n"); err != nil {
+		t.Fatal(err)
+	}
+	if err := n.Set(b, "second\n"); err != nil {
+		t.Fatal(err)
+	}
+	first, err := n.Commit(sig, sig, "Notes added\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	n, err = Read(store, first)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text, ok, err := n.Text(a); err != nil || !ok || text != "first\n" {
+		t.Errorf("Text(a) = %q, %v, %v", text, ok, err)
+	}
+	if list := n.List(); len(list) != 2 {
+		t.Errorf("List() = %v, want 2 notes", list)
+	}
+
+	if !n.Remove(a) || n.Remove(a) {
+		t.Error("Remove() should report the note only once")
+	}
+	second, err := n.Commit(sig, sig, "Notes removed\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, err := store.ReadObject(second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if parents := obj.(*objects.Commit).Parents(); len(parents) != 1 || parents[0] != first {
+		t.Errorf("notes commit parents = %v, want %s", parents, first)
+	}
+	n, err = Read(store, second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := n.Get(a); ok {
+		t.Error("removed note still present")
+	}
+	if _, ok := n.Get(b); !ok {
+		t.Error("kept note missing")
+	}
+}
+
+func TestReadFanout(t *testing.T) {
+	store := newStore(t)
+	annotated := objects.NewBlob([]byte("annotated")).ID()
+	note := objects.NewBlob([]byte("fanned out\n"))
+	if err := store.WriteObject(note); err != nil {
+		t.Fatal(err)
+	}
+
+	// The note sits at ab/cdef... as Git writes large notes trees
+	hex := annotated.String()
+	sub := objects.NewTree()
+	sub.AddEntry(objects.ModeBlob, hex[2:], note.ID())
+	readme := objects.NewBlob([]byte("not a note\n"))
+	top := objects.NewTree()
+	top.AddEntry(objects.ModeTree, hex[:2], sub.ID())
+	top.AddEntry(objects.ModeBlob, "README", readme.ID())
+	sig := objects.Signature{Name: "Test", Email: "test@example.com", When: time.Unix(1700000000, 0)}
+	commit := objects.NewCommit(top.ID(), nil, sig, sig, "Notes\n")
+	for _, obj := range []objects.Object{sub, readme, top, commit} {
+		if err := store.WriteObject(obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	n, err := Read(store, commit.ID())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text, ok, err := n.Text(annotated); err != nil || !ok || text != "fanned out\n" {
+		t.Errorf("Text() = %q, %v, %v", text, ok, err)
+	}
+
+	// Rewriting flattens the notes and keeps what is not a note
+	tip, err := n.Commit(sig, sig, "Rewrite\n")
+	if err != nil {
+		t.Fatal(err)
+	}
+	obj, _ := store.ReadObject(tip)
+	tree, _ := store.ReadObject(obj.(*objects.Commit).Tree())
+	entries := tree.(*objects.Tree).Entries()
+	if len(entries) != 2 || entries[0].Name != hex && entries[1].Name != hex {
+		t.Errorf("rewritten tree = %v", entries)
+	}
+}
@@ -0,0 +1,205 @@
+package objects
+
+import (
+	"container/list"
+	"sync"
+)
+
+// DefaultCacheSize is the default limit of a Cache in bytes
+const DefaultCacheSize = 64 << 20
+
+// Cache is a size-bounded LRU cache of parsed commits, trees and tags, the
+// objects history walks read over and over. One Cache may be shared by
+// several Storages. Objects never change once written, so entries only go
+// stale when objects are deleted; Invalidate handles that by starting a new
+// generation, and entries from an older generation are treated as missing.
+type Cache struct {
+	mu         sync.Mutex
+	limit      int64
+	size       int64
+	generation uint64
+	entries    map[ObjectID]*list.Element
+	lru        *list.List // most recently used at the front
+	hits       uint64
+	misses     uint64
+	evictions  uint64
+}
+
+type cacheEntry struct {
+	obj        Object
+	size       int64
+	generation uint64
+}
+
+// CacheStats reports how a Cache has been used
+type CacheStats struct {
+	Hits      uint64
+	Misses    uint64
+	Evictions uint64
+	Entries   int
+	Size      int64
+	Limit     int64
+}
+
+// HitRate returns the fraction of lookups that were hits
+func (s CacheStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+// NewCache creates a cache holding up to limit bytes of object content. A
+// limit of zero or less disables caching.
+func NewCache(limit int64) *Cache {
+	return &Cache{
+		limit:   limit,
+		entries: make(map[ObjectID]*list.Element),
+		lru:     list.New(),
+	}
+}
+
+// cacheable reports whether objects of type t are kept in a Cache
+func cacheable(t ObjectType) bool {
+	return t == TypeCommit || t == TypeTree || t == TypeTag
+}
+
+// Get returns the cached object with the given ID. Only hits are counted;
+// a miss is counted when the object read instead is passed to Load, so
+// lookups of blobs, which are never cached, do not lower the hit rate.
+func (c *Cache) Get(id ObjectID) (Object, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.lookup(id)
+	if !ok {
+		return nil, false
+	}
+	c.hits++
+	c.lru.MoveToFront(elem)
+	return elem.Value.(*cacheEntry).obj, true
+}
+
+// Contains reports whether the object is cached, without counting a lookup
+func (c *Cache) Contains(id ObjectID) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	_, ok := c.lookup(id)
+	return ok
+}
+
+// lookup finds the entry for id, dropping it if it is from an older
+// generation
+func (c *Cache) lookup(id ObjectID) (*list.Element, bool) {
+	elem, ok := c.entries[id]
+	if !ok {
+		return nil, false
+	}
+	if elem.Value.(*cacheEntry).generation != c.generation {
+		c.remove(id, elem)
+		return nil, false
+	}
+	return elem, true
+}
+
+// Add caches obj, whose serialized content is size bytes. Blobs and
+// objects larger than the limit are ignored.
+func (c *Cache) Add(obj Object, size int64) {
+	if !cacheable(obj.Type()) {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.add(obj, size)
+}
+
+// Load caches obj after a lookup for it missed and it was read from disk
+func (c *Cache) Load(obj Object, size int64) {
+	if !cacheable(obj.Type()) {
+		return
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.misses++
+	c.add(obj, size)
+}
+
+func (c *Cache) add(obj Object, size int64) {
+	if size > c.limit {
+		return
+	}
+
+	id := obj.ID()
+	if elem, ok := c.entries[id]; ok {
+		c.remove(id, elem)
+	}
+	c.entries[id] = c.lru.PushFront(&cacheEntry{obj: obj, size: size, generation: c.generation})
+	c.size += size
+	c.evict()
+}
+
+// Remove drops the object with the given ID from the cache
+func (c *Cache) Remove(id ObjectID) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[id]; ok {
+		c.remove(id, elem)
+	}
+}
+
+// Invalidate starts a new generation, so everything cached so far is
+// treated as missing. It is used after objects may have been deleted.
+func (c *Cache) Invalidate() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+}
+
+// SetLimit changes the size limit, evicting entries if needed
+func (c *Cache) SetLimit(limit int64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.limit = limit
+	c.evict()
+}
+
+// Stats returns the cache's counters
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return CacheStats{
+		Hits:      c.hits,
+		Misses:    c.misses,
+		Evictions: c.evictions,
+		Entries:   len(c.entries),
+		Size:      c.size,
+		Limit:     c.limit,
+	}
+}
+
+// evict drops least recently used entries until the cache fits its limit
+func (c *Cache) evict() {
+	for c.size > c.limit && c.lru.Len() > 0 {
+		elem := c.lru.Back()
+		entry := elem.Value.(*cacheEntry)
+		c.remove(entry.obj.ID(), elem)
+		if entry.generation == c.generation {
+			c.evictions++
+		}
+	}
+}
+
+func (c *Cache) remove(id ObjectID, elem *list.Element) {
+	c.lru.Remove(elem)
+	delete(c.entries, id)
+	c.size -= elem.Value.(*cacheEntry).size
+}
@@ -0,0 +1,128 @@
+package objects
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func testCommit(message string) (*Commit, int64) {
+	sig := Signature{Name: "Test", Email: "test@example.com", When: time.Unix(0, 0)}
+	commit := NewCommit(ObjectID{}, nil, sig, sig, message)
+	data, _ := commit.Serialize()
+	return commit, int64(len(data))
+}
+
+func TestCache_LRUEviction(t *testing.T) {
+	a, size := testCommit("a\n")
+	b, _ := testCommit("b\n")
+	c, _ := testCommit("c\n")
+
+	cache := NewCache(2 * size)
+	cache.Add(a, size)
+	cache.Add(b, size)
+
+	// Touch a so b is the least recently used
+	if _, ok := cache.Get(a.ID()); !ok {
+		t.Fatal("expected a to be cached")
+	}
+	cache.Add(c, size)
+
+	if _, ok := cache.Get(b.ID()); ok {
+		t.Error("expected b to be evicted")
+	}
+	for _, commit := range []*Commit{a, c} {
+		if _, ok := cache.Get(commit.ID()); !ok {
+			t.Errorf("expected %s to be cached", commit.Message())
+		}
+	}
+
+	stats := cache.Stats()
+	if stats.Entries != 2 || stats.Size != 2*size || stats.Evictions != 1 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	cache.SetLimit(size)
+	if stats := cache.Stats(); stats.Entries != 1 || stats.Evictions != 2 {
+		t.Errorf("unexpected stats after SetLimit %+v", stats)
+	}
+}
+
+func TestCache_Invalidate(t *testing.T) {
+	a, size := testCommit("a\n")
+	cache := NewCache(DefaultCacheSize)
+	cache.Add(a, size)
+
+	cache.Invalidate()
+	if cache.Contains(a.ID()) {
+		t.Error("expected entry from an older generation to be dropped")
+	}
+	if stats := cache.Stats(); stats.Entries != 0 || stats.Size != 0 || stats.Evictions != 0 {
+		t.Errorf("unexpected stats %+v", stats)
+	}
+
+	cache.Add(a, size)
+	if !cache.Contains(a.ID()) {
+		t.Error("expected entry added after Invalidate to be cached")
+	}
+}
+
+func TestCache_SkipsBlobs(t *testing.T) {
+	cache := NewCache(DefaultCacheSize)
+	blob := NewBlob([]byte("data"))
+	cache.Load(blob, 4)
+	if cache.Contains(blob.ID()) {
+		t.Error("blobs should not be cached")
+	}
+
+	tree := NewTree()
+	cache.Load(tree, 0)
+	if !cache.Contains(tree.ID()) {
+		t.Error("trees should be cached")
+	}
+
+	if stats := cache.Stats(); stats.Misses != 1 {
+		t.Errorf("expected only the tree load to count as a miss, got %+v", stats)
+	}
+
+	huge, size := testCommit("huge\n")
+	small := NewCache(size - 1)
+	small.Add(huge, size)
+	if small.Contains(huge.ID()) {
+		t.Error("objects larger than the limit should not be cached")
+	}
+}
+
+func TestStorage_CacheStats(t *testing.T) {
+	storage := NewStorage(filepath.Join(t.TempDir(), ".git"))
+	if err := storage.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	commit, _ := testCommit("cached\n")
+	if err := storage.WriteObject(commit); err != nil {
+		t.Fatalf("WriteObject() error = %v", err)
+	}
+
+	// A fresh cache has to read the commit from disk once
+	storage.SetCache(NewCache(DefaultCacheSize))
+	for i := 0; i < 3; i++ {
+		if _, err := storage.ReadObject(commit.ID()); err != nil {
+			t.Fatalf("ReadObject() error = %v", err)
+		}
+	}
+
+	stats := storage.Cache().Stats()
+	if stats.Hits != 2 || stats.Misses != 1 {
+		t.Errorf("expected 2 hits and 1 miss, got %+v", stats)
+	}
+	if rate := stats.HitRate(); rate < 0.66 || rate > 0.67 {
+		t.Errorf("HitRate() = %v", rate)
+	}
+
+	if err := storage.RemoveLooseObject(commit.ID()); err != nil {
+		t.Fatalf("RemoveLooseObject() error = %v", err)
+	}
+	if storage.HasObject(commit.ID()) {
+		t.Error("removed object should not be found in the cache")
+	}
+}
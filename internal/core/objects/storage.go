@@ -7,22 +7,141 @@ import (
 	"io"
 	"os"
 	"path/filepath"
-	"sync"
+	"strings"
+	"sync/atomic"
+
+	"github.com/fenilsonani/vcs/internal/core/fault"
+	"github.com/fenilsonani/vcs/internal/core/gitdir"
+	"github.com/fenilsonani/vcs/internal/trace"
 )
 
 // Storage handles reading and writing git objects
 type Storage struct {
 	basePath string
-	mu       sync.RWMutex
-	cache    map[ObjectID]Object // Simple in-memory cache
+	cache    *Cache
+	packed   PackedObjects
+	fetcher  ObjectFetcher
+	// replacements maps objects to those read in their place
+	replacements map[ObjectID]ObjectID
+}
+
+// MaxReplaceDepth is the longest chain of replacements followed, as in Git
+const MaxReplaceDepth = 5
+
+// PackedObjects provides objects that are not stored loose, such as those
+// in packfiles
+type PackedObjects interface {
+	ReadPackedObject(id ObjectID) (ObjectType, []byte, error)
+	HasPackedObject(id ObjectID) bool
+	// PackedObjectSize returns the number of bytes id takes in its pack
+	PackedObjectSize(id ObjectID) (int64, error)
+	// FindPackedObjects returns the objects whose hex name starts with prefix
+	FindPackedObjects(prefix string) ([]ObjectID, error)
+}
+
+// ObjectFetcher fetches objects that are neither loose nor packed, such as
+// those a partial clone leaves to its promisor remote
+type ObjectFetcher interface {
+	// FetchObjects stores the objects ids in the storage the fetcher is
+	// set on, returning once they can be read
+	FetchObjects(ids []ObjectID) error
 }
 
-// NewStorage creates a new object storage
+// NewStorage creates a new object storage. A linked worktree uses the
+// objects of its common directory.
 func NewStorage(gitDir string) *Storage {
 	return &Storage{
-		basePath: filepath.Join(gitDir, "objects"),
-		cache:    make(map[ObjectID]Object),
+		basePath: filepath.Join(gitdir.CommonDir(gitDir), "objects"),
+		cache:    NewCache(DefaultCacheSize),
+	}
+}
+
+// SetCache replaces the cache of parsed objects, so several storages can
+// share one
+func (s *Storage) SetCache(cache *Cache) {
+	s.cache = cache
+}
+
+// Cache returns the cache of parsed objects
+func (s *Storage) Cache() *Cache {
+	return s.cache
+}
+
+// SetPackedObjects sets where objects missing from the loose object
+// directory are looked up
+func (s *Storage) SetPackedObjects(packed PackedObjects) {
+	s.packed = packed
+}
+
+// PackedObjects returns where objects missing from the loose object
+// directory are looked up, or nil
+func (s *Storage) PackedObjects() PackedObjects {
+	return s.packed
+}
+
+// SetFetcher sets how objects the storage lacks are fetched when read. Only
+// reads fetch: HasObject still reports what is stored locally.
+func (s *Storage) SetFetcher(fetcher ObjectFetcher) {
+	s.fetcher = fetcher
+}
+
+// Fetcher returns how objects the storage lacks are fetched, or nil
+func (s *Storage) Fetcher() ObjectFetcher {
+	return s.fetcher
+}
+
+// FetchMissing fetches in one batch those of ids the storage lacks, so
+// reading many objects does not fetch them one by one. Without a fetcher
+// it does nothing.
+func (s *Storage) FetchMissing(ids []ObjectID) error {
+	if s.fetcher == nil {
+		return nil
+	}
+	var missing []ObjectID
+	seen := make(map[ObjectID]bool)
+	for _, id := range ids {
+		if !seen[id] && !s.HasObject(id) {
+			seen[id] = true
+			missing = append(missing, id)
+		}
 	}
+	if len(missing) == 0 {
+		return nil
+	}
+	trace.General.Printf("object: fetching %d missing objects", len(missing))
+	return s.fetcher.FetchObjects(missing)
+}
+
+// SetReplacements sets the objects read in place of others, as the
+// refs/replace refs say, or nil to read every object as stored
+func (s *Storage) SetReplacements(replacements map[ObjectID]ObjectID) {
+	s.replacements = replacements
+}
+
+// Replacements returns the objects read in place of others
+func (s *Storage) Replacements() map[ObjectID]ObjectID {
+	return s.replacements
+}
+
+// replacement returns the object read in place of id, following chains of
+// replacements, or id itself when it has none
+func (s *Storage) replacement(id ObjectID) (ObjectID, error) {
+	target := id
+	for depth := 0; ; depth++ {
+		next, ok := s.replacements[target]
+		if !ok {
+			return target, nil
+		}
+		if depth == MaxReplaceDepth {
+			return id, fmt.Errorf("replace depth too high for object %s", id)
+		}
+		target = next
+	}
+}
+
+// PackDir returns the directory holding the repository's packfiles
+func (s *Storage) PackDir() string {
+	return filepath.Join(s.basePath, "pack")
 }
 
 // Init initializes the object storage directory structure
@@ -80,66 +199,105 @@ func (s *Storage) WriteObject(obj Object) error {
 		return fmt.Errorf("failed to compress object: %w", err)
 	}
 	
-	// Write to loose object file
-	path := s.objectPath(id)
-	dir := filepath.Dir(path)
-	
-	// Ensure directory exists
-	if err := os.MkdirAll(dir, 0755); err != nil {
-		return fmt.Errorf("failed to create object directory: %w", err)
+	if err := s.writeLooseObject(id, compressed); err != nil {
+		return err
 	}
 	
-	// Write atomically using a temporary file
-	tmpPath := path + ".tmp"
-	if err := os.WriteFile(tmpPath, compressed, 0444); err != nil {
-		return fmt.Errorf("failed to write object file: %w", err)
-	}
-	
-	// Rename to final location
-	if err := os.Rename(tmpPath, path); err != nil {
-		os.Remove(tmpPath)
-		return fmt.Errorf("failed to finalize object file: %w", err)
-	}
-	
-	// Update cache
-	s.mu.Lock()
-	s.cache[id] = obj
-	s.mu.Unlock()
+	s.cache.Add(obj, int64(len(data)))
 	
 	return nil
 }
 
-// ReadObject reads an object from storage
+// ReadObject reads an object from storage. A replaced object is read from
+// its replacement but keeps its own ID.
 func (s *Storage) ReadObject(id ObjectID) (Object, error) {
+	// The cache, which storages ignoring replacements may share, only
+	// holds objects as stored
+	if _, ok := s.replacements[id]; ok {
+		objType, data, err := s.ReadRawObject(id)
+		if err != nil {
+			return nil, err
+		}
+		return ParseObject(id, objType, data)
+	}
+
 	// Check cache first
-	s.mu.RLock()
-	if obj, ok := s.cache[id]; ok {
-		s.mu.RUnlock()
+	if obj, ok := s.cache.Get(id); ok {
 		return obj, nil
 	}
-	s.mu.RUnlock()
 	
+	objType, data, err := s.ReadRawObject(id)
+	if err != nil {
+		return nil, err
+	}
+	
+	obj, err := ParseObject(id, objType, data)
+	if err != nil {
+		return nil, err
+	}
+	
+	s.cache.Load(obj, int64(len(data)))
+	
+	return obj, nil
+}
+
+// ReadRawObject reads the type and uncompressed content of an object
+// without parsing it, that of its replacement if it has one. An object the
+// storage lacks is fetched when there is a fetcher.
+func (s *Storage) ReadRawObject(id ObjectID) (ObjectType, []byte, error) {
+	if len(s.replacements) > 0 {
+		var err error
+		if id, err = s.replacement(id); err != nil {
+			return "", nil, err
+		}
+	}
+
+	objType, data, found, err := s.readRawObject(id)
+	if err != nil || found {
+		return objType, data, err
+	}
+	if s.fetcher != nil {
+		trace.General.Printf("object: fetching missing %s", id)
+		if err := s.fetcher.FetchObjects([]ObjectID{id}); err != nil {
+			return "", nil, fmt.Errorf("object not found: %s: %w", id, err)
+		}
+		if objType, data, found, err = s.readRawObject(id); err != nil || found {
+			return objType, data, err
+		}
+	}
+	return "", nil, fmt.Errorf("object not found: %s", id)
+}
+
+// readRawObject reads an object stored loose or packed, reporting whether
+// it was found
+func (s *Storage) readRawObject(id ObjectID) (ObjectType, []byte, bool, error) {
 	// Read from loose object
 	path := s.objectPath(id)
 	compressed, err := os.ReadFile(path)
 	if err != nil {
 		if os.IsNotExist(err) {
-			// TODO: Check packfiles
-			return nil, fmt.Errorf("object not found: %s", id)
+			if s.packed != nil && s.packed.HasPackedObject(id) {
+				objType, data, err := s.packed.ReadPackedObject(id)
+				if err == nil {
+					trace.General.Printf("object: read %s %s (packed)", objType, id)
+				}
+				return objType, data, err == nil, err
+			}
+			return "", nil, false, nil
 		}
-		return nil, fmt.Errorf("failed to read object file: %w", err)
+		return "", nil, false, fmt.Errorf("failed to read object file: %w", err)
 	}
 	
 	// Decompress data
 	fullData, err := decompressData(compressed)
 	if err != nil {
-		return nil, fmt.Errorf("failed to decompress object: %w", err)
+		return "", nil, false, fmt.Errorf("failed to decompress object: %w", err)
 	}
 	
 	// Parse header
 	nullIdx := bytes.IndexByte(fullData, 0)
 	if nullIdx == -1 {
-		return nil, fmt.Errorf("invalid object format: no null byte")
+		return "", nil, false, fmt.Errorf("invalid object format: no null byte")
 	}
 	
 	header := string(fullData[:nullIdx])
@@ -149,49 +307,83 @@ func (s *Storage) ReadObject(id ObjectID) (Object, error) {
 	var objType string
 	var size int
 	if _, err := fmt.Sscanf(header, "%s %d", &objType, &size); err != nil {
-		return nil, fmt.Errorf("invalid object header: %s", header)
+		return "", nil, false, fmt.Errorf("invalid object header: %s", header)
 	}
 	
 	if len(data) != size {
-		return nil, fmt.Errorf("object size mismatch: expected %d, got %d", size, len(data))
+		return "", nil, false, fmt.Errorf("object size mismatch: expected %d, got %d", size, len(data))
 	}
 	
-	// Parse object based on type
-	var obj Object
-	switch ObjectType(objType) {
+	trace.General.Printf("object: read %s %s (loose)", objType, id)
+	return ObjectType(objType), data, true, nil
+}
+
+// ParseObject parses raw object content of the given type
+func ParseObject(id ObjectID, objType ObjectType, data []byte) (Object, error) {
+	switch objType {
 	case TypeBlob:
-		obj = ParseBlob(id, data)
+		return ParseBlob(id, data), nil
 	case TypeTree:
-		obj, err = ParseTree(id, data)
+		return ParseTree(id, data)
 	case TypeCommit:
-		obj, err = ParseCommit(id, data)
+		return ParseCommit(id, data)
 	case TypeTag:
-		obj, err = ParseTag(id, data)
+		return ParseTag(id, data)
 	default:
 		return nil, fmt.Errorf("unknown object type: %s", objType)
 	}
+}
+
+// WriteRawObject writes already-serialized object content to storage. Unlike
+// WriteObject it stores the bytes verbatim, so objects with headers this
+// package does not model (signatures, encodings) keep their original ID.
+func (s *Storage) WriteRawObject(objType ObjectType, data []byte) (ObjectID, error) {
+	if !objType.IsValid() {
+		return ObjectID{}, fmt.Errorf("invalid object type: %s", objType)
+	}
 	
-	if err != nil {
-		return nil, err
+	id := ComputeHash(objType, data)
+	if s.HasObject(id) {
+		return id, nil
 	}
 	
-	// Update cache
-	s.mu.Lock()
-	s.cache[id] = obj
-	s.mu.Unlock()
+	return id, s.writeRaw(id, objType, data)
+}
+
+// WriteLooseObject writes an object as a loose file even if a pack already
+// holds it, so it survives removal of that pack
+func (s *Storage) WriteLooseObject(objType ObjectType, data []byte) (ObjectID, error) {
+	if !objType.IsValid() {
+		return ObjectID{}, fmt.Errorf("invalid object type: %s", objType)
+	}
 	
-	return obj, nil
+	id := ComputeHash(objType, data)
+	if _, err := os.Stat(s.objectPath(id)); err == nil {
+		return id, nil
+	}
+	
+	return id, s.writeRaw(id, objType, data)
+}
+
+// writeRaw compresses and stores serialized object content as a loose object
+func (s *Storage) writeRaw(id ObjectID, objType ObjectType, data []byte) error {
+	header := fmt.Sprintf("%s %d\x00", objType, len(data))
+	fullData := append([]byte(header), data...)
+	
+	compressed, err := compressData(fullData)
+	if err != nil {
+		return fmt.Errorf("failed to compress object: %w", err)
+	}
+	
+	return s.writeLooseObject(id, compressed)
 }
 
 // HasObject checks if an object exists in storage
 func (s *Storage) HasObject(id ObjectID) bool {
 	// Check cache
-	s.mu.RLock()
-	if _, ok := s.cache[id]; ok {
-		s.mu.RUnlock()
+	if s.cache.Contains(id) {
 		return true
 	}
-	s.mu.RUnlock()
 	
 	// Check loose object
 	path := s.objectPath(id)
@@ -199,8 +391,126 @@ func (s *Storage) HasObject(id ObjectID) bool {
 		return true
 	}
 	
-	// TODO: Check packfiles
-	return false
+	return s.packed != nil && s.packed.HasPackedObject(id)
+}
+
+// tmpSeq numbers the temporary files of loose objects, so that goroutines
+// writing the same object do not share one
+var tmpSeq atomic.Uint64
+
+// writeLooseObject atomically writes compressed object data to its loose path
+func (s *Storage) writeLooseObject(id ObjectID, compressed []byte) error {
+	path := s.objectPath(id)
+	dir := filepath.Dir(path)
+	
+	// Ensure directory exists
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create object directory: %w", err)
+	}
+	
+	// Write atomically using a temporary file
+	tmpPath := fmt.Sprintf("%s.%d.tmp", path, tmpSeq.Add(1))
+	if err := fault.WriteFile(tmpPath, compressed, 0444); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to write object file: %w", err)
+	}
+	
+	// Rename to final location
+	if err := fault.Rename(tmpPath, path); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to finalize object file: %w", err)
+	}
+	
+	trace.General.Printf("object: wrote %s (loose)", id)
+	return nil
+}
+
+// LooseObjects returns the IDs of all loose objects
+func (s *Storage) LooseObjects() ([]ObjectID, error) {
+	var ids []ObjectID
+	for i := 0; i < 256; i++ {
+		prefix := fmt.Sprintf("%02x", i)
+		entries, err := os.ReadDir(filepath.Join(s.basePath, prefix))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return nil, fmt.Errorf("failed to read object directory: %w", err)
+		}
+		for _, entry := range entries {
+			if id, err := NewObjectID(prefix + entry.Name()); err == nil {
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids, nil
+}
+
+// FindObjects returns the loose and packed objects whose hex name starts
+// with prefix, which must be at least two characters long
+func (s *Storage) FindObjects(prefix string) ([]ObjectID, error) {
+	prefix = strings.ToLower(prefix)
+	if len(prefix) < 2 {
+		return nil, fmt.Errorf("object name prefix too short: %s", prefix)
+	}
+
+	seen := make(map[ObjectID]bool)
+	var ids []ObjectID
+	entries, err := os.ReadDir(filepath.Join(s.basePath, prefix[:2]))
+	if err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read object directory: %w", err)
+	}
+	for _, entry := range entries {
+		name := prefix[:2] + entry.Name()
+		if !strings.HasPrefix(name, prefix) {
+			continue
+		}
+		if id, err := NewObjectID(name); err == nil && !seen[id] {
+			seen[id] = true
+			ids = append(ids, id)
+		}
+	}
+
+	if s.packed != nil {
+		packed, err := s.packed.FindPackedObjects(prefix)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range packed {
+			if !seen[id] {
+				seen[id] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	return ids, nil
+}
+
+// DiskSize returns the number of bytes id takes on disk: the size of its
+// loose file, or of its entry in a pack
+func (s *Storage) DiskSize(id ObjectID) (int64, error) {
+	if info, err := os.Stat(s.objectPath(id)); err == nil {
+		return info.Size(), nil
+	}
+	if s.packed != nil && s.packed.HasPackedObject(id) {
+		return s.packed.PackedObjectSize(id)
+	}
+	return 0, fmt.Errorf("object not found: %s", id)
+}
+
+// LooseObjectPath returns the path of the loose file for an object
+func (s *Storage) LooseObjectPath(id ObjectID) string {
+	return s.objectPath(id)
+}
+
+// RemoveLooseObject deletes a loose object file
+func (s *Storage) RemoveLooseObject(id ObjectID) error {
+	s.cache.Remove(id)
+
+	if err := os.Remove(s.objectPath(id)); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove object %s: %w", id, err)
+	}
+	return nil
 }
 
 // objectPath returns the path to a loose object file
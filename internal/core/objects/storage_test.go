@@ -170,4 +170,114 @@ func TestStorage_ReadNonExistentObject(t *testing.T) {
 	if err == nil {
 		t.Error("Storage.ReadObject() error = nil, want error")
 	}
-}
\ No newline at end of file
+}
+// copyFetcher fetches objects by writing them from another storage
+type copyFetcher struct {
+	from    *Storage
+	to      *Storage
+	fetched [][]ObjectID
+}
+
+func (f *copyFetcher) FetchObjects(ids []ObjectID) error {
+	f.fetched = append(f.fetched, ids)
+	for _, id := range ids {
+		obj, err := f.from.ReadObject(id)
+		if err != nil {
+			return err
+		}
+		if err := f.to.WriteObject(obj); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func TestStorage_Fetcher(t *testing.T) {
+	remote := NewStorage(filepath.Join(t.TempDir(), ".git"))
+	local := NewStorage(filepath.Join(t.TempDir(), ".git"))
+	for _, s := range []*Storage{remote, local} {
+		if err := s.Init(); err != nil {
+			t.Fatalf("Storage.Init() error = %v", err)
+		}
+	}
+
+	a := NewBlob([]byte("a\n"))
+	b := NewBlob([]byte("b\n"))
+	for _, blob := range []*Blob{a, b} {
+		if err := remote.WriteObject(blob); err != nil {
+			t.Fatalf("WriteObject() error = %v", err)
+		}
+	}
+
+	fetcher := &copyFetcher{from: remote, to: local}
+	local.SetFetcher(fetcher)
+
+	if local.HasObject(a.ID()) {
+		t.Fatal("HasObject() = true before the object was fetched")
+	}
+	obj, err := local.ReadObject(a.ID())
+	if err != nil {
+		t.Fatalf("ReadObject() error = %v", err)
+	}
+	if string(obj.(*Blob).Data()) != "a\n" {
+		t.Errorf("ReadObject() = %q, want %q", obj.(*Blob).Data(), "a\n")
+	}
+
+	// Stored objects are not fetched again, and the rest go in one batch
+	if err := local.FetchMissing([]ObjectID{a.ID(), b.ID(), b.ID()}); err != nil {
+		t.Fatalf("FetchMissing() error = %v", err)
+	}
+	if len(fetcher.fetched) != 2 || len(fetcher.fetched[1]) != 1 || fetcher.fetched[1][0] != b.ID() {
+		t.Errorf("fetched %v, want [[a] [b]]", fetcher.fetched)
+	}
+
+	// An object the remote lacks is still not found
+	missing, _ := NewObjectID("1234567890abcdef1234567890abcdef12345678")
+	if _, err := local.ReadObject(missing); err == nil {
+		t.Error("ReadObject() error = nil, want error")
+	}
+}
+
+func TestStorage_Replacements(t *testing.T) {
+	storage := NewStorage(filepath.Join(t.TempDir(), ".git"))
+	if err := storage.Init(); err != nil {
+		t.Fatalf("Storage.Init() error = %v", err)
+	}
+	var blobs []*Blob
+	for _, content := range []string{"original\n", "first\n", "second\n"} {
+		blob := NewBlob([]byte(content))
+		if err := storage.WriteObject(blob); err != nil {
+			t.Fatalf("WriteObject() error = %v", err)
+		}
+		blobs = append(blobs, blob)
+	}
+	original := blobs[0].ID()
+
+	read := func() string {
+		t.Helper()
+		obj, err := storage.ReadObject(original)
+		if err != nil {
+			t.Fatalf("ReadObject() error = %v", err)
+		}
+		if obj.ID() != original {
+			t.Errorf("ReadObject() ID = %s, want %s", obj.ID(), original)
+		}
+		return string(obj.(*Blob).Data())
+	}
+
+	// Chains of replacements are followed
+	storage.SetReplacements(map[ObjectID]ObjectID{original: blobs[1].ID(), blobs[1].ID(): blobs[2].ID()})
+	if got := read(); got != "second\n" {
+		t.Errorf("ReadObject() = %q, want the replacement", got)
+	}
+	storage.SetReplacements(nil)
+	if got := read(); got != "original\n" {
+		t.Errorf("ReadObject() = %q, want the stored object", got)
+	}
+
+	// A cycle is cut short
+	storage.SetReplacements(map[ObjectID]ObjectID{original: blobs[1].ID(), blobs[1].ID(): original})
+	if _, err := storage.ReadObject(original); err == nil {
+		t.Error("ReadObject() error = nil for a replacement cycle")
+	}
+}
@@ -0,0 +1,510 @@
+package packfile
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/fenilsonani/vcs/internal/core/fault"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+// bitmapInterval is how many commits apart the commits below the ref tips
+// that get a bitmap are
+const bitmapInterval = 100
+
+const (
+	bitmapSignature = "BITM"
+	bitmapVersion   = 1
+	// bitmapFullDAG says every object reachable from a commit with a bitmap
+	// is in the pack; it is the only kind of bitmap Git writes
+	bitmapFullDAG = 0x1
+)
+
+// ObjectReader reads the objects a reachability walk visits
+type ObjectReader interface {
+	ReadObject(id objects.ObjectID) (objects.Object, error)
+	HasObject(id objects.ObjectID) bool
+}
+
+// PackBitmap holds the reachability bitmaps of a pack, Git's .bitmap file:
+// for some commits, the set of objects reachable from each. Objects are
+// numbered by their order in the pack.
+type PackBitmap struct {
+	pack      *Pack
+	order     []objects.ObjectID
+	positions map[objects.ObjectID]uint32
+	commits   map[objects.ObjectID]*Bitset
+}
+
+// bitmapPath returns the path of the bitmap of the pack at packPath
+func bitmapPath(packPath string) string {
+	return strings.TrimSuffix(packPath, ".pack") + ".bitmap"
+}
+
+// packOrder returns the objects of pack sorted by offset
+func packOrder(pack *Pack) []objects.ObjectID {
+	byOffset := make([]int, len(pack.ids))
+	for i := range byOffset {
+		byOffset[i] = i
+	}
+	sort.Slice(byOffset, func(i, j int) bool { return pack.offsets[byOffset[i]] < pack.offsets[byOffset[j]] })
+	order := make([]objects.ObjectID, len(byOffset))
+	for i, idx := range byOffset {
+		order[i] = pack.ids[idx]
+	}
+	return order
+}
+
+// newPackBitmap returns an empty bitmap of pack
+func newPackBitmap(pack *Pack) *PackBitmap {
+	b := &PackBitmap{
+		pack:      pack,
+		order:     packOrder(pack),
+		positions: make(map[objects.ObjectID]uint32, len(pack.ids)),
+		commits:   make(map[objects.ObjectID]*Bitset),
+	}
+	for i, id := range b.order {
+		b.positions[id] = uint32(i)
+	}
+	return b
+}
+
+// checksum reads the checksum at the end of the pack
+func (p *Pack) checksum() ([]byte, error) {
+	sum := make([]byte, sha1.Size)
+	if _, err := p.file.ReadAt(sum, p.size-sha1.Size); err != nil {
+		return nil, fmt.Errorf("failed to read pack checksum: %w", err)
+	}
+	return sum, nil
+}
+
+// LoadBitmap reads the bitmap of pack, or returns nil when it has none
+func LoadBitmap(pack *Pack) (*PackBitmap, error) {
+	data, err := os.ReadFile(bitmapPath(pack.path))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read bitmap: %w", err)
+	}
+	b, err := parseBitmap(pack, data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid bitmap %s: %w", bitmapPath(pack.path), err)
+	}
+	return b, nil
+}
+
+// parseBitmap reads the bitmap file of pack
+func parseBitmap(pack *Pack, data []byte) (*PackBitmap, error) {
+	const headerSize = 12 + sha1.Size
+	if len(data) < headerSize+sha1.Size || string(data[:4]) != bitmapSignature {
+		return nil, fmt.Errorf("not a bitmap file")
+	}
+	if version := binary.BigEndian.Uint16(data[4:]); version != bitmapVersion {
+		return nil, fmt.Errorf("unsupported version %d", version)
+	}
+	if flags := binary.BigEndian.Uint16(data[6:]); flags&bitmapFullDAG == 0 {
+		return nil, fmt.Errorf("unsupported bitmap options %#x", flags)
+	}
+	body := data[:len(data)-sha1.Size]
+	if sum := sha1.Sum(body); !bytes.Equal(sum[:], data[len(body):]) {
+		return nil, fmt.Errorf("checksum mismatch")
+	}
+	packSum, err := pack.checksum()
+	if err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(data[12:headerSize], packSum) {
+		return nil, fmt.Errorf("written for another pack")
+	}
+
+	b := newPackBitmap(pack)
+	rest := body[headerSize:]
+	// The type bitmaps come first; walks read object types themselves
+	for i := 0; i < 4; i++ {
+		_, n, err := readEWAH(rest)
+		if err != nil {
+			return nil, err
+		}
+		rest = rest[n:]
+	}
+
+	count := int(binary.BigEndian.Uint32(data[8:]))
+	resolved := make([]*Bitset, count)
+	for i := 0; i < count; i++ {
+		if len(rest) < 6 {
+			return nil, fmt.Errorf("truncated entry %d", i)
+		}
+		pos := int(binary.BigEndian.Uint32(rest))
+		xor := int(rest[4])
+		bitset, n, err := readEWAH(rest[6:])
+		if err != nil {
+			return nil, err
+		}
+		rest = rest[6+n:]
+
+		if pos >= len(pack.ids) || xor > i {
+			return nil, fmt.Errorf("invalid entry %d", i)
+		}
+		// An entry may be stored as its difference from an earlier one
+		if xor > 0 {
+			bitset.Xor(resolved[i-xor])
+		}
+		resolved[i] = bitset
+		b.commits[pack.ids[pos]] = bitset
+	}
+	return b, nil
+}
+
+// StorageBitmap returns the reachability bitmap of the packs storage reads,
+// or nil when they have none
+func StorageBitmap(storage *objects.Storage) (*PackBitmap, error) {
+	dir, ok := storage.PackedObjects().(*PackDir)
+	if !ok {
+		return nil, nil
+	}
+	return dir.Bitmap()
+}
+
+// Commits returns the number of commits with a bitmap
+func (b *PackBitmap) Commits() int {
+	return len(b.commits)
+}
+
+// reachSet is the result of a walk: the objects of the pack as a bitset,
+// and those outside it
+type reachSet struct {
+	bits  *Bitset
+	extra map[objects.ObjectID]bool
+	order []objects.ObjectID
+}
+
+// reach finds the objects reachable from roots, using the bitmap of every
+// commit met and walking the rest. Objects in exclude are not walked.
+// Missing objects are skipped, since a root may be unknown here and
+// shallow history ends at absent parents.
+func (b *PackBitmap) reach(store ObjectReader, roots []objects.ObjectID, exclude *reachSet) (*reachSet, error) {
+	set := &reachSet{bits: &Bitset{}, extra: make(map[objects.ObjectID]bool)}
+	stack := append([]objects.ObjectID(nil), roots...)
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		if pos, ok := b.positions[id]; ok {
+			if set.bits.Has(pos) || (exclude != nil && exclude.bits.Has(pos)) {
+				continue
+			}
+			if bitset, ok := b.commits[id]; ok {
+				set.bits.Or(bitset)
+				continue
+			}
+			set.bits.Set(pos)
+		} else {
+			if set.extra[id] || (exclude != nil && exclude.extra[id]) || !store.HasObject(id) {
+				continue
+			}
+			set.extra[id] = true
+			set.order = append(set.order, id)
+		}
+
+		obj, err := store.ReadObject(id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read object %s: %w", id, err)
+		}
+		stack = appendLinks(stack, obj)
+	}
+	return set, nil
+}
+
+// appendLinks appends the objects obj points to. Submodule commits live in
+// another repository and are left out.
+func appendLinks(stack []objects.ObjectID, obj objects.Object) []objects.ObjectID {
+	switch o := obj.(type) {
+	case *objects.Commit:
+		stack = append(stack, o.Tree())
+		stack = append(stack, o.Parents()...)
+	case *objects.Tree:
+		for _, entry := range o.Entries() {
+			if entry.Mode != objects.ModeCommit {
+				stack = append(stack, entry.ID)
+			}
+		}
+	case *objects.Tag:
+		stack = append(stack, o.Object())
+	}
+	return stack
+}
+
+// CountObjects returns the objects reachable from wants that are not
+// reachable from haves: those in the pack in pack order, then the others.
+// Only history without a bitmap is walked, so counting the objects to send
+// costs little more than the commits added since the bitmaps were written.
+func (b *PackBitmap) CountObjects(store ObjectReader, wants, haves []objects.ObjectID) ([]objects.ObjectID, error) {
+	have, err := b.reach(store, haves, nil)
+	if err != nil {
+		return nil, err
+	}
+	want, err := b.reach(store, wants, have)
+	if err != nil {
+		return nil, err
+	}
+	want.bits.AndNot(have.bits)
+
+	ids := make([]objects.ObjectID, 0, want.bits.Count()+len(want.order))
+	want.bits.ForEach(func(i uint32) {
+		ids = append(ids, b.order[i])
+	})
+	return append(ids, want.order...), nil
+}
+
+// WriteBitmap writes the bitmap of pack for the commits reachable from
+// tips: every tip gets a bitmap, and so does one commit in every hundred
+// below them. Every object those commits reach must be in the pack, or be
+// missing altogether as beyond a shallow boundary. It returns the number
+// of bitmaps written.
+func WriteBitmap(pack *Pack, store ObjectReader, tips []objects.ObjectID) (int, error) {
+	b := newPackBitmap(pack)
+
+	selected, err := b.selectCommits(store, tips)
+	if err != nil {
+		return 0, err
+	}
+	for _, id := range selected {
+		bitset, err := b.commitBitset(store, id)
+		if err != nil {
+			return 0, err
+		}
+		b.commits[id] = bitset
+	}
+
+	types, err := b.typeBitsets()
+	if err != nil {
+		return 0, err
+	}
+	packSum, err := pack.checksum()
+	if err != nil {
+		return 0, err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(bitmapSignature)
+	binary.Write(&buf, binary.BigEndian, uint16(bitmapVersion))
+	binary.Write(&buf, binary.BigEndian, uint16(bitmapFullDAG))
+	binary.Write(&buf, binary.BigEndian, uint32(len(selected)))
+	buf.Write(packSum)
+	size := uint32(len(b.order))
+	for _, bitset := range types {
+		if err := writeEWAH(&buf, bitset, size); err != nil {
+			return 0, err
+		}
+	}
+	for _, id := range selected {
+		idx, _ := pack.index(id)
+		binary.Write(&buf, binary.BigEndian, uint32(idx))
+		// No XOR compression, no flags
+		buf.Write([]byte{0, 0})
+		if err := writeEWAH(&buf, b.commits[id], size); err != nil {
+			return 0, err
+		}
+	}
+	sum := sha1.Sum(buf.Bytes())
+	buf.Write(sum[:])
+
+	path := bitmapPath(pack.path)
+	tmp := path + ".lock"
+	if err := fault.WriteFile(tmp, buf.Bytes(), 0444); err != nil {
+		os.Remove(tmp)
+		return 0, fmt.Errorf("failed to write bitmap: %w", err)
+	}
+	if err := fault.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return 0, fmt.Errorf("failed to write bitmap: %w", err)
+	}
+	return len(selected), nil
+}
+
+// selectCommits picks the commits in the pack that get a bitmap, parents
+// before children so that each bitmap can start from those below it
+func (b *PackBitmap) selectCommits(store ObjectReader, tips []objects.ObjectID) ([]objects.ObjectID, error) {
+	// Peel tags down to the commits the tips name
+	isTip := make(map[objects.ObjectID]bool)
+	var starts []objects.ObjectID
+	for _, id := range tips {
+		for store.HasObject(id) {
+			obj, err := store.ReadObject(id)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read object %s: %w", id, err)
+			}
+			if tag, ok := obj.(*objects.Tag); ok {
+				id = tag.Object()
+				continue
+			}
+			if _, ok := b.positions[id]; ok && obj.Type() == objects.TypeCommit && !isTip[id] {
+				isTip[id] = true
+				starts = append(starts, id)
+			}
+			break
+		}
+	}
+
+	// Post-order walk, so parents come first
+	type frame struct {
+		id      objects.ObjectID
+		parents []objects.ObjectID
+	}
+	var order []objects.ObjectID
+	visited := make(map[objects.ObjectID]bool)
+	for _, start := range starts {
+		if visited[start] {
+			continue
+		}
+		visited[start] = true
+		commit, err := readCommit(store, start)
+		if err != nil {
+			return nil, err
+		}
+		stack := []frame{{start, commit.Parents()}}
+		for len(stack) > 0 {
+			top := &stack[len(stack)-1]
+			if len(top.parents) == 0 {
+				order = append(order, top.id)
+				stack = stack[:len(stack)-1]
+				continue
+			}
+			parent := top.parents[0]
+			top.parents = top.parents[1:]
+			if visited[parent] || !store.HasObject(parent) {
+				continue
+			}
+			visited[parent] = true
+			commit, err := readCommit(store, parent)
+			if err != nil {
+				return nil, err
+			}
+			stack = append(stack, frame{parent, commit.Parents()})
+		}
+	}
+
+	var selected []objects.ObjectID
+	for i, id := range order {
+		if isTip[id] || (len(order)-1-i)%bitmapInterval == 0 {
+			selected = append(selected, id)
+		}
+	}
+	return selected, nil
+}
+
+// readCommit reads commit id from store
+func readCommit(store ObjectReader, id objects.ObjectID) (*objects.Commit, error) {
+	obj, err := store.ReadObject(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read commit %s: %w", id, err)
+	}
+	commit, ok := obj.(*objects.Commit)
+	if !ok {
+		return nil, fmt.Errorf("object %s is a %s, not a commit", id, obj.Type())
+	}
+	return commit, nil
+}
+
+// commitBitset finds the objects reachable from commit id, starting from
+// the bitmaps of the commits below it that have one
+func (b *PackBitmap) commitBitset(store ObjectReader, id objects.ObjectID) (*Bitset, error) {
+	bitset := &Bitset{}
+	stack := []objects.ObjectID{id}
+	for len(stack) > 0 {
+		next := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+
+		pos, ok := b.positions[next]
+		if !ok {
+			if store.HasObject(next) {
+				return nil, fmt.Errorf("object %s reachable from %s is not in the pack", next, id)
+			}
+			continue
+		}
+		if bitset.Has(pos) {
+			continue
+		}
+		if below, ok := b.commits[next]; ok {
+			bitset.Or(below)
+			continue
+		}
+		bitset.Set(pos)
+
+		obj, err := store.ReadObject(next)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read object %s: %w", next, err)
+		}
+		stack = appendLinks(stack, obj)
+	}
+	return bitset, nil
+}
+
+// typeBitsets returns the commits, trees, blobs and tags of the pack, in
+// the order of Git's bitmap files
+func (b *PackBitmap) typeBitsets() ([4]*Bitset, error) {
+	sets := [4]*Bitset{{}, {}, {}, {}}
+	for i, id := range b.order {
+		offset, _ := b.pack.find(id)
+		objType, err := b.pack.typeAt(offset, 0)
+		if err != nil {
+			return sets, fmt.Errorf("failed to read type of %s: %w", id, err)
+		}
+		switch objType {
+		case ObjCommit:
+			sets[0].Set(uint32(i))
+		case ObjTree:
+			sets[1].Set(uint32(i))
+		case ObjBlob:
+			sets[2].Set(uint32(i))
+		case ObjTag:
+			sets[3].Set(uint32(i))
+		}
+	}
+	return sets, nil
+}
+
+// typeAt returns the type of the entry at offset, following deltas to
+// their bases without inflating anything
+func (p *Pack) typeAt(offset int64, depth int) (ObjectType, error) {
+	if depth > maxDeltaChain {
+		return 0, fmt.Errorf("delta chain too deep")
+	}
+	if offset < 12 || offset >= p.size-sha1.Size {
+		return 0, fmt.Errorf("invalid entry offset %d", offset)
+	}
+	section := io.NewSectionReader(p.file, offset, p.size-sha1.Size-offset)
+	pr := &Reader{r: &countingReader{r: bufio.NewReaderSize(section, 64), h: sha1.New(), n: offset}}
+	objType, _, err := pr.readEntryHeader()
+	if err != nil {
+		return 0, err
+	}
+
+	switch objType {
+	case ObjOfsDelta:
+		distance, err := pr.readOffset()
+		if err != nil {
+			return 0, err
+		}
+		return p.typeAt(offset-distance, depth+1)
+	case ObjRefDelta:
+		var base objects.ObjectID
+		if _, err := io.ReadFull(pr.r, base[:]); err != nil {
+			return 0, err
+		}
+		baseOffset, ok := p.find(base)
+		if !ok {
+			return 0, fmt.Errorf("delta base %s not in pack", base)
+		}
+		return p.typeAt(baseOffset, depth+1)
+	}
+	return objType, nil
+}
@@ -0,0 +1,160 @@
+package packfile
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"testing"
+	"time"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+func TestEWAHRoundTrip(t *testing.T) {
+	sets := map[string][]uint32{
+		"empty":  nil,
+		"sparse": {0, 63, 64, 1000, 4095},
+		"dense":  nil,
+	}
+	for i := uint32(100); i < 400; i++ {
+		sets["dense"] = append(sets["dense"], i)
+	}
+
+	for name, positions := range sets {
+		b := &Bitset{}
+		for _, i := range positions {
+			b.Set(i)
+		}
+		var buf bytes.Buffer
+		if err := writeEWAH(&buf, b, 4096); err != nil {
+			t.Fatalf("%s: writeEWAH() error = %v", name, err)
+		}
+		got, n, err := readEWAH(buf.Bytes())
+		if err != nil || n != buf.Len() {
+			t.Fatalf("%s: readEWAH() = %d bytes, %v; want %d", name, n, err, buf.Len())
+		}
+		var back []uint32
+		got.ForEach(func(i uint32) { back = append(back, i) })
+		if fmt.Sprint(back) != fmt.Sprint(positions) && !(len(back) == 0 && len(positions) == 0) {
+			t.Errorf("%s: round trip = %v, want %v", name, back, positions)
+		}
+	}
+}
+
+// bitmapHistory writes a line of commits to a new repository and packs
+// them, returning the storage and the commits oldest first
+func bitmapHistory(t *testing.T, count int) (*objects.Storage, []objects.ObjectID) {
+	storage := objects.NewStorage(t.TempDir())
+	if err := storage.Init(); err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+	packDir := NewPackDir(storage.PackDir())
+	t.Cleanup(func() { packDir.Close() })
+	storage.SetPackedObjects(packDir)
+
+	var commits []objects.ObjectID
+	var written []objects.Object
+	for i := 0; i < count; i++ {
+		blob := objects.NewBlob([]byte(versionedFile(i)))
+		tree := objects.NewTree()
+		if err := tree.AddEntry(objects.ModeBlob, "file.txt", blob.ID()); err != nil {
+			t.Fatal(err)
+		}
+		sig := objects.Signature{Name: "Test", Email: "test@example.com", When: time.Unix(1700000000+int64(i), 0)}
+		var parents []objects.ObjectID
+		if i > 0 {
+			parents = commits[i-1:]
+		}
+		commit := objects.NewCommit(tree.ID(), parents, sig, sig, fmt.Sprintf("commit %d\n", i))
+		for _, obj := range []objects.Object{blob, tree, commit} {
+			if err := storage.WriteObject(obj); err != nil {
+				t.Fatalf("WriteObject() error = %v", err)
+			}
+			written = append(written, obj)
+		}
+		commits = append(commits, commit.ID())
+	}
+
+	var objs []*Object
+	for _, obj := range written {
+		data, err := obj.Serialize()
+		if err != nil {
+			t.Fatal(err)
+		}
+		objs = append(objs, &Object{ID: obj.ID(), Type: obj.Type(), Data: data})
+	}
+	if _, _, err := SavePack(storage.PackDir(), objs, DefaultWriterOptions()); err != nil {
+		t.Fatalf("SavePack() error = %v", err)
+	}
+	return storage, commits
+}
+
+func TestBitmapCountObjects(t *testing.T) {
+	storage, commits := bitmapHistory(t, 250)
+	packs, err := NewPackDir(storage.PackDir()).Packs()
+	if err != nil || len(packs) != 1 {
+		t.Fatalf("Packs() = %d, %v; want 1 pack", len(packs), err)
+	}
+	tip := commits[len(commits)-1]
+
+	n, err := WriteBitmap(packs[0], storage, []objects.ObjectID{tip})
+	if err != nil {
+		t.Fatalf("WriteBitmap() error = %v", err)
+	}
+	// The tip and one commit in every hundred below it
+	if n != 3 {
+		t.Errorf("WriteBitmap() wrote %d bitmaps, want 3", n)
+	}
+
+	bitmap, err := StorageBitmap(storage)
+	if err != nil || bitmap == nil {
+		t.Fatalf("StorageBitmap() = %v, %v", bitmap, err)
+	}
+	if bitmap.Commits() != n {
+		t.Errorf("Commits() = %d, want %d", bitmap.Commits(), n)
+	}
+
+	ids, err := bitmap.CountObjects(storage, []objects.ObjectID{tip}, nil)
+	if err != nil || len(ids) != 3*len(commits) {
+		t.Errorf("CountObjects(tip) = %d objects, %v; want %d", len(ids), err, 3*len(commits))
+	}
+
+	// Fetching the last 30 commits, and one written after the bitmap
+	blob := objects.NewBlob([]byte("new\n"))
+	tree := objects.NewTree()
+	if err := tree.AddEntry(objects.ModeBlob, "file.txt", blob.ID()); err != nil {
+		t.Fatal(err)
+	}
+	sig := objects.Signature{Name: "Test", Email: "test@example.com", When: time.Unix(1800000000, 0)}
+	next := objects.NewCommit(tree.ID(), []objects.ObjectID{tip}, sig, sig, "next\n")
+	for _, obj := range []objects.Object{blob, tree, next} {
+		if err := storage.WriteObject(obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ids, err = bitmap.CountObjects(storage, []objects.ObjectID{next.ID()}, []objects.ObjectID{commits[len(commits)-31]})
+	if err != nil {
+		t.Fatalf("CountObjects() error = %v", err)
+	}
+	want := []objects.ObjectID{blob.ID(), tree.ID(), next.ID()}
+	for _, id := range commits[len(commits)-30:] {
+		commit, err := storage.ReadObject(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		treeID := commit.(*objects.Commit).Tree()
+		tree, err := storage.ReadObject(treeID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want = append(want, id, treeID, tree.(*objects.Tree).Entries()[0].ID)
+	}
+	sortIDs := func(ids []objects.ObjectID) {
+		sort.Slice(ids, func(i, j int) bool { return bytes.Compare(ids[i][:], ids[j][:]) < 0 })
+	}
+	sortIDs(ids)
+	sortIDs(want)
+	if fmt.Sprint(ids) != fmt.Sprint(want) {
+		t.Errorf("CountObjects() = %d objects, want %d", len(ids), len(want))
+	}
+}
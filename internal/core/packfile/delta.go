@@ -0,0 +1,232 @@
+package packfile
+
+import (
+	"fmt"
+)
+
+// ApplyDelta reconstructs an object from its base and a Git delta
+func ApplyDelta(base, delta []byte) ([]byte, error) {
+	srcSize, delta, err := readDeltaSize(delta)
+	if err != nil {
+		return nil, fmt.Errorf("invalid delta source size: %w", err)
+	}
+	if srcSize != uint64(len(base)) {
+		return nil, fmt.Errorf("delta base size mismatch: expected %d, got %d", srcSize, len(base))
+	}
+
+	dstSize, delta, err := readDeltaSize(delta)
+	if err != nil {
+		return nil, fmt.Errorf("invalid delta target size: %w", err)
+	}
+
+	result := make([]byte, 0, dstSize)
+
+	for len(delta) > 0 {
+		cmd := delta[0]
+		delta = delta[1:]
+
+		switch {
+		case cmd&0x80 != 0:
+			// Copy from base
+			var offset, size uint64
+			for i := uint(0); i < 4; i++ {
+				if cmd&(1<<i) != 0 {
+					if len(delta) == 0 {
+						return nil, fmt.Errorf("truncated delta copy instruction")
+					}
+					offset |= uint64(delta[0]) << (8 * i)
+					delta = delta[1:]
+				}
+			}
+			for i := uint(0); i < 3; i++ {
+				if cmd&(0x10<<i) != 0 {
+					if len(delta) == 0 {
+						return nil, fmt.Errorf("truncated delta copy instruction")
+					}
+					size |= uint64(delta[0]) << (8 * i)
+					delta = delta[1:]
+				}
+			}
+			if size == 0 {
+				size = 0x10000
+			}
+			if offset+size > uint64(len(base)) {
+				return nil, fmt.Errorf("delta copy out of bounds")
+			}
+			result = append(result, base[offset:offset+size]...)
+		case cmd != 0:
+			// Insert literal data
+			if int(cmd) > len(delta) {
+				return nil, fmt.Errorf("truncated delta insert instruction")
+			}
+			result = append(result, delta[:cmd]...)
+			delta = delta[cmd:]
+		default:
+			return nil, fmt.Errorf("invalid delta instruction")
+		}
+	}
+
+	if uint64(len(result)) != dstSize {
+		return nil, fmt.Errorf("delta result size mismatch: expected %d, got %d", dstSize, len(result))
+	}
+
+	return result, nil
+}
+
+// readDeltaSize decodes a little-endian base-128 size from a delta header
+func readDeltaSize(delta []byte) (uint64, []byte, error) {
+	var size uint64
+	var shift uint
+
+	for i, b := range delta {
+		if shift > 63 {
+			return 0, nil, fmt.Errorf("size overflow")
+		}
+		size |= uint64(b&0x7f) << shift
+		shift += 7
+		if b&0x80 == 0 {
+			return size, delta[i+1:], nil
+		}
+	}
+
+	return 0, nil, fmt.Errorf("truncated size")
+}
+
+const (
+	// deltaBlockSize is the granularity at which base content is indexed
+	deltaBlockSize = 16
+	// maxBlockCandidates caps how many base offsets are kept per block
+	maxBlockCandidates = 64
+	// maxCopySize is the largest copy a single delta instruction can express
+	maxCopySize = 0x10000
+	// maxInsertSize is the largest literal a single delta instruction can hold
+	maxInsertSize = 0x7f
+)
+
+// deltaIndex indexes a base object so several targets can be delta-encoded
+// against it without rebuilding the index
+type deltaIndex struct {
+	base   []byte
+	blocks map[string][]int
+}
+
+// newDeltaIndex indexes base at block-aligned offsets
+func newDeltaIndex(base []byte) *deltaIndex {
+	di := &deltaIndex{
+		base:   base,
+		blocks: make(map[string][]int),
+	}
+	for off := 0; off+deltaBlockSize <= len(base); off += deltaBlockSize {
+		key := string(base[off : off+deltaBlockSize])
+		if len(di.blocks[key]) < maxBlockCandidates {
+			di.blocks[key] = append(di.blocks[key], off)
+		}
+	}
+	return di
+}
+
+// CreateDelta returns a Git delta that rebuilds target from base
+func CreateDelta(base, target []byte) []byte {
+	return newDeltaIndex(base).encode(target)
+}
+
+// encode produces a delta from the indexed base to target
+func (di *deltaIndex) encode(target []byte) []byte {
+	delta := appendDeltaSize(nil, uint64(len(di.base)))
+	delta = appendDeltaSize(delta, uint64(len(target)))
+
+	var pending []byte
+	i := 0
+	for i < len(target) {
+		bestOff, bestLen := 0, 0
+		if i+deltaBlockSize <= len(target) {
+			for _, off := range di.blocks[string(target[i:i+deltaBlockSize])] {
+				n := deltaBlockSize
+				for off+n < len(di.base) && i+n < len(target) && di.base[off+n] == target[i+n] {
+					n++
+				}
+				if n > bestLen {
+					bestOff, bestLen = off, n
+				}
+			}
+		}
+
+		if bestLen == 0 {
+			pending = append(pending, target[i])
+			i++
+			continue
+		}
+
+		// Grow the match backwards into literal bytes not yet emitted
+		forward := bestLen
+		for bestOff > 0 && len(pending) > 0 && di.base[bestOff-1] == pending[len(pending)-1] {
+			bestOff--
+			bestLen++
+			pending = pending[:len(pending)-1]
+		}
+
+		delta = appendInsert(delta, pending)
+		pending = pending[:0]
+		delta = appendCopy(delta, bestOff, bestLen)
+		i += forward
+	}
+
+	return appendInsert(delta, pending)
+}
+
+// appendDeltaSize appends a little-endian base-128 size
+func appendDeltaSize(buf []byte, size uint64) []byte {
+	for size >= 0x80 {
+		buf = append(buf, byte(size)|0x80)
+		size >>= 7
+	}
+	return append(buf, byte(size))
+}
+
+// appendInsert appends literal data as one or more insert instructions
+func appendInsert(delta, data []byte) []byte {
+	for len(data) > 0 {
+		n := len(data)
+		if n > maxInsertSize {
+			n = maxInsertSize
+		}
+		delta = append(delta, byte(n))
+		delta = append(delta, data[:n]...)
+		data = data[n:]
+	}
+	return delta
+}
+
+// appendCopy appends copy instructions for base[offset:offset+length]
+func appendCopy(delta []byte, offset, length int) []byte {
+	for length > 0 {
+		size := length
+		if size > maxCopySize {
+			size = maxCopySize
+		}
+
+		cmd := byte(0x80)
+		var args []byte
+		for i := uint(0); i < 4; i++ {
+			if b := byte(offset >> (8 * i)); b != 0 {
+				cmd |= 1 << i
+				args = append(args, b)
+			}
+		}
+		// A size of 0x10000 is encoded by omitting all size bytes
+		if size != maxCopySize {
+			for i := uint(0); i < 3; i++ {
+				if b := byte(size >> (8 * i)); b != 0 {
+					cmd |= 0x10 << i
+					args = append(args, b)
+				}
+			}
+		}
+
+		delta = append(delta, cmd)
+		delta = append(delta, args...)
+		offset += size
+		length -= size
+	}
+	return delta
+}
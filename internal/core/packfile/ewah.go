@@ -0,0 +1,165 @@
+package packfile
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math/bits"
+)
+
+// Bitset is an uncompressed set of object positions
+type Bitset struct {
+	words []uint64
+}
+
+// Set adds position i
+func (b *Bitset) Set(i uint32) {
+	w := int(i / 64)
+	for len(b.words) <= w {
+		b.words = append(b.words, 0)
+	}
+	b.words[w] |= 1 << (i % 64)
+}
+
+// Has reports whether position i is in the set
+func (b *Bitset) Has(i uint32) bool {
+	w := int(i / 64)
+	return w < len(b.words) && b.words[w]&(1<<(i%64)) != 0
+}
+
+// Or adds every position of other
+func (b *Bitset) Or(other *Bitset) {
+	for len(b.words) < len(other.words) {
+		b.words = append(b.words, 0)
+	}
+	for i, w := range other.words {
+		b.words[i] |= w
+	}
+}
+
+// AndNot removes every position of other
+func (b *Bitset) AndNot(other *Bitset) {
+	for i := range b.words {
+		if i < len(other.words) {
+			b.words[i] &^= other.words[i]
+		}
+	}
+}
+
+// Xor toggles every position of other
+func (b *Bitset) Xor(other *Bitset) {
+	for len(b.words) < len(other.words) {
+		b.words = append(b.words, 0)
+	}
+	for i, w := range other.words {
+		b.words[i] ^= w
+	}
+}
+
+// Count returns the number of positions in the set
+func (b *Bitset) Count() int {
+	n := 0
+	for _, w := range b.words {
+		n += bits.OnesCount64(w)
+	}
+	return n
+}
+
+// Clone returns a copy of the set
+func (b *Bitset) Clone() *Bitset {
+	return &Bitset{words: append([]uint64(nil), b.words...)}
+}
+
+// ForEach calls fn with every position in the set, in increasing order
+func (b *Bitset) ForEach(fn func(i uint32)) {
+	for w, word := range b.words {
+		for word != 0 {
+			bit := bits.TrailingZeros64(word)
+			fn(uint32(w*64 + bit))
+			word &= word - 1
+		}
+	}
+}
+
+// EWAH run length words hold the running bit in bit 0, the number of clean
+// words in the next 32 bits and the number of literal words after them in
+// the top 31
+const (
+	ewahMaxRun      = 1<<32 - 1
+	ewahMaxLiterals = 1<<31 - 1
+)
+
+// writeEWAH writes b, holding size bits, in the EWAH compressed form Git's
+// bitmap files use
+func writeEWAH(w io.Writer, b *Bitset, size uint32) error {
+	words := b.words
+	if n := int((size + 63) / 64); len(words) > n {
+		words = words[:n]
+	}
+
+	var buf []uint64
+	rlw := 0
+	for i := 0; i < len(words) || len(buf) == 0; {
+		rlw = len(buf)
+		buf = append(buf, 0)
+
+		var running, run uint64
+		if i < len(words) && (words[i] == 0 || words[i] == ^uint64(0)) {
+			running = words[i] & 1
+			for i < len(words) && words[i] == -running && run < ewahMaxRun {
+				run++
+				i++
+			}
+		}
+		var literals uint64
+		for i < len(words) && words[i] != 0 && words[i] != ^uint64(0) && literals < ewahMaxLiterals {
+			buf = append(buf, words[i])
+			literals++
+			i++
+		}
+		buf[rlw] = running | run<<1 | literals<<33
+	}
+
+	out := make([]byte, 8+8*len(buf)+4)
+	binary.BigEndian.PutUint32(out, size)
+	binary.BigEndian.PutUint32(out[4:], uint32(len(buf)))
+	for i, word := range buf {
+		binary.BigEndian.PutUint64(out[8+8*i:], word)
+	}
+	binary.BigEndian.PutUint32(out[8+8*len(buf):], uint32(rlw))
+	_, err := w.Write(out)
+	return err
+}
+
+// readEWAH reads an EWAH compressed bitmap from the start of data and
+// returns it with the number of bytes it took
+func readEWAH(data []byte) (*Bitset, int, error) {
+	if len(data) < 8 {
+		return nil, 0, fmt.Errorf("truncated bitmap")
+	}
+	size := binary.BigEndian.Uint32(data)
+	count := int(binary.BigEndian.Uint32(data[4:]))
+	end := 8 + 8*count + 4
+	if count < 0 || end > len(data) {
+		return nil, 0, fmt.Errorf("truncated bitmap")
+	}
+
+	b := &Bitset{words: make([]uint64, 0, (size+63)/64)}
+	for i := 0; i < count; {
+		rlw := binary.BigEndian.Uint64(data[8+8*i:])
+		running := -(rlw & 1)
+		run := (rlw >> 1) & ewahMaxRun
+		literals := int(rlw >> 33)
+		if i+1+literals > count || uint64(len(b.words))+run > uint64(size+63)/64 {
+			return nil, 0, fmt.Errorf("corrupt bitmap")
+		}
+		for ; run > 0; run-- {
+			b.words = append(b.words, running)
+		}
+		for j := 0; j < literals; j++ {
+			b.words = append(b.words, binary.BigEndian.Uint64(data[8+8*(i+1+j):]))
+		}
+		i += 1 + literals
+	}
+	return b, end, nil
+}
@@ -0,0 +1,67 @@
+package packfile
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// indexSignature is the magic number of a version 2 pack index
+var indexSignature = []byte{0xff, 't', 'O', 'c'}
+
+// WriteIndex writes a version 2 .idx file for a pack written by WritePack
+func WriteIndex(w io.Writer, entries []IndexEntry, packChecksum []byte) error {
+	if len(packChecksum) != sha1.Size {
+		return fmt.Errorf("invalid pack checksum length: %d", len(packChecksum))
+	}
+
+	sorted := append([]IndexEntry(nil), entries...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return bytes.Compare(sorted[i].ID[:], sorted[j].ID[:]) < 0
+	})
+
+	var buf bytes.Buffer
+	buf.Write(indexSignature)
+	binary.Write(&buf, binary.BigEndian, uint32(2))
+
+	// Fan-out table: number of objects whose first byte is <= i
+	var fanout [256]uint32
+	for _, e := range sorted {
+		fanout[e.ID[0]]++
+	}
+	for i := 1; i < 256; i++ {
+		fanout[i] += fanout[i-1]
+	}
+	binary.Write(&buf, binary.BigEndian, fanout)
+
+	for _, e := range sorted {
+		buf.Write(e.ID[:])
+	}
+	for _, e := range sorted {
+		binary.Write(&buf, binary.BigEndian, e.CRC32)
+	}
+
+	// Offsets that do not fit in 31 bits go to a separate 64-bit table
+	var large []uint64
+	for _, e := range sorted {
+		if e.Offset < 0x80000000 {
+			binary.Write(&buf, binary.BigEndian, uint32(e.Offset))
+			continue
+		}
+		binary.Write(&buf, binary.BigEndian, uint32(0x80000000|len(large)))
+		large = append(large, uint64(e.Offset))
+	}
+	for _, offset := range large {
+		binary.Write(&buf, binary.BigEndian, offset)
+	}
+
+	buf.Write(packChecksum)
+	sum := sha1.Sum(buf.Bytes())
+	buf.Write(sum[:])
+
+	_, err := w.Write(buf.Bytes())
+	return err
+}
@@ -0,0 +1,334 @@
+package packfile
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/fenilsonani/vcs/internal/core/fault"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+// MultiPackIndexFile is the name of the multi-pack-index in the pack
+// directory
+const MultiPackIndexFile = "multi-pack-index"
+
+const (
+	midxSignature  = "MIDX"
+	midxHeaderSize = 12
+	midxChunkEntry = 12
+
+	midxChunkPackNames    = 0x504e414d // PNAM
+	midxChunkFanout       = 0x4f494446 // OIDF
+	midxChunkLookup       = 0x4f49444c // OIDL
+	midxChunkOffsets      = 0x4f4f4646 // OOFF
+	midxChunkLargeOffsets = 0x4c4f4646 // LOFF
+)
+
+// MultiPackIndex is a single index of the objects of many packs, Git's
+// multi-pack-index, so that finding an object takes one search instead of
+// one per pack
+type MultiPackIndex struct {
+	// packs holds the .idx names of the packs covered, sorted
+	packs   []string
+	fanout  [256]uint32
+	ids     []byte
+	offsets []byte
+	large   []byte
+}
+
+// LoadMultiPackIndex reads the multi-pack-index of the pack directory, or
+// returns nil when there is none
+func LoadMultiPackIndex(dir string) (*MultiPackIndex, error) {
+	data, err := os.ReadFile(filepath.Join(dir, MultiPackIndexFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read multi-pack-index: %w", err)
+	}
+	m, err := ParseMultiPackIndex(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid multi-pack-index: %w", err)
+	}
+	return m, nil
+}
+
+// ParseMultiPackIndex reads a multi-pack-index file
+func ParseMultiPackIndex(data []byte) (*MultiPackIndex, error) {
+	if len(data) < midxHeaderSize+midxChunkEntry+sha1.Size || string(data[:4]) != midxSignature {
+		return nil, fmt.Errorf("not a multi-pack-index file")
+	}
+	if data[4] != 1 {
+		return nil, fmt.Errorf("unsupported version %d", data[4])
+	}
+	if data[5] != 1 {
+		return nil, fmt.Errorf("unsupported hash version %d", data[5])
+	}
+	if data[7] != 0 {
+		return nil, fmt.Errorf("incremental multi-pack-indexes are not supported")
+	}
+	body := data[:len(data)-sha1.Size]
+	if sum := sha1.Sum(body); !bytes.Equal(sum[:], data[len(body):]) {
+		return nil, fmt.Errorf("checksum mismatch")
+	}
+
+	count := int(data[6])
+	if midxHeaderSize+(count+1)*midxChunkEntry > len(body) {
+		return nil, fmt.Errorf("truncated chunk table")
+	}
+	chunks := make(map[uint32][]byte, count)
+	for i := 0; i < count; i++ {
+		entry := body[midxHeaderSize+i*midxChunkEntry:]
+		id := binary.BigEndian.Uint32(entry)
+		start := binary.BigEndian.Uint64(entry[4:])
+		end := binary.BigEndian.Uint64(entry[4+midxChunkEntry:])
+		if start > end || end > uint64(len(body)) {
+			return nil, fmt.Errorf("invalid offset of chunk %08x", id)
+		}
+		chunks[id] = body[start:end]
+	}
+
+	m := &MultiPackIndex{
+		ids:     chunks[midxChunkLookup],
+		offsets: chunks[midxChunkOffsets],
+		large:   chunks[midxChunkLargeOffsets],
+	}
+	packCount := int(binary.BigEndian.Uint32(data[8:]))
+	for _, name := range strings.Split(string(chunks[midxChunkPackNames]), "\x00") {
+		if name != "" {
+			m.packs = append(m.packs, name)
+		}
+	}
+	if len(m.packs) != packCount {
+		return nil, fmt.Errorf("lists %d packs, want %d", len(m.packs), packCount)
+	}
+
+	fanout, ok := chunks[midxChunkFanout]
+	if !ok || len(fanout) != 256*4 {
+		return nil, fmt.Errorf("missing or invalid OIDF chunk")
+	}
+	for i := range m.fanout {
+		m.fanout[i] = binary.BigEndian.Uint32(fanout[i*4:])
+	}
+	objectCount := int(m.fanout[255])
+	if len(m.ids) != objectCount*sha1.Size {
+		return nil, fmt.Errorf("missing or invalid OIDL chunk")
+	}
+	if len(m.offsets) != objectCount*8 {
+		return nil, fmt.Errorf("missing or invalid OOFF chunk")
+	}
+	return m, nil
+}
+
+// PackNames returns the .idx names of the packs covered, sorted
+func (m *MultiPackIndex) PackNames() []string {
+	return m.packs
+}
+
+// Len returns the number of objects indexed
+func (m *MultiPackIndex) Len() int {
+	return int(m.fanout[255])
+}
+
+// id returns the object at position i
+func (m *MultiPackIndex) id(i int) objects.ObjectID {
+	var id objects.ObjectID
+	copy(id[:], m.ids[i*sha1.Size:])
+	return id
+}
+
+// search returns the position of the first object not below id
+func (m *MultiPackIndex) search(id objects.ObjectID) int {
+	lo := 0
+	if id[0] > 0 {
+		lo = int(m.fanout[id[0]-1])
+	}
+	hi := int(m.fanout[id[0]])
+	return lo + sort.Search(hi-lo, func(i int) bool {
+		return bytes.Compare(m.ids[(lo+i)*sha1.Size:(lo+i+1)*sha1.Size], id[:]) >= 0
+	})
+}
+
+// Find returns the .idx name of the pack holding id and its offset there
+func (m *MultiPackIndex) Find(id objects.ObjectID) (string, int64, bool) {
+	i := m.search(id)
+	if i >= m.Len() || m.id(i) != id {
+		return "", 0, false
+	}
+	entry := m.offsets[i*8:]
+	pack := binary.BigEndian.Uint32(entry)
+	offset := binary.BigEndian.Uint32(entry[4:])
+	if int(pack) >= len(m.packs) {
+		return "", 0, false
+	}
+	if offset&0x80000000 == 0 {
+		return m.packs[pack], int64(offset), true
+	}
+	pos := int(offset&0x7fffffff) * 8
+	if pos+8 > len(m.large) {
+		return "", 0, false
+	}
+	return m.packs[pack], int64(binary.BigEndian.Uint64(m.large[pos:])), true
+}
+
+// FindPrefix returns the indexed objects whose hex name starts with prefix
+func (m *MultiPackIndex) FindPrefix(prefix string) []objects.ObjectID {
+	var low objects.ObjectID
+	full := prefix + strings.Repeat("0", 2*len(low)-len(prefix))
+	if _, err := hex.Decode(low[:], []byte(full)); err != nil {
+		return nil
+	}
+
+	var ids []objects.ObjectID
+	for i := m.search(low); i < m.Len(); i++ {
+		id := m.id(i)
+		if !strings.HasPrefix(id.String(), prefix) {
+			break
+		}
+		ids = append(ids, id)
+	}
+	return ids
+}
+
+// WriteMultiPackIndex writes a multi-pack-index covering every pack in dir,
+// replacing any there. An object in several packs is taken from the most
+// recently modified one. It returns the number of packs covered.
+func WriteMultiPackIndex(dir string) (int, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, fmt.Errorf("failed to read pack directory: %w", err)
+	}
+
+	type packIndex struct {
+		name    string
+		mtime   int64
+		ids     []objects.ObjectID
+		offsets []int64
+	}
+	var packs []*packIndex
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, "pack-") || !strings.HasSuffix(name, ".idx") {
+			continue
+		}
+		info, err := os.Stat(filepath.Join(dir, strings.TrimSuffix(name, ".idx")+".pack"))
+		if err != nil {
+			// A pack still being written has no .pack yet
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			return 0, fmt.Errorf("failed to read pack index: %w", err)
+		}
+		ids, offsets, err := parseIndex(data)
+		if err != nil {
+			return 0, fmt.Errorf("invalid pack index %s: %w", name, err)
+		}
+		packs = append(packs, &packIndex{name: name, mtime: info.ModTime().UnixNano(), ids: ids, offsets: offsets})
+	}
+
+	path := filepath.Join(dir, MultiPackIndexFile)
+	if len(packs) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return 0, fmt.Errorf("failed to remove multi-pack-index: %w", err)
+		}
+		return 0, nil
+	}
+	sort.Slice(packs, func(i, j int) bool { return packs[i].name < packs[j].name })
+
+	type location struct {
+		pack   int
+		offset int64
+	}
+	found := make(map[objects.ObjectID]location)
+	for p, pack := range packs {
+		for i, id := range pack.ids {
+			if prev, ok := found[id]; ok && packs[prev.pack].mtime >= pack.mtime {
+				continue
+			}
+			found[id] = location{pack: p, offset: pack.offsets[i]}
+		}
+	}
+	ids := make([]objects.ObjectID, 0, len(found))
+	for id := range found {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return bytes.Compare(ids[i][:], ids[j][:]) < 0 })
+
+	var names, fanout, lookup, offsets, large bytes.Buffer
+	for _, pack := range packs {
+		names.WriteString(pack.name)
+		names.WriteByte(0)
+	}
+	for names.Len()%4 != 0 {
+		names.WriteByte(0)
+	}
+	var counts [256]uint32
+	for _, id := range ids {
+		counts[id[0]]++
+		lookup.Write(id[:])
+		loc := found[id]
+		binary.Write(&offsets, binary.BigEndian, uint32(loc.pack))
+		if loc.offset < 0x80000000 {
+			binary.Write(&offsets, binary.BigEndian, uint32(loc.offset))
+			continue
+		}
+		binary.Write(&offsets, binary.BigEndian, uint32(0x80000000|large.Len()/8))
+		binary.Write(&large, binary.BigEndian, uint64(loc.offset))
+	}
+	total := uint32(0)
+	for _, n := range counts {
+		total += n
+		binary.Write(&fanout, binary.BigEndian, total)
+	}
+
+	type chunk struct {
+		id   uint32
+		data []byte
+	}
+	chunks := []chunk{
+		{midxChunkPackNames, names.Bytes()},
+		{midxChunkFanout, fanout.Bytes()},
+		{midxChunkLookup, lookup.Bytes()},
+		{midxChunkOffsets, offsets.Bytes()},
+	}
+	if large.Len() > 0 {
+		chunks = append(chunks, chunk{midxChunkLargeOffsets, large.Bytes()})
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(midxSignature)
+	buf.Write([]byte{1, 1, byte(len(chunks)), 0})
+	binary.Write(&buf, binary.BigEndian, uint32(len(packs)))
+	offset := uint64(midxHeaderSize + (len(chunks)+1)*midxChunkEntry)
+	for _, c := range chunks {
+		binary.Write(&buf, binary.BigEndian, c.id)
+		binary.Write(&buf, binary.BigEndian, offset)
+		offset += uint64(len(c.data))
+	}
+	binary.Write(&buf, binary.BigEndian, uint32(0))
+	binary.Write(&buf, binary.BigEndian, offset)
+	for _, c := range chunks {
+		buf.Write(c.data)
+	}
+	sum := sha1.Sum(buf.Bytes())
+	buf.Write(sum[:])
+
+	tmp := path + ".lock"
+	if err := fault.WriteFile(tmp, buf.Bytes(), 0444); err != nil {
+		os.Remove(tmp)
+		return 0, fmt.Errorf("failed to write multi-pack-index: %w", err)
+	}
+	if err := fault.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return 0, fmt.Errorf("failed to write multi-pack-index: %w", err)
+	}
+	return len(packs), nil
+}
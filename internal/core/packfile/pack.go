@@ -0,0 +1,624 @@
+package packfile
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/fenilsonani/vcs/internal/core/fault"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/trace"
+)
+
+// maxDeltaChain bounds delta resolution so corrupt packs cannot recurse forever
+const maxDeltaChain = 10000
+
+// Pack provides random access to the objects of an on-disk pack through its
+// .idx file
+type Pack struct {
+	path    string
+	file    *os.File
+	size    int64
+	ids     []objects.ObjectID
+	offsets []int64
+	// ends holds the entry offsets in pack order, to find where each ends
+	endsOnce sync.Once
+	ends     []int64
+	// windows, when set, bounds how much of the pack is held in memory
+	windows *windowCache
+}
+
+// OpenPack opens a .pack file and the .idx file next to it
+func OpenPack(packPath string) (*Pack, error) {
+	idxPath := strings.TrimSuffix(packPath, ".pack") + ".idx"
+	idxData, err := os.ReadFile(idxPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read pack index: %w", err)
+	}
+
+	ids, offsets, err := parseIndex(idxData)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pack index %s: %w", filepath.Base(idxPath), err)
+	}
+
+	file, err := os.Open(packPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open pack: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to stat pack: %w", err)
+	}
+
+	return &Pack{
+		path:    packPath,
+		file:    file,
+		size:    info.Size(),
+		ids:     ids,
+		offsets: offsets,
+	}, nil
+}
+
+// parseIndex reads the sorted object names and offsets of a version 2 index
+func parseIndex(data []byte) ([]objects.ObjectID, []int64, error) {
+	if len(data) < 8+256*4+2*sha1.Size || !bytes.Equal(data[:4], indexSignature) {
+		return nil, nil, fmt.Errorf("not a version 2 pack index")
+	}
+	if version := binary.BigEndian.Uint32(data[4:8]); version != 2 {
+		return nil, nil, fmt.Errorf("unsupported index version: %d", version)
+	}
+
+	count := int(binary.BigEndian.Uint32(data[8+255*4:]))
+	namesStart := 8 + 256*4
+	offsetsStart := namesStart + count*(sha1.Size+4)
+	largeStart := offsetsStart + count*4
+	if len(data) < largeStart+2*sha1.Size {
+		return nil, nil, fmt.Errorf("truncated index")
+	}
+
+	ids := make([]objects.ObjectID, count)
+	offsets := make([]int64, count)
+	for i := 0; i < count; i++ {
+		copy(ids[i][:], data[namesStart+i*sha1.Size:])
+
+		offset := binary.BigEndian.Uint32(data[offsetsStart+i*4:])
+		if offset&0x80000000 == 0 {
+			offsets[i] = int64(offset)
+			continue
+		}
+		pos := largeStart + int(offset&0x7fffffff)*8
+		if pos+8 > len(data)-2*sha1.Size {
+			return nil, nil, fmt.Errorf("invalid large offset for %s", ids[i])
+		}
+		offsets[i] = int64(binary.BigEndian.Uint64(data[pos:]))
+	}
+
+	return ids, offsets, nil
+}
+
+// Path returns the path of the .pack file
+func (p *Pack) Path() string {
+	return p.path
+}
+
+// IDs returns the objects in the pack, sorted by name
+func (p *Pack) IDs() []objects.ObjectID {
+	return p.ids
+}
+
+// Close releases the pack file
+func (p *Pack) Close() error {
+	if p.windows != nil {
+		p.windows.drop(p)
+	}
+	return p.file.Close()
+}
+
+// Verify checks the pack against the checksum it ends with and its index:
+// the index must match its own checksum, record that of the pack and list
+// as many objects as the pack header declares. The objects themselves are
+// checked by reading them.
+func (p *Pack) Verify() error {
+	if p.size < 12+sha1.Size {
+		return fmt.Errorf("pack is truncated")
+	}
+	header := make([]byte, 12)
+	if _, err := p.file.ReadAt(header, 0); err != nil {
+		return fmt.Errorf("failed to read pack header: %w", err)
+	}
+	if string(header[:4]) != Signature {
+		return fmt.Errorf("invalid pack signature: %q", header[:4])
+	}
+
+	h := sha1.New()
+	if _, err := io.Copy(h, io.NewSectionReader(p.file, 0, p.size-sha1.Size)); err != nil {
+		return fmt.Errorf("failed to read pack: %w", err)
+	}
+	packSum, err := p.checksum()
+	if err != nil {
+		return err
+	}
+	if !bytes.Equal(h.Sum(nil), packSum) {
+		return fmt.Errorf("pack checksum mismatch")
+	}
+
+	idx, err := os.ReadFile(strings.TrimSuffix(p.path, ".pack") + ".idx")
+	if err != nil {
+		return fmt.Errorf("failed to read pack index: %w", err)
+	}
+	if len(idx) < 2*sha1.Size {
+		return fmt.Errorf("pack index is truncated")
+	}
+	body := idx[:len(idx)-sha1.Size]
+	if sum := sha1.Sum(body); !bytes.Equal(sum[:], idx[len(body):]) {
+		return fmt.Errorf("index checksum mismatch")
+	}
+	if !bytes.Equal(body[len(body)-sha1.Size:], packSum) {
+		return fmt.Errorf("index was written for another pack")
+	}
+	if count := binary.BigEndian.Uint32(header[8:]); int(count) != len(p.ids) {
+		return fmt.Errorf("pack holds %d objects but its index lists %d", count, len(p.ids))
+	}
+	return nil
+}
+
+// Contains reports whether the pack holds id
+func (p *Pack) Contains(id objects.ObjectID) bool {
+	_, ok := p.find(id)
+	return ok
+}
+
+// FindPrefix returns the objects in the pack whose hex name starts with prefix
+func (p *Pack) FindPrefix(prefix string) []objects.ObjectID {
+	// Search from the lowest name the prefix allows
+	var low objects.ObjectID
+	full := prefix + strings.Repeat("0", 2*len(low)-len(prefix))
+	if _, err := hex.Decode(low[:], []byte(full)); err != nil {
+		return nil
+	}
+
+	var ids []objects.ObjectID
+	i := sort.Search(len(p.ids), func(i int) bool {
+		return bytes.Compare(p.ids[i][:], low[:]) >= 0
+	})
+	for ; i < len(p.ids) && strings.HasPrefix(p.ids[i].String(), prefix); i++ {
+		ids = append(ids, p.ids[i])
+	}
+	return ids
+}
+
+// find returns the offset of id in the pack
+func (p *Pack) find(id objects.ObjectID) (int64, bool) {
+	if i, ok := p.index(id); ok {
+		return p.offsets[i], true
+	}
+	return 0, false
+}
+
+// index returns the position of id in the pack index
+func (p *Pack) index(id objects.ObjectID) (int, bool) {
+	i := sort.Search(len(p.ids), func(i int) bool {
+		return bytes.Compare(p.ids[i][:], id[:]) >= 0
+	})
+	return i, i < len(p.ids) && p.ids[i] == id
+}
+
+// ReadObject returns the type and content of id, resolving deltas
+func (p *Pack) ReadObject(id objects.ObjectID) (objects.ObjectType, []byte, error) {
+	offset, ok := p.find(id)
+	if !ok {
+		return "", nil, fmt.Errorf("object not found: %s", id)
+	}
+
+	packType, data, err := p.readAt(offset, 0)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to read %s from %s: %w", id, filepath.Base(p.path), err)
+	}
+
+	objType, err := packType.ToObjectType()
+	if err != nil {
+		return "", nil, err
+	}
+	return objType, data, nil
+}
+
+// EntrySize returns the number of bytes the entry of id takes in the pack,
+// which for a delta is the size of the compressed delta
+func (p *Pack) EntrySize(id objects.ObjectID) (int64, bool) {
+	offset, ok := p.find(id)
+	if !ok {
+		return 0, false
+	}
+	p.endsOnce.Do(func() {
+		p.ends = append([]int64(nil), p.offsets...)
+		sort.Slice(p.ends, func(i, j int) bool { return p.ends[i] < p.ends[j] })
+	})
+
+	end := p.size - sha1.Size
+	if i := sort.Search(len(p.ends), func(i int) bool { return p.ends[i] > offset }); i < len(p.ends) {
+		end = p.ends[i]
+	}
+	return end - offset, true
+}
+
+// readAt reads and fully resolves the entry at offset
+func (p *Pack) readAt(offset int64, depth int) (ObjectType, []byte, error) {
+	if depth > maxDeltaChain {
+		return 0, nil, fmt.Errorf("delta chain too deep")
+	}
+	if offset < 12 || offset >= p.size-sha1.Size {
+		return 0, nil, fmt.Errorf("invalid entry offset %d", offset)
+	}
+
+	var section io.Reader = io.NewSectionReader(p.file, offset, p.size-sha1.Size-offset)
+	if p.windows != nil {
+		section = &windowReader{cache: p.windows, pack: p, off: offset, end: p.size - sha1.Size}
+	}
+	pr := &Reader{r: &countingReader{r: bufio.NewReader(section), h: sha1.New(), n: offset}}
+	entry, err := pr.readEntry()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var baseType ObjectType
+	var base []byte
+	switch entry.Type {
+	case ObjOfsDelta:
+		baseType, base, err = p.readAt(entry.BaseOffset, depth+1)
+	case ObjRefDelta:
+		baseOffset, ok := p.find(entry.BaseID)
+		if !ok {
+			return 0, nil, fmt.Errorf("delta base %s not in pack", entry.BaseID)
+		}
+		baseType, base, err = p.readAt(baseOffset, depth+1)
+	default:
+		return entry.Type, entry.Data, nil
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+
+	data, err := ApplyDelta(base, entry.Data)
+	if err != nil {
+		return 0, nil, fmt.Errorf("failed to apply delta at offset %d: %w", offset, err)
+	}
+	return baseType, data, nil
+}
+
+// PackDir provides the objects of every pack in an objects/pack directory.
+// Packs added to the directory are picked up on the next lookup miss. When
+// the directory has a multi-pack-index, objects are found through it and the
+// packs it covers are only opened once an object is read from them.
+type PackDir struct {
+	dir     string
+	mu      sync.Mutex
+	packs   map[string]*Pack
+	windows *windowCache
+	// midx is the multi-pack-index read when the file had midxInfo
+	midx     *MultiPackIndex
+	midxInfo os.FileInfo
+	// covered holds the names of the packs midx covers
+	covered map[string]bool
+	// bitmap is the last bitmap Bitmap loaded
+	bitmap *PackBitmap
+}
+
+// NewPackDir returns a PackDir for dir. Packs are opened lazily.
+func NewPackDir(dir string) *PackDir {
+	return &PackDir{
+		dir:   dir,
+		packs: make(map[string]*Pack),
+	}
+}
+
+// SetWindowLimits reads packs through windows of windowSize bytes, keeping
+// at most limit bytes of them in memory across all packs, the equivalent of
+// core.packedGitWindowSize and core.packedGitLimit. A windowSize of zero
+// reads entries straight from the files. Packs already open are unaffected.
+func (d *PackDir) SetWindowLimits(windowSize, limit int64) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.windows = nil
+	if windowSize > 0 {
+		d.windows = newWindowCache(windowSize, limit)
+	}
+}
+
+// Packs returns the packs currently in the directory
+func (d *PackDir) Packs() ([]*Pack, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.refresh(); err != nil {
+		return nil, err
+	}
+
+	for name := range d.covered {
+		if _, err := d.open(name); err != nil {
+			return nil, err
+		}
+	}
+
+	packs := make([]*Pack, 0, len(d.packs))
+	for _, pack := range d.packs {
+		packs = append(packs, pack)
+	}
+	sort.Slice(packs, func(i, j int) bool { return packs[i].path < packs[j].path })
+	return packs, nil
+}
+
+// ReadPackedObject reads id from whichever pack holds it
+func (d *PackDir) ReadPackedObject(id objects.ObjectID) (objects.ObjectType, []byte, error) {
+	pack, err := d.lookup(id)
+	if err != nil {
+		return "", nil, err
+	}
+	return pack.ReadObject(id)
+}
+
+// HasPackedObject reports whether any pack holds id
+func (d *PackDir) HasPackedObject(id objects.ObjectID) bool {
+	_, err := d.lookup(id)
+	return err == nil
+}
+
+// PackedObjectSize returns the number of bytes id takes in the pack holding
+// it
+func (d *PackDir) PackedObjectSize(id objects.ObjectID) (int64, error) {
+	pack, err := d.lookup(id)
+	if err != nil {
+		return 0, err
+	}
+	size, _ := pack.EntrySize(id)
+	return size, nil
+}
+
+// FindPackedObjects returns the packed objects whose hex name starts with
+// prefix
+func (d *PackDir) FindPackedObjects(prefix string) ([]objects.ObjectID, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.refresh(); err != nil {
+		return nil, err
+	}
+	var ids []objects.ObjectID
+	if d.midx != nil {
+		ids = d.midx.FindPrefix(prefix)
+	}
+	for name, pack := range d.packs {
+		if !d.covered[name] {
+			ids = append(ids, pack.FindPrefix(prefix)...)
+		}
+	}
+	return ids, nil
+}
+
+// Bitmap returns the reachability bitmap of a pack in the directory, or
+// nil when no pack has a usable one
+func (d *PackDir) Bitmap() (*PackBitmap, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.refresh(); err != nil {
+		return nil, err
+	}
+	paths, err := filepath.Glob(filepath.Join(d.dir, "pack-*.bitmap"))
+	if err != nil {
+		return nil, err
+	}
+	for _, path := range paths {
+		packName := strings.TrimSuffix(filepath.Base(path), ".bitmap") + ".pack"
+		if d.bitmap != nil && d.bitmap.pack == d.packs[packName] {
+			return d.bitmap, nil
+		}
+		pack, err := d.open(packName)
+		if err != nil {
+			continue
+		}
+		// A damaged bitmap only costs speed
+		if bitmap, err := LoadBitmap(pack); err == nil && bitmap != nil {
+			d.bitmap = bitmap
+			return bitmap, nil
+		}
+	}
+	return nil, nil
+}
+
+// Close closes every open pack
+func (d *PackDir) Close() error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	d.bitmap = nil
+	var firstErr error
+	for name, pack := range d.packs {
+		if err := pack.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+		delete(d.packs, name)
+	}
+	return firstErr
+}
+
+// lookup finds the pack holding id, rescanning the directory on a miss
+func (d *PackDir) lookup(id objects.ObjectID) (*Pack, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if pack := d.find(id); pack != nil {
+		return pack, nil
+	}
+	if err := d.refresh(); err != nil {
+		return nil, err
+	}
+	if pack := d.find(id); pack != nil {
+		return pack, nil
+	}
+
+	return nil, fmt.Errorf("object not found: %s", id)
+}
+
+// find returns the pack known to hold id, or nil. A pack the
+// multi-pack-index names that cannot be opened is left for refresh to
+// notice. Callers hold d.mu.
+func (d *PackDir) find(id objects.ObjectID) *Pack {
+	if d.midx != nil {
+		if idxName, _, ok := d.midx.Find(id); ok {
+			if pack, err := d.open(strings.TrimSuffix(idxName, ".idx") + ".pack"); err == nil {
+				return pack
+			}
+		}
+	}
+	for name, pack := range d.packs {
+		if !d.covered[name] && pack.Contains(id) {
+			return pack
+		}
+	}
+	return nil
+}
+
+// open returns the pack named, opening it if needed. Callers hold d.mu.
+func (d *PackDir) open(packName string) (*Pack, error) {
+	if pack, ok := d.packs[packName]; ok {
+		return pack, nil
+	}
+	pack, err := OpenPack(filepath.Join(d.dir, packName))
+	if err != nil {
+		return nil, err
+	}
+	pack.windows = d.windows
+	d.packs[packName] = pack
+	return pack, nil
+}
+
+// refresh opens new packs and forgets deleted ones. Callers hold d.mu.
+func (d *PackDir) refresh() error {
+	entries, err := os.ReadDir(d.dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read pack directory: %w", err)
+	}
+
+	present := make(map[string]bool)
+	for _, entry := range entries {
+		name := entry.Name()
+		if strings.HasPrefix(name, "pack-") && strings.HasSuffix(name, ".idx") {
+			present[strings.TrimSuffix(name, ".idx")+".pack"] = true
+		}
+	}
+
+	d.refreshMultiPackIndex(present)
+	for packName := range present {
+		if _, ok := d.packs[packName]; ok || d.covered[packName] {
+			continue
+		}
+		if _, err := d.open(packName); err != nil {
+			// A pack still being written has no .pack yet
+			continue
+		}
+	}
+
+	for name, pack := range d.packs {
+		if !present[name] {
+			pack.Close()
+			delete(d.packs, name)
+		}
+	}
+
+	return nil
+}
+
+// refreshMultiPackIndex rereads the multi-pack-index when it changed. One
+// that cannot be read, or that covers a pack no longer present, is ignored
+// and the packs are searched one by one. Callers hold d.mu.
+func (d *PackDir) refreshMultiPackIndex(present map[string]bool) {
+	info, err := os.Stat(filepath.Join(d.dir, MultiPackIndexFile))
+	if err != nil {
+		d.midx, d.midxInfo, d.covered = nil, nil, nil
+		return
+	}
+	if d.midxInfo == nil || !info.ModTime().Equal(d.midxInfo.ModTime()) || info.Size() != d.midxInfo.Size() {
+		d.midx, d.covered = nil, nil
+		d.midxInfo = info
+		midx, err := LoadMultiPackIndex(d.dir)
+		if err != nil || midx == nil {
+			return
+		}
+		d.midx = midx
+		d.covered = make(map[string]bool, len(midx.packs))
+		for _, name := range midx.packs {
+			d.covered[strings.TrimSuffix(name, ".idx")+".pack"] = true
+		}
+	}
+	for name := range d.covered {
+		if !present[name] {
+			d.midx, d.covered = nil, nil
+			return
+		}
+	}
+}
+
+// SavePack writes objs as pack-<checksum>.pack and its .idx into dir. The
+// index is renamed into place last, so PackDir never sees a partial pack.
+func SavePack(dir string, objs []*Object, opts WriterOptions) (string, *WriteResult, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", nil, fmt.Errorf("failed to create pack directory: %w", err)
+	}
+
+	tmpPack, err := os.CreateTemp(dir, "tmp_pack_")
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to create pack: %w", err)
+	}
+	defer os.Remove(tmpPack.Name())
+
+	bw := bufio.NewWriter(fault.NewWriter(tmpPack, tmpPack.Name()))
+	result, err := WritePack(bw, objs, opts)
+	if err == nil {
+		err = bw.Flush()
+	}
+	if closeErr := tmpPack.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to write pack: %w", err)
+	}
+
+	var idx bytes.Buffer
+	if err := WriteIndex(&idx, result.Entries, result.Checksum); err != nil {
+		return "", nil, fmt.Errorf("failed to write pack index: %w", err)
+	}
+	tmpIdx := filepath.Join(dir, filepath.Base(tmpPack.Name())+".idx")
+	if err := fault.WriteFile(tmpIdx, idx.Bytes(), 0444); err != nil {
+		os.Remove(tmpIdx)
+		return "", nil, fmt.Errorf("failed to write pack index: %w", err)
+	}
+	defer os.Remove(tmpIdx)
+
+	base := filepath.Join(dir, fmt.Sprintf("pack-%x", result.Checksum))
+	if err := os.Chmod(tmpPack.Name(), 0444); err != nil {
+		return "", nil, fmt.Errorf("failed to finalize pack: %w", err)
+	}
+	if err := fault.Rename(tmpPack.Name(), base+".pack"); err != nil {
+		return "", nil, fmt.Errorf("failed to finalize pack: %w", err)
+	}
+	if err := fault.Rename(tmpIdx, base+".idx"); err != nil {
+		return "", nil, fmt.Errorf("failed to finalize pack index: %w", err)
+	}
+
+	trace.General.Printf("pack: wrote %s.pack (%d objects, %d deltas)", base, len(result.Entries), result.Deltas)
+	return base + ".pack", result, nil
+}
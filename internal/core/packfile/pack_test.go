@@ -0,0 +1,236 @@
+package packfile
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+func TestSavePackAndRead(t *testing.T) {
+	var objs []*Object
+	for v := 1; v <= 4; v++ {
+		objs = append(objs, newBlob(versionedFile(v)))
+	}
+
+	dir := t.TempDir()
+	packPath, result, err := SavePack(dir, objs, DefaultWriterOptions())
+	if err != nil {
+		t.Fatalf("SavePack() error = %v", err)
+	}
+	if result.Deltas == 0 {
+		t.Errorf("SavePack() wrote no deltas")
+	}
+
+	pack, err := OpenPack(packPath)
+	if err != nil {
+		t.Fatalf("OpenPack() error = %v", err)
+	}
+	defer pack.Close()
+
+	if len(pack.IDs()) != len(objs) {
+		t.Errorf("IDs() = %d, want %d", len(pack.IDs()), len(objs))
+	}
+	for _, obj := range objs {
+		objType, data, err := pack.ReadObject(obj.ID)
+		if err != nil {
+			t.Fatalf("ReadObject(%s) error = %v", obj.ID, err)
+		}
+		if objType != objects.TypeBlob || !bytes.Equal(data, obj.Data) {
+			t.Errorf("ReadObject(%s) returned wrong content", obj.ID)
+		}
+	}
+
+	// The entries fill the pack between its header and trailing checksum
+	var total int64
+	for _, obj := range objs {
+		size, ok := pack.EntrySize(obj.ID)
+		if !ok || size <= 0 {
+			t.Fatalf("EntrySize(%s) = %d, %v", obj.ID, size, ok)
+		}
+		total += size
+	}
+	if info, _ := os.Stat(packPath); total != info.Size()-12-20 {
+		t.Errorf("entry sizes add up to %d, want %d", total, info.Size()-32)
+	}
+
+	missing := newBlob("not packed")
+	if pack.Contains(missing.ID) {
+		t.Errorf("Contains() = true for an object not in the pack")
+	}
+	if _, _, err := pack.ReadObject(missing.ID); err == nil {
+		t.Errorf("ReadObject() expected error for missing object")
+	}
+}
+
+func TestPackVerify(t *testing.T) {
+	objs := []*Object{newBlob(versionedFile(1)), newBlob(versionedFile(2))}
+	packPath, _, err := SavePack(t.TempDir(), objs, DefaultWriterOptions())
+	if err != nil {
+		t.Fatalf("SavePack() error = %v", err)
+	}
+	pack, err := OpenPack(packPath)
+	if err != nil {
+		t.Fatalf("OpenPack() error = %v", err)
+	}
+	defer pack.Close()
+	if err := pack.Verify(); err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+
+	// Flip a byte inside the first entry
+	data, err := os.ReadFile(packPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	os.Chmod(packPath, 0644)
+	data[20] ^= 0xff
+	if err := os.WriteFile(packPath, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := pack.Verify(); err == nil || !strings.Contains(err.Error(), "pack checksum mismatch") {
+		t.Errorf("Verify() of a corrupt pack = %v", err)
+	}
+}
+
+func TestPackDir(t *testing.T) {
+	dir := t.TempDir()
+	packDir := NewPackDir(dir)
+	defer packDir.Close()
+
+	first := newBlob("first")
+	if packDir.HasPackedObject(first.ID) {
+		t.Fatalf("HasPackedObject() = true for an empty directory")
+	}
+
+	// Packs written after the first lookup are picked up on a miss
+	if _, _, err := SavePack(dir, []*Object{first}, DefaultWriterOptions()); err != nil {
+		t.Fatalf("SavePack() error = %v", err)
+	}
+	second := newBlob("second")
+	if _, _, err := SavePack(dir, []*Object{second}, DefaultWriterOptions()); err != nil {
+		t.Fatalf("SavePack() error = %v", err)
+	}
+
+	for _, obj := range []*Object{first, second} {
+		_, data, err := packDir.ReadPackedObject(obj.ID)
+		if err != nil {
+			t.Fatalf("ReadPackedObject(%s) error = %v", obj.ID, err)
+		}
+		if !bytes.Equal(data, obj.Data) {
+			t.Errorf("ReadPackedObject(%s) = %q, want %q", obj.ID, data, obj.Data)
+		}
+	}
+
+	packs, err := packDir.Packs()
+	if err != nil {
+		t.Fatalf("Packs() error = %v", err)
+	}
+	if len(packs) != 2 {
+		t.Errorf("Packs() = %d packs, want 2", len(packs))
+	}
+}
+
+func TestPackDirWindows(t *testing.T) {
+	var objs []*Object
+	for v := 1; v <= 4; v++ {
+		objs = append(objs, newBlob(versionedFile(v)))
+	}
+
+	dir := t.TempDir()
+	if _, _, err := SavePack(dir, objs, DefaultWriterOptions()); err != nil {
+		t.Fatalf("SavePack() error = %v", err)
+	}
+
+	packDir := NewPackDir(dir)
+	defer packDir.Close()
+	packDir.SetWindowLimits(512, 2048)
+
+	for _, obj := range objs {
+		_, data, err := packDir.ReadPackedObject(obj.ID)
+		if err != nil {
+			t.Fatalf("ReadPackedObject(%s) error = %v", obj.ID, err)
+		}
+		if !bytes.Equal(data, obj.Data) {
+			t.Errorf("ReadPackedObject(%s) returned wrong content", obj.ID)
+		}
+	}
+
+	windows := packDir.windows
+	if windows.used > 2048 || windows.lru.Len() == 0 {
+		t.Errorf("window cache holds %d bytes in %d windows, want 1 to 4 windows", windows.used, windows.lru.Len())
+	}
+
+	packDir.Close()
+	if windows.lru.Len() != 0 || windows.used != 0 {
+		t.Errorf("window cache holds %d windows after Close(), want 0", windows.lru.Len())
+	}
+}
+
+func TestMultiPackIndex(t *testing.T) {
+	dir := t.TempDir()
+	shared := newBlob("shared")
+	var objs []*Object
+	for _, content := range []string{"first", "second"} {
+		obj := newBlob(content)
+		objs = append(objs, obj)
+		if _, _, err := SavePack(dir, []*Object{obj, shared}, DefaultWriterOptions()); err != nil {
+			t.Fatalf("SavePack() error = %v", err)
+		}
+	}
+	objs = append(objs, shared)
+
+	packs, err := WriteMultiPackIndex(dir)
+	if err != nil || packs != 2 {
+		t.Fatalf("WriteMultiPackIndex() = %d, %v; want 2 packs", packs, err)
+	}
+	midx, err := LoadMultiPackIndex(dir)
+	if err != nil || midx == nil {
+		t.Fatalf("LoadMultiPackIndex() = %v, %v", midx, err)
+	}
+	if midx.Len() != 3 || len(midx.PackNames()) != 2 {
+		t.Errorf("multi-pack-index holds %d objects of %d packs, want 3 of 2", midx.Len(), len(midx.PackNames()))
+	}
+	if ids := midx.FindPrefix(shared.ID.String()[:6]); len(ids) != 1 || ids[0] != shared.ID {
+		t.Errorf("FindPrefix() = %v, want %s", ids, shared.ID)
+	}
+
+	packDir := NewPackDir(dir)
+	defer packDir.Close()
+	for _, obj := range objs {
+		_, data, err := packDir.ReadPackedObject(obj.ID)
+		if err != nil {
+			t.Fatalf("ReadPackedObject(%s) error = %v", obj.ID, err)
+		}
+		if !bytes.Equal(data, obj.Data) {
+			t.Errorf("ReadPackedObject(%s) = %q, want %q", obj.ID, data, obj.Data)
+		}
+	}
+
+	// A pack written after the index is searched on its own
+	third := newBlob("third")
+	if _, _, err := SavePack(dir, []*Object{third}, DefaultWriterOptions()); err != nil {
+		t.Fatalf("SavePack() error = %v", err)
+	}
+	if !packDir.HasPackedObject(third.ID) {
+		t.Errorf("HasPackedObject() = false for a pack not in the multi-pack-index")
+	}
+	if packs, err := packDir.Packs(); err != nil || len(packs) != 3 {
+		t.Errorf("Packs() = %d packs, %v; want 3", len(packs), err)
+	}
+
+	// An index naming a removed pack is ignored
+	for _, name := range midx.PackNames()[:1] {
+		base := filepath.Join(dir, strings.TrimSuffix(name, ".idx"))
+		os.Remove(base + ".idx")
+		os.Remove(base + ".pack")
+	}
+	fresh := NewPackDir(dir)
+	defer fresh.Close()
+	if !fresh.HasPackedObject(third.ID) {
+		t.Errorf("HasPackedObject() = false with a stale multi-pack-index")
+	}
+}
@@ -0,0 +1,91 @@
+// Package packfile reads Git packfiles (version 2) as produced by
+// git-upload-pack and git-pack-objects.
+package packfile
+
+import (
+	"fmt"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+// Signature is the magic number at the start of every packfile
+const Signature = "PACK"
+
+// Version is the only packfile version this package supports
+const Version = 2
+
+// ObjectType is the 3-bit type stored in a packfile entry header
+type ObjectType byte
+
+const (
+	ObjCommit   ObjectType = 1
+	ObjTree     ObjectType = 2
+	ObjBlob     ObjectType = 3
+	ObjTag      ObjectType = 4
+	ObjOfsDelta ObjectType = 6
+	ObjRefDelta ObjectType = 7
+)
+
+// IsDelta returns true if the entry stores a delta against another object
+func (t ObjectType) IsDelta() bool {
+	return t == ObjOfsDelta || t == ObjRefDelta
+}
+
+// String returns the name Git uses for the type
+func (t ObjectType) String() string {
+	switch t {
+	case ObjCommit:
+		return "commit"
+	case ObjTree:
+		return "tree"
+	case ObjBlob:
+		return "blob"
+	case ObjTag:
+		return "tag"
+	case ObjOfsDelta:
+		return "ofs-delta"
+	case ObjRefDelta:
+		return "ref-delta"
+	default:
+		return fmt.Sprintf("unknown(%d)", byte(t))
+	}
+}
+
+// ToObjectType converts a non-delta pack type into an object type
+func (t ObjectType) ToObjectType() (objects.ObjectType, error) {
+	switch t {
+	case ObjCommit:
+		return objects.TypeCommit, nil
+	case ObjTree:
+		return objects.TypeTree, nil
+	case ObjBlob:
+		return objects.TypeBlob, nil
+	case ObjTag:
+		return objects.TypeTag, nil
+	default:
+		return "", fmt.Errorf("pack type %s has no object type", t)
+	}
+}
+
+// FromObjectType converts an object type into its pack type
+func FromObjectType(t objects.ObjectType) (ObjectType, error) {
+	switch t {
+	case objects.TypeCommit:
+		return ObjCommit, nil
+	case objects.TypeTree:
+		return ObjTree, nil
+	case objects.TypeBlob:
+		return ObjBlob, nil
+	case objects.TypeTag:
+		return ObjTag, nil
+	default:
+		return 0, fmt.Errorf("unknown object type: %s", t)
+	}
+}
+
+// ObjectStore is where unpacked objects are written and where bases for
+// thin-pack deltas are looked up
+type ObjectStore interface {
+	ReadRawObject(id objects.ObjectID) (objects.ObjectType, []byte, error)
+	WriteRawObject(objType objects.ObjectType, data []byte) (objects.ObjectID, error)
+}
@@ -0,0 +1,244 @@
+package packfile
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"strings"
+	"testing"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+// testPack builds packfiles by hand for tests
+type testPack struct {
+	buf   bytes.Buffer
+	count uint32
+}
+
+func (p *testPack) entryHeader(t ObjectType, size int) {
+	b := byte(t)<<4 | byte(size&0x0f)
+	size >>= 4
+	for size > 0 {
+		p.buf.WriteByte(b | 0x80)
+		b = byte(size & 0x7f)
+		size >>= 7
+	}
+	p.buf.WriteByte(b)
+}
+
+func (p *testPack) compressed(data []byte) {
+	zw := zlib.NewWriter(&p.buf)
+	zw.Write(data)
+	zw.Close()
+}
+
+func (p *testPack) addObject(t ObjectType, data []byte) int64 {
+	offset := int64(12 + p.buf.Len())
+	p.entryHeader(t, len(data))
+	p.compressed(data)
+	p.count++
+	return offset
+}
+
+func (p *testPack) addOfsDelta(baseOffset int64, delta []byte) int64 {
+	offset := int64(12 + p.buf.Len())
+	p.entryHeader(ObjOfsDelta, len(delta))
+
+	distance := offset - baseOffset
+	encoded := []byte{byte(distance & 0x7f)}
+	for distance >>= 7; distance > 0; distance >>= 7 {
+		distance--
+		encoded = append([]byte{byte(0x80 | distance&0x7f)}, encoded...)
+	}
+	p.buf.Write(encoded)
+
+	p.compressed(delta)
+	p.count++
+	return offset
+}
+
+func (p *testPack) addRefDelta(base objects.ObjectID, delta []byte) {
+	p.entryHeader(ObjRefDelta, len(delta))
+	p.buf.Write(base[:])
+	p.compressed(delta)
+	p.count++
+}
+
+func (p *testPack) bytes() []byte {
+	var out bytes.Buffer
+	out.WriteString(Signature)
+	binary.Write(&out, binary.BigEndian, uint32(Version))
+	binary.Write(&out, binary.BigEndian, p.count)
+	out.Write(p.buf.Bytes())
+	sum := sha1.Sum(out.Bytes())
+	out.Write(sum[:])
+	return out.Bytes()
+}
+
+// makeDelta builds a delta that copies base[:copyLen] and appends insert
+func makeDelta(base []byte, copyLen int, insert []byte) []byte {
+	var d []byte
+	d = append(d, deltaSize(len(base))...)
+	d = append(d, deltaSize(copyLen+len(insert))...)
+	d = append(d, 0x80|0x10, byte(copyLen))
+	d = append(d, byte(len(insert)))
+	d = append(d, insert...)
+	return d
+}
+
+func deltaSize(n int) []byte {
+	var out []byte
+	for {
+		b := byte(n & 0x7f)
+		n >>= 7
+		if n == 0 {
+			return append(out, b)
+		}
+		out = append(out, b|0x80)
+	}
+}
+
+func newStore(t *testing.T) *objects.Storage {
+	store := objects.NewStorage(t.TempDir())
+	if err := store.Init(); err != nil {
+		t.Fatalf("Storage.Init() error = %v", err)
+	}
+	return store
+}
+
+func readBlob(t *testing.T, store *objects.Storage, id objects.ObjectID) string {
+	objType, data, err := store.ReadRawObject(id)
+	if err != nil {
+		t.Fatalf("ReadRawObject(%s) error = %v", id, err)
+	}
+	if objType != objects.TypeBlob {
+		t.Fatalf("ReadRawObject(%s) type = %s, want blob", id, objType)
+	}
+	return string(data)
+}
+
+func TestApplyDelta(t *testing.T) {
+	base := []byte("hello world")
+	result, err := ApplyDelta(base, makeDelta(base, 5, []byte(", gophers")))
+	if err != nil {
+		t.Fatalf("ApplyDelta() error = %v", err)
+	}
+	if string(result) != "hello, gophers" {
+		t.Errorf("ApplyDelta() = %q, want %q", result, "hello, gophers")
+	}
+
+	if _, err := ApplyDelta([]byte("short"), makeDelta(base, 5, nil)); err == nil {
+		t.Error("expected error for base size mismatch")
+	}
+
+	if _, err := ApplyDelta(base, []byte{11, 5, 0x00}); err == nil {
+		t.Error("expected error for zero delta instruction")
+	}
+}
+
+func TestUnpack(t *testing.T) {
+	store := newStore(t)
+
+	base := []byte("package main\n")
+	p := &testPack{}
+	baseOffset := p.addObject(ObjBlob, base)
+	p.addOfsDelta(baseOffset, makeDelta(base, len(base), []byte("func main() {}\n")))
+
+	result, err := Unpack(bytes.NewReader(p.bytes()), store)
+	if err != nil {
+		t.Fatalf("Unpack() error = %v", err)
+	}
+	if len(result.Objects) != 2 || result.Deltas != 1 {
+		t.Fatalf("Unpack() = %d objects, %d deltas; want 2, 1", len(result.Objects), result.Deltas)
+	}
+	if len(result.Checksum) != sha1.Size {
+		t.Errorf("checksum length = %d, want %d", len(result.Checksum), sha1.Size)
+	}
+
+	want := "package main\nfunc main() {}\n"
+	if got := readBlob(t, store, result.Objects[1]); got != want {
+		t.Errorf("delta object = %q, want %q", got, want)
+	}
+	if result.Objects[1] != objects.ComputeHash(objects.TypeBlob, []byte(want)) {
+		t.Error("delta object stored under wrong ID")
+	}
+}
+
+func TestUnpackRefDeltaOutOfOrder(t *testing.T) {
+	store := newStore(t)
+
+	base := []byte("base content")
+	baseID := objects.ComputeHash(objects.TypeBlob, base)
+
+	p := &testPack{}
+	p.addRefDelta(baseID, makeDelta(base, 4, []byte(" line")))
+	p.addObject(ObjBlob, base)
+
+	result, err := Unpack(bytes.NewReader(p.bytes()), store)
+	if err != nil {
+		t.Fatalf("Unpack() error = %v", err)
+	}
+	if len(result.Objects) != 2 {
+		t.Fatalf("Unpack() = %d objects, want 2", len(result.Objects))
+	}
+
+	if got := readBlob(t, store, objects.ComputeHash(objects.TypeBlob, []byte("base line"))); got != "base line" {
+		t.Errorf("delta object = %q, want %q", got, "base line")
+	}
+}
+
+func TestUnpackThinPack(t *testing.T) {
+	store := newStore(t)
+
+	base := []byte("already present")
+	baseID, err := store.WriteRawObject(objects.TypeBlob, base)
+	if err != nil {
+		t.Fatalf("WriteRawObject() error = %v", err)
+	}
+
+	p := &testPack{}
+	p.addRefDelta(baseID, makeDelta(base, 7, []byte(" here")))
+
+	result, err := Unpack(bytes.NewReader(p.bytes()), store)
+	if err != nil {
+		t.Fatalf("Unpack() error = %v", err)
+	}
+	if len(result.Objects) != 1 {
+		t.Fatalf("Unpack() = %d objects, want 1", len(result.Objects))
+	}
+
+	if got := readBlob(t, store, result.Objects[0]); got != "already here" {
+		t.Errorf("delta object = %q, want %q", got, "already here")
+	}
+}
+
+func TestUnpackErrors(t *testing.T) {
+	badChecksum := &testPack{}
+	badChecksum.addObject(ObjBlob, []byte("data"))
+	corrupt := badChecksum.bytes()
+	corrupt[len(corrupt)-1] ^= 0xff
+
+	missingBase := &testPack{}
+	missingBase.addRefDelta(objects.ComputeHash(objects.TypeBlob, []byte("nope")), makeDelta([]byte("nope"), 4, nil))
+
+	tests := []struct {
+		name    string
+		data    []byte
+		wantErr string
+	}{
+		{"bad signature", []byte("KCAP\x00\x00\x00\x02\x00\x00\x00\x00"), "signature"},
+		{"checksum mismatch", corrupt, "checksum"},
+		{"missing delta base", missingBase.bytes(), "not found"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := Unpack(bytes.NewReader(tt.data), newStore(t))
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("Unpack() error = %v, want error containing %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -0,0 +1,234 @@
+package packfile
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+// Entry is a single packfile entry as stored on the wire. For delta entries
+// Data holds the delta instructions and BaseOffset or BaseID names the base.
+type Entry struct {
+	Offset     int64
+	Type       ObjectType
+	Size       int64
+	Data       []byte
+	BaseOffset int64
+	BaseID     objects.ObjectID
+}
+
+// Reader reads entries sequentially from a packfile stream
+type Reader struct {
+	r        *countingReader
+	count    uint32
+	read     uint32
+	checksum []byte
+}
+
+// countingReader tracks the stream offset and hashes everything it returns.
+// It implements io.ByteReader so zlib never reads past the end of an entry.
+type countingReader struct {
+	r *bufio.Reader
+	h hash.Hash
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.h.Write(p[:n])
+	c.n += int64(n)
+	return n, err
+}
+
+func (c *countingReader) ReadByte() (byte, error) {
+	b, err := c.r.ReadByte()
+	if err == nil {
+		c.h.Write([]byte{b})
+		c.n++
+	}
+	return b, err
+}
+
+// NewReader reads the packfile header and returns a reader for its entries
+func NewReader(r io.Reader) (*Reader, error) {
+	cr := &countingReader{r: bufio.NewReader(r), h: sha1.New()}
+
+	header := make([]byte, 12)
+	if _, err := io.ReadFull(cr, header); err != nil {
+		return nil, fmt.Errorf("failed to read pack header: %w", err)
+	}
+
+	if string(header[:4]) != Signature {
+		return nil, fmt.Errorf("invalid pack signature: %q", header[:4])
+	}
+
+	version := binary.BigEndian.Uint32(header[4:8])
+	if version != Version {
+		return nil, fmt.Errorf("unsupported pack version: %d", version)
+	}
+
+	return &Reader{
+		r:     cr,
+		count: binary.BigEndian.Uint32(header[8:12]),
+	}, nil
+}
+
+// Count returns the number of entries declared in the pack header
+func (pr *Reader) Count() uint32 {
+	return pr.count
+}
+
+// Checksum returns the pack trailer once all entries have been read
+func (pr *Reader) Checksum() []byte {
+	return pr.checksum
+}
+
+// Next returns the next entry. After the last entry it verifies the pack
+// trailer and returns io.EOF.
+func (pr *Reader) Next() (*Entry, error) {
+	if pr.read == pr.count {
+		if pr.checksum == nil {
+			if err := pr.verifyTrailer(); err != nil {
+				return nil, err
+			}
+		}
+		return nil, io.EOF
+	}
+
+	entry, err := pr.readEntry()
+	if err != nil {
+		return nil, err
+	}
+
+	pr.read++
+	return entry, nil
+}
+
+// readEntry reads the entry at the current offset
+func (pr *Reader) readEntry() (*Entry, error) {
+	entry := &Entry{Offset: pr.r.n}
+
+	objType, size, err := pr.readEntryHeader()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read entry header at offset %d: %w", entry.Offset, err)
+	}
+	entry.Type = objType
+	entry.Size = size
+
+	switch objType {
+	case ObjCommit, ObjTree, ObjBlob, ObjTag:
+	case ObjOfsDelta:
+		distance, err := pr.readOffset()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read delta offset at offset %d: %w", entry.Offset, err)
+		}
+		if distance <= 0 || distance > entry.Offset {
+			return nil, fmt.Errorf("invalid delta base offset at offset %d", entry.Offset)
+		}
+		entry.BaseOffset = entry.Offset - distance
+	case ObjRefDelta:
+		if _, err := io.ReadFull(pr.r, entry.BaseID[:]); err != nil {
+			return nil, fmt.Errorf("failed to read delta base at offset %d: %w", entry.Offset, err)
+		}
+	default:
+		return nil, fmt.Errorf("invalid object type %d at offset %d", byte(objType), entry.Offset)
+	}
+
+	entry.Data, err = pr.inflate(size)
+	if err != nil {
+		return nil, fmt.Errorf("failed to inflate entry at offset %d: %w", entry.Offset, err)
+	}
+
+	return entry, nil
+}
+
+// readEntryHeader decodes the type and inflated size of an entry
+func (pr *Reader) readEntryHeader() (ObjectType, int64, error) {
+	b, err := pr.r.ReadByte()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	objType := ObjectType((b >> 4) & 0x07)
+	size := int64(b & 0x0f)
+	shift := uint(4)
+
+	for b&0x80 != 0 {
+		if b, err = pr.r.ReadByte(); err != nil {
+			return 0, 0, err
+		}
+		if shift > 56 {
+			return 0, 0, fmt.Errorf("object size overflow")
+		}
+		size |= int64(b&0x7f) << shift
+		shift += 7
+	}
+
+	return objType, size, nil
+}
+
+// readOffset decodes the base distance of an OFS_DELTA entry
+func (pr *Reader) readOffset() (int64, error) {
+	b, err := pr.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+
+	offset := int64(b & 0x7f)
+	for b&0x80 != 0 {
+		if b, err = pr.r.ReadByte(); err != nil {
+			return 0, err
+		}
+		if offset > (1<<55)-1 {
+			return 0, fmt.Errorf("delta offset overflow")
+		}
+		offset = ((offset + 1) << 7) | int64(b&0x7f)
+	}
+
+	return offset, nil
+}
+
+// inflate decompresses exactly one zlib stream of the given size
+func (pr *Reader) inflate(size int64) ([]byte, error) {
+	zr, err := zlib.NewReader(pr.r)
+	if err != nil {
+		return nil, err
+	}
+	defer zr.Close()
+
+	var buf bytes.Buffer
+	buf.Grow(int(size))
+	n, err := io.Copy(&buf, zr)
+	if err != nil {
+		return nil, err
+	}
+	if n != size {
+		return nil, fmt.Errorf("size mismatch: expected %d, got %d", size, n)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// verifyTrailer checks the SHA-1 trailer against the bytes read so far
+func (pr *Reader) verifyTrailer() error {
+	expected := pr.r.h.Sum(nil)
+
+	trailer := make([]byte, sha1.Size)
+	if _, err := io.ReadFull(pr.r.r, trailer); err != nil {
+		return fmt.Errorf("failed to read pack trailer: %w", err)
+	}
+
+	if !bytes.Equal(expected, trailer) {
+		return fmt.Errorf("pack checksum mismatch")
+	}
+
+	pr.checksum = trailer
+	return nil
+}
@@ -0,0 +1,166 @@
+package packfile
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/progress"
+)
+
+// UnpackResult summarizes an unpacked packfile
+type UnpackResult struct {
+	Objects  []objects.ObjectID
+	Deltas   int
+	Checksum []byte
+}
+
+// resolvedObject is a fully reconstructed pack entry
+type resolvedObject struct {
+	objType objects.ObjectType
+	data    []byte
+}
+
+// Unpack reads a packfile from r, resolves all deltas and writes every object
+// to store as a loose object. REF_DELTA bases missing from the pack are read
+// from store, which allows thin packs.
+func Unpack(r io.Reader, store ObjectStore) (*UnpackResult, error) {
+	return UnpackProgress(r, store, nil)
+}
+
+// UnpackProgress is Unpack drawing its progress on out, when it is not
+// nil: the objects received with the bytes read and the throughput, then
+// the deltas resolved
+func UnpackProgress(r io.Reader, store ObjectStore, out io.Writer) (*UnpackResult, error) {
+	counted := &progress.Reader{R: r}
+	pr, err := NewReader(counted)
+	if err != nil {
+		return nil, err
+	}
+	receiving := progress.New(out, "Receiving objects", int(pr.Count()))
+	counted.Meter = receiving
+
+	result := &UnpackResult{}
+	byOffset := make(map[int64]*resolvedObject)
+	byID := make(map[objects.ObjectID]*resolvedObject)
+	var pending []*Entry
+
+	write := func(obj *resolvedObject) error {
+		id, err := store.WriteRawObject(obj.objType, obj.data)
+		if err != nil {
+			return fmt.Errorf("failed to write object: %w", err)
+		}
+		byID[id] = obj
+		result.Objects = append(result.Objects, id)
+		return nil
+	}
+
+	for {
+		entry, err := pr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		var obj *resolvedObject
+		switch entry.Type {
+		case ObjOfsDelta:
+			base, ok := byOffset[entry.BaseOffset]
+			if !ok {
+				return nil, fmt.Errorf("delta base at offset %d not found", entry.BaseOffset)
+			}
+			if obj, err = resolveDelta(base, entry); err != nil {
+				return nil, err
+			}
+			result.Deltas++
+		case ObjRefDelta:
+			base, err := lookupBase(entry.BaseID, byID, store)
+			if err != nil {
+				// The base may appear later in the pack
+				pending = append(pending, entry)
+				continue
+			}
+			if obj, err = resolveDelta(base, entry); err != nil {
+				return nil, err
+			}
+			result.Deltas++
+		default:
+			objType, err := entry.Type.ToObjectType()
+			if err != nil {
+				return nil, err
+			}
+			obj = &resolvedObject{objType: objType, data: entry.Data}
+		}
+
+		byOffset[entry.Offset] = obj
+		if err := write(obj); err != nil {
+			return nil, err
+		}
+		receiving.Add(1)
+	}
+	receiving.Add(len(pending))
+	receiving.Done()
+
+	// Deltas are resolved as they arrive but for those whose base came
+	// later, which are counted on from there
+	var resolving *progress.Meter
+	if deltas := result.Deltas + len(pending); deltas > 0 {
+		resolving = progress.New(out, "Resolving deltas", deltas)
+		resolving.Set(result.Deltas)
+	}
+
+	// Resolve REF_DELTA entries whose base came after them, repeating until
+	// no further progress can be made
+	for len(pending) > 0 {
+		var remaining []*Entry
+		for _, entry := range pending {
+			base, err := lookupBase(entry.BaseID, byID, store)
+			if err != nil {
+				remaining = append(remaining, entry)
+				continue
+			}
+			obj, err := resolveDelta(base, entry)
+			if err != nil {
+				return nil, err
+			}
+			result.Deltas++
+			byOffset[entry.Offset] = obj
+			if err := write(obj); err != nil {
+				return nil, err
+			}
+			resolving.Add(1)
+		}
+		if len(remaining) == len(pending) {
+			return nil, fmt.Errorf("delta base %s not found", remaining[0].BaseID)
+		}
+		pending = remaining
+	}
+	resolving.Done()
+
+	result.Checksum = pr.Checksum()
+	return result, nil
+}
+
+// lookupBase finds a REF_DELTA base in the pack or in the object store
+func lookupBase(id objects.ObjectID, byID map[objects.ObjectID]*resolvedObject, store ObjectStore) (*resolvedObject, error) {
+	if obj, ok := byID[id]; ok {
+		return obj, nil
+	}
+
+	objType, data, err := store.ReadRawObject(id)
+	if err != nil {
+		return nil, err
+	}
+	return &resolvedObject{objType: objType, data: data}, nil
+}
+
+// resolveDelta applies a delta entry to its base
+func resolveDelta(base *resolvedObject, entry *Entry) (*resolvedObject, error) {
+	data, err := ApplyDelta(base.data, entry.Data)
+	if err != nil {
+		return nil, fmt.Errorf("failed to apply delta at offset %d: %w", entry.Offset, err)
+	}
+	return &resolvedObject{objType: base.objType, data: data}, nil
+}
@@ -0,0 +1,129 @@
+package packfile
+
+import (
+	"container/list"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// windowCache keeps recently read regions of pack files in memory, like the
+// windows Git maps under core.packedGitWindowSize and core.packedGitLimit.
+// The least recently used windows are dropped once the limit is exceeded.
+type windowCache struct {
+	size  int64
+	limit int64
+
+	mu      sync.Mutex
+	used    int64
+	lru     *list.List
+	windows map[windowKey]*list.Element
+}
+
+// windowKey names the index-th window of a pack
+type windowKey struct {
+	pack  *Pack
+	index int64
+}
+
+type window struct {
+	key  windowKey
+	data []byte
+}
+
+// newWindowCache returns a cache of size-byte windows holding at most limit
+// bytes, but always at least one window
+func newWindowCache(size, limit int64) *windowCache {
+	if limit < size {
+		limit = size
+	}
+	return &windowCache{
+		size:    size,
+		limit:   limit,
+		lru:     list.New(),
+		windows: make(map[windowKey]*list.Element),
+	}
+}
+
+// get returns the data of the index-th window of pack, reading it if needed
+func (c *windowCache) get(pack *Pack, index int64) ([]byte, error) {
+	key := windowKey{pack: pack, index: index}
+
+	c.mu.Lock()
+	if elem, ok := c.windows[key]; ok {
+		c.lru.MoveToFront(elem)
+		c.mu.Unlock()
+		return elem.Value.(*window).data, nil
+	}
+	c.mu.Unlock()
+
+	start := index * c.size
+	length := c.size
+	if start+length > pack.size {
+		length = pack.size - start
+	}
+	if length <= 0 {
+		return nil, io.EOF
+	}
+	data := make([]byte, length)
+	if _, err := pack.file.ReadAt(data, start); err != nil {
+		return nil, fmt.Errorf("failed to read pack window: %w", err)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if elem, ok := c.windows[key]; ok {
+		// Another reader loaded it meanwhile
+		c.lru.MoveToFront(elem)
+		return elem.Value.(*window).data, nil
+	}
+	c.windows[key] = c.lru.PushFront(&window{key: key, data: data})
+	c.used += length
+	for c.used > c.limit && c.lru.Len() > 1 {
+		c.remove(c.lru.Back())
+	}
+	return data, nil
+}
+
+// drop forgets every window of pack, such as when it is closed
+func (c *windowCache) drop(pack *Pack) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for key, elem := range c.windows {
+		if key.pack == pack {
+			c.remove(elem)
+		}
+	}
+}
+
+// remove evicts a window. Callers hold c.mu.
+func (c *windowCache) remove(elem *list.Element) {
+	w := c.lru.Remove(elem).(*window)
+	delete(c.windows, w.key)
+	c.used -= int64(len(w.data))
+}
+
+// windowReader reads part of a pack through the window cache
+type windowReader struct {
+	cache *windowCache
+	pack  *Pack
+	off   int64
+	end   int64
+}
+
+func (r *windowReader) Read(b []byte) (int, error) {
+	if r.off >= r.end {
+		return 0, io.EOF
+	}
+	data, err := r.cache.get(r.pack, r.off/r.cache.size)
+	if err != nil {
+		return 0, err
+	}
+	data = data[r.off%r.cache.size:]
+	if remaining := r.end - r.off; int64(len(data)) > remaining {
+		data = data[:remaining]
+	}
+	n := copy(b, data)
+	r.off += int64(n)
+	return n, nil
+}
@@ -0,0 +1,380 @@
+package packfile
+
+import (
+	"bytes"
+	"compress/zlib"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"path"
+	"sort"
+	"sync"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/progress"
+)
+
+// Object is an object to be written into a pack
+type Object struct {
+	ID   objects.ObjectID
+	Type objects.ObjectType
+	Data []byte
+	// Path is an optional hint (such as the file name of a blob) used to
+	// place similar objects next to each other when searching for deltas
+	Path string
+}
+
+// WriterOptions controls how a pack is built
+type WriterOptions struct {
+	// Window is how many preceding objects are tried as delta bases.
+	// Zero disables delta compression.
+	Window int
+	// MaxDepth limits the length of delta chains
+	MaxDepth int
+	// WindowMemory caps the bytes of base objects held in the window while
+	// searching for a delta, shrinking the window for large objects. Zero
+	// means no limit.
+	WindowMemory int64
+	// Threads is how many goroutines search for deltas, each over its own
+	// slice of the objects. Zero or one searches serially.
+	Threads int
+	// OffsetDeltas uses OFS_DELTA for bases inside the pack. Otherwise
+	// REF_DELTA is used, for receivers without the ofs-delta capability.
+	OffsetDeltas bool
+	// ThinBases are objects the receiver already has. They may be used as
+	// REF_DELTA bases without being written, producing a thin pack.
+	ThinBases []*Object
+	// Progress is where the objects compressed and written are counted,
+	// nil for nowhere
+	Progress io.Writer
+}
+
+// DefaultWriterOptions returns the options used by Git's pack-objects
+func DefaultWriterOptions() WriterOptions {
+	return WriterOptions{
+		Window:       10,
+		MaxDepth:     50,
+		OffsetDeltas: true,
+	}
+}
+
+// IndexEntry locates an object inside a written pack
+type IndexEntry struct {
+	ID     objects.ObjectID
+	Offset int64
+	CRC32  uint32
+}
+
+// WriteResult describes a written pack
+type WriteResult struct {
+	Entries  []IndexEntry
+	Deltas   int
+	Checksum []byte
+}
+
+// countingWriter tracks the offset and checksum of everything written
+type countingWriter struct {
+	w io.Writer
+	h hash.Hash
+	n int64
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.h.Write(p[:n])
+	c.n += int64(n)
+	return n, err
+}
+
+// packEntry is an object together with its chosen delta base
+type packEntry struct {
+	obj   *Object
+	base  *packEntry
+	thin  *Object
+	delta []byte
+	depth int
+	index *deltaIndex
+}
+
+// WritePack writes objs as a version 2 packfile
+func WritePack(w io.Writer, objs []*Object, opts WriterOptions) (*WriteResult, error) {
+	entries := make([]*packEntry, len(objs))
+	for i, obj := range objs {
+		if !obj.Type.IsValid() {
+			return nil, fmt.Errorf("invalid object type %s for %s", obj.Type, obj.ID)
+		}
+		entries[i] = &packEntry{obj: obj}
+	}
+
+	// Group objects so that likely delta pairs sit within the window, and
+	// put larger objects first so they become bases for smaller ones
+	sort.SliceStable(entries, func(i, j int) bool {
+		a, b := entries[i].obj, entries[j].obj
+		if a.Type != b.Type {
+			return a.Type < b.Type
+		}
+		if na, nb := path.Base(a.Path), path.Base(b.Path); na != nb {
+			return na < nb
+		}
+		return len(a.Data) > len(b.Data)
+	})
+
+	if opts.Window > 0 {
+		compressing := progress.New(opts.Progress, "Compressing objects", len(entries))
+		findDeltas(entries, opts, compressing)
+		compressing.Done()
+	}
+
+	writing := progress.New(opts.Progress, "Writing objects", len(entries))
+	cw := &countingWriter{w: &progress.Writer{W: w, Meter: writing}, h: sha1.New()}
+
+	header := make([]byte, 12)
+	copy(header, Signature)
+	binary.BigEndian.PutUint32(header[4:8], Version)
+	binary.BigEndian.PutUint32(header[8:12], uint32(len(entries)))
+	if _, err := cw.Write(header); err != nil {
+		return nil, fmt.Errorf("failed to write pack header: %w", err)
+	}
+
+	result := &WriteResult{}
+	offsets := make(map[*packEntry]int64, len(entries))
+
+	for _, e := range entries {
+		offset := cw.n
+		raw, err := encodeEntry(e, offset, offsets, opts.OffsetDeltas)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := cw.Write(raw); err != nil {
+			return nil, fmt.Errorf("failed to write object %s: %w", e.obj.ID, err)
+		}
+
+		offsets[e] = offset
+		if e.delta != nil {
+			result.Deltas++
+		}
+		writing.Add(1)
+		result.Entries = append(result.Entries, IndexEntry{
+			ID:     e.obj.ID,
+			Offset: offset,
+			CRC32:  crc32.ChecksumIEEE(raw),
+		})
+	}
+
+	result.Checksum = cw.h.Sum(nil)
+	if _, err := w.Write(result.Checksum); err != nil {
+		return nil, fmt.Errorf("failed to write pack trailer: %w", err)
+	}
+	writing.Done()
+
+	return result, nil
+}
+
+// findDeltas picks a delta base for each entry from the preceding window and
+// from the thin-pack bases. With several threads the entries are split into
+// contiguous runs searched independently, so bases never cross a run.
+// Each entry searched is counted on meter.
+func findDeltas(entries []*packEntry, opts WriterOptions, meter *progress.Meter) {
+	threads := opts.Threads
+	// A run shorter than a couple of windows would lose most of its bases
+	if most := len(entries) / (2 * (opts.Window + 1)); threads > most {
+		threads = most
+	}
+	if threads <= 1 {
+		findDeltasIn(entries, opts, meter)
+		return
+	}
+
+	var wg sync.WaitGroup
+	size := (len(entries) + threads - 1) / threads
+	for start := 0; start < len(entries); start += size {
+		end := start + size
+		if end > len(entries) {
+			end = len(entries)
+		}
+		wg.Add(1)
+		go func(run []*packEntry) {
+			defer wg.Done()
+			findDeltasIn(run, opts, meter)
+		}(entries[start:end])
+	}
+	wg.Wait()
+}
+
+// findDeltasIn searches for delta bases within a single run of entries
+func findDeltasIn(entries []*packEntry, opts WriterOptions, meter *progress.Meter) {
+	thinByType := make(map[objects.ObjectType][]*Object)
+	for _, base := range opts.ThinBases {
+		thinByType[base.Type] = append(thinByType[base.Type], base)
+	}
+	thinIndexes := make(map[*Object]*deltaIndex)
+
+	for i, e := range entries {
+		meter.Add(1)
+		// The entry that just left the window is never a base again
+		if old := i - opts.Window - 1; old >= 0 {
+			entries[old].index = nil
+		}
+
+		// Only deltas well under the full size are worth the indirection
+		best := len(e.obj.Data)/2 - 20
+		if best <= 0 {
+			continue
+		}
+
+		held := int64(len(e.obj.Data))
+		for j := i - 1; j >= 0 && j >= i-opts.Window; j-- {
+			base := entries[j]
+			// The nearest base is always tried, further ones only while
+			// the window fits in pack.windowMemory
+			held += int64(len(base.obj.Data))
+			if opts.WindowMemory > 0 && j < i-1 && held > opts.WindowMemory {
+				break
+			}
+			if base.obj.Type != e.obj.Type || base.depth >= opts.MaxDepth {
+				continue
+			}
+			if base.index == nil {
+				base.index = newDeltaIndex(base.obj.Data)
+			}
+			if delta := base.index.encode(e.obj.Data); len(delta) < best {
+				best = len(delta)
+				e.base, e.thin, e.delta, e.depth = base, nil, delta, base.depth+1
+			}
+		}
+
+		for _, base := range closestBySize(thinByType[e.obj.Type], len(e.obj.Data), opts.Window) {
+			index, ok := thinIndexes[base]
+			if !ok {
+				index = newDeltaIndex(base.Data)
+				thinIndexes[base] = index
+			}
+			if delta := index.encode(e.obj.Data); len(delta) < best {
+				best = len(delta)
+				e.base, e.thin, e.delta, e.depth = nil, base, delta, 1
+			}
+		}
+	}
+
+	// Delta indexes are only needed during the search
+	for _, e := range entries {
+		e.index = nil
+	}
+}
+
+// closestBySize returns up to n candidates whose size is nearest to size
+func closestBySize(candidates []*Object, size, n int) []*Object {
+	if len(candidates) <= n {
+		return candidates
+	}
+	sorted := append([]*Object(nil), candidates...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return absInt(len(sorted[i].Data)-size) < absInt(len(sorted[j].Data)-size)
+	})
+	return sorted[:n]
+}
+
+func absInt(n int) int {
+	if n < 0 {
+		return -n
+	}
+	return n
+}
+
+// encodeEntry serializes a single pack entry written at offset
+func encodeEntry(e *packEntry, offset int64, offsets map[*packEntry]int64, offsetDeltas bool) ([]byte, error) {
+	var buf bytes.Buffer
+	data := e.obj.Data
+
+	switch {
+	case e.thin != nil:
+		writeEntryHeader(&buf, ObjRefDelta, len(e.delta))
+		buf.Write(e.thin.ID[:])
+		data = e.delta
+	case e.base != nil && offsetDeltas:
+		baseOffset, ok := offsets[e.base]
+		if !ok {
+			return nil, fmt.Errorf("delta base for %s not written yet", e.obj.ID)
+		}
+		writeEntryHeader(&buf, ObjOfsDelta, len(e.delta))
+		buf.Write(encodeOffset(offset - baseOffset))
+		data = e.delta
+	case e.base != nil:
+		writeEntryHeader(&buf, ObjRefDelta, len(e.delta))
+		buf.Write(e.base.obj.ID[:])
+		data = e.delta
+	default:
+		packType, err := FromObjectType(e.obj.Type)
+		if err != nil {
+			return nil, err
+		}
+		writeEntryHeader(&buf, packType, len(data))
+	}
+
+	zw := zlib.NewWriter(&buf)
+	if _, err := zw.Write(data); err != nil {
+		return nil, fmt.Errorf("failed to compress object %s: %w", e.obj.ID, err)
+	}
+	if err := zw.Close(); err != nil {
+		return nil, fmt.Errorf("failed to compress object %s: %w", e.obj.ID, err)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// writeEntryHeader writes the type and size header of an entry
+func writeEntryHeader(buf *bytes.Buffer, t ObjectType, size int) {
+	b := byte(t)<<4 | byte(size&0x0f)
+	size >>= 4
+	for size > 0 {
+		buf.WriteByte(b | 0x80)
+		b = byte(size & 0x7f)
+		size >>= 7
+	}
+	buf.WriteByte(b)
+}
+
+// encodeOffset encodes the distance to an OFS_DELTA base
+func encodeOffset(distance int64) []byte {
+	encoded := []byte{byte(distance & 0x7f)}
+	for distance >>= 7; distance > 0; distance >>= 7 {
+		distance--
+		encoded = append([]byte{byte(0x80 | distance&0x7f)}, encoded...)
+	}
+	return encoded
+}
+
+// ReadObjects loads the given objects from store for packing. Objects named
+// by a tree among them get the entry name as their Path hint.
+func ReadObjects(store ObjectStore, ids []objects.ObjectID) ([]*Object, error) {
+	objs := make([]*Object, 0, len(ids))
+	byID := make(map[objects.ObjectID]*Object, len(ids))
+	for _, id := range ids {
+		objType, data, err := store.ReadRawObject(id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read object %s: %w", id, err)
+		}
+		obj := &Object{ID: id, Type: objType, Data: data}
+		objs = append(objs, obj)
+		byID[id] = obj
+	}
+
+	for _, obj := range objs {
+		if obj.Type != objects.TypeTree {
+			continue
+		}
+		tree, err := objects.ParseTree(obj.ID, obj.Data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse tree %s: %w", obj.ID, err)
+		}
+		for _, entry := range tree.Entries() {
+			if named, ok := byID[entry.ID]; ok && named.Path == "" {
+				named.Path = entry.Name
+			}
+		}
+	}
+	return objs, nil
+}
@@ -0,0 +1,291 @@
+package packfile
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+func newBlob(content string) *Object {
+	data := []byte(content)
+	return &Object{
+		ID:   objects.ComputeHash(objects.TypeBlob, data),
+		Type: objects.TypeBlob,
+		Data: data,
+	}
+}
+
+// versionedFile returns file content that differs only in its version line
+func versionedFile(version int) string {
+	var b strings.Builder
+	for i := 0; i < 200; i++ {
+		fmt.Fprintf(&b, "line %d of a moderately long file\n", i)
+		if i == 100 {
+			fmt.Fprintf(&b, "version %d\n", version)
+		}
+	}
+	return b.String()
+}
+
+func TestCreateDelta(t *testing.T) {
+	base := []byte(versionedFile(1))
+	tests := []struct {
+		name   string
+		target []byte
+	}{
+		{"identical", base},
+		{"edited", []byte(versionedFile(2))},
+		{"prefix", []byte("new header\n" + versionedFile(1))},
+		{"unrelated", []byte("nothing in common")},
+		{"empty", []byte{}},
+		{"large copy", bytes.Repeat(base, 20)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			delta := CreateDelta(base, tt.target)
+			got, err := ApplyDelta(base, delta)
+			if err != nil {
+				t.Fatalf("ApplyDelta() error = %v", err)
+			}
+			if !bytes.Equal(got, tt.target) {
+				t.Errorf("ApplyDelta() did not reproduce the target")
+			}
+		})
+	}
+
+	if delta := CreateDelta(base, []byte(versionedFile(2))); len(delta) > 64 {
+		t.Errorf("CreateDelta() for a one-line change = %d bytes, want <= 64", len(delta))
+	}
+}
+
+func TestWritePack(t *testing.T) {
+	var objs []*Object
+	for v := 1; v <= 5; v++ {
+		obj := newBlob(versionedFile(v))
+		obj.Path = "src/file.txt"
+		objs = append(objs, obj)
+	}
+	objs = append(objs, newBlob("small"))
+
+	for _, offsetDeltas := range []bool{true, false} {
+		t.Run(fmt.Sprintf("offset deltas %v", offsetDeltas), func(t *testing.T) {
+			opts := DefaultWriterOptions()
+			opts.OffsetDeltas = offsetDeltas
+
+			var buf bytes.Buffer
+			result, err := WritePack(&buf, objs, opts)
+			if err != nil {
+				t.Fatalf("WritePack() error = %v", err)
+			}
+			if result.Deltas != 4 {
+				t.Errorf("WritePack() deltas = %d, want 4", result.Deltas)
+			}
+			if len(result.Entries) != len(objs) {
+				t.Errorf("WritePack() entries = %d, want %d", len(result.Entries), len(objs))
+			}
+
+			store := newStore(t)
+			unpacked, err := Unpack(bytes.NewReader(buf.Bytes()), store)
+			if err != nil {
+				t.Fatalf("Unpack() error = %v", err)
+			}
+			if !bytes.Equal(unpacked.Checksum, result.Checksum) {
+				t.Errorf("Unpack() checksum = %x, want %x", unpacked.Checksum, result.Checksum)
+			}
+			for _, obj := range objs {
+				if got := readBlob(t, store, obj.ID); got != string(obj.Data) {
+					t.Errorf("object %s content mismatch", obj.ID)
+				}
+			}
+		})
+	}
+}
+
+func TestWritePackWithoutDeltas(t *testing.T) {
+	objs := []*Object{newBlob(versionedFile(1)), newBlob(versionedFile(2))}
+
+	var buf bytes.Buffer
+	result, err := WritePack(&buf, objs, WriterOptions{})
+	if err != nil {
+		t.Fatalf("WritePack() error = %v", err)
+	}
+	if result.Deltas != 0 {
+		t.Errorf("WritePack() deltas = %d, want 0", result.Deltas)
+	}
+
+	r, err := NewReader(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("NewReader() error = %v", err)
+	}
+	if r.Count() != 2 {
+		t.Errorf("Count() = %d, want 2", r.Count())
+	}
+}
+
+func TestWritePackWindowMemory(t *testing.T) {
+	first := newBlob(versionedFile(1))
+	first.Path = "a.txt"
+	unrelated := newBlob(strings.Repeat("nothing alike here\n", 400))
+	unrelated.Path = "b.txt"
+	second := newBlob(versionedFile(2))
+	second.Path = "c.txt"
+	objs := []*Object{first, unrelated, second}
+
+	var buf bytes.Buffer
+	result, err := WritePack(&buf, objs, DefaultWriterOptions())
+	if err != nil {
+		t.Fatalf("WritePack() error = %v", err)
+	}
+	if result.Deltas != 1 {
+		t.Fatalf("WritePack() deltas = %d, want 1", result.Deltas)
+	}
+
+	// Only the nearest base fits, so the similar file is out of reach
+	opts := DefaultWriterOptions()
+	opts.WindowMemory = int64(len(second.Data) + len(unrelated.Data))
+	buf.Reset()
+	result, err = WritePack(&buf, objs, opts)
+	if err != nil {
+		t.Fatalf("WritePack() error = %v", err)
+	}
+	if result.Deltas != 0 {
+		t.Errorf("WritePack() with window memory deltas = %d, want 0", result.Deltas)
+	}
+}
+
+func TestWritePackThreads(t *testing.T) {
+	var objs []*Object
+	for v := 1; v <= 100; v++ {
+		obj := newBlob(versionedFile(v))
+		obj.Path = "file.txt"
+		objs = append(objs, obj)
+	}
+
+	opts := DefaultWriterOptions()
+	opts.Threads = 4
+	var buf bytes.Buffer
+	result, err := WritePack(&buf, objs, opts)
+	if err != nil {
+		t.Fatalf("WritePack() error = %v", err)
+	}
+	// The first object of each run has no base
+	if result.Deltas < len(objs)-opts.Threads {
+		t.Errorf("WritePack() deltas = %d, want at least %d", result.Deltas, len(objs)-opts.Threads)
+	}
+
+	store := newStore(t)
+	if _, err := Unpack(bytes.NewReader(buf.Bytes()), store); err != nil {
+		t.Fatalf("Unpack() error = %v", err)
+	}
+	for _, obj := range objs {
+		if got := readBlob(t, store, obj.ID); got != string(obj.Data) {
+			t.Errorf("object %s content mismatch", obj.ID)
+		}
+	}
+}
+
+func TestWritePackThin(t *testing.T) {
+	base := newBlob(versionedFile(1))
+	target := newBlob(versionedFile(2))
+
+	opts := DefaultWriterOptions()
+	opts.ThinBases = []*Object{base}
+
+	var buf bytes.Buffer
+	result, err := WritePack(&buf, []*Object{target}, opts)
+	if err != nil {
+		t.Fatalf("WritePack() error = %v", err)
+	}
+	if result.Deltas != 1 {
+		t.Fatalf("WritePack() deltas = %d, want 1", result.Deltas)
+	}
+
+	// The receiver already has the base object
+	store := newStore(t)
+	if _, err := store.WriteRawObject(base.Type, base.Data); err != nil {
+		t.Fatalf("WriteRawObject() error = %v", err)
+	}
+	if _, err := Unpack(bytes.NewReader(buf.Bytes()), store); err != nil {
+		t.Fatalf("Unpack() error = %v", err)
+	}
+	if got := readBlob(t, store, target.ID); got != string(target.Data) {
+		t.Errorf("thin delta target content mismatch")
+	}
+}
+
+func TestWritePackInvalidType(t *testing.T) {
+	obj := &Object{Type: objects.ObjectType("bogus"), Data: []byte("x")}
+	if _, err := WritePack(&bytes.Buffer{}, []*Object{obj}, DefaultWriterOptions()); err == nil {
+		t.Error("WritePack() expected error for invalid type")
+	}
+}
+
+func TestWriteIndex(t *testing.T) {
+	var objs []*Object
+	for i := 0; i < 20; i++ {
+		objs = append(objs, newBlob(fmt.Sprintf("blob %d", i)))
+	}
+
+	var pack bytes.Buffer
+	result, err := WritePack(&pack, objs, DefaultWriterOptions())
+	if err != nil {
+		t.Fatalf("WritePack() error = %v", err)
+	}
+
+	var idx bytes.Buffer
+	if err := WriteIndex(&idx, result.Entries, result.Checksum); err != nil {
+		t.Fatalf("WriteIndex() error = %v", err)
+	}
+	data := idx.Bytes()
+
+	wantSize := 8 + 256*4 + len(objs)*(20+4+4) + 2*sha1.Size
+	if len(data) != wantSize {
+		t.Fatalf("WriteIndex() size = %d, want %d", len(data), wantSize)
+	}
+	if !bytes.Equal(data[:4], indexSignature) || binary.BigEndian.Uint32(data[4:8]) != 2 {
+		t.Errorf("WriteIndex() header = %x, want v2 signature", data[:8])
+	}
+	if total := binary.BigEndian.Uint32(data[8+255*4:]); total != uint32(len(objs)) {
+		t.Errorf("fanout[255] = %d, want %d", total, len(objs))
+	}
+
+	names := data[8+256*4:]
+	for i := 1; i < len(objs); i++ {
+		if bytes.Compare(names[(i-1)*20:i*20], names[i*20:(i+1)*20]) >= 0 {
+			t.Fatalf("object names are not sorted at %d", i)
+		}
+	}
+
+	trailer := data[len(data)-2*sha1.Size:]
+	if !bytes.Equal(trailer[:sha1.Size], result.Checksum) {
+		t.Errorf("index pack checksum = %x, want %x", trailer[:sha1.Size], result.Checksum)
+	}
+	if sum := sha1.Sum(data[:len(data)-sha1.Size]); !bytes.Equal(trailer[sha1.Size:], sum[:]) {
+		t.Errorf("index checksum mismatch")
+	}
+
+	if err := WriteIndex(&bytes.Buffer{}, result.Entries, []byte("short")); err == nil {
+		t.Error("WriteIndex() expected error for invalid pack checksum")
+	}
+}
+
+func TestEncodeOffset(t *testing.T) {
+	for _, distance := range []int64{1, 127, 128, 16511, 16512, 1 << 30} {
+		encoded := encodeOffset(distance)
+
+		// Decode using the same scheme as Reader.readOffset
+		got := int64(encoded[0] & 0x7f)
+		for _, b := range encoded[1:] {
+			got = ((got + 1) << 7) | int64(b&0x7f)
+		}
+		if got != distance {
+			t.Errorf("encodeOffset(%d) decodes to %d", distance, got)
+		}
+	}
+}
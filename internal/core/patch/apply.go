@@ -0,0 +1,225 @@
+package patch
+
+import (
+	"fmt"
+	"strings"
+)
+
+// HunkError reports a hunk whose old lines are found nowhere in the
+// content it is applied to
+type HunkError struct {
+	Path string
+	// Line is where the hunk header puts the old lines
+	Line int
+}
+
+func (e *HunkError) Error() string {
+	return fmt.Sprintf("patch failed: %s:%d", e.Path, e.Line)
+}
+
+// Apply returns content with the hunks of f applied. A hunk whose old
+// lines are not where its header says is looked for above and below, as
+// for content that moved since the patch was made. Hunks without leading
+// context at the start of the file, or without trailing context, must
+// match at the start or the end of the content. A binary patch must carry
+// its data and full blob IDs, and is only applied to the content it was
+// made against.
+func (f *File) Apply(content []byte) ([]byte, error) {
+	if f.Binary {
+		return f.applyBinary(content)
+	}
+	lines := splitLines(content)
+	var out []string
+	pos, offset := 0, 0
+	for _, h := range f.Hunks {
+		var old, new []string
+		for _, line := range h.Lines {
+			if line.Op != '+' {
+				old = append(old, line.Text)
+			}
+			if line.Op != '-' {
+				new = append(new, line.Text)
+			}
+		}
+
+		// A hunk that only adds lines goes after line OldStart
+		want := h.OldStart - 1
+		if h.OldLines == 0 {
+			want = h.OldStart
+		}
+		trailing := 0
+		for i := len(h.Lines) - 1; i >= 0 && h.Lines[i].Op == ' '; i-- {
+			trailing++
+		}
+		at := findLines(lines, old, pos, want+offset, h.OldStart <= 1, trailing == 0)
+		if at < 0 {
+			return nil, &HunkError{Path: f.Name(), Line: h.OldStart}
+		}
+
+		out = append(out, lines[pos:at]...)
+		out = append(out, new...)
+		pos = at + len(old)
+		offset = at - want
+	}
+	out = append(out, lines[pos:]...)
+	return []byte(strings.Join(out, "")), nil
+}
+
+// findLines returns the position at or after min where lines holds want,
+// the one closest to at, or -1. atStart and atEnd restrict the match to
+// the start or the end of lines.
+func findLines(lines, want []string, min, at int, atStart, atEnd bool) int {
+	max := len(lines) - len(want)
+	matches := func(i int) bool {
+		if i < min || i > max || (atStart && i != 0) || (atEnd && i != max) {
+			return false
+		}
+		for j, line := range want {
+			if lines[i+j] != line {
+				return false
+			}
+		}
+		return true
+	}
+	for d := 0; at-d >= min || at+d <= max; d++ {
+		if matches(at - d) {
+			return at - d
+		}
+		if d > 0 && matches(at+d) {
+			return at + d
+		}
+	}
+	return -1
+}
+
+// Reverse turns f into the patch that undoes it
+func (f *File) Reverse() {
+	f.OldPath, f.NewPath = f.NewPath, f.OldPath
+	f.OldMode, f.NewMode = f.NewMode, f.OldMode
+	f.OldID, f.NewID = f.NewID, f.OldID
+	f.BinaryPatch, f.BinaryReverse = f.BinaryReverse, f.BinaryPatch
+	for _, h := range f.Hunks {
+		h.OldStart, h.NewStart = h.NewStart, h.OldStart
+		h.OldLines, h.NewLines = h.NewLines, h.OldLines
+		for i := range h.Lines {
+			switch h.Lines[i].Op {
+			case '+':
+				h.Lines[i].Op = '-'
+			case '-':
+				h.Lines[i].Op = '+'
+			}
+		}
+	}
+}
+
+// WhitespaceError is a whitespace problem of a line a patch adds
+type WhitespaceError struct {
+	Line    Line
+	Problem string
+}
+
+// tabWidth is how many columns a tab in an indent stands for
+const tabWidth = 8
+
+// WhitespaceErrors returns the whitespace problems of the lines f adds:
+// trailing whitespace, spaces before a tab in the indent and blank lines
+// added at the end of the file
+func (f *File) WhitespaceErrors() []WhitespaceError {
+	var errs []WhitespaceError
+	for _, h := range f.Hunks {
+		for _, line := range h.Lines {
+			if line.Op != '+' {
+				continue
+			}
+			body, _ := cutEOL(line.Text)
+			switch {
+			case strings.TrimSpace(body) == "" && f.blankAtEOF(h, line):
+				errs = append(errs, WhitespaceError{Line: line, Problem: "new blank line at EOF"})
+			case strings.TrimRight(body, " \t") != body:
+				errs = append(errs, WhitespaceError{Line: line, Problem: "trailing whitespace"})
+			case spaceBeforeTab(body):
+				errs = append(errs, WhitespaceError{Line: line, Problem: "space before tab in indent"})
+			}
+		}
+	}
+	return errs
+}
+
+// FixWhitespace corrects the problems WhitespaceErrors reports, dropping
+// blank lines added at the end of the file, and returns how many lines it
+// changed
+func (f *File) FixWhitespace() int {
+	fixed := 0
+	for _, h := range f.Hunks {
+		kept := h.Lines[:0]
+		for _, line := range h.Lines {
+			if line.Op == '+' {
+				body, eol := cutEOL(line.Text)
+				if strings.TrimSpace(body) == "" && f.blankAtEOF(h, line) {
+					h.NewLines--
+					fixed++
+					continue
+				}
+				if text := fixLine(body) + eol; text != line.Text {
+					line.Text = text
+					fixed++
+				}
+			}
+			kept = append(kept, line)
+		}
+		h.Lines = kept
+	}
+	return fixed
+}
+
+// blankAtEOF reports whether line of hunk h is one of the added lines the
+// new file ends with, the hunk reaching the end of the file
+func (f *File) blankAtEOF(h *Hunk, line Line) bool {
+	if h != f.Hunks[len(f.Hunks)-1] {
+		return false
+	}
+	i := len(h.Lines) - 1
+	for ; i >= 0 && h.Lines[i].Op == '+' && strings.TrimSpace(h.Lines[i].Text) == ""; i-- {
+		if h.Lines[i].Num == line.Num {
+			return true
+		}
+	}
+	return false
+}
+
+// spaceBeforeTab reports whether the indent of body has a space before a
+// tab
+func spaceBeforeTab(body string) bool {
+	indent := body[:len(body)-len(strings.TrimLeft(body, " \t"))]
+	return strings.Contains(indent, " \t")
+}
+
+// fixLine removes trailing whitespace and turns the spaces before tabs in
+// the indent into tabs, dropping those a tab absorbs anyway
+func fixLine(body string) string {
+	body = strings.TrimRight(body, " \t")
+	indentLen := len(body) - len(strings.TrimLeft(body, " \t"))
+	last := strings.LastIndexByte(body[:indentLen], '\t')
+	if last < 0 {
+		return body
+	}
+
+	var b strings.Builder
+	spaces := 0
+	for _, c := range body[:last+1] {
+		if c == ' ' {
+			spaces++
+			continue
+		}
+		b.WriteString(strings.Repeat("\t", spaces/tabWidth))
+		b.WriteRune(c)
+		spaces = 0
+	}
+	return b.String() + body[last+1:]
+}
+
+// cutEOL splits a line into its body and its terminator
+func cutEOL(line string) (string, string) {
+	body := strings.TrimRight(line, "\r\n")
+	return body, line[len(body):]
+}
@@ -0,0 +1,255 @@
+package patch
+
+import (
+	"bytes"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/packfile"
+)
+
+// base85Alphabet is the alphabet of Git's base85, which unlike Ascii85
+// has no quotes or backslash
+const base85Alphabet = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz!#$%&()*+-;<=>?@^_`{|}~"
+
+// binaryLineMax is how many bytes of compressed data a line of a binary
+// patch holds
+const binaryLineMax = 52
+
+var base85Values = func() [256]int {
+	var values [256]int
+	for i := range values {
+		values[i] = -1
+	}
+	for i := 0; i < len(base85Alphabet); i++ {
+		values[base85Alphabet[i]] = i
+	}
+	return values
+}()
+
+// BinaryPatch is one block of a GIT binary patch, which gives the new
+// content either whole or as a delta against the old content
+type BinaryPatch struct {
+	Delta bool
+	// Data is the content or the delta, inflated
+	Data []byte
+}
+
+// apply returns the content the block makes of old
+func (b *BinaryPatch) apply(old []byte) ([]byte, error) {
+	if !b.Delta {
+		return b.Data, nil
+	}
+	return packfile.ApplyDelta(old, b.Data)
+}
+
+// applyBinary returns content with the binary patch of f applied. The
+// index line must give the full blob IDs, which content and the result
+// must have.
+func (f *File) applyBinary(content []byte) ([]byte, error) {
+	name := f.Name()
+	oldID, oldErr := objects.NewObjectID(f.OldID)
+	newID, newErr := objects.NewObjectID(f.NewID)
+	if f.BinaryPatch == nil && f.BinaryReverse != nil {
+		return nil, fmt.Errorf("cannot reverse-apply a binary patch without the reverse hunk to '%s'", name)
+	}
+	if f.BinaryPatch == nil || oldErr != nil || newErr != nil {
+		return nil, fmt.Errorf("cannot apply binary patch to '%s' without full index line", name)
+	}
+	if f.OldPath != "" && objects.NewBlob(content).ID() != oldID {
+		return nil, fmt.Errorf("the patch applies to '%s' (%s), which does not match the current contents", name, f.OldID)
+	}
+	result, err := f.BinaryPatch.apply(content)
+	if err != nil {
+		return nil, fmt.Errorf("binary patch does not apply to '%s': %w", name, err)
+	}
+	if id := objects.NewBlob(result).ID(); f.NewPath != "" && id != newID {
+		return nil, fmt.Errorf("binary patch to '%s' creates incorrect result (expecting %s, got %s)", name, f.NewID, id)
+	}
+	return result, nil
+}
+
+// parseBinary reads the blocks after a "GIT binary patch" line: the one
+// making the new content and, when the patch has it, the one making the
+// old content back
+func (p *parser) parseBinary(f *File) error {
+	var err error
+	if f.BinaryPatch, err = p.parseBinaryBlock(); err != nil {
+		return err
+	}
+	if f.BinaryPatch == nil {
+		return fmt.Errorf("line %d: binary patch without data", p.pos+1)
+	}
+	f.BinaryReverse, err = p.parseBinaryBlock()
+	return err
+}
+
+// parseBinaryBlock reads a "literal <size>" or "delta <size>" line, the
+// base85 lines of compressed data after it and the empty line ending
+// them, or returns nil when there is no block at the current position
+func (p *parser) parseBinaryBlock() (*BinaryPatch, error) {
+	if p.pos >= len(p.lines) {
+		return nil, nil
+	}
+	num, line := p.pos+1, trimEOL(p.lines[p.pos])
+	kind, sizeText, _ := strings.Cut(line, " ")
+	if kind != "literal" && kind != "delta" {
+		return nil, nil
+	}
+	size, err := strconv.Atoi(sizeText)
+	if err != nil || size < 0 {
+		return nil, fmt.Errorf("line %d: invalid binary patch size: %s", num, line)
+	}
+	p.pos++
+
+	var compressed []byte
+	for ; p.pos < len(p.lines); p.pos++ {
+		line := trimEOL(p.lines[p.pos])
+		if line == "" {
+			p.pos++
+			break
+		}
+		data, err := decodeBinaryLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", p.pos+1, err)
+		}
+		compressed = append(compressed, data...)
+	}
+
+	r, err := zlib.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, fmt.Errorf("line %d: corrupt binary patch: %w", num, err)
+	}
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("line %d: corrupt binary patch: %w", num, err)
+	}
+	if len(data) != size {
+		return nil, fmt.Errorf("line %d: binary patch inflates to %d bytes, not %d", num, len(data), size)
+	}
+	return &BinaryPatch{Delta: kind == "delta", Data: data}, nil
+}
+
+// decodeBinaryLine decodes a line of a binary patch, a length character
+// followed by the base85 of that many bytes
+func decodeBinaryLine(line string) ([]byte, error) {
+	var n int
+	switch c := line[0]; {
+	case c >= 'A' && c <= 'Z':
+		n = int(c-'A') + 1
+	case c >= 'a' && c <= 'z':
+		n = int(c-'a') + 27
+	default:
+		return nil, fmt.Errorf("invalid binary patch line length %q", c)
+	}
+	encoded := line[1:]
+	if len(encoded) != (n+3)/4*5 {
+		return nil, fmt.Errorf("binary patch line has %d characters for %d bytes", len(encoded), n)
+	}
+	data, err := decodeBase85(encoded)
+	if err != nil {
+		return nil, err
+	}
+	return data[:n], nil
+}
+
+// decodeBase85 decodes s, whose length is a multiple of five, four bytes
+// for every five characters
+func decodeBase85(s string) ([]byte, error) {
+	out := make([]byte, 0, len(s)/5*4)
+	for ; len(s) >= 5; s = s[5:] {
+		var acc uint64
+		for i := 0; i < 5; i++ {
+			v := base85Values[s[i]]
+			if v < 0 {
+				return nil, fmt.Errorf("invalid base85 character %q", s[i])
+			}
+			acc = acc*85 + uint64(v)
+		}
+		if acc > 0xffffffff {
+			return nil, fmt.Errorf("invalid base85 sequence %q", s[:5])
+		}
+		out = append(out, byte(acc>>24), byte(acc>>16), byte(acc>>8), byte(acc))
+	}
+	return out, nil
+}
+
+// encodeBase85 encodes data, padded with zeros to a multiple of four
+// bytes, five characters for every four bytes
+func encodeBase85(data []byte) string {
+	var out strings.Builder
+	for len(data) > 0 {
+		var acc uint32
+		for i := 0; i < 4; i++ {
+			acc <<= 8
+			if i < len(data) {
+				acc |= uint32(data[i])
+			}
+		}
+		var chunk [5]byte
+		for i := 4; i >= 0; i-- {
+			chunk[i] = base85Alphabet[acc%85]
+			acc /= 85
+		}
+		out.Write(chunk[:])
+		if len(data) < 4 {
+			break
+		}
+		data = data[4:]
+	}
+	return out.String()
+}
+
+// WriteBinary writes a GIT binary patch changing old into new, with a
+// block for each direction so that it also applies in reverse. Each block
+// is a delta when that is smaller than the content.
+func WriteBinary(w io.Writer, old, new []byte) error {
+	if _, err := io.WriteString(w, "GIT binary patch\n"); err != nil {
+		return err
+	}
+	if err := writeBinaryBlock(w, old, new); err != nil {
+		return err
+	}
+	return writeBinaryBlock(w, new, old)
+}
+
+// writeBinaryBlock writes the block making target from base
+func writeBinaryBlock(w io.Writer, base, target []byte) error {
+	kind, size, data := "literal", len(target), deflate(target)
+	if len(base) > 0 && len(target) > 0 {
+		delta := packfile.CreateDelta(base, target)
+		if compressed := deflate(delta); len(compressed) < len(data) {
+			kind, size, data = "delta", len(delta), compressed
+		}
+	}
+
+	var out strings.Builder
+	fmt.Fprintf(&out, "%s %d\n", kind, size)
+	for len(data) > 0 {
+		n := min(len(data), binaryLineMax)
+		if n <= 26 {
+			out.WriteByte(byte('A' + n - 1))
+		} else {
+			out.WriteByte(byte('a' + n - 27))
+		}
+		out.WriteString(encodeBase85(data[:n]))
+		out.WriteByte('\n')
+		data = data[n:]
+	}
+	out.WriteByte('\n')
+	_, err := io.WriteString(w, out.String())
+	return err
+}
+
+// deflate compresses data with zlib
+func deflate(data []byte) []byte {
+	var buf bytes.Buffer
+	zw := zlib.NewWriter(&buf)
+	zw.Write(data)
+	zw.Close()
+	return buf.Bytes()
+}
@@ -0,0 +1,194 @@
+package patch
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+// Mail is a patch sent as a mail, as format-patch writes them: the
+// subject and body are the commit message, up to a "---" line, and the
+// diff follows
+type Mail struct {
+	// Author is the sender, or who a "From:" line at the top of the body
+	// names. When is zero if the mail has no date.
+	Author  objects.Signature
+	Subject string
+	// Message is the commit message: the subject, a blank line and the
+	// body
+	Message string
+	// Patch is the rest of the mail, which holds the diff
+	Patch []byte
+}
+
+// subjectPrefix matches what mailers and format-patch put before the
+// subject proper, such as "Re:" and "[PATCH v2 1/3]"
+var subjectPrefix = regexp.MustCompile(`^\s*((?i:re|fwd?):|\[[^\]]*\])\s*`)
+
+// SplitMbox splits an mbox into its mails, each of which starts with a
+// "From " line at the start of the mbox or after a blank line. Data that
+// does not start with one is a single mail.
+func SplitMbox(data []byte) [][]byte {
+	if !bytes.HasPrefix(data, []byte("From ")) {
+		return [][]byte{data}
+	}
+
+	var mails [][]byte
+	start, blank := 0, false
+	for pos := 0; pos < len(data); {
+		end := bytes.IndexByte(data[pos:], '\n') + 1
+		if end == 0 {
+			end = len(data) - pos
+		}
+		line := data[pos : pos+end]
+		if pos > start && blank && bytes.HasPrefix(line, []byte("From ")) {
+			mails = append(mails, data[start:pos])
+			start = pos
+		}
+		blank = len(bytes.TrimRight(line, "\r\n")) == 0
+		pos += end
+	}
+	return append(mails, data[start:])
+}
+
+// ParseMail reads a mail, decoding its headers and a quoted-printable or
+// base64 body
+func ParseMail(data []byte) (*Mail, error) {
+	// The "From " line of an mbox is not a header
+	if bytes.HasPrefix(data, []byte("From ")) {
+		if i := bytes.IndexByte(data, '\n'); i >= 0 {
+			data = data[i+1:]
+		}
+	}
+	msg, err := mail.ReadMessage(bytes.NewReader(data))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mail: %w", err)
+	}
+	if mediaType, _, _ := mime.ParseMediaType(msg.Header.Get("Content-Type")); strings.HasPrefix(mediaType, "multipart/") {
+		return nil, fmt.Errorf("multipart mails are not supported")
+	}
+
+	m := &Mail{}
+	decoder := new(mime.WordDecoder)
+	subject, err := decoder.DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil {
+		subject = msg.Header.Get("Subject")
+	}
+	from := msg.Header.Get("From")
+	date, _ := msg.Header.Date()
+
+	body, err := decodeBody(msg.Header.Get("Content-Transfer-Encoding"), msg.Body)
+	if err != nil {
+		return nil, err
+	}
+	text := strings.ReplaceAll(string(body), "\r\n", "\n")
+
+	// Headers at the top of the body override those of the mail, as when
+	// the sender is not the author
+	for {
+		line, rest, _ := strings.Cut(text, "\n")
+		name, value, ok := strings.Cut(line, ": ")
+		if !ok {
+			break
+		}
+		switch name {
+		case "From":
+			from = value
+		case "Subject":
+			subject = value
+		case "Date":
+			if d, err := mail.ParseDate(value); err == nil {
+				date = d
+			}
+		default:
+			ok = false
+		}
+		if !ok {
+			break
+		}
+		text = rest
+		if strings.HasPrefix(text, "\n") {
+			text = text[1:]
+			break
+		}
+	}
+
+	if from == "" {
+		return nil, fmt.Errorf("mail has no author")
+	}
+	addr, err := mail.ParseAddress(from)
+	if err != nil {
+		return nil, fmt.Errorf("invalid author %q: %w", from, err)
+	}
+	m.Author = objects.Signature{Name: addr.Name, Email: addr.Address, When: date}
+	if m.Author.Name == "" {
+		m.Author.Name, _, _ = strings.Cut(addr.Address, "@")
+	}
+
+	m.Subject = cleanSubject(subject)
+	message, patch := splitMailBody(text)
+	m.Message = m.Subject + "\n"
+	if message = strings.Trim(message, "\n"); message != "" {
+		m.Message += "\n" + message + "\n"
+	}
+	m.Patch = []byte(patch)
+	return m, nil
+}
+
+// Date returns when the mail was written, or now when it does not say
+func (m *Mail) Date() time.Time {
+	if m.Author.When.IsZero() {
+		return time.Now()
+	}
+	return m.Author.When
+}
+
+// decodeBody undoes the transfer encoding of a mail body
+func decodeBody(encoding string, body io.Reader) ([]byte, error) {
+	switch strings.ToLower(strings.TrimSpace(encoding)) {
+	case "quoted-printable":
+		body = quotedprintable.NewReader(body)
+	case "base64":
+		body = base64.NewDecoder(base64.StdEncoding, body)
+	}
+	data, err := io.ReadAll(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read mail body: %w", err)
+	}
+	return data, nil
+}
+
+// cleanSubject drops the prefixes of a subject and folds its whitespace
+func cleanSubject(subject string) string {
+	subject = strings.Join(strings.Fields(subject), " ")
+	for {
+		loc := subjectPrefix.FindStringIndex(subject)
+		if loc == nil {
+			return subject
+		}
+		subject = subject[loc[1]:]
+	}
+}
+
+// splitMailBody splits a mail body into the message and the patch, which
+// starts at a "---" line or at the first diff when there is none before it
+func splitMailBody(body string) (message, patch string) {
+	for pos := 0; pos < len(body); {
+		line, rest, _ := strings.Cut(body[pos:], "\n")
+		if strings.TrimRight(line, " \t") == "---" || strings.HasPrefix(line, "diff --git ") ||
+			strings.HasPrefix(line, "Index: ") || (strings.HasPrefix(line, "--- ") && strings.HasPrefix(rest, "+++ ")) {
+			return body[:pos], body[pos:]
+		}
+		pos += len(line) + 1
+	}
+	return body, ""
+}
@@ -0,0 +1,469 @@
+// Package patch reads and applies patches: unified diffs as diff -u writes
+// them, the extended form git diff writes with modes, renames and blob IDs
+// in its headers, and the mails format-patch wraps them in.
+package patch
+
+import (
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+// devNull names the missing side of a created or deleted file
+const devNull = "/dev/null"
+
+// File is the change a patch makes to one file. OldPath is empty for a file
+// the patch creates and NewPath for one it deletes.
+type File struct {
+	OldPath, NewPath string
+	// OldMode and NewMode are the modes the headers give, zero when they
+	// give none
+	OldMode, NewMode objects.FileMode
+	IsRename, IsCopy bool
+	// OldID and NewID are the abbreviated blob IDs of the index header
+	OldID, NewID string
+	// Binary is set for a change to a binary file, which has no hunks
+	Binary bool
+	// BinaryPatch makes the new content of a binary file when the patch
+	// carries it, and BinaryReverse the old content back
+	BinaryPatch, BinaryReverse *BinaryPatch
+	Hunks                      []*Hunk
+}
+
+// Name returns the path the patch is known by, the old one unless the
+// file is new
+func (f *File) Name() string {
+	if f.OldPath != "" {
+		return f.OldPath
+	}
+	return f.NewPath
+}
+
+// Hunk is a run of changed lines and the context around them. OldStart
+// and NewStart are 1-based line numbers as in the hunk header.
+type Hunk struct {
+	OldStart, OldLines int
+	NewStart, NewLines int
+	Lines              []Line
+}
+
+// Line is one line of a hunk. Op is ' ' for context, '-' for a removed
+// line and '+' for an added one. Text keeps its terminator, which is only
+// missing where the patch says "\ No newline at end of file". Num is the
+// line of the patch it was read from.
+type Line struct {
+	Op   byte
+	Text string
+	Num  int
+}
+
+// parser walks the lines of a patch
+type parser struct {
+	lines []string
+	pos   int
+	strip int
+}
+
+// Parse reads the file changes of a patch, removing strip leading
+// components from the paths of its ---, +++ and diff --git lines as
+// patch -p does. Text around the diffs, such as the message of a mail, is
+// skipped.
+func Parse(data []byte, strip int) ([]*File, error) {
+	p := &parser{lines: splitLines(data), strip: strip}
+	var files []*File
+	for p.pos < len(p.lines) {
+		line := p.lines[p.pos]
+		var f *File
+		var err error
+		switch {
+		case strings.HasPrefix(line, "diff --git "):
+			f, err = p.parseGitFile()
+		case strings.HasPrefix(line, "--- ") && p.startsUnified():
+			f, err = p.parseUnifiedFile()
+		default:
+			p.pos++
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		files = append(files, f)
+	}
+	return files, nil
+}
+
+// startsUnified reports whether the --- line at the current position
+// begins a unified diff, being followed by a +++ line and a hunk
+func (p *parser) startsUnified() bool {
+	return p.pos+2 < len(p.lines) &&
+		strings.HasPrefix(p.lines[p.pos+1], "+++ ") &&
+		strings.HasPrefix(p.lines[p.pos+2], "@@ -")
+}
+
+// parseGitFile reads a diff --git header, its extended header lines and
+// the hunks that follow
+func (p *parser) parseGitFile() (*File, error) {
+	num := p.pos + 1
+	oldName, newName, err := splitGitNames(strings.TrimPrefix(trimEOL(p.lines[p.pos]), "diff --git "))
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %w", num, err)
+	}
+	f := &File{OldPath: p.stripPath(oldName), NewPath: p.stripPath(newName)}
+	p.pos++
+
+	created, deleted := false, false
+	for ; p.pos < len(p.lines); p.pos++ {
+		num, line := p.pos+1, trimEOL(p.lines[p.pos])
+		switch {
+		case strings.HasPrefix(line, "old mode "):
+			f.OldMode, err = parseMode(strings.TrimPrefix(line, "old mode "))
+		case strings.HasPrefix(line, "new mode "):
+			f.NewMode, err = parseMode(strings.TrimPrefix(line, "new mode "))
+		case strings.HasPrefix(line, "deleted file mode "):
+			f.OldMode, err = parseMode(strings.TrimPrefix(line, "deleted file mode "))
+			deleted = true
+		case strings.HasPrefix(line, "new file mode "):
+			f.NewMode, err = parseMode(strings.TrimPrefix(line, "new file mode "))
+			created = true
+		case strings.HasPrefix(line, "rename from "), strings.HasPrefix(line, "copy from "):
+			f.IsRename = strings.HasPrefix(line, "rename")
+			f.IsCopy = !f.IsRename
+			_, name, _ := strings.Cut(line, " from ")
+			f.OldPath, err = unquoteName(name)
+		case strings.HasPrefix(line, "rename to "), strings.HasPrefix(line, "copy to "):
+			_, name, _ := strings.Cut(line, " to ")
+			f.NewPath, err = unquoteName(name)
+		case strings.HasPrefix(line, "similarity index "), strings.HasPrefix(line, "dissimilarity index "):
+		case strings.HasPrefix(line, "index "):
+			ids, mode, _ := strings.Cut(strings.TrimPrefix(line, "index "), " ")
+			oldID, newID, ok := strings.Cut(ids, "..")
+			if !ok {
+				err = fmt.Errorf("invalid index line: %s", line)
+				break
+			}
+			f.OldID, f.NewID = oldID, newID
+			if mode != "" {
+				f.OldMode, err = parseMode(mode)
+				f.NewMode = f.OldMode
+			}
+		case strings.HasPrefix(line, "Binary files "):
+			f.Binary = true
+			p.pos++
+			return f.finish(created, deleted), nil
+		case line == "GIT binary patch":
+			f.Binary = true
+			p.pos++
+			if err := p.parseBinary(f); err != nil {
+				return nil, err
+			}
+			return f.finish(created, deleted), nil
+		case strings.HasPrefix(line, "--- ") && p.pos+1 < len(p.lines) && strings.HasPrefix(p.lines[p.pos+1], "+++ "):
+			oldName, err := p.headerName(strings.TrimPrefix(line, "--- "))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", num, err)
+			}
+			newName, err := p.headerName(strings.TrimPrefix(trimEOL(p.lines[p.pos+1]), "+++ "))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %w", num+1, err)
+			}
+			created = created || oldName == devNull
+			deleted = deleted || newName == devNull
+			if !f.IsRename && !f.IsCopy {
+				if oldName != devNull {
+					f.OldPath = oldName
+				}
+				if newName != devNull {
+					f.NewPath = newName
+				}
+			}
+			p.pos += 2
+			if err := p.parseHunks(f); err != nil {
+				return nil, err
+			}
+			return f.finish(created, deleted), nil
+		default:
+			// The header ends without hunks, as for a change of mode only
+			return f.finish(created, deleted), nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", num, err)
+		}
+	}
+	return f.finish(created, deleted), nil
+}
+
+// finish clears the path of the side a created or deleted file lacks
+func (f *File) finish(created, deleted bool) *File {
+	if created {
+		f.OldPath = ""
+	}
+	if deleted {
+		f.NewPath = ""
+	}
+	return f
+}
+
+// parseUnifiedFile reads a plain unified diff: a --- and a +++ line and
+// the hunks that follow
+func (p *parser) parseUnifiedFile() (*File, error) {
+	oldName, err := p.headerName(strings.TrimPrefix(trimEOL(p.lines[p.pos]), "--- "))
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %w", p.pos+1, err)
+	}
+	newName, err := p.headerName(strings.TrimPrefix(trimEOL(p.lines[p.pos+1]), "+++ "))
+	if err != nil {
+		return nil, fmt.Errorf("line %d: %w", p.pos+2, err)
+	}
+	f := &File{OldPath: oldName, NewPath: newName}
+	p.pos += 2
+	if err := p.parseHunks(f); err != nil {
+		return nil, err
+	}
+	return f.finish(oldName == devNull, newName == devNull), nil
+}
+
+// parseHunks reads the hunks at the current position into f
+func (p *parser) parseHunks(f *File) error {
+	for p.pos < len(p.lines) && strings.HasPrefix(p.lines[p.pos], "@@ -") {
+		num := p.pos + 1
+		h, err := parseHunkHeader(trimEOL(p.lines[p.pos]))
+		if err != nil {
+			return fmt.Errorf("line %d: %w", num, err)
+		}
+		p.pos++
+
+		oldLeft, newLeft := h.OldLines, h.NewLines
+		for oldLeft > 0 || newLeft > 0 || p.noNewline() {
+			if p.pos >= len(p.lines) {
+				return fmt.Errorf("line %d: hunk is missing %d old and %d new lines", num, oldLeft, newLeft)
+			}
+			text := p.lines[p.pos]
+			op := text[0]
+			if text == "\n" || text == "\r\n" {
+				// Mailers drop the space of empty context lines
+				op, text = ' ', " "+text
+			}
+			switch op {
+			case ' ':
+				oldLeft--
+				newLeft--
+			case '-':
+				oldLeft--
+			case '+':
+				newLeft--
+			case '\\':
+				if len(h.Lines) > 0 {
+					last := &h.Lines[len(h.Lines)-1]
+					last.Text = strings.TrimSuffix(last.Text, "\n")
+				}
+				p.pos++
+				continue
+			default:
+				return fmt.Errorf("line %d: corrupt hunk", p.pos+1)
+			}
+			if oldLeft < 0 || newLeft < 0 {
+				return fmt.Errorf("line %d: hunk has more lines than its header says", p.pos+1)
+			}
+			h.Lines = append(h.Lines, Line{Op: op, Text: text[1:], Num: p.pos + 1})
+			p.pos++
+		}
+		f.Hunks = append(f.Hunks, h)
+	}
+	return nil
+}
+
+// noNewline reports whether the current line says the line before it
+// has no newline
+func (p *parser) noNewline() bool {
+	return p.pos < len(p.lines) && strings.HasPrefix(p.lines[p.pos], "\\")
+}
+
+// parseHunkHeader parses "@@ -l,s +l,s @@", where a missing count is 1
+func parseHunkHeader(line string) (*Hunk, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 4 || fields[3] != "@@" || !strings.HasPrefix(fields[2], "+") {
+		return nil, fmt.Errorf("invalid hunk header: %s", line)
+	}
+	h := &Hunk{}
+	var err error
+	if h.OldStart, h.OldLines, err = parseRange(fields[1][1:]); err != nil {
+		return nil, fmt.Errorf("invalid hunk header: %s", line)
+	}
+	if h.NewStart, h.NewLines, err = parseRange(fields[2][1:]); err != nil {
+		return nil, fmt.Errorf("invalid hunk header: %s", line)
+	}
+	return h, nil
+}
+
+func parseRange(s string) (start, count int, err error) {
+	startText, countText, ok := strings.Cut(s, ",")
+	if start, err = strconv.Atoi(startText); err != nil {
+		return 0, 0, err
+	}
+	count = 1
+	if ok {
+		if count, err = strconv.Atoi(countText); err != nil {
+			return 0, 0, err
+		}
+	}
+	return start, count, nil
+}
+
+// headerName reads the path of a --- or +++ line, which may be quoted or
+// followed by a tab and a timestamp
+func (p *parser) headerName(s string) (string, error) {
+	if strings.HasPrefix(s, `"`) {
+		name, err := unquoteName(s[:quotedEnd(s)+1])
+		if err != nil {
+			return "", err
+		}
+		return p.stripPath(name), nil
+	}
+	if name, _, ok := strings.Cut(s, "\t"); ok {
+		s = name
+	}
+	return p.stripPath(strings.TrimRight(s, " ")), nil
+}
+
+// stripPath removes the leading components of name that p.strip says to
+func (p *parser) stripPath(name string) string {
+	if name == devNull {
+		return name
+	}
+	for i := 0; i < p.strip; i++ {
+		_, rest, ok := strings.Cut(name, "/")
+		if !ok {
+			break
+		}
+		name = strings.TrimLeft(rest, "/")
+	}
+	return name
+}
+
+// splitGitNames splits the names of a diff --git line. Unquoted names may
+// hold spaces, so the line is split where both halves name the same file;
+// the names of renames and copies come from later header lines anyway.
+func splitGitNames(s string) (string, string, error) {
+	if strings.HasPrefix(s, `"`) {
+		end := quotedEnd(s)
+		oldName, err := unquoteName(s[:end+1])
+		if err != nil {
+			return "", "", err
+		}
+		newName, err := unquoteName(strings.TrimSpace(s[end+1:]))
+		return oldName, newName, err
+	}
+	if i := strings.Index(s, ` "`); i >= 0 && strings.HasSuffix(s, `"`) {
+		newName, err := unquoteName(s[i+1:])
+		return s[:i], newName, err
+	}
+	if n := len(s); n%2 == 1 && s[n/2] == ' ' {
+		oldName, newName := s[:n/2], s[n/2+1:]
+		_, oldRest, _ := strings.Cut(oldName, "/")
+		_, newRest, _ := strings.Cut(newName, "/")
+		if oldRest == newRest {
+			return oldName, newName, nil
+		}
+	}
+	oldName, newName, ok := strings.Cut(s, " ")
+	if !ok {
+		return "", "", fmt.Errorf("invalid diff --git line: %s", s)
+	}
+	return oldName, newName, nil
+}
+
+// unquoteName reads a name that Git may have quoted C-style
+func unquoteName(s string) (string, error) {
+	if !strings.HasPrefix(s, `"`) {
+		return s, nil
+	}
+	// Git's escapes, octal bytes included, are valid in Go string literals
+	name, err := strconv.Unquote(s)
+	if err != nil {
+		return "", fmt.Errorf("invalid quoted name: %s", s)
+	}
+	return name, nil
+}
+
+// QuoteName quotes name C-style, as Git writes names with control
+// characters, quotes, backslashes or non-ASCII bytes in diff headers
+func QuoteName(name string) string {
+	quote := false
+	for i := 0; i < len(name); i++ {
+		if c := name[i]; c < 0x20 || c >= 0x7f || c == '"' || c == '\\' {
+			quote = true
+			break
+		}
+	}
+	if !quote {
+		return name
+	}
+
+	var b strings.Builder
+	b.WriteByte('"')
+	for i := 0; i < len(name); i++ {
+		switch c := name[i]; c {
+		case '"', '\\':
+			b.WriteByte('\\')
+			b.WriteByte(c)
+		case '\t':
+			b.WriteString(`\t`)
+		case '\n':
+			b.WriteString(`\n`)
+		default:
+			if c < 0x20 || c >= 0x7f {
+				fmt.Fprintf(&b, `\%03o`, c)
+			} else {
+				b.WriteByte(c)
+			}
+		}
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// quotedEnd returns the index of the quote closing the string s starts
+// with, or the last index of s
+func quotedEnd(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return len(s) - 1
+}
+
+// parseMode parses an octal file mode of a header line
+func parseMode(s string) (objects.FileMode, error) {
+	mode, err := strconv.ParseUint(strings.TrimSpace(s), 8, 32)
+	if err != nil {
+		return 0, fmt.Errorf("invalid mode: %s", s)
+	}
+	return objects.FileMode(mode), nil
+}
+
+// splitLines splits data after each newline, keeping the terminators
+func splitLines(data []byte) []string {
+	var lines []string
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			lines = append(lines, string(data))
+			break
+		}
+		lines = append(lines, string(data[:i+1]))
+		data = data[i+1:]
+	}
+	return lines
+}
+
+// trimEOL removes the line terminator of line
+func trimEOL(line string) string {
+	return strings.TrimRight(line, "\r\n")
+}
@@ -0,0 +1,421 @@
+package patch
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+const gitDiff = `diff --git a/run.sh b/run.sh
+old mode 100644
+new mode 100755
+diff --git a/old name.txt b/new name.txt
+similarity index 80%
+rename from old name.txt
+rename to new name.txt
+index 1234567..89abcde 100644
+--- a/old name.txt
++++ b/new name.txt
+@@ -1,3 +1,3 @@
+ one
+-two
++2
+ three
+diff --git a/gone.txt b/gone.txt
+deleted file mode 100644
+index e69de29..0000000
+diff --git "a/d/\303\251t\303\251" "b/d/\303\251t\303\251"
+new file mode 100644
+index 0000000..ce01362
+--- /dev/null
++++ "b/d/\303\251t\303\251"
+@@ -0,0 +1 @@
++hello
+\ No newline at end of file
+diff --git a/image.png b/image.png
+index 1111111..2222222 100644
+Binary files a/image.png and b/image.png differ
+`
+
+func TestParseGitDiff(t *testing.T) {
+	files, err := Parse([]byte(gitDiff), 1)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(files) != 5 {
+		t.Fatalf("Parse() returned %d files, want 5", len(files))
+	}
+
+	mode := files[0]
+	if mode.OldPath != "run.sh" || mode.NewPath != "run.sh" || mode.OldMode != objects.ModeBlob || mode.NewMode != objects.ModeExec || len(mode.Hunks) != 0 {
+		t.Errorf("mode change = %+v", mode)
+	}
+
+	rename := files[1]
+	if !rename.IsRename || rename.OldPath != "old name.txt" || rename.NewPath != "new name.txt" || rename.OldID != "1234567" {
+		t.Errorf("rename = %+v", rename)
+	}
+	if len(rename.Hunks) != 1 || len(rename.Hunks[0].Lines) != 4 || rename.Hunks[0].Lines[1] != (Line{'-', "two\n", 13}) {
+		t.Errorf("rename hunks = %+v", rename.Hunks)
+	}
+
+	if deleted := files[2]; deleted.OldPath != "gone.txt" || deleted.NewPath != "" || deleted.OldMode != objects.ModeBlob {
+		t.Errorf("deletion = %+v", deleted)
+	}
+
+	created := files[3]
+	if created.OldPath != "" || created.NewPath != "d/été" || created.NewMode != objects.ModeBlob {
+		t.Errorf("creation = %+v", created)
+	}
+	if len(created.Hunks) != 1 || created.Hunks[0].Lines[0].Text != "hello" {
+		t.Errorf("creation hunks = %+v", created.Hunks)
+	}
+
+	if binary := files[4]; !binary.Binary || binary.Name() != "image.png" {
+		t.Errorf("binary = %+v", binary)
+	}
+}
+
+func TestParseUnified(t *testing.T) {
+	diff := `Some description of the patch.
+
+--- src/a.c	2024-01-01 10:00:00.000000000 +0000
++++ src/a.c	2024-01-02 10:00:00.000000000 +0000
+@@ -2 +2,2 @@
+-b
++B
++C
+`
+	files, err := Parse([]byte(diff), 0)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	if len(files) != 1 || files[0].OldPath != "src/a.c" || files[0].NewPath != "src/a.c" {
+		t.Fatalf("Parse() = %+v", files)
+	}
+	h := files[0].Hunks[0]
+	if h.OldStart != 2 || h.OldLines != 1 || h.NewLines != 2 || len(h.Lines) != 3 {
+		t.Errorf("hunk = %+v", h)
+	}
+
+	for _, bad := range []string{
+		"--- a\n+++ b\n@@ -1,2 +1,2 @@\n a\n",
+		"--- a\n+++ b\n@@ -1 +1 @@\n*a\n",
+		"diff --git a/x b/x\nold mode 1z\n",
+	} {
+		if _, err := Parse([]byte(bad), 1); err == nil {
+			t.Errorf("Parse(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestQuoteName(t *testing.T) {
+	for name, want := range map[string]string{
+		"plain name.txt": "plain name.txt",
+		"d/été":          `"d/\303\251t\303\251"`,
+		"tab\there":      `"tab\there"`,
+		`say "hi"`:       `"say \"hi\""`,
+	} {
+		got := QuoteName(name)
+		if got != want {
+			t.Errorf("QuoteName(%q) = %s, want %s", name, got, want)
+		}
+		if back, err := unquoteName(got); err != nil || back != name {
+			t.Errorf("unquoteName(%s) = %q, %v", got, back, err)
+		}
+	}
+}
+
+func TestApply(t *testing.T) {
+	patch := `--- a/f
++++ b/f
+@@ -2,3 +2,3 @@
+ b
+-c
++C
+ d
+@@ -8,2 +8,3 @@
+ h
+ i
++j
+`
+	files, err := Parse([]byte(patch), 1)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	f := files[0]
+
+	got, err := f.Apply([]byte("a\nb\nc\nd\ne\nf\ng\nh\ni\n"))
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if want := "a\nb\nC\nd\ne\nf\ng\nh\ni\nj\n"; string(got) != want {
+		t.Errorf("Apply() = %q, want %q", got, want)
+	}
+
+	// Lines added above the hunks move them down
+	got, err = f.Apply([]byte("x\ny\na\nb\nc\nd\ne\nf\ng\nh\ni\n"))
+	if err != nil {
+		t.Fatalf("Apply() with offset error = %v", err)
+	}
+	if want := "x\ny\na\nb\nC\nd\ne\nf\ng\nh\ni\nj\n"; string(got) != want {
+		t.Errorf("Apply() with offset = %q, want %q", got, want)
+	}
+
+	// A hunk without trailing context must match at the end
+	_, err = f.Apply([]byte("a\nb\nc\nd\ne\nf\ng\nh\ni\nz\n"))
+	var hunkErr *HunkError
+	if !errors.As(err, &hunkErr) || hunkErr.Line != 8 {
+		t.Errorf("Apply() error = %v, want a failure of the hunk at line 8", err)
+	}
+
+	f.Reverse()
+	got, err = f.Apply([]byte("a\nb\nC\nd\ne\nf\ng\nh\ni\nj\n"))
+	if err != nil {
+		t.Fatalf("Apply() of the reverse error = %v", err)
+	}
+	if want := "a\nb\nc\nd\ne\nf\ng\nh\ni\n"; string(got) != want {
+		t.Errorf("Apply() of the reverse = %q, want %q", got, want)
+	}
+}
+
+func TestApplyNoNewline(t *testing.T) {
+	patch := `--- a/f
++++ b/f
+@@ -1,2 +1,2 @@
+ a
+-b
+\ No newline at end of file
++b
+`
+	files, err := Parse([]byte(patch), 1)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	got, err := files[0].Apply([]byte("a\nb"))
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if string(got) != "a\nb\n" {
+		t.Errorf("Apply() = %q, want %q", got, "a\nb\n")
+	}
+	if _, err := files[0].Apply([]byte("a\nb\n")); err == nil {
+		t.Errorf("Apply() matched a line with a newline to one without")
+	}
+}
+
+func TestWhitespace(t *testing.T) {
+	patch := "--- a/f\n+++ b/f\n@@ -1 +1,5 @@\n a\n+trailing \n+ \tindent\n+        \tok\n+\n"
+	files, err := Parse([]byte(patch), 1)
+	if err != nil {
+		t.Fatalf("Parse() error = %v", err)
+	}
+	f := files[0]
+
+	var problems []string
+	for _, e := range f.WhitespaceErrors() {
+		problems = append(problems, e.Problem)
+	}
+	want := "trailing whitespace,space before tab in indent,space before tab in indent,new blank line at EOF"
+	if strings.Join(problems, ",") != want {
+		t.Errorf("WhitespaceErrors() = %q, want %q", problems, want)
+	}
+
+	if n := f.FixWhitespace(); n != 4 {
+		t.Errorf("FixWhitespace() = %d, want 4", n)
+	}
+	if errs := f.WhitespaceErrors(); len(errs) != 0 {
+		t.Errorf("WhitespaceErrors() after fixing = %+v", errs)
+	}
+	got, err := f.Apply([]byte("a\n"))
+	if err != nil {
+		t.Fatalf("Apply() error = %v", err)
+	}
+	if want := "a\ntrailing\n\tindent\n\t\tok\n"; string(got) != want {
+		t.Errorf("Apply() = %q, want %q", got, want)
+	}
+}
+
+const mbox = `From 0123456789abcdef0123456789abcdef01234567 Mon Sep 17 00:00:00 2001
+From: =?UTF-8?q?J=C3=BCrgen=20Doe?= <jurgen@example.com>
+Date: Tue, 14 Nov 2023 22:13:20 +0100
+Subject: [PATCH 1/2] Fix the frobnicator
+ for real
+Content-Type: text/plain; charset=UTF-8
+Content-Transfer-Encoding: quoted-printable
+
+It was broken =E2=80=94 badly.
+
+Signed-off-by: J=C3=BCrgen Doe <jurgen@example.com>
+---
+ f | 2 +-
+ 1 file changed, 1 insertion(+), 1 deletion(-)
+
+diff --git a/f b/f
+index 1234567..89abcde 100644
+--- a/f
++++ b/f
+@@ -1 +1 @@
+-a
++b
+--=20
+2.40.0
+
+From 89abcdef0123456789abcdef0123456789abcdef Mon Sep 17 00:00:00 2001
+From: Sender <sender@example.com>
+Date: Wed, 15 Nov 2023 10:00:00 +0000
+Subject: [PATCH 2/2] Ignored
+
+From: Author <author@example.com>
+Subject: Second change
+
+diff --git a/f b/f
+--- a/f
++++ b/f
+@@ -1 +1 @@
+-b
++c
+`
+
+func TestParseMail(t *testing.T) {
+	mails := SplitMbox([]byte(mbox))
+	if len(mails) != 2 {
+		t.Fatalf("SplitMbox() returned %d mails, want 2", len(mails))
+	}
+
+	m, err := ParseMail(mails[0])
+	if err != nil {
+		t.Fatalf("ParseMail() error = %v", err)
+	}
+	if m.Author.Name != "Jürgen Doe" || m.Author.Email != "jurgen@example.com" {
+		t.Errorf("author = %+v", m.Author)
+	}
+	if want := time.Date(2023, 11, 14, 21, 13, 20, 0, time.UTC); !m.Date().Equal(want) {
+		t.Errorf("date = %v, want %v", m.Date(), want)
+	}
+	if m.Subject != "Fix the frobnicator for real" {
+		t.Errorf("subject = %q", m.Subject)
+	}
+	if want := "Fix the frobnicator for real\n\nIt was broken — badly.\n\nSigned-off-by: Jürgen Doe <jurgen@example.com>\n"; m.Message != want {
+		t.Errorf("message = %q, want %q", m.Message, want)
+	}
+	files, err := Parse(m.Patch, 1)
+	if err != nil || len(files) != 1 || len(files[0].Hunks) != 1 {
+		t.Errorf("patch = %v, %v", files, err)
+	}
+
+	m, err = ParseMail(mails[1])
+	if err != nil {
+		t.Fatalf("ParseMail() error = %v", err)
+	}
+	if m.Author.Name != "Author" || m.Subject != "Second change" || m.Message != "Second change\n" {
+		t.Errorf("mail with in-body headers = %+v", m)
+	}
+	if !strings.HasPrefix(string(m.Patch), "diff --git") {
+		t.Errorf("patch = %q", m.Patch)
+	}
+
+	if _, err := ParseMail([]byte("Subject: x\n\nbody\n")); err == nil {
+		t.Errorf("ParseMail() accepted a mail without an author")
+	}
+}
+
+func TestBinaryPatch(t *testing.T) {
+	// As written by git format-patch
+	created := `diff --git a/small.bin b/small.bin
+new file mode 100644
+index 0000000000000000000000000000000000000000..8352675d67aed6625ece79af41c27fdb4ee2e867
+GIT binary patch
+literal 3
+KcmZQzWC8#H2LJ>B
+
+literal 0
+HcmV?d00001
+
+`
+	files, err := Parse([]byte(created), 1)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Parse() = %v, %v", files, err)
+	}
+	f := files[0]
+	if !f.Binary || f.NewPath != "small.bin" || f.BinaryPatch == nil || f.BinaryReverse == nil {
+		t.Fatalf("binary file = %+v", f)
+	}
+	got, err := f.Apply(nil)
+	if err != nil || string(got) != "\x00\x01\x02" {
+		t.Errorf("Apply() = %q, %v", got, err)
+	}
+	f.Reverse()
+	if got, err := f.Apply([]byte("\x00\x01\x02")); err != nil || len(got) != 0 {
+		t.Errorf("reverse Apply() = %q, %v", got, err)
+	}
+
+	old := []byte(strings.Repeat("\x00\x01binary data\xff", 200))
+	new := append([]byte("header\x00"), old[:1500]...)
+	var buf strings.Builder
+	buf.WriteString("diff --git a/img b/img\nindex " + objects.NewBlob(old).ID().String() + ".." + objects.NewBlob(new).ID().String() + " 100644\n")
+	if err := WriteBinary(&buf, old, new); err != nil {
+		t.Fatalf("WriteBinary() error = %v", err)
+	}
+	if !strings.Contains(buf.String(), "\ndelta ") {
+		t.Errorf("similar content was not written as a delta:\n%s", buf.String())
+	}
+	files, err = Parse([]byte(buf.String()), 1)
+	if err != nil || len(files) != 1 {
+		t.Fatalf("Parse() = %v, %v", files, err)
+	}
+	if got, err := files[0].Apply(old); err != nil || string(got) != string(new) {
+		t.Errorf("Apply() of delta = %v", err)
+	}
+	if _, err := files[0].Apply(new); err == nil || !strings.Contains(err.Error(), "does not match the current contents") {
+		t.Errorf("Apply() to other content = %v", err)
+	}
+	files[0].Reverse()
+	if got, err := files[0].Apply(new); err != nil || string(got) != string(old) {
+		t.Errorf("reverse Apply() of delta = %v", err)
+	}
+
+	// Without data or full IDs there is nothing to apply
+	for _, diff := range []string{
+		"diff --git a/x b/x\nindex 1234567..89abcde 100644\nBinary files a/x and b/x differ\n",
+		"diff --git a/x b/x\nindex 1234567..89abcde 100644\nGIT binary patch\nliteral 3\nKcmZQzWC8#H2LJ>B\n\n",
+	} {
+		files, err := Parse([]byte(diff), 1)
+		if err != nil {
+			t.Fatalf("Parse() error = %v", err)
+		}
+		if _, err := files[0].Apply(nil); err == nil || !strings.Contains(err.Error(), "without full index line") {
+			t.Errorf("Apply() = %v", err)
+		}
+	}
+
+	for _, bad := range []string{
+		"diff --git a/x b/x\nGIT binary patch\nliteral 4\nKcmZQzWC8#H2LJ>B\n\n",
+		"diff --git a/x b/x\nGIT binary patch\nliteral 3\nKcmZQzWC8#H2LJ>\n\n",
+		"diff --git a/x b/x\nGIT binary patch\nliteral 3\nK\"mZQzWC8#H2LJ>B\n\n",
+		"diff --git a/x b/x\nGIT binary patch\n",
+	} {
+		if _, err := Parse([]byte(bad), 1); err == nil {
+			t.Errorf("Parse(%q) succeeded", bad)
+		}
+	}
+}
+
+func TestBase85(t *testing.T) {
+	for _, data := range []string{"", "a", "abcd", "\xff\xff\xff\xff", "hello, world"} {
+		encoded := encodeBase85([]byte(data))
+		if len(encoded) != (len(data)+3)/4*5 {
+			t.Errorf("encodeBase85(%q) = %q", data, encoded)
+		}
+		decoded, err := decodeBase85(encoded)
+		if err != nil || string(decoded[:len(data)]) != data {
+			t.Errorf("decodeBase85(%q) = %q, %v", encoded, decoded, err)
+		}
+	}
+	if _, err := decodeBase85("|NsC1"); err == nil {
+		t.Errorf("decodeBase85() accepted a value above 32 bits")
+	}
+}
@@ -0,0 +1,121 @@
+// Package promisor fetches the objects a repository lacks from the remote
+// that promised them, as a partial clone does when it reads an object it
+// was cloned without.
+//
+// A Fetcher batches the misses of concurrent readers: the objects asked for
+// while every fetch slot is busy go out together in the next request, and
+// an object already on its way is waited for rather than asked for again.
+package promisor
+
+import (
+	"sync"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+// DefaultConcurrency is the number of requests a Fetcher has in flight at
+// once unless told otherwise
+const DefaultConcurrency = 4
+
+// DefaultBatchSize is the most objects a Fetcher asks for in one request
+const DefaultBatchSize = 1000
+
+// FetchFunc fetches the objects ids from the promisor remote into the
+// repository
+type FetchFunc func(ids []objects.ObjectID) error
+
+// Fetcher fetches missing objects with a FetchFunc, implementing
+// objects.ObjectFetcher
+type Fetcher struct {
+	fetch     FetchFunc
+	slots     chan struct{}
+	batchSize int
+
+	mu sync.Mutex
+	// open is the batch still taking objects, waiting for a slot
+	open *batch
+	// pending holds the batch each queued or in-flight object is in
+	pending map[objects.ObjectID]*batch
+}
+
+// batch is the objects of one request, whose readers wait on done
+type batch struct {
+	ids  []objects.ObjectID
+	done chan struct{}
+	err  error
+}
+
+// NewFetcher returns a Fetcher running at most concurrency fetches at once,
+// DefaultConcurrency when concurrency is not positive
+func NewFetcher(fetch FetchFunc, concurrency int) *Fetcher {
+	if concurrency <= 0 {
+		concurrency = DefaultConcurrency
+	}
+	return &Fetcher{
+		fetch:     fetch,
+		slots:     make(chan struct{}, concurrency),
+		batchSize: DefaultBatchSize,
+		pending:   make(map[objects.ObjectID]*batch),
+	}
+}
+
+// SetBatchSize sets the most objects asked for in one request
+func (f *Fetcher) SetBatchSize(size int) {
+	if size > 0 {
+		f.batchSize = size
+	}
+}
+
+// FetchObjects fetches ids, returning once every batch they were put in
+// has been fetched
+func (f *Fetcher) FetchObjects(ids []objects.ObjectID) error {
+	var waits []*batch
+	f.mu.Lock()
+	for _, id := range ids {
+		if b, ok := f.pending[id]; ok {
+			waits = append(waits, b)
+			continue
+		}
+		if f.open == nil || len(f.open.ids) >= f.batchSize {
+			// The reader opening a batch sends it in the background
+			f.open = &batch{done: make(chan struct{})}
+			go f.send(f.open)
+		}
+		f.open.ids = append(f.open.ids, id)
+		f.pending[id] = f.open
+		waits = append(waits, f.open)
+	}
+	f.mu.Unlock()
+
+	var firstErr error
+	for _, b := range waits {
+		<-b.done
+		if b.err != nil && firstErr == nil {
+			firstErr = b.err
+		}
+	}
+	return firstErr
+}
+
+// send fetches b once a slot is free, closing it to further objects first
+func (f *Fetcher) send(b *batch) {
+	f.slots <- struct{}{}
+	defer func() { <-f.slots }()
+
+	f.mu.Lock()
+	if f.open == b {
+		f.open = nil
+	}
+	f.mu.Unlock()
+
+	b.err = f.fetch(b.ids)
+
+	f.mu.Lock()
+	for _, id := range b.ids {
+		if f.pending[id] == b {
+			delete(f.pending, id)
+		}
+	}
+	f.mu.Unlock()
+	close(b.done)
+}
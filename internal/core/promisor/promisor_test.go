@@ -0,0 +1,139 @@
+package promisor
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+func testID(n int) objects.ObjectID {
+	id, _ := objects.NewObjectID(fmt.Sprintf("%040x", n))
+	return id
+}
+
+// waitPending waits until n objects are queued or in flight
+func waitPending(t *testing.T, f *Fetcher, n int) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		f.mu.Lock()
+		got := len(f.pending)
+		f.mu.Unlock()
+		if got >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d objects pending, want %d", got, n)
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestFetcherBatchesWhileBusy(t *testing.T) {
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var requests [][]objects.ObjectID
+	f := NewFetcher(func(ids []objects.ObjectID) error {
+		mu.Lock()
+		requests = append(requests, ids)
+		first := len(requests) == 1
+		mu.Unlock()
+		if first {
+			<-release
+		}
+		return nil
+	}, 1)
+
+	var wg sync.WaitGroup
+	fetch := func(ids ...objects.ObjectID) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := f.FetchObjects(ids); err != nil {
+				t.Errorf("FetchObjects() error = %v", err)
+			}
+		}()
+	}
+
+	// The first request holds the only slot while the rest queue up
+	fetch(testID(1))
+	waitPending(t, f, 1)
+	for {
+		mu.Lock()
+		started := len(requests) == 1
+		mu.Unlock()
+		if started {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+	fetch(testID(2))
+	fetch(testID(3), testID(1))
+	waitPending(t, f, 3)
+	close(release)
+	wg.Wait()
+
+	if len(requests) != 2 || len(requests[0]) != 1 || len(requests[1]) != 2 {
+		t.Fatalf("requests = %v, want one of 1 object then one of 2", requests)
+	}
+	if len(f.pending) != 0 {
+		t.Errorf("%d objects still pending", len(f.pending))
+	}
+}
+
+func TestFetcherConcurrencyLimit(t *testing.T) {
+	var mu sync.Mutex
+	var inFlight, most, requests int
+	f := NewFetcher(func(ids []objects.ObjectID) error {
+		mu.Lock()
+		inFlight++
+		requests++
+		if inFlight > most {
+			most = inFlight
+		}
+		mu.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mu.Lock()
+		inFlight--
+		mu.Unlock()
+		return nil
+	}, 2)
+	f.SetBatchSize(1)
+
+	var ids []objects.ObjectID
+	for i := 0; i < 10; i++ {
+		ids = append(ids, testID(i))
+	}
+	if err := f.FetchObjects(ids); err != nil {
+		t.Fatalf("FetchObjects() error = %v", err)
+	}
+	if requests != 10 {
+		t.Errorf("%d requests, want 10", requests)
+	}
+	if most != 2 {
+		t.Errorf("%d requests in flight at once, want 2", most)
+	}
+}
+
+func TestFetcherError(t *testing.T) {
+	fail := errors.New("remote hung up")
+	calls := 0
+	f := NewFetcher(func(ids []objects.ObjectID) error {
+		calls++
+		return fail
+	}, 0)
+
+	if err := f.FetchObjects([]objects.ObjectID{testID(1)}); !errors.Is(err, fail) {
+		t.Errorf("FetchObjects() error = %v, want %v", err, fail)
+	}
+	// A failed object is asked for again
+	if err := f.FetchObjects([]objects.ObjectID{testID(1)}); !errors.Is(err, fail) {
+		t.Errorf("FetchObjects() error = %v, want %v", err, fail)
+	}
+	if calls != 2 {
+		t.Errorf("%d requests, want 2", calls)
+	}
+}
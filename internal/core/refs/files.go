@@ -0,0 +1,555 @@
+package refs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/fenilsonani/vcs/internal/core/fault"
+	"github.com/fenilsonani/vcs/internal/core/gitdir"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+// filesStore keeps each ref in a file named after it, a loose ref, with
+// the packed-refs file holding the refs pack-refs moved out of their own
+// files. A loose ref takes precedence over a packed one of the same name.
+// In a linked worktree, HEAD and the other per-worktree refs are kept in
+// the worktree's directory and all others in the common directory.
+type filesStore struct {
+	gitDir    string
+	commonDir string
+
+	// packed caches packed-refs, which is only parsed again once it
+	// changes on disk
+	mu     sync.Mutex
+	packed *PackedRefs
+	stat   os.FileInfo
+}
+
+// newFilesStore returns the files backend of the repository at gitDir
+func newFilesStore(gitDir, commonDir string) *filesStore {
+	return &filesStore{gitDir: gitDir, commonDir: commonDir}
+}
+
+// Format returns FormatFiles
+func (s *filesStore) Format() string {
+	return FormatFiles
+}
+
+// refPath returns the file of refName
+func (s *filesStore) refPath(refName string) string {
+	if gitdir.IsPerWorktreeRef(refName) {
+		return filepath.Join(s.gitDir, refName)
+	}
+	return filepath.Join(s.commonDir, refName)
+}
+
+// noRefFile reports whether err, from reading a loose ref, means there is
+// no such file, including when a directory is in the way
+func noRefFile(err error) bool {
+	return os.IsNotExist(err) || errors.Is(err, syscall.ENOTDIR) || errors.Is(err, syscall.EISDIR)
+}
+
+// parseLooseRef parses the content of the loose ref name
+func parseLooseRef(name string, content []byte) (Ref, error) {
+	value := strings.TrimSpace(string(content))
+	// FETCH_HEAD and MERGE_HEAD list several commits, and name the first
+	if fields := strings.Fields(value); isFileRef(name) && len(fields) > 0 {
+		value = fields[0]
+	}
+	if target, ok := strings.CutPrefix(value, "ref: "); ok {
+		return Ref{Name: name, Target: target}, nil
+	}
+	id, err := objects.NewObjectID(value)
+	if err != nil {
+		return Ref{}, fmt.Errorf("invalid reference %s: %w", name, err)
+	}
+	return Ref{Name: name, ID: id}, nil
+}
+
+// ReadRef reads the loose ref name, or its packed value when it has no file
+func (s *filesStore) ReadRef(name string) (Ref, error) {
+	content, err := os.ReadFile(s.refPath(name))
+	if err == nil {
+		return parseLooseRef(name, content)
+	}
+	if !noRefFile(err) {
+		return Ref{}, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+
+	packed, err := s.readPackedRefs()
+	if err != nil {
+		return Ref{}, err
+	}
+	if id, ok := packed.refs[name]; ok {
+		return Ref{Name: name, ID: id}, nil
+	}
+	return Ref{}, fmt.Errorf("%w: %s", ErrRefNotFound, name)
+}
+
+// ListRefs walks the loose refs under prefix and merges in the packed ones,
+// found by binary search in the sorted packed names. Loose files that are
+// not valid refs are skipped.
+func (s *filesStore) ListRefs(prefix string) ([]Ref, error) {
+	found := make(map[string]Ref)
+	root := filepath.Join(s.commonDir, filepath.FromSlash(prefix))
+	if !strings.HasSuffix(prefix, "/") {
+		root = filepath.Dir(root)
+	}
+
+	err := filepath.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || strings.HasSuffix(path, ".lock") {
+			return nil
+		}
+		rel, err := filepath.Rel(s.commonDir, path)
+		if err != nil {
+			return err
+		}
+		name := filepath.ToSlash(rel)
+		if !strings.HasPrefix(name, prefix) {
+			return nil
+		}
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return nil
+		}
+		if ref, err := parseLooseRef(name, content); err == nil {
+			found[name] = ref
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	packed, err := s.readPackedRefs()
+	if err != nil {
+		return nil, err
+	}
+	for i := sort.SearchStrings(packed.names, prefix); i < len(packed.names); i++ {
+		name := packed.names[i]
+		if !strings.HasPrefix(name, prefix) {
+			break
+		}
+		if _, ok := found[name]; !ok {
+			found[name] = Ref{Name: name, ID: packed.refs[name]}
+		}
+	}
+
+	refs := make([]Ref, 0, len(found))
+	for _, ref := range found {
+		refs = append(refs, ref)
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].Name < refs[j].Name })
+	return refs, nil
+}
+
+// WriteRef writes ref to its loose file
+func (s *filesStore) WriteRef(ref Ref) error {
+	refPath := s.refPath(ref.Name)
+	if err := os.MkdirAll(filepath.Dir(refPath), 0755); err != nil {
+		return fmt.Errorf("failed to create ref directory: %w", err)
+	}
+	content := ref.ID.String() + "\n"
+	if ref.IsSymbolic() {
+		content = "ref: " + ref.Target + "\n"
+	}
+	return writeRefFile(refPath, content)
+}
+
+// DeleteRef removes the loose file of name and its packed entry
+func (s *filesStore) DeleteRef(name string) (bool, error) {
+	err := os.Remove(s.refPath(name))
+	if err != nil && !noRefFile(err) {
+		return false, fmt.Errorf("failed to delete %s: %w", name, err)
+	}
+	removed, perr := s.removePackedRefs([]string{name})
+	if perr != nil {
+		return false, perr
+	}
+	return err == nil || removed, nil
+}
+
+// writeRefFile atomically replaces the ref file at path with content,
+// writing it to a lock file that is renamed into place
+func writeRefFile(path, content string) error {
+	lockPath := path + ".lock"
+	lockFile, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to acquire lock: %w", err)
+	}
+	defer os.Remove(lockPath)
+
+	if err := writeLockFile(lockFile, lockPath, content); err != nil {
+		return err
+	}
+	return fault.Rename(lockPath, path)
+}
+
+// writeLockFile writes content to the lock file at lockPath, syncs it to
+// disk and closes it
+func writeLockFile(lockFile *os.File, lockPath, content string) error {
+	if _, err := fault.NewWriter(lockFile, lockPath).Write([]byte(content)); err != nil {
+		lockFile.Close()
+		return fmt.Errorf("failed to write lock file: %w", err)
+	}
+	if err := fault.Sync(lockFile); err != nil {
+		lockFile.Close()
+		return fmt.Errorf("failed to sync lock file: %w", err)
+	}
+	if err := lockFile.Close(); err != nil {
+		return fmt.Errorf("failed to write lock file: %w", err)
+	}
+	return nil
+}
+
+// PrepareUpdates takes <ref>.lock for each update, writing the new value of
+// plain updates to it, and reads the value the ref has under the lock
+func (s *filesStore) PrepareUpdates(updates []*RefUpdate) error {
+	for _, u := range updates {
+		refPath := s.refPath(u.Ref)
+		if err := os.MkdirAll(filepath.Dir(refPath), 0755); err != nil {
+			return fmt.Errorf("cannot lock ref '%s': %w", u.Ref, err)
+		}
+		lockPath := refPath + ".lock"
+		lockFile, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+		if err != nil {
+			return fmt.Errorf("cannot lock ref '%s': %w", u.Ref, err)
+		}
+		u.lockPath = lockPath
+
+		// A symbolic ref updated itself exists but has no value
+		current, err := s.ReadRef(u.Ref)
+		switch {
+		case err == nil:
+			u.Previous, u.exists = current.ID, true
+		case !errors.Is(err, ErrRefNotFound):
+			lockFile.Close()
+			return fmt.Errorf("cannot lock ref '%s': %w", u.Ref, err)
+		}
+
+		if u.delete || u.verify {
+			if err := lockFile.Close(); err != nil {
+				return err
+			}
+			continue
+		}
+		if err := writeLockFile(lockFile, lockPath, u.NewID.String()+"\n"); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// CommitUpdates renames the lock files of the updates into place and
+// removes deleted refs, loose and packed, then syncs the directories
+// changed so that the changes survive a crash
+func (s *filesStore) CommitUpdates(updates []*RefUpdate) error {
+	defer s.AbortUpdates(updates)
+
+	dirs := make(map[string]bool)
+	var deleted []string
+	for _, u := range updates {
+		refPath := s.refPath(u.Ref)
+		switch {
+		case u.verify:
+		case u.delete:
+			if err := os.Remove(refPath); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to delete %s: %w", u.Ref, err)
+			}
+			deleted = append(deleted, u.Ref)
+		default:
+			if err := fault.Rename(u.lockPath, refPath); err != nil {
+				return fmt.Errorf("failed to update %s: %w", u.Ref, err)
+			}
+			u.lockPath = ""
+		}
+		dirs[filepath.Dir(refPath)] = true
+	}
+
+	if len(deleted) > 0 {
+		if _, err := s.removePackedRefs(deleted); err != nil {
+			return err
+		}
+	}
+	for dir := range dirs {
+		if err := syncDir(dir); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// AbortUpdates removes the lock files of the updates
+func (s *filesStore) AbortUpdates(updates []*RefUpdate) {
+	for _, u := range updates {
+		if u.lockPath != "" {
+			os.Remove(u.lockPath)
+			u.lockPath = ""
+		}
+	}
+}
+
+// syncDir syncs the directory at dir, so that the renames and removals in
+// it survive a crash
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", dir, err)
+	}
+	defer d.Close()
+	if err := fault.Sync(d); err != nil {
+		return fmt.Errorf("failed to sync %s: %w", dir, err)
+	}
+	return nil
+}
+
+// PackedRefs represents packed references
+type PackedRefs struct {
+	refs map[string]objects.ObjectID
+	// names holds the keys of refs in sorted order
+	names []string
+}
+
+// packedRefsPath returns the path of the packed-refs file
+func (s *filesStore) packedRefsPath() string {
+	return filepath.Join(s.commonDir, "packed-refs")
+}
+
+// readPackedRefs returns the packed refs, parsing packed-refs again only
+// when it was replaced since it was last read. The result is shared and
+// must not be modified.
+func (s *filesStore) readPackedRefs() (*PackedRefs, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, err := os.Stat(s.packedRefsPath())
+	if os.IsNotExist(err) {
+		s.packed, s.stat = nil, nil
+		return &PackedRefs{refs: make(map[string]objects.ObjectID)}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if s.packed != nil && os.SameFile(info, s.stat) && info.Size() == s.stat.Size() && info.ModTime().Equal(s.stat.ModTime()) {
+		return s.packed, nil
+	}
+
+	content, err := os.ReadFile(s.packedRefsPath())
+	if err != nil {
+		return nil, err
+	}
+	s.packed, s.stat = parsePackedRefs(content), info
+	return s.packed, nil
+}
+
+// parsePackedRefs parses the content of packed-refs: a header comment
+// followed by a line of "<id> <name>" per ref, each possibly followed by a
+// "^<id>" line with the object an annotated tag peels to, which is skipped
+func parsePackedRefs(content []byte) *PackedRefs {
+	packed := &PackedRefs{refs: make(map[string]objects.ObjectID)}
+	sorted := true
+	for len(content) > 0 {
+		line := content
+		if i := bytes.IndexByte(content, '\n'); i >= 0 {
+			line, content = content[:i], content[i+1:]
+		} else {
+			content = nil
+		}
+		line = bytes.TrimSpace(line)
+		if len(line) == 0 || line[0] == '#' || line[0] == '^' {
+			continue
+		}
+
+		idStr, name, ok := bytes.Cut(line, []byte(" "))
+		if !ok {
+			continue
+		}
+		id, err := objects.NewObjectID(string(idStr))
+		if err != nil {
+			continue
+		}
+		refName := string(bytes.TrimSpace(name))
+		if _, dup := packed.refs[refName]; !dup {
+			if n := len(packed.names); n > 0 && packed.names[n-1] > refName {
+				sorted = false
+			}
+			packed.names = append(packed.names, refName)
+		}
+		packed.refs[refName] = id
+	}
+	if !sorted {
+		sort.Strings(packed.names)
+	}
+	return packed
+}
+
+// packedRefsHeader is written at the top of packed-refs. Tags are not
+// peeled, so only the sorted trait is advertised.
+const packedRefsHeader = "# pack-refs with: sorted \n"
+
+// updatePackedRefs rewrites packed-refs with the changes update makes to
+// the packed refs, holding packed-refs.lock from reading to writing so that
+// concurrent updates are not lost. Nothing is written when update reports
+// no change.
+func (s *filesStore) updatePackedRefs(update func(refs map[string]objects.ObjectID) bool) error {
+	packedPath := s.packedRefsPath()
+	lockPath := packedPath + ".lock"
+	lockFile, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to acquire packed-refs lock: %w", err)
+	}
+	defer os.Remove(lockPath)
+
+	packed, err := s.readPackedRefs()
+	if err != nil {
+		lockFile.Close()
+		return err
+	}
+	refs := make(map[string]objects.ObjectID, len(packed.refs))
+	for name, id := range packed.refs {
+		refs[name] = id
+	}
+	if !update(refs) {
+		return lockFile.Close()
+	}
+
+	if len(refs) == 0 {
+		lockFile.Close()
+		if err := os.Remove(packedPath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove packed-refs: %w", err)
+		}
+		return nil
+	}
+
+	names := make([]string, 0, len(refs))
+	for name := range refs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var b strings.Builder
+	b.Grow(len(packedRefsHeader) + len(names)*(len(objects.ObjectID{})*2+32))
+	b.WriteString(packedRefsHeader)
+	for _, name := range names {
+		b.WriteString(refs[name].String())
+		b.WriteByte(' ')
+		b.WriteString(name)
+		b.WriteByte('\n')
+	}
+
+	if err := writeLockFile(lockFile, lockPath, b.String()); err != nil {
+		return fmt.Errorf("failed to write packed-refs: %w", err)
+	}
+	return fault.Rename(lockPath, packedPath)
+}
+
+// removePackedRefs drops names from packed-refs, rewriting it only when one
+// of them is there, and reports whether any was
+func (s *filesStore) removePackedRefs(names []string) (bool, error) {
+	packed, err := s.readPackedRefs()
+	if err != nil {
+		return false, err
+	}
+	found := false
+	for _, name := range names {
+		_, ok := packed.refs[name]
+		found = found || ok
+	}
+	if !found {
+		return false, nil
+	}
+
+	removed := false
+	err = s.updatePackedRefs(func(refs map[string]objects.ObjectID) bool {
+		for _, name := range names {
+			if _, ok := refs[name]; ok {
+				delete(refs, name)
+				removed = true
+			}
+		}
+		return removed
+	})
+	return removed, err
+}
+
+// PackRefs moves the selected loose refs into packed-refs, removing their
+// files with opts.Prune. Symbolic refs stay loose, and the refs/heads and
+// refs/tags directories are kept.
+func (s *filesStore) PackRefs(opts PackOptions) (int, error) {
+	loose, err := s.ListRefs("refs/")
+	if err != nil {
+		return 0, err
+	}
+	packed, err := s.readPackedRefs()
+	if err != nil {
+		return 0, err
+	}
+
+	selected := make(map[string]objects.ObjectID)
+	for _, ref := range loose {
+		if ref.IsSymbolic() {
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(s.commonDir, ref.Name)); err != nil {
+			continue // already packed
+		}
+		if _, wasPacked := packed.refs[ref.Name]; opts.All || wasPacked || strings.HasPrefix(ref.Name, "refs/tags/") {
+			selected[ref.Name] = ref.ID
+		}
+	}
+	if len(selected) == 0 {
+		return 0, nil
+	}
+
+	err = s.updatePackedRefs(func(refs map[string]objects.ObjectID) bool {
+		for name, id := range selected {
+			refs[name] = id
+		}
+		return true
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	// Only delete loose files that were not updated in the meantime
+	if opts.Prune {
+		for name, id := range selected {
+			refPath := filepath.Join(s.commonDir, name)
+			if current, err := os.ReadFile(refPath); err == nil && strings.TrimSpace(string(current)) == id.String() {
+				os.Remove(refPath)
+			}
+			s.removeEmptyRefDirs(filepath.Dir(refPath))
+		}
+	}
+	return len(selected), nil
+}
+
+// removeEmptyRefDirs removes empty directories left behind by packed refs,
+// stopping at refs/heads, refs/tags and refs itself
+func (s *filesStore) removeEmptyRefDirs(dir string) {
+	stop := map[string]bool{
+		filepath.Join(s.commonDir, "refs"):          true,
+		filepath.Join(s.commonDir, "refs", "heads"): true,
+		filepath.Join(s.commonDir, "refs", "tags"):  true,
+	}
+	for !stop[dir] && strings.HasPrefix(dir, s.commonDir) {
+		if err := os.Remove(dir); err != nil {
+			return
+		}
+		dir = filepath.Dir(dir)
+	}
+}
@@ -0,0 +1,171 @@
+package refs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+func TestFilesStore_PackOptions(t *testing.T) {
+	tmpDir := t.TempDir()
+	rm := NewRefManager(tmpDir)
+	id, _ := objects.NewObjectID("a94a8fe5ccb19ba61c4c0873d391e987982fbbd3")
+	for _, name := range []string{"refs/heads/main", "refs/tags/v1.0", "refs/tags/v2.0"} {
+		if err := rm.UpdateRef(name, id); err != nil {
+			t.Fatalf("UpdateRef() error = %v", err)
+		}
+	}
+
+	// Without All only tags are packed, and without Prune their files stay
+	packed, err := rm.Pack(PackOptions{})
+	if err != nil || packed != 2 {
+		t.Fatalf("Pack() = %d, %v, want 2", packed, err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "refs/tags/v1.0")); err != nil {
+		t.Errorf("loose tag removed without Prune: %v", err)
+	}
+
+	packed, err = rm.Pack(PackOptions{Prune: true})
+	if err != nil || packed != 2 {
+		t.Fatalf("Pack() = %d, %v, want 2", packed, err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "refs/tags/v1.0")); !os.IsNotExist(err) {
+		t.Errorf("loose tag still exists after Prune")
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "refs/heads/main")); err != nil {
+		t.Errorf("branch packed without All: %v", err)
+	}
+
+	packed, err = rm.Pack(PackOptions{All: true, Prune: true})
+	if err != nil || packed != 1 {
+		t.Fatalf("Pack() = %d, %v, want 1", packed, err)
+	}
+	all, err := rm.AllRefs()
+	if err != nil || len(all) != 3 {
+		t.Errorf("AllRefs() = %v, %v", all, err)
+	}
+}
+
+func TestFilesStore_ListRefs(t *testing.T) {
+	tmpDir := t.TempDir()
+	rm := NewRefManager(tmpDir)
+	packedID, _ := objects.NewObjectID("a94a8fe5ccb19ba61c4c0873d391e987982fbbd3")
+	looseID, _ := objects.NewObjectID("b94a8fe5ccb19ba61c4c0873d391e987982fbbd3")
+
+	for i := 0; i < 100; i++ {
+		rm.UpdateRef(fmt.Sprintf("refs/tags/v%03d", i), packedID)
+	}
+	rm.UpdateRef("refs/heads/main", packedID)
+	if _, err := rm.PackRefs(); err != nil {
+		t.Fatalf("PackRefs() error = %v", err)
+	}
+	rm.UpdateRef("refs/tags/v050", looseID)
+	rm.UpdateRef("refs/tags/v100", looseID)
+	rm.SetSymbolicRef("refs/remotes/origin/HEAD", "refs/heads/main")
+
+	tags, err := rm.Store().ListRefs("refs/tags/")
+	if err != nil {
+		t.Fatalf("ListRefs() error = %v", err)
+	}
+	if len(tags) != 101 {
+		t.Fatalf("ListRefs() returned %d tags, want 101", len(tags))
+	}
+	for i, ref := range tags {
+		if want := fmt.Sprintf("refs/tags/v%03d", i); ref.Name != want {
+			t.Fatalf("ListRefs()[%d] = %s, want %s", i, ref.Name, want)
+		}
+	}
+	if tags[50].ID != looseID || tags[49].ID != packedID {
+		t.Errorf("loose value does not shadow the packed one: %v, %v", tags[50].ID, tags[49].ID)
+	}
+
+	// A prefix that is not a directory matches the start of names
+	some, err := rm.Store().ListRefs("refs/tags/v09")
+	if err != nil || len(some) != 10 {
+		t.Errorf("ListRefs(refs/tags/v09) = %d refs, %v, want 10", len(some), err)
+	}
+
+	remotes, err := rm.Store().ListRefs("refs/remotes/")
+	if err != nil || len(remotes) != 1 || remotes[0].Target != "refs/heads/main" {
+		t.Errorf("ListRefs(refs/remotes/) = %v, %v", remotes, err)
+	}
+	all, err := rm.AllRefs()
+	if err != nil || all["refs/remotes/origin/HEAD"] != packedID {
+		t.Errorf("AllRefs() did not resolve the symbolic ref: %v", err)
+	}
+}
+
+func TestFilesStore_PackedRefsCache(t *testing.T) {
+	tmpDir := t.TempDir()
+	rm := NewRefManager(tmpDir)
+	first, _ := objects.NewObjectID("a94a8fe5ccb19ba61c4c0873d391e987982fbbd3")
+	second, _ := objects.NewObjectID("b94a8fe5ccb19ba61c4c0873d391e987982fbbd3")
+
+	content := "# pack-refs with: peeled fully-peeled sorted \n" +
+		first.String() + " refs/tags/v1.0\n^" + second.String() + "\n"
+	os.WriteFile(filepath.Join(tmpDir, "packed-refs"), []byte(content), 0644)
+	if id, err := rm.ResolveRef("v1.0"); err != nil || id != first {
+		t.Fatalf("ResolveRef() = %v, %v, want %v", id, err, first)
+	}
+
+	// Replacing the file is noticed even within the same second
+	next := filepath.Join(tmpDir, "packed-refs.new")
+	os.WriteFile(next, []byte(second.String()+" refs/tags/v1.0\n"), 0644)
+	os.Rename(next, filepath.Join(tmpDir, "packed-refs"))
+	if id, err := rm.ResolveRef("v1.0"); err != nil || id != second {
+		t.Errorf("ResolveRef() = %v, %v, want %v after packed-refs changed", id, err, second)
+	}
+
+	if err := rm.DeleteTag("v1.0"); err != nil {
+		t.Fatalf("DeleteTag() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(tmpDir, "packed-refs")); !os.IsNotExist(err) {
+		t.Errorf("empty packed-refs not removed")
+	}
+	if rm.RefExists("v1.0") {
+		t.Errorf("deleted tag still resolves")
+	}
+}
+
+func BenchmarkAllRefsPacked(b *testing.B) {
+	rm := NewRefManager(b.TempDir())
+	id, _ := objects.NewObjectID("a94a8fe5ccb19ba61c4c0873d391e987982fbbd3")
+	refs := make(map[string]objects.ObjectID)
+	for i := 0; i < 50000; i++ {
+		refs[fmt.Sprintf("refs/tags/v%05d", i)] = id
+	}
+	store := rm.Store().(*filesStore)
+	if err := store.updatePackedRefs(func(packed map[string]objects.ObjectID) bool {
+		for name, id := range refs {
+			packed[name] = id
+		}
+		return true
+	}); err != nil {
+		b.Fatalf("updatePackedRefs() error = %v", err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if all, err := rm.AllRefs(); err != nil || len(all) != len(refs) {
+			b.Fatalf("AllRefs() = %d refs, %v", len(all), err)
+		}
+	}
+}
+
+func TestFilesStore_FetchHead(t *testing.T) {
+	tmpDir := t.TempDir()
+	rm := NewRefManager(tmpDir)
+	content := "a94a8fe5ccb19ba61c4c0873d391e987982fbbd3\t\tbranch 'main' of https://example.com/repo\n" +
+		"b94a8fe5ccb19ba61c4c0873d391e987982fbbd3\tnot-for-merge\tbranch 'topic' of https://example.com/repo\n"
+	if err := os.WriteFile(filepath.Join(tmpDir, "FETCH_HEAD"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := rm.ResolveRef("FETCH_HEAD")
+	if err != nil || id.String() != "a94a8fe5ccb19ba61c4c0873d391e987982fbbd3" {
+		t.Errorf("ResolveRef(FETCH_HEAD) = %v, %v, want the first line's commit", id, err)
+	}
+}
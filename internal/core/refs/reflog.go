@@ -0,0 +1,214 @@
+package refs
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fenilsonani/vcs/internal/core/gitdir"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+// ReflogEntry is one update of a reference as recorded under logs/
+type ReflogEntry struct {
+	Old     objects.ObjectID
+	New     objects.ObjectID
+	Who     objects.Signature
+	Message string
+}
+
+// reflogPath returns where the reflog of refName is kept
+func (rm *RefManager) reflogPath(refName string) string {
+	dir := rm.commonDir
+	if gitdir.IsPerWorktreeRef(refName) {
+		dir = rm.gitDir
+	}
+	return filepath.Join(dir, "logs", filepath.FromSlash(refName))
+}
+
+// ReadReflog returns the reflog of refName, oldest entry first. A reference
+// without a reflog has no entries.
+func (rm *RefManager) ReadReflog(refName string) ([]ReflogEntry, error) {
+	file, err := os.Open(rm.reflogPath(refName))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read reflog: %w", err)
+	}
+	defer file.Close()
+
+	var entries []ReflogEntry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if line := scanner.Text(); line != "" {
+			entry, err := parseReflogLine(line)
+			if err != nil {
+				return nil, fmt.Errorf("invalid reflog entry for %s: %w", refName, err)
+			}
+			entries = append(entries, entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read reflog: %w", err)
+	}
+	return entries, nil
+}
+
+// parseReflogLine parses "<old> <new> <name> <<email>> <unix> <tz>\t<message>"
+func parseReflogLine(line string) (ReflogEntry, error) {
+	var entry ReflogEntry
+	if tab := strings.IndexByte(line, '\t'); tab >= 0 {
+		entry.Message = line[tab+1:]
+		line = line[:tab]
+	}
+
+	fields := strings.SplitN(line, " ", 3)
+	if len(fields) != 3 {
+		return entry, fmt.Errorf("missing object IDs")
+	}
+	var err error
+	if entry.Old, err = objects.NewObjectID(fields[0]); err != nil {
+		return entry, err
+	}
+	if entry.New, err = objects.NewObjectID(fields[1]); err != nil {
+		return entry, err
+	}
+
+	ident := fields[2]
+	start, end := strings.IndexByte(ident, '<'), strings.LastIndexByte(ident, '>')
+	if start < 0 || end < start {
+		return entry, fmt.Errorf("missing identity")
+	}
+	entry.Who.Name = strings.TrimSpace(ident[:start])
+	entry.Who.Email = ident[start+1 : end]
+
+	when := strings.Fields(ident[end+1:])
+	if len(when) != 2 {
+		return entry, fmt.Errorf("missing timestamp")
+	}
+	secs, err := strconv.ParseInt(when[0], 10, 64)
+	if err != nil {
+		return entry, fmt.Errorf("invalid timestamp: %w", err)
+	}
+	entry.Who.When = time.Unix(secs, 0).In(parseTimezone(when[1]))
+	return entry, nil
+}
+
+// parseTimezone turns an offset such as "-0700" into a fixed zone
+func parseTimezone(tz string) *time.Location {
+	if len(tz) != 5 || (tz[0] != '+' && tz[0] != '-') {
+		return time.UTC
+	}
+	hours, herr := strconv.Atoi(tz[1:3])
+	minutes, merr := strconv.Atoi(tz[3:5])
+	if herr != nil || merr != nil {
+		return time.UTC
+	}
+	offset := hours*3600 + minutes*60
+	if tz[0] == '-' {
+		offset = -offset
+	}
+	return time.FixedZone("", offset)
+}
+
+// AppendReflog adds an entry to the reflog of refName, creating it if needed
+func (rm *RefManager) AppendReflog(refName string, entry ReflogEntry) error {
+	path := rm.reflogPath(refName)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create reflog directory: %w", err)
+	}
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open reflog: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := file.WriteString(formatReflogLine(entry)); err != nil {
+		return fmt.Errorf("failed to write reflog: %w", err)
+	}
+	return nil
+}
+
+// WriteReflog replaces the reflog of refName with entries, oldest first,
+// removing it when there are none, as dropping a stash entry does
+func (rm *RefManager) WriteReflog(refName string, entries []ReflogEntry) error {
+	path := rm.reflogPath(refName)
+	if len(entries) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove reflog: %w", err)
+		}
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create reflog directory: %w", err)
+	}
+
+	var data strings.Builder
+	for _, entry := range entries {
+		data.WriteString(formatReflogLine(entry))
+	}
+	tmp := path + ".lock"
+	if err := os.WriteFile(tmp, []byte(data.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write reflog: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write reflog: %w", err)
+	}
+	return nil
+}
+
+// formatReflogLine returns entry as a line of a reflog
+func formatReflogLine(entry ReflogEntry) string {
+	// Messages are a single line
+	message := strings.ReplaceAll(strings.TrimSpace(entry.Message), "\n", " ")
+	return fmt.Sprintf("%s %s %s\t%s\n", entry.Old, entry.New, entry.Who, message)
+}
+
+// LogUpdate records that refName moved from oldID to newID. Moving the
+// branch HEAD points to is recorded for HEAD as well, as Git does.
+func (rm *RefManager) LogUpdate(refName string, oldID, newID objects.ObjectID, who objects.Signature, message string) error {
+	entry := ReflogEntry{Old: oldID, New: newID, Who: who, Message: message}
+	if err := rm.AppendReflog(refName, entry); err != nil {
+		return err
+	}
+	if refName == "HEAD" {
+		return nil
+	}
+	if head, err := rm.SymbolicHEAD(); err == nil && head == refName {
+		return rm.AppendReflog("HEAD", entry)
+	}
+	return nil
+}
+
+// ReflogAt returns what refName pointed to at the given time according to
+// its reflog
+func (rm *RefManager) ReflogAt(refName string, at time.Time) (objects.ObjectID, error) {
+	entries, err := rm.ReadReflog(refName)
+	if err != nil {
+		return objects.ObjectID{}, err
+	}
+	if len(entries) == 0 {
+		return objects.ObjectID{}, fmt.Errorf("no reflog for %s", refName)
+	}
+
+	for i := len(entries) - 1; i >= 0; i-- {
+		if !entries[i].Who.When.After(at) {
+			return entries[i].New, nil
+		}
+	}
+
+	// Before the first entry the ref still had the value it was moved from
+	first := entries[0]
+	if first.Old.IsZero() {
+		return objects.ObjectID{}, fmt.Errorf("log for %s only goes back to %s",
+			refName, first.Who.When.Format("Mon Jan 2 15:04:05 2006 -0700"))
+	}
+	return first.Old, nil
+}
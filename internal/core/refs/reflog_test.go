@@ -0,0 +1,193 @@
+package refs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+func newReflogTestManager(t *testing.T) *RefManager {
+	gitDir := filepath.Join(t.TempDir(), ".git")
+	if err := os.MkdirAll(gitDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	return NewRefManager(gitDir)
+}
+
+func TestRefManager_AppendReadReflog(t *testing.T) {
+	rm := newReflogTestManager(t)
+
+	entries, err := rm.ReadReflog("refs/heads/main")
+	if err != nil || entries != nil {
+		t.Fatalf("ReadReflog() of missing log = %v, %v; want nil, nil", entries, err)
+	}
+
+	when := time.Unix(1700000000, 0).In(time.FixedZone("", -7*3600))
+	want := ReflogEntry{
+		New:     objects.ObjectID{1},
+		Who:     objects.Signature{Name: "A U Thor", Email: "author@example.com", When: when},
+		Message: "commit (initial): first\nwith more",
+	}
+	if err := rm.AppendReflog("refs/heads/main", want); err != nil {
+		t.Fatalf("AppendReflog() error = %v", err)
+	}
+	if err := rm.AppendReflog("refs/heads/main", ReflogEntry{Old: objects.ObjectID{1}, New: objects.ObjectID{2}, Who: want.Who}); err != nil {
+		t.Fatalf("AppendReflog() error = %v", err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(rm.gitDir, "logs", "refs", "heads", "main"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), "A U Thor <author@example.com> 1700000000 -0700\tcommit (initial): first with more\n") {
+		t.Errorf("reflog = %q", data)
+	}
+
+	entries, err = rm.ReadReflog("refs/heads/main")
+	if err != nil {
+		t.Fatalf("ReadReflog() error = %v", err)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("ReadReflog() = %d entries, want 2", len(entries))
+	}
+	got := entries[0]
+	if got.Old != want.Old || got.New != want.New || got.Who.Name != want.Who.Name ||
+		got.Who.Email != want.Who.Email || !got.Who.When.Equal(when) || got.Message != "commit (initial): first with more" {
+		t.Errorf("entries[0] = %+v, want %+v", got, want)
+	}
+	if _, offset := got.Who.When.Zone(); offset != -7*3600 {
+		t.Errorf("entries[0] zone offset = %d, want %d", offset, -7*3600)
+	}
+	if entries[1].Old != (objects.ObjectID{1}) || entries[1].Message != "" {
+		t.Errorf("entries[1] = %+v", entries[1])
+	}
+}
+
+func TestRefManager_LogUpdate(t *testing.T) {
+	rm := newReflogTestManager(t)
+	if err := rm.SetHEAD("refs/heads/main"); err != nil {
+		t.Fatal(err)
+	}
+	who := objects.Signature{Name: "Test", Email: "test@example.com", When: time.Unix(1700000000, 0)}
+
+	if err := rm.LogUpdate("refs/heads/main", objects.ObjectID{}, objects.ObjectID{1}, who, "commit"); err != nil {
+		t.Fatalf("LogUpdate() error = %v", err)
+	}
+	if err := rm.LogUpdate("refs/heads/topic", objects.ObjectID{}, objects.ObjectID{2}, who, "branch"); err != nil {
+		t.Fatalf("LogUpdate() error = %v", err)
+	}
+
+	head, err := rm.ReadReflog("HEAD")
+	if err != nil {
+		t.Fatalf("ReadReflog() error = %v", err)
+	}
+	if len(head) != 1 || head[0].New != (objects.ObjectID{1}) {
+		t.Errorf("HEAD reflog = %+v, want only the update of the checked out branch", head)
+	}
+}
+
+func TestRefManager_ReflogAt(t *testing.T) {
+	rm := newReflogTestManager(t)
+	base := time.Unix(1700000000, 0)
+	add := func(from, to objects.ObjectID, at time.Time) {
+		entry := ReflogEntry{Old: from, New: to, Who: objects.Signature{Name: "Test", Email: "test@example.com", When: at}}
+		if err := rm.AppendReflog("refs/heads/main", entry); err != nil {
+			t.Fatal(err)
+		}
+	}
+	one, two, three := objects.ObjectID{1}, objects.ObjectID{2}, objects.ObjectID{3}
+	add(objects.ObjectID{}, one, base)
+	add(one, two, base.Add(time.Hour))
+	add(two, three, base.Add(2*time.Hour))
+
+	tests := []struct {
+		name string
+		at   time.Time
+		want objects.ObjectID
+	}{
+		{"at first entry", base, one},
+		{"between entries", base.Add(90 * time.Minute), two},
+		{"at an entry", base.Add(time.Hour), two},
+		{"after last entry", base.Add(24 * time.Hour), three},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := rm.ReflogAt("refs/heads/main", tt.at)
+			if err != nil {
+				t.Fatalf("ReflogAt() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("ReflogAt() = %s, want %s", got.Short(), tt.want.Short())
+			}
+		})
+	}
+
+	if _, err := rm.ReflogAt("refs/heads/main", base.Add(-time.Hour)); err == nil || !strings.Contains(err.Error(), "only goes back to") {
+		t.Errorf("ReflogAt() before the log error = %v", err)
+	}
+	if _, err := rm.ReflogAt("refs/heads/other", base); err == nil {
+		t.Error("ReflogAt() without a reflog should fail")
+	}
+}
+
+func TestRefManager_ExpandRef(t *testing.T) {
+	rm := newReflogTestManager(t)
+	if err := rm.UpdateRef("refs/heads/main", objects.ObjectID{1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := rm.UpdateRef("refs/remotes/origin/dev", objects.ObjectID{2}); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, want := range map[string]string{
+		"main":            "refs/heads/main",
+		"refs/heads/main": "refs/heads/main",
+		"origin/dev":      "refs/remotes/origin/dev",
+		"dev":             "refs/remotes/origin/dev",
+		"HEAD":            "HEAD",
+	} {
+		got, err := rm.ExpandRef(name)
+		if err != nil || got != want {
+			t.Errorf("ExpandRef(%q) = %q, %v; want %q", name, got, err, want)
+		}
+	}
+	if _, err := rm.ExpandRef("missing"); err == nil {
+		t.Error("ExpandRef() of a missing ref should fail")
+	}
+}
+
+func TestRefManager_WriteReflog(t *testing.T) {
+	rm := newReflogTestManager(t)
+	who := objects.Signature{Name: "Test", Email: "test@example.com", When: time.Unix(1700000000, 0)}
+	for i := byte(1); i <= 3; i++ {
+		if err := rm.AppendReflog("refs/stash", ReflogEntry{Old: objects.ObjectID{i - 1}, New: objects.ObjectID{i}, Who: who}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	entries, err := rm.ReadReflog("refs/stash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rm.WriteReflog("refs/stash", append(entries[:1], entries[2:]...)); err != nil {
+		t.Fatalf("WriteReflog() error = %v", err)
+	}
+	entries, err = rm.ReadReflog("refs/stash")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].New != (objects.ObjectID{1}) || entries[1].New != (objects.ObjectID{3}) {
+		t.Errorf("ReadReflog() after WriteReflog() = %+v", entries)
+	}
+
+	if err := rm.WriteReflog("refs/stash", nil); err != nil {
+		t.Fatalf("WriteReflog() error = %v", err)
+	}
+	if _, err := os.Stat(rm.reflogPath("refs/stash")); !os.IsNotExist(err) {
+		t.Errorf("reflog still exists after writing no entries: %v", err)
+	}
+}
@@ -2,60 +2,81 @@ package refs
 
 import (
 	"fmt"
-	"io"
-	"os"
-	"path/filepath"
 	"strings"
 
+	"github.com/fenilsonani/vcs/internal/core/gitdir"
 	"github.com/fenilsonani/vcs/internal/core/objects"
 )
 
-// RefManager manages Git references (branches, tags, HEAD)
+// RefManager manages Git references (branches, tags, HEAD), which it keeps
+// in a RefStore
 type RefManager struct {
-	gitDir string
+	gitDir    string
+	commonDir string
+	store     RefStore
 }
 
-// NewRefManager creates a new reference manager
+// NewRefManager creates a new reference manager, keeping the references
+// in the storage format extensions.refStorage selects
 func NewRefManager(gitDir string) *RefManager {
+	commonDir := gitdir.CommonDir(gitDir)
+	var store RefStore = newFilesStore(gitDir, commonDir)
+	if refStorageFormat(commonDir) == FormatReftable {
+		store = newReftableStore(gitDir, commonDir)
+	}
 	return &RefManager{
-		gitDir: gitDir,
+		gitDir:    gitDir,
+		commonDir: commonDir,
+		store:     store,
 	}
 }
 
+// Store returns the backend the references are kept in
+func (rm *RefManager) Store() RefStore {
+	return rm.store
+}
+
+// GitDir returns the repository directory the references live in
+func (rm *RefManager) GitDir() string {
+	return rm.gitDir
+}
+
+// CommonDir returns the directory of the references shared by all
+// worktrees, which is GitDir outside linked worktrees
+func (rm *RefManager) CommonDir() string {
+	return rm.commonDir
+}
+
 // HEAD returns the current HEAD reference
 func (rm *RefManager) HEAD() (objects.ObjectID, string, error) {
-	headPath := filepath.Join(rm.gitDir, "HEAD")
-	content, err := os.ReadFile(headPath)
+	head, err := rm.store.ReadRef("HEAD")
 	if err != nil {
 		return objects.ObjectID{}, "", fmt.Errorf("failed to read HEAD: %w", err)
 	}
 
-	headStr := strings.TrimSpace(string(content))
-	
 	// Check if HEAD points to a reference
-	if strings.HasPrefix(headStr, "ref: ") {
-		refName := strings.TrimPrefix(headStr, "ref: ")
-		id, err := rm.ResolveRef(refName)
-		return id, refName, err
+	if head.IsSymbolic() {
+		id, err := rm.ResolveRef(head.Target)
+		return id, head.Target, err
 	}
-	
+
 	// HEAD points directly to an object
-	id, err := objects.NewObjectID(headStr)
-	return id, "", err
+	return head.ID, "", nil
 }
 
 // SetHEAD sets the HEAD reference
 func (rm *RefManager) SetHEAD(refName string) error {
-	headPath := filepath.Join(rm.gitDir, "HEAD")
-	content := fmt.Sprintf("ref: %s\n", refName)
-	return os.WriteFile(headPath, []byte(content), 0644)
+	return rm.store.WriteRef(Ref{Name: "HEAD", Target: refName})
+}
+
+// SetSymbolicRef points refName at target, as HEAD points at a branch
+func (rm *RefManager) SetSymbolicRef(refName, target string) error {
+	return rm.store.WriteRef(Ref{Name: refName, Target: target})
 }
 
 // SetHEADToCommit sets HEAD to point directly to a commit
 func (rm *RefManager) SetHEADToCommit(commitID objects.ObjectID) error {
-	headPath := filepath.Join(rm.gitDir, "HEAD")
-	content := fmt.Sprintf("%s\n", commitID.String())
-	return os.WriteFile(headPath, []byte(content), 0644)
+	return rm.store.WriteRef(Ref{Name: "HEAD", ID: commitID})
 }
 
 // ResolveRef resolves a reference name to an object ID
@@ -81,79 +102,116 @@ func (rm *RefManager) ResolveRef(refName string) (objects.ObjectID, error) {
 		}
 	}
 	
-	return objects.ObjectID{}, fmt.Errorf("reference not found: %s", refName)
+	return objects.ObjectID{}, fmt.Errorf("%w: %s", ErrRefNotFound, refName)
 }
 
-// readRefFile reads a reference file and returns the object ID
-func (rm *RefManager) readRefFile(refName string) (objects.ObjectID, error) {
-	refPath := filepath.Join(rm.gitDir, refName)
-	content, err := os.ReadFile(refPath)
-	if err != nil {
-		return objects.ObjectID{}, err
+// ExpandRef returns the full name of the reference ResolveRef would find for
+// refName, such as refs/heads/main for main
+func (rm *RefManager) ExpandRef(refName string) (string, error) {
+	if refName == "HEAD" {
+		return refName, nil
 	}
-	
-	refStr := strings.TrimSpace(string(content))
-	
-	// Handle symbolic references
-	if strings.HasPrefix(refStr, "ref: ") {
-		targetRef := strings.TrimPrefix(refStr, "ref: ")
-		return rm.ResolveRef(targetRef)
+	for _, prefix := range []string{"", "refs/", "refs/heads/", "refs/tags/", "refs/remotes/", "refs/remotes/origin/"} {
+		if _, err := rm.readRefFile(prefix + refName); err == nil {
+			return prefix + refName, nil
+		}
 	}
-	
-	// Direct object reference
-	return objects.NewObjectID(refStr)
+	return "", fmt.Errorf("reference not found: %s", refName)
+}
+
+// readRefFile returns the object refName points to, following symbolic
+// references
+func (rm *RefManager) readRefFile(refName string) (objects.ObjectID, error) {
+	for i := 0; i < maxSymrefDepth; i++ {
+		ref, err := rm.store.ReadRef(refName)
+		if err != nil {
+			return objects.ObjectID{}, err
+		}
+		if !ref.IsSymbolic() {
+			return ref.ID, nil
+		}
+		refName = ref.Target
+	}
+	return objects.ObjectID{}, fmt.Errorf("symbolic ref loop at %s", refName)
 }
 
 // UpdateRef updates a reference to point to an object
 func (rm *RefManager) UpdateRef(refName string, id objects.ObjectID) error {
-	refPath := filepath.Join(rm.gitDir, refName)
-	
-	// Ensure directory exists
-	if err := os.MkdirAll(filepath.Dir(refPath), 0755); err != nil {
-		return fmt.Errorf("failed to create ref directory: %w", err)
+	if err := checkRefName(refName); err != nil {
+		return err
 	}
-	
-	content := fmt.Sprintf("%s\n", id.String())
-	return os.WriteFile(refPath, []byte(content), 0644)
+	return rm.store.WriteRef(Ref{Name: refName, ID: id})
 }
 
 // ListBranches returns all local branches
 func (rm *RefManager) ListBranches() ([]string, error) {
-	branchesDir := filepath.Join(rm.gitDir, "refs", "heads")
-	return rm.listRefs(branchesDir, "refs/heads/")
+	return rm.listRefs("refs/heads/")
 }
 
 // ListTags returns all tags
 func (rm *RefManager) ListTags() ([]string, error) {
-	tagsDir := filepath.Join(rm.gitDir, "refs", "tags")
-	return rm.listRefs(tagsDir, "refs/tags/")
+	return rm.listRefs("refs/tags/")
 }
 
-// listRefs lists all references in a directory
-func (rm *RefManager) listRefs(dir, prefix string) ([]string, error) {
-	var refs []string
-	
-	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+// ReplacePrefix starts the names of replacement refs, each named after the
+// object it replaces
+const ReplacePrefix = "refs/replace/"
+
+// ReplaceRefs returns the replacement refs as a map from each replaced
+// object to its replacement. Refs not named after an object are ignored.
+func (rm *RefManager) ReplaceRefs() (map[objects.ObjectID]objects.ObjectID, error) {
+	all, err := rm.refValues(ReplacePrefix)
+	if err != nil {
+		return nil, err
+	}
+
+	replacements := make(map[objects.ObjectID]objects.ObjectID, len(all))
+	for name, id := range all {
+		replaced, err := objects.NewObjectID(strings.TrimPrefix(name, ReplacePrefix))
 		if err != nil {
-			// Ignore missing directories
-			if os.IsNotExist(err) {
-				return nil
-			}
-			return err
+			continue
 		}
-		
-		if !info.IsDir() {
-			relPath, err := filepath.Rel(dir, path)
-			if err != nil {
-				return err
-			}
-			refs = append(refs, prefix+filepath.ToSlash(relPath))
+		replacements[replaced] = id
+	}
+	return replacements, nil
+}
+
+// listRefs returns the names of the references starting with prefix,
+// loose and packed, in order
+func (rm *RefManager) listRefs(prefix string) ([]string, error) {
+	refs, err := rm.store.ListRefs(prefix)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(refs))
+	for i, ref := range refs {
+		names[i] = ref.Name
+	}
+	return names, nil
+}
+
+// refValues returns the references starting with prefix with the objects
+// they point to. Symbolic references that do not resolve are left out.
+func (rm *RefManager) refValues(prefix string) (map[string]objects.ObjectID, error) {
+	refs, err := rm.store.ListRefs(prefix)
+	if err != nil {
+		return nil, err
+	}
+	all := make(map[string]objects.ObjectID, len(refs))
+	for _, ref := range refs {
+		if !ref.IsSymbolic() {
+			all[ref.Name] = ref.ID
+		} else if id, err := rm.readRefFile(ref.Target); err == nil {
+			all[ref.Name] = id
 		}
-		
-		return nil
-	})
-	
-	return refs, err
+	}
+	return all, nil
+}
+
+// AllRefs returns every reference under refs/ with the object it points to.
+// Loose references take precedence over packed ones.
+func (rm *RefManager) AllRefs() (map[string]objects.ObjectID, error) {
+	return rm.refValues("refs/")
 }
 
 // CreateBranch creates a new branch pointing to the given commit
@@ -164,11 +222,20 @@ func (rm *RefManager) CreateBranch(branchName string, commitID objects.ObjectID)
 
 // DeleteBranch deletes a branch
 func (rm *RefManager) DeleteBranch(branchName string) error {
-	refPath := filepath.Join(rm.gitDir, "refs", "heads", branchName)
-	err := os.Remove(refPath)
-	if os.IsNotExist(err) {
+	existed, err := rm.store.DeleteRef("refs/heads/" + branchName)
+	if err != nil {
+		return err
+	}
+	if !existed {
 		return fmt.Errorf("branch does not exist: %s", branchName)
 	}
+	return nil
+}
+
+// DeleteRef removes refName, loose or packed. Removing a reference that does
+// not exist is not an error.
+func (rm *RefManager) DeleteRef(refName string) error {
+	_, err := rm.store.DeleteRef(refName)
 	return err
 }
 
@@ -180,12 +247,24 @@ func (rm *RefManager) CreateTag(tagName string, objectID objects.ObjectID) error
 
 // DeleteTag deletes a tag
 func (rm *RefManager) DeleteTag(tagName string) error {
-	refPath := filepath.Join(rm.gitDir, "refs", "tags", tagName)
-	err := os.Remove(refPath)
-	if os.IsNotExist(err) {
+	existed, err := rm.store.DeleteRef("refs/tags/" + tagName)
+	if err != nil {
+		return err
+	}
+	if !existed {
 		return fmt.Errorf("tag does not exist: %s", tagName)
 	}
-	return err
+	return nil
+}
+
+// SymbolicHEAD returns the ref HEAD points to without resolving it, so it
+// also works on unborn branches. It returns "" when HEAD is detached.
+func (rm *RefManager) SymbolicHEAD() (string, error) {
+	head, err := rm.store.ReadRef("HEAD")
+	if err != nil {
+		return "", fmt.Errorf("failed to read HEAD: %w", err)
+	}
+	return head.Target, nil
 }
 
 // CurrentBranch returns the current branch name
@@ -240,85 +319,38 @@ func (rm *RefManager) RefExists(refName string) bool {
 	return err == nil
 }
 
-// WriteRef writes a reference with locking
+// WriteRef writes a reference with locking, failing when oldID is given
+// and the reference exists with another value
 func (rm *RefManager) WriteRef(refName string, id objects.ObjectID, oldID *objects.ObjectID) error {
-	refPath := filepath.Join(rm.gitDir, refName)
-	lockPath := refPath + ".lock"
-	
-	// Ensure directory exists before creating lock file
-	if err := os.MkdirAll(filepath.Dir(refPath), 0755); err != nil {
-		return fmt.Errorf("failed to create ref directory: %w", err)
+	tx := rm.NewTransaction()
+	update := &RefUpdate{Name: refName, NewID: id, NoDeref: true}
+	tx.updates = append(tx.updates, update)
+	if err := tx.Prepare(); err != nil {
+		return err
 	}
-	
-	// Create lock file
-	lockFile, err := os.OpenFile(lockPath, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
-	if err != nil {
-		return fmt.Errorf("failed to acquire lock: %w", err)
-	}
-	defer os.Remove(lockPath)
-	defer lockFile.Close()
-	
-	// Verify old value if specified
-	if oldID != nil {
-		currentID, err := rm.readRefFile(refName)
-		if err == nil && currentID != *oldID {
-			return fmt.Errorf("reference has changed")
-		}
-	}
-	
-	// Write new value to lock file
-	content := fmt.Sprintf("%s\n", id.String())
-	if _, err := lockFile.WriteString(content); err != nil {
-		return fmt.Errorf("failed to write lock file: %w", err)
+	if oldID != nil && update.exists && update.Previous != *oldID {
+		tx.Abort()
+		return fmt.Errorf("reference has changed")
 	}
-	
-	if err := lockFile.Sync(); err != nil {
-		return fmt.Errorf("failed to sync lock file: %w", err)
+	return tx.Commit()
+}
+
+// ReadPackedRefs reads the packed-refs file. A backend without one has no
+// packed references.
+func (rm *RefManager) ReadPackedRefs() (*PackedRefs, error) {
+	if files, ok := rm.store.(*filesStore); ok {
+		return files.readPackedRefs()
 	}
-	
-	lockFile.Close()
-	
-	// Atomically rename lock file to reference file
-	return os.Rename(lockPath, refPath)
+	return &PackedRefs{refs: make(map[string]objects.ObjectID)}, nil
 }
 
-// PackedRefs represents packed references
-type PackedRefs struct {
-	refs map[string]objects.ObjectID
+// PackRefs moves every non-symbolic reference into packed-refs and removes
+// the loose files, as pack-refs --all does
+func (rm *RefManager) PackRefs() (int, error) {
+	return rm.store.PackRefs(PackOptions{All: true, Prune: true})
 }
 
-// ReadPackedRefs reads the packed-refs file
-func (rm *RefManager) ReadPackedRefs() (*PackedRefs, error) {
-	packedPath := filepath.Join(rm.gitDir, "packed-refs")
-	file, err := os.Open(packedPath)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return &PackedRefs{refs: make(map[string]objects.ObjectID)}, nil
-		}
-		return nil, err
-	}
-	defer file.Close()
-	
-	refs := make(map[string]objects.ObjectID)
-	content, err := io.ReadAll(file)
-	if err != nil {
-		return nil, err
-	}
-	
-	lines := strings.Split(string(content), "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-		
-		parts := strings.Fields(line)
-		if len(parts) >= 2 {
-			if id, err := objects.NewObjectID(parts[0]); err == nil {
-				refs[parts[1]] = id
-			}
-		}
-	}
-	
-	return &PackedRefs{refs: refs}, nil
-}
\ No newline at end of file
+// Pack packs the references opts selects
+func (rm *RefManager) Pack(opts PackOptions) (int, error) {
+	return rm.store.PackRefs(opts)
+}
@@ -300,6 +300,34 @@ func TestRefManager_DeleteBranch(t *testing.T) {
 	}
 }
 
+func TestRefManager_DeleteRef(t *testing.T) {
+	gitDir := filepath.Join(t.TempDir(), ".git")
+	rm := NewRefManager(gitDir)
+	commitID, _ := objects.NewObjectID("a94a8fe5ccb19ba61c4c0873d391e987982fbbd3")
+
+	if err := rm.UpdateRef("refs/notes/loose", commitID); err != nil {
+		t.Fatal(err)
+	}
+	if err := rm.UpdateRef("refs/notes/packed", commitID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rm.PackRefs(); err != nil {
+		t.Fatal(err)
+	}
+	if err := rm.UpdateRef("refs/notes/loose", commitID); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, name := range []string{"refs/notes/loose", "refs/notes/packed", "refs/notes/missing"} {
+		if err := rm.DeleteRef(name); err != nil {
+			t.Fatalf("DeleteRef(%s) error = %v", name, err)
+		}
+		if rm.RefExists(name) {
+			t.Errorf("%s still exists", name)
+		}
+	}
+}
+
 func TestRefManager_CreateTag(t *testing.T) {
 	tmpDir, err := os.MkdirTemp("", "refs-test-*")
 	if err != nil {
@@ -662,4 +690,127 @@ c94a8fe5ccb19ba61c4c0873d391e987982fbbd5 refs/remotes/origin/main
 			t.Errorf("Ref %s ID = %v, want %v", refName, id.String(), expectedIDStr)
 		}
 	}
-}
\ No newline at end of file
+}
+func TestRefManager_SymbolicHEAD(t *testing.T) {
+	tmpDir := t.TempDir()
+	rm := NewRefManager(tmpDir)
+
+	// Unborn branch: HEAD names a ref that does not exist yet
+	if err := rm.SetHEAD("refs/heads/gh-pages"); err != nil {
+		t.Fatalf("SetHEAD() error = %v", err)
+	}
+	refName, err := rm.SymbolicHEAD()
+	if err != nil {
+		t.Fatalf("SymbolicHEAD() error = %v", err)
+	}
+	if refName != "refs/heads/gh-pages" {
+		t.Errorf("SymbolicHEAD() = %q, want %q", refName, "refs/heads/gh-pages")
+	}
+
+	// Detached HEAD
+	commitID, _ := objects.NewObjectID("a94a8fe5ccb19ba61c4c0873d391e987982fbbd3")
+	if err := rm.SetHEADToCommit(commitID); err != nil {
+		t.Fatalf("SetHEADToCommit() error = %v", err)
+	}
+	refName, err = rm.SymbolicHEAD()
+	if err != nil {
+		t.Fatalf("SymbolicHEAD() error = %v", err)
+	}
+	if refName != "" {
+		t.Errorf("SymbolicHEAD() = %q for detached HEAD, want empty", refName)
+	}
+}
+
+func TestRefManager_PackRefs(t *testing.T) {
+	tmpDir := t.TempDir()
+	rm := NewRefManager(tmpDir)
+
+	mainID, _ := objects.NewObjectID("a94a8fe5ccb19ba61c4c0873d391e987982fbbd3")
+	tagID, _ := objects.NewObjectID("b94a8fe5ccb19ba61c4c0873d391e987982fbbd3")
+	if err := rm.CreateBranch("feature/login", mainID); err != nil {
+		t.Fatalf("CreateBranch() error = %v", err)
+	}
+	if err := rm.CreateTag("v1.0", tagID); err != nil {
+		t.Fatalf("CreateTag() error = %v", err)
+	}
+	if err := rm.UpdateRef("refs/remotes/origin/main", mainID); err != nil {
+		t.Fatalf("UpdateRef() error = %v", err)
+	}
+
+	packed, err := rm.PackRefs()
+	if err != nil {
+		t.Fatalf("PackRefs() error = %v", err)
+	}
+	if packed != 3 {
+		t.Errorf("PackRefs() = %d, want 3", packed)
+	}
+
+	// Loose files and their emptied directories are gone
+	for _, path := range []string{"refs/heads/feature", "refs/tags/v1.0", "refs/remotes"} {
+		if _, err := os.Stat(filepath.Join(tmpDir, path)); !os.IsNotExist(err) {
+			t.Errorf("%s still exists after PackRefs()", path)
+		}
+	}
+
+	// Packed refs still resolve and list
+	if id, err := rm.ResolveRef("feature/login"); err != nil || id != mainID {
+		t.Errorf("ResolveRef() = %v, %v, want %v", id, err, mainID)
+	}
+	branches, err := rm.ListBranches()
+	if err != nil || len(branches) != 1 || branches[0] != "refs/heads/feature/login" {
+		t.Errorf("ListBranches() = %v, %v", branches, err)
+	}
+	all, err := rm.AllRefs()
+	if err != nil || len(all) != 3 || all["refs/tags/v1.0"] != tagID {
+		t.Errorf("AllRefs() = %v, %v", all, err)
+	}
+
+	// A loose update takes precedence over the packed value
+	if err := rm.UpdateRef("refs/heads/feature/login", tagID); err != nil {
+		t.Fatalf("UpdateRef() error = %v", err)
+	}
+	if id, _ := rm.ResolveRef("refs/heads/feature/login"); id != tagID {
+		t.Errorf("ResolveRef() = %v, want loose value %v", id, tagID)
+	}
+
+	// Deleting removes both the loose and the packed entry
+	if err := rm.DeleteBranch("feature/login"); err != nil {
+		t.Fatalf("DeleteBranch() error = %v", err)
+	}
+	if rm.RefExists("refs/heads/feature/login") {
+		t.Errorf("branch still exists after DeleteBranch()")
+	}
+	if err := rm.DeleteTag("v1.0"); err != nil {
+		t.Fatalf("DeleteTag() error = %v", err)
+	}
+	if err := rm.DeleteTag("v1.0"); err == nil {
+		t.Errorf("DeleteTag() expected error for missing tag")
+	}
+}
+
+func TestRefManager_ReplaceRefs(t *testing.T) {
+	rm := NewRefManager(t.TempDir())
+
+	replaced, _ := objects.NewObjectID("a94a8fe5ccb19ba61c4c0873d391e987982fbbd3")
+	packed, _ := objects.NewObjectID("c94a8fe5ccb19ba61c4c0873d391e987982fbbd3")
+	replacement, _ := objects.NewObjectID("b94a8fe5ccb19ba61c4c0873d391e987982fbbd3")
+	if err := rm.UpdateRef(ReplacePrefix+packed.String(), replacement); err != nil {
+		t.Fatalf("UpdateRef() error = %v", err)
+	}
+	if _, err := rm.PackRefs(); err != nil {
+		t.Fatalf("PackRefs() error = %v", err)
+	}
+	for _, name := range []string{ReplacePrefix + replaced.String(), ReplacePrefix + "not-an-object"} {
+		if err := rm.UpdateRef(name, replacement); err != nil {
+			t.Fatalf("UpdateRef() error = %v", err)
+		}
+	}
+
+	replacements, err := rm.ReplaceRefs()
+	if err != nil {
+		t.Fatalf("ReplaceRefs() error = %v", err)
+	}
+	if len(replacements) != 2 || replacements[replaced] != replacement || replacements[packed] != replacement {
+		t.Errorf("ReplaceRefs() = %v", replacements)
+	}
+}
@@ -0,0 +1,416 @@
+package refs
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/fenilsonani/vcs/internal/core/config"
+	"github.com/fenilsonani/vcs/internal/core/gitdir"
+	"github.com/fenilsonani/vcs/internal/core/reftable"
+)
+
+// The ref storage formats, as extensions.refStorage names them
+const (
+	FormatFiles    = "files"
+	FormatReftable = "reftable"
+)
+
+// reftableStubHead is the HEAD file of a reftable repository, and
+// reftableStubRefs the content of its refs/heads file. Both are only there
+// so that tools reading refs as files see a broken repository rather than
+// an empty one; the refs are in the reftable directory.
+const (
+	reftableStubHead = "ref: refs/heads/.invalid\n"
+	reftableStubRefs = "this repository uses the reftable format\n"
+)
+
+// refStorageFormat returns the ref storage format the config of the
+// repository at commonDir selects
+func refStorageFormat(commonDir string) string {
+	f, err := config.ReadFile(filepath.Join(commonDir, "config"))
+	if err != nil {
+		return FormatFiles
+	}
+	if format, ok := f.Get("extensions.refStorage"); ok && strings.EqualFold(format, FormatReftable) {
+		return FormatReftable
+	}
+	return FormatFiles
+}
+
+// reftableStore keeps refs in the stack of reftables in the reftable
+// directory, so that a lookup is a binary search however many refs there
+// are and a transaction is one table added atomically. In a linked
+// worktree the per-worktree refs have a stack in the worktree's directory.
+// FETCH_HEAD and MERGE_HEAD, which hold more than a ref, stay files, and
+// other refs outside refs/ that are not in a table are read from files
+// for the commands that still write them directly.
+type reftableStore struct {
+	gitDir    string
+	commonDir string
+	files     *filesStore
+
+	// mu guards the stacks, opened on first use, and the locks a
+	// prepared transaction holds on them
+	mu     sync.Mutex
+	stacks map[string]*reftable.Stack
+	locks  map[string]*reftable.Lock
+}
+
+// newReftableStore returns the reftable backend of the repository at gitDir
+func newReftableStore(gitDir, commonDir string) *reftableStore {
+	return &reftableStore{
+		gitDir:    gitDir,
+		commonDir: commonDir,
+		files:     newFilesStore(gitDir, commonDir),
+		stacks:    make(map[string]*reftable.Stack),
+		locks:     make(map[string]*reftable.Lock),
+	}
+}
+
+// isFileRef reports whether name is kept as a file in a reftable repository
+func isFileRef(name string) bool {
+	return name == "FETCH_HEAD" || name == "MERGE_HEAD"
+}
+
+// stackDir returns the directory of the stack holding name
+func (s *reftableStore) stackDir(name string) string {
+	if s.gitDir != s.commonDir && gitdir.IsPerWorktreeRef(name) {
+		return filepath.Join(s.gitDir, "reftable")
+	}
+	return filepath.Join(s.commonDir, "reftable")
+}
+
+// stack returns the stack in dir, opening it on first use. The caller
+// holds s.mu.
+func (s *reftableStore) stack(dir string) (*reftable.Stack, error) {
+	if stack, ok := s.stacks[dir]; ok {
+		return stack, nil
+	}
+	if err := reftable.Init(dir); err != nil {
+		return nil, err
+	}
+	stack, err := reftable.OpenStack(dir)
+	if err != nil {
+		return nil, err
+	}
+	s.stacks[dir] = stack
+	return stack, nil
+}
+
+// recordRef converts a table record to a Ref
+func recordRef(rec reftable.RefRecord) Ref {
+	if rec.Type == reftable.ValueSymref {
+		return Ref{Name: rec.Name, Target: rec.Target}
+	}
+	return Ref{Name: rec.Name, ID: rec.ID}
+}
+
+// refRecord converts a Ref to a table record
+func refRecord(ref Ref) reftable.RefRecord {
+	if ref.IsSymbolic() {
+		return reftable.RefRecord{Name: ref.Name, Type: reftable.ValueSymref, Target: ref.Target}
+	}
+	return reftable.RefRecord{Name: ref.Name, Type: reftable.ValueID, ID: ref.ID}
+}
+
+// Format returns FormatReftable
+func (s *reftableStore) Format() string {
+	return FormatReftable
+}
+
+// ReadRef looks name up in its stack
+func (s *reftableStore) ReadRef(name string) (Ref, error) {
+	if isFileRef(name) {
+		return s.files.ReadRef(name)
+	}
+	s.mu.Lock()
+	stack, err := s.stack(s.stackDir(name))
+	var rec reftable.RefRecord
+	var ok bool
+	if err == nil {
+		rec, ok, err = stack.Get(name)
+	}
+	s.mu.Unlock()
+	if err != nil {
+		return Ref{}, fmt.Errorf("failed to read %s: %w", name, err)
+	}
+	if ok {
+		return recordRef(rec), nil
+	}
+	if name != "HEAD" && !strings.HasPrefix(name, "refs/") {
+		return s.files.ReadRef(name)
+	}
+	return Ref{}, fmt.Errorf("%w: %s", ErrRefNotFound, name)
+}
+
+// ListRefs iterates over the refs of the common stack from prefix
+func (s *reftableStore) ListRefs(prefix string) ([]Ref, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stack, err := s.stack(filepath.Join(s.commonDir, "reftable"))
+	if err != nil {
+		return nil, err
+	}
+	it, err := stack.Seek(prefix)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list refs: %w", err)
+	}
+	var refs []Ref
+	for {
+		rec, ok, err := it.Next()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list refs: %w", err)
+		}
+		if !ok || !strings.HasPrefix(rec.Name, prefix) {
+			return refs, nil
+		}
+		refs = append(refs, recordRef(rec))
+	}
+}
+
+// WriteRef adds a table holding ref to its stack
+func (s *reftableStore) WriteRef(ref Ref) error {
+	if isFileRef(ref.Name) {
+		return s.files.WriteRef(ref)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stack, err := s.stack(s.stackDir(ref.Name))
+	if err != nil {
+		return err
+	}
+	lock, err := stack.Lock()
+	if err != nil {
+		return fmt.Errorf("cannot lock ref '%s': %w", ref.Name, err)
+	}
+	return lock.Add([]reftable.RefRecord{refRecord(ref)})
+}
+
+// DeleteRef adds a table recording the deletion of name, and removes its
+// file when it has one instead
+func (s *reftableStore) DeleteRef(name string) (bool, error) {
+	if isFileRef(name) {
+		return s.files.DeleteRef(name)
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	stack, err := s.stack(s.stackDir(name))
+	if err != nil {
+		return false, err
+	}
+	lock, err := stack.Lock()
+	if err != nil {
+		return false, fmt.Errorf("cannot lock ref '%s': %w", name, err)
+	}
+	_, ok, err := stack.Get(name)
+	if err != nil || !ok {
+		lock.Unlock()
+		if err == nil && !strings.HasPrefix(name, "refs/") {
+			return s.files.DeleteRef(name)
+		}
+		return false, err
+	}
+	if err := lock.Add([]reftable.RefRecord{{Name: name, Type: reftable.ValueDeletion}}); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// splitUpdates separates the updates of refs kept as files from those of
+// refs in stacks, grouped by stack directory
+func (s *reftableStore) splitUpdates(updates []*RefUpdate) ([]*RefUpdate, map[string][]*RefUpdate) {
+	var files []*RefUpdate
+	stacks := make(map[string][]*RefUpdate)
+	for _, u := range updates {
+		if isFileRef(u.Ref) {
+			files = append(files, u)
+			continue
+		}
+		dir := s.stackDir(u.Ref)
+		stacks[dir] = append(stacks[dir], u)
+	}
+	return files, stacks
+}
+
+// PrepareUpdates locks the stacks of the updates, which keeps any other
+// writer from adding a table until the updates are committed or aborted,
+// and reads the refs' values from them
+func (s *reftableStore) PrepareUpdates(updates []*RefUpdate) error {
+	files, stacks := s.splitUpdates(updates)
+	if err := s.files.PrepareUpdates(files); err != nil {
+		return err
+	}
+
+	dirs := make([]string, 0, len(stacks))
+	for dir := range stacks {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, dir := range dirs {
+		stack, err := s.stack(dir)
+		if err != nil {
+			return err
+		}
+		lock, err := stack.Lock()
+		if err != nil {
+			return fmt.Errorf("cannot lock ref '%s': %w", stacks[dir][0].Ref, err)
+		}
+		s.locks[dir] = lock
+		for _, u := range stacks[dir] {
+			rec, ok, err := stack.Get(u.Ref)
+			if err != nil {
+				return fmt.Errorf("cannot lock ref '%s': %w", u.Ref, err)
+			}
+			if ok {
+				u.Previous, u.exists = rec.ID, true
+			}
+		}
+	}
+	return nil
+}
+
+// CommitUpdates adds a table with the updates to each stack locked
+func (s *reftableStore) CommitUpdates(updates []*RefUpdate) error {
+	defer s.AbortUpdates(updates)
+	files, stacks := s.splitUpdates(updates)
+
+	s.mu.Lock()
+	for dir, stackUpdates := range stacks {
+		var records []reftable.RefRecord
+		for _, u := range stackUpdates {
+			switch {
+			case u.verify:
+			case u.delete:
+				if u.exists {
+					records = append(records, reftable.RefRecord{Name: u.Ref, Type: reftable.ValueDeletion})
+				}
+			default:
+				records = append(records, refRecord(Ref{Name: u.Ref, ID: u.NewID}))
+			}
+		}
+		lock := s.locks[dir]
+		delete(s.locks, dir)
+		if len(records) == 0 {
+			lock.Unlock()
+			continue
+		}
+		sort.Slice(records, func(i, j int) bool { return records[i].Name < records[j].Name })
+		if err := lock.Add(records); err != nil {
+			s.mu.Unlock()
+			return fmt.Errorf("failed to update refs: %w", err)
+		}
+	}
+	s.mu.Unlock()
+
+	return s.files.CommitUpdates(files)
+}
+
+// AbortUpdates releases the locks on the stacks and those of the refs kept
+// as files
+func (s *reftableStore) AbortUpdates(updates []*RefUpdate) {
+	files, _ := s.splitUpdates(updates)
+	s.files.AbortUpdates(files)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for dir, lock := range s.locks {
+		lock.Unlock()
+		delete(s.locks, dir)
+	}
+}
+
+// PackRefs merges the tables of each stack into one, returning how many
+// refs the common stack holds. Every ref is in a table, so opts has
+// nothing to select.
+func (s *reftableStore) PackRefs(opts PackOptions) (int, error) {
+	dirs := []string{filepath.Join(s.commonDir, "reftable")}
+	if s.gitDir != s.commonDir {
+		dirs = append(dirs, filepath.Join(s.gitDir, "reftable"))
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, dir := range dirs {
+		stack, err := s.stack(dir)
+		if err != nil {
+			return 0, err
+		}
+		lock, err := stack.Lock()
+		if err != nil {
+			return 0, fmt.Errorf("cannot pack refs: %w", err)
+		}
+		if err := lock.Compact(); err != nil {
+			return 0, fmt.Errorf("cannot pack refs: %w", err)
+		}
+	}
+
+	it, err := s.stacks[dirs[0]].Seek("")
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for {
+		_, ok, err := it.Next()
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			return count, nil
+		}
+		count++
+	}
+}
+
+// InitReftable switches the new repository at gitDir to the reftable
+// format: its HEAD moves to a table, and like Git it is left with a HEAD
+// and a refs/heads file that tools reading refs as files cannot use, and
+// with extensions.refStorage set, which older tools refuse to touch
+func InitReftable(gitDir string) error {
+	head, err := os.ReadFile(filepath.Join(gitDir, "HEAD"))
+	if err != nil {
+		return fmt.Errorf("failed to read HEAD: %w", err)
+	}
+	headRef, err := parseLooseRef("HEAD", head)
+	if err != nil {
+		return err
+	}
+
+	for _, dir := range []string{"refs/heads", "refs/tags"} {
+		if err := os.Remove(filepath.Join(gitDir, dir)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("repository already has refs: %w", err)
+		}
+	}
+	if err := os.MkdirAll(filepath.Join(gitDir, "refs"), 0755); err != nil {
+		return fmt.Errorf("failed to create refs directory: %w", err)
+	}
+
+	store := newReftableStore(gitDir, gitDir)
+	if err := store.WriteRef(headRef); err != nil {
+		return err
+	}
+	if err := os.WriteFile(filepath.Join(gitDir, "refs", "heads"), []byte(reftableStubRefs), 0644); err != nil {
+		return fmt.Errorf("failed to write refs/heads: %w", err)
+	}
+	if err := os.WriteFile(filepath.Join(gitDir, "HEAD"), []byte(reftableStubHead), 0644); err != nil {
+		return fmt.Errorf("failed to write HEAD: %w", err)
+	}
+
+	cfg, err := config.ReadFile(filepath.Join(gitDir, "config"))
+	if err != nil {
+		return err
+	}
+	if err := cfg.Set("core.repositoryformatversion", "1"); err != nil {
+		return err
+	}
+	if err := cfg.Set("extensions.refStorage", FormatReftable); err != nil {
+		return err
+	}
+	return cfg.Save()
+}
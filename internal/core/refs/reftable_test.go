@@ -0,0 +1,225 @@
+package refs
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/reftable"
+)
+
+func newReftableRepo(t *testing.T) (*RefManager, objects.ObjectID, objects.ObjectID) {
+	gitDir := filepath.Join(t.TempDir(), ".git")
+	for _, dir := range []string{"refs/heads", "refs/tags"} {
+		if err := os.MkdirAll(filepath.Join(gitDir, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(gitDir, "HEAD"), []byte("ref: refs/heads/main\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := InitReftable(gitDir); err != nil {
+		t.Fatalf("InitReftable() error = %v", err)
+	}
+	first, _ := objects.NewObjectID("a94a8fe5ccb19ba61c4c0873d391e987982fbbd3")
+	second, _ := objects.NewObjectID("b94a8fe5ccb19ba61c4c0873d391e987982fbbd3")
+	return NewRefManager(gitDir), first, second
+}
+
+func TestReftableStore_Init(t *testing.T) {
+	rm, _, _ := newReftableRepo(t)
+	if format := rm.Store().Format(); format != FormatReftable {
+		t.Fatalf("Format() = %s, want %s", format, FormatReftable)
+	}
+	if branch, err := rm.SymbolicHEAD(); err != nil || branch != "refs/heads/main" {
+		t.Errorf("SymbolicHEAD() = %s, %v", branch, err)
+	}
+	data, err := os.ReadFile(filepath.Join(rm.GitDir(), "HEAD"))
+	if err != nil || string(data) != reftableStubHead {
+		t.Errorf("HEAD file = %q, %v", data, err)
+	}
+	if info, err := os.Stat(filepath.Join(rm.GitDir(), "refs", "heads")); err != nil || info.IsDir() {
+		t.Errorf("refs/heads is not a file: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(rm.GitDir(), "reftable", reftable.ListFile)); err != nil {
+		t.Errorf("no %s: %v", reftable.ListFile, err)
+	}
+}
+
+func TestReftableStore_Refs(t *testing.T) {
+	rm, first, second := newReftableRepo(t)
+	if err := rm.CreateBranch("main", first); err != nil {
+		t.Fatalf("CreateBranch() error = %v", err)
+	}
+	if err := rm.CreateBranch("feature", second); err != nil {
+		t.Fatalf("CreateBranch() error = %v", err)
+	}
+	if err := rm.CreateTag("v1.0", first); err != nil {
+		t.Fatalf("CreateTag() error = %v", err)
+	}
+
+	if id, branch, err := rm.HEAD(); err != nil || id != first || branch != "refs/heads/main" {
+		t.Errorf("HEAD() = %v, %s, %v", id, branch, err)
+	}
+	if id, err := rm.ResolveRef("feature"); err != nil || id != second {
+		t.Errorf("ResolveRef(feature) = %v, %v", id, err)
+	}
+	branches, err := rm.ListBranches()
+	if err != nil || fmt.Sprint(branches) != "[refs/heads/feature refs/heads/main]" {
+		t.Errorf("ListBranches() = %v, %v", branches, err)
+	}
+	all, err := rm.AllRefs()
+	if err != nil || len(all) != 3 {
+		t.Errorf("AllRefs() = %v, %v", all, err)
+	}
+
+	if err := rm.DeleteBranch("feature"); err != nil {
+		t.Fatalf("DeleteBranch() error = %v", err)
+	}
+	if _, err := rm.ResolveRef("refs/heads/feature"); !errors.Is(err, ErrRefNotFound) {
+		t.Errorf("ResolveRef() of a deleted branch error = %v", err)
+	}
+	if err := rm.DeleteBranch("feature"); err == nil {
+		t.Error("DeleteBranch() of a missing branch succeeded")
+	}
+
+	// A RefManager opened later reads the same refs
+	other := NewRefManager(rm.GitDir())
+	if id, err := other.ResolveRef("refs/tags/v1.0"); err != nil || id != first {
+		t.Errorf("ResolveRef() from another manager = %v, %v", id, err)
+	}
+}
+
+func TestReftableStore_Transaction(t *testing.T) {
+	rm, first, second := newReftableRepo(t)
+	rm.UpdateRef("refs/heads/old", first)
+
+	tx := rm.NewTransaction()
+	tx.Create("refs/heads/main", first)
+	tx.Update("refs/tags/v1", second, nil)
+	tx.Delete("refs/heads/old", &first)
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+	if rm.RefExists("refs/heads/old") {
+		t.Error("refs/heads/old still exists after delete")
+	}
+	if id, err := rm.ResolveRef("refs/tags/v1"); err != nil || id != second {
+		t.Errorf("ResolveRef(refs/tags/v1) = %v, %v", id, err)
+	}
+
+	// A failed check leaves every ref as it was and the stack unlocked
+	tx = rm.NewTransaction()
+	tx.Update("refs/heads/main", second, &second)
+	tx.Create("refs/heads/new", second)
+	if err := tx.Commit(); err == nil {
+		t.Fatal("Commit() with a wrong old value succeeded")
+	}
+	if rm.RefExists("refs/heads/new") {
+		t.Error("refs/heads/new created by a failed transaction")
+	}
+	if locks := lockFiles(rm); len(locks) > 0 {
+		t.Errorf("lock files left: %v", locks)
+	}
+
+	// While a transaction is prepared other writers are locked out
+	tx = rm.NewTransaction()
+	tx.Update("refs/heads/main", second, &first)
+	if err := tx.Prepare(); err != nil {
+		t.Fatalf("Prepare() error = %v", err)
+	}
+	if err := NewRefManager(rm.GitDir()).UpdateRef("refs/heads/other", first); !errors.Is(err, reftable.ErrLocked) {
+		t.Errorf("UpdateRef() during a transaction error = %v, want ErrLocked", err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+	if id, _ := rm.ResolveRef("refs/heads/main"); id != second {
+		t.Errorf("refs/heads/main = %v, want %v", id, second)
+	}
+}
+
+func TestReftableStore_PseudoRefs(t *testing.T) {
+	rm, first, second := newReftableRepo(t)
+	if err := rm.UpdateRef("ORIG_HEAD", first); err != nil {
+		t.Fatalf("UpdateRef(ORIG_HEAD) error = %v", err)
+	}
+	if err := rm.UpdateRef("MERGE_HEAD", second); err != nil {
+		t.Fatalf("UpdateRef(MERGE_HEAD) error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(rm.GitDir(), "MERGE_HEAD")); err != nil {
+		t.Errorf("MERGE_HEAD is not a file: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(rm.GitDir(), "ORIG_HEAD")); !os.IsNotExist(err) {
+		t.Errorf("ORIG_HEAD written as a file")
+	}
+
+	// Refs outside refs/ written as files are still found
+	os.WriteFile(filepath.Join(rm.GitDir(), "CHERRY_PICK_HEAD"), []byte(first.String()+"\n"), 0644)
+	if id, err := rm.ResolveRef("CHERRY_PICK_HEAD"); err != nil || id != first {
+		t.Errorf("ResolveRef(CHERRY_PICK_HEAD) = %v, %v", id, err)
+	}
+	if err := rm.DeleteRef("CHERRY_PICK_HEAD"); err != nil {
+		t.Errorf("DeleteRef(CHERRY_PICK_HEAD) error = %v", err)
+	}
+	if rm.RefExists("CHERRY_PICK_HEAD") {
+		t.Error("CHERRY_PICK_HEAD still exists after delete")
+	}
+}
+
+func TestReftableStore_Worktree(t *testing.T) {
+	rm, first, second := newReftableRepo(t)
+	rm.CreateBranch("main", first)
+	wtDir := filepath.Join(rm.GitDir(), "worktrees", "wt")
+	if err := os.MkdirAll(wtDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	os.WriteFile(filepath.Join(wtDir, "commondir"), []byte("../..\n"), 0644)
+
+	wt := NewRefManager(wtDir)
+	if err := wt.SetHEADToCommit(second); err != nil {
+		t.Fatalf("SetHEADToCommit() error = %v", err)
+	}
+	if err := wt.UpdateRef("refs/bisect/bad", second); err != nil {
+		t.Fatalf("UpdateRef() error = %v", err)
+	}
+	if id, _, err := wt.HEAD(); err != nil || id != second {
+		t.Errorf("worktree HEAD() = %v, %v", id, err)
+	}
+	if id, _, err := rm.HEAD(); err != nil || id != first {
+		t.Errorf("main HEAD() = %v, %v", id, err)
+	}
+	if rm.RefExists("refs/bisect/bad") {
+		t.Error("per-worktree ref visible in the main worktree")
+	}
+	if id, err := wt.ResolveRef("refs/heads/main"); err != nil || id != first {
+		t.Errorf("worktree ResolveRef(main) = %v, %v", id, err)
+	}
+}
+
+func TestReftableStore_Pack(t *testing.T) {
+	rm, first, _ := newReftableRepo(t)
+	for i := 0; i < 20; i++ {
+		if err := rm.CreateTag(fmt.Sprintf("v%02d", i), first); err != nil {
+			t.Fatal(err)
+		}
+	}
+	packed, err := rm.PackRefs()
+	if err != nil || packed != 21 {
+		t.Fatalf("PackRefs() = %d, %v, want 21", packed, err)
+	}
+	stack, err := reftable.OpenStack(filepath.Join(rm.GitDir(), "reftable"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stack.Close()
+	if tables, _ := stack.Tables(); len(tables) != 1 {
+		t.Errorf("stack has %d tables after PackRefs()", len(tables))
+	}
+	if id, err := rm.ResolveRef("v07"); err != nil || id != first {
+		t.Errorf("ResolveRef(v07) = %v, %v", id, err)
+	}
+}
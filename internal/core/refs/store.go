@@ -0,0 +1,72 @@
+package refs
+
+import (
+	"errors"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+// ErrRefNotFound is returned for a ref that does not exist
+var ErrRefNotFound = errors.New("reference not found")
+
+// Ref is a ref as a RefStore keeps it: the object it points to or, for a
+// symbolic ref such as HEAD, the name of the ref it points to
+type Ref struct {
+	Name   string
+	ID     objects.ObjectID
+	Target string
+}
+
+// IsSymbolic reports whether the ref points to another ref
+func (r Ref) IsSymbolic() bool {
+	return r.Target != ""
+}
+
+// PackOptions selects the refs a RefStore packs
+type PackOptions struct {
+	// All packs every ref; otherwise only tags and refs already packed
+	// are, as branches are expected to move
+	All bool
+	// Prune removes the loose copies of the refs packed
+	Prune bool
+}
+
+// RefStore is where the refs of a repository are kept. RefManager builds
+// resolution, branches, tags and transactions on top of it, so that a
+// storage format only has to read, list and write refs and apply a set of
+// updates atomically.
+type RefStore interface {
+	// Format returns the name of the storage format, FormatFiles or
+	// FormatReftable
+	Format() string
+
+	// ReadRef returns name without following symbolic refs, or an error
+	// wrapping ErrRefNotFound
+	ReadRef(name string) (Ref, error)
+
+	// ListRefs returns the refs whose names start with prefix, which is
+	// under refs/, sorted by name
+	ListRefs(prefix string) ([]Ref, error)
+
+	// WriteRef stores ref, replacing any ref of the same name
+	WriteRef(ref Ref) error
+
+	// DeleteRef removes name and reports whether it existed
+	DeleteRef(name string) (bool, error)
+
+	// PrepareUpdates locks the refs of updates, whose Ref is set, and
+	// records their current values in Previous so that their old values
+	// can be checked. Nothing is visible to readers until CommitUpdates.
+	PrepareUpdates(updates []*RefUpdate) error
+
+	// CommitUpdates applies prepared updates and releases their locks
+	CommitUpdates(updates []*RefUpdate) error
+
+	// AbortUpdates releases the locks of prepared updates, leaving their
+	// refs as they were
+	AbortUpdates(updates []*RefUpdate)
+
+	// PackRefs compacts the storage of the refs selected by opts and
+	// returns how many were packed
+	PackRefs(opts PackOptions) (int, error)
+}
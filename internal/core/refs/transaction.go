@@ -0,0 +1,242 @@
+package refs
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+// maxSymrefDepth bounds the chain of symbolic refs followed to the ref an
+// update applies to
+const maxSymrefDepth = 5
+
+// ErrTransactionClosed is returned when a transaction is used after it was
+// committed or aborted
+var ErrTransactionClosed = errors.New("ref transaction is closed")
+
+// RefUpdate is one change queued in a Transaction
+type RefUpdate struct {
+	// Name is the ref as given
+	Name string
+	// NewID is what the ref is set to; ignored by deletes and verifies
+	NewID objects.ObjectID
+	// OldID, when not nil, is what the ref must point to for the
+	// transaction to go ahead; the zero ID requires it not to exist
+	OldID *objects.ObjectID
+	// NoDeref updates a symbolic ref itself rather than what it points to
+	NoDeref bool
+
+	delete bool
+	verify bool
+
+	// Ref is the ref the update applies to, Name with symbolic refs
+	// followed, and Previous what it pointed to, zero when it did not
+	// exist. Both are set once the transaction is prepared.
+	Ref      string
+	Previous objects.ObjectID
+
+	// exists records whether Ref existed when it was locked, and lockPath
+	// the lock file the files backend holds for it
+	exists   bool
+	lockPath string
+}
+
+// IsDelete reports whether the update deletes its ref
+func (u *RefUpdate) IsDelete() bool {
+	return u.delete
+}
+
+// IsVerify reports whether the update only checks the value of its ref
+func (u *RefUpdate) IsVerify() bool {
+	return u.verify
+}
+
+// Transaction updates several refs at once: either all of them change or
+// none does. Prepare has the RefStore lock every ref and checks the old
+// values; Commit then applies the updates and Abort drops them.
+type Transaction struct {
+	rm      *RefManager
+	updates []*RefUpdate
+	state   transactionState
+}
+
+// transactionState is how far a Transaction has got
+type transactionState int
+
+const (
+	transactionOpen transactionState = iota
+	transactionPrepared
+	transactionClosed
+)
+
+// NewTransaction starts a transaction on the refs of rm
+func (rm *RefManager) NewTransaction() *Transaction {
+	return &Transaction{rm: rm}
+}
+
+// Updates returns the updates queued, in order
+func (tx *Transaction) Updates() []*RefUpdate {
+	return tx.updates
+}
+
+// Update queues setting name to newID, when it points to oldID if that is
+// not nil
+func (tx *Transaction) Update(name string, newID objects.ObjectID, oldID *objects.ObjectID) (*RefUpdate, error) {
+	return tx.queue(&RefUpdate{Name: name, NewID: newID, OldID: oldID})
+}
+
+// Create queues creating name at newID, which fails if it exists
+func (tx *Transaction) Create(name string, newID objects.ObjectID) (*RefUpdate, error) {
+	return tx.queue(&RefUpdate{Name: name, NewID: newID, OldID: &objects.ObjectID{}})
+}
+
+// Delete queues deleting name, when it points to oldID if that is not nil
+func (tx *Transaction) Delete(name string, oldID *objects.ObjectID) (*RefUpdate, error) {
+	return tx.queue(&RefUpdate{Name: name, OldID: oldID, delete: true})
+}
+
+// Verify queues checking that name points to oldID, or does not exist when
+// oldID is zero, without changing it
+func (tx *Transaction) Verify(name string, oldID objects.ObjectID) (*RefUpdate, error) {
+	return tx.queue(&RefUpdate{Name: name, OldID: &oldID, verify: true})
+}
+
+func (tx *Transaction) queue(u *RefUpdate) (*RefUpdate, error) {
+	if tx.state != transactionOpen {
+		return nil, ErrTransactionClosed
+	}
+	if err := checkRefName(u.Name); err != nil {
+		return nil, err
+	}
+	if !u.delete && !u.verify && u.NewID.IsZero() {
+		return nil, fmt.Errorf("cannot update %s to the zero object", u.Name)
+	}
+	tx.updates = append(tx.updates, u)
+	return u, nil
+}
+
+// Prepare locks the refs of the transaction and checks their old values.
+// If any check fails nothing is left locked and the transaction is closed.
+func (tx *Transaction) Prepare() error {
+	switch tx.state {
+	case transactionPrepared:
+		return nil
+	case transactionClosed:
+		return ErrTransactionClosed
+	}
+
+	seen := make(map[string]bool)
+	for _, u := range tx.updates {
+		ref := u.Name
+		if !u.NoDeref {
+			var err error
+			if ref, err = tx.rm.followSymref(u.Name); err != nil {
+				tx.state = transactionClosed
+				return err
+			}
+		}
+		if seen[ref] {
+			tx.state = transactionClosed
+			return fmt.Errorf("multiple updates for ref '%s' not allowed", ref)
+		}
+		seen[ref] = true
+		u.Ref = ref
+	}
+
+	tx.state = transactionPrepared
+	if err := tx.rm.store.PrepareUpdates(tx.updates); err != nil {
+		tx.Abort()
+		return err
+	}
+	for _, u := range tx.updates {
+		if err := u.checkOld(); err != nil {
+			tx.Abort()
+			return err
+		}
+	}
+	return nil
+}
+
+// checkOld checks that the ref of u has the value it must have
+func (u *RefUpdate) checkOld() error {
+	switch {
+	case u.OldID == nil:
+	case u.OldID.IsZero() && u.exists:
+		return fmt.Errorf("cannot lock ref '%s': reference already exists", u.Ref)
+	case !u.OldID.IsZero() && !u.exists:
+		return fmt.Errorf("cannot lock ref '%s': unable to resolve reference '%s'", u.Ref, u.Ref)
+	case !u.OldID.IsZero() && u.Previous != *u.OldID:
+		return fmt.Errorf("cannot lock ref '%s': is at %s but expected %s", u.Ref, u.Previous, *u.OldID)
+	}
+	return nil
+}
+
+// Commit applies the transaction, preparing it first if needed
+func (tx *Transaction) Commit() error {
+	if err := tx.Prepare(); err != nil {
+		return err
+	}
+	if err := tx.rm.store.CommitUpdates(tx.updates); err != nil {
+		tx.Abort()
+		return err
+	}
+	tx.state = transactionClosed
+	return nil
+}
+
+// Abort releases the locks of the transaction, leaving its refs as they
+// were, and closes it
+func (tx *Transaction) Abort() {
+	if tx.state == transactionPrepared {
+		tx.rm.store.AbortUpdates(tx.updates)
+	}
+	tx.state = transactionClosed
+}
+
+// checkRefName checks that name can be updated: a ref under refs/ or a
+// pseudo-ref such as HEAD, made of valid components
+func checkRefName(name string) error {
+	invalid := name == "" || strings.HasSuffix(name, "/") || strings.HasSuffix(name, ".lock") ||
+		strings.Contains(name, "..") || strings.Contains(name, "//") || strings.Contains(name, "@{") ||
+		strings.ContainsAny(name, " ~^:?*[\\\x7f") || strings.HasPrefix(name, "/")
+	for _, c := range name {
+		invalid = invalid || c < ' '
+	}
+	if !invalid && !strings.HasPrefix(name, "refs/") {
+		invalid = strings.Trim(name, "ABCDEFGHIJKLMNOPQRSTUVWXYZ_") != ""
+	}
+	if invalid {
+		return fmt.Errorf("invalid ref name: %s", name)
+	}
+	return nil
+}
+
+// followSymref returns the ref name ends up at after following symbolic
+// refs, which may not exist yet
+func (rm *RefManager) followSymref(name string) (string, error) {
+	for i := 0; i < maxSymrefDepth; i++ {
+		target, ok, err := rm.SymbolicRef(name)
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			return name, nil
+		}
+		name = target
+	}
+	return "", fmt.Errorf("symbolic ref loop at %s", name)
+}
+
+// SymbolicRef returns the ref name points to when it is a symbolic ref
+func (rm *RefManager) SymbolicRef(name string) (string, bool, error) {
+	ref, err := rm.store.ReadRef(name)
+	if errors.Is(err, ErrRefNotFound) {
+		return "", false, nil
+	}
+	if err != nil {
+		return "", false, err
+	}
+	return ref.Target, ref.IsSymbolic(), nil
+}
@@ -0,0 +1,223 @@
+package refs
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+func newTransactionRepo(t *testing.T) (*RefManager, objects.ObjectID, objects.ObjectID) {
+	gitDir := filepath.Join(t.TempDir(), ".git")
+	if err := os.MkdirAll(gitDir, 0755); err != nil {
+		t.Fatalf("Failed to create git dir: %v", err)
+	}
+	rm := NewRefManager(gitDir)
+	if err := rm.SetHEAD("refs/heads/main"); err != nil {
+		t.Fatalf("SetHEAD() error = %v", err)
+	}
+	first, _ := objects.NewObjectID("a94a8fe5ccb19ba61c4c0873d391e987982fbbd3")
+	second, _ := objects.NewObjectID("b94a8fe5ccb19ba61c4c0873d391e987982fbbd3")
+	return rm, first, second
+}
+
+// lockFiles returns the lock files left below the refs of rm
+func lockFiles(rm *RefManager) []string {
+	var locks []string
+	filepath.Walk(rm.GitDir(), func(path string, info os.FileInfo, err error) error {
+		if err == nil && strings.HasSuffix(path, ".lock") {
+			locks = append(locks, path)
+		}
+		return nil
+	})
+	return locks
+}
+
+func TestTransaction_Commit(t *testing.T) {
+	rm, first, second := newTransactionRepo(t)
+	if err := rm.UpdateRef("refs/heads/old", first); err != nil {
+		t.Fatalf("UpdateRef() error = %v", err)
+	}
+
+	tx := rm.NewTransaction()
+	tx.Create("refs/heads/main", first)
+	tx.Update("refs/tags/v1", second, nil)
+	tx.Delete("refs/heads/old", &first)
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+
+	for ref, want := range map[string]objects.ObjectID{"refs/heads/main": first, "refs/tags/v1": second} {
+		if got, err := rm.ResolveRef(ref); err != nil || got != want {
+			t.Errorf("ResolveRef(%s) = %v, %v, want %v", ref, got, err, want)
+		}
+	}
+	if rm.RefExists("refs/heads/old") {
+		t.Errorf("refs/heads/old still exists after delete")
+	}
+	if locks := lockFiles(rm); len(locks) > 0 {
+		t.Errorf("lock files left behind: %v", locks)
+	}
+	if _, err := tx.Update("refs/heads/main", second, nil); err != ErrTransactionClosed {
+		t.Errorf("Update() after Commit() error = %v, want %v", err, ErrTransactionClosed)
+	}
+}
+
+func TestTransaction_OldValueMismatch(t *testing.T) {
+	rm, first, second := newTransactionRepo(t)
+	rm.UpdateRef("refs/heads/main", first)
+
+	tests := []struct {
+		name  string
+		queue func(tx *Transaction)
+		want  string
+	}{
+		{
+			name:  "wrong old value",
+			queue: func(tx *Transaction) { tx.Update("refs/heads/main", second, &second) },
+			want:  "but expected",
+		},
+		{
+			name:  "create existing ref",
+			queue: func(tx *Transaction) { tx.Create("refs/heads/main", second) },
+			want:  "reference already exists",
+		},
+		{
+			name:  "verify missing ref",
+			queue: func(tx *Transaction) { tx.Verify("refs/heads/missing", first) },
+			want:  "unable to resolve reference",
+		},
+		{
+			name: "same ref twice",
+			queue: func(tx *Transaction) {
+				tx.Update("HEAD", second, nil)
+				tx.Update("refs/heads/main", second, nil)
+			},
+			want: "multiple updates",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tx := rm.NewTransaction()
+			tx.Update("refs/heads/other", second, nil)
+			tt.queue(tx)
+			err := tx.Commit()
+			if err == nil || !strings.Contains(err.Error(), tt.want) {
+				t.Fatalf("Commit() error = %v, want one containing %q", err, tt.want)
+			}
+			if got, _ := rm.ResolveRef("refs/heads/main"); got != first {
+				t.Errorf("refs/heads/main = %v, want %v", got, first)
+			}
+			if rm.RefExists("refs/heads/other") {
+				t.Errorf("refs/heads/other was created by a failed transaction")
+			}
+			if locks := lockFiles(rm); len(locks) > 0 {
+				t.Errorf("lock files left behind: %v", locks)
+			}
+		})
+	}
+}
+
+func TestTransaction_PrepareAndAbort(t *testing.T) {
+	rm, first, second := newTransactionRepo(t)
+	rm.UpdateRef("refs/heads/main", first)
+
+	tx := rm.NewTransaction()
+	tx.Update("refs/heads/main", second, &first)
+	if err := tx.Prepare(); err != nil {
+		t.Fatalf("Prepare() error = %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(rm.GitDir(), "refs/heads/main.lock")); err != nil {
+		t.Errorf("ref not locked after Prepare(): %v", err)
+	}
+
+	// A second transaction cannot take the lock
+	other := rm.NewTransaction()
+	other.Update("refs/heads/main", second, nil)
+	if err := other.Commit(); err == nil {
+		t.Errorf("Commit() of a locked ref succeeded")
+	}
+
+	tx.Abort()
+	if got, _ := rm.ResolveRef("refs/heads/main"); got != first {
+		t.Errorf("refs/heads/main = %v after Abort(), want %v", got, first)
+	}
+	if locks := lockFiles(rm); len(locks) > 0 {
+		t.Errorf("lock files left behind: %v", locks)
+	}
+	if err := tx.Commit(); err != ErrTransactionClosed {
+		t.Errorf("Commit() after Abort() error = %v, want %v", err, ErrTransactionClosed)
+	}
+}
+
+func TestTransaction_SymbolicRefs(t *testing.T) {
+	rm, first, second := newTransactionRepo(t)
+
+	tx := rm.NewTransaction()
+	update, _ := tx.Update("HEAD", first, nil)
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+	if update.Ref != "refs/heads/main" {
+		t.Errorf("update.Ref = %s, want refs/heads/main", update.Ref)
+	}
+	if got, _ := rm.ResolveRef("refs/heads/main"); got != first {
+		t.Errorf("refs/heads/main = %v, want %v", got, first)
+	}
+
+	// With NoDeref HEAD itself is detached
+	tx = rm.NewTransaction()
+	update, _ = tx.Update("HEAD", second, nil)
+	update.NoDeref = true
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+	if _, ok, _ := rm.SymbolicRef("HEAD"); ok {
+		t.Errorf("HEAD is still symbolic after a NoDeref update")
+	}
+	if got, _ := rm.ResolveRef("refs/heads/main"); got != first {
+		t.Errorf("refs/heads/main = %v, want %v", got, first)
+	}
+}
+
+func TestTransaction_DeletePackedRef(t *testing.T) {
+	rm, first, second := newTransactionRepo(t)
+	rm.UpdateRef("refs/heads/main", first)
+	rm.UpdateRef("refs/heads/topic", second)
+	if _, err := rm.PackRefs(); err != nil {
+		t.Fatalf("PackRefs() error = %v", err)
+	}
+
+	tx := rm.NewTransaction()
+	tx.Delete("refs/heads/topic", &second)
+	if err := tx.Commit(); err != nil {
+		t.Fatalf("Commit() error = %v", err)
+	}
+
+	packed, err := rm.ReadPackedRefs()
+	if err != nil {
+		t.Fatalf("ReadPackedRefs() error = %v", err)
+	}
+	if _, ok := packed.refs["refs/heads/topic"]; ok {
+		t.Errorf("refs/heads/topic still packed after delete")
+	}
+	if got, _ := rm.ResolveRef("refs/heads/main"); got != first {
+		t.Errorf("refs/heads/main = %v, want %v", got, first)
+	}
+}
+
+func TestCheckRefName(t *testing.T) {
+	for _, name := range []string{"HEAD", "ORIG_HEAD", "refs/heads/main", "refs/tags/v1.0"} {
+		if err := checkRefName(name); err != nil {
+			t.Errorf("checkRefName(%q) error = %v", name, err)
+		}
+	}
+	for _, name := range []string{"", "head", "refs/heads/", "refs/heads/a..b", "refs/heads/x.lock", "refs/heads/a b", "refs//x"} {
+		if err := checkRefName(name); err == nil {
+			t.Errorf("checkRefName(%q) succeeded", name)
+		}
+	}
+}
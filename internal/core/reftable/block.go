@@ -0,0 +1,255 @@
+package reftable
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// blockWriter builds a block of prefix-compressed records. Every
+// restartInterval records one is stored with its full key, and the
+// offsets of those restarts end the block so that readers can binary
+// search them.
+type blockWriter struct {
+	typ       byte
+	buf       []byte
+	headerOff int
+	blockSize int
+	restarts  []uint32
+	lastKey   string
+	entries   int
+}
+
+// newBlockWriter starts a block of type typ. The first block of a table
+// starts with the file header, passed as prefix, which counts towards the
+// block's size and offsets.
+func newBlockWriter(typ byte, prefix []byte, blockSize int) *blockWriter {
+	buf := make([]byte, 0, blockSize)
+	buf = append(buf, prefix...)
+	buf = append(buf, make([]byte, blockHeaderSize)...)
+	return &blockWriter{typ: typ, buf: buf, headerOff: len(prefix), blockSize: blockSize}
+}
+
+// add appends a record of key, valueType and value, reporting false when
+// it does not fit in the block
+func (w *blockWriter) add(key string, valueType ValueType, value []byte) bool {
+	restart := w.entries%restartInterval == 0
+	prefix := 0
+	if !restart {
+		for prefix < len(key) && prefix < len(w.lastKey) && key[prefix] == w.lastKey[prefix] {
+			prefix++
+		}
+	}
+
+	rec := putVarint(nil, uint64(prefix))
+	rec = putVarint(rec, uint64(len(key)-prefix)<<3|uint64(valueType))
+	rec = append(rec, key[prefix:]...)
+	rec = append(rec, value...)
+
+	restarts := len(w.restarts)
+	if restart {
+		restarts++
+	}
+	if len(w.buf)+len(rec)+3*restarts+2 > w.blockSize {
+		return false
+	}
+	if restart {
+		w.restarts = append(w.restarts, uint32(len(w.buf)))
+	}
+	w.buf = append(w.buf, rec...)
+	w.lastKey = key
+	w.entries++
+	return true
+}
+
+// finish ends the block with its restart offsets and fills in its header,
+// padding it with zeros to the block size
+func (w *blockWriter) finish() []byte {
+	var b [3]byte
+	for _, offset := range w.restarts {
+		putUint24(b[:], offset)
+		w.buf = append(w.buf, b[:]...)
+	}
+	w.buf = binary.BigEndian.AppendUint16(w.buf, uint16(len(w.restarts)))
+
+	w.buf[w.headerOff] = w.typ
+	putUint24(w.buf[w.headerOff+1:], uint32(len(w.buf)))
+	for len(w.buf) < w.blockSize {
+		w.buf = append(w.buf, 0)
+	}
+	return w.buf
+}
+
+// block is a block read from a table, data holding it from its start up
+// to the end of the restart offsets
+type block struct {
+	typ          byte
+	data         []byte
+	start        int
+	restartStart int
+	restartCount int
+	// minUpdateIndex is that of the table, which the update indexes of
+	// ref records are relative to
+	minUpdateIndex uint64
+}
+
+// parseBlock parses the block at the start of data, which in the first
+// block of a table is preceded by the headerOff bytes of the file header
+func parseBlock(data []byte, headerOff int) (*block, error) {
+	if len(data) < headerOff+blockHeaderSize {
+		return nil, fmt.Errorf("%w: truncated block", ErrCorrupt)
+	}
+	typ := data[headerOff]
+	n := int(getUint24(data[headerOff+1:]))
+	if n > len(data) || n < headerOff+blockHeaderSize+2 {
+		return nil, fmt.Errorf("%w: bad block length %d", ErrCorrupt, n)
+	}
+	data = data[:n]
+	count := int(binary.BigEndian.Uint16(data[n-2:]))
+	restartStart := n - 2 - 3*count
+	if count == 0 || restartStart < headerOff+blockHeaderSize {
+		return nil, fmt.Errorf("%w: bad restart count %d", ErrCorrupt, count)
+	}
+	return &block{
+		typ:          typ,
+		data:         data,
+		start:        headerOff + blockHeaderSize,
+		restartStart: restartStart,
+		restartCount: count,
+	}, nil
+}
+
+// restart returns the offset of the i-th restart record
+func (b *block) restart(i int) int {
+	return int(getUint24(b.data[b.restartStart+3*i:]))
+}
+
+// decode decodes the record at pos following the record with lastKey,
+// returning its key, value type and value, and the position of the next
+// record
+func (b *block) decode(pos int, lastKey []byte) (key []byte, valueType ValueType, value []byte, next int, err error) {
+	data := b.data[:b.restartStart]
+	prefix, n := getVarint(data[pos:])
+	if n == 0 {
+		return nil, 0, nil, 0, fmt.Errorf("%w: bad record", ErrCorrupt)
+	}
+	pos += n
+	suffixAndType, n := getVarint(data[pos:])
+	if n == 0 {
+		return nil, 0, nil, 0, fmt.Errorf("%w: bad record", ErrCorrupt)
+	}
+	pos += n
+	suffix := int(suffixAndType >> 3)
+	valueType = ValueType(suffixAndType & 0x7)
+	if prefix > uint64(len(lastKey)) || pos+suffix > len(data) {
+		return nil, 0, nil, 0, fmt.Errorf("%w: bad record key", ErrCorrupt)
+	}
+	key = append(lastKey[:prefix:prefix], data[pos:pos+suffix]...)
+	pos += suffix
+
+	valueStart := pos
+	switch b.typ {
+	case blockTypeRef:
+		if _, n = getVarint(data[pos:]); n == 0 {
+			return nil, 0, nil, 0, fmt.Errorf("%w: bad update index", ErrCorrupt)
+		}
+		pos += n
+		switch valueType {
+		case ValueDeletion:
+		case ValueID:
+			pos += hashSize
+		case ValueIDPeeled:
+			pos += 2 * hashSize
+		case ValueSymref:
+			length, n := getVarint(data[pos:])
+			if n == 0 {
+				return nil, 0, nil, 0, fmt.Errorf("%w: bad symref", ErrCorrupt)
+			}
+			pos += n + int(length)
+		default:
+			return nil, 0, nil, 0, fmt.Errorf("%w: bad value type %d", ErrCorrupt, valueType)
+		}
+	case blockTypeIndex:
+		if _, n = getVarint(data[pos:]); n == 0 {
+			return nil, 0, nil, 0, fmt.Errorf("%w: bad index record", ErrCorrupt)
+		}
+		pos += n
+	default:
+		return nil, 0, nil, 0, fmt.Errorf("%w: cannot read records of block type %q", ErrCorrupt, b.typ)
+	}
+	if pos > len(data) {
+		return nil, 0, nil, 0, fmt.Errorf("%w: truncated record", ErrCorrupt)
+	}
+	return key, valueType, data[valueStart:pos], pos, nil
+}
+
+// seek returns the position of the first record whose key is not below
+// key, and the key of the record before it, or the end of the records
+func (b *block) seek(key string) (int, []byte, error) {
+	// Restart records have their full key, so they can be binary searched
+	// for the last one at or below key
+	var searchErr error
+	i := sort.Search(b.restartCount, func(i int) bool {
+		k, _, _, _, err := b.decode(b.restart(i), nil)
+		if err != nil {
+			searchErr = err
+			return true
+		}
+		return string(k) > key
+	})
+	if searchErr != nil {
+		return 0, nil, searchErr
+	}
+
+	pos := b.start
+	if i > 0 {
+		pos = b.restart(i - 1)
+	}
+	var lastKey []byte
+	for pos < b.restartStart {
+		k, _, _, next, err := b.decode(pos, lastKey)
+		if err != nil {
+			return 0, nil, err
+		}
+		if string(k) >= key {
+			return pos, lastKey, nil
+		}
+		lastKey, pos = k, next
+	}
+	return pos, lastKey, nil
+}
+
+// decodeRef decodes the value of a ref record
+func (b *block) decodeRef(key []byte, valueType ValueType, value []byte) RefRecord {
+	delta, n := getVarint(value)
+	rec := RefRecord{Name: string(key), UpdateIndex: b.minUpdateIndex + delta, Type: valueType}
+	value = value[n:]
+	switch valueType {
+	case ValueID:
+		copy(rec.ID[:], value)
+	case ValueIDPeeled:
+		copy(rec.ID[:], value)
+		copy(rec.Peeled[:], value[hashSize:])
+	case ValueSymref:
+		length, n := getVarint(value)
+		rec.Target = string(value[n : n+int(length)])
+	}
+	return rec
+}
+
+// encodeRefValue encodes the value of r in a table whose update indexes
+// start at minUpdateIndex
+func encodeRefValue(r *RefRecord, minUpdateIndex uint64) []byte {
+	value := putVarint(nil, r.UpdateIndex-minUpdateIndex)
+	switch r.Type {
+	case ValueID:
+		value = append(value, r.ID[:]...)
+	case ValueIDPeeled:
+		value = append(value, r.ID[:]...)
+		value = append(value, r.Peeled[:]...)
+	case ValueSymref:
+		value = putVarint(value, uint64(len(r.Target)))
+		value = append(value, r.Target...)
+	}
+	return value
+}
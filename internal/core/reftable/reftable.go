@@ -0,0 +1,202 @@
+// Package reftable reads and writes reftables, Git's binary format for
+// storing refs. A table holds refs sorted by name in prefix-compressed
+// blocks with an index over them, so a ref is found by binary search in a
+// few block reads however many refs there are. A repository keeps a stack
+// of tables listed in tables.list, each newer table overriding the refs
+// of the older ones; a transaction adds one table by swapping in a new
+// list, so updates to many refs are atomic, and tables are merged as the
+// stack grows so that it stays short.
+package reftable
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+const (
+	magic   = "REFT"
+	version = 1
+
+	headerSize = 24
+	footerSize = headerSize + 5*8 + 4
+
+	// blockHeaderSize is the type byte and the uint24 length that start
+	// every block
+	blockHeaderSize = 4
+
+	blockTypeRef   = 'r'
+	blockTypeIndex = 'i'
+	blockTypeObj   = 'o'
+	blockTypeLog   = 'g'
+
+	// DefaultBlockSize is the size tables are written with
+	DefaultBlockSize = 4096
+	// restartInterval is how many records apart the records stored with
+	// their full name are
+	restartInterval = 16
+	// indexThreshold is the number of ref blocks above which a table gets
+	// an index
+	indexThreshold = 3
+
+	hashSize = len(objects.ObjectID{})
+)
+
+// ErrCorrupt is returned for a table that does not follow the format
+var ErrCorrupt = errors.New("corrupt reftable")
+
+// ValueType says what a ref record holds
+type ValueType uint8
+
+const (
+	// ValueDeletion records that the ref was deleted, hiding it in older
+	// tables
+	ValueDeletion ValueType = iota
+	// ValueID is a ref pointing to an object
+	ValueID
+	// ValueIDPeeled is a ref pointing to an annotated tag, along with the
+	// object the tag peels to
+	ValueIDPeeled
+	// ValueSymref is a symbolic ref pointing to another ref
+	ValueSymref
+)
+
+// RefRecord is a ref as a table stores it
+type RefRecord struct {
+	Name string
+	// UpdateIndex orders the changes to refs: every table adds refs with
+	// an update index above those of the tables below it
+	UpdateIndex uint64
+	Type        ValueType
+	// ID is the object of ValueID and ValueIDPeeled records, and Peeled
+	// what it peels to for ValueIDPeeled
+	ID     objects.ObjectID
+	Peeled objects.ObjectID
+	// Target is the ref a ValueSymref record points to
+	Target string
+}
+
+// IsDeletion reports whether the record deletes its ref
+func (r *RefRecord) IsDeletion() bool {
+	return r.Type == ValueDeletion
+}
+
+// putVarint appends v in the varint encoding of reftables, which like the
+// offsets of packfiles has no redundant encodings: each continuation adds
+// one before shifting
+func putVarint(dst []byte, v uint64) []byte {
+	var buf [10]byte
+	n := len(buf) - 1
+	buf[n] = byte(v & 0x7f)
+	for v >>= 7; v != 0; v >>= 7 {
+		v--
+		n--
+		buf[n] = 0x80 | byte(v&0x7f)
+	}
+	return append(dst, buf[n:]...)
+}
+
+// getVarint decodes a varint from the start of data, returning it and its
+// length, or a length of 0 when data is too short
+func getVarint(data []byte) (uint64, int) {
+	if len(data) == 0 {
+		return 0, 0
+	}
+	v := uint64(data[0] & 0x7f)
+	n := 1
+	for data[n-1]&0x80 != 0 {
+		if n >= len(data) || n >= 10 {
+			return 0, 0
+		}
+		v = (v+1)<<7 | uint64(data[n]&0x7f)
+		n++
+	}
+	return v, n
+}
+
+func putUint24(dst []byte, v uint32) {
+	dst[0], dst[1], dst[2] = byte(v>>16), byte(v>>8), byte(v)
+}
+
+func getUint24(data []byte) uint32 {
+	return uint32(data[0])<<16 | uint32(data[1])<<8 | uint32(data[2])
+}
+
+// header is the start of a table, repeated in its footer
+type header struct {
+	blockSize      uint32
+	minUpdateIndex uint64
+	maxUpdateIndex uint64
+}
+
+func (h header) encode() []byte {
+	buf := make([]byte, headerSize)
+	copy(buf, magic)
+	buf[4] = version
+	putUint24(buf[5:], h.blockSize)
+	binary.BigEndian.PutUint64(buf[8:], h.minUpdateIndex)
+	binary.BigEndian.PutUint64(buf[16:], h.maxUpdateIndex)
+	return buf
+}
+
+func parseHeader(data []byte) (header, error) {
+	if len(data) < headerSize || string(data[:4]) != magic {
+		return header{}, fmt.Errorf("%w: bad signature", ErrCorrupt)
+	}
+	if data[4] != version {
+		return header{}, fmt.Errorf("unsupported reftable version %d", data[4])
+	}
+	return header{
+		blockSize:      getUint24(data[5:]),
+		minUpdateIndex: binary.BigEndian.Uint64(data[8:]),
+		maxUpdateIndex: binary.BigEndian.Uint64(data[16:]),
+	}, nil
+}
+
+// footer ends a table with the positions of its sections, 0 for those it
+// does not have
+type footer struct {
+	header
+	refIndexPosition uint64
+	objPosition      uint64
+	objIDLen         uint8
+	objIndexPosition uint64
+	logPosition      uint64
+	logIndexPosition uint64
+}
+
+func (f footer) encode() []byte {
+	buf := f.header.encode()
+	buf = binary.BigEndian.AppendUint64(buf, f.refIndexPosition)
+	buf = binary.BigEndian.AppendUint64(buf, f.objPosition<<5|uint64(f.objIDLen))
+	buf = binary.BigEndian.AppendUint64(buf, f.objIndexPosition)
+	buf = binary.BigEndian.AppendUint64(buf, f.logPosition)
+	buf = binary.BigEndian.AppendUint64(buf, f.logIndexPosition)
+	return binary.BigEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf))
+}
+
+func parseFooter(data []byte) (footer, error) {
+	if len(data) != footerSize {
+		return footer{}, fmt.Errorf("%w: short footer", ErrCorrupt)
+	}
+	if crc32.ChecksumIEEE(data[:footerSize-4]) != binary.BigEndian.Uint32(data[footerSize-4:]) {
+		return footer{}, fmt.Errorf("%w: footer checksum mismatch", ErrCorrupt)
+	}
+	h, err := parseHeader(data)
+	if err != nil {
+		return footer{}, err
+	}
+	obj := binary.BigEndian.Uint64(data[32:])
+	return footer{
+		header:           h,
+		refIndexPosition: binary.BigEndian.Uint64(data[24:]),
+		objPosition:      obj >> 5,
+		objIDLen:         uint8(obj & 0x1f),
+		objIndexPosition: binary.BigEndian.Uint64(data[40:]),
+		logPosition:      binary.BigEndian.Uint64(data[48:]),
+		logIndexPosition: binary.BigEndian.Uint64(data[56:]),
+	}, nil
+}
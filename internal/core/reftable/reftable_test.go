@@ -0,0 +1,350 @@
+package reftable
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+func testID(i int) objects.ObjectID {
+	var id objects.ObjectID
+	copy(id[:], fmt.Sprintf("%020d", i))
+	return id
+}
+
+func TestVarint(t *testing.T) {
+	for _, v := range []uint64{0, 1, 127, 128, 255, 16383, 16384, 1 << 32, 1<<64 - 1} {
+		buf := putVarint(nil, v)
+		got, n := getVarint(buf)
+		if got != v || n != len(buf) {
+			t.Errorf("getVarint(putVarint(%d)) = %d, %d; want %d, %d", v, got, n, v, len(buf))
+		}
+	}
+	// git's encoding of 128 is 0x80 0x00
+	if buf := putVarint(nil, 128); !bytes.Equal(buf, []byte{0x80, 0x00}) {
+		t.Errorf("putVarint(128) = %x, want 8000", buf)
+	}
+	if _, n := getVarint([]byte{0x80}); n != 0 {
+		t.Errorf("getVarint() of a truncated varint = %d, want 0", n)
+	}
+}
+
+func writeTable(t *testing.T, refs []RefRecord, min, max uint64) *Table {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := Write(&buf, refs, min, max); err != nil {
+		t.Fatalf("Write() error = %v", err)
+	}
+	table, err := OpenTable(bytes.NewReader(buf.Bytes()), int64(buf.Len()))
+	if err != nil {
+		t.Fatalf("OpenTable() error = %v", err)
+	}
+	return table
+}
+
+func TestTable(t *testing.T) {
+	tests := []struct {
+		name  string
+		count int
+	}{
+		{"empty", 0},
+		{"one block", 10},
+		{"few blocks", 200},
+		{"indexed", 20000},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			refs := make([]RefRecord, tt.count)
+			for i := range refs {
+				refs[i] = RefRecord{Name: fmt.Sprintf("refs/tags/v%06d", i), UpdateIndex: 1, Type: ValueID, ID: testID(i)}
+			}
+			table := writeTable(t, refs, 1, 1)
+
+			for _, i := range []int{0, tt.count / 3, tt.count - 1} {
+				if i < 0 || i >= tt.count {
+					continue
+				}
+				rec, ok, err := table.Get(refs[i].Name)
+				if err != nil || !ok || rec != refs[i] {
+					t.Errorf("Get(%s) = %+v, %v, %v", refs[i].Name, rec, ok, err)
+				}
+			}
+			for _, name := range []string{"refs/heads/main", "refs/tags/v", "refs/tags/v0000005", "refs/tags/z"} {
+				if _, ok, err := table.Get(name); ok || err != nil {
+					t.Errorf("Get(%s) = %v, %v; want not found", name, ok, err)
+				}
+			}
+
+			it, err := table.Seek("")
+			if err != nil {
+				t.Fatalf("Seek() error = %v", err)
+			}
+			n := 0
+			for {
+				rec, ok, err := it.Next()
+				if err != nil {
+					t.Fatalf("Next() error = %v", err)
+				}
+				if !ok {
+					break
+				}
+				if rec != refs[n] {
+					t.Fatalf("record %d = %+v, want %+v", n, rec, refs[n])
+				}
+				n++
+			}
+			if n != tt.count {
+				t.Errorf("iterated %d refs, want %d", n, tt.count)
+			}
+		})
+	}
+}
+
+func TestTable_Values(t *testing.T) {
+	refs := []RefRecord{
+		{Name: "HEAD", UpdateIndex: 3, Type: ValueSymref, Target: "refs/heads/main"},
+		{Name: "refs/heads/gone", UpdateIndex: 4, Type: ValueDeletion},
+		{Name: "refs/heads/main", UpdateIndex: 3, Type: ValueID, ID: testID(1)},
+		{Name: "refs/tags/v1.0", UpdateIndex: 4, Type: ValueIDPeeled, ID: testID(2), Peeled: testID(3)},
+	}
+	table := writeTable(t, refs, 3, 4)
+	if table.MinUpdateIndex() != 3 || table.MaxUpdateIndex() != 4 {
+		t.Errorf("update indexes = %d-%d, want 3-4", table.MinUpdateIndex(), table.MaxUpdateIndex())
+	}
+	for _, want := range refs {
+		got, ok, err := table.Get(want.Name)
+		if err != nil || !ok || got != want {
+			t.Errorf("Get(%s) = %+v, %v, %v; want %+v", want.Name, got, ok, err, want)
+		}
+	}
+}
+
+func TestWrite_Errors(t *testing.T) {
+	unsorted := []RefRecord{{Name: "refs/b", UpdateIndex: 1}, {Name: "refs/a", UpdateIndex: 1}}
+	if err := Write(&bytes.Buffer{}, unsorted, 1, 1); err == nil {
+		t.Error("Write() of unsorted refs succeeded")
+	}
+	outside := []RefRecord{{Name: "refs/a", UpdateIndex: 5}}
+	if err := Write(&bytes.Buffer{}, outside, 1, 2); err == nil {
+		t.Error("Write() of an update index outside the range succeeded")
+	}
+}
+
+func TestOpenTable_Corrupt(t *testing.T) {
+	var buf bytes.Buffer
+	refs := []RefRecord{{Name: "refs/heads/main", UpdateIndex: 1, Type: ValueID, ID: testID(1)}}
+	if err := Write(&buf, refs, 1, 1); err != nil {
+		t.Fatal(err)
+	}
+	data := buf.Bytes()
+
+	if _, err := OpenTable(bytes.NewReader(data[:40]), 40); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("OpenTable() of a short file error = %v, want ErrCorrupt", err)
+	}
+	data[len(data)-10] ^= 0xff
+	if _, err := OpenTable(bytes.NewReader(data), int64(len(data))); !errors.Is(err, ErrCorrupt) {
+		t.Errorf("OpenTable() with a bad footer error = %v, want ErrCorrupt", err)
+	}
+}
+
+func addRefs(t *testing.T, s *Stack, refs ...RefRecord) {
+	t.Helper()
+	lock, err := s.Lock()
+	if err != nil {
+		t.Fatalf("Lock() error = %v", err)
+	}
+	if err := lock.Add(refs); err != nil {
+		t.Fatalf("Add() error = %v", err)
+	}
+}
+
+func TestStack(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "reftable")
+	if err := Init(dir); err != nil {
+		t.Fatal(err)
+	}
+	s, err := OpenStack(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	addRefs(t, s,
+		RefRecord{Name: "refs/heads/a", Type: ValueID, ID: testID(1)},
+		RefRecord{Name: "refs/heads/b", Type: ValueID, ID: testID(2)},
+	)
+	addRefs(t, s,
+		RefRecord{Name: "refs/heads/a", Type: ValueDeletion},
+		RefRecord{Name: "refs/heads/c", Type: ValueID, ID: testID(3)},
+	)
+
+	if _, ok, err := s.Get("refs/heads/a"); ok || err != nil {
+		t.Errorf("Get() of a deleted ref = %v, %v", ok, err)
+	}
+	rec, ok, err := s.Get("refs/heads/b")
+	if err != nil || !ok || rec.ID != testID(2) || rec.UpdateIndex != 1 {
+		t.Errorf("Get(refs/heads/b) = %+v, %v, %v", rec, ok, err)
+	}
+	if rec, ok, _ := s.Get("refs/heads/c"); !ok || rec.UpdateIndex != 2 {
+		t.Errorf("Get(refs/heads/c) = %+v, %v", rec, ok)
+	}
+
+	it, err := s.Seek("refs/heads/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for {
+		rec, ok, err := it.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			break
+		}
+		names = append(names, rec.Name)
+	}
+	if fmt.Sprint(names) != "[refs/heads/b refs/heads/c]" {
+		t.Errorf("refs = %v", names)
+	}
+
+	// A second stack on the same directory sees the additions
+	other, err := OpenStack(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	addRefs(t, s, RefRecord{Name: "refs/heads/d", Type: ValueID, ID: testID(4)})
+	if _, ok, err := other.Get("refs/heads/d"); !ok || err != nil {
+		t.Errorf("Get() from another stack = %v, %v", ok, err)
+	}
+}
+
+func TestStack_Lock(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenStack(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	lock, err := s.Lock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Lock(); !errors.Is(err, ErrLocked) {
+		t.Errorf("second Lock() error = %v, want ErrLocked", err)
+	}
+	lock.Unlock()
+	if _, err := os.Stat(filepath.Join(dir, ListFile+".lock")); !os.IsNotExist(err) {
+		t.Error("lock file remains after Unlock()")
+	}
+	if err := lock.Add(nil); err == nil {
+		t.Error("Add() after Unlock() succeeded")
+	}
+}
+
+func TestStack_Compaction(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenStack(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	const additions = 100
+	for i := 0; i < additions; i++ {
+		addRefs(t, s, RefRecord{Name: fmt.Sprintf("refs/heads/b%03d", i), Type: ValueID, ID: testID(i)})
+		if i%10 == 9 {
+			addRefs(t, s, RefRecord{Name: fmt.Sprintf("refs/heads/b%03d", i), Type: ValueDeletion})
+		}
+	}
+
+	tables, err := s.Tables()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(tables) > 8 {
+		t.Errorf("stack has %d tables after %d additions", len(tables), additions)
+	}
+	entries, _ := os.ReadDir(dir)
+	if len(entries) != len(tables)+1 {
+		t.Errorf("directory has %d files for %d tables", len(entries), len(tables))
+	}
+	if got := s.NextUpdateIndex(); got != additions+additions/10+1 {
+		t.Errorf("NextUpdateIndex() = %d", got)
+	}
+
+	for i := 0; i < additions; i++ {
+		_, ok, err := s.Get(fmt.Sprintf("refs/heads/b%03d", i))
+		if err != nil || ok != (i%10 != 9) {
+			t.Errorf("Get(b%03d) = %v, %v", i, ok, err)
+		}
+	}
+
+	lock, err := s.Lock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := lock.Compact(); err != nil {
+		t.Fatalf("Compact() error = %v", err)
+	}
+	if tables, _ := s.Tables(); len(tables) != 1 {
+		t.Errorf("stack has %d tables after Compact()", len(tables))
+	}
+	// Compacting the whole stack drops the deletions
+	it, err := s.tables[0].Seek("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := 0
+	for {
+		rec, ok, err := it.Next()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			break
+		}
+		if rec.IsDeletion() {
+			t.Errorf("deletion of %s kept", rec.Name)
+		}
+		n++
+	}
+	if n != additions-additions/10 {
+		t.Errorf("compacted table has %d refs", n)
+	}
+}
+
+func BenchmarkStackGet(b *testing.B) {
+	refs := make([]RefRecord, 200000)
+	for i := range refs {
+		refs[i] = RefRecord{Name: fmt.Sprintf("refs/tags/v%07d", i), Type: ValueID, ID: testID(i)}
+	}
+	dir := b.TempDir()
+	s, err := OpenStack(dir)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer s.Close()
+	lock, err := s.Lock()
+	if err != nil {
+		b.Fatal(err)
+	}
+	if err := lock.Add(refs); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, ok, err := s.Get(refs[i%len(refs)].Name); !ok || err != nil {
+			b.Fatalf("Get() = %v, %v", ok, err)
+		}
+	}
+}
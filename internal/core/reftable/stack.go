@@ -0,0 +1,475 @@
+package reftable
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/fenilsonani/vcs/internal/core/fault"
+)
+
+const (
+	// ListFile names the tables of a stack, oldest first
+	ListFile = "tables.list"
+
+	// compactionFactor is how much larger than all the tables above it
+	// each table of a compacted stack is
+	compactionFactor = 2
+)
+
+// ErrLocked is returned when another process holds the lock of a stack
+var ErrLocked = errors.New("reftable stack is locked")
+
+// Stack is the stack of tables in a reftable directory. A ref has the
+// value of its record in the newest table that has one, and does not
+// exist when that record is a deletion.
+type Stack struct {
+	dir    string
+	names  []string
+	tables []*Table
+	files  []*os.File
+	// list is tables.list when the tables were opened
+	list os.FileInfo
+}
+
+// OpenStack opens the stack in dir, which may not exist yet
+func OpenStack(dir string) (*Stack, error) {
+	s := &Stack{dir: dir}
+	if err := s.reload(true); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Dir returns the directory of the stack
+func (s *Stack) Dir() string {
+	return s.dir
+}
+
+// Init creates the directory of a stack with no tables
+func Init(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	path := filepath.Join(dir, ListFile)
+	if _, err := os.Stat(path); err == nil {
+		return nil
+	}
+	return writeSynced(path, nil)
+}
+
+// reload opens the tables of tables.list again if it changed since they
+// were opened, or always with force
+func (s *Stack) reload(force bool) error {
+	path := filepath.Join(s.dir, ListFile)
+	info, err := os.Stat(path)
+	if err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to read %s: %w", path, err)
+	}
+	if !force && s.list != nil && info != nil && os.SameFile(info, s.list) &&
+		info.Size() == s.list.Size() && info.ModTime().Equal(s.list.ModTime()) {
+		return nil
+	}
+
+	var names []string
+	if info != nil {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", path, err)
+		}
+		for _, line := range strings.Split(string(data), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				names = append(names, line)
+			}
+		}
+	}
+
+	// Tables already open are kept; tables.list may have been replaced
+	// between reading it and opening its tables by a compaction that
+	// removed some, so the list is read again when one is missing
+	open := make(map[string]int, len(s.names))
+	for i, name := range s.names {
+		open[name] = i
+	}
+	tables := make([]*Table, len(names))
+	files := make([]*os.File, len(names))
+	var opened []*os.File
+	for i, name := range names {
+		if j, ok := open[name]; ok {
+			tables[i], files[i] = s.tables[j], s.files[j]
+			delete(open, name)
+			continue
+		}
+		f, t, err := openTableFile(filepath.Join(s.dir, name))
+		if err != nil {
+			for _, f := range opened {
+				f.Close()
+			}
+			if os.IsNotExist(err) && !force {
+				return s.reload(true)
+			}
+			return err
+		}
+		tables[i], files[i] = t, f
+		opened = append(opened, f)
+	}
+	for _, j := range open {
+		s.files[j].Close()
+	}
+	s.names, s.tables, s.files, s.list = names, tables, files, info
+	return nil
+}
+
+// openTableFile opens the table at path
+func openTableFile(path string) (*os.File, *Table, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return nil, nil, err
+	}
+	t, err := OpenTable(f, info.Size())
+	if err != nil {
+		f.Close()
+		return nil, nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return f, t, nil
+}
+
+// Close closes the tables of the stack
+func (s *Stack) Close() error {
+	for _, f := range s.files {
+		f.Close()
+	}
+	s.names, s.tables, s.files, s.list = nil, nil, nil, nil
+	return nil
+}
+
+// Tables returns the names of the tables of the stack, oldest first
+func (s *Stack) Tables() ([]string, error) {
+	if err := s.reload(false); err != nil {
+		return nil, err
+	}
+	return s.names, nil
+}
+
+// NextUpdateIndex returns the update index of the next table added
+func (s *Stack) NextUpdateIndex() uint64 {
+	if len(s.tables) == 0 {
+		return 1
+	}
+	return s.tables[len(s.tables)-1].MaxUpdateIndex() + 1
+}
+
+// Get returns the ref name, reporting false when it does not exist
+func (s *Stack) Get(name string) (RefRecord, bool, error) {
+	if err := s.reload(false); err != nil {
+		return RefRecord{}, false, err
+	}
+	for i := len(s.tables) - 1; i >= 0; i-- {
+		rec, ok, err := s.tables[i].Get(name)
+		if err != nil {
+			return RefRecord{}, false, err
+		}
+		if ok {
+			return rec, !rec.IsDeletion(), nil
+		}
+	}
+	return RefRecord{}, false, nil
+}
+
+// Seek returns an iterator over the refs of the stack from the first one
+// named name or after it
+func (s *Stack) Seek(name string) (*Iterator, error) {
+	if err := s.reload(false); err != nil {
+		return nil, err
+	}
+	return seekTables(s.tables, name, false)
+}
+
+// Iterator merges the refs of the tables of a stack in order, the record
+// of the newest table hiding those of older ones
+type Iterator struct {
+	iters []*TableIterator
+	heads []RefRecord
+	valid []bool
+	// deletions makes deletions part of the iteration, as when tables
+	// are merged into one that does not replace the whole stack
+	deletions bool
+}
+
+// seekTables returns an iterator over tables, oldest first, from name
+func seekTables(tables []*Table, name string, deletions bool) (*Iterator, error) {
+	it := &Iterator{deletions: deletions}
+	for _, t := range tables {
+		ti, err := t.Seek(name)
+		if err != nil {
+			return nil, err
+		}
+		it.iters = append(it.iters, ti)
+		it.heads = append(it.heads, RefRecord{})
+		it.valid = append(it.valid, false)
+		if err := it.advance(len(it.iters) - 1); err != nil {
+			return nil, err
+		}
+	}
+	return it, nil
+}
+
+// advance moves the i-th table to its next record
+func (it *Iterator) advance(i int) error {
+	rec, ok, err := it.iters[i].Next()
+	if err != nil {
+		return err
+	}
+	it.heads[i], it.valid[i] = rec, ok
+	return nil
+}
+
+// Next returns the next ref, or false at the end of the stack
+func (it *Iterator) Next() (RefRecord, bool, error) {
+	for {
+		newest := -1
+		for i := range it.heads {
+			if it.valid[i] && (newest < 0 || it.heads[i].Name <= it.heads[newest].Name) {
+				newest = i
+			}
+		}
+		if newest < 0 {
+			return RefRecord{}, false, nil
+		}
+
+		rec := it.heads[newest]
+		for i := range it.heads {
+			if it.valid[i] && it.heads[i].Name == rec.Name {
+				if err := it.advance(i); err != nil {
+					return RefRecord{}, false, err
+				}
+			}
+		}
+		if it.deletions || !rec.IsDeletion() {
+			return rec, true, nil
+		}
+	}
+}
+
+// Lock is the lock of a stack, which a process holds to add tables
+type Lock struct {
+	s    *Stack
+	path string
+}
+
+// Lock takes the lock of the stack and reads its newest tables, which
+// stay the same until the lock is released
+func (s *Stack) Lock() (*Lock, error) {
+	path := filepath.Join(s.dir, ListFile+".lock")
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0644)
+	if os.IsExist(err) {
+		return nil, fmt.Errorf("%w: %s exists", ErrLocked, path)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to lock %s: %w", s.dir, err)
+	}
+	f.Close()
+	if err := s.reload(true); err != nil {
+		os.Remove(path)
+		return nil, err
+	}
+	return &Lock{s: s, path: path}, nil
+}
+
+// Unlock releases the lock without changing the stack
+func (l *Lock) Unlock() {
+	if l.path != "" {
+		os.Remove(l.path)
+		l.path = ""
+	}
+}
+
+// Add adds a table of refs, sorted by name, at the next update index and
+// releases the lock. Tables are merged as needed so that each is at least
+// twice as large as all those above it, keeping the stack logarithmic in
+// the number of additions.
+func (l *Lock) Add(refs []RefRecord) error {
+	defer l.Unlock()
+	if l.path == "" {
+		return fmt.Errorf("reftable stack is not locked")
+	}
+
+	s := l.s
+	updateIndex := s.NextUpdateIndex()
+	for i := range refs {
+		refs[i].UpdateIndex = updateIndex
+	}
+	name, size, err := s.writeTable(refs, updateIndex, updateIndex)
+	if err != nil {
+		return err
+	}
+
+	names := append(append([]string(nil), s.names...), name)
+	sizes := make([]int64, 0, len(names))
+	for _, t := range s.tables {
+		sizes = append(sizes, t.size)
+	}
+	sizes = append(sizes, size)
+
+	// The tables from start on are merged into one, dropping deletions
+	// when that one is the bottom of the stack
+	start := compactionStart(sizes)
+	written := []string{name}
+	var obsolete []string
+	if start < len(names)-1 {
+		f, added, err := openTableFile(filepath.Join(s.dir, name))
+		if err != nil {
+			os.Remove(filepath.Join(s.dir, name))
+			return err
+		}
+		merged, err := s.compact(append(s.tables[start:len(s.tables):len(s.tables)], added), start == 0)
+		f.Close()
+		if err != nil {
+			os.Remove(filepath.Join(s.dir, name))
+			return err
+		}
+		written = append(written, merged)
+		obsolete = names[start:]
+		names = append(names[:start:start], merged)
+	}
+
+	if err := l.commitList(names); err != nil {
+		for _, n := range written {
+			os.Remove(filepath.Join(s.dir, n))
+		}
+		return err
+	}
+	for _, n := range obsolete {
+		os.Remove(filepath.Join(s.dir, n))
+	}
+	return s.reload(true)
+}
+
+// compactionStart returns the first of the tables with sizes, oldest first,
+// to merge with the newest so that each table is at least compactionFactor
+// times larger than all those above it
+func compactionStart(sizes []int64) int {
+	start := len(sizes) - 1
+	total := sizes[start]
+	for start > 0 && sizes[start-1] < compactionFactor*total {
+		start--
+		total += sizes[start]
+	}
+	return start
+}
+
+// Compact merges every table of the stack into one
+func (l *Lock) Compact() error {
+	defer l.Unlock()
+	s := l.s
+	if len(s.tables) < 2 {
+		return nil
+	}
+	merged, err := s.compact(s.tables, true)
+	if err != nil {
+		return err
+	}
+	if err := l.commitList([]string{merged}); err != nil {
+		os.Remove(filepath.Join(s.dir, merged))
+		return err
+	}
+	for _, n := range s.names {
+		os.Remove(filepath.Join(s.dir, n))
+	}
+	return s.reload(true)
+}
+
+// compact writes a table with the refs of tables, oldest first, returning
+// its name. Deletions are kept unless the tables are the bottom of the
+// stack, where there is nothing left for them to hide.
+func (s *Stack) compact(tables []*Table, bottom bool) (string, error) {
+	it, err := seekTables(tables, "", !bottom)
+	if err != nil {
+		return "", err
+	}
+	var refs []RefRecord
+	for {
+		rec, ok, err := it.Next()
+		if err != nil {
+			return "", err
+		}
+		if !ok {
+			break
+		}
+		refs = append(refs, rec)
+	}
+	name, _, err := s.writeTable(refs, tables[0].MinUpdateIndex(), tables[len(tables)-1].MaxUpdateIndex())
+	return name, err
+}
+
+// writeTable writes a table of refs to a new file of the stack, synced to
+// disk, returning its name and size
+func (s *Stack) writeTable(refs []RefRecord, minUpdateIndex, maxUpdateIndex uint64) (string, int64, error) {
+	var buf bytes.Buffer
+	if err := Write(&buf, refs, minUpdateIndex, maxUpdateIndex); err != nil {
+		return "", 0, err
+	}
+	name := fmt.Sprintf("0x%012x-0x%012x-%08x.ref", minUpdateIndex, maxUpdateIndex, rand.Uint32())
+	if err := writeSynced(filepath.Join(s.dir, name), buf.Bytes()); err != nil {
+		return "", 0, fmt.Errorf("failed to write reftable: %w", err)
+	}
+	return name, int64(buf.Len()), nil
+}
+
+// commitList replaces tables.list with names through the lock file, which
+// releases the lock
+func (l *Lock) commitList(names []string) error {
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name)
+		b.WriteByte('\n')
+	}
+	if err := writeSynced(l.path, []byte(b.String())); err != nil {
+		return fmt.Errorf("failed to write %s: %w", ListFile, err)
+	}
+	if err := fault.Rename(l.path, filepath.Join(l.s.dir, ListFile)); err != nil {
+		return fmt.Errorf("failed to update %s: %w", ListFile, err)
+	}
+	l.path = ""
+	return syncDir(l.s.dir)
+}
+
+// writeSynced writes data to the file at path and syncs it to disk
+func writeSynced(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := fault.NewWriter(f, path).Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := fault.Sync(f); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// syncDir syncs the directory at dir, so that the files renamed into it
+// survive a crash
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open %s: %w", dir, err)
+	}
+	defer d.Close()
+	if err := fault.Sync(d); err != nil {
+		return fmt.Errorf("failed to sync %s: %w", dir, err)
+	}
+	return nil
+}
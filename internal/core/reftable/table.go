@@ -0,0 +1,310 @@
+package reftable
+
+import (
+	"fmt"
+	"io"
+)
+
+// indexRecord points to a block by the last key in it
+type indexRecord struct {
+	key      string
+	position uint64
+}
+
+// Write writes a table of refs, which must be sorted by name without
+// duplicates, holding the updates from minUpdateIndex to maxUpdateIndex.
+// Ref blocks are padded to DefaultBlockSize and indexed once there are
+// more than a few; the table has no object or log sections.
+func Write(w io.Writer, refs []RefRecord, minUpdateIndex, maxUpdateIndex uint64) error {
+	h := header{blockSize: DefaultBlockSize, minUpdateIndex: minUpdateIndex, maxUpdateIndex: maxUpdateIndex}
+	for i := range refs {
+		if i > 0 && refs[i-1].Name >= refs[i].Name {
+			return fmt.Errorf("refs not sorted: %s after %s", refs[i].Name, refs[i-1].Name)
+		}
+		if u := refs[i].UpdateIndex; u < minUpdateIndex || u > maxUpdateIndex {
+			return fmt.Errorf("update index %d of %s outside of %d-%d", u, refs[i].Name, minUpdateIndex, maxUpdateIndex)
+		}
+	}
+
+	var out []byte
+	var index []indexRecord
+	bw := newBlockWriter(blockTypeRef, h.encode(), DefaultBlockSize)
+	for i := range refs {
+		r := &refs[i]
+		value := encodeRefValue(r, minUpdateIndex)
+		if bw.add(r.Name, r.Type, value) {
+			continue
+		}
+		if bw.entries == 0 {
+			return fmt.Errorf("ref %s does not fit in a block", r.Name)
+		}
+		index = append(index, indexRecord{bw.lastKey, uint64(len(out))})
+		out = append(out, bw.finish()...)
+		bw = newBlockWriter(blockTypeRef, nil, DefaultBlockSize)
+		if !bw.add(r.Name, r.Type, value) {
+			return fmt.Errorf("ref %s does not fit in a block", r.Name)
+		}
+	}
+	if bw.entries > 0 {
+		index = append(index, indexRecord{bw.lastKey, uint64(len(out))})
+		out = append(out, bw.finish()...)
+	} else {
+		out = h.encode()
+	}
+
+	// Each level of the index points to the blocks of the level below,
+	// until the top level is small enough to be scanned
+	var refIndexPosition uint64
+	for len(index) > indexThreshold {
+		refIndexPosition = uint64(len(out))
+		var level []indexRecord
+		bw := newBlockWriter(blockTypeIndex, nil, DefaultBlockSize)
+		for _, ir := range index {
+			value := putVarint(nil, ir.position)
+			if bw.add(ir.key, 0, value) {
+				continue
+			}
+			level = append(level, indexRecord{bw.lastKey, uint64(len(out))})
+			out = append(out, bw.finish()...)
+			bw = newBlockWriter(blockTypeIndex, nil, DefaultBlockSize)
+			if !bw.add(ir.key, 0, value) {
+				return fmt.Errorf("ref %s does not fit in an index block", ir.key)
+			}
+		}
+		level = append(level, indexRecord{bw.lastKey, uint64(len(out))})
+		out = append(out, bw.finish()...)
+		index = level
+	}
+
+	f := footer{header: h, refIndexPosition: refIndexPosition}
+	out = append(out, f.encode()...)
+	_, err := w.Write(out)
+	return err
+}
+
+// Table is a table open for reading. Blocks are read from the file as
+// needed, so a lookup reads a block per level of the index and one ref
+// block.
+type Table struct {
+	r      io.ReaderAt
+	size   int64
+	footer footer
+	// refEnd is where the ref blocks end
+	refEnd int64
+}
+
+// OpenTable opens the table of size bytes in r
+func OpenTable(r io.ReaderAt, size int64) (*Table, error) {
+	if size < headerSize+footerSize {
+		return nil, fmt.Errorf("%w: file too small", ErrCorrupt)
+	}
+	buf := make([]byte, footerSize)
+	if _, err := r.ReadAt(buf, size-footerSize); err != nil {
+		return nil, fmt.Errorf("failed to read reftable footer: %w", err)
+	}
+	f, err := parseFooter(buf)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := r.ReadAt(buf[:headerSize], 0); err != nil {
+		return nil, fmt.Errorf("failed to read reftable header: %w", err)
+	}
+	if string(buf[:headerSize]) != string(f.header.encode()) {
+		return nil, fmt.Errorf("%w: header and footer differ", ErrCorrupt)
+	}
+
+	t := &Table{r: r, size: size, footer: f, refEnd: size - footerSize}
+	for _, position := range []uint64{f.logPosition, f.objPosition, f.refIndexPosition} {
+		if position != 0 && int64(position) < t.refEnd {
+			t.refEnd = int64(position)
+		}
+	}
+	return t, nil
+}
+
+// MinUpdateIndex returns the lowest update index of the refs in the table
+func (t *Table) MinUpdateIndex() uint64 {
+	return t.footer.minUpdateIndex
+}
+
+// MaxUpdateIndex returns the highest update index of the refs in the table
+func (t *Table) MaxUpdateIndex() uint64 {
+	return t.footer.maxUpdateIndex
+}
+
+// readBlock reads the block at position, or returns nil when there is no
+// block of type typ there
+func (t *Table) readBlock(position int64, typ byte) (*block, error) {
+	headerOff := 0
+	if position == 0 {
+		headerOff = headerSize
+	}
+	if position+int64(headerOff+blockHeaderSize) > t.size-footerSize {
+		return nil, nil
+	}
+	var head [blockHeaderSize]byte
+	if _, err := t.r.ReadAt(head[:], position+int64(headerOff)); err != nil {
+		return nil, fmt.Errorf("failed to read reftable block: %w", err)
+	}
+	if head[0] != typ {
+		return nil, nil
+	}
+	n := int64(getUint24(head[1:]))
+	if position+n > t.size-footerSize {
+		return nil, fmt.Errorf("%w: block at %d overruns the file", ErrCorrupt, position)
+	}
+	data := make([]byte, n)
+	if _, err := t.r.ReadAt(data, position); err != nil {
+		return nil, fmt.Errorf("failed to read reftable block: %w", err)
+	}
+	b, err := parseBlock(data, headerOff)
+	if err != nil {
+		return nil, err
+	}
+	b.minUpdateIndex = t.footer.minUpdateIndex
+	return b, nil
+}
+
+// nextBlock returns the position of the block after the one at position
+// with b's length, blocks being padded to the block size
+func (t *Table) nextBlock(position int64, b *block) int64 {
+	size := int64(len(b.data))
+	if blockSize := int64(t.footer.blockSize); blockSize > size {
+		size = blockSize
+	}
+	return position + size
+}
+
+// Seek returns an iterator over the refs of the table from the first one
+// named name or after it, including deletions
+func (t *Table) Seek(name string) (*TableIterator, error) {
+	it := &TableIterator{t: t}
+	if t.refEnd <= headerSize {
+		return it, nil
+	}
+
+	position := int64(0)
+	if t.footer.refIndexPosition != 0 {
+		var err error
+		var found bool
+		if position, found, err = t.seekIndex(name); err != nil || !found {
+			return it, err
+		}
+	}
+
+	// Without an index the blocks are searched in turn
+	for position < t.refEnd {
+		b, err := t.readBlock(position, blockTypeRef)
+		if err != nil || b == nil {
+			return it, err
+		}
+		pos, lastKey, err := b.seek(name)
+		if err != nil {
+			return it, err
+		}
+		if pos < b.restartStart {
+			it.block, it.position, it.pos, it.lastKey = b, position, pos, lastKey
+			return it, nil
+		}
+		position = t.nextBlock(position, b)
+	}
+	return it, nil
+}
+
+// seekIndex finds the ref block holding the first ref not below name
+// through the index, reporting false when every ref is below it
+func (t *Table) seekIndex(name string) (int64, bool, error) {
+	// The top level may span a few blocks, scanned in turn
+	position := int64(t.footer.refIndexPosition)
+	var b *block
+	for {
+		var err error
+		if b, err = t.readBlock(position, blockTypeIndex); err != nil {
+			return 0, false, err
+		}
+		if b == nil {
+			return 0, false, nil
+		}
+		pos, _, err := b.seek(name)
+		if err != nil {
+			return 0, false, err
+		}
+		if pos < b.restartStart {
+			break
+		}
+		position = t.nextBlock(position, b)
+	}
+
+	// Each index record points to the block whose last key it has
+	for {
+		pos, lastKey, err := b.seek(name)
+		if err != nil {
+			return 0, false, err
+		}
+		if pos >= b.restartStart {
+			return 0, false, nil
+		}
+		_, _, value, _, err := b.decode(pos, lastKey)
+		if err != nil {
+			return 0, false, err
+		}
+		target, _ := getVarint(value)
+		if b, err = t.readBlock(int64(target), blockTypeIndex); err != nil {
+			return 0, false, err
+		}
+		if b == nil {
+			return int64(target), true, nil
+		}
+	}
+}
+
+// Get returns the record of name, which may be a deletion, and whether
+// the table has one
+func (t *Table) Get(name string) (RefRecord, bool, error) {
+	it, err := t.Seek(name)
+	if err != nil {
+		return RefRecord{}, false, err
+	}
+	rec, ok, err := it.Next()
+	if err != nil || !ok || rec.Name != name {
+		return RefRecord{}, false, err
+	}
+	return rec, true, nil
+}
+
+// TableIterator iterates over the refs of a table in order
+type TableIterator struct {
+	t        *Table
+	block    *block
+	position int64
+	pos      int
+	lastKey  []byte
+}
+
+// Next returns the next ref, or false at the end of the table
+func (it *TableIterator) Next() (RefRecord, bool, error) {
+	for it.block != nil {
+		if it.pos < it.block.restartStart {
+			key, valueType, value, next, err := it.block.decode(it.pos, it.lastKey)
+			if err != nil {
+				return RefRecord{}, false, err
+			}
+			it.pos, it.lastKey = next, key
+			return it.block.decodeRef(key, valueType, value), true, nil
+		}
+
+		position := it.t.nextBlock(it.position, it.block)
+		it.block = nil
+		if position >= it.t.refEnd {
+			break
+		}
+		b, err := it.t.readBlock(position, blockTypeRef)
+		if err != nil {
+			return RefRecord{}, false, err
+		}
+		if b != nil {
+			it.block, it.position, it.pos, it.lastKey = b, position, b.start, nil
+		}
+	}
+	return RefRecord{}, false, nil
+}
@@ -0,0 +1,87 @@
+package signing
+
+import (
+	"bytes"
+	"strings"
+)
+
+// Commit headers holding a signature; the second is used by SHA-256
+// repositories, and both are left out of the signed payload
+var signatureHeaders = []string{"gpgsig", "gpgsig-sha256"}
+
+// SplitCommit separates the signature header of raw commit data from the
+// payload that was signed, the commit without it. The signature is nil
+// when the commit is not signed.
+func SplitCommit(data []byte) (payload, signature []byte) {
+	end := bytes.Index(data, []byte("\n\n"))
+	if end < 0 {
+		return data, nil
+	}
+
+	// Only the first signature is kept, but all are left out of the payload
+	var out, sig bytes.Buffer
+	inSignature, keep := false, false
+	for _, line := range strings.SplitAfter(string(data[:end+1]), "\n") {
+		// A header continues on lines starting with a space
+		if inSignature && strings.HasPrefix(line, " ") {
+			if keep {
+				sig.WriteString(line[1:])
+			}
+			continue
+		}
+		inSignature = false
+		for _, header := range signatureHeaders {
+			if value, ok := strings.CutPrefix(line, header+" "); ok {
+				inSignature, keep = true, sig.Len() == 0
+				if keep {
+					sig.WriteString(value)
+				}
+			}
+		}
+		if !inSignature {
+			out.WriteString(line)
+		}
+	}
+	out.Write(data[end+1:])
+
+	if sig.Len() == 0 {
+		return data, nil
+	}
+	return out.Bytes(), sig.Bytes()
+}
+
+// AddCommitSignature returns the raw commit data payload with signature
+// added as its gpgsig header, after the other headers
+func AddCommitSignature(payload, signature []byte) []byte {
+	end := bytes.Index(payload, []byte("\n\n"))
+	if end < 0 {
+		end = len(payload) - 1
+	}
+
+	var buf bytes.Buffer
+	buf.Write(payload[:end+1])
+	buf.WriteString("gpgsig")
+	for _, line := range strings.SplitAfter(strings.TrimSuffix(string(signature), "\n"), "\n") {
+		buf.WriteString(" ")
+		buf.WriteString(line)
+	}
+	buf.WriteString("\n")
+	buf.Write(payload[end+1:])
+	return buf.Bytes()
+}
+
+// SplitTag separates the signature Git appends to the message of a signed
+// tag from the payload that was signed. The signature is nil when the tag
+// is not signed.
+func SplitTag(data []byte) (payload, signature []byte) {
+	start := -1
+	for _, header := range []string{pgpHeader, sshHeader} {
+		if i := bytes.LastIndex(data, []byte(header)); i > start && (i == 0 || data[i-1] == '\n') {
+			start = i
+		}
+	}
+	if start < 0 {
+		return data, nil
+	}
+	return data[:start], data[start:]
+}
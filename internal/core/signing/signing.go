@@ -0,0 +1,272 @@
+// Package signing signs commit and tag objects and verifies their
+// signatures. Like Git, it runs gpg for OpenPGP signatures and ssh-keygen
+// for SSH signatures rather than handling keys itself, so keys stay in the
+// user's keyring or agent.
+package signing
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Format is a kind of signature
+type Format string
+
+const (
+	FormatOpenPGP Format = "openpgp"
+	FormatSSH     Format = "ssh"
+)
+
+// Armor headers that start a signature of each format
+const (
+	pgpHeader = "-----BEGIN PGP SIGNATURE-----"
+	sshHeader = "-----BEGIN SSH SIGNATURE-----"
+)
+
+// ParseFormat parses the value of gpg.format
+func ParseFormat(value string) (Format, error) {
+	switch Format(value) {
+	case "", FormatOpenPGP:
+		return FormatOpenPGP, nil
+	case FormatSSH:
+		return FormatSSH, nil
+	default:
+		return "", fmt.Errorf("unsupported signature format %q (supported: openpgp, ssh)", value)
+	}
+}
+
+// DetectFormat returns the format of signature from its armor
+func DetectFormat(signature []byte) (Format, bool) {
+	switch {
+	case bytes.HasPrefix(signature, []byte(pgpHeader)):
+		return FormatOpenPGP, true
+	case bytes.HasPrefix(signature, []byte(sshHeader)):
+		return FormatSSH, true
+	}
+	return "", false
+}
+
+// Signer creates signatures
+type Signer struct {
+	Format Format
+	// Program is the gpg or ssh-keygen executable, found in PATH by default
+	Program string
+	// Key is the key ID or user ID of an OpenPGP key, or the path of an SSH
+	// private key or of a public key whose private key is in ssh-agent
+	Key string
+}
+
+// Sign returns an armored detached signature of payload
+func (s *Signer) Sign(payload []byte) ([]byte, error) {
+	if s.Key == "" {
+		return nil, fmt.Errorf("no signing key configured (set user.signingKey)")
+	}
+	if s.Format == FormatSSH {
+		return s.signSSH(payload)
+	}
+	return s.signOpenPGP(payload)
+}
+
+func (s *Signer) signOpenPGP(payload []byte) ([]byte, error) {
+	cmd := exec.Command(program(s.Program, "gpg"), "--status-fd=2", "-bsau", s.Key)
+	cmd.Stdin = bytes.NewReader(payload)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+	// gpg can exit zero without signing, so look for its status line too
+	if err != nil || !strings.Contains("\n"+stderr.String(), "\n[GNUPG:] SIG_CREATED ") {
+		return nil, fmt.Errorf("gpg failed to sign the data: %s", strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
+
+func (s *Signer) signSSH(payload []byte) ([]byte, error) {
+	dir, err := os.MkdirTemp("", "vcs-sign-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+
+	buffer := filepath.Join(dir, "payload")
+	if err := os.WriteFile(buffer, payload, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write payload: %w", err)
+	}
+	args := []string{"-Y", "sign", "-n", "git", "-f", expandHome(s.Key)}
+	if strings.HasSuffix(s.Key, ".pub") {
+		// The private key is in ssh-agent
+		args = append(args, "-U")
+	}
+	cmd := exec.Command(program(s.Program, "ssh-keygen"), append(args, buffer)...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("ssh-keygen failed to sign the data: %s", strings.TrimSpace(stderr.String()))
+	}
+	signature, err := os.ReadFile(buffer + ".sig")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read SSH signature: %w", err)
+	}
+	return signature, nil
+}
+
+// Verifier checks signatures
+type Verifier struct {
+	// GPGProgram and SSHProgram are the gpg and ssh-keygen executables,
+	// found in PATH by default
+	GPGProgram string
+	SSHProgram string
+	// AllowedSigners is the path of the ssh-keygen allowed signers file
+	// listing the principals trusted to sign with each SSH key
+	AllowedSigners string
+}
+
+// Result is the outcome of verifying a signature
+type Result struct {
+	Format Format
+	// Good is set when the signature is valid and made by a trusted key
+	Good bool
+	// Signer is the user ID or principal the key belongs to, if known
+	Signer string
+	// Key is the fingerprint or ID of the key that made the signature
+	Key string
+	// Output is what the verifying program reported, for the user
+	Output string
+}
+
+// Verify checks that signature is a signature of payload. An error means
+// the signature could not be checked at all; a bad signature is reported
+// in the result instead.
+func (v *Verifier) Verify(payload, signature []byte) (*Result, error) {
+	format, ok := DetectFormat(signature)
+	if !ok {
+		return nil, fmt.Errorf("unrecognized signature format")
+	}
+
+	dir, err := os.MkdirTemp("", "vcs-verify-")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create temporary directory: %w", err)
+	}
+	defer os.RemoveAll(dir)
+	sigPath := filepath.Join(dir, "signature")
+	if err := os.WriteFile(sigPath, signature, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write signature: %w", err)
+	}
+
+	if format == FormatSSH {
+		return v.verifySSH(payload, sigPath)
+	}
+	return v.verifyOpenPGP(payload, sigPath)
+}
+
+func (v *Verifier) verifyOpenPGP(payload []byte, sigPath string) (*Result, error) {
+	cmd := exec.Command(program(v.GPGProgram, "gpg"), "--keyid-format=long", "--status-fd=1", "--verify", sigPath, "-")
+	cmd.Stdin = bytes.NewReader(payload)
+	var status, stderr bytes.Buffer
+	cmd.Stdout = &status
+	cmd.Stderr = &stderr
+	runErr := cmd.Run()
+	if _, ok := runErr.(*exec.ExitError); runErr != nil && !ok {
+		return nil, fmt.Errorf("failed to run gpg: %w", runErr)
+	}
+
+	result := &Result{Format: FormatOpenPGP, Output: stderr.String()}
+	for _, line := range strings.Split(status.String(), "\n") {
+		fields := strings.SplitN(strings.TrimPrefix(line, "[GNUPG:] "), " ", 3)
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "GOODSIG":
+			result.Good = runErr == nil
+			result.Key = fields[1]
+			if len(fields) > 2 {
+				result.Signer = fields[2]
+			}
+		case "BADSIG", "EXPKEYSIG", "REVKEYSIG":
+			result.Good = false
+			result.Key = fields[1]
+			if len(fields) > 2 {
+				result.Signer = fields[2]
+			}
+		case "ERRSIG":
+			result.Key = fields[1]
+		case "VALIDSIG":
+			result.Key = fields[1]
+		}
+	}
+	return result, nil
+}
+
+func (v *Verifier) verifySSH(payload []byte, sigPath string) (*Result, error) {
+	if v.AllowedSigners == "" {
+		return nil, fmt.Errorf("gpg.ssh.allowedSignersFile needs to be configured and exist for ssh signature verification")
+	}
+	allowed := expandHome(v.AllowedSigners)
+	if _, err := os.Stat(allowed); err != nil {
+		return nil, fmt.Errorf("gpg.ssh.allowedSignersFile needs to be configured and exist for ssh signature verification: %w", err)
+	}
+	sshKeygen := program(v.SSHProgram, "ssh-keygen")
+
+	result := &Result{Format: FormatSSH}
+	find := exec.Command(sshKeygen, "-Y", "find-principals", "-f", allowed, "-s", sigPath)
+	var principals, stderr bytes.Buffer
+	find.Stdout = &principals
+	find.Stderr = &stderr
+	if err := find.Run(); err != nil {
+		// No principal is trusted with the key; report who signed anyway
+		check := exec.Command(sshKeygen, "-Y", "check-novalidate", "-n", "git", "-s", sigPath)
+		check.Stdin = bytes.NewReader(payload)
+		out, _ := check.CombinedOutput()
+		result.Output = string(out) + "No principal matched.\n"
+		result.Key = sshKeyFingerprint(string(out))
+		return result, nil
+	}
+
+	for _, principal := range strings.Split(strings.TrimSpace(principals.String()), "\n") {
+		verify := exec.Command(sshKeygen, "-Y", "verify", "-n", "git", "-f", allowed, "-I", principal, "-s", sigPath)
+		verify.Stdin = bytes.NewReader(payload)
+		out, err := verify.CombinedOutput()
+		result.Output = string(out)
+		result.Signer = principal
+		result.Key = sshKeyFingerprint(string(out))
+		if err == nil {
+			result.Good = true
+			break
+		}
+	}
+	return result, nil
+}
+
+// sshKeyFingerprint extracts the key fingerprint from ssh-keygen output
+// such as `Good "git" signature for x with ED25519 key SHA256:...`
+func sshKeyFingerprint(output string) string {
+	for _, field := range strings.Fields(output) {
+		if strings.HasPrefix(field, "SHA256:") {
+			return field
+		}
+	}
+	return ""
+}
+
+// program returns configured, or else the default program name
+func program(configured, fallback string) string {
+	if configured != "" {
+		return configured
+	}
+	return fallback
+}
+
+// expandHome expands a leading ~/ in path to the home directory
+func expandHome(path string) string {
+	if rest, ok := strings.CutPrefix(path, "~/"); ok {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, rest)
+		}
+	}
+	return path
+}
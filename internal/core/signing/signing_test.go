@@ -0,0 +1,121 @@
+package signing
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+const commitPayload = "tree 6be660545b31f61a82a87d2b1915f0b88bb9f16f\n" +
+	"author Test <test@example.com> 1700000000 +0000\n" +
+	"committer Test <test@example.com> 1700000000 +0000\n" +
+	"\n" +
+	"Signed\n\nBody\n"
+
+const armoredSignature = "-----BEGIN SSH SIGNATURE-----\nU1NIU0lH\n\nAAAA\n-----END SSH SIGNATURE-----\n"
+
+func TestCommitSignatureRoundTrip(t *testing.T) {
+	signed := AddCommitSignature([]byte(commitPayload), []byte(armoredSignature))
+	want := "tree 6be660545b31f61a82a87d2b1915f0b88bb9f16f\n" +
+		"author Test <test@example.com> 1700000000 +0000\n" +
+		"committer Test <test@example.com> 1700000000 +0000\n" +
+		"gpgsig -----BEGIN SSH SIGNATURE-----\n U1NIU0lH\n \n AAAA\n -----END SSH SIGNATURE-----\n" +
+		"\n" +
+		"Signed\n\nBody\n"
+	if string(signed) != want {
+		t.Fatalf("AddCommitSignature() =\n%s\nwant\n%s", signed, want)
+	}
+
+	payload, signature := SplitCommit(signed)
+	if string(payload) != commitPayload || string(signature) != armoredSignature {
+		t.Errorf("SplitCommit() = %q, %q", payload, signature)
+	}
+
+	payload, signature = SplitCommit([]byte(commitPayload))
+	if string(payload) != commitPayload || signature != nil {
+		t.Errorf("SplitCommit() of an unsigned commit = %q, %q", payload, signature)
+	}
+}
+
+func TestSplitTag(t *testing.T) {
+	payload := "object 6be660545b31f61a82a87d2b1915f0b88bb9f16f\ntype commit\ntag v1\ntagger Test <test@example.com> 1700000000 +0000\n\nRelease\n"
+	gotPayload, signature := SplitTag([]byte(payload + armoredSignature))
+	if string(gotPayload) != payload || string(signature) != armoredSignature {
+		t.Errorf("SplitTag() = %q, %q", gotPayload, signature)
+	}
+	if _, signature := SplitTag([]byte(payload)); signature != nil {
+		t.Errorf("SplitTag() of an unsigned tag returned %q", signature)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for value, want := range map[string]Format{"": FormatOpenPGP, "openpgp": FormatOpenPGP, "ssh": FormatSSH} {
+		if got, err := ParseFormat(value); err != nil || got != want {
+			t.Errorf("ParseFormat(%q) = %q, %v", value, got, err)
+		}
+	}
+	if _, err := ParseFormat("x509"); err == nil {
+		t.Error("ParseFormat() accepted x509")
+	}
+}
+
+// sshKey creates an SSH key without a passphrase and an allowed signers
+// file trusting it for principal
+func sshKey(t *testing.T, principal string) (key, allowed string) {
+	if _, err := exec.LookPath("ssh-keygen"); err != nil {
+		t.Skip("ssh-keygen is not installed")
+	}
+	dir := t.TempDir()
+	key = filepath.Join(dir, "key")
+	if out, err := exec.Command("ssh-keygen", "-q", "-t", "ed25519", "-N", "", "-C", "test", "-f", key).CombinedOutput(); err != nil {
+		t.Fatalf("ssh-keygen failed: %v\n%s", err, out)
+	}
+	public, err := os.ReadFile(key + ".pub")
+	if err != nil {
+		t.Fatal(err)
+	}
+	allowed = filepath.Join(dir, "allowed_signers")
+	if err := os.WriteFile(allowed, []byte(principal+" "+string(public)), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return key, allowed
+}
+
+func TestSSHSignAndVerify(t *testing.T) {
+	key, allowed := sshKey(t, "test@example.com")
+	signer := &Signer{Format: FormatSSH, Key: key}
+	signature, err := signer.Sign([]byte(commitPayload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if format, ok := DetectFormat(signature); !ok || format != FormatSSH {
+		t.Fatalf("DetectFormat() = %q, %v", format, ok)
+	}
+
+	verifier := &Verifier{AllowedSigners: allowed}
+	result, err := verifier.Verify([]byte(commitPayload), signature)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Good || result.Signer != "test@example.com" || result.Key == "" {
+		t.Errorf("Verify() = %+v", result)
+	}
+
+	tampered := bytes.Replace([]byte(commitPayload), []byte("Signed"), []byte("Forged"), 1)
+	if result, err := verifier.Verify(tampered, signature); err != nil || result.Good {
+		t.Errorf("Verify() of a tampered payload = %+v, %v", result, err)
+	}
+
+	// A valid signature by a key nobody is trusted with is not good
+	_, otherAllowed := sshKey(t, "other@example.com")
+	result, err = (&Verifier{AllowedSigners: otherAllowed}).Verify([]byte(commitPayload), signature)
+	if err != nil || result.Good {
+		t.Errorf("Verify() with an untrusted key = %+v, %v", result, err)
+	}
+
+	if _, err := (&Verifier{}).Verify([]byte(commitPayload), signature); err == nil {
+		t.Error("Verify() checked an SSH signature without allowed signers")
+	}
+}
@@ -0,0 +1,181 @@
+// Package submodule reads and writes the .gitmodules file, which names the
+// repositories embedded in a superproject. The superproject's tree records
+// each submodule as a gitlink, an entry of mode 160000 holding the commit
+// the submodule is checked out at.
+package submodule
+
+import (
+	"fmt"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/fenilsonani/vcs/internal/core/config"
+)
+
+// ModulesFile is the name of the file listing the submodules, at the top of
+// the superproject's working tree
+const ModulesFile = ".gitmodules"
+
+// Submodule is a submodule as described in .gitmodules
+type Submodule struct {
+	// Name identifies the submodule in config and under modules/ in the
+	// superproject's repository directory. It defaults to the path.
+	Name   string
+	Path   string
+	URL    string
+	Branch string
+}
+
+// Modules is the parsed .gitmodules file of a working tree
+type Modules struct {
+	file *config.File
+}
+
+// Read reads the .gitmodules file of workTree. A missing file reads as no
+// submodules.
+func Read(workTree string) (*Modules, error) {
+	file, err := config.ReadFile(filepath.Join(workTree, ModulesFile))
+	if err != nil {
+		return nil, err
+	}
+	return &Modules{file: file}, nil
+}
+
+// Parse parses the content of a .gitmodules file
+func Parse(data []byte) (*Modules, error) {
+	file, err := config.Parse(ModulesFile, data)
+	if err != nil {
+		return nil, err
+	}
+	return &Modules{file: file}, nil
+}
+
+// List returns the submodules that have a path, sorted by path
+func (m *Modules) List() []*Submodule {
+	var names []string
+	seen := make(map[string]bool)
+	for _, entry := range m.file.Entries() {
+		rest, ok := strings.CutPrefix(entry.Key, "submodule.")
+		if !ok {
+			continue
+		}
+		dot := strings.LastIndex(rest, ".")
+		if dot < 0 || seen[rest[:dot]] {
+			continue
+		}
+		seen[rest[:dot]] = true
+		names = append(names, rest[:dot])
+	}
+
+	var modules []*Submodule
+	for _, name := range names {
+		if sm := m.Get(name); sm != nil {
+			modules = append(modules, sm)
+		}
+	}
+	sort.Slice(modules, func(i, j int) bool { return modules[i].Path < modules[j].Path })
+	return modules
+}
+
+// Get returns the submodule called name, or nil when it has no path
+func (m *Modules) Get(name string) *Submodule {
+	p, ok := m.file.Get("submodule." + name + ".path")
+	if !ok || p == "" {
+		return nil
+	}
+	url, _ := m.file.Get("submodule." + name + ".url")
+	branch, _ := m.file.Get("submodule." + name + ".branch")
+	return &Submodule{Name: name, Path: path.Clean(p), URL: url, Branch: branch}
+}
+
+// ByPath returns the submodule at path, or nil when there is none
+func (m *Modules) ByPath(p string) *Submodule {
+	p = path.Clean(filepath.ToSlash(p))
+	for _, sm := range m.List() {
+		if sm.Path == p {
+			return sm
+		}
+	}
+	return nil
+}
+
+// Add records sm, replacing any submodule of the same name
+func (m *Modules) Add(sm *Submodule) error {
+	if err := ValidateName(sm.Name); err != nil {
+		return err
+	}
+	m.file.RemoveSection("submodule", sm.Name)
+	if err := m.file.Set("submodule."+sm.Name+".path", sm.Path); err != nil {
+		return err
+	}
+	if err := m.file.Set("submodule."+sm.Name+".url", sm.URL); err != nil {
+		return err
+	}
+	if sm.Branch != "" {
+		return m.file.Set("submodule."+sm.Name+".branch", sm.Branch)
+	}
+	return nil
+}
+
+// Save writes the file back to the working tree it was read from
+func (m *Modules) Save() error {
+	return m.file.Save()
+}
+
+// ValidateName rejects submodule names that could escape modules/ in the
+// repository directory when used as a path
+func ValidateName(name string) error {
+	if name == "" {
+		return fmt.Errorf("submodule name is empty")
+	}
+	for _, part := range strings.Split(filepath.ToSlash(name), "/") {
+		if part == ".." {
+			return fmt.Errorf("submodule name '%s' may not contain '..'", name)
+		}
+	}
+	return nil
+}
+
+// IsRelativeURL reports whether url is relative to the superproject's own
+// URL, as ./lib or ../lib.git is
+func IsRelativeURL(url string) bool {
+	return strings.HasPrefix(url, "./") || strings.HasPrefix(url, "../")
+}
+
+// ResolveURL resolves a relative submodule URL against base, the URL of the
+// superproject: each leading ../ removes a component from the end of base,
+// as in Git, so ../lib.git next to https://host/group/app.git is
+// https://host/group/lib.git. Other URLs are returned unchanged.
+func ResolveURL(url, base string) (string, error) {
+	if !IsRelativeURL(url) {
+		return url, nil
+	}
+	base = strings.TrimRight(base, "/")
+	if base == "" {
+		return "", fmt.Errorf("cannot resolve relative URL %s without a superproject URL", url)
+	}
+
+	rest := url
+	for {
+		if r, ok := strings.CutPrefix(rest, "./"); ok {
+			rest = r
+			continue
+		}
+		r, ok := strings.CutPrefix(rest, "../")
+		if !ok {
+			break
+		}
+		rest = r
+		cut := strings.LastIndexAny(base, "/:")
+		if cut < 0 || strings.HasSuffix(base[:cut+1], "://") {
+			return "", fmt.Errorf("relative URL %s leaves %s", url, base)
+		}
+		base = base[:cut]
+	}
+	if rest == "" {
+		return base, nil
+	}
+	return base + "/" + rest, nil
+}
@@ -0,0 +1,102 @@
+package submodule
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseAndList(t *testing.T) {
+	m, err := Parse([]byte(`[submodule "zlib"]
+	path = vendor/zlib
+	url = https://example.com/zlib.git
+	branch = stable
+[submodule "docs"]
+	path = docs/
+	url = ../docs.git
+[submodule "broken"]
+	url = https://example.com/broken.git
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list := m.List()
+	if len(list) != 2 {
+		t.Fatalf("List() returned %d submodules, want 2", len(list))
+	}
+	if *list[0] != (Submodule{Name: "docs", Path: "docs", URL: "../docs.git"}) {
+		t.Errorf("List()[0] = %+v", list[0])
+	}
+	if *list[1] != (Submodule{Name: "zlib", Path: "vendor/zlib", URL: "https://example.com/zlib.git", Branch: "stable"}) {
+		t.Errorf("List()[1] = %+v", list[1])
+	}
+	if sm := m.ByPath("vendor/zlib/"); sm == nil || sm.Name != "zlib" {
+		t.Errorf("ByPath() = %+v", sm)
+	}
+	if m.Get("broken") != nil {
+		t.Error("Get() returned a submodule without a path")
+	}
+}
+
+func TestAddAndSave(t *testing.T) {
+	dir := t.TempDir()
+	m, err := Read(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.List()) != 0 {
+		t.Fatal("missing .gitmodules lists submodules")
+	}
+
+	if err := m.Add(&Submodule{Name: "lib", Path: "lib", URL: "https://example.com/old.git", Branch: "main"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Add(&Submodule{Name: "lib", Path: "lib", URL: "https://example.com/lib.git"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Add(&Submodule{Name: "../escape", Path: "escape"}); err == nil {
+		t.Error("Add() accepted a name with '..'")
+	}
+	if err := m.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(filepath.Join(dir, ModulesFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	read, err := Parse(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	list := read.List()
+	if len(list) != 1 || *list[0] != (Submodule{Name: "lib", Path: "lib", URL: "https://example.com/lib.git"}) {
+		t.Errorf("saved submodules = %+v\n%s", list, data)
+	}
+}
+
+func TestResolveURL(t *testing.T) {
+	tests := []struct {
+		url, base, want string
+	}{
+		{"../lib.git", "https://host/group/app.git", "https://host/group/lib.git"},
+		{"../../other/lib.git", "https://host/group/app.git", "https://host/other/lib.git"},
+		{"./lib.git", "https://host/group/app.git", "https://host/group/app.git/lib.git"},
+		{"../lib", "/srv/repos/app/", "/srv/repos/lib"},
+		{"https://elsewhere/lib.git", "https://host/app.git", "https://elsewhere/lib.git"},
+	}
+	for _, tt := range tests {
+		got, err := ResolveURL(tt.url, tt.base)
+		if err != nil || got != tt.want {
+			t.Errorf("ResolveURL(%q, %q) = %q, %v, want %q", tt.url, tt.base, got, err, tt.want)
+		}
+	}
+
+	if _, err := ResolveURL("../../../lib.git", "https://host/app.git"); err == nil {
+		t.Error("ResolveURL() went above the host")
+	}
+	if _, err := ResolveURL("../lib.git", ""); err == nil {
+		t.Error("ResolveURL() resolved without a base")
+	}
+}
@@ -0,0 +1,491 @@
+// Package trailer reads and adds the trailers of commit messages: the
+// "Token: value" lines, such as "Signed-off-by: A U Thor <a@example.com>",
+// of the last paragraph of a message. It follows Git's rules for finding
+// them and for placing new ones, which "git interpret-trailers" documents.
+package trailer
+
+import (
+	"fmt"
+	"strings"
+)
+
+// SignedOffBy is the token of the trailer commit --signoff adds
+const SignedOffBy = "Signed-off-by"
+
+// generatedPrefixes start lines Git writes into trailer blocks, which make
+// a paragraph that is mostly trailers count as a trailer block
+var generatedPrefixes = []string{SignedOffBy + ": ", "(cherry picked from commit "}
+
+// Where is where a trailer is added: after the last or before the first
+// trailer with the same token, or at the end or start of the block
+type Where int
+
+const (
+	WhereEnd Where = iota
+	WhereAfter
+	WhereBefore
+	WhereStart
+)
+
+// IfExists is what is done with a trailer whose token the block has
+type IfExists int
+
+const (
+	// ExistsAddIfDifferentNeighbor adds the trailer unless the trailer it
+	// would be next to is the same
+	ExistsAddIfDifferentNeighbor IfExists = iota
+	// ExistsAddIfDifferent adds it unless the block has the same trailer
+	ExistsAddIfDifferent
+	ExistsAdd
+	// ExistsReplace replaces the trailer with the same token
+	ExistsReplace
+	ExistsDoNothing
+)
+
+// IfMissing is what is done with a trailer whose token the block lacks
+type IfMissing int
+
+const (
+	MissingAdd IfMissing = iota
+	MissingDoNothing
+)
+
+// ParseWhere parses a trailer.where value: after, before, end or start
+func ParseWhere(s string) (Where, error) {
+	switch strings.ToLower(s) {
+	case "end":
+		return WhereEnd, nil
+	case "after":
+		return WhereAfter, nil
+	case "before":
+		return WhereBefore, nil
+	case "start":
+		return WhereStart, nil
+	}
+	return 0, fmt.Errorf("unknown trailer where value %q", s)
+}
+
+// ParseIfExists parses a trailer.ifExists value
+func ParseIfExists(s string) (IfExists, error) {
+	switch strings.ToLower(s) {
+	case "addifdifferentneighbor":
+		return ExistsAddIfDifferentNeighbor, nil
+	case "addifdifferent":
+		return ExistsAddIfDifferent, nil
+	case "add":
+		return ExistsAdd, nil
+	case "replace":
+		return ExistsReplace, nil
+	case "donothing":
+		return ExistsDoNothing, nil
+	}
+	return 0, fmt.Errorf("unknown trailer ifExists value %q", s)
+}
+
+// ParseIfMissing parses a trailer.ifMissing value
+func ParseIfMissing(s string) (IfMissing, error) {
+	switch strings.ToLower(s) {
+	case "add":
+		return MissingAdd, nil
+	case "donothing":
+		return MissingDoNothing, nil
+	}
+	return 0, fmt.Errorf("unknown trailer ifMissing value %q", s)
+}
+
+// Placement is how a trailer is added to a block
+type Placement struct {
+	Where     Where
+	IfExists  IfExists
+	IfMissing IfMissing
+}
+
+// Trailer is a line of a trailer block. A line that is not a trailer,
+// such as "(cherry picked from commit ...)", has no token and is kept
+// whole as the value.
+type Trailer struct {
+	Token string
+	// Value may hold continuation lines, each after a newline and
+	// starting with whitespace
+	Value string
+}
+
+// format returns the trailer as a line, without the newline. A token
+// ending with one of separators, such as the key "Bug #", is written as it
+// is before the value; others are followed by the first separator.
+func (t Trailer) format(separators string) string {
+	if t.Token == "" {
+		return t.Value
+	}
+	token := strings.TrimRight(t.Token, " \t")
+	if token != "" && strings.IndexByte(separators, token[len(token)-1]) >= 0 {
+		return t.Token + t.Value
+	}
+	if t.Value == "" {
+		return t.Token + separators[:1]
+	}
+	return t.Token + separators[:1] + " " + t.Value
+}
+
+// same reports whether two trailers have the same token, compared without
+// case, and the same value
+func (t Trailer) same(o Trailer) bool {
+	return sameToken(t, o) && strings.EqualFold(t.Value, o.Value)
+}
+
+func sameToken(a, b Trailer) bool {
+	return a.Token != "" && strings.EqualFold(tokenName(a.Token), tokenName(b.Token))
+}
+
+// tokenName returns token without the separator and spaces a configured
+// key may end with
+func tokenName(token string) string {
+	return strings.TrimRightFunc(token, func(r rune) bool {
+		return r > 0x7f || !isAlnum(byte(r)) && r != '-'
+	})
+}
+
+// Options changes how messages are parsed
+type Options struct {
+	// Separators are the characters that may end a token, ":" when
+	// empty. Trailers are written with the first.
+	Separators string
+	// Known are tokens that, like Signed-off-by, make a last paragraph
+	// that is at least a quarter trailers a trailer block
+	Known []string
+	// NoDivider treats a "---" line as part of the message rather than as
+	// the start of a patch, which ends the message
+	NoDivider bool
+}
+
+// Message is a message split around its trailer block
+type Message struct {
+	// Body is the message before the trailer block, with the blank line
+	// separating them
+	Body     string
+	Trailers []Trailer
+	// Tail is what follows the block: trailing comments and blank lines,
+	// and a patch after a "---" line
+	Tail       string
+	separators string
+}
+
+// Parse splits message around its trailer block. The first paragraph is
+// the subject and never a trailer block; the last paragraph is one when
+// every line is a trailer or continues the one before it, or when a
+// quarter of it are trailers and one of them is Git's or a known token.
+// Comment lines starting with "#" are left out of the block.
+func Parse(message string, opts Options) *Message {
+	separators := opts.Separators
+	if separators == "" {
+		separators = ":"
+	}
+	m := &Message{separators: separators}
+
+	lines := splitLines(message)
+	end := len(lines)
+	if !opts.NoDivider {
+		for i, line := range lines {
+			if isDivider(line) {
+				end = i
+				break
+			}
+		}
+	}
+	// Trailing comments and blank lines are not part of the message
+	for end > 0 && (isComment(lines[end-1]) || isBlank(lines[end-1])) {
+		end--
+	}
+
+	start := blockStart(lines[:end], separators, opts.Known)
+	m.Body = strings.Join(lines[:start], "")
+	m.Tail = strings.Join(lines[end:], "")
+	for _, line := range lines[start:end] {
+		line = strings.TrimRight(line, "\n")
+		switch {
+		case isComment(line):
+			continue
+		case len(line) > 0 && isSpace(line[0]) && len(m.Trailers) > 0:
+			last := &m.Trailers[len(m.Trailers)-1]
+			last.Value += "\n" + line
+			continue
+		}
+		m.Trailers = append(m.Trailers, parseLine(line, separators))
+	}
+	return m
+}
+
+// blockStart returns the index of the first line of the trailer block
+// ending lines, or len(lines) when there is none
+func blockStart(lines []string, separators string, known []string) int {
+	// The subject paragraph is never trailers
+	title := 0
+	for title < len(lines) && (isComment(lines[title]) || !isBlank(lines[title])) {
+		title++
+	}
+
+	trailers, others, continuations := 0, 0, 0
+	recognized, onlySpaces := false, true
+	for i := len(lines) - 1; i >= title; i-- {
+		line := lines[i]
+		if isComment(line) {
+			others += continuations
+			continuations = 0
+			continue
+		}
+		if isBlank(line) {
+			if onlySpaces {
+				continue
+			}
+			others += continuations
+			if (recognized && trailers*3 >= others) || (trailers > 0 && others == 0) {
+				return i + 1
+			}
+			return len(lines)
+		}
+		onlySpaces = false
+
+		generated := false
+		for _, prefix := range generatedPrefixes {
+			if strings.HasPrefix(line, prefix) {
+				generated = true
+				break
+			}
+		}
+		if generated {
+			trailers++
+			continuations = 0
+			recognized = true
+			continue
+		}
+		if pos := findSeparator(line, separators); pos >= 1 && !isSpace(line[0]) {
+			trailers++
+			continuations = 0
+			token := strings.TrimSpace(line[:pos])
+			for _, k := range known {
+				if strings.EqualFold(tokenName(k), token) {
+					recognized = true
+				}
+			}
+		} else if isSpace(line[0]) {
+			continuations++
+		} else {
+			others += 1 + continuations
+			continuations = 0
+		}
+	}
+	return len(lines)
+}
+
+// findSeparator returns the index of the separator ending the token that
+// starts line, or -1 when it does not start with one. Tokens are letters,
+// digits and dashes, which may be followed by spaces.
+func findSeparator(line string, separators string) int {
+	spaces := false
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		if strings.IndexByte(separators, c) >= 0 {
+			return i
+		}
+		if !spaces && (isAlnum(c) || c == '-') {
+			continue
+		}
+		if i > 0 && (c == ' ' || c == '\t') {
+			spaces = true
+			continue
+		}
+		break
+	}
+	return -1
+}
+
+// parseLine parses a line of a trailer block
+func parseLine(line, separators string) Trailer {
+	pos := findSeparator(line, separators)
+	if pos < 1 {
+		return Trailer{Value: line}
+	}
+	return Trailer{Token: strings.TrimSpace(line[:pos]), Value: strings.TrimSpace(line[pos+1:])}
+}
+
+// ParseTrailer parses a trailer given as "token=value" or with one of
+// separators, as --trailer takes it. A token alone has an empty value.
+func ParseTrailer(arg, separators string) (Trailer, error) {
+	if separators == "" {
+		separators = ":"
+	}
+	pos := strings.IndexAny(arg, "="+separators)
+	if pos < 0 {
+		pos = len(arg)
+	}
+	token := strings.TrimSpace(arg[:pos])
+	if token == "" {
+		return Trailer{}, fmt.Errorf("empty trailer token in trailer '%s'", arg)
+	}
+	value := ""
+	if pos < len(arg) {
+		value = strings.TrimSpace(arg[pos+1:])
+	}
+	return Trailer{Token: token, Value: value}, nil
+}
+
+// Add adds t to the block as p asks. With the token in the block, the
+// trailer searched from the end, for WhereAfter and WhereEnd, or from the
+// start decides what IfExists does; the new trailer goes after or before
+// it for WhereAfter and WhereBefore and at the end or start otherwise.
+func (m *Message) Add(t Trailer, p Placement) {
+	backwards := p.Where == WhereAfter || p.Where == WhereEnd
+	found := -1
+	for k := range m.Trailers {
+		i := k
+		if backwards {
+			i = len(m.Trailers) - 1 - k
+		}
+		if sameToken(m.Trailers[i], t) {
+			found = i
+			break
+		}
+	}
+
+	if found < 0 {
+		if p.IfMissing == MissingDoNothing {
+			return
+		}
+		if backwards {
+			m.insert(len(m.Trailers), t)
+		} else {
+			m.insert(0, t)
+		}
+		return
+	}
+
+	// on is the trailer the new one goes next to
+	on := found
+	switch p.Where {
+	case WhereEnd:
+		on = len(m.Trailers) - 1
+	case WhereStart:
+		on = 0
+	}
+	at := on
+	if backwards {
+		at = on + 1
+	}
+
+	switch p.IfExists {
+	case ExistsDoNothing:
+		return
+	case ExistsReplace:
+		m.insert(at, t)
+		if at <= found {
+			found++
+		}
+		m.Trailers = append(m.Trailers[:found], m.Trailers[found+1:]...)
+	case ExistsAdd:
+		m.insert(at, t)
+	case ExistsAddIfDifferent:
+		for _, existing := range m.Trailers {
+			if existing.same(t) {
+				return
+			}
+		}
+		m.insert(at, t)
+	case ExistsAddIfDifferentNeighbor:
+		if m.Trailers[on].same(t) {
+			return
+		}
+		m.insert(at, t)
+	}
+}
+
+func (m *Message) insert(i int, t Trailer) {
+	m.Trailers = append(m.Trailers, Trailer{})
+	copy(m.Trailers[i+1:], m.Trailers[i:])
+	m.Trailers[i] = t
+}
+
+// Unfold joins the continuation lines of each trailer's value into one
+// line
+func (m *Message) Unfold() {
+	for i := range m.Trailers {
+		m.Trailers[i].Value = strings.Join(strings.Fields(m.Trailers[i].Value), " ")
+	}
+}
+
+// TrimEmpty removes the trailers whose value is empty
+func (m *Message) TrimEmpty() {
+	kept := m.Trailers[:0]
+	for _, t := range m.Trailers {
+		if t.Token == "" || strings.TrimSpace(t.Value) != "" {
+			kept = append(kept, t)
+		}
+	}
+	m.Trailers = kept
+}
+
+// TrailerText returns the trailer block, a line for each trailer
+func (m *Message) TrailerText() string {
+	var b strings.Builder
+	for _, t := range m.Trailers {
+		b.WriteString(t.format(m.separators))
+		b.WriteByte('\n')
+	}
+	return b.String()
+}
+
+// String returns the message with its trailer block, set apart from the
+// body by a blank line
+func (m *Message) String() string {
+	body := m.Body
+	if len(m.Trailers) > 0 && strings.TrimSpace(body) != "" && !strings.HasSuffix(body, "\n\n") {
+		if !strings.HasSuffix(body, "\n") {
+			body += "\n"
+		}
+		body += "\n"
+	}
+	return body + m.TrailerText() + m.Tail
+}
+
+// Append adds the trailer "token: value" to the end of message's trailer
+// block, starting one when it has none, unless the last trailer is the
+// same, as Git adds Signed-off-by
+func Append(message, token, value string) string {
+	m := Parse(message, Options{})
+	m.Add(Trailer{Token: token, Value: value}, Placement{})
+	return m.String()
+}
+
+// splitLines splits s into lines, each keeping its newline
+func splitLines(s string) []string {
+	var lines []string
+	for s != "" {
+		i := strings.IndexByte(s, '\n')
+		if i < 0 {
+			lines = append(lines, s)
+			break
+		}
+		lines = append(lines, s[:i+1])
+		s = s[i+1:]
+	}
+	return lines
+}
+
+func isDivider(line string) bool {
+	return strings.HasPrefix(line, "---") && (len(line) == 3 || isSpace(line[3]))
+}
+
+func isComment(line string) bool {
+	return strings.HasPrefix(line, "#")
+}
+
+func isBlank(line string) bool {
+	return strings.TrimSpace(line) == ""
+}
+
+func isSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\n' || c == '\r'
+}
+
+func isAlnum(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
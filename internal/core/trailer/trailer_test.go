@@ -0,0 +1,167 @@
+package trailer
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		name     string
+		message  string
+		opts     Options
+		body     string
+		trailers []Trailer
+		tail     string
+	}{
+		{
+			name:    "subject only",
+			message: "Subject: not a trailer\n",
+			body:    "Subject: not a trailer\n",
+		},
+		{
+			name:     "trailer block",
+			message:  "subject\n\nbody\n\nReviewed-by: A <a@example.com>\nFixes : #12\n",
+			body:     "subject\n\nbody\n\n",
+			trailers: []Trailer{{"Reviewed-by", "A <a@example.com>"}, {"Fixes", "#12"}},
+		},
+		{
+			name:    "last paragraph of prose",
+			message: "subject\n\nSee: the docs\nfor more about it\n",
+			body:    "subject\n\nSee: the docs\nfor more about it\n",
+		},
+		{
+			name:     "continuation lines",
+			message:  "subject\n\nNote: first\n  second\nAcked-by: B\n",
+			body:     "subject\n\n",
+			trailers: []Trailer{{"Note", "first\n  second"}, {"Acked-by", "B"}},
+		},
+		{
+			name:     "mostly prose with a sign-off",
+			message:  "subject\n\nwhy\nand how\nSigned-off-by: C <c@example.com>\n",
+			body:     "subject\n\n",
+			trailers: []Trailer{{"", "why"}, {"", "and how"}, {"Signed-off-by", "C <c@example.com>"}},
+		},
+		{
+			name:    "mostly prose with an unknown token",
+			message: "subject\n\nwhy\nand how\nHelped-by: D\n",
+			body:    "subject\n\nwhy\nand how\nHelped-by: D\n",
+		},
+		{
+			name:     "mostly prose with a known token",
+			message:  "subject\n\nwhy\nand how\nHelped-by: D\n",
+			opts:     Options{Known: []string{"helped-by"}},
+			body:     "subject\n\n",
+			trailers: []Trailer{{"", "why"}, {"", "and how"}, {"Helped-by", "D"}},
+		},
+		{
+			name:     "comments and a patch after the block",
+			message:  "subject\n\nAcked-by: B\n# comment\n\n---\n a.txt | 1 +\n",
+			body:     "subject\n\n",
+			trailers: []Trailer{{"Acked-by", "B"}},
+			tail:     "# comment\n\n---\n a.txt | 1 +\n",
+		},
+		{
+			name:     "other separators",
+			message:  "subject\n\nBug #42\n",
+			opts:     Options{Separators: ":#"},
+			body:     "subject\n\n",
+			trailers: []Trailer{{"Bug", "42"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := Parse(tt.message, tt.opts)
+			if m.Body != tt.body || m.Tail != tt.tail {
+				t.Errorf("Parse() body %q, tail %q; want %q, %q", m.Body, m.Tail, tt.body, tt.tail)
+			}
+			if !reflect.DeepEqual(m.Trailers, tt.trailers) {
+				t.Errorf("Parse() trailers = %q, want %q", m.Trailers, tt.trailers)
+			}
+		})
+	}
+}
+
+func TestMessageString(t *testing.T) {
+	m := Parse("subject\n\nbody\n\nFixes : #12\n  more\n# comment\n", Options{})
+	if got, want := m.String(), "subject\n\nbody\n\nFixes: #12\n  more\n# comment\n"; got != want {
+		t.Errorf("String() = %q, want %q", got, want)
+	}
+	m.Unfold()
+	if got, want := m.TrailerText(), "Fixes: #12 more\n"; got != want {
+		t.Errorf("TrailerText() = %q, want %q", got, want)
+	}
+}
+
+func TestAdd(t *testing.T) {
+	block := "subject\n\nA: 1\nB: 2\nA: 3\n"
+	tests := []struct {
+		name      string
+		trailer   Trailer
+		placement Placement
+		want      string
+	}{
+		{"end", Trailer{"C", "4"}, Placement{}, "A: 1\nB: 2\nA: 3\nC: 4\n"},
+		{"start", Trailer{"C", "4"}, Placement{Where: WhereStart}, "C: 4\nA: 1\nB: 2\nA: 3\n"},
+		{"after", Trailer{"a", "4"}, Placement{Where: WhereAfter}, "A: 1\nB: 2\nA: 3\na: 4\n"},
+		{"before", Trailer{"B", "4"}, Placement{Where: WhereBefore}, "A: 1\nB: 4\nB: 2\nA: 3\n"},
+		{"same neighbor", Trailer{"A", "3"}, Placement{}, "A: 1\nB: 2\nA: 3\n"},
+		{"different neighbor", Trailer{"A", "1"}, Placement{}, "A: 1\nB: 2\nA: 3\nA: 1\n"},
+		{"add if different", Trailer{"A", "1"}, Placement{IfExists: ExistsAddIfDifferent}, "A: 1\nB: 2\nA: 3\n"},
+		{"add", Trailer{"A", "3"}, Placement{IfExists: ExistsAdd}, "A: 1\nB: 2\nA: 3\nA: 3\n"},
+		{"replace", Trailer{"A", "5"}, Placement{IfExists: ExistsReplace}, "A: 1\nB: 2\nA: 5\n"},
+		{"replace after", Trailer{"A", "5"}, Placement{Where: WhereAfter, IfExists: ExistsReplace}, "A: 1\nB: 2\nA: 5\n"},
+		{"replace before", Trailer{"A", "5"}, Placement{Where: WhereBefore, IfExists: ExistsReplace}, "A: 5\nB: 2\nA: 3\n"},
+		{"do nothing", Trailer{"B", "4"}, Placement{IfExists: ExistsDoNothing}, "A: 1\nB: 2\nA: 3\n"},
+		{"missing do nothing", Trailer{"C", "4"}, Placement{IfMissing: MissingDoNothing}, "A: 1\nB: 2\nA: 3\n"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := Parse(block, Options{})
+			m.Add(tt.trailer, tt.placement)
+			if got := m.TrailerText(); got != tt.want {
+				t.Errorf("Add() block = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestAppend(t *testing.T) {
+	tests := []struct {
+		message string
+		want    string
+	}{
+		{"", "Signed-off-by: A <a@example.com>\n"},
+		{"subject", "subject\n\nSigned-off-by: A <a@example.com>\n"},
+		{"subject\n\nbody\n", "subject\n\nbody\n\nSigned-off-by: A <a@example.com>\n"},
+		{"subject\n\nAcked-by: B\n", "subject\n\nAcked-by: B\nSigned-off-by: A <a@example.com>\n"},
+		{"subject\n\nSigned-off-by: A <a@example.com>\n", "subject\n\nSigned-off-by: A <a@example.com>\n"},
+		{"subject\n\n# comment\n", "subject\n\nSigned-off-by: A <a@example.com>\n\n# comment\n"},
+	}
+	for _, tt := range tests {
+		if got := Append(tt.message, SignedOffBy, "A <a@example.com>"); got != tt.want {
+			t.Errorf("Append(%q) = %q, want %q", tt.message, got, tt.want)
+		}
+	}
+}
+
+func TestParseTrailer(t *testing.T) {
+	tests := []struct {
+		arg  string
+		want Trailer
+	}{
+		{"Acked-by=B", Trailer{"Acked-by", "B"}},
+		{"Acked-by: B <b@example.com>", Trailer{"Acked-by", "B <b@example.com>"}},
+		{"sob", Trailer{"sob", ""}},
+		{"Ref = a=b", Trailer{"Ref", "a=b"}},
+	}
+	for _, tt := range tests {
+		got, err := ParseTrailer(tt.arg, "")
+		if err != nil || got != tt.want {
+			t.Errorf("ParseTrailer(%q) = %q, %v; want %q", tt.arg, got, err, tt.want)
+		}
+	}
+	if _, err := ParseTrailer("=value", ""); err == nil {
+		t.Error("ParseTrailer() accepted an empty token")
+	}
+}
@@ -0,0 +1,332 @@
+package workdir
+
+import (
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Attribute is the state of one attribute for a path: "set", "unset",
+// "unspecified" or the value assigned to it
+type Attribute struct {
+	Name   string
+	Value  string
+	Source PatternSource
+}
+
+// Attributes manages .gitattributes rules
+type Attributes struct {
+	rules []attributeRule
+	// macros are the attributes each [attr] line defines a name for
+	macros map[string][]Attribute
+}
+
+// attributeRule is a pattern and the attributes it assigns
+type attributeRule struct {
+	pattern string
+	// base is the directory of the file the rule is from, relative to the
+	// top of the working tree; its pattern matches paths below it
+	base  string
+	re    *regexp.Regexp
+	attrs []Attribute
+}
+
+// NewAttributes creates a new attributes manager
+func NewAttributes() *Attributes {
+	return &Attributes{macros: make(map[string][]Attribute)}
+}
+
+// LoadFile loads rules from a .gitattributes file
+func (a *Attributes) LoadFile(filename string) error {
+	content, err := os.ReadFile(filename)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	a.parse(content, filename, "")
+	return nil
+}
+
+// parse adds the rules in content, read from filename in the directory
+// base. As in Git, macros are only defined by files that apply to the whole
+// working tree.
+func (a *Attributes) parse(content []byte, filename, base string) {
+	if a.macros == nil {
+		a.macros = make(map[string][]Attribute)
+	}
+	for i, line := range strings.Split(string(content), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 2 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+
+		source := PatternSource{File: filename, Line: i + 1, Pattern: strings.Join(fields, " ")}
+		var attrs []Attribute
+		for _, field := range fields[1:] {
+			attr := Attribute{Name: field, Value: "set", Source: source}
+			switch {
+			case strings.HasPrefix(field, "-"):
+				attr.Name, attr.Value = field[1:], "unset"
+			case strings.HasPrefix(field, "!"):
+				attr.Name, attr.Value = field[1:], "unspecified"
+			case strings.Contains(field, "="):
+				attr.Name, attr.Value, _ = strings.Cut(field, "=")
+			}
+			attrs = append(attrs, attr)
+		}
+
+		if name, ok := strings.CutPrefix(fields[0], "[attr]"); ok {
+			if base == "" && name != "" {
+				a.macros[name] = attrs
+			}
+			continue
+		}
+		a.rules = append(a.rules, attributeRule{
+			pattern: fields[0],
+			base:    base,
+			re:      compileAttributePattern(fields[0]),
+			attrs:   attrs,
+		})
+	}
+}
+
+// compileAttributePattern compiles pattern as Git matches it: without a
+// slash it matches the file name at any depth, otherwise the path relative
+// to the directory of its file, with ** spanning directories. Patterns
+// naming directories match nothing, since only files have attributes.
+func compileAttributePattern(pattern string) *regexp.Regexp {
+	if strings.HasSuffix(pattern, "/") || strings.HasPrefix(pattern, "!") {
+		return nil
+	}
+	anchored := strings.Contains(pattern, "/")
+	pattern = strings.TrimPrefix(pattern, "/")
+
+	var b strings.Builder
+	b.WriteString("^")
+	if !anchored {
+		b.WriteString("(?:.*/)?")
+	}
+	for i := 0; i < len(pattern); i++ {
+		switch c := pattern[i]; {
+		case strings.HasPrefix(pattern[i:], "**/"):
+			b.WriteString("(?:.*/)?")
+			i += 2
+		case strings.HasPrefix(pattern[i:], "**"):
+			b.WriteString(".*")
+			i++
+		case c == '*':
+			b.WriteString("[^/]*")
+		case c == '?':
+			b.WriteString("[^/]")
+		case c == '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				b.WriteString(`\[`)
+				continue
+			}
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case c == '\\' && i+1 < len(pattern):
+			i++
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		default:
+			b.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+	b.WriteString("$")
+
+	re, err := regexp.Compile(b.String())
+	if err != nil {
+		return nil
+	}
+	return re
+}
+
+// matches reports whether the rule applies to path, relative to the top of
+// the working tree
+func (r attributeRule) matches(path string) bool {
+	if r.re == nil {
+		return false
+	}
+	if r.base != "" {
+		rest, ok := strings.CutPrefix(path, r.base+"/")
+		if !ok {
+			return false
+		}
+		path = rest
+	}
+	return r.re.MatchString(path)
+}
+
+// Match returns the attributes that apply to path, sorted by name. When
+// several rules set the same attribute the last one wins, as in Git.
+func (a *Attributes) Match(path string) []Attribute {
+	path = filepath.ToSlash(path)
+
+	byName := make(map[string]Attribute)
+	for _, rule := range a.rules {
+		if !rule.matches(path) {
+			continue
+		}
+		for _, attr := range rule.attrs {
+			byName[attr.Name] = attr
+		}
+	}
+
+	attrs := make([]Attribute, 0, len(byName))
+	for _, attr := range byName {
+		attrs = append(attrs, attr)
+	}
+	sort.Slice(attrs, func(i, j int) bool { return attrs[i].Name < attrs[j].Name })
+	return attrs
+}
+
+// AttributeSet holds the attributes specified for a path by name
+type AttributeSet map[string]Attribute
+
+// IsSet reports whether the attribute name is set
+func (s AttributeSet) IsSet(name string) bool {
+	return s[name].Value == "set"
+}
+
+// IsUnset reports whether the attribute name is unset
+func (s AttributeSet) IsUnset(name string) bool {
+	return s[name].Value == "unset"
+}
+
+// Value returns the value assigned to the attribute name, if it has one
+// rather than being set or unset
+func (s AttributeSet) Value(name string) (string, bool) {
+	attr, ok := s[name]
+	if !ok || attr.Value == "set" || attr.Value == "unset" {
+		return "", false
+	}
+	return attr.Value, true
+}
+
+// builtinMacros are the macros Git defines
+var builtinMacros = map[string][]Attribute{
+	"binary": {{Name: "diff", Value: "unset"}, {Name: "merge", Value: "unset"}, {Name: "text", Value: "unset"}},
+}
+
+// AttributeReader returns the .gitattributes file of dir, relative to the
+// top of the working tree with "" for the top itself, and whether it has one
+type AttributeReader func(dir string) ([]byte, bool)
+
+// WorkTreeAttributes reads the .gitattributes files in workTree
+func WorkTreeAttributes(workTree string) AttributeReader {
+	return func(dir string) ([]byte, bool) {
+		data, err := os.ReadFile(filepath.Join(workTree, filepath.FromSlash(dir), ".gitattributes"))
+		return data, err == nil
+	}
+}
+
+// AttributeResolver resolves the attributes of paths as Git does: from the
+// global attributes file, the .gitattributes files of the top directory and
+// of each directory down to the path, then the repository's
+// info/attributes, each taking precedence over those before it. The
+// .gitattributes files are read once, on first use. A resolver may be used
+// from several goroutines.
+type AttributeResolver struct {
+	read   AttributeReader
+	global *Attributes
+	info   *Attributes
+	mu     sync.Mutex
+	dirs   map[string]*Attributes
+}
+
+// NewAttributeResolver returns a resolver reading .gitattributes files with
+// read, and the global and info attributes files at the given paths, either
+// of which may be "" or missing
+func NewAttributeResolver(read AttributeReader, globalFile, infoFile string) *AttributeResolver {
+	r := &AttributeResolver{
+		read:   read,
+		global: NewAttributes(),
+		info:   NewAttributes(),
+		dirs:   make(map[string]*Attributes),
+	}
+	if globalFile != "" {
+		r.global.LoadFile(globalFile)
+	}
+	if infoFile != "" {
+		r.info.LoadFile(infoFile)
+	}
+	return r
+}
+
+// dir returns the rules of the .gitattributes file in dir
+func (r *AttributeResolver) dir(dir string) *Attributes {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	attrs, ok := r.dirs[dir]
+	if !ok {
+		attrs = NewAttributes()
+		if r.read != nil {
+			if data, found := r.read(dir); found {
+				attrs.parse(data, path.Join(dir, ".gitattributes"), dir)
+			}
+		}
+		r.dirs[dir] = attrs
+	}
+	return attrs
+}
+
+// Lookup returns the attributes of path, relative to the top of the working
+// tree. Macros such as binary are expanded, and attributes made
+// unspecified again are left out.
+func (r *AttributeResolver) Lookup(name string) AttributeSet {
+	name = filepath.ToSlash(name)
+
+	files := []*Attributes{r.global, r.dir("")}
+	for i := range name {
+		if name[i] == '/' {
+			files = append(files, r.dir(name[:i]))
+		}
+	}
+	files = append(files, r.info)
+
+	macros := make(map[string][]Attribute)
+	for _, attrs := range []map[string][]Attribute{builtinMacros, r.global.macros, r.dir("").macros, r.info.macros} {
+		for macro, expansion := range attrs {
+			macros[macro] = expansion
+		}
+	}
+
+	set := make(AttributeSet)
+	for _, attrs := range files {
+		for _, rule := range attrs.rules {
+			if !rule.matches(name) {
+				continue
+			}
+			for _, attr := range rule.attrs {
+				set.assign(attr, macros)
+			}
+		}
+	}
+	return set
+}
+
+// assign applies attr, expanding it first when it sets a macro
+func (s AttributeSet) assign(attr Attribute, macros map[string][]Attribute) {
+	if expansion, ok := macros[attr.Name]; ok && attr.Value == "set" {
+		for _, expanded := range expansion {
+			expanded.Source = attr.Source
+			s.assign(expanded, nil)
+		}
+	}
+	if attr.Value == "unspecified" {
+		delete(s, attr.Name)
+		return
+	}
+	s[attr.Name] = attr
+}
@@ -4,9 +4,11 @@ import (
 	"io/fs"
 	"os"
 	"path/filepath"
-	"strings"
 	"time"
 
+	"github.com/fenilsonani/vcs/internal/core/gitdir"
+	"github.com/fenilsonani/vcs/internal/core/ignore"
+	"github.com/fenilsonani/vcs/internal/core/index"
 	"github.com/fenilsonani/vcs/internal/core/objects"
 )
 
@@ -17,6 +19,9 @@ type FileInfo struct {
 	Mode     os.FileMode
 	ModTime  time.Time
 	IsDir    bool
+	// IsRepo marks a directory holding a repository of its own, such as a
+	// submodule, whose files are not scanned
+	IsRepo   bool
 }
 
 // Status represents the status of a file
@@ -60,25 +65,46 @@ type FileStatus struct {
 
 // Scanner scans the working directory for changes
 type Scanner struct {
-	repoPath string
-	gitDir   string
-	ignores  *IgnorePatterns
+	repoPath   string
+	gitDir     string
+	ignores    *ignore.Matcher
+	attributes *Attributes
 }
 
-// NewScanner creates a new working directory scanner
+// NewScanner creates a new working directory scanner, which ignores paths
+// as the .gitignore files of the working tree and the repository's
+// info/exclude say
 func NewScanner(repoPath, gitDir string) *Scanner {
 	return &Scanner{
-		repoPath: repoPath,
-		gitDir:   gitDir,
-		ignores:  NewIgnorePatterns(),
+		repoPath:   repoPath,
+		gitDir:     gitDir,
+		ignores:    ignore.NewMatcher(ignore.WorkTreeReader(repoPath), filepath.Join(gitdir.CommonDir(gitDir), "info", "exclude")),
+		attributes: NewAttributes(),
 	}
 }
 
-// LoadIgnoreFile loads patterns from a .gitignore file
+// LoadIgnoreFile loads patterns from an ignore file, taking precedence
+// over the .gitignore files
 func (s *Scanner) LoadIgnoreFile(path string) error {
 	return s.ignores.LoadFile(path)
 }
 
+// LoadExcludesFile loads the patterns of core.excludesFile, which come
+// after every other
+func (s *Scanner) LoadExcludesFile(path string) error {
+	return s.ignores.LoadExcludesFile(path)
+}
+
+// Ignores returns the matcher deciding which paths are ignored
+func (s *Scanner) Ignores() *ignore.Matcher {
+	return s.ignores
+}
+
+// LoadAttributesFile loads attributes from a .gitattributes file
+func (s *Scanner) LoadAttributesFile(path string) error {
+	return s.attributes.LoadFile(path)
+}
+
 // ScanWorkingDirectory scans the working directory and returns file info
 func (s *Scanner) ScanWorkingDirectory() ([]FileInfo, error) {
 	var files []FileInfo
@@ -88,9 +114,13 @@ func (s *Scanner) ScanWorkingDirectory() ([]FileInfo, error) {
 			return err
 		}
 		
-		// Skip .git directory
-		if d.IsDir() && d.Name() == ".git" {
-			return filepath.SkipDir
+		// Skip .git, which is a file pointing to the repository in a
+		// linked worktree
+		if d.Name() == ".git" {
+			if d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
 		}
 		
 		// Get relative path from repo root
@@ -117,6 +147,13 @@ func (s *Scanner) ScanWorkingDirectory() ([]FileInfo, error) {
 			IsDir:   info.IsDir(),
 		}
 		
+		// The files of a nested repository belong to it
+		if d.IsDir() && IsRepository(path) {
+			fileInfo.IsRepo = true
+			files = append(files, fileInfo)
+			return filepath.SkipDir
+		}
+		
 		files = append(files, fileInfo)
 		return nil
 	})
@@ -124,6 +161,13 @@ func (s *Scanner) ScanWorkingDirectory() ([]FileInfo, error) {
 	return files, err
 }
 
+// IsRepository reports whether dir is the working tree of a repository,
+// holding a .git directory or a .git file pointing to one
+func IsRepository(dir string) bool {
+	_, err := os.Stat(filepath.Join(dir, ".git"))
+	return err == nil
+}
+
 // ScanFiles scans only files (not directories)
 func (s *Scanner) ScanFiles() ([]FileInfo, error) {
 	files, err := s.ScanWorkingDirectory()
@@ -141,9 +185,124 @@ func (s *Scanner) ScanFiles() ([]FileInfo, error) {
 	return fileList, nil
 }
 
+// ScanFilesCached is ScanFiles reusing the directory listings of cache, as
+// the index keeps them. A listing is reused when unchanged, which may be
+// nil, reports its directory unchanged, as a filesystem monitor tells, or
+// else when the directory's modification time is the one recorded; other
+// directories are read again. Listings of directories modified at or after
+// before are not trusted by their modification time next time, since they
+// may change again within its granularity. It returns the files, with only
+// Path set, and the listings to cache for the next scan.
+func (s *Scanner) ScanFilesCached(cache *index.UntrackedCache, unchanged func(dir string) bool, before time.Time) ([]FileInfo, *index.UntrackedCache, error) {
+	var files []FileInfo
+	updated := &index.UntrackedCache{}
+
+	var walk func(dir string) error
+	walk = func(dir string) error {
+		var listing *index.UntrackedDir
+		cached := false
+		if cache != nil {
+			listing, cached = cache.Find(dir)
+		}
+		if !cached || unchanged == nil || !unchanged(dir) {
+			info, err := os.Lstat(filepath.Join(s.repoPath, filepath.FromSlash(dir)))
+			if os.IsNotExist(err) && dir != "" {
+				// Removed since its parent was listed
+				return nil
+			}
+			if err != nil {
+				return err
+			}
+			if !cached || listing.MTime.IsZero() || !listing.MTime.Equal(info.ModTime()) {
+				if listing, err = s.readListing(dir, info, before); err != nil {
+					return err
+				}
+			}
+		}
+
+		updated.Dirs = append(updated.Dirs, *listing)
+		if listing.Repo {
+			return nil
+		}
+		for _, name := range listing.Files {
+			files = append(files, FileInfo{Path: joinPath(dir, name)})
+		}
+		for _, name := range listing.Dirs {
+			if err := walk(joinPath(dir, name)); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if err := walk(""); err != nil {
+		return nil, nil, err
+	}
+	return files, updated, nil
+}
+
+// readListing lists the directory at dir, whose stat data is info
+func (s *Scanner) readListing(dir string, info os.FileInfo, before time.Time) (*index.UntrackedDir, error) {
+	entries, err := os.ReadDir(filepath.Join(s.repoPath, filepath.FromSlash(dir)))
+	if err != nil {
+		return nil, err
+	}
+
+	listing := &index.UntrackedDir{Path: dir}
+	if info.ModTime().Before(before) {
+		listing.MTime = info.ModTime()
+	}
+	for _, entry := range entries {
+		// Skip .git, which is a file pointing to the repository in a
+		// linked worktree; below the top it makes a nested repository
+		if entry.Name() == ".git" {
+			if dir != "" {
+				return &index.UntrackedDir{Path: dir, MTime: listing.MTime, Repo: true}, nil
+			}
+			continue
+		}
+		if entry.IsDir() {
+			listing.Dirs = append(listing.Dirs, entry.Name())
+		} else {
+			listing.Files = append(listing.Files, entry.Name())
+		}
+	}
+	return listing, nil
+}
+
+// joinPath joins a slash separated directory, "" for the top, and a name
+func joinPath(dir, name string) string {
+	if dir == "" {
+		return name
+	}
+	return dir + "/" + name
+}
+
 // IsIgnored checks if a path should be ignored
 func (s *Scanner) IsIgnored(path string) bool {
-	return s.ignores.Match(path)
+	_, ignored := s.ignores.Match(path, s.isDir(path))
+	return ignored
+}
+
+// IgnoreSource returns the pattern that makes a path ignored
+func (s *Scanner) IgnoreSource(path string) (PatternSource, bool) {
+	p, ignored := s.ignores.Match(path, s.isDir(path))
+	if !ignored {
+		return PatternSource{}, false
+	}
+	return p.Source, true
+}
+
+// isDir reports whether path, relative to the top of the working tree, is
+// a directory there, as directory-only patterns need to know
+func (s *Scanner) isDir(path string) bool {
+	info, err := os.Lstat(filepath.Join(s.repoPath, filepath.FromSlash(path)))
+	return err == nil && info.IsDir()
+}
+
+// Attributes returns the attributes that apply to a path
+func (s *Scanner) Attributes(path string) []Attribute {
+	return s.attributes.Match(path)
 }
 
 // FilterIgnored filters out ignored files from the list
@@ -179,135 +338,6 @@ func (s *Scanner) GetFileMode(path string) (objects.FileMode, error) {
 	return objects.ModeBlob, nil
 }
 
-// IgnorePatterns manages .gitignore patterns
-type IgnorePatterns struct {
-	patterns []string
-}
-
-// NewIgnorePatterns creates a new ignore patterns manager
-func NewIgnorePatterns() *IgnorePatterns {
-	return &IgnorePatterns{
-		patterns: make([]string, 0),
-	}
-}
-
-// AddPattern adds a pattern to ignore
-func (ip *IgnorePatterns) AddPattern(pattern string) {
-	pattern = strings.TrimSpace(pattern)
-	if pattern != "" && !strings.HasPrefix(pattern, "#") {
-		ip.patterns = append(ip.patterns, pattern)
-	}
-}
-
-// LoadFile loads patterns from a file
-func (ip *IgnorePatterns) LoadFile(filename string) error {
-	content, err := os.ReadFile(filename)
-	if err != nil {
-		if os.IsNotExist(err) {
-			return nil // .gitignore doesn't exist, that's OK
-		}
-		return err
-	}
-	
-	lines := strings.Split(string(content), "\n")
-	for _, line := range lines {
-		ip.AddPattern(line)
-	}
-	
-	return nil
-}
-
-// Match checks if a path matches any ignore pattern
-func (ip *IgnorePatterns) Match(path string) bool {
-	path = filepath.ToSlash(path)
-	
-	for _, pattern := range ip.patterns {
-		if ip.matchPattern(pattern, path) {
-			return true
-		}
-	}
-	return false
-}
-
-// matchPattern checks if a path matches a specific pattern
-func (ip *IgnorePatterns) matchPattern(pattern, path string) bool {
-	pattern = filepath.ToSlash(pattern)
-	
-	// Handle negation (patterns starting with !)
-	if strings.HasPrefix(pattern, "!") {
-		return false // TODO: implement negation properly
-	}
-	
-	// Handle directory patterns (ending with /)
-	if strings.HasSuffix(pattern, "/") {
-		pattern = strings.TrimSuffix(pattern, "/")
-		// Check if path starts with the pattern or contains it as a directory
-		return strings.HasPrefix(path, pattern+"/") || path == pattern || strings.Contains(path, "/"+pattern+"/")
-	}
-	
-	// Handle patterns starting with /
-	if strings.HasPrefix(pattern, "/") {
-		pattern = strings.TrimPrefix(pattern, "/")
-		return ip.simpleMatch(pattern, path)
-	}
-	
-	// Handle wildcard patterns
-	if strings.Contains(pattern, "*") {
-		return ip.wildcardMatch(pattern, path)
-	}
-	
-	// Simple substring match
-	return strings.Contains(path, pattern) || path == pattern
-}
-
-// simpleMatch performs simple pattern matching
-func (ip *IgnorePatterns) simpleMatch(pattern, path string) bool {
-	if strings.Contains(pattern, "*") {
-		return ip.wildcardMatch(pattern, path)
-	}
-	return path == pattern || strings.HasPrefix(path, pattern+"/")
-}
-
-// wildcardMatch performs wildcard pattern matching
-func (ip *IgnorePatterns) wildcardMatch(pattern, path string) bool {
-	// Simple wildcard matching - convert * to regexp equivalent
-	// This is a simplified implementation
-	parts := strings.Split(pattern, "*")
-	if len(parts) == 1 {
-		return path == pattern
-	}
-	
-	// Check if path starts with first part
-	if parts[0] != "" && !strings.HasPrefix(path, parts[0]) {
-		return false
-	}
-	
-	// Check if path ends with last part
-	if parts[len(parts)-1] != "" && !strings.HasSuffix(path, parts[len(parts)-1]) {
-		return false
-	}
-	
-	// For more complex patterns, we'd need proper regexp matching
-	// This is a basic implementation
-	current := path
-	for i, part := range parts {
-		if i == 0 {
-			if part != "" {
-				current = strings.TrimPrefix(current, part)
-			}
-			continue
-		}
-		
-		if part == "" {
-			continue
-		}
-		
-		idx := strings.Index(current, part)
-		if idx == -1 {
-			return false
-		}
-		current = current[idx+len(part):]
-	}
-	
-	return true
-}
\ No newline at end of file
+// PatternSource records where a pattern was read from. File is empty for
+// patterns added directly.
+type PatternSource = ignore.Source
@@ -3,9 +3,12 @@ package workdir
 import (
 	"os"
 	"path/filepath"
+	"reflect"
+	"sort"
 	"testing"
 	"time"
 
+	"github.com/fenilsonani/vcs/internal/core/index"
 	"github.com/fenilsonani/vcs/internal/core/objects"
 )
 
@@ -120,6 +123,22 @@ func TestScanner_ScanWorkingDirectory(t *testing.T) {
 	}
 }
 
+func TestScanner_ScanWorkingDirectory_NestedRepository(t *testing.T) {
+	tmpDir := t.TempDir()
+	os.MkdirAll(filepath.Join(tmpDir, ".git"), 0755)
+	os.MkdirAll(filepath.Join(tmpDir, "lib"), 0755)
+	os.WriteFile(filepath.Join(tmpDir, "lib", ".git"), []byte("gitdir: ../.git/modules/lib\n"), 0644)
+	os.WriteFile(filepath.Join(tmpDir, "lib", "README"), []byte("library"), 0644)
+
+	files, err := NewScanner(tmpDir, filepath.Join(tmpDir, ".git")).ScanWorkingDirectory()
+	if err != nil {
+		t.Fatalf("ScanWorkingDirectory() error = %v", err)
+	}
+	if len(files) != 1 || files[0].Path != "lib" || !files[0].IsRepo {
+		t.Errorf("ScanWorkingDirectory() = %+v, want only the repository lib", files)
+	}
+}
+
 func TestScanner_ScanFiles(t *testing.T) {
 	tmpDir, err := os.MkdirTemp("", "workdir-test-*")
 	if err != nil {
@@ -239,137 +258,6 @@ func TestScanner_GetFileMode_NotExists(t *testing.T) {
 	}
 }
 
-func TestIgnorePatterns_AddPattern(t *testing.T) {
-	ip := NewIgnorePatterns()
-	
-	tests := []struct {
-		pattern string
-		want    int
-	}{
-		{"*.txt", 1},
-		{"  *.log  ", 2}, // should trim whitespace
-		{"# comment", 2}, // should ignore comments
-		{"", 2},          // should ignore empty lines
-		{"build/", 3},
-	}
-	
-	for _, tt := range tests {
-		ip.AddPattern(tt.pattern)
-		if len(ip.patterns) != tt.want {
-			t.Errorf("After adding %q, patterns count = %d, want %d", tt.pattern, len(ip.patterns), tt.want)
-		}
-	}
-}
-
-func TestIgnorePatterns_LoadFile(t *testing.T) {
-	tmpDir, err := os.MkdirTemp("", "workdir-test-*")
-	if err != nil {
-		t.Fatalf("Failed to create temp dir: %v", err)
-	}
-	defer os.RemoveAll(tmpDir)
-
-	gitignoreContent := `# This is a comment
-*.log
-*.tmp
-
-# Another comment
-build/
-node_modules/
-`
-	
-	gitignorePath := filepath.Join(tmpDir, ".gitignore")
-	os.WriteFile(gitignorePath, []byte(gitignoreContent), 0644)
-	
-	ip := NewIgnorePatterns()
-	err = ip.LoadFile(gitignorePath)
-	if err != nil {
-		t.Fatalf("LoadFile() error = %v", err)
-	}
-	
-	// Should have 4 patterns (comments and empty lines ignored)
-	if len(ip.patterns) != 4 {
-		t.Errorf("LoadFile() loaded %d patterns, want 4", len(ip.patterns))
-	}
-	
-	expectedPatterns := []string{"*.log", "*.tmp", "build/", "node_modules/"}
-	for i, expected := range expectedPatterns {
-		if i >= len(ip.patterns) || ip.patterns[i] != expected {
-			t.Errorf("Pattern %d = %v, want %v", i, ip.patterns[i], expected)
-		}
-	}
-}
-
-func TestIgnorePatterns_LoadFile_NotExists(t *testing.T) {
-	ip := NewIgnorePatterns()
-	err := ip.LoadFile("nonexistent.gitignore")
-	if err != nil {
-		t.Errorf("LoadFile() should not error for non-existent file, got: %v", err)
-	}
-}
-
-func TestIgnorePatterns_Match(t *testing.T) {
-	ip := NewIgnorePatterns()
-	ip.AddPattern("*.log")
-	ip.AddPattern("*.tmp")
-	ip.AddPattern("build/")
-	ip.AddPattern("node_modules/")
-	ip.AddPattern("/config.json")
-	ip.AddPattern("*.o")
-	
-	tests := []struct {
-		path string
-		want bool
-	}{
-		{"file.log", true},
-		{"debug.log", true},
-		{"logs/error.log", true},
-		{"file.txt", false},
-		{"build/output", true},
-		{"src/build/output", true}, // should match build/ anywhere
-		{"node_modules/package", true},
-		{"config.json", true},
-		{"src/config.json", false}, // /config.json should only match at root
-		{"main.o", true},
-		{"src/main.o", true},
-		{"main.c", false},
-		{"temp.tmp", true},
-		{"data.tmp", true},
-	}
-	
-	for _, tt := range tests {
-		if got := ip.Match(tt.path); got != tt.want {
-			t.Errorf("Match(%q) = %v, want %v", tt.path, got, tt.want)
-		}
-	}
-}
-
-func TestIgnorePatterns_wildcardMatch(t *testing.T) {
-	ip := NewIgnorePatterns()
-	
-	tests := []struct {
-		pattern string
-		path    string
-		want    bool
-	}{
-		{"*.txt", "file.txt", true},
-		{"*.txt", "file.log", false},
-		{"test*", "test.txt", true},
-		{"test*", "testing", true},
-		{"test*", "file.txt", false},
-		{"*test*", "mytest.txt", true},
-		{"*test*", "testing", true},
-		{"*test*", "file.txt", false},
-		{"*.o", "main.o", true},
-		{"*.o", "main.c", false},
-	}
-	
-	for _, tt := range tests {
-		if got := ip.wildcardMatch(tt.pattern, tt.path); got != tt.want {
-			t.Errorf("wildcardMatch(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
-		}
-	}
-}
-
 func TestScanner_LoadIgnoreFile(t *testing.T) {
 	tmpDir, err := os.MkdirTemp("", "workdir-test-*")
 	if err != nil {
@@ -451,4 +339,225 @@ func TestScanner_FilterIgnored(t *testing.T) {
 			t.Errorf("FilterIgnored() included unexpected file: %v", file.Path)
 		}
 	}
-}
\ No newline at end of file
+}
+
+func TestScanner_IgnoreSource(t *testing.T) {
+	tmpDir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(tmpDir, ".gitignore"), []byte("# build output\nbuild/\n\n*.log\n"), 0644); err != nil {
+		t.Fatalf("Failed to write .gitignore: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Join(tmpDir, "build"), 0755); err != nil {
+		t.Fatalf("Failed to create build: %v", err)
+	}
+
+	scanner := NewScanner(tmpDir, filepath.Join(tmpDir, ".git"))
+	scanner.ignores.AddPattern("*.tmp")
+
+	source, ok := scanner.IgnoreSource("logs/debug.log")
+	if !ok {
+		t.Fatal("IgnoreSource() found no pattern for logs/debug.log")
+	}
+	want := PatternSource{File: ".gitignore", Line: 4, Pattern: "*.log"}
+	if source != want {
+		t.Errorf("IgnoreSource() = %+v, want %+v", source, want)
+	}
+	if got := source.String(); got != ".gitignore:4: *.log" {
+		t.Errorf("String() = %q", got)
+	}
+
+	// Directory-only patterns apply to what is a directory on disk
+	if source, ok := scanner.IgnoreSource("build"); !ok || source.Line != 2 {
+		t.Errorf("IgnoreSource(build) = %+v, %v", source, ok)
+	}
+	if source, _ := scanner.IgnoreSource("x.tmp"); source.String() != "*.tmp" {
+		t.Errorf("IgnoreSource() for an added pattern = %+v", source)
+	}
+	if _, ok := scanner.IgnoreSource("main.go"); ok {
+		t.Error("IgnoreSource() matched main.go")
+	}
+}
+
+func TestAttributes_Match(t *testing.T) {
+	tmpDir := t.TempDir()
+	attributesPath := filepath.Join(tmpDir, ".gitattributes")
+	content := "# comment\n*.txt text eol=crlf\n*.bin binary -diff\ndocs/*.txt eol=lf !text\n"
+	if err := os.WriteFile(attributesPath, []byte(content), 0644); err != nil {
+		t.Fatalf("Failed to write .gitattributes: %v", err)
+	}
+
+	attrs := NewAttributes()
+	if err := attrs.LoadFile(attributesPath); err != nil {
+		t.Fatalf("LoadFile() error = %v", err)
+	}
+
+	got := attrs.Match("docs/readme.txt")
+	if len(got) != 2 {
+		t.Fatalf("Match() returned %d attributes, want 2: %+v", len(got), got)
+	}
+	if got[0].Name != "eol" || got[0].Value != "lf" || got[0].Source.Line != 4 {
+		t.Errorf("Match()[0] = %+v, want eol=lf from line 4", got[0])
+	}
+	if got[1].Name != "text" || got[1].Value != "unspecified" {
+		t.Errorf("Match()[1] = %+v, want text unspecified", got[1])
+	}
+
+	got = attrs.Match("image.bin")
+	if len(got) != 2 || got[0].Name != "binary" || got[0].Value != "set" || got[1].Name != "diff" || got[1].Value != "unset" {
+		t.Errorf("Match(image.bin) = %+v", got)
+	}
+
+	if got := attrs.Match("main.go"); len(got) != 0 {
+		t.Errorf("Match(main.go) = %+v, want none", got)
+	}
+}
+
+func TestAttributeResolver_Lookup(t *testing.T) {
+	files := map[string]string{
+		"":     "[attr]generated -diff linguist-generated\n*.txt text\n*.gen generated\nbuild/** -text\n",
+		"docs": "*.txt eol=crlf\nlegacy.txt !text\n",
+	}
+	read := func(dir string) ([]byte, bool) {
+		content, ok := files[dir]
+		return []byte(content), ok
+	}
+
+	tmpDir := t.TempDir()
+	infoPath := filepath.Join(tmpDir, "attributes")
+	if err := os.WriteFile(infoPath, []byte("docs/notes.txt -text\n"), 0644); err != nil {
+		t.Fatalf("Failed to write info/attributes: %v", err)
+	}
+	globalPath := filepath.Join(tmpDir, "global")
+	if err := os.WriteFile(globalPath, []byte("*.txt eol=lf\n*.png binary\n"), 0644); err != nil {
+		t.Fatalf("Failed to write global attributes: %v", err)
+	}
+	r := NewAttributeResolver(read, globalPath, infoPath)
+
+	attrs := r.Lookup("a/b/readme.txt")
+	if !attrs.IsSet("text") {
+		t.Errorf("Lookup(a/b/readme.txt) text = %+v, want set", attrs["text"])
+	}
+	if eol, _ := attrs.Value("eol"); eol != "lf" {
+		t.Errorf("Lookup(a/b/readme.txt) eol = %q, want lf from the global file", eol)
+	}
+
+	attrs = r.Lookup("docs/guide.txt")
+	if eol, _ := attrs.Value("eol"); eol != "crlf" || attrs["eol"].Source.File != "docs/.gitattributes" {
+		t.Errorf("Lookup(docs/guide.txt) eol = %+v, want crlf from docs/.gitattributes", attrs["eol"])
+	}
+	if _, ok := r.Lookup("docs/legacy.txt")["text"]; ok {
+		t.Error("Lookup(docs/legacy.txt) text is specified, want it unspecified by docs/.gitattributes")
+	}
+	if !r.Lookup("docs/notes.txt").IsUnset("text") {
+		t.Error("Lookup(docs/notes.txt) text is not unset, want info/attributes to win")
+	}
+	if !r.Lookup("build/out/main.txt").IsUnset("text") {
+		t.Error("Lookup(build/out/main.txt) text is not unset by build/**")
+	}
+
+	attrs = r.Lookup("src/parser.gen")
+	if !attrs.IsUnset("diff") || !attrs.IsSet("linguist-generated") || !attrs.IsSet("generated") {
+		t.Errorf("Lookup(src/parser.gen) = %+v, want the generated macro expanded", attrs)
+	}
+	attrs = r.Lookup("logo.png")
+	if !attrs.IsUnset("diff") || !attrs.IsUnset("merge") || !attrs.IsUnset("text") {
+		t.Errorf("Lookup(logo.png) = %+v, want binary expanded", attrs)
+	}
+}
+
+func TestAttributeResolver_DirectoryPatterns(t *testing.T) {
+	read := func(dir string) ([]byte, bool) {
+		if dir == "src" {
+			return []byte("*.c diff=cpp\n/top.h text\nlib/*.h -text\n"), true
+		}
+		return nil, false
+	}
+	r := NewAttributeResolver(read, "", "")
+
+	tests := []struct {
+		path string
+		attr string
+		want string
+	}{
+		{"src/main.c", "diff", "cpp"},
+		{"src/deep/er/main.c", "diff", "cpp"},
+		{"main.c", "diff", ""},
+		{"src/top.h", "text", "set"},
+		{"src/sub/top.h", "text", ""},
+		{"src/lib/x.h", "text", "unset"},
+		{"lib/x.h", "text", ""},
+	}
+	for _, tt := range tests {
+		if got := r.Lookup(tt.path)[tt.attr].Value; got != tt.want {
+			t.Errorf("Lookup(%s)[%s] = %q, want %q", tt.path, tt.attr, got, tt.want)
+		}
+	}
+}
+
+func TestScanner_ScanFilesCached(t *testing.T) {
+	dir := t.TempDir()
+	for _, path := range []string{".git/HEAD", "a.txt", "src/main.go", "nested/.git/HEAD", "nested/x.txt"} {
+		full := filepath.Join(dir, path)
+		os.MkdirAll(filepath.Dir(full), 0755)
+		if err := os.WriteFile(full, []byte(path), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	old := time.Unix(1600000000, 0)
+	src := filepath.Join(dir, "src")
+	os.Chtimes(src, old, old)
+
+	scanner := NewScanner(dir, filepath.Join(dir, ".git"))
+	scan := func(cache *index.UntrackedCache, unchanged func(string) bool) ([]string, *index.UntrackedCache) {
+		t.Helper()
+		files, updated, err := scanner.ScanFilesCached(cache, unchanged, time.Now())
+		if err != nil {
+			t.Fatalf("ScanFilesCached() error = %v", err)
+		}
+		var paths []string
+		for _, f := range files {
+			paths = append(paths, f.Path)
+		}
+		sort.Strings(paths)
+		return paths, updated
+	}
+
+	paths, cache := scan(nil, nil)
+	if want := []string{"a.txt", "src/main.go"}; !reflect.DeepEqual(paths, want) {
+		t.Errorf("ScanFilesCached() = %v, want %v", paths, want)
+	}
+	if nested, ok := cache.Find("nested"); !ok || !nested.Repo {
+		t.Errorf("Find(nested) = %+v, %v; want a nested repository", nested, ok)
+	}
+	if listing, ok := cache.Find("src"); !ok || !listing.MTime.Equal(old) {
+		t.Errorf("Find(src) = %+v, %v", listing, ok)
+	}
+
+	// A directory with the recorded modification time is not read again
+	os.WriteFile(filepath.Join(src, "new.go"), nil, 0644)
+	os.Chtimes(src, old, old)
+	if paths, _ = scan(cache, nil); len(paths) != 2 {
+		t.Errorf("ScanFilesCached() read an unchanged directory: %v", paths)
+	}
+	now := time.Now()
+	os.Chtimes(src, now, now)
+	if paths, _ = scan(cache, nil); len(paths) != 3 {
+		t.Errorf("ScanFilesCached() = %v, want src/new.go found", paths)
+	}
+
+	// Unchanged directories are trusted without looking at them
+	paths, _ = scan(cache, func(dir string) bool { return true })
+	if len(paths) != 2 {
+		t.Errorf("ScanFilesCached() = %v, want the listings reused", paths)
+	}
+	paths, _ = scan(cache, func(dir string) bool { return dir != "src" })
+	if len(paths) != 3 {
+		t.Errorf("ScanFilesCached() = %v, want src read again", paths)
+	}
+
+	// Directories changed too recently are not trusted by time
+	if _, racy, err := scanner.ScanFilesCached(nil, nil, old); err != nil {
+		t.Fatal(err)
+	} else if listing, _ := racy.Find("src"); !listing.MTime.IsZero() {
+		t.Errorf("Find(src).MTime = %v, want none for a racy listing", listing.MTime)
+	}
+}
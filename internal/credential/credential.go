@@ -0,0 +1,122 @@
+// Package credential supplies the usernames and passwords remotes ask for,
+// speaking the git-credential protocol. Credentials come from the helpers
+// configured with credential.helper, the same programs Git runs such as
+// osxkeychain, wincred, libsecret, store and cache, or else from asking the
+// user, and are kept per host for the rest of the process once known.
+package credential
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net/url"
+	"strings"
+)
+
+// Credential describes what to authenticate against and, once filled, the
+// username and password to use
+type Credential struct {
+	Protocol string
+	Host     string
+	// Path is only set when credentials differ per repository on a host,
+	// as with credential.useHttpPath
+	Path     string
+	Username string
+	Password string
+}
+
+// FromURL returns the credential for u, with any username and password it
+// carries
+func FromURL(u *url.URL) *Credential {
+	c := &Credential{
+		Protocol: u.Scheme,
+		Host:     u.Host,
+		Path:     strings.TrimPrefix(u.Path, "/"),
+	}
+	if u.User != nil {
+		c.Username = u.User.Username()
+		c.Password, _ = u.User.Password()
+	}
+	return c
+}
+
+// Complete reports whether c has both a username and a password
+func (c *Credential) Complete() bool {
+	return c.Username != "" && c.Password != ""
+}
+
+// URL returns what c authenticates against, as shown in prompts
+func (c *Credential) URL() string {
+	u := c.Protocol + "://" + c.Host
+	if c.Path != "" {
+		u += "/" + c.Path
+	}
+	return u
+}
+
+// Write sends c to a helper: an attribute=value line for each field set
+func (c *Credential) Write(w io.Writer) error {
+	fields := []struct{ name, value string }{
+		{"protocol", c.Protocol},
+		{"host", c.Host},
+		{"path", c.Path},
+		{"username", c.Username},
+		{"password", c.Password},
+	}
+	for _, f := range fields {
+		if f.value == "" {
+			continue
+		}
+		if strings.ContainsAny(f.value, "\n\x00") {
+			return fmt.Errorf("credential %s contains a newline or NUL", f.name)
+		}
+		if _, err := fmt.Fprintf(w, "%s=%s\n", f.name, f.value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Read parses attribute=value lines up to a blank line or the end of r.
+// A url attribute sets the fields it includes, and attributes that are not
+// known are ignored, as helpers may send more than is asked. quit reports
+// whether the helper said quit=true, meaning no further helper should be
+// asked.
+func Read(r io.Reader) (c *Credential, quit bool, err error) {
+	c = &Credential{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" {
+			break
+		}
+		name, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, false, fmt.Errorf("invalid credential line %q", line)
+		}
+		switch name {
+		case "protocol":
+			c.Protocol = value
+		case "host":
+			c.Host = value
+		case "path":
+			c.Path = value
+		case "username":
+			c.Username = value
+		case "password":
+			c.Password = value
+		case "url":
+			u, err := url.Parse(value)
+			if err != nil {
+				return nil, false, fmt.Errorf("invalid credential url %q: %w", value, err)
+			}
+			*c = *FromURL(u)
+		case "quit":
+			quit = value == "1" || value == "true"
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, false, fmt.Errorf("failed to read credential: %w", err)
+	}
+	return c, quit, nil
+}
@@ -0,0 +1,86 @@
+package credential
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Helper actions, as helpers are asked to carry them out
+const (
+	ActionGet   = "get"
+	ActionStore = "store"
+	ActionErase = "erase"
+)
+
+// runHelper carries out action with the helper spec names, as Git does: a
+// spec starting with ! is a shell command, an absolute path is run as is,
+// and any other name is the git-credential-<name> program, or the built-in
+// store helper. It returns what the helper answered to a get.
+func runHelper(spec, action string, c *Credential, stderr io.Writer) (*Credential, bool, error) {
+	name, args, _ := strings.Cut(spec, " ")
+	if name == "store" {
+		return runStoreHelper(args, action, c)
+	}
+
+	command := spec
+	switch {
+	case strings.HasPrefix(spec, "!"):
+		command = spec[1:]
+	case filepath.IsAbs(name):
+	default:
+		command = "git-credential-" + spec
+	}
+
+	var in bytes.Buffer
+	if err := c.Write(&in); err != nil {
+		return nil, false, err
+	}
+	var out bytes.Buffer
+	cmd := exec.Command("sh", "-c", command+" "+action)
+	cmd.Stdin = &in
+	cmd.Stdout = &out
+	cmd.Stderr = stderr
+	if err := cmd.Run(); err != nil {
+		return nil, false, fmt.Errorf("credential helper '%s' failed: %w", spec, err)
+	}
+	if action != ActionGet {
+		return nil, false, nil
+	}
+	return Read(&out)
+}
+
+// runStoreHelper carries out action with the built-in store helper, which
+// keeps credentials as URLs in a file, ~/.git-credentials unless
+// --file=<path> is given, so it shares them with git-credential-store
+func runStoreHelper(args, action string, c *Credential) (*Credential, bool, error) {
+	var path string
+	fields := strings.Fields(args)
+	for i := 0; i < len(fields); i++ {
+		switch {
+		case strings.HasPrefix(fields[i], "--file="):
+			path = strings.TrimPrefix(fields[i], "--file=")
+		case fields[i] == "--file" && i+1 < len(fields):
+			i++
+			path = fields[i]
+		}
+	}
+	store, err := NewFileStore(path)
+	if err != nil {
+		return nil, false, err
+	}
+
+	switch action {
+	case ActionGet:
+		found, err := store.Get(c)
+		return found, false, err
+	case ActionStore:
+		return nil, false, store.Store(c)
+	case ActionErase:
+		return nil, false, store.Erase(c)
+	}
+	return nil, false, fmt.Errorf("unknown credential action %q", action)
+}
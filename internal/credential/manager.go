@@ -0,0 +1,150 @@
+package credential
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// Prompt asks the user for what c lacks of its username and password
+type Prompt func(c *Credential) error
+
+// Manager fills in credentials from its helpers, or by prompting, and
+// tells the helpers which ones worked. Credentials that worked are kept
+// per host, or per repository with SetUseHTTPPath, for as long as the
+// manager is, so they are only asked for once per process.
+type Manager struct {
+	helpers     []string
+	useHTTPPath bool
+	username    string
+	prompt      Prompt
+	stderr      io.Writer
+
+	mu    sync.Mutex
+	cache map[string]Credential
+}
+
+// NewManager returns a manager asking helpers in order. As with repeated
+// credential.helper entries, an empty one drops the helpers before it.
+func NewManager(helpers []string) *Manager {
+	m := &Manager{stderr: os.Stderr, cache: make(map[string]Credential)}
+	for _, h := range helpers {
+		if h == "" {
+			m.helpers = nil
+			continue
+		}
+		m.helpers = append(m.helpers, h)
+	}
+	return m
+}
+
+// SetUseHTTPPath keeps HTTP credentials per repository rather than per
+// host, as credential.useHttpPath does
+func (m *Manager) SetUseHTTPPath(use bool) {
+	m.useHTTPPath = use
+}
+
+// SetUsername sets the username used when none is known, as
+// credential.username does
+func (m *Manager) SetUsername(username string) {
+	m.username = username
+}
+
+// SetPrompt sets how to ask for credentials no helper has; without one,
+// Fill fails instead
+func (m *Manager) SetPrompt(prompt Prompt) {
+	m.prompt = prompt
+}
+
+// Fill completes c with a username and password: from the credentials
+// already used in this process, then from each helper in turn, and last
+// from the prompt
+func (m *Manager) Fill(c *Credential) error {
+	m.normalize(c)
+	if c.Complete() {
+		return nil
+	}
+
+	m.mu.Lock()
+	cached, ok := m.cache[c.URL()]
+	m.mu.Unlock()
+	if ok && (c.Username == "" || c.Username == cached.Username) {
+		c.Username, c.Password = cached.Username, cached.Password
+		return nil
+	}
+
+	for _, helper := range m.helpers {
+		found, quit, err := runHelper(helper, ActionGet, c, m.stderr)
+		if err != nil {
+			fmt.Fprintf(m.stderr, "warning: %v\n", err)
+			continue
+		}
+		if found != nil {
+			if c.Username == "" {
+				c.Username = found.Username
+			}
+			if found.Password != "" && found.Username == c.Username {
+				c.Password = found.Password
+			}
+		}
+		if c.Complete() {
+			return nil
+		}
+		if quit {
+			return fmt.Errorf("credential helper '%s' told us to quit", helper)
+		}
+	}
+
+	if m.prompt != nil {
+		if err := m.prompt(c); err != nil {
+			return err
+		}
+	}
+	if !c.Complete() {
+		return fmt.Errorf("no credentials for '%s'", c.URL())
+	}
+	return nil
+}
+
+// Approve records that c worked, keeping it for this process and giving it
+// to every helper to store
+func (m *Manager) Approve(c *Credential) {
+	m.normalize(c)
+	if !c.Complete() {
+		return
+	}
+	m.mu.Lock()
+	m.cache[c.URL()] = *c
+	m.mu.Unlock()
+	m.tellHelpers(ActionStore, c)
+}
+
+// Reject records that c was refused, forgetting it here and asking every
+// helper to erase it
+func (m *Manager) Reject(c *Credential) {
+	m.normalize(c)
+	m.mu.Lock()
+	delete(m.cache, c.URL())
+	m.mu.Unlock()
+	m.tellHelpers(ActionErase, c)
+}
+
+func (m *Manager) tellHelpers(action string, c *Credential) {
+	for _, helper := range m.helpers {
+		if _, _, err := runHelper(helper, action, c, m.stderr); err != nil {
+			fmt.Fprintf(m.stderr, "warning: %v\n", err)
+		}
+	}
+}
+
+// normalize drops the path of HTTP credentials unless they are kept per
+// repository, and applies the default username
+func (m *Manager) normalize(c *Credential) {
+	if !m.useHTTPPath && (c.Protocol == "http" || c.Protocol == "https") {
+		c.Path = ""
+	}
+	if c.Username == "" {
+		c.Username = m.username
+	}
+}
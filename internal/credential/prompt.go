@@ -0,0 +1,91 @@
+package credential
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// AskpassPrompt asks with program, run with each question as its argument
+// and answering on its standard output, as GIT_ASKPASS and core.askPass
+// programs do
+func AskpassPrompt(program string) Prompt {
+	return func(c *Credential) error {
+		return ask(c, func(question string, _ bool) (string, error) {
+			var out bytes.Buffer
+			cmd := exec.Command("sh", "-c", program+` "$@"`, program, question)
+			cmd.Stdout = &out
+			cmd.Stderr = os.Stderr
+			if err := cmd.Run(); err != nil {
+				return "", fmt.Errorf("failed to run askpass program '%s': %w", program, err)
+			}
+			return strings.TrimRight(out.String(), "\r\n"), nil
+		})
+	}
+}
+
+// TerminalPrompt asks on the controlling terminal, without echoing the
+// password. GIT_TERMINAL_PROMPT=0 turns it off.
+func TerminalPrompt() Prompt {
+	return func(c *Credential) error {
+		if os.Getenv("GIT_TERMINAL_PROMPT") == "0" {
+			return fmt.Errorf("could not read credentials for '%s': terminal prompts disabled", c.URL())
+		}
+		tty, err := os.OpenFile("/dev/tty", os.O_RDWR, 0)
+		if err != nil {
+			return fmt.Errorf("could not read credentials for '%s': no terminal", c.URL())
+		}
+		defer tty.Close()
+
+		reader := bufio.NewReader(tty)
+		return ask(c, func(question string, secret bool) (string, error) {
+			fmt.Fprint(tty, question)
+			if secret {
+				setEcho(tty, false)
+				defer func() {
+					setEcho(tty, true)
+					fmt.Fprintln(tty)
+				}()
+			}
+			line, err := reader.ReadString('\n')
+			if err != nil && line == "" {
+				return "", fmt.Errorf("failed to read from terminal: %w", err)
+			}
+			return strings.TrimRight(line, "\r\n"), nil
+		})
+	}
+}
+
+// ask fills in the username and password c lacks with the answers to the
+// questions Git asks
+func ask(c *Credential, answer func(question string, secret bool) (string, error)) error {
+	var err error
+	if c.Username == "" {
+		if c.Username, err = answer(fmt.Sprintf("Username for '%s': ", c.URL()), false); err != nil {
+			return err
+		}
+	}
+	if c.Password == "" {
+		where := *c
+		where.Host = c.Username + "@" + c.Host
+		if c.Password, err = answer(fmt.Sprintf("Password for '%s': ", where.URL()), true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// setEcho turns echoing of what is typed on tty on or off. Where stty is
+// missing the password is echoed, which only affects what is shown.
+func setEcho(tty *os.File, on bool) {
+	mode := "-echo"
+	if on {
+		mode = "echo"
+	}
+	cmd := exec.Command("stty", mode)
+	cmd.Stdin = tty
+	cmd.Run()
+}
@@ -0,0 +1,142 @@
+package credential
+
+import (
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileStore keeps credentials in plain text, a URL with the username and
+// password per line, in the format of git-credential-store
+type FileStore struct {
+	path string
+}
+
+// NewFileStore returns the store kept at path, ~/.git-credentials when path
+// is empty. A leading ~/ stands for the home directory.
+func NewFileStore(path string) (*FileStore, error) {
+	if path == "" || strings.HasPrefix(path, "~/") {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to find the home directory: %w", err)
+		}
+		if path == "" {
+			path = filepath.Join(home, ".git-credentials")
+		} else {
+			path = filepath.Join(home, path[2:])
+		}
+	}
+	return &FileStore{path: path}, nil
+}
+
+// Get returns the first stored credential matching c, or nil when there is
+// none
+func (s *FileStore) Get(c *Credential) (*Credential, error) {
+	stored, err := s.read()
+	if err != nil {
+		return nil, err
+	}
+	for _, candidate := range stored {
+		if matches(candidate, c) {
+			return candidate, nil
+		}
+	}
+	return nil, nil
+}
+
+// Store adds c, replacing the credentials stored for the same place
+func (s *FileStore) Store(c *Credential) error {
+	if !c.Complete() || c.Protocol == "" || c.Host == "" {
+		return nil
+	}
+	stored, err := s.read()
+	if err != nil {
+		return err
+	}
+	kept := []*Credential{c}
+	for _, candidate := range stored {
+		if !matches(candidate, &Credential{Protocol: c.Protocol, Host: c.Host, Path: c.Path, Username: c.Username}) {
+			kept = append(kept, candidate)
+		}
+	}
+	return s.write(kept)
+}
+
+// Erase removes the stored credentials matching c
+func (s *FileStore) Erase(c *Credential) error {
+	stored, err := s.read()
+	if err != nil {
+		return err
+	}
+	var kept []*Credential
+	for _, candidate := range stored {
+		if !matches(candidate, c) {
+			kept = append(kept, candidate)
+		}
+	}
+	if len(kept) == len(stored) {
+		return nil
+	}
+	return s.write(kept)
+}
+
+// matches reports whether stored answers for query: every field query sets
+// must be the same, except the password, which is what is asked for
+func matches(stored, query *Credential) bool {
+	return stored.Protocol == query.Protocol &&
+		stored.Host == query.Host &&
+		(query.Path == "" || stored.Path == query.Path) &&
+		(query.Username == "" || stored.Username == query.Username)
+}
+
+func (s *FileStore) read() ([]*Credential, error) {
+	data, err := os.ReadFile(s.path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials: %w", err)
+	}
+
+	var stored []*Credential
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" {
+			continue
+		}
+		// Lines that are not URLs are skipped, as git-credential-store does
+		u, err := url.Parse(line)
+		if err != nil || u.Scheme == "" || u.Host == "" || u.User == nil {
+			continue
+		}
+		stored = append(stored, FromURL(u))
+	}
+	return stored, nil
+}
+
+// write replaces the file with stored, readable only by its owner
+func (s *FileStore) write(stored []*Credential) error {
+	var b strings.Builder
+	for _, c := range stored {
+		u := url.URL{Scheme: c.Protocol, Host: c.Host, User: url.UserPassword(c.Username, c.Password)}
+		if c.Path != "" {
+			u.Path = "/" + c.Path
+		}
+		b.WriteString(u.String() + "\n")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.path), 0700); err != nil {
+		return fmt.Errorf("failed to create credential store: %w", err)
+	}
+	tmp := s.path + ".lock"
+	if err := os.WriteFile(tmp, []byte(b.String()), 0600); err != nil {
+		return fmt.Errorf("failed to write credentials: %w", err)
+	}
+	if err := os.Rename(tmp, s.path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write credentials: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,300 @@
+// Package interactive lets the user pick hunks of a change one at a time,
+// as in add -p. A change is split into hunks, each of which the user may
+// take, skip, split into smaller hunks or edit by hand, and the hunks taken
+// are then applied to one side of the change or reverted from the other.
+package interactive
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/fenilsonani/vcs/internal/core/merge"
+)
+
+// DefaultContext is how many unchanged lines surround the changes of a hunk
+const DefaultContext = 3
+
+// Line is one line of a hunk. Op is ' ' for context, '-' for a removed line
+// and '+' for an added one; Text keeps its line terminator, which only the
+// last line of the content may lack.
+type Line struct {
+	Op   byte
+	Text string
+}
+
+// Hunk is a run of changed lines and the context around them. OldStart and
+// NewStart are the 0-based positions of its first line in the old and the
+// new content.
+type Hunk struct {
+	OldStart, NewStart int
+	Lines              []Line
+	// Selected is set when the user took the hunk
+	Selected bool
+}
+
+// SplitLines splits data after each newline, keeping the terminators
+func SplitLines(data []byte) []string {
+	var lines []string
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			lines = append(lines, string(data))
+			break
+		}
+		lines = append(lines, string(data[:i+1]))
+		data = data[i+1:]
+	}
+	return lines
+}
+
+// Diff returns the hunks that change old into new, with context lines
+// around each change. Changes closer than twice that share a hunk.
+func Diff(old, new []byte, context int) []*Hunk {
+	return DiffWith(old, new, context, merge.DiffMyers)
+}
+
+// DiffWith is Diff with the changed lines found by algorithm
+func DiffWith(old, new []byte, context int, algorithm merge.DiffAlgorithm) []*Hunk {
+	a, b := SplitLines(old), SplitLines(new)
+
+	// The edit script, each line with its position in old and new
+	type scriptLine struct {
+		Line
+		oldPos, newPos int
+	}
+	var script []scriptLine
+	i, j := 0, 0
+	matches := append(merge.MatchLinesWith(a, b, algorithm), merge.LineMatch{A: len(a), B: len(b)})
+	for _, m := range matches {
+		for ; i < m.A; i++ {
+			script = append(script, scriptLine{Line{'-', a[i]}, i, j})
+		}
+		for ; j < m.B; j++ {
+			script = append(script, scriptLine{Line{'+', b[j]}, i, j})
+		}
+		if m.A < len(a) {
+			script = append(script, scriptLine{Line{' ', a[i]}, i, j})
+			i, j = i+1, j+1
+		}
+	}
+
+	var hunks []*Hunk
+	for k := 0; k < len(script); k++ {
+		if script[k].Op == ' ' {
+			continue
+		}
+
+		// Extend the hunk over changes separated by little enough context
+		end, gap := k, 0
+		for n := k + 1; n < len(script) && gap <= 2*context; n++ {
+			if script[n].Op == ' ' {
+				gap++
+			} else {
+				end, gap = n, 0
+			}
+		}
+
+		start := k - context
+		if start < 0 {
+			start = 0
+		}
+		stop := end + context + 1
+		if stop > len(script) {
+			stop = len(script)
+		}
+		hunk := &Hunk{OldStart: script[start].oldPos, NewStart: script[start].newPos}
+		for _, line := range script[start:stop] {
+			hunk.Lines = append(hunk.Lines, line.Line)
+		}
+		hunks = append(hunks, hunk)
+		k = end
+	}
+	return hunks
+}
+
+// counts returns how many lines the hunk has in the old and the new content
+func (h *Hunk) counts() (old, new int) {
+	for _, line := range h.Lines {
+		if line.Op != '+' {
+			old++
+		}
+		if line.Op != '-' {
+			new++
+		}
+	}
+	return old, new
+}
+
+// Header returns the hunk's "@@ -old +new @@" line
+func (h *Hunk) Header() string {
+	oldCount, newCount := h.counts()
+	return fmt.Sprintf("@@ -%s +%s @@", hunkRange(h.OldStart, oldCount), hunkRange(h.NewStart, newCount))
+}
+
+// hunkRange formats the lines from start as a unified diff range, in which
+// an empty range names the line before it
+func hunkRange(start, count int) string {
+	switch count {
+	case 0:
+		return fmt.Sprintf("%d,0", start)
+	case 1:
+		return fmt.Sprintf("%d", start+1)
+	}
+	return fmt.Sprintf("%d,%d", start+1, count)
+}
+
+// String returns the hunk as it appears in a unified diff, header included
+func (h *Hunk) String() string {
+	var out bytes.Buffer
+	out.WriteString(h.Header() + "\n")
+	for _, line := range h.Lines {
+		out.WriteByte(line.Op)
+		out.WriteString(line.Text)
+		if !strings.HasSuffix(line.Text, "\n") {
+			out.WriteString("\n\\ No newline at end of file\n")
+		}
+	}
+	return out.String()
+}
+
+// Split splits the hunk at the context between its changes, each part
+// keeping the context on either side of it. It returns nil when the hunk
+// has only one run of changes.
+func (h *Hunk) Split() []*Hunk {
+	var parts []*Hunk
+	oldPos, newPos := h.OldStart, h.NewStart
+	// The run of context before the next changes, and where it starts
+	contextStart, contextOld, contextNew := 0, oldPos, newPos
+	for k := 0; k < len(h.Lines); {
+		if h.Lines[k].Op == ' ' {
+			oldPos, newPos = oldPos+1, newPos+1
+			k++
+			continue
+		}
+
+		end := k
+		for end < len(h.Lines) && h.Lines[end].Op != ' ' {
+			if h.Lines[end].Op == '-' {
+				oldPos++
+			} else {
+				newPos++
+			}
+			end++
+		}
+		stop := end
+		for stop < len(h.Lines) && h.Lines[stop].Op == ' ' {
+			stop++
+		}
+		part := &Hunk{OldStart: contextOld, NewStart: contextNew}
+		part.Lines = append(part.Lines, h.Lines[contextStart:stop]...)
+		parts = append(parts, part)
+
+		// The context after these changes comes before the next ones
+		contextStart, contextOld, contextNew = end, oldPos, newPos
+		k = end
+	}
+	if len(parts) < 2 {
+		return nil
+	}
+	return parts
+}
+
+// Reverse returns the hunk that undoes h
+func (h *Hunk) Reverse() *Hunk {
+	reversed := &Hunk{OldStart: h.NewStart, NewStart: h.OldStart, Selected: h.Selected}
+	for _, line := range h.Lines {
+		switch line.Op {
+		case '-':
+			line.Op = '+'
+		case '+':
+			line.Op = '-'
+		}
+		reversed.Lines = append(reversed.Lines, line)
+	}
+	return reversed
+}
+
+// side returns the text of the lines of the hunk in the old content, or in
+// the new content when new is set
+func (h *Hunk) side(new bool) string {
+	skip := byte('+')
+	if new {
+		skip = '-'
+	}
+	var text bytes.Buffer
+	for _, line := range h.Lines {
+		if line.Op != skip {
+			text.WriteString(line.Text)
+		}
+	}
+	return text.String()
+}
+
+// Apply applies the selected hunks, which must be in order, to old, the
+// content they were taken from. Hunks whose changes overlap those of an
+// earlier hunk, as edits of neighbouring parts of a split hunk may, are
+// left out.
+func Apply(old []byte, hunks []*Hunk) []byte {
+	lines := SplitLines(old)
+	var out bytes.Buffer
+	pos := 0
+	for _, h := range hunks {
+		if !h.Selected {
+			continue
+		}
+
+		// Only the lines from the first change to the last are applied,
+		// as the context may be shared with other hunks
+		first, last := -1, -1
+		for k, line := range h.Lines {
+			if line.Op != ' ' {
+				if first < 0 {
+					first = k
+				}
+				last = k
+			}
+		}
+		if first < 0 {
+			continue
+		}
+		start := h.OldStart
+		for _, line := range h.Lines[:first] {
+			if line.Op != '+' {
+				start++
+			}
+		}
+		if start < pos || start > len(lines) {
+			continue
+		}
+
+		for _, line := range lines[pos:start] {
+			out.WriteString(line)
+		}
+		pos = start
+		for _, line := range h.Lines[first : last+1] {
+			if line.Op != '+' {
+				pos++
+			}
+			if line.Op != '-' {
+				out.WriteString(line.Text)
+			}
+		}
+	}
+	if pos < len(lines) {
+		for _, line := range lines[pos:] {
+			out.WriteString(line)
+		}
+	}
+	return out.Bytes()
+}
+
+// Revert reverts the selected hunks of a change from old to new, which must
+// be in order, from new
+func Revert(new []byte, hunks []*Hunk) []byte {
+	reversed := make([]*Hunk, len(hunks))
+	for i, h := range hunks {
+		reversed[i] = h.Reverse()
+	}
+	return Apply(new, reversed)
+}
@@ -0,0 +1,109 @@
+package interactive
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+// numbered returns lines "1\n" to "n\n", with the lines in changes replaced
+func numbered(n int, changes map[int]string) []byte {
+	var b strings.Builder
+	for i := 1; i <= n; i++ {
+		if line, ok := changes[i]; ok {
+			b.WriteString(line)
+			continue
+		}
+		fmt.Fprintf(&b, "%d\n", i)
+	}
+	return []byte(b.String())
+}
+
+func TestDiff(t *testing.T) {
+	old := numbered(20, nil)
+	new := numbered(20, map[int]string{2: "two\n", 18: ""})
+
+	hunks := Diff(old, new, DefaultContext)
+	if len(hunks) != 2 {
+		t.Fatalf("got %d hunks, want 2", len(hunks))
+	}
+	if got, want := hunks[0].Header(), "@@ -1,5 +1,5 @@"; got != want {
+		t.Errorf("first header = %q, want %q", got, want)
+	}
+	if got, want := hunks[1].Header(), "@@ -15,6 +15,5 @@"; got != want {
+		t.Errorf("second header = %q, want %q", got, want)
+	}
+
+	// Changes with little context between them share a hunk
+	close := numbered(20, map[int]string{2: "two\n", 8: "eight\n"})
+	if hunks := Diff(old, close, DefaultContext); len(hunks) != 1 {
+		t.Errorf("got %d hunks for nearby changes, want 1", len(hunks))
+	}
+	if hunks := Diff(old, old, DefaultContext); len(hunks) != 0 {
+		t.Errorf("got %d hunks for no change, want 0", len(hunks))
+	}
+}
+
+func TestApplyAndRevert(t *testing.T) {
+	old := numbered(20, nil)
+	new := numbered(20, map[int]string{2: "two\n", 18: ""})
+	hunks := Diff(old, new, DefaultContext)
+
+	hunks[1].Selected = true
+	want := string(numbered(20, map[int]string{18: ""}))
+	if got := string(Apply(old, hunks)); got != want {
+		t.Errorf("Apply = %q, want %q", got, want)
+	}
+	want = string(numbered(20, map[int]string{2: "two\n"}))
+	if got := string(Revert(new, hunks)); got != want {
+		t.Errorf("Revert = %q, want %q", got, want)
+	}
+
+	for _, h := range hunks {
+		h.Selected = true
+	}
+	if got := string(Apply(old, hunks)); got != string(new) {
+		t.Errorf("Apply of every hunk = %q, want %q", got, new)
+	}
+	if got := string(Revert(new, hunks)); got != string(old) {
+		t.Errorf("Revert of every hunk = %q, want %q", got, old)
+	}
+}
+
+func TestSplit(t *testing.T) {
+	old := numbered(20, nil)
+	new := numbered(20, map[int]string{2: "two\n", 8: "eight\n"})
+	hunks := Diff(old, new, DefaultContext)
+
+	parts := hunks[0].Split()
+	if len(parts) != 2 {
+		t.Fatalf("got %d parts, want 2", len(parts))
+	}
+	if got, want := parts[1].Header(), "@@ -3,9 +3,9 @@"; got != want {
+		t.Errorf("second part header = %q, want %q", got, want)
+	}
+	if parts[0].Split() != nil {
+		t.Error("a hunk with one change was split")
+	}
+
+	parts[1].Selected = true
+	want := string(numbered(20, map[int]string{8: "eight\n"}))
+	if got := string(Apply(old, parts)); got != want {
+		t.Errorf("Apply of second part = %q, want %q", got, want)
+	}
+}
+
+func TestNoNewlineAtEnd(t *testing.T) {
+	hunks := Diff([]byte("a\nb"), []byte("a\nc"), DefaultContext)
+	if len(hunks) != 1 {
+		t.Fatalf("got %d hunks, want 1", len(hunks))
+	}
+	want := "@@ -1,2 +1,2 @@\n a\n-b\n\\ No newline at end of file\n+c\n\\ No newline at end of file\n"
+	if got := hunks[0].String(); got != want {
+		t.Errorf("String = %q, want %q", got, want)
+	}
+	hunks[0].Selected = true
+	if got := string(Apply([]byte("a\nb"), hunks)); got != "a\nc" {
+		t.Errorf("Apply = %q, want %q", got, "a\nc")
+	}
+}
@@ -0,0 +1,200 @@
+package interactive
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Session asks the user about the hunks of one or more files, on in and
+// out. Quitting in one file skips the files after it.
+type Session struct {
+	in  *bufio.Reader
+	out io.Writer
+	// Prompt is the question asked for each hunk, such as "Stage this hunk"
+	Prompt string
+	// Revert is set when the hunks taken are reverted from the new content
+	// rather than applied to the old, which changes how they are edited
+	Revert bool
+	// Edit lets the user edit text and returns the result; without it
+	// hunks cannot be edited
+	Edit func(text string) (string, error)
+
+	quit  bool
+	asked bool
+}
+
+// NewSession returns a session asking prompt about each hunk
+func NewSession(in io.Reader, out io.Writer, prompt string) *Session {
+	return &Session{in: bufio.NewReader(in), out: out, Prompt: prompt}
+}
+
+// Asked reports whether the user has been asked about any hunk
+func (s *Session) Asked() bool {
+	return s.asked
+}
+
+// Select asks about each hunk of the change of path from old to new and
+// returns the hunks, after any splits and edits, with those taken
+// selected
+func (s *Session) Select(path string, old, new []byte) ([]*Hunk, error) {
+	hunks := Diff(old, new, DefaultContext)
+	if s.quit || len(hunks) == 0 {
+		return hunks, nil
+	}
+	s.asked = true
+
+	fmt.Fprintf(s.out, "diff --git a/%s b/%s\n--- a/%s\n+++ b/%s\n", path, path, path, path)
+	for i := 0; i < len(hunks); {
+		hunk := hunks[i]
+		parts := hunk.Split()
+		choices := "y,n,q,a,d"
+		if parts != nil {
+			choices += ",s"
+		}
+		if s.Edit != nil {
+			choices += ",e"
+		}
+		fmt.Fprint(s.out, hunk.String())
+		fmt.Fprintf(s.out, "(%d/%d) %s [%s,?]? ", i+1, len(hunks), s.Prompt, choices)
+
+		answer, err := s.in.ReadString('\n')
+		if err != nil && answer == "" {
+			// Running out of input ends the session like q
+			fmt.Fprintln(s.out)
+			s.quit = true
+			return hunks, nil
+		}
+		answer = strings.ToLower(strings.TrimSpace(answer))
+		if answer == "" {
+			continue
+		}
+
+		switch answer[0] {
+		case 'y':
+			hunk.Selected = true
+		case 'n':
+		case 'q':
+			s.quit = true
+			return hunks, nil
+		case 'a':
+			for _, h := range hunks[i:] {
+				h.Selected = true
+			}
+			return hunks, nil
+		case 'd':
+			return hunks, nil
+		case 's':
+			if parts == nil {
+				fmt.Fprintln(s.out, "Sorry, cannot split this hunk")
+				continue
+			}
+			fmt.Fprintf(s.out, "Split into %d hunks.\n", len(parts))
+			hunks = append(hunks[:i], append(parts, hunks[i+1:]...)...)
+			continue
+		case 'e':
+			if s.Edit == nil {
+				s.help()
+				continue
+			}
+			edited, err := s.edit(hunk)
+			if err != nil {
+				return nil, err
+			}
+			if edited == nil {
+				continue
+			}
+			edited.Selected = true
+			hunks[i] = edited
+		default:
+			s.help()
+			continue
+		}
+		i++
+	}
+	return hunks, nil
+}
+
+// help explains the answers to the hunk prompt
+func (s *Session) help() {
+	verb := strings.ToLower(strings.Fields(s.Prompt + " take")[0])
+	fmt.Fprintf(s.out, `y - %[1]s this hunk
+n - do not %[1]s this hunk
+q - quit; do not %[1]s this hunk or any of the remaining ones
+a - %[1]s this hunk and all later hunks in the file
+d - do not %[1]s this hunk or any of the later hunks in the file
+s - split the current hunk into smaller hunks
+e - manually edit the current hunk
+? - print help
+`, verb)
+}
+
+// edit lets the user edit hunk until the result fits the content it came
+// from, returning nil when they give up or remove every line
+func (s *Session) edit(hunk *Hunk) (*Hunk, error) {
+	// The hunk is edited as shown; the side the hunks are applied to must
+	// stay as it is
+	removed, added := "-", "+"
+	if s.Revert {
+		removed, added = "+", "-"
+	}
+	guide := fmt.Sprintf(`# Manual hunk edit mode - see bottom for a quick guide.
+%s# ---
+# To remove '%s' lines, make them ' ' lines (context).
+# To remove '%s' lines, delete them.
+# Lines starting with # will be removed.
+# If the hunk does not fit the file, you will be given an opportunity to
+# edit again. If all lines of the hunk are removed, then the edit is
+# aborted and the hunk is left unchanged.
+`, hunk.String(), removed, added)
+
+	for {
+		text, err := s.Edit(guide)
+		if err != nil {
+			return nil, err
+		}
+		edited := parseHunk(text, hunk.OldStart, hunk.NewStart)
+		if edited == nil {
+			return nil, nil
+		}
+		if edited.side(s.Revert) == hunk.side(s.Revert) {
+			return edited, nil
+		}
+
+		fmt.Fprint(s.out, "Your edited hunk does not apply. Edit again (saying \"no\" discards!) [y/n]? ")
+		answer, _ := s.in.ReadString('\n')
+		if !strings.HasPrefix(strings.ToLower(strings.TrimSpace(answer)), "y") {
+			return nil, nil
+		}
+	}
+}
+
+// parseHunk reads the lines of an edited hunk, leaving out comments and
+// the header. It returns nil when no lines are left.
+func parseHunk(text string, oldStart, newStart int) *Hunk {
+	hunk := &Hunk{OldStart: oldStart, NewStart: newStart}
+	for _, line := range SplitLines([]byte(text)) {
+		switch {
+		case strings.HasPrefix(line, "#"), strings.HasPrefix(line, "@@"):
+		case strings.HasPrefix(line, "\\"):
+			// The line before has no newline at the end of the file
+			if n := len(hunk.Lines); n > 0 {
+				hunk.Lines[n-1].Text = strings.TrimSuffix(hunk.Lines[n-1].Text, "\n")
+			}
+		case line == "\n":
+			// Editors may strip the space of an empty context line
+			hunk.Lines = append(hunk.Lines, Line{' ', "\n"})
+		case line[0] == ' ' || line[0] == '-' || line[0] == '+':
+			text := line[1:]
+			if !strings.HasSuffix(text, "\n") {
+				text += "\n"
+			}
+			hunk.Lines = append(hunk.Lines, Line{line[0], text})
+		}
+	}
+	if len(hunk.Lines) == 0 {
+		return nil
+	}
+	return hunk
+}
@@ -0,0 +1,69 @@
+package interactive
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestSessionSelect(t *testing.T) {
+	old := numbered(20, nil)
+	new := numbered(20, map[int]string{2: "two\n", 8: "eight\n", 18: ""})
+
+	var out bytes.Buffer
+	s := NewSession(strings.NewReader("s\nn\ny\nq\n"), &out, "Stage this hunk")
+	hunks, err := s.Select("f.txt", old, new)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "(1/2) Stage this hunk [y,n,q,a,d,s,?]? Split into 2 hunks.") {
+		t.Errorf("unexpected prompts:\n%s", out.String())
+	}
+	want := string(numbered(20, map[int]string{8: "eight\n"}))
+	if got := string(Apply(old, hunks)); got != want {
+		t.Errorf("Apply = %q, want %q", got, want)
+	}
+
+	// After q no more files are asked about
+	out.Reset()
+	hunks, err = s.Select("g.txt", old, new)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if out.Len() != 0 || string(Apply(old, hunks)) != string(old) {
+		t.Errorf("file after quitting was asked about:\n%s", out.String())
+	}
+}
+
+func TestSessionEdit(t *testing.T) {
+	old := []byte("a\nb\nc\n")
+	new := []byte("a\nB\nc\nd\n")
+
+	var out bytes.Buffer
+	s := NewSession(strings.NewReader("e\ny\n"), &out, "Stage this hunk")
+	edits := []string{
+		// Changing a line of the old content does not apply
+		"@@ -1,3 +1,4 @@\n a\n-x\n+B\n c\n",
+		// Keeping b and dropping the added d does
+		"# comment\n@@ -1,3 +1,4 @@\n a\n b\n+B\n c\n",
+	}
+	s.Edit = func(text string) (string, error) {
+		if !strings.Contains(text, "# To remove '-' lines, make them ' ' lines (context).") {
+			t.Errorf("edit guide missing from:\n%s", text)
+		}
+		edit := edits[0]
+		edits = edits[1:]
+		return edit, nil
+	}
+
+	hunks, err := s.Select("f.txt", old, new)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(out.String(), "Your edited hunk does not apply.") {
+		t.Errorf("bad edit was not reported:\n%s", out.String())
+	}
+	if got, want := string(Apply(old, hunks)), "a\nb\nB\nc\n"; got != want {
+		t.Errorf("Apply = %q, want %q", got, want)
+	}
+}
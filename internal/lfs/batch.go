@@ -0,0 +1,217 @@
+package lfs
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// MediaType is the content type of batch API requests and responses
+const MediaType = "application/vnd.git-lfs+json"
+
+// Operations of the batch API
+const (
+	Download = "download"
+	Upload   = "upload"
+)
+
+// BatchRequest asks the server how to transfer objects
+type BatchRequest struct {
+	Operation string    `json:"operation"`
+	Transfers []string  `json:"transfers,omitempty"`
+	Objects   []Pointer `json:"objects"`
+}
+
+// BatchResponse tells how to transfer each object requested
+type BatchResponse struct {
+	Transfer string        `json:"transfer,omitempty"`
+	Objects  []BatchObject `json:"objects"`
+}
+
+// BatchObject is an object of a batch response. An upload without actions
+// means the server already has the object.
+type BatchObject struct {
+	Pointer
+	Actions map[string]*Action `json:"actions,omitempty"`
+	Error   *ObjectError       `json:"error,omitempty"`
+}
+
+// Action is a request to make to transfer an object
+type Action struct {
+	Href      string            `json:"href"`
+	Header    map[string]string `json:"header,omitempty"`
+	ExpiresIn int               `json:"expires_in,omitempty"`
+}
+
+// ObjectError is why an object cannot be transferred
+type ObjectError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+func (e *ObjectError) Error() string {
+	return fmt.Sprintf("%s (%d)", e.Message, e.Code)
+}
+
+// Endpoint returns the LFS endpoint of the repository at remoteURL, as Git
+// LFS derives it
+func Endpoint(remoteURL string) string {
+	remoteURL = strings.TrimSuffix(remoteURL, "/")
+	if strings.HasSuffix(remoteURL, ".git") {
+		return remoteURL + "/info/lfs"
+	}
+	return remoteURL + ".git/info/lfs"
+}
+
+// Client transfers objects with an LFS server's batch API
+type Client struct {
+	endpoint string
+	client   *http.Client
+}
+
+// NewClient returns a client for the LFS endpoint
+func NewClient(endpoint string) *Client {
+	return &Client{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		client:   &http.Client{Timeout: 10 * time.Minute},
+	}
+}
+
+// Batch asks how to transfer objects for operation
+func (c *Client) Batch(ctx context.Context, operation string, objects []Pointer) (*BatchResponse, error) {
+	body, err := json.Marshal(&BatchRequest{Operation: operation, Transfers: []string{"basic"}, Objects: objects})
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.endpoint+"/objects/batch", bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Accept", MediaType)
+	req.Header.Set("Content-Type", MediaType)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach LFS server: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("LFS batch request failed: %s", responseError(resp))
+	}
+
+	var batch BatchResponse
+	if err := json.NewDecoder(resp.Body).Decode(&batch); err != nil {
+		return nil, fmt.Errorf("failed to parse LFS batch response: %w", err)
+	}
+	if batch.Transfer != "" && batch.Transfer != "basic" {
+		return nil, fmt.Errorf("unsupported LFS transfer %q", batch.Transfer)
+	}
+	return &batch, nil
+}
+
+// Download fetches obj into store as its download action says
+func (c *Client) Download(ctx context.Context, store *Store, obj BatchObject) error {
+	if obj.Error != nil {
+		return fmt.Errorf("cannot download %s: %w", obj.Oid, obj.Error)
+	}
+	action := obj.Actions[Download]
+	if action == nil {
+		return fmt.Errorf("cannot download %s: the server offered no download", obj.Oid)
+	}
+
+	resp, err := c.do(ctx, http.MethodGet, action, nil, 0)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to download %s: %s", obj.Oid, responseError(resp))
+	}
+	return store.Receive(obj.Pointer, resp.Body)
+}
+
+// Upload sends obj from store as its upload action says, then has the
+// server verify it when it asks to. An object without an upload action is
+// on the server already.
+func (c *Client) Upload(ctx context.Context, store *Store, obj BatchObject) error {
+	if obj.Error != nil {
+		return fmt.Errorf("cannot upload %s: %w", obj.Oid, obj.Error)
+	}
+	action := obj.Actions[Upload]
+	if action == nil {
+		return nil
+	}
+
+	file, err := store.Open(obj.Pointer)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	resp, err := c.do(ctx, http.MethodPut, action, file, obj.Size)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return fmt.Errorf("failed to upload %s: %s", obj.Oid, responseError(resp))
+	}
+
+	if verify := obj.Actions["verify"]; verify != nil {
+		body, err := json.Marshal(obj.Pointer)
+		if err != nil {
+			return err
+		}
+		resp, err := c.do(ctx, http.MethodPost, verify, bytes.NewReader(body), int64(len(body)))
+		if err != nil {
+			return err
+		}
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("failed to verify %s: %s", obj.Oid, responseError(resp))
+		}
+	}
+	return nil
+}
+
+// do makes the request of action
+func (c *Client) do(ctx context.Context, method string, action *Action, body io.Reader, size int64) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, action.Href, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	for name, value := range action.Header {
+		req.Header.Set(name, value)
+	}
+	if body != nil {
+		req.ContentLength = size
+		if req.Header.Get("Content-Type") == "" {
+			req.Header.Set("Content-Type", "application/octet-stream")
+		}
+	}
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach LFS server: %w", err)
+	}
+	return resp, nil
+}
+
+// responseError describes a failed response, with the message of a Git LFS
+// error body when there is one
+func responseError(resp *http.Response) string {
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	var body struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(data, &body) == nil && body.Message != "" {
+		return fmt.Sprintf("%s: %s", resp.Status, body.Message)
+	}
+	if text := strings.TrimSpace(string(data)); text != "" {
+		return fmt.Sprintf("%s: %s", resp.Status, text)
+	}
+	return resp.Status
+}
@@ -0,0 +1,99 @@
+package lfs
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestPointerRoundTrip(t *testing.T) {
+	p := NewPointer([]byte("hello\n"))
+	want := "version https://git-lfs.github.com/spec/v1\n" +
+		"oid sha256:5891b5b522d5df086d0ff0b110fbd9d21bb4fc7163af34d08286a2e846f6be03\n" +
+		"size 6\n"
+	if got := string(p.Encode()); got != want {
+		t.Fatalf("Encode() = %q, want %q", got, want)
+	}
+
+	parsed, ok := ParsePointer(p.Encode())
+	if !ok || parsed != p {
+		t.Fatalf("ParsePointer(Encode()) = %v, %v, want %v", parsed, ok, p)
+	}
+}
+
+func TestParsePointer(t *testing.T) {
+	oid := strings.Repeat("ab", 32)
+	tests := []struct {
+		name string
+		data string
+		ok   bool
+	}{
+		{"valid", "version " + Version + "\noid sha256:" + oid + "\nsize 12\n", true},
+		{"extra keys", "version " + Version + "\next-0-foo sha256:00\noid sha256:" + oid + "\nsize 12\n", true},
+		{"no size", "version " + Version + "\noid sha256:" + oid + "\n", false},
+		{"other version", "version https://example.com/v2\noid sha256:" + oid + "\nsize 12\n", false},
+		{"short oid", "version " + Version + "\noid sha256:abc\nsize 12\n", false},
+		{"negative size", "version " + Version + "\noid sha256:" + oid + "\nsize -1\n", false},
+		{"plain text", "just some text\n", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, ok := ParsePointer([]byte(tt.data)); ok != tt.ok {
+				t.Errorf("ParsePointer() ok = %v, want %v", ok, tt.ok)
+			}
+		})
+	}
+}
+
+func TestStoreCleanSmudge(t *testing.T) {
+	store := NewStore(t.TempDir())
+	content := bytes.Repeat([]byte("large file\n"), 100)
+
+	blob, err := store.Clean(content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p, ok := ParsePointer(blob)
+	if !ok {
+		t.Fatalf("Clean() returned %q, not a pointer", blob)
+	}
+	if !store.Has(p) {
+		t.Fatal("store does not have the cleaned content")
+	}
+
+	// Cleaning a pointer leaves it alone
+	again, err := store.Clean(blob)
+	if err != nil || !bytes.Equal(again, blob) {
+		t.Fatalf("Clean(pointer) = %q, %v", again, err)
+	}
+
+	smudged, err := store.Smudge(blob)
+	if err != nil || !bytes.Equal(smudged, content) {
+		t.Fatalf("Smudge() did not restore the content: %v", err)
+	}
+
+	// A pointer to content the store lacks is checked out as it is
+	missing := NewPointer([]byte("elsewhere")).Encode()
+	smudged, err = store.Smudge(missing)
+	if err != nil || !bytes.Equal(smudged, missing) {
+		t.Fatalf("Smudge(missing) = %q, %v", smudged, err)
+	}
+}
+
+func TestStoreReceiveRejectsCorruptContent(t *testing.T) {
+	store := NewStore(t.TempDir())
+	p := NewPointer([]byte("expected"))
+
+	if err := store.Receive(p, strings.NewReader("tampered")); err == nil {
+		t.Fatal("Receive() accepted content that does not match the pointer")
+	}
+	if store.Has(p) {
+		t.Fatal("store kept corrupt content")
+	}
+	if err := store.Receive(p, strings.NewReader("expected")); err != nil {
+		t.Fatal(err)
+	}
+	if !store.Has(p) {
+		t.Fatal("store does not have received content")
+	}
+}
@@ -0,0 +1,89 @@
+// Package lfs stores large files outside the object database in the Git LFS
+// format. A tracked file is committed as a small pointer blob naming the
+// SHA-256 of its content, while the content itself is kept in the
+// repository's lfs/objects directory and moved between repositories with
+// the Git LFS batch API.
+package lfs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is the pointer format written, the first line of every pointer
+const Version = "https://git-lfs.github.com/spec/v1"
+
+// MaxPointerSize is the largest blob read as a pointer; pointers are always
+// much smaller
+const MaxPointerSize = 1024
+
+// Pointer names the content of a large file
+type Pointer struct {
+	// Oid is the hex SHA-256 of the content
+	Oid  string `json:"oid"`
+	Size int64  `json:"size"`
+}
+
+// NewPointer returns the pointer to data
+func NewPointer(data []byte) Pointer {
+	sum := sha256.Sum256(data)
+	return Pointer{Oid: hex.EncodeToString(sum[:]), Size: int64(len(data))}
+}
+
+// Encode returns the pointer as it is stored in a blob
+func (p Pointer) Encode() []byte {
+	return []byte(fmt.Sprintf("version %s\noid sha256:%s\nsize %d\n", Version, p.Oid, p.Size))
+}
+
+// ParsePointer parses data as a pointer blob. Keys other than version, oid
+// and size, which later versions of the format add, are ignored.
+func ParsePointer(data []byte) (Pointer, bool) {
+	if len(data) > MaxPointerSize || !bytes.HasPrefix(data, []byte("version ")) {
+		return Pointer{}, false
+	}
+
+	var p Pointer
+	var haveOid, haveSize bool
+	for i, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+		key, value, ok := strings.Cut(line, " ")
+		if !ok {
+			return Pointer{}, false
+		}
+		switch {
+		case i == 0:
+			if key != "version" || value != Version {
+				return Pointer{}, false
+			}
+		case key == "oid":
+			oid, ok := strings.CutPrefix(value, "sha256:")
+			if !ok || !ValidOid(oid) {
+				return Pointer{}, false
+			}
+			p.Oid, haveOid = oid, true
+		case key == "size":
+			size, err := strconv.ParseInt(value, 10, 64)
+			if err != nil || size < 0 {
+				return Pointer{}, false
+			}
+			p.Size, haveSize = size, true
+		}
+	}
+	return p, haveOid && haveSize
+}
+
+// ValidOid reports whether oid is a hex SHA-256
+func ValidOid(oid string) bool {
+	if len(oid) != 64 {
+		return false
+	}
+	for _, c := range oid {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return false
+		}
+	}
+	return true
+}
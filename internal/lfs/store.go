@@ -0,0 +1,120 @@
+package lfs
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// Store holds the content of large files, in lfs/objects under a
+// repository directory, each at <oid[0:2]>/<oid[2:4]>/<oid> as Git LFS
+// lays them out
+type Store struct {
+	dir string
+}
+
+// NewStore returns the store of the repository directory gitDir
+func NewStore(gitDir string) *Store {
+	return &Store{dir: filepath.Join(gitDir, "lfs", "objects")}
+}
+
+// Dir returns the directory the store keeps objects in
+func (s *Store) Dir() string {
+	return s.dir
+}
+
+// Path returns where the content named oid is kept
+func (s *Store) Path(oid string) string {
+	return filepath.Join(s.dir, oid[0:2], oid[2:4], oid)
+}
+
+// Has reports whether the store holds the content p points to
+func (s *Store) Has(p Pointer) bool {
+	info, err := os.Stat(s.Path(p.Oid))
+	return err == nil && info.Size() == p.Size
+}
+
+// Open opens the content p points to
+func (s *Store) Open(p Pointer) (*os.File, error) {
+	file, err := os.Open(s.Path(p.Oid))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open LFS object %s: %w", p.Oid, err)
+	}
+	return file, nil
+}
+
+// Read returns the content p points to
+func (s *Store) Read(p Pointer) ([]byte, error) {
+	data, err := os.ReadFile(s.Path(p.Oid))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read LFS object %s: %w", p.Oid, err)
+	}
+	return data, nil
+}
+
+// Write stores data and returns the pointer to it
+func (s *Store) Write(data []byte) (Pointer, error) {
+	p := NewPointer(data)
+	if s.Has(p) {
+		return p, nil
+	}
+	return p, s.Receive(p, bytes.NewReader(data))
+}
+
+// Receive stores the content p points to from r. Content that does not
+// match p is refused, and the store never holds a partly written object.
+func (s *Store) Receive(p Pointer, r io.Reader) error {
+	if !ValidOid(p.Oid) {
+		return fmt.Errorf("invalid LFS object id %q", p.Oid)
+	}
+	path := s.Path(p.Oid)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to store LFS object: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), p.Oid+".tmp-")
+	if err != nil {
+		return fmt.Errorf("failed to store LFS object: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	hash := sha256.New()
+	n, err := io.Copy(io.MultiWriter(tmp, hash), r)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to store LFS object %s: %w", p.Oid, err)
+	}
+	if got := hex.EncodeToString(hash.Sum(nil)); got != p.Oid || n != p.Size {
+		return fmt.Errorf("LFS object %s is corrupt: got %d bytes with id %s", p.Oid, n, got)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Clean stores data and returns the pointer blob to commit in its place.
+// Data that is already a pointer is returned as it is.
+func (s *Store) Clean(data []byte) ([]byte, error) {
+	if _, ok := ParsePointer(data); ok {
+		return data, nil
+	}
+	p, err := s.Write(data)
+	if err != nil {
+		return nil, err
+	}
+	return p.Encode(), nil
+}
+
+// Smudge returns the content a pointer blob points to. Other blobs, and
+// pointers to content the store does not have yet, are returned as they
+// are, so the pointer is checked out until the content is fetched.
+func (s *Store) Smudge(data []byte) ([]byte, error) {
+	p, ok := ParsePointer(data)
+	if !ok || !s.Has(p) {
+		return data, nil
+	}
+	return s.Read(p)
+}
@@ -0,0 +1,107 @@
+package metrics
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// JournalFile is the name of the journal in the repository directory
+const JournalFile = "metrics.json"
+
+// Kinds of operations in the journal
+const (
+	KindFetch       = "fetch"
+	KindMaintenance = "maintenance"
+)
+
+// Run summarizes the runs of an operation
+type Run struct {
+	// LastStart is when the latest run started
+	LastStart time.Time `json:"last_start"`
+	// LastDuration is how long the latest run took
+	LastDuration time.Duration `json:"last_duration"`
+	// LastSuccess is set when the latest run succeeded
+	LastSuccess bool `json:"last_success"`
+	// LastSuccessTime is when the latest successful run ended
+	LastSuccessTime time.Time `json:"last_success_time,omitempty"`
+	Runs            int64     `json:"runs"`
+	Failures        int64     `json:"failures"`
+}
+
+// Journal holds the runs of each operation by kind, then by name, such as
+// the remote fetched from or the maintenance task
+type Journal struct {
+	Operations map[string]map[string]*Run `json:"operations"`
+}
+
+// ReadJournal reads the journal in dir. A missing journal reads as empty.
+func ReadJournal(dir string) (*Journal, error) {
+	journal := &Journal{Operations: make(map[string]map[string]*Run)}
+	data, err := os.ReadFile(filepath.Join(dir, JournalFile))
+	if os.IsNotExist(err) {
+		return journal, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read metrics journal: %w", err)
+	}
+	if err := json.Unmarshal(data, journal); err != nil {
+		return nil, fmt.Errorf("failed to parse metrics journal: %w", err)
+	}
+	if journal.Operations == nil {
+		journal.Operations = make(map[string]map[string]*Run)
+	}
+	return journal, nil
+}
+
+// Record adds a run of the operation kind/name that started at start and
+// ended now, failing when failed is set, to the journal in dir. Runs
+// recorded at the same moment by two processes may lose one of them; the
+// journal is replaced atomically, so it is never torn.
+func Record(dir, kind, name string, start time.Time, failed bool) error {
+	journal, err := ReadJournal(dir)
+	if err != nil {
+		return err
+	}
+	runs := journal.Operations[kind]
+	if runs == nil {
+		runs = make(map[string]*Run)
+		journal.Operations[kind] = runs
+	}
+	run := runs[name]
+	if run == nil {
+		run = &Run{}
+		runs[name] = run
+	}
+
+	end := time.Now()
+	run.LastStart = start.UTC()
+	run.LastDuration = end.Sub(start)
+	run.LastSuccess = !failed
+	run.Runs++
+	if failed {
+		run.Failures++
+	} else {
+		run.LastSuccessTime = end.UTC()
+	}
+
+	data, err := json.MarshalIndent(journal, "", "  ")
+	if err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, JournalFile+".tmp-")
+	if err != nil {
+		return fmt.Errorf("failed to write metrics journal: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = tmp.Write(append(data, '\n'))
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write metrics journal: %w", err)
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, JournalFile))
+}
@@ -0,0 +1,136 @@
+// Package metrics writes metrics in the Prometheus text exposition format
+// and keeps a journal of the runs of operations, such as fetches and
+// maintenance tasks, whose results are exported as metrics later.
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Metric types
+const (
+	Gauge   = "gauge"
+	Counter = "counter"
+)
+
+// ContentType is the media type of the text exposition format
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// family is a metric with its samples
+type family struct {
+	name    string
+	typ     string
+	help    string
+	samples []sample
+}
+
+type sample struct {
+	labels []string
+	value  float64
+}
+
+// Set collects samples of metrics for writing
+type Set struct {
+	families []*family
+	byName   map[string]*family
+}
+
+// NewSet returns an empty set
+func NewSet() *Set {
+	return &Set{byName: make(map[string]*family)}
+}
+
+// Add adds a sample of the metric name, declared with typ and help when it
+// is first added. labels are name, value pairs.
+func (s *Set) Add(name, typ, help string, value float64, labels ...string) {
+	if len(labels)%2 != 0 {
+		panic("metrics: odd number of label names and values")
+	}
+	f := s.byName[name]
+	if f == nil {
+		f = &family{name: name, typ: typ, help: help}
+		s.byName[name] = f
+		s.families = append(s.families, f)
+	}
+	f.samples = append(f.samples, sample{labels: labels, value: value})
+}
+
+// WriteTo writes the set in the text exposition format, each metric once
+// with its samples in the order added
+func (s *Set) WriteTo(w io.Writer) (int64, error) {
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+	for _, f := range s.families {
+		fmt.Fprintf(cw, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+		fmt.Fprintf(cw, "# TYPE %s %s\n", f.name, f.typ)
+		for _, smp := range f.samples {
+			cw.WriteString(f.name)
+			if len(smp.labels) > 0 {
+				cw.WriteString("{")
+				for i := 0; i < len(smp.labels); i += 2 {
+					if i > 0 {
+						cw.WriteString(",")
+					}
+					fmt.Fprintf(cw, "%s=\"%s\"", smp.labels[i], escapeLabel(smp.labels[i+1]))
+				}
+				cw.WriteString("}")
+			}
+			fmt.Fprintf(cw, " %s\n", formatValue(smp.value))
+		}
+	}
+	if cw.err == nil {
+		cw.err = cw.w.(*bufio.Writer).Flush()
+	}
+	return cw.n, cw.err
+}
+
+// countingWriter counts what is written and keeps the first error
+type countingWriter struct {
+	w   io.Writer
+	n   int64
+	err error
+}
+
+func (c *countingWriter) Write(p []byte) (int, error) {
+	if c.err != nil {
+		return 0, c.err
+	}
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.err = err
+	return n, err
+}
+
+func (c *countingWriter) WriteString(s string) {
+	c.Write([]byte(s))
+}
+
+// formatValue formats a sample value as the exposition format expects
+func formatValue(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelEscaper.Replace(s)
+}
@@ -0,0 +1,84 @@
+package metrics
+
+import (
+	"bytes"
+	"math"
+	"testing"
+	"time"
+)
+
+func TestSetWriteTo(t *testing.T) {
+	set := NewSet()
+	set.Add("vcs_packs", Gauge, "Number of packfiles", 2, "repo", "/a")
+	set.Add("vcs_runs_total", Counter, "Runs\nof fetches", 1.5, "repo", `/b "x"\y`)
+	set.Add("vcs_packs", Gauge, "ignored", 0, "repo", "/b")
+	set.Add("vcs_up", Gauge, "Up", math.Inf(1))
+
+	var buf bytes.Buffer
+	n, err := set.WriteTo(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n != int64(buf.Len()) {
+		t.Errorf("WriteTo returned %d, wrote %d bytes", n, buf.Len())
+	}
+
+	want := `# HELP vcs_packs Number of packfiles
+# TYPE vcs_packs gauge
+vcs_packs{repo="/a"} 2
+vcs_packs{repo="/b"} 0
+# HELP vcs_runs_total Runs\nof fetches
+# TYPE vcs_runs_total counter
+vcs_runs_total{repo="/b \"x\"\\y"} 1.5
+# HELP vcs_up Up
+# TYPE vcs_up gauge
+vcs_up +Inf
+`
+	if buf.String() != want {
+		t.Errorf("WriteTo wrote\n%s\nwant\n%s", buf.String(), want)
+	}
+}
+
+func TestRecord(t *testing.T) {
+	dir := t.TempDir()
+
+	journal, err := ReadJournal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(journal.Operations) != 0 {
+		t.Fatalf("missing journal read as %v", journal.Operations)
+	}
+
+	start := time.Now().Add(-time.Second)
+	if err := Record(dir, KindFetch, "origin", start, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := Record(dir, KindFetch, "origin", start, true); err != nil {
+		t.Fatal(err)
+	}
+	if err := Record(dir, KindMaintenance, "gc", start, false); err != nil {
+		t.Fatal(err)
+	}
+
+	journal, err = ReadJournal(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	run := journal.Operations[KindFetch]["origin"]
+	if run == nil {
+		t.Fatal("fetch from origin not recorded")
+	}
+	if run.Runs != 2 || run.Failures != 1 || run.LastSuccess {
+		t.Errorf("fetch run = %+v, want 2 runs with the latest failed", run)
+	}
+	if run.LastSuccessTime.IsZero() {
+		t.Error("time of the earlier successful fetch lost")
+	}
+	if run.LastDuration < time.Second {
+		t.Errorf("duration %v shorter than the run", run.LastDuration)
+	}
+	if gc := journal.Operations[KindMaintenance]["gc"]; gc == nil || !gc.LastSuccess || gc.Runs != 1 {
+		t.Errorf("gc run = %+v", gc)
+	}
+}
@@ -0,0 +1,171 @@
+// Package oplog keeps a journal of the operations run in a repository. Each
+// operation records the command that ran and the state of HEAD, the refs,
+// the index and the stash before and after it, so operations can be listed
+// and undone beyond what reflogs cover.
+package oplog
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// File is the name of the journal in the repository directory
+const File = "oplog"
+
+// MaxOperations is how many operations the journal keeps; older ones are
+// dropped
+const MaxOperations = 500
+
+// State is what an operation may change. Object IDs are hex.
+type State struct {
+	// Head is the content of HEAD: "ref: <name>" or an object ID
+	Head string `json:"head"`
+	// Refs maps each ref under refs/ to the object it points to. Journaled
+	// states keep only the refs their operation changed; see Trim.
+	Refs map[string]string `json:"refs,omitempty"`
+	// Index is the blob holding the index file, empty without an index
+	Index string `json:"index,omitempty"`
+	// Stash is the content of the stash reflog, which lists the stashes
+	Stash string `json:"stash,omitempty"`
+}
+
+// Equal reports whether s and other are the same state
+func (s State) Equal(other State) bool {
+	if s.Head != other.Head || s.Index != other.Index || s.Stash != other.Stash || len(s.Refs) != len(other.Refs) {
+		return false
+	}
+	for name, id := range s.Refs {
+		if other.Refs[name] != id {
+			return false
+		}
+	}
+	return true
+}
+
+// ChangedRefs returns the refs whose value differs between s and other,
+// including refs only one of them has
+func (s State) ChangedRefs(other State) []string {
+	var names []string
+	for name, id := range s.Refs {
+		if other.Refs[name] != id {
+			names = append(names, name)
+		}
+	}
+	for name := range other.Refs {
+		if _, ok := s.Refs[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	return names
+}
+
+// Trim returns before and after with only the refs that differ between
+// them, so the journal does not repeat every ref for every operation. A ref
+// one side lacks is recorded there as "".
+func Trim(before, after State) (State, State) {
+	names := before.ChangedRefs(after)
+	b, a := before, after
+	b.Refs, a.Refs = make(map[string]string, len(names)), make(map[string]string, len(names))
+	for _, name := range names {
+		b.Refs[name], a.Refs[name] = before.Refs[name], after.Refs[name]
+	}
+	return b, a
+}
+
+// Operation is a command that changed the repository
+type Operation struct {
+	ID      int       `json:"id"`
+	Time    time.Time `json:"time"`
+	Command string    `json:"command"`
+	Before  State     `json:"before"`
+	After   State     `json:"after"`
+	// UndoneBy is the operation that undid this one
+	UndoneBy int `json:"undone_by,omitempty"`
+	// Undoes lists the operations an undo undid
+	Undoes []int `json:"undoes,omitempty"`
+}
+
+// Read returns the operations journaled in dir, oldest first. A missing
+// journal has none.
+func Read(dir string) ([]*Operation, error) {
+	file, err := os.Open(filepath.Join(dir, File))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read operation log: %w", err)
+	}
+	defer file.Close()
+
+	var ops []*Operation
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), 64<<20)
+	for scanner.Scan() {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		op := &Operation{}
+		if err := json.Unmarshal(scanner.Bytes(), op); err != nil {
+			return nil, fmt.Errorf("failed to parse operation log: %w", err)
+		}
+		ops = append(ops, op)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read operation log: %w", err)
+	}
+	return ops, nil
+}
+
+// Append journals op in dir, numbering it after the last operation
+func Append(dir string, op *Operation) error {
+	ops, err := Read(dir)
+	if err != nil {
+		return err
+	}
+	op.ID = NextID(ops)
+	return Write(dir, append(ops, op))
+}
+
+// NextID returns the ID the next operation after ops gets
+func NextID(ops []*Operation) int {
+	if len(ops) == 0 {
+		return 1
+	}
+	return ops[len(ops)-1].ID + 1
+}
+
+// Write replaces the journal in dir with ops, keeping the last
+// MaxOperations. The journal is replaced atomically, so it is never torn.
+func Write(dir string, ops []*Operation) error {
+	if len(ops) > MaxOperations {
+		ops = ops[len(ops)-MaxOperations:]
+	}
+
+	tmp, err := os.CreateTemp(dir, File+".tmp-")
+	if err != nil {
+		return fmt.Errorf("failed to write operation log: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	w := bufio.NewWriter(tmp)
+	enc := json.NewEncoder(w)
+	for _, op := range ops {
+		if err = enc.Encode(op); err != nil {
+			break
+		}
+	}
+	if err == nil {
+		err = w.Flush()
+	}
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write operation log: %w", err)
+	}
+	return os.Rename(tmp.Name(), filepath.Join(dir, File))
+}
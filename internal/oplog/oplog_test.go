@@ -0,0 +1,89 @@
+package oplog
+
+import (
+	"reflect"
+	"sort"
+	"testing"
+	"time"
+)
+
+func TestAppendRead(t *testing.T) {
+	dir := t.TempDir()
+
+	ops, err := Read(dir)
+	if err != nil || len(ops) != 0 {
+		t.Fatalf("Read() of a missing journal = %v, %v", ops, err)
+	}
+
+	first := &Operation{Time: time.Unix(1700000000, 0).UTC(), Command: "commit -m one",
+		After: State{Head: "ref: refs/heads/main", Refs: map[string]string{"refs/heads/main": "a94a8fe5ccb19ba61c4c0873d391e987982fbbd3"}}}
+	if err := Append(dir, first); err != nil {
+		t.Fatal(err)
+	}
+	if err := Append(dir, &Operation{Command: "add a.txt"}); err != nil {
+		t.Fatal(err)
+	}
+
+	ops, err = Read(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ops) != 2 || ops[0].ID != 1 || ops[1].ID != 2 {
+		t.Fatalf("Read() = %+v, want operations 1 and 2", ops)
+	}
+	if !reflect.DeepEqual(ops[0], first) {
+		t.Errorf("Read() = %+v, want %+v", ops[0], first)
+	}
+	if got := NextID(ops); got != 3 {
+		t.Errorf("NextID() = %d, want 3", got)
+	}
+}
+
+func TestWriteKeepsLatest(t *testing.T) {
+	dir := t.TempDir()
+	var ops []*Operation
+	for i := 1; i <= MaxOperations+10; i++ {
+		ops = append(ops, &Operation{ID: i})
+	}
+	if err := Write(dir, ops); err != nil {
+		t.Fatal(err)
+	}
+
+	ops, err := Read(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ops) != MaxOperations || ops[0].ID != 11 {
+		t.Fatalf("kept %d operations from %d, want %d from 11", len(ops), ops[0].ID, MaxOperations)
+	}
+}
+
+func TestTrim(t *testing.T) {
+	before := State{Head: "ref: refs/heads/main", Refs: map[string]string{
+		"refs/heads/main":  "1111111111111111111111111111111111111111",
+		"refs/heads/gone":  "2222222222222222222222222222222222222222",
+		"refs/tags/stable": "3333333333333333333333333333333333333333",
+	}}
+	after := State{Head: "ref: refs/heads/main", Refs: map[string]string{
+		"refs/heads/main":  "4444444444444444444444444444444444444444",
+		"refs/heads/new":   "5555555555555555555555555555555555555555",
+		"refs/tags/stable": "3333333333333333333333333333333333333333",
+	}}
+	if before.Equal(after) {
+		t.Fatal("Equal() = true for different states")
+	}
+
+	b, a := Trim(before, after)
+	want := []string{"refs/heads/gone", "refs/heads/main", "refs/heads/new"}
+	got := b.ChangedRefs(a)
+	sort.Strings(got)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ChangedRefs() = %v, want %v", got, want)
+	}
+	if _, ok := b.Refs["refs/tags/stable"]; ok {
+		t.Error("Trim() kept an unchanged ref")
+	}
+	if b.Refs["refs/heads/new"] != "" || a.Refs["refs/heads/gone"] != "" {
+		t.Error("Trim() did not record a missing ref as empty")
+	}
+}
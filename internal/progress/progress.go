@@ -0,0 +1,207 @@
+// Package progress draws the progress of long operations on a terminal
+// line, as Git does: "Receiving objects:  45% (9/20), 1.20 MiB | 2.00 MiB/s"
+// redrawn in place and finished with ", done.", and passes on the progress
+// a remote sends over side-band next to it.
+package progress
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Interval is how often a meter is redrawn when only its count or
+// throughput changed. A new percentage is drawn at once.
+const Interval = time.Second
+
+// Meter shows how far an operation has got, as a percentage of its total
+// or, without a total, as a count. Bytes counted with AddBytes add the
+// amount transferred and the throughput. A nil Meter does nothing, so
+// callers need not check whether progress is shown. Meters are safe for
+// concurrent use.
+type Meter struct {
+	mu      sync.Mutex
+	out     io.Writer
+	title   string
+	total   int
+	done    int
+	bytes   int64
+	percent int
+	start   time.Time
+	drawn   time.Time
+	// width is the length of the line last drawn, which a shorter one
+	// has to cover
+	width int
+	now   func() time.Time
+}
+
+// New returns a meter of total steps writing to out, or of steps counted
+// without a total when total is zero. It returns nil, whose methods do
+// nothing, when out is nil.
+func New(out io.Writer, title string, total int) *Meter {
+	if out == nil {
+		return nil
+	}
+	m := &Meter{out: out, title: title, total: total, percent: -1, now: time.Now}
+	m.start = m.now()
+	m.draw(m.start, false)
+	return m
+}
+
+// Add records n more steps as done
+func (m *Meter) Add(n int) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.done += n
+	m.update()
+}
+
+// Set records that n steps in all are done
+func (m *Meter) Set(n int) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.done = n
+	m.update()
+}
+
+// AddBytes records n more bytes as transferred
+func (m *Meter) AddBytes(n int64) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.bytes += n
+	m.update()
+}
+
+// Done draws the meter a last time and ends its line
+func (m *Meter) Done() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.draw(m.now(), true)
+}
+
+// update redraws the meter when its percentage changed or it was last
+// drawn Interval ago
+func (m *Meter) update() {
+	now := m.now()
+	if (m.total > 0 && m.done*100/m.total != m.percent) || now.Sub(m.drawn) >= Interval {
+		m.draw(now, false)
+	}
+}
+
+// draw writes the meter's line as of now, ending it with done
+func (m *Meter) draw(now time.Time, done bool) {
+	line := fmt.Sprintf("%s: %d", m.title, m.done)
+	if m.total > 0 {
+		m.percent = m.done * 100 / m.total
+		line = fmt.Sprintf("%s: %3d%% (%d/%d)", m.title, m.percent, m.done, m.total)
+	}
+	if m.bytes > 0 {
+		line += ", " + FormatBytes(m.bytes)
+		if elapsed := now.Sub(m.start); elapsed > 0 {
+			line += " | " + FormatBytes(int64(float64(m.bytes)/elapsed.Seconds())) + "/s"
+		}
+	}
+	end := "\r"
+	if done {
+		line += ", done."
+		end = "\n"
+	}
+	padding := ""
+	if len(line) < m.width {
+		padding = strings.Repeat(" ", m.width-len(line))
+	}
+	m.width = len(line)
+	m.drawn = now
+	fmt.Fprint(m.out, line+padding+end)
+}
+
+// FormatBytes returns n as Git shows amounts transferred: "512 bytes",
+// "12.50 KiB", "1.20 MiB" or "2.00 GiB"
+func FormatBytes(n int64) string {
+	switch {
+	case n >= 1<<30:
+		return fmt.Sprintf("%.2f GiB", float64(n)/(1<<30))
+	case n >= 1<<20:
+		return fmt.Sprintf("%.2f MiB", float64(n)/(1<<20))
+	case n >= 1<<10:
+		return fmt.Sprintf("%.2f KiB", float64(n)/(1<<10))
+	}
+	return fmt.Sprintf("%d bytes", n)
+}
+
+// Reader counts the bytes read through it on a meter
+type Reader struct {
+	R     io.Reader
+	Meter *Meter
+}
+
+func (r *Reader) Read(p []byte) (int, error) {
+	n, err := r.R.Read(p)
+	r.Meter.AddBytes(int64(n))
+	return n, err
+}
+
+// Writer counts the bytes written through it on a meter
+type Writer struct {
+	W     io.Writer
+	Meter *Meter
+}
+
+func (w *Writer) Write(p []byte) (int, error) {
+	n, err := w.W.Write(p)
+	w.Meter.AddBytes(int64(n))
+	return n, err
+}
+
+// clearLine is the escape sequence erasing the rest of a terminal line
+const clearLine = "\033[K"
+
+// remoteWriter writes the progress messages of a remote, each line
+// prefixed with "remote: "
+type remoteWriter struct {
+	w     io.Writer
+	clear bool
+	start bool
+}
+
+// NewRemoteWriter returns a writer for the side-band progress channel of a
+// remote, which writes each line, ended by "\n" or by the "\r" its meters
+// are redrawn with, to w prefixed with "remote: ". On a terminal, as
+// terminal says w is, the rest of each line is erased, so that a longer
+// line drawn before it does not show through.
+func NewRemoteWriter(w io.Writer, terminal bool) io.Writer {
+	return &remoteWriter{w: w, clear: terminal, start: true}
+}
+
+func (r *remoteWriter) Write(data []byte) (int, error) {
+	var buf []byte
+	for _, c := range data {
+		if r.start {
+			buf = append(buf, "remote: "...)
+			r.start = false
+		}
+		if (c == '\n' || c == '\r') && r.clear {
+			buf = append(buf, clearLine...)
+		}
+		buf = append(buf, c)
+		r.start = c == '\n' || c == '\r'
+	}
+	if _, err := r.w.Write(buf); err != nil {
+		return 0, err
+	}
+	return len(data), nil
+}
@@ -0,0 +1,107 @@
+package progress
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// clock returns a meter's clock, which moves on by step each time it is
+// read
+func clock(step time.Duration) func() time.Time {
+	now := time.Unix(0, 0)
+	return func() time.Time {
+		now = now.Add(step)
+		return now
+	}
+}
+
+func TestMeter(t *testing.T) {
+	var out bytes.Buffer
+	meter := New(&out, "Updating files", 3)
+	meter.Add(1)
+	meter.Add(2)
+	meter.Done()
+	assert.Equal(t, "Updating files:   0% (0/3)\rUpdating files:  33% (1/3)\r"+
+		"Updating files: 100% (3/3)\rUpdating files: 100% (3/3), done.\n", out.String())
+
+	// Without output the meter does nothing
+	assert.Nil(t, New(nil, "Updating files", 3))
+	var none *Meter
+	none.Add(1)
+	none.AddBytes(1)
+	none.Done()
+}
+
+func TestMeterRateLimit(t *testing.T) {
+	var out bytes.Buffer
+	meter := &Meter{out: &out, title: "Receiving objects", total: 1000, percent: -1, now: clock(100 * time.Millisecond)}
+	meter.start = meter.now()
+	meter.draw(meter.start, false)
+
+	// Steps within a percent are not drawn until Interval has passed
+	for i := 0; i < 9; i++ {
+		meter.Add(1)
+	}
+	assert.Equal(t, 1, strings.Count(out.String(), "\r"))
+	meter.Add(1)
+	assert.Equal(t, 2, strings.Count(out.String(), "\r"))
+	assert.True(t, strings.HasSuffix(out.String(), "Receiving objects:   1% (10/1000)\r"))
+
+	// Without a total only time redraws the count
+	out.Reset()
+	counter := &Meter{out: &out, title: "Counting objects", now: clock(300 * time.Millisecond)}
+	counter.start = counter.now()
+	counter.draw(counter.start, false)
+	for i := 0; i < 4; i++ {
+		counter.Add(1)
+	}
+	counter.Done()
+	assert.Equal(t, "Counting objects: 0\rCounting objects: 4\rCounting objects: 4, done.\n", out.String())
+}
+
+func TestMeterThroughput(t *testing.T) {
+	var out bytes.Buffer
+	meter := &Meter{out: &out, title: "Writing objects", total: 2, percent: -1, now: clock(time.Second)}
+	meter.start = meter.now()
+	w := &Writer{W: io.Discard, Meter: meter}
+	w.Write(make([]byte, 3<<20))
+	meter.Add(2)
+	meter.Done()
+	assert.Equal(t, "Writing objects:   0% (0/2), 3.00 MiB | 3.00 MiB/s\r"+
+		"Writing objects: 100% (2/2), 3.00 MiB | 1.50 MiB/s\r"+
+		"Writing objects: 100% (2/2), 3.00 MiB | 1.00 MiB/s, done.\n", out.String())
+
+	// A shorter line covers the one before it
+	out.Reset()
+	meter = &Meter{out: &out, title: "Receiving", now: clock(time.Second)}
+	meter.start = meter.now()
+	meter.AddBytes(2048)
+	meter.bytes = 0
+	meter.Done()
+	assert.Equal(t, "Receiving: 0, 2.00 KiB | 2.00 KiB/s\rReceiving: 0, done.                \n", out.String())
+}
+
+func TestFormatBytes(t *testing.T) {
+	assert.Equal(t, "512 bytes", FormatBytes(512))
+	assert.Equal(t, "12.50 KiB", FormatBytes(12800))
+	assert.Equal(t, "1.20 MiB", FormatBytes(1258292))
+	assert.Equal(t, "2.00 GiB", FormatBytes(2<<30))
+}
+
+func TestRemoteWriter(t *testing.T) {
+	var out bytes.Buffer
+	w := NewRemoteWriter(&out, false)
+	io.WriteString(w, "Counting: 50%\rCounting: 100%")
+	io.WriteString(w, ", done.\nTotal 3\n")
+	assert.Equal(t, "remote: Counting: 50%\rremote: Counting: 100%, done.\nremote: Total 3\n", out.String())
+
+	// On a terminal the rest of each line is erased
+	out.Reset()
+	io.WriteString(NewRemoteWriter(&out, true), "Counting: 50%\rdone\n")
+	assert.Equal(t, "remote: Counting: 50%\033[K\rremote: done\033[K\n", out.String())
+}
@@ -0,0 +1,382 @@
+// Package rerere reuses recorded resolutions of conflicted merges. When a
+// merge leaves conflict markers in a file, the conflict is recorded in the
+// rr-cache directory of the repository; once it is resolved the resolution
+// is recorded next to it, and the next time the same conflict shows up it
+// is resolved the same way.
+package rerere
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/fenilsonani/vcs/internal/core/merge"
+)
+
+const (
+	// CacheDir is the directory in the repository directory holding a
+	// directory per recorded conflict, named by its ID
+	CacheDir = "rr-cache"
+	// MergeRR lists the conflicts of the merge in progress, one
+	// "<id>\t<path>" line each
+	MergeRR = "MERGE_RR"
+)
+
+// Files of a recorded conflict: the conflicted content and its resolution
+const (
+	preimage  = "preimage"
+	postimage = "postimage"
+)
+
+// Conflict markers, matched at the start of a line
+const (
+	markerOurs   = "<<<<<<<"
+	markerBase   = "|||||||"
+	markerSep    = "======="
+	markerTheirs = ">>>>>>>"
+)
+
+// Normalize returns data with its conflicts written in a canonical form:
+// markers without labels, no base section, and the two sides of each in
+// sorted order, so the same conflict looks the same whichever side was
+// merged into which. id identifies the conflicts. ok is false when data has
+// no well-formed conflicts.
+func Normalize(data []byte) (normalized []byte, id string, ok bool) {
+	const (
+		outside = iota
+		inOurs
+		inBase
+		inTheirs
+	)
+
+	var out bytes.Buffer
+	hash := sha1.New()
+	state := outside
+	var ours, theirs bytes.Buffer
+	conflicts := 0
+
+	for _, line := range splitLines(data) {
+		switch {
+		case isMarker(line, markerOurs):
+			if state != outside {
+				return nil, "", false
+			}
+			state = inOurs
+			ours.Reset()
+			theirs.Reset()
+		case isMarker(line, markerBase) && state == inOurs:
+			state = inBase
+		case isMarker(line, markerSep) && (state == inOurs || state == inBase):
+			state = inTheirs
+		case isMarker(line, markerTheirs) && state == inTheirs:
+			a, b := ours.String(), theirs.String()
+			if a > b {
+				a, b = b, a
+			}
+			hash.Write([]byte(a + "\x00" + b + "\x00"))
+			out.WriteString(markerOurs + "\n" + a + markerSep + "\n" + b + markerTheirs + "\n")
+			conflicts++
+			state = outside
+		case state == inOurs:
+			ours.WriteString(line)
+		case state == inTheirs:
+			theirs.WriteString(line)
+		case state == outside:
+			out.WriteString(line)
+		}
+	}
+
+	if state != outside || conflicts == 0 {
+		return nil, "", false
+	}
+	return out.Bytes(), hex.EncodeToString(hash.Sum(nil)), true
+}
+
+// HasConflicts reports whether data has conflict markers left
+func HasConflicts(data []byte) bool {
+	_, _, ok := Normalize(data)
+	return ok
+}
+
+// Entry is a conflicted file of the merge in progress
+type Entry struct {
+	ID   string
+	Path string
+}
+
+// Cache is the rerere state of a repository
+type Cache struct {
+	gitDir string
+}
+
+// New returns the cache of the repository at gitDir
+func New(gitDir string) *Cache {
+	return &Cache{gitDir: gitDir}
+}
+
+// Conflicts returns the conflicts of the merge in progress, sorted by path
+func (c *Cache) Conflicts() ([]Entry, error) {
+	file, err := os.Open(filepath.Join(c.gitDir, MergeRR))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", MergeRR, err)
+	}
+	defer file.Close()
+
+	var entries []Entry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		id, path, ok := strings.Cut(scanner.Text(), "\t")
+		if !ok {
+			continue
+		}
+		entries = append(entries, Entry{ID: id, Path: path})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read %s: %w", MergeRR, err)
+	}
+	return entries, nil
+}
+
+func (c *Cache) writeConflicts(entries []Entry) error {
+	path := filepath.Join(c.gitDir, MergeRR)
+	if len(entries) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", MergeRR, err)
+		}
+		return nil
+	}
+
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Path < entries[j].Path })
+	var data strings.Builder
+	for _, e := range entries {
+		data.WriteString(e.ID + "\t" + e.Path + "\n")
+	}
+	if err := os.WriteFile(path, []byte(data.String()), 0644); err != nil {
+		return fmt.Errorf("failed to write %s: %w", MergeRR, err)
+	}
+	return nil
+}
+
+// Record notes the conflicts a merge left in the file at path, whose
+// content is data. When a resolution of the same conflicts was recorded
+// before and still applies, it is returned with replayed set. It reports
+// recorded false when data has no conflicts to record.
+func (c *Cache) Record(path string, data []byte) (resolved []byte, recorded, replayed bool, err error) {
+	normalized, id, ok := Normalize(data)
+	if !ok {
+		return nil, false, false, nil
+	}
+
+	dir := filepath.Join(c.gitDir, CacheDir, id)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, false, false, fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+
+	// The recorded resolution is applied as a change from the recorded
+	// conflict, so it survives edits around the conflict
+	oldPre, preErr := os.ReadFile(filepath.Join(dir, preimage))
+	post, postErr := os.ReadFile(filepath.Join(dir, postimage))
+	if preErr == nil && postErr == nil {
+		if merged, clean := merge.MergeContent(oldPre, normalized, post, merge.Options{}); clean {
+			resolved, replayed = merged, true
+		}
+	}
+
+	if err := os.WriteFile(filepath.Join(dir, preimage), normalized, 0644); err != nil {
+		return nil, false, false, fmt.Errorf("failed to record conflict: %w", err)
+	}
+	switch {
+	case replayed:
+		err = os.WriteFile(filepath.Join(dir, postimage), resolved, 0644)
+	case postErr == nil:
+		// The old resolution no longer matches; the next one replaces it
+		err = os.Remove(filepath.Join(dir, postimage))
+	}
+	if err != nil {
+		return nil, false, false, fmt.Errorf("failed to record resolution: %w", err)
+	}
+
+	entries, err := c.Conflicts()
+	if err != nil {
+		return nil, false, false, err
+	}
+	kept := entries[:0]
+	for _, e := range entries {
+		if e.Path != path {
+			kept = append(kept, e)
+		}
+	}
+	if err := c.writeConflicts(append(kept, Entry{ID: id, Path: path})); err != nil {
+		return nil, false, false, err
+	}
+	return resolved, true, replayed, nil
+}
+
+// RecordResolutions records the resolution of each conflict of the merge
+// in progress whose file no longer has conflict markers. read returns the
+// content of a file; a file that does not exist was resolved by removing
+// it, which is not recorded. It returns the paths whose resolution was
+// newly recorded.
+func (c *Cache) RecordResolutions(read func(path string) ([]byte, error)) ([]string, error) {
+	entries, err := c.Conflicts()
+	if err != nil {
+		return nil, err
+	}
+
+	var remaining []Entry
+	var recorded []string
+	for _, e := range entries {
+		data, err := read(e.Path)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if HasConflicts(data) {
+			remaining = append(remaining, e)
+			continue
+		}
+
+		post := filepath.Join(c.gitDir, CacheDir, e.ID, postimage)
+		if old, err := os.ReadFile(post); err == nil && bytes.Equal(old, data) {
+			continue
+		}
+		if err := os.MkdirAll(filepath.Dir(post), 0755); err != nil {
+			return nil, fmt.Errorf("failed to record resolution of %s: %w", e.Path, err)
+		}
+		if err := os.WriteFile(post, data, 0644); err != nil {
+			return nil, fmt.Errorf("failed to record resolution of %s: %w", e.Path, err)
+		}
+		recorded = append(recorded, e.Path)
+	}
+
+	if err := c.writeConflicts(remaining); err != nil {
+		return nil, err
+	}
+	return recorded, nil
+}
+
+// Forget drops the recorded resolution of the conflicts in the file at
+// path, found from its conflict markers in data or, once resolved, from
+// the merge in progress
+func (c *Cache) Forget(path string, data []byte) error {
+	_, id, ok := Normalize(data)
+	if !ok {
+		entries, err := c.Conflicts()
+		if err != nil {
+			return err
+		}
+		for _, e := range entries {
+			if e.Path == path {
+				id, ok = e.ID, true
+			}
+		}
+	}
+	if !ok {
+		return fmt.Errorf("no conflict recorded for %s", path)
+	}
+
+	err := os.Remove(filepath.Join(c.gitDir, CacheDir, id, postimage))
+	if os.IsNotExist(err) {
+		return fmt.Errorf("no resolution recorded for %s", path)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to forget resolution of %s: %w", path, err)
+	}
+	return nil
+}
+
+// Clear abandons the conflicts of the merge in progress, dropping those
+// that were never resolved
+func (c *Cache) Clear() error {
+	entries, err := c.Conflicts()
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		dir := filepath.Join(c.gitDir, CacheDir, e.ID)
+		if _, err := os.Stat(filepath.Join(dir, postimage)); os.IsNotExist(err) {
+			if err := os.RemoveAll(dir); err != nil {
+				return fmt.Errorf("failed to remove %s: %w", dir, err)
+			}
+		}
+	}
+	return c.writeConflicts(nil)
+}
+
+// GC removes resolutions last used before now less resolvedAge, and
+// conflicts never resolved recorded before now less unresolvedAge. It
+// returns how many records were removed.
+func (c *Cache) GC(now time.Time, resolvedAge, unresolvedAge time.Duration) (int, error) {
+	root := filepath.Join(c.gitDir, CacheDir)
+	dirs, err := os.ReadDir(root)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, fmt.Errorf("failed to read %s: %w", CacheDir, err)
+	}
+
+	removed := 0
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		dir := filepath.Join(root, d.Name())
+
+		name, age := postimage, resolvedAge
+		info, err := os.Stat(filepath.Join(dir, postimage))
+		if os.IsNotExist(err) {
+			name, age = preimage, unresolvedAge
+			info, err = os.Stat(filepath.Join(dir, preimage))
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return removed, fmt.Errorf("failed to read %s: %w", filepath.Join(dir, name), err)
+		}
+		if err == nil && now.Sub(info.ModTime()) <= age {
+			continue
+		}
+
+		if err := os.RemoveAll(dir); err != nil {
+			return removed, fmt.Errorf("failed to remove %s: %w", dir, err)
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// isMarker reports whether line is the given conflict marker, alone or
+// followed by a label
+func isMarker(line, marker string) bool {
+	if !strings.HasPrefix(line, marker) {
+		return false
+	}
+	rest := strings.TrimRight(line[len(marker):], "\r\n")
+	return rest == "" || rest[0] == ' '
+}
+
+// splitLines splits data after each newline, keeping the terminators
+func splitLines(data []byte) []string {
+	var lines []string
+	for len(data) > 0 {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			lines = append(lines, string(data))
+			break
+		}
+		lines = append(lines, string(data[:i+1]))
+		data = data[i+1:]
+	}
+	return lines
+}
@@ -0,0 +1,118 @@
+package rerere
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const conflicted = `one
+<<<<<<< HEAD
+ours
+||||||| base
+base
+=======
+theirs
+>>>>>>> topic
+three
+`
+
+func TestNormalize(t *testing.T) {
+	normalized, id, ok := Normalize([]byte(conflicted))
+	if !ok {
+		t.Fatalf("Normalize() found no conflicts")
+	}
+	if want := "one\n<<<<<<<\nours\n=======\ntheirs\n>>>>>>>\nthree\n"; string(normalized) != want {
+		t.Errorf("Normalize() = %q, want %q", normalized, want)
+	}
+
+	// The same conflict merged the other way round
+	swapped := "one\n<<<<<<< HEAD\ntheirs\n=======\nours\n>>>>>>> main\nthree\n"
+	if _, other, _ := Normalize([]byte(swapped)); other != id {
+		t.Errorf("Normalize() of swapped sides id = %s, want %s", other, id)
+	}
+
+	for _, data := range []string{"no conflicts\n", "<<<<<<< HEAD\nunterminated\n", "======= not after a start\n"} {
+		if _, _, ok := Normalize([]byte(data)); ok {
+			t.Errorf("Normalize(%q) found conflicts", data)
+		}
+	}
+}
+
+func TestRecordAndReplay(t *testing.T) {
+	gitDir := t.TempDir()
+	cache := New(gitDir)
+	files := map[string]string{"a.txt": conflicted}
+	read := func(path string) ([]byte, error) {
+		data, ok := files[path]
+		if !ok {
+			return nil, os.ErrNotExist
+		}
+		return []byte(data), nil
+	}
+
+	_, recorded, replayed, err := cache.Record("a.txt", []byte(conflicted))
+	if err != nil || !recorded || replayed {
+		t.Fatalf("Record() = %v, %v, %v; want a recorded conflict", recorded, replayed, err)
+	}
+	entries, err := cache.Conflicts()
+	if err != nil || len(entries) != 1 || entries[0].Path != "a.txt" {
+		t.Fatalf("Conflicts() = %v, %v; want a.txt", entries, err)
+	}
+
+	// Unresolved conflicts are not recorded
+	if paths, err := cache.RecordResolutions(read); err != nil || len(paths) != 0 {
+		t.Errorf("RecordResolutions() = %v, %v; want nothing yet", paths, err)
+	}
+
+	files["a.txt"] = "one\nresolved\nthree\n"
+	paths, err := cache.RecordResolutions(read)
+	if err != nil || len(paths) != 1 || paths[0] != "a.txt" {
+		t.Fatalf("RecordResolutions() = %v, %v; want a.txt", paths, err)
+	}
+	if _, err := os.Stat(filepath.Join(gitDir, MergeRR)); !os.IsNotExist(err) {
+		t.Errorf("%s left behind once every conflict is resolved", MergeRR)
+	}
+
+	// The same conflict with other lines around it comes out resolved
+	again := "zero\n" + conflicted
+	resolved, _, replayed, err := cache.Record("b.txt", []byte(again))
+	if err != nil || !replayed {
+		t.Fatalf("Record() replayed = %v, %v; want the recorded resolution", replayed, err)
+	}
+	if want := "zero\none\nresolved\nthree\n"; string(resolved) != want {
+		t.Errorf("Record() = %q, want %q", resolved, want)
+	}
+
+	if err := cache.Forget("b.txt", []byte(again)); err != nil {
+		t.Fatalf("Forget() error = %v", err)
+	}
+	if _, _, replayed, _ := cache.Record("b.txt", []byte(again)); replayed {
+		t.Errorf("Record() replayed a forgotten resolution")
+	}
+}
+
+func TestClearAndGC(t *testing.T) {
+	gitDir := t.TempDir()
+	cache := New(gitDir)
+	if _, _, _, err := cache.Record("a.txt", []byte(conflicted)); err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+	if err := cache.Clear(); err != nil {
+		t.Fatalf("Clear() error = %v", err)
+	}
+	if dirs, _ := os.ReadDir(filepath.Join(gitDir, CacheDir)); len(dirs) != 0 {
+		t.Errorf("Clear() kept %d unresolved conflicts", len(dirs))
+	}
+
+	if _, _, _, err := cache.Record("a.txt", []byte(conflicted)); err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+	if n, err := cache.GC(time.Now(), 60*24*time.Hour, 15*24*time.Hour); err != nil || n != 0 {
+		t.Errorf("GC() = %d, %v; want a fresh conflict kept", n, err)
+	}
+	if n, err := cache.GC(time.Now().Add(16*24*time.Hour), 60*24*time.Hour, 15*24*time.Hour); err != nil || n != 1 {
+		t.Errorf("GC() = %d, %v; want an old unresolved conflict removed", n, err)
+	}
+}
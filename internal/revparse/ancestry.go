@@ -0,0 +1,479 @@
+package revparse
+
+import (
+	"container/heap"
+	"path/filepath"
+	"sort"
+
+	"github.com/fenilsonani/vcs/internal/core/commitgraph"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+// node is what ancestry queries need of a commit, taken from the
+// commit-graph when it has the commit and read from the object otherwise
+type node struct {
+	id         objects.ObjectID
+	parents    []objects.ObjectID
+	time       int64
+	generation uint32
+}
+
+// commitGraph returns the commit-graph, or nil when there is none or it
+// cannot be used. History cut short by a shallow clone or rewritten by
+// replacement refs does not match the graph, so those repositories do
+// without it, as in Git.
+func (r *Resolver) commitGraph() *commitgraph.Graph {
+	if r.graphLoaded {
+		return r.graph
+	}
+	r.graphLoaded = true
+	if shallow, err := r.shallowCommits(); err != nil || len(shallow) > 0 {
+		return nil
+	}
+	if replaced, err := r.refs.ReplaceRefs(); err != nil || len(replaced) > 0 {
+		return nil
+	}
+	// A damaged graph only costs speed
+	r.graph, _ = commitgraph.Load(filepath.Join(r.refs.CommonDir(), "objects"))
+	return r.graph
+}
+
+// node returns the parents, time and generation of commit id
+func (r *Resolver) node(id objects.ObjectID) (*node, error) {
+	if graph := r.commitGraph(); graph != nil {
+		if c, ok := graph.Lookup(id); ok {
+			return &node{id: id, parents: c.Parents, time: c.Time, generation: c.Generation}, nil
+		}
+	}
+	commit, err := r.readCommit(id)
+	if err != nil {
+		return nil, err
+	}
+	return &node{
+		id:         id,
+		parents:    commit.Parents(),
+		time:       commit.Committer().When.Unix(),
+		generation: commitgraph.GenerationInfinity,
+	}, nil
+}
+
+// Reachable returns every commit reachable from starts, stopping at
+// shallow boundaries
+func (r *Resolver) Reachable(starts []objects.ObjectID) (map[objects.ObjectID]bool, error) {
+	shallow, err := r.shallowCommits()
+	if err != nil {
+		return nil, err
+	}
+
+	seen := make(map[objects.ObjectID]bool)
+	stack := append([]objects.ObjectID(nil), starts...)
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		if shallow[id] {
+			continue
+		}
+		n, err := r.node(id)
+		if err != nil {
+			return nil, err
+		}
+		stack = append(stack, n.parents...)
+	}
+	return seen, nil
+}
+
+// MergeBases returns the best common ancestors of a and b: those that are
+// not ancestors of another common ancestor
+func (r *Resolver) MergeBases(a, b objects.ObjectID) ([]objects.ObjectID, error) {
+	return r.MergeBasesOf([]objects.ObjectID{a}, b)
+}
+
+// Flags painted on commits while looking for merge bases
+const (
+	paintA = 1 << iota
+	paintB
+	paintStale
+	paintResult
+)
+
+// painter paints flags on commits walking down from some of them, highest
+// generation first, so that a commit is visited after its descendants
+type painter struct {
+	r     *Resolver
+	nodes map[objects.ObjectID]*node
+	flags map[objects.ObjectID]int
+	queue nodeQueue
+}
+
+func (r *Resolver) newPainter() *painter {
+	return &painter{r: r, nodes: make(map[objects.ObjectID]*node), flags: make(map[objects.ObjectID]int)}
+}
+
+// paint adds f to the flags of id, queueing it to pass them on to its
+// parents unless it already had them
+func (p *painter) paint(id objects.ObjectID, f int) error {
+	if p.flags[id]&f == f {
+		return nil
+	}
+	p.flags[id] |= f
+	n, ok := p.nodes[id]
+	if !ok {
+		var err error
+		if n, err = p.r.node(id); err != nil {
+			return err
+		}
+		p.nodes[id] = n
+	}
+	heap.Push(&p.queue, n)
+	return nil
+}
+
+// MergeBasesOf is MergeBases for the history of several commits a, as when
+// merging into a merge of them that was never committed.
+//
+// The commits reachable from a and from b are painted walking down from
+// both, highest generation first, until every commit left to visit is
+// below a common ancestor already found; with a commit-graph only the
+// history above the merge bases is read.
+func (r *Resolver) MergeBasesOf(a []objects.ObjectID, b objects.ObjectID) ([]objects.ObjectID, error) {
+	shallow, err := r.shallowCommits()
+	if err != nil {
+		return nil, err
+	}
+
+	p := r.newPainter()
+	for _, id := range a {
+		if err := p.paint(id, paintA); err != nil {
+			return nil, err
+		}
+	}
+	if err := p.paint(b, paintB); err != nil {
+		return nil, err
+	}
+
+	var found []*node
+	for p.queue.live(p.flags) {
+		n := heap.Pop(&p.queue).(*node)
+		f := p.flags[n.id] & (paintA | paintB | paintStale)
+		if f == paintA|paintB {
+			if p.flags[n.id]&paintResult == 0 {
+				p.flags[n.id] |= paintResult
+				found = append(found, n)
+			}
+			// Whatever lies below a common ancestor is not a best one
+			f |= paintStale
+		}
+		if shallow[n.id] {
+			continue
+		}
+		for _, parent := range n.parents {
+			if err := p.paint(parent, f); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	found, err = r.removeRedundant(found, shallow)
+	if err != nil {
+		return nil, err
+	}
+	return nodeIDs(found), nil
+}
+
+// nodeIDs sorts nodes newest first and returns their IDs
+func nodeIDs(nodes []*node) []objects.ObjectID {
+	sort.SliceStable(nodes, func(i, j int) bool { return nodes[i].time > nodes[j].time })
+	ids := make([]objects.ObjectID, len(nodes))
+	for i, n := range nodes {
+		ids[i] = n.id
+	}
+	return ids
+}
+
+// OctopusMergeBases returns the best common ancestors of all of commits,
+// the base of merging them at once: the merge bases of the first two, then
+// those of each of them with the third, and so on
+func (r *Resolver) OctopusMergeBases(commits []objects.ObjectID) ([]objects.ObjectID, error) {
+	if len(commits) == 0 {
+		return nil, nil
+	}
+	shallow, err := r.shallowCommits()
+	if err != nil {
+		return nil, err
+	}
+
+	bases := commits[:1]
+	for _, next := range commits[1:] {
+		seen := make(map[objects.ObjectID]bool)
+		var found []*node
+		for _, base := range bases {
+			ids, err := r.MergeBases(base, next)
+			if err != nil {
+				return nil, err
+			}
+			for _, id := range ids {
+				if seen[id] {
+					continue
+				}
+				seen[id] = true
+				n, err := r.node(id)
+				if err != nil {
+					return nil, err
+				}
+				found = append(found, n)
+			}
+		}
+		if found, err = r.removeRedundant(found, shallow); err != nil {
+			return nil, err
+		}
+		bases = nodeIDs(found)
+	}
+	return bases, nil
+}
+
+// IsAncestor reports whether a is b or one of its ancestors. No commit
+// reaches one of a higher generation, so the walk down from b skips the
+// commits at or below the generation of a and with a commit-graph reads
+// only the history between the two.
+func (r *Resolver) IsAncestor(a, b objects.ObjectID) (bool, error) {
+	if a == b {
+		return true, nil
+	}
+	shallow, err := r.shallowCommits()
+	if err != nil {
+		return false, err
+	}
+	target, err := r.node(a)
+	if err != nil {
+		return false, err
+	}
+
+	seen := make(map[objects.ObjectID]bool)
+	stack := []objects.ObjectID{b}
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		if id == a {
+			return true, nil
+		}
+		n, err := r.node(id)
+		if err != nil {
+			return false, err
+		}
+		if n.generation < target.generation || n.generation == target.generation && n.generation != commitgraph.GenerationInfinity {
+			continue
+		}
+		if !shallow[id] {
+			stack = append(stack, n.parents...)
+		}
+	}
+	return false, nil
+}
+
+// RangeCommits returns the commits of rng, those reachable from Include
+// but not from Exclude, newest first.
+//
+// Both sides are painted walking down, highest generation first, until
+// every commit left to visit is reachable from Exclude, so that with a
+// commit-graph only the history above the merge bases is read. Commits
+// outside the graph are ordered by date, which clock skew can get wrong,
+// so the walk goes on while any of them is left to visit.
+func (r *Resolver) RangeCommits(rng *Range) ([]objects.ObjectID, error) {
+	shallow, err := r.shallowCommits()
+	if err != nil {
+		return nil, err
+	}
+
+	p := r.newPainter()
+	for _, id := range rng.Include {
+		if err := p.paint(id, paintA); err != nil {
+			return nil, err
+		}
+	}
+	for _, id := range rng.Exclude {
+		if err := p.paint(id, paintStale); err != nil {
+			return nil, err
+		}
+	}
+
+	var found []*node
+	for p.queue.Len() > 0 && (p.queue[0].generation == commitgraph.GenerationInfinity || p.queue.live(p.flags)) {
+		n := heap.Pop(&p.queue).(*node)
+		f := p.flags[n.id] & (paintA | paintStale)
+		if f == paintA && p.flags[n.id]&paintResult == 0 {
+			p.flags[n.id] |= paintResult
+			found = append(found, n)
+		}
+		if shallow[n.id] {
+			continue
+		}
+		for _, parent := range n.parents {
+			if err := p.paint(parent, f); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// A commit found before the walk from Exclude reached it is not in
+	// the range
+	var ids []objects.ObjectID
+	for _, n := range found {
+		if p.flags[n.id]&paintStale == 0 {
+			ids = append(ids, n.id)
+		}
+	}
+	return ids, nil
+}
+
+// AheadBehind counts the commits reachable from a but not from b, and
+// those reachable from b but not from a, as status shows a branch against
+// its upstream.
+//
+// Both are painted in one walk that stops, as RangeCommits does, once
+// every commit left to visit is reachable from both.
+func (r *Resolver) AheadBehind(a, b objects.ObjectID) (ahead, behind int, err error) {
+	if a == b {
+		return 0, 0, nil
+	}
+	shallow, err := r.shallowCommits()
+	if err != nil {
+		return 0, 0, err
+	}
+
+	p := r.newPainter()
+	if err := p.paint(a, paintA); err != nil {
+		return 0, 0, err
+	}
+	if err := p.paint(b, paintB); err != nil {
+		return 0, 0, err
+	}
+
+	var visited []*node
+	for p.queue.Len() > 0 && (p.queue[0].generation == commitgraph.GenerationInfinity || p.queue.live(p.flags)) {
+		n := heap.Pop(&p.queue).(*node)
+		f := p.flags[n.id] & (paintA | paintB | paintStale)
+		if f&(paintA|paintB) == paintA|paintB {
+			f |= paintStale
+			p.flags[n.id] |= paintStale
+		}
+		if p.flags[n.id]&paintResult == 0 {
+			p.flags[n.id] |= paintResult
+			visited = append(visited, n)
+		}
+		if shallow[n.id] {
+			continue
+		}
+		for _, parent := range n.parents {
+			if err := p.paint(parent, f); err != nil {
+				return 0, 0, err
+			}
+		}
+	}
+
+	// A commit visited before the walk from the other side reached it is
+	// counted by its final flags
+	for _, n := range visited {
+		switch p.flags[n.id] & (paintA | paintB) {
+		case paintA:
+			ahead++
+		case paintB:
+			behind++
+		}
+	}
+	return ahead, behind, nil
+}
+
+// removeRedundant drops the candidates reachable from another one. No
+// commit reaches one of a higher generation, so each walk stops below the
+// lowest generation among the candidates.
+func (r *Resolver) removeRedundant(candidates []*node, shallow map[objects.ObjectID]bool) ([]*node, error) {
+	if len(candidates) < 2 {
+		return candidates, nil
+	}
+	minGeneration := uint32(commitgraph.GenerationInfinity)
+	index := make(map[objects.ObjectID]int)
+	for i, n := range candidates {
+		index[n.id] = i
+		if n.generation < minGeneration {
+			minGeneration = n.generation
+		}
+	}
+
+	redundant := make([]bool, len(candidates))
+	for i, candidate := range candidates {
+		if redundant[i] {
+			continue
+		}
+		seen := make(map[objects.ObjectID]bool)
+		stack := append([]objects.ObjectID(nil), candidate.parents...)
+		if shallow[candidate.id] {
+			stack = nil
+		}
+		for len(stack) > 0 {
+			id := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if seen[id] {
+				continue
+			}
+			seen[id] = true
+			if j, ok := index[id]; ok {
+				redundant[j] = true
+			}
+			n, err := r.node(id)
+			if err != nil {
+				return nil, err
+			}
+			if n.generation < minGeneration || shallow[id] {
+				continue
+			}
+			stack = append(stack, n.parents...)
+		}
+	}
+
+	var kept []*node
+	for i, n := range candidates {
+		if !redundant[i] {
+			kept = append(kept, n)
+		}
+	}
+	return kept, nil
+}
+
+// nodeQueue orders commits highest generation first, then newest
+// committer date first
+type nodeQueue []*node
+
+func (q nodeQueue) Len() int { return len(q) }
+func (q nodeQueue) Less(i, j int) bool {
+	if q[i].generation != q[j].generation {
+		return q[i].generation > q[j].generation
+	}
+	return q[i].time > q[j].time
+}
+func (q nodeQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *nodeQueue) Push(x any)   { *q = append(*q, x.(*node)) }
+func (q *nodeQueue) Pop() any {
+	old := *q
+	n := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return n
+}
+
+// live reports whether any queued commit is not yet known to lie below a
+// common ancestor
+func (q nodeQueue) live(flags map[objects.ObjectID]int) bool {
+	for _, n := range q {
+		if flags[n.id]&paintStale == 0 {
+			return true
+		}
+	}
+	return false
+}
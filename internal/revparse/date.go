@@ -0,0 +1,65 @@
+package revparse
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// relativeDate matches approxidate-style values such as "2.weeks.ago"
+var relativeDate = regexp.MustCompile(`^(\d+)[. ]+(second|minute|hour|day|week|month|year)s?[. ]+ago$`)
+
+// ParseDate parses the approximate dates Git accepts in <ref>@{<date>}:
+// "now", "yesterday", weekdays such as "last tuesday", relative dates such
+// as "2.weeks.ago" and absolute dates such as "2024-01-01"
+func ParseDate(value string, now time.Time) (time.Time, error) {
+	value = strings.ToLower(strings.TrimSpace(value))
+	switch value {
+	case "now":
+		return now, nil
+	case "yesterday":
+		return now.AddDate(0, 0, -1), nil
+	}
+
+	// A weekday means its most recent occurrence before today
+	day := strings.TrimPrefix(value, "last ")
+	for weekday := time.Sunday; weekday <= time.Saturday; weekday++ {
+		if day == strings.ToLower(weekday.String()) {
+			back := (int(now.Weekday()) - int(weekday) + 7) % 7
+			if back == 0 {
+				back = 7
+			}
+			return now.AddDate(0, 0, -back), nil
+		}
+	}
+
+	if m := relativeDate.FindStringSubmatch(value); m != nil {
+		n, _ := strconv.Atoi(m[1])
+		switch m[2] {
+		case "second":
+			return now.Add(-time.Duration(n) * time.Second), nil
+		case "minute":
+			return now.Add(-time.Duration(n) * time.Minute), nil
+		case "hour":
+			return now.Add(-time.Duration(n) * time.Hour), nil
+		case "day":
+			return now.AddDate(0, 0, -n), nil
+		case "week":
+			return now.AddDate(0, 0, -7*n), nil
+		case "month":
+			return now.AddDate(0, -n, 0), nil
+		case "year":
+			return now.AddDate(-n, 0, 0), nil
+		}
+	}
+
+	for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("invalid date: %s", value)
+}
@@ -0,0 +1,149 @@
+package revparse
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+// Range is the set of commits a revision argument selects: those reachable
+// from Include but not from Exclude
+type Range struct {
+	Include []objects.ObjectID
+	Exclude []objects.ObjectID
+	// Symmetric is set for A...B, whose merge bases are excluded
+	Symmetric bool
+}
+
+// IsRange reports whether arg uses range syntax rather than naming a single
+// revision
+func IsRange(arg string) bool {
+	if strings.HasPrefix(arg, ":") {
+		return false
+	}
+	if i := strings.Index(arg, ".."); i >= 0 && pathSeparator(arg[:i]) < 0 {
+		return true
+	}
+	if len(arg) > 1 && arg[0] == '^' {
+		return true
+	}
+	_, _, ok := parentShorthand(arg)
+	return ok
+}
+
+// parentShorthand splits the A^@, A^! and A^-<n> forms into A and the
+// suffix after the caret
+func parentShorthand(arg string) (rev, suffix string, ok bool) {
+	i := strings.LastIndex(arg, "^")
+	if i <= 0 {
+		return "", "", false
+	}
+	suffix = arg[i+1:]
+	switch {
+	case suffix == "@" || suffix == "!":
+		return arg[:i], suffix, true
+	case strings.HasPrefix(suffix, "-"):
+		if suffix == "-" {
+			return arg[:i], suffix, true
+		}
+		if n, err := strconv.Atoi(suffix[1:]); err == nil && n > 0 {
+			return arg[:i], suffix, true
+		}
+	}
+	return "", "", false
+}
+
+// ResolveRange resolves a revision argument to the commits it selects:
+// A..B (B but not A), A...B (either but not both), ^A (not A), A^@ (the
+// parents of A), A^! (A without its parents) and A^-<n> (A without its
+// n-th parent). A single revision includes just that commit. An empty side
+// of A..B or A...B stands for HEAD.
+func (r *Resolver) ResolveRange(arg string) (*Range, error) {
+	if IsRange(arg) {
+		if i := strings.Index(arg, "..."); i >= 0 {
+			return r.symmetricRange(arg[:i], arg[i+3:])
+		}
+		if i := strings.Index(arg, ".."); i >= 0 {
+			from, err := r.rangeEnd(arg[:i])
+			if err != nil {
+				return nil, err
+			}
+			to, err := r.rangeEnd(arg[i+2:])
+			if err != nil {
+				return nil, err
+			}
+			return &Range{Include: []objects.ObjectID{to}, Exclude: []objects.ObjectID{from}}, nil
+		}
+		if arg[0] == '^' {
+			id, err := r.ResolveCommit(arg[1:])
+			if err != nil {
+				return nil, err
+			}
+			return &Range{Exclude: []objects.ObjectID{id}}, nil
+		}
+		return r.parentRange(arg)
+	}
+
+	id, err := r.ResolveCommit(arg)
+	if err != nil {
+		return nil, err
+	}
+	return &Range{Include: []objects.ObjectID{id}}, nil
+}
+
+// rangeEnd resolves one side of A..B or A...B
+func (r *Resolver) rangeEnd(rev string) (objects.ObjectID, error) {
+	if rev == "" {
+		rev = "HEAD"
+	}
+	return r.ResolveCommit(rev)
+}
+
+// symmetricRange resolves A...B
+func (r *Resolver) symmetricRange(left, right string) (*Range, error) {
+	a, err := r.rangeEnd(left)
+	if err != nil {
+		return nil, err
+	}
+	b, err := r.rangeEnd(right)
+	if err != nil {
+		return nil, err
+	}
+	bases, err := r.MergeBases(a, b)
+	if err != nil {
+		return nil, err
+	}
+	// Git lists the right side first
+	return &Range{Include: []objects.ObjectID{b, a}, Exclude: bases, Symmetric: true}, nil
+}
+
+// parentRange resolves A^@, A^! and A^-<n>
+func (r *Resolver) parentRange(arg string) (*Range, error) {
+	rev, suffix, _ := parentShorthand(arg)
+	id, err := r.ResolveCommit(rev)
+	if err != nil {
+		return nil, err
+	}
+	commit, err := r.readCommit(id)
+	if err != nil {
+		return nil, err
+	}
+
+	switch suffix {
+	case "@":
+		return &Range{Include: commit.Parents()}, nil
+	case "!":
+		return &Range{Include: []objects.ObjectID{id}, Exclude: commit.Parents()}, nil
+	}
+
+	n := 1
+	if suffix != "-" {
+		n, _ = strconv.Atoi(suffix[1:])
+	}
+	parent, err := r.parent(id, n)
+	if err != nil {
+		return nil, err
+	}
+	return &Range{Include: []objects.ObjectID{id}, Exclude: []objects.ObjectID{parent}}, nil
+}
@@ -0,0 +1,84 @@
+package revparse
+
+import (
+	"strings"
+
+	"github.com/fenilsonani/vcs/internal/core/config"
+)
+
+// DefaultFetchRefspec returns the refspec Git records for a new remote,
+// which fetches its branches into refs/remotes/<remote>/
+func DefaultFetchRefspec(remote string) string {
+	return "+refs/heads/*:refs/remotes/" + remote + "/*"
+}
+
+// fetchRefspecs returns the remote.<remote>.fetch refspecs, or the default
+// for a remote that has none, as remotes added before they were recorded
+func fetchRefspecs(cfg *config.Config, remote string) []string {
+	if specs := cfg.GetAll("remote." + remote + ".fetch"); len(specs) > 0 {
+		return specs
+	}
+	return []string{DefaultFetchRefspec(remote)}
+}
+
+// TrackingRef returns the remote-tracking ref that ref, a ref of remote
+// such as refs/heads/main, is fetched into by the remote's fetch refspecs.
+// It reports false when no refspec fetches ref, or a negative one leaves it
+// out.
+func TrackingRef(cfg *config.Config, remote, ref string) (string, bool) {
+	var tracking string
+	for _, spec := range fetchRefspecs(cfg, remote) {
+		if negative, ok := strings.CutPrefix(spec, "^"); ok {
+			if _, ok := matchRefspec(negative, ref); ok {
+				return "", false
+			}
+			continue
+		}
+		src, dst, ok := strings.Cut(strings.TrimPrefix(spec, "+"), ":")
+		if !ok || dst == "" || tracking != "" {
+			continue
+		}
+		if star, ok := matchRefspec(src, ref); ok {
+			tracking = strings.Replace(dst, "*", star, 1)
+		}
+	}
+	return tracking, tracking != ""
+}
+
+// RemoteRef returns the ref of remote that tracking, a remote-tracking ref
+// such as refs/remotes/origin/main, is fetched from, as TrackingRef maps
+// them the other way. It reports false when the remote does not fetch into
+// tracking.
+func RemoteRef(cfg *config.Config, remote, tracking string) (string, bool) {
+	for _, spec := range fetchRefspecs(cfg, remote) {
+		if strings.HasPrefix(spec, "^") {
+			continue
+		}
+		src, dst, ok := strings.Cut(strings.TrimPrefix(spec, "+"), ":")
+		if !ok || dst == "" {
+			continue
+		}
+		star, ok := matchRefspec(dst, tracking)
+		if !ok {
+			continue
+		}
+		ref := strings.Replace(src, "*", star, 1)
+		if mapped, ok := TrackingRef(cfg, remote, ref); ok && mapped == tracking {
+			return ref, true
+		}
+	}
+	return "", false
+}
+
+// matchRefspec reports whether ref matches pattern, one side of a refspec,
+// returning what its * stands for
+func matchRefspec(pattern, ref string) (string, bool) {
+	prefix, suffix, wildcard := strings.Cut(pattern, "*")
+	if !wildcard {
+		return "", pattern == ref
+	}
+	if len(ref) < len(prefix)+len(suffix) || !strings.HasPrefix(ref, prefix) || !strings.HasSuffix(ref, suffix) {
+		return "", false
+	}
+	return ref[len(prefix) : len(ref)-len(suffix)], true
+}
@@ -0,0 +1,742 @@
+// Package revparse resolves Git's revision syntax: object names and their
+// abbreviations, refs and their reflogs (@{...}), ancestry and peeling
+// suffixes (~, ^, ^{...}), commit message searches (:/text), paths in trees
+// and the index (<rev>:<path>, :<path>) and ranges (A..B, A...B, ^A).
+package revparse
+
+import (
+	"bufio"
+	"container/heap"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fenilsonani/vcs/internal/core/commitgraph"
+	"github.com/fenilsonani/vcs/internal/core/config"
+	"github.com/fenilsonani/vcs/internal/core/index"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+)
+
+// minAbbrev is the shortest abbreviated object name accepted
+const minAbbrev = 4
+
+// ErrNoUpstream is returned for a branch without branch.<name>.remote and
+// branch.<name>.merge
+var ErrNoUpstream = errors.New("no upstream configured")
+
+// ErrNotTracked is returned for an upstream that the fetch refspecs of its
+// remote map to no remote-tracking branch
+var ErrNotTracked = errors.New("not stored as a remote-tracking branch")
+
+// Store reads the objects revisions name and finds abbreviated names
+type Store interface {
+	ReadObject(id objects.ObjectID) (objects.Object, error)
+	FindObjects(prefix string) ([]objects.ObjectID, error)
+}
+
+// Resolver resolves revisions in one repository
+type Resolver struct {
+	gitDir string
+	store  Store
+	refs   *refs.RefManager
+	// graph is the commit-graph, read on first use when graphLoaded is
+	// unset
+	graph       *commitgraph.Graph
+	graphLoaded bool
+	// Now returns the time that dates such as @{yesterday} count back from
+	Now func() time.Time
+}
+
+// NewResolver returns a resolver for the repository at gitDir
+func NewResolver(gitDir string, store Store) *Resolver {
+	return &Resolver{
+		gitDir: gitDir,
+		store:  store,
+		refs:   refs.NewRefManager(gitDir),
+		Now:    time.Now,
+	}
+}
+
+// Resolve returns the object rev names
+func (r *Resolver) Resolve(rev string) (objects.ObjectID, error) {
+	switch {
+	case rev == "":
+		return objects.ObjectID{}, fmt.Errorf("empty revision")
+	case strings.HasPrefix(rev, ":/"):
+		starts, err := r.refTips()
+		if err != nil {
+			return objects.ObjectID{}, err
+		}
+		return r.search(starts, rev[2:])
+	case strings.HasPrefix(rev, ":"):
+		return r.resolveIndexPath(rev[1:])
+	}
+
+	if i := pathSeparator(rev); i >= 0 {
+		return r.resolveTreePath(rev[:i], rev[i+1:])
+	}
+	return r.resolveSuffixes(rev)
+}
+
+// ResolveCommit returns the commit rev names, peeling tags
+func (r *Resolver) ResolveCommit(rev string) (objects.ObjectID, error) {
+	id, err := r.Resolve(rev)
+	if err != nil {
+		return objects.ObjectID{}, err
+	}
+	return r.peel(id, objects.TypeCommit)
+}
+
+// ResolveTree returns the tree rev names, peeling tags and commits
+func (r *Resolver) ResolveTree(rev string) (objects.ObjectID, error) {
+	id, err := r.Resolve(rev)
+	if err != nil {
+		return objects.ObjectID{}, err
+	}
+	return r.peel(id, objects.TypeTree)
+}
+
+// pathSeparator returns the index of the colon in <rev>:<path>, skipping
+// colons inside braces such as ^{/fix: typo}, or -1
+func pathSeparator(rev string) int {
+	depth := 0
+	for i, c := range rev {
+		switch c {
+		case '{':
+			depth++
+		case '}':
+			depth--
+		case ':':
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// resolveSuffixes applies the ~<n>, ^<n> and ^{...} suffixes at the end of
+// rev, innermost first
+func (r *Resolver) resolveSuffixes(rev string) (objects.ObjectID, error) {
+	if strings.HasSuffix(rev, "}") {
+		if i := strings.LastIndex(rev, "^{"); i >= 0 {
+			id, err := r.resolveSuffixes(rev[:i])
+			if err != nil {
+				return objects.ObjectID{}, err
+			}
+			return r.peelSpec(id, rev[i+2:len(rev)-1])
+		}
+	}
+
+	digits := len(rev)
+	for digits > 0 && rev[digits-1] >= '0' && rev[digits-1] <= '9' {
+		digits--
+	}
+	if digits > 0 && (rev[digits-1] == '~' || rev[digits-1] == '^') {
+		n := 1
+		if digits < len(rev) {
+			var err error
+			if n, err = strconv.Atoi(rev[digits:]); err != nil {
+				return objects.ObjectID{}, fmt.Errorf("invalid revision %q", rev)
+			}
+		}
+		id, err := r.resolveSuffixes(rev[:digits-1])
+		if err != nil {
+			return objects.ObjectID{}, err
+		}
+		if rev[digits-1] == '~' {
+			return r.ancestor(id, n)
+		}
+		return r.parent(id, n)
+	}
+
+	return r.resolveName(rev)
+}
+
+// resolveName resolves a revision without suffixes: a full or abbreviated
+// object name, a ref, or a ref with an @{...} reflog or tracking spec
+func (r *Resolver) resolveName(name string) (objects.ObjectID, error) {
+	if name == "@" {
+		name = "HEAD"
+	}
+	if i := strings.LastIndex(name, "@{"); i >= 0 && strings.HasSuffix(name, "}") {
+		return r.resolveAt(name[:i], name[i+2:len(name)-1])
+	}
+
+	if len(name) == 2*len(objects.ObjectID{}) {
+		if id, err := objects.NewObjectID(name); err == nil {
+			return id, nil
+		}
+	}
+	if id, err := r.refs.ResolveRef(name); err == nil {
+		return id, nil
+	}
+	// A remote name stands for its default branch
+	if id, err := r.refs.ResolveRef("refs/remotes/" + name + "/HEAD"); err == nil {
+		return id, nil
+	}
+	if len(name) >= minAbbrev && isHex(name) {
+		return r.resolveAbbrev(name)
+	}
+	return objects.ObjectID{}, fmt.Errorf("invalid revision %q", name)
+}
+
+func isHex(s string) bool {
+	for _, c := range s {
+		if !(c >= '0' && c <= '9' || c >= 'a' && c <= 'f' || c >= 'A' && c <= 'F') {
+			return false
+		}
+	}
+	return true
+}
+
+// resolveAbbrev finds the single object whose name starts with prefix
+func (r *Resolver) resolveAbbrev(prefix string) (objects.ObjectID, error) {
+	ids, err := r.store.FindObjects(prefix)
+	if err != nil {
+		return objects.ObjectID{}, err
+	}
+	switch len(ids) {
+	case 0:
+		return objects.ObjectID{}, fmt.Errorf("invalid revision %q", prefix)
+	case 1:
+		return ids[0], nil
+	default:
+		return objects.ObjectID{}, fmt.Errorf("short object ID %s is ambiguous", prefix)
+	}
+}
+
+// Abbrev returns the shortest prefix of id, at least minLength long, that
+// names no other object
+func (r *Resolver) Abbrev(id objects.ObjectID, minLength int) (string, error) {
+	name := id.String()
+	if minLength < minAbbrev {
+		minLength = minAbbrev
+	}
+	for n := minLength; n < len(name); n++ {
+		ids, err := r.store.FindObjects(name[:n])
+		if err != nil {
+			return "", err
+		}
+		if len(ids) <= 1 {
+			return name[:n], nil
+		}
+	}
+	return name, nil
+}
+
+// resolveAt resolves <ref>@{<spec>}: @{upstream} and @{push}, @{-<n>} for
+// the n-th branch checked out before the current one, @{<n>} for the n-th
+// prior value of ref and @{<date>} for its value at that time
+func (r *Resolver) resolveAt(ref, spec string) (objects.ObjectID, error) {
+	lower := strings.ToLower(spec)
+	if lower == "upstream" || lower == "u" || lower == "push" {
+		tracking, err := r.trackingRef(ref, lower == "push")
+		if err != nil {
+			return objects.ObjectID{}, err
+		}
+		return r.refs.ResolveRef(tracking)
+	}
+
+	if strings.HasPrefix(spec, "-") && ref == "" {
+		n, err := strconv.Atoi(spec[1:])
+		if err != nil || n < 1 {
+			return objects.ObjectID{}, fmt.Errorf("invalid revision %q", "@{"+spec+"}")
+		}
+		previous, err := r.PreviousCheckout(n)
+		if err != nil {
+			return objects.ObjectID{}, err
+		}
+		return r.resolveName(previous)
+	}
+
+	refName, err := r.reflogRef(ref)
+	if err != nil {
+		return objects.ObjectID{}, err
+	}
+
+	if n, err := strconv.Atoi(spec); err == nil && n >= 0 {
+		entries, err := r.refs.ReadReflog(refName)
+		if err != nil {
+			return objects.ObjectID{}, err
+		}
+		if n == 0 && len(entries) == 0 {
+			return r.refs.ResolveRef(refName)
+		}
+		if n >= len(entries) {
+			return objects.ObjectID{}, fmt.Errorf("log for %s only has %d entries", refName, len(entries))
+		}
+		return entries[len(entries)-1-n].New, nil
+	}
+
+	at, err := ParseDate(spec, r.Now())
+	if err != nil {
+		return objects.ObjectID{}, err
+	}
+	return r.refs.ReflogAt(refName, at)
+}
+
+// reflogRef returns the ref whose reflog <ref>@{...} reads. Without a ref
+// Git reads the current branch's reflog, or HEAD's when detached.
+func (r *Resolver) reflogRef(ref string) (string, error) {
+	if ref != "" {
+		return r.refs.ExpandRef(ref)
+	}
+	head, err := r.refs.SymbolicHEAD()
+	if err != nil {
+		return "", err
+	}
+	if head == "" {
+		return "HEAD", nil
+	}
+	return head, nil
+}
+
+// PreviousCheckout returns the branch or commit that was checked out n
+// switches ago, as recorded in HEAD's reflog
+func (r *Resolver) PreviousCheckout(n int) (string, error) {
+	entries, err := r.refs.ReadReflog("HEAD")
+	if err != nil {
+		return "", err
+	}
+	for i := len(entries) - 1; i >= 0; i-- {
+		rest, ok := strings.CutPrefix(entries[i].Message, "checkout: moving from ")
+		if !ok {
+			continue
+		}
+		from, _, ok := strings.Cut(rest, " to ")
+		if !ok {
+			continue
+		}
+		if n--; n == 0 {
+			return from, nil
+		}
+	}
+	return "", fmt.Errorf("no previous checkout to return to")
+}
+
+// trackingRef returns the remote-tracking ref the branch ref names merges
+// from, or pushes to when push is set. An empty ref means the current
+// branch.
+func (r *Resolver) trackingRef(ref string, push bool) (string, error) {
+	branch, err := r.branchName(ref)
+	if err != nil {
+		return "", err
+	}
+	cfg, err := config.Load(r.gitDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to read config: %w", err)
+	}
+	if push {
+		return pushRef(cfg, branch)
+	}
+	return upstreamRef(cfg, branch)
+}
+
+// Upstream returns the full name of the ref branch merges from, such as
+// refs/remotes/origin/main, whether or not that ref exists. A branch
+// without one gives ErrNoUpstream, and one whose upstream is fetched into
+// no remote-tracking branch ErrNotTracked.
+func (r *Resolver) Upstream(branch string) (string, error) {
+	cfg, err := config.Load(r.gitDir)
+	if err != nil {
+		return "", fmt.Errorf("failed to read config: %w", err)
+	}
+	return upstreamRef(cfg, strings.TrimPrefix(branch, "refs/heads/"))
+}
+
+// branchName returns the short name of the branch ref names, or of the
+// current branch when ref is empty
+func (r *Resolver) branchName(ref string) (string, error) {
+	if ref == "" || ref == "HEAD" || ref == "@" {
+		head, err := r.refs.SymbolicHEAD()
+		if err != nil {
+			return "", err
+		}
+		if !strings.HasPrefix(head, "refs/heads/") {
+			return "", fmt.Errorf("HEAD does not point to a branch")
+		}
+		return strings.TrimPrefix(head, "refs/heads/"), nil
+	}
+	branch := strings.TrimPrefix(ref, "refs/heads/")
+	if !r.refs.RefExists("refs/heads/" + branch) {
+		return "", fmt.Errorf("no such branch: '%s'", branch)
+	}
+	return branch, nil
+}
+
+// upstreamRef returns the remote-tracking ref branch merges from, as set by
+// branch.<name>.remote and branch.<name>.merge: the remote's fetch refspecs
+// map the merge ref to it
+func upstreamRef(cfg *config.Config, branch string) (string, error) {
+	remote, _ := cfg.Get("branch." + branch + ".remote")
+	merge, _ := cfg.Get("branch." + branch + ".merge")
+	if remote == "" || merge == "" {
+		return "", fmt.Errorf("%w for branch '%s'", ErrNoUpstream, branch)
+	}
+	if !strings.HasPrefix(merge, "refs/") {
+		merge = "refs/heads/" + merge
+	}
+	if remote == "." {
+		return merge, nil
+	}
+	tracking, ok := TrackingRef(cfg, remote, merge)
+	if !ok {
+		return "", fmt.Errorf("upstream branch '%s' %w", merge, ErrNotTracked)
+	}
+	return tracking, nil
+}
+
+// pushRef returns the remote-tracking ref for where "vcs push" would send
+// branch: the remote is branch.<name>.pushRemote, remote.pushDefault or the
+// upstream remote, and the branch keeps its name unless push.default is
+// upstream
+func pushRef(cfg *config.Config, branch string) (string, error) {
+	remote, _ := cfg.Get("branch." + branch + ".pushRemote")
+	if remote == "" {
+		remote, _ = cfg.Get("remote.pushDefault")
+	}
+	if remote == "" {
+		remote, _ = cfg.Get("branch." + branch + ".remote")
+	}
+	if remote == "" {
+		return "", fmt.Errorf("branch '%s' has no push destination", branch)
+	}
+
+	if mode, _ := cfg.Get("push.default"); mode == "upstream" || mode == "tracking" {
+		return upstreamRef(cfg, branch)
+	}
+	tracking, ok := TrackingRef(cfg, remote, "refs/heads/"+branch)
+	if !ok {
+		return "", fmt.Errorf("push destination 'refs/heads/%s' on remote '%s' has no local tracking branch", branch, remote)
+	}
+	return tracking, nil
+}
+
+// SymbolicFullName returns the full name of the ref rev names, such as
+// refs/heads/main for main or HEAD, and refs/remotes/origin/main for
+// main@{upstream}. A detached HEAD is returned as HEAD.
+func (r *Resolver) SymbolicFullName(rev string) (string, error) {
+	if rev == "@" {
+		rev = "HEAD"
+	}
+	if i := strings.LastIndex(rev, "@{"); i >= 0 && strings.HasSuffix(rev, "}") {
+		spec := strings.ToLower(rev[i+2 : len(rev)-1])
+		if spec == "upstream" || spec == "u" || spec == "push" {
+			return r.trackingRef(rev[:i], spec == "push")
+		}
+		return "", fmt.Errorf("%s does not name a ref", rev)
+	}
+	if rev == "HEAD" {
+		head, err := r.refs.SymbolicHEAD()
+		if err != nil || head == "" {
+			return "HEAD", err
+		}
+		return head, nil
+	}
+	return r.refs.ExpandRef(rev)
+}
+
+// parent returns the n-th parent of the commit id names, or the commit
+// itself for n of zero
+func (r *Resolver) parent(id objects.ObjectID, n int) (objects.ObjectID, error) {
+	commit, err := r.readCommit(id)
+	if err != nil {
+		return objects.ObjectID{}, err
+	}
+	if n == 0 {
+		return commit.ID(), nil
+	}
+	if n > len(commit.Parents()) {
+		return objects.ObjectID{}, fmt.Errorf("commit %s has no parent %d", commit.ID().Short(), n)
+	}
+	return commit.Parents()[n-1], nil
+}
+
+// ancestor returns the n-th first-parent ancestor of the commit id names
+func (r *Resolver) ancestor(id objects.ObjectID, n int) (objects.ObjectID, error) {
+	id, err := r.peel(id, objects.TypeCommit)
+	if err != nil {
+		return objects.ObjectID{}, err
+	}
+	for ; n > 0; n-- {
+		if id, err = r.parent(id, 1); err != nil {
+			return objects.ObjectID{}, err
+		}
+	}
+	return id, nil
+}
+
+// readCommit reads the commit id names, peeling tags
+func (r *Resolver) readCommit(id objects.ObjectID) (*objects.Commit, error) {
+	id, err := r.peel(id, objects.TypeCommit)
+	if err != nil {
+		return nil, err
+	}
+	obj, err := r.store.ReadObject(id)
+	if err != nil {
+		return nil, err
+	}
+	return obj.(*objects.Commit), nil
+}
+
+// peelSpec applies ^{<spec>}: ^{} peels tags, ^{<type>} peels to that type
+// and ^{/<text>} finds the youngest matching commit reachable from id
+func (r *Resolver) peelSpec(id objects.ObjectID, spec string) (objects.ObjectID, error) {
+	switch {
+	case strings.HasPrefix(spec, "/"):
+		commit, err := r.peel(id, objects.TypeCommit)
+		if err != nil {
+			return objects.ObjectID{}, err
+		}
+		return r.search([]objects.ObjectID{commit}, spec[1:])
+	case spec == "":
+		return r.peel(id, "")
+	case spec == "object":
+		_, err := r.store.ReadObject(id)
+		return id, err
+	case spec == "tag":
+		obj, err := r.store.ReadObject(id)
+		if err != nil {
+			return objects.ObjectID{}, err
+		}
+		if obj.Type() != objects.TypeTag {
+			return objects.ObjectID{}, fmt.Errorf("%s is a %s, not a tag", id.Short(), obj.Type())
+		}
+		return id, nil
+	}
+
+	want := objects.ObjectType(spec)
+	if !want.IsValid() {
+		return objects.ObjectID{}, fmt.Errorf("invalid object type %q", spec)
+	}
+	return r.peel(id, want)
+}
+
+// peel follows tags, and commits to their trees, until it reaches an object
+// of type want. An empty want stops at the first object that is not a tag.
+func (r *Resolver) peel(id objects.ObjectID, want objects.ObjectType) (objects.ObjectID, error) {
+	for {
+		obj, err := r.store.ReadObject(id)
+		if err != nil {
+			return objects.ObjectID{}, err
+		}
+		switch {
+		case obj.Type() == want || (want == "" && obj.Type() != objects.TypeTag):
+			return id, nil
+		case obj.Type() == objects.TypeTag:
+			id = obj.(*objects.Tag).Object()
+		case obj.Type() == objects.TypeCommit && want == objects.TypeTree:
+			id = obj.(*objects.Commit).Tree()
+		default:
+			return objects.ObjectID{}, fmt.Errorf("%s is a %s, not a %s", id.Short(), obj.Type(), want)
+		}
+	}
+}
+
+// resolveTreePath resolves <rev>:<path> to the blob or tree at path
+func (r *Resolver) resolveTreePath(rev, path string) (objects.ObjectID, error) {
+	id, err := r.resolveSuffixes(rev)
+	if err != nil {
+		return objects.ObjectID{}, err
+	}
+	if id, err = r.peel(id, objects.TypeTree); err != nil {
+		return objects.ObjectID{}, err
+	}
+
+	path = strings.Trim(strings.TrimPrefix(path, "./"), "/")
+	if path == "" {
+		return id, nil
+	}
+	for _, name := range strings.Split(path, "/") {
+		obj, err := r.store.ReadObject(id)
+		if err != nil {
+			return objects.ObjectID{}, err
+		}
+		tree, ok := obj.(*objects.Tree)
+		if !ok {
+			return objects.ObjectID{}, fmt.Errorf("path '%s' does not exist in '%s'", path, rev)
+		}
+		found := false
+		for _, entry := range tree.Entries() {
+			if entry.Name == name {
+				id, found = entry.ID, true
+				break
+			}
+		}
+		if !found {
+			return objects.ObjectID{}, fmt.Errorf("path '%s' does not exist in '%s'", path, rev)
+		}
+	}
+	return id, nil
+}
+
+// resolveIndexPath resolves :<path> and :<stage>:<path> to a blob in the
+// index
+func (r *Resolver) resolveIndexPath(spec string) (objects.ObjectID, error) {
+	stage, path := 0, spec
+	if len(spec) > 2 && spec[0] >= '0' && spec[0] <= '3' && spec[1] == ':' {
+		stage, path = int(spec[0]-'0'), spec[2:]
+	}
+
+	idx := index.New()
+	indexPath := filepath.Join(r.gitDir, "index")
+	if _, err := os.Stat(indexPath); err == nil {
+		if err := idx.ReadFromFile(indexPath); err != nil {
+			return objects.ObjectID{}, fmt.Errorf("failed to read index: %w", err)
+		}
+	}
+	for _, entry := range idx.Entries() {
+		if entry.Path == path && entry.Stage() == stage {
+			return entry.ID, nil
+		}
+	}
+	if stage == 0 {
+		return objects.ObjectID{}, fmt.Errorf("path '%s' is not in the index", path)
+	}
+	return objects.ObjectID{}, fmt.Errorf("path '%s' is not in the index at stage %d", path, stage)
+}
+
+// refTips returns the commits of HEAD and every ref, the starting points
+// of :/<text>
+func (r *Resolver) refTips() ([]objects.ObjectID, error) {
+	all, err := r.refs.AllRefs()
+	if err != nil {
+		return nil, err
+	}
+	var tips []objects.ObjectID
+	if head, err := r.refs.ResolveRef("HEAD"); err == nil {
+		tips = append(tips, head)
+	}
+	for _, id := range all {
+		// Tags of trees or blobs have no history to search
+		if commit, err := r.peel(id, objects.TypeCommit); err == nil {
+			tips = append(tips, commit)
+		}
+	}
+	return tips, nil
+}
+
+// search returns the youngest commit reachable from starts whose message
+// matches the regular expression pattern. A leading "!-" inverts the
+// match, and "!!" stands for a literal "!".
+func (r *Resolver) search(starts []objects.ObjectID, pattern string) (objects.ObjectID, error) {
+	negate := false
+	switch {
+	case strings.HasPrefix(pattern, "!-"):
+		negate, pattern = true, pattern[2:]
+	case strings.HasPrefix(pattern, "!!"):
+		pattern = pattern[1:]
+	case strings.HasPrefix(pattern, "!"):
+		return objects.ObjectID{}, fmt.Errorf("invalid search pattern %q", pattern)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return objects.ObjectID{}, fmt.Errorf("invalid search pattern: %w", err)
+	}
+
+	var found objects.ObjectID
+	err = r.walk(starts, func(commit *objects.Commit) bool {
+		if re.MatchString(commit.Message()) != negate {
+			found = commit.ID()
+			return false
+		}
+		return true
+	})
+	if err != nil {
+		return objects.ObjectID{}, err
+	}
+	if found.IsZero() {
+		return objects.ObjectID{}, fmt.Errorf("no commit message matches %q", pattern)
+	}
+	return found, nil
+}
+
+// walk visits the commits reachable from starts, newest committer date
+// first, until visit returns false
+func (r *Resolver) walk(starts []objects.ObjectID, visit func(*objects.Commit) bool) error {
+	shallow, err := r.shallowCommits()
+	if err != nil {
+		return err
+	}
+
+	queue := &commitQueue{}
+	seen := make(map[objects.ObjectID]bool)
+	push := func(id objects.ObjectID) error {
+		if seen[id] {
+			return nil
+		}
+		seen[id] = true
+		commit, err := r.readCommit(id)
+		if err != nil {
+			return err
+		}
+		heap.Push(queue, commit)
+		return nil
+	}
+
+	for _, id := range starts {
+		if err := push(id); err != nil {
+			return err
+		}
+	}
+	for queue.Len() > 0 {
+		commit := heap.Pop(queue).(*objects.Commit)
+		if !visit(commit) {
+			return nil
+		}
+		// History ends at shallow boundaries; their parents were not fetched
+		if shallow[commit.ID()] {
+			continue
+		}
+		for _, parent := range commit.Parents() {
+			if err := push(parent); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// shallowCommits reads the commits listed in the shallow file
+func (r *Resolver) shallowCommits() (map[objects.ObjectID]bool, error) {
+	file, err := os.Open(filepath.Join(r.refs.CommonDir(), "shallow"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read shallow file: %w", err)
+	}
+	defer file.Close()
+
+	shallow := make(map[objects.ObjectID]bool)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		if id, err := objects.NewObjectID(strings.TrimSpace(scanner.Text())); err == nil {
+			shallow[id] = true
+		}
+	}
+	return shallow, scanner.Err()
+}
+
+// commitQueue orders commits newest committer date first
+type commitQueue []*objects.Commit
+
+func (q commitQueue) Len() int { return len(q) }
+func (q commitQueue) Less(i, j int) bool {
+	return q[i].Committer().When.After(q[j].Committer().When)
+}
+func (q commitQueue) Swap(i, j int) { q[i], q[j] = q[j], q[i] }
+func (q *commitQueue) Push(x any)   { *q = append(*q, x.(*objects.Commit)) }
+func (q *commitQueue) Pop() any {
+	old := *q
+	commit := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return commit
+}
@@ -0,0 +1,528 @@
+package revparse
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fenilsonani/vcs/internal/core/commitgraph"
+	"github.com/fenilsonani/vcs/internal/core/config"
+	"github.com/fenilsonani/vcs/internal/core/index"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+)
+
+// fixture is a repository with this history, oldest first:
+//
+//	c1 - c2 - c3 - m (main, HEAD)
+//	       \      /
+//	        s1 ---  (side)
+//	c3 - o1 (other)
+//
+// and an annotated tag v1 of c2
+type fixture struct {
+	gitDir                string
+	store                 *objects.Storage
+	refs                  *refs.RefManager
+	c1, c2, c3, s1, m, o1 objects.ObjectID
+	tag, blob, tree       objects.ObjectID
+	resolver              *Resolver
+}
+
+func newFixture(t *testing.T) *fixture {
+	gitDir := t.TempDir()
+	store := objects.NewStorage(gitDir)
+	if err := store.Init(); err != nil {
+		t.Fatalf("Storage.Init() error = %v", err)
+	}
+	f := &fixture{gitDir: gitDir, store: store, refs: refs.NewRefManager(gitDir)}
+
+	blob := objects.NewBlob([]byte("a\n"))
+	tree := objects.NewTree()
+	if err := tree.AddEntry(objects.ModeBlob, "a.txt", blob.ID()); err != nil {
+		t.Fatal(err)
+	}
+	for _, obj := range []objects.Object{blob, tree} {
+		if err := store.WriteObject(obj); err != nil {
+			t.Fatalf("WriteObject() error = %v", err)
+		}
+	}
+	f.blob, f.tree = blob.ID(), tree.ID()
+
+	when := time.Unix(1700000000, 0)
+	commit := func(message string, parents ...objects.ObjectID) objects.ObjectID {
+		when = when.Add(time.Hour)
+		sig := objects.Signature{Name: "Test", Email: "test@example.com", When: when}
+		c := objects.NewCommit(f.tree, parents, sig, sig, message)
+		if err := store.WriteObject(c); err != nil {
+			t.Fatalf("WriteObject() error = %v", err)
+		}
+		return c.ID()
+	}
+	f.c1 = commit("first\n")
+	f.c2 = commit("second\n", f.c1)
+	f.s1 = commit("side work\n", f.c2)
+	f.c3 = commit("third\n", f.c2)
+	f.m = commit("merge side\n", f.c3, f.s1)
+	f.o1 = commit("other work\n", f.c3)
+
+	tag := objects.NewTag(f.c2, objects.TypeCommit, "v1", objects.Signature{Name: "Test", Email: "test@example.com", When: when}, "release\n")
+	if err := store.WriteObject(tag); err != nil {
+		t.Fatalf("WriteObject() error = %v", err)
+	}
+	f.tag = tag.ID()
+
+	for name, id := range map[string]objects.ObjectID{
+		"refs/heads/main":  f.m,
+		"refs/heads/side":  f.s1,
+		"refs/heads/other": f.o1,
+		"refs/tags/v1":     f.tag,
+	} {
+		if err := f.refs.UpdateRef(name, id); err != nil {
+			t.Fatalf("UpdateRef() error = %v", err)
+		}
+	}
+	if err := f.refs.SetHEAD("refs/heads/main"); err != nil {
+		t.Fatalf("SetHEAD() error = %v", err)
+	}
+
+	f.resolver = NewResolver(gitDir, store)
+	return f
+}
+
+func TestResolve(t *testing.T) {
+	f := newFixture(t)
+
+	tests := map[string]objects.ObjectID{
+		"main":             f.m,
+		"HEAD":             f.m,
+		"@":                f.m,
+		"refs/heads/side":  f.s1,
+		"main^":            f.c3,
+		"main^1":           f.c3,
+		"main^2":           f.s1,
+		"main^0":           f.m,
+		"main~2":           f.c2,
+		"HEAD^^":           f.c2,
+		"main^2~1":         f.c2,
+		"v1":               f.tag,
+		"v1^{}":            f.c2,
+		"v1^{commit}":      f.c2,
+		"v1^{tag}":         f.tag,
+		"v1~1":             f.c1,
+		"v1^{tree}":        f.tree,
+		"main:a.txt":       f.blob,
+		"main:":            f.tree,
+		"HEAD~3:a.txt":     f.blob,
+		f.c1.String():      f.c1,
+		f.c1.String()[:10]: f.c1,
+		":/second":         f.c2,
+		":/^side":          f.s1,
+		":/!-merge":        f.o1,
+		"main^{/^f}":       f.c1,
+		"other^{/work}":    f.o1,
+	}
+	for rev, want := range tests {
+		got, err := f.resolver.Resolve(rev)
+		if err != nil {
+			t.Errorf("Resolve(%q) error = %v", rev, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("Resolve(%q) = %s, want %s", rev, got.Short(), want.Short())
+		}
+	}
+
+	errors := map[string]string{
+		"nope":          `invalid revision "nope"`,
+		"main~10":       "has no parent",
+		"main^3":        "has no parent 3",
+		"v1^{blob}":     "not a blob",
+		"main:nope.txt": "does not exist",
+		":/no such":     "no commit message matches",
+		"":              "empty revision",
+	}
+	for rev, want := range errors {
+		_, err := f.resolver.Resolve(rev)
+		if err == nil || !strings.Contains(err.Error(), want) {
+			t.Errorf("Resolve(%q) error = %v, want %q", rev, err, want)
+		}
+	}
+
+	if got, err := f.resolver.ResolveCommit("v1"); err != nil || got != f.c2 {
+		t.Errorf("ResolveCommit(v1) = %s, %v, want %s", got.Short(), err, f.c2.Short())
+	}
+	if _, err := f.resolver.ResolveCommit("main:a.txt"); err == nil {
+		t.Errorf("ResolveCommit() of a blob expected error")
+	}
+}
+
+func TestResolveReflog(t *testing.T) {
+	f := newFixture(t)
+	who := objects.Signature{Name: "Test", Email: "test@example.com", When: time.Now()}
+
+	previous := objects.ObjectID{}
+	for _, id := range []objects.ObjectID{f.c1, f.c2, f.c3, f.m} {
+		if err := f.refs.LogUpdate("refs/heads/main", previous, id, who, "commit"); err != nil {
+			t.Fatalf("LogUpdate() error = %v", err)
+		}
+		previous = id
+	}
+	if err := f.refs.AppendReflog("HEAD", refs.ReflogEntry{Old: f.s1, New: f.m, Who: who, Message: "checkout: moving from side to main"}); err != nil {
+		t.Fatalf("AppendReflog() error = %v", err)
+	}
+
+	tests := map[string]objects.ObjectID{
+		"main@{0}":   f.m,
+		"@{1}":       f.c3,
+		"main@{3}":   f.c1,
+		"main@{1}~1": f.c2,
+		"@{-1}":      f.s1,
+		"@{-1}~1":    f.c2,
+		"HEAD@{0}":   f.m,
+	}
+	for rev, want := range tests {
+		got, err := f.resolver.Resolve(rev)
+		if err != nil {
+			t.Errorf("Resolve(%q) error = %v", rev, err)
+			continue
+		}
+		if got != want {
+			t.Errorf("Resolve(%q) = %s, want %s", rev, got.Short(), want.Short())
+		}
+	}
+
+	if _, err := f.resolver.Resolve("main@{4}"); err == nil || !strings.Contains(err.Error(), "only has 4 entries") {
+		t.Errorf("Resolve(main@{4}) error = %v, want only has 4 entries", err)
+	}
+	if _, err := f.resolver.Resolve("@{-2}"); err == nil {
+		t.Errorf("Resolve(@{-2}) expected error")
+	}
+	if previous, err := f.resolver.PreviousCheckout(1); err != nil || previous != "side" {
+		t.Errorf("PreviousCheckout(1) = %q, %v, want side", previous, err)
+	}
+}
+
+func TestResolveIndexPath(t *testing.T) {
+	f := newFixture(t)
+
+	idx := index.New()
+	if err := idx.Add(&index.Entry{Path: "a.txt", ID: f.blob, Mode: objects.ModeBlob}); err != nil {
+		t.Fatal(err)
+	}
+	theirs := &index.Entry{Path: "b.txt", ID: f.tree, Mode: objects.ModeBlob}
+	theirs.SetStage(3)
+	if err := idx.Add(theirs); err != nil {
+		t.Fatal(err)
+	}
+	if err := idx.WriteToFile(filepath.Join(f.gitDir, "index")); err != nil {
+		t.Fatalf("WriteToFile() error = %v", err)
+	}
+
+	if got, err := f.resolver.Resolve(":a.txt"); err != nil || got != f.blob {
+		t.Errorf("Resolve(:a.txt) = %s, %v, want %s", got.Short(), err, f.blob.Short())
+	}
+	if got, err := f.resolver.Resolve(":3:b.txt"); err != nil || got != f.tree {
+		t.Errorf("Resolve(:3:b.txt) = %s, %v, want %s", got.Short(), err, f.tree.Short())
+	}
+	if _, err := f.resolver.Resolve(":b.txt"); err == nil {
+		t.Errorf("Resolve(:b.txt) expected error for an unmerged path")
+	}
+}
+
+func TestResolveRange(t *testing.T) {
+	f := newFixture(t)
+
+	tests := []struct {
+		arg       string
+		include   []objects.ObjectID
+		exclude   []objects.ObjectID
+		symmetric bool
+	}{
+		{"main", []objects.ObjectID{f.m}, nil, false},
+		{"v1..main", []objects.ObjectID{f.m}, []objects.ObjectID{f.c2}, false},
+		{"side..", []objects.ObjectID{f.m}, []objects.ObjectID{f.s1}, false},
+		{"..side", []objects.ObjectID{f.s1}, []objects.ObjectID{f.m}, false},
+		{"other...side", []objects.ObjectID{f.s1, f.o1}, []objects.ObjectID{f.c2}, true},
+		{"^main", nil, []objects.ObjectID{f.m}, false},
+		{"main^@", []objects.ObjectID{f.c3, f.s1}, nil, false},
+		{"main^!", []objects.ObjectID{f.m}, []objects.ObjectID{f.c3, f.s1}, false},
+		{"main^-", []objects.ObjectID{f.m}, []objects.ObjectID{f.c3}, false},
+		{"main^-2", []objects.ObjectID{f.m}, []objects.ObjectID{f.s1}, false},
+	}
+	for _, tt := range tests {
+		r, err := f.resolver.ResolveRange(tt.arg)
+		if err != nil {
+			t.Errorf("ResolveRange(%q) error = %v", tt.arg, err)
+			continue
+		}
+		if !equalIDs(r.Include, tt.include) || !equalIDs(r.Exclude, tt.exclude) || r.Symmetric != tt.symmetric {
+			t.Errorf("ResolveRange(%q) = %+v, want include %v exclude %v", tt.arg, r, tt.include, tt.exclude)
+		}
+	}
+
+	for _, arg := range []string{"main", "HEAD^2", "main~1", ":/a..b", "main:a..b", "v1^{}"} {
+		if IsRange(arg) {
+			t.Errorf("IsRange(%q) = true, want false", arg)
+		}
+	}
+}
+
+func equalIDs(a, b []objects.ObjectID) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestMergeBases(t *testing.T) {
+	f := newFixture(t)
+
+	tests := []struct {
+		a, b objects.ObjectID
+		want objects.ObjectID
+	}{
+		{f.o1, f.s1, f.c2},
+		{f.m, f.o1, f.c3},
+		{f.m, f.s1, f.s1},
+		{f.s1, f.m, f.s1},
+	}
+	check := func(resolver *Resolver) {
+		t.Helper()
+		for _, tt := range tests {
+			bases, err := resolver.MergeBases(tt.a, tt.b)
+			if err != nil {
+				t.Fatalf("MergeBases() error = %v", err)
+			}
+			if len(bases) != 1 || bases[0] != tt.want {
+				t.Errorf("MergeBases(%s, %s) = %v, want %s", tt.a.Short(), tt.b.Short(), bases, tt.want.Short())
+			}
+		}
+	}
+	check(f.resolver)
+
+	// The same answers come from the commit-graph
+	resolver := withCommitGraph(t, f)
+	check(resolver)
+
+	reachable, err := resolver.Reachable([]objects.ObjectID{f.m})
+	if err != nil || len(reachable) != 5 || reachable[f.o1] {
+		t.Errorf("Reachable(main) = %d commits, %v; want the 5 of main", len(reachable), err)
+	}
+}
+
+// withCommitGraph returns a resolver of f that reads its commit-graph
+func withCommitGraph(t *testing.T, f *fixture) *Resolver {
+	t.Helper()
+	commits, err := commitgraph.Build(f.store, []objects.ObjectID{f.m, f.o1}, nil)
+	if err != nil {
+		t.Fatalf("commitgraph.Build() error = %v", err)
+	}
+	if err := commitgraph.Save(filepath.Join(f.gitDir, "objects"), commits); err != nil {
+		t.Fatalf("commitgraph.Save() error = %v", err)
+	}
+	resolver := NewResolver(f.gitDir, f.store)
+	if resolver.commitGraph() == nil {
+		t.Fatalf("commitGraph() = nil after writing one")
+	}
+	return resolver
+}
+
+func TestIsAncestor(t *testing.T) {
+	f := newFixture(t)
+
+	tests := []struct {
+		a, b objects.ObjectID
+		want bool
+	}{
+		{f.c1, f.m, true},
+		{f.s1, f.m, true},
+		{f.m, f.m, true},
+		{f.m, f.c3, false},
+		{f.s1, f.o1, false},
+		{f.o1, f.m, false},
+	}
+	for _, resolver := range []*Resolver{f.resolver, withCommitGraph(t, f)} {
+		for _, tt := range tests {
+			got, err := resolver.IsAncestor(tt.a, tt.b)
+			if err != nil || got != tt.want {
+				t.Errorf("IsAncestor(%s, %s) = %v, %v, want %v", tt.a.Short(), tt.b.Short(), got, err, tt.want)
+			}
+		}
+	}
+}
+
+func TestOctopusMergeBases(t *testing.T) {
+	f := newFixture(t)
+	for _, resolver := range []*Resolver{f.resolver, withCommitGraph(t, f)} {
+		bases, err := resolver.OctopusMergeBases([]objects.ObjectID{f.m, f.o1, f.s1})
+		if err != nil || !equalIDs(bases, []objects.ObjectID{f.c2}) {
+			t.Errorf("OctopusMergeBases(m, o1, s1) = %v, %v, want c2", bases, err)
+		}
+		bases, err = resolver.OctopusMergeBases([]objects.ObjectID{f.m, f.o1})
+		if err != nil || !equalIDs(bases, []objects.ObjectID{f.c3}) {
+			t.Errorf("OctopusMergeBases(m, o1) = %v, %v, want c3", bases, err)
+		}
+	}
+}
+
+func TestRangeCommits(t *testing.T) {
+	f := newFixture(t)
+
+	tests := []struct {
+		rng  Range
+		want []objects.ObjectID
+	}{
+		{Range{Include: []objects.ObjectID{f.m}, Exclude: []objects.ObjectID{f.o1}}, []objects.ObjectID{f.m, f.s1}},
+		{Range{Include: []objects.ObjectID{f.o1}, Exclude: []objects.ObjectID{f.m}}, []objects.ObjectID{f.o1}},
+		{Range{Include: []objects.ObjectID{f.m}, Exclude: []objects.ObjectID{f.m}}, nil},
+		{Range{Include: []objects.ObjectID{f.c3}}, []objects.ObjectID{f.c3, f.c2, f.c1}},
+	}
+	for _, resolver := range []*Resolver{f.resolver, withCommitGraph(t, f)} {
+		for _, tt := range tests {
+			got, err := resolver.RangeCommits(&tt.rng)
+			if err != nil || !equalIDs(got, tt.want) {
+				t.Errorf("RangeCommits(%v) = %v, %v, want %v", tt.rng, got, err, tt.want)
+			}
+		}
+	}
+}
+
+func TestAheadBehind(t *testing.T) {
+	f := newFixture(t)
+
+	tests := []struct {
+		a, b          objects.ObjectID
+		ahead, behind int
+	}{
+		{f.m, f.o1, 2, 1},
+		{f.o1, f.m, 1, 2},
+		{f.c1, f.m, 0, 4},
+		{f.m, f.c2, 3, 0},
+		{f.m, f.m, 0, 0},
+	}
+	for _, resolver := range []*Resolver{f.resolver, withCommitGraph(t, f)} {
+		for _, tt := range tests {
+			ahead, behind, err := resolver.AheadBehind(tt.a, tt.b)
+			if err != nil || ahead != tt.ahead || behind != tt.behind {
+				t.Errorf("AheadBehind(%s, %s) = %d, %d, %v, want %d, %d", tt.a.Short(), tt.b.Short(), ahead, behind, err, tt.ahead, tt.behind)
+			}
+		}
+	}
+}
+
+func TestAbbrev(t *testing.T) {
+	f := newFixture(t)
+
+	short, err := f.resolver.Abbrev(f.c1, 7)
+	if err != nil {
+		t.Fatalf("Abbrev() error = %v", err)
+	}
+	if short != f.c1.String()[:7] {
+		t.Errorf("Abbrev() = %s, want %s", short, f.c1.String()[:7])
+	}
+	if got, err := f.resolver.Resolve(short); err != nil || got != f.c1 {
+		t.Errorf("Resolve(%s) = %s, %v, want %s", short, got.Short(), err, f.c1.Short())
+	}
+}
+
+func TestParseDate(t *testing.T) {
+	// A Wednesday
+	now := time.Date(2024, 1, 10, 12, 0, 0, 0, time.Local)
+
+	tests := []struct {
+		value string
+		want  time.Time
+	}{
+		{"now", now},
+		{"yesterday", now.AddDate(0, 0, -1)},
+		{"last tuesday", now.AddDate(0, 0, -1)},
+		{"Wednesday", now.AddDate(0, 0, -7)},
+		{"2.weeks.ago", now.AddDate(0, 0, -14)},
+		{"3 days ago", now.AddDate(0, 0, -3)},
+		{"2024-01-01", time.Date(2024, 1, 1, 0, 0, 0, 0, time.Local)},
+	}
+	for _, tt := range tests {
+		got, err := ParseDate(tt.value, now)
+		if err != nil {
+			t.Errorf("ParseDate(%q) error = %v", tt.value, err)
+			continue
+		}
+		if !got.Equal(tt.want) {
+			t.Errorf("ParseDate(%q) = %v, want %v", tt.value, got, tt.want)
+		}
+	}
+
+	for _, value := range []string{"never", "someday"} {
+		if _, err := ParseDate(value, now); err == nil || !strings.Contains(err.Error(), "invalid date") {
+			t.Errorf("ParseDate(%q) error = %v, want invalid date", value, err)
+		}
+	}
+}
+
+func TestTrackingRef(t *testing.T) {
+	gitDir := t.TempDir()
+	t.Setenv("GIT_CONFIG_NOSYSTEM", "1")
+	t.Setenv("GIT_CONFIG_GLOBAL", filepath.Join(gitDir, "global"))
+	data := "[remote \"origin\"]\n" +
+		"\tfetch = +refs/heads/*:refs/remotes/mirror/*\n" +
+		"\tfetch = ^refs/heads/secret\n" +
+		"\tfetch = refs/tags/v1:refs/tags/v1\n" +
+		"[remote \"fork\"]\n" +
+		"\turl = /srv/fork.git\n" +
+		"[branch \"main\"]\n\tremote = origin\n\tmerge = refs/heads/main\n" +
+		"[branch \"hidden\"]\n\tremote = origin\n\tmerge = refs/heads/secret\n"
+	if err := os.WriteFile(filepath.Join(gitDir, "config"), []byte(data), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := config.Load(gitDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		remote, ref, tracking string
+	}{
+		{"origin", "refs/heads/main", "refs/remotes/mirror/main"},
+		{"origin", "refs/heads/a/b", "refs/remotes/mirror/a/b"},
+		{"origin", "refs/heads/secret", ""},
+		{"origin", "refs/tags/v1", "refs/tags/v1"},
+		{"origin", "refs/notes/x", ""},
+		// A remote without fetch refspecs gets the default
+		{"fork", "refs/heads/main", "refs/remotes/fork/main"},
+	}
+	for _, tt := range tests {
+		got, ok := TrackingRef(cfg, tt.remote, tt.ref)
+		if got != tt.tracking || ok != (tt.tracking != "") {
+			t.Errorf("TrackingRef(%s, %s) = %q, %v, want %q", tt.remote, tt.ref, got, ok, tt.tracking)
+		}
+		if tt.tracking == "" {
+			continue
+		}
+		if ref, ok := RemoteRef(cfg, tt.remote, tt.tracking); !ok || ref != tt.ref {
+			t.Errorf("RemoteRef(%s, %s) = %q, %v, want %q", tt.remote, tt.tracking, ref, ok, tt.ref)
+		}
+	}
+	if ref, ok := RemoteRef(cfg, "origin", "refs/remotes/origin/main"); ok {
+		t.Errorf("RemoteRef() = %q for a ref origin does not fetch into", ref)
+	}
+
+	// The upstream is the merge ref mapped through the refspecs
+	if upstream, err := upstreamRef(cfg, "main"); err != nil || upstream != "refs/remotes/mirror/main" {
+		t.Errorf("upstreamRef(main) = %q, %v", upstream, err)
+	}
+	if upstream, err := upstreamRef(cfg, "hidden"); !errors.Is(err, ErrNotTracked) {
+		t.Errorf("upstreamRef(hidden) = %q, %v, want ErrNotTracked", upstream, err)
+	}
+	if upstream, err := upstreamRef(cfg, "other"); !errors.Is(err, ErrNoUpstream) {
+		t.Errorf("upstreamRef(other) = %q, %v, want ErrNoUpstream", upstream, err)
+	}
+}
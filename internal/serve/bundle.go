@@ -0,0 +1,102 @@
+package serve
+
+import (
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/fenilsonani/vcs/internal/core/bundle"
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+// BundleServer serves a bundle file as a read-only repository holding
+// just the bundle's refs. Every fetch gets the bundle's whole pack, as
+// there is nothing to negotiate over.
+type BundleServer struct {
+	bundle *bundle.Bundle
+}
+
+// NewBundleServer creates a server for b
+func NewBundleServer(b *bundle.Bundle) *BundleServer {
+	return &BundleServer{bundle: b}
+}
+
+// ServeHTTP implements http.Handler
+func (s *BundleServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/info/refs") && r.Method == http.MethodGet:
+		if r.URL.Query().Get("service") != "git-upload-pack" {
+			http.Error(w, "a bundle can only be fetched from", http.StatusForbidden)
+			return
+		}
+		s.advertiseRefs(w)
+	case strings.HasSuffix(r.URL.Path, "/git-upload-pack") && r.Method == http.MethodPost:
+		s.uploadPack(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// advertiseRefs writes the refs of the bundle, HEAD first. HEAD is
+// offered as a symref to the branch it matches, if any.
+func (s *BundleServer) advertiseRefs(w http.ResponseWriter) {
+	capabilities := []string{"ofs-delta", "agent=" + agent}
+	var refs []bundle.Ref
+	if head, ok := s.bundle.Ref("HEAD"); ok {
+		refs = append(refs, bundle.Ref{Name: "HEAD", ID: head})
+		for _, ref := range s.bundle.Refs {
+			if ref.ID == head && strings.HasPrefix(ref.Name, "refs/heads/") {
+				capabilities = append(capabilities, "symref=HEAD:"+ref.Name)
+				break
+			}
+		}
+	}
+	for _, ref := range s.bundle.Refs {
+		if ref.Name != "HEAD" {
+			refs = append(refs, ref)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/x-git-upload-pack-advertisement")
+	w.Header().Set("Cache-Control", "no-cache")
+	pw := newPktLineWriter(w)
+	pw.WriteString("# service=git-upload-pack\n")
+	pw.Flush()
+	if len(refs) == 0 {
+		pw.Writef("%s capabilities^{}\x00%s\n", objects.ObjectID{}, strings.Join(capabilities, " "))
+	}
+	for i, ref := range refs {
+		if i == 0 {
+			pw.Writef("%s %s\x00%s\n", ref.ID, ref.Name, strings.Join(capabilities, " "))
+			continue
+		}
+		pw.Writef("%s %s\n", ref.ID, ref.Name)
+	}
+	pw.Flush()
+}
+
+// uploadPack answers a fetch with the bundle's pack. The client was
+// offered no multi_ack, so it sends a single request ending in done.
+func (s *BundleServer) uploadPack(w http.ResponseWriter, r *http.Request) {
+	body, err := requestBody(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer body.Close()
+	io.Copy(io.Discard, body)
+
+	w.Header().Set("Content-Type", "application/x-git-upload-pack-result")
+	w.Header().Set("Cache-Control", "no-cache")
+	pw := newPktLineWriter(w)
+
+	pack, err := s.bundle.Pack()
+	if err != nil {
+		pw.Writef("ERR %v\n", err)
+		return
+	}
+	defer pack.Close()
+
+	pw.WriteString("NAK\n")
+	io.Copy(w, pack)
+}
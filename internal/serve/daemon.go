@@ -0,0 +1,249 @@
+package serve
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// DefaultDaemonPort is the port of git:// URLs that name none
+const DefaultDaemonPort = 9418
+
+// ExportOKFile, in a repository's directory, lets a Daemon serve it
+const ExportOKFile = "git-daemon-export-ok"
+
+// requestTimeout bounds the wait for the request that opens a connection
+const requestTimeout = 10 * time.Second
+
+// DaemonOptions controls which repositories a Daemon serves and how many
+// clients it serves at once
+type DaemonOptions struct {
+	// ExportAll serves every repository, not only those with ExportOKFile
+	ExportAll bool
+	// MaxConnections is the most clients served at once, zero for no
+	// limit
+	MaxConnections int
+	// Timeout closes connections idle for that long, zero for never
+	Timeout time.Duration
+	// Log, when set, receives a line for each request and each failure
+	Log io.Writer
+}
+
+// DefaultDaemonOptions returns the options of git daemon
+func DefaultDaemonOptions() DaemonOptions {
+	return DaemonOptions{MaxConnections: 32}
+}
+
+// Daemon serves the repositories under a directory over the git://
+// protocol, as git daemon does. A connection starts with a request naming
+// the service and the repository, after which the service speaks the same
+// protocol as over HTTP, but with the negotiation held on one connection
+// rather than spread over requests.
+type Daemon struct {
+	root string
+	open OpenFunc
+	opts DaemonOptions
+}
+
+// NewDaemon creates a daemon for the repositories under root, opening the
+// one each connection is for with open
+func NewDaemon(root string, open OpenFunc, opts DaemonOptions) *Daemon {
+	return &Daemon{root: root, open: open, opts: opts}
+}
+
+// Serve accepts connections on listener until ctx is done, then waits a
+// few seconds for the connections in progress before closing them
+func (d *Daemon) Serve(ctx context.Context, listener net.Listener) error {
+	var slots chan struct{}
+	if d.opts.MaxConnections > 0 {
+		slots = make(chan struct{}, d.opts.MaxConnections)
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	conns := make(map[net.Conn]bool)
+
+	go func() {
+		<-ctx.Done()
+		listener.Close()
+	}()
+
+	var serveErr error
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if ctx.Err() == nil {
+				serveErr = fmt.Errorf("failed to accept connection: %w", err)
+			}
+			break
+		}
+
+		if slots != nil {
+			select {
+			case slots <- struct{}{}:
+			default:
+				d.logf("%s: refused, too many connections", conn.RemoteAddr())
+				go refuse(conn, "too many connections, try again later")
+				continue
+			}
+		}
+
+		mu.Lock()
+		conns[conn] = true
+		mu.Unlock()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			d.handle(conn)
+			conn.Close()
+			mu.Lock()
+			delete(conns, conn)
+			mu.Unlock()
+			if slots != nil {
+				<-slots
+			}
+		}()
+	}
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		mu.Lock()
+		for conn := range conns {
+			conn.Close()
+		}
+		mu.Unlock()
+		<-done
+	}
+	return serveErr
+}
+
+// refuse tells the client on conn why it is not served and hangs up
+func refuse(conn net.Conn, reason string) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(requestTimeout))
+	newPktLineWriter(conn).Writef("ERR %s\n", reason)
+}
+
+// handle serves the request that opens conn
+func (d *Daemon) handle(conn net.Conn) {
+	addr := conn.RemoteAddr()
+	// The request has to come soon whatever the idle timeout
+	stream := &idleConn{Conn: conn, timeout: requestTimeout}
+	pr := newPktLineReader(stream)
+	line, err := pr.ReadLine()
+	if err != nil {
+		d.logf("%s: failed to read request: %v", addr, err)
+		return
+	}
+	service, repoPath, err := parseDaemonRequest(line)
+	if err != nil {
+		d.logf("%s: %v", addr, err)
+		newPktLineWriter(stream).Writef("ERR %v\n", err)
+		return
+	}
+	d.logf("%s: %s %s", addr, service, repoPath)
+	stream.timeout = d.opts.Timeout
+	conn.SetDeadline(time.Time{})
+
+	server, err := d.lookup(repoPath)
+	if err == nil && service == "git-receive-pack" && !server.push {
+		err = fmt.Errorf("receive-pack is not enabled for %s", repoPath)
+	}
+	if err != nil {
+		d.logf("%s: %v", addr, err)
+		newPktLineWriter(stream).Writef("ERR %v\n", err)
+		return
+	}
+
+	if service == "git-upload-pack" {
+		err = server.uploadPackStream(pr, stream)
+	} else {
+		err = server.receivePackStream(pr, stream)
+	}
+	if err != nil {
+		d.logf("%s: %s %s failed: %v", addr, service, repoPath, err)
+	}
+}
+
+// lookup returns the server for the repository at repoPath when it is
+// exported. Unexported repositories are reported like missing ones, so
+// clients cannot tell which exist.
+func (d *Daemon) lookup(repoPath string) (*Server, error) {
+	denied := fmt.Errorf("access denied or repository not exported: %s", repoPath)
+	cleaned, ok := cleanRepositoryPath(repoPath)
+	if !ok {
+		return nil, denied
+	}
+	server, err := lookupRepository(d.root, cleaned, d.open)
+	if errors.Is(err, ErrNotRepository) {
+		return nil, denied
+	}
+	if err != nil {
+		return nil, err
+	}
+	if !d.opts.ExportAll {
+		if _, err := os.Stat(filepath.Join(server.gitDir, ExportOKFile)); err != nil {
+			return nil, denied
+		}
+	}
+	return server, nil
+}
+
+func (d *Daemon) logf(format string, args ...interface{}) {
+	if d.opts.Log != nil {
+		fmt.Fprintf(d.opts.Log, format+"\n", args...)
+	}
+}
+
+// parseDaemonRequest reads the service and repository path from the line
+// opening a connection: "git-upload-pack /path\0host=example.com\0", where
+// everything after the path is optional
+func parseDaemonRequest(line string) (string, string, error) {
+	request, _, _ := strings.Cut(line, "\x00")
+	service, repoPath, ok := strings.Cut(strings.TrimSuffix(request, "\n"), " ")
+	if !ok || repoPath == "" {
+		return "", "", fmt.Errorf("malformed request")
+	}
+	switch service {
+	case "git-upload-pack", "git-receive-pack":
+		return service, repoPath, nil
+	default:
+		return "", "", fmt.Errorf("service not enabled: %s", service)
+	}
+}
+
+// idleConn is a connection that times out once idle for timeout, rather
+// than at a fixed time
+type idleConn struct {
+	net.Conn
+	timeout time.Duration
+}
+
+func (c *idleConn) extend() {
+	if c.timeout > 0 {
+		c.Conn.SetDeadline(time.Now().Add(c.timeout))
+	}
+}
+
+func (c *idleConn) Read(p []byte) (int, error) {
+	c.extend()
+	return c.Conn.Read(p)
+}
+
+func (c *idleConn) Write(p []byte) (int, error) {
+	c.extend()
+	return c.Conn.Write(p)
+}
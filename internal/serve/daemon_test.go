@@ -0,0 +1,189 @@
+package serve
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/packfile"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/internal/transport"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+func TestParseDaemonRequest(t *testing.T) {
+	tests := []struct {
+		line    string
+		service string
+		path    string
+		err     string
+	}{
+		{"git-upload-pack /project.git\x00host=example.com\x00", "git-upload-pack", "/project.git", ""},
+		{"git-receive-pack /team/project\n", "git-receive-pack", "/team/project", ""},
+		{"git-upload-pack /p\x00host=h\x00\x00version=2\x00", "git-upload-pack", "/p", ""},
+		{"git-upload-archive /project", "", "", "service not enabled"},
+		{"git-upload-pack", "", "", "malformed request"},
+	}
+	for _, tt := range tests {
+		service, path, err := parseDaemonRequest(tt.line)
+		if tt.err != "" {
+			assert.ErrorContains(t, err, tt.err, tt.line)
+			continue
+		}
+		require.NoError(t, err, tt.line)
+		assert.Equal(t, tt.service, service)
+		assert.Equal(t, tt.path, path)
+	}
+}
+
+// startDaemon serves the repository at root/project.git with a daemon and
+// returns its address
+func startDaemon(t *testing.T, opts DaemonOptions, push bool) (*vcs.Repository, string) {
+	root := t.TempDir()
+	repo, err := vcs.Init(filepath.Join(root, "project.git"))
+	require.NoError(t, err)
+	require.NoError(t, refs.NewRefManager(repo.GitDir()).SetHEAD("refs/heads/work"))
+
+	daemon := NewDaemon(root, func(dir string) (*Server, error) {
+		if dir != repo.Path() {
+			return nil, ErrNotRepository
+		}
+		s := NewServer(repo.GitDir(), repo.Storage())
+		if push {
+			s.AllowPush(Policy{})
+		}
+		return s, nil
+	}, opts)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	served := make(chan error, 1)
+	go func() { served <- daemon.Serve(ctx, listener) }()
+	t.Cleanup(func() {
+		cancel()
+		select {
+		case err := <-served:
+			assert.NoError(t, err)
+		case <-time.After(10 * time.Second):
+			t.Error("daemon did not stop")
+		}
+	})
+	return repo, listener.Addr().String()
+}
+
+// request connects to addr, sends a request for service on repoPath and
+// returns the connection and the first line of the answer
+func request(t *testing.T, addr, service, repoPath string) (net.Conn, *transport.PktLineReader, string) {
+	conn, err := net.Dial("tcp", addr)
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+	require.NoError(t, transport.NewPktLineWriter(conn).Writef("%s %s\x00host=localhost\x00", service, repoPath))
+
+	pr := transport.NewPktLineReader(conn)
+	line, err := pr.ReadLine()
+	require.NoError(t, err)
+	return conn, pr, line
+}
+
+// readAdvertisement reads the rest of a ref advertisement
+func readAdvertisement(t *testing.T, pr *transport.PktLineReader) {
+	for {
+		_, err := pr.ReadLine()
+		if err == transport.ErrFlushPkt {
+			return
+		}
+		require.NoError(t, err)
+	}
+}
+
+func TestDaemon_Fetch(t *testing.T) {
+	repo, addr := startDaemon(t, DefaultDaemonOptions(), false)
+	first := commitFile(t, repo, "a.txt", "one\n")
+	second := commitFile(t, repo, "a.txt", "two\n", first)
+
+	// Without the export-ok file the repository is not served
+	_, _, line := request(t, addr, "git-upload-pack", "/project")
+	assert.Equal(t, "ERR access denied or repository not exported: /project", line)
+	require.NoError(t, os.WriteFile(filepath.Join(repo.GitDir(), ExportOKFile), nil, 0644))
+
+	conn, pr, line := request(t, addr, "git-upload-pack", "/project")
+	assert.True(t, strings.HasPrefix(line, second.String()+" refs/heads/main\x00"), line)
+	readAdvertisement(t, pr)
+
+	// Without multi_ack only the first common have is acknowledged, and a
+	// round with none is answered with NAK
+	pw := transport.NewPktLineWriter(conn)
+	pw.Writef("want %s ofs-delta\n", second)
+	pw.Flush()
+	pw.Writef("have %s\n", strings.Repeat("1", 40))
+	pw.Flush()
+	pw.Writef("have %s\n", first)
+	pw.Flush()
+	pw.WriteString("done\n")
+
+	line, err := pr.ReadLine()
+	require.NoError(t, err)
+	assert.Equal(t, "NAK", line)
+	line, err = pr.ReadLine()
+	require.NoError(t, err)
+	assert.Equal(t, "ACK "+first.String(), line)
+
+	dst := objects.NewStorage(t.TempDir())
+	require.NoError(t, dst.Init())
+	result, err := packfile.Unpack(pr.Reader(), dst)
+	require.NoError(t, err)
+	assert.Len(t, result.Objects, 3, "commit, tree and blob of the second commit")
+	assert.NotContains(t, result.Objects, first)
+}
+
+func TestDaemon_Push(t *testing.T) {
+	repo, addr := startDaemon(t, DaemonOptions{ExportAll: true}, true)
+	first := commitFile(t, repo, "a.txt", "one\n")
+
+	conn, pr, _ := request(t, addr, "git-receive-pack", "/project.git")
+	readAdvertisement(t, pr)
+	pw := transport.NewPktLineWriter(conn)
+	pw.Writef("%s %s refs/heads/main\x00report-status\n", first, objects.ObjectID{})
+	pw.Flush()
+
+	line, err := pr.ReadLine()
+	require.NoError(t, err)
+	assert.Equal(t, "unpack ok", line)
+	line, err = pr.ReadLine()
+	require.NoError(t, err)
+	assert.Equal(t, "ok refs/heads/main", line)
+	_, err = refs.NewRefManager(repo.GitDir()).ResolveRef("refs/heads/main")
+	assert.Error(t, err, "main was deleted")
+}
+
+func TestDaemon_ReadOnly(t *testing.T) {
+	_, addr := startDaemon(t, DaemonOptions{ExportAll: true}, false)
+
+	_, _, line := request(t, addr, "git-receive-pack", "/project")
+	assert.Equal(t, "ERR receive-pack is not enabled for /project", line)
+	_, _, line = request(t, addr, "git-upload-pack", "/../project.git/.")
+	assert.True(t, strings.HasPrefix(line, objects.ObjectID{}.String()+" capabilities^{}\x00"), line)
+	_, _, line = request(t, addr, "git-upload-pack", "/missing")
+	assert.Equal(t, "ERR access denied or repository not exported: /missing", line)
+}
+
+func TestDaemon_MaxConnections(t *testing.T) {
+	_, addr := startDaemon(t, DaemonOptions{ExportAll: true, MaxConnections: 1}, false)
+
+	// The first client holds the only slot while it negotiates
+	_, pr, _ := request(t, addr, "git-upload-pack", "/project")
+	readAdvertisement(t, pr)
+
+	_, _, line := request(t, addr, "git-upload-pack", "/project")
+	assert.Equal(t, "ERR too many connections, try again later", line)
+}
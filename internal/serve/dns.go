@@ -0,0 +1,295 @@
+package serve
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// DNS record types used by service discovery
+const (
+	typeA    = 1
+	typePTR  = 12
+	typeTXT  = 16
+	typeAAAA = 28
+	typeSRV  = 33
+	typeANY  = 255
+
+	classIN = 1
+	// classCacheFlush marks a record as the complete set for its name (RFC
+	// 6762 section 10.2). In questions the same bit asks for a unicast reply.
+	classCacheFlush = 0x8000
+
+	flagResponse      = 0x8000
+	flagAuthoritative = 0x0400
+)
+
+// dnsQuestion is an entry of the question section
+type dnsQuestion struct {
+	name  string
+	qtype uint16
+}
+
+// dnsRecord is a resource record with its data decoded by type
+type dnsRecord struct {
+	name  string
+	rtype uint16
+	ttl   uint32
+
+	target string   // PTR and SRV
+	port   uint16   // SRV
+	ip     net.IP   // A and AAAA
+	txt    []string // TXT
+}
+
+// dnsMessage is a DNS message as used by multicast DNS. Authority records
+// are read but dropped, since service discovery does not use them.
+type dnsMessage struct {
+	id        uint16
+	response  bool
+	questions []dnsQuestion
+	answers   []dnsRecord
+	extra     []dnsRecord
+}
+
+// pack encodes the message. Names are written without compression.
+func (m *dnsMessage) pack() ([]byte, error) {
+	var flags uint16
+	if m.response {
+		flags = flagResponse | flagAuthoritative
+	}
+
+	buf := make([]byte, 12, 512)
+	binary.BigEndian.PutUint16(buf[0:], m.id)
+	binary.BigEndian.PutUint16(buf[2:], flags)
+	binary.BigEndian.PutUint16(buf[4:], uint16(len(m.questions)))
+	binary.BigEndian.PutUint16(buf[6:], uint16(len(m.answers)))
+	binary.BigEndian.PutUint16(buf[10:], uint16(len(m.extra)))
+
+	var err error
+	for _, q := range m.questions {
+		if buf, err = appendName(buf, q.name); err != nil {
+			return nil, err
+		}
+		buf = binary.BigEndian.AppendUint16(buf, q.qtype)
+		buf = binary.BigEndian.AppendUint16(buf, classIN)
+	}
+	for _, records := range [][]dnsRecord{m.answers, m.extra} {
+		for _, rr := range records {
+			if buf, err = appendRecord(buf, rr); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return buf, nil
+}
+
+// appendRecord encodes a resource record
+func appendRecord(buf []byte, rr dnsRecord) ([]byte, error) {
+	var data []byte
+	var err error
+	switch rr.rtype {
+	case typeA:
+		if data = rr.ip.To4(); data == nil {
+			return nil, fmt.Errorf("%s is not an IPv4 address", rr.ip)
+		}
+	case typeAAAA:
+		data = rr.ip.To16()
+	case typePTR:
+		data, err = appendName(nil, rr.target)
+	case typeSRV:
+		// Priority and weight are unused: there is only one target
+		data = make([]byte, 6)
+		binary.BigEndian.PutUint16(data[4:], rr.port)
+		data, err = appendName(data, rr.target)
+	case typeTXT:
+		for _, s := range rr.txt {
+			if len(s) > 255 {
+				return nil, fmt.Errorf("TXT string too long: %q", s)
+			}
+			data = append(append(data, byte(len(s))), s...)
+		}
+		if len(data) == 0 {
+			data = []byte{0}
+		}
+	default:
+		return nil, fmt.Errorf("unsupported record type %d", rr.rtype)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// Everything a responder sends is unique to it
+	if buf, err = appendName(buf, rr.name); err != nil {
+		return nil, err
+	}
+	buf = binary.BigEndian.AppendUint16(buf, rr.rtype)
+	class := uint16(classIN)
+	if rr.rtype != typePTR {
+		class |= classCacheFlush
+	}
+	buf = binary.BigEndian.AppendUint16(buf, class)
+	buf = binary.BigEndian.AppendUint32(buf, rr.ttl)
+	buf = binary.BigEndian.AppendUint16(buf, uint16(len(data)))
+	return append(buf, data...), nil
+}
+
+// appendName encodes a domain name such as "host.local." as labels
+func appendName(buf []byte, name string) ([]byte, error) {
+	name = strings.TrimSuffix(name, ".")
+	if name != "" {
+		for _, label := range strings.Split(name, ".") {
+			if label == "" || len(label) > 63 {
+				return nil, fmt.Errorf("invalid DNS name %q", name)
+			}
+			buf = append(append(buf, byte(len(label))), label...)
+		}
+	}
+	return append(buf, 0), nil
+}
+
+// parseDNSMessage decodes a DNS message
+func parseDNSMessage(data []byte) (*dnsMessage, error) {
+	if len(data) < 12 {
+		return nil, fmt.Errorf("DNS message too short")
+	}
+
+	m := &dnsMessage{
+		id:       binary.BigEndian.Uint16(data[0:]),
+		response: binary.BigEndian.Uint16(data[2:])&flagResponse != 0,
+	}
+	counts := [4]int{}
+	for i := range counts {
+		counts[i] = int(binary.BigEndian.Uint16(data[4+2*i:]))
+	}
+
+	off := 12
+	for i := 0; i < counts[0]; i++ {
+		name, next, err := readName(data, off)
+		if err != nil {
+			return nil, err
+		}
+		if next+4 > len(data) {
+			return nil, fmt.Errorf("truncated DNS question")
+		}
+		m.questions = append(m.questions, dnsQuestion{name: name, qtype: binary.BigEndian.Uint16(data[next:])})
+		off = next + 4
+	}
+
+	for section := 1; section < 4; section++ {
+		for i := 0; i < counts[section]; i++ {
+			rr, next, err := readRecord(data, off)
+			if err != nil {
+				return nil, err
+			}
+			off = next
+			switch section {
+			case 1:
+				m.answers = append(m.answers, rr)
+			case 3:
+				m.extra = append(m.extra, rr)
+			}
+		}
+	}
+	return m, nil
+}
+
+// readRecord decodes the resource record at off and returns the offset
+// following it
+func readRecord(data []byte, off int) (dnsRecord, int, error) {
+	var rr dnsRecord
+	name, off, err := readName(data, off)
+	if err != nil {
+		return rr, 0, err
+	}
+	if off+10 > len(data) {
+		return rr, 0, fmt.Errorf("truncated DNS record")
+	}
+	rr.name = name
+	rr.rtype = binary.BigEndian.Uint16(data[off:])
+	rr.ttl = binary.BigEndian.Uint32(data[off+4:])
+	length := int(binary.BigEndian.Uint16(data[off+8:]))
+	start, end := off+10, off+10+length
+	if end > len(data) {
+		return rr, 0, fmt.Errorf("truncated DNS record data")
+	}
+	rdata := data[start:end]
+
+	switch rr.rtype {
+	case typeA, typeAAAA:
+		if len(rdata) != net.IPv4len && len(rdata) != net.IPv6len {
+			return rr, 0, fmt.Errorf("invalid address record for %s", name)
+		}
+		rr.ip = append(net.IP(nil), rdata...)
+	case typePTR:
+		if rr.target, _, err = readName(data, start); err != nil {
+			return rr, 0, err
+		}
+	case typeSRV:
+		if length < 7 {
+			return rr, 0, fmt.Errorf("invalid SRV record for %s", name)
+		}
+		rr.port = binary.BigEndian.Uint16(rdata[4:])
+		if rr.target, _, err = readName(data, start+6); err != nil {
+			return rr, 0, err
+		}
+	case typeTXT:
+		for i := 0; i < len(rdata); {
+			n := int(rdata[i])
+			if i+1+n > len(rdata) {
+				return rr, 0, fmt.Errorf("invalid TXT record for %s", name)
+			}
+			if n > 0 {
+				rr.txt = append(rr.txt, string(rdata[i+1:i+1+n]))
+			}
+			i += 1 + n
+		}
+	}
+	return rr, end, nil
+}
+
+// readName decodes the possibly compressed name at off and returns it with
+// a trailing dot, along with the offset following it
+func readName(data []byte, off int) (string, int, error) {
+	var labels []string
+	next := -1
+	for jumps := 0; ; {
+		if off >= len(data) {
+			return "", 0, fmt.Errorf("truncated DNS name")
+		}
+		n := int(data[off])
+		switch {
+		case n == 0:
+			if next < 0 {
+				next = off + 1
+			}
+			return strings.Join(labels, ".") + ".", next, nil
+		case n&0xc0 == 0xc0:
+			if off+1 >= len(data) {
+				return "", 0, fmt.Errorf("truncated DNS name")
+			}
+			if jumps++; jumps > 16 {
+				return "", 0, fmt.Errorf("DNS name compression loop")
+			}
+			if next < 0 {
+				next = off + 2
+			}
+			off = int(binary.BigEndian.Uint16(data[off:]) & 0x3fff)
+		case n > 63:
+			return "", 0, fmt.Errorf("invalid DNS label length %d", n)
+		default:
+			if off+1+n > len(data) {
+				return "", 0, fmt.Errorf("truncated DNS name")
+			}
+			labels = append(labels, string(data[off+1:off+1+n]))
+			off += 1 + n
+		}
+	}
+}
+
+// sameName compares domain names the way DNS does, ignoring case and the
+// trailing dot
+func sameName(a, b string) bool {
+	return strings.EqualFold(strings.TrimSuffix(a, "."), strings.TrimSuffix(b, "."))
+}
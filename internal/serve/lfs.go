@@ -0,0 +1,122 @@
+package serve
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+
+	"github.com/fenilsonani/vcs/internal/lfs"
+)
+
+// lfsPrefix is where a repository's URL continues for its LFS endpoint
+const lfsPrefix = "/info/lfs/"
+
+// serveLFS serves the Git LFS batch API and the basic transfers it hands
+// out, downloading from the repository's LFS store and, when pushes are
+// allowed, uploading to it
+func (s *Server) serveLFS(w http.ResponseWriter, r *http.Request) {
+	i := strings.LastIndex(r.URL.Path, lfsPrefix)
+	base, rest := r.URL.Path[:i+len(lfsPrefix)], r.URL.Path[i+len(lfsPrefix):]
+	store := lfs.NewStore(s.gitDir)
+
+	switch {
+	case rest == "objects/batch" && r.Method == http.MethodPost:
+		s.lfsBatch(w, r, store, base)
+	case strings.HasPrefix(rest, "objects/") && lfs.ValidOid(rest[len("objects/"):]):
+		oid := rest[len("objects/"):]
+		switch {
+		case r.Method == http.MethodGet:
+			s.lfsDownload(w, store, oid)
+		case r.Method == http.MethodPut && s.push:
+			s.lfsUpload(w, r, store, oid)
+		case r.Method == http.MethodPut:
+			lfsError(w, http.StatusForbidden, "this repository is read-only")
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		}
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// lfsBatch answers a batch request with an action for each object that can
+// be transferred: a download for objects the store has, and an upload for
+// those it lacks
+func (s *Server) lfsBatch(w http.ResponseWriter, r *http.Request, store *lfs.Store, base string) {
+	var req lfs.BatchRequest
+	if err := json.NewDecoder(io.LimitReader(r.Body, 16<<20)).Decode(&req); err != nil {
+		lfsError(w, http.StatusUnprocessableEntity, "invalid batch request")
+		return
+	}
+	switch req.Operation {
+	case lfs.Download:
+	case lfs.Upload:
+		if !s.push {
+			lfsError(w, http.StatusForbidden, "this repository is read-only")
+			return
+		}
+	default:
+		lfsError(w, http.StatusUnprocessableEntity, fmt.Sprintf("unknown operation %q", req.Operation))
+		return
+	}
+
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	href := func(oid string) string {
+		return scheme + "://" + r.Host + base + "objects/" + oid
+	}
+
+	resp := lfs.BatchResponse{Transfer: "basic", Objects: []lfs.BatchObject{}}
+	for _, p := range req.Objects {
+		obj := lfs.BatchObject{Pointer: p}
+		switch {
+		case !lfs.ValidOid(p.Oid) || p.Size < 0:
+			obj.Error = &lfs.ObjectError{Code: http.StatusUnprocessableEntity, Message: "invalid object"}
+		case req.Operation == lfs.Download && !store.Has(p):
+			obj.Error = &lfs.ObjectError{Code: http.StatusNotFound, Message: "object does not exist"}
+		case req.Operation == lfs.Download:
+			obj.Actions = map[string]*lfs.Action{lfs.Download: {Href: href(p.Oid)}}
+		case !store.Has(p):
+			obj.Actions = map[string]*lfs.Action{lfs.Upload: {Href: href(p.Oid)}}
+		}
+		resp.Objects = append(resp.Objects, obj)
+	}
+
+	w.Header().Set("Content-Type", lfs.MediaType)
+	json.NewEncoder(w).Encode(&resp)
+}
+
+func (s *Server) lfsDownload(w http.ResponseWriter, store *lfs.Store, oid string) {
+	file, err := store.Open(lfs.Pointer{Oid: oid})
+	if err != nil {
+		lfsError(w, http.StatusNotFound, "object does not exist")
+		return
+	}
+	defer file.Close()
+	w.Header().Set("Content-Type", "application/octet-stream")
+	io.Copy(w, file)
+}
+
+// lfsUpload stores an uploaded object, which must match its oid
+func (s *Server) lfsUpload(w http.ResponseWriter, r *http.Request, store *lfs.Store, oid string) {
+	if r.ContentLength < 0 {
+		lfsError(w, http.StatusLengthRequired, "uploads need a Content-Length")
+		return
+	}
+	if err := store.Receive(lfs.Pointer{Oid: oid, Size: r.ContentLength}, r.Body); err != nil {
+		lfsError(w, http.StatusUnprocessableEntity, err.Error())
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// lfsError writes an error response in the form Git LFS clients show
+func lfsError(w http.ResponseWriter, status int, message string) {
+	w.Header().Set("Content-Type", lfs.MediaType)
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(map[string]string{"message": message})
+}
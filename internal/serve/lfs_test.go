@@ -0,0 +1,97 @@
+package serve
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/lfs"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// newLFSServer serves a new repository, accepting pushes when push is set,
+// and returns its LFS store and endpoint
+func newLFSServer(t *testing.T, push bool) (*lfs.Store, string) {
+	repo, err := vcs.Init(filepath.Join(t.TempDir(), "src"))
+	require.NoError(t, err)
+
+	s := NewServer(repo.GitDir(), repo.Storage())
+	if push {
+		s.AllowPush(Policy{})
+	}
+	server := httptest.NewServer(s)
+	t.Cleanup(server.Close)
+	return lfs.NewStore(repo.GitDir()), lfs.Endpoint(server.URL + "/repo")
+}
+
+func TestLFSUploadAndDownload(t *testing.T) {
+	remote, endpoint := newLFSServer(t, true)
+	client := lfs.NewClient(endpoint)
+	ctx := context.Background()
+
+	local := lfs.NewStore(t.TempDir())
+	content := bytes.Repeat([]byte("big"), 1000)
+	p, err := local.Write(content)
+	require.NoError(t, err)
+
+	// Nothing to download before the upload
+	resp, err := client.Batch(ctx, lfs.Download, []lfs.Pointer{p})
+	require.NoError(t, err)
+	require.Len(t, resp.Objects, 1)
+	require.NotNil(t, resp.Objects[0].Error)
+	assert.Equal(t, http.StatusNotFound, resp.Objects[0].Error.Code)
+
+	resp, err = client.Batch(ctx, lfs.Upload, []lfs.Pointer{p})
+	require.NoError(t, err)
+	require.Len(t, resp.Objects, 1)
+	require.NoError(t, client.Upload(ctx, local, resp.Objects[0]))
+	assert.True(t, remote.Has(p))
+
+	// The server has it now, so there is nothing more to upload
+	resp, err = client.Batch(ctx, lfs.Upload, []lfs.Pointer{p})
+	require.NoError(t, err)
+	assert.Nil(t, resp.Objects[0].Actions[lfs.Upload])
+
+	fresh := lfs.NewStore(t.TempDir())
+	resp, err = client.Batch(ctx, lfs.Download, []lfs.Pointer{p})
+	require.NoError(t, err)
+	require.NoError(t, client.Download(ctx, fresh, resp.Objects[0]))
+	data, err := fresh.Read(p)
+	require.NoError(t, err)
+	assert.Equal(t, content, data)
+}
+
+func TestLFSReadOnly(t *testing.T) {
+	_, endpoint := newLFSServer(t, false)
+	p := lfs.NewPointer([]byte("content"))
+
+	_, err := lfs.NewClient(endpoint).Batch(context.Background(), lfs.Upload, []lfs.Pointer{p})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "read-only")
+
+	req, err := http.NewRequest(http.MethodPut, endpoint+"/objects/"+p.Oid, bytes.NewReader([]byte("content")))
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
+
+func TestLFSUploadRejectsMismatchedContent(t *testing.T) {
+	remote, endpoint := newLFSServer(t, true)
+	p := lfs.NewPointer([]byte("content"))
+
+	req, err := http.NewRequest(http.MethodPut, endpoint+"/objects/"+p.Oid, bytes.NewReader([]byte("CONTENT")))
+	require.NoError(t, err)
+	resp, err := http.DefaultClient.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusUnprocessableEntity, resp.StatusCode)
+	assert.False(t, remote.Has(p))
+}
@@ -0,0 +1,415 @@
+package serve
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// ServiceType is the DNS-SD service shared repositories are advertised as
+	ServiceType = "_vcs._tcp.local."
+	// URLScheme starts the URL of a repository shared on the local network,
+	// as in vcs-local://name
+	URLScheme = "vcs-local://"
+
+	// serviceEnumeration lists the service types on a network (RFC 6763
+	// section 9)
+	serviceEnumeration = "_services._dns-sd._udp.local."
+
+	mdnsPort = 5353
+	// recordTTL is how long multicast answers may be cached
+	recordTTL = 120
+	// legacyTTL caps the TTL of answers sent directly to simple resolvers
+	// (RFC 6762 section 6.7)
+	legacyTTL = 10
+)
+
+var (
+	mdnsGroupIPv4 = &net.UDPAddr{IP: net.IPv4(224, 0, 0, 251), Port: mdnsPort}
+	mdnsGroupIPv6 = &net.UDPAddr{IP: net.ParseIP("ff02::fb"), Port: mdnsPort}
+)
+
+// ShareName turns s, such as a directory name, into a name a repository
+// can be shared as: lowercase letters, digits and dashes, as in a host name
+func ShareName(s string) string {
+	var b strings.Builder
+	dash := false
+	for _, r := range strings.ToLower(s) {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteByte('-')
+			dash = true
+		}
+	}
+
+	name := strings.TrimRight(b.String(), "-")
+	if len(name) > 63 {
+		name = strings.TrimRight(name[:63], "-")
+	}
+	if name == "" {
+		return "repo"
+	}
+	return name
+}
+
+// ValidateShareName checks that name can be used as a share name
+func ValidateShareName(name string) error {
+	if name == "" || ShareName(name) != name {
+		return fmt.Errorf("invalid share name %q: use lowercase letters, digits and dashes", name)
+	}
+	return nil
+}
+
+// ParseURL returns the share name of a vcs-local:// URL
+func ParseURL(url string) (string, bool) {
+	if !strings.HasPrefix(url, URLScheme) {
+		return "", false
+	}
+	name := strings.TrimSuffix(strings.TrimSuffix(url[len(URLScheme):], "/"), ".git")
+	return name, ValidateShareName(name) == nil
+}
+
+// Share is a repository found on the local network
+type Share struct {
+	Name string
+	Addr *net.TCPAddr
+}
+
+// URL returns the HTTP URL the repository is served at
+func (s *Share) URL() string {
+	host := s.Addr.IP.String()
+	if s.Addr.Zone != "" {
+		host += "%25" + s.Addr.Zone
+	}
+	return "http://" + net.JoinHostPort(host, strconv.Itoa(s.Addr.Port))
+}
+
+// Responder answers multicast DNS queries for a repository shared on the
+// local network, making it resolvable by name without any configuration
+type Responder struct {
+	name  string
+	host  string
+	port  int
+	addrs []net.IP
+}
+
+// NewResponder creates a responder advertising the repository shared as
+// name and served on port, under this machine's addresses
+func NewResponder(name string, port int) (*Responder, error) {
+	if err := ValidateShareName(name); err != nil {
+		return nil, err
+	}
+
+	hostname, err := os.Hostname()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get host name: %w", err)
+	}
+	hostname, _, _ = strings.Cut(hostname, ".")
+
+	addrs, err := localAddrs()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Responder{
+		name:  name,
+		host:  ShareName(hostname) + ".local.",
+		port:  port,
+		addrs: addrs,
+	}, nil
+}
+
+// localAddrs returns the addresses other machines can reach this one at,
+// or the loopback addresses when there are none
+func localAddrs() ([]net.IP, error) {
+	ifaceAddrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list network addresses: %w", err)
+	}
+
+	var addrs, loopback []net.IP
+	for _, addr := range ifaceAddrs {
+		ipNet, ok := addr.(*net.IPNet)
+		if !ok {
+			continue
+		}
+		if ipNet.IP.IsLoopback() {
+			loopback = append(loopback, ipNet.IP)
+		} else if ipNet.IP.IsGlobalUnicast() || ipNet.IP.IsLinkLocalUnicast() {
+			addrs = append(addrs, ipNet.IP)
+		}
+	}
+	if len(addrs) == 0 {
+		return loopback, nil
+	}
+	return addrs, nil
+}
+
+// instance returns the DNS-SD instance name of the share
+func (r *Responder) instance() string {
+	return r.name + "." + ServiceType
+}
+
+// Serve answers queries on the IPv4 and IPv6 mDNS groups until ctx is
+// done. It fails only when neither group can be joined.
+func (r *Responder) Serve(ctx context.Context) error {
+	var conns []*net.UDPConn
+	var groups []*net.UDPAddr
+	var lastErr error
+	for _, group := range []*net.UDPAddr{mdnsGroupIPv4, mdnsGroupIPv6} {
+		conn, err := net.ListenMulticastUDP(udpNetwork(group), nil, group)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		conns = append(conns, conn)
+		groups = append(groups, group)
+	}
+	if len(conns) == 0 {
+		return fmt.Errorf("failed to join the mDNS group: %w", lastErr)
+	}
+
+	var wg sync.WaitGroup
+	for i, conn := range conns {
+		// Announce the share so browsers already listening see it
+		if announcement, err := r.announcement().pack(); err == nil {
+			conn.WriteTo(announcement, groups[i])
+		}
+
+		wg.Add(1)
+		go func(conn *net.UDPConn, group *net.UDPAddr) {
+			defer wg.Done()
+			r.serve(conn, group)
+		}(conn, groups[i])
+	}
+
+	<-ctx.Done()
+	for _, conn := range conns {
+		conn.Close()
+	}
+	wg.Wait()
+	return nil
+}
+
+// serve answers the queries arriving on conn until it is closed. Answers go
+// to group, except for queries from simple resolvers, which are answered
+// directly, as are all queries when group is nil.
+func (r *Responder) serve(conn net.PacketConn, group net.Addr) {
+	buf := make([]byte, 9000)
+	for {
+		n, from, err := conn.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+
+		query, err := parseDNSMessage(buf[:n])
+		if err != nil || query.response {
+			continue
+		}
+
+		// Queries not sent from the mDNS port come from resolvers that
+		// expect a unicast answer (RFC 6762 section 6.7)
+		udp, _ := from.(*net.UDPAddr)
+		legacy := group == nil || udp == nil || udp.Port != mdnsPort
+
+		resp := r.answer(query, legacy)
+		if resp == nil {
+			continue
+		}
+		data, err := resp.pack()
+		if err != nil {
+			continue
+		}
+		if legacy {
+			conn.WriteTo(data, from)
+		} else {
+			conn.WriteTo(data, group)
+		}
+	}
+}
+
+// answer builds the response to query, or nil when it asks about nothing
+// this responder owns. Legacy answers echo the query as resolvers expect.
+func (r *Responder) answer(query *dnsMessage, legacy bool) *dnsMessage {
+	ttl := uint32(recordTTL)
+	if legacy {
+		ttl = legacyTTL
+	}
+
+	var service, enumeration, instance, host bool
+	for _, q := range query.questions {
+		anyType := q.qtype == typeANY
+		switch {
+		case sameName(q.name, ServiceType) && (q.qtype == typePTR || anyType):
+			service = true
+		case sameName(q.name, serviceEnumeration) && (q.qtype == typePTR || anyType):
+			enumeration = true
+		case sameName(q.name, r.instance()) && (q.qtype == typeSRV || q.qtype == typeTXT || anyType):
+			instance = true
+		case sameName(q.name, r.host) && (q.qtype == typeA || q.qtype == typeAAAA || anyType):
+			host = true
+		}
+	}
+
+	resp := &dnsMessage{response: true}
+	if legacy {
+		resp.id = query.id
+		resp.questions = query.questions
+	}
+
+	switch {
+	case service:
+		resp.answers = append(resp.answers, r.pointer(ttl))
+		resp.extra = append(r.instanceRecords(ttl), r.addressRecords(ttl)...)
+	case instance:
+		resp.answers = r.instanceRecords(ttl)
+		resp.extra = r.addressRecords(ttl)
+	case host:
+		resp.answers = r.addressRecords(ttl)
+	}
+	if enumeration {
+		resp.answers = append(resp.answers, dnsRecord{name: serviceEnumeration, rtype: typePTR, ttl: ttl, target: ServiceType})
+	}
+
+	if len(resp.answers) == 0 {
+		return nil
+	}
+	return resp
+}
+
+// announcement is the unsolicited response sent when sharing starts
+func (r *Responder) announcement() *dnsMessage {
+	return &dnsMessage{
+		response: true,
+		answers:  append([]dnsRecord{r.pointer(recordTTL)}, r.instanceRecords(recordTTL)...),
+		extra:    r.addressRecords(recordTTL),
+	}
+}
+
+func (r *Responder) pointer(ttl uint32) dnsRecord {
+	return dnsRecord{name: ServiceType, rtype: typePTR, ttl: ttl, target: r.instance()}
+}
+
+func (r *Responder) instanceRecords(ttl uint32) []dnsRecord {
+	return []dnsRecord{
+		{name: r.instance(), rtype: typeSRV, ttl: ttl, target: r.host, port: uint16(r.port)},
+		{name: r.instance(), rtype: typeTXT, ttl: ttl, txt: []string{"path=/"}},
+	}
+}
+
+func (r *Responder) addressRecords(ttl uint32) []dnsRecord {
+	records := make([]dnsRecord, 0, len(r.addrs))
+	for _, ip := range r.addrs {
+		rtype := uint16(typeAAAA)
+		if ip.To4() != nil {
+			rtype = typeA
+		}
+		records = append(records, dnsRecord{name: r.host, rtype: rtype, ttl: ttl, ip: ip})
+	}
+	return records
+}
+
+// Resolve finds the repository shared as name on the local network, asking
+// on both the IPv4 and IPv6 mDNS groups until one answers or ctx is done
+func Resolve(ctx context.Context, name string) (*Share, error) {
+	return resolve(ctx, name, []*net.UDPAddr{mdnsGroupIPv4, mdnsGroupIPv6})
+}
+
+// resolve sends the query for name to each server once a second. The
+// share is reached at the address the answer came from, which is one this
+// machine can route to, unlike some of the advertised addresses.
+func resolve(ctx context.Context, name string, servers []*net.UDPAddr) (*Share, error) {
+	if err := ValidateShareName(name); err != nil {
+		return nil, err
+	}
+
+	instance := name + "." + ServiceType
+	query := &dnsMessage{
+		id:        uint16(rand.Intn(1 << 16)),
+		questions: []dnsQuestion{{name: instance, qtype: typeSRV}},
+	}
+	data, err := query.pack()
+	if err != nil {
+		return nil, err
+	}
+
+	found := make(chan *Share, 1)
+	var conns []*net.UDPConn
+	var targets []*net.UDPAddr
+	var lastErr error
+	for _, server := range servers {
+		conn, err := net.ListenUDP(udpNetwork(server), nil)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		defer conn.Close()
+		conns = append(conns, conn)
+		targets = append(targets, server)
+
+		go func(conn *net.UDPConn) {
+			buf := make([]byte, 9000)
+			for {
+				n, from, err := conn.ReadFromUDP(buf)
+				if err != nil {
+					return
+				}
+				msg, err := parseDNSMessage(buf[:n])
+				if err != nil || !msg.response {
+					continue
+				}
+				for _, rr := range append(msg.answers, msg.extra...) {
+					if rr.rtype != typeSRV || !sameName(rr.name, instance) {
+						continue
+					}
+					share := &Share{Name: name, Addr: &net.TCPAddr{IP: from.IP, Port: int(rr.port), Zone: from.Zone}}
+					select {
+					case found <- share:
+					default:
+					}
+					return
+				}
+			}
+		}(conn)
+	}
+	if len(conns) == 0 {
+		return nil, fmt.Errorf("failed to open mDNS socket: %w", lastErr)
+	}
+
+	send := func() {
+		for i, conn := range conns {
+			conn.WriteToUDP(data, targets[i])
+		}
+	}
+	send()
+
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case share := <-found:
+			return share, nil
+		case <-ticker.C:
+			send()
+		case <-ctx.Done():
+			return nil, fmt.Errorf("no repository shared as %s found on the local network", name)
+		}
+	}
+}
+
+// udpNetwork returns the network to use for addr
+func udpNetwork(addr *net.UDPAddr) string {
+	if addr.IP.To4() != nil {
+		return "udp4"
+	}
+	return "udp6"
+}
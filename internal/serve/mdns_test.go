@@ -0,0 +1,147 @@
+package serve
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestResponder() *Responder {
+	return &Responder{
+		name:  "project",
+		host:  "laptop.local.",
+		port:  9418,
+		addrs: []net.IP{net.ParseIP("192.168.1.20"), net.ParseIP("fe80::1")},
+	}
+}
+
+func TestShareName(t *testing.T) {
+	tests := map[string]string{
+		"project":        "project",
+		"My Project.git": "my-project-git",
+		"--weird__name-": "weird-name",
+		"ünïcode":        "n-code",
+		"":               "repo",
+		"...":            "repo",
+	}
+	for input, want := range tests {
+		assert.Equal(t, want, ShareName(input), input)
+	}
+
+	assert.NoError(t, ValidateShareName("my-project"))
+	assert.Error(t, ValidateShareName("My Project"))
+	assert.Error(t, ValidateShareName(""))
+}
+
+func TestParseURL(t *testing.T) {
+	name, ok := ParseURL("vcs-local://project")
+	assert.True(t, ok)
+	assert.Equal(t, "project", name)
+
+	name, ok = ParseURL("vcs-local://project.git/")
+	assert.True(t, ok)
+	assert.Equal(t, "project", name)
+
+	_, ok = ParseURL("vcs-local://Bad Name")
+	assert.False(t, ok)
+	_, ok = ParseURL("https://example.com/project")
+	assert.False(t, ok)
+}
+
+func TestShareURL(t *testing.T) {
+	share := &Share{Addr: &net.TCPAddr{IP: net.ParseIP("192.168.1.20"), Port: 8080}}
+	assert.Equal(t, "http://192.168.1.20:8080", share.URL())
+
+	share = &Share{Addr: &net.TCPAddr{IP: net.ParseIP("fe80::1"), Port: 8080, Zone: "eth0"}}
+	assert.Equal(t, "http://[fe80::1%25eth0]:8080", share.URL())
+}
+
+func TestDNSMessageRoundTrip(t *testing.T) {
+	msg := newTestResponder().announcement()
+	msg.id = 42
+	msg.questions = []dnsQuestion{{name: ServiceType, qtype: typePTR}}
+
+	data, err := msg.pack()
+	require.NoError(t, err)
+	parsed, err := parseDNSMessage(data)
+	require.NoError(t, err)
+
+	assert.Equal(t, uint16(42), parsed.id)
+	assert.True(t, parsed.response)
+	assert.Equal(t, msg.questions, parsed.questions)
+	require.Len(t, parsed.answers, 3)
+	assert.Equal(t, "project._vcs._tcp.local.", parsed.answers[0].target)
+	assert.Equal(t, uint16(9418), parsed.answers[1].port)
+	assert.Equal(t, "laptop.local.", parsed.answers[1].target)
+	assert.Equal(t, []string{"path=/"}, parsed.answers[2].txt)
+	require.Len(t, parsed.extra, 2)
+	assert.True(t, parsed.extra[0].ip.Equal(net.ParseIP("192.168.1.20")))
+	assert.Equal(t, uint16(typeAAAA), parsed.extra[1].rtype)
+}
+
+func TestParseDNSMessage_Compression(t *testing.T) {
+	// A PTR answer whose target points back into the question name
+	data := []byte{
+		0, 0, 0x84, 0, 0, 1, 0, 1, 0, 0, 0, 0,
+		4, '_', 'v', 'c', 's', 4, '_', 't', 'c', 'p', 5, 'l', 'o', 'c', 'a', 'l', 0,
+		0, typePTR, 0, classIN,
+		0xc0, 12, 0, typePTR, 0, classIN, 0, 0, 0, 120, 0, 4,
+		1, 'x', 0xc0, 12,
+	}
+	msg, err := parseDNSMessage(data)
+	require.NoError(t, err)
+	require.Len(t, msg.answers, 1)
+	assert.Equal(t, ServiceType, msg.answers[0].name)
+	assert.Equal(t, "x."+ServiceType, msg.answers[0].target)
+
+	// A pointer to itself must not loop forever
+	loop := append(append([]byte{}, data[:12]...), 0xc0, 12, 0, typePTR, 0, classIN)
+	_, err = parseDNSMessage(loop)
+	assert.Error(t, err)
+}
+
+func TestResponder_Answer(t *testing.T) {
+	r := newTestResponder()
+
+	resp := r.answer(&dnsMessage{id: 7, questions: []dnsQuestion{{name: "_VCS._tcp.local.", qtype: typePTR}}}, false)
+	require.NotNil(t, resp)
+	assert.Zero(t, resp.id, "multicast answers carry no ID")
+	assert.Empty(t, resp.questions)
+	require.Len(t, resp.answers, 1)
+	assert.Equal(t, uint32(recordTTL), resp.answers[0].ttl)
+	assert.Len(t, resp.extra, 4)
+
+	resp = r.answer(&dnsMessage{id: 7, questions: []dnsQuestion{{name: "project._vcs._tcp.local.", qtype: typeSRV}}}, true)
+	require.NotNil(t, resp)
+	assert.Equal(t, uint16(7), resp.id, "legacy answers echo the query")
+	assert.Len(t, resp.questions, 1)
+	assert.Equal(t, uint32(legacyTTL), resp.answers[0].ttl)
+
+	resp = r.answer(&dnsMessage{questions: []dnsQuestion{{name: "laptop.local.", qtype: typeA}}}, false)
+	require.NotNil(t, resp)
+	assert.Len(t, resp.answers, 2)
+
+	assert.Nil(t, r.answer(&dnsMessage{questions: []dnsQuestion{{name: "other._vcs._tcp.local.", qtype: typeSRV}}}, false))
+}
+
+func TestResolve(t *testing.T) {
+	conn, err := net.ListenPacket("udp4", "127.0.0.1:0")
+	require.NoError(t, err)
+	defer conn.Close()
+	go newTestResponder().serve(conn, nil)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	share, err := resolve(ctx, "project", []*net.UDPAddr{conn.LocalAddr().(*net.UDPAddr)})
+	require.NoError(t, err)
+	assert.Equal(t, "http://127.0.0.1:9418", share.URL(), "the share is reached where the answer came from")
+
+	ctx, cancel = context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	_, err = resolve(ctx, "missing", []*net.UDPAddr{conn.LocalAddr().(*net.UDPAddr)})
+	assert.ErrorContains(t, err, "no repository shared as missing")
+}
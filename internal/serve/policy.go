@@ -0,0 +1,113 @@
+package serve
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+// Policy rules, reported in PolicyError.Rule
+const (
+	RuleBlobSize    = "blob-size"
+	RuleBlockedPath = "blocked-path"
+	RuleRefFormat   = "ref-format"
+	RuleFastForward = "fast-forward"
+)
+
+// Policy restricts what a push may change. The zero Policy allows any push.
+type Policy struct {
+	// MaxBlobSize, when positive, is the largest blob in bytes a push may add
+	MaxBlobSize int64
+	// BlockedPaths are path.Match patterns of files that may not appear in
+	// the trees a push adds. A pattern without a slash matches the file
+	// name in any directory.
+	BlockedPaths []string
+	// RefFormat, when set, must match the full name of every ref a push
+	// creates or updates
+	RefFormat *regexp.Regexp
+	// FastForwardOnly are path.Match patterns of full ref names, such as
+	// refs/heads/release/*, that may only be fast-forwarded, never rewound
+	// or deleted
+	FastForwardOnly []string
+}
+
+// PolicyError is a ref update refused by a Policy rule. Its message is sent
+// to the pushing client as the reason the ref was rejected.
+type PolicyError struct {
+	// Rule is the rule broken, one of the Rule constants
+	Rule string
+	Ref  string
+	// Path and Object name the file that broke a blob-size or blocked-path
+	// rule
+	Path   string
+	Object objects.ObjectID
+	Reason string
+}
+
+// Error returns "policy <rule>: <reason>", which clients show as the
+// rejection reason
+func (e *PolicyError) Error() string {
+	return fmt.Sprintf("policy %s: %s", e.Rule, e.Reason)
+}
+
+// checkRef applies the ref name and fast-forward rules to an update of ref
+// from oldID to newID, either of which is zero for a creation or deletion
+func (p *Policy) checkRef(ref string, oldID, newID objects.ObjectID, isAncestor func(a, b objects.ObjectID) (bool, error)) error {
+	if !newID.IsZero() && p.RefFormat != nil && !p.RefFormat.MatchString(ref) {
+		return &PolicyError{Rule: RuleRefFormat, Ref: ref,
+			Reason: fmt.Sprintf("%s does not match %s", ref, p.RefFormat)}
+	}
+
+	if oldID.IsZero() || !matchAny(p.FastForwardOnly, ref) {
+		return nil
+	}
+	if newID.IsZero() {
+		return &PolicyError{Rule: RuleFastForward, Ref: ref, Reason: fmt.Sprintf("%s may not be deleted", ref)}
+	}
+	ok, err := isAncestor(oldID, newID)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return &PolicyError{Rule: RuleFastForward, Ref: ref,
+			Reason: fmt.Sprintf("%s may only be fast-forwarded, %s is not a descendant of %s", ref, newID.Short(), oldID.Short())}
+	}
+	return nil
+}
+
+// checkFile applies the blocked path rule to a file a push adds at filePath
+func (p *Policy) checkFile(ref, filePath string, id objects.ObjectID) error {
+	for _, pattern := range p.BlockedPaths {
+		name := filePath
+		if !strings.Contains(pattern, "/") {
+			name = path.Base(filePath)
+		}
+		if ok, _ := path.Match(pattern, name); ok {
+			return &PolicyError{Rule: RuleBlockedPath, Ref: ref, Path: filePath, Object: id,
+				Reason: fmt.Sprintf("%s matches blocked pattern %s", filePath, pattern)}
+		}
+	}
+	return nil
+}
+
+// checkBlob applies the blob size rule to a blob a push adds at filePath
+func (p *Policy) checkBlob(ref, filePath string, id objects.ObjectID, size int) error {
+	if p.MaxBlobSize > 0 && int64(size) > p.MaxBlobSize {
+		return &PolicyError{Rule: RuleBlobSize, Ref: ref, Path: filePath, Object: id,
+			Reason: fmt.Sprintf("%s is %d bytes, over the limit of %d", filePath, size, p.MaxBlobSize)}
+	}
+	return nil
+}
+
+// matchAny reports whether name matches one of the path.Match patterns
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if ok, _ := path.Match(pattern, name); ok {
+			return true
+		}
+	}
+	return false
+}
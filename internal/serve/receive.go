@@ -0,0 +1,516 @@
+package serve
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/packfile"
+	"github.com/fenilsonani/vcs/internal/transport"
+)
+
+// receiveCapabilities are advertised to pushing clients
+var receiveCapabilities = []string{"report-status", "report-status-v2", "delete-refs", "atomic", "push-options", "ofs-delta", "agent=" + agent}
+
+// RefUpdate is a ref moved by a push: created when Old is zero, deleted
+// when New is zero
+type RefUpdate struct {
+	Ref      string
+	Old, New objects.ObjectID
+	// PushOptions are the push options sent with the push, shared by all
+	// its updates
+	PushOptions []string
+}
+
+// Hooks are called as a push is applied, as Git's server-side hooks are.
+// They see only the updates that passed the checks and the policy, and the
+// objects those updates need are stored before they run, so hooks can read
+// them; the objects of updates a hook refuses are left unreachable.
+type Hooks struct {
+	// PreReceive is called before any update is applied. An error refuses
+	// every update, with the error as the reason.
+	PreReceive func(updates []RefUpdate) error
+	// Update is called for each update after PreReceive. An error refuses
+	// that update.
+	Update func(update RefUpdate) error
+	// PostReceive is called with the updates that were applied
+	PostReceive func(updates []RefUpdate)
+}
+
+// SetHooks sets the hooks called as pushes are applied
+func (s *Server) SetHooks(hooks Hooks) {
+	s.hooks = hooks
+}
+
+// refUpdate is one command of a push: move ref from old to new
+type refUpdate struct {
+	ref      string
+	old, new objects.ObjectID
+	options  []string
+	// err is why the update was refused, nil when accepted
+	err error
+}
+
+// errAtomicPush refuses the updates of an atomic push that were accepted
+// when another was not
+var errAtomicPush = fmt.Errorf("atomic push failure")
+
+// parseReceiveRequest reads the ref update commands that start a push, and
+// the capabilities sent with the first of them
+func parseReceiveRequest(pr *transport.PktLineReader) ([]*refUpdate, []string, error) {
+	var updates []*refUpdate
+	var capabilities []string
+	for {
+		line, err := pr.ReadLine()
+		if err == transport.ErrFlushPkt || (err == io.EOF && len(updates) > 0) {
+			return updates, capabilities, nil
+		}
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read commands: %w", err)
+		}
+
+		command, caps, hasCaps := strings.Cut(line, "\x00")
+		if hasCaps && len(updates) == 0 {
+			capabilities = strings.Fields(caps)
+		}
+		fields := strings.Fields(command)
+		if len(fields) != 3 {
+			return nil, nil, fmt.Errorf("malformed command %q", command)
+		}
+		oldID, err := objects.NewObjectID(fields[0])
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid object ID in %q: %w", command, err)
+		}
+		newID, err := objects.NewObjectID(fields[1])
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid object ID in %q: %w", command, err)
+		}
+		updates = append(updates, &refUpdate{ref: fields[2], old: oldID, new: newID})
+	}
+}
+
+// receivePack applies a push: it unpacks the pushed objects into a
+// quarantine, checks each ref update against the repository and the
+// policy, stores the objects the accepted updates need and moves their refs
+func (s *Server) receivePack(w http.ResponseWriter, r *http.Request) {
+	body, err := requestBody(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer body.Close()
+
+	pr := newPktLineReader(body)
+	updates, capabilities, err := parseReceiveRequest(pr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-git-receive-pack-result")
+	w.Header().Set("Cache-Control", "no-cache")
+	s.receive(pr, w, updates, capabilities)
+}
+
+// readPushOptions reads the push options that follow the commands, up to
+// the flush-pkt ending them
+func readPushOptions(pr *transport.PktLineReader) ([]string, error) {
+	var options []string
+	for {
+		line, err := pr.ReadLine()
+		if err == transport.ErrFlushPkt {
+			return options, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read push options: %w", err)
+		}
+		options = append(options, line)
+	}
+}
+
+// failAtomic refuses every update of an atomic push once one of them was
+// refused, and reports whether it did
+func failAtomic(updates []*refUpdate) bool {
+	failed := false
+	for _, u := range updates {
+		if u.err != nil {
+			failed = true
+			break
+		}
+	}
+	if failed {
+		for _, u := range updates {
+			if u.err == nil {
+				u.err = errAtomicPush
+			}
+		}
+	}
+	return failed
+}
+
+// receive applies updates, reading the push options and the pack from pr,
+// and reports the outcome to w when the client asked for report-status.
+// With atomic either every update is applied or none is.
+func (s *Server) receive(pr *transport.PktLineReader, w io.Writer, updates []*refUpdate, capabilities []string) error {
+	if hasCapability(capabilities, "push-options") {
+		options, err := readPushOptions(pr)
+		if err != nil {
+			return err
+		}
+		for _, u := range updates {
+			u.options = options
+		}
+	}
+	atomic := hasCapability(capabilities, "atomic")
+
+	// Only deletions come without a pack
+	q := newQuarantine(s.storage)
+	unpackErr := error(nil)
+	for _, u := range updates {
+		if !u.new.IsZero() {
+			if _, err := packfile.Unpack(pr.Reader(), q); err != nil {
+				unpackErr = err
+			}
+			break
+		}
+	}
+
+	var keep []objects.ObjectID
+	for _, u := range updates {
+		if unpackErr != nil {
+			u.err = fmt.Errorf("unpacker error")
+			continue
+		}
+		var needed []objects.ObjectID
+		if needed, u.err = s.checkUpdate(q, u); u.err == nil {
+			keep = append(keep, needed...)
+		}
+	}
+	if atomic && failAtomic(updates) {
+		keep = nil
+	}
+
+	if err := q.commit(keep); err != nil {
+		for _, u := range updates {
+			if u.err == nil {
+				u.err = err
+			}
+		}
+	}
+	s.runHooks(updates)
+	if atomic {
+		failAtomic(updates)
+	}
+	var applied []*refUpdate
+	for _, u := range updates {
+		if u.err != nil {
+			continue
+		}
+		if u.err = s.applyUpdate(u); u.err != nil {
+			if atomic {
+				s.rollBack(applied)
+				failAtomic(updates)
+				applied = nil
+				break
+			}
+			continue
+		}
+		applied = append(applied, u)
+	}
+	if len(applied) > 0 && s.hooks.PostReceive != nil {
+		public := make([]RefUpdate, len(applied))
+		for i, u := range applied {
+			public[i] = u.public()
+		}
+		s.hooks.PostReceive(public)
+	}
+
+	// report-status-v2 adds options to report-status for updates the
+	// server changed, which it never does
+	if !hasCapability(capabilities, "report-status") && !hasCapability(capabilities, "report-status-v2") {
+		return unpackErr
+	}
+	pw := newPktLineWriter(w)
+	if unpackErr != nil {
+		pw.Writef("unpack %v\n", unpackErr)
+	} else {
+		pw.WriteString("unpack ok\n")
+	}
+	for _, u := range updates {
+		if u.err != nil {
+			pw.Writef("ng %s %s\n", u.ref, strings.ReplaceAll(u.err.Error(), "\n", " "))
+		} else {
+			pw.Writef("ok %s\n", u.ref)
+		}
+	}
+	if err := pw.Flush(); err != nil {
+		return err
+	}
+	return unpackErr
+}
+
+// public returns u as the hooks see it
+func (u *refUpdate) public() RefUpdate {
+	return RefUpdate{Ref: u.ref, Old: u.old, New: u.new, PushOptions: u.options}
+}
+
+// rollBack moves the refs of applied updates back where they were, as far
+// as it can, when an atomic push fails part way
+func (s *Server) rollBack(applied []*refUpdate) {
+	for _, u := range applied {
+		s.applyUpdate(&refUpdate{ref: u.ref, old: u.new, new: u.old})
+	}
+}
+
+// runHooks calls the pre-receive and update hooks on the updates not yet
+// refused, refusing those the hooks decline
+func (s *Server) runHooks(updates []*refUpdate) {
+	var accepted []*refUpdate
+	var public []RefUpdate
+	for _, u := range updates {
+		if u.err == nil {
+			accepted = append(accepted, u)
+			public = append(public, u.public())
+		}
+	}
+	if len(accepted) == 0 {
+		return
+	}
+
+	if s.hooks.PreReceive != nil {
+		if err := s.hooks.PreReceive(public); err != nil {
+			for _, u := range accepted {
+				u.err = err
+			}
+			return
+		}
+	}
+	if s.hooks.Update != nil {
+		for _, u := range accepted {
+			u.err = s.hooks.Update(u.public())
+		}
+	}
+}
+
+// CheckUpdate checks update as a push would, against the repository and
+// the policy, with the objects it needs already stored. Hooks are not
+// called.
+func (s *Server) CheckUpdate(update RefUpdate) error {
+	_, err := s.checkUpdate(newQuarantine(s.storage), &refUpdate{ref: update.Ref, old: update.Old, new: update.New})
+	return err
+}
+
+// checkUpdate decides whether u may be applied and returns the quarantined
+// objects it needs
+func (s *Server) checkUpdate(q *quarantine, u *refUpdate) ([]objects.ObjectID, error) {
+	if !strings.HasPrefix(u.ref, "refs/") || !s.refs.IsValidRef(u.ref) {
+		return nil, fmt.Errorf("funny refname")
+	}
+	current, err := s.refs.ResolveRef(u.ref)
+	if err != nil {
+		current = objects.ObjectID{}
+	}
+	if current != u.old {
+		return nil, fmt.Errorf("stale info")
+	}
+	if u.new.IsZero() && !strings.HasPrefix(u.ref, "refs/heads/") && !strings.HasPrefix(u.ref, "refs/tags/") {
+		return nil, fmt.Errorf("deleting %s is not supported", u.ref)
+	}
+
+	// Moving the checked out branch would leave the working tree behind
+	if head, _ := s.refs.SymbolicHEAD(); head == u.ref && filepath.Base(s.gitDir) == ".git" {
+		return nil, fmt.Errorf("branch is currently checked out")
+	}
+
+	if err := s.policy.checkRef(u.ref, u.old, u.new, q.isAncestor); err != nil {
+		return nil, err
+	}
+	if u.new.IsZero() {
+		return nil, nil
+	}
+	return q.walk(u.new, func(filePath string, entry objects.TreeEntry, size int) error {
+		if err := s.policy.checkFile(u.ref, filePath, entry.ID); err != nil {
+			return err
+		}
+		if size >= 0 {
+			return s.policy.checkBlob(u.ref, filePath, entry.ID, size)
+		}
+		return nil
+	})
+}
+
+// applyUpdate moves or deletes the ref of an accepted update
+func (s *Server) applyUpdate(u *refUpdate) error {
+	var err error
+	switch {
+	case !u.new.IsZero():
+		err = s.refs.UpdateRef(u.ref, u.new)
+	case strings.HasPrefix(u.ref, "refs/heads/"):
+		err = s.refs.DeleteBranch(strings.TrimPrefix(u.ref, "refs/heads/"))
+	default:
+		err = s.refs.DeleteTag(strings.TrimPrefix(u.ref, "refs/tags/"))
+	}
+	if err != nil {
+		return fmt.Errorf("failed to update ref: %v", err)
+	}
+	return nil
+}
+
+// quarantine holds pushed objects in memory until the updates needing them
+// are accepted, so refused objects never reach the repository. Objects
+// already in the repository are read from storage.
+type quarantine struct {
+	storage *objects.Storage
+	objects map[objects.ObjectID]rawObject
+}
+
+type rawObject struct {
+	objType objects.ObjectType
+	data    []byte
+}
+
+func newQuarantine(storage *objects.Storage) *quarantine {
+	return &quarantine{storage: storage, objects: make(map[objects.ObjectID]rawObject)}
+}
+
+// ReadRawObject implements packfile.ObjectStore
+func (q *quarantine) ReadRawObject(id objects.ObjectID) (objects.ObjectType, []byte, error) {
+	if obj, ok := q.objects[id]; ok {
+		return obj.objType, obj.data, nil
+	}
+	return q.storage.ReadRawObject(id)
+}
+
+// WriteRawObject implements packfile.ObjectStore
+func (q *quarantine) WriteRawObject(objType objects.ObjectType, data []byte) (objects.ObjectID, error) {
+	id := objects.ComputeHash(objType, data)
+	if !q.storage.HasObject(id) {
+		q.objects[id] = rawObject{objType: objType, data: data}
+	}
+	return id, nil
+}
+
+// readObject parses an object from the quarantine or the repository
+func (q *quarantine) readObject(id objects.ObjectID) (objects.Object, error) {
+	objType, data, err := q.ReadRawObject(id)
+	if err != nil {
+		return nil, err
+	}
+	return objects.ParseObject(id, objType, data)
+}
+
+// walk visits the quarantined objects reachable from tip, calling visit for
+// each entry of a quarantined tree that is not a tree, with the size of the
+// blob when it is quarantined too and -1 otherwise. Objects already in the
+// repository are complete and not descended into. It returns the objects
+// visited, and fails when one is missing.
+func (q *quarantine) walk(tip objects.ObjectID, visit func(filePath string, entry objects.TreeEntry, size int) error) ([]objects.ObjectID, error) {
+	type item struct {
+		id   objects.ObjectID
+		path string
+	}
+	var reached []objects.ObjectID
+	seen := make(map[objects.ObjectID]bool)
+	stack := []item{{id: tip}}
+	for len(stack) > 0 {
+		it := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[it.id] {
+			continue
+		}
+		seen[it.id] = true
+
+		raw, ok := q.objects[it.id]
+		if !ok {
+			if !q.storage.HasObject(it.id) {
+				return nil, fmt.Errorf("missing necessary objects")
+			}
+			continue
+		}
+		reached = append(reached, it.id)
+
+		obj, err := objects.ParseObject(it.id, raw.objType, raw.data)
+		if err != nil {
+			return nil, fmt.Errorf("invalid object %s: %v", it.id, err)
+		}
+		switch o := obj.(type) {
+		case *objects.Commit:
+			stack = append(stack, item{id: o.Tree()})
+			for _, parent := range o.Parents() {
+				stack = append(stack, item{id: parent})
+			}
+		case *objects.Tag:
+			stack = append(stack, item{id: o.Object()})
+		case *objects.Tree:
+			for _, entry := range o.Entries() {
+				entryPath := path.Join(it.path, entry.Name)
+				switch entry.Mode {
+				case objects.ModeTree:
+					stack = append(stack, item{id: entry.ID, path: entryPath})
+					continue
+				case objects.ModeCommit:
+					// Submodule commits live in another repository
+					continue
+				}
+				size := -1
+				if blob, ok := q.objects[entry.ID]; ok {
+					size = len(blob.data)
+				}
+				if err := visit(entryPath, entry, size); err != nil {
+					return nil, err
+				}
+				stack = append(stack, item{id: entry.ID, path: entryPath})
+			}
+		}
+	}
+	return reached, nil
+}
+
+// isAncestor reports whether commit a is reachable from commit b
+func (q *quarantine) isAncestor(a, b objects.ObjectID) (bool, error) {
+	seen := make(map[objects.ObjectID]bool)
+	queue := []objects.ObjectID{b}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		if id == a {
+			return true, nil
+		}
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+
+		obj, err := q.readObject(id)
+		if err != nil {
+			// History may end at a shallow boundary
+			continue
+		}
+		switch o := obj.(type) {
+		case *objects.Commit:
+			queue = append(queue, o.Parents()...)
+		case *objects.Tag:
+			queue = append(queue, o.Object())
+		}
+	}
+	return false, nil
+}
+
+// commit writes the given quarantined objects to the repository
+func (q *quarantine) commit(ids []objects.ObjectID) error {
+	for _, id := range ids {
+		obj, ok := q.objects[id]
+		if !ok {
+			continue
+		}
+		if _, err := q.storage.WriteRawObject(obj.objType, obj.data); err != nil {
+			return fmt.Errorf("failed to write object %s: %v", id.Short(), err)
+		}
+		delete(q.objects, id)
+	}
+	return nil
+}
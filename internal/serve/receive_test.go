@@ -0,0 +1,320 @@
+package serve
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/packfile"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/internal/transport"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// pushFixture is a served repository accepting pushes and a client
+// repository to create the pushed objects in
+type pushFixture struct {
+	server *vcs.Repository
+	client *vcs.Repository
+	url    string
+}
+
+func newPushFixture(t *testing.T, policy Policy) *pushFixture {
+	server, err := vcs.Init(filepath.Join(t.TempDir(), "server"))
+	require.NoError(t, err)
+	// main is pushed to, so another branch is checked out
+	require.NoError(t, refs.NewRefManager(server.GitDir()).SetHEAD("refs/heads/work"))
+	client, err := vcs.Init(filepath.Join(t.TempDir(), "client"))
+	require.NoError(t, err)
+
+	s := NewServer(server.GitDir(), server.Storage())
+	s.AllowPush(policy)
+	hs := httptest.NewServer(s)
+	t.Cleanup(hs.Close)
+	return &pushFixture{server: server, client: client, url: hs.URL}
+}
+
+// commit creates a commit of files in the client and returns it with all
+// the objects it added
+func (f *pushFixture) commit(t *testing.T, files map[string]string, parents ...objects.ObjectID) (objects.ObjectID, []objects.ObjectID) {
+	sig := objects.Signature{Name: "Test", Email: "test@example.com", When: time.Unix(1700000000, 0)}
+	var created []objects.ObjectID
+	var entries []objects.TreeEntry
+	for name, content := range files {
+		blob, err := f.client.CreateBlob([]byte(content))
+		require.NoError(t, err)
+		created = append(created, blob.ID())
+		entries = append(entries, objects.TreeEntry{Mode: objects.ModeBlob, Name: name, ID: blob.ID()})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	tree, err := f.client.CreateTree(entries)
+	require.NoError(t, err)
+	commit, err := f.client.CreateCommit(tree.ID(), parents, sig, sig, "commit")
+	require.NoError(t, err)
+	return commit.ID(), append(created, tree.ID(), commit.ID())
+}
+
+// push sends commands with a pack of objs and returns the status reported
+// for each ref: "ok" or the reason it was refused
+func (f *pushFixture) push(t *testing.T, commands []string, objs []objects.ObjectID) map[string]string {
+	return f.pushWith(t, nil, nil, commands, objs)
+}
+
+// pushWith pushes like push, asking for capabilities besides report-status
+// and sending options as push options
+func (f *pushFixture) pushWith(t *testing.T, capabilities, options, commands []string, objs []objects.ObjectID) map[string]string {
+	var body bytes.Buffer
+	pw := transport.NewPktLineWriter(&body)
+	for i, command := range commands {
+		if i == 0 {
+			command += "\x00" + strings.Join(append([]string{"report-status"}, capabilities...), " ")
+		}
+		require.NoError(t, pw.WriteString(command+"\n"))
+	}
+	require.NoError(t, pw.Flush())
+	if len(options) > 0 {
+		for _, option := range options {
+			require.NoError(t, pw.WriteString(option+"\n"))
+		}
+		require.NoError(t, pw.Flush())
+	}
+	if len(objs) > 0 {
+		var pack []*packfile.Object
+		for _, id := range objs {
+			objType, data, err := f.client.Storage().ReadRawObject(id)
+			require.NoError(t, err)
+			pack = append(pack, &packfile.Object{ID: id, Type: objType, Data: data})
+		}
+		_, err := packfile.WritePack(&body, pack, packfile.DefaultWriterOptions())
+		require.NoError(t, err)
+	}
+
+	resp, err := http.Post(f.url+"/git-receive-pack", "application/x-git-receive-pack-request", &body)
+	require.NoError(t, err)
+	defer resp.Body.Close()
+	require.Equal(t, http.StatusOK, resp.StatusCode)
+
+	pr := transport.NewPktLineReader(resp.Body)
+	line, err := pr.ReadLine()
+	require.NoError(t, err)
+	require.Equal(t, "unpack ok", line)
+	status := make(map[string]string)
+	for {
+		line, err := pr.ReadLine()
+		if err == transport.ErrFlushPkt {
+			return status
+		}
+		require.NoError(t, err)
+		if ref, ok := strings.CutPrefix(line, "ok "); ok {
+			status[ref] = "ok"
+		} else if rest, ok := strings.CutPrefix(line, "ng "); ok {
+			ref, reason, _ := strings.Cut(rest, " ")
+			status[ref] = reason
+		}
+	}
+}
+
+func update(oldID, newID objects.ObjectID, ref string) string {
+	return fmt.Sprintf("%s %s %s", oldID, newID, ref)
+}
+
+func (f *pushFixture) ref(t *testing.T, name string) objects.ObjectID {
+	id, err := refs.NewRefManager(f.server.GitDir()).ResolveRef(name)
+	if err != nil {
+		return objects.ObjectID{}
+	}
+	return id
+}
+
+func TestReceivePack_Advertisement(t *testing.T) {
+	f := newPushFixture(t, Policy{})
+	discovery, err := transport.NewHTTPTransport(f.url).DiscoverRefs(context.Background(), "git-receive-pack")
+	require.NoError(t, err)
+	assert.True(t, discovery.HasCapability("report-status"))
+	assert.True(t, discovery.HasCapability("delete-refs"))
+	assert.True(t, discovery.HasCapability("report-status-v2"))
+	assert.True(t, discovery.HasCapability("atomic"))
+	assert.True(t, discovery.HasCapability("push-options"))
+}
+
+func TestReceivePack_CreateUpdateDelete(t *testing.T) {
+	f := newPushFixture(t, Policy{})
+	var zero objects.ObjectID
+
+	first, objs := f.commit(t, map[string]string{"a.txt": "one\n"})
+	status := f.push(t, []string{update(zero, first, "refs/heads/main"), update(zero, first, "refs/tags/v1")}, objs)
+	assert.Equal(t, map[string]string{"refs/heads/main": "ok", "refs/tags/v1": "ok"}, status)
+	assert.Equal(t, first, f.ref(t, "refs/heads/main"))
+	for _, id := range objs {
+		assert.True(t, f.server.Storage().HasObject(id))
+	}
+
+	second, objs := f.commit(t, map[string]string{"a.txt": "two\n"}, first)
+	status = f.push(t, []string{update(first, second, "refs/heads/main")}, objs)
+	assert.Equal(t, "ok", status["refs/heads/main"])
+	assert.Equal(t, second, f.ref(t, "refs/heads/main"))
+
+	status = f.push(t, []string{update(first, zero, "refs/tags/v1")}, nil)
+	assert.Equal(t, "ok", status["refs/tags/v1"])
+	assert.True(t, f.ref(t, "refs/tags/v1").IsZero())
+}
+
+func TestReceivePack_RefusesStaleAndInvalidUpdates(t *testing.T) {
+	f := newPushFixture(t, Policy{})
+	var zero objects.ObjectID
+	first, objs := f.commit(t, map[string]string{"a.txt": "one\n"})
+	require.Equal(t, "ok", f.push(t, []string{update(zero, first, "refs/heads/main")}, objs)["refs/heads/main"])
+
+	second, objs := f.commit(t, map[string]string{"a.txt": "two\n"}, first)
+	status := f.push(t, []string{
+		update(zero, second, "refs/heads/main"),
+		update(zero, second, "refs/heads/bad..name"),
+		update(zero, second, "refs/heads/work"),
+	}, objs)
+	assert.Equal(t, "stale info", status["refs/heads/main"])
+	assert.Equal(t, "funny refname", status["refs/heads/bad..name"])
+	assert.Equal(t, "branch is currently checked out", status["refs/heads/work"])
+	assert.Equal(t, first, f.ref(t, "refs/heads/main"))
+	assert.False(t, f.server.Storage().HasObject(second), "refused objects are not stored")
+
+	// A pack missing the objects a commit needs is refused
+	third, objs := f.commit(t, map[string]string{"b.txt": "three\n"}, first)
+	status = f.push(t, []string{update(first, third, "refs/heads/main")}, objs[len(objs)-1:])
+	assert.Equal(t, "missing necessary objects", status["refs/heads/main"])
+}
+
+func TestReceivePack_Atomic(t *testing.T) {
+	f := newPushFixture(t, Policy{})
+	var zero objects.ObjectID
+	first, objs := f.commit(t, map[string]string{"a.txt": "one\n"})
+	require.Equal(t, "ok", f.push(t, []string{update(zero, first, "refs/heads/main")}, objs)["refs/heads/main"])
+
+	// One stale update refuses the others, whose objects are not stored
+	second, objs := f.commit(t, map[string]string{"a.txt": "two\n"}, first)
+	status := f.pushWith(t, []string{"atomic"}, nil, []string{
+		update(zero, second, "refs/heads/topic"),
+		update(zero, second, "refs/heads/main"),
+	}, objs)
+	assert.Equal(t, map[string]string{"refs/heads/topic": "atomic push failure", "refs/heads/main": "stale info"}, status)
+	assert.True(t, f.ref(t, "refs/heads/topic").IsZero())
+	assert.False(t, f.server.Storage().HasObject(second))
+
+	// Refused by a hook too
+	s := NewServer(f.server.GitDir(), f.server.Storage())
+	s.AllowPush(Policy{})
+	s.SetHooks(Hooks{Update: func(u RefUpdate) error {
+		if u.Ref == "refs/heads/main" {
+			return fmt.Errorf("hook declined")
+		}
+		return nil
+	}})
+	hs := httptest.NewServer(s)
+	t.Cleanup(hs.Close)
+	f.url = hs.URL
+	status = f.pushWith(t, []string{"atomic"}, nil, []string{
+		update(zero, second, "refs/heads/topic"),
+		update(first, second, "refs/heads/main"),
+	}, objs)
+	assert.Equal(t, map[string]string{"refs/heads/topic": "atomic push failure", "refs/heads/main": "hook declined"}, status)
+	assert.True(t, f.ref(t, "refs/heads/topic").IsZero())
+
+	// Without atomic the others are applied
+	status = f.push(t, []string{
+		update(zero, second, "refs/heads/topic"),
+		update(first, second, "refs/heads/main"),
+	}, objs)
+	assert.Equal(t, map[string]string{"refs/heads/topic": "ok", "refs/heads/main": "hook declined"}, status)
+}
+
+func TestReceivePack_PushOptions(t *testing.T) {
+	f := newPushFixture(t, Policy{})
+	var received [][]string
+	s := NewServer(f.server.GitDir(), f.server.Storage())
+	s.AllowPush(Policy{})
+	s.SetHooks(Hooks{PostReceive: func(updates []RefUpdate) {
+		for _, u := range updates {
+			received = append(received, u.PushOptions)
+		}
+	}})
+	hs := httptest.NewServer(s)
+	t.Cleanup(hs.Close)
+	f.url = hs.URL
+
+	var zero objects.ObjectID
+	first, objs := f.commit(t, map[string]string{"a.txt": "one\n"})
+	status := f.pushWith(t, []string{"push-options", "report-status-v2"}, []string{"ci.skip", "topic=x"}, []string{
+		update(zero, first, "refs/heads/main"),
+		update(zero, first, "refs/tags/v1"),
+	}, objs)
+	assert.Equal(t, map[string]string{"refs/heads/main": "ok", "refs/tags/v1": "ok"}, status)
+	assert.Equal(t, [][]string{{"ci.skip", "topic=x"}, {"ci.skip", "topic=x"}}, received)
+
+	// Deletions come without a pack, but still with the options
+	received = nil
+	status = f.pushWith(t, []string{"push-options"}, []string{"reason=cleanup"}, []string{update(first, zero, "refs/tags/v1")}, nil)
+	assert.Equal(t, "ok", status["refs/tags/v1"])
+	assert.Equal(t, [][]string{{"reason=cleanup"}}, received)
+}
+
+func TestReceivePack_Policy(t *testing.T) {
+	f := newPushFixture(t, Policy{
+		MaxBlobSize:     8,
+		BlockedPaths:    []string{"*.pem", "secrets/*"},
+		RefFormat:       regexp.MustCompile(`^refs/(heads|tags)/[a-z]+$`),
+		FastForwardOnly: []string{"refs/heads/main"},
+	})
+	var zero objects.ObjectID
+	base, objs := f.commit(t, map[string]string{"a.txt": "one\n"})
+	require.Equal(t, "ok", f.push(t, []string{update(zero, base, "refs/heads/main")}, objs)["refs/heads/main"])
+
+	big, bigObjs := f.commit(t, map[string]string{"a.txt": "much too large\n"}, base)
+	key, keyObjs := f.commit(t, map[string]string{"a.txt": "one\n", "key.pem": "k\n"}, base)
+	status := f.push(t, []string{
+		update(zero, big, "refs/heads/big"),
+		update(zero, key, "refs/heads/key"),
+		update(zero, base, "refs/heads/Upper"),
+	}, append(bigObjs, keyObjs...))
+	assert.Equal(t, "policy blob-size: a.txt is 15 bytes, over the limit of 8", status["refs/heads/big"])
+	assert.Equal(t, "policy blocked-path: key.pem matches blocked pattern *.pem", status["refs/heads/key"])
+	assert.Contains(t, status["refs/heads/Upper"], "policy ref-format:")
+	assert.False(t, f.server.Storage().HasObject(big))
+	assert.False(t, f.server.Storage().HasObject(key))
+
+	// main may move forward but not be rewound or deleted
+	rewound, objs := f.commit(t, map[string]string{"a.txt": "two\n"})
+	status = f.push(t, []string{update(base, rewound, "refs/heads/main")}, objs)
+	assert.Contains(t, status["refs/heads/main"], "policy fast-forward:")
+	status = f.push(t, []string{update(base, zero, "refs/heads/main")}, nil)
+	assert.Equal(t, "policy fast-forward: refs/heads/main may not be deleted", status["refs/heads/main"])
+
+	next, objs := f.commit(t, map[string]string{"a.txt": "two\n"}, base)
+	status = f.push(t, []string{update(base, next, "refs/heads/main"), update(zero, next, "refs/heads/topic")}, objs)
+	assert.Equal(t, map[string]string{"refs/heads/main": "ok", "refs/heads/topic": "ok"}, status)
+}
+
+func TestPolicy_CheckFile(t *testing.T) {
+	p := Policy{BlockedPaths: []string{"*.pem", "config/secrets.yml"}}
+	var id objects.ObjectID
+
+	err := p.checkFile("refs/heads/main", "deploy/server.pem", id)
+	var policyErr *PolicyError
+	require.ErrorAs(t, err, &policyErr)
+	assert.Equal(t, RuleBlockedPath, policyErr.Rule)
+	assert.Equal(t, "deploy/server.pem", policyErr.Path)
+
+	assert.Error(t, p.checkFile("refs/heads/main", "config/secrets.yml", id))
+	assert.NoError(t, p.checkFile("refs/heads/main", "other/config/secrets.yml", id))
+	assert.NoError(t, p.checkFile("refs/heads/main", "README", id))
+}
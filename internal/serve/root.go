@@ -0,0 +1,116 @@
+package serve
+
+import (
+	"errors"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// ErrNotRepository is returned by an OpenFunc for a directory that is not a
+// repository
+var ErrNotRepository = errors.New("not a repository")
+
+// OpenFunc returns the Server for the repository in dir
+type OpenFunc func(dir string) (*Server, error)
+
+// serviceSuffixes end the URLs of the smart HTTP protocol, after the path
+// of the repository
+var serviceSuffixes = []string{"/info/refs", "/git-upload-pack", "/git-receive-pack"}
+
+// RootServer serves every repository under a directory, each at the URL of
+// its path relative to the directory. As with git http-backend, a bare
+// repository's ".git" suffix may be left out of its URL.
+type RootServer struct {
+	root string
+	open OpenFunc
+}
+
+// NewRootServer creates a server for the repositories under root, opening
+// the one each request is for with open
+func NewRootServer(root string, open OpenFunc) *RootServer {
+	return &RootServer{root: root, open: open}
+}
+
+// ServeHTTP implements http.Handler
+func (s *RootServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	repoPath, ok := splitRepositoryPath(r.URL.Path)
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	server, err := lookupRepository(s.root, repoPath, s.open)
+	if errors.Is(err, ErrNotRepository) {
+		http.Error(w, "repository not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	server.ServeHTTP(w, r)
+}
+
+// lookupRepository opens the repository at repoPath under root, trying the
+// path with ".git" appended when it names no repository
+func lookupRepository(root, repoPath string, open OpenFunc) (*Server, error) {
+	candidates := []string{repoPath}
+	if !strings.HasSuffix(repoPath, ".git") {
+		candidates = append(candidates, repoPath+".git")
+	}
+	for _, candidate := range candidates {
+		dir := filepath.Join(root, filepath.FromSlash(candidate))
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			continue
+		}
+		server, err := open(dir)
+		if errors.Is(err, ErrNotRepository) {
+			continue
+		}
+		return server, err
+	}
+	return nil, ErrNotRepository
+}
+
+// splitRepositoryPath returns the repository path a protocol URL path
+// starts with, cleaned as cleanRepositoryPath does. It reports false for a
+// path that is not part of the protocol.
+func splitRepositoryPath(urlPath string) (string, bool) {
+	end := -1
+	for _, suffix := range serviceSuffixes {
+		if strings.HasSuffix(urlPath, suffix) {
+			end = len(urlPath) - len(suffix)
+			break
+		}
+	}
+	if i := strings.LastIndex(urlPath, lfsPrefix); end < 0 && i >= 0 {
+		end = i
+	}
+	if end < 0 {
+		return "", false
+	}
+	return cleanRepositoryPath(urlPath[:end])
+}
+
+// cleanRepositoryPath returns the path of a requested repository cleaned
+// and relative to the root. It reports false for the root itself and for
+// paths through hidden directories.
+func cleanRepositoryPath(p string) (string, bool) {
+	// Cleaning a rooted path removes every "..", so the result cannot
+	// climb out of the root
+	repoPath := strings.TrimPrefix(path.Clean("/"+p), "/")
+	if repoPath == "" {
+		return "", false
+	}
+	for _, part := range strings.Split(repoPath, "/") {
+		// Hidden directories hold no served repositories, and a
+		// repository's own .git directory is reached through its path
+		if strings.HasPrefix(part, ".") {
+			return "", false
+		}
+	}
+	return repoPath, true
+}
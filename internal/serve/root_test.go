@@ -0,0 +1,81 @@
+package serve
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/internal/transport"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+func TestSplitRepositoryPath(t *testing.T) {
+	tests := []struct {
+		urlPath string
+		want    string
+		ok      bool
+	}{
+		{"/project/info/refs", "project", true},
+		{"/team/project.git/git-upload-pack", "team/project.git", true},
+		{"/team//project/git-receive-pack", "team/project", true},
+		{"/project/info/lfs/objects/batch", "project", true},
+		{"/../../etc/info/refs", "etc", true},
+		{"/info/refs", "", false},
+		{"/project/.git/info/refs", "", false},
+		{"/.ssh/info/refs", "", false},
+		{"/project/HEAD", "", false},
+	}
+	for _, tt := range tests {
+		got, ok := splitRepositoryPath(tt.urlPath)
+		assert.Equal(t, tt.ok, ok, tt.urlPath)
+		assert.Equal(t, tt.want, got, tt.urlPath)
+	}
+}
+
+func TestRootServer(t *testing.T) {
+	root := t.TempDir()
+	repo, err := vcs.Init(filepath.Join(root, "team", "project.git"))
+	require.NoError(t, err)
+	require.NoError(t, refs.NewRefManager(repo.GitDir()).SetHEAD("refs/heads/main"))
+	first := commitFile(t, repo, "a.txt", "one\n")
+	require.NoError(t, os.MkdirAll(filepath.Join(root, "plain"), 0755))
+
+	var opened []string
+	server := httptest.NewServer(NewRootServer(root, func(dir string) (*Server, error) {
+		opened = append(opened, dir)
+		if dir != filepath.Join(root, "team", "project.git") {
+			return nil, ErrNotRepository
+		}
+		return NewServer(repo.GitDir(), repo.Storage()), nil
+	}))
+	t.Cleanup(server.Close)
+
+	for _, name := range []string{"team/project", "team/project.git"} {
+		client := transport.NewHTTPTransport(server.URL + "/" + name)
+		discovery, err := client.DiscoverRefs(context.Background(), "git-upload-pack")
+		require.NoError(t, err, name)
+		assert.Equal(t, first.String(), discovery.Refs["refs/heads/main"])
+		assert.Len(t, fetch(t, client, first), 3)
+	}
+
+	for _, name := range []string{"plain", "missing", "team"} {
+		resp, err := http.Get(server.URL + "/" + name + "/info/refs?service=git-upload-pack")
+		require.NoError(t, err)
+		resp.Body.Close()
+		assert.Equal(t, http.StatusNotFound, resp.StatusCode, name)
+	}
+	assert.NotContains(t, opened, filepath.Join(root, "missing"), "only directories are opened")
+
+	// Pushes are for the repository's server to refuse
+	resp, err := http.Get(server.URL + "/team/project/info/refs?service=git-receive-pack")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+}
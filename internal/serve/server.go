@@ -0,0 +1,584 @@
+// Package serve serves repositories to other machines.
+//
+// Server speaks Git's smart HTTP protocol, so a served repository can be
+// cloned and fetched by vcs or git, shallowly too. It is read-only unless
+// pushes are allowed, in which case receive-pack accepts the pushes a
+// Policy permits.
+// Large files are served over the Git LFS batch API alongside. RootServer
+// serves every repository under a directory, which is what `vcs serve` is
+// built on, and Daemon serves them over the git:// protocol for
+// `vcs daemon`.
+// Responder and Resolve advertise and find served
+// repositories on the local network over multicast DNS, which is what
+// `vcs share` and vcs-local:// URLs are built on.
+package serve
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/packfile"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/internal/transport"
+)
+
+// agent is advertised to clients as the server implementation
+const agent = "vcs/1.0"
+
+// Server serves a repository over Git's smart HTTP protocol. Pushes are
+// refused unless AllowPush is called.
+type Server struct {
+	gitDir  string
+	storage *objects.Storage
+	refs    *refs.RefManager
+	packing packfile.WriterOptions
+	push    bool
+	policy  Policy
+	hooks   Hooks
+}
+
+// NewServer creates a server for the repository at gitDir, reading objects
+// from storage
+func NewServer(gitDir string, storage *objects.Storage) *Server {
+	return &Server{
+		gitDir:  gitDir,
+		storage: storage,
+		refs:    refs.NewRefManager(gitDir),
+		packing: packfile.DefaultWriterOptions(),
+	}
+}
+
+// SetPackOptions sets how packs sent to clients are built
+func (s *Server) SetPackOptions(opts packfile.WriterOptions) {
+	s.packing = opts
+}
+
+// AllowPush accepts pushes that policy permits
+func (s *Server) AllowPush(policy Policy) {
+	s.push = true
+	s.policy = policy
+}
+
+// ServeHTTP implements http.Handler
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	switch {
+	case strings.HasSuffix(r.URL.Path, "/info/refs") && r.Method == http.MethodGet:
+		service := r.URL.Query().Get("service")
+		switch {
+		case service == "git-upload-pack":
+		case service == "git-receive-pack" && s.push:
+		case service == "git-receive-pack":
+			http.Error(w, "this repository is read-only", http.StatusForbidden)
+			return
+		default:
+			http.Error(w, "only git-upload-pack and git-receive-pack are served", http.StatusForbidden)
+			return
+		}
+		s.advertiseRefs(w, r, service)
+	case strings.HasSuffix(r.URL.Path, "/git-upload-pack") && r.Method == http.MethodPost:
+		s.uploadPack(w, r)
+	case strings.HasSuffix(r.URL.Path, "/git-receive-pack") && r.Method == http.MethodPost && s.push:
+		s.receivePack(w, r)
+	case strings.HasSuffix(r.URL.Path, "/git-receive-pack"):
+		http.Error(w, "this repository is read-only", http.StatusForbidden)
+	case strings.Contains(r.URL.Path, lfsPrefix):
+		s.serveLFS(w, r)
+	default:
+		http.NotFound(w, r)
+	}
+}
+
+// advertisedRefs returns the refs offered to clients, and the branch HEAD
+// points to
+func (s *Server) advertisedRefs() (map[string]objects.ObjectID, string, error) {
+	all, err := s.refs.AllRefs()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to list refs: %w", err)
+	}
+
+	head, _ := s.refs.SymbolicHEAD()
+	if id, _, err := s.refs.HEAD(); err == nil {
+		all["HEAD"] = id
+	}
+	return all, head, nil
+}
+
+// advertiseRefs writes the ref advertisement that starts every fetch and
+// push
+func (s *Server) advertiseRefs(w http.ResponseWriter, r *http.Request, service string) {
+	var buf bytes.Buffer
+	pw := newPktLineWriter(&buf)
+	pw.Writef("# service=%s\n", service)
+	pw.Flush()
+	if err := s.writeAdvertisement(pw, service); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	// Clients polling for changes revalidate with the ETag and are spared
+	// the advertisement while the refs stay the same
+	sum := sha1.Sum(buf.Bytes())
+	etag := `"` + hex.EncodeToString(sum[:]) + `"`
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("ETag", etag)
+	if etagMatches(r.Header.Get("If-None-Match"), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-"+service+"-advertisement")
+	w.Write(buf.Bytes())
+}
+
+// writeAdvertisement writes the refs offered for service with the
+// capabilities, ending with a flush-pkt
+func (s *Server) writeAdvertisement(pw *transport.PktLineWriter, service string) error {
+	all, head, err := s.advertisedRefs()
+	if err != nil {
+		return err
+	}
+
+	capabilities := []string{"multi_ack_detailed", "no-done", "side-band-64k", "side-band", "ofs-delta", "allow-reachable-sha1-in-want", "shallow", "deepen-since", "deepen-not", "agent=" + agent}
+	if service == "git-receive-pack" {
+		// Pushes update refs by name, so HEAD is not offered
+		capabilities = receiveCapabilities
+		delete(all, "HEAD")
+	} else if head != "" {
+		capabilities = append(capabilities, "symref=HEAD:"+head)
+	}
+
+	// HEAD comes first, then the refs in sorted order
+	names := make([]string, 0, len(all))
+	for name := range all {
+		if name != "HEAD" {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	if _, ok := all["HEAD"]; ok {
+		names = append([]string{"HEAD"}, names...)
+	}
+
+	if len(names) == 0 {
+		// An empty repository still has to send its capabilities
+		pw.Writef("%s capabilities^{}\x00%s\n", objects.ObjectID{}, strings.Join(capabilities, " "))
+	}
+	for i, name := range names {
+		if i == 0 {
+			pw.Writef("%s %s\x00%s\n", all[name], name, strings.Join(capabilities, " "))
+			continue
+		}
+		pw.Writef("%s %s\n", all[name], name)
+	}
+	return pw.Flush()
+}
+
+// AdvertiseRefs writes the ref advertisement for service, git-upload-pack
+// or git-receive-pack, that starts a fetch or push over a connection
+func (s *Server) AdvertiseRefs(w io.Writer, service string) error {
+	return s.writeAdvertisement(newPktLineWriter(w), service)
+}
+
+// newPktLineWriter frames the server's side of the protocol, which the
+// packet trace shows as sent by the server
+func newPktLineWriter(w io.Writer) *transport.PktLineWriter {
+	return transport.NewPktLineWriter(w).TraceAs("server")
+}
+
+// newPktLineReader reads the client's side of the protocol, traced as
+// received by the server
+func newPktLineReader(r io.Reader) *transport.PktLineReader {
+	return transport.NewPktLineReader(r).TraceAs("server")
+}
+
+// etagMatches reports whether an If-None-Match header lists etag
+func etagMatches(header, etag string) bool {
+	for _, candidate := range strings.Split(header, ",") {
+		candidate = strings.TrimPrefix(strings.TrimSpace(candidate), "W/")
+		if candidate == etag || candidate == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// uploadRequest is the part of an upload-pack request the server acts on
+type uploadRequest struct {
+	wants        []objects.ObjectID
+	haves        []objects.ObjectID
+	capabilities []string
+	shallow      Deepen
+	done         bool
+	// wantsOnly is set when nothing followed the wants, as in the first
+	// request of a stateless shallow fetch
+	wantsOnly bool
+}
+
+// parseUploadRequest reads the wants, haves, capabilities and shallow lines
+// sent by a client
+func parseUploadRequest(r io.Reader) (*uploadRequest, error) {
+	req := &uploadRequest{}
+	pr := newPktLineReader(r)
+
+	flushed := false
+	for {
+		line, err := pr.ReadLine()
+		if err == transport.ErrFlushPkt {
+			flushed = true
+			continue
+		}
+		if err == io.EOF {
+			req.wantsOnly = flushed && len(req.haves) == 0
+			return req, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read request: %w", err)
+		}
+
+		flushed = false
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		switch fields[0] {
+		case "want", "have":
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("malformed %s line", fields[0])
+			}
+			id, err := objects.NewObjectID(fields[1])
+			if err != nil {
+				return nil, fmt.Errorf("invalid object ID in %q: %w", line, err)
+			}
+			if fields[0] == "have" {
+				req.haves = append(req.haves, id)
+				continue
+			}
+			if len(req.wants) == 0 {
+				req.capabilities = fields[2:]
+			}
+			req.wants = append(req.wants, id)
+		case "done":
+			req.done = true
+			return req, nil
+		default:
+			ok, err := req.shallow.parseLine(fields)
+			if err != nil {
+				return nil, err
+			}
+			if !ok {
+				return nil, fmt.Errorf("unexpected line %q", line)
+			}
+		}
+	}
+}
+
+// uploadPack answers a negotiation with a pack of everything reachable from
+// the wants that is not reachable from the haves. Clients without
+// multi_ack_detailed are told NAK; a request not ending in done is a round
+// of the negotiation and gets the pack only when the client allows no-done
+// and the server is ready. A client deepening its history hears of the
+// commits it ends at before every answer, and only of them when it sent
+// nothing but the wants.
+func (s *Server) uploadPack(w http.ResponseWriter, r *http.Request) {
+	body, err := requestBody(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	defer body.Close()
+
+	req, err := parseUploadRequest(body)
+
+	w.Header().Set("Content-Type", "application/x-git-upload-pack-result")
+	w.Header().Set("Cache-Control", "no-cache")
+	pw := newPktLineWriter(w)
+
+	if err == nil {
+		err = s.CheckWants(req.wants)
+	}
+	var cut *historyCut
+	if err == nil {
+		cut, err = s.cutHistory(req.wants, &req.shallow)
+	}
+	if err != nil {
+		pw.Writef("ERR %v\n", err)
+		return
+	}
+	if req.shallow.deepens() {
+		cut.writeUpdate(pw)
+		if req.wantsOnly {
+			// The client asked for the shallow commits alone
+			return
+		}
+	}
+
+	if !hasCapability(req.capabilities, "multi_ack_detailed") {
+		pw.WriteString("NAK\n")
+		if !req.done {
+			// A stateless client sends another round ending in done
+			return
+		}
+	} else if !s.acknowledge(pw, req) {
+		return
+	}
+
+	s.sendPack(w, req.wants, req.haves, req.capabilities, cut)
+}
+
+// sendPack writes the pack of everything reachable from wants that is not
+// reachable from haves, over side-band when capabilities ask for it. The
+// history in it ends where cut says, when it is not nil.
+func (s *Server) sendPack(w io.Writer, wants, haves []objects.ObjectID, capabilities []string, cut *historyCut) error {
+	pw := newPktLineWriter(w)
+	objs, err := s.packObjects(wants, haves, cut)
+	if err != nil {
+		pw.Writef("ERR %v\n", err)
+		return err
+	}
+
+	opts := s.packing
+	opts.OffsetDeltas = hasCapability(capabilities, "ofs-delta")
+
+	size := 0
+	switch {
+	case hasCapability(capabilities, "side-band-64k"):
+		size = transport.MaxSideband64kData
+	case hasCapability(capabilities, "side-band"):
+		size = transport.MaxSidebandData
+	}
+	if size == 0 {
+		_, err := packfile.WritePack(w, objs, opts)
+		return err
+	}
+
+	// Over side-band the pack follows the progress and ends with a
+	// flush-pkt
+	progress := transport.NewSidebandWriter(w, transport.SidebandProgress, size).TraceAs("server")
+	fmt.Fprintf(progress, "Enumerating objects: %d, done.\n", len(objs))
+	if _, err := packfile.WritePack(transport.NewSidebandWriter(w, transport.SidebandData, size).TraceAs("server"), objs, opts); err != nil {
+		fmt.Fprintf(transport.NewSidebandWriter(w, transport.SidebandError, size).TraceAs("server"), "%v\n", err)
+		return err
+	}
+	return pw.Flush()
+}
+
+// WritePack writes a pack of everything reachable from wants that is not
+// reachable from haves, with offset deltas
+func (s *Server) WritePack(w io.Writer, wants, haves []objects.ObjectID) error {
+	return s.writePack(w, wants, haves, nil)
+}
+
+// writePack writes the pack of WritePack with the history ending where cut
+// says, when it is not nil
+func (s *Server) writePack(w io.Writer, wants, haves []objects.ObjectID, cut *historyCut) error {
+	objs, err := s.packObjects(wants, haves, cut)
+	if err != nil {
+		return err
+	}
+	opts := s.packing
+	opts.OffsetDeltas = true
+	_, err = packfile.WritePack(w, objs, opts)
+	return err
+}
+
+// acknowledge answers the haves of a multi_ack_detailed client and reports
+// whether the pack is to follow. Every have the server has is common, and
+// one is enough for it to be ready, as all that is reachable from it is
+// left out of the pack. A round ends with NAK, followed by the final ACK
+// when no-done lets the pack come at once; done is answered with the final
+// ACK of the last common have, or NAK when there is none.
+func (s *Server) acknowledge(pw *transport.PktLineWriter, req *uploadRequest) bool {
+	var last objects.ObjectID
+	for _, id := range req.haves {
+		if s.storage.HasObject(id) {
+			pw.Writef("ACK %s common\n", id)
+			last = id
+		}
+	}
+
+	if req.done {
+		if last.IsZero() {
+			pw.WriteString("NAK\n")
+		} else {
+			pw.Writef("ACK %s\n", last)
+		}
+		return true
+	}
+
+	ready := !last.IsZero()
+	if ready {
+		pw.Writef("ACK %s ready\n", last)
+	}
+	pw.WriteString("NAK\n")
+	if ready && hasCapability(req.capabilities, "no-done") {
+		pw.Writef("ACK %s\n", last)
+		return true
+	}
+	return false
+}
+
+// CheckWants refuses objects that are not reachable from an advertised
+// ref, so unreachable objects are never handed out. Objects other than ref
+// tips may be asked for, as allow-reachable-sha1-in-want promises.
+func (s *Server) CheckWants(wants []objects.ObjectID) error {
+	if len(wants) == 0 {
+		return fmt.Errorf("no wants")
+	}
+
+	all, _, err := s.advertisedRefs()
+	if err != nil {
+		return err
+	}
+	tips := make(map[objects.ObjectID]bool, len(all))
+	for _, id := range all {
+		tips[id] = true
+	}
+
+	var missing []objects.ObjectID
+	for _, id := range wants {
+		if !tips[id] {
+			missing = append(missing, id)
+		}
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	// Only walking the history finds the others
+	roots := make([]objects.ObjectID, 0, len(tips))
+	for id := range tips {
+		roots = append(roots, id)
+	}
+	reachable := make(map[objects.ObjectID]bool)
+	if _, err := s.walk(roots, reachable, nil, nil); err != nil {
+		return err
+	}
+	for _, id := range missing {
+		if !reachable[id] {
+			return fmt.Errorf("upload-pack: not our ref %s", id)
+		}
+	}
+	return nil
+}
+
+// packObjects reads every object reachable from wants that is not reachable
+// from a have the server knows. The pack bitmap, when there is one, spares
+// walking the history it covers. With a cut the history of the haves ends
+// at the client's shallow commits and that of the wants where the cut
+// ends it, which the bitmap cannot tell.
+func (s *Server) packObjects(wants, haves []objects.ObjectID, cut *historyCut) ([]*packfile.Object, error) {
+	var theirs, ends map[objects.ObjectID]bool
+	if cut != nil {
+		theirs, ends = cut.theirs, cut.ends
+		wants = append(append([]objects.ObjectID(nil), wants...), cut.wants...)
+	}
+
+	bitmap, err := packfile.StorageBitmap(s.storage)
+	if err != nil {
+		return nil, err
+	}
+	if bitmap != nil && cut == nil {
+		ids, err := bitmap.CountObjects(s.storage, wants, haves)
+		if err != nil {
+			return nil, err
+		}
+		return packfile.ReadObjects(s.storage, ids)
+	}
+
+	common := make(map[objects.ObjectID]bool)
+	if _, err := s.walk(haves, common, nil, theirs); err != nil {
+		return nil, err
+	}
+
+	paths := make(map[objects.ObjectID]string)
+	ids, err := s.walk(wants, common, paths, ends)
+	if err != nil {
+		return nil, err
+	}
+
+	objs := make([]*packfile.Object, 0, len(ids))
+	for _, id := range ids {
+		objType, data, err := s.storage.ReadRawObject(id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read object %s: %w", id, err)
+		}
+		objs = append(objs, &packfile.Object{ID: id, Type: objType, Data: data, Path: paths[id]})
+	}
+	return objs, nil
+}
+
+// walk marks every object reachable from roots in seen and returns the ones
+// not seen before. Missing objects are skipped, since a have may be unknown
+// here and shallow history ends at absent parents. When paths is non-nil it
+// records tree entry names as delta search hints. The parents of the
+// commits in ends are not walked.
+func (s *Server) walk(roots []objects.ObjectID, seen map[objects.ObjectID]bool, paths map[objects.ObjectID]string, ends map[objects.ObjectID]bool) ([]objects.ObjectID, error) {
+	var order []objects.ObjectID
+	stack := append([]objects.ObjectID(nil), roots...)
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[id] || !s.storage.HasObject(id) {
+			continue
+		}
+
+		obj, err := s.storage.ReadObject(id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read object %s: %w", id, err)
+		}
+		seen[id] = true
+		order = append(order, id)
+
+		switch o := obj.(type) {
+		case *objects.Commit:
+			stack = append(stack, o.Tree())
+			if !ends[id] {
+				stack = append(stack, o.Parents()...)
+			}
+		case *objects.Tree:
+			for _, entry := range o.Entries() {
+				// Submodule commits live in another repository
+				if entry.Mode == objects.ModeCommit {
+					continue
+				}
+				if _, ok := paths[entry.ID]; !ok && paths != nil {
+					paths[entry.ID] = entry.Name
+				}
+				stack = append(stack, entry.ID)
+			}
+		case *objects.Tag:
+			stack = append(stack, o.Object())
+		}
+	}
+	return order, nil
+}
+
+// requestBody returns the body of r, decompressed when the client gzipped it
+func requestBody(r *http.Request) (io.ReadCloser, error) {
+	if r.Header.Get("Content-Encoding") != "gzip" {
+		return r.Body, nil
+	}
+	gz, err := gzip.NewReader(r.Body)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gzip request body")
+	}
+	return gz, nil
+}
+
+// hasCapability reports whether name is among the requested capabilities
+func hasCapability(capabilities []string, name string) bool {
+	for _, capability := range capabilities {
+		if capability == name {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,235 @@
+package serve
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/packfile"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/internal/transport"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// commitFile commits a single file on top of parents and moves main to it
+func commitFile(t *testing.T, repo *vcs.Repository, name, content string, parents ...objects.ObjectID) objects.ObjectID {
+	sig := objects.Signature{Name: "Test", Email: "test@example.com", When: time.Unix(1700000000, 0)}
+	blob, err := repo.CreateBlob([]byte(content))
+	require.NoError(t, err)
+	tree, err := repo.CreateTree([]objects.TreeEntry{{Mode: objects.ModeBlob, Name: name, ID: blob.ID()}})
+	require.NoError(t, err)
+	commit, err := repo.CreateCommit(tree.ID(), parents, sig, sig, content)
+	require.NoError(t, err)
+	require.NoError(t, refs.NewRefManager(repo.GitDir()).UpdateRef("refs/heads/main", commit.ID()))
+	return commit.ID()
+}
+
+func newTestServer(t *testing.T) (*vcs.Repository, *transport.HTTPTransport) {
+	repo, err := vcs.Init(filepath.Join(t.TempDir(), "src"))
+	require.NoError(t, err)
+	require.NoError(t, refs.NewRefManager(repo.GitDir()).SetHEAD("refs/heads/main"))
+
+	server := httptest.NewServer(NewServer(repo.GitDir(), repo.Storage()))
+	t.Cleanup(server.Close)
+	return repo, transport.NewHTTPTransport(server.URL)
+}
+
+func fetch(t *testing.T, client *transport.HTTPTransport, want objects.ObjectID, haves ...string) []objects.ObjectID {
+	dst := objects.NewStorage(t.TempDir())
+	require.NoError(t, dst.Init())
+
+	resp, err := client.Fetch(context.Background(), &transport.FetchRequest{
+		Wants:        []string{want.String()},
+		Haves:        haves,
+		Capabilities: []string{"ofs-delta"},
+	})
+	require.NoError(t, err)
+	defer resp.Close()
+
+	result, err := packfile.Unpack(resp.Pack, dst)
+	require.NoError(t, err)
+	return result.Objects
+}
+
+func TestServer_Fetch(t *testing.T) {
+	repo, client := newTestServer(t)
+	first := commitFile(t, repo, "a.txt", "one\n")
+
+	discovery, err := client.DiscoverRefs(context.Background(), "git-upload-pack")
+	require.NoError(t, err)
+	assert.Equal(t, first.String(), discovery.Refs["refs/heads/main"])
+	assert.Equal(t, "refs/heads/main", discovery.HeadRef())
+	assert.True(t, discovery.HasCapability("ofs-delta"))
+
+	assert.Len(t, fetch(t, client, first), 3, "commit, tree and blob")
+
+	// Objects reachable from a have are not sent again
+	second := commitFile(t, repo, "a.txt", "two\n", first)
+	got := fetch(t, client, second, first.String())
+	assert.Len(t, got, 3)
+	assert.NotContains(t, got, first)
+}
+
+func TestServer_Negotiation(t *testing.T) {
+	repo, err := vcs.Init(filepath.Join(t.TempDir(), "src"))
+	require.NoError(t, err)
+	require.NoError(t, refs.NewRefManager(repo.GitDir()).SetHEAD("refs/heads/main"))
+	first := commitFile(t, repo, "a.txt", "one\n")
+	second := commitFile(t, repo, "a.txt", "two\n", first)
+
+	handler := NewServer(repo.GitDir(), repo.Storage())
+	rounds := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodPost {
+			rounds++
+		}
+		handler.ServeHTTP(w, r)
+	}))
+	t.Cleanup(server.Close)
+	client := transport.NewHTTPTransport(server.URL)
+
+	discovery, err := client.DiscoverRefs(context.Background(), "git-upload-pack")
+	require.NoError(t, err)
+	for _, name := range []string{"multi_ack_detailed", "no-done", "side-band-64k", "side-band"} {
+		assert.True(t, discovery.HasCapability(name), name)
+	}
+
+	// The only have the server knows is sent in the second round
+	var haves []string
+	for i := 0; i < 60; i++ {
+		haves = append(haves, fmt.Sprintf("%040x", i+1))
+	}
+	haves[20] = first.String()
+
+	tests := []struct {
+		capabilities []string
+		rounds       int
+	}{
+		{[]string{"ofs-delta"}, 1},
+		{[]string{"multi_ack_detailed", "side-band", "ofs-delta"}, 3},
+		{[]string{"multi_ack_detailed", "no-done", "side-band-64k", "ofs-delta"}, 2},
+	}
+	for _, tt := range tests {
+		rounds = 0
+		var progress strings.Builder
+		resp, err := client.Fetch(context.Background(), &transport.FetchRequest{
+			Wants:        []string{second.String()},
+			Haves:        haves,
+			Capabilities: tt.capabilities,
+			Progress:     &progress,
+		})
+		require.NoError(t, err, tt.capabilities)
+
+		dst := objects.NewStorage(t.TempDir())
+		require.NoError(t, dst.Init())
+		result, err := packfile.Unpack(resp.Pack, dst)
+		require.NoError(t, err)
+		resp.Close()
+
+		assert.Len(t, result.Objects, 3, tt.capabilities)
+		assert.NotContains(t, result.Objects, first)
+		assert.Equal(t, tt.rounds, rounds, tt.capabilities)
+		if len(tt.capabilities) > 1 {
+			assert.Equal(t, []string{first.String()}, resp.Acks[len(resp.Acks)-1:])
+			assert.Equal(t, "Enumerating objects: 3, done.\n", progress.String())
+		}
+	}
+}
+
+func TestServer_EmptyRepository(t *testing.T) {
+	_, client := newTestServer(t)
+
+	discovery, err := client.DiscoverRefs(context.Background(), "git-upload-pack")
+	require.NoError(t, err)
+	assert.Equal(t, "refs/heads/main", discovery.HeadRef())
+	assert.True(t, discovery.HasCapability("ofs-delta"))
+}
+
+func TestServer_RejectsUnadvertisedWants(t *testing.T) {
+	repo, client := newTestServer(t)
+	commitFile(t, repo, "a.txt", "one\n")
+	blob, err := repo.CreateBlob([]byte("secret\n"))
+	require.NoError(t, err)
+
+	_, err = client.Fetch(context.Background(), &transport.FetchRequest{Wants: []string{blob.ID().String()}})
+	assert.ErrorContains(t, err, "not our ref")
+}
+
+func TestServer_AllowsReachableWants(t *testing.T) {
+	repo, client := newTestServer(t)
+	first := commitFile(t, repo, "a.txt", "one\n")
+	commitFile(t, repo, "a.txt", "two\n", first)
+
+	discovery, err := client.DiscoverRefs(context.Background(), "git-upload-pack")
+	require.NoError(t, err)
+	assert.True(t, discovery.HasCapability("allow-reachable-sha1-in-want"))
+
+	// A commit below the tip can be asked for by ID
+	got := fetch(t, client, first)
+	assert.Len(t, got, 3)
+	assert.Contains(t, got, first)
+}
+
+func TestServer_ReadOnly(t *testing.T) {
+	repo, err := vcs.Init(t.TempDir())
+	require.NoError(t, err)
+	server := httptest.NewServer(NewServer(repo.GitDir(), repo.Storage()))
+	defer server.Close()
+
+	resp, err := http.Get(server.URL + "/info/refs?service=git-receive-pack")
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp, err = http.Post(server.URL+"/git-receive-pack", "application/x-git-receive-pack-request", strings.NewReader("0000"))
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+
+	resp, err = http.Get(server.URL + "/objects/info/packs")
+	require.NoError(t, err)
+	io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusNotFound, resp.StatusCode)
+}
+
+func TestServer_RefAdvertisementETag(t *testing.T) {
+	repo, client := newTestServer(t)
+	first := commitFile(t, repo, "a.txt", "first\n")
+	client.SetRefCache(transport.NewRefCache(t.TempDir()))
+
+	discovery, err := client.DiscoverRefs(context.Background(), "git-upload-pack")
+	require.NoError(t, err)
+	assert.False(t, discovery.Cached)
+
+	// Unchanged refs are revalidated, not sent again
+	discovery, err = client.DiscoverRefs(context.Background(), "git-upload-pack")
+	require.NoError(t, err)
+	assert.True(t, discovery.Cached)
+	assert.Equal(t, first.String(), discovery.Refs["refs/heads/main"])
+	assert.Equal(t, "refs/heads/main", discovery.HeadRef())
+
+	second := commitFile(t, repo, "a.txt", "second\n", first)
+	discovery, err = client.DiscoverRefs(context.Background(), "git-upload-pack")
+	require.NoError(t, err)
+	assert.False(t, discovery.Cached)
+	assert.Equal(t, second.String(), discovery.Refs["refs/heads/main"])
+}
+
+func TestEtagMatches(t *testing.T) {
+	assert.True(t, etagMatches(`"abc"`, `"abc"`))
+	assert.True(t, etagMatches(`"x", W/"abc"`, `"abc"`))
+	assert.True(t, etagMatches(`*`, `"abc"`))
+	assert.False(t, etagMatches(``, `"abc"`))
+	assert.False(t, etagMatches(`"abcd"`, `"abc"`))
+}
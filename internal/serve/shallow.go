@@ -0,0 +1,326 @@
+package serve
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/transport"
+)
+
+// Deepen is what a shallow fetch asks of the history sent: the commits the
+// client's history already ends at, and where to cut the history
+type Deepen struct {
+	// Shallows are the client's shallow commits, whose parents it lacks
+	Shallows []objects.ObjectID
+	// Depth is the number of commits sent from each want
+	Depth int
+	// Since leaves out commits older than it
+	Since time.Time
+	// Not leaves out the history of these refs
+	Not []string
+}
+
+// parseLine reads a shallow, deepen, deepen-since or deepen-not line,
+// reporting whether fields were one
+func (req *Deepen) parseLine(fields []string) (bool, error) {
+	switch fields[0] {
+	case "shallow", "deepen", "deepen-since", "deepen-not":
+	default:
+		return false, nil
+	}
+	if len(fields) != 2 {
+		return true, fmt.Errorf("malformed %s line", fields[0])
+	}
+
+	switch fields[0] {
+	case "shallow":
+		id, err := objects.NewObjectID(fields[1])
+		if err != nil {
+			return true, fmt.Errorf("invalid object ID in shallow line: %w", err)
+		}
+		req.Shallows = append(req.Shallows, id)
+	case "deepen":
+		depth, err := strconv.Atoi(fields[1])
+		if err != nil || depth <= 0 {
+			return true, fmt.Errorf("invalid deepen: %s", fields[1])
+		}
+		req.Depth = depth
+	case "deepen-since":
+		seconds, err := strconv.ParseInt(fields[1], 10, 64)
+		if err != nil {
+			return true, fmt.Errorf("invalid deepen-since: %s", fields[1])
+		}
+		req.Since = time.Unix(seconds, 0)
+	case "deepen-not":
+		req.Not = append(req.Not, fields[1])
+	}
+	return true, nil
+}
+
+// deepens reports whether the client asked for the history to be cut, and
+// so waits for the shallow commits before the acknowledgments
+func (req *Deepen) deepens() bool {
+	return req.Depth > 0 || !req.Since.IsZero() || len(req.Not) > 0
+}
+
+// historyCut is where the history sent to a client ends
+type historyCut struct {
+	// ends are the commits whose parents are not sent, and theirs those
+	// whose parents the client lacks
+	ends   map[objects.ObjectID]bool
+	theirs map[objects.ObjectID]bool
+	// shallow are the commits the client is to record as shallow, and
+	// unshallow its shallow commits whose parents it now gets
+	shallow   []objects.ObjectID
+	unshallow []objects.ObjectID
+	// wants are the parents of the unshallowed commits, wanted as well
+	wants []objects.ObjectID
+}
+
+// cutHistory works out where the history of wants sent for req ends. It
+// is nil when the client neither is shallow nor asks to be.
+func (s *Server) cutHistory(wants []objects.ObjectID, req *Deepen) (*historyCut, error) {
+	if !req.deepens() && len(req.Shallows) == 0 {
+		return nil, nil
+	}
+	if req.Depth > 0 && (!req.Since.IsZero() || len(req.Not) > 0) {
+		return nil, fmt.Errorf("deepen and deepen-since (or deepen-not) cannot be used together")
+	}
+
+	cut := &historyCut{ends: make(map[objects.ObjectID]bool), theirs: make(map[objects.ObjectID]bool)}
+	for _, id := range req.Shallows {
+		cut.theirs[id] = true
+	}
+	if !req.deepens() {
+		// The history sent ends where the client's does
+		for id := range cut.theirs {
+			cut.ends[id] = true
+		}
+		return cut, nil
+	}
+
+	// The commits sent, and for each whether its parents are sent too
+	included, err := s.selectShallowHistory(wants, req)
+	if err != nil {
+		return nil, err
+	}
+	for id, whole := range included {
+		if !whole {
+			cut.ends[id] = true
+			if !cut.theirs[id] {
+				cut.shallow = append(cut.shallow, id)
+			}
+		}
+	}
+	for _, id := range req.Shallows {
+		whole, ok := included[id]
+		if !ok {
+			// Not sent, so still where the client's history ends
+			cut.ends[id] = true
+			continue
+		}
+		if whole {
+			commit, err := s.readCommit(id)
+			if err != nil {
+				return nil, err
+			}
+			cut.unshallow = append(cut.unshallow, id)
+			cut.wants = append(cut.wants, commit.Parents()...)
+		}
+	}
+	sortObjectIDs(cut.shallow)
+	return cut, nil
+}
+
+// ShallowUpdate returns the commits a client fetching wants as deepen asks
+// is to record as shallow, and those of its shallow commits it is not to
+// any longer
+func (s *Server) ShallowUpdate(wants []objects.ObjectID, deepen Deepen) (shallow, unshallow []objects.ObjectID, err error) {
+	if !deepen.deepens() {
+		return nil, nil, nil
+	}
+	cut, err := s.cutHistory(wants, &deepen)
+	if err != nil {
+		return nil, nil, err
+	}
+	return cut.shallow, cut.unshallow, nil
+}
+
+// WriteShallowPack writes the pack of WritePack with the history cut as
+// deepen asks, ending where ShallowUpdate says the client's now does
+func (s *Server) WriteShallowPack(w io.Writer, wants, haves []objects.ObjectID, deepen Deepen) error {
+	cut, err := s.cutHistory(wants, &deepen)
+	if err != nil {
+		return err
+	}
+	return s.writePack(w, wants, haves, cut)
+}
+
+// selectShallowHistory returns the commits of the history of wants to send
+// for req, each with whether its parents are sent as well. With a depth
+// the commits that far from a want end the history; with deepen-since and
+// deepen-not it is the commits older than the date or reachable from the
+// refs that are left out, ending the history at the commits they are
+// parents of.
+func (s *Server) selectShallowHistory(wants []objects.ObjectID, req *Deepen) (map[objects.ObjectID]bool, error) {
+	excluded := make(map[objects.ObjectID]bool)
+	for _, name := range req.Not {
+		id, err := s.resolveDeepenNot(name)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.walkCommits([]objects.ObjectID{id}, excluded); err != nil {
+			return nil, err
+		}
+	}
+
+	// Breadth first, so each commit is reached at its least depth
+	depths := make(map[objects.ObjectID]int)
+	included := make(map[objects.ObjectID]bool)
+	var queue []objects.ObjectID
+	for _, id := range wants {
+		id, err := s.peelToCommit(id)
+		if err != nil {
+			return nil, err
+		}
+		if id.IsZero() || excluded[id] {
+			continue
+		}
+		if _, ok := depths[id]; !ok {
+			depths[id] = 1
+			queue = append(queue, id)
+		}
+	}
+	for len(queue) > 0 {
+		id := queue[0]
+		queue = queue[1:]
+		commit, err := s.readCommit(id)
+		if err != nil {
+			return nil, err
+		}
+		if !req.Since.IsZero() && commit.Committer().When.Before(req.Since) {
+			continue
+		}
+		included[id] = false
+		if req.Depth > 0 && depths[id] >= req.Depth {
+			continue
+		}
+
+		whole := true
+		for _, parent := range commit.Parents() {
+			if excluded[parent] || !s.storage.HasObject(parent) {
+				whole = false
+				continue
+			}
+			if req.Since.IsZero() {
+				if _, ok := depths[parent]; !ok {
+					depths[parent] = depths[id] + 1
+					queue = append(queue, parent)
+				}
+				continue
+			}
+			parentCommit, err := s.readCommit(parent)
+			if err != nil {
+				return nil, err
+			}
+			if parentCommit.Committer().When.Before(req.Since) {
+				whole = false
+			} else if _, ok := depths[parent]; !ok {
+				depths[parent] = depths[id] + 1
+				queue = append(queue, parent)
+			}
+		}
+		included[id] = whole
+	}
+	if len(included) == 0 {
+		return nil, fmt.Errorf("no commits selected for shallow requests")
+	}
+	return included, nil
+}
+
+// resolveDeepenNot resolves the ref of a deepen-not line, full or short
+func (s *Server) resolveDeepenNot(name string) (objects.ObjectID, error) {
+	for _, ref := range []string{name, "refs/" + name, "refs/tags/" + name, "refs/heads/" + name} {
+		if !strings.HasPrefix(ref, "refs/") {
+			continue
+		}
+		if id, err := s.refs.ResolveRef(ref); err == nil {
+			return s.peelToCommit(id)
+		}
+	}
+	return objects.ObjectID{}, fmt.Errorf("git upload-pack: deepen-not is not a ref: %s", name)
+}
+
+// walkCommits marks every commit reachable from roots in seen
+func (s *Server) walkCommits(roots []objects.ObjectID, seen map[objects.ObjectID]bool) error {
+	stack := append([]objects.ObjectID(nil), roots...)
+	for len(stack) > 0 {
+		id := stack[len(stack)-1]
+		stack = stack[:len(stack)-1]
+		if seen[id] || !s.storage.HasObject(id) {
+			continue
+		}
+		commit, err := s.readCommit(id)
+		if err != nil {
+			return err
+		}
+		seen[id] = true
+		stack = append(stack, commit.Parents()...)
+	}
+	return nil
+}
+
+// peelToCommit follows tags from id to the commit they tag, or returns the
+// zero ID when they end at another kind of object
+func (s *Server) peelToCommit(id objects.ObjectID) (objects.ObjectID, error) {
+	for {
+		obj, err := s.storage.ReadObject(id)
+		if err != nil {
+			return objects.ObjectID{}, fmt.Errorf("failed to read object %s: %w", id, err)
+		}
+		switch o := obj.(type) {
+		case *objects.Commit:
+			return id, nil
+		case *objects.Tag:
+			id = o.Object()
+		default:
+			return objects.ObjectID{}, nil
+		}
+	}
+}
+
+// readCommit reads the commit id
+func (s *Server) readCommit(id objects.ObjectID) (*objects.Commit, error) {
+	obj, err := s.storage.ReadObject(id)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read commit %s: %w", id, err)
+	}
+	commit, ok := obj.(*objects.Commit)
+	if !ok {
+		return nil, fmt.Errorf("%s is not a commit", id)
+	}
+	return commit, nil
+}
+
+// writeUpdate tells the client of the commits its history now ends at and
+// of those it no longer does, ending with a flush-pkt
+func (c *historyCut) writeUpdate(pw *transport.PktLineWriter) error {
+	for _, id := range c.shallow {
+		pw.Writef("shallow %s\n", id)
+	}
+	for _, id := range c.unshallow {
+		pw.Writef("unshallow %s\n", id)
+	}
+	return pw.Flush()
+}
+
+// sortObjectIDs sorts ids by their hex form, so the shallow commits are
+// sent in a stable order
+func sortObjectIDs(ids []objects.ObjectID) {
+	sort.Slice(ids, func(i, j int) bool { return ids[i].String() < ids[j].String() })
+}
@@ -0,0 +1,153 @@
+package serve
+
+import (
+	"context"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/packfile"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/internal/transport"
+)
+
+// shallowFetch fetches want with req's shallow fields and returns the
+// response with the objects of its pack
+func shallowFetch(t *testing.T, client *transport.HTTPTransport, want objects.ObjectID, req transport.FetchRequest) (*transport.FetchResponse, []objects.ObjectID) {
+	dst := objects.NewStorage(t.TempDir())
+	require.NoError(t, dst.Init())
+
+	req.Wants = []string{want.String()}
+	req.Capabilities = []string{"ofs-delta", "shallow"}
+	resp, err := client.Fetch(context.Background(), &req)
+	require.NoError(t, err)
+	defer resp.Close()
+
+	result, err := packfile.Unpack(resp.Pack, dst)
+	require.NoError(t, err)
+	return resp, result.Objects
+}
+
+func TestServer_ShallowFetch(t *testing.T) {
+	repo, client := newTestServer(t)
+	first := commitFile(t, repo, "a.txt", "one\n")
+	second := commitFile(t, repo, "a.txt", "two\n", first)
+	third := commitFile(t, repo, "a.txt", "three\n", second)
+
+	discovery, err := client.DiscoverRefs(context.Background(), "git-upload-pack")
+	require.NoError(t, err)
+	for _, capability := range []string{"shallow", "deepen-since", "deepen-not"} {
+		assert.True(t, discovery.HasCapability(capability), capability)
+	}
+	assert.False(t, discovery.HasCapability("deepen-relative"))
+
+	resp, got := shallowFetch(t, client, third, transport.FetchRequest{Depth: 1})
+	assert.Equal(t, []string{third.String()}, resp.Shallows)
+	assert.Empty(t, resp.Unshallows)
+	assert.Len(t, got, 3, "commit, tree and blob of the tip only")
+	assert.Contains(t, got, third)
+
+	// Deepening moves the boundary back a commit and sends only that one
+	resp, got = shallowFetch(t, client, third, transport.FetchRequest{
+		Depth:    2,
+		Shallows: []string{third.String()},
+		Haves:    []string{third.String()},
+	})
+	assert.Equal(t, []string{second.String()}, resp.Shallows)
+	assert.Equal(t, []string{third.String()}, resp.Unshallows)
+	assert.Len(t, got, 3)
+	assert.Contains(t, got, second)
+	assert.NotContains(t, got, third)
+
+	// The root is shallow too when the depth ends at it
+	resp, _ = shallowFetch(t, client, third, transport.FetchRequest{Depth: 3})
+	assert.Equal(t, []string{first.String()}, resp.Shallows)
+
+	// The history reachable from deepen-not is left out
+	require.NoError(t, refs.NewRefManager(repo.GitDir()).CreateTag("v1", first))
+	resp, got = shallowFetch(t, client, third, transport.FetchRequest{DeepenNot: []string{"v1"}})
+	assert.Equal(t, []string{second.String()}, resp.Shallows)
+	assert.NotContains(t, got, first)
+	assert.Contains(t, got, second)
+}
+
+func TestServer_ShallowFetchErrors(t *testing.T) {
+	repo, client := newTestServer(t)
+	first := commitFile(t, repo, "a.txt", "one\n")
+	require.NoError(t, refs.NewRefManager(repo.GitDir()).CreateTag("v1", first))
+
+	for name, req := range map[string]transport.FetchRequest{
+		"nothing selected": {DeepenNot: []string{"v1"}},
+		"unknown ref":      {DeepenNot: []string{"nope"}},
+		"depth and refs":   {Depth: 1, DeepenNot: []string{"v1"}},
+	} {
+		t.Run(name, func(t *testing.T) {
+			req.Wants = []string{first.String()}
+			_, err := client.Fetch(context.Background(), &req)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestServer_GitShallowClone(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	repo, _ := newTestServer(t)
+	first := commitFile(t, repo, "a.txt", "one\n")
+	second := commitFile(t, repo, "a.txt", "two\n", first)
+	third := commitFile(t, repo, "a.txt", "three\n", second)
+	server := httptest.NewServer(NewServer(repo.GitDir(), repo.Storage()))
+	t.Cleanup(server.Close)
+
+	dir := filepath.Join(t.TempDir(), "clone")
+	git := func(args ...string) string {
+		cmd := exec.Command("git", args...)
+		cmd.Env = append(os.Environ(), "GIT_CONFIG_NOSYSTEM=1", "HOME="+t.TempDir())
+		out, err := cmd.CombinedOutput()
+		require.NoError(t, err, string(out))
+		return strings.TrimSpace(string(out))
+	}
+
+	git("clone", "-q", "--depth", "1", server.URL, dir)
+	assert.Equal(t, "1", git("-C", dir, "rev-list", "--count", "HEAD"))
+	assert.Equal(t, third.String(), git("-C", dir, "rev-parse", "HEAD"))
+	shallow, err := os.ReadFile(filepath.Join(dir, ".git", "shallow"))
+	require.NoError(t, err)
+	assert.Equal(t, third.String()+"\n", string(shallow))
+
+	git("-C", dir, "fetch", "-q", "--depth", "2")
+	assert.Equal(t, "2", git("-C", dir, "rev-list", "--count", "HEAD"))
+
+	git("-C", dir, "fetch", "-q", "--unshallow")
+	assert.Equal(t, "3", git("-C", dir, "rev-list", "--count", "HEAD"))
+	git("-C", dir, "fsck", "--no-progress")
+}
+
+func TestDaemon_GitShallowClone(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	repo, addr := startDaemon(t, DefaultDaemonOptions(), false)
+	require.NoError(t, refs.NewRefManager(repo.GitDir()).SetHEAD("refs/heads/main"))
+	first := commitFile(t, repo, "a.txt", "one\n")
+	second := commitFile(t, repo, "a.txt", "two\n", first)
+	require.NoError(t, os.WriteFile(filepath.Join(repo.GitDir(), ExportOKFile), nil, 0644))
+
+	dir := filepath.Join(t.TempDir(), "clone")
+	cmd := exec.Command("git", "clone", "-q", "--depth", "1", "git://"+addr+"/project", dir)
+	cmd.Env = append(os.Environ(), "GIT_CONFIG_NOSYSTEM=1", "HOME="+t.TempDir())
+	out, err := cmd.CombinedOutput()
+	require.NoError(t, err, string(out))
+
+	shallow, err := os.ReadFile(filepath.Join(dir, ".git", "shallow"))
+	require.NoError(t, err)
+	assert.Equal(t, second.String()+"\n", string(shallow))
+}
@@ -0,0 +1,170 @@
+package serve
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/transport"
+)
+
+// UploadPack serves a fetch over a connection such as an SSH channel or a
+// git:// socket, reading the client's side from r and writing the
+// server's to w
+func (s *Server) UploadPack(r io.Reader, w io.Writer) error {
+	return s.uploadPackStream(newPktLineReader(r), w)
+}
+
+// ReceivePack serves a push over a connection, as UploadPack serves a
+// fetch. Pushes are refused unless AllowPush was called.
+func (s *Server) ReceivePack(r io.Reader, w io.Writer) error {
+	if !s.push {
+		newPktLineWriter(w).WriteString("ERR this repository is read-only\n")
+		return fmt.Errorf("this repository is read-only")
+	}
+	return s.receivePackStream(newPktLineReader(r), w)
+}
+
+// uploadPackStream serves a fetch over a connection: the advertisement,
+// then the wants, then rounds of haves each ending in a flush-pkt until the
+// client sends done, then the pack. Clients with multi_ack_detailed hear of
+// every common have and that the server is ready; others only hear of the
+// first common have. A client deepening its history hears of the commits
+// it ends at once the wants are in.
+func (s *Server) uploadPackStream(pr *transport.PktLineReader, w io.Writer) error {
+	pw := newPktLineWriter(w)
+	if err := s.writeAdvertisement(pw, "git-upload-pack"); err != nil {
+		return err
+	}
+
+	var wants []objects.ObjectID
+	var capabilities []string
+	var shallow Deepen
+	for {
+		line, err := pr.ReadLine()
+		if err == transport.ErrFlushPkt {
+			break
+		}
+		if err == io.EOF && len(wants) == 0 {
+			// The client only wanted the advertisement
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read wants: %w", err)
+		}
+		fields := strings.Fields(line)
+		if len(fields) > 0 && fields[0] != "want" {
+			ok, err := shallow.parseLine(fields)
+			if ok && err == nil {
+				continue
+			}
+			if err == nil {
+				err = fmt.Errorf("unexpected line %q", line)
+			}
+			pw.Writef("ERR %v\n", err)
+			return err
+		}
+		if len(fields) < 2 {
+			pw.Writef("ERR unexpected line %q\n", line)
+			return fmt.Errorf("unexpected line %q", line)
+		}
+		id, err := objects.NewObjectID(fields[1])
+		if err != nil {
+			return fmt.Errorf("invalid object ID in %q: %w", line, err)
+		}
+		if len(wants) == 0 {
+			capabilities = fields[2:]
+		}
+		wants = append(wants, id)
+	}
+	if len(wants) == 0 {
+		return nil
+	}
+	if err := s.CheckWants(wants); err != nil {
+		pw.Writef("ERR %v\n", err)
+		return err
+	}
+	cut, err := s.cutHistory(wants, &shallow)
+	if err != nil {
+		pw.Writef("ERR %v\n", err)
+		return err
+	}
+	if shallow.deepens() {
+		if err := cut.writeUpdate(pw); err != nil {
+			return err
+		}
+	}
+
+	detailed := hasCapability(capabilities, "multi_ack_detailed")
+	var common []objects.ObjectID
+	for {
+		line, err := pr.ReadLine()
+		if err == transport.ErrFlushPkt {
+			if !detailed {
+				if len(common) == 0 {
+					pw.WriteString("NAK\n")
+				}
+				continue
+			}
+			if len(common) > 0 {
+				pw.Writef("ACK %s ready\n", common[len(common)-1])
+			}
+			pw.WriteString("NAK\n")
+			continue
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read haves: %w", err)
+		}
+
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 1 && fields[0] == "done":
+			if len(common) == 0 {
+				pw.WriteString("NAK\n")
+			} else if detailed {
+				pw.Writef("ACK %s\n", common[len(common)-1])
+			}
+			return s.sendPack(w, wants, common, capabilities, cut)
+		case len(fields) == 2 && fields[0] == "have":
+			id, err := objects.NewObjectID(fields[1])
+			if err != nil {
+				return fmt.Errorf("invalid object ID in %q: %w", line, err)
+			}
+			if !s.storage.HasObject(id) {
+				continue
+			}
+			common = append(common, id)
+			if detailed {
+				pw.Writef("ACK %s common\n", id)
+			} else if len(common) == 1 {
+				pw.Writef("ACK %s\n", id)
+			}
+		default:
+			pw.Writef("ERR unexpected line %q\n", line)
+			return fmt.Errorf("unexpected line %q", line)
+		}
+	}
+}
+
+// receivePackStream serves a push over a connection: the advertisement,
+// then the commands and their pack, then the report
+func (s *Server) receivePackStream(pr *transport.PktLineReader, w io.Writer) error {
+	if err := s.writeAdvertisement(newPktLineWriter(w), "git-receive-pack"); err != nil {
+		return err
+	}
+
+	updates, capabilities, err := parseReceiveRequest(pr)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			// The client only wanted the advertisement
+			return nil
+		}
+		return err
+	}
+	if len(updates) == 0 {
+		return nil
+	}
+	return s.receive(pr, w, updates, capabilities)
+}
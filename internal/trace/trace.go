@@ -0,0 +1,123 @@
+// Package trace writes diagnostics switched on by environment variables, as
+// Git's GIT_TRACE family does:
+//
+//	VCS_TRACE              commands, hooks, HTTP requests and object store
+//	                       reads and writes
+//	VCS_TRACE_PACKET       every pkt-line sent and received
+//	VCS_TRACE_PERFORMANCE  how long commands and their phases take
+//
+// A variable set to 1, 2 or true traces to standard error, and one set to
+// an absolute path appends to that file. Each line starts with the time of
+// day to the microsecond.
+package trace
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Key is one kind of trace, switched on by its environment variable
+type Key struct {
+	env  string
+	once sync.Once
+	mu   sync.Mutex
+	w    io.Writer
+}
+
+// The kinds of trace
+var (
+	General     = NewKey("VCS_TRACE")
+	Packet      = NewKey("VCS_TRACE_PACKET")
+	Performance = NewKey("VCS_TRACE_PERFORMANCE")
+)
+
+// NewKey creates a trace switched on by the environment variable env
+func NewKey(env string) *Key {
+	return &Key{env: env}
+}
+
+// open reads the environment variable the first time the key is used
+func (k *Key) open() {
+	k.once.Do(func() {
+		value := os.Getenv(k.env)
+		switch strings.ToLower(value) {
+		case "", "0", "false":
+		case "1", "2", "true":
+			k.w = os.Stderr
+		default:
+			if !filepath.IsAbs(value) {
+				fmt.Fprintf(os.Stderr, "warning: %s is neither a boolean nor an absolute path: %s\n", k.env, value)
+				return
+			}
+			f, err := os.OpenFile(value, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "warning: cannot open %s for %s: %v\n", value, k.env, err)
+				return
+			}
+			// The file stays open for the life of the process
+			k.w = f
+		}
+	})
+}
+
+// SetOutput traces to w whatever the environment says, or switches the
+// trace off when w is nil
+func (k *Key) SetOutput(w io.Writer) {
+	k.open()
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.w = w
+}
+
+// Enabled reports whether the trace is on, so callers can skip preparing
+// what they would trace
+func (k *Key) Enabled() bool {
+	k.open()
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.w != nil
+}
+
+// Printf writes a line to the trace when it is on
+func (k *Key) Printf(format string, args ...interface{}) {
+	k.open()
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.w == nil {
+		return
+	}
+	line := time.Now().Format("15:04:05.000000") + " " + fmt.Sprintf(format, args...)
+	io.WriteString(k.w, strings.TrimSuffix(line, "\n")+"\n")
+}
+
+// Since traces how long the operation described by format and args took,
+// having started at start
+func (k *Key) Since(start time.Time, format string, args ...interface{}) {
+	if !k.Enabled() {
+		return
+	}
+	k.Printf("performance: %.9f s: %s", time.Since(start).Seconds(), fmt.Sprintf(format, args...))
+}
+
+// Quote makes data printable for a trace line: a trailing newline is
+// dropped and other bytes that are not printable ASCII are written as
+// octal escapes, as Git's packet trace does
+func Quote(data []byte) string {
+	if n := len(data); n > 0 && data[n-1] == '\n' {
+		data = data[:n-1]
+	}
+	var b strings.Builder
+	for _, c := range data {
+		if c == '\t' || (c >= 0x20 && c < 0x7f) {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "\\%o", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,70 @@
+package trace
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestKeyOutput(t *testing.T) {
+	k := NewKey("VCS_TEST_TRACE_UNSET")
+	if k.Enabled() {
+		t.Fatal("trace enabled without its variable")
+	}
+	k.Printf("not traced")
+
+	var buf bytes.Buffer
+	k.SetOutput(&buf)
+	k.Printf("packet: %s\n", "hello")
+	k.Since(time.Now().Add(-time.Second), "fetch %d", 1)
+	lines := strings.Split(strings.TrimSuffix(buf.String(), "\n"), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("got %q", buf.String())
+	}
+	if !regexp.MustCompile(`^\d\d:\d\d:\d\d\.\d{6} packet: hello$`).MatchString(lines[0]) {
+		t.Errorf("line = %q", lines[0])
+	}
+	if !regexp.MustCompile(`^\S+ performance: 1\.\d{9} s: fetch 1$`).MatchString(lines[1]) {
+		t.Errorf("line = %q", lines[1])
+	}
+
+	k.SetOutput(nil)
+	k.Printf("not traced")
+	if buf.Len() == 0 || strings.Contains(buf.String(), "not traced") {
+		t.Errorf("traced while off: %q", buf.String())
+	}
+}
+
+func TestKeyEnvironment(t *testing.T) {
+	t.Setenv("VCS_TEST_TRACE_OFF", "0")
+	if NewKey("VCS_TEST_TRACE_OFF").Enabled() {
+		t.Error("trace enabled by 0")
+	}
+	t.Setenv("VCS_TEST_TRACE_ON", "true")
+	if !NewKey("VCS_TEST_TRACE_ON").Enabled() {
+		t.Error("trace not enabled by true")
+	}
+
+	path := filepath.Join(t.TempDir(), "trace.log")
+	t.Setenv("VCS_TEST_TRACE_FILE", path)
+	k := NewKey("VCS_TEST_TRACE_FILE")
+	k.Printf("first")
+	k.Printf("second")
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Count(string(data), "\n"); got != 2 || !strings.HasSuffix(string(data), " second\n") {
+		t.Errorf("trace file = %q", data)
+	}
+}
+
+func TestQuote(t *testing.T) {
+	if got := Quote([]byte("want abc\x00agent=vcs\t\x02\xff\n")); got != `want abc\0agent=vcs`+"\t"+`\2\377` {
+		t.Errorf("Quote() = %q", got)
+	}
+}
@@ -4,9 +4,15 @@
 // remote repositories, particularly GitHub. It supports:
 //
 //   - Git HTTP protocol (info/refs and upload-pack endpoints)
-//   - GitHub API integration with token authentication
-//   - URL parsing for various Git URL formats (SSH, HTTPS, shorthand)
-//   - Ref discovery and pack file negotiation
+//   - GitHub, GitLab and Bitbucket API integration with token authentication,
+//     chosen from the remote's host by DetectProvider
+//   - Basic authentication with credentials from git-credential helpers
+//   - URL parsing for various Git URL formats (SCP-like, SSH, HTTPS, shorthand)
+//   - Ref discovery and pack file negotiation, with multi_ack_detailed,
+//     no-done and side-band-64k progress
+//   - Fetches retried with exponential backoff, interrupted pack downloads
+//     resumed with Range requests, and proxies from HTTP_PROXY, HTTPS_PROXY
+//     and NO_PROXY or SetProxy
 //
 // Example usage:
 //
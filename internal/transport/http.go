@@ -8,8 +8,12 @@ import (
 	"io"
 	"net/http"
 	"net/url"
+	"sort"
 	"strings"
 	"time"
+
+	"github.com/fenilsonani/vcs/internal/credential"
+	"github.com/fenilsonani/vcs/internal/trace"
 )
 
 // HTTPTransport implements Git's HTTP transport protocol
@@ -17,23 +21,110 @@ type HTTPTransport struct {
 	client    *http.Client
 	baseURL   string
 	userAgent string
+	limiter   *RateLimiter
+	refCache  *RefCache
+	// retries is how many times fetches are tried again after failing,
+	// the first time after retryBase
+	retries   int
+	retryBase time.Duration
+
+	credentials *credential.Manager
+	// credential is what requests authenticate with, once known
+	credential *credential.Credential
+	// unapproved is set while credential came from credentials and no
+	// request has succeeded with it yet
+	unapproved bool
+	// protocolVersion is the protocol version asked of the server, 0
+	// unless SetProtocolVersion says otherwise
+	protocolVersion int
 }
 
 // NewHTTPTransport creates a new HTTP transport for Git protocol
 func NewHTTPTransport(baseURL string) *HTTPTransport {
 	return &HTTPTransport{
 		client: &http.Client{
-			Timeout: 30 * time.Second,
+			// Proxies are taken from HTTP_PROXY, HTTPS_PROXY and NO_PROXY
+			Transport: http.DefaultTransport.(*http.Transport).Clone(),
+			Timeout:   30 * time.Second,
 		},
 		baseURL:   baseURL,
 		userAgent: "vcs/1.0 (git-http-transport)",
+		retries:   DefaultRetries,
+		retryBase: DefaultRetryDelay,
 	}
 }
 
-// SetCredentials configures authentication for the transport
+// SetCredentials makes requests authenticate with username and password
+// from the start
 func (t *HTTPTransport) SetCredentials(username, password string) {
-	// In a real implementation, this would set up authentication
-	// For GitHub, this would handle personal access tokens
+	t.credential = &credential.Credential{Username: username, Password: password}
+	t.unapproved = false
+}
+
+// SetCredentialManager asks manager for credentials when the server answers
+// 401 Unauthorized, and tells it whether they were accepted
+func (t *HTTPTransport) SetCredentialManager(manager *credential.Manager) {
+	t.credentials = manager
+}
+
+// SetRateLimit caps pack uploads and downloads at bytesPerSecond, or lifts
+// the cap when it is not positive. Throttled transfers are expected to run
+// long, so the overall request timeout is dropped while a limit is set; the
+// request context still cancels them.
+func (t *HTTPTransport) SetRateLimit(bytesPerSecond int64) {
+	t.limiter = NewRateLimiter(bytesPerSecond)
+	if t.limiter != nil {
+		t.client.Timeout = 0
+	}
+}
+
+// SetTimeout limits how long each request may take, including reading its
+// response, or lifts the limit when d is zero. A pack download cut off by
+// it is resumed like one whose connection broke.
+func (t *HTTPTransport) SetTimeout(d time.Duration) {
+	t.client.Timeout = d
+}
+
+// SetRetries makes fetches try failed requests and interrupted pack
+// downloads again up to retries times, waiting delay before the first retry
+// and twice as long before each one after it. Zero retries turns retrying
+// off.
+func (t *HTTPTransport) SetRetries(retries int, delay time.Duration) {
+	t.retries = retries
+	t.retryBase = delay
+}
+
+// SetProxy sends requests through the HTTP proxy at proxyURL, or directly
+// when it is "", rather than through the one the environment names
+func (t *HTTPTransport) SetProxy(proxyURL string) error {
+	rt, ok := t.client.Transport.(*http.Transport)
+	if !ok {
+		// Requests served in this process do not go through proxies
+		return nil
+	}
+	if proxyURL == "" {
+		rt.Proxy = nil
+		return nil
+	}
+	u, err := url.Parse(proxyURL)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid proxy URL '%s'", proxyURL)
+	}
+	rt.Proxy = http.ProxyURL(u)
+	return nil
+}
+
+// SetRefCache revalidates ref advertisements against those kept in cache,
+// so an unchanged advertisement is not downloaded again
+func (t *HTTPTransport) SetRefCache(cache *RefCache) {
+	t.refCache = cache
+}
+
+// SetProtocolVersion asks the server for the given protocol version, sent
+// as the Git-Protocol header. A server that does not speak it answers with
+// its v0 advertisement; RefDiscovery.Version says which one was used.
+func (t *HTTPTransport) SetProtocolVersion(version int) {
+	t.protocolVersion = version
 }
 
 // DiscoverRefs implements the initial ref discovery phase of Git HTTP protocol
@@ -48,13 +139,31 @@ func (t *HTTPTransport) DiscoverRefs(ctx context.Context, service string) (*RefD
 	
 	req.Header.Set("User-Agent", t.userAgent)
 	req.Header.Set("Accept", "*/*")
+	// The cache holds v0 advertisements, which a v2 request must not get
+	refCache := t.refCache
+	if t.protocolVersion == 2 {
+		req.Header.Set("Git-Protocol", "version=2")
+		refCache = nil
+	}
+	etag, cached, haveCached := refCache.Get(reqURL)
+	if haveCached {
+		req.Header.Set("If-None-Match", etag)
+	}
 	
-	resp, err := t.client.Do(req)
+	resp, err := t.do(req, nil)
 	if err != nil {
-		return nil, fmt.Errorf("failed to make request: %w", err)
+		return nil, err
 	}
 	defer resp.Body.Close()
 	
+	if resp.StatusCode == http.StatusNotModified && haveCached {
+		discovery, err := t.parseRefAdvertisement(bytes.NewReader(cached))
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse cached ref advertisement: %w", err)
+		}
+		discovery.Cached = true
+		return discovery, nil
+	}
 	if resp.StatusCode != http.StatusOK {
 		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 	}
@@ -66,19 +175,43 @@ func (t *HTTPTransport) DiscoverRefs(ctx context.Context, service string) (*RefD
 		return nil, fmt.Errorf("unexpected content type: %s", contentType)
 	}
 	
-	return t.parseRefAdvertisement(resp.Body)
+	etag = resp.Header.Get("ETag")
+	if refCache == nil || etag == "" {
+		return t.parseRefAdvertisement(resp.Body)
+	}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read ref advertisement: %w", err)
+	}
+	discovery, err := t.parseRefAdvertisement(bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	// The cache only saves a download, so failing to update it is harmless
+	refCache.Put(reqURL, etag, body)
+	return discovery, nil
 }
 
 // RefDiscovery represents the result of ref discovery
 type RefDiscovery struct {
 	Refs         map[string]string // ref name -> object ID
 	Capabilities []string          // server capabilities
+	Symrefs      map[string]string // symbolic ref name -> target, from symref capabilities
 	Service      string            // service name
+	Cached       bool              // read from the ref cache after the server reported no change
+	Version      int               // protocol version of the advertisement, 2 or 0
 }
 
 // parseRefAdvertisement parses the Git ref advertisement format
 func (t *HTTPTransport) parseRefAdvertisement(r io.Reader) (*RefDiscovery, error) {
-	scanner := bufio.NewScanner(r)
+	br := bufio.NewReader(r)
+
+	// Smart HTTP servers frame the advertisement as pkt-lines
+	if header, err := br.Peek(4); err == nil && isPktLineHeader(header) {
+		return parsePktLineAdvertisement(NewPktLineReader(br))
+	}
+
+	scanner := bufio.NewScanner(br)
 	discovery := &RefDiscovery{
 		Refs: make(map[string]string),
 	}
@@ -113,23 +246,7 @@ func (t *HTTPTransport) parseRefAdvertisement(r io.Reader) (*RefDiscovery, error
 			continue
 		}
 		
-		// Parse "objectid refname [capabilities]"
-		parts := strings.Fields(refLine)
-		if len(parts) >= 2 {
-			objectID := parts[0]
-			refName := parts[1]
-			discovery.Refs[refName] = objectID
-			
-			// Parse capabilities from first ref
-			if len(discovery.Capabilities) == 0 && len(parts) > 2 {
-				// Capabilities are after a null byte
-				capString := strings.Join(parts[2:], " ")
-				if idx := strings.Index(capString, "\x00"); idx >= 0 {
-					capString = capString[idx+1:]
-				}
-				discovery.Capabilities = strings.Fields(capString)
-			}
-		}
+		discovery.addRefLine(refLine)
 	}
 	
 	if err := scanner.Err(); err != nil {
@@ -139,28 +256,269 @@ func (t *HTTPTransport) parseRefAdvertisement(r io.Reader) (*RefDiscovery, error
 	return discovery, nil
 }
 
+// parsePktLineAdvertisement parses a pkt-line framed ref advertisement
+func parsePktLineAdvertisement(pr *PktLineReader) (*RefDiscovery, error) {
+	discovery := &RefDiscovery{
+		Refs: make(map[string]string),
+	}
+
+	for {
+		line, err := pr.ReadLine()
+		if err == ErrFlushPkt {
+			// The service line is followed by a flush before the refs
+			if discovery.Service != "" && len(discovery.Refs) == 0 && len(discovery.Capabilities) == 0 {
+				continue
+			}
+			break
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read ref advertisement: %w", err)
+		}
+
+		if strings.HasPrefix(line, "# service=") {
+			discovery.Service = strings.TrimPrefix(line, "# service=")
+			continue
+		}
+		if strings.HasPrefix(line, "ERR ") {
+			return nil, fmt.Errorf("remote error: %s", strings.TrimPrefix(line, "ERR "))
+		}
+		// A protocol v2 server advertises its capabilities, one a line,
+		// and no refs
+		if line == "version 2" && len(discovery.Refs) == 0 {
+			discovery.Version = 2
+			continue
+		}
+		if discovery.Version == 2 {
+			discovery.Capabilities = append(discovery.Capabilities, line)
+			continue
+		}
+
+		discovery.addRefLine(line)
+	}
+
+	if discovery.Service == "" {
+		return nil, fmt.Errorf("invalid service advertisement")
+	}
+
+	return discovery, nil
+}
+
+// addRefLine records a single "objectid refname[\0capabilities]" line
+func (d *RefDiscovery) addRefLine(refLine string) {
+	var capString string
+	hasCaps := false
+	if idx := strings.IndexByte(refLine, 0); idx >= 0 {
+		capString = refLine[idx+1:]
+		refLine = refLine[:idx]
+		hasCaps = true
+	}
+
+	parts := strings.Fields(refLine)
+	if len(parts) < 2 {
+		return
+	}
+
+	objectID := parts[0]
+	refName := parts[1]
+
+	// Parse capabilities from first ref
+	if len(d.Capabilities) == 0 {
+		if hasCaps {
+			d.Capabilities = strings.Fields(capString)
+		} else if len(parts) > 2 {
+			d.Capabilities = parts[2:]
+		}
+		d.parseSymrefs()
+	}
+
+	// Empty repositories advertise capabilities on a placeholder ref
+	if refName == "capabilities^{}" {
+		return
+	}
+
+	d.Refs[refName] = objectID
+}
+
+// parseSymrefs records the symref=<name>:<target> capabilities, which tell
+// which branch symbolic refs such as HEAD point to
+func (d *RefDiscovery) parseSymrefs() {
+	for _, capability := range d.Capabilities {
+		value, ok := strings.CutPrefix(capability, "symref=")
+		if !ok {
+			continue
+		}
+		if name, target, ok := strings.Cut(value, ":"); ok {
+			if d.Symrefs == nil {
+				d.Symrefs = make(map[string]string)
+			}
+			d.Symrefs[name] = target
+		}
+	}
+}
+
+// HeadRef returns the ref the remote HEAD points to, or "" when it is not
+// known. Servers that do not advertise symrefs only send the object HEAD
+// points to, so the branch is then guessed from the branches at that
+// object, preferring main and master as Git does.
+func (d *RefDiscovery) HeadRef() string {
+	if target, ok := d.Symrefs["HEAD"]; ok {
+		return target
+	}
+
+	head, ok := d.Refs["HEAD"]
+	if !ok {
+		return ""
+	}
+
+	var matches []string
+	for name, id := range d.Refs {
+		if id == head && strings.HasPrefix(name, "refs/heads/") {
+			matches = append(matches, name)
+		}
+	}
+	if len(matches) == 0 {
+		return ""
+	}
+	sort.Strings(matches)
+	for _, preferred := range []string{"refs/heads/main", "refs/heads/master"} {
+		for _, name := range matches {
+			if name == preferred {
+				return name
+			}
+		}
+	}
+	return matches[0]
+}
+
+// isPktLineHeader reports whether b looks like a pkt-line length prefix
+func isPktLineHeader(b []byte) bool {
+	for _, c := range b {
+		if !((c >= '0' && c <= '9') || (c >= 'a' && c <= 'f')) {
+			return false
+		}
+	}
+	return true
+}
+
 // FetchPack performs the pack negotiation and download phase
 func (t *HTTPTransport) FetchPack(ctx context.Context, wants, haves []string) (io.ReadCloser, error) {
+	req := &FetchRequest{
+		Wants: wants,
+		Haves: haves,
+	}
+
+	resp, err := t.postUploadPack(ctx, req, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	return resp.Body, nil
+}
+
+// firstHaveRound is how many haves the first round of a multi_ack_detailed
+// negotiation sends; each further round sends twice as many
+const firstHaveRound = 16
+
+// Fetch negotiates with upload-pack using the given request and returns the
+// parsed response. The caller must Close the response once the pack is read.
+//
+// When the request asks for multi_ack_detailed the haves are sent in rounds
+// of growing size, each repeating those the server found in common, until
+// the server is ready to send the pack or the haves run out. A server
+// granting no-done sends the pack as soon as it is ready.
+func (t *HTTPTransport) Fetch(ctx context.Context, req *FetchRequest) (*FetchResponse, error) {
+	if !containsString(req.Capabilities, "multi_ack_detailed") || len(req.Haves) <= firstHaveRound {
+		return t.fetchRound(ctx, req)
+	}
+
+	var common []string
+	pending := req.Haves
+	for size := firstHaveRound; ; size *= 2 {
+		n := len(pending)
+		if n > size {
+			n = size
+		}
+		round := *req
+		round.Haves = append(append([]string(nil), common...), pending[:n]...)
+		pending = pending[n:]
+		round.negotiating = len(pending) > 0
+
+		resp, err := t.fetchRound(ctx, &round)
+		if err != nil || resp.Pack != nil {
+			return resp, err
+		}
+		resp.Close()
+		for _, id := range resp.Common {
+			// Haves sent again are acknowledged again
+			if !containsString(common, id) {
+				common = append(common, id)
+			}
+		}
+
+		if resp.Ready {
+			// Conclude with what the server has in common with us
+			final := *req
+			final.Haves = common
+			return t.fetchRound(ctx, &final)
+		}
+	}
+}
+
+// FetchV2 sends the request as a protocol v2 fetch command, in one round,
+// to a server whose discovery came back with Version 2. Unlike in v0, the
+// wants may be any commits the server has. The caller must Close the
+// response once the pack is read.
+func (t *HTTPTransport) FetchV2(ctx context.Context, req *FetchRequest) (*FetchResponse, error) {
+	return t.fetchRoundVersion(ctx, req, 2)
+}
+
+// fetchRound sends one request of a negotiation and parses the response
+func (t *HTTPTransport) fetchRound(ctx context.Context, req *FetchRequest) (*FetchResponse, error) {
+	return t.fetchRoundVersion(ctx, req, 0)
+}
+
+// fetchRoundVersion sends one request in the given protocol version and
+// parses the response
+func (t *HTTPTransport) fetchRoundVersion(ctx context.Context, req *FetchRequest, version int) (*FetchResponse, error) {
+	httpResp, err := t.postUploadPack(ctx, req, version)
+	if err != nil {
+		return nil, err
+	}
+
+	parse := ParseFetchResponse
+	if version == 2 {
+		parse = ParseFetchResponseV2
+	}
+	resp, err := parse(httpResp.Body, req)
+	if err != nil {
+		httpResp.Body.Close()
+		return nil, err
+	}
+	resp.body = httpResp.Body
+
+	return resp, nil
+}
+
+// postUploadPack sends a fetch request, encoded for the protocol version,
+// to the upload-pack endpoint
+func (t *HTTPTransport) postUploadPack(ctx context.Context, fetchReq *FetchRequest, version int) (*http.Response, error) {
 	// Git HTTP protocol: POST /git-upload-pack
 	reqURL := fmt.Sprintf("%s/git-upload-pack", t.baseURL)
 	
 	// Build the request body (pack negotiation)
 	var buf bytes.Buffer
-	
-	// Write wants
-	for _, want := range wants {
-		buf.WriteString(fmt.Sprintf("want %s\n", want))
+	encode := fetchReq.Encode
+	if version == 2 {
+		encode = fetchReq.EncodeV2
 	}
-	
-	// Write haves
-	for _, have := range haves {
-		buf.WriteString(fmt.Sprintf("have %s\n", have))
+	if err := encode(&buf); err != nil {
+		return nil, fmt.Errorf("failed to encode fetch request: %w", err)
 	}
 	
-	// End negotiation
-	buf.WriteString("done\n")
-	
-	req, err := http.NewRequestWithContext(ctx, "POST", reqURL, &buf)
+	req, err := http.NewRequestWithContext(ctx, "POST", reqURL, nil)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create request: %w", err)
 	}
@@ -168,10 +526,13 @@ func (t *HTTPTransport) FetchPack(ctx context.Context, wants, haves []string) (i
 	req.Header.Set("User-Agent", t.userAgent)
 	req.Header.Set("Content-Type", "application/x-git-upload-pack-request")
 	req.Header.Set("Accept", "application/x-git-upload-pack-result")
+	if version == 2 {
+		req.Header.Set("Git-Protocol", "version=2")
+	}
 	
-	resp, err := t.client.Do(req)
+	resp, err := t.do(req, buf.Bytes())
 	if err != nil {
-		return nil, fmt.Errorf("failed to make request: %w", err)
+		return nil, err
 	}
 	
 	if resp.StatusCode != http.StatusOK {
@@ -186,26 +547,138 @@ func (t *HTTPTransport) FetchPack(ctx context.Context, wants, haves []string) (i
 		return nil, fmt.Errorf("unexpected content type: %s", contentType)
 	}
 	
-	return resp.Body, nil
+	// A broken download picks up where it stopped
+	if t.retries > 0 {
+		resp.Body = &resumableBody{t: t, req: req, body: buf.Bytes(), rc: resp.Body}
+	}
+	resp.Body = newRateLimitedReadCloser(ctx, resp.Body, t.limiter)
+	return resp, nil
+}
+
+// do sends req, with body when it is not nil, authenticating as the server
+// asks: when it answers 401 Unauthorized, credentials for the URL are
+// filled in and the request is sent again. Credentials the server accepts
+// are approved and ones it refuses rejected, so helpers can store or
+// forget them.
+func (t *HTTPTransport) do(req *http.Request, body []byte) (*http.Response, error) {
+	for {
+		resp, err := t.send(req, body)
+		if err != nil {
+			return nil, fmt.Errorf("failed to make request: %w", err)
+		}
+		if resp.StatusCode != http.StatusUnauthorized {
+			if t.unapproved && resp.StatusCode < http.StatusBadRequest {
+				t.credentials.Approve(t.credential)
+				t.unapproved = false
+			}
+			return resp, nil
+		}
+		// Without credentials to try, the caller reports the status
+		if t.credential == nil && t.credentials == nil {
+			return resp, nil
+		}
+		resp.Body.Close()
+
+		where := redactURL(req.URL)
+		if t.credential != nil {
+			if t.unapproved {
+				t.credentials.Reject(t.credential)
+			}
+			t.credential, t.unapproved = nil, false
+			return nil, fmt.Errorf("authentication failed for '%s'", where)
+		}
+
+		c := credential.FromURL(req.URL)
+		if err := t.credentials.Fill(c); err != nil {
+			return nil, fmt.Errorf("authentication required for '%s': %w", where, err)
+		}
+		t.credential, t.unapproved = c, true
+	}
+}
+
+// send sends req once with the current credentials, or more times when a
+// fetch fails on the way or the server is briefly unavailable
+func (t *HTTPTransport) send(req *http.Request, body []byte) (*http.Response, error) {
+	for n := 0; ; n++ {
+		attempt := req.Clone(req.Context())
+		if body != nil {
+			attempt.Body = io.NopCloser(NewRateLimitedReader(req.Context(), bytes.NewReader(body), t.limiter))
+			attempt.ContentLength = int64(len(body))
+		}
+		if t.credential != nil {
+			attempt.SetBasicAuth(t.credential.Username, t.credential.Password)
+		}
+
+		start := time.Now()
+		resp, err := t.client.Do(attempt)
+		traceRequest(attempt, resp, err, start)
+		failed := (err != nil && !permanentError(err)) || (err == nil && transientStatus(resp.StatusCode))
+		if !failed || n >= t.retries || !retryable(req) || req.Context().Err() != nil {
+			return resp, err
+		}
+		if resp != nil {
+			resp.Body.Close()
+		}
+		if err := wait(req.Context(), t.retryDelay(n, resp)); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// traceRequest traces a request sent at start and how it ended
+func traceRequest(req *http.Request, resp *http.Response, err error, start time.Time) {
+	if !trace.General.Enabled() {
+		return
+	}
+	// The query names the service, so only the password is left out
+	u := *req.URL
+	u.User = nil
+	elapsed := time.Since(start).Round(time.Millisecond)
+	if err != nil {
+		trace.General.Printf("http: %s %s failed after %s: %v", req.Method, u.String(), elapsed, err)
+		return
+	}
+	trace.General.Printf("http: %s %s: %s in %s", req.Method, u.String(), resp.Status, elapsed)
+}
+
+// redactURL returns u without its password and query, for messages
+func redactURL(u *url.URL) string {
+	shown := *u
+	shown.User = nil
+	shown.RawQuery = ""
+	return shown.String()
 }
 
 // ParseGitURL parses a Git URL and returns the HTTP equivalent
 func ParseGitURL(gitURL string) (string, error) {
 	// Handle different Git URL formats
 	
-	// SSH format: git@github.com:user/repo.git
-	if strings.HasPrefix(gitURL, "git@") {
-		parts := strings.SplitN(gitURL, ":", 2)
+	// SSH format: git@github.com:user/repo.git, with any user name
+	if at := strings.Index(gitURL, "@"); at > 0 && !strings.Contains(gitURL, "://") {
+		parts := strings.SplitN(gitURL[at+1:], ":", 2)
 		if len(parts) != 2 {
 			return "", fmt.Errorf("invalid SSH URL format: %s", gitURL)
 		}
 		
-		host := strings.TrimPrefix(parts[0], "git@")
-		path := strings.TrimSuffix(parts[1], ".git")
+		host := parts[0]
+		path := strings.TrimSuffix(strings.TrimPrefix(parts[1], "/"), ".git")
 		
 		return fmt.Sprintf("https://%s/%s", host, path), nil
 	}
 	
+	// SSH and Git protocol URLs: ssh://git@gitlab.com/group/repo.git. The
+	// HTTPS server listens on its own port, so the SSH port is dropped.
+	if strings.HasPrefix(gitURL, "ssh://") || strings.HasPrefix(gitURL, "git+ssh://") || strings.HasPrefix(gitURL, "git://") {
+		u, err := url.Parse(gitURL)
+		if err != nil {
+			return "", fmt.Errorf("invalid URL: %w", err)
+		}
+		if u.Hostname() == "" {
+			return "", fmt.Errorf("invalid SSH URL format: %s", gitURL)
+		}
+		return fmt.Sprintf("https://%s%s", u.Hostname(), strings.TrimSuffix(u.Path, ".git")), nil
+	}
+	
 	// HTTP/HTTPS format
 	if strings.HasPrefix(gitURL, "http://") || strings.HasPrefix(gitURL, "https://") {
 		u, err := url.Parse(gitURL)
@@ -230,10 +703,15 @@ func ParseGitURL(gitURL string) (string, error) {
 	return "", fmt.Errorf("unsupported URL format: %s", gitURL)
 }
 
+// GitHubAPIURL is where the GitHub REST API is served
+const GitHubAPIURL = "https://api.github.com"
+
 // GitHubTransport is a specialized HTTP transport for GitHub
 type GitHubTransport struct {
 	*HTTPTransport
 	token string
+	// apiURL is the REST API root, GitHubAPIURL when empty
+	apiURL string
 }
 
 // NewGitHubTransport creates a new GitHub-specific transport
@@ -250,6 +728,9 @@ func NewGitHubTransport(repoURL, token string) (*GitHubTransport, error) {
 	
 	// Configure GitHub-specific settings
 	transport.userAgent = "vcs/1.0 (GitHub-integration)"
+	if token != "" {
+		transport.SetCredentials("x-access-token", token)
+	}
 	
 	return transport, nil
 }
@@ -288,23 +769,43 @@ func (t *GitHubTransport) DiscoverRefs(ctx context.Context, service string) (*Re
 	return t.parseRefAdvertisement(resp.Body)
 }
 
-// ListRepositoryRefs uses GitHub API to list repository references
-func (t *GitHubTransport) ListRepositoryRefs(ctx context.Context) (map[string]string, error) {
-	// Extract owner/repo from URL
+// SetAPIURL sets the REST API root, for GitHub Enterprise servers
+func (t *GitHubTransport) SetAPIURL(apiURL string) {
+	t.apiURL = strings.TrimSuffix(apiURL, "/")
+}
+
+// repository returns the owner and name of the repository the transport
+// reaches
+func (t *GitHubTransport) repository() (owner, repo string, err error) {
 	u, err := url.Parse(t.baseURL)
 	if err != nil {
-		return nil, fmt.Errorf("invalid URL: %w", err)
+		return "", "", fmt.Errorf("invalid URL: %w", err)
 	}
 	
 	pathParts := strings.Split(strings.Trim(u.Path, "/"), "/")
 	if len(pathParts) != 2 {
-		return nil, fmt.Errorf("invalid GitHub repository URL")
+		return "", "", fmt.Errorf("invalid GitHub repository URL")
+	}
+	return pathParts[0], strings.TrimSuffix(pathParts[1], ".git"), nil
+}
+
+// apiRoot returns the REST API root
+func (t *GitHubTransport) apiRoot() string {
+	if t.apiURL == "" {
+		return GitHubAPIURL
+	}
+	return t.apiURL
+}
+
+// ListRepositoryRefs uses GitHub API to list repository references
+func (t *GitHubTransport) ListRepositoryRefs(ctx context.Context) (map[string]string, error) {
+	owner, repo, err := t.repository()
+	if err != nil {
+		return nil, err
 	}
-	
-	owner, repo := pathParts[0], pathParts[1]
 	
 	// Use GitHub API v3 to list refs
-	apiURL := fmt.Sprintf("https://api.github.com/repos/%s/%s/git/refs", owner, repo)
+	apiURL := fmt.Sprintf("%s/repos/%s/%s/git/refs", t.apiRoot(), owner, repo)
 	
 	req, err := http.NewRequestWithContext(ctx, "GET", apiURL, nil)
 	if err != nil {
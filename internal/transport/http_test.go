@@ -5,11 +5,15 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
 	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/credential"
 )
 
 func TestNewHTTPTransport(t *testing.T) {
@@ -52,6 +56,18 @@ func TestParseGitURL(t *testing.T) {
 			expected: "https://github.com/user/repo",
 			wantErr:  false,
 		},
+		{
+			name:     "SSH format with another user",
+			input:    "org-1234@bitbucket.org:team/repo.git",
+			expected: "https://bitbucket.org/team/repo",
+			wantErr:  false,
+		},
+		{
+			name:     "SSH URL",
+			input:    "ssh://git@gitlab.com:2222/group/sub/repo.git",
+			expected: "https://gitlab.com/group/sub/repo",
+			wantErr:  false,
+		},
 		{
 			name:     "Invalid SSH format",
 			input:    "git@github.com",
@@ -213,6 +229,65 @@ func TestHTTPTransport_FetchPack_Error(t *testing.T) {
 	assert.Contains(t, err.Error(), "unexpected status code: 401")
 }
 
+func TestHTTPTransport_ProtocolV2(t *testing.T) {
+	want := "1111111111111111111111111111111111111111"
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		pw := NewPktLineWriter(w)
+		if r.Header.Get("Git-Protocol") != "version=2" {
+			w.Header().Set("Content-Type", "application/x-git-upload-pack-advertisement")
+			pw.WriteString("# service=git-upload-pack\n")
+			pw.Flush()
+			pw.WriteString(want + " refs/heads/main\x00ofs-delta\n")
+			pw.Flush()
+			return
+		}
+		if r.Method == "GET" {
+			w.Header().Set("Content-Type", "application/x-git-upload-pack-advertisement")
+			pw.WriteString("# service=git-upload-pack\n")
+			pw.Flush()
+			pw.WriteString("version 2\n")
+			pw.WriteString("ls-refs=unborn\n")
+			pw.WriteString("fetch=shallow\n")
+			pw.Flush()
+			return
+		}
+
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		assert.Contains(t, string(body), "command=fetch\n")
+		assert.Contains(t, string(body), "want "+want+"\n")
+
+		w.Header().Set("Content-Type", "application/x-git-upload-pack-result")
+		pw.WriteString("packfile\n")
+		NewSidebandWriter(w, SidebandData, MaxSideband64kData).Write([]byte("PACK"))
+		pw.Flush()
+	}))
+	defer server.Close()
+	ctx := context.Background()
+
+	// Without asking for v2 the server answers in v0
+	discovery, err := NewHTTPTransport(server.URL).DiscoverRefs(ctx, "git-upload-pack")
+	require.NoError(t, err)
+	assert.Equal(t, 0, discovery.Version)
+	assert.Equal(t, want, discovery.Refs["refs/heads/main"])
+
+	transport := NewHTTPTransport(server.URL)
+	transport.SetProtocolVersion(2)
+	discovery, err = transport.DiscoverRefs(ctx, "git-upload-pack")
+	require.NoError(t, err)
+	assert.Equal(t, 2, discovery.Version)
+	assert.Empty(t, discovery.Refs)
+	assert.True(t, discovery.HasCapability("fetch"))
+	assert.True(t, discovery.HasCapability("ls-refs"))
+
+	resp, err := transport.FetchV2(ctx, &FetchRequest{Wants: []string{want}})
+	require.NoError(t, err)
+	defer resp.Close()
+	pack, err := io.ReadAll(resp.Pack)
+	require.NoError(t, err)
+	assert.Equal(t, "PACK", string(pack))
+}
+
 func TestNewGitHubTransport(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -407,11 +482,90 @@ func TestParseRefAdvertisement(t *testing.T) {
 func TestHTTPTransport_SetCredentials(t *testing.T) {
 	transport := NewHTTPTransport("https://example.com")
 	
-	// This method currently doesn't do anything, but we test it for coverage
 	transport.SetCredentials("username", "password")
 	
-	// No assertions needed as the method is a placeholder
 	assert.NotNil(t, transport)
+	assert.Equal(t, "username", transport.credential.Username)
+}
+
+// newAuthServer serves an empty ref advertisement to requests authenticated
+// as alice:secret, counting the 401 answers it gives the others
+func newAuthServer(t *testing.T, refused *int) *httptest.Server {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "alice" || pass != "secret" {
+			*refused++
+			w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-git-upload-pack-advertisement")
+		w.Write([]byte("001e# service=git-upload-pack\n0000"))
+	}))
+	t.Cleanup(server.Close)
+	return server
+}
+
+func TestHTTPTransport_SetCredentialsAuthenticates(t *testing.T) {
+	var refused int
+	server := newAuthServer(t, &refused)
+
+	transport := NewHTTPTransport(server.URL)
+	transport.SetCredentials("alice", "secret")
+	_, err := transport.DiscoverRefs(context.Background(), "git-upload-pack")
+	require.NoError(t, err)
+	assert.Equal(t, 0, refused, "credentials are sent from the first request")
+
+	transport.SetCredentials("alice", "wrong")
+	_, err = transport.DiscoverRefs(context.Background(), "git-upload-pack")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "authentication failed")
+}
+
+func TestHTTPTransport_CredentialManager(t *testing.T) {
+	var refused int
+	server := newAuthServer(t, &refused)
+	store := filepath.Join(t.TempDir(), "credentials")
+
+	prompts := 0
+	password := "wrong"
+	manager := credential.NewManager([]string{"store --file=" + store})
+	manager.SetPrompt(func(c *credential.Credential) error {
+		prompts++
+		c.Username, c.Password = "alice", password
+		return nil
+	})
+
+	// Refused credentials are rejected rather than stored
+	transport := NewHTTPTransport(server.URL)
+	transport.SetCredentialManager(manager)
+	_, err := transport.DiscoverRefs(context.Background(), "git-upload-pack")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "authentication failed for '"+server.URL+"/info/refs'")
+	assert.NoFileExists(t, store)
+
+	password = "secret"
+	transport = NewHTTPTransport(server.URL)
+	transport.SetCredentialManager(manager)
+	_, err = transport.DiscoverRefs(context.Background(), "git-upload-pack")
+	require.NoError(t, err)
+	assert.Equal(t, 2, prompts)
+	data, err := os.ReadFile(store)
+	require.NoError(t, err)
+	assert.Contains(t, string(data), "alice:secret@127.0.0.1")
+
+	// Later requests reuse the credentials without a 401 first
+	refused = 0
+	_, err = transport.DiscoverRefs(context.Background(), "git-upload-pack")
+	require.NoError(t, err)
+	assert.Equal(t, 0, refused)
+
+	// Another transport finds them in the store helper
+	other := credential.NewManager([]string{"store --file=" + store})
+	transport = NewHTTPTransport(server.URL)
+	transport.SetCredentialManager(other)
+	_, err = transport.DiscoverRefs(context.Background(), "git-upload-pack")
+	require.NoError(t, err)
+	assert.Equal(t, 2, prompts)
 }
 
 func TestHTTPTransport_ContextCancellation(t *testing.T) {
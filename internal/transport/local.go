@@ -0,0 +1,96 @@
+package transport
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sync"
+)
+
+// NewHandlerTransport creates a transport whose requests are served by
+// handler in this process rather than sent over the network. Repositories
+// on this machine are reached this way, through the same protocol and
+// negotiation as remote ones.
+func NewHandlerTransport(baseURL string, handler http.Handler) *HTTPTransport {
+	t := NewHTTPTransport(baseURL)
+	// Nothing is sent over a network, so there is nothing to time out or
+	// retry
+	t.client = &http.Client{Transport: handlerRoundTripper{handler: handler}}
+	t.retries = 0
+	return t
+}
+
+// handlerRoundTripper answers requests by calling a handler, streaming its
+// response back as it is written
+type handlerRoundTripper struct {
+	handler http.Handler
+}
+
+func (rt handlerRoundTripper) RoundTrip(req *http.Request) (*http.Response, error) {
+	// Handlers may assume a body, as servers always give them one
+	if req.Body == nil {
+		req.Body = http.NoBody
+	}
+
+	pr, pw := io.Pipe()
+	w := &pipeResponseWriter{header: make(http.Header), body: pw, ready: make(chan struct{})}
+	go func() {
+		defer func() {
+			if v := recover(); v != nil {
+				w.WriteHeader(http.StatusInternalServerError)
+				pw.CloseWithError(fmt.Errorf("handler panicked: %v", v))
+				return
+			}
+			w.WriteHeader(http.StatusOK)
+			pw.Close()
+		}()
+		rt.handler.ServeHTTP(w, req)
+	}()
+
+	select {
+	case <-w.ready:
+	case <-req.Context().Done():
+		pr.CloseWithError(req.Context().Err())
+		return nil, req.Context().Err()
+	}
+	return &http.Response{
+		Status:        fmt.Sprintf("%d %s", w.status, http.StatusText(w.status)),
+		StatusCode:    w.status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        w.sent,
+		Body:          pr,
+		ContentLength: -1,
+		Request:       req,
+	}, nil
+}
+
+// pipeResponseWriter is the http.ResponseWriter of a handler called by
+// handlerRoundTripper. The response is ready once the header is written,
+// and its body is what the handler writes after.
+type pipeResponseWriter struct {
+	header http.Header
+	body   *io.PipeWriter
+	once   sync.Once
+	ready  chan struct{}
+	status int
+	sent   http.Header
+}
+
+func (w *pipeResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *pipeResponseWriter) WriteHeader(status int) {
+	w.once.Do(func() {
+		w.status = status
+		w.sent = w.header.Clone()
+		close(w.ready)
+	})
+}
+
+func (w *pipeResponseWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(p)
+}
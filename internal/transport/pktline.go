@@ -0,0 +1,191 @@
+package transport
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+
+	"github.com/fenilsonani/vcs/internal/trace"
+)
+
+const (
+	// MaxPktLineData is the maximum payload carried by a single pkt-line
+	MaxPktLineData = 65516
+
+	pktFlush = "0000"
+	pktDelim = "0001"
+)
+
+var (
+	// ErrFlushPkt is returned by ReadPacket when a flush-pkt (0000) is read
+	ErrFlushPkt = errors.New("flush packet")
+	// ErrDelimPkt is returned by ReadPacket when a delim-pkt (0001) is read
+	ErrDelimPkt = errors.New("delim packet")
+)
+
+// defaultTraceName is the side of the protocol packets are traced as,
+// unless TraceAs says otherwise
+const defaultTraceName = "vcs"
+
+// packetTracer writes the packets of one stream to the packet trace
+type packetTracer struct {
+	name string
+	// pack is set once a pack starts, after which the packets carrying it
+	// are not traced
+	pack bool
+}
+
+// trace traces data as sent (>) or received (<)
+func (t *packetTracer) trace(direction string, data []byte) {
+	if !trace.Packet.Enabled() {
+		return
+	}
+	if t.pack && len(data) > 0 && data[0] == 1 {
+		return
+	}
+	if bytes.HasPrefix(data, []byte("PACK")) || bytes.HasPrefix(data, []byte("\x01PACK")) {
+		t.pack = true
+		trace.Packet.Printf("packet: %12s%s PACK ...", t.name, direction)
+		return
+	}
+	trace.Packet.Printf("packet: %12s%s %s", t.name, direction, trace.Quote(data))
+}
+
+// PktLineWriter writes data using Git's pkt-line framing
+type PktLineWriter struct {
+	w      io.Writer
+	tracer packetTracer
+}
+
+// NewPktLineWriter creates a new pkt-line writer
+func NewPktLineWriter(w io.Writer) *PktLineWriter {
+	return &PktLineWriter{w: w, tracer: packetTracer{name: defaultTraceName}}
+}
+
+// TraceAs names the side of the protocol the writer's packets are traced
+// as sent by, such as upload-pack
+func (p *PktLineWriter) TraceAs(name string) *PktLineWriter {
+	p.tracer.name = name
+	return p
+}
+
+// WritePacket writes a single pkt-line containing data
+func (p *PktLineWriter) WritePacket(data []byte) error {
+	if len(data) > MaxPktLineData {
+		return fmt.Errorf("pkt-line payload too large: %d bytes", len(data))
+	}
+
+	p.tracer.trace(">", data)
+	header := fmt.Sprintf("%04x", len(data)+4)
+	if _, err := io.WriteString(p.w, header); err != nil {
+		return err
+	}
+	_, err := p.w.Write(data)
+	return err
+}
+
+// WriteString writes a single pkt-line containing s
+func (p *PktLineWriter) WriteString(s string) error {
+	return p.WritePacket([]byte(s))
+}
+
+// Writef formats and writes a single pkt-line
+func (p *PktLineWriter) Writef(format string, args ...interface{}) error {
+	return p.WriteString(fmt.Sprintf(format, args...))
+}
+
+// Flush writes a flush-pkt
+func (p *PktLineWriter) Flush() error {
+	p.tracer.trace(">", []byte(pktFlush))
+	_, err := io.WriteString(p.w, pktFlush)
+	return err
+}
+
+// Delim writes a delim-pkt
+func (p *PktLineWriter) Delim() error {
+	p.tracer.trace(">", []byte(pktDelim))
+	_, err := io.WriteString(p.w, pktDelim)
+	return err
+}
+
+// PktLineReader reads data framed with Git's pkt-line format
+type PktLineReader struct {
+	r      *bufio.Reader
+	tracer packetTracer
+}
+
+// NewPktLineReader creates a new pkt-line reader
+func NewPktLineReader(r io.Reader) *PktLineReader {
+	tracer := packetTracer{name: defaultTraceName}
+	if br, ok := r.(*bufio.Reader); ok {
+		return &PktLineReader{r: br, tracer: tracer}
+	}
+	return &PktLineReader{r: bufio.NewReader(r), tracer: tracer}
+}
+
+// TraceAs names the side of the protocol the reader's packets are traced
+// as received by
+func (p *PktLineReader) TraceAs(name string) *PktLineReader {
+	p.tracer.name = name
+	return p
+}
+
+// ReadPacket reads the next pkt-line payload. Flush and delim packets are
+// reported as ErrFlushPkt and ErrDelimPkt respectively.
+func (p *PktLineReader) ReadPacket() ([]byte, error) {
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(p.r, header); err != nil {
+		return nil, err
+	}
+
+	length, err := strconv.ParseUint(string(header), 16, 16)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pkt-line header: %q", header)
+	}
+
+	switch length {
+	case 0:
+		p.tracer.trace("<", []byte(pktFlush))
+		return nil, ErrFlushPkt
+	case 1:
+		p.tracer.trace("<", []byte(pktDelim))
+		return nil, ErrDelimPkt
+	case 2, 3:
+		return nil, fmt.Errorf("invalid pkt-line length: %d", length)
+	}
+
+	data := make([]byte, length-4)
+	if _, err := io.ReadFull(p.r, data); err != nil {
+		return nil, fmt.Errorf("failed to read pkt-line payload: %w", err)
+	}
+	p.tracer.trace("<", data)
+
+	return data, nil
+}
+
+// ReadLine reads the next pkt-line and returns it as a string without the
+// trailing newline
+func (p *PktLineReader) ReadLine() (string, error) {
+	data, err := p.ReadPacket()
+	if err != nil {
+		return "", err
+	}
+	if n := len(data); n > 0 && data[n-1] == '\n' {
+		data = data[:n-1]
+	}
+	return string(data), nil
+}
+
+// PeekHeader returns the next four bytes without consuming them
+func (p *PktLineReader) PeekHeader() ([]byte, error) {
+	return p.r.Peek(4)
+}
+
+// Reader returns the underlying buffered reader, used once the pkt-line
+// section of a stream ends and raw data (such as a packfile) follows
+func (p *PktLineReader) Reader() *bufio.Reader {
+	return p.r
+}
@@ -0,0 +1,403 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// Names of the hosting providers, as remote.<name>.provider sets them
+const (
+	ProviderGitHub    = "github"
+	ProviderGitLab    = "gitlab"
+	ProviderBitbucket = "bitbucket"
+)
+
+// Provider is a Git hosting service: repositories are fetched from it with
+// the Git HTTP protocol and described by its REST API
+type Provider interface {
+	// Name returns the provider's name, such as ProviderGitLab
+	Name() string
+	// Transport returns the Git HTTP transport of the repository, which
+	// authenticates with the provider's token when one was given
+	Transport() *HTTPTransport
+	// SetAPIURL sets the REST API root, for self-hosted servers
+	SetAPIURL(apiURL string)
+	// DefaultBranch asks the API for the repository's default branch
+	DefaultBranch(ctx context.Context) (string, error)
+}
+
+// RemoteRepository is a repository URL split into the server and the path
+// naming the repository on it
+type RemoteRepository struct {
+	// Host is the server's host name, with the port when one is given
+	Host string
+	// Path names the repository without a leading / or trailing .git, such
+	// as owner/repo, or group/subgroup/repo on GitLab
+	Path string
+}
+
+// ParseRemoteRepository splits a Git URL in any form ParseGitURL accepts
+func ParseRemoteRepository(gitURL string) (*RemoteRepository, error) {
+	httpURL, err := ParseGitURL(gitURL)
+	if err != nil {
+		return nil, err
+	}
+	u, err := url.Parse(httpURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid URL: %w", err)
+	}
+	return &RemoteRepository{
+		Host: u.Host,
+		Path: strings.TrimSuffix(strings.Trim(u.Path, "/"), ".git"),
+	}, nil
+}
+
+// DetectProvider returns the provider serving host: github.com, gitlab.com
+// and hosts named gitlab.*, or bitbucket.org. It returns "" for any other
+// host.
+func DetectProvider(host string) string {
+	host = strings.ToLower(host)
+	if h, _, ok := strings.Cut(host, ":"); ok {
+		host = h
+	}
+	switch {
+	case host == "github.com" || host == "www.github.com":
+		return ProviderGitHub
+	case host == "gitlab.com" || strings.HasPrefix(host, "gitlab."):
+		return ProviderGitLab
+	case host == "bitbucket.org" || host == "www.bitbucket.org":
+		return ProviderBitbucket
+	}
+	return ""
+}
+
+// NewProvider returns the named provider's client for the repository at
+// repoURL, authenticating with token when it is not empty
+func NewProvider(name, repoURL, token string) (Provider, error) {
+	switch name {
+	case ProviderGitHub:
+		return NewGitHubTransport(repoURL, token)
+	case ProviderGitLab:
+		return NewGitLabTransport(repoURL, token)
+	case ProviderBitbucket:
+		return NewBitbucketTransport(repoURL, token)
+	}
+	return nil, fmt.Errorf("unknown provider '%s'", name)
+}
+
+// Name returns ProviderGitHub
+func (t *GitHubTransport) Name() string {
+	return ProviderGitHub
+}
+
+// Transport returns the Git HTTP transport of the repository
+func (t *GitHubTransport) Transport() *HTTPTransport {
+	return t.HTTPTransport
+}
+
+// DefaultBranch asks the GitHub API for the repository's default branch
+func (t *GitHubTransport) DefaultBranch(ctx context.Context) (string, error) {
+	owner, repo, err := t.repository()
+	if err != nil {
+		return "", err
+	}
+	req, err := t.apiRequest(ctx, "GET", fmt.Sprintf("/repos/%s/%s", owner, repo), nil)
+	if err != nil {
+		return "", err
+	}
+	var info struct {
+		DefaultBranch string `json:"default_branch"`
+	}
+	if err := t.callAPI(req, "GitHub", http.StatusOK, &info); err != nil {
+		return "", err
+	}
+	return info.DefaultBranch, nil
+}
+
+// apiRequest creates a request for path below the GitHub API root
+func (t *GitHubTransport) apiRequest(ctx context.Context, method, path string, body []byte) (*http.Request, error) {
+	req, err := http.NewRequestWithContext(ctx, method, t.apiRoot()+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create API request: %w", err)
+	}
+	req.Header.Set("Accept", "application/vnd.github.v3+json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if t.token != "" {
+		req.Header.Set("Authorization", fmt.Sprintf("token %s", t.token))
+	}
+	return req, nil
+}
+
+// GitLabTransport reaches a repository on GitLab.com or a self-hosted
+// GitLab server
+type GitLabTransport struct {
+	*HTTPTransport
+	token   string
+	project string
+	// apiURL is the REST API root, https://<host>/api/v4 when empty
+	apiURL string
+	host   string
+}
+
+// NewGitLabTransport creates a transport for the GitLab repository at
+// repoURL. The token, a personal, project or group access token, is sent to
+// the API and used as the password of Git requests.
+func NewGitLabTransport(repoURL, token string) (*GitLabTransport, error) {
+	httpURL, err := ParseGitURL(repoURL)
+	if err != nil {
+		return nil, err
+	}
+	remote, err := ParseRemoteRepository(httpURL)
+	if err != nil {
+		return nil, err
+	}
+	if !strings.Contains(remote.Path, "/") {
+		return nil, fmt.Errorf("invalid GitLab repository URL")
+	}
+
+	transport := &GitLabTransport{
+		HTTPTransport: NewHTTPTransport(httpURL),
+		token:         token,
+		project:       remote.Path,
+		host:          remote.Host,
+	}
+	transport.userAgent = "vcs/1.0 (GitLab-integration)"
+	if token != "" {
+		transport.SetCredentials("oauth2", token)
+	}
+	return transport, nil
+}
+
+// Name returns ProviderGitLab
+func (t *GitLabTransport) Name() string {
+	return ProviderGitLab
+}
+
+// Transport returns the Git HTTP transport of the repository
+func (t *GitLabTransport) Transport() *HTTPTransport {
+	return t.HTTPTransport
+}
+
+// SetAPIURL sets the REST API root, such as https://gitlab.example.com/api/v4
+func (t *GitLabTransport) SetAPIURL(apiURL string) {
+	t.apiURL = strings.TrimSuffix(apiURL, "/")
+}
+
+// DefaultBranch asks the GitLab API for the project's default branch
+func (t *GitLabTransport) DefaultBranch(ctx context.Context) (string, error) {
+	apiURL := t.apiURL
+	if apiURL == "" {
+		apiURL = "https://" + t.host + "/api/v4"
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", apiURL+"/projects/"+url.PathEscape(t.project), nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create API request: %w", err)
+	}
+	if t.token != "" {
+		req.Header.Set("PRIVATE-TOKEN", t.token)
+	}
+	var info struct {
+		DefaultBranch string `json:"default_branch"`
+	}
+	if err := t.callAPI(req, "GitLab", http.StatusOK, &info); err != nil {
+		return "", err
+	}
+	return info.DefaultBranch, nil
+}
+
+// BitbucketAPIURL is where the Bitbucket Cloud REST API is served
+const BitbucketAPIURL = "https://api.bitbucket.org/2.0"
+
+// BitbucketTransport reaches a repository on Bitbucket Cloud
+type BitbucketTransport struct {
+	*HTTPTransport
+	token     string
+	workspace string
+	slug      string
+	// apiURL is the REST API root, BitbucketAPIURL when empty
+	apiURL string
+}
+
+// NewBitbucketTransport creates a transport for the Bitbucket repository at
+// repoURL. The token, a repository or workspace access token, is sent to the
+// API and used as the password of Git requests.
+func NewBitbucketTransport(repoURL, token string) (*BitbucketTransport, error) {
+	httpURL, err := ParseGitURL(repoURL)
+	if err != nil {
+		return nil, err
+	}
+	remote, err := ParseRemoteRepository(httpURL)
+	if err != nil {
+		return nil, err
+	}
+	workspace, slug, ok := strings.Cut(remote.Path, "/")
+	if !ok || strings.Contains(slug, "/") {
+		return nil, fmt.Errorf("invalid Bitbucket repository URL")
+	}
+
+	transport := &BitbucketTransport{
+		HTTPTransport: NewHTTPTransport(httpURL),
+		token:         token,
+		workspace:     workspace,
+		slug:          slug,
+	}
+	transport.userAgent = "vcs/1.0 (Bitbucket-integration)"
+	if token != "" {
+		transport.SetCredentials("x-token-auth", token)
+	}
+	return transport, nil
+}
+
+// Name returns ProviderBitbucket
+func (t *BitbucketTransport) Name() string {
+	return ProviderBitbucket
+}
+
+// Transport returns the Git HTTP transport of the repository
+func (t *BitbucketTransport) Transport() *HTTPTransport {
+	return t.HTTPTransport
+}
+
+// SetAPIURL sets the REST API root
+func (t *BitbucketTransport) SetAPIURL(apiURL string) {
+	t.apiURL = strings.TrimSuffix(apiURL, "/")
+}
+
+// DefaultBranch asks the Bitbucket API for the repository's main branch
+func (t *BitbucketTransport) DefaultBranch(ctx context.Context) (string, error) {
+	apiURL := t.apiURL
+	if apiURL == "" {
+		apiURL = BitbucketAPIURL
+	}
+	reqURL := fmt.Sprintf("%s/repositories/%s/%s", apiURL, url.PathEscape(t.workspace), url.PathEscape(t.slug))
+	req, err := http.NewRequestWithContext(ctx, "GET", reqURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create API request: %w", err)
+	}
+	if t.token != "" {
+		req.Header.Set("Authorization", "Bearer "+t.token)
+	}
+	var info struct {
+		MainBranch struct {
+			Name string `json:"name"`
+		} `json:"mainbranch"`
+	}
+	if err := t.callAPI(req, "Bitbucket", http.StatusOK, &info); err != nil {
+		return "", err
+	}
+	return info.MainBranch.Name, nil
+}
+
+// callAPI sends a REST API request of provider and decodes the JSON response
+// into v, which must come with status want
+func (t *HTTPTransport) callAPI(req *http.Request, provider string, want int, v interface{}) error {
+	req.Header.Set("User-Agent", t.userAgent)
+	if req.Header.Get("Accept") == "" {
+		req.Header.Set("Accept", "application/json")
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to make API request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != want {
+		return apiError(provider, resp)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(v); err != nil {
+		return fmt.Errorf("failed to parse API response: %w", err)
+	}
+	return nil
+}
+
+// APIError is a failed call to the REST API of a provider
+type APIError struct {
+	// Provider is the provider's display name, such as GitHub
+	Provider   string
+	StatusCode int
+	// Message is what the provider said went wrong, such as why a pull
+	// request could not be validated
+	Message string
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("%s API error: %d", e.Provider, e.StatusCode)
+	}
+	return fmt.Sprintf("%s API error: %d: %s", e.Provider, e.StatusCode, e.Message)
+}
+
+// apiError reads the error a failed API response describes. GitHub sends a
+// message and a list of errors, GitLab a message or an error, and
+// Bitbucket an error object with a message.
+func apiError(provider string, resp *http.Response) error {
+	var body struct {
+		Message json.RawMessage `json:"message"`
+		Error   json.RawMessage `json:"error"`
+		Errors  []struct {
+			Message string `json:"message"`
+			Code    string `json:"code"`
+			Field   string `json:"field"`
+		} `json:"errors"`
+	}
+	apiErr := &APIError{Provider: provider, StatusCode: resp.StatusCode}
+	data, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if json.Unmarshal(data, &body) != nil {
+		return apiErr
+	}
+
+	var details []string
+	for _, raw := range []json.RawMessage{body.Message, body.Error} {
+		if message := errorMessage(raw); message != "" {
+			details = append(details, message)
+		}
+	}
+	for _, e := range body.Errors {
+		switch {
+		case e.Message != "":
+			details = append(details, e.Message)
+		case e.Field != "":
+			details = append(details, e.Field+" "+e.Code)
+		}
+	}
+	apiErr.Message = strings.Join(details, ": ")
+	return apiErr
+}
+
+// errorMessage returns the text of an error field, which is a string, an
+// object with a message, or GitLab's map of field names to complaints
+func errorMessage(raw json.RawMessage) string {
+	if len(raw) == 0 {
+		return ""
+	}
+	var text string
+	if json.Unmarshal(raw, &text) == nil {
+		return text
+	}
+	var object struct {
+		Message string `json:"message"`
+	}
+	if json.Unmarshal(raw, &object) == nil && object.Message != "" {
+		return object.Message
+	}
+	var fields map[string][]string
+	if json.Unmarshal(raw, &fields) == nil {
+		var parts []string
+		for field, complaints := range fields {
+			parts = append(parts, field+" "+strings.Join(complaints, ", "))
+		}
+		sort.Strings(parts)
+		return strings.Join(parts, "; ")
+	}
+	return ""
+}
@@ -0,0 +1,133 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRemoteRepository(t *testing.T) {
+	remote, err := ParseRemoteRepository("git@gitlab.example.com:group/sub/repo.git")
+	require.NoError(t, err)
+	assert.Equal(t, &RemoteRepository{Host: "gitlab.example.com", Path: "group/sub/repo"}, remote)
+
+	remote, err = ParseRemoteRepository("https://bitbucket.org/team/repo.git")
+	require.NoError(t, err)
+	assert.Equal(t, &RemoteRepository{Host: "bitbucket.org", Path: "team/repo"}, remote)
+
+	_, err = ParseRemoteRepository("ftp://example.com/repo")
+	assert.Error(t, err)
+}
+
+func TestDetectProvider(t *testing.T) {
+	tests := map[string]string{
+		"github.com":              ProviderGitHub,
+		"GitHub.com":              ProviderGitHub,
+		"gitlab.com":              ProviderGitLab,
+		"gitlab.example.com:8443": ProviderGitLab,
+		"bitbucket.org":           ProviderBitbucket,
+		"example.com":             "",
+		"notgitlab.com":           "",
+	}
+	for host, want := range tests {
+		assert.Equal(t, want, DetectProvider(host), host)
+	}
+}
+
+func TestNewProvider(t *testing.T) {
+	for _, name := range []string{ProviderGitHub, ProviderGitLab, ProviderBitbucket} {
+		provider, err := NewProvider(name, "https://example.com/owner/repo.git", "")
+		require.NoError(t, err)
+		assert.Equal(t, name, provider.Name())
+		assert.NotNil(t, provider.Transport())
+	}
+
+	_, err := NewProvider("gitea", "https://example.com/owner/repo.git", "")
+	assert.EqualError(t, err, "unknown provider 'gitea'")
+	_, err = NewProvider(ProviderBitbucket, "https://bitbucket.org/team/sub/repo.git", "")
+	assert.EqualError(t, err, "invalid Bitbucket repository URL")
+	_, err = NewProvider(ProviderGitLab, "https://gitlab.com/repo.git", "")
+	assert.EqualError(t, err, "invalid GitLab repository URL")
+}
+
+func TestProviderDefaultBranch(t *testing.T) {
+	tests := []struct {
+		name     string
+		repoURL  string
+		path     string
+		header   string
+		value    string
+		response string
+	}{
+		{ProviderGitHub, "git@github.com:owner/repo.git", "/repos/owner/repo", "Authorization", "token secret", `{"default_branch": "trunk"}`},
+		{ProviderGitLab, "https://gitlab.com/group/sub/repo.git", "/projects/group%2Fsub%2Frepo", "PRIVATE-TOKEN", "secret", `{"default_branch": "trunk"}`},
+		{ProviderBitbucket, "git@bitbucket.org:team/repo.git", "/repositories/team/repo", "Authorization", "Bearer secret", `{"mainbranch": {"name": "trunk", "type": "branch"}}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				assert.Equal(t, tt.path, r.URL.EscapedPath())
+				assert.Equal(t, tt.value, r.Header.Get(tt.header))
+				w.Write([]byte(tt.response))
+			}))
+			defer server.Close()
+
+			provider, err := NewProvider(tt.name, tt.repoURL, "secret")
+			require.NoError(t, err)
+			provider.SetAPIURL(server.URL + "/")
+			branch, err := provider.DefaultBranch(context.Background())
+			require.NoError(t, err)
+			assert.Equal(t, "trunk", branch)
+		})
+	}
+}
+
+func TestProviderTransportSendsToken(t *testing.T) {
+	users := map[string]string{
+		ProviderGitHub:    "x-access-token",
+		ProviderGitLab:    "oauth2",
+		ProviderBitbucket: "x-token-auth",
+	}
+	for name, user := range users {
+		provider, err := NewProvider(name, "https://example.com/owner/repo.git", "secret")
+		require.NoError(t, err)
+		require.NotNil(t, provider.Transport().credential, name)
+		assert.Equal(t, user, provider.Transport().credential.Username, name)
+		assert.Equal(t, "secret", provider.Transport().credential.Password, name)
+	}
+}
+
+func TestProviderAPIError(t *testing.T) {
+	tests := []struct {
+		name     string
+		response string
+		want     string
+	}{
+		{ProviderGitLab, `{"message": "404 Project Not Found"}`, "GitLab API error: 404: 404 Project Not Found"},
+		{ProviderGitLab, `{"message": {"name": ["can't be blank", "is too short"]}}`, "GitLab API error: 404: name can't be blank, is too short"},
+		{ProviderBitbucket, `{"type": "error", "error": {"message": "Repository not found"}}`, "Bitbucket API error: 404: Repository not found"},
+		{ProviderBitbucket, `not json`, "Bitbucket API error: 404"},
+	}
+
+	for _, tt := range tests {
+		server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(tt.response))
+		}))
+
+		provider, err := NewProvider(tt.name, "https://example.com/owner/repo.git", "")
+		require.NoError(t, err)
+		provider.SetAPIURL(server.URL)
+		_, err = provider.DefaultBranch(context.Background())
+		assert.EqualError(t, err, tt.want)
+		var apiErr *APIError
+		assert.True(t, errors.As(err, &apiErr))
+		server.Close()
+	}
+}
@@ -0,0 +1,51 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+)
+
+// NewPullRequest describes a pull request to open on GitHub
+type NewPullRequest struct {
+	Title string `json:"title"`
+	Body  string `json:"body,omitempty"`
+	// Head is the branch holding the changes, as owner:branch when it is in
+	// another repository
+	Head string `json:"head"`
+	// Base is the branch the changes are to be merged into
+	Base  string `json:"base"`
+	Draft bool   `json:"draft,omitempty"`
+}
+
+// PullRequest is a pull request as the GitHub API describes it
+type PullRequest struct {
+	Number  int    `json:"number"`
+	HTMLURL string `json:"html_url"`
+	State   string `json:"state"`
+	Draft   bool   `json:"draft"`
+}
+
+// CreatePullRequest opens a pull request on the repository the transport
+// reaches
+func (t *GitHubTransport) CreatePullRequest(ctx context.Context, pr *NewPullRequest) (*PullRequest, error) {
+	owner, repo, err := t.repository()
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(pr)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := t.apiRequest(ctx, "POST", fmt.Sprintf("/repos/%s/%s/pulls", owner, repo), body)
+	if err != nil {
+		return nil, err
+	}
+	var created PullRequest
+	if err := t.callAPI(req, "GitHub", http.StatusCreated, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
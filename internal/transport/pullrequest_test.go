@@ -0,0 +1,63 @@
+package transport
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGitHubTransport_CreatePullRequest(t *testing.T) {
+	var received NewPullRequest
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "POST", r.Method)
+		assert.Equal(t, "/repos/user/repo/pulls", r.URL.Path)
+		assert.Equal(t, "token secret", r.Header.Get("Authorization"))
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&received))
+
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{"number": 7, "html_url": "https://github.com/user/repo/pull/7", "state": "open", "draft": true}`))
+	}))
+	defer server.Close()
+
+	transport, err := NewGitHubTransport("git@github.com:user/repo.git", "secret")
+	require.NoError(t, err)
+	transport.SetAPIURL(server.URL + "/")
+
+	pr, err := transport.CreatePullRequest(context.Background(), &NewPullRequest{
+		Title: "Add feature",
+		Body:  "Details",
+		Head:  "feature",
+		Base:  "main",
+		Draft: true,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, NewPullRequest{Title: "Add feature", Body: "Details", Head: "feature", Base: "main", Draft: true}, received)
+	assert.Equal(t, 7, pr.Number)
+	assert.Equal(t, "https://github.com/user/repo/pull/7", pr.HTMLURL)
+	assert.True(t, pr.Draft)
+}
+
+func TestGitHubTransport_CreatePullRequestError(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write([]byte(`{"message": "Validation Failed", "errors": [{"resource": "PullRequest", "code": "custom", "message": "A pull request already exists for user:feature."}, {"resource": "PullRequest", "field": "base", "code": "invalid"}]}`))
+	}))
+	defer server.Close()
+
+	transport, err := NewGitHubTransport("https://github.com/user/repo.git", "")
+	require.NoError(t, err)
+	transport.SetAPIURL(server.URL)
+
+	_, err = transport.CreatePullRequest(context.Background(), &NewPullRequest{Title: "x", Head: "feature", Base: "main"})
+	require.Error(t, err)
+	assert.EqualError(t, err, "GitHub API error: 422: Validation Failed: A pull request already exists for user:feature.: base invalid")
+	var apiErr *APIError
+	require.True(t, errors.As(err, &apiErr))
+	assert.Equal(t, http.StatusUnprocessableEntity, apiErr.StatusCode)
+}
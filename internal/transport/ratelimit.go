@@ -0,0 +1,134 @@
+package transport
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// RateLimiter caps the bandwidth of transfers with a token bucket. Tokens
+// are bytes: the bucket refills at the limit and holds one second's worth,
+// so short bursts go through at full speed while the average stays at the
+// limit. One limiter may be shared by several streams to cap them together.
+type RateLimiter struct {
+	mu     sync.Mutex
+	rate   float64 // bytes per second
+	tokens float64
+	last   time.Time
+
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+// NewRateLimiter creates a limiter allowing bytesPerSecond on average, or
+// nil, meaning no limit, when bytesPerSecond is not positive
+func NewRateLimiter(bytesPerSecond int64) *RateLimiter {
+	if bytesPerSecond <= 0 {
+		return nil
+	}
+	return &RateLimiter{
+		rate:   float64(bytesPerSecond),
+		tokens: float64(bytesPerSecond),
+		now:    time.Now,
+		sleep:  sleepContext,
+	}
+}
+
+// Limit returns the average rate allowed in bytes per second
+func (l *RateLimiter) Limit() int64 {
+	return int64(l.rate)
+}
+
+// Take accounts for n bytes that were just transferred, blocking until the
+// bucket has refilled enough to cover them. The bucket goes into debt for
+// transfers larger than what it holds, and the wait pays the debt back.
+func (l *RateLimiter) Take(ctx context.Context, n int) error {
+	l.mu.Lock()
+	now := l.now()
+	if !l.last.IsZero() {
+		l.tokens += now.Sub(l.last).Seconds() * l.rate
+		if l.tokens > l.rate {
+			l.tokens = l.rate
+		}
+	}
+	l.last = now
+	l.tokens -= float64(n)
+
+	var wait time.Duration
+	if l.tokens < 0 {
+		wait = time.Duration(-l.tokens / l.rate * float64(time.Second))
+	}
+	l.mu.Unlock()
+
+	if wait == 0 {
+		return nil
+	}
+	return l.sleep(ctx, wait)
+}
+
+// chunkSize is the most a single read moves, a tenth of a second's worth,
+// so that one large buffer does not arrive as a burst followed by a long
+// pause
+func (l *RateLimiter) chunkSize() int {
+	if size := int(l.rate / 10); size > 0 {
+		return size
+	}
+	return 1
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// rateLimitedReader throttles reads from r with a RateLimiter
+type rateLimitedReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *RateLimiter
+}
+
+// NewRateLimitedReader returns a reader that reads from r no faster than
+// the limiter allows. A nil limiter returns r unchanged.
+func NewRateLimitedReader(ctx context.Context, r io.Reader, limiter *RateLimiter) io.Reader {
+	if limiter == nil {
+		return r
+	}
+	return &rateLimitedReader{ctx: ctx, r: r, limiter: limiter}
+}
+
+func (r *rateLimitedReader) Read(p []byte) (int, error) {
+	if size := r.limiter.chunkSize(); len(p) > size {
+		p = p[:size]
+	}
+
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if waitErr := r.limiter.Take(r.ctx, n); waitErr != nil && err == nil {
+			err = waitErr
+		}
+	}
+	return n, err
+}
+
+// rateLimitedReadCloser throttles a response body while keeping its Close
+type rateLimitedReadCloser struct {
+	io.Reader
+	io.Closer
+}
+
+// newRateLimitedReadCloser wraps rc like NewRateLimitedReader
+func newRateLimitedReadCloser(ctx context.Context, rc io.ReadCloser, limiter *RateLimiter) io.ReadCloser {
+	if limiter == nil {
+		return rc
+	}
+	return rateLimitedReadCloser{Reader: NewRateLimitedReader(ctx, rc, limiter), Closer: rc}
+}
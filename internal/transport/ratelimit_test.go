@@ -0,0 +1,120 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeClock drives a RateLimiter without sleeping, recording the waits
+type fakeClock struct {
+	now    time.Time
+	waited time.Duration
+}
+
+func newFakeLimiter(bytesPerSecond int64) (*RateLimiter, *fakeClock) {
+	clock := &fakeClock{now: time.Unix(1700000000, 0)}
+	limiter := NewRateLimiter(bytesPerSecond)
+	limiter.now = func() time.Time { return clock.now }
+	limiter.sleep = func(ctx context.Context, d time.Duration) error {
+		clock.waited += d
+		clock.now = clock.now.Add(d)
+		return ctx.Err()
+	}
+	return limiter, clock
+}
+
+func TestNewRateLimiter(t *testing.T) {
+	assert.Nil(t, NewRateLimiter(0))
+	assert.Nil(t, NewRateLimiter(-1))
+	assert.Equal(t, int64(1024), NewRateLimiter(1024).Limit())
+}
+
+func TestRateLimiter_Take(t *testing.T) {
+	limiter, clock := newFakeLimiter(1000)
+	ctx := context.Background()
+
+	// A full bucket lets a second's worth through at once
+	require.NoError(t, limiter.Take(ctx, 1000))
+	assert.Zero(t, clock.waited)
+
+	// After that, transfers wait for the bucket to refill
+	require.NoError(t, limiter.Take(ctx, 500))
+	assert.Equal(t, 500*time.Millisecond, clock.waited)
+
+	// Idle time refills the bucket, but never beyond one second's worth
+	clock.now = clock.now.Add(time.Minute)
+	clock.waited = 0
+	require.NoError(t, limiter.Take(ctx, 1500))
+	assert.Equal(t, 500*time.Millisecond, clock.waited)
+}
+
+func TestRateLimiter_Cancel(t *testing.T) {
+	limiter := NewRateLimiter(10)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	require.NoError(t, limiter.Take(ctx, 10))
+	err := limiter.Take(ctx, 10)
+	assert.ErrorIs(t, err, context.Canceled)
+}
+
+func TestRateLimitedReader(t *testing.T) {
+	limiter, clock := newFakeLimiter(100)
+	data := strings.Repeat("x", 300)
+
+	r := NewRateLimitedReader(context.Background(), strings.NewReader(data), limiter)
+	buf := make([]byte, 1000)
+	n, err := r.Read(buf)
+	require.NoError(t, err)
+	assert.Equal(t, 10, n, "reads are capped at a tenth of a second's worth")
+
+	rest, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, data, string(buf[:n])+string(rest))
+	assert.Equal(t, 2*time.Second, clock.waited, "300 bytes at 100/s after a 100 byte burst")
+
+	plain := strings.NewReader(data)
+	assert.Same(t, plain, NewRateLimitedReader(context.Background(), plain, nil))
+}
+
+func TestHTTPTransport_FetchPack_RateLimit(t *testing.T) {
+	packData := bytes.Repeat([]byte("P"), 4096)
+	var received int64
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received = r.ContentLength
+		io.Copy(io.Discard, r.Body)
+		w.Header().Set("Content-Type", "application/x-git-upload-pack-result")
+		w.WriteHeader(http.StatusOK)
+		w.Write(packData)
+	}))
+	defer server.Close()
+
+	transport := NewHTTPTransport(server.URL)
+	transport.SetRateLimit(1024)
+	assert.Zero(t, transport.client.Timeout)
+	limiter, clock := newFakeLimiter(1024)
+	transport.limiter = limiter
+
+	packReader, err := transport.FetchPack(context.Background(), []string{"abc123"}, nil)
+	require.NoError(t, err)
+	defer packReader.Close()
+
+	got, err := io.ReadAll(packReader)
+	require.NoError(t, err)
+	assert.Equal(t, packData, got)
+	assert.Positive(t, received, "the request keeps its content length")
+
+	// The request and the pack together exceed the burst by about 3KB
+	assert.GreaterOrEqual(t, clock.waited, 3*time.Second)
+	assert.Less(t, clock.waited, 4*time.Second)
+}
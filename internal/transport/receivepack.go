@@ -0,0 +1,184 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// RefUpdate is a command of a push: move Ref from Old to New, where a zero
+// Old creates the ref and a zero New deletes it. IDs are hex.
+type RefUpdate struct {
+	Ref string
+	Old string
+	New string
+}
+
+// PushRequest is what a push sends to receive-pack
+type PushRequest struct {
+	Updates      []RefUpdate
+	Capabilities []string
+	// Options are the push options, sent after the commands when the
+	// remote advertised push-options and the request asks for it
+	Options []string
+	// Pack holds the objects the updates need; it may be nil when every
+	// update is a deletion
+	Pack io.Reader
+}
+
+// Encode writes the request in the format receive-pack expects: the
+// commands, the first carrying the capabilities, a flush-pkt, the push
+// options ending in another flush-pkt when there are any, and the pack
+func (r *PushRequest) Encode(w io.Writer) error {
+	pw := NewPktLineWriter(w)
+	for i, u := range r.Updates {
+		line := fmt.Sprintf("%s %s %s", u.Old, u.New, u.Ref)
+		if i == 0 && len(r.Capabilities) > 0 {
+			line += "\x00" + strings.Join(r.Capabilities, " ")
+		}
+		if err := pw.WriteString(line + "\n"); err != nil {
+			return err
+		}
+	}
+	if err := pw.Flush(); err != nil {
+		return err
+	}
+	if len(r.Options) > 0 {
+		for _, option := range r.Options {
+			if err := pw.WriteString(option + "\n"); err != nil {
+				return err
+			}
+		}
+		if err := pw.Flush(); err != nil {
+			return err
+		}
+	}
+	if r.Pack != nil {
+		if _, err := io.Copy(w, r.Pack); err != nil {
+			return fmt.Errorf("failed to send pack: %w", err)
+		}
+	}
+	return nil
+}
+
+// PushResult is the status receive-pack reported for a push
+type PushResult struct {
+	// UnpackError is why the pack could not be unpacked, empty when it was
+	UnpackError string
+	// Refs maps each updated ref to "" when it was accepted, or to the
+	// reason it was refused
+	Refs map[string]string
+	// Statuses are the reported statuses in order, with the options
+	// report-status-v2 adds to them
+	Statuses []RefStatus
+}
+
+// RefStatus is the status receive-pack reported for one command of a push.
+// With report-status-v2 an accepted command may be followed by options
+// saying the remote did something else than asked, such as updating
+// another ref; the fields they set are empty otherwise.
+type RefStatus struct {
+	Ref string
+	// Reason is why the command was refused, empty when it was accepted
+	Reason string
+	// Refname is the ref the remote updated instead of Ref
+	Refname string
+	// OldID and NewID are the values, in hex, the ref was moved between
+	OldID string
+	NewID string
+	// ForcedUpdate says the update was not a fast-forward
+	ForcedUpdate bool
+}
+
+// ParsePushResult reads a report-status or report-status-v2 response
+func ParsePushResult(r io.Reader) (*PushResult, error) {
+	pr := NewPktLineReader(r)
+	line, err := pr.ReadLine()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read push status: %w", err)
+	}
+	unpack, ok := strings.CutPrefix(line, "unpack ")
+	if !ok {
+		return nil, fmt.Errorf("unexpected push status %q", line)
+	}
+
+	result := &PushResult{Refs: make(map[string]string)}
+	if unpack != "ok" {
+		result.UnpackError = unpack
+	}
+	for {
+		line, err := pr.ReadLine()
+		if err == ErrFlushPkt || err == io.EOF {
+			return result, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read push status: %w", err)
+		}
+		if ref, ok := strings.CutPrefix(line, "ok "); ok {
+			result.Refs[ref] = ""
+			result.Statuses = append(result.Statuses, RefStatus{Ref: ref})
+		} else if rest, ok := strings.CutPrefix(line, "ng "); ok {
+			ref, reason, _ := strings.Cut(rest, " ")
+			result.Refs[ref] = reason
+			result.Statuses = append(result.Statuses, RefStatus{Ref: ref, Reason: reason})
+		} else if rest, ok := strings.CutPrefix(line, "option "); ok {
+			if len(result.Statuses) == 0 {
+				return nil, fmt.Errorf("push status option %q before any ref", line)
+			}
+			status := &result.Statuses[len(result.Statuses)-1]
+			key, value, _ := strings.Cut(rest, " ")
+			switch key {
+			case "refname":
+				status.Refname = value
+			case "old-oid":
+				status.OldID = value
+			case "new-oid":
+				status.NewID = value
+			case "forced-update":
+				status.ForcedUpdate = true
+			}
+		}
+	}
+}
+
+// Push sends req to receive-pack and returns the status it reported. It
+// asks for report-status unless req asks for report-status-v2, so each
+// ref's outcome is known.
+func (t *HTTPTransport) Push(ctx context.Context, req *PushRequest) (*PushResult, error) {
+	if !containsString(req.Capabilities, "report-status") && !containsString(req.Capabilities, "report-status-v2") {
+		req.Capabilities = append(req.Capabilities, "report-status")
+	}
+
+	var buf bytes.Buffer
+	if err := req.Encode(&buf); err != nil {
+		return nil, fmt.Errorf("failed to encode push request: %w", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", t.baseURL+"/git-receive-pack", nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %w", err)
+	}
+	httpReq.Header.Set("User-Agent", t.userAgent)
+	httpReq.Header.Set("Content-Type", "application/x-git-receive-pack-request")
+	httpReq.Header.Set("Accept", "application/x-git-receive-pack-result")
+
+	resp, err := t.do(httpReq, buf.Bytes())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		if msg := strings.TrimSpace(string(body)); msg != "" {
+			return nil, fmt.Errorf("unexpected status code: %d: %s", resp.StatusCode, msg)
+		}
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	if contentType := resp.Header.Get("Content-Type"); contentType != "application/x-git-receive-pack-result" {
+		return nil, fmt.Errorf("unexpected content type: %s", contentType)
+	}
+	return ParsePushResult(resp.Body)
+}
@@ -0,0 +1,170 @@
+package transport
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	zeroID = "0000000000000000000000000000000000000000"
+	someID = "1111111111111111111111111111111111111111"
+)
+
+func TestPushRequestEncode(t *testing.T) {
+	req := &PushRequest{
+		Updates: []RefUpdate{
+			{Ref: "refs/heads/main", Old: zeroID, New: someID},
+			{Ref: "refs/heads/old", Old: someID, New: zeroID},
+		},
+		Capabilities: []string{"report-status"},
+		Pack:         strings.NewReader("PACK"),
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, req.Encode(&buf))
+
+	assert.True(t, strings.HasSuffix(buf.String(), "0000PACK"), "the pack follows a flush-pkt")
+	pr := NewPktLineReader(&buf)
+	line, err := pr.ReadLine()
+	require.NoError(t, err)
+	assert.Equal(t, zeroID+" "+someID+" refs/heads/main\x00report-status", line)
+	line, err = pr.ReadLine()
+	require.NoError(t, err)
+	assert.Equal(t, someID+" "+zeroID+" refs/heads/old", line)
+	_, err = pr.ReadLine()
+	assert.Equal(t, ErrFlushPkt, err)
+}
+
+func TestPushRequestEncodeOptions(t *testing.T) {
+	req := &PushRequest{
+		Updates:      []RefUpdate{{Ref: "refs/heads/old", Old: someID, New: zeroID}},
+		Capabilities: []string{"push-options"},
+		Options:      []string{"ci.skip", "reviewer=alice"},
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, req.Encode(&buf))
+
+	// The options follow the commands, each list ending in a flush-pkt
+	pr := NewPktLineReader(&buf)
+	var lines []string
+	for {
+		line, err := pr.ReadLine()
+		if err == io.EOF {
+			break
+		}
+		if err == ErrFlushPkt {
+			line = "flush"
+		} else {
+			require.NoError(t, err)
+		}
+		lines = append(lines, line)
+	}
+	assert.Equal(t, []string{someID + " " + zeroID + " refs/heads/old\x00push-options", "flush", "ci.skip", "reviewer=alice", "flush"}, lines)
+}
+
+func TestParsePushResult(t *testing.T) {
+	var buf bytes.Buffer
+	pw := NewPktLineWriter(&buf)
+	pw.WriteString("unpack ok\n")
+	pw.WriteString("ok refs/heads/main\n")
+	pw.WriteString("ng refs/heads/topic non-fast-forward\n")
+	pw.Flush()
+
+	result, err := ParsePushResult(&buf)
+	require.NoError(t, err)
+	assert.Empty(t, result.UnpackError)
+	assert.Equal(t, map[string]string{"refs/heads/main": "", "refs/heads/topic": "non-fast-forward"}, result.Refs)
+
+	_, err = ParsePushResult(strings.NewReader("0009oops\n"))
+	assert.Error(t, err)
+}
+
+func TestParsePushResultV2(t *testing.T) {
+	var buf bytes.Buffer
+	pw := NewPktLineWriter(&buf)
+	pw.WriteString("unpack ok\n")
+	pw.WriteString("ok refs/for/main\n")
+	pw.WriteString("option refname refs/changes/01/1\n")
+	pw.WriteString("option new-oid " + someID + "\n")
+	pw.WriteString("ok refs/heads/main\n")
+	pw.WriteString("option old-oid " + someID + "\n")
+	pw.WriteString("option forced-update\n")
+	pw.WriteString("ng refs/heads/topic atomic push failure\n")
+	pw.Flush()
+
+	result, err := ParsePushResult(&buf)
+	require.NoError(t, err)
+	assert.Equal(t, []RefStatus{
+		{Ref: "refs/for/main", Refname: "refs/changes/01/1", NewID: someID},
+		{Ref: "refs/heads/main", OldID: someID, ForcedUpdate: true},
+		{Ref: "refs/heads/topic", Reason: "atomic push failure"},
+	}, result.Statuses)
+	assert.Equal(t, "atomic push failure", result.Refs["refs/heads/topic"])
+
+	buf.Reset()
+	pw.WriteString("unpack ok\n")
+	pw.WriteString("option forced-update\n")
+	pw.Flush()
+	_, err = ParsePushResult(&buf)
+	assert.ErrorContains(t, err, "before any ref")
+}
+
+func TestHandlerTransportPush(t *testing.T) {
+	var received []string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/repo/git-receive-pack", r.URL.Path)
+		body, err := io.ReadAll(r.Body)
+		require.NoError(t, err)
+		// The commands end with a flush-pkt, followed by the pack
+		commands, ok := strings.CutSuffix(string(body), "0000PACK")
+		assert.True(t, ok, "the pack follows the commands")
+		pr := NewPktLineReader(strings.NewReader(commands))
+		for {
+			line, err := pr.ReadLine()
+			if err != nil {
+				break
+			}
+			received = append(received, line)
+		}
+
+		w.Header().Set("Content-Type", "application/x-git-receive-pack-result")
+		pw := NewPktLineWriter(w)
+		pw.WriteString("unpack ok\n")
+		pw.WriteString("ng refs/heads/main branch is currently checked out\n")
+		pw.Flush()
+	})
+
+	transport := NewHandlerTransport("file:///repo", handler)
+	result, err := transport.Push(context.Background(), &PushRequest{
+		Updates: []RefUpdate{{Ref: "refs/heads/main", Old: zeroID, New: someID}},
+		Pack:    strings.NewReader("PACK"),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{zeroID + " " + someID + " refs/heads/main\x00report-status"}, received)
+	assert.Equal(t, "branch is currently checked out", result.Refs["refs/heads/main"])
+}
+
+func TestHandlerTransportErrors(t *testing.T) {
+	transport := NewHandlerTransport("file:///repo", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "push not allowed", http.StatusForbidden)
+	}))
+	_, err := transport.Push(context.Background(), &PushRequest{
+		Updates: []RefUpdate{{Ref: "refs/heads/main", Old: zeroID, New: someID}},
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "403: push not allowed")
+
+	transport = NewHandlerTransport("file:///repo", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		panic("boom")
+	}))
+	_, err = transport.DiscoverRefs(context.Background(), "git-upload-pack")
+	assert.Error(t, err)
+}
@@ -0,0 +1,76 @@
+package transport
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+)
+
+// RefCache keeps the ref advertisements servers sent along with their
+// ETags, so that a later discovery can ask with If-None-Match and reuse the
+// cached copy when the server answers 304 Not Modified. Entries are keyed by
+// request URL and are only ever used after the server revalidates them.
+type RefCache struct {
+	dir string
+}
+
+// NewRefCache returns a cache storing its entries in dir, which is created
+// on first use
+func NewRefCache(dir string) *RefCache {
+	return &RefCache{dir: dir}
+}
+
+// path returns the file holding the entry for url. URLs can carry
+// credentials, so only their hash appears on disk.
+func (c *RefCache) path(url string) string {
+	sum := sha1.Sum([]byte(url))
+	return filepath.Join(c.dir, hex.EncodeToString(sum[:]))
+}
+
+// Get returns the cached advertisement for url and its ETag
+func (c *RefCache) Get(url string) (etag string, body []byte, ok bool) {
+	if c == nil {
+		return "", nil, false
+	}
+	data, err := os.ReadFile(c.path(url))
+	if err != nil {
+		return "", nil, false
+	}
+	line, body, found := bytes.Cut(data, []byte("\n"))
+	if !found || len(line) == 0 {
+		return "", nil, false
+	}
+	return string(line), body, true
+}
+
+// Put caches body as the advertisement for url, with the server's etag
+func (c *RefCache) Put(url, etag string, body []byte) error {
+	if c == nil {
+		return nil
+	}
+	if etag == "" || bytes.ContainsAny([]byte(etag), "\r\n") {
+		return fmt.Errorf("invalid ETag %q", etag)
+	}
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return fmt.Errorf("failed to create ref cache: %w", err)
+	}
+
+	// Readers never see a partial entry
+	path := c.path(url)
+	tmp, err := os.CreateTemp(c.dir, ".tmp-")
+	if err != nil {
+		return fmt.Errorf("failed to write ref cache: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+	_, err = fmt.Fprintf(tmp, "%s\n%s", etag, body)
+	if cerr := tmp.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write ref cache: %w", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,145 @@
+package transport
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultRetries is how many times a failed request or an interrupted
+	// pack download is tried again
+	DefaultRetries = 3
+	// DefaultRetryDelay is how long to wait before the first retry; each
+	// further retry waits twice as long as the one before
+	DefaultRetryDelay = 500 * time.Millisecond
+	// maxRetryDelay caps the wait before a retry, including one a server
+	// asks for with Retry-After
+	maxRetryDelay = 30 * time.Second
+)
+
+// retryable reports whether req may be sent again when it fails: fetches
+// may, as they change nothing on the server, but pushes may not
+func retryable(req *http.Request) bool {
+	return req.Method == http.MethodGet || strings.HasSuffix(req.URL.Path, "/git-upload-pack")
+}
+
+// permanentError reports whether err, from sending a request, would only
+// happen again, as when the host does not exist
+func permanentError(err error) bool {
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) && dnsErr.IsNotFound
+}
+
+// transientStatus reports whether a response with status is worth
+// retrying: the server is overloaded or a gateway could not reach it
+func transientStatus(status int) bool {
+	switch status {
+	case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
+
+// retryDelay returns how long to wait before retry n, counting from zero,
+// or longer when resp asks for it with Retry-After in seconds
+func (t *HTTPTransport) retryDelay(n int, resp *http.Response) time.Duration {
+	delay := t.retryBase << n
+	if resp != nil {
+		if seconds, err := strconv.Atoi(resp.Header.Get("Retry-After")); err == nil {
+			if after := time.Duration(seconds) * time.Second; after > delay {
+				delay = after
+			}
+		}
+	}
+	if delay > maxRetryDelay || delay < 0 {
+		delay = maxRetryDelay
+	}
+	return delay
+}
+
+// wait sleeps for d, returning early with the context's error when ctx is
+// done
+func wait(ctx context.Context, d time.Duration) error {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// resumableBody reads the response to req, a pack download. When the
+// connection breaks the request is sent again asking, with a Range header,
+// for the rest of the response, so that what was already downloaded is
+// not downloaded again.
+type resumableBody struct {
+	t    *HTTPTransport
+	req  *http.Request
+	body []byte
+	rc   io.ReadCloser
+	// offset is how many bytes of the response have been read
+	offset  int64
+	resumes int
+}
+
+func (r *resumableBody) Read(p []byte) (int, error) {
+	n, err := r.rc.Read(p)
+	r.offset += int64(n)
+	if err == nil || err == io.EOF || r.req.Context().Err() != nil || r.resumes >= r.t.retries {
+		return n, err
+	}
+
+	r.rc.Close()
+	if werr := wait(r.req.Context(), r.t.retryDelay(r.resumes, nil)); werr != nil {
+		return n, werr
+	}
+	r.resumes++
+	rc, rerr := r.resume()
+	if rerr != nil {
+		r.rc = io.NopCloser(errReader{rerr})
+		return n, fmt.Errorf("%w; failed to resume download: %v", err, rerr)
+	}
+	r.rc = rc
+	if n == 0 {
+		return r.Read(p)
+	}
+	return n, nil
+}
+
+func (r *resumableBody) Close() error {
+	return r.rc.Close()
+}
+
+// resume asks for the response from r.offset on
+func (r *resumableBody) resume() (io.ReadCloser, error) {
+	req := r.req.Clone(r.req.Context())
+	req.Header.Set("Range", fmt.Sprintf("bytes=%d-", r.offset))
+	resp, err := r.t.do(req, r.body)
+	if err != nil {
+		return nil, err
+	}
+	prefix := fmt.Sprintf("bytes %d-", r.offset)
+	if resp.StatusCode != http.StatusPartialContent || !strings.HasPrefix(resp.Header.Get("Content-Range"), prefix) {
+		resp.Body.Close()
+		return nil, errors.New("server cannot resume the download")
+	}
+	return resp.Body, nil
+}
+
+// errReader fails every read with err
+type errReader struct {
+	err error
+}
+
+func (e errReader) Read([]byte) (int, error) {
+	return 0, e.err
+}
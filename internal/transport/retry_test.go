@@ -0,0 +1,156 @@
+package transport
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testRefID = "95dc4b2c3e0f0a5b7b2e4b3e1f2e3e4e5e6e7e8e"
+
+// advertiseRefs answers a ref discovery with refs/heads/main
+func advertiseRefs(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "application/x-git-upload-pack-advertisement")
+	pw := NewPktLineWriter(w)
+	pw.WriteString("# service=git-upload-pack\n")
+	pw.Flush()
+	pw.WriteString(testRefID + " refs/heads/main\x00multi_ack\n")
+	pw.Flush()
+}
+
+func TestHTTPTransport_RetriesTransientFailures(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if requests.Add(1) <= 2 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		advertiseRefs(w)
+	}))
+	defer server.Close()
+
+	transport := NewHTTPTransport(server.URL)
+	transport.SetRetries(3, time.Millisecond)
+	discovery, err := transport.DiscoverRefs(context.Background(), "git-upload-pack")
+	require.NoError(t, err)
+	assert.Equal(t, testRefID, discovery.Refs["refs/heads/main"])
+	assert.Equal(t, int32(3), requests.Load())
+
+	// Retries run out
+	requests.Store(0)
+	transport = NewHTTPTransport(server.URL)
+	transport.SetRetries(1, time.Millisecond)
+	_, err = transport.DiscoverRefs(context.Background(), "git-upload-pack")
+	assert.ErrorContains(t, err, "unexpected status code: 503")
+	assert.Equal(t, int32(2), requests.Load())
+}
+
+func TestHTTPTransport_RetriesOnlyFetches(t *testing.T) {
+	get, _ := http.NewRequest(http.MethodGet, "https://example.com/repo/info/refs", nil)
+	fetch, _ := http.NewRequest(http.MethodPost, "https://example.com/repo/git-upload-pack", nil)
+	push, _ := http.NewRequest(http.MethodPost, "https://example.com/repo/git-receive-pack", nil)
+	assert.True(t, retryable(get))
+	assert.True(t, retryable(fetch))
+	assert.False(t, retryable(push))
+
+	transport := NewHTTPTransport("")
+	transport.SetRetries(3, time.Second)
+	assert.Equal(t, time.Second, transport.retryDelay(0, nil))
+	assert.Equal(t, 4*time.Second, transport.retryDelay(2, nil))
+	assert.Equal(t, maxRetryDelay, transport.retryDelay(10, nil))
+	resp := &http.Response{Header: http.Header{"Retry-After": {"7"}}}
+	assert.Equal(t, 7*time.Second, transport.retryDelay(0, resp))
+}
+
+func TestHTTPTransport_ResumesPackDownload(t *testing.T) {
+	response := "0008NAK\nPACK" + strings.Repeat("pack data ", 1000)
+	cut := len(response) / 3
+
+	var ranges []string
+	newServer := func(supportRange bool) *httptest.Server {
+		return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/x-git-upload-pack-result")
+			if start := r.Header.Get("Range"); start != "" && supportRange {
+				ranges = append(ranges, start)
+				var offset int
+				fmt.Sscanf(start, "bytes=%d-", &offset)
+				w.Header().Set("Content-Range", fmt.Sprintf("bytes %d-%d/%d", offset, len(response)-1, len(response)))
+				w.WriteHeader(http.StatusPartialContent)
+				io.WriteString(w, response[offset:])
+				return
+			}
+			// The connection breaks part of the way through
+			w.Header().Set("Content-Length", fmt.Sprint(len(response)))
+			io.WriteString(w, response[:cut])
+			w.(http.Flusher).Flush()
+			panic(http.ErrAbortHandler)
+		}))
+	}
+
+	server := newServer(true)
+	defer server.Close()
+	transport := NewHTTPTransport(server.URL)
+	transport.SetRetries(2, time.Millisecond)
+	body, err := transport.FetchPack(context.Background(), []string{testRefID}, nil)
+	require.NoError(t, err)
+	data, err := io.ReadAll(body)
+	body.Close()
+	require.NoError(t, err)
+	assert.Equal(t, response, string(data))
+	assert.Equal(t, []string{fmt.Sprintf("bytes=%d-", cut)}, ranges)
+
+	// A server that cannot send part of a response fails the download
+	plain := newServer(false)
+	defer plain.Close()
+	transport = NewHTTPTransport(plain.URL)
+	transport.SetRetries(2, time.Millisecond)
+	body, err = transport.FetchPack(context.Background(), []string{testRefID}, nil)
+	require.NoError(t, err)
+	_, err = io.ReadAll(body)
+	body.Close()
+	assert.ErrorContains(t, err, "server cannot resume the download")
+}
+
+func TestHTTPTransport_Timeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+
+	transport := NewHTTPTransport(server.URL)
+	transport.SetTimeout(20 * time.Millisecond)
+	transport.SetRetries(0, 0)
+	_, err := transport.DiscoverRefs(context.Background(), "git-upload-pack")
+	assert.ErrorContains(t, err, "Client.Timeout exceeded")
+}
+
+func TestHTTPTransport_Proxy(t *testing.T) {
+	var proxied []string
+	proxy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		proxied = append(proxied, r.URL.String())
+		advertiseRefs(w)
+	}))
+	defer proxy.Close()
+
+	transport := NewHTTPTransport("http://git.example.invalid/repo.git")
+	require.NoError(t, transport.SetProxy(proxy.URL))
+	discovery, err := transport.DiscoverRefs(context.Background(), "git-upload-pack")
+	require.NoError(t, err)
+	assert.Equal(t, testRefID, discovery.Refs["refs/heads/main"])
+	assert.Equal(t, []string{"http://git.example.invalid/repo.git/info/refs?service=git-upload-pack"}, proxied)
+
+	assert.Error(t, transport.SetProxy("not a proxy"))
+	require.NoError(t, transport.SetProxy(""))
+}
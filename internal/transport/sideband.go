@@ -0,0 +1,121 @@
+package transport
+
+import (
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Side-band channels of an upload-pack response
+const (
+	SidebandData     = 1
+	SidebandProgress = 2
+	SidebandError    = 3
+)
+
+const (
+	// MaxSidebandData is the most data a side-band packet carries, the
+	// packet being limited to 1000 bytes
+	MaxSidebandData = 1000 - 5
+	// MaxSideband64kData is the most data a side-band-64k packet carries
+	MaxSideband64kData = MaxPktLineData - 1
+)
+
+// SidebandWriter writes to one channel of a side-band stream, splitting
+// data into packets of at most size bytes
+type SidebandWriter struct {
+	pw      *PktLineWriter
+	channel byte
+	size    int
+}
+
+// NewSidebandWriter returns a writer sending data on channel of w in
+// packets of at most size bytes, MaxSidebandData or MaxSideband64kData
+func NewSidebandWriter(w io.Writer, channel byte, size int) *SidebandWriter {
+	return &SidebandWriter{pw: NewPktLineWriter(w), channel: channel, size: size}
+}
+
+// TraceAs names the side of the protocol the writer's packets are traced
+// as sent by
+func (s *SidebandWriter) TraceAs(name string) *SidebandWriter {
+	s.pw.TraceAs(name)
+	return s
+}
+
+// Write sends p as one or more packets
+func (s *SidebandWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) > 0 {
+		n := len(p)
+		if n > s.size {
+			n = s.size
+		}
+		packet := make([]byte, n+1)
+		packet[0] = s.channel
+		copy(packet[1:], p[:n])
+		if err := s.pw.WritePacket(packet); err != nil {
+			return written, err
+		}
+		written += n
+		p = p[n:]
+	}
+	return written, nil
+}
+
+// sidebandReader reads the data channel of a side-band stream, copying
+// progress messages to progress and failing with the message of the error
+// channel
+type sidebandReader struct {
+	pr       *PktLineReader
+	progress io.Writer
+	buf      []byte
+	err      error
+}
+
+// newSidebandReader returns a reader of the side-band stream of pr whose
+// first packet, already read, is first
+func newSidebandReader(pr *PktLineReader, first []byte, progress io.Writer) *sidebandReader {
+	s := &sidebandReader{pr: pr, progress: progress}
+	s.err = s.demux(first)
+	return s
+}
+
+func (s *sidebandReader) Read(p []byte) (int, error) {
+	for len(s.buf) == 0 {
+		if s.err != nil {
+			return 0, s.err
+		}
+		packet, err := s.pr.ReadPacket()
+		switch {
+		case err == ErrFlushPkt:
+			s.err = io.EOF
+		case err != nil:
+			s.err = err
+		default:
+			s.err = s.demux(packet)
+		}
+	}
+	n := copy(p, s.buf)
+	s.buf = s.buf[n:]
+	return n, nil
+}
+
+// demux handles a packet according to its channel
+func (s *sidebandReader) demux(packet []byte) error {
+	if len(packet) == 0 {
+		return nil
+	}
+	switch packet[0] {
+	case SidebandData:
+		s.buf = packet[1:]
+	case SidebandProgress:
+		if s.progress != nil {
+			s.progress.Write(packet[1:])
+		}
+	case SidebandError:
+		return fmt.Errorf("remote error: %s", strings.TrimSpace(string(packet[1:])))
+	default:
+		return fmt.Errorf("invalid side-band channel %d", packet[0])
+	}
+	return nil
+}
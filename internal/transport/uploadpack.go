@@ -0,0 +1,442 @@
+package transport
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// InfiniteDepth is the depth Git sends when converting a shallow repository
+// into a complete one (--unshallow)
+const InfiniteDepth = 0x7fffffff
+
+// FetchRequest describes the client side of an upload-pack negotiation
+type FetchRequest struct {
+	Wants        []string
+	Haves        []string
+	Capabilities []string
+
+	// Shallows lists the commits the client currently treats as shallow
+	// boundaries (the contents of .git/shallow)
+	Shallows []string
+
+	// Depth limits history to the given number of commits from each want
+	Depth int
+	// DeepenSince limits history to commits newer than the given time
+	DeepenSince time.Time
+	// DeepenNot excludes history reachable from the given refs
+	DeepenNot []string
+	// DeepenRelative makes Depth relative to the current shallow boundary
+	DeepenRelative bool
+
+	// Progress receives the progress messages of a server sending the pack
+	// over side-band, nil to discard them
+	Progress io.Writer
+
+	// negotiating is set on the rounds of a multi_ack_detailed negotiation
+	// that do not conclude it: the haves end with a flush-pkt instead of
+	// done, and the server may answer without a pack
+	negotiating bool
+}
+
+// IsShallowRequest reports whether the request asks for any deepen
+// behaviour. Only then does the server answer with the shallow commits: a
+// shallow client that fetches without deepening keeps its boundary.
+func (r *FetchRequest) IsShallowRequest() bool {
+	return r.Depth > 0 || !r.DeepenSince.IsZero() || len(r.DeepenNot) > 0
+}
+
+// Encode writes the request in the upload-pack (protocol v0/v1) format
+func (r *FetchRequest) Encode(w io.Writer) error {
+	if len(r.Wants) == 0 {
+		return fmt.Errorf("fetch request has no wants")
+	}
+
+	pw := NewPktLineWriter(w)
+
+	// In protocol v0 deepen-relative is requested as a capability
+	capabilities := r.Capabilities
+	if r.DeepenRelative && !containsString(capabilities, "deepen-relative") {
+		capabilities = append(append([]string{}, capabilities...), "deepen-relative")
+	}
+
+	for i, want := range r.Wants {
+		line := "want " + want
+		if i == 0 && len(capabilities) > 0 {
+			line += " " + strings.Join(capabilities, " ")
+		}
+		if err := pw.WriteString(line + "\n"); err != nil {
+			return err
+		}
+	}
+
+	for _, shallow := range r.Shallows {
+		if err := pw.Writef("shallow %s\n", shallow); err != nil {
+			return err
+		}
+	}
+
+	if r.Depth > 0 {
+		if err := pw.Writef("deepen %d\n", r.Depth); err != nil {
+			return err
+		}
+	}
+
+	if !r.DeepenSince.IsZero() {
+		if err := pw.Writef("deepen-since %d\n", r.DeepenSince.Unix()); err != nil {
+			return err
+		}
+	}
+
+	for _, ref := range r.DeepenNot {
+		if err := pw.Writef("deepen-not %s\n", ref); err != nil {
+			return err
+		}
+	}
+
+	if err := pw.Flush(); err != nil {
+		return err
+	}
+
+	for _, have := range r.Haves {
+		if err := pw.Writef("have %s\n", have); err != nil {
+			return err
+		}
+	}
+
+	if r.negotiating {
+		return pw.Flush()
+	}
+	return pw.WriteString("done\n")
+}
+
+// fetchArgumentsV2 are the capabilities a protocol v2 fetch command takes
+// as arguments
+var fetchArgumentsV2 = []string{"thin-pack", "no-progress", "include-tag", "ofs-delta"}
+
+// EncodeV2 writes the request as a protocol v2 fetch command. The haves are
+// all sent at once and followed by done, so the server answers with the
+// pack; wants may name any object the server has, not only ref tips.
+func (r *FetchRequest) EncodeV2(w io.Writer) error {
+	if len(r.Wants) == 0 {
+		return fmt.Errorf("fetch request has no wants")
+	}
+
+	pw := NewPktLineWriter(w)
+	if err := pw.WriteString("command=fetch\n"); err != nil {
+		return err
+	}
+	if err := pw.Delim(); err != nil {
+		return err
+	}
+
+	var args []string
+	for _, capability := range r.Capabilities {
+		if containsString(fetchArgumentsV2, capability) {
+			args = append(args, capability)
+		}
+	}
+	for _, want := range r.Wants {
+		args = append(args, "want "+want)
+	}
+	for _, shallow := range r.Shallows {
+		args = append(args, "shallow "+shallow)
+	}
+	if r.Depth > 0 {
+		args = append(args, fmt.Sprintf("deepen %d", r.Depth))
+	}
+	if r.DeepenRelative {
+		args = append(args, "deepen-relative")
+	}
+	if !r.DeepenSince.IsZero() {
+		args = append(args, fmt.Sprintf("deepen-since %d", r.DeepenSince.Unix()))
+	}
+	for _, ref := range r.DeepenNot {
+		args = append(args, "deepen-not "+ref)
+	}
+	for _, have := range r.Haves {
+		args = append(args, "have "+have)
+	}
+	args = append(args, "done")
+
+	for _, arg := range args {
+		if err := pw.WriteString(arg + "\n"); err != nil {
+			return err
+		}
+	}
+	return pw.Flush()
+}
+
+// sideband reports whether the request asks for the pack over side-band
+func (r *FetchRequest) sideband() bool {
+	return containsString(r.Capabilities, "side-band-64k") || containsString(r.Capabilities, "side-band")
+}
+
+// FetchResponse is the parsed server side of an upload-pack negotiation
+type FetchResponse struct {
+	// Shallows are commits the client must now record as shallow
+	Shallows []string
+	// Unshallows are commits whose history is now complete
+	Unshallows []string
+	// Acks are the object IDs the server acknowledged as common
+	Acks []string
+	// Common are the haves multi_ack_detailed acknowledged with "common"
+	Common []string
+	// Ready is set when the server acknowledged with "ready" that it has
+	// found enough in common to send the pack
+	Ready bool
+	// Pack streams the packfile that follows the negotiation, with progress
+	// messages removed when it comes over side-band. It is nil when a
+	// negotiation round ends without a pack.
+	Pack io.Reader
+
+	body io.Closer
+}
+
+// Close releases the underlying connection
+func (r *FetchResponse) Close() error {
+	if r.body == nil {
+		return nil
+	}
+	return r.body.Close()
+}
+
+// ParseFetchResponse parses an upload-pack response. The shallow-info section
+// is only present when the request asked to deepen.
+func ParseFetchResponse(r io.Reader, req *FetchRequest) (*FetchResponse, error) {
+	pr := NewPktLineReader(r)
+	resp := &FetchResponse{}
+	if req == nil {
+		req = &FetchRequest{}
+	}
+
+	if req.IsShallowRequest() {
+		if err := parseShallowInfo(pr, resp); err != nil {
+			return nil, err
+		}
+	}
+
+	// With no-done a server that is ready sends the pack without waiting
+	// for done
+	noDone := containsString(req.Capabilities, "no-done")
+
+	// Read ACK/NAK lines until the packfile starts
+	for {
+		if !req.sideband() {
+			header, err := pr.PeekHeader()
+			if err != nil {
+				if err == io.EOF {
+					return nil, fmt.Errorf("unexpected end of upload-pack response")
+				}
+				return nil, fmt.Errorf("failed to read upload-pack response: %w", err)
+			}
+			if bytes.Equal(header, []byte("PACK")) {
+				break
+			}
+		}
+
+		packet, err := pr.ReadPacket()
+		if err == ErrFlushPkt {
+			continue
+		}
+		if err == io.EOF {
+			return nil, fmt.Errorf("unexpected end of upload-pack response")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read upload-pack response: %w", err)
+		}
+		if req.sideband() && len(packet) > 0 && packet[0] >= SidebandData && packet[0] <= SidebandError {
+			resp.Pack = newSidebandReader(pr, packet, req.Progress)
+			return resp, nil
+		}
+		line := strings.TrimSuffix(string(packet), "\n")
+
+		switch {
+		case line == "NAK":
+			// A round that does not conclude the negotiation ends here,
+			// unless the server is ready to send the pack anyway
+			if req.negotiating && !(resp.Ready && noDone) {
+				return resp, nil
+			}
+		case strings.HasPrefix(line, "ACK "):
+			fields := strings.Fields(line)
+			if len(fields) < 2 {
+				return nil, fmt.Errorf("malformed upload-pack line: %q", line)
+			}
+			resp.Acks = append(resp.Acks, fields[1])
+			if len(fields) > 2 {
+				switch fields[2] {
+				case "common":
+					resp.Common = append(resp.Common, fields[1])
+				case "ready":
+					resp.Ready = true
+				}
+			}
+		case strings.HasPrefix(line, "ERR "):
+			return nil, fmt.Errorf("remote error: %s", strings.TrimPrefix(line, "ERR "))
+		default:
+			return nil, fmt.Errorf("unexpected upload-pack line: %q", line)
+		}
+	}
+
+	resp.Pack = pr.Reader()
+	return resp, nil
+}
+
+// ParseFetchResponseV2 parses the response to a protocol v2 fetch command:
+// sections each named by their first line and ended by a delim-pkt, the
+// last of them a flush-pkt. The pack comes in the packfile section, always
+// over side-band.
+func ParseFetchResponseV2(r io.Reader, req *FetchRequest) (*FetchResponse, error) {
+	pr := NewPktLineReader(r)
+	resp := &FetchResponse{}
+	if req == nil {
+		req = &FetchRequest{}
+	}
+
+	for {
+		section, err := pr.ReadLine()
+		if err == ErrFlushPkt {
+			// Only a negotiation that has not concluded ends without a pack
+			return resp, nil
+		}
+		if err == io.EOF {
+			return nil, fmt.Errorf("unexpected end of upload-pack response")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read upload-pack response: %w", err)
+		}
+
+		var handle func(line string) error
+		switch {
+		case section == "packfile":
+			packet, err := pr.ReadPacket()
+			if err != nil {
+				return nil, fmt.Errorf("failed to read packfile section: %w", err)
+			}
+			resp.Pack = newSidebandReader(pr, packet, req.Progress)
+			return resp, nil
+		case section == "acknowledgments":
+			handle = resp.addAcknowledgment
+		case section == "shallow-info":
+			handle = resp.addShallowInfo
+		case section == "wanted-refs" || section == "packfile-uris":
+			// Neither is requested, so neither is expected; skip them
+			handle = func(string) error { return nil }
+		case strings.HasPrefix(section, "ERR "):
+			return nil, fmt.Errorf("remote error: %s", strings.TrimPrefix(section, "ERR "))
+		default:
+			return nil, fmt.Errorf("unexpected upload-pack section: %q", section)
+		}
+
+		last, err := readSectionV2(pr, handle)
+		if err != nil {
+			return nil, err
+		}
+		if last {
+			return resp, nil
+		}
+	}
+}
+
+// readSectionV2 hands each line of a protocol v2 response section to handle
+// and reports whether it was the last, ended by a flush-pkt
+func readSectionV2(pr *PktLineReader, handle func(line string) error) (bool, error) {
+	for {
+		line, err := pr.ReadLine()
+		if err == ErrDelimPkt || err == ErrFlushPkt {
+			return err == ErrFlushPkt, nil
+		}
+		if err == io.EOF {
+			return false, fmt.Errorf("unexpected end of upload-pack response")
+		}
+		if err != nil {
+			return false, fmt.Errorf("failed to read upload-pack response: %w", err)
+		}
+		if strings.HasPrefix(line, "ERR ") {
+			return false, fmt.Errorf("remote error: %s", strings.TrimPrefix(line, "ERR "))
+		}
+		if err := handle(line); err != nil {
+			return false, err
+		}
+	}
+}
+
+// addAcknowledgment records a line of the acknowledgments section
+func (r *FetchResponse) addAcknowledgment(line string) error {
+	switch {
+	case line == "NAK":
+	case line == "ready":
+		r.Ready = true
+	case strings.HasPrefix(line, "ACK "):
+		id := strings.TrimPrefix(line, "ACK ")
+		if id == "" {
+			return fmt.Errorf("malformed upload-pack line: %q", line)
+		}
+		r.Acks = append(r.Acks, id)
+		r.Common = append(r.Common, id)
+	default:
+		return fmt.Errorf("unexpected upload-pack line: %q", line)
+	}
+	return nil
+}
+
+// addShallowInfo records a line of the shallow-info section
+func (r *FetchResponse) addShallowInfo(line string) error {
+	switch {
+	case strings.HasPrefix(line, "shallow "):
+		r.Shallows = append(r.Shallows, strings.TrimPrefix(line, "shallow "))
+	case strings.HasPrefix(line, "unshallow "):
+		r.Unshallows = append(r.Unshallows, strings.TrimPrefix(line, "unshallow "))
+	default:
+		return fmt.Errorf("unexpected shallow info line: %q", line)
+	}
+	return nil
+}
+
+// parseShallowInfo reads shallow/unshallow lines up to the terminating flush
+func parseShallowInfo(pr *PktLineReader, resp *FetchResponse) error {
+	for {
+		line, err := pr.ReadLine()
+		if err == ErrFlushPkt {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read shallow info: %w", err)
+		}
+
+		switch {
+		case strings.HasPrefix(line, "shallow "):
+			resp.Shallows = append(resp.Shallows, strings.TrimPrefix(line, "shallow "))
+		case strings.HasPrefix(line, "unshallow "):
+			resp.Unshallows = append(resp.Unshallows, strings.TrimPrefix(line, "unshallow "))
+		case strings.HasPrefix(line, "ERR "):
+			return fmt.Errorf("remote error: %s", strings.TrimPrefix(line, "ERR "))
+		default:
+			return fmt.Errorf("unexpected shallow info line: %q", line)
+		}
+	}
+}
+
+// HasCapability reports whether the server advertised the given capability.
+// Capabilities with values (agent=..., symref=...) match on their name.
+func (d *RefDiscovery) HasCapability(name string) bool {
+	for _, capability := range d.Capabilities {
+		if capability == name || strings.HasPrefix(capability, name+"=") {
+			return true
+		}
+	}
+	return false
+}
+
+// containsString reports whether list contains s
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,315 @@
+package transport
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/fenilsonani/vcs/internal/trace"
+)
+
+const (
+	testWant    = "1111111111111111111111111111111111111111"
+	testHave    = "2222222222222222222222222222222222222222"
+	testShallow = "3333333333333333333333333333333333333333"
+)
+
+func TestPktLineRoundTrip(t *testing.T) {
+	var buf bytes.Buffer
+	pw := NewPktLineWriter(&buf)
+	require.NoError(t, pw.WriteString("hello\n"))
+	require.NoError(t, pw.Delim())
+	require.NoError(t, pw.Flush())
+
+	assert.Equal(t, "000ahello\n00010000", buf.String())
+
+	pr := NewPktLineReader(&buf)
+	line, err := pr.ReadLine()
+	require.NoError(t, err)
+	assert.Equal(t, "hello", line)
+
+	_, err = pr.ReadPacket()
+	assert.Equal(t, ErrDelimPkt, err)
+	_, err = pr.ReadPacket()
+	assert.Equal(t, ErrFlushPkt, err)
+	_, err = pr.ReadPacket()
+	assert.Equal(t, io.EOF, err)
+
+	_, err = NewPktLineReader(strings.NewReader("zzzz")).ReadPacket()
+	assert.Error(t, err)
+}
+
+func TestPktLineTrace(t *testing.T) {
+	var traced bytes.Buffer
+	trace.Packet.SetOutput(&traced)
+	t.Cleanup(func() { trace.Packet.SetOutput(nil) })
+
+	var buf bytes.Buffer
+	pw := NewPktLineWriter(&buf).TraceAs("upload-pack")
+	require.NoError(t, pw.WriteString("want x\x00agent\n"))
+	require.NoError(t, pw.Flush())
+	data := NewSidebandWriter(&buf, SidebandData, MaxSidebandData)
+	_, err := data.Write([]byte("PACK\x00\x00"))
+	require.NoError(t, err)
+	_, err = data.Write([]byte("more pack data"))
+	require.NoError(t, err)
+
+	pr := NewPktLineReader(&buf)
+	_, err = pr.ReadLine()
+	require.NoError(t, err)
+
+	var lines []string
+	for _, line := range strings.Split(strings.TrimSpace(traced.String()), "\n") {
+		// Drop the time of day
+		lines = append(lines, line[strings.Index(line, " ")+1:])
+	}
+	assert.Equal(t, []string{
+		"packet:  upload-pack> want x\\0agent",
+		"packet:  upload-pack> 0000",
+		"packet:          vcs> PACK ...",
+		"packet:          vcs< want x\\0agent",
+	}, lines)
+}
+
+func TestFetchRequestEncode(t *testing.T) {
+	req := &FetchRequest{
+		Wants:          []string{testWant},
+		Haves:          []string{testHave},
+		Capabilities:   []string{"ofs-delta", "shallow"},
+		Shallows:       []string{testShallow},
+		Depth:          2,
+		DeepenSince:    time.Unix(1700000000, 0),
+		DeepenNot:      []string{"refs/heads/old"},
+		DeepenRelative: true,
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, req.Encode(&buf))
+
+	expected := "0054want " + testWant + " ofs-delta shallow deepen-relative\n" +
+		"0035shallow " + testShallow + "\n" +
+		"000ddeepen 2\n" +
+		"001cdeepen-since 1700000000\n" +
+		"001edeepen-not refs/heads/old\n" +
+		"0000" +
+		"0032have " + testHave + "\n" +
+		"0009done\n"
+	assert.Equal(t, expected, buf.String())
+
+	assert.Error(t, (&FetchRequest{}).Encode(&buf))
+}
+
+func TestParseFetchResponse(t *testing.T) {
+	var buf bytes.Buffer
+	pw := NewPktLineWriter(&buf)
+	pw.WriteString("shallow " + testShallow + "\n")
+	pw.WriteString("unshallow " + testHave + "\n")
+	pw.Flush()
+	pw.WriteString("ACK " + testHave + "\n")
+	buf.WriteString("PACK rest of pack")
+
+	resp, err := ParseFetchResponse(&buf, &FetchRequest{Wants: []string{testWant}, Depth: 1})
+	require.NoError(t, err)
+	assert.Equal(t, []string{testShallow}, resp.Shallows)
+	assert.Equal(t, []string{testHave}, resp.Unshallows)
+	assert.Equal(t, []string{testHave}, resp.Acks)
+
+	pack, err := io.ReadAll(resp.Pack)
+	require.NoError(t, err)
+	assert.Equal(t, "PACK rest of pack", string(pack))
+
+	// A shallow client that does not deepen gets no shallow-info
+	buf.Reset()
+	pw.WriteString("ACK " + testHave + "\n")
+	buf.WriteString("PACK rest of pack")
+	resp, err = ParseFetchResponse(&buf, &FetchRequest{Wants: []string{testWant}, Shallows: []string{testShallow}})
+	require.NoError(t, err)
+	assert.Empty(t, resp.Shallows)
+	assert.Equal(t, []string{testHave}, resp.Acks)
+}
+
+func TestParseFetchResponseErrors(t *testing.T) {
+	var buf bytes.Buffer
+	NewPktLineWriter(&buf).WriteString("ERR upload-pack: not our ref\n")
+
+	_, err := ParseFetchResponse(&buf, &FetchRequest{Wants: []string{testWant}})
+	assert.ErrorContains(t, err, "not our ref")
+
+	_, err = ParseFetchResponse(strings.NewReader("0008NAK\n"), nil)
+	assert.ErrorContains(t, err, "unexpected end")
+
+	// A truncated ACK is a protocol error, not a panic
+	_, err = ParseFetchResponse(strings.NewReader("0008ACK \n"), nil)
+	assert.EqualError(t, err, `malformed upload-pack line: "ACK "`)
+}
+
+func TestRefDiscoveryHasCapability(t *testing.T) {
+	d := &RefDiscovery{Capabilities: []string{"shallow", "agent=git/2.40"}}
+	assert.True(t, d.HasCapability("shallow"))
+	assert.True(t, d.HasCapability("agent"))
+	assert.False(t, d.HasCapability("deepen-since"))
+}
+
+func TestRefDiscoveryHeadRef(t *testing.T) {
+	var buf bytes.Buffer
+	pw := NewPktLineWriter(&buf)
+	pw.WriteString("# service=git-upload-pack\n")
+	pw.Flush()
+	pw.Writef("%s HEAD\x00multi_ack symref=HEAD:refs/heads/trunk agent=git/2.40\n", strings.Repeat("a", 40))
+	pw.Writef("%s refs/heads/main\n", strings.Repeat("a", 40))
+	pw.Writef("%s refs/heads/trunk\n", strings.Repeat("a", 40))
+	pw.Flush()
+
+	d, err := parsePktLineAdvertisement(NewPktLineReader(&buf))
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"HEAD": "refs/heads/trunk"}, d.Symrefs)
+	assert.Equal(t, "refs/heads/trunk", d.HeadRef())
+
+	// Without the symref capability the branch at HEAD's object is guessed
+	d.Symrefs = nil
+	assert.Equal(t, "refs/heads/main", d.HeadRef())
+	d.Refs["refs/heads/main"] = strings.Repeat("b", 40)
+	assert.Equal(t, "refs/heads/trunk", d.HeadRef())
+	delete(d.Refs, "HEAD")
+	assert.Equal(t, "", d.HeadRef())
+}
+
+func TestFetchRequestEncodeNegotiating(t *testing.T) {
+	req := &FetchRequest{Wants: []string{testWant}, Haves: []string{testHave}, negotiating: true}
+
+	var buf bytes.Buffer
+	require.NoError(t, req.Encode(&buf))
+	assert.Equal(t, "0032want "+testWant+"\n0000"+"0032have "+testHave+"\n0000", buf.String())
+}
+
+func TestParseFetchResponseMultiAckDetailed(t *testing.T) {
+	var buf bytes.Buffer
+	pw := NewPktLineWriter(&buf)
+	pw.WriteString("ACK " + testHave + " common\n")
+	pw.WriteString("ACK " + testHave + " ready\n")
+	pw.WriteString("NAK\n")
+	pw.WriteString("ACK " + testHave + "\n")
+	buf.WriteString("PACK rest of pack")
+	response := buf.String()
+
+	// A round ends at NAK
+	req := &FetchRequest{Wants: []string{testWant}, Capabilities: []string{"multi_ack_detailed"}, negotiating: true}
+	resp, err := ParseFetchResponse(strings.NewReader(response), req)
+	require.NoError(t, err)
+	assert.Equal(t, []string{testHave}, resp.Common)
+	assert.True(t, resp.Ready)
+	assert.Nil(t, resp.Pack)
+
+	// unless no-done lets a ready server send the pack
+	req.Capabilities = append(req.Capabilities, "no-done")
+	resp, err = ParseFetchResponse(strings.NewReader(response), req)
+	require.NoError(t, err)
+	assert.Equal(t, []string{testHave, testHave, testHave}, resp.Acks)
+	require.NotNil(t, resp.Pack)
+	pack, err := io.ReadAll(resp.Pack)
+	require.NoError(t, err)
+	assert.Equal(t, "PACK rest of pack", string(pack))
+}
+
+func TestParseFetchResponseSideband(t *testing.T) {
+	var buf bytes.Buffer
+	pw := NewPktLineWriter(&buf)
+	pw.WriteString("NAK\n")
+	progress := NewSidebandWriter(&buf, SidebandProgress, MaxSideband64kData)
+	data := NewSidebandWriter(&buf, SidebandData, 4)
+	io.WriteString(progress, "Counting objects: 50%\r")
+	io.WriteString(data, "PACK and")
+	io.WriteString(progress, "Counting objects: 100%, done.\n")
+	io.WriteString(data, " the rest")
+	pw.Flush()
+	assert.Contains(t, buf.String(), "0009\x01PACK", "data is split into packets of at most 4 bytes")
+
+	var messages bytes.Buffer
+	req := &FetchRequest{Wants: []string{testWant}, Capabilities: []string{"side-band-64k"}, Progress: &messages}
+	resp, err := ParseFetchResponse(&buf, req)
+	require.NoError(t, err)
+	pack, err := io.ReadAll(resp.Pack)
+	require.NoError(t, err)
+	assert.Equal(t, "PACK and the rest", string(pack))
+	assert.Equal(t, "Counting objects: 50%\rCounting objects: 100%, done.\n", messages.String())
+
+	// The error channel fails the read
+	buf.Reset()
+	NewSidebandWriter(&buf, SidebandData, MaxSidebandData).Write([]byte("PACK"))
+	NewSidebandWriter(&buf, SidebandError, MaxSidebandData).Write([]byte("pack-objects died\n"))
+	resp, err = ParseFetchResponse(&buf, &FetchRequest{Wants: []string{testWant}, Capabilities: []string{"side-band"}})
+	require.NoError(t, err)
+	pack, err = io.ReadAll(resp.Pack)
+	assert.Equal(t, "PACK", string(pack))
+	assert.EqualError(t, err, "remote error: pack-objects died")
+}
+
+func TestFetchRequestEncodeV2(t *testing.T) {
+	req := &FetchRequest{
+		Wants:        []string{testWant},
+		Haves:        []string{testHave},
+		Capabilities: []string{"ofs-delta", "side-band-64k"},
+		Depth:        1,
+	}
+
+	var buf bytes.Buffer
+	require.NoError(t, req.EncodeV2(&buf))
+
+	// side-band-64k is no argument of the v2 fetch command
+	expected := "0012command=fetch\n" +
+		"0001" +
+		"000eofs-delta\n" +
+		"0032want " + testWant + "\n" +
+		"000ddeepen 1\n" +
+		"0032have " + testHave + "\n" +
+		"0009done\n" +
+		"0000"
+	assert.Equal(t, expected, buf.String())
+
+	assert.Error(t, (&FetchRequest{}).EncodeV2(&buf))
+}
+
+func TestParseFetchResponseV2(t *testing.T) {
+	var buf bytes.Buffer
+	pw := NewPktLineWriter(&buf)
+	pw.WriteString("shallow-info\n")
+	pw.WriteString("shallow " + testShallow + "\n")
+	pw.Delim()
+	pw.WriteString("packfile\n")
+	io.WriteString(NewSidebandWriter(&buf, SidebandProgress, MaxSideband64kData), "Enumerating objects: 3, done.\n")
+	io.WriteString(NewSidebandWriter(&buf, SidebandData, MaxSideband64kData), "PACK rest of pack")
+	pw.Flush()
+
+	var messages bytes.Buffer
+	resp, err := ParseFetchResponseV2(&buf, &FetchRequest{Wants: []string{testWant}, Progress: &messages})
+	require.NoError(t, err)
+	assert.Equal(t, []string{testShallow}, resp.Shallows)
+	pack, err := io.ReadAll(resp.Pack)
+	require.NoError(t, err)
+	assert.Equal(t, "PACK rest of pack", string(pack))
+	assert.Equal(t, "Enumerating objects: 3, done.\n", messages.String())
+
+	// Acknowledgments ending the response leave the negotiation open
+	buf.Reset()
+	pw.WriteString("acknowledgments\n")
+	pw.WriteString("ACK " + testHave + "\n")
+	pw.WriteString("ready\n")
+	pw.Flush()
+	resp, err = ParseFetchResponseV2(&buf, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{testHave}, resp.Common)
+	assert.True(t, resp.Ready)
+	assert.Nil(t, resp.Pack)
+
+	_, err = ParseFetchResponseV2(strings.NewReader("0014ERR not our ref\n"), nil)
+	assert.EqualError(t, err, "remote error: not our ref")
+	_, err = ParseFetchResponseV2(strings.NewReader("0014acknowledgments\n0008NAK\n"), nil)
+	assert.ErrorContains(t, err, "unexpected end")
+	_, err = ParseFetchResponseV2(strings.NewReader("000abogus\n0000"), nil)
+	assert.EqualError(t, err, `unexpected upload-pack section: "bogus"`)
+}
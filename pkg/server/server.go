@@ -0,0 +1,151 @@
+// Package server embeds a Git server in a Go program. A Server serves one
+// repository to vcs and git clients: over HTTP as an http.Handler, or over
+// any other connection, such as an SSH channel, with UploadPack and
+// ReceivePack:
+//
+//	repo, err := vcs.Open("/srv/project")
+//	...
+//	srv := server.New(repo, server.Options{AllowPush: true})
+//	http.ListenAndServe(":8080", srv)
+//
+// Fetches may be shallow, with the history cut at a depth, a date or the
+// history of refs, as git clone --depth, --shallow-since and
+// --shallow-exclude ask.
+//
+// The steps of the protocol are available on their own for servers that
+// speak it themselves: AdvertiseRefs, CheckWants, ShallowUpdate,
+// WritePack, WriteShallowPack and CheckUpdate. Pushes can be checked against a Policy and reported to
+// Hooks, which can refuse them as Git's server-side hooks do.
+package server
+
+import (
+	"io"
+	"net/http"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/serve"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// ObjectID is the ID of an object
+type ObjectID = objects.ObjectID
+
+// Policy restricts what pushes may do; the zero Policy allows anything
+type Policy = serve.Policy
+
+// PolicyError is why a push was refused by a Policy
+type PolicyError = serve.PolicyError
+
+// RefUpdate is a ref moved by a push: created when Old is zero, deleted
+// when New is zero
+type RefUpdate = serve.RefUpdate
+
+// Deepen is what a shallow fetch asks of the history sent: the client's
+// shallow commits, and the depth, date or refs the history is cut at
+type Deepen = serve.Deepen
+
+// Hooks are called as a push is applied, as Git's pre-receive, update and
+// post-receive hooks are
+type Hooks = serve.Hooks
+
+// ErrNotRepository is returned by the open function of NewRootHandler for
+// a directory that is not a repository
+var ErrNotRepository = serve.ErrNotRepository
+
+// ParseObjectID parses the hex form of an object ID
+func ParseObjectID(s string) (ObjectID, error) {
+	return objects.NewObjectID(s)
+}
+
+// Options controls what a Server lets clients do
+type Options struct {
+	// AllowPush accepts pushes that Policy permits; without it the
+	// repository is read-only
+	AllowPush bool
+	Policy    Policy
+	Hooks     Hooks
+}
+
+// Server serves a repository over Git's protocol
+type Server struct {
+	s *serve.Server
+}
+
+// New creates a server for repo
+func New(repo *vcs.Repository, opts Options) *Server {
+	s := serve.NewServer(repo.GitDir(), repo.Storage())
+	if opts.AllowPush {
+		s.AllowPush(opts.Policy)
+	}
+	s.SetHooks(opts.Hooks)
+	return &Server{s: s}
+}
+
+// ServeHTTP serves the smart HTTP protocol, with the repository at any URL
+// path the protocol's endpoints are appended to
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.s.ServeHTTP(w, r)
+}
+
+// UploadPack serves a fetch over a connection, reading the client's side
+// from r and writing the server's to w
+func (s *Server) UploadPack(r io.Reader, w io.Writer) error {
+	return s.s.UploadPack(r, w)
+}
+
+// ReceivePack serves a push over a connection, as UploadPack serves a
+// fetch
+func (s *Server) ReceivePack(r io.Reader, w io.Writer) error {
+	return s.s.ReceivePack(r, w)
+}
+
+// AdvertiseRefs writes the ref advertisement for service, git-upload-pack
+// or git-receive-pack, that starts a fetch or push
+func (s *Server) AdvertiseRefs(w io.Writer, service string) error {
+	return s.s.AdvertiseRefs(w, service)
+}
+
+// CheckWants refuses wanted objects that are not reachable from a ref, so
+// a client is never handed unreachable objects
+func (s *Server) CheckWants(wants []ObjectID) error {
+	return s.s.CheckWants(wants)
+}
+
+// WritePack writes a pack of everything reachable from wants that is not
+// reachable from haves
+func (s *Server) WritePack(w io.Writer, wants, haves []ObjectID) error {
+	return s.s.WritePack(w, wants, haves)
+}
+
+// ShallowUpdate returns the commits a client fetching wants as deepen asks
+// is to record as shallow, and those of its shallow commits it is not to
+// any longer. They are sent before the acknowledgments of its haves.
+func (s *Server) ShallowUpdate(wants []ObjectID, deepen Deepen) (shallow, unshallow []ObjectID, err error) {
+	return s.s.ShallowUpdate(wants, deepen)
+}
+
+// WriteShallowPack writes the pack of WritePack with the history cut as
+// deepen asks
+func (s *Server) WriteShallowPack(w io.Writer, wants, haves []ObjectID, deepen Deepen) error {
+	return s.s.WriteShallowPack(w, wants, haves, deepen)
+}
+
+// CheckUpdate checks update as a push would, against the repository and
+// the Policy, with the objects it needs already in the repository
+func (s *Server) CheckUpdate(update RefUpdate) error {
+	return s.s.CheckUpdate(update)
+}
+
+// NewRootHandler returns a handler serving every repository under root
+// over smart HTTP, each at the URL of its path relative to root. open
+// returns the server for a directory, or an error wrapping
+// ErrNotRepository for one that is not a repository.
+func NewRootHandler(root string, open func(dir string) (*Server, error)) http.Handler {
+	return serve.NewRootServer(root, func(dir string) (*serve.Server, error) {
+		srv, err := open(dir)
+		if err != nil {
+			return nil, err
+		}
+		return srv.s, nil
+	})
+}
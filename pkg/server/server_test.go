@@ -0,0 +1,353 @@
+package server
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/packfile"
+	"github.com/fenilsonani/vcs/internal/core/refs"
+	"github.com/fenilsonani/vcs/internal/transport"
+	"github.com/fenilsonani/vcs/pkg/vcs"
+)
+
+// newRepo creates a repository with a commit on main, checked out on
+// another branch so main can be pushed to
+func newRepo(t *testing.T) (*vcs.Repository, ObjectID) {
+	t.Helper()
+	repo, err := vcs.Init(filepath.Join(t.TempDir(), "repo"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	id := commit(t, repo, "one\n")
+	rm := refs.NewRefManager(repo.GitDir())
+	if err := rm.UpdateRef("refs/heads/main", id); err != nil {
+		t.Fatal(err)
+	}
+	if err := rm.SetHEAD("refs/heads/work"); err != nil {
+		t.Fatal(err)
+	}
+	return repo, id
+}
+
+func commit(t *testing.T, repo *vcs.Repository, content string, parents ...ObjectID) ObjectID {
+	t.Helper()
+	sig := objects.Signature{Name: "Test", Email: "test@example.com", When: time.Unix(1700000000, 0)}
+	blob, err := repo.CreateBlob([]byte(content))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tree, err := repo.CreateTree([]objects.TreeEntry{{Mode: objects.ModeBlob, Name: "a.txt", ID: blob.ID()}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	c, err := repo.CreateCommit(tree.ID(), parents, sig, sig, content)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c.ID()
+}
+
+// readLines reads pkt-lines up to and including the next flush-pkt
+func readLines(t *testing.T, pr *transport.PktLineReader) []string {
+	t.Helper()
+	var lines []string
+	for {
+		line, err := pr.ReadLine()
+		if err == transport.ErrFlushPkt {
+			return lines
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, line)
+	}
+}
+
+func TestUploadPack(t *testing.T) {
+	repo, first := newRepo(t)
+	srv := New(repo, Options{})
+
+	var in bytes.Buffer
+	pw := transport.NewPktLineWriter(&in)
+	pw.Writef("want %s ofs-delta\n", first)
+	pw.Flush()
+	pw.WriteString("done\n")
+
+	var out bytes.Buffer
+	if err := srv.UploadPack(&in, &out); err != nil {
+		t.Fatalf("UploadPack() error = %v", err)
+	}
+	pr := transport.NewPktLineReader(&out)
+	if ad := readLines(t, pr); !strings.HasPrefix(ad[0], first.String()+" refs/heads/main\x00") {
+		t.Errorf("advertisement = %q", ad)
+	}
+	if line, _ := pr.ReadLine(); line != "NAK" {
+		t.Errorf("got %q, want NAK", line)
+	}
+	dst := objects.NewStorage(t.TempDir())
+	if err := dst.Init(); err != nil {
+		t.Fatal(err)
+	}
+	result, err := packfile.Unpack(pr.Reader(), dst)
+	if err != nil {
+		t.Fatalf("Unpack() error = %v", err)
+	}
+	if len(result.Objects) != 3 {
+		t.Errorf("got %d objects, want commit, tree and blob", len(result.Objects))
+	}
+
+	if err := srv.CheckWants([]ObjectID{first}); err != nil {
+		t.Errorf("CheckWants() error = %v", err)
+	}
+	loose := commit(t, repo, "loose\n")
+	if err := srv.CheckWants([]ObjectID{loose}); err == nil {
+		t.Error("CheckWants() accepted an unreachable commit")
+	}
+
+	var pack bytes.Buffer
+	if err := srv.WritePack(&pack, []ObjectID{loose}, []ObjectID{first}); err != nil {
+		t.Fatalf("WritePack() error = %v", err)
+	}
+	if result, err = packfile.Unpack(&pack, dst); err != nil || len(result.Objects) != 3 {
+		t.Errorf("WritePack() wrote %v, %v", result, err)
+	}
+}
+
+// push sends update to srv with the objects of its new commit, returning
+// the report
+func push(t *testing.T, srv *Server, src *vcs.Repository, update RefUpdate) []string {
+	t.Helper()
+	var in bytes.Buffer
+	pw := transport.NewPktLineWriter(&in)
+	pw.Writef("%s %s %s\x00report-status\n", update.Old, update.New, update.Ref)
+	pw.Flush()
+	if !update.New.IsZero() {
+		objs, err := packObjects(src, update.New)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := packfile.WritePack(&in, objs, packfile.DefaultWriterOptions()); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var out bytes.Buffer
+	if err := srv.ReceivePack(&in, &out); err != nil {
+		t.Fatalf("ReceivePack() error = %v", err)
+	}
+	pr := transport.NewPktLineReader(&out)
+	readLines(t, pr)
+	return readLines(t, pr)
+}
+
+// packObjects returns the commit id with its tree and blobs
+func packObjects(repo *vcs.Repository, id ObjectID) ([]*packfile.Object, error) {
+	c, err := repo.GetCommit(id)
+	if err != nil {
+		return nil, err
+	}
+	tree, err := repo.GetTree(c.Tree())
+	if err != nil {
+		return nil, err
+	}
+	ids := []ObjectID{id, tree.ID()}
+	for _, entry := range tree.Entries() {
+		ids = append(ids, entry.ID)
+	}
+	var objs []*packfile.Object
+	for _, oid := range ids {
+		objType, data, err := repo.ReadRawObject(oid)
+		if err != nil {
+			return nil, err
+		}
+		objs = append(objs, &packfile.Object{ID: oid, Type: objType, Data: data})
+	}
+	return objs, nil
+}
+
+func TestReceivePackHooks(t *testing.T) {
+	repo, first := newRepo(t)
+	src, err := vcs.Init(filepath.Join(t.TempDir(), "src"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := commit(t, src, "two\n", first)
+
+	var calls []string
+	var received []RefUpdate
+	declinePre, declineUpdate := false, false
+	srv := New(repo, Options{AllowPush: true, Hooks: Hooks{
+		PreReceive: func(updates []RefUpdate) error {
+			calls = append(calls, "pre-receive")
+			// The pushed objects can be read
+			if _, err := repo.GetCommit(updates[0].New); err != nil {
+				t.Errorf("pushed commit not readable: %v", err)
+			}
+			if declinePre {
+				return errors.New("pre-receive hook declined")
+			}
+			return nil
+		},
+		Update: func(update RefUpdate) error {
+			calls = append(calls, "update "+update.Ref)
+			if declineUpdate {
+				return errors.New("hook declined")
+			}
+			return nil
+		},
+		PostReceive: func(updates []RefUpdate) {
+			calls = append(calls, "post-receive")
+			received = updates
+		},
+	}})
+
+	update := RefUpdate{Ref: "refs/heads/main", Old: first, New: second}
+	if err := srv.CheckUpdate(RefUpdate{Ref: "refs/heads/main", Old: second, New: first}); err == nil {
+		t.Error("CheckUpdate() accepted a stale old ID")
+	}
+
+	declinePre = true
+	if got := push(t, srv, src, update); !reflect.DeepEqual(got, []string{"unpack ok", "ng refs/heads/main pre-receive hook declined"}) {
+		t.Errorf("report = %q", got)
+	}
+	declinePre, declineUpdate = false, true
+	if got := push(t, srv, src, update); !reflect.DeepEqual(got, []string{"unpack ok", "ng refs/heads/main hook declined"}) {
+		t.Errorf("report = %q", got)
+	}
+	want := []string{"pre-receive", "pre-receive", "update refs/heads/main"}
+	if !reflect.DeepEqual(calls, want) {
+		t.Errorf("calls = %q, want %q", calls, want)
+	}
+
+	declineUpdate = false
+	calls = nil
+	if got := push(t, srv, src, update); !reflect.DeepEqual(got, []string{"unpack ok", "ok refs/heads/main"}) {
+		t.Errorf("report = %q", got)
+	}
+	want = []string{"pre-receive", "update refs/heads/main", "post-receive"}
+	if !reflect.DeepEqual(calls, want) || !reflect.DeepEqual(received, []RefUpdate{update}) {
+		t.Errorf("calls = %q, post-receive got %v", calls, received)
+	}
+	if id, err := refs.NewRefManager(repo.GitDir()).ResolveRef("refs/heads/main"); err != nil || id != second {
+		t.Errorf("main = %s, %v; want %s", id, err, second)
+	}
+}
+
+func TestReceivePackReadOnly(t *testing.T) {
+	repo, _ := newRepo(t)
+	var out bytes.Buffer
+	if err := New(repo, Options{}).ReceivePack(strings.NewReader(""), &out); err == nil {
+		t.Error("ReceivePack() accepted a push to a read-only server")
+	}
+	line, err := transport.NewPktLineReader(&out).ReadLine()
+	if err != nil && err != io.EOF {
+		t.Fatal(err)
+	}
+	if line != "ERR this repository is read-only" {
+		t.Errorf("got %q", line)
+	}
+}
+
+func TestShallowFetch(t *testing.T) {
+	repo, first := newRepo(t)
+	second := commit(t, repo, "two\n", first)
+	if err := refs.NewRefManager(repo.GitDir()).UpdateRef("refs/heads/main", second); err != nil {
+		t.Fatal(err)
+	}
+	srv := New(repo, Options{})
+
+	var in bytes.Buffer
+	pw := transport.NewPktLineWriter(&in)
+	pw.Writef("want %s ofs-delta shallow\n", second)
+	pw.WriteString("deepen 1\n")
+	pw.Flush()
+	pw.WriteString("done\n")
+
+	var out bytes.Buffer
+	if err := srv.UploadPack(&in, &out); err != nil {
+		t.Fatalf("UploadPack() error = %v", err)
+	}
+	pr := transport.NewPktLineReader(&out)
+	if ad := readLines(t, pr); !strings.Contains(ad[0], " shallow ") {
+		t.Errorf("advertisement = %q, want the shallow capability", ad)
+	}
+	if got, want := readLines(t, pr), []string{"shallow " + second.String()}; !reflect.DeepEqual(got, want) {
+		t.Errorf("shallow update = %q, want %q", got, want)
+	}
+	if line, _ := pr.ReadLine(); line != "NAK" {
+		t.Errorf("got %q, want NAK", line)
+	}
+	dst := objects.NewStorage(t.TempDir())
+	if err := dst.Init(); err != nil {
+		t.Fatal(err)
+	}
+	result, err := packfile.Unpack(pr.Reader(), dst)
+	if err != nil {
+		t.Fatalf("Unpack() error = %v", err)
+	}
+	if len(result.Objects) != 3 {
+		t.Errorf("got %d objects, want the commit, tree and blob of the tip", len(result.Objects))
+	}
+
+	// Deepening a shallow client unshallows its boundary and sends the rest
+	deepen := Deepen{Shallows: []ObjectID{second}, Depth: 2}
+	shallow, unshallow, err := srv.ShallowUpdate([]ObjectID{second}, deepen)
+	if err != nil {
+		t.Fatalf("ShallowUpdate() error = %v", err)
+	}
+	if !reflect.DeepEqual(shallow, []ObjectID{first}) || !reflect.DeepEqual(unshallow, []ObjectID{second}) {
+		t.Errorf("ShallowUpdate() = %v, %v", shallow, unshallow)
+	}
+	var pack bytes.Buffer
+	if err := srv.WriteShallowPack(&pack, []ObjectID{second}, []ObjectID{second}, deepen); err != nil {
+		t.Fatalf("WriteShallowPack() error = %v", err)
+	}
+	if result, err = packfile.Unpack(&pack, dst); err != nil || len(result.Objects) != 3 {
+		t.Errorf("WriteShallowPack() wrote %v, %v", result, err)
+	}
+}
+
+func TestGitShallowClone(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not available")
+	}
+	repo, first := newRepo(t)
+	second := commit(t, repo, "two\n", first)
+	rm := refs.NewRefManager(repo.GitDir())
+	if err := rm.UpdateRef("refs/heads/main", second); err != nil {
+		t.Fatal(err)
+	}
+	if err := rm.SetHEAD("refs/heads/main"); err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(New(repo, Options{}))
+	defer server.Close()
+
+	dir := filepath.Join(t.TempDir(), "clone")
+	cmd := exec.Command("git", "clone", "-q", "--depth", "1", server.URL, dir)
+	cmd.Env = append(os.Environ(), "GIT_CONFIG_NOSYSTEM=1", "HOME="+t.TempDir())
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git clone --depth 1: %v\n%s", err, out)
+	}
+	shallow, err := os.ReadFile(filepath.Join(dir, ".git", "shallow"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(shallow) != second.String()+"\n" {
+		t.Errorf(".git/shallow = %q, want %s", shallow, second)
+	}
+	out, err := exec.Command("git", "-C", dir, "rev-list", "--count", "HEAD").Output()
+	if err != nil || strings.TrimSpace(string(out)) != "1" {
+		t.Errorf("git rev-list --count HEAD = %q, %v, want 1", out, err)
+	}
+}
@@ -6,7 +6,9 @@ import (
 	"os"
 	"path/filepath"
 
+	"github.com/fenilsonani/vcs/internal/core/gitdir"
 	"github.com/fenilsonani/vcs/internal/core/objects"
+	"github.com/fenilsonani/vcs/internal/core/packfile"
 )
 
 // Repository represents a git repository
@@ -31,7 +33,7 @@ func Init(path string) (*Repository, error) {
 	}
 	
 	// Initialize object storage
-	storage := objects.NewStorage(gitDir)
+	storage := newStorage(gitDir)
 	if err := storage.Init(); err != nil {
 		return nil, fmt.Errorf("failed to initialize object storage: %w", err)
 	}
@@ -78,13 +80,22 @@ func Init(path string) (*Repository, error) {
 	}, nil
 }
 
-// Open opens an existing repository
+// Open opens an existing repository. Its .git may also be a file pointing
+// to the repository directory, as in a linked worktree.
 func Open(path string) (*Repository, error) {
-	// Find .git directory
-	gitDir := filepath.Join(path, ".git")
-	if info, err := os.Stat(gitDir); err != nil || !info.IsDir() {
+	gitDir, err := gitdir.Resolve(filepath.Join(path, ".git"))
+	if err != nil {
 		return nil, fmt.Errorf("not a git repository: %s", path)
 	}
+	return OpenWithGitDir(path, gitDir)
+}
+
+// OpenWithGitDir opens a repository whose git directory is not necessarily
+// inside its working tree, as with --git-dir and --work-tree
+func OpenWithGitDir(workTree, gitDir string) (*Repository, error) {
+	if info, err := os.Stat(gitDir); err != nil || !info.IsDir() {
+		return nil, fmt.Errorf("not a git repository: %s", workTree)
+	}
 	
 	// Verify it's a valid repository
 	headPath := filepath.Join(gitDir, "HEAD")
@@ -92,15 +103,28 @@ func Open(path string) (*Repository, error) {
 		return nil, fmt.Errorf("invalid git repository: missing HEAD")
 	}
 	
-	storage := objects.NewStorage(gitDir)
+	storage := newStorage(gitDir)
 	
 	return &Repository{
-		path:    path,
+		path:    workTree,
 		gitDir:  gitDir,
 		storage: storage,
 	}, nil
 }
 
+// newStorage returns object storage that also reads from the repository's
+// packfiles
+func newStorage(gitDir string) *objects.Storage {
+	storage := objects.NewStorage(gitDir)
+	storage.SetPackedObjects(packfile.NewPackDir(storage.PackDir()))
+	return storage
+}
+
+// Storage returns the repository's object storage
+func (r *Repository) Storage() *objects.Storage {
+	return r.storage
+}
+
 // Path returns the repository path
 func (r *Repository) Path() string {
 	return r.path
@@ -111,6 +135,13 @@ func (r *Repository) GitDir() string {
 	return r.gitDir
 }
 
+// CommonDir returns the directory of the objects, refs and config shared
+// by all worktrees of the repository, which is GitDir unless this is a
+// linked worktree
+func (r *Repository) CommonDir() string {
+	return gitdir.CommonDir(r.gitDir)
+}
+
 // WorkDir returns the working directory path
 func (r *Repository) WorkDir() string {
 	return r.path
@@ -218,6 +249,16 @@ func (r *Repository) WriteObject(obj objects.Object) error {
 	return r.storage.WriteObject(obj)
 }
 
+// ReadRawObject reads the type and content of an object without parsing it
+func (r *Repository) ReadRawObject(id objects.ObjectID) (objects.ObjectType, []byte, error) {
+	return r.storage.ReadRawObject(id)
+}
+
+// WriteRawObject writes serialized object content to the repository
+func (r *Repository) WriteRawObject(objType objects.ObjectType, data []byte) (objects.ObjectID, error) {
+	return r.storage.WriteRawObject(objType, data)
+}
+
 // HasObject checks if an object exists in the repository
 func (r *Repository) HasObject(id objects.ObjectID) bool {
 	return r.storage.HasObject(id)
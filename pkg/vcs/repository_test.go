@@ -144,6 +144,33 @@ func TestOpen_NotRepository(t *testing.T) {
 	}
 }
 
+func TestOpenWithGitDir(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	repo1, err := Init(filepath.Join(tmpDir, "repo"))
+	if err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+
+	// Use the git directory with a detached work tree
+	workTree := filepath.Join(tmpDir, "checkout")
+	repo2, err := OpenWithGitDir(workTree, repo1.GitDir())
+	if err != nil {
+		t.Fatalf("OpenWithGitDir() error = %v", err)
+	}
+
+	if repo2.WorkDir() != workTree {
+		t.Errorf("WorkDir() = %v, want %v", repo2.WorkDir(), workTree)
+	}
+	if repo2.GitDir() != repo1.GitDir() {
+		t.Errorf("GitDir() = %v, want %v", repo2.GitDir(), repo1.GitDir())
+	}
+
+	if _, err := OpenWithGitDir(workTree, filepath.Join(tmpDir, "missing")); err == nil {
+		t.Error("OpenWithGitDir() error = nil, want error")
+	}
+}
+
 func TestOpen_MissingHEAD(t *testing.T) {
 	// Create temp directory
 	tmpDir, err := os.MkdirTemp("", "vcs-repo-test-*")
@@ -0,0 +1,97 @@
+package vcs
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+// shallowFile returns the path of the file listing shallow boundary commits
+func (r *Repository) shallowFile() string {
+	return filepath.Join(r.CommonDir(), "shallow")
+}
+
+// ShallowCommits returns the commits recorded as shallow boundaries. Their
+// parents are not present in the repository.
+func (r *Repository) ShallowCommits() ([]objects.ObjectID, error) {
+	data, err := os.ReadFile(r.shallowFile())
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read shallow file: %w", err)
+	}
+
+	var ids []objects.ObjectID
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		id, err := objects.NewObjectID(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid shallow entry %q: %w", line, err)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+// IsShallow reports whether the repository has truncated history
+func (r *Repository) IsShallow() bool {
+	ids, err := r.ShallowCommits()
+	return err == nil && len(ids) > 0
+}
+
+// UpdateShallow adds and removes shallow boundaries. The shallow file is
+// removed once the repository no longer has any boundaries.
+func (r *Repository) UpdateShallow(add, remove []objects.ObjectID) error {
+	current, err := r.ShallowCommits()
+	if err != nil {
+		return err
+	}
+
+	set := make(map[objects.ObjectID]bool)
+	for _, id := range current {
+		set[id] = true
+	}
+	for _, id := range add {
+		set[id] = true
+	}
+	for _, id := range remove {
+		delete(set, id)
+	}
+
+	if len(set) == 0 {
+		if err := os.Remove(r.shallowFile()); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove shallow file: %w", err)
+		}
+		return nil
+	}
+
+	lines := make([]string, 0, len(set))
+	for id := range set {
+		lines = append(lines, id.String())
+	}
+	sort.Strings(lines)
+
+	content := strings.Join(lines, "\n") + "\n"
+	tmpPath := r.shallowFile() + ".lock"
+	if err := os.WriteFile(tmpPath, []byte(content), 0644); err != nil {
+		return fmt.Errorf("failed to write shallow file: %w", err)
+	}
+	if err := os.Rename(tmpPath, r.shallowFile()); err != nil {
+		os.Remove(tmpPath)
+		return fmt.Errorf("failed to update shallow file: %w", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,72 @@
+package vcs
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/fenilsonani/vcs/internal/core/objects"
+)
+
+func TestRepository_Shallow(t *testing.T) {
+	repo, err := Init(t.TempDir())
+	if err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+
+	if repo.IsShallow() {
+		t.Error("new repository should not be shallow")
+	}
+
+	a := objects.ComputeHash(objects.TypeBlob, []byte("a"))
+	b := objects.ComputeHash(objects.TypeBlob, []byte("b"))
+
+	if err := repo.UpdateShallow([]objects.ObjectID{b, a}, nil); err != nil {
+		t.Fatalf("UpdateShallow() error = %v", err)
+	}
+	if !repo.IsShallow() {
+		t.Error("repository should be shallow after adding boundaries")
+	}
+	ids, err := repo.ShallowCommits()
+	if err != nil {
+		t.Fatalf("ShallowCommits() error = %v", err)
+	}
+	if len(ids) != 2 {
+		t.Fatalf("ShallowCommits() = %d entries, want 2", len(ids))
+	}
+
+	if err := repo.UpdateShallow(nil, []objects.ObjectID{a}); err != nil {
+		t.Fatalf("UpdateShallow() error = %v", err)
+	}
+	ids, err = repo.ShallowCommits()
+	if err != nil {
+		t.Fatalf("ShallowCommits() error = %v", err)
+	}
+	if len(ids) != 1 || ids[0] != b {
+		t.Errorf("ShallowCommits() = %v, want [%s]", ids, b)
+	}
+
+	if err := repo.UpdateShallow(nil, []objects.ObjectID{b}); err != nil {
+		t.Fatalf("UpdateShallow() error = %v", err)
+	}
+	if repo.IsShallow() {
+		t.Error("repository should be complete after removing all boundaries")
+	}
+	if _, err := os.Stat(filepath.Join(repo.GitDir(), "shallow")); !os.IsNotExist(err) {
+		t.Error("shallow file should be removed")
+	}
+}
+
+func TestRepository_ShallowInvalidEntry(t *testing.T) {
+	repo, err := Init(t.TempDir())
+	if err != nil {
+		t.Fatalf("Init() error = %v", err)
+	}
+
+	if err := os.WriteFile(filepath.Join(repo.GitDir(), "shallow"), []byte("not-a-hash\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.ShallowCommits(); err == nil {
+		t.Error("expected error for invalid shallow entry")
+	}
+}